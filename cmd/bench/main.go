@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// bench builds a synthetic block of signed spends and times each stage of
+// handling it: serialization, script checks (serial and spread over
+// workers), full connection and mempool acceptance. Each stage runs
+// -runs times and the fastest run is reported, so results from two builds
+// can be compared to spot regressions.
+func main() {
+	txs := flag.Int("txs", 4000, "Transactions in the block besides the coinbase")
+	inputs := flag.Int("inputs", 2, "Inputs per transaction")
+	outputs := flag.Int("outputs", 2, "Outputs per transaction")
+	runs := flag.Int("runs", 3, "Times each stage is run")
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "Goroutines checking scripts in parallel")
+	flag.Parse()
+
+	if *txs < 1 || *inputs < 1 || *outputs < 1 || *runs < 1 || *workers < 1 {
+		fmt.Println("Error: all flags must be positive")
+		os.Exit(1)
+	}
+
+	start := time.Now()
+	block, set, err := testharness.SyntheticBlock(testharness.SyntheticBlockConfig{
+		Transactions: *txs,
+		Inputs:       *inputs,
+		Outputs:      *outputs,
+	})
+	if err != nil {
+		fmt.Printf("Error: failed to build block: %v\n", err)
+		os.Exit(1)
+	}
+	data, err := serialization.SerializeBlock(block)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	weight, _ := validation.BlockWeight(block)
+	fmt.Printf("Block: %d transactions, %d inputs, %d bytes, weight %d (built in %v)\n",
+		len(block.Transactions), *txs**inputs, len(data), weight, time.Since(start).Round(time.Millisecond))
+
+	// Synthetic blocks may be bigger than consensus allows
+	rules := consensus.NewMainnetRules()
+	if uint32(weight) > rules.MaxBlockWeight {
+		rules.MaxBlockWeight = uint32(weight)
+		fmt.Println("Block is over the weight limit, raising it for the benchmark")
+	}
+
+	prevs, err := prevOutputs(block, set)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Each connection needs its own copy of the UTXO set
+	var view *utxo.UTXOSet
+
+	// Twice the memory the block's transactions take in a mempool
+	poolSize := int64(0)
+	for i := 1; i < len(block.Transactions); i++ {
+		poolSize += 2 * mempool.EstimateMemoryUsage(&block.Transactions[i], 0).Total()
+	}
+
+	stages := []struct {
+		name  string
+		setup func()
+		run   func() error
+	}{
+		{"serialize", nil, func() error {
+			_, err := serialization.SerializeBlock(block)
+			return err
+		}},
+		{"deserialize", nil, func() error {
+			_, err := serialization.DeserializeBlock(data)
+			return err
+		}},
+		{"scripts (serial)", nil, func() error {
+			return checkScripts(block, prevs, 1)
+		}},
+		{"scripts (parallel)", nil, func() error {
+			return checkScripts(block, prevs, *workers)
+		}},
+		{"connect block", func() { view = set.Clone() }, func() error {
+			validator := validation.NewBlockValidator(view)
+			validator.SetRules(rules)
+			if err := validator.ValidateBlock(block, 1, types.Hash{}); err != nil {
+				return err
+			}
+			return validator.ApplyBlock(block, 1)
+		}},
+		{"mempool add", nil, func() error {
+			pool := mempool.NewMempool(poolSize, 1000, 3600)
+			for i := 1; i < len(block.Transactions); i++ {
+				if err := pool.Add(&block.Transactions[i], 1000, 1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	}
+
+	fmt.Printf("\nBest of %d runs, %d script workers\n%-22s %12s %14s\n", *runs, *workers, "Stage", "Time", "Tx/s")
+	for _, stage := range stages {
+		var best time.Duration
+		for i := 0; i < *runs; i++ {
+			if stage.setup != nil {
+				stage.setup()
+			}
+			start := time.Now()
+			if err := stage.run(); err != nil {
+				fmt.Printf("FAILED: %s: %v\n", stage.name, err)
+				os.Exit(2)
+			}
+			if elapsed := time.Since(start); best == 0 || elapsed < best {
+				best = elapsed
+			}
+		}
+		fmt.Printf("%-22s %12v %14.0f\n", stage.name, best.Round(time.Microsecond), float64(len(block.Transactions))/best.Seconds())
+	}
+}
+
+// prevOutputs looks up the outputs every spend in block consumes
+func prevOutputs(block *types.Block, set *utxo.UTXOSet) ([][]types.TxOutput, error) {
+	prevs := make([][]types.TxOutput, len(block.Transactions))
+	for i := 1; i < len(block.Transactions); i++ {
+		for _, input := range block.Transactions[i].Inputs {
+			coin, err := set.Get(utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex))
+			if err != nil {
+				return nil, err
+			}
+			prevs[i] = append(prevs[i], coin.Output)
+		}
+	}
+	return prevs, nil
+}
+
+// checkScripts verifies every spend's scripts, handing transactions out to
+// workers from a shared queue and stopping at the first failure
+func checkScripts(block *types.Block, prevs [][]types.TxOutput, workers int) error {
+	queue := make(chan int, len(block.Transactions))
+	for i := 1; i < len(block.Transactions); i++ {
+		queue <- i
+	}
+	close(queue)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if err := transaction.ValidateTransactionScripts(&block.Transactions[i], prevs[i]); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("transaction %d: %w", i, err)
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
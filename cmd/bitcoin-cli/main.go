@@ -18,9 +18,12 @@ func main() {
 
 	// Default RPC server address
 	rpcAddr := flag.String("rpcaddr", "http://localhost:8332", "RPC server address")
+	rpcWallet := flag.String("rpcwallet", "", "Named wallet for wallet commands")
+	flag.BoolVar(&jsonOutput, "json", false, "Print results as JSON instead of tables")
 	flag.Parse()
 
 	client := rpc.NewClient(*rpcAddr)
+	client.SetWallet(*rpcWallet)
 	command := flag.Arg(0)
 
 	switch command {
@@ -38,6 +41,82 @@ func main() {
 		handleGetTransaction(client)
 	case "listaddresses":
 		handleListAddresses(client)
+	case "getpeerinfo":
+		handleGetPeerInfo(client)
+	case "addnode":
+		handleAddNode(client)
+	case "setban":
+		handleSetBan(client)
+	case "getchaintips":
+		handleGetChainTips(client)
+	case "verifychain":
+		handleVerifyChain(client)
+	case "getdiskusage":
+		handleGetDiskUsage(client)
+	case "compactdb":
+		handleCompactDB(client)
+	case "backupnode":
+		handleBackupNode(client)
+	case "getmempoolinfo":
+		handleGetMempoolInfo(client)
+	case "getrawmempool":
+		handleGetRawMempool(client)
+	case "getmempoolsnapshot":
+		handleGetMempoolSnapshot(client)
+	case "getmempooldiff":
+		handleGetMempoolDiff(client)
+	case "watchaddress":
+		handleWatchAddress(client, false)
+	case "unwatchaddress":
+		handleWatchAddress(client, true)
+	case "waitforwatchevents":
+		handleWaitForWatchEvents(client)
+	case "getdifficulty":
+		handleGetDifficulty(client)
+	case "getnetworkhashps":
+		handleGetNetworkHashPS(client)
+	case "getspentinfo":
+		handleGetSpentInfo(client)
+	case "gettxgraph":
+		handleGetTxGraph(client)
+	case "getblockhash":
+		handleGetBlockHash(client)
+	case "getblockheader":
+		handleGetBlockHeader(client)
+	case "decoderawtransaction":
+		handleDecodeRawTransaction(client)
+	case "decodeblock":
+		handleDecodeBlock(client)
+	case "validateblock":
+		handleValidateBlock(client)
+	case "createwallet":
+		handleCreateWallet(client)
+	case "encryptwallet":
+		handleEncryptWallet(client)
+	case "walletpassphrase":
+		handleWalletPassphrase(client)
+	case "walletlock":
+		handleWalletLock(client)
+	case "sethdseed":
+		handleSetHDSeed(client)
+	case "setwalletflag":
+		handleSetWalletFlag(client)
+	case "listdescriptors":
+		handleListDescriptors(client)
+	case "getdescriptorinfo":
+		handleGetDescriptorInfo(client)
+	case "importprivkey":
+		handleImportPrivKey(client)
+	case "dumpwallet":
+		handleDumpWallet(client)
+	case "listunspent":
+		handleListUnspent(client)
+	case "listtransactions":
+		handleListTransactions(client)
+	case "bumpfee":
+		handleBumpFee(client)
+	case "abandontransaction":
+		handleAbandonTransaction(client)
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		printUsage()
@@ -51,14 +130,56 @@ func printUsage() {
 	fmt.Println("  bitcoin-cli [options] <command> [args...]")
 	fmt.Println("\nOptions:")
 	fmt.Println("  -rpcaddr <url>    RPC server address (default: http://localhost:8332)")
+	fmt.Println("  -rpcwallet <name> Named wallet for wallet commands (default: the node's wallet)")
+	fmt.Println("  -json             Print results as JSON instead of tables")
 	fmt.Println("\nCommands:")
 	fmt.Println("  getnewaddress                    Generate a new address")
 	fmt.Println("  getbalance                       Show wallet balance")
 	fmt.Println("  sendtoaddress <address> <amount> Send coins to address")
 	fmt.Println("  getblockcount                    Show current blockchain height")
-	fmt.Println("  getblock <height>                Retrieve block by height")
+	fmt.Println("  getblock <height|hash> [verb]    Retrieve block (verbosity 0 hex, 1 txids, 2 decoded)")
 	fmt.Println("  gettransaction <txhash>          Retrieve transaction by hash")
 	fmt.Println("  listaddresses                    List all wallet addresses")
+	fmt.Println("\nNetwork and Chain:")
+	fmt.Println("  getpeerinfo                             List connected peers")
+	fmt.Println("  addnode <host:port> <add|remove|onetry> Manage the added node list")
+	fmt.Println("  setban <ip> <add|remove> [seconds]      Ban or unban a peer address")
+	fmt.Println("  getchaintips                            List the best chain tip and known forks")
+	fmt.Println("  verifychain [checklevel] [nblocks]      Re-validate recent blocks to catch disk corruption")
+	fmt.Println("  getdiskusage                            Show the chain database's size by kind of entry")
+	fmt.Println("  compactdb                               Compact the chain database to reclaim space")
+	fmt.Println("  backupnode <destination>                Back up the chain and wallets to a tarball")
+	fmt.Println("  getmempoolinfo                          Summarize the mempool")
+	fmt.Println("  getrawmempool [verbose]                 List mempool transactions")
+	fmt.Println("  getmempoolsnapshot                      Capture the mempool for later diffs")
+	fmt.Println("  getmempooldiff <from> [to]              Show what entered and left the mempool between snapshots")
+	fmt.Println("  watchaddress <address>                  Report payments to and spends from an address")
+	fmt.Println("  unwatchaddress <address>                Stop reporting payments to an address")
+	fmt.Println("  waitforwatchevents [since] [timeout_ms] Wait for payments to or spends from watched addresses")
+	fmt.Println("  getdifficulty                           Show the current proof-of-work difficulty")
+	fmt.Println("  getnetworkhashps [nblocks] [height]     Estimate the network hash rate over recent blocks")
+	fmt.Println("  getspentinfo <txid> <n>                 Show the input that spent an output")
+	fmt.Println("  gettxgraph <txid> [depth]               Show the ancestors and descendants of a transaction")
+	fmt.Println("  getblockhash <height>                   Show the hash of the block at height")
+	fmt.Println("  getblockheader <hash> [verbose]         Show a block header, as hex if verbose=false")
+	fmt.Println("  decoderawtransaction <hex>              Decode a raw transaction as JSON")
+	fmt.Println("  decodeblock <hex>                       Decode a raw block as JSON")
+	fmt.Println("  validateblock <hex>                     Check a raw block against every rule")
+	fmt.Println("\nWallet Management:")
+	fmt.Println("  createwallet <name> [passphrase]        Create a named wallet, encrypted if a passphrase is given")
+	fmt.Println("  encryptwallet <passphrase>              Encrypt the wallet's keys and lock it")
+	fmt.Println("  walletpassphrase <passphrase> <seconds> Unlock an encrypted wallet for a while")
+	fmt.Println("  walletlock                              Lock an encrypted wallet")
+	fmt.Println("  sethdseed <seed hex> [gaplimit]         Derive keys from a seed, restoring its used addresses")
+	fmt.Println("  setwalletflag <flag> [value]            Set a wallet flag (avoid_reuse), or clear it with value=false")
+	fmt.Println("  listdescriptors [private]               Export the wallet's descriptors, with private keys if private=true")
+	fmt.Println("  getdescriptorinfo <descriptor>          Add or check a descriptor's checksum")
+	fmt.Println("  importprivkey <wif> [rescan]            Import a private key, rescanning unless rescan=false")
+	fmt.Println("  dumpwallet <filename>                   Write all private keys to a new file on the node")
+	fmt.Println("  listunspent [minconf]                   List unspent wallet outputs (default minconf 1)")
+	fmt.Println("  listtransactions [count] [skip]         List recent wallet transactions (default 10)")
+	fmt.Println("  bumpfee <txid> [fee]                    Replace an unconfirmed transaction with a higher fee")
+	fmt.Println("  abandontransaction <txid>               Release the inputs of a transaction stuck outside the mempool")
 }
 
 func handleGetNewAddress(client *rpc.Client) {
@@ -115,18 +236,55 @@ func handleGetBlockCount(client *rpc.Client) {
 
 func handleGetBlock(client *rpc.Client) {
 	if flag.NArg() < 2 {
-		fmt.Println("Usage: getblock <height>")
+		fmt.Println("Usage: getblock <height|hash> [verbosity]")
 		os.Exit(1)
 	}
 
-	heightStr := flag.Arg(1)
-	height, err := strconv.ParseUint(heightStr, 10, 64)
-	if err != nil {
-		fmt.Printf("Invalid height: %v\n", err)
-		os.Exit(1)
+	verbosity := uint64(1)
+	if flag.NArg() > 2 {
+		v, err := strconv.ParseUint(flag.Arg(2), 10, 8)
+		if err != nil || v > 2 {
+			fmt.Printf("Invalid verbosity: %s (0, 1 or 2)\n", flag.Arg(2))
+			os.Exit(1)
+		}
+		verbosity = v
+	}
+
+	// A 64 hex digit argument is a hash, which may name a side-chain block
+	hash := flag.Arg(1)
+	if len(hash) != 64 {
+		height, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if err != nil {
+			fmt.Printf("Invalid height: %v\n", err)
+			os.Exit(1)
+		}
+		if hash, err = client.GetBlockHash(height); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	block, err := client.GetBlock(height)
+	switch verbosity {
+	case 0:
+		data, err := client.GetRawBlock(hash)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(data)
+		return
+	case 2:
+		// Decoded transactions nest too deeply for a table
+		block, err := client.GetBlockVerbose(hash)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		printJSON(block)
+		return
+	}
+
+	block, err := client.GetBlockByHash(hash)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
@@ -137,6 +295,11 @@ func handleGetBlock(client *rpc.Client) {
 	fmt.Fprintf(w, "=================\n")
 	fmt.Fprintf(w, "Hash:\t%s\n", block.Hash)
 	fmt.Fprintf(w, "Height:\t%d\n", block.Height)
+	if block.Confirmations < 0 {
+		fmt.Fprintf(w, "Confirmations:\tnot on the best chain\n")
+	} else {
+		fmt.Fprintf(w, "Confirmations:\t%d\n", block.Confirmations)
+	}
 	fmt.Fprintf(w, "Version:\t%d\n", block.Version)
 	fmt.Fprintf(w, "Previous Hash:\t%s\n", block.PrevHash)
 	fmt.Fprintf(w, "Merkle Root:\t%s\n", block.MerkleRoot)
@@ -0,0 +1,757 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+)
+
+// formatBytes shows a byte count with a binary unit
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatFeeRate shows a sat/kvB fee rate in sat/vB, to the 1/1000th
+func formatFeeRate(satPerKvB int64) string {
+	return fmt.Sprintf("%d.%03d", satPerKvB/1000, satPerKvB%1000)
+}
+
+// formatAge shows how long ago a Unix time was, or "-" for zero
+func formatAge(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Since(time.Unix(unix, 0)).Round(time.Second).String()
+}
+
+func handleGetPeerInfo(client *rpc.Client) {
+	peers, err := client.GetPeerInfo()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(peers)
+		return
+	}
+	if len(peers) == 0 {
+		fmt.Println("No connected peers")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tADDRESS\tDIR\tVERSION\tSUBVER\tHEIGHT\tPING\tSENT\tRECV\tCONNECTED\tBANSCORE\n")
+	for _, p := range peers {
+		direction := "out"
+		if p.Inbound {
+			direction = "in"
+		}
+		ping := "-"
+		if p.PingTime > 0 {
+			ping = fmt.Sprintf("%.0fms", p.PingTime*1000)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%d\t%s\t%s\t%s\t%s\t%d\n",
+			p.ID, p.Addr, direction, p.Version, p.SubVer, p.StartingHeight, ping,
+			formatBytes(p.BytesSent), formatBytes(p.BytesRecv), formatAge(p.ConnTime), p.BanScore)
+	}
+	w.Flush()
+	fmt.Printf("\n%d peers\n", len(peers))
+}
+
+func handleAddNode(client *rpc.Client) {
+	if flag.NArg() < 3 {
+		fmt.Println("Usage: addnode <host:port> <add|remove|onetry>")
+		os.Exit(1)
+	}
+
+	node, command := flag.Arg(1), flag.Arg(2)
+	if err := client.AddNode(node, command); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(nil)
+		return
+	}
+	switch command {
+	case "add":
+		fmt.Printf("Added %s to the node list\n", node)
+	case "remove":
+		fmt.Printf("Removed %s from the node list\n", node)
+	default:
+		fmt.Printf("Connecting to %s once\n", node)
+	}
+}
+
+func handleSetBan(client *rpc.Client) {
+	if flag.NArg() < 3 {
+		fmt.Println("Usage: setban <ip> <add|remove> [bantime seconds]")
+		os.Exit(1)
+	}
+
+	ip, command := flag.Arg(1), flag.Arg(2)
+	var banTime int64
+	if flag.NArg() > 3 {
+		var err error
+		if banTime, err = strconv.ParseInt(flag.Arg(3), 10, 64); err != nil {
+			fmt.Printf("Invalid bantime: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := client.SetBan(ip, command, banTime); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(nil)
+		return
+	}
+	if command == "add" {
+		fmt.Printf("Banned %s\n", ip)
+	} else {
+		fmt.Printf("Unbanned %s\n", ip)
+	}
+}
+
+func handleGetChainTips(client *rpc.Client) {
+	tips, err := client.GetChainTips()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(tips)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "HEIGHT\tSTATUS\tBRANCHLEN\tHASH\n")
+	for _, tip := range tips {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", tip.Height, tip.Status, tip.BranchLen, tip.Hash)
+	}
+	w.Flush()
+}
+
+func handleVerifyChain(client *rpc.Client) {
+	level := -1
+	if flag.NArg() > 1 {
+		l, err := strconv.Atoi(flag.Arg(1))
+		if err != nil {
+			fmt.Printf("Invalid checklevel: %v\n", err)
+			os.Exit(1)
+		}
+		level = l
+	}
+	nblocks := -1
+	if flag.NArg() > 2 {
+		n, err := strconv.Atoi(flag.Arg(2))
+		if err != nil || n < 0 {
+			fmt.Printf("Invalid nblocks: %s\n", flag.Arg(2))
+			os.Exit(1)
+		}
+		nblocks = n
+	}
+
+	result, err := client.VerifyChain(level, nblocks)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	if !result.Valid {
+		fmt.Printf("Chain verification failed at height %d: %s\n", *result.FailedHeight, result.Error)
+		os.Exit(1)
+	}
+	fmt.Printf("Blocks %d to %d verified at level %d\n", result.From, result.To, result.CheckLevel)
+}
+
+func handleGetDiskUsage(client *rpc.Client) {
+	usage, err := client.GetDiskUsage()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(usage)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "KIND\tENTRIES\tSIZE\tON DISK\n")
+	for _, space := range usage.KeySpaces {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", space.Name, space.Entries,
+			formatBytes(uint64(space.Bytes)), formatBytes(uint64(space.DiskBytes)))
+	}
+	fmt.Fprintf(w, "total\t\t\t%s\n", formatBytes(uint64(usage.TotalBytes)))
+	w.Flush()
+}
+
+func handleCompactDB(client *rpc.Client) {
+	result, err := client.CompactDB()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("Compacted from %s to %s\n", formatBytes(uint64(result.BytesBefore)), formatBytes(uint64(result.BytesAfter)))
+}
+
+func handleBackupNode(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: bitcoin-cli backupnode <destination>")
+		os.Exit(1)
+	}
+
+	result, err := client.BackupNode(flag.Arg(1))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("Backed up %d entries up to block %d and %d wallet(s) to %s (%s)\n",
+		result.Entries, result.TipHeight, len(result.Wallets), result.Destination, formatBytes(uint64(result.Bytes)))
+}
+
+func handleGetMempoolInfo(client *rpc.Client) {
+	info, err := client.GetMempoolInfo()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(info)
+		return
+	}
+
+	usage := 0.0
+	if info.MaxMempool > 0 {
+		usage = 100 * float64(info.Usage) / float64(info.MaxMempool)
+	}
+	split := info.UsageSplit
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Mempool Information\n")
+	fmt.Fprintf(w, "===================\n")
+	fmt.Fprintf(w, "Transactions:\t%d\n", info.Size)
+	fmt.Fprintf(w, "Size:\t%s\n", formatBytes(uint64(info.Bytes)))
+	fmt.Fprintf(w, "Memory:\t%s of %s (%.2f%%)\n", formatBytes(uint64(info.Usage)), formatBytes(uint64(info.MaxMempool)), usage)
+	fmt.Fprintf(w, "  Transactions:\t%s\n", formatBytes(uint64(split.Transactions)))
+	fmt.Fprintf(w, "  Entries:\t%s\n", formatBytes(uint64(split.Entries)))
+	fmt.Fprintf(w, "  Spent Index:\t%s\n", formatBytes(uint64(split.Spends)))
+	fmt.Fprintf(w, "  Links:\t%s\n", formatBytes(uint64(split.Links)))
+	fmt.Fprintf(w, "Total Fees:\t%s BTC\n", formatBTC(info.TotalFee))
+	fmt.Fprintf(w, "Min Fee Rate:\t%s sat/vB\n", formatFeeRate(info.MinFeeRate))
+	fmt.Fprintf(w, "Min Relay Fee:\t%s sat/vB\n", formatFeeRate(info.MinRelayFee))
+	fmt.Fprintf(w, "Incremental Fee:\t%s sat/vB\n", formatFeeRate(info.IncrementalFee))
+	fmt.Fprintf(w, "Replaceable:\t%d\n", info.Replaceable)
+	fmt.Fprintf(w, "Oldest Entry:\t%s\n", formatAge(info.OldestEntered))
+	w.Flush()
+}
+
+func handleGetRawMempool(client *rpc.Client) {
+	verbose := false
+	if flag.NArg() > 1 {
+		var err error
+		if verbose, err = strconv.ParseBool(flag.Arg(1)); err != nil {
+			fmt.Printf("Invalid verbose flag: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	pool, err := client.GetRawMempool(verbose)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		if verbose {
+			printJSON(pool.Entries)
+		} else {
+			printJSON(pool.TxIDs)
+		}
+		return
+	}
+	if len(pool.TxIDs) == 0 {
+		fmt.Println("Mempool is empty")
+		return
+	}
+	if !verbose {
+		for _, txid := range pool.TxIDs {
+			fmt.Println(txid)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TXID\tSIZE\tFEE\tSAT/VB\tAGE\tDEPENDS\tRBF\n")
+	for _, e := range pool.Entries {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%d\t%t\n",
+			e.TxID, e.Size, e.Fee, formatFeeRate(e.FeeRate), formatAge(e.Time), len(e.Depends), e.Replaceable)
+	}
+	w.Flush()
+}
+
+func handleGetMempoolSnapshot(client *rpc.Client) {
+	snapshot, err := client.GetMempoolSnapshot()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(snapshot)
+		return
+	}
+	fmt.Printf("Snapshot %d at height %d: %d transactions\n", snapshot.ID, snapshot.Height, len(snapshot.Transactions))
+	if len(snapshot.Transactions) == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TXID\tSIZE\tFEE\tSAT/VB\n")
+	for _, tx := range snapshot.Transactions {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", tx.TxID, tx.Size, tx.Fee, formatFeeRate(tx.FeeRate))
+	}
+	w.Flush()
+}
+
+func handleGetMempoolDiff(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: getmempooldiff <from> [to]")
+		os.Exit(1)
+	}
+	from, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+	if err != nil {
+		fmt.Printf("Invalid snapshot id: %v\n", err)
+		os.Exit(1)
+	}
+	to := uint64(0)
+	if flag.NArg() > 2 {
+		if to, err = strconv.ParseUint(flag.Arg(2), 10, 64); err != nil {
+			fmt.Printf("Invalid snapshot id: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	diff, err := client.GetMempoolDiff(from, to)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(diff)
+		return
+	}
+	fmt.Printf("Snapshot %d -> %d\n", diff.From, diff.To)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CHANGE\tTXID\tSIZE\tFEE\tSAT/VB\n")
+	for _, group := range []struct {
+		change string
+		txs    []rpc.SnapshotTxInfo
+	}{
+		{"added", diff.Added},
+		{"mined", diff.Mined},
+		{"evicted", diff.Evicted},
+		{"expired", diff.Expired},
+		{"replaced", diff.Replaced},
+		{"conflicted", diff.Conflicted},
+		{"removed", diff.Removed},
+	} {
+		for _, tx := range group.txs {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", group.change, tx.TxID, tx.Size, tx.Fee, formatFeeRate(tx.FeeRate))
+		}
+	}
+	w.Flush()
+}
+
+func handleGetDifficulty(client *rpc.Client) {
+	difficulty, err := client.GetDifficulty()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(difficulty)
+		return
+	}
+	fmt.Printf("Difficulty: %g\n", difficulty)
+}
+
+func handleGetNetworkHashPS(client *rpc.Client) {
+	nblocks := uint64(rpc.DefaultHashRateBlocks)
+	if flag.NArg() > 1 {
+		n, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if err != nil {
+			fmt.Printf("Invalid nblocks: %v\n", err)
+			os.Exit(1)
+		}
+		nblocks = n
+	}
+	height := int64(-1)
+	if flag.NArg() > 2 {
+		h, err := strconv.ParseInt(flag.Arg(2), 10, 64)
+		if err != nil {
+			fmt.Printf("Invalid height: %v\n", err)
+			os.Exit(1)
+		}
+		height = h
+	}
+
+	info, err := client.GetNetworkHashPS(nblocks, height)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(info)
+		return
+	}
+	fmt.Printf("Network hash rate: %g H/s (%d blocks up to height %d)\n", info.NetworkHashPS, info.Blocks, info.Height)
+	if info.LocalHashPS != nil {
+		fmt.Printf("Local miner:       %g H/s\n", *info.LocalHashPS)
+	}
+}
+
+func handleGetSpentInfo(client *rpc.Client) {
+	if flag.NArg() < 3 {
+		fmt.Println("Usage: getspentinfo <txid> <n>")
+		os.Exit(1)
+	}
+
+	n, err := strconv.ParseUint(flag.Arg(2), 10, 32)
+	if err != nil {
+		fmt.Printf("Invalid output index: %v\n", err)
+		os.Exit(1)
+	}
+
+	info, err := client.GetSpentInfo(flag.Arg(1), uint32(n), true)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(info)
+		return
+	}
+	if info == nil {
+		fmt.Println("Not spent (or spent before the index was enabled)")
+		return
+	}
+	fmt.Printf("Spent by: %s:%d\n", info.TxID, info.Index)
+	if info.Confirmed {
+		fmt.Printf("Block:    %s (height %d)\n", info.BlockHash, info.Height)
+	} else {
+		fmt.Println("Block:    unconfirmed")
+	}
+}
+
+func handleGetTxGraph(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: gettxgraph <txid> [depth]")
+		os.Exit(1)
+	}
+
+	depth := 10
+	if flag.NArg() > 2 {
+		var err error
+		if depth, err = strconv.Atoi(flag.Arg(2)); err != nil {
+			fmt.Printf("Invalid depth: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	graph, err := client.GetTxGraph(flag.Arg(1), depth)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(graph)
+		return
+	}
+	// Oldest ancestors at the top, down through the root to its spenders
+	nodes := []rpc.TxGraphNode{graph.Root}
+	for _, node := range graph.Ancestors {
+		nodes = append([]rpc.TxGraphNode{node}, nodes...)
+	}
+	nodes = append(nodes, graph.Descendants...)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "DEPTH\tTXID\tSTATUS\tFEE\n")
+	for _, node := range nodes {
+		status := "mempool"
+		if node.Confirmed {
+			status = fmt.Sprintf("block %d", node.Height)
+		}
+		fee := "-"
+		if !node.Confirmed {
+			fee = fmt.Sprintf("%d (%d bytes)", node.Fee, node.Size)
+		}
+		fmt.Fprintf(w, "%+d\t%s\t%s\t%s\n", node.Depth, node.TxID, status, fee)
+	}
+	w.Flush()
+	if graph.Truncated {
+		fmt.Println("\nTruncated: the graph is too large or confirmed spends aren't indexed")
+	}
+}
+
+func handleGetBlockHash(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: getblockhash <height>")
+		os.Exit(1)
+	}
+
+	height, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+	if err != nil {
+		fmt.Printf("Invalid height: %v\n", err)
+		os.Exit(1)
+	}
+
+	hash, err := client.GetBlockHash(height)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(hash)
+		return
+	}
+	fmt.Println(hash)
+}
+
+func handleGetBlockHeader(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: getblockheader <hash> [verbose]")
+		os.Exit(1)
+	}
+
+	verbose := true
+	if flag.NArg() > 2 {
+		v, err := strconv.ParseBool(flag.Arg(2))
+		if err != nil {
+			fmt.Printf("Invalid verbose: %v\n", err)
+			os.Exit(1)
+		}
+		verbose = v
+	}
+
+	if !verbose {
+		data, err := client.GetBlockHeaderHex(flag.Arg(1))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(data)
+		return
+	}
+
+	header, err := client.GetBlockHeader(flag.Arg(1))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(header)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Hash:\t%s\n", header.Hash)
+	fmt.Fprintf(w, "Height:\t%d\n", header.Height)
+	if header.Confirmations < 0 {
+		fmt.Fprintf(w, "Confirmations:\tnot on the best chain\n")
+	} else {
+		fmt.Fprintf(w, "Confirmations:\t%d\n", header.Confirmations)
+	}
+	fmt.Fprintf(w, "Version:\t%d\n", header.Version)
+	fmt.Fprintf(w, "Merkle Root:\t%s\n", header.MerkleRoot)
+	fmt.Fprintf(w, "Timestamp:\t%d\n", header.Timestamp)
+	fmt.Fprintf(w, "Bits:\t0x%08x\n", header.Bits)
+	fmt.Fprintf(w, "Nonce:\t%d\n", header.Nonce)
+	fmt.Fprintf(w, "Difficulty:\t%g\n", header.Difficulty)
+	fmt.Fprintf(w, "Chain Work:\t%s\n", header.ChainWork)
+	fmt.Fprintf(w, "Transactions:\t%d\n", header.NTx)
+	if header.PrevHash != "" {
+		fmt.Fprintf(w, "Previous Hash:\t%s\n", header.PrevHash)
+	}
+	if header.NextHash != "" {
+		fmt.Fprintf(w, "Next Hash:\t%s\n", header.NextHash)
+	}
+	w.Flush()
+}
+
+// The decoded forms nest too deeply for a table, so the decode commands
+// always print JSON
+
+func handleDecodeRawTransaction(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: decoderawtransaction <hex>")
+		os.Exit(1)
+	}
+
+	tx, err := client.DecodeRawTransaction(flag.Arg(1))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	printJSON(tx)
+}
+
+func handleDecodeBlock(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: decodeblock <hex>")
+		os.Exit(1)
+	}
+
+	block, err := client.DecodeBlock(flag.Arg(1))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	printJSON(block)
+}
+
+func handleValidateBlock(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: validateblock <hex>")
+		os.Exit(1)
+	}
+
+	report, err := client.ValidateBlock(flag.Arg(1))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(report)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Hash:\t%s\n", report.Hash)
+	if report.Valid {
+		fmt.Fprintf(w, "Result:\tvalid\n")
+	} else {
+		fmt.Fprintf(w, "Result:\tinvalid (%s)\n", report.Reason)
+	}
+	fmt.Fprintf(w, "Size:\t%d bytes\n", report.Size)
+	fmt.Fprintf(w, "Weight:\t%d\n", report.Weight)
+	fmt.Fprintf(w, "Sigops:\t%d\n", report.SigOps)
+	fmt.Fprintf(w, "Fees:\t%d satoshis\n", report.Fees)
+	fmt.Fprintf(w, "Coinbase Value:\t%d satoshis\n", report.CoinbaseValue)
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "RULE\tRESULT\tERROR\n")
+	for _, rule := range report.Rules {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", rule.Rule, rule.Result, rule.Error)
+	}
+	w.Flush()
+}
+
+func handleWatchAddress(client *rpc.Client, unwatch bool) {
+	command, call := "watchaddress", client.WatchAddress
+	if unwatch {
+		command, call = "unwatchaddress", client.UnwatchAddress
+	}
+	if flag.NArg() < 2 {
+		fmt.Printf("Usage: %s <address>\n", command)
+		os.Exit(1)
+	}
+
+	result, err := call(flag.Arg(1))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	if unwatch {
+		fmt.Printf("Stopped watching %s\n", result.Address)
+	} else {
+		fmt.Printf("Watching %s (script %s)\n", result.Address, result.Script)
+	}
+}
+
+func handleWaitForWatchEvents(client *rpc.Client) {
+	since := uint64(0)
+	if flag.NArg() > 1 {
+		n, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if err != nil {
+			fmt.Printf("Invalid since: %v\n", err)
+			os.Exit(1)
+		}
+		since = n
+	}
+	timeout := time.Duration(0)
+	if flag.NArg() > 2 {
+		ms, err := strconv.ParseUint(flag.Arg(2), 10, 32)
+		if err != nil {
+			fmt.Printf("Invalid timeout: %v\n", err)
+			os.Exit(1)
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	result, err := client.WaitForWatchEvents(since, timeout)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	if len(result.Events) == 0 {
+		fmt.Printf("No events (last %d)\n", result.Last)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "SEQ\tTYPE\tTXID\tVALUE\tADDRESS\tCONFIRMED\n")
+	for _, e := range result.Events {
+		fmt.Fprintf(w, "%d\t%s\t%s:%d\t%d\t%s\t%t\n", e.Sequence, e.Type, e.TxID, e.Index, e.Value, e.Address, e.Confirmed)
+	}
+	w.Flush()
+}
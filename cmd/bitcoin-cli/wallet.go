@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+)
+
+// jsonOutput prints command results as JSON instead of tables
+var jsonOutput bool
+
+// printJSON writes v as indented JSON
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// formatBTC shows satoshis as BTC with eight decimals
+func formatBTC(satoshis int64) string {
+	return fmt.Sprintf("%.8f", float64(satoshis)/100000000.0)
+}
+
+// printWalletStatus reports the lock state after an encryption command
+func printWalletStatus(status *rpc.WalletStatusResponse) {
+	if jsonOutput {
+		printJSON(status)
+		return
+	}
+	switch {
+	case status.Locked:
+		fmt.Println("Wallet is encrypted and locked")
+	case status.UnlockedUntil > 0:
+		fmt.Printf("Wallet unlocked until %s\n", time.Unix(status.UnlockedUntil, 0).Format(time.RFC3339))
+	default:
+		fmt.Println("Wallet is unlocked")
+	}
+}
+
+func handleCreateWallet(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: createwallet <name> [passphrase]")
+		os.Exit(1)
+	}
+
+	result, err := client.CreateWallet(flag.Arg(1), flag.Arg(2))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("Created wallet %q", result.Name)
+	if result.Encrypted {
+		fmt.Print(" (encrypted)")
+	}
+	fmt.Printf("\nUse it with: bitcoin-cli -rpcwallet %s <command>\n", result.Name)
+}
+
+func handleEncryptWallet(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: encryptwallet <passphrase>")
+		os.Exit(1)
+	}
+
+	status, err := client.EncryptWallet(flag.Arg(1))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	printWalletStatus(status)
+}
+
+func handleWalletPassphrase(client *rpc.Client) {
+	if flag.NArg() < 3 {
+		fmt.Println("Usage: walletpassphrase <passphrase> <timeout seconds>")
+		os.Exit(1)
+	}
+
+	timeout, err := strconv.ParseInt(flag.Arg(2), 10, 64)
+	if err != nil {
+		fmt.Printf("Invalid timeout: %v\n", err)
+		os.Exit(1)
+	}
+
+	status, err := client.WalletPassphrase(flag.Arg(1), timeout)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	printWalletStatus(status)
+}
+
+func handleWalletLock(client *rpc.Client) {
+	status, err := client.WalletLock()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	printWalletStatus(status)
+}
+
+func handleSetHDSeed(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: sethdseed <seed hex> [gaplimit]")
+		os.Exit(1)
+	}
+	seed, err := hex.DecodeString(flag.Arg(1))
+	if err != nil {
+		fmt.Printf("Invalid seed: %v\n", err)
+		os.Exit(1)
+	}
+
+	gapLimit := 0
+	if flag.NArg() > 2 {
+		if gapLimit, err = strconv.Atoi(flag.Arg(2)); err != nil || gapLimit < 1 {
+			fmt.Printf("Invalid gap limit: %s\n", flag.Arg(2))
+			os.Exit(1)
+		}
+	}
+
+	result, err := client.SetHDSeed(seed, gapLimit)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("HD seed set\n")
+	fmt.Printf("  External: highest used index %d, next %d\n", result.External, result.NextExternal)
+	fmt.Printf("  Internal: highest used index %d, next %d\n", result.Internal, result.NextInternal)
+}
+
+func handleSetWalletFlag(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: setwalletflag <flag> [value]")
+		os.Exit(1)
+	}
+
+	value := true
+	if flag.NArg() > 2 {
+		var err error
+		if value, err = strconv.ParseBool(flag.Arg(2)); err != nil {
+			fmt.Printf("Invalid value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	result, err := client.SetWalletFlag(flag.Arg(1), value)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("%s: %t\n", result.FlagName, result.FlagState)
+}
+
+func handleListDescriptors(client *rpc.Client) {
+	private := false
+	if flag.NArg() > 1 {
+		var err error
+		if private, err = strconv.ParseBool(flag.Arg(1)); err != nil {
+			fmt.Printf("Invalid private flag: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	descs, err := client.ListDescriptors(private)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(rpc.ListDescriptorsResponse{Descriptors: descs})
+		return
+	}
+	for _, desc := range descs {
+		note := ""
+		if desc.Active {
+			note = fmt.Sprintf(" (active, next %d)", desc.Next)
+			if desc.Internal {
+				note = fmt.Sprintf(" (active change, next %d)", desc.Next)
+			}
+		}
+		fmt.Printf("%s%s\n", desc.Desc, note)
+	}
+}
+
+func handleGetDescriptorInfo(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: getdescriptorinfo <descriptor>")
+		os.Exit(1)
+	}
+
+	info, err := client.GetDescriptorInfo(flag.Arg(1))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(info)
+		return
+	}
+	fmt.Println(info.Descriptor)
+}
+
+func handleImportPrivKey(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: importprivkey <wif> [rescan]")
+		os.Exit(1)
+	}
+
+	rescan := true
+	if flag.NArg() > 2 {
+		var err error
+		if rescan, err = strconv.ParseBool(flag.Arg(2)); err != nil {
+			fmt.Printf("Invalid rescan flag: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	address, err := client.ImportPrivKey(flag.Arg(1), rescan)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(rpc.ImportPrivKeyResponse{Address: address})
+		return
+	}
+	fmt.Printf("Imported key for %s\n", address)
+}
+
+func handleDumpWallet(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: dumpwallet <filename>")
+		os.Exit(1)
+	}
+
+	result, err := client.DumpWallet(flag.Arg(1))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("Wrote %d keys to %s on the node\n", result.Keys, result.Filename)
+}
+
+func handleListUnspent(client *rpc.Client) {
+	minConf := uint64(1)
+	if flag.NArg() > 1 {
+		var err error
+		if minConf, err = strconv.ParseUint(flag.Arg(1), 10, 64); err != nil {
+			fmt.Printf("Invalid minconf: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	unspent, err := client.ListUnspent(minConf)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(unspent)
+		return
+	}
+	if len(unspent) == 0 {
+		fmt.Println("No unspent outputs")
+		return
+	}
+
+	var total int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TXID:VOUT\tADDRESS\tAMOUNT (BTC)\tCONFS\n")
+	for _, u := range unspent {
+		note := ""
+		if u.Coinbase {
+			note = " (coinbase)"
+		}
+		fmt.Fprintf(w, "%s:%d\t%s\t%s\t%d%s\n", u.TxID, u.Vout, u.Address, formatBTC(u.Amount), u.Confirmations, note)
+		total += u.Amount
+	}
+	w.Flush()
+	fmt.Printf("\n%d outputs, %s BTC\n", len(unspent), formatBTC(total))
+}
+
+func handleListTransactions(client *rpc.Client) {
+	count, skip := 10, 0
+	for i, dst := range []*int{&count, &skip} {
+		if flag.NArg() <= i+1 {
+			break
+		}
+		n, err := strconv.Atoi(flag.Arg(i + 1))
+		if err != nil || n < 0 {
+			fmt.Printf("Invalid number: %s\n", flag.Arg(i+1))
+			os.Exit(1)
+		}
+		*dst = n
+	}
+
+	txs, err := client.ListTransactions(count, skip)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(txs)
+		return
+	}
+	if len(txs) == 0 {
+		fmt.Println("No transactions")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TIME\tCATEGORY\tAMOUNT (BTC)\tFEE\tCONFS\tTXID\n")
+	for _, tx := range txs {
+		category := tx.Category
+		switch {
+		case tx.ReplacedBy != "":
+			category += " (replaced)"
+		case tx.Abandoned:
+			category += " (abandoned)"
+		case tx.Conflicted:
+			category += " (conflicted)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n",
+			time.Unix(tx.Time, 0).Format("2006-01-02 15:04:05"), category, formatBTC(tx.Amount), tx.Fee, tx.Confirmations, tx.TxID)
+	}
+	w.Flush()
+}
+
+func handleBumpFee(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: bumpfee <txid> [fee]")
+		os.Exit(1)
+	}
+
+	var fee int64
+	if flag.NArg() > 2 {
+		var err error
+		if fee, err = strconv.ParseInt(flag.Arg(2), 10, 64); err != nil {
+			fmt.Printf("Invalid fee: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	result, err := client.BumpFee(flag.Arg(1), fee)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Replacement:\t%s\n", result.TxID)
+	fmt.Fprintf(w, "Fee:\t%d -> %d satoshis\n", result.OrigFee, result.Fee)
+	fmt.Fprintf(w, "Relayed:\t%t\n", result.Broadcast)
+	w.Flush()
+}
+
+func handleAbandonTransaction(client *rpc.Client) {
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: abandontransaction <txid>")
+		os.Exit(1)
+	}
+
+	txid := flag.Arg(1)
+	if err := client.AbandonTransaction(txid); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(nil)
+		return
+	}
+	fmt.Printf("Abandoned %s\n", txid)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+func main() {
+	dataDir := flag.String("datadir", "./data", "Blockchain data directory")
+	network := flag.String("network", "mainnet", "Network whose subsidy schedule applies (mainnet, testnet, signet, regtest)")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	rules, err := consensus.NewRulesForNetwork(*network)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	command := flag.Arg(0)
+
+	switch command {
+	case "halving":
+		handleHalving(rules)
+	case "audit", "fees":
+		chain, err := storage.NewBlockchainStorage(*dataDir)
+		if err != nil {
+			fmt.Printf("Error: failed to open blockchain: %v\n", err)
+			os.Exit(1)
+		}
+		defer chain.Close()
+
+		report, err := validation.AuditChain(chain, rules)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if command == "audit" {
+			printAudit(report)
+		} else {
+			printFees(report)
+		}
+
+		if report.Violations > 0 {
+			os.Exit(2)
+		}
+	default:
+		fmt.Printf("Unknown command: %s\n", command)
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Println("Chain Analysis Tool")
+	fmt.Println("\nUsage:")
+	fmt.Println("  chainutil [options] <command>")
+	fmt.Println("\nOptions:")
+	fmt.Println("  -datadir <path>   Blockchain data directory (default: ./data)")
+	fmt.Println("  -network <name>   mainnet, testnet, signet or regtest (default: mainnet)")
+	fmt.Println("\nCommands:")
+	fmt.Println("  audit             Verify issued supply against the subsidy schedule")
+	fmt.Println("  fees              List fee totals per block")
+	fmt.Println("  halving           Print the subsidy halving schedule")
+}
+
+func printAudit(report *validation.AuditReport) {
+	fmt.Printf("Blocks checked:   %d\n", len(report.Blocks))
+	fmt.Printf("Expected supply:  %s BTC\n", formatBTC(report.ExpectedSupply))
+	fmt.Printf("Issued supply:    %s BTC\n", formatBTC(report.IssuedSupply))
+	fmt.Printf("Total fees:       %s BTC\n", formatBTC(report.TotalFees))
+
+	if report.IssuedSupply > report.ExpectedSupply {
+		fmt.Printf("WARNING: %d satoshis issued above the schedule\n", report.IssuedSupply-report.ExpectedSupply)
+	}
+
+	if report.Violations == 0 {
+		fmt.Println("\n✓ All coinbases respect the subsidy schedule")
+		return
+	}
+
+	fmt.Printf("\n✗ %d block(s) violate the rules:\n", report.Violations)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HEIGHT\tHASH\tPROBLEM")
+	for _, audit := range report.Blocks {
+		if audit.Problem != "" {
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", audit.Height, audit.Hash, audit.Problem)
+		}
+	}
+	tw.Flush()
+}
+
+func printFees(report *validation.AuditReport) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HEIGHT\tTXS\tSUBSIDY\tFEES\tCOINBASE\tSTATUS")
+	for _, audit := range report.Blocks {
+		status := "ok"
+		if audit.Problem != "" {
+			status = audit.Problem
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%s\n",
+			audit.Height, audit.TxCount, audit.Subsidy, audit.Fees, audit.CoinbaseValue, status)
+	}
+	tw.Flush()
+
+	fmt.Printf("\nTotal fees: %d satoshis\n", report.TotalFees)
+}
+
+func handleHalving(rules *consensus.ConsensusRules) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ERA\tSTART HEIGHT\tSUBSIDY (BTC)\tSUPPLY AT END (BTC)")
+
+	supply := int64(0)
+	for _, era := range validation.HalvingSchedule(rules) {
+		supply = era.SupplyAtEnd
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\n", era.Era, era.StartHeight, formatBTC(era.Subsidy), formatBTC(era.SupplyAtEnd))
+	}
+	tw.Flush()
+
+	fmt.Printf("\nFinal supply: %s BTC (max money: %s BTC)\n",
+		formatBTC(supply), formatBTC(validation.MaxMoney))
+}
+
+// formatBTC formats satoshis as a BTC amount
+func formatBTC(sats int64) string {
+	return fmt.Sprintf("%d.%08d", sats/100000000, sats%100000000)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/psbt"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// syncTimeout bounds every wait for the nodes to agree
+const syncTimeout = 10 * time.Second
+
+// multisig-demo runs three connected regtest nodes, one wallet each, and
+// spends from a 2-of-3 multisig output they share. The spend travels
+// between the wallets as a base64 PSBT: each signer adds its signature,
+// and the last one finalizes it and broadcasts the transaction.
+func main() {
+	amount := flag.Int64("amount", 10*100000000, "Satoshis Alice locks in the multisig output")
+	fee := flag.Int64("fee", 10000, "Fee of the funding and spending transactions")
+	flag.Parse()
+
+	if err := run(*amount, *fee); err != nil {
+		fmt.Printf("FAILED: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// signer is one of the three wallets sharing the multisig output
+type signer struct {
+	name string
+	node *testharness.TestNode
+	key  *keys.PrivateKey // Drawn from the node's wallet
+}
+
+// run funds the multisig output from Alice's wallet and has Alice and Bob
+// co-sign a spend paying Carol
+func run(amount, fee int64) error {
+	if fee < 0 || fee >= amount {
+		return fmt.Errorf("invalid fee %d for an amount of %d", fee, amount)
+	}
+
+	h, err := testharness.New(3)
+	if err != nil {
+		return fmt.Errorf("failed to start nodes: %w", err)
+	}
+	defer h.Close()
+	if err := h.ConnectAll(syncTimeout); err != nil {
+		return err
+	}
+
+	// 1. Each wallet contributes a fresh key to a 2-of-3 script
+	var signers []*signer
+	var pubKeys [][]byte
+	for i, name := range []string{"Alice", "Bob", "Carol"} {
+		s, err := newSigner(name, h.Node(i))
+		if err != nil {
+			return err
+		}
+		signers = append(signers, s)
+		pubKeys = append(pubKeys, s.key.PublicKey().Bytes(true))
+	}
+	alice, bob, carol := signers[0], signers[1], signers[2]
+
+	multiSig, err := contracts.MultiSigScript(2, pubKeys...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("2-of-3 multisig script: %s\n", script.Asm(multiSig))
+
+	// 2. Alice mines some coins and locks amount in the multisig output
+	if _, err := alice.node.MineBlocks(3); err != nil {
+		return err
+	}
+	funding, err := alice.node.SendToScript(multiSig, amount, fee)
+	if err != nil {
+		return fmt.Errorf("failed to fund the multisig output: %w", err)
+	}
+	if err := mine(h, alice.node); err != nil {
+		return err
+	}
+	fundingHash, err := serialization.HashTransaction(funding)
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, output := range funding.Outputs {
+		if bytes.Equal(output.PubKeyScript, multiSig) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("funding transaction %s has no multisig output", fundingHash)
+	}
+	fmt.Printf("Alice locked %d satoshis in %s:%d\n", amount, fundingHash, index)
+
+	// 3. Carol creates the PSBT spending it to a new address of her own,
+	// with the funding transaction for the signers to check against
+	payoutKey, err := newKey(carol.node)
+	if err != nil {
+		return err
+	}
+	payoutScript, err := script.P2PKH(payoutKey.PublicKey().Hash160())
+	if err != nil {
+		return err
+	}
+	payTo := types.TxOutput{Value: amount - fee, PubKeyScript: payoutScript}
+	packet, err := psbt.New(contracts.SpendTx(fundingHash, uint32(index), payTo, 0))
+	if err != nil {
+		return err
+	}
+	if err := packet.AddUTXO(0, funding, nil); err != nil {
+		return err
+	}
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Carol creates the PSBT (%d base64 characters)\n", len(encoded))
+
+	// 4. It goes to Alice and on to Bob, each signing the copy they got
+	for _, s := range []*signer{alice, bob} {
+		if encoded, err = s.sign(encoded); err != nil {
+			return err
+		}
+	}
+
+	// 5. Back with Carol, two signatures are enough to finalize
+	packet, err = psbt.B64Decode(encoded)
+	if err != nil {
+		return err
+	}
+	if err := packet.Finalize(); err != nil {
+		return fmt.Errorf("Carol cannot finalize: %w", err)
+	}
+	spend, err := packet.Extract()
+	if err != nil {
+		return err
+	}
+	if err := contracts.Verify(spend, 0, funding.Outputs[index]); err != nil {
+		return fmt.Errorf("finalized spend does not verify: %w", err)
+	}
+	spendHash, err := serialization.HashTransaction(spend)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Carol finalizes the PSBT into transaction %s\n", spendHash)
+
+	// 6. Carol broadcasts it; every node relays and mines it
+	if err := carol.node.P2P.BroadcastTransaction(spend); err != nil {
+		return fmt.Errorf("failed to broadcast the spend: %w", err)
+	}
+	if err := h.WaitForMempool(spendHash, syncTimeout); err != nil {
+		return err
+	}
+	if err := mine(h, carol.node); err != nil {
+		return err
+	}
+	if _, _, err := alice.node.Chain.GetTransactionLocation(spendHash); err != nil {
+		return fmt.Errorf("spend not confirmed on Alice's node: %w", err)
+	}
+
+	balance, err := carol.node.Balance()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Spend confirmed; Carol received %d satoshis, her wallet holds %d with her block reward\n", payTo.Value, balance)
+	return nil
+}
+
+// newSigner draws a multisig key from node's wallet
+func newSigner(name string, node *testharness.TestNode) (*signer, error) {
+	key, err := newKey(node)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &signer{name: name, node: node, key: key}, nil
+}
+
+// newKey generates an address in node's wallet and returns its key
+func newKey(node *testharness.TestNode) (*keys.PrivateKey, error) {
+	address, err := node.Wallet.GenerateAddress()
+	if err != nil {
+		return nil, err
+	}
+	key, ok := node.Wallet.GetKey(address)
+	if !ok {
+		return nil, fmt.Errorf("wallet has no key for its address %s", address)
+	}
+	return key, nil
+}
+
+// sign decodes a base64 PSBT, adds the signer's signature and re-encodes
+// it for the next wallet
+func (s *signer) sign(encoded string) (string, error) {
+	packet, err := psbt.B64Decode(encoded)
+	if err != nil {
+		return "", fmt.Errorf("%s cannot read the PSBT: %w", s.name, err)
+	}
+	if err := packet.Sign(0, s.key); err != nil {
+		return "", fmt.Errorf("%s cannot sign: %w", s.name, err)
+	}
+	fmt.Printf("%s signs (%d of 2 signatures)\n", s.name, len(packet.Inputs[0].PartialSigs))
+	return packet.B64Encode()
+}
+
+// mine mines a block on node and waits for the others to follow
+func mine(h *testharness.Harness, node *testharness.TestNode) error {
+	if _, err := node.MineBlocks(1); err != nil {
+		return err
+	}
+	return h.WaitForSync(syncTimeout)
+}
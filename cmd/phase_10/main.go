@@ -170,7 +170,7 @@ func demoReorgHandling(logger *monitoring.Logger) {
 	defer blockchain.Close()
 
 	utxoSet := utxo.NewUTXOSet()
-	mp := mempool.NewMempool(10*1024*1024, 1000, 3600) // 10MB, 1000 sat/byte min, 1 hour max age
+	mp := mempool.NewMempool(10*1024*1024, 1000000, 3600) // 10MB, 1000 sat/vB min, 1 hour max age
 
 	// Create genesis if needed
 	isEmpty, _ := blockchain.IsEmpty()
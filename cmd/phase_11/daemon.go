@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+)
+
+// version is the release shown in the startup banner. Release builds set
+// it with -ldflags "-X main.version=...".
+var version = "dev"
+
+// buildCommit returns the VCS revision the binary was built from, or
+// "unknown" when it wasn't built from a checkout
+func buildCommit() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	commit, dirty := "unknown", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			commit = setting.Value
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+		case "vcs.modified":
+			dirty = setting.Value == "true"
+		}
+	}
+	if dirty {
+		commit += "-dirty"
+	}
+	return commit
+}
+
+// banner identifies the binary at the top of every log
+func banner() string {
+	return fmt.Sprintf("Bitcoin Node %s (commit %s, %s %s/%s), pid %d",
+		version, buildCommit(), runtime.Version(), runtime.GOOS, runtime.GOARCH, os.Getpid())
+}
+
+// setupLogging sends the log to the data directory's debug.log in daemon
+// mode, along with anything printed to stdout or stderr, so the process
+// can run without a terminal. Otherwise it keeps logging to stderr. The
+// returned file, if any, is closed on shutdown.
+func setupLogging(daemon bool, path string) (io.Closer, error) {
+	if !daemon {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	log.SetOutput(file)
+
+	// Packages that print progress write to stdout directly
+	os.Stdout, os.Stderr = file, file
+	return file, nil
+}
@@ -2,23 +2,40 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/backup"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/config"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/daemon"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/electrum"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/explorer"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rest"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/security"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/watch"
 )
 
 // Node represents a full Bitcoin node
@@ -29,46 +46,108 @@ type Node struct {
 	p2pServer *network.Server
 	rpcServer *rpc.Server
 	miner     *mining.Miner
+	rules     *consensus.ConsensusRules // Nil for an unknown network
+	utxoStore *utxo.UTXOStorage
+	utxoCache *utxo.UTXOCache // In front of utxoStore, flushed on its interval and at shutdown
+	fees      *mempool.FeeHistory
+	debug     *http.Server     // Nil unless DebugAddr is set
+	electrum  *electrum.Server // Nil unless ElectrumAddr is set
+	rest      *http.Server     // Nil unless RESTAddr is set
+	watcher   *watch.Watcher
 	ctx       context.Context
 	cancel    context.CancelFunc
 	wg        sync.WaitGroup
 }
 
 func main() {
-	// Load configuration from environment
+	// Load configuration from environment, then let flags override it
 	cfg := config.LoadFromEnv()
+	flag.BoolVar(&cfg.Daemon, "daemon", cfg.Daemon, "log to <datadir>/debug.log instead of the terminal")
+	flag.StringVar(&cfg.PIDFile, "pid", cfg.PIDFile, "process ID file (default <datadir>/bitcoind.pid)")
+	flag.IntVar(&cfg.CheckBlocks, "checkblocks", cfg.CheckBlocks, "blocks to re-validate at startup (0 = all, -1 = none)")
+	flag.IntVar(&cfg.CheckLevel, "checklevel", cfg.CheckLevel, "how thoroughly -checkblocks blocks are re-validated (0-4)")
+	flag.Int64Var(&cfg.MinRelayTxFee, "minrelaytxfee", cfg.MinRelayTxFee, "lowest fee rate, in sat/kvB, accepted into the mempool and relayed")
+	flag.Int64Var(&cfg.IncrementalRelayFee, "incrementalrelayfee", cfg.IncrementalRelayFee, "fee rate, in sat/kvB, a replacement must add and a full mempool's floor rises by")
+	restore := flag.String("restore", "", "restore the empty data directory from a backupnode tarball before starting")
+	flag.Parse()
 
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
 		log.Fatalf("Invalid configuration: %v", err)
 	}
 
+	// Restore before anything, the log file included, is written to the
+	// data directory
+	if *restore != "" {
+		if err := restoreBackup(*restore, cfg.DataDir); err != nil {
+			log.Fatalf("Failed to restore backup: %v", err)
+		}
+	}
+
+	logFile, err := setupLogging(cfg.Daemon, cfg.GetLogFile())
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
 	// Print configuration
 	logInfo("=== Bitcoin Node Starting ===")
+	logInfo(banner())
 	logInfo(cfg.String())
 	logInfo("")
 
-	// Create and start node
+	// Create and start node. This fails if another node holds the data
+	// directory lock.
 	node, err := NewNode(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create node: %v", err)
 	}
 
+	pidFile := cfg.GetPIDFile()
+	if err := daemon.WritePIDFile(pidFile); err != nil {
+		node.Stop()
+		log.Fatalf("Failed to start node: %v", err)
+	}
+	defer daemon.RemovePIDFile(pidFile)
+
 	// Start the node
 	if err := node.Start(); err != nil {
+		daemon.RemovePIDFile(pidFile)
 		log.Fatalf("Failed to start node: %v", err)
 	}
 
 	// Wait for shutdown signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	select {
+	case <-sigChan:
+		logInfo("Shutdown signal received, stopping node...")
+	case <-node.ctx.Done():
+		logInfo("Stop requested over RPC, stopping node...")
+	}
 
-	logInfo("Shutdown signal received, stopping node...")
 	node.Stop()
 	logInfo("Node stopped gracefully")
 }
 
+// restoreBackup recreates dataDir from the backup at path
+func restoreBackup(path, dataDir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest, err := backup.Restore(f, dataDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored %s: %d entries up to block %d, %d wallet(s)", path, manifest.Entries, manifest.TipHeight, len(manifest.Wallets()))
+	return nil
+}
+
 // NewNode creates a new Bitcoin node
 func NewNode(cfg *config.NodeConfig) (*Node, error) {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -79,9 +158,41 @@ func NewNode(cfg *config.NodeConfig) (*Node, error) {
 		cancel()
 		return nil, fmt.Errorf("failed to initialize blockchain: %w", err)
 	}
+	if cfg.NullDataIndex {
+		chain.EnableNullDataIndex()
+	}
+	if cfg.SpentIndex {
+		chain.EnableSpentIndex()
+	}
+	if cfg.AddressIndex {
+		chain.EnableAddressIndex()
+	}
 
 	// Create wallet
 	w := wallet.NewWallet()
+	w.SetOptInRBF(cfg.WalletRBF)
+	params, err := keys.ParamsForNetwork(cfg.Network)
+	if err != nil {
+		cancel()
+		chain.Close()
+		return nil, err
+	}
+	w.SetNetParams(params)
+
+	// Restore the wallet from the previous run. Starting empty instead
+	// would overwrite its keys on the next save.
+	walletPath := filepath.Join(cfg.DataDir, wallet.WalletFileName)
+	if err := w.LoadFromFile(walletPath); err != nil {
+		cancel()
+		chain.Close()
+		return nil, fmt.Errorf("failed to load wallet: %w", err)
+	}
+	w.SetFile(walletPath)
+	if err := w.Flush(); err != nil {
+		cancel()
+		chain.Close()
+		return nil, fmt.Errorf("failed to save wallet: %w", err)
+	}
 
 	// Initialize genesis block if needed
 	isEmpty, _ := chain.IsEmpty()
@@ -108,14 +219,146 @@ func NewNode(cfg *config.NodeConfig) (*Node, error) {
 
 	// Create P2P server
 	p2pServer := network.NewServer(cfg.GetP2PAddress(), chain)
+	rules, err := consensus.NewRulesForNetwork(cfg.Network)
+	if err == nil {
+		if cfg.SignetChallenge != "" {
+			// Validate already checked the format
+			challenge, _ := hex.DecodeString(cfg.SignetChallenge)
+			rules = consensus.NewSignetRules(challenge)
+		}
+		p2pServer.Node().SyncManager.SetMinimumChainWork(rules.MinimumChainWork)
+		if cfg.AssumeValid != "" {
+			rules.AssumeValid = types.Hash{}
+			if cfg.AssumeValid != "0" {
+				// Validate already checked the format
+				rules.AssumeValid, _ = types.NewHashFromString(cfg.AssumeValid)
+			}
+		}
+		p2pServer.Node().SyncManager.SetRules(rules)
+	}
+
+	// The UTXO set lives in its own database, kept in memory and written
+	// back every UTXOFlushInterval and at shutdown
+	utxoStore, err := utxo.NewUTXOStorage(filepath.Join(cfg.DataDir, utxo.ChainstateDirName))
+	if err != nil {
+		cancel()
+		chain.Close()
+		return nil, fmt.Errorf("failed to open chainstate: %w", err)
+	}
+	cacheConfig := utxo.DefaultCacheConfig()
+	cacheConfig.FlushInterval = cfg.UTXOFlushInterval
+	utxoCache := utxo.NewUTXOCacheWithConfig(utxoStore, cacheConfig)
+	if err := p2pServer.Node().SetChainstate(utxoCache); err != nil {
+		cancel()
+		utxoStore.Close()
+		chain.Close()
+		return nil, err
+	}
+
+	if len(cfg.DNSSeeds) > 0 {
+		// Peers on the same network listen on the same port as us
+		p2pServer.Node().DNSSeeder = network.NewDNSSeeder(cfg.DNSSeeds, uint16(cfg.P2PPort))
+	}
+	if listen := cfg.GetP2PListenAddrs(); len(listen) > 1 {
+		p2pServer.Node().Config.ListenAddrs = listen[1:]
+	}
+	p2pServer.Node().Config.NoListen = cfg.NoListen
+	p2pServer.Node().Config.ExternalAddr = cfg.GetExternalAddr()
+	p2pServer.Node().Config.EnableNAT = cfg.EnableNAT
+	p2pServer.Node().Config.EnableV2Transport = cfg.V2Transport
+	p2pServer.Node().Config.EnableDandelion = cfg.Dandelion
+	if err := p2pServer.Node().LoadBanList(filepath.Join(cfg.DataDir, security.BanListFileName)); err != nil {
+		logWarn(fmt.Sprintf("Failed to load ban list: %v", err))
+	}
+
+	// Restore fee estimation history from the previous run
+	fees := mempool.NewFeeHistory()
+	if err := fees.LoadFromFile(filepath.Join(cfg.DataDir, mempool.FeeEstimatesFileName)); err != nil {
+		logWarn(fmt.Sprintf("Failed to load fee estimates, starting fresh: %v", err))
+		fees = mempool.NewFeeHistory()
+	}
+	p2pServer.Mempool().SetFeeHistory(fees)
+	p2pServer.Mempool().SetRelayFees(cfg.MinRelayTxFee, cfg.IncrementalRelayFee)
+
+	// Release the inputs of wallet transactions the mempool drops
+	p2pServer.Mempool().SetRemovalHandler(func(txHash types.Hash, _ mempool.RemovalReason) {
+		w.TransactionDropped(txHash)
+	})
+
+	// Credit the wallet as blocks connect, and take back what reorged-out
+	// blocks paid it
+	p2pServer.Node().Subscribe(w)
 
 	// Create RPC server
 	rpcServer := rpc.NewServer(w, chain, cfg.GetRPCAddress())
+	rpcServer.SetNode(p2pServer.Node())
+	rpcServer.SetUTXOCache(utxoCache)
+	rpcServer.SetShutdown(cancel)
+	if err := rpcServer.SetWalletDir(filepath.Join(cfg.DataDir, "wallets")); err != nil {
+		logWarn(fmt.Sprintf("Failed to load named wallets: %v", err))
+	}
+	if rules != nil {
+		rpcServer.SetConsensusRules(rules)
+	}
+	rpcServer.SetRateLimits(rpc.RateLimitConfig{
+		ReadOnly: rpc.RateLimit{Rate: cfg.RPCRateLimit, Burst: 2 * cfg.RPCRateLimit},
+		Wallet:   rpc.RateLimit{Rate: cfg.RPCWalletRateLimit, Burst: 2 * cfg.RPCWalletRateLimit},
+	})
+
+	// Serve block templates for external miners, built on the best block
+	// at the network's next target and timestamped with the
+	// network-adjusted time
+	builder := mining.NewBlockBuilder(p2pServer.Mempool())
+	builder.SetClock(p2pServer.Node().AdjustedTime())
+	if order, err := mempool.ParseSelectionOrder(cfg.BlockOrder); err == nil && order == mempool.OrderPriority {
+		p2pServer.Node().EnableCoinAgePriority()
+		builder.SetOrder(order)
+	}
+	templates := mining.NewTemplateCache(builder, func() (types.Hash, uint64, uint32, error) {
+		tip, height, err := chain.GetBestBlock()
+		if err != nil {
+			return types.Hash{}, 0, 0, err
+		}
+		hash, err := chain.GetBlockHash(tip)
+		if err != nil || rules == nil {
+			return hash, height, tip.Header.Bits, err
+		}
+		bits, err := rules.NextWorkRequired(chain, height+1)
+		return hash, height, bits, err
+	}, mining.DefaultTemplateCacheConfig(cfg.MinerAddress))
+	rpcServer.SetBlockTemplateCache(templates)
+
+	// Mount the block explorer next to the RPC endpoints. It shares the
+	// UTXO set the node validates blocks against, brought up to date with
+	// the chain first.
+	if _, err := p2pServer.Node().SyncManager.Chainstate(); err != nil {
+		logWarn(fmt.Sprintf("Failed to load UTXO set: %v", err))
+	}
+	explorer.NewExplorer(chain, p2pServer.Mempool(), utxoCache).Register(http.DefaultServeMux)
+
+	// Address watches are added over RPC and their events logged
+	watcher := watch.NewWatcher(chain, p2pServer.Mempool())
+	watcher.Subscribe(func(event watch.Event) {
+		logInfo(fmt.Sprintf("Watch: %s %d sat in %s (confirmed: %v)", event.Type, event.Value, event.TxHash, event.Confirmed))
+	})
+	rpcServer.SetWatcher(watcher)
+
+	var electrumServer *electrum.Server
+	if cfg.ElectrumAddr != "" {
+		electrumServer = electrum.NewServer(chain, p2pServer.Mempool())
+	}
+
+	// REST has no authentication, so it never shares the RPC port
+	var restServer *http.Server
+	if cfg.RESTAddr != "" {
+		restServer = &http.Server{Addr: cfg.RESTAddr, Handler: rest.NewServer(chain, p2pServer.Mempool()).Handler()}
+	}
 
 	// Create miner if mining is enabled
 	var miner *mining.Miner
 	if cfg.MiningEnabled {
 		miner = mining.NewMiner()
+		rpcServer.SetMiner(miner)
 	}
 
 	return &Node{
@@ -125,6 +368,13 @@ func NewNode(cfg *config.NodeConfig) (*Node, error) {
 		p2pServer: p2pServer,
 		rpcServer: rpcServer,
 		miner:     miner,
+		rules:     rules,
+		utxoStore: utxoStore,
+		utxoCache: utxoCache,
+		fees:      fees,
+		electrum:  electrumServer,
+		watcher:   watcher,
+		rest:      restServer,
 		ctx:       ctx,
 		cancel:    cancel,
 	}, nil
@@ -139,7 +389,7 @@ func (n *Node) Start() error {
 	go func() {
 		defer n.wg.Done()
 		logInfo(fmt.Sprintf("Starting P2P server on %s", n.config.GetP2PAddress()))
-		if err := n.p2pServer.Start(); err != nil {
+		if err := n.p2pServer.StartContext(n.ctx); err != nil {
 			logError(fmt.Sprintf("P2P server error: %v", err))
 		}
 	}()
@@ -153,7 +403,12 @@ func (n *Node) Start() error {
 		n.wg.Add(1)
 		go func(addr string) {
 			defer n.wg.Done()
-			time.Sleep(2 * time.Second) // Wait for other nodes to start
+			// Wait for other nodes to start
+			select {
+			case <-n.ctx.Done():
+				return
+			case <-time.After(2 * time.Second):
+			}
 			if err := n.p2pServer.ConnectToPeer(addr); err != nil {
 				logWarn(fmt.Sprintf("Failed to connect to peer %s: %v", addr, err))
 			} else {
@@ -167,11 +422,50 @@ func (n *Node) Start() error {
 	go func() {
 		defer n.wg.Done()
 		logInfo(fmt.Sprintf("Starting RPC server on %s", n.config.GetRPCAddress()))
-		if err := n.rpcServer.Start(); err != nil {
+		if err := n.rpcServer.StartContext(n.ctx); err != nil {
 			logError(fmt.Sprintf("RPC server error: %v", err))
 		}
 	}()
 
+	// Start the debug listener if enabled. It serves profiles, so it
+	// should only be bound to localhost or a private interface.
+	if n.config.DebugAddr != "" {
+		n.debug = &http.Server{Addr: n.config.DebugAddr, Handler: monitoring.DebugHandler()}
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			logInfo(fmt.Sprintf("Starting debug listener on %s", n.config.DebugAddr))
+			if err := n.debug.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logError(fmt.Sprintf("Debug listener error: %v", err))
+			}
+		}()
+	}
+
+	if n.rest != nil {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			logInfo(fmt.Sprintf("Starting REST interface on %s", n.config.RESTAddr))
+			if err := n.rest.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logError(fmt.Sprintf("REST interface error: %v", err))
+			}
+		}()
+	}
+
+	if n.electrum != nil {
+		logInfo(fmt.Sprintf("Starting Electrum server on %s", n.config.ElectrumAddr))
+		if err := n.electrum.Start(n.config.ElectrumAddr); err != nil {
+			logError(fmt.Sprintf("Electrum server error: %v", err))
+		}
+	}
+
+	// Write the UTXO set back to disk every UTXOFlushInterval
+	n.utxoCache.Start()
+
+	if err := n.watcher.Start(); err != nil {
+		logError(fmt.Sprintf("Address watcher error: %v", err))
+	}
+
 	// Start auto-mining if enabled
 	if n.config.MiningEnabled && n.config.AutoMine {
 		n.wg.Add(1)
@@ -181,6 +475,25 @@ func (n *Node) Start() error {
 		}()
 	}
 
+	// Start automatic wallet backups if enabled
+	if n.config.WalletBackupInterval > 0 {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.walletBackupLoop()
+		}()
+	}
+
+	// Re-validate the newest blocks in the background, so disk corruption
+	// shows up before the node builds on it
+	if n.config.CheckBlocks >= 0 {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.verifyChain()
+		}()
+	}
+
 	// Start status reporter
 	n.wg.Add(1)
 	go func() {
@@ -190,7 +503,11 @@ func (n *Node) Start() error {
 
 	logInfo("Node started successfully!")
 	logInfo(fmt.Sprintf("RPC endpoint: http://localhost%s", n.config.GetRPCAddress()))
-	logInfo(fmt.Sprintf("P2P listening on: %s", n.config.GetP2PAddress()))
+	if n.config.NoListen {
+		logInfo("P2P listening disabled, outbound connections only")
+	} else {
+		logInfo(fmt.Sprintf("P2P listening on: %s", strings.Join(n.config.GetP2PListenAddrs(), ", ")))
+	}
 	if n.config.MiningEnabled {
 		logInfo(fmt.Sprintf("Mining enabled: %v (Auto: %v, Interval: %v)",
 			n.config.MiningEnabled, n.config.AutoMine, n.config.MineInterval))
@@ -209,13 +526,47 @@ func (n *Node) Stop() {
 		n.p2pServer.Stop()
 	}
 
+	if n.debug != nil {
+		n.debug.Close()
+	}
+
+	if n.rest != nil {
+		n.rest.Close()
+	}
+
+	if n.electrum != nil {
+		n.electrum.Stop()
+	}
+
+	n.watcher.Stop()
+
+	// Wait for all goroutines to finish before closing what they use
+	n.wg.Wait()
+
+	// Persist fee estimates so they survive the restart
+	if n.fees != nil {
+		path := filepath.Join(n.config.DataDir, mempool.FeeEstimatesFileName)
+		if err := n.fees.SaveToFile(path); err != nil {
+			logError(fmt.Sprintf("Failed to save fee estimates: %v", err))
+		}
+	}
+
+	// Write wallet changes not yet on disk
+	if err := n.wallet.Flush(); err != nil {
+		logError(fmt.Sprintf("Failed to save wallet: %v", err))
+	}
+
+	// Stop flushing the UTXO set in the background and flush what is left,
+	// so the next start needs no replay
+	if err := n.utxoCache.Close(); err != nil {
+		logError(fmt.Sprintf("Failed to flush UTXO set: %v", err))
+	}
+	n.utxoStore.Close()
+
 	// Close blockchain storage
 	if n.chain != nil {
 		n.chain.Close()
 	}
-
-	// Wait for all goroutines to finish
-	n.wg.Wait()
 }
 
 // autoMineLoop automatically mines blocks at regular intervals
@@ -261,47 +612,93 @@ func (n *Node) mineBlock() error {
 	if err != nil {
 		return fmt.Errorf("failed to create coinbase: %w", err)
 	}
+	if n.rules != nil {
+		// CreateCoinbase pays mainnet's subsidy; test networks halve sooner
+		coinbase.Outputs[0].Value = int64(n.rules.GetBlockSubsidy(newHeight))
+	}
+
+	bits := uint32(consensus.DifficultyOneBits)
+	if n.rules != nil {
+		if bits, err = n.rules.NextWorkRequired(n.chain, newHeight); err != nil {
+			return fmt.Errorf("failed to get target: %w", err)
+		}
+	}
 
 	// Create block template
 	template := &mining.BlockTemplate{
 		Version:       1,
 		PrevBlockHash: prevHash,
 		Transactions:  []types.Transaction{*coinbase},
-		Timestamp:     uint32(time.Now().Unix()),
-		Bits:          0x1d00ffff,
+		Timestamp:     uint32(n.p2pServer.Node().AdjustedTime().Now().Unix()),
+		Bits:          bits,
 		Height:        newHeight,
 		TotalFees:     0,
 	}
 
-	// Mine block
+	// Regtest blocks meet their real target instantly; elsewhere the
+	// demo miner settles for 1 leading zero byte
 	startTime := time.Now()
-	block, err := n.miner.MineBlock(template, 1) // 1 leading zero byte
+	var block *types.Block
+	if n.rules != nil && n.rules.PowNoRetargeting {
+		block, err = mining.SolveBlock(template)
+	} else {
+		block, err = n.miner.MineBlock(template, 1)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to mine block: %w", err)
 	}
 	miningTime := time.Since(startTime)
 
-	// Save block
-	if err := n.chain.SaveBlock(block, newHeight); err != nil {
-		return fmt.Errorf("failed to save block: %w", err)
+	// Connect it like a peer's block, so it is validated, the UTXO set
+	// and wallet follow it and peers hear about it
+	if err := n.p2pServer.Node().ProcessNewBlock(block); err != nil {
+		return fmt.Errorf("failed to connect block: %w", err)
 	}
 
-	// Add coinbase UTXO to wallet
-	coinbaseTx := block.Transactions[0]
-	txHash, _ := serialization.HashTransaction(&coinbaseTx)
-	coinbaseUTXO := utxo.NewUTXO(txHash, 0, coinbaseTx.Outputs[0], newHeight, true)
-	n.wallet.AddUTXO(coinbaseUTXO)
-
 	blockHash, _ := n.chain.GetBlockHash(block)
 	logInfo(fmt.Sprintf("[%s] Mined block %d: %s (time: %v, nonce: %d)",
 		n.config.NodeID, newHeight, blockHash, miningTime, block.Header.Nonce))
 
-	// Broadcast block to peers
-	if n.p2pServer != nil {
-		n.p2pServer.BroadcastBlock(block)
+	return nil
+}
+
+// walletBackupLoop backs the wallet up at regular intervals, keeping the
+// newest WalletBackupKeep copies
+func (n *Node) walletBackupLoop() {
+	dir := filepath.Join(n.config.DataDir, wallet.BackupDirName)
+	ticker := time.NewTicker(n.config.WalletBackupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			path, err := n.wallet.RotateBackups(dir, n.config.WalletBackupKeep)
+			if err != nil {
+				logError(fmt.Sprintf("Wallet backup failed: %v", err))
+				continue
+			}
+			logInfo(fmt.Sprintf("Wallet backed up to %s", path))
+		}
 	}
+}
 
-	return nil
+// verifyChain re-validates the last CheckBlocks blocks at CheckLevel
+func (n *Node) verifyChain() {
+	logInfo(fmt.Sprintf("Verifying the last %d blocks at level %d...", n.config.CheckBlocks, n.config.CheckLevel))
+	result, err := validation.VerifyChain(n.ctx, n.chain, n.rules, n.config.CheckLevel, uint64(n.config.CheckBlocks))
+	if err != nil {
+		if n.ctx.Err() == nil {
+			logError(fmt.Sprintf("Chain verification failed to run: %v", err))
+		}
+		return
+	}
+	if !result.Valid() {
+		logError(fmt.Sprintf("Chain verification found a bad block at height %d: %v", result.Height, result.Err))
+		return
+	}
+	logInfo(fmt.Sprintf("Verified blocks %d to %d", result.From, result.To))
 }
 
 // statusReporter periodically reports node status
@@ -462,11 +462,11 @@ func demoFeeCalculation() {
 	// Estimate fee for different fee rates
 	fmt.Printf("\nFee estimates for different priorities:\n")
 	fmt.Printf("  Low priority (1 sat/byte): %.8f BTC\n",
-		float64(transaction.EstimateFee(2, 2, 1))/100000000)
+		float64(transaction.EstimateFee(2, 2, 1000))/100000000)
 	fmt.Printf("  Medium priority (10 sat/byte): %.8f BTC\n",
-		float64(transaction.EstimateFee(2, 2, 10))/100000000)
+		float64(transaction.EstimateFee(2, 2, 10000))/100000000)
 	fmt.Printf("  High priority (50 sat/byte): %.8f BTC\n",
-		float64(transaction.EstimateFee(2, 2, 50))/100000000)
+		float64(transaction.EstimateFee(2, 2, 50000))/100000000)
 
 	fmt.Println()
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
 
@@ -46,12 +47,12 @@ func main() {
 func demoBasicMempool() {
 	fmt.Println("--- Demo 1: Basic Mempool Operations ---")
 
-	// Create mempool with 10 MB limit, 1 sat/byte min fee, 24 hour expiration
-	mp := mempool.NewMempool(10*1024*1024, 1, 24*3600)
+	// Create mempool with 10 MB limit, 1 sat/vB min fee, 24 hour expiration
+	mp := mempool.NewMempool(10*1024*1024, 1000, 24*3600)
 
 	fmt.Printf("Mempool created:\n")
 	fmt.Printf("  Max size: 10 MB\n")
-	fmt.Printf("  Min fee rate: 1 sat/byte\n")
+	fmt.Printf("  Min fee rate: 1 sat/vB\n")
 	fmt.Printf("  Max age: 24 hours\n\n")
 
 	// Create some transactions
@@ -80,7 +81,7 @@ func demoBasicMempool() {
 		fmt.Printf("  Hash: %s...\n", entry.TxHash.String()[:16])
 		fmt.Printf("  Size: %d bytes\n", entry.Size)
 		fmt.Printf("  Fee: %d sats\n", entry.Fee)
-		fmt.Printf("  Fee rate: %d sat/byte\n", entry.FeeRate)
+		fmt.Printf("  Fee rate: %s\n", transaction.FormatFeeRate(entry.FeeRate))
 	}
 
 	fmt.Println()
@@ -89,7 +90,7 @@ func demoBasicMempool() {
 func demoFeeCalculation() {
 	fmt.Println("--- Demo 2: Fee Calculation and Estimation ---")
 
-	mp := mempool.NewMempool(10*1024*1024, 1, 24*3600)
+	mp := mempool.NewMempool(10*1024*1024, 1000, 24*3600)
 	estimator := mempool.NewFeeEstimator(mp)
 
 	// Add transactions with varying fee rates
@@ -103,7 +104,7 @@ func demoFeeCalculation() {
 
 		err := mp.Add(tx, fee, 100)
 		if err == nil {
-			fmt.Printf("  ✓ Tx %d: %d sat/byte (fee: %d sats)\n", i, feeRate, fee)
+			fmt.Printf("  ✓ Tx %d: %d sat/vB (fee: %d sats)\n", i, feeRate, fee)
 		}
 	}
 
@@ -111,21 +112,21 @@ func demoFeeCalculation() {
 	stats := estimator.GetFeeStatistics()
 	fmt.Printf("\nFee Statistics:\n")
 	fmt.Printf("  Total transactions: %d\n", stats.TxCount)
-	fmt.Printf("  Min fee rate: %d sat/byte\n", stats.MinFeeRate)
-	fmt.Printf("  Max fee rate: %d sat/byte\n", stats.MaxFeeRate)
-	fmt.Printf("  Median fee rate: %d sat/byte\n", stats.MedianFeeRate)
-	fmt.Printf("  Average fee rate: %d sat/byte\n", stats.AverageFeeRate)
-	fmt.Printf("  25th percentile: %d sat/byte\n", stats.P25FeeRate)
-	fmt.Printf("  75th percentile: %d sat/byte\n", stats.P75FeeRate)
-	fmt.Printf("  90th percentile: %d sat/byte\n", stats.P90FeeRate)
+	fmt.Printf("  Min fee rate: %s\n", transaction.FormatFeeRate(stats.MinFeeRate))
+	fmt.Printf("  Max fee rate: %s\n", transaction.FormatFeeRate(stats.MaxFeeRate))
+	fmt.Printf("  Median fee rate: %s\n", transaction.FormatFeeRate(stats.MedianFeeRate))
+	fmt.Printf("  Average fee rate: %s\n", transaction.FormatFeeRate(stats.AverageFeeRate))
+	fmt.Printf("  25th percentile: %s\n", transaction.FormatFeeRate(stats.P25FeeRate))
+	fmt.Printf("  75th percentile: %s\n", transaction.FormatFeeRate(stats.P75FeeRate))
+	fmt.Printf("  90th percentile: %s\n", transaction.FormatFeeRate(stats.P90FeeRate))
 
 	// Estimate fees for different confirmation targets
 	fmt.Printf("\nFee Estimation (for 250-byte transaction):\n")
 	targets := []int{1, 3, 6, 12}
 	for _, target := range targets {
 		estimatedFee := estimator.EstimateFee(target, 250)
-		estimatedRate := estimatedFee / 250
-		fmt.Printf("  %d blocks: %d sats (%d sat/byte)\n", target, estimatedFee, estimatedRate)
+		estimatedRate := mempool.CalculateFeeRate(estimatedFee, 250)
+		fmt.Printf("  %d blocks: %d sats (%s)\n", target, estimatedFee, transaction.FormatFeeRate(estimatedRate))
 	}
 
 	fmt.Println()
@@ -134,7 +135,7 @@ func demoFeeCalculation() {
 func demoTransactionDependencies() {
 	fmt.Println("--- Demo 3: Transaction Dependencies ---")
 
-	mp := mempool.NewMempool(10*1024*1024, 1, 24*3600)
+	mp := mempool.NewMempool(10*1024*1024, 1000, 24*3600)
 
 	// Create parent transaction
 	parentTx := createSampleTransaction(0, 1)
@@ -192,8 +193,8 @@ func demoTransactionDependencies() {
 	fmt.Printf("  Parent has %d child(ren)\n", len(parentEntry.Children))
 
 	// Calculate package fee rate
-	packageFeeRate := childEntry.AncestorFee / childEntry.AncestorSize
-	fmt.Printf("  Package fee rate: %d sat/byte\n", packageFeeRate)
+	packageFeeRate := mempool.CalculateFeeRate(childEntry.AncestorFee, childEntry.AncestorSize)
+	fmt.Printf("  Package fee rate: %s\n", transaction.FormatFeeRate(packageFeeRate))
 
 	fmt.Println()
 }
@@ -201,7 +202,7 @@ func demoTransactionDependencies() {
 func demoReplaceByFee() {
 	fmt.Println("--- Demo 4: Replace-By-Fee (RBF) ---")
 
-	mp := mempool.NewMempool(10*1024*1024, 1, 24*3600)
+	mp := mempool.NewMempool(10*1024*1024, 1000, 24*3600)
 
 	// Create original transaction with RBF signal (sequence < 0xfffffffe)
 	originalTx := &types.Transaction{
@@ -231,11 +232,11 @@ func demoReplaceByFee() {
 
 	originalHash, _ := serialization.HashTransaction(originalTx)
 	originalSize := mempool.CalculateTransactionSize(originalTx)
-	originalFeeRate := originalFee / originalSize
+	originalFeeRate := mempool.CalculateFeeRate(originalFee, originalSize)
 
 	fmt.Printf("Original transaction:\n")
 	fmt.Printf("  Hash: %s...\n", originalHash.String()[:16])
-	fmt.Printf("  Fee: %d sats (%d sat/byte)\n", originalFee, originalFeeRate)
+	fmt.Printf("  Fee: %d sats (%s)\n", originalFee, transaction.FormatFeeRate(originalFeeRate))
 	fmt.Printf("  Sequence: 0x%x (RBF enabled)\n", originalTx.Inputs[0].Sequence)
 
 	// Create replacement transaction with higher fee
@@ -263,12 +264,12 @@ func demoReplaceByFee() {
 
 	replacementHash, _ := serialization.HashTransaction(replacementTx)
 	replacementSize := mempool.CalculateTransactionSize(replacementTx)
-	replacementFeeRate := replacementFee / replacementSize
+	replacementFeeRate := mempool.CalculateFeeRate(replacementFee, replacementSize)
 
 	if err == nil {
 		fmt.Printf("\n✓ Replacement successful:\n")
 		fmt.Printf("  New hash: %s...\n", replacementHash.String()[:16])
-		fmt.Printf("  New fee: %d sats (%d sat/byte)\n", replacementFee, replacementFeeRate)
+		fmt.Printf("  New fee: %d sats (%s)\n", replacementFee, transaction.FormatFeeRate(replacementFeeRate))
 		fmt.Printf("  Fee increase: %d sats\n", replacementFee-originalFee)
 
 		// Verify original is removed
@@ -285,9 +286,9 @@ func demoReplaceByFee() {
 func demoMempoolPolicies() {
 	fmt.Println("--- Demo 5: Mempool Policies ---")
 
-	mp := mempool.NewMempool(10*1024*1024, 10, 24*3600) // 10 sat/byte minimum
+	mp := mempool.NewMempool(10*1024*1024, 10000, 24*3600) // 10 sat/vB minimum
 	policy := mempool.DefaultPolicy()
-	policy.MinFeeRate = 10
+	policy.MinRelayTxFee = 10000
 	validator := mempool.NewPolicyValidator(policy, mp)
 
 	fmt.Println("Policy configuration:")
@@ -339,12 +340,12 @@ func demoMempoolPolicies() {
 	fmt.Println("\nTest 3: Valid transaction")
 	validTx := createSampleTransaction(1, 1)
 	validSize := mempool.CalculateTransactionSize(validTx)
-	validFee := policy.MinFeeRate * validSize
+	validFee := transaction.FeeAtRate(policy.MinRelayTxFee, validSize)
 	err = validator.ValidateTransaction(validTx, validFee)
 	if err != nil {
 		fmt.Printf("  ✗ Rejected: %v\n", err)
 	} else {
-		fmt.Printf("  ✓ Accepted (fee: %d sats, %d sat/byte)\n", validFee, policy.MinFeeRate)
+		fmt.Printf("  ✓ Accepted (fee: %d sats, %s)\n", validFee, transaction.FormatFeeRate(policy.MinRelayTxFee))
 	}
 
 	fmt.Println()
@@ -353,7 +354,7 @@ func demoMempoolPolicies() {
 func demoTransactionSelection() {
 	fmt.Println("--- Demo 6: Transaction Selection for Blocks ---")
 
-	mp := mempool.NewMempool(10*1024*1024, 1, 24*3600)
+	mp := mempool.NewMempool(10*1024*1024, 1000, 24*3600)
 
 	// Add transactions with different fee rates
 	fmt.Println("Adding transactions to mempool:")
@@ -365,7 +366,7 @@ func demoTransactionSelection() {
 		fee := feeRate * size
 
 		mp.Add(tx, fee, 100)
-		fmt.Printf("  Tx %d: %d sat/byte\n", i, feeRate)
+		fmt.Printf("  Tx %d: %d sat/vB\n", i, feeRate)
 	}
 
 	// Create priority queue
@@ -375,8 +376,8 @@ func demoTransactionSelection() {
 	fmt.Printf("\nTop 5 transactions by fee rate:\n")
 	topTxs := pq.GetTopTransactions(5)
 	for i, entry := range topTxs {
-		fmt.Printf("  %d. Fee rate: %d sat/byte, Fee: %d sats\n",
-			i+1, entry.FeeRate, entry.Fee)
+		fmt.Printf("  %d. Fee rate: %s, Fee: %d sats\n",
+			i+1, transaction.FormatFeeRate(entry.FeeRate), entry.Fee)
 	}
 
 	// Select transactions for a block (1 MB limit)
@@ -399,8 +400,8 @@ func demoTransactionSelection() {
 		for _, entry := range mp.GetAllTransactions() {
 			if entry.Tx == tx {
 				totalFees += entry.Fee
-				fmt.Printf("  %d. Size: %d bytes, Fee: %d sats (%d sat/byte)\n",
-					i+1, size, entry.Fee, entry.FeeRate)
+				fmt.Printf("  %d. Size: %d bytes, Fee: %d sats (%s)\n",
+					i+1, size, entry.Fee, transaction.FormatFeeRate(entry.FeeRate))
 				break
 			}
 		}
@@ -420,7 +421,7 @@ func demoHighLoad() {
 	fmt.Println("--- Demo 7: High Load Scenario ---")
 
 	// Create mempool with 1 MB limit
-	mp := mempool.NewMempool(1024*1024, 1, 24*3600)
+	mp := mempool.NewMempool(1024*1024, 1000, 24*3600)
 
 	fmt.Printf("Mempool limit: 1 MB\n")
 	fmt.Println("Adding 100 transactions...")
@@ -432,7 +433,7 @@ func demoHighLoad() {
 		tx := createSampleTransaction(i, 2) // Larger transactions
 		size := mempool.CalculateTransactionSize(tx)
 
-		// Random fee rate between 1-100 sat/byte
+		// Random fee rate between 1-100 sat/vB
 		feeRate := int64((i % 100) + 1)
 		fee := feeRate * size
 
@@ -463,9 +464,9 @@ func demoHighLoad() {
 	stats := estimator.GetFeeStatistics()
 
 	fmt.Printf("\nFee statistics after high load:\n")
-	fmt.Printf("  Min fee rate: %d sat/byte\n", stats.MinFeeRate)
-	fmt.Printf("  Median fee rate: %d sat/byte\n", stats.MedianFeeRate)
-	fmt.Printf("  Max fee rate: %d sat/byte\n", stats.MaxFeeRate)
+	fmt.Printf("  Min fee rate: %s\n", transaction.FormatFeeRate(stats.MinFeeRate))
+	fmt.Printf("  Median fee rate: %s\n", transaction.FormatFeeRate(stats.MedianFeeRate))
+	fmt.Printf("  Max fee rate: %s\n", transaction.FormatFeeRate(stats.MaxFeeRate))
 
 	fmt.Println()
 }
@@ -474,7 +475,7 @@ func demoTransactionExpiration() {
 	fmt.Println("--- Demo 8: Transaction Expiration ---")
 
 	// Create mempool with 5 second expiration for demo
-	mp := mempool.NewMempool(10*1024*1024, 1, 5)
+	mp := mempool.NewMempool(10*1024*1024, 1000, 5)
 
 	fmt.Println("Adding transactions...")
 
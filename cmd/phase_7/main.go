@@ -81,8 +81,8 @@ func demo2_BlockConstruction() {
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
 	// Create mempool
-	// Create mempool (maxSize: 1MB, minFeeRate: 1 sat/byte, maxTxAge: 24 hours)
-	mp := mempool.NewMempool(1000000, 1, 86400)
+	// Create mempool (maxSize: 1MB, minFeeRate: 1 sat/vB, maxTxAge: 24 hours)
+	mp := mempool.NewMempool(1000000, 1000, 86400)
 	fmt.Printf("\n📝 Created mempool (max size: 1000 txs)\n")
 
 	// Create block builder
@@ -137,7 +137,7 @@ func demo3_Mining() {
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
 	// Create a simple block template
-	mp := mempool.NewMempool(1000000, 1, 86400)
+	mp := mempool.NewMempool(1000000, 1000, 86400)
 	builder := mining.NewBlockBuilder(mp)
 
 	prevBlockHash := types.Hash{}
@@ -194,7 +194,7 @@ func demo4_DifficultyComparison() {
 	fmt.Printf("\n📈 Mining blocks at different difficulties...\n")
 
 	// Create template
-	mp := mempool.NewMempool(1000000, 1, 86400)
+	mp := mempool.NewMempool(1000000, 1000, 86400)
 	builder := mining.NewBlockBuilder(mp)
 	prevBlockHash := types.Hash{}
 	minerAddress := "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/reorg"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+)
+
+// reorgsim repeatedly builds competing forks against a chain, reorganizes
+// onto them and checks that the chain, UTXO set, mempool and wallet stay
+// consistent. It needs exclusive access to the data directory, so point
+// it at a copy of a stopped node's chain or leave -datadir empty to start
+// from a fresh regtest chain.
+func main() {
+	dataDir := flag.String("datadir", "", "Chain data directory (default: a temporary directory)")
+	rounds := flag.Int("rounds", 20, "Number of reorgs to perform")
+	maxDepth := flag.Int("max-depth", 6, "Deepest fork to build")
+	txs := flag.Int("txs", 4, "Transactions per block")
+	limit := flag.Uint64("max-reorg-depth", reorg.DefaultMaxReorgDepth, "Deepest reorg the node accepts (0 = unlimited); deeper forks must be refused")
+	seed := flag.Int64("seed", 0, "Random seed (default: current time)")
+	flag.Parse()
+
+	if *rounds < 1 || *maxDepth < 1 || *txs < 0 {
+		fmt.Println("Error: -rounds and -max-depth must be positive, -txs not negative")
+		os.Exit(1)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	if *dataDir == "" {
+		dir, err := os.MkdirTemp("", "reorgsim-")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer os.RemoveAll(dir)
+		*dataDir = dir
+	}
+
+	chain, err := storage.NewBlockchainStorage(*dataDir)
+	if err != nil {
+		fmt.Printf("Error: failed to open blockchain: %v\n", err)
+		os.Exit(1)
+	}
+	defer chain.Close()
+
+	fmt.Printf("Reorg simulation: %d rounds, depth up to %d, seed %d\n", *rounds, *maxDepth, *seed)
+	if err := run(chain, *rounds, *maxDepth, *txs, *limit, *seed); err != nil {
+		fmt.Printf("FAILED: %v\n", err)
+		chain.Close()
+		os.Exit(2)
+	}
+	fmt.Println("All reorgs left the chain, UTXO set, mempool and wallet consistent")
+}
+
+// run performs the rounds, stopping at the first inconsistency
+func run(chain *storage.BlockchainStorage, rounds, maxDepth, txs int, limit uint64, seed int64) error {
+	sim, err := reorg.NewSimulator(chain, seed)
+	if err != nil {
+		return err
+	}
+	sim.Handler.SetMaxReorgDepth(limit)
+	rng := rand.New(rand.NewSource(seed))
+
+	// Enough history for the deepest fork
+	if height, _ := chain.GetBestBlockHeight(); height < uint64(maxDepth) {
+		if _, err := sim.Mine(maxDepth - int(height)); err != nil {
+			return fmt.Errorf("failed to mine initial blocks: %w", err)
+		}
+	}
+	if err := sim.Verify(); err != nil {
+		return fmt.Errorf("chain inconsistent before the first reorg: %w", err)
+	}
+
+	for round := 1; round <= rounds; round++ {
+		if _, err := sim.Spend(txs); err != nil {
+			return fmt.Errorf("round %d: %w", round, err)
+		}
+		if _, err := sim.Mine(1 + rng.Intn(3)); err != nil {
+			return fmt.Errorf("round %d: %w", round, err)
+		}
+		if _, err := sim.Spend(txs); err != nil {
+			return fmt.Errorf("round %d: %w", round, err)
+		}
+
+		depth := 1 + rng.Intn(maxDepth)
+		extra := 1 + rng.Intn(2)
+		branch, err := sim.BuildFork(depth, extra, txs)
+		if err != nil {
+			return fmt.Errorf("round %d: %w", round, err)
+		}
+
+		if limit > 0 && uint64(depth) > limit {
+			tip, _ := chain.GetBestBlockHash()
+			if err := sim.Reorg(branch); !errors.Is(err, reorg.ErrReorgTooDeep) {
+				return fmt.Errorf("round %d: depth %d reorg over the limit of %d was not refused: %v", round, depth, limit, err)
+			}
+			if after, _ := chain.GetBestBlockHash(); after != tip {
+				return fmt.Errorf("round %d: refused reorg moved the tip", round)
+			}
+			if err := sim.Verify(); err != nil {
+				return fmt.Errorf("round %d: refused reorg left the chain inconsistent: %w", round, err)
+			}
+			fmt.Printf("Round %d: reorg depth %d refused (limit %d)\n", round, depth, limit)
+			continue
+		}
+
+		start := time.Now()
+		if err := sim.Reorg(branch); err != nil {
+			return fmt.Errorf("round %d (depth %d): %w", round, depth, err)
+		}
+
+		height, _ := chain.GetBestBlockHeight()
+		fmt.Printf("Round %d: reorg depth %d -> height %d, %d UTXOs, %d mempool txs (%v)\n",
+			round, depth, height, sim.UTXOs.Size(), sim.Mempool.Size(), time.Since(start).Round(time.Millisecond))
+	}
+
+	return nil
+}
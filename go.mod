@@ -8,4 +8,7 @@ require (
 	golang.org/x/crypto v0.45.0
 )
 
-require github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
+require (
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
+	golang.org/x/sys v0.38.0 // indirect
+)
@@ -0,0 +1,270 @@
+// Package atomicswap trades coins on one chain for coins on another
+// without either side trusting the other. Both sides lock their coins in
+// hashed timelock contracts (HTLCs) under the same hash; claiming one
+// contract reveals the secret that unlocks the other, and the time locks
+// give each side its coins back if the swap stalls.
+package atomicswap
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// SecretSize is the length of a generated swap secret
+const SecretSize = 32
+
+var (
+	ErrNotRevealed = errors.New("secret not revealed yet")
+	ErrNotExpired  = errors.New("contract lock time not reached")
+)
+
+// Participant is one side of a swap: the node holding its coins and a key
+// that signs for it on both chains
+type Participant struct {
+	Name string
+	Node *testharness.TestNode
+	Key  *keys.PrivateKey
+}
+
+// NewParticipant creates a participant with a fresh key, spending coins
+// from node's wallet
+func NewParticipant(name string, node *testharness.TestNode) (*Participant, error) {
+	key, err := keys.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &Participant{Name: name, Node: node, Key: key}, nil
+}
+
+// PubKey returns the participant's compressed public key
+func (p *Participant) PubKey() []byte {
+	return p.Key.PublicKey().Bytes(true)
+}
+
+// PayTo returns a P2PKH output of value to the participant's key
+func (p *Participant) PayTo(value int64) (types.TxOutput, error) {
+	pkScript, err := script.P2PKH(p.Key.PublicKey().Hash160())
+	if err != nil {
+		return types.TxOutput{}, err
+	}
+	return types.TxOutput{Value: value, PubKeyScript: pkScript}, nil
+}
+
+// Contract is an HTLC output funded on one chain
+type Contract struct {
+	Node     *testharness.TestNode // A node on the contract's chain
+	Script   []byte
+	TxHash   types.Hash
+	Index    uint32
+	Value    int64
+	Hash     [32]byte
+	LockTime uint32 // Block height after which the sender can refund
+}
+
+// NewSecret generates a random secret and the hash contracts lock to
+func NewSecret() ([]byte, [32]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, [32]byte{}, err
+	}
+	return secret, contracts.HashLock(secret), nil
+}
+
+// Lock pays amount from the sender's wallet into an HTLC that recipient
+// can claim with the preimage of hash, or the sender can refund after
+// lockTime. The funding transaction is broadcast but not mined.
+func Lock(sender *Participant, recipient []byte, hash [32]byte, lockTime uint32, amount, fee int64) (*Contract, error) {
+	htlc, err := contracts.HTLCScript(recipient, sender.PubKey(), hash, lockTime)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := sender.Node.SendToScript(htlc, amount, fee)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fund contract: %w", err)
+	}
+	txHash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, output := range tx.Outputs {
+		if bytes.Equal(output.PubKeyScript, htlc) {
+			return &Contract{
+				Node:     sender.Node,
+				Script:   htlc,
+				TxHash:   txHash,
+				Index:    uint32(i),
+				Value:    output.Value,
+				Hash:     hash,
+				LockTime: lockTime,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("funding transaction %s has no contract output", txHash)
+}
+
+// Audit checks a counterparty's contract before relying on it: it must
+// be confirmed on its chain, pay at least amount to recipient under hash,
+// and not expire before minLockTime
+func Audit(c *Contract, recipient, sender []byte, amount int64, minLockTime uint32) error {
+	expected, err := contracts.HTLCScript(recipient, sender, c.Hash, c.LockTime)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(c.Script, expected) {
+		return fmt.Errorf("contract script does not match the agreed terms")
+	}
+	if c.LockTime < minLockTime {
+		return fmt.Errorf("contract expires at height %d, need at least %d", c.LockTime, minLockTime)
+	}
+
+	output, err := confirmedOutput(c.Node, c.TxHash, c.Index)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(output.PubKeyScript, c.Script) {
+		return fmt.Errorf("output %s:%d is not the contract", c.TxHash, c.Index)
+	}
+	if output.Value < amount {
+		return fmt.Errorf("contract holds %d, need %d", output.Value, amount)
+	}
+	return nil
+}
+
+// Claim spends the contract to the recipient with the secret, revealing
+// it on the contract's chain
+func Claim(c *Contract, recipient *Participant, secret []byte, fee int64) (*types.Transaction, error) {
+	if contracts.HashLock(secret) != c.Hash {
+		return nil, fmt.Errorf("secret does not match the contract hash")
+	}
+	return spend(c, recipient, fee, 0, func(sig []byte) []byte {
+		return contracts.HTLCClaimSpend(sig, secret)
+	})
+}
+
+// Refund spends the contract back to its sender once its lock time has
+// passed
+func Refund(c *Contract, sender *Participant, fee int64) (*types.Transaction, error) {
+	height, err := c.Node.Height()
+	if err != nil {
+		return nil, err
+	}
+	// The refund can only be mined in a block above the lock time
+	if height < uint64(c.LockTime) {
+		return nil, fmt.Errorf("%w: height %d, lock time %d", ErrNotExpired, height, c.LockTime)
+	}
+	return spend(c, sender, fee, c.LockTime, contracts.HTLCRefundSpend)
+}
+
+// spend signs a spend of the contract paying to p, checks it against the
+// contract with real signature checking and broadcasts it
+func spend(c *Contract, p *Participant, fee int64, lockTime uint32, sigScript func(sig []byte) []byte) (*types.Transaction, error) {
+	if fee < 0 || fee >= c.Value {
+		return nil, fmt.Errorf("invalid fee %d for contract of %d", fee, c.Value)
+	}
+	payTo, err := p.PayTo(c.Value - fee)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := contracts.SpendTx(c.TxHash, c.Index, payTo, lockTime)
+	sig, err := contracts.Sign(tx, 0, p.Key, c.Script)
+	if err != nil {
+		return nil, err
+	}
+	tx.Inputs[0].SignatureScript = sigScript(sig)
+
+	prevOut := types.TxOutput{Value: c.Value, PubKeyScript: c.Script}
+	if err := contracts.Verify(tx, 0, prevOut); err != nil {
+		return nil, fmt.Errorf("%s cannot spend the contract: %w", p.Name, err)
+	}
+
+	if err := c.Node.P2P.BroadcastTransaction(tx); err != nil {
+		return nil, fmt.Errorf("failed to broadcast spend: %w", err)
+	}
+	return tx, nil
+}
+
+// FindSecret looks for the recipient's claim of the contract, first in
+// the mempool and then in blocks from fromHeight, and returns the secret
+// it reveals
+func FindSecret(c *Contract, fromHeight uint64) ([]byte, error) {
+	for _, entry := range c.Node.P2P.Mempool.GetAllTransactions() {
+		if secret, err := ExtractSecret(entry.Tx, c); err == nil {
+			return secret, nil
+		}
+	}
+
+	tip, err := c.Node.Height()
+	if err != nil {
+		return nil, err
+	}
+	for height := fromHeight; height <= tip; height++ {
+		block, err := c.Node.Chain.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		for i := range block.Transactions {
+			if secret, err := ExtractSecret(&block.Transactions[i], c); err == nil {
+				return secret, nil
+			}
+		}
+	}
+	return nil, ErrNotRevealed
+}
+
+// ExtractSecret returns the secret from tx if it claims the contract
+func ExtractSecret(tx *types.Transaction, c *Contract) ([]byte, error) {
+	for _, input := range tx.Inputs {
+		if input.PrevTxHash != c.TxHash || input.OutputIndex != c.Index {
+			continue
+		}
+
+		// A claim is <sig> <secret> OP_1; a refund has no secret
+		pushes, err := script.Pushes(input.SignatureScript)
+		if err != nil || len(pushes) != 3 {
+			return nil, ErrNotRevealed
+		}
+		secret := pushes[1]
+		if contracts.HashLock(secret) != c.Hash {
+			return nil, fmt.Errorf("claim reveals a secret that does not match the hash")
+		}
+		return secret, nil
+	}
+	return nil, ErrNotRevealed
+}
+
+// confirmedOutput reads an output of a transaction in node's best chain
+func confirmedOutput(node *testharness.TestNode, txHash types.Hash, index uint32) (types.TxOutput, error) {
+	blockHash, _, err := node.Chain.GetTransactionLocation(txHash)
+	if err != nil {
+		return types.TxOutput{}, fmt.Errorf("contract %s not confirmed: %w", txHash, err)
+	}
+	block, err := node.Chain.GetBlock(blockHash)
+	if err != nil {
+		return types.TxOutput{}, err
+	}
+
+	for i := range block.Transactions {
+		hash, err := serialization.HashTransaction(&block.Transactions[i])
+		if err != nil || hash != txHash {
+			continue
+		}
+		outputs := block.Transactions[i].Outputs
+		if int(index) >= len(outputs) {
+			return types.TxOutput{}, fmt.Errorf("output index %d out of range", index)
+		}
+		return outputs[index], nil
+	}
+	return types.TxOutput{}, fmt.Errorf("contract %s not found in block %s", txHash, blockHash)
+}
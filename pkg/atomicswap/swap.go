@@ -0,0 +1,126 @@
+package atomicswap
+
+import (
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Config describes the terms of a swap
+type Config struct {
+	InitiatorAmount   int64  // Locked by the initiator on its chain
+	ParticipantAmount int64  // Locked by the participant on its chain
+	Fee               int64  // Paid by every transaction of the swap
+	LockBlocks        uint32 // Initiator's refund delay; the participant's is half
+
+	// Logf, if set, is told about every step
+	Logf func(format string, args ...interface{})
+}
+
+// Result records the contracts and claims of a completed swap
+type Result struct {
+	Secret              []byte
+	InitiatorContract   *Contract // On the initiator's chain, claimed by the participant
+	ParticipantContract *Contract // On the participant's chain, claimed by the initiator
+	InitiatorClaim      *types.Transaction
+	ParticipantClaim    *types.Transaction
+}
+
+// Run performs a swap between two participants whose nodes are on
+// different chains. The initiator picks the secret and locks first with
+// the longer time lock, so the participant can always claim before the
+// initiator could refund. Each step is mined on its chain before the
+// other side acts on it.
+func Run(initiator, participant *Participant, cfg Config) (*Result, error) {
+	logf := cfg.Logf
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	if cfg.LockBlocks < 2 {
+		return nil, fmt.Errorf("lock of %d blocks leaves no room for the participant", cfg.LockBlocks)
+	}
+
+	secret, hash, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Secret: secret}
+	logf("%s picks a secret with hash %x", initiator.Name, hash)
+
+	// 1. The initiator locks its coins for the participant
+	initHeight, err := initiator.Node.Height()
+	if err != nil {
+		return nil, err
+	}
+	initLock := uint32(initHeight) + cfg.LockBlocks
+	result.InitiatorContract, err = Lock(initiator, participant.PubKey(), hash, initLock, cfg.InitiatorAmount, cfg.Fee)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", initiator.Name, err)
+	}
+	if err := mine(initiator, 1); err != nil {
+		return nil, err
+	}
+	logf("%s locked %d in %s:%d until height %d", initiator.Name, cfg.InitiatorAmount,
+		result.InitiatorContract.TxHash, result.InitiatorContract.Index, initLock)
+
+	// 2. The participant checks it and locks its coins under the same hash
+	// with a shorter time lock
+	if err := Audit(result.InitiatorContract, participant.PubKey(), initiator.PubKey(), cfg.InitiatorAmount, initLock); err != nil {
+		return nil, fmt.Errorf("%s rejects the contract: %w", participant.Name, err)
+	}
+	partHeight, err := participant.Node.Height()
+	if err != nil {
+		return nil, err
+	}
+	partLock := uint32(partHeight) + cfg.LockBlocks/2
+	result.ParticipantContract, err = Lock(participant, initiator.PubKey(), hash, partLock, cfg.ParticipantAmount, cfg.Fee)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", participant.Name, err)
+	}
+	if err := mine(participant, 1); err != nil {
+		return nil, err
+	}
+	logf("%s locked %d in %s:%d until height %d", participant.Name, cfg.ParticipantAmount,
+		result.ParticipantContract.TxHash, result.ParticipantContract.Index, partLock)
+
+	// 3. The initiator checks it and claims, revealing the secret
+	if err := Audit(result.ParticipantContract, initiator.PubKey(), participant.PubKey(), cfg.ParticipantAmount, partLock); err != nil {
+		return nil, fmt.Errorf("%s rejects the contract: %w", initiator.Name, err)
+	}
+	claimFrom, err := participant.Node.Height()
+	if err != nil {
+		return nil, err
+	}
+	result.InitiatorClaim, err = Claim(result.ParticipantContract, initiator, secret, cfg.Fee)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", initiator.Name, err)
+	}
+	if err := mine(participant, 1); err != nil {
+		return nil, err
+	}
+	logf("%s claimed %d on %s's chain, revealing the secret", initiator.Name, cfg.ParticipantAmount-cfg.Fee, participant.Name)
+
+	// 4. The participant reads the secret off its chain and claims
+	learned, err := FindSecret(result.ParticipantContract, claimFrom)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", participant.Name, err)
+	}
+	result.ParticipantClaim, err = Claim(result.InitiatorContract, participant, learned, cfg.Fee)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", participant.Name, err)
+	}
+	if err := mine(initiator, 1); err != nil {
+		return nil, err
+	}
+	logf("%s learned the secret and claimed %d on %s's chain", participant.Name, cfg.InitiatorAmount-cfg.Fee, initiator.Name)
+
+	return result, nil
+}
+
+// mine confirms pending transactions on p's chain
+func mine(p *Participant, blocks int) error {
+	if _, err := p.Node.MineBlocks(blocks); err != nil {
+		return fmt.Errorf("failed to mine on %s's chain: %w", p.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+// Package backup writes the whole state of a running node, its chain
+// database and its wallets, to one tarball, and restores a data directory
+// from one. The chain database is read through a LevelDB snapshot, so the
+// node keeps running while a backup is taken; every file is hashed into
+// the manifest and checked again on restore.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+const (
+	// Version is the backup format written
+	Version = 1
+
+	// ManifestName is the first file of a backup, describing the rest
+	ManifestName = "manifest.json"
+
+	// ChainName holds the chain database, in storage's snapshot format
+	ChainName = "chain.snapshot"
+)
+
+// ErrMismatch is returned by Restore when a file or the restored database
+// doesn't hash to what the manifest says
+var ErrMismatch = errors.New("backup does not match its manifest")
+
+// Manifest describes a backup
+type Manifest struct {
+	Version   int               `json:"version"`
+	Created   int64             `json:"created"` // Unix time
+	TipHash   string            `json:"tip_hash"`
+	TipHeight uint64            `json:"tip_height"`
+	Entries   int64             `json:"entries"` // Chain database entries
+	Files     map[string]string `json:"files"`   // Name to hex SHA-256
+}
+
+// Wallets returns the names of the wallet files in the backup, sorted
+func (m *Manifest) Wallets() []string {
+	var names []string
+	for name := range m.Files {
+		if name != ChainName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Create writes a gzipped tarball of chain and wallets to out. Wallets are
+// keyed by their path relative to the data directory, which is where
+// Restore puts them back. The chain is snapshotted into a temporary file
+// first, since the manifest leading the tarball needs its hash.
+func Create(out io.Writer, chain *storage.BlockchainStorage, wallets map[string]*wallet.Wallet) (*Manifest, error) {
+	for name := range wallets {
+		if err := checkName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "chain-*.snapshot")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	info, err := chain.ExportSnapshot(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot chain: %w", err)
+	}
+	chainSize, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		Version:   Version,
+		Created:   time.Now().Unix(),
+		TipHeight: info.TipHeight,
+		Entries:   info.Entries,
+		Files:     map[string]string{ChainName: hex.EncodeToString(info.Digest[:])},
+	}
+	if info.TipHash != (types.Hash{}) {
+		manifest.TipHash = info.TipHash.String()
+	}
+	contents := make(map[string][]byte, len(wallets))
+	for name, w := range wallets {
+		data, err := w.Contents()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read wallet %s: %w", name, err)
+		}
+		contents[name] = data
+		sum := sha256.Sum256(data)
+		manifest.Files[name] = hex.EncodeToString(sum[:])
+	}
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(tw, ManifestName, int64(len(manifestData)), bytes.NewReader(manifestData)); err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := writeFile(tw, ChainName, chainSize, tmp); err != nil {
+		return nil, err
+	}
+	for _, name := range manifest.Wallets() {
+		data := contents[name]
+		if err := writeFile(tw, name, int64(len(data)), bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Restore recreates a data directory from a backup. dataDir must not
+// exist or be empty. Everything is written to a directory beside it and
+// only moved into place once every file has matched its hash and the
+// restored database, read back from disk, matches the one backed up.
+func Restore(in io.Reader, dataDir string) (*Manifest, error) {
+	if entries, err := os.ReadDir(dataDir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%s is not empty", dataDir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	staging := filepath.Clean(dataDir) + ".restoring"
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+	manifest, err := restoreTo(in, staging)
+	if err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	if err := os.Rename(staging, dataDir); err != nil {
+		os.RemoveAll(staging)
+		return nil, fmt.Errorf("failed to move restored data into place: %w", err)
+	}
+	return manifest, nil
+}
+
+// restoreTo unpacks and checks a backup in dir
+func restoreTo(in io.Reader, dir string) (*Manifest, error) {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != ManifestName {
+		return nil, fmt.Errorf("not a backup: %s must come first", ManifestName)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version != Version {
+		return nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+	if _, ok := manifest.Files[ChainName]; !ok {
+		return nil, fmt.Errorf("invalid manifest: no %s", ChainName)
+	}
+
+	seen := make(map[string]bool)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+		want, ok := manifest.Files[header.Name]
+		if !ok || seen[header.Name] {
+			return nil, fmt.Errorf("%w: unexpected file %s", ErrMismatch, header.Name)
+		}
+		if header.Name != ChainName {
+			if err := checkName(header.Name); err != nil {
+				return nil, err
+			}
+		}
+		seen[header.Name] = true
+
+		hasher := sha256.New()
+		body := io.TeeReader(tr, hasher)
+		if header.Name == ChainName {
+			_, err = storage.ImportSnapshot(dir, body)
+		} else {
+			err = restoreFile(filepath.Join(dir, filepath.FromSlash(header.Name)), body)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", header.Name, err)
+		}
+		if err := checkSum(header.Name, hasher, want); err != nil {
+			return nil, err
+		}
+		if header.Name == ChainName {
+			if err := verifyChain(dir, &manifest); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for name := range manifest.Files {
+		if !seen[name] {
+			return nil, fmt.Errorf("%w: %s is missing", ErrMismatch, name)
+		}
+	}
+	return &manifest, nil
+}
+
+// verifyChain reopens an imported chain database and snapshots it again,
+// so what is on disk is compared with what was backed up
+func verifyChain(dir string, manifest *Manifest) error {
+	chain, err := storage.NewBlockchainStorage(dir)
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+
+	info, err := chain.ExportSnapshot(io.Discard)
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(info.Digest[:]); got != manifest.Files[ChainName] {
+		return fmt.Errorf("%w: restored database hashes to %s, want %s", ErrMismatch, got, manifest.Files[ChainName])
+	}
+	if info.Entries != manifest.Entries {
+		return fmt.Errorf("%w: restored %d entries, want %d", ErrMismatch, info.Entries, manifest.Entries)
+	}
+
+	tipHash, tipHeight, err := chain.GetTip()
+	if err != nil && manifest.TipHash != "" {
+		return err
+	}
+	if err == nil && (tipHash.String() != manifest.TipHash || tipHeight != manifest.TipHeight) {
+		return fmt.Errorf("%w: restored tip %s at %d, want %s at %d", ErrMismatch, tipHash, tipHeight, manifest.TipHash, manifest.TipHeight)
+	}
+	return nil
+}
+
+// restoreFile writes a wallet file
+func restoreFile(path string, in io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, in); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeFile adds a file to the tarball
+func writeFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := io.CopyN(tw, r, size); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// checkSum compares a file's hash with the manifest's
+func checkSum(name string, hasher hash.Hash, want string) error {
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != want {
+		return fmt.Errorf("%w: %s hashes to %s, want %s", ErrMismatch, name, got, want)
+	}
+	return nil
+}
+
+// checkName rejects wallet names that would land outside the data
+// directory or clash with the backup's own files
+func checkName(name string) error {
+	clean := path.Clean(name)
+	if name == "" || clean != name || path.IsAbs(name) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid wallet file name %q", name)
+	}
+	if name == ManifestName || name == ChainName {
+		return fmt.Errorf("wallet file name %q is reserved", name)
+	}
+	return nil
+}
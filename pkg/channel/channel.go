@@ -0,0 +1,180 @@
+// Package channel implements a unidirectional payment channel. The payer
+// locks funds in a 2-of-2 multisig output shared with the payee, then pays
+// off-chain by signing commitment transactions that move more and more of
+// them to the payee. Only one transaction ever reaches the chain: a
+// cooperative close, the latest commitment, or the payer's refund.
+//
+// Each commitment has a lower lock time than the one before, so the
+// latest state becomes valid first. The payee, who gains from every
+// update, can always broadcast the newest commitment before the payer
+// could fall back to an older one or to the refund.
+package channel
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+var (
+	ErrNotOpen       = errors.New("channel not open")
+	ErrExhausted     = errors.New("channel has no lock times left for updates")
+	ErrInsufficient  = errors.New("channel capacity exceeded")
+	ErrTimeLocked    = errors.New("transaction lock time not reached")
+	ErrBadSignature  = errors.New("invalid counterparty signature")
+	ErrStaleUpdate   = errors.New("update does not follow the current state")
+	ErrNothingToPay  = errors.New("payment must be positive")
+	ErrFundingScript = errors.New("funding output does not match the channel")
+)
+
+// Params are the terms both sides agree on before the channel is funded
+type Params struct {
+	Payer    []byte // Serialized public keys
+	Payee    []byte
+	Capacity int64  // Value of the funding output
+	Fee      int64  // Paid by every channel transaction, from the payer's side
+	Expiry   uint32 // Height from which the payer can take a refund
+	Step     uint32 // Lock time decrease per update
+}
+
+// Validate checks that the terms are usable
+func (p *Params) Validate() error {
+	if p.Capacity <= 0 || p.Fee < 0 || p.Fee >= p.Capacity {
+		return fmt.Errorf("invalid capacity %d and fee %d", p.Capacity, p.Fee)
+	}
+	if p.Step == 0 || p.Expiry <= p.Step {
+		return fmt.Errorf("expiry %d leaves no room for updates of %d blocks", p.Expiry, p.Step)
+	}
+	if _, err := p.FundingScript(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FundingScript returns the 2-of-2 multisig script the funding output
+// pays to, payer's key first
+func (p *Params) FundingScript() ([]byte, error) {
+	return contracts.MultiSigScript(2, p.Payer, p.Payee)
+}
+
+// Update is a payer-signed commitment sent to the payee
+type Update struct {
+	Seq       uint32 // Updates since the channel opened, starting at 1
+	Paid      int64  // Total paid to the payee
+	LockTime  uint32
+	Signature []byte // Payer's signature of the commitment
+}
+
+// channel is the state both sides track
+type channel struct {
+	params        Params
+	fundingScript []byte
+	fundingHash   types.Hash
+	fundingIndex  uint32
+	funded        bool
+	seq           uint32
+	paid          int64
+}
+
+// newChannel checks the terms and prepares the shared state
+func newChannel(params Params) (*channel, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	fundingScript, _ := params.FundingScript()
+	return &channel{params: params, fundingScript: fundingScript}, nil
+}
+
+// lockTime returns the lock time of commitment seq
+func (c *channel) lockTime(seq uint32) uint32 {
+	return c.params.Expiry - seq*c.params.Step
+}
+
+// spendTx builds a transaction spending the funding output that pays
+// paid to the payee and the rest, less the fee, back to the payer
+func (c *channel) spendTx(paid int64, lockTime uint32) (*types.Transaction, error) {
+	if !c.funded {
+		return nil, ErrNotOpen
+	}
+	change := c.params.Capacity - c.params.Fee - paid
+	if paid < 0 || change < 0 {
+		return nil, fmt.Errorf("%w: paying %d of %d", ErrInsufficient, paid, c.params.Capacity-c.params.Fee)
+	}
+
+	sequence := transaction.SequenceFinal
+	if lockTime > 0 {
+		sequence--
+	}
+	tx := &types.Transaction{
+		Version:  1,
+		Inputs:   []types.TxInput{{PrevTxHash: c.fundingHash, OutputIndex: c.fundingIndex, Sequence: sequence}},
+		LockTime: lockTime,
+	}
+
+	for _, out := range []struct {
+		key   []byte
+		value int64
+	}{{c.params.Payee, paid}, {c.params.Payer, change}} {
+		if out.value == 0 {
+			continue
+		}
+		pkScript, err := payToKey(out.key)
+		if err != nil {
+			return nil, err
+		}
+		tx.Outputs = append(tx.Outputs, types.TxOutput{Value: out.value, PubKeyScript: pkScript})
+	}
+	return tx, nil
+}
+
+// sign signs the funding input of tx
+func (c *channel) sign(tx *types.Transaction, key *keys.PrivateKey) ([]byte, error) {
+	return contracts.Sign(tx, 0, key, c.fundingScript)
+}
+
+// checkSig verifies pubKey's signature of the funding input of tx
+func (c *channel) checkSig(tx *types.Transaction, sig, pubKey []byte) error {
+	if !transaction.SignatureChecker(tx, 0, c.fundingScript)(sig, pubKey) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// complete adds both signatures to tx and checks it against the funding
+// output
+func (c *channel) complete(tx *types.Transaction, payerSig, payeeSig []byte) error {
+	tx.Inputs[0].SignatureScript = contracts.MultiSigSpend(payerSig, payeeSig)
+	prevOut := types.TxOutput{Value: c.params.Capacity, PubKeyScript: c.fundingScript}
+	return contracts.Verify(tx, 0, prevOut)
+}
+
+// broadcast relays tx through node once its lock time has passed. The
+// chain doesn't enforce transaction lock times, so the channel does.
+func broadcast(node *network.Node, tx *types.Transaction) error {
+	if tx.LockTime > 0 {
+		height, err := node.Blockchain.GetBestBlockHeight()
+		if err != nil {
+			return err
+		}
+		// It must fit in a block above its lock time
+		if height < uint64(tx.LockTime) {
+			return fmt.Errorf("%w: height %d, lock time %d", ErrTimeLocked, height, tx.LockTime)
+		}
+	}
+	return node.BroadcastTransaction(tx)
+}
+
+// payToKey returns a P2PKH script for a serialized public key
+func payToKey(pubKey []byte) ([]byte, error) {
+	key, err := keys.ParsePublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return script.P2PKH(key.Hash160())
+}
@@ -0,0 +1,126 @@
+package channel
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Payee is the side that receives payments through the channel
+type Payee struct {
+	*channel
+	key    *keys.PrivateKey
+	latest *types.Transaction // Newest fully signed commitment
+	mu     sync.Mutex
+}
+
+// NewPayee creates the payee's side of a channel; key must match
+// params.Payee
+func NewPayee(key *keys.PrivateKey, params Params) (*Payee, error) {
+	if !bytes.Equal(key.PublicKey().Bytes(true), params.Payee) {
+		return nil, fmt.Errorf("key does not match the payee's public key")
+	}
+	c, err := newChannel(params)
+	if err != nil {
+		return nil, err
+	}
+	return &Payee{channel: c, key: key}, nil
+}
+
+// SignRefund checks the payer's funding transaction and signs the refund
+// that returns everything to the payer at the channel's expiry
+func (p *Payee) SignRefund(funding *types.Transaction) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.funded {
+		return nil, fmt.Errorf("refund already signed")
+	}
+	index, err := findFundingOutput(funding, p.fundingScript, p.params.Capacity)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := serialization.HashTransaction(funding)
+	if err != nil {
+		return nil, err
+	}
+	p.fundingHash, p.fundingIndex, p.funded = hash, index, true
+
+	refund, err := p.spendTx(0, p.params.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	return p.sign(refund, p.key)
+}
+
+// Accept checks a payment from the payer and keeps its commitment, which
+// the payee can broadcast from its lock time on
+func (p *Payee) Accept(u *Update) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.funded {
+		return ErrNotOpen
+	}
+	if u.Seq != p.seq+1 || u.Paid <= p.paid || u.LockTime != p.lockTime(u.Seq) {
+		return fmt.Errorf("%w: seq %d paying %d at %d", ErrStaleUpdate, u.Seq, u.Paid, u.LockTime)
+	}
+
+	commitment, err := p.spendTx(u.Paid, u.LockTime)
+	if err != nil {
+		return err
+	}
+	if err := p.checkSig(commitment, u.Signature, p.params.Payer); err != nil {
+		return err
+	}
+	sig, err := p.sign(commitment, p.key)
+	if err != nil {
+		return err
+	}
+	if err := p.complete(commitment, u.Signature, sig); err != nil {
+		return err
+	}
+
+	p.seq, p.paid, p.latest = u.Seq, u.Paid, commitment
+	return nil
+}
+
+// Received returns the total received through the channel
+func (p *Payee) Received() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paid
+}
+
+// SignClose signs a cooperative close paying out the current state
+// immediately, for the payer to complete with Payer.Close
+func (p *Payee) SignClose() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tx, err := p.spendTx(p.paid, 0)
+	if err != nil {
+		return nil, err
+	}
+	return p.sign(tx, p.key)
+}
+
+// Broadcast closes the channel unilaterally with the latest commitment,
+// which fails with ErrTimeLocked until its lock time
+func (p *Payee) Broadcast(node *network.Node) (*types.Transaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.latest == nil {
+		return nil, fmt.Errorf("no payments to close with")
+	}
+	if err := broadcast(node, p.latest); err != nil {
+		return nil, err
+	}
+	return p.latest, nil
+}
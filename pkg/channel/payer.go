@@ -0,0 +1,205 @@
+package channel
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// Payer is the side that funds the channel and pays through it
+type Payer struct {
+	*channel
+	key     *keys.PrivateKey
+	funding *types.Transaction
+	refund  *types.Transaction // Fully signed once the payee signed it
+	open    bool
+	mu      sync.Mutex
+}
+
+// NewPayer creates the payer's side of a channel; key must match
+// params.Payer
+func NewPayer(key *keys.PrivateKey, params Params) (*Payer, error) {
+	if !bytes.Equal(key.PublicKey().Bytes(true), params.Payer) {
+		return nil, fmt.Errorf("key does not match the payer's public key")
+	}
+	c, err := newChannel(params)
+	if err != nil {
+		return nil, err
+	}
+	return &Payer{channel: c, key: key}, nil
+}
+
+// Fund builds and signs the funding transaction from w without
+// broadcasting it. Send it to the payee to get the refund signed first.
+func (p *Payer) Fund(w *wallet.Wallet, fee int64) (*types.Transaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.funding != nil {
+		return nil, fmt.Errorf("channel already funded")
+	}
+
+	tx, err := w.SendToScript(p.fundingScript, p.params.Capacity, fee)
+	if err != nil {
+		return nil, err
+	}
+	index, err := findFundingOutput(tx, p.fundingScript, p.params.Capacity)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The wallet must not pick these coins again
+	for _, input := range tx.Inputs {
+		w.RemoveUTXO(utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex))
+	}
+
+	p.funding = tx
+	p.fundingHash, p.fundingIndex, p.funded = hash, index, true
+	return tx, nil
+}
+
+// Open checks the payee's refund signature and broadcasts the funding
+// transaction. Without a valid refund the payer's coins could be held
+// hostage, so nothing is broadcast until then.
+func (p *Payer) Open(node *network.Node, refundSig []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.funding == nil {
+		return ErrNotOpen
+	}
+	if p.open {
+		return fmt.Errorf("channel already open")
+	}
+
+	refund, err := p.spendTx(0, p.params.Expiry)
+	if err != nil {
+		return err
+	}
+	if err := p.checkSig(refund, refundSig, p.params.Payee); err != nil {
+		return fmt.Errorf("refund: %w", err)
+	}
+	sig, err := p.sign(refund, p.key)
+	if err != nil {
+		return err
+	}
+	if err := p.complete(refund, sig, refundSig); err != nil {
+		return err
+	}
+
+	if err := node.BroadcastTransaction(p.funding); err != nil {
+		return fmt.Errorf("failed to broadcast funding: %w", err)
+	}
+	p.refund = refund
+	p.open = true
+	return nil
+}
+
+// Pay moves amount more to the payee and returns the signed commitment
+// to send them. The payee may broadcast it, so the payment counts as
+// made from here on.
+func (p *Payer) Pay(amount int64) (*Update, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.open {
+		return nil, ErrNotOpen
+	}
+	if amount <= 0 {
+		return nil, ErrNothingToPay
+	}
+	seq := p.seq + 1
+	if seq >= p.params.Expiry/p.params.Step {
+		return nil, ErrExhausted
+	}
+
+	paid := p.paid + amount
+	lockTime := p.lockTime(seq)
+	commitment, err := p.spendTx(paid, lockTime)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := p.sign(commitment, p.key)
+	if err != nil {
+		return nil, err
+	}
+
+	p.seq, p.paid = seq, paid
+	return &Update{Seq: seq, Paid: paid, LockTime: lockTime, Signature: sig}, nil
+}
+
+// Paid returns the total paid through the channel
+func (p *Payer) Paid() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paid
+}
+
+// Close completes a cooperative close the payee signed with SignClose
+// and broadcasts it. It pays out the current state with no lock time.
+func (p *Payer) Close(node *network.Node, payeeSig []byte) (*types.Transaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.open {
+		return nil, ErrNotOpen
+	}
+
+	tx, err := p.spendTx(p.paid, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkSig(tx, payeeSig, p.params.Payee); err != nil {
+		return nil, fmt.Errorf("close: %w", err)
+	}
+	sig, err := p.sign(tx, p.key)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.complete(tx, sig, payeeSig); err != nil {
+		return nil, err
+	}
+
+	if err := broadcast(node, tx); err != nil {
+		return nil, err
+	}
+	p.open = false
+	return tx, nil
+}
+
+// Refund broadcasts the refund once the channel has expired, taking back
+// everything if the payee never closed
+func (p *Payer) Refund(node *network.Node) (*types.Transaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.refund == nil {
+		return nil, ErrNotOpen
+	}
+	if err := broadcast(node, p.refund); err != nil {
+		return nil, err
+	}
+	p.open = false
+	return p.refund, nil
+}
+
+// findFundingOutput returns the index of the channel's output in tx
+func findFundingOutput(tx *types.Transaction, fundingScript []byte, capacity int64) (uint32, error) {
+	for i, output := range tx.Outputs {
+		if bytes.Equal(output.PubKeyScript, fundingScript) && output.Value == capacity {
+			return uint32(i), nil
+		}
+	}
+	return 0, ErrFundingScript
+}
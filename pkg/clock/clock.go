@@ -0,0 +1,123 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for time-dependent components (mempool
+// expiry, peer timeouts, block templates, network-adjusted time).
+// Production code uses Real; tests use a Fake that only moves when told
+// to. Difficulty adjustment and fee estimation take no clock: they read
+// the timestamps and heights of blocks, so under a Fake they follow the
+// timestamps it put in the templates.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// After returns a channel that receives the time once d has elapsed
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the wall clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Fake is a manually driven clock for deterministic tests
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+// waiter is a pending After call
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewFake creates a fake clock starting at the given time
+func NewFake(start time.Time) *Fake {
+	return &Fake{
+		now: start,
+	}
+}
+
+// Now returns the fake current time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// After returns a channel that fires once the clock is advanced past d
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	deadline := f.now.Add(d)
+
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+
+	f.waiters = append(f.waiters, waiter{deadline: deadline, ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward, firing any timers that expire
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.setLocked(f.now.Add(d))
+}
+
+// Set jumps the clock to t (which may be in the past)
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.setLocked(t)
+}
+
+// PendingTimers returns the number of After calls still waiting
+func (f *Fake) PendingTimers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.waiters)
+}
+
+// setLocked updates the time and fires expired waiters (internal, lock held)
+func (f *Fake) setLocked(t time.Time) {
+	f.now = t
+
+	// Fire in deadline order so callers observe a consistent sequence
+	sort.Slice(f.waiters, func(i, j int) bool {
+		return f.waiters[i].deadline.Before(f.waiters[j].deadline)
+	})
+
+	remaining := f.waiters[:0]
+	for _, w := range f.waiters {
+		if !w.deadline.After(t) {
+			w.ch <- t
+		} else {
+			remaining = append(remaining, w)
+		}
+	}
+	f.waiters = remaining
+}
@@ -0,0 +1,134 @@
+package clock
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxTimeSamples caps the peers whose clock offset is remembered
+	MaxTimeSamples = 200
+
+	// MinTimeSamples is how many peers must report before the local
+	// clock is adjusted
+	MinTimeSamples = 5
+
+	// MaxTimeAdjustment is the largest correction applied to the local
+	// clock. A larger median offset is ignored: either our clock or most
+	// of our peers are badly wrong.
+	MaxTimeAdjustment = 70 * time.Minute
+
+	// ClockWarningThreshold is the median offset at which the operator is
+	// told to check the system clock
+	ClockWarningThreshold = 5 * time.Minute
+)
+
+// NetworkTime is a clock adjusted by the median offset peers report in
+// their version messages, so a node with a slightly wrong clock still
+// agrees with the network on which block times are acceptable
+type NetworkTime struct {
+	mu      sync.Mutex
+	base    Clock
+	samples map[string]time.Duration // Offset by source, one per peer address
+	offset  time.Duration
+	warning string // Set once the local clock looks wrong
+}
+
+// NewNetworkTime creates a network-adjusted clock on top of base
+func NewNetworkTime(base Clock) *NetworkTime {
+	return &NetworkTime{
+		base:    base,
+		samples: make(map[string]time.Duration),
+	}
+}
+
+// SetBase replaces the underlying clock, keeping the samples
+func (nt *NetworkTime) SetBase(base Clock) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	nt.base = base
+}
+
+// Now returns the local time plus the network offset
+func (nt *NetworkTime) Now() time.Time {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	return nt.base.Now().Add(nt.offset)
+}
+
+// After waits on the underlying clock; durations aren't adjusted
+func (nt *NetworkTime) After(d time.Duration) <-chan time.Time {
+	nt.mu.Lock()
+	base := nt.base
+	nt.mu.Unlock()
+
+	return base.After(d)
+}
+
+// Offset returns the adjustment currently applied to the local clock
+func (nt *NetworkTime) Offset() time.Duration {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	return nt.offset
+}
+
+// NumSamples returns how many peers have reported an offset
+func (nt *NetworkTime) NumSamples() int {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	return len(nt.samples)
+}
+
+// AddSample records the offset between a peer's clock and ours. Each
+// source counts once, and only the first MaxTimeSamples are kept so
+// reconnecting peers can't drag the median. The adjustment is
+// recalculated on every odd sample count, as in Bitcoin Core. It returns
+// a warning the first time the median says the local clock is off by
+// more than ClockWarningThreshold, and "" otherwise.
+func (nt *NetworkTime) AddSample(source string, offset time.Duration) string {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	if _, ok := nt.samples[source]; ok || len(nt.samples) >= MaxTimeSamples {
+		return ""
+	}
+	nt.samples[source] = offset
+
+	if len(nt.samples) < MinTimeSamples || len(nt.samples)%2 == 0 {
+		return ""
+	}
+
+	offsets := make([]time.Duration, 0, len(nt.samples))
+	for _, o := range nt.samples {
+		offsets = append(offsets, o)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	median := offsets[len(offsets)/2]
+
+	if median.Abs() <= MaxTimeAdjustment {
+		nt.offset = median
+	} else {
+		nt.offset = 0
+	}
+
+	if median.Abs() > ClockWarningThreshold && nt.warning == "" {
+		nt.warning = fmt.Sprintf("Your clock is %v off the median of %d peers. Please check that your computer's date and time are correct.",
+			median.Round(time.Second), len(offsets))
+		return nt.warning
+	}
+	return ""
+}
+
+// Warning returns the clock warning raised by AddSample, or ""
+func (nt *NetworkTime) Warning() string {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	return nt.warning
+}
@@ -1,38 +1,84 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	PIDFileName = "bitcoind.pid" // Default process ID file in the data directory
+	LogFileName = "debug.log"    // Log file in the data directory in daemon mode
+)
+
 // NodeConfig holds all configuration for a Bitcoin node
 type NodeConfig struct {
 	// Node Identity
 	NodeID string
 
 	// Network Configuration
-	Network      string   // mainnet, testnet, regtest
+	Network      string   // mainnet, testnet, signet, regtest
 	RPCPort      int      // RPC server port
 	P2PPort      int      // P2P network port
+	ListenAddrs  []string // Extra P2P listen addresses; entries without a port use P2PPort
+	NoListen     bool     // Outbound connections only
+	ExternalIP   string   // Address advertised to peers, "" = detect
 	InitialPeers []string // List of initial peer addresses
+	DNSSeeds     []string // Hostnames queried for peer addresses when none are known
+	EnableNAT    bool     // Map the P2P port on the router via NAT-PMP/UPnP
+	V2Transport  bool     // Offer encrypted peer connections
+	Dandelion    bool     // Relay our transactions along a stem before announcing them
+	ElectrumAddr string   // Listen address for Electrum wallets, "" = disabled
+	RESTAddr     string   // Listen address for the unauthenticated REST interface, "" = disabled
 
 	// Storage
-	DataDir string // Data directory path
+	DataDir           string        // Data directory path
+	NullDataIndex     bool          // Index OP_RETURN payloads for listnulldata
+	SpentIndex        bool          // Index spent outputs for getspentinfo
+	AddressIndex      bool          // Index transactions by address for the explorer
+	UTXOFlushInterval time.Duration // Time between writes of the cached UTXO set to disk, 0 = only at shutdown
+
+	// Wallet
+	WalletRBF            bool          // Created transactions opt in to replace-by-fee
+	WalletBackupInterval time.Duration // Time between automatic wallet backups, 0 = disabled
+	WalletBackupKeep     int           // Automatic backups kept before the oldest is deleted
+
+	// Mempool policy, fee rates in sat/kvB
+	MinRelayTxFee       int64 // Lowest fee rate accepted into the mempool and relayed
+	IncrementalRelayFee int64 // Fee rate replacements add, and a full mempool's floor rises by
+
+	// Validation
+	AssumeValid     string // Block whose ancestors skip script checks, "" = network default, "0" = check all
+	SignetChallenge string // Hex script signet blocks must solve, "" = the default signet
+	CheckBlocks     int    // Blocks re-validated at startup, 0 = all, -1 = none
+	CheckLevel      int    // How thoroughly they are re-validated, 0 (headers) to 4 (full scripts)
 
 	// Mining Configuration
 	MiningEnabled bool          // Enable mining
 	MinerAddress  string        // Address to receive mining rewards
 	AutoMine      bool          // Automatically mine blocks
 	MineInterval  time.Duration // Interval between auto-mining attempts
+	BlockOrder    string        // Transaction order in new blocks: "feerate" or "priority" (coin age)
 
 	// Logging
 	LogLevel string // debug, info, warn, error
 
+	// Process
+	Daemon  bool   // Log to DataDir/debug.log instead of the terminal
+	PIDFile string // Process ID file, "" = DataDir/bitcoind.pid
+
 	// Monitoring
-	EnableMonitoring bool // Enable monitoring/metrics
+	EnableMonitoring bool   // Enable monitoring/metrics
+	DebugAddr        string // Listen address for pprof and diagnostics, "" = disabled
+
+	// RPC rate limits per client IP, in requests per second (0 = unlimited)
+	RPCRateLimit       int // Read-only commands
+	RPCWalletRateLimit int // Wallet and node-changing commands
 }
 
 // DefaultConfig returns the default configuration
@@ -47,9 +93,23 @@ func DefaultConfig() *NodeConfig {
 		MinerAddress:     "",
 		AutoMine:         false,
 		MineInterval:     10 * time.Second,
+		BlockOrder:       "feerate",
 		LogLevel:         "info",
 		InitialPeers:     []string{},
 		EnableMonitoring: false,
+
+		UTXOFlushInterval: time.Minute,
+
+		WalletBackupKeep: 10,
+
+		MinRelayTxFee:       1000, // 1 sat/vB
+		IncrementalRelayFee: 1000, // 1 sat/vB
+
+		CheckBlocks: 6,
+		CheckLevel:  3,
+
+		RPCRateLimit:       50,
+		RPCWalletRateLimit: 5,
 	}
 }
 
@@ -73,21 +133,138 @@ func LoadFromEnv() *NodeConfig {
 		}
 	}
 
+	if limit := os.Getenv("RPC_RATE_LIMIT"); limit != "" {
+		if rate, err := strconv.Atoi(limit); err == nil {
+			cfg.RPCRateLimit = rate
+		}
+	}
+
+	if limit := os.Getenv("RPC_WALLET_RATE_LIMIT"); limit != "" {
+		if rate, err := strconv.Atoi(limit); err == nil {
+			cfg.RPCWalletRateLimit = rate
+		}
+	}
+
 	if p2pPort := os.Getenv("P2P_PORT"); p2pPort != "" {
 		if port, err := strconv.Atoi(p2pPort); err == nil {
 			cfg.P2PPort = port
 		}
 	}
 
+	if listenAddrs := os.Getenv("LISTEN_ADDRS"); listenAddrs != "" {
+		cfg.ListenAddrs = strings.Split(listenAddrs, ",")
+	}
+
+	if noListen := os.Getenv("NO_LISTEN"); noListen != "" {
+		cfg.NoListen = strings.ToLower(noListen) == "true"
+	}
+
+	if externalIP := os.Getenv("EXTERNAL_IP"); externalIP != "" {
+		cfg.ExternalIP = externalIP
+	}
+
 	if peers := os.Getenv("INITIAL_PEERS"); peers != "" {
 		cfg.InitialPeers = strings.Split(peers, ",")
 	}
 
+	if seeds := os.Getenv("DNS_SEEDS"); seeds != "" {
+		cfg.DNSSeeds = strings.Split(seeds, ",")
+	}
+
+	if enableNAT := os.Getenv("ENABLE_NAT"); enableNAT != "" {
+		cfg.EnableNAT = strings.ToLower(enableNAT) == "true"
+	}
+
+	if v2 := os.Getenv("V2_TRANSPORT"); v2 != "" {
+		cfg.V2Transport = strings.ToLower(v2) == "true"
+	}
+
+	if dandelion := os.Getenv("DANDELION"); dandelion != "" {
+		cfg.Dandelion = strings.ToLower(dandelion) == "true"
+	}
+
 	// Storage
+	if electrumAddr := os.Getenv("ELECTRUM_ADDR"); electrumAddr != "" {
+		cfg.ElectrumAddr = electrumAddr
+	}
+
+	if restAddr := os.Getenv("REST_ADDR"); restAddr != "" {
+		cfg.RESTAddr = restAddr
+	}
+
 	if dataDir := os.Getenv("DATA_DIR"); dataDir != "" {
 		cfg.DataDir = dataDir
 	}
 
+	if nullDataIndex := os.Getenv("NULLDATA_INDEX"); nullDataIndex != "" {
+		cfg.NullDataIndex = strings.ToLower(nullDataIndex) == "true"
+	}
+
+	if spentIndex := os.Getenv("SPENT_INDEX"); spentIndex != "" {
+		cfg.SpentIndex = strings.ToLower(spentIndex) == "true"
+	}
+
+	if addressIndex := os.Getenv("ADDRESS_INDEX"); addressIndex != "" {
+		cfg.AddressIndex = strings.ToLower(addressIndex) == "true"
+	}
+
+	if flushInterval := os.Getenv("UTXO_FLUSH_INTERVAL"); flushInterval != "" {
+		if interval, err := strconv.Atoi(flushInterval); err == nil {
+			cfg.UTXOFlushInterval = time.Duration(interval) * time.Second
+		}
+	}
+
+	// Wallet
+	if walletRBF := os.Getenv("WALLET_RBF"); walletRBF != "" {
+		cfg.WalletRBF = strings.ToLower(walletRBF) == "true"
+	}
+
+	if backupInterval := os.Getenv("WALLET_BACKUP_INTERVAL"); backupInterval != "" {
+		if interval, err := strconv.Atoi(backupInterval); err == nil {
+			cfg.WalletBackupInterval = time.Duration(interval) * time.Second
+		}
+	}
+
+	if backupKeep := os.Getenv("WALLET_BACKUP_KEEP"); backupKeep != "" {
+		if keep, err := strconv.Atoi(backupKeep); err == nil {
+			cfg.WalletBackupKeep = keep
+		}
+	}
+
+	// Mempool policy
+	if minRelayFee := os.Getenv("MIN_RELAY_TX_FEE"); minRelayFee != "" {
+		if rate, err := strconv.ParseInt(minRelayFee, 10, 64); err == nil {
+			cfg.MinRelayTxFee = rate
+		}
+	}
+
+	if incrementalFee := os.Getenv("INCREMENTAL_RELAY_FEE"); incrementalFee != "" {
+		if rate, err := strconv.ParseInt(incrementalFee, 10, 64); err == nil {
+			cfg.IncrementalRelayFee = rate
+		}
+	}
+
+	// Validation
+	if assumeValid := os.Getenv("ASSUME_VALID"); assumeValid != "" {
+		cfg.AssumeValid = assumeValid
+	}
+
+	if challenge := os.Getenv("SIGNET_CHALLENGE"); challenge != "" {
+		cfg.SignetChallenge = challenge
+	}
+
+	if checkBlocks := os.Getenv("CHECK_BLOCKS"); checkBlocks != "" {
+		if blocks, err := strconv.Atoi(checkBlocks); err == nil {
+			cfg.CheckBlocks = blocks
+		}
+	}
+
+	if checkLevel := os.Getenv("CHECK_LEVEL"); checkLevel != "" {
+		if level, err := strconv.Atoi(checkLevel); err == nil {
+			cfg.CheckLevel = level
+		}
+	}
+
 	// Mining Configuration
 	if miningEnabled := os.Getenv("MINING_ENABLED"); miningEnabled != "" {
 		cfg.MiningEnabled = strings.ToLower(miningEnabled) == "true"
@@ -107,16 +284,33 @@ func LoadFromEnv() *NodeConfig {
 		}
 	}
 
+	if order := os.Getenv("BLOCK_ORDER"); order != "" {
+		cfg.BlockOrder = strings.ToLower(order)
+	}
+
 	// Logging
 	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
 		cfg.LogLevel = logLevel
 	}
 
+	// Process
+	if daemon := os.Getenv("DAEMON"); daemon != "" {
+		cfg.Daemon = strings.ToLower(daemon) == "true"
+	}
+
+	if pidFile := os.Getenv("PID_FILE"); pidFile != "" {
+		cfg.PIDFile = pidFile
+	}
+
 	// Monitoring
 	if enableMonitoring := os.Getenv("ENABLE_MONITORING"); enableMonitoring != "" {
 		cfg.EnableMonitoring = strings.ToLower(enableMonitoring) == "true"
 	}
 
+	if debugAddr := os.Getenv("DEBUG_ADDR"); debugAddr != "" {
+		cfg.DebugAddr = debugAddr
+	}
+
 	return cfg
 }
 
@@ -126,10 +320,11 @@ func (c *NodeConfig) Validate() error {
 	validNetworks := map[string]bool{
 		"mainnet": true,
 		"testnet": true,
+		"signet":  true,
 		"regtest": true,
 	}
 	if !validNetworks[c.Network] {
-		return fmt.Errorf("invalid network: %s (must be mainnet, testnet, or regtest)", c.Network)
+		return fmt.Errorf("invalid network: %s (must be mainnet, testnet, signet, or regtest)", c.Network)
 	}
 
 	// Validate ports
@@ -140,15 +335,82 @@ func (c *NodeConfig) Validate() error {
 		return fmt.Errorf("invalid P2P port: %d", c.P2PPort)
 	}
 
+	// Validate listen and external addresses
+	for _, addr := range c.ListenAddrs {
+		if _, _, err := net.SplitHostPort(c.withP2PPort(addr)); err != nil {
+			return fmt.Errorf("invalid listen address %q: %w", addr, err)
+		}
+	}
+	if c.ExternalIP != "" {
+		host, _, err := net.SplitHostPort(c.GetExternalAddr())
+		if err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid external IP: %s", c.ExternalIP)
+		}
+	}
+
+	// Validate RPC rate limits
+	if c.RPCRateLimit < 0 || c.RPCWalletRateLimit < 0 {
+		return fmt.Errorf("RPC rate limits cannot be negative")
+	}
+
 	// Validate data directory
 	if c.DataDir == "" {
 		return fmt.Errorf("data directory cannot be empty")
 	}
 
+	// Validate UTXO set flushing
+	if c.UTXOFlushInterval < 0 {
+		return fmt.Errorf("UTXO flush interval cannot be negative")
+	}
+
+	// Validate wallet backups
+	if c.WalletBackupInterval < 0 {
+		return fmt.Errorf("wallet backup interval cannot be negative")
+	}
+	if c.WalletBackupInterval > 0 && c.WalletBackupKeep < 1 {
+		return fmt.Errorf("wallet backup keep must be at least 1, got %d", c.WalletBackupKeep)
+	}
+
+	// Validate relay fees
+	if c.MinRelayTxFee < 0 {
+		return fmt.Errorf("invalid min relay tx fee %d sat/kvB", c.MinRelayTxFee)
+	}
+	if c.IncrementalRelayFee < 0 {
+		return fmt.Errorf("invalid incremental relay fee %d sat/kvB", c.IncrementalRelayFee)
+	}
+
+	// Validate assumevalid block hash
+	if c.AssumeValid != "" && c.AssumeValid != "0" {
+		if b, err := hex.DecodeString(c.AssumeValid); err != nil || len(b) != 32 {
+			return fmt.Errorf("invalid assumevalid block hash: %s", c.AssumeValid)
+		}
+	}
+
+	// Validate signet challenge
+	if c.SignetChallenge != "" {
+		if c.Network != "signet" {
+			return fmt.Errorf("signet challenge set on %s", c.Network)
+		}
+		if b, err := hex.DecodeString(c.SignetChallenge); err != nil || len(b) == 0 {
+			return fmt.Errorf("invalid signet challenge: %s", c.SignetChallenge)
+		}
+	}
+
+	// Validate startup verification
+	if c.CheckBlocks < -1 {
+		return fmt.Errorf("invalid check blocks %d, use -1 to skip verification", c.CheckBlocks)
+	}
+	if c.CheckLevel < 0 || c.CheckLevel > 4 {
+		return fmt.Errorf("invalid check level %d (must be 0 to 4)", c.CheckLevel)
+	}
+
 	// Validate mining configuration
 	if c.MiningEnabled && c.MinerAddress == "" {
 		return fmt.Errorf("miner address required when mining is enabled")
 	}
+	if c.BlockOrder != "feerate" && c.BlockOrder != "priority" {
+		return fmt.Errorf("invalid block order %q, use feerate or priority", c.BlockOrder)
+	}
 
 	// Validate log level
 	validLogLevels := map[string]bool{
@@ -171,29 +433,118 @@ func (c *NodeConfig) String() string {
   Network:          %s
   RPC Port:         %d
   P2P Port:         %d
+  P2P Listen:       %v
+  External Address: %s
   Data Directory:   %s
+  OP_RETURN Index:  %v
+  Spent Index:      %v
+  Address Index:    %v
+  UTXO Flush:       %v
+  Wallet RBF:       %v
+  Wallet Backups:   %v (keep %d)
+  Min Relay Fee:    %d sat/kvB
+  Incremental Fee:  %d sat/kvB
+  Assume Valid:     %s
+  Check Blocks:     %d (level %d)
   Mining Enabled:   %v
   Miner Address:    %s
   Auto Mine:        %v
   Mine Interval:    %v
+  Block Order:      %s
   Log Level:        %s
+  Daemon:           %v
+  PID File:         %s
   Initial Peers:    %v
-  Enable Monitoring: %v`,
+  DNS Seeds:        %v
+  Port Mapping:     %v
+  V2 Transport:     %v
+  Dandelion:        %v
+  Electrum Server:  %s
+  REST Interface:   %s
+  Enable Monitoring: %v
+  Debug Listener:   %s`,
 		c.NodeID,
 		c.Network,
 		c.RPCPort,
 		c.P2PPort,
+		c.GetP2PListenAddrs(),
+		c.GetExternalAddr(),
 		c.DataDir,
+		c.NullDataIndex,
+		c.SpentIndex,
+		c.AddressIndex,
+		c.UTXOFlushInterval,
+		c.WalletRBF,
+		c.WalletBackupInterval,
+		c.WalletBackupKeep,
+		c.MinRelayTxFee,
+		c.IncrementalRelayFee,
+		c.AssumeValid,
+		c.CheckBlocks,
+		c.CheckLevel,
 		c.MiningEnabled,
 		c.MinerAddress,
 		c.AutoMine,
 		c.MineInterval,
+		c.BlockOrder,
 		c.LogLevel,
+		c.Daemon,
+		c.GetPIDFile(),
 		c.InitialPeers,
+		c.DNSSeeds,
+		c.EnableNAT,
+		c.V2Transport,
+		c.Dandelion,
+		c.ElectrumAddr,
+		c.RESTAddr,
 		c.EnableMonitoring,
+		c.DebugAddr,
 	)
 }
 
+// GetP2PListenAddrs returns every P2P listen address, the P2P port on all
+// interfaces first. It is empty with NoListen.
+func (c *NodeConfig) GetP2PListenAddrs() []string {
+	if c.NoListen {
+		return nil
+	}
+	addrs := []string{c.GetP2PAddress()}
+	for _, addr := range c.ListenAddrs {
+		addrs = append(addrs, c.withP2PPort(strings.TrimSpace(addr)))
+	}
+	return addrs
+}
+
+// GetExternalAddr returns ExternalIP with the P2P port added if it has
+// none, or "" when unset
+func (c *NodeConfig) GetExternalAddr() string {
+	if c.ExternalIP == "" {
+		return ""
+	}
+	return c.withP2PPort(c.ExternalIP)
+}
+
+// withP2PPort adds the P2P port to an address that has none
+func (c *NodeConfig) withP2PPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), strconv.Itoa(c.P2PPort))
+}
+
+// GetPIDFile returns the process ID file path
+func (c *NodeConfig) GetPIDFile() string {
+	if c.PIDFile != "" {
+		return c.PIDFile
+	}
+	return filepath.Join(c.DataDir, PIDFileName)
+}
+
+// GetLogFile returns the log file written in daemon mode
+func (c *NodeConfig) GetLogFile() string {
+	return filepath.Join(c.DataDir, LogFileName)
+}
+
 // GetRPCAddress returns the full RPC address
 func (c *NodeConfig) GetRPCAddress() string {
 	return fmt.Sprintf(":%d", c.RPCPort)
@@ -0,0 +1,76 @@
+package consensus
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// DifficultyOneBits is the compact target of difficulty 1, the easiest
+// target mainnet ever allowed
+const DifficultyOneBits = 0x1d00ffff
+
+// GetDifficulty returns how many times harder the target bits is to meet
+// than difficulty 1. Regtest targets are easier, so their difficulty is
+// below 1.
+func GetDifficulty(bits uint32) float64 {
+	target := CompactToTarget(bits)
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	ratio := new(big.Float).Quo(
+		new(big.Float).SetInt(CompactToTarget(DifficultyOneBits)),
+		new(big.Float).SetInt(target),
+	)
+	difficulty, _ := ratio.Float64()
+	return difficulty
+}
+
+// EstimateHashRate estimates the hashes per second that produced headers,
+// which must be consecutive and oldest first: the work of every block after
+// the first divided by the time they took. It returns 0 when fewer than two
+// headers are given or their timestamps don't move forward.
+func EstimateHashRate(headers []types.BlockHeader) float64 {
+	if len(headers) < 2 {
+		return 0
+	}
+
+	// Timestamps are only loosely ordered, so take the span between the
+	// earliest and latest rather than the first and last
+	minTime, maxTime := headers[0].Timestamp, headers[0].Timestamp
+	work := new(big.Int)
+	for i, header := range headers {
+		minTime = min(minTime, header.Timestamp)
+		maxTime = max(maxTime, header.Timestamp)
+		if i > 0 {
+			work.Add(work, CalcWork(header.Bits))
+		}
+	}
+	if maxTime == minTime {
+		return 0
+	}
+
+	rate, _ := new(big.Float).Quo(new(big.Float).SetInt(work), big.NewFloat(float64(maxTime-minTime))).Float64()
+	return rate
+}
+
+// NetworkHashRate estimates the hash rate over the nblocks blocks ending at
+// height. The block before the window is included, because its timestamp
+// starts the first interval.
+func NetworkHashRate(chain HeaderSource, height, nblocks uint64) (float64, error) {
+	start := uint64(0)
+	if height > nblocks {
+		start = height - nblocks
+	}
+	headers := make([]types.BlockHeader, 0, height-start+1)
+	for h := start; h <= height; h++ {
+		header, err := chain.HeaderAt(h)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get header %d: %w", h, err)
+		}
+		headers = append(headers, *header)
+	}
+	return EstimateHashRate(headers), nil
+}
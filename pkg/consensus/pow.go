@@ -0,0 +1,111 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+const (
+	// RegtestPowLimitBits is regtest's proof-of-work limit. Its target
+	// is half the hash space, so about every other nonce meets it.
+	RegtestPowLimitBits = 0x207fffff
+
+	// SignetPowLimitBits is signet's proof-of-work limit, low enough for
+	// a single CPU to keep up with the block interval
+	SignetPowLimitBits = 0x1e0377ae
+)
+
+// ErrHighHash is returned for a block hash above its target
+var ErrHighHash = errors.New("block hash does not meet its target")
+
+// TargetToCompact encodes a target as compact nBits, the inverse of
+// CompactToTarget up to the precision nBits can hold
+func TargetToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	size := uint32(len(target.Bytes()))
+	var mantissa uint32
+	if size <= 3 {
+		mantissa = uint32(target.Uint64() << (8 * (3 - size)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, uint(8*(size-3))).Uint64())
+	}
+
+	// The mantissa's top bit is the sign, so shift a set one out
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		size++
+	}
+	return size<<24 | mantissa
+}
+
+// HashToBig interprets a block hash as a number for comparing it with a
+// target. Hashes are stored in display order, so the first byte is the
+// most significant.
+func HashToBig(hash types.Hash) *big.Int {
+	return new(big.Int).SetBytes(hash[:])
+}
+
+// CheckProofOfWork checks that bits is a target the network allows and
+// that hash meets it
+func (cr *ConsensusRules) CheckProofOfWork(hash types.Hash, bits uint32) error {
+	target := CompactToTarget(bits)
+	if target.Sign() <= 0 {
+		return fmt.Errorf("invalid target bits %08x", bits)
+	}
+	if target.Cmp(CompactToTarget(cr.PowLimitBits)) > 0 {
+		return fmt.Errorf("target bits %08x are easier than the limit %08x", bits, cr.PowLimitBits)
+	}
+	if HashToBig(hash).Cmp(target) > 0 {
+		return fmt.Errorf("%w: %s above %064x", ErrHighHash, hash, target)
+	}
+	return nil
+}
+
+// DifficultyAdjustmentInterval is the number of blocks between retargets
+func (cr *ConsensusRules) DifficultyAdjustmentInterval() uint64 {
+	return uint64(cr.PowTargetTimespan / cr.PowTargetSpacing)
+}
+
+// NextWorkRequired returns the target bits a block at height must meet.
+// The target only changes on the first block of an adjustment interval,
+// scaled by how long the previous interval took compared with
+// PowTargetTimespan, at most by a factor of four either way. Networks
+// without retargeting mine every block at the limit.
+func (cr *ConsensusRules) NextWorkRequired(chain HeaderSource, height uint64) (uint32, error) {
+	if height == 0 || cr.PowNoRetargeting {
+		return cr.PowLimitBits, nil
+	}
+	prev, err := chain.HeaderAt(height - 1)
+	if err != nil {
+		return 0, err
+	}
+
+	interval := cr.DifficultyAdjustmentInterval()
+	if height%interval != 0 {
+		return prev.Bits, nil
+	}
+
+	first, err := chain.HeaderAt(height - interval)
+	if err != nil {
+		return 0, err
+	}
+
+	// Timestamps aren't ordered, so the span can even be negative
+	timespan := int64(prev.Timestamp) - int64(first.Timestamp)
+	targetTimespan := int64(cr.PowTargetTimespan.Seconds())
+	timespan = min(max(timespan, targetTimespan/4), targetTimespan*4)
+
+	target := CompactToTarget(prev.Bits)
+	target.Mul(target, big.NewInt(timespan))
+	target.Div(target, big.NewInt(targetTimespan))
+	if limit := CompactToTarget(cr.PowLimitBits); target.Cmp(limit) > 0 {
+		target = limit
+	}
+	return TargetToCompact(target), nil
+}
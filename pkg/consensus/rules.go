@@ -1,7 +1,9 @@
 package consensus
 
 import (
+	"encoding/hex"
 	"fmt"
+	"math/big"
 	"time"
 
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
@@ -19,12 +21,67 @@ type ConsensusRules struct {
 	MaxFutureBlockTime time.Duration
 	MedianTimeSpan     int
 
-	// BIP activation heights
+	// Proof of work. PowLimitBits is the easiest target allowed. The
+	// target is adjusted every PowTargetTimespan / PowTargetSpacing
+	// blocks, or fixed at the limit if PowNoRetargeting is set.
+	PowLimitBits      uint32
+	PowTargetTimespan time.Duration
+	PowTargetSpacing  time.Duration
+	PowNoRetargeting  bool
+
+	// Buried BIP activation heights. These soft forks activated long ago
+	// and are enforced by height rather than by versionbits state.
 	BIP16Height  uint64
 	BIP34Height  uint64
 	BIP65Height  uint64
 	BIP66Height  uint64
 	SegWitHeight uint64
+
+	// BIP30Exceptions are blocks whose coinbases duplicated an earlier,
+	// still unspent one before BIP30 was enforced
+	BIP30Exceptions []Checkpoint
+
+	// Versionbits (BIP9): a deployment locks in when
+	// RuleChangeActivationThreshold blocks of a MinerConfirmationWindow
+	// signal for it
+	MinerConfirmationWindow       uint64
+	RuleChangeActivationThreshold uint64
+	Deployments                   []Deployment
+
+	// MinimumChainWork is the least cumulative work a header chain must
+	// show before we spend bandwidth downloading its blocks
+	MinimumChainWork *big.Int
+
+	// AssumeValid is a block whose ancestors are assumed to have valid
+	// scripts, so their signatures aren't checked again. Zero checks
+	// every script.
+	AssumeValid types.Hash
+
+	// SignetChallenge is the script every block's signet solution must
+	// satisfy (BIP325). It is nil except on signet.
+	SignetChallenge []byte
+}
+
+// mustParseWork parses a hex chain work constant
+func mustParseWork(hex string) *big.Int {
+	work, ok := new(big.Int).SetString(hex, 16)
+	if !ok {
+		panic("invalid chain work constant: " + hex)
+	}
+	return work
+}
+
+// DefaultSignetChallenge is the block challenge of the public signet: a
+// 1-of-2 bare multisig
+var DefaultSignetChallenge = mustParseHex("512103ad5e0edad18cb1f0fc0d28a3d4f1f3e445640337489abb10404f2d1e086be430210359ef5021964fe22d6f8e05b2463c9540ce96883fe3b278760f048f5189f2e6c452ae")
+
+// mustParseHex decodes a hex constant
+func mustParseHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex constant: " + s)
+	}
+	return b
 }
 
 // NewMainnetRules returns consensus rules for mainnet
@@ -36,11 +93,27 @@ func NewMainnetRules() *ConsensusRules {
 		SubsidyHalvingInterval: 210000,
 		MaxFutureBlockTime:     2 * time.Hour,
 		MedianTimeSpan:         11,
+		PowLimitBits:           DifficultyOneBits,
+		PowTargetTimespan:      14 * 24 * time.Hour,
+		PowTargetSpacing:       10 * time.Minute,
 		BIP16Height:            173805,
 		BIP34Height:            227931,
 		BIP65Height:            388381,
 		BIP66Height:            363725,
 		SegWitHeight:           481824,
+		BIP30Exceptions: []Checkpoint{
+			{Height: 91842, Hash: hashFromString("00000000000a4d0a398161ffc163c503763b1f4360639393e0e4c8e300e0caec")},
+			{Height: 91880, Hash: hashFromString("00000000000743f190a18c5577a3c2d2a1f610ae9601ac046a38084ccb7cd721")},
+		},
+
+		MinerConfirmationWindow:       2016,
+		RuleChangeActivationThreshold: 1815, // 90%
+		Deployments: []Deployment{
+			{Name: "testdummy", Bit: 28, StartTime: NeverActive, Timeout: NoTimeout},
+			{Name: "taproot", Bit: 2, StartTime: 1619222400, Timeout: 1628640000, MinActivationHeight: 709632},
+		},
+
+		MinimumChainWork: mustParseWork("00000000000000000000000000000000000000001533efd8d716a517fe2c5008"),
 	}
 }
 
@@ -53,15 +126,29 @@ func NewTestnetRules() *ConsensusRules {
 		SubsidyHalvingInterval: 210000,
 		MaxFutureBlockTime:     2 * time.Hour,
 		MedianTimeSpan:         11,
+		PowLimitBits:           DifficultyOneBits,
+		PowTargetTimespan:      14 * 24 * time.Hour,
+		PowTargetSpacing:       10 * time.Minute,
 		BIP16Height:            0,
 		BIP34Height:            0,
 		BIP65Height:            0,
 		BIP66Height:            0,
 		SegWitHeight:           0,
+
+		MinerConfirmationWindow:       2016,
+		RuleChangeActivationThreshold: 1512, // 75%
+		Deployments: []Deployment{
+			{Name: "testdummy", Bit: 28, StartTime: NeverActive, Timeout: NoTimeout},
+			{Name: "taproot", Bit: 2, StartTime: 1619222400, Timeout: 1628640000},
+		},
+
+		MinimumChainWork: mustParseWork("0000000000000000000000000000000000000000000001db6ec4ac88cf2272c6"),
 	}
 }
 
-// NewRegtestRules returns consensus rules for regtest
+// NewRegtestRules returns consensus rules for regtest. Its trivial
+// proof-of-work limit and fixed difficulty let tests mine blocks
+// instantly.
 func NewRegtestRules() *ConsensusRules {
 	return &ConsensusRules{
 		MaxBlockSize:           1000000,
@@ -70,14 +157,108 @@ func NewRegtestRules() *ConsensusRules {
 		SubsidyHalvingInterval: 150,
 		MaxFutureBlockTime:     2 * time.Hour,
 		MedianTimeSpan:         11,
+		PowLimitBits:           RegtestPowLimitBits,
+		PowTargetTimespan:      14 * 24 * time.Hour,
+		PowTargetSpacing:       10 * time.Minute,
+		PowNoRetargeting:       true,
+		BIP16Height:            0,
+		BIP34Height:            0,
+		BIP65Height:            0,
+		BIP66Height:            0,
+		SegWitHeight:           0,
+
+		MinerConfirmationWindow:       144,
+		RuleChangeActivationThreshold: 108, // 75%
+		Deployments: []Deployment{
+			{Name: "testdummy", Bit: 28, StartTime: 0, Timeout: NoTimeout},
+			{Name: "taproot", Bit: 2, StartTime: AlwaysActive, Timeout: NoTimeout},
+		},
+
+		MinimumChainWork: big.NewInt(0),
+	}
+}
+
+// NewSignetRules returns consensus rules for a signet whose blocks must
+// solve challenge. Signet retargets like testnet, but only the holders of
+// the challenge's keys can produce blocks.
+func NewSignetRules(challenge []byte) *ConsensusRules {
+	return &ConsensusRules{
+		MaxBlockSize:           1000000,
+		MaxBlockWeight:         4000000,
+		CoinbaseMaturity:       100,
+		SubsidyHalvingInterval: 210000,
+		MaxFutureBlockTime:     2 * time.Hour,
+		MedianTimeSpan:         11,
+		PowLimitBits:           SignetPowLimitBits,
+		PowTargetTimespan:      14 * 24 * time.Hour,
+		PowTargetSpacing:       10 * time.Minute,
 		BIP16Height:            0,
 		BIP34Height:            0,
 		BIP65Height:            0,
 		BIP66Height:            0,
 		SegWitHeight:           0,
+
+		MinerConfirmationWindow:       2016,
+		RuleChangeActivationThreshold: 1815, // 90%
+		Deployments: []Deployment{
+			{Name: "testdummy", Bit: 28, StartTime: NeverActive, Timeout: NoTimeout},
+			{Name: "taproot", Bit: 2, StartTime: AlwaysActive, Timeout: NoTimeout},
+		},
+
+		MinimumChainWork: big.NewInt(0),
+		SignetChallenge:  append([]byte(nil), challenge...),
 	}
 }
 
+// NewRulesForNetwork returns the consensus rules for a network name
+// (mainnet, testnet, signet or regtest). Signet uses the default
+// challenge.
+func NewRulesForNetwork(network string) (*ConsensusRules, error) {
+	switch network {
+	case "mainnet":
+		return NewMainnetRules(), nil
+	case "testnet":
+		return NewTestnetRules(), nil
+	case "signet":
+		return NewSignetRules(DefaultSignetChallenge), nil
+	case "regtest":
+		return NewRegtestRules(), nil
+	default:
+		return nil, fmt.Errorf("unknown network: %s", network)
+	}
+}
+
+// CompactToTarget expands a compact nBits target. Negative targets, which
+// can never be met, come back as zero.
+func CompactToTarget(bits uint32) *big.Int {
+	exponent := bits >> 24
+	mantissa := big.NewInt(int64(bits & 0x007fffff))
+
+	target := new(big.Int)
+	if exponent <= 3 {
+		target.Rsh(mantissa, uint(8*(3-exponent)))
+	} else {
+		target.Lsh(mantissa, uint(8*(exponent-3)))
+	}
+
+	if bits&0x00800000 != 0 {
+		return target.SetInt64(0)
+	}
+	return target
+}
+
+// CalcWork returns the expected number of hashes needed to find a block
+// with the given compact target: 2^256 / (target + 1)
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToTarget(bits)
+	if target.Sign() == 0 {
+		return big.NewInt(0)
+	}
+
+	numerator := new(big.Int).Lsh(big.NewInt(1), 256)
+	return numerator.Div(numerator, target.Add(target, big.NewInt(1)))
+}
+
 // ValidateBlockTime validates block timestamp against consensus rules
 func (cr *ConsensusRules) ValidateBlockTime(blockTime uint32, medianTimePast uint32, currentTime time.Time) error {
 	// Block time must be greater than median time of past blocks
@@ -114,6 +295,17 @@ func (cr *ConsensusRules) ValidateBlockSize(blockSize uint32, height uint64) err
 	return nil
 }
 
+// IsBIP30Exception reports whether a block is allowed to overwrite unspent
+// outputs of an earlier transaction with the same txid
+func (cr *ConsensusRules) IsBIP30Exception(height uint64, hash types.Hash) bool {
+	for _, exception := range cr.BIP30Exceptions {
+		if exception.Height == height && exception.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
 // IsBIP34Active checks if BIP34 is active at given height
 func (cr *ConsensusRules) IsBIP34Active(height uint64) bool {
 	return height >= cr.BIP34Height
@@ -0,0 +1,345 @@
+package consensus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Versionbits (BIP9) block version layout
+const (
+	VersionBitsTopBits = 0x20000000 // Top three bits of a signalling version
+	VersionBitsTopMask = 0xE0000000 // Mask selecting the top three bits
+	VersionBitsNumBits = 29         // Bits available for deployments
+)
+
+// Special deployment start times
+const (
+	AlwaysActive int64 = -1 // Deployment is active from genesis
+	NeverActive  int64 = -2 // Deployment can never activate
+)
+
+// NoTimeout is a deployment timeout that is never reached
+const NoTimeout int64 = math.MaxInt64
+
+// ThresholdState is the versionbits state of a deployment for a block
+type ThresholdState int
+
+const (
+	// ThresholdDefined is the state before the start time
+	ThresholdDefined ThresholdState = iota
+	// ThresholdStarted means blocks are counted for signals
+	ThresholdStarted
+	// ThresholdLockedIn means the threshold was reached and the deployment
+	// activates once the period ends and the minimum height is reached
+	ThresholdLockedIn
+	// ThresholdActive means the new rules are enforced
+	ThresholdActive
+	// ThresholdFailed means the timeout passed without lock-in
+	ThresholdFailed
+)
+
+// String returns the state name as reported by getdeploymentinfo
+func (s ThresholdState) String() string {
+	switch s {
+	case ThresholdDefined:
+		return "defined"
+	case ThresholdStarted:
+		return "started"
+	case ThresholdLockedIn:
+		return "locked_in"
+	case ThresholdActive:
+		return "active"
+	case ThresholdFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// Deployment describes a soft fork activated by versionbits signalling
+type Deployment struct {
+	Name                string
+	Bit                 uint8
+	StartTime           int64  // Median time past signalling starts at, or AlwaysActive/NeverActive
+	Timeout             int64  // Median time past the deployment fails at without lock-in
+	MinActivationHeight uint64 // Earliest height a locked-in deployment becomes active
+}
+
+// Signals reports whether a block version signals for the deployment
+func (d *Deployment) Signals(version int32) bool {
+	v := uint32(version)
+	return v&VersionBitsTopMask == VersionBitsTopBits && v&(1<<d.Bit) != 0
+}
+
+// DeploymentStats is the signalling within one confirmation window
+type DeploymentStats struct {
+	Period    uint64 `json:"period"`
+	Threshold uint64 `json:"threshold"`
+	Elapsed   uint64 `json:"elapsed"`
+	Count     uint64 `json:"count"`
+	Possible  bool   `json:"possible"` // Whether the threshold can still be reached
+}
+
+// HeaderSource gives versionbits read access to the headers of a chain
+type HeaderSource interface {
+	HeaderAt(height uint64) (*types.BlockHeader, error)
+}
+
+// periodKey identifies the last block of a confirmation window. The
+// header is part of the key so that cached states don't survive a reorg.
+type periodKey struct {
+	height uint64
+	header types.BlockHeader
+}
+
+// VersionBits computes deployment states from block version signalling.
+// States only change at window boundaries and are cached per boundary.
+type VersionBits struct {
+	rules *ConsensusRules
+	cache map[string]map[periodKey]ThresholdState
+	mu    sync.Mutex
+}
+
+// NewVersionBits creates a state tracker for the deployments in rules
+func NewVersionBits(rules *ConsensusRules) *VersionBits {
+	return &VersionBits{
+		rules: rules,
+		cache: make(map[string]map[periodKey]ThresholdState),
+	}
+}
+
+// Deployment looks up a versionbits deployment by name
+func (cr *ConsensusRules) Deployment(name string) (*Deployment, error) {
+	for i := range cr.Deployments {
+		if cr.Deployments[i].Name == name {
+			return &cr.Deployments[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown deployment: %s", name)
+}
+
+// State returns the state of a deployment for the block at height
+func (vb *VersionBits) State(chain HeaderSource, name string, height uint64) (ThresholdState, error) {
+	d, err := vb.rules.Deployment(name)
+	if err != nil {
+		return ThresholdDefined, err
+	}
+
+	vb.mu.Lock()
+	defer vb.mu.Unlock()
+	return vb.state(chain, d, height)
+}
+
+// IsActive reports whether a deployment's rules apply to the block at height
+func (vb *VersionBits) IsActive(chain HeaderSource, name string, height uint64) (bool, error) {
+	state, err := vb.State(chain, name, height)
+	return state == ThresholdActive, err
+}
+
+// StateSince returns the first height of the window in which the
+// deployment entered its state for the block at height
+func (vb *VersionBits) StateSince(chain HeaderSource, name string, height uint64) (uint64, error) {
+	d, err := vb.rules.Deployment(name)
+	if err != nil {
+		return 0, err
+	}
+
+	vb.mu.Lock()
+	defer vb.mu.Unlock()
+
+	if d.StartTime == AlwaysActive || d.StartTime == NeverActive {
+		return 0, nil
+	}
+
+	state, err := vb.state(chain, d, height)
+	if err != nil || state == ThresholdDefined {
+		return 0, err
+	}
+
+	period := vb.rules.MinerConfirmationWindow
+	since := height - height%period
+	for since >= period {
+		prev, err := vb.state(chain, d, since-period)
+		if err != nil {
+			return 0, err
+		}
+		if prev != state {
+			break
+		}
+		since -= period
+	}
+	return since, nil
+}
+
+// Statistics counts the blocks signalling for a deployment in the window
+// containing height, up to and including height
+func (vb *VersionBits) Statistics(chain HeaderSource, name string, height uint64) (*DeploymentStats, error) {
+	d, err := vb.rules.Deployment(name)
+	if err != nil {
+		return nil, err
+	}
+
+	period := vb.rules.MinerConfirmationWindow
+	start := height - height%period
+	count, err := countSignals(chain, d, start, height)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &DeploymentStats{
+		Period:    period,
+		Threshold: vb.rules.RuleChangeActivationThreshold,
+		Elapsed:   height - start + 1,
+		Count:     count,
+	}
+	stats.Possible = stats.Period-stats.Threshold >= stats.Elapsed-stats.Count
+	return stats, nil
+}
+
+// ComputeBlockVersion returns the version a miner should use for the block
+// at height: the versionbits top bits plus the bit of every deployment
+// that is started or locked in
+func (vb *VersionBits) ComputeBlockVersion(chain HeaderSource, height uint64) (int32, error) {
+	vb.mu.Lock()
+	defer vb.mu.Unlock()
+
+	version := uint32(VersionBitsTopBits)
+	for i := range vb.rules.Deployments {
+		d := &vb.rules.Deployments[i]
+		state, err := vb.state(chain, d, height)
+		if err != nil {
+			return 0, err
+		}
+		if state == ThresholdStarted || state == ThresholdLockedIn {
+			version |= 1 << d.Bit
+		}
+	}
+	return int32(version), nil
+}
+
+// state runs the state machine up to the window containing height. The
+// caller must hold vb.mu.
+func (vb *VersionBits) state(chain HeaderSource, d *Deployment, height uint64) (ThresholdState, error) {
+	switch d.StartTime {
+	case AlwaysActive:
+		return ThresholdActive, nil
+	case NeverActive:
+		return ThresholdFailed, nil
+	}
+
+	// Every block of a window shares the state decided at the end of the
+	// previous window, and the first window is always DEFINED
+	period := vb.rules.MinerConfirmationWindow
+	if height < period {
+		return ThresholdDefined, nil
+	}
+
+	cache, ok := vb.cache[d.Name]
+	if !ok {
+		cache = make(map[periodKey]ThresholdState)
+		vb.cache[d.Name] = cache
+	}
+
+	// Walk back to a known state, remembering the boundaries to replay
+	type boundary struct {
+		key periodKey
+		mtp int64
+	}
+	var pending []boundary
+	state := ThresholdDefined
+	last := height - height%period - 1
+	for {
+		header, err := chain.HeaderAt(last)
+		if err != nil {
+			return ThresholdDefined, fmt.Errorf("failed to get header at %d: %w", last, err)
+		}
+		key := periodKey{height: last, header: *header}
+		if cached, ok := cache[key]; ok {
+			state = cached
+			break
+		}
+
+		mtp, err := vb.medianTimePast(chain, last)
+		if err != nil {
+			return ThresholdDefined, err
+		}
+		if mtp < d.StartTime {
+			cache[key] = ThresholdDefined
+			break
+		}
+
+		pending = append(pending, boundary{key: key, mtp: mtp})
+		if last < period {
+			break
+		}
+		last -= period
+	}
+
+	for i := len(pending) - 1; i >= 0; i-- {
+		b := pending[i]
+		switch state {
+		case ThresholdDefined:
+			if b.mtp >= d.StartTime {
+				state = ThresholdStarted
+			}
+		case ThresholdStarted:
+			count, err := countSignals(chain, d, b.key.height+1-period, b.key.height)
+			if err != nil {
+				return ThresholdDefined, err
+			}
+			if count >= vb.rules.RuleChangeActivationThreshold {
+				state = ThresholdLockedIn
+			} else if b.mtp >= d.Timeout {
+				state = ThresholdFailed
+			}
+		case ThresholdLockedIn:
+			if b.key.height+1 >= d.MinActivationHeight {
+				state = ThresholdActive
+			}
+		}
+		cache[b.key] = state
+	}
+
+	return state, nil
+}
+
+// medianTimePast returns the median timestamp of the block at height and
+// the blocks before it
+func (vb *VersionBits) medianTimePast(chain HeaderSource, height uint64) (int64, error) {
+	span := uint64(vb.rules.MedianTimeSpan)
+	first := uint64(0)
+	if height+1 > span {
+		first = height + 1 - span
+	}
+
+	times := make([]int64, 0, height-first+1)
+	for h := first; h <= height; h++ {
+		header, err := chain.HeaderAt(h)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get header at %d: %w", h, err)
+		}
+		times = append(times, int64(header.Timestamp))
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2], nil
+}
+
+// countSignals counts the blocks from start to end that signal for d
+func countSignals(chain HeaderSource, d *Deployment, start, end uint64) (uint64, error) {
+	var count uint64
+	for h := start; h <= end; h++ {
+		header, err := chain.HeaderAt(h)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get header at %d: %w", h, err)
+		}
+		if d.Signals(header.Version) {
+			count++
+		}
+	}
+	return count, nil
+}
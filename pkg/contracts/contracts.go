@@ -0,0 +1,62 @@
+// Package contracts provides script templates for common Bitcoin
+// contracts and builders for the scriptSigs that spend them. Each
+// template returns the locking script to pay to; each spend path returns
+// the scriptSig for one way of unlocking it.
+package contracts
+
+import (
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// sequenceLockTime is the input sequence of a spend that needs its lock
+// time enforced: not final, not signalling replace-by-fee
+const sequenceLockTime = transaction.SequenceFinal - 1
+
+// SpendTx creates an unsigned transaction spending a contract output to
+// payTo. A non-zero lockTime is needed by the time-locked spend paths.
+func SpendTx(prevHash types.Hash, prevIndex uint32, payTo types.TxOutput, lockTime uint32) *types.Transaction {
+	sequence := transaction.SequenceFinal
+	if lockTime > 0 {
+		sequence = sequenceLockTime
+	}
+
+	return &types.Transaction{
+		Version:  1,
+		Inputs:   []types.TxInput{{PrevTxHash: prevHash, OutputIndex: prevIndex, Sequence: sequence}},
+		Outputs:  []types.TxOutput{payTo},
+		LockTime: lockTime,
+	}
+}
+
+// Sign signs input inputIdx of tx, which spends an output locked by
+// contractScript, for use in one of the spend paths
+func Sign(tx *types.Transaction, inputIdx int, key *keys.PrivateKey, contractScript []byte) ([]byte, error) {
+	return transaction.Sign(tx, inputIdx, key, contractScript, transaction.SigHashAll)
+}
+
+// Verify runs an input's scriptSig against the contract output it spends,
+// checking every signature
+func Verify(tx *types.Transaction, inputIdx int, prevOut types.TxOutput) error {
+	if inputIdx < 0 || inputIdx >= len(tx.Inputs) {
+		return fmt.Errorf("invalid input index: %d", inputIdx)
+	}
+
+	sigScript := tx.Inputs[inputIdx].SignatureScript
+	engine := script.NewInputEngine(sigScript, prevOut.PubKeyScript)
+	engine.SetTransaction(tx, inputIdx)
+	engine.SetSigChecker(transaction.SignatureChecker(tx, inputIdx, prevOut.PubKeyScript))
+	return engine.Execute()
+}
+
+// checkPubKey fails unless key is a serialized public key
+func checkPubKey(key []byte) error {
+	if _, err := keys.ParsePublicKey(key); err != nil {
+		return err
+	}
+	return nil
+}
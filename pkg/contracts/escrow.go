@@ -0,0 +1,63 @@
+package contracts
+
+import (
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+)
+
+// MultiSigScript creates an m-of-n multisig locking script
+// Format: <m> <pubKey>... <n> OP_CHECKMULTISIG
+func MultiSigScript(m int, pubKeys ...[]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > 16 {
+		return nil, fmt.Errorf("multisig needs 1 to 16 keys, got %d", len(pubKeys))
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("invalid threshold %d of %d", m, len(pubKeys))
+	}
+
+	builder := script.NewBuilder().AddInt(int64(m))
+	for i, key := range pubKeys {
+		if err := checkPubKey(key); err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		builder.AddData(key)
+	}
+	return builder.AddInt(int64(len(pubKeys))).AddOp(script.OP_CHECKMULTISIG).Script(), nil
+}
+
+// MultiSigSpend creates the scriptSig for a multisig script. Signatures
+// must be in the same order as their keys in the script.
+// Format: OP_0 <sig>...
+func MultiSigSpend(sigs ...[]byte) []byte {
+	// OP_0 feeds the extra element OP_CHECKMULTISIG consumes
+	builder := script.NewBuilder().AddOp(script.OP_0)
+	for _, sig := range sigs {
+		builder.AddData(sig)
+	}
+	return builder.Script()
+}
+
+// MultiSigWitness creates the witness spending a P2WSH multisig whose
+// witness script is multiSig. Signatures must be in the same order as
+// their keys in the script.
+// Format: <empty> <sig>... <multiSig>
+func MultiSigWitness(multiSig []byte, sigs ...[]byte) [][]byte {
+	witness := make([][]byte, 0, len(sigs)+2)
+	witness = append(witness, []byte{}) // The OP_CHECKMULTISIG dummy
+	witness = append(witness, sigs...)
+	return append(witness, multiSig)
+}
+
+// EscrowScript locks funds so any two of buyer, seller and arbiter can
+// release them: buyer and seller when the deal goes through, the arbiter
+// with either side in a dispute
+func EscrowScript(buyer, seller, arbiter []byte) ([]byte, error) {
+	return MultiSigScript(2, buyer, seller, arbiter)
+}
+
+// EscrowSpend creates the scriptSig releasing escrowed funds with two
+// signatures, given in buyer, seller, arbiter order
+func EscrowSpend(first, second []byte) []byte {
+	return MultiSigSpend(first, second)
+}
@@ -0,0 +1,54 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+)
+
+// HTLCScript creates a hashed timelock contract: the recipient can spend
+// by revealing the preimage of hash (SHA-256), and the sender can take
+// the funds back once lockTime has passed
+// Format: OP_IF OP_SHA256 <hash> OP_EQUALVERIFY <recipient>
+//
+//	OP_ELSE <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP <sender> OP_ENDIF OP_CHECKSIG
+func HTLCScript(recipient, sender []byte, hash [32]byte, lockTime uint32) ([]byte, error) {
+	if err := checkPubKey(recipient); err != nil {
+		return nil, fmt.Errorf("recipient: %w", err)
+	}
+	if err := checkPubKey(sender); err != nil {
+		return nil, fmt.Errorf("sender: %w", err)
+	}
+	if lockTime == 0 {
+		return nil, fmt.Errorf("HTLC lock time must be set")
+	}
+
+	return script.NewBuilder().
+		AddOp(script.OP_IF).
+		AddOp(script.OP_SHA256).AddData(hash[:]).AddOp(script.OP_EQUALVERIFY).AddData(recipient).
+		AddOp(script.OP_ELSE).
+		AddInt(int64(lockTime)).AddOp(script.OP_CHECKLOCKTIMEVERIFY).AddOp(script.OP_DROP).AddData(sender).
+		AddOp(script.OP_ENDIF).
+		AddOp(script.OP_CHECKSIG).
+		Script(), nil
+}
+
+// HashLock returns the hash an HTLC locks to for preimage
+func HashLock(preimage []byte) [32]byte {
+	return sha256.Sum256(preimage)
+}
+
+// HTLCClaimSpend creates the scriptSig for the recipient claiming the
+// funds with the preimage
+// Format: <recipientSig> <preimage> OP_1
+func HTLCClaimSpend(recipientSig, preimage []byte) []byte {
+	return script.NewBuilder().AddData(recipientSig).AddData(preimage).AddOp(script.OP_1).Script()
+}
+
+// HTLCRefundSpend creates the scriptSig for the sender taking the funds
+// back after the lock time, see SpendTx
+// Format: <senderSig> OP_0
+func HTLCRefundSpend(senderSig []byte) []byte {
+	return script.NewBuilder().AddData(senderSig).AddOp(script.OP_0).Script()
+}
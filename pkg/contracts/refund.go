@@ -0,0 +1,52 @@
+package contracts
+
+import (
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+)
+
+// RefundScript locks funds so payer and payee can spend them together at
+// any time, and the payer alone once lockTime (a height or timestamp) has
+// passed, so the payer gets their money back if the payee walks away
+// Format: OP_IF OP_2 <payer> <payee> OP_2 OP_CHECKMULTISIG
+//
+//	OP_ELSE <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP <payer> OP_CHECKSIG OP_ENDIF
+func RefundScript(payer, payee []byte, lockTime uint32) ([]byte, error) {
+	if err := checkPubKey(payer); err != nil {
+		return nil, fmt.Errorf("payer: %w", err)
+	}
+	if err := checkPubKey(payee); err != nil {
+		return nil, fmt.Errorf("payee: %w", err)
+	}
+	if lockTime == 0 {
+		return nil, fmt.Errorf("refund lock time must be set")
+	}
+
+	return script.NewBuilder().
+		AddOp(script.OP_IF).
+		AddOp(script.OP_2).AddData(payer).AddData(payee).AddOp(script.OP_2).AddOp(script.OP_CHECKMULTISIG).
+		AddOp(script.OP_ELSE).
+		AddInt(int64(lockTime)).AddOp(script.OP_CHECKLOCKTIMEVERIFY).AddOp(script.OP_DROP).
+		AddData(payer).AddOp(script.OP_CHECKSIG).
+		AddOp(script.OP_ENDIF).
+		Script(), nil
+}
+
+// RefundCooperativeSpend creates the scriptSig for payer and payee
+// spending together
+// Format: OP_0 <payerSig> <payeeSig> OP_1
+func RefundCooperativeSpend(payerSig, payeeSig []byte) []byte {
+	return script.NewBuilder().
+		AddOp(script.OP_0).AddData(payerSig).AddData(payeeSig).
+		AddOp(script.OP_1).
+		Script()
+}
+
+// RefundTimeoutSpend creates the scriptSig for the payer taking the funds
+// back. The spending transaction needs a lock time of at least the
+// script's, see SpendTx.
+// Format: <payerSig> OP_0
+func RefundTimeoutSpend(payerSig []byte) []byte {
+	return script.NewBuilder().AddData(payerSig).AddOp(script.OP_0).Script()
+}
@@ -0,0 +1,161 @@
+package contracts
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+)
+
+var ErrLeafNotInTree = errors.New("leaf is not in the script tree")
+
+// TaprootOutput describes a taproot output (BIP341): an internal key
+// that can spend it alone, and optionally a tree of scripts that can
+// spend it instead. Spending with the key reveals nothing of the scripts;
+// spending with a leaf reveals only that leaf.
+type TaprootOutput struct {
+	InternalKey *keys.PublicKey
+	Tree        *script.TapTree // nil = key path only
+}
+
+// MerkleRoot returns the root of the script tree, nil without one
+func (t *TaprootOutput) MerkleRoot() []byte {
+	if t.Tree == nil {
+		return nil
+	}
+	return t.Tree.RootHash()
+}
+
+// OutputKey returns the tweaked key the output pays
+func (t *TaprootOutput) OutputKey() (*keys.PublicKey, error) {
+	return keys.TaprootOutputKey(t.InternalKey, t.MerkleRoot())
+}
+
+// Script returns the locking script to pay to
+// Format: OP_1 <32-byte output key>
+func (t *TaprootOutput) Script() ([]byte, error) {
+	outputKey, err := t.OutputKey()
+	if err != nil {
+		return nil, err
+	}
+	return script.WitnessProgram(1, outputKey.XOnly())
+}
+
+// ControlBlock returns the control block proving leaf is in the tree
+func (t *TaprootOutput) ControlBlock(leaf script.TapLeaf) (*script.ControlBlock, error) {
+	if t.Tree == nil {
+		return nil, ErrLeafNotInTree
+	}
+	path, ok := t.Tree.MerklePath(leaf)
+	if !ok {
+		return nil, ErrLeafNotInTree
+	}
+	outputKey, err := t.OutputKey()
+	if err != nil {
+		return nil, err
+	}
+	return &script.ControlBlock{
+		LeafVersion:     leaf.Version,
+		OutputKeyYIsOdd: outputKey.HasOddY(),
+		InternalKey:     t.InternalKey.XOnly(),
+		Path:            path,
+	}, nil
+}
+
+// ScriptPathWitness creates the witness spending the output with leaf.
+// stack satisfies the leaf script, its first item deepest.
+// Format: <stack>... <leaf script> <control block>
+func (t *TaprootOutput) ScriptPathWitness(leaf script.TapLeaf, stack ...[]byte) ([][]byte, error) {
+	control, err := t.ControlBlock(leaf)
+	if err != nil {
+		return nil, err
+	}
+	witness := make([][]byte, 0, len(stack)+2)
+	witness = append(witness, stack...)
+	return append(witness, leaf.Script, control.Bytes()), nil
+}
+
+// CheckSigAddScript creates a tapscript m-of-n multisig leaf over x-only
+// keys. Tapscript has no OP_CHECKMULTISIG; each key's signature check
+// adds to a count instead, and the count must reach m.
+// Format: <key> OP_CHECKSIG [<key> OP_CHECKSIGADD]... <m> OP_NUMEQUAL,
+// or <key> OP_CHECKSIG for a single key
+func CheckSigAddScript(m int, pubKeys ...[]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > script.MaxStackSize {
+		return nil, fmt.Errorf("tapscript multisig needs 1 to %d keys, got %d", script.MaxStackSize, len(pubKeys))
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("invalid threshold %d of %d", m, len(pubKeys))
+	}
+
+	builder := script.NewBuilder()
+	for i, key := range pubKeys {
+		if _, err := keys.ParseXOnlyPublicKey(key); err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		builder.AddData(key)
+		if i == 0 {
+			builder.AddOp(script.OP_CHECKSIG)
+		} else {
+			builder.AddOp(script.OP_CHECKSIGADD)
+		}
+	}
+	if len(pubKeys) == 1 {
+		return builder.Script(), nil
+	}
+	return builder.AddInt(int64(m)).AddOp(script.OP_NUMEQUAL).Script(), nil
+}
+
+// ParseCheckSigAddScript returns the keys and threshold of a leaf made by
+// CheckSigAddScript
+func ParseCheckSigAddScript(leafScript []byte) ([][]byte, int, error) {
+	ops, err := script.ParseScript(leafScript)
+	if err != nil {
+		return nil, 0, err
+	}
+	notMultiSig := fmt.Errorf("not a tapscript multisig")
+
+	var pubKeys [][]byte
+	for len(ops) >= 2 && len(ops[0].Data) == keys.XOnlyPubKeySize {
+		want := byte(script.OP_CHECKSIGADD)
+		if len(pubKeys) == 0 {
+			want = script.OP_CHECKSIG
+		}
+		if ops[1].Opcode != want {
+			return nil, 0, notMultiSig
+		}
+		pubKeys = append(pubKeys, ops[0].Data)
+		ops = ops[2:]
+	}
+
+	switch {
+	case len(pubKeys) == 1 && len(ops) == 0:
+		return pubKeys, 1, nil
+	case len(pubKeys) > 1 && len(ops) == 2 && ops[1].Opcode == script.OP_NUMEQUAL:
+		m := scriptInt(ops[0])
+		if m < 1 || m > len(pubKeys) {
+			return nil, 0, notMultiSig
+		}
+		return pubKeys, m, nil
+	}
+	return nil, 0, notMultiSig
+}
+
+// scriptInt returns the small number an opcode pushes, or -1. Thresholds
+// above 16 are pushed as data.
+func scriptInt(op script.ParsedOp) int {
+	if script.IsSmallInt(op.Opcode) {
+		return script.SmallIntValue(op.Opcode)
+	}
+	if op.Opcode > script.OP_0 && op.Opcode < script.OP_PUSHDATA1 && len(op.Data) <= 2 {
+		n := 0
+		for i := len(op.Data) - 1; i >= 0; i-- {
+			n = n<<8 | int(op.Data[i])
+		}
+		if len(op.Data) > 0 && op.Data[len(op.Data)-1]&0x80 == 0 {
+			return n
+		}
+	}
+	return -1
+}
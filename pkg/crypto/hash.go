@@ -27,6 +27,22 @@ func HashBlockHeader(data []byte) types.Hash {
 	return DoubleSHA256(data)
 }
 
+// TaggedHash is the BIP340 hash: SHA256(SHA256(tag) || SHA256(tag) ||
+// msgs...). Each use gets its own tag, so a hash computed for one purpose
+// can never be passed off as another.
+func TaggedHash(tag string, msgs ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, msg := range msgs {
+		h.Write(msg)
+	}
+	var hash [32]byte
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
 /*
 
 **Why double hashing?**
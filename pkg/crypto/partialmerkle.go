@@ -0,0 +1,149 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// PartialMerkleTree proves that some transactions are in a block without
+// the rest of it (BIP37). It holds the hashes of the subtrees that don't
+// contain a match and one flag bit per node visited, depth first.
+type PartialMerkleTree struct {
+	Transactions uint32 // Number of transactions in the block
+	Hashes       []types.Hash
+	Flags        []bool
+}
+
+// NewPartialMerkleTree builds a proof for the transactions whose matches
+// entry is true
+func NewPartialMerkleTree(txHashes []types.Hash, matches []bool) *PartialMerkleTree {
+	t := &PartialMerkleTree{Transactions: uint32(len(txHashes))}
+	if len(txHashes) == 0 {
+		return t
+	}
+	t.build(t.height(), 0, txHashes, matches)
+	return t
+}
+
+// height returns the number of levels above the transactions
+func (t *PartialMerkleTree) height() int {
+	height := 0
+	for t.width(height) > 1 {
+		height++
+	}
+	return height
+}
+
+// width returns the number of nodes at a level, counting up from the
+// transactions at 0
+func (t *PartialMerkleTree) width(height int) uint32 {
+	return (t.Transactions + (1 << height) - 1) >> height
+}
+
+// nodeHash computes the hash of a node from every transaction
+func (t *PartialMerkleTree) nodeHash(height int, pos uint32, txHashes []types.Hash) types.Hash {
+	if height == 0 {
+		return txHashes[pos]
+	}
+	left := t.nodeHash(height-1, pos*2, txHashes)
+	right := left
+	if pos*2+1 < t.width(height-1) {
+		right = t.nodeHash(height-1, pos*2+1, txHashes)
+	}
+	return DoubleSHA256(append(left[:], right[:]...))
+}
+
+// build walks the tree depth first, descending only into subtrees that
+// contain a match
+func (t *PartialMerkleTree) build(height int, pos uint32, txHashes []types.Hash, matches []bool) {
+	parentOfMatch := false
+	for p := pos << height; p < (pos+1)<<height && p < t.Transactions; p++ {
+		if matches[p] {
+			parentOfMatch = true
+			break
+		}
+	}
+	t.Flags = append(t.Flags, parentOfMatch)
+
+	if height == 0 || !parentOfMatch {
+		t.Hashes = append(t.Hashes, t.nodeHash(height, pos, txHashes))
+		return
+	}
+	t.build(height-1, pos*2, txHashes, matches)
+	if pos*2+1 < t.width(height-1) {
+		t.build(height-1, pos*2+1, txHashes, matches)
+	}
+}
+
+// ExtractMatches recomputes the merkle root from the proof and returns it
+// with the matched transaction hashes, in block order
+func (t *PartialMerkleTree) ExtractMatches() (types.Hash, []types.Hash, error) {
+	if t.Transactions == 0 {
+		return types.Hash{}, nil, errors.New("proof covers no transactions")
+	}
+	if uint32(len(t.Hashes)) > t.Transactions {
+		return types.Hash{}, nil, fmt.Errorf("proof has %d hashes for %d transactions", len(t.Hashes), t.Transactions)
+	}
+	if len(t.Flags) < len(t.Hashes) {
+		return types.Hash{}, nil, errors.New("proof has fewer flags than hashes")
+	}
+
+	var (
+		bitsUsed, hashesUsed int
+		matches              []types.Hash
+	)
+	root, err := t.extract(t.height(), 0, &bitsUsed, &hashesUsed, &matches)
+	if err != nil {
+		return types.Hash{}, nil, err
+	}
+
+	// Everything must be consumed, up to the padding of the last flag byte
+	if (bitsUsed+7)/8 != (len(t.Flags)+7)/8 {
+		return types.Hash{}, nil, errors.New("proof has unused flags")
+	}
+	if hashesUsed != len(t.Hashes) {
+		return types.Hash{}, nil, errors.New("proof has unused hashes")
+	}
+	return root, matches, nil
+}
+
+// extract is the inverse of build
+func (t *PartialMerkleTree) extract(height int, pos uint32, bitsUsed, hashesUsed *int, matches *[]types.Hash) (types.Hash, error) {
+	if *bitsUsed >= len(t.Flags) {
+		return types.Hash{}, errors.New("proof ran out of flags")
+	}
+	parentOfMatch := t.Flags[*bitsUsed]
+	*bitsUsed++
+
+	if height == 0 || !parentOfMatch {
+		if *hashesUsed >= len(t.Hashes) {
+			return types.Hash{}, errors.New("proof ran out of hashes")
+		}
+		hash := t.Hashes[*hashesUsed]
+		*hashesUsed++
+		if height == 0 && parentOfMatch {
+			*matches = append(*matches, hash)
+		}
+		return hash, nil
+	}
+
+	left, err := t.extract(height-1, pos*2, bitsUsed, hashesUsed, matches)
+	if err != nil {
+		return types.Hash{}, err
+	}
+	right := left
+	if pos*2+1 < t.width(height-1) {
+		right, err = t.extract(height-1, pos*2+1, bitsUsed, hashesUsed, matches)
+		if err != nil {
+			return types.Hash{}, err
+		}
+		// Identical siblings would let a proof claim a duplicated
+		// transaction (CVE-2012-2459)
+		if right == left {
+			return types.Hash{}, errors.New("proof has identical sibling hashes")
+		}
+	}
+	return DoubleSHA256(append(left[:], right[:]...)), nil
+}
@@ -0,0 +1,66 @@
+// Package daemon keeps the process ID file a node writes while it runs,
+// so service managers and scripts can find it and a second node sharing
+// the file refuses to start.
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/fileutil"
+)
+
+// ErrAlreadyRunning is returned when the PID file names a live process
+var ErrAlreadyRunning = errors.New("another node is running")
+
+// WritePIDFile records this process's ID at path. A file naming a live
+// process other than this one is left alone and ErrAlreadyRunning
+// returned; one left behind by a crash is replaced.
+func WritePIDFile(path string) error {
+	if pid, err := ReadPIDFile(path); err == nil && pid != os.Getpid() && processAlive(pid) {
+		return fmt.Errorf("%w: pid %d in %s", ErrAlreadyRunning, pid, path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := fileutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write pid file: %w", err)
+	}
+	return nil
+}
+
+// RemovePIDFile deletes the PID file if it still names this process
+func RemovePIDFile(path string) {
+	if pid, err := ReadPIDFile(path); err == nil && pid == os.Getpid() {
+		os.Remove(path)
+	}
+}
+
+// ReadPIDFile parses the process ID stored at path
+func ReadPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// processAlive reports whether a process with the given ID exists. One
+// owned by another user can't be signalled but still counts.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,297 @@
+package electrum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// ScriptHash returns the Electrum script hash of a locking script: its
+// SHA-256, byte-reversed and hex encoded
+func ScriptHash(pkScript []byte) string {
+	sum := sha256.Sum256(pkScript)
+	for i, j := 0, len(sum)-1; i < j; i, j = i+1, j-1 {
+		sum[i], sum[j] = sum[j], sum[i]
+	}
+	return hex.EncodeToString(sum[:])
+}
+
+// HistoryItem is a transaction touching a script hash. Height is 0 for
+// mempool transactions.
+type HistoryItem struct {
+	TxHash types.Hash
+	Height uint64
+}
+
+// Unspent is an output paying a script hash. Height is 0 for mempool
+// transactions.
+type Unspent struct {
+	OutPoint types.OutPoint
+	Value    int64
+	Height   uint64
+}
+
+// coin is an unspent confirmed output in the index
+type coin struct {
+	scriptHash string
+	value      int64
+	height     uint64
+}
+
+// index maps script hashes to their confirmed history and coins. The
+// node's address index is optional and holds no coins, so the server
+// builds its own by following the best chain, and rebuilds it from
+// genesis after a reorg.
+type index struct {
+	tipHash   types.Hash
+	tipHeight uint64
+	synced    bool
+	history   map[string][]HistoryItem
+	coins     map[types.OutPoint]coin
+	byScript  map[string]map[types.OutPoint]bool
+	mu        sync.RWMutex
+}
+
+// newIndex creates an empty index
+func newIndex() *index {
+	idx := &index{}
+	idx.reset()
+	return idx
+}
+
+// reset drops everything indexed (lock held)
+func (idx *index) reset() {
+	idx.tipHash = types.Hash{}
+	idx.tipHeight = 0
+	idx.synced = false
+	idx.history = make(map[string][]HistoryItem)
+	idx.coins = make(map[types.OutPoint]coin)
+	idx.byScript = make(map[string]map[types.OutPoint]bool)
+}
+
+// sync catches the index up with the best chain and reports whether the
+// tip changed
+func (idx *index) sync(chain *storage.BlockchainStorage) (bool, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	isEmpty, err := chain.IsEmpty()
+	if err != nil {
+		return false, err
+	}
+	if isEmpty {
+		changed := idx.synced
+		idx.reset()
+		return changed, nil
+	}
+
+	bestHash, err := chain.GetBestBlockHash()
+	if err != nil {
+		return false, err
+	}
+	if idx.synced && bestHash == idx.tipHash {
+		return false, nil
+	}
+	bestHeight, err := chain.GetBestBlockHeight()
+	if err != nil {
+		return false, err
+	}
+
+	// Start over if our tip is no longer in the best chain
+	next := uint64(0)
+	if idx.synced {
+		next = idx.tipHeight + 1
+		block, err := chain.GetBlockByHeight(idx.tipHeight)
+		if err != nil || idx.tipHeight > bestHeight {
+			idx.reset()
+			next = 0
+		} else if hash, err := chain.GetBlockHash(block); err != nil || hash != idx.tipHash {
+			idx.reset()
+			next = 0
+		}
+	}
+
+	for height := next; height <= bestHeight; height++ {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil {
+			return false, fmt.Errorf("failed to load block %d: %w", height, err)
+		}
+		if err := idx.connect(block, height); err != nil {
+			return false, err
+		}
+		hash, err := chain.GetBlockHash(block)
+		if err != nil {
+			return false, err
+		}
+		idx.tipHash, idx.tipHeight, idx.synced = hash, height, true
+	}
+	return true, nil
+}
+
+// connect indexes a block at height (lock held)
+func (idx *index) connect(block *types.Block, height uint64) error {
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		txHash, err := serialization.HashTransaction(tx)
+		if err != nil {
+			return err
+		}
+
+		touched := make(map[string]bool)
+		for _, input := range tx.Inputs {
+			outpoint := types.OutPoint{Hash: input.PrevTxHash, Index: input.OutputIndex}
+			if c, ok := idx.coins[outpoint]; ok {
+				delete(idx.coins, outpoint)
+				delete(idx.byScript[c.scriptHash], outpoint)
+				touched[c.scriptHash] = true
+			}
+		}
+		for vout, output := range tx.Outputs {
+			scriptHash := ScriptHash(output.PubKeyScript)
+			outpoint := types.OutPoint{Hash: txHash, Index: uint32(vout)}
+			idx.coins[outpoint] = coin{scriptHash: scriptHash, value: output.Value, height: height}
+			if idx.byScript[scriptHash] == nil {
+				idx.byScript[scriptHash] = make(map[types.OutPoint]bool)
+			}
+			idx.byScript[scriptHash][outpoint] = true
+			touched[scriptHash] = true
+		}
+
+		for scriptHash := range touched {
+			idx.history[scriptHash] = append(idx.history[scriptHash], HistoryItem{TxHash: txHash, Height: height})
+		}
+	}
+	return nil
+}
+
+// tip returns the indexed best block height and hash
+func (idx *index) tip() (uint64, types.Hash, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.tipHeight, idx.tipHash, idx.synced
+}
+
+// mempoolView is what the mempool adds to a script hash's state
+type mempoolView struct {
+	history []HistoryItem
+	funded  []Unspent                // Mempool outputs paying the script hash
+	spent   map[types.OutPoint]int64 // Coins of the script hash spent in the mempool
+}
+
+// scanMempool collects the unconfirmed activity of scriptHash (read lock
+// held)
+func (idx *index) scanMempool(mp *mempool.Mempool, scriptHash string) *mempoolView {
+	view := &mempoolView{spent: make(map[types.OutPoint]int64)}
+	if mp == nil {
+		return view
+	}
+
+	entries := mp.GetAllTransactions()
+	// Outputs paying the script hash, so spends of them are recognized
+	pending := make(map[types.OutPoint]int64)
+	for _, entry := range entries {
+		for vout, output := range entry.Tx.Outputs {
+			if ScriptHash(output.PubKeyScript) == scriptHash {
+				pending[types.OutPoint{Hash: entry.TxHash, Index: uint32(vout)}] = output.Value
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		touches := false
+		for _, input := range entry.Tx.Inputs {
+			outpoint := types.OutPoint{Hash: input.PrevTxHash, Index: input.OutputIndex}
+			if c, ok := idx.coins[outpoint]; ok && c.scriptHash == scriptHash {
+				view.spent[outpoint] = c.value
+				touches = true
+			} else if value, ok := pending[outpoint]; ok {
+				view.spent[outpoint] = value
+				touches = true
+			}
+		}
+		for vout, output := range entry.Tx.Outputs {
+			if ScriptHash(output.PubKeyScript) == scriptHash {
+				outpoint := types.OutPoint{Hash: entry.TxHash, Index: uint32(vout)}
+				view.funded = append(view.funded, Unspent{OutPoint: outpoint, Value: output.Value})
+				touches = true
+			}
+		}
+		if touches {
+			view.history = append(view.history, HistoryItem{TxHash: entry.TxHash})
+		}
+	}
+
+	sort.Slice(view.history, func(i, j int) bool {
+		return view.history[i].TxHash.String() < view.history[j].TxHash.String()
+	})
+	return view
+}
+
+// balance returns the confirmed balance of scriptHash and the change the
+// mempool would make to it
+func (idx *index) balance(mp *mempool.Mempool, scriptHash string) (int64, int64) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	confirmed := int64(0)
+	for outpoint := range idx.byScript[scriptHash] {
+		confirmed += idx.coins[outpoint].value
+	}
+
+	view := idx.scanMempool(mp, scriptHash)
+	unconfirmed := int64(0)
+	for _, u := range view.funded {
+		unconfirmed += u.Value
+	}
+	for _, value := range view.spent {
+		unconfirmed -= value
+	}
+	return confirmed, unconfirmed
+}
+
+// historyOf returns the confirmed history of scriptHash in chain order,
+// followed by its mempool transactions
+func (idx *index) historyOf(mp *mempool.Mempool, scriptHash string) []HistoryItem {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	history := append([]HistoryItem(nil), idx.history[scriptHash]...)
+	return append(history, idx.scanMempool(mp, scriptHash).history...)
+}
+
+// unspentOf returns the outputs paying scriptHash that the mempool
+// doesn't spend, confirmed ones first
+func (idx *index) unspentOf(mp *mempool.Mempool, scriptHash string) []Unspent {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	view := idx.scanMempool(mp, scriptHash)
+	var unspent []Unspent
+	for outpoint := range idx.byScript[scriptHash] {
+		if _, spent := view.spent[outpoint]; spent {
+			continue
+		}
+		c := idx.coins[outpoint]
+		unspent = append(unspent, Unspent{OutPoint: outpoint, Value: c.value, Height: c.height})
+	}
+	sort.Slice(unspent, func(i, j int) bool {
+		if unspent[i].Height != unspent[j].Height {
+			return unspent[i].Height < unspent[j].Height
+		}
+		return unspent[i].OutPoint.String() < unspent[j].OutPoint.String()
+	})
+
+	for _, u := range view.funded {
+		if _, spent := view.spent[u.OutPoint]; !spent {
+			unspent = append(unspent, u)
+		}
+	}
+	return unspent
+}
@@ -0,0 +1,423 @@
+// Package electrum serves a subset of the Electrum protocol so light
+// wallets can follow balances and history through this node. Requests
+// and responses are JSON-RPC 2.0 objects, one per line, over plain TCP.
+//
+// Supported methods:
+//
+//	server.version, server.ping
+//	blockchain.headers.subscribe
+//	blockchain.scripthash.get_balance
+//	blockchain.scripthash.get_history
+//	blockchain.scripthash.listunspent
+package electrum
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+)
+
+const (
+	// ServerVersion is reported by server.version
+	ServerVersion = "learn-bitcoin-electrum 0.1"
+
+	// ProtocolVersion is the Electrum protocol version spoken
+	ProtocolVersion = "1.4"
+
+	// DefaultPollInterval is how often the chain tip is checked for
+	// header notifications
+	DefaultPollInterval = time.Second
+
+	// maxRequestSize bounds a single request line
+	maxRequestSize = 1 << 20
+)
+
+// JSON-RPC error codes
+const (
+	errCodeParse          = -32700
+	errCodeMethodNotFound = -32601
+	errCodeInvalidParams  = -32602
+	errCodeInternal       = -32603
+)
+
+// Request is a JSON-RPC request or notification
+type Request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id,omitempty"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// Response is a JSON-RPC response
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Notification is a server-initiated message for subscribers
+type Notification struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// Error is a JSON-RPC error
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("electrum error %d: %s", e.Code, e.Message)
+}
+
+// Header is the result of blockchain.headers.subscribe
+type Header struct {
+	Height uint64 `json:"height"`
+	Hex    string `json:"hex"`
+}
+
+// Balance is the result of blockchain.scripthash.get_balance
+type Balance struct {
+	Confirmed   int64 `json:"confirmed"`
+	Unconfirmed int64 `json:"unconfirmed"`
+}
+
+// HistoryEntry is an element of blockchain.scripthash.get_history
+type HistoryEntry struct {
+	TxHash string `json:"tx_hash"`
+	Height uint64 `json:"height"`
+}
+
+// UnspentEntry is an element of blockchain.scripthash.listunspent
+type UnspentEntry struct {
+	TxHash string `json:"tx_hash"`
+	TxPos  uint32 `json:"tx_pos"`
+	Height uint64 `json:"height"`
+	Value  int64  `json:"value"`
+}
+
+// Server answers Electrum clients from the chain and mempool
+type Server struct {
+	chain        *storage.BlockchainStorage
+	mempool      *mempool.Mempool
+	index        *index
+	pollInterval time.Duration
+	listener     net.Listener
+	clients      map[*client]bool
+	quit         chan struct{}
+	wg           sync.WaitGroup
+	mu           sync.Mutex
+}
+
+// client is one connected wallet
+type client struct {
+	conn    net.Conn
+	headers bool // Subscribed to new headers
+	mu      sync.Mutex
+}
+
+// NewServer creates a server. mp may be nil, in which case no
+// unconfirmed activity is reported.
+func NewServer(chain *storage.BlockchainStorage, mp *mempool.Mempool) *Server {
+	return &Server{
+		chain:        chain,
+		mempool:      mp,
+		index:        newIndex(),
+		pollInterval: DefaultPollInterval,
+		clients:      make(map[*client]bool),
+		quit:         make(chan struct{}),
+	}
+}
+
+// SetPollInterval changes how often the chain tip is checked
+func (s *Server) SetPollInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pollInterval = interval
+}
+
+// Start indexes the chain and listens for clients on addr
+func (s *Server) Start(addr string) error {
+	if _, err := s.index.sync(s.chain); err != nil {
+		return fmt.Errorf("failed to index chain: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = listener
+	interval := s.pollInterval
+	s.mu.Unlock()
+
+	s.wg.Add(2)
+	go s.acceptLoop(listener)
+	go s.pollLoop(interval)
+	return nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Stop disconnects every client and stops the server
+func (s *Server) Stop() {
+	s.mu.Lock()
+	select {
+	case <-s.quit:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	close(s.quit)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// acceptLoop serves each connection in its own goroutine
+func (s *Server) acceptLoop(listener net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			log.Printf("electrum: accept failed: %v", err)
+			continue
+		}
+
+		c := &client{conn: conn}
+		s.mu.Lock()
+		s.clients[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+// serve reads requests from a client until it disconnects
+func (s *Server) serve(c *client) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 4096), maxRequestSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var req Request
+		if err := json.Unmarshal(line, &req); err != nil {
+			c.send(Response{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &Error{Code: errCodeParse, Message: err.Error()}})
+			continue
+		}
+
+		result, rpcErr := s.handle(c, &req)
+		if req.ID == nil {
+			continue // A notification wants no answer
+		}
+		resp := Response{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}
+		if err := c.send(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle dispatches a request to its method
+func (s *Server) handle(c *client, req *Request) (interface{}, *Error) {
+	// Answer from the current tip, not the last poll
+	if _, err := s.index.sync(s.chain); err != nil {
+		return nil, &Error{Code: errCodeInternal, Message: err.Error()}
+	}
+
+	switch req.Method {
+	case "server.version":
+		return []string{ServerVersion, ProtocolVersion}, nil
+	case "server.ping":
+		return nil, nil
+	case "blockchain.headers.subscribe":
+		header, err := s.tipHeader()
+		if err != nil {
+			return nil, &Error{Code: errCodeInternal, Message: err.Error()}
+		}
+		c.mu.Lock()
+		c.headers = true
+		c.mu.Unlock()
+		return header, nil
+	case "blockchain.scripthash.get_balance":
+		scriptHash, rpcErr := scriptHashParam(req)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		confirmed, unconfirmed := s.index.balance(s.mempool, scriptHash)
+		return Balance{Confirmed: confirmed, Unconfirmed: unconfirmed}, nil
+	case "blockchain.scripthash.get_history":
+		scriptHash, rpcErr := scriptHashParam(req)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		history := make([]HistoryEntry, 0)
+		for _, item := range s.index.historyOf(s.mempool, scriptHash) {
+			history = append(history, HistoryEntry{TxHash: item.TxHash.String(), Height: item.Height})
+		}
+		return history, nil
+	case "blockchain.scripthash.listunspent":
+		scriptHash, rpcErr := scriptHashParam(req)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		unspent := make([]UnspentEntry, 0)
+		for _, u := range s.index.unspentOf(s.mempool, scriptHash) {
+			unspent = append(unspent, UnspentEntry{
+				TxHash: u.OutPoint.Hash.String(),
+				TxPos:  u.OutPoint.Index,
+				Height: u.Height,
+				Value:  u.Value,
+			})
+		}
+		return unspent, nil
+	default:
+		return nil, &Error{Code: errCodeMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+	}
+}
+
+// scriptHashParam reads and checks the script hash every
+// blockchain.scripthash method takes first
+func scriptHashParam(req *Request) (string, *Error) {
+	if len(req.Params) < 1 {
+		return "", &Error{Code: errCodeInvalidParams, Message: "missing script hash"}
+	}
+	var scriptHash string
+	if err := json.Unmarshal(req.Params[0], &scriptHash); err != nil {
+		return "", &Error{Code: errCodeInvalidParams, Message: "script hash must be a string"}
+	}
+	if b, err := hex.DecodeString(scriptHash); err != nil || len(b) != 32 {
+		return "", &Error{Code: errCodeInvalidParams, Message: fmt.Sprintf("invalid script hash %q", scriptHash)}
+	}
+	return scriptHash, nil
+}
+
+// tipHeader returns the indexed best block header
+func (s *Server) tipHeader() (*Header, error) {
+	height, _, synced := s.index.tip()
+	if !synced {
+		return nil, fmt.Errorf("chain is empty")
+	}
+	header, err := s.chain.HeaderAt(height)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := serialization.SerializeBlockHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	return &Header{Height: height, Hex: hex.EncodeToString(raw)}, nil
+}
+
+// pollLoop follows the chain tip and tells header subscribers about new
+// blocks. The node has no event bus to push them, so the tip is polled.
+func (s *Server) pollLoop(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Requests sync the index too, so compare tips rather than trusting
+	// sync to report the change
+	_, notified, _ := s.index.tip()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.index.sync(s.chain); err != nil {
+			log.Printf("electrum: failed to sync index: %v", err)
+			continue
+		}
+		if _, tip, synced := s.index.tip(); synced && tip != notified {
+			notified = tip
+			s.notifyHeaders()
+		}
+	}
+}
+
+// notifyHeaders sends the new tip to every header subscriber
+func (s *Server) notifyHeaders() {
+	header, err := s.tipHeader()
+	if err != nil {
+		return
+	}
+	note := Notification{JSONRPC: "2.0", Method: "blockchain.headers.subscribe", Params: []interface{}{header}}
+
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.mu.Lock()
+		subscribed := c.headers
+		c.mu.Unlock()
+		if subscribed {
+			c.send(note)
+		}
+	}
+}
+
+// send writes one message followed by a newline
+func (c *client) send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = c.conn.Write(data)
+	return err
+}
@@ -0,0 +1,489 @@
+package explorer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/encoding"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// PathPrefix is the URL prefix all explorer routes live under
+const PathPrefix = "/explorer/"
+
+const (
+	// defaultRichListLimit is the number of UTXOs returned by /richlist
+	defaultRichListLimit = 20
+
+	// maxAddressHistory caps the number of transactions listed on an
+	// address page
+	maxAddressHistory = 100
+)
+
+// UTXOSource lists the unspent outputs the explorer shows. utxo.UTXOSet
+// and utxo.UTXOCache both provide it.
+type UTXOSource interface {
+	GetAll() []*utxo.UTXO
+	FindByScript(script []byte) []*utxo.UTXO
+}
+
+// Explorer is a read-only HTTP API over the blockchain, mempool and UTXO set
+type Explorer struct {
+	chain   *storage.BlockchainStorage
+	mempool *mempool.Mempool
+	utxoSet UTXOSource
+}
+
+// NewExplorer creates a new explorer. mempool and utxoSet may be nil, in
+// which case the corresponding pages report an error.
+func NewExplorer(chain *storage.BlockchainStorage, mp *mempool.Mempool, utxoSet UTXOSource) *Explorer {
+	return &Explorer{
+		chain:   chain,
+		mempool: mp,
+		utxoSet: utxoSet,
+	}
+}
+
+// Register mounts the explorer routes on a mux
+func (e *Explorer) Register(mux *http.ServeMux) {
+	mux.HandleFunc(PathPrefix+"block/", e.handleBlock)
+	mux.HandleFunc(PathPrefix+"height/", e.handleHeight)
+	mux.HandleFunc(PathPrefix+"tx/", e.handleTx)
+	mux.HandleFunc(PathPrefix+"address/", e.handleAddress)
+	mux.HandleFunc(PathPrefix+"mempool", e.handleMempool)
+	mux.HandleFunc(PathPrefix+"richlist", e.handleRichList)
+}
+
+// Handler returns an http.Handler serving only the explorer routes
+func (e *Explorer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	e.Register(mux)
+	return mux
+}
+
+// Response structures
+type Response struct {
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type Block struct {
+	Hash         string        `json:"hash"`
+	Height       uint64        `json:"height"`
+	Version      int32         `json:"version"`
+	PrevHash     string        `json:"prev_hash"`
+	MerkleRoot   string        `json:"merkle_root"`
+	Timestamp    uint32        `json:"timestamp"`
+	Bits         uint32        `json:"bits"`
+	Nonce        uint32        `json:"nonce"`
+	TxCount      int           `json:"tx_count"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+type Transaction struct {
+	TxHash    string   `json:"txhash"`
+	BlockHash string   `json:"block_hash,omitempty"`
+	Height    uint64   `json:"height,omitempty"`
+	Coinbase  bool     `json:"coinbase"`
+	Version   int32    `json:"version"`
+	Inputs    []Input  `json:"inputs"`
+	Outputs   []Output `json:"outputs"`
+	LockTime  uint32   `json:"locktime"`
+	TotalOut  int64    `json:"total_out"`
+}
+
+type Input struct {
+	PrevTxHash  string `json:"prev_txhash"`
+	OutputIndex uint32 `json:"output_index"`
+	ScriptSig   string `json:"script_sig"`
+	Sequence    uint32 `json:"sequence"`
+}
+
+type Output struct {
+	Value        int64  `json:"value"`
+	ScriptPubKey string `json:"script_pubkey"`
+	Asm          string `json:"asm"`
+	Address      string `json:"address,omitempty"`
+}
+
+type AddressPage struct {
+	Address      string        `json:"address"`
+	Balance      int64         `json:"balance"`
+	Received     int64         `json:"received"`
+	UTXOCount    int           `json:"utxo_count"`
+	TxCount      int           `json:"tx_count"`
+	Transactions []string      `json:"transactions"` // Newest first, at most maxAddressHistory
+	UTXOs        []UTXOSummary `json:"utxos"`
+}
+
+type UTXOSummary struct {
+	Outpoint string `json:"outpoint"`
+	Value    int64  `json:"value"`
+	Height   uint64 `json:"height"`
+	Address  string `json:"address,omitempty"`
+	Coinbase bool   `json:"coinbase"`
+}
+
+type MempoolEntry struct {
+	TxHash  string `json:"txhash"`
+	Size    int64  `json:"size"`
+	Fee     int64  `json:"fee"`
+	FeeRate int64  `json:"fee_rate"` // sat/kvB
+	Time    int64  `json:"time"`
+}
+
+type MempoolPage struct {
+	Count        int            `json:"count"`
+	Bytes        int64          `json:"bytes"`
+	Transactions []MempoolEntry `json:"transactions"`
+}
+
+// Handler functions
+func (e *Explorer) handleBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		e.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	hash, err := types.NewHashFromString(strings.TrimPrefix(r.URL.Path, PathPrefix+"block/"))
+	if err != nil {
+		e.sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid block hash: %v", err))
+		return
+	}
+
+	block, err := e.chain.GetBlock(hash)
+	if err != nil {
+		e.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	height, err := e.chain.GetBlockHeight(hash)
+	if err != nil {
+		e.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	e.sendSuccess(w, DecodeBlock(block, hash, height))
+}
+
+func (e *Explorer) handleHeight(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		e.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	height, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, PathPrefix+"height/"), 10, 64)
+	if err != nil {
+		e.sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid height: %v", err))
+		return
+	}
+
+	block, err := e.chain.GetBlockByHeight(height)
+	if err != nil {
+		e.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	hash, err := e.chain.GetBlockHash(block)
+	if err != nil {
+		e.sendError(w, http.StatusInternalServerError, fmt.Sprintf("failed to hash block: %v", err))
+		return
+	}
+
+	e.sendSuccess(w, DecodeBlock(block, hash, height))
+}
+
+func (e *Explorer) handleTx(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		e.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	txHash, err := types.NewHashFromString(strings.TrimPrefix(r.URL.Path, PathPrefix+"tx/"))
+	if err != nil {
+		e.sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid txhash: %v", err))
+		return
+	}
+
+	// Unconfirmed transactions are served straight from the mempool
+	if e.mempool != nil {
+		if entry, err := e.mempool.Get(txHash); err == nil {
+			e.sendSuccess(w, DecodeTransaction(entry.Tx, txHash))
+			return
+		}
+	}
+
+	blockHash, txIndex, err := e.chain.GetTransactionLocation(txHash)
+	if err != nil {
+		e.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	block, err := e.chain.GetBlock(blockHash)
+	if err != nil {
+		e.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if int(txIndex) >= len(block.Transactions) {
+		e.sendError(w, http.StatusInternalServerError, "invalid transaction index")
+		return
+	}
+
+	tx := DecodeTransaction(&block.Transactions[txIndex], txHash)
+	tx.BlockHash = blockHash.String()
+	tx.Height, _ = e.chain.GetBlockHeight(blockHash)
+
+	e.sendSuccess(w, tx)
+}
+
+func (e *Explorer) handleAddress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		e.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	address := strings.TrimPrefix(r.URL.Path, PathPrefix+"address/")
+	addr, err := keys.DecodeAddress(address)
+	if err != nil {
+		e.sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid address: %v", err))
+		return
+	}
+	if !addr.IsP2PKH() {
+		e.sendError(w, http.StatusBadRequest, "only P2PKH addresses are supported")
+		return
+	}
+
+	lockingScript, err := script.P2PKH(addr.Hash())
+	if err != nil {
+		e.sendError(w, http.StatusBadRequest, fmt.Sprintf("failed to build script: %v", err))
+		return
+	}
+
+	if !e.chain.AddressIndexEnabled() {
+		e.sendError(w, http.StatusServiceUnavailable, "address index not enabled")
+		return
+	}
+	page, err := e.buildAddressPage(address, lockingScript)
+	if err != nil {
+		e.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	e.sendSuccess(w, page)
+}
+
+func (e *Explorer) handleMempool(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		e.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if e.mempool == nil {
+		e.sendError(w, http.StatusServiceUnavailable, "mempool not available")
+		return
+	}
+
+	entries := e.mempool.GetAllTransactions()
+
+	// Sort by fee rate (highest first)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FeeRate > entries[j].FeeRate
+	})
+
+	page := MempoolPage{
+		Count:        len(entries),
+		Bytes:        e.mempool.Bytes(),
+		Transactions: make([]MempoolEntry, len(entries)),
+	}
+	for i, entry := range entries {
+		page.Transactions[i] = MempoolEntry{
+			TxHash:  entry.TxHash.String(),
+			Size:    entry.Size,
+			Fee:     entry.Fee,
+			FeeRate: entry.FeeRate,
+			Time:    entry.Time,
+		}
+	}
+
+	e.sendSuccess(w, page)
+}
+
+func (e *Explorer) handleRichList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		e.sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if e.utxoSet == nil {
+		e.sendError(w, http.StatusServiceUnavailable, "UTXO set not available")
+		return
+	}
+
+	limit := defaultRichListLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			e.sendError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	utxos := e.utxoSet.GetAll()
+
+	// Sort by value (largest first)
+	sort.Slice(utxos, func(i, j int) bool {
+		return utxos[i].Value() > utxos[j].Value()
+	})
+
+	if limit > len(utxos) {
+		limit = len(utxos)
+	}
+
+	result := make([]UTXOSummary, limit)
+	for i, u := range utxos[:limit] {
+		result[i] = summarizeUTXO(u)
+	}
+
+	e.sendSuccess(w, result)
+}
+
+// buildAddressPage collects balance and history for a locking script from
+// the address index. Balance and totals cover the whole history; only the
+// transaction list is capped, newest first.
+func (e *Explorer) buildAddressPage(address string, lockingScript []byte) (*AddressPage, error) {
+	page := &AddressPage{
+		Address:      address,
+		Transactions: make([]string, 0),
+		UTXOs:        make([]UTXOSummary, 0),
+	}
+
+	if e.utxoSet != nil {
+		for _, u := range e.utxoSet.FindByScript(lockingScript) {
+			page.UTXOs = append(page.UTXOs, summarizeUTXO(u))
+		}
+		page.UTXOCount = len(page.UTXOs)
+	}
+
+	history, err := e.chain.GetAddressHistory(lockingScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address index: %w", err)
+	}
+	page.TxCount = len(history)
+	for _, entry := range history {
+		page.Received += entry.Received
+		page.Balance += entry.Received - entry.Sent
+	}
+	for i := len(history) - 1; i >= 0 && len(page.Transactions) < maxAddressHistory; i-- {
+		page.Transactions = append(page.Transactions, history[i].TxHash.String())
+	}
+
+	return page, nil
+}
+
+// DecodeBlock converts a block into its explorer representation
+func DecodeBlock(block *types.Block, hash types.Hash, height uint64) Block {
+	result := Block{
+		Hash:         hash.String(),
+		Height:       height,
+		Version:      block.Header.Version,
+		PrevHash:     block.Header.PrevBlockHash.String(),
+		MerkleRoot:   block.Header.MerkleRoot.String(),
+		Timestamp:    block.Header.Timestamp,
+		Bits:         block.Header.Bits,
+		Nonce:        block.Header.Nonce,
+		TxCount:      len(block.Transactions),
+		Transactions: make([]Transaction, len(block.Transactions)),
+	}
+
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		txHash, _ := serialization.HashTransaction(tx)
+		result.Transactions[i] = DecodeTransaction(tx, txHash)
+		result.Transactions[i].BlockHash = result.Hash
+		result.Transactions[i].Height = height
+	}
+
+	return result
+}
+
+// DecodeTransaction converts a transaction into its explorer representation
+func DecodeTransaction(tx *types.Transaction, txHash types.Hash) Transaction {
+	result := Transaction{
+		TxHash:   txHash.String(),
+		Version:  tx.Version,
+		Inputs:   make([]Input, len(tx.Inputs)),
+		Outputs:  make([]Output, len(tx.Outputs)),
+		LockTime: tx.LockTime,
+	}
+
+	result.Coinbase = len(tx.Inputs) == 1 &&
+		tx.Inputs[0].PrevTxHash.IsZero() &&
+		tx.Inputs[0].OutputIndex == 0xFFFFFFFF
+
+	for i, input := range tx.Inputs {
+		result.Inputs[i] = Input{
+			PrevTxHash:  input.PrevTxHash.String(),
+			OutputIndex: input.OutputIndex,
+			ScriptSig:   fmt.Sprintf("%x", input.SignatureScript),
+			Sequence:    input.Sequence,
+		}
+	}
+
+	for i, output := range tx.Outputs {
+		result.Outputs[i] = Output{
+			Value:        output.Value,
+			ScriptPubKey: fmt.Sprintf("%x", output.PubKeyScript),
+			Asm:          script.DisassembleScript(output.PubKeyScript),
+			Address:      scriptAddress(output.PubKeyScript),
+		}
+		result.TotalOut += output.Value
+	}
+
+	return result
+}
+
+// summarizeUTXO converts a UTXO into its explorer representation
+func summarizeUTXO(u *utxo.UTXO) UTXOSummary {
+	return UTXOSummary{
+		Outpoint: u.OutPoint().String(),
+		Value:    u.Value(),
+		Height:   u.Height,
+		Address:  scriptAddress(u.Output.PubKeyScript),
+		Coinbase: u.IsCoinbase,
+	}
+}
+
+// scriptAddress returns the mainnet address for a P2PKH script, or "" otherwise
+func scriptAddress(lockingScript []byte) string {
+	pubKeyHash, err := script.ExtractP2PKHAddress(lockingScript)
+	if err != nil {
+		return ""
+	}
+	return encoding.EncodeBase58Check(keys.AddressTypeP2PKH, pubKeyHash)
+}
+
+// Helper functions
+func (e *Explorer) sendSuccess(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(Response{Result: result})
+}
+
+func (e *Explorer) sendError(w http.ResponseWriter, status int, errMsg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{Error: errMsg})
+}
@@ -0,0 +1,46 @@
+// Package fileutil holds file helpers shared by the node's components
+package fileutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// WriteFile replaces the file at path with data, like os.WriteFile, but
+// never leaves it half-written. The data goes to a temporary file beside
+// path, is synced to disk and then renamed over path, which is atomic on
+// the same filesystem: after a crash the file holds either its old
+// contents or the new ones.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return Write(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Write is WriteFile for contents produced by write. If write fails the
+// file at path is left as it was.
+func Write(path string, perm os.FileMode, write func(io.Writer) error) error {
+	tmpPath := path + ".new"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
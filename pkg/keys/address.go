@@ -33,10 +33,13 @@ func NewAddress(version byte, hash []byte) (*Address, error) {
 		return nil, fmt.Errorf("hash must be 20 bytes, got %d", len(hash))
 	}
 
-	return &Address{
+	addr := &Address{
 		version: version,
 		hash:    make([]byte, 20),
-	}, nil
+	}
+	copy(addr.hash, hash)
+
+	return addr, nil
 }
 
 // P2PKHAddress creates a Pay-to-PubKey-Hash address
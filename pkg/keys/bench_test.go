@@ -0,0 +1,54 @@
+package keys_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+)
+
+// Run with e.g. `go test ./pkg/keys -run=^$ -bench=. -benchmem`
+
+// BenchmarkSchnorrBatchVerify compares verifying n signatures one by one
+// with queueing them in a batch and verifying that, the way a block's
+// taproot inputs are checked. Both report time per signature.
+func BenchmarkSchnorrBatchVerify(b *testing.B) {
+	for _, n := range []int{16, 128, 1024} {
+		pubKeys, hashes, sigs := make([][]byte, n), make([][]byte, n), make([][]byte, n)
+		for i := 0; i < n; i++ {
+			key, err := keys.GeneratePrivateKey()
+			if err != nil {
+				b.Fatal(err)
+			}
+			hash := sha256.Sum256([]byte(fmt.Sprint(i)))
+			if sigs[i], err = key.SignSchnorr(hash[:], nil); err != nil {
+				b.Fatal(err)
+			}
+			pubKeys[i], hashes[i] = key.PublicKey().XOnly(), hash[:]
+		}
+
+		b.Run(fmt.Sprintf("individual/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < n; j++ {
+					if !keys.VerifySchnorr(pubKeys[j], hashes[j], sigs[j]) {
+						b.Fatal("signature doesn't verify")
+					}
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/sig")
+		})
+		b.Run(fmt.Sprintf("batch/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				batch := keys.NewSchnorrBatch()
+				for j := 0; j < n; j++ {
+					batch.Add(pubKeys[j], hashes[j], sigs[j])
+				}
+				if !batch.Verify() {
+					b.Fatal("batch doesn't verify")
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/sig")
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package keys
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/encoding"
+)
+
+// BIP32 hierarchical deterministic keys: a tree of keys grown from one
+// seed, so a backup of the seed covers every key the wallet will ever use
+const (
+	// HardenedKeyStart is the first hardened child index. A hardened
+	// child can only be derived from the parent's private key.
+	HardenedKeyStart = 0x80000000
+
+	MinSeedSize = 16
+	MaxSeedSize = 64
+)
+
+var (
+	ErrInvalidSeed = errors.New("invalid HD seed")
+	ErrUnusableKey = errors.New("derived key is unusable, skip to the next index")
+)
+
+// ExtendedKey is a private key with the chain code its children are
+// derived with, and where it sits in the tree
+type ExtendedKey struct {
+	key       *PrivateKey
+	chainCode []byte
+	depth     byte
+	parentFP  [4]byte // First 4 bytes of the parent's public key hash
+	index     uint32  // Child index below the parent
+}
+
+// NewMasterKey derives the root of the key tree from a seed of 16 to 64
+// bytes
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < MinSeedSize || len(seed) > MaxSeedSize {
+		return nil, fmt.Errorf("%w: %d bytes, want %d to %d", ErrInvalidSeed, len(seed), MinSeedSize, MaxSeedSize)
+	}
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	var k secp256k1.ModNScalar
+	if overflow := k.SetByteSlice(sum[:32]); overflow || k.IsZero() {
+		return nil, fmt.Errorf("%w: unusable master key", ErrInvalidSeed)
+	}
+	return &ExtendedKey{key: &PrivateKey{key: secp256k1.NewPrivateKey(&k)}, chainCode: sum[32:]}, nil
+}
+
+// Child derives child index. Indexes from HardenedKeyStart on are
+// hardened. With probability below 2^-127 a child is unusable and
+// ErrUnusableKey is returned; BIP32 says to skip that index.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	var data []byte
+	if index >= HardenedKeyStart {
+		data = append([]byte{0x00}, k.key.Bytes()...)
+	} else {
+		data = k.key.PublicKey().Bytes(true)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	var child secp256k1.ModNScalar
+	if overflow := child.SetByteSlice(sum[:32]); overflow {
+		return nil, ErrUnusableKey
+	}
+	child.Add(&k.key.key.Key)
+	if child.IsZero() {
+		return nil, ErrUnusableKey
+	}
+	if k.depth == 255 {
+		return nil, fmt.Errorf("key tree is at most 255 levels deep")
+	}
+	return &ExtendedKey{
+		key:       &PrivateKey{key: secp256k1.NewPrivateKey(&child)},
+		chainCode: sum[32:],
+		depth:     k.depth + 1,
+		parentFP:  k.Fingerprint(),
+		index:     index,
+	}, nil
+}
+
+// Derive follows path down from k, one child index per level
+func (k *ExtendedKey) Derive(path ...uint32) (*ExtendedKey, error) {
+	for _, index := range path {
+		child, err := k.Child(index)
+		if err != nil {
+			return nil, err
+		}
+		k = child
+	}
+	return k, nil
+}
+
+// PrivateKey returns the key itself
+func (k *ExtendedKey) PrivateKey() *PrivateKey {
+	return k.key
+}
+
+// ChainCode returns the 32 bytes mixed into child derivation
+func (k *ExtendedKey) ChainCode() []byte {
+	return k.chainCode
+}
+
+// Fingerprint returns the first 4 bytes of the key's public key hash,
+// which identifies it as a parent, or as the master in a key origin
+func (k *ExtendedKey) Fingerprint() [4]byte {
+	var fp [4]byte
+	copy(fp[:], k.key.PublicKey().Hash160())
+	return fp
+}
+
+// String returns the key serialized as an extended private key for
+// params, e.g. xprv... on mainnet
+func (k *ExtendedKey) String(params *NetParams) string {
+	return k.serialize(params.HDPrivateKeyID, append([]byte{0x00}, k.key.Bytes()...))
+}
+
+// PublicString returns the public half serialized as an extended public
+// key for params, e.g. xpub... on mainnet. Anyone holding it can derive
+// the public keys of the non-hardened children, but no private key.
+func (k *ExtendedKey) PublicString(params *NetParams) string {
+	return k.serialize(params.HDPublicKeyID, k.key.PublicKey().Bytes(true))
+}
+
+// serialize encodes the key in BIP32's 78 byte layout with Base58Check
+func (k *ExtendedKey) serialize(version [4]byte, keyData []byte) string {
+	data := make([]byte, 0, 78)
+	data = append(data, version[:]...)
+	data = append(data, k.depth)
+	data = append(data, k.parentFP[:]...)
+	data = binary.BigEndian.AppendUint32(data, k.index)
+	data = append(data, k.chainCode...)
+	data = append(data, keyData...)
+	return encoding.EncodeBase58Check(data[0], data[1:])
+}
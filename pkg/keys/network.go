@@ -0,0 +1,230 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/encoding"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+)
+
+// ErrWrongNetwork is returned for an address that is valid on another
+// network than the one it is decoded for
+var ErrWrongNetwork = errors.New("address is for a different network")
+
+// NetParams holds the prefixes a network's addresses are encoded with
+type NetParams struct {
+	Name             string
+	PubKeyHashAddrID byte    // Base58 version byte of P2PKH addresses
+	ScriptHashAddrID byte    // Base58 version byte of P2SH addresses
+	Bech32HRP        string  // Human-readable prefix of segwit addresses
+	HDPrivateKeyID   [4]byte // Version of serialized extended private keys (xprv, tprv)
+	HDPublicKeyID    [4]byte // Version of serialized extended public keys (xpub, tpub)
+}
+
+// Address prefixes of the supported networks. Testnet, signet and regtest
+// share their Base58 version bytes and regtest only differs in the segwit
+// prefix. Signet addresses are identical to testnet ones.
+var (
+	MainNetParams = &NetParams{Name: "mainnet", PubKeyHashAddrID: AddressTypeP2PKH, ScriptHashAddrID: AddressTypeP2SH, Bech32HRP: "bc",
+		HDPrivateKeyID: [4]byte{0x04, 0x88, 0xad, 0xe4}, HDPublicKeyID: [4]byte{0x04, 0x88, 0xb2, 0x1e}}
+	TestNetParams = &NetParams{Name: "testnet", PubKeyHashAddrID: AddressTypeTestnetP2PKH, ScriptHashAddrID: AddressTypeTestnetP2SH, Bech32HRP: "tb",
+		HDPrivateKeyID: testHDPrivateKeyID, HDPublicKeyID: testHDPublicKeyID}
+	SignetParams = &NetParams{Name: "signet", PubKeyHashAddrID: AddressTypeTestnetP2PKH, ScriptHashAddrID: AddressTypeTestnetP2SH, Bech32HRP: "tb",
+		HDPrivateKeyID: testHDPrivateKeyID, HDPublicKeyID: testHDPublicKeyID}
+	RegtestParams = &NetParams{Name: "regtest", PubKeyHashAddrID: AddressTypeTestnetP2PKH, ScriptHashAddrID: AddressTypeTestnetP2SH, Bech32HRP: "bcrt",
+		HDPrivateKeyID: testHDPrivateKeyID, HDPublicKeyID: testHDPublicKeyID}
+)
+
+// Extended key versions shared by the test networks (tprv, tpub)
+var (
+	testHDPrivateKeyID = [4]byte{0x04, 0x35, 0x83, 0x94}
+	testHDPublicKeyID  = [4]byte{0x04, 0x35, 0x87, 0xcf}
+)
+
+// allNetParams is searched to name the network of a foreign address
+var allNetParams = []*NetParams{MainNetParams, TestNetParams, SignetParams, RegtestParams}
+
+// ParamsForNetwork returns the address prefixes for a network name
+// (mainnet, testnet, signet or regtest)
+func ParamsForNetwork(network string) (*NetParams, error) {
+	for _, params := range allNetParams {
+		if params.Name == network {
+			return params, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown network: %s", network)
+}
+
+// AddressType is the kind of output an address pays to
+type AddressType int
+
+const (
+	AddressP2PKH AddressType = iota
+	AddressP2SH
+	AddressP2WPKH
+	AddressP2WSH
+	AddressP2TR
+	AddressWitnessUnknown // Future witness version, spendable by anyone today
+)
+
+// String returns the type's usual short name
+func (t AddressType) String() string {
+	switch t {
+	case AddressP2PKH:
+		return "p2pkh"
+	case AddressP2SH:
+		return "p2sh"
+	case AddressP2WPKH:
+		return "p2wpkh"
+	case AddressP2WSH:
+		return "p2wsh"
+	case AddressP2TR:
+		return "p2tr"
+	default:
+		return "witness_unknown"
+	}
+}
+
+// NetworkAddress is an address decoded for a particular network
+type NetworkAddress struct {
+	Type           AddressType
+	WitnessVersion byte   // Only meaningful for segwit types
+	Program        []byte // Pubkey hash, script hash or witness program
+	Params         *NetParams
+}
+
+// DecodeAddressForNetwork decodes a Base58 or segwit address and checks
+// it belongs to the network described by params. Addresses valid on
+// another known network fail with ErrWrongNetwork.
+func DecodeAddressForNetwork(address string, params *NetParams) (*NetworkAddress, error) {
+	if hrp, ok := bech32Prefix(address); ok {
+		return decodeSegWitForNetwork(address, hrp, params)
+	}
+
+	version, hash, err := encoding.DecodeBase58Check(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address: %w", err)
+	}
+	if len(hash) != 20 {
+		return nil, fmt.Errorf("invalid address hash length: %d", len(hash))
+	}
+
+	switch version {
+	case params.PubKeyHashAddrID:
+		return &NetworkAddress{Type: AddressP2PKH, Program: hash, Params: params}, nil
+	case params.ScriptHashAddrID:
+		return &NetworkAddress{Type: AddressP2SH, Program: hash, Params: params}, nil
+	}
+
+	for _, other := range allNetParams {
+		if version == other.PubKeyHashAddrID || version == other.ScriptHashAddrID {
+			return nil, fmt.Errorf("%w: %s address used on %s", ErrWrongNetwork, other.Name, params.Name)
+		}
+	}
+	return nil, fmt.Errorf("unknown address version 0x%02x", version)
+}
+
+// bech32Prefix returns the part of address before the last '1' when it
+// starts with a known segwit prefix
+func bech32Prefix(address string) (string, bool) {
+	sep := strings.LastIndexByte(address, '1')
+	if sep < 1 {
+		return "", false
+	}
+	hrp := strings.ToLower(address[:sep])
+	for _, params := range allNetParams {
+		if hrp == params.Bech32HRP {
+			return hrp, true
+		}
+	}
+	return "", false
+}
+
+// decodeSegWitForNetwork decodes a bech32 or bech32m address
+func decodeSegWitForNetwork(address, hrp string, params *NetParams) (*NetworkAddress, error) {
+	if hrp != params.Bech32HRP {
+		for _, other := range allNetParams {
+			if hrp == other.Bech32HRP {
+				return nil, fmt.Errorf("%w: %s address used on %s", ErrWrongNetwork, other.Name, params.Name)
+			}
+		}
+	}
+
+	_, version, program, err := encoding.DecodeSegWitAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address: %w", err)
+	}
+
+	addr := &NetworkAddress{Type: AddressWitnessUnknown, WitnessVersion: version, Program: program, Params: params}
+	switch {
+	case version == 0 && len(program) == 20:
+		addr.Type = AddressP2WPKH
+	case version == 0 && len(program) == 32:
+		addr.Type = AddressP2WSH
+	case version == 1 && len(program) == 32:
+		addr.Type = AddressP2TR
+	}
+	return addr, nil
+}
+
+// Script returns the locking script that pays the address
+func (a *NetworkAddress) Script() ([]byte, error) {
+	switch a.Type {
+	case AddressP2PKH:
+		return script.P2PKH(a.Program)
+	case AddressP2SH:
+		return script.P2SH(a.Program)
+	default:
+		return script.WitnessProgram(a.WitnessVersion, a.Program)
+	}
+}
+
+// String encodes the address for its network
+func (a *NetworkAddress) String() string {
+	switch a.Type {
+	case AddressP2PKH:
+		return encoding.EncodeBase58Check(a.Params.PubKeyHashAddrID, a.Program)
+	case AddressP2SH:
+		return encoding.EncodeBase58Check(a.Params.ScriptHashAddrID, a.Program)
+	default:
+		encoded, _ := encoding.EncodeSegWitAddress(a.Params.Bech32HRP, a.WitnessVersion, a.Program)
+		return encoded
+	}
+}
+
+// P2PKHAddressForNetwork creates a Pay-to-PubKey-Hash address for params
+func (pub *PublicKey) P2PKHAddressForNetwork(params *NetParams) string {
+	return encoding.EncodeBase58Check(params.PubKeyHashAddrID, pub.Hash160())
+}
+
+// AddressFromScript returns the address a locking script pays on params.
+// Scripts without an address form, such as bare multisig or null data,
+// return an error.
+func AddressFromScript(pkScript []byte, params *NetParams) (*NetworkAddress, error) {
+	addr := &NetworkAddress{Params: params}
+	switch script.ClassifyScript(pkScript) {
+	case script.PubKeyHashTy:
+		addr.Type = AddressP2PKH
+	case script.ScriptHashTy:
+		addr.Type = AddressP2SH
+	case script.WitnessV0KeyHashTy:
+		addr.Type = AddressP2WPKH
+	case script.WitnessV0ScriptHashTy:
+		addr.Type = AddressP2WSH
+	case script.WitnessV1TaprootTy:
+		addr.Type = AddressP2TR
+	case script.WitnessUnknownTy:
+		addr.Type = AddressWitnessUnknown
+	default:
+		return nil, errors.New("script has no address form")
+	}
+
+	if version, program, ok := script.ExtractWitnessProgram(pkScript); ok {
+		addr.WitnessVersion, addr.Program = version, program
+	} else {
+		addr.Program = script.ExtractScriptHash(pkScript)
+	}
+	return addr, nil
+}
@@ -13,6 +13,16 @@ type PublicKey struct {
 	key *secp256k1.PublicKey
 }
 
+// ParsePublicKey parses a compressed or uncompressed public key
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	key, err := secp256k1.ParsePubKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	return &PublicKey{key: key}, nil
+}
+
 // Bytes returns serialized public key
 func (pub *PublicKey) Bytes(compressed bool) []byte {
 	if compressed {
@@ -0,0 +1,154 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+)
+
+// BIP340 Schnorr signatures. Keys are 32-byte x coordinates: of the two
+// points with that x, the one with an even y is meant.
+const (
+	XOnlyPubKeySize  = 32
+	SchnorrSigSize   = 64
+	schnorrAuxSize   = 32
+	schnorrNonceTag  = "BIP0340/nonce"
+	schnorrAuxTag    = "BIP0340/aux"
+	schnorrChallenge = "BIP0340/challenge"
+)
+
+var ErrInvalidXOnlyKey = errors.New("invalid x-only public key")
+
+// XOnly returns the key as BIP340 serializes it: its x coordinate alone
+func (pub *PublicKey) XOnly() []byte {
+	return pub.key.SerializeCompressed()[1:]
+}
+
+// HasOddY reports whether the key's y coordinate is odd, so its x-only
+// form stands for its negation
+func (pub *PublicKey) HasOddY() bool {
+	return pub.key.SerializeCompressed()[0] == secp256k1.PubKeyFormatCompressedOdd
+}
+
+// ParseXOnlyPublicKey parses a 32-byte BIP340 key into the point with that
+// x coordinate and an even y
+func ParseXOnlyPublicKey(data []byte) (*PublicKey, error) {
+	if len(data) != XOnlyPubKeySize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidXOnlyKey, len(data))
+	}
+	key, err := secp256k1.ParsePubKey(append([]byte{secp256k1.PubKeyFormatCompressedEven}, data...))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidXOnlyKey, err)
+	}
+	return &PublicKey{key: key}, nil
+}
+
+// SignSchnorr signs a 32-byte hash with BIP340. auxRand is mixed into the
+// nonce to protect against side channels; nil draws fresh randomness.
+// The signature is checked before it is returned.
+func (pk *PrivateKey) SignSchnorr(hash, auxRand []byte) ([]byte, error) {
+	if len(hash) != 32 {
+		return nil, fmt.Errorf("hash must be 32 bytes, got %d", len(hash))
+	}
+	if auxRand == nil {
+		auxRand = make([]byte, schnorrAuxSize)
+		if _, err := rand.Read(auxRand); err != nil {
+			return nil, fmt.Errorf("failed to read randomness: %w", err)
+		}
+	}
+	if len(auxRand) != schnorrAuxSize {
+		return nil, fmt.Errorf("auxiliary randomness must be %d bytes, got %d", schnorrAuxSize, len(auxRand))
+	}
+
+	// The secret is negated if needed so that its point has an even y
+	d := pk.key.Key
+	if d.IsZero() {
+		return nil, errors.New("private key is zero")
+	}
+	pub := pk.PublicKey()
+	if pub.HasOddY() {
+		d.Negate()
+	}
+	pubX := pub.XOnly()
+
+	// The nonce is derived from the secret, the key and the message
+	dBytes := d.Bytes()
+	aux := crypto.TaggedHash(schnorrAuxTag, auxRand)
+	var t [32]byte
+	for i := range t {
+		t[i] = dBytes[i] ^ aux[i]
+	}
+	nonce := crypto.TaggedHash(schnorrNonceTag, t[:], pubX, hash)
+	var k secp256k1.ModNScalar
+	k.SetBytes(&nonce)
+	if k.IsZero() {
+		return nil, errors.New("nonce is zero")
+	}
+	var r secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&k, &r)
+	r.ToAffine()
+	if r.Y.IsOdd() {
+		k.Negate()
+	}
+	rX := r.X.Bytes()
+
+	e := schnorrChallengeHash(rX[:], pubX, hash)
+	var s secp256k1.ModNScalar
+	s.Mul2(&e, &d).Add(&k)
+	sBytes := s.Bytes()
+
+	sig := append(append(make([]byte, 0, SchnorrSigSize), rX[:]...), sBytes[:]...)
+	if !VerifySchnorr(pubX, hash, sig) {
+		return nil, errors.New("created signature does not verify")
+	}
+	return sig, nil
+}
+
+// VerifySchnorr reports whether sig is a valid BIP340 signature of hash
+// by the x-only key pubKey
+func VerifySchnorr(pubKey, hash, sig []byte) bool {
+	if len(hash) != 32 || len(sig) != SchnorrSigSize {
+		return false
+	}
+	pub, err := ParseXOnlyPublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+
+	var r secp256k1.FieldVal
+	if overflow := r.SetByteSlice(sig[:32]); overflow {
+		return false
+	}
+	var s secp256k1.ModNScalar
+	if overflow := s.SetByteSlice(sig[32:]); overflow {
+		return false
+	}
+	e := schnorrChallengeHash(sig[:32], pubKey, hash)
+
+	// R = s*G - e*P must be the point with x = r and an even y
+	var p, sG, eP, point secp256k1.JacobianPoint
+	pub.key.AsJacobian(&p)
+	secp256k1.ScalarBaseMultNonConst(&s, &sG)
+	secp256k1.ScalarMultNonConst(e.Negate(), &p, &eP)
+	secp256k1.AddNonConst(&sG, &eP, &point)
+	if point.Z.Normalize().IsZero() {
+		return false
+	}
+	point.ToAffine()
+	if point.Y.IsOdd() {
+		return false
+	}
+	return bytes.Equal(point.X.Bytes()[:], sig[:32])
+}
+
+// schnorrChallengeHash is the BIP340 challenge e, reduced mod the order
+func schnorrChallengeHash(r, pubKey, hash []byte) secp256k1.ModNScalar {
+	digest := crypto.TaggedHash(schnorrChallenge, r, pubKey, hash)
+	var e secp256k1.ModNScalar
+	e.SetBytes(&digest)
+	return e
+}
@@ -0,0 +1,211 @@
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// SchnorrBatch checks many BIP340 signatures at once. Each signature says
+// s*G = R + e*P; weighting every equation by a random a_i and summing
+// gives one: (sum a_i*s_i)*G = sum a_i*R_i + sum a_i*e_i*P_i. The right
+// side is a single multi-scalar multiplication, which costs much less
+// than a scalar multiplication per signature. A forger would have to
+// guess the weights, so if the sum holds every signature is valid with
+// overwhelming probability; if it fails some signature is invalid, and
+// FirstInvalid finds which.
+//
+// ECDSA signatures can't be batched this way: they carry only R's x
+// coordinate and a signer can pick either point.
+//
+// A SchnorrBatch is not safe for concurrent use.
+type SchnorrBatch struct {
+	entries []schnorrEntry
+}
+
+// schnorrEntry is one signature as Add parsed it
+type schnorrEntry struct {
+	pubKey, hash, sig []byte
+	p, r              secp256k1.JacobianPoint
+	s, e              secp256k1.ModNScalar
+}
+
+// schnorrBatchMin is the smallest batch worth the multi-scalar
+// multiplication; smaller ones are verified one by one
+const schnorrBatchMin = 4
+
+// NewSchnorrBatch returns an empty batch
+func NewSchnorrBatch() *SchnorrBatch {
+	return &SchnorrBatch{}
+}
+
+// Add queues sig over hash by the x-only key pubKey. It returns false if
+// the signature can't be valid whatever the others are: a bad length, a
+// key or R that isn't on the curve, or s not below the group order. Such
+// a signature is not queued.
+func (b *SchnorrBatch) Add(pubKey, hash, sig []byte) bool {
+	if len(hash) != 32 || len(sig) != SchnorrSigSize {
+		return false
+	}
+	pub, err := ParseXOnlyPublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+	// R is the point with x = r and an even y, as for a key
+	r, err := ParseXOnlyPublicKey(sig[:32])
+	if err != nil {
+		return false
+	}
+
+	entry := schnorrEntry{pubKey: pubKey, hash: hash, sig: sig}
+	if overflow := entry.s.SetByteSlice(sig[32:]); overflow {
+		return false
+	}
+	entry.e = schnorrChallengeHash(sig[:32], pubKey, hash)
+	pub.key.AsJacobian(&entry.p)
+	r.key.AsJacobian(&entry.r)
+	b.entries = append(b.entries, entry)
+	return true
+}
+
+// Len returns the number of signatures queued
+func (b *SchnorrBatch) Len() int {
+	return len(b.entries)
+}
+
+// Verify reports whether every queued signature is valid. An empty batch
+// is.
+func (b *SchnorrBatch) Verify() bool {
+	if len(b.entries) < schnorrBatchMin {
+		return b.FirstInvalid() < 0
+	}
+
+	// The weights come from fresh randomness and everything queued, so
+	// they can't be known before the signatures are fixed. The first is 1,
+	// which saves a multiplication.
+	var seed [32]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return b.FirstInvalid() < 0
+	}
+	seeder := sha256.New()
+	seeder.Write(seed[:])
+	for _, entry := range b.entries {
+		seeder.Write(entry.pubKey)
+		seeder.Write(entry.hash)
+		seeder.Write(entry.sig)
+	}
+	copy(seed[:], seeder.Sum(nil))
+
+	points := make([]secp256k1.JacobianPoint, 0, 2*len(b.entries))
+	scalars := make([]secp256k1.ModNScalar, 0, 2*len(b.entries))
+	var sum secp256k1.ModNScalar
+	for i := range b.entries {
+		entry := &b.entries[i]
+		a := batchWeight(seed, i)
+		var ae, as secp256k1.ModNScalar
+		ae.Mul2(&a, &entry.e)
+		as.Mul2(&a, &entry.s)
+		sum.Add(&as)
+		points = append(points, entry.r, entry.p)
+		scalars = append(scalars, a, ae)
+	}
+
+	var lhs secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&sum, &lhs)
+	rhs := multiScalarMult(points, scalars)
+	return lhs.EquivalentNonConst(&rhs)
+}
+
+// FirstInvalid verifies the queued signatures one by one and returns the
+// index of the first invalid one, or -1 if all are valid
+func (b *SchnorrBatch) FirstInvalid() int {
+	for i, entry := range b.entries {
+		if !VerifySchnorr(entry.pubKey, entry.hash, entry.sig) {
+			return i
+		}
+	}
+	return -1
+}
+
+// batchWeight returns the i-th weight: 1 for the first, then 128-bit
+// numbers drawn from seed. 128 bits is all the security needed, and half
+// the bits halve the work their points take.
+func batchWeight(seed [32]byte, i int) secp256k1.ModNScalar {
+	var a secp256k1.ModNScalar
+	if i == 0 {
+		a.SetInt(1)
+		return a
+	}
+	var buf [36]byte
+	copy(buf[:], seed[:])
+	binary.LittleEndian.PutUint32(buf[32:], uint32(i))
+	digest := sha256.Sum256(buf[:])
+	var weight [32]byte
+	copy(weight[16:], digest[:16])
+	weight[16] |= 0x80 // never zero
+	a.SetBytes(&weight)
+	return a
+}
+
+// multiScalarMult returns sum scalars[i]*points[i] using Pippenger's
+// bucket method. Each window of c bits puts every point in the bucket of
+// its digit, and the buckets are summed so bucket j counts j times: about
+// one addition per point per window instead of a scalar multiplication
+// per point.
+func multiScalarMult(points []secp256k1.JacobianPoint, scalars []secp256k1.ModNScalar) secp256k1.JacobianPoint {
+	// Each window costs an addition per point and two per bucket; pick
+	// the width that costs least overall
+	c, best := 1, -1
+	for width := 1; width <= 16; width++ {
+		cost := (256 + width - 1) / width * (len(points) + 2<<uint(width))
+		if best < 0 || cost < best {
+			c, best = width, cost
+		}
+	}
+
+	digits := make([][32]byte, len(scalars))
+	for i := range scalars {
+		digits[i] = scalars[i].Bytes()
+	}
+	// digit returns bits [bit, bit+c) of the big-endian scalar
+	digit := func(k *[32]byte, bit int) int {
+		d := 0
+		for j := 0; j < c && bit+j < 256; j++ {
+			pos := bit + j
+			if k[31-pos/8]>>(uint(pos)%8)&1 == 1 {
+				d |= 1 << uint(j)
+			}
+		}
+		return d
+	}
+
+	var result secp256k1.JacobianPoint
+	buckets := make([]secp256k1.JacobianPoint, 1<<uint(c))
+	windows := (256 + c - 1) / c
+	for w := windows - 1; w >= 0; w-- {
+		for j := 0; j < c; j++ {
+			secp256k1.DoubleNonConst(&result, &result)
+		}
+
+		for j := range buckets {
+			buckets[j] = secp256k1.JacobianPoint{}
+		}
+		for i := range points {
+			if d := digit(&digits[i], w*c); d != 0 {
+				secp256k1.AddNonConst(&buckets[d], &points[i], &buckets[d])
+			}
+		}
+
+		// running holds buckets j and up; adding it at every j adds
+		// bucket j to the window's sum j times
+		var running, window secp256k1.JacobianPoint
+		for j := len(buckets) - 1; j >= 1; j-- {
+			secp256k1.AddNonConst(&running, &buckets[j], &running)
+			secp256k1.AddNonConst(&window, &running, &window)
+		}
+		secp256k1.AddNonConst(&result, &window, &result)
+	}
+	return result
+}
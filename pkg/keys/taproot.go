@@ -0,0 +1,75 @@
+package keys
+
+import (
+	"errors"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+)
+
+// tapTweakTag tags the hash committing a taproot output key to its
+// internal key and script tree (BIP341)
+const tapTweakTag = "TapTweak"
+
+var ErrInvalidTweak = errors.New("taproot tweak out of range")
+
+// TaprootTweak returns the scalar a taproot internal key is tweaked by:
+// the tagged hash of its x-only form and the script tree's merkle root.
+// A key-path-only output commits to no root (merkleRoot nil).
+func TaprootTweak(internalKey, merkleRoot []byte) ([]byte, error) {
+	tweak := crypto.TaggedHash(tapTweakTag, internalKey, merkleRoot)
+	var t secp256k1.ModNScalar
+	if overflow := t.SetByteSlice(tweak[:]); overflow {
+		return nil, ErrInvalidTweak
+	}
+	return tweak[:], nil
+}
+
+// TaprootOutputKey returns the key a taproot output pays: internalKey,
+// taken with an even y, plus the tweak times G. The output's witness
+// program is its x-only form.
+func TaprootOutputKey(internalKey *PublicKey, merkleRoot []byte) (*PublicKey, error) {
+	internalX := internalKey.XOnly()
+	tweak, err := TaprootTweak(internalX, merkleRoot)
+	if err != nil {
+		return nil, err
+	}
+	even, err := ParseXOnlyPublicKey(internalX)
+	if err != nil {
+		return nil, err
+	}
+
+	var t secp256k1.ModNScalar
+	t.SetByteSlice(tweak)
+	var p, tG, q secp256k1.JacobianPoint
+	even.key.AsJacobian(&p)
+	secp256k1.ScalarBaseMultNonConst(&t, &tG)
+	secp256k1.AddNonConst(&p, &tG, &q)
+	if q.Z.Normalize().IsZero() {
+		return nil, ErrInvalidTweak
+	}
+	q.ToAffine()
+	return &PublicKey{key: secp256k1.NewPublicKey(&q.X, &q.Y)}, nil
+}
+
+// TaprootPrivateKey returns the key that signs for the key path of a
+// taproot output with internal key pk: the secret of TaprootOutputKey
+func (pk *PrivateKey) TaprootPrivateKey(merkleRoot []byte) (*PrivateKey, error) {
+	pub := pk.PublicKey()
+	tweak, err := TaprootTweak(pub.XOnly(), merkleRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	d := pk.key.Key
+	if pub.HasOddY() {
+		d.Negate()
+	}
+	var t secp256k1.ModNScalar
+	t.SetByteSlice(tweak)
+	d.Add(&t)
+	if d.IsZero() {
+		return nil, ErrInvalidTweak
+	}
+	return &PrivateKey{key: secp256k1.NewPrivateKey(&d)}, nil
+}
@@ -0,0 +1,64 @@
+package mempool_test
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Run with e.g. `go test ./pkg/mempool -run=^$ -bench=. -benchmem`
+
+// benchTransactions returns the spends of the benchmark block
+func benchTransactions(b *testing.B) []types.Transaction {
+	b.Helper()
+	block, _, err := testharness.SyntheticBlock(testharness.BenchBlockConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return block.Transactions[1:]
+}
+
+func BenchmarkMempoolAdd(b *testing.B) {
+	txs := benchTransactions(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		pool := mempool.NewMempool(300000000, 1000, 3600)
+		b.StartTimer()
+
+		for j := range txs {
+			if err := pool.Add(&txs[j], 1000, 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkMempoolEvict(b *testing.B) {
+	txs := benchTransactions(b)
+
+	// Room for half the transactions, so the second half evicts the first
+	maxSize := int64(0)
+	for j := range txs[:len(txs)/2] {
+		maxSize += mempool.EstimateMemoryUsage(&txs[j], 0).Total()
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		pool := mempool.NewMempool(maxSize, 1000, 3600)
+		b.StartTimer()
+
+		for j := range txs {
+			// Later transactions pay more so they always displace earlier ones
+			if err := pool.Add(&txs[j], int64(1000+j*10), 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
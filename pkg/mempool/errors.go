@@ -0,0 +1,39 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+)
+
+// Reasons a transaction is refused by the mempool. Add and the policy
+// checks wrap these, so callers can tell them apart with errors.Is and map
+// them to reject codes.
+var (
+	ErrAlreadyInMempool = errors.New("transaction already in mempool")
+	ErrLowFee           = errors.New("fee rate too low")
+	ErrConflict         = errors.New("transaction conflicts with the mempool")
+	ErrNonStandard      = errors.New("transaction is non-standard")
+	ErrTooLarge         = errors.New("transaction too large")
+	ErrMempoolFull      = errors.New("mempool full")
+	ErrMissingInputs    = errors.New("missing inputs")
+	ErrScriptFailed     = errors.New("script verification failed")
+)
+
+// MempoolFullError is returned when a transaction doesn't pay enough to
+// evict others from a full mempool. It matches ErrMempoolFull.
+type MempoolFullError struct {
+	FeeRate     int64 // Fee rate the transaction paid, in sat/kvB
+	MinFeeRate  int64 // Lowest fee rate that would have been accepted, in sat/kvB
+	RequiredFee int64 // Fee the transaction needs at its size
+}
+
+func (e *MempoolFullError) Error() string {
+	return fmt.Sprintf("%v: fee rate %s below %s, need a fee of %d", ErrMempoolFull, transaction.FormatFeeRate(e.FeeRate), transaction.FormatFeeRate(e.MinFeeRate), e.RequiredFee)
+}
+
+// Is makes errors.Is(err, ErrMempoolFull) true for a MempoolFullError
+func (e *MempoolFullError) Is(target error) bool {
+	return target == ErrMempoolFull
+}
@@ -3,6 +3,7 @@ package mempool
 import (
 	"fmt"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
 
@@ -18,47 +19,10 @@ func NewFeeEstimator(mempool *Mempool) *FeeEstimator {
 	}
 }
 
-// CalculateTransactionSize calculates the size of a transaction in bytes
+// CalculateTransactionSize returns the virtual size of a transaction, the
+// size fee rates and policy limits are measured in
 func CalculateTransactionSize(tx *types.Transaction) int64 {
-	size := int64(0)
-
-	// Version (4 bytes)
-	size += 4
-
-	// Input count (varint, assume 1 byte for simplicity)
-	size += 1
-
-	// Inputs
-	for _, input := range tx.Inputs {
-		// Previous transaction hash (32 bytes)
-		size += 32
-		// Output index (4 bytes)
-		size += 4
-		// Script length (varint, assume 1 byte)
-		size += 1
-		// Script
-		size += int64(len(input.SignatureScript))
-		// Sequence (4 bytes)
-		size += 4
-	}
-
-	// Output count (varint, assume 1 byte)
-	size += 1
-
-	// Outputs
-	for _, output := range tx.Outputs {
-		// Value (8 bytes)
-		size += 8
-		// Script length (varint, assume 1 byte)
-		size += 1
-		// Script
-		size += int64(len(output.PubKeyScript))
-	}
-
-	// Locktime (4 bytes)
-	size += 4
-
-	return size
+	return int64(transaction.VirtualSize(tx))
 }
 
 // CalculateTransactionFee calculates the fee for a transaction
@@ -89,23 +53,31 @@ func CalculateTransactionFee(tx *types.Transaction, inputValues []int64) (int64,
 	return fee, nil
 }
 
-// CalculateFeeRate calculates the fee rate (satoshis per byte)
+// CalculateFeeRate calculates the fee rate in satoshis per 1000 virtual
+// bytes (sat/kvB), the unit every fee rate in the mempool is kept in
 func CalculateFeeRate(fee int64, size int64) int64 {
-	if size == 0 {
-		return 0
-	}
-	return fee / size
+	return transaction.FeeRate(fee, size)
 }
 
-// EstimateFee estimates the required fee for a transaction to be included
-// within a target number of blocks
+// EstimateFee estimates the required fee for a transaction of txSize
+// virtual bytes to be included within a target number of blocks
 func (fe *FeeEstimator) EstimateFee(targetBlocks int, txSize int64) int64 {
 	fe.mempool.mu.RLock()
 	defer fe.mempool.mu.RUnlock()
 
+	// Prefer historical confirmation data when we have enough of it
+	if fe.mempool.feeHistory != nil {
+		if feeRate, ok := fe.mempool.feeHistory.EstimateFeeRate(targetBlocks); ok {
+			if feeRate < fe.mempool.minFeeRate {
+				feeRate = fe.mempool.minFeeRate
+			}
+			return transaction.FeeAtRate(feeRate, txSize)
+		}
+	}
+
 	if len(fe.mempool.entries) == 0 {
 		// No transactions in mempool, use minimum fee rate
-		return fe.mempool.minFeeRate * txSize
+		return transaction.FeeAtRate(fe.mempool.minFeeRate, txSize)
 	}
 
 	// Get all fee rates
@@ -149,7 +121,7 @@ func (fe *FeeEstimator) EstimateFee(targetBlocks int, txSize int64) int64 {
 		estimatedFeeRate = fe.mempool.minFeeRate
 	}
 
-	return estimatedFeeRate * txSize
+	return transaction.FeeAtRate(estimatedFeeRate, txSize)
 }
 
 // GetFeeStatistics returns fee statistics from the mempool
@@ -189,7 +161,7 @@ func (fe *FeeEstimator) GetFeeStatistics() *FeeStatistics {
 	stats.MinFeeRate = feeRates[0]
 	stats.MaxFeeRate = feeRates[len(feeRates)-1]
 	stats.MedianFeeRate = feeRates[len(feeRates)/2]
-	stats.AverageFeeRate = totalFee / totalSize
+	stats.AverageFeeRate = CalculateFeeRate(totalFee, totalSize)
 
 	// Percentiles
 	stats.P25FeeRate = feeRates[len(feeRates)/4]
@@ -202,7 +174,8 @@ func (fe *FeeEstimator) GetFeeStatistics() *FeeStatistics {
 	return stats
 }
 
-// FeeStatistics contains fee statistics from the mempool
+// FeeStatistics contains fee statistics from the mempool. Fee rates are
+// in sat/kvB.
 type FeeStatistics struct {
 	TxCount        int
 	MinFeeRate     int64
@@ -229,7 +202,8 @@ func (fe *FeeEstimator) CalculateAncestorFee(txHash types.Hash) (int64, error) {
 	return entry.AncestorFee, nil
 }
 
-// CalculateAncestorFeeRate calculates the fee rate including all ancestors
+// CalculateAncestorFeeRate calculates the fee rate, in sat/kvB, including
+// all ancestors
 func (fe *FeeEstimator) CalculateAncestorFeeRate(txHash types.Hash) (int64, error) {
 	fe.mempool.mu.RLock()
 	defer fe.mempool.mu.RUnlock()
@@ -239,11 +213,7 @@ func (fe *FeeEstimator) CalculateAncestorFeeRate(txHash types.Hash) (int64, erro
 		return 0, fmt.Errorf("transaction not in mempool")
 	}
 
-	if entry.AncestorSize == 0 {
-		return 0, nil
-	}
-
-	return entry.AncestorFee / entry.AncestorSize, nil
+	return CalculateFeeRate(entry.AncestorFee, entry.AncestorSize), nil
 }
 
 // GetDescendants returns all descendant transactions
@@ -0,0 +1,347 @@
+package mempool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/fileutil"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// FeeEstimatesFileName is the file name used to persist fee history in the data directory
+const FeeEstimatesFileName = "fee_estimates.dat"
+
+const (
+	feeHistoryMagic   = 0x46454553 // "FEES"
+	feeHistoryVersion = 2
+
+	// MaxConfirmTarget is the largest confirmation target we track
+	MaxConfirmTarget = 25
+
+	// feeHistoryDecay is applied to all bucket counters on every block so
+	// old observations slowly lose their weight
+	feeHistoryDecay = 0.998
+
+	// minBucketSamples is the minimum (decayed) number of transactions a
+	// bucket needs before we trust its statistics
+	minBucketSamples = 1.0
+
+	// successThreshold is the fraction of transactions in a bucket that must
+	// have confirmed within the target for the bucket to be considered safe
+	successThreshold = 0.85
+)
+
+// feeBucketLimits are the lower bounds (sat/kvB) of each fee bucket
+var feeBucketLimits = []int64{1000, 2000, 3000, 5000, 8000, 12000, 18000, 27000, 40000, 60000, 90000, 135000, 200000, 300000, 450000, 700000, 1000000}
+
+// FeeBucket holds confirmation statistics for a range of fee rates
+type FeeBucket struct {
+	MinFeeRate int64                     // Lower bound of the bucket (sat/kvB)
+	TxCount    float64                   // Decayed number of transactions that left the tracker
+	Confirmed  [MaxConfirmTarget]float64 // Decayed count confirmed within i+1 blocks
+}
+
+// trackedTx remembers when a mempool transaction was first seen
+type trackedTx struct {
+	bucket int
+	height uint64
+}
+
+// FeeHistory tracks how fast transactions at different fee rates confirmed
+type FeeHistory struct {
+	mu sync.RWMutex
+
+	buckets    []FeeBucket
+	tracked    map[types.Hash]trackedTx
+	bestHeight uint64
+}
+
+// NewFeeHistory creates an empty fee history
+func NewFeeHistory() *FeeHistory {
+	buckets := make([]FeeBucket, len(feeBucketLimits))
+	for i, limit := range feeBucketLimits {
+		buckets[i].MinFeeRate = limit
+	}
+
+	return &FeeHistory{
+		buckets: buckets,
+		tracked: make(map[types.Hash]trackedTx),
+	}
+}
+
+// bucketIndex returns the bucket a fee rate falls into
+func bucketIndex(feeRate int64) int {
+	index := 0
+	for i, limit := range feeBucketLimits {
+		if feeRate >= limit {
+			index = i
+		}
+	}
+	return index
+}
+
+// TrackTransaction starts tracking a transaction that entered the mempool
+// paying feeRate sat/kvB
+func (fh *FeeHistory) TrackTransaction(txHash types.Hash, feeRate int64, height uint64) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	if _, exists := fh.tracked[txHash]; exists {
+		return
+	}
+
+	fh.tracked[txHash] = trackedTx{
+		bucket: bucketIndex(feeRate),
+		height: height,
+	}
+}
+
+// RemoveTransaction stops tracking a transaction that left the mempool
+// without being confirmed (evicted, expired or replaced)
+func (fh *FeeHistory) RemoveTransaction(txHash types.Hash) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	delete(fh.tracked, txHash)
+}
+
+// ProcessBlock records confirmations for tracked transactions in a new block
+func (fh *FeeHistory) ProcessBlock(height uint64, txHashes []types.Hash) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	// Ignore blocks we have already seen (e.g. during a reorg)
+	if height <= fh.bestHeight && fh.bestHeight != 0 {
+		return
+	}
+	fh.bestHeight = height
+
+	// Decay old data so recent blocks matter more
+	for i := range fh.buckets {
+		fh.buckets[i].TxCount *= feeHistoryDecay
+		for j := range fh.buckets[i].Confirmed {
+			fh.buckets[i].Confirmed[j] *= feeHistoryDecay
+		}
+	}
+
+	// Transactions that waited longer than we track count as failures
+	for txHash, tracked := range fh.tracked {
+		if height > tracked.height+MaxConfirmTarget {
+			fh.buckets[tracked.bucket].TxCount++
+			delete(fh.tracked, txHash)
+		}
+	}
+
+	for _, txHash := range txHashes {
+		tracked, exists := fh.tracked[txHash]
+		if !exists {
+			continue
+		}
+		delete(fh.tracked, txHash)
+
+		blocksToConfirm := 1
+		if height > tracked.height {
+			blocksToConfirm = int(height - tracked.height)
+		}
+
+		bucket := &fh.buckets[tracked.bucket]
+		bucket.TxCount++
+		for target := blocksToConfirm; target <= MaxConfirmTarget; target++ {
+			bucket.Confirmed[target-1]++
+		}
+	}
+}
+
+// EstimateFeeRate returns the lowest fee rate whose bucket (and all higher
+// buckets) confirmed reliably within targetBlocks. The second return value is
+// false when there is not enough data.
+func (fh *FeeHistory) EstimateFeeRate(targetBlocks int) (int64, bool) {
+	fh.mu.RLock()
+	defer fh.mu.RUnlock()
+
+	if targetBlocks < 1 {
+		targetBlocks = 1
+	}
+	if targetBlocks > MaxConfirmTarget {
+		targetBlocks = MaxConfirmTarget
+	}
+
+	// Walk from the highest bucket down, stop at the first unreliable one
+	found := false
+	feeRate := int64(0)
+	for i := len(fh.buckets) - 1; i >= 0; i-- {
+		bucket := fh.buckets[i]
+		if bucket.TxCount < minBucketSamples {
+			continue
+		}
+
+		if bucket.Confirmed[targetBlocks-1]/bucket.TxCount < successThreshold {
+			break
+		}
+
+		feeRate = bucket.MinFeeRate
+		found = true
+	}
+
+	return feeRate, found
+}
+
+// Buckets returns a copy of the fee buckets
+func (fh *FeeHistory) Buckets() []FeeBucket {
+	fh.mu.RLock()
+	defer fh.mu.RUnlock()
+
+	buckets := make([]FeeBucket, len(fh.buckets))
+	copy(buckets, fh.buckets)
+	return buckets
+}
+
+// BestHeight returns the height of the last processed block
+func (fh *FeeHistory) BestHeight() uint64 {
+	fh.mu.RLock()
+	defer fh.mu.RUnlock()
+
+	return fh.bestHeight
+}
+
+// SaveToFile writes the fee buckets to disk
+//
+// Only the bucket statistics are saved; transactions still waiting in the
+// mempool are not, since the mempool itself does not survive a restart.
+func (fh *FeeHistory) SaveToFile(path string) error {
+	fh.mu.RLock()
+	defer fh.mu.RUnlock()
+
+	err := fileutil.Write(path, 0644, func(file io.Writer) error {
+		w := bufio.NewWriter(file)
+		if err := fh.write(w); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write fee estimates file: %w", err)
+	}
+
+	return nil
+}
+
+// write serializes the buckets (internal, no lock)
+func (fh *FeeHistory) write(w *bufio.Writer) error {
+	if err := serialization.WriteUint32(w, feeHistoryMagic); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint32(w, feeHistoryVersion); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint64(w, fh.bestHeight); err != nil {
+		return err
+	}
+	if err := serialization.WriteVarInt(w, uint64(len(fh.buckets))); err != nil {
+		return err
+	}
+
+	for _, bucket := range fh.buckets {
+		if err := serialization.WriteUint64(w, uint64(bucket.MinFeeRate)); err != nil {
+			return err
+		}
+		if err := serialization.WriteUint64(w, math.Float64bits(bucket.TxCount)); err != nil {
+			return err
+		}
+		for _, confirmed := range bucket.Confirmed {
+			if err := serialization.WriteUint64(w, math.Float64bits(confirmed)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadFromFile restores fee buckets previously written by SaveToFile.
+// A missing file is not an error: the history simply starts empty.
+func (fh *FeeHistory) LoadFromFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open fee estimates file: %w", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+
+	magic, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("failed to read fee estimates header: %w", err)
+	}
+	if magic != feeHistoryMagic {
+		return fmt.Errorf("invalid fee estimates file magic: %08x", magic)
+	}
+
+	version, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("failed to read fee estimates version: %w", err)
+	}
+	// Version 1 stored the bucket limits in sat/vB
+	if version != feeHistoryVersion && version != 1 {
+		return fmt.Errorf("unsupported fee estimates version: %d", version)
+	}
+
+	bestHeight, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("failed to read best height: %w", err)
+	}
+
+	count, err := serialization.ReadVarInt(r)
+	if err != nil {
+		return fmt.Errorf("failed to read bucket count: %w", err)
+	}
+	if count != uint64(len(feeBucketLimits)) {
+		return fmt.Errorf("bucket count mismatch: got %d, want %d", count, len(feeBucketLimits))
+	}
+
+	buckets := make([]FeeBucket, count)
+	for i := range buckets {
+		minFeeRate, err := serialization.ReadUint64(r)
+		if err != nil {
+			return fmt.Errorf("failed to read bucket %d: %w", i, err)
+		}
+		if version == 1 {
+			minFeeRate *= transaction.FeeRateScale
+		}
+		if int64(minFeeRate) != feeBucketLimits[i] {
+			return fmt.Errorf("bucket %d limit mismatch: %d", i, minFeeRate)
+		}
+		buckets[i].MinFeeRate = int64(minFeeRate)
+
+		txCount, err := serialization.ReadUint64(r)
+		if err != nil {
+			return fmt.Errorf("failed to read bucket %d: %w", i, err)
+		}
+		buckets[i].TxCount = math.Float64frombits(txCount)
+
+		for j := range buckets[i].Confirmed {
+			confirmed, err := serialization.ReadUint64(r)
+			if err != nil {
+				return fmt.Errorf("failed to read bucket %d: %w", i, err)
+			}
+			buckets[i].Confirmed[j] = math.Float64frombits(confirmed)
+		}
+	}
+
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	fh.buckets = buckets
+	fh.bestHeight = bestHeight
+
+	return nil
+}
@@ -2,10 +2,12 @@ package mempool
 
 import (
 	"fmt"
+	"sort"
 	"sync"
-	"time"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
 
@@ -15,13 +17,20 @@ type MempoolEntry struct {
 	TxHash       types.Hash
 	Size         int64        // Transaction size in bytes
 	Fee          int64        // Transaction fee in satoshis
-	FeeRate      int64        // Fee per byte (satoshis/byte)
+	FeeRate      int64        // Fee per 1000 virtual bytes (sat/kvB)
 	Time         int64        // Time added to mempool (Unix timestamp)
 	Height       uint64       // Block height when added
 	Parents      []types.Hash // Parent transactions (dependencies)
 	Children     []types.Hash // Child transactions
 	AncestorFee  int64        // Total fee including ancestors
 	AncestorSize int64        // Total size including ancestors
+	SignalsRBF   bool         // Replaceable: it or an unconfirmed ancestor signals BIP125
+
+	// Coin-age priority, with a CoinLookup set (see Priority)
+	StartingPriority float64 // Priority in the block after Height
+	InChainValue     int64   // Value of the confirmed inputs, which age with each block
+
+	usage MemoryUsage // Counted against the size limit when added
 }
 
 // Mempool manages the transaction pool
@@ -36,26 +45,112 @@ type Mempool struct {
 	spentOutputs map[types.OutPoint]types.Hash
 
 	// Configuration
-	maxSize       int64  // Maximum mempool size in bytes
-	minFeeRate    int64  // Minimum fee rate (satoshis/byte)
-	maxTxAge      int64  // Maximum transaction age in seconds
-	currentSize   int64  // Current mempool size in bytes
-	currentHeight uint64 // Current blockchain height
+	maxSize        int64       // Maximum estimated memory usage in bytes
+	minFeeRate     int64       // Minimum relay fee rate (sat/kvB)
+	incrementalFee int64       // Fee rate replacements and evictions add (sat/kvB)
+	maxTxAge       int64       // Maximum transaction age in seconds
+	currentSize    int64       // Serialized size of the transactions in bytes
+	usage          MemoryUsage // Estimated memory usage, bounded by maxSize
+	currentHeight  uint64      // Current blockchain height
+
+	// Bumped whenever a transaction enters or leaves, so block template
+	// users can tell the pool changed without comparing contents
+	transactionsUpdated uint64
+
+	// Optional historical fee tracking
+	feeHistory *FeeHistory
+
+	// Optional, finds confirmed inputs for coin-age priority
+	coinLookup CoinLookup
+
+	// Time source for entry timestamps and expiry
+	clock clock.Clock
+
+	// Optional, told about transactions leaving without being mined
+	onRemoved func(txHash types.Hash, reason RemovalReason)
+
+	// Why recent transactions left, for diffing snapshots. The order
+	// bounds the log to maxDepartures.
+	departures     map[types.Hash]RemovalReason
+	departureOrder []types.Hash
 }
 
-// NewMempool creates a new mempool
+// NewMempool creates a new mempool. minFeeRate is the minimum relay fee
+// rate in sat/kvB; the incremental relay fee starts at its default.
 func NewMempool(maxSize int64, minFeeRate int64, maxTxAge int64) *Mempool {
 	return &Mempool{
-		entries:       make(map[types.Hash]*MempoolEntry),
-		spentOutputs:  make(map[types.OutPoint]types.Hash),
-		maxSize:       maxSize,
-		minFeeRate:    minFeeRate,
-		maxTxAge:      maxTxAge,
-		currentSize:   0,
-		currentHeight: 0,
+		entries:        make(map[types.Hash]*MempoolEntry),
+		spentOutputs:   make(map[types.OutPoint]types.Hash),
+		departures:     make(map[types.Hash]RemovalReason),
+		maxSize:        maxSize,
+		minFeeRate:     minFeeRate,
+		incrementalFee: DefaultIncrementalRelayFee,
+		maxTxAge:       maxTxAge,
+		currentSize:    0,
+		currentHeight:  0,
+		clock:          clock.Real,
 	}
 }
 
+// SetRelayFees sets the minimum relay fee rate transactions must pay and
+// the incremental relay fee rate replacements and evictions must add,
+// both in sat/kvB. Transactions already in the pool are kept.
+func (m *Mempool) SetRelayFees(minRelayTxFee, incrementalRelayFee int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.minFeeRate = minRelayTxFee
+	m.incrementalFee = incrementalRelayFee
+}
+
+// SetClock replaces the mempool's time source (used by tests)
+func (m *Mempool) SetClock(c clock.Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = c
+}
+
+// RemovalReason is why a transaction left the mempool
+type RemovalReason int
+
+const (
+	RemovalExpired  RemovalReason = iota // Older than the maximum age
+	RemovalEvicted                       // Pushed out of a full mempool
+	RemovalReplaced                      // Replaced by a higher-fee spend (BIP125)
+	RemovalConflict                      // Double-spent by a block
+	RemovalBlock                         // Mined
+)
+
+// String returns the reason as Bitcoin Core names it
+func (r RemovalReason) String() string {
+	switch r {
+	case RemovalExpired:
+		return "expiry"
+	case RemovalEvicted:
+		return "sizelimit"
+	case RemovalReplaced:
+		return "replaced"
+	case RemovalConflict:
+		return "conflict"
+	case RemovalBlock:
+		return "block"
+	default:
+		return "unknown"
+	}
+}
+
+// SetRemovalHandler registers fn to be told about every transaction that
+// leaves the mempool without being mined: evicted, expired, replaced or
+// conflicted by a block. Removals by Remove and Clear are not reported.
+// fn runs with the mempool locked and must not call back into it.
+func (m *Mempool) SetRemovalHandler(fn func(txHash types.Hash, reason RemovalReason)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onRemoved = fn
+}
+
 // Add adds a transaction to the mempool
 func (m *Mempool) Add(tx *types.Transaction, fee int64, height uint64) error {
 	m.mu.Lock()
@@ -69,16 +164,16 @@ func (m *Mempool) Add(tx *types.Transaction, fee int64, height uint64) error {
 
 	// Check if already in mempool
 	if _, exists := m.entries[txHash]; exists {
-		return fmt.Errorf("transaction already in mempool")
+		return ErrAlreadyInMempool
 	}
 
 	// Calculate transaction size
 	size := CalculateTransactionSize(tx)
 
 	// Calculate fee rate
-	feeRate := fee / size
-	if feeRate < m.minFeeRate {
-		return fmt.Errorf("fee rate too low: %d < %d", feeRate, m.minFeeRate)
+	feeRate := CalculateFeeRate(fee, size)
+	if err := m.checkFeeRateLocked(tx, fee); err != nil {
+		return err
 	}
 
 	// Check for conflicts (double-spends)
@@ -92,19 +187,20 @@ func (m *Mempool) Add(tx *types.Transaction, fee int64, height uint64) error {
 			// Check if this is Replace-By-Fee (RBF)
 			existingEntry := m.entries[existingTxHash]
 			if !m.canReplace(existingEntry, fee, feeRate) {
-				return fmt.Errorf("output already spent by %s", existingTxHash.String())
+				return fmt.Errorf("%w: output already spent by %s", ErrConflict, existingTxHash.String())
 			}
 
 			// Remove the existing transaction (RBF)
-			m.removeTransaction(existingTxHash)
+			m.dropTransaction(existingTxHash, RemovalReplaced)
 		}
 	}
 
-	// Check mempool size limit
-	if m.currentSize+size > m.maxSize {
+	// Check mempool size limit, against the memory the entry will take
+	usage := EstimateMemoryUsage(tx, len(m.findParents(tx))).Total()
+	if m.usage.Total()+usage > m.maxSize {
 		// Try to evict low-fee transactions
-		if !m.evictTransactions(size) {
-			return fmt.Errorf("mempool full and cannot evict transactions")
+		if err := m.evictTransactions(m.usage.Total()+usage-m.maxSize, size, feeRate); err != nil {
+			return err
 		}
 	}
 
@@ -118,18 +214,31 @@ func (m *Mempool) Add(tx *types.Transaction, fee int64, height uint64) error {
 		Size:     size,
 		Fee:      fee,
 		FeeRate:  feeRate,
-		Time:     time.Now().Unix(),
+		Time:     m.clock.Now().Unix(),
 		Height:   height,
 		Parents:  parents,
 		Children: make([]types.Hash, 0),
+		usage:    EstimateMemoryUsage(tx, len(parents)),
 	}
 
 	// Calculate ancestor fee and size
 	entry.AncestorFee, entry.AncestorSize = m.calculateAncestorMetrics(entry)
+	entry.StartingPriority, entry.InChainValue = m.startingPriority(tx, size, height)
+
+	// Spending a replaceable transaction makes this one replaceable too,
+	// since replacing the parent would evict it
+	entry.SignalsRBF = transaction.SignalsRBF(tx)
+	for _, parentHash := range parents {
+		if parent, exists := m.entries[parentHash]; exists && parent.SignalsRBF {
+			entry.SignalsRBF = true
+		}
+	}
 
 	// Add to mempool
 	m.entries[txHash] = entry
 	m.currentSize += size
+	m.usage.add(entry.usage)
+	m.transactionsUpdated++
 
 	// Update spent outputs index
 	for _, input := range tx.Inputs {
@@ -147,6 +256,30 @@ func (m *Mempool) Add(tx *types.Transaction, fee int64, height uint64) error {
 		}
 	}
 
+	// Start tracking confirmation time for fee estimation
+	if m.feeHistory != nil {
+		m.feeHistory.TrackTransaction(txHash, feeRate, height)
+	}
+
+	return nil
+}
+
+// CheckFeeRate fails with ErrLowFee if tx paying fee is below the minimum
+// fee rate. Callers run it before costlier checks such as script
+// evaluation, as Add would refuse the transaction anyway.
+func (m *Mempool) CheckFeeRate(tx *types.Transaction, fee int64) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.checkFeeRateLocked(tx, fee)
+}
+
+// checkFeeRateLocked is CheckFeeRate for callers holding the lock
+func (m *Mempool) checkFeeRateLocked(tx *types.Transaction, fee int64) error {
+	feeRate := CalculateFeeRate(fee, CalculateTransactionSize(tx))
+	if feeRate < m.minFeeRate {
+		return fmt.Errorf("%w: %s < %s", ErrLowFee, transaction.FormatFeeRate(feeRate), transaction.FormatFeeRate(m.minFeeRate))
+	}
 	return nil
 }
 
@@ -155,7 +288,23 @@ func (m *Mempool) Remove(txHash types.Hash) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	return m.removeTransaction(txHash)
+	removed := m.descendants(txHash)
+	if err := m.removeTransaction(txHash); err != nil {
+		return err
+	}
+	m.untrack(removed)
+	return nil
+}
+
+// untrack stops fee estimation waiting for transactions that left the
+// mempool unconfirmed (internal, no lock)
+func (m *Mempool) untrack(hashes []types.Hash) {
+	if m.feeHistory == nil {
+		return
+	}
+	for _, hash := range hashes {
+		m.feeHistory.RemoveTransaction(hash)
+	}
 }
 
 // removeTransaction removes a transaction (internal, no lock)
@@ -168,6 +317,8 @@ func (m *Mempool) removeTransaction(txHash types.Hash) error {
 	// Remove from entries
 	delete(m.entries, txHash)
 	m.currentSize -= entry.Size
+	m.usage.sub(entry.usage)
+	m.transactionsUpdated++
 
 	// Remove from spent outputs index
 	for _, input := range entry.Tx.Inputs {
@@ -193,6 +344,103 @@ func (m *Mempool) removeTransaction(txHash types.Hash) error {
 	return nil
 }
 
+// dropTransaction removes a transaction and its descendants for reason,
+// telling the removal handler about each (internal, no lock)
+func (m *Mempool) dropTransaction(txHash types.Hash, reason RemovalReason) {
+	dropped := m.descendants(txHash)
+	m.removeTransaction(txHash)
+
+	for _, hash := range dropped {
+		m.recordDeparture(hash, reason)
+	}
+	m.untrack(dropped)
+	if m.onRemoved == nil {
+		return
+	}
+	for _, hash := range dropped {
+		m.onRemoved(hash, reason)
+	}
+}
+
+// descendants returns txHash and every mempool transaction spending its
+// outputs, directly or not (internal, no lock)
+func (m *Mempool) descendants(txHash types.Hash) []types.Hash {
+	result := []types.Hash{txHash}
+	seen := map[types.Hash]bool{txHash: true}
+	for i := 0; i < len(result); i++ {
+		entry, exists := m.entries[result[i]]
+		if !exists {
+			continue
+		}
+		for _, child := range entry.Children {
+			if !seen[child] {
+				seen[child] = true
+				result = append(result, child)
+			}
+		}
+	}
+	return result
+}
+
+// RemoveConfirmed removes transactions included in a block. Unlike Remove,
+// their children stay in the mempool since their inputs are now confirmed.
+func (m *Mempool) RemoveConfirmed(txs []types.Transaction) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for i := range txs {
+		txHash, err := serialization.HashTransaction(&txs[i])
+		if err != nil {
+			continue
+		}
+
+		entry, exists := m.entries[txHash]
+		if !exists {
+			continue
+		}
+
+		// Detach children so removeTransaction doesn't take them along
+		for _, childHash := range entry.Children {
+			if child, ok := m.entries[childHash]; ok {
+				child.Parents = removeHash(child.Parents, txHash)
+			}
+		}
+		entry.Children = nil
+
+		m.removeTransaction(txHash)
+		m.recordDeparture(txHash, RemovalBlock)
+		removed++
+	}
+
+	return removed
+}
+
+// RemoveConflicts removes mempool transactions (and their descendants)
+// that spend an output also spent by one of txs, e.g. a newly connected
+// block. Transactions in txs themselves are left alone.
+func (m *Mempool) RemoveConflicts(txs []types.Transaction) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := len(m.entries)
+	for i := range txs {
+		txHash, err := serialization.HashTransaction(&txs[i])
+		if err != nil {
+			continue
+		}
+
+		for _, input := range txs[i].Inputs {
+			outpoint := types.OutPoint{Hash: input.PrevTxHash, Index: input.OutputIndex}
+			if spender, exists := m.spentOutputs[outpoint]; exists && spender != txHash {
+				m.dropTransaction(spender, RemovalConflict)
+			}
+		}
+	}
+
+	return before - len(m.entries)
+}
+
 // Get retrieves a transaction from the mempool
 func (m *Mempool) Get(txHash types.Hash) (*MempoolEntry, error) {
 	m.mu.RLock()
@@ -215,6 +463,15 @@ func (m *Mempool) Exists(txHash types.Hash) bool {
 	return exists
 }
 
+// SpentBy returns the mempool transaction spending outpoint, if any
+func (m *Mempool) SpentBy(outpoint types.OutPoint) (types.Hash, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	spender, exists := m.spentOutputs[outpoint]
+	return spender, exists
+}
+
 // Size returns the number of transactions in the mempool
 func (m *Mempool) Size() int {
 	m.mu.RLock()
@@ -223,11 +480,51 @@ func (m *Mempool) Size() int {
 	return len(m.entries)
 }
 
-// GetMemoryUsage returns current memory usage in bytes
+// MaxSize returns the most memory, in bytes, the pool's estimated usage
+// may reach
+func (m *Mempool) MaxSize() int64 {
+	return m.maxSize
+}
+
+// MinFeeRate returns the lowest fee rate the pool accepts, the minimum
+// relay fee (sat/kvB)
+func (m *Mempool) MinFeeRate() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.minFeeRate
+}
+
+// IncrementalRelayFee returns the fee rate a replacement must add for the
+// size it replaces (sat/kvB)
+func (m *Mempool) IncrementalRelayFee() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.incrementalFee
+}
+
+// GetMemoryUsage returns the estimated memory usage in bytes
 func (m *Mempool) GetMemoryUsage() int64 {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	return m.usage.Total()
+}
+
+// MemoryUsage returns the estimated memory usage split by what holds it
+func (m *Mempool) MemoryUsage() MemoryUsage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.usage
+}
+
+// Bytes returns the serialized size of the pool's transactions
+func (m *Mempool) Bytes() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	return m.currentSize
 }
 
@@ -268,6 +565,11 @@ func (m *Mempool) calculateAncestorMetrics(entry *MempoolEntry) (int64, int64) {
 
 // canReplace checks if a transaction can be replaced (RBF)
 func (m *Mempool) canReplace(existing *MempoolEntry, newFee int64, newFeeRate int64) bool {
+	// Only transactions that opted in may be replaced
+	if !existing.SignalsRBF {
+		return false
+	}
+
 	// New transaction must pay higher fee
 	if newFee <= existing.Fee {
 		return false
@@ -278,44 +580,59 @@ func (m *Mempool) canReplace(existing *MempoolEntry, newFee int64, newFeeRate in
 		return false
 	}
 
-	// Additional fee must be at least minFeeRate * size
+	// Additional fee must pay the incremental relay fee for the replaced
+	// size
 	additionalFee := newFee - existing.Fee
-	if additionalFee < m.minFeeRate*existing.Size {
+	if additionalFee < transaction.FeeAtRate(m.incrementalFee, existing.Size) {
 		return false
 	}
 
 	return true
 }
 
-// evictTransactions evicts low-fee transactions to make room
-func (m *Mempool) evictTransactions(neededSize int64) bool {
-	// Get all transactions sorted by fee rate (ascending)
+// evictTransactions evicts the lowest fee rate transactions to free
+// neededSize bytes of memory for a transaction of the given serialized
+// size and fee rate. Only transactions paying at least the incremental
+// relay fee less than it are evicted, and nothing is evicted if that
+// isn't enough.
+func (m *Mempool) evictTransactions(neededSize int64, size int64, feeRate int64) error {
+	if neededSize > m.usage.Total() {
+		return fmt.Errorf("%w: transaction of %d bytes doesn't fit in a mempool of %d bytes of memory", ErrTooLarge, size, m.maxSize)
+	}
+
+	// Get all transactions sorted by fee rate (lowest first)
 	entries := make([]*MempoolEntry, 0, len(m.entries))
 	for _, entry := range m.entries {
 		entries = append(entries, entry)
 	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FeeRate < entries[j].FeeRate
+	})
 
-	// Sort by fee rate (lowest first)
-	for i := 0; i < len(entries)-1; i++ {
-		for j := i + 1; j < len(entries); j++ {
-			if entries[i].FeeRate > entries[j].FeeRate {
-				entries[i], entries[j] = entries[j], entries[i]
-			}
-		}
+	// Find how many we'd have to evict before touching any
+	freedSize := int64(0)
+	count := 0
+	for freedSize < neededSize {
+		freedSize += entries[count].usage.Total()
+		count++
 	}
 
-	// Evict transactions until we have enough space
-	freedSize := int64(0)
-	for _, entry := range entries {
-		m.removeTransaction(entry.TxHash)
-		freedSize += entry.Size
+	if floor := entries[count-1].FeeRate + m.incrementalFee; feeRate < floor {
+		return &MempoolFullError{
+			FeeRate:     feeRate,
+			MinFeeRate:  floor,
+			RequiredFee: transaction.FeeAtRate(floor, size),
+		}
+	}
 
-		if freedSize >= neededSize {
-			return true
+	for _, entry := range entries[:count] {
+		// Evicting a parent can take its children with it
+		if _, exists := m.entries[entry.TxHash]; exists {
+			m.dropTransaction(entry.TxHash, RemovalEvicted)
 		}
 	}
 
-	return false
+	return nil
 }
 
 // ExpireTransactions removes transactions older than maxTxAge
@@ -327,7 +644,7 @@ func (m *Mempool) ExpireTransactions() int {
 		return 0
 	}
 
-	currentTime := time.Now().Unix()
+	currentTime := m.clock.Now().Unix()
 	expired := make([]types.Hash, 0)
 
 	for txHash, entry := range m.entries {
@@ -339,7 +656,9 @@ func (m *Mempool) ExpireTransactions() int {
 
 	// Remove expired transactions
 	for _, txHash := range expired {
-		m.removeTransaction(txHash)
+		if _, exists := m.entries[txHash]; exists {
+			m.dropTransaction(txHash, RemovalExpired)
+		}
 	}
 
 	return len(expired)
@@ -366,6 +685,33 @@ func (m *Mempool) Clear() {
 	m.entries = make(map[types.Hash]*MempoolEntry)
 	m.spentOutputs = make(map[types.OutPoint]types.Hash)
 	m.currentSize = 0
+	m.usage = MemoryUsage{}
+	m.transactionsUpdated++
+}
+
+// TransactionsUpdated returns a counter that changes every time a
+// transaction is added to or removed from the mempool
+func (m *Mempool) TransactionsUpdated() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.transactionsUpdated
+}
+
+// SetFeeHistory attaches a fee history tracker to the mempool
+func (m *Mempool) SetFeeHistory(history *FeeHistory) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.feeHistory = history
+}
+
+// FeeHistory returns the attached fee history tracker (may be nil)
+func (m *Mempool) FeeHistory() *FeeHistory {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.feeHistory
 }
 
 // UpdateHeight updates the current blockchain height
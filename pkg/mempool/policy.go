@@ -3,36 +3,53 @@ package mempool
 import (
 	"fmt"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
 
+// Default relay fees, in sat/kvB
+const (
+	// DefaultMinRelayTxFee is the lowest fee rate a transaction may pay
+	// to be accepted and relayed
+	DefaultMinRelayTxFee = 1000
+
+	// DefaultIncrementalRelayFee is the fee rate a replacement must add
+	// for the size it replaces, and how far a full pool's floor rises
+	// above the transactions it evicts
+	DefaultIncrementalRelayFee = 1000
+)
+
 // Policy defines mempool acceptance policies
 type Policy struct {
-	MinFeeRate         int64 // Minimum fee rate (satoshis/byte)
-	MaxTxSize          int64 // Maximum transaction size
-	MaxAncestorCount   int   // Maximum number of ancestors
-	MaxAncestorSize    int64 // Maximum total size of ancestors
-	MaxDescendantCount int   // Maximum number of descendants
-	MaxDescendantSize  int64 // Maximum total size of descendants
-	RequireStandard    bool  // Require standard transaction types
-	AllowRBF           bool  // Allow Replace-By-Fee
-	MaxSigOps          int   // Maximum signature operations
-	DustThreshold      int64 // Minimum output value (dust threshold)
+	MinRelayTxFee       int64 // Minimum fee rate to be accepted (sat/kvB)
+	IncrementalRelayFee int64 // Fee rate replacements must add (sat/kvB)
+	MaxTxSize           int64 // Maximum transaction size
+	MaxAncestorCount    int   // Maximum number of ancestors
+	MaxAncestorSize     int64 // Maximum total size of ancestors
+	MaxDescendantCount  int   // Maximum number of descendants
+	MaxDescendantSize   int64 // Maximum total size of descendants
+	RequireStandard     bool  // Require standard transaction types
+	AllowRBF            bool  // Allow Replace-By-Fee
+	MaxSigOps           int   // Maximum signature operations
+	DustThreshold       int64 // Minimum output value (dust threshold)
 }
 
 // DefaultPolicy returns the default mempool policy
 func DefaultPolicy() *Policy {
 	return &Policy{
-		MinFeeRate:         1,      // 1 satoshi per byte
-		MaxTxSize:          100000, // 100 KB
-		MaxAncestorCount:   25,
-		MaxAncestorSize:    101000, // 101 KB
-		MaxDescendantCount: 25,
-		MaxDescendantSize:  101000, // 101 KB
-		RequireStandard:    true,
-		AllowRBF:           true,
-		MaxSigOps:          4000,
-		DustThreshold:      546, // 546 satoshis (standard dust threshold)
+		MinRelayTxFee:       DefaultMinRelayTxFee,
+		IncrementalRelayFee: DefaultIncrementalRelayFee,
+		MaxTxSize:           100000, // 100 KB
+		MaxAncestorCount:    25,
+		MaxAncestorSize:     101000, // 101 KB
+		MaxDescendantCount:  25,
+		MaxDescendantSize:   101000, // 101 KB
+		RequireStandard:     true,
+		AllowRBF:            true,
+		MaxSigOps:           4000,
+		DustThreshold:       546, // 546 satoshis (standard dust threshold)
 	}
 }
 
@@ -55,13 +72,13 @@ func (pv *PolicyValidator) ValidateTransaction(tx *types.Transaction, fee int64)
 	// Check transaction size
 	size := CalculateTransactionSize(tx)
 	if size > pv.policy.MaxTxSize {
-		return fmt.Errorf("transaction too large: %d > %d", size, pv.policy.MaxTxSize)
+		return fmt.Errorf("%w: %d > %d", ErrTooLarge, size, pv.policy.MaxTxSize)
 	}
 
 	// Check fee rate
 	feeRate := CalculateFeeRate(fee, size)
-	if feeRate < pv.policy.MinFeeRate {
-		return fmt.Errorf("fee rate too low: %d < %d", feeRate, pv.policy.MinFeeRate)
+	if feeRate < pv.policy.MinRelayTxFee {
+		return fmt.Errorf("%w: %s < %s", ErrLowFee, transaction.FormatFeeRate(feeRate), transaction.FormatFeeRate(pv.policy.MinRelayTxFee))
 	}
 
 	// Check for dust outputs
@@ -87,8 +104,12 @@ func (pv *PolicyValidator) ValidateTransaction(tx *types.Transaction, fee int64)
 // checkDustOutputs checks for dust outputs
 func (pv *PolicyValidator) checkDustOutputs(tx *types.Transaction) error {
 	for i, output := range tx.Outputs {
+		// OP_RETURN outputs are unspendable and carry no value by design
+		if script.IsNullData(output.PubKeyScript) {
+			continue
+		}
 		if output.Value < pv.policy.DustThreshold {
-			return fmt.Errorf("output %d is dust: %d < %d", i, output.Value, pv.policy.DustThreshold)
+			return fmt.Errorf("%w: output %d is dust: %d < %d", ErrNonStandard, i, output.Value, pv.policy.DustThreshold)
 		}
 	}
 	return nil
@@ -98,29 +119,29 @@ func (pv *PolicyValidator) checkDustOutputs(tx *types.Transaction) error {
 func (pv *PolicyValidator) checkStandardTransaction(tx *types.Transaction) error {
 	// Check version
 	if tx.Version < 1 || tx.Version > 2 {
-		return fmt.Errorf("non-standard version: %d", tx.Version)
+		return fmt.Errorf("%w: version %d", ErrNonStandard, tx.Version)
 	}
 
 	// Check inputs
 	if len(tx.Inputs) == 0 {
-		return fmt.Errorf("no inputs")
+		return fmt.Errorf("%w: no inputs", ErrNonStandard)
 	}
 
 	// Check outputs
 	if len(tx.Outputs) == 0 {
-		return fmt.Errorf("no outputs")
+		return fmt.Errorf("%w: no outputs", ErrNonStandard)
 	}
 
 	// Check for null data outputs (OP_RETURN)
 	nullDataCount := 0
 	for _, output := range tx.Outputs {
-		if len(output.PubKeyScript) > 0 && output.PubKeyScript[0] == 0x6a { // OP_RETURN
+		if script.IsNullData(output.PubKeyScript) {
 			nullDataCount++
 			if nullDataCount > 1 {
-				return fmt.Errorf("multiple OP_RETURN outputs")
+				return fmt.Errorf("%w: multiple OP_RETURN outputs", ErrNonStandard)
 			}
-			if len(output.PubKeyScript) > 83 {
-				return fmt.Errorf("OP_RETURN output too large")
+			if len(output.PubKeyScript) > script.MaxNullDataScriptSize {
+				return fmt.Errorf("%w: OP_RETURN output too large", ErrNonStandard)
 			}
 		}
 	}
@@ -128,6 +149,54 @@ func (pv *PolicyValidator) checkStandardTransaction(tx *types.Transaction) error
 	return nil
 }
 
+// CheckInputScripts enforces script standardness on a transaction's
+// inputs, given the outputs they spend in input order. It is a no-op when
+// the policy doesn't require standard transactions.
+func (pv *PolicyValidator) CheckInputScripts(tx *types.Transaction, prevOuts []types.TxOutput) error {
+	if !pv.policy.RequireStandard {
+		return nil
+	}
+	return CheckInputStandardness(tx, prevOuts)
+}
+
+// CheckInputStandardness checks that every scriptSig is push-only with
+// minimal pushes and leaves a clean stack when run against the output it
+// spends. Failures wrap ErrNonStandard.
+func CheckInputStandardness(tx *types.Transaction, prevOuts []types.TxOutput) error {
+	if len(prevOuts) != len(tx.Inputs) {
+		return fmt.Errorf("%d previous outputs for %d inputs", len(prevOuts), len(tx.Inputs))
+	}
+	for i, input := range tx.Inputs {
+		if err := script.VerifyStandardInput(input.SignatureScript, prevOuts[i].PubKeyScript, tx, i); err != nil {
+			return fmt.Errorf("%w: input %d: %v", ErrNonStandard, i, err)
+		}
+	}
+	return nil
+}
+
+// CheckWitnessScripts runs the witness of every input spending a version
+// 0 witness program or a taproot output, signatures included, given the
+// outputs the inputs spend in input order. Failures wrap ErrScriptFailed.
+func CheckWitnessScripts(tx *types.Transaction, prevOuts []types.TxOutput) error {
+	if len(prevOuts) != len(tx.Inputs) {
+		return fmt.Errorf("%d previous outputs for %d inputs", len(prevOuts), len(tx.Inputs))
+	}
+	for i := range tx.Inputs {
+		var err error
+		version, program, ok := script.ExtractWitnessProgram(prevOuts[i].PubKeyScript)
+		switch {
+		case ok && version == 0:
+			err = transaction.VerifyWitnessInput(tx, i, prevOuts[i])
+		case ok && version == 1 && len(program) == keys.XOnlyPubKeySize:
+			err = transaction.VerifyTaprootInput(tx, i, prevOuts)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: input %d: %v", ErrScriptFailed, i, err)
+		}
+	}
+	return nil
+}
+
 // checkSigOps checks signature operations count
 func (pv *PolicyValidator) checkSigOps(tx *types.Transaction) error {
 	// Simplified: count inputs as potential sig ops
@@ -135,7 +204,7 @@ func (pv *PolicyValidator) checkSigOps(tx *types.Transaction) error {
 	sigOps := len(tx.Inputs) * 2 // Assume 2 sig ops per input (conservative)
 
 	if sigOps > pv.policy.MaxSigOps {
-		return fmt.Errorf("too many signature operations: %d > %d", sigOps, pv.policy.MaxSigOps)
+		return fmt.Errorf("%w: too many signature operations: %d > %d", ErrNonStandard, sigOps, pv.policy.MaxSigOps)
 	}
 
 	return nil
@@ -169,11 +238,11 @@ func (pv *PolicyValidator) CheckAncestorLimits(tx *types.Transaction) error {
 
 	// Check limits
 	if ancestorCount > pv.policy.MaxAncestorCount {
-		return fmt.Errorf("too many ancestors: %d > %d", ancestorCount, pv.policy.MaxAncestorCount)
+		return fmt.Errorf("%w: too many ancestors: %d > %d", ErrNonStandard, ancestorCount, pv.policy.MaxAncestorCount)
 	}
 
 	if ancestorSize > pv.policy.MaxAncestorSize {
-		return fmt.Errorf("ancestor size too large: %d > %d", ancestorSize, pv.policy.MaxAncestorSize)
+		return fmt.Errorf("%w: ancestor size too large: %d > %d", ErrNonStandard, ancestorSize, pv.policy.MaxAncestorSize)
 	}
 
 	return nil
@@ -198,11 +267,11 @@ func (pv *PolicyValidator) CheckDescendantLimits(tx *types.Transaction) error {
 			}
 
 			if descendantCount > pv.policy.MaxDescendantCount {
-				return fmt.Errorf("too many descendants: %d > %d", descendantCount, pv.policy.MaxDescendantCount)
+				return fmt.Errorf("%w: too many descendants: %d > %d", ErrNonStandard, descendantCount, pv.policy.MaxDescendantCount)
 			}
 
 			if descendantSize > pv.policy.MaxDescendantSize {
-				return fmt.Errorf("descendant size too large: %d > %d", descendantSize, pv.policy.MaxDescendantSize)
+				return fmt.Errorf("%w: descendant size too large: %d > %d", ErrNonStandard, descendantSize, pv.policy.MaxDescendantSize)
 			}
 		}
 	}
@@ -217,19 +286,13 @@ func (pv *PolicyValidator) IsRBFSignaled(tx *types.Transaction) bool {
 	}
 
 	// Check if any input has sequence < 0xfffffffe (BIP 125)
-	for _, input := range tx.Inputs {
-		if input.Sequence < 0xfffffffe {
-			return true
-		}
-	}
-
-	return false
+	return transaction.SignalsRBF(tx)
 }
 
 // ValidateReplacement validates a replacement transaction (RBF)
 func (pv *PolicyValidator) ValidateReplacement(newTx *types.Transaction, newFee int64, conflictingTxs []*MempoolEntry) error {
 	if !pv.policy.AllowRBF {
-		return fmt.Errorf("RBF not allowed")
+		return fmt.Errorf("%w: RBF not allowed", ErrConflict)
 	}
 
 	// Calculate new transaction fee rate
@@ -240,20 +303,20 @@ func (pv *PolicyValidator) ValidateReplacement(newTx *types.Transaction, newFee
 	for _, conflicting := range conflictingTxs {
 		// Rule 1: New transaction must pay higher absolute fee
 		if newFee <= conflicting.Fee {
-			return fmt.Errorf("new fee not higher than existing: %d <= %d", newFee, conflicting.Fee)
+			return fmt.Errorf("%w: new fee not higher than existing: %d <= %d", ErrLowFee, newFee, conflicting.Fee)
 		}
 
 		// Rule 2: New transaction must have higher fee rate
 		if newFeeRate <= conflicting.FeeRate {
-			return fmt.Errorf("new fee rate not higher: %d <= %d", newFeeRate, conflicting.FeeRate)
+			return fmt.Errorf("%w: new fee rate not higher: %s <= %s", ErrLowFee, transaction.FormatFeeRate(newFeeRate), transaction.FormatFeeRate(conflicting.FeeRate))
 		}
 
 		// Rule 3: Additional fee must cover bandwidth cost
 		additionalFee := newFee - conflicting.Fee
-		minAdditionalFee := pv.policy.MinFeeRate * conflicting.Size
+		minAdditionalFee := transaction.FeeAtRate(pv.policy.IncrementalRelayFee, conflicting.Size)
 
 		if additionalFee < minAdditionalFee {
-			return fmt.Errorf("additional fee too low: %d < %d", additionalFee, minAdditionalFee)
+			return fmt.Errorf("%w: additional fee too low: %d < %d", ErrLowFee, additionalFee, minAdditionalFee)
 		}
 	}
 
@@ -263,15 +326,16 @@ func (pv *PolicyValidator) ValidateReplacement(newTx *types.Transaction, newFee
 // GetPolicyInfo returns information about the current policy
 func (pv *PolicyValidator) GetPolicyInfo() map[string]interface{} {
 	return map[string]interface{}{
-		"min_fee_rate":         pv.policy.MinFeeRate,
-		"max_tx_size":          pv.policy.MaxTxSize,
-		"max_ancestor_count":   pv.policy.MaxAncestorCount,
-		"max_ancestor_size":    pv.policy.MaxAncestorSize,
-		"max_descendant_count": pv.policy.MaxDescendantCount,
-		"max_descendant_size":  pv.policy.MaxDescendantSize,
-		"require_standard":     pv.policy.RequireStandard,
-		"allow_rbf":            pv.policy.AllowRBF,
-		"max_sig_ops":          pv.policy.MaxSigOps,
-		"dust_threshold":       pv.policy.DustThreshold,
+		"min_relay_tx_fee":      pv.policy.MinRelayTxFee,
+		"incremental_relay_fee": pv.policy.IncrementalRelayFee,
+		"max_tx_size":           pv.policy.MaxTxSize,
+		"max_ancestor_count":    pv.policy.MaxAncestorCount,
+		"max_ancestor_size":     pv.policy.MaxAncestorSize,
+		"max_descendant_count":  pv.policy.MaxDescendantCount,
+		"max_descendant_size":   pv.policy.MaxDescendantSize,
+		"require_standard":      pv.policy.RequireStandard,
+		"allow_rbf":             pv.policy.AllowRBF,
+		"max_sig_ops":           pv.policy.MaxSigOps,
+		"dust_threshold":        pv.policy.DustThreshold,
 	}
 }
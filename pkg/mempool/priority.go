@@ -0,0 +1,60 @@
+package mempool
+
+import (
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// HighPriority is the coin-age priority old Bitcoin Core nodes relayed and
+// mined for free: one bitcoin a day old (144 blocks) in a 250 byte
+// transaction
+const HighPriority = 100000000 * 144 / 250.0
+
+// CoinLookup finds a confirmed output, returning its value and the height
+// of the block that created it. ok is false for an unknown output.
+type CoinLookup func(outpoint types.OutPoint) (value int64, height uint64, ok bool)
+
+// SetCoinLookup turns on coin-age priority for transactions added from
+// now on, finding their inputs with lookup. Without it priority is 0.
+func (m *Mempool) SetCoinLookup(lookup CoinLookup) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.coinLookup = lookup
+}
+
+// Priority returns the entry's coin-age priority in a block at height: the
+// sum of each confirmed input's value times its age in blocks by then,
+// divided by the size. Inputs from the mempool have no age and never gain
+// any here, even once their parent confirms.
+func (e *MempoolEntry) Priority(height uint64) float64 {
+	mined := e.Height + 1 // StartingPriority is for the next block
+	if height <= mined || e.Size == 0 {
+		return e.StartingPriority
+	}
+	return e.StartingPriority + float64(e.InChainValue)*float64(height-mined)/float64(e.Size)
+}
+
+// startingPriority computes the priority of tx, of size bytes, in the
+// block after height, and the value of its confirmed inputs. The caller
+// holds m.mu.
+func (m *Mempool) startingPriority(tx *types.Transaction, size int64, height uint64) (float64, int64) {
+	if m.coinLookup == nil || size == 0 {
+		return 0, 0
+	}
+
+	var coinAge float64
+	var inChain int64
+	for _, input := range tx.Inputs {
+		if _, unconfirmed := m.entries[input.PrevTxHash]; unconfirmed {
+			continue
+		}
+		value, coinHeight, ok := m.coinLookup(types.OutPoint{Hash: input.PrevTxHash, Index: input.OutputIndex})
+		if !ok || coinHeight > height {
+			continue
+		}
+		// Confirmations at the tip, which is its age in the next block
+		coinAge += float64(value) * float64(height-coinHeight+1)
+		inChain += value
+	}
+	return coinAge / float64(size), inChain
+}
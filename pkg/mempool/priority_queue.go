@@ -2,14 +2,48 @@ package mempool
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
 
+// SelectionOrder is how block assembly ranks mempool transactions
+type SelectionOrder int
+
+const (
+	// OrderFeeRate ranks by ancestor fee rate, as miners do today
+	OrderFeeRate SelectionOrder = iota
+	// OrderPriority ranks by coin-age priority, as early miners did.
+	// Needs a CoinLookup on the mempool.
+	OrderPriority
+)
+
+// String returns the name ParseSelectionOrder accepts
+func (o SelectionOrder) String() string {
+	if o == OrderPriority {
+		return "priority"
+	}
+	return "feerate"
+}
+
+// ParseSelectionOrder parses "feerate" or "priority"
+func ParseSelectionOrder(name string) (SelectionOrder, error) {
+	switch name {
+	case "feerate":
+		return OrderFeeRate, nil
+	case "priority":
+		return OrderPriority, nil
+	default:
+		return OrderFeeRate, fmt.Errorf("unknown selection order %q, use feerate or priority", name)
+	}
+}
+
 // PriorityQueue manages transaction selection for block building
 type PriorityQueue struct {
 	mempool *Mempool
 	entries []*MempoolEntry
+	order   SelectionOrder
+	height  uint64 // Of the block being built, for OrderPriority
 }
 
 // NewPriorityQueue creates a new priority queue
@@ -20,6 +54,13 @@ func NewPriorityQueue(mempool *Mempool) *PriorityQueue {
 	}
 }
 
+// SetOrder changes how transactions are ranked. Priority depends on the
+// height of the block being built.
+func (pq *PriorityQueue) SetOrder(order SelectionOrder, height uint64) {
+	pq.order = order
+	pq.height = height
+}
+
 // Build builds the priority queue from mempool
 func (pq *PriorityQueue) Build() {
 	pq.mempool.mu.RLock()
@@ -31,10 +72,27 @@ func (pq *PriorityQueue) Build() {
 		pq.entries = append(pq.entries, entry)
 	}
 
+	if pq.order == OrderPriority {
+		pq.sortByPriority()
+		return
+	}
+
 	// Sort by ancestor fee rate (descending)
 	pq.sortByAncestorFeeRate()
 }
 
+// sortByPriority sorts entries by coin-age priority (highest first), ties
+// broken by fee rate
+func (pq *PriorityQueue) sortByPriority() {
+	sort.SliceStable(pq.entries, func(i, j int) bool {
+		pi, pj := pq.entries[i].Priority(pq.height), pq.entries[j].Priority(pq.height)
+		if pi != pj {
+			return pi > pj
+		}
+		return pq.entries[i].FeeRate > pq.entries[j].FeeRate
+	})
+}
+
 // sortByAncestorFeeRate sorts entries by ancestor fee rate (highest first)
 func (pq *PriorityQueue) sortByAncestorFeeRate() {
 	for i := 0; i < len(pq.entries)-1; i++ {
@@ -49,12 +107,10 @@ func (pq *PriorityQueue) sortByAncestorFeeRate() {
 	}
 }
 
-// getAncestorFeeRate calculates the ancestor fee rate for an entry
+// getAncestorFeeRate calculates the ancestor fee rate for an entry, in
+// sat/kvB
 func (pq *PriorityQueue) getAncestorFeeRate(entry *MempoolEntry) int64 {
-	if entry.AncestorSize == 0 {
-		return 0
-	}
-	return entry.AncestorFee / entry.AncestorSize
+	return CalculateFeeRate(entry.AncestorFee, entry.AncestorSize)
 }
 
 // SelectTransactions selects transactions for a block
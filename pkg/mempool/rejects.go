@@ -0,0 +1,111 @@
+package mempool
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// DefaultRecentRejectsSize bounds the recent-rejects filter, the same
+// number of entries as Bitcoin Core's rolling filter
+const DefaultRecentRejectsSize = 120000
+
+// RejectEntry is why a transaction was refused
+type RejectEntry struct {
+	Reason string
+	Policy bool // Refused for the state of the mempool, reset on a new tip
+}
+
+// RecentRejects remembers transactions the node refused, so the same
+// transaction from another peer is neither requested nor validated again.
+// Entries are keyed by witness hash: a txid rejection could have been
+// caused by a malleated witness and must not block the valid version.
+// The oldest entries are forgotten once the filter is full.
+type RecentRejects struct {
+	entries map[types.Hash]RejectEntry
+	order   []types.Hash // Insertion order, oldest first
+	size    int
+	mu      sync.Mutex
+}
+
+// NewRecentRejects creates a filter holding up to size entries
+func NewRecentRejects(size int) *RecentRejects {
+	return &RecentRejects{
+		entries: make(map[types.Hash]RejectEntry),
+		size:    size,
+	}
+}
+
+// IsPolicyReject reports whether err refuses a transaction for the
+// mempool's current contents, which a new block can change: a fee rate
+// below a full mempool's minimum or a conflict with a transaction that
+// may since have been mined or evicted. Invalid and non-standard
+// transactions stay refused whatever the tip.
+func IsPolicyReject(err error) bool {
+	return errors.Is(err, ErrLowFee) || errors.Is(err, ErrMempoolFull) || errors.Is(err, ErrConflict)
+}
+
+// Add records that the transaction with witness hash wtxid was refused
+// with err. Duplicates of mempool transactions aren't rejections, and a
+// transaction missing inputs may be fine once its parent arrives, so
+// neither is recorded.
+func (rr *RecentRejects) Add(wtxid types.Hash, err error) {
+	if err == nil || errors.Is(err, ErrAlreadyInMempool) || errors.Is(err, ErrMissingInputs) || rr.size <= 0 {
+		return
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if _, ok := rr.entries[wtxid]; !ok {
+		for len(rr.entries) >= rr.size {
+			delete(rr.entries, rr.order[0])
+			rr.order = rr.order[1:]
+		}
+		rr.order = append(rr.order, wtxid)
+	}
+	rr.entries[wtxid] = RejectEntry{Reason: err.Error(), Policy: IsPolicyReject(err)}
+}
+
+// Lookup returns why wtxid was refused, if it was
+func (rr *RecentRejects) Lookup(wtxid types.Hash) (RejectEntry, bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	entry, ok := rr.entries[wtxid]
+	return entry, ok
+}
+
+// Contains reports whether wtxid was recently refused
+func (rr *RecentRejects) Contains(wtxid types.Hash) bool {
+	_, ok := rr.Lookup(wtxid)
+	return ok
+}
+
+// ResetPolicy forgets the policy rejections, which a new tip may have
+// made acceptable, and returns how many were dropped
+func (rr *RecentRejects) ResetPolicy() int {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	kept := rr.order[:0]
+	for _, hash := range rr.order {
+		if rr.entries[hash].Policy {
+			delete(rr.entries, hash)
+			continue
+		}
+		kept = append(kept, hash)
+	}
+	dropped := len(rr.order) - len(kept)
+	rr.order = kept
+	return dropped
+}
+
+// Len returns the number of remembered rejections
+func (rr *RecentRejects) Len() int {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	return len(rr.entries)
+}
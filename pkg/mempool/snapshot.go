@@ -0,0 +1,138 @@
+package mempool
+
+import (
+	"sort"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// maxDepartures bounds the log of why transactions left the mempool.
+// Snapshots older than that many departures diff with some removals
+// unexplained.
+const maxDepartures = 10000
+
+// SnapshotEntry is one transaction in a mempool snapshot
+type SnapshotEntry struct {
+	TxHash  types.Hash
+	Size    int64
+	Fee     int64
+	FeeRate int64 // sat/kvB
+}
+
+// Snapshot is the mempool's contents at one moment
+type Snapshot struct {
+	Time    int64  // Unix time taken
+	Height  uint64 // Chain height at the time
+	Entries map[types.Hash]SnapshotEntry
+}
+
+// SnapshotDiff is how the mempool changed between two snapshots. Each
+// list is sorted by fee rate, highest first. Removed holds transactions
+// that left for no recorded reason, such as an explicit removal or a
+// departure too long ago to remember.
+type SnapshotDiff struct {
+	Added      []SnapshotEntry
+	Mined      []SnapshotEntry
+	Evicted    []SnapshotEntry
+	Expired    []SnapshotEntry
+	Replaced   []SnapshotEntry
+	Conflicted []SnapshotEntry
+	Removed    []SnapshotEntry
+}
+
+// Snapshot captures every transaction's id, size and fee
+func (m *Mempool) Snapshot() *Snapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshot := &Snapshot{
+		Time:    m.clock.Now().Unix(),
+		Height:  m.currentHeight,
+		Entries: make(map[types.Hash]SnapshotEntry, len(m.entries)),
+	}
+	for txHash, entry := range m.entries {
+		snapshot.Entries[txHash] = SnapshotEntry{
+			TxHash:  txHash,
+			Size:    entry.Size,
+			Fee:     entry.Fee,
+			FeeRate: entry.FeeRate,
+		}
+	}
+	return snapshot
+}
+
+// List returns the snapshot's transactions, highest fee rate first
+func (s *Snapshot) List() []SnapshotEntry {
+	entries := make([]SnapshotEntry, 0, len(s.Entries))
+	for _, entry := range s.Entries {
+		entries = append(entries, entry)
+	}
+	sortByFeeRate(entries)
+	return entries
+}
+
+// DiffSnapshots compares two snapshots of this mempool, sorting the
+// transactions only in from by why they left. The reason is the latest
+// one recorded, so from and to should be taken in that order.
+func (m *Mempool) DiffSnapshots(from, to *Snapshot) *SnapshotDiff {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	diff := &SnapshotDiff{}
+	for txHash, entry := range to.Entries {
+		if _, ok := from.Entries[txHash]; !ok {
+			diff.Added = append(diff.Added, entry)
+		}
+	}
+	for txHash, entry := range from.Entries {
+		if _, ok := to.Entries[txHash]; ok {
+			continue
+		}
+		reason, known := m.departures[txHash]
+		switch {
+		case !known:
+			diff.Removed = append(diff.Removed, entry)
+		case reason == RemovalBlock:
+			diff.Mined = append(diff.Mined, entry)
+		case reason == RemovalEvicted:
+			diff.Evicted = append(diff.Evicted, entry)
+		case reason == RemovalExpired:
+			diff.Expired = append(diff.Expired, entry)
+		case reason == RemovalReplaced:
+			diff.Replaced = append(diff.Replaced, entry)
+		case reason == RemovalConflict:
+			diff.Conflicted = append(diff.Conflicted, entry)
+		default:
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+
+	for _, list := range [][]SnapshotEntry{diff.Added, diff.Mined, diff.Evicted, diff.Expired, diff.Replaced, diff.Conflicted, diff.Removed} {
+		sortByFeeRate(list)
+	}
+	return diff
+}
+
+// sortByFeeRate orders entries by fee rate, highest first, then by txid
+func sortByFeeRate(entries []SnapshotEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].FeeRate != entries[j].FeeRate {
+			return entries[i].FeeRate > entries[j].FeeRate
+		}
+		return entries[i].TxHash.String() < entries[j].TxHash.String()
+	})
+}
+
+// recordDeparture remembers why a transaction left, forgetting the oldest
+// departure once the log is full (internal, no lock)
+func (m *Mempool) recordDeparture(txHash types.Hash, reason RemovalReason) {
+	if _, seen := m.departures[txHash]; !seen {
+		m.departureOrder = append(m.departureOrder, txHash)
+	}
+	m.departures[txHash] = reason
+
+	if len(m.departureOrder) > maxDepartures {
+		delete(m.departures, m.departureOrder[0])
+		m.departureOrder = m.departureOrder[1:]
+	}
+}
@@ -0,0 +1,91 @@
+package mempool
+
+import (
+	"unsafe"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Per-entry costs beyond the transaction itself, calibrated against
+// runtime.MemStats over pools of typical 1-5 input transactions
+const (
+	// entryOverhead covers the entry struct and its slot in the txid map,
+	// whose buckets are only partly full on average
+	entryOverhead = int64(unsafe.Sizeof(MempoolEntry{})) + 88
+
+	// spendOverhead covers one input's slot in the spent outpoint index
+	spendOverhead = 112
+
+	// linkOverhead covers one parent link: the hash in the child's Parents
+	// and the one in the parent's Children
+	linkOverhead = 2 * int64(unsafe.Sizeof(types.Hash{}))
+)
+
+// MemoryUsage splits the mempool's estimated heap use by what holds it.
+// For typical transactions it comes to three or four times their
+// serialized size.
+type MemoryUsage struct {
+	Transactions int64 // Decoded transactions: structs, slices and scripts
+	Entries      int64 // Entry metadata and the txid map
+	Spends       int64 // The spent outpoint index
+	Links        int64 // Parent and child lists
+}
+
+// Total returns the estimated bytes overall
+func (u MemoryUsage) Total() int64 {
+	return u.Transactions + u.Entries + u.Spends + u.Links
+}
+
+// add adds other to u
+func (u *MemoryUsage) add(other MemoryUsage) {
+	u.Transactions += other.Transactions
+	u.Entries += other.Entries
+	u.Spends += other.Spends
+	u.Links += other.Links
+}
+
+// sub takes other from u
+func (u *MemoryUsage) sub(other MemoryUsage) {
+	u.Transactions -= other.Transactions
+	u.Entries -= other.Entries
+	u.Spends -= other.Spends
+	u.Links -= other.Links
+}
+
+// EstimateMemoryUsage returns what an entry for tx, with parents
+// unconfirmed parents in the pool, adds to the mempool's heap use. The
+// mempool's size limit is checked against these estimates.
+func EstimateMemoryUsage(tx *types.Transaction, parents int) MemoryUsage {
+	return MemoryUsage{
+		Transactions: transactionUsage(tx),
+		Entries:      entryOverhead,
+		Spends:       int64(len(tx.Inputs)) * spendOverhead,
+		Links:        int64(parents) * linkOverhead,
+	}
+}
+
+// transactionUsage estimates the heap bytes of a decoded transaction
+func transactionUsage(tx *types.Transaction) int64 {
+	total := int64(unsafe.Sizeof(*tx))
+	total += allocSize(int64(cap(tx.Inputs)) * int64(unsafe.Sizeof(types.TxInput{})))
+	for _, input := range tx.Inputs {
+		total += allocSize(int64(cap(input.SignatureScript)))
+		if input.Witness != nil {
+			total += allocSize(int64(cap(input.Witness)) * int64(unsafe.Sizeof([]byte(nil))))
+			for _, item := range input.Witness {
+				total += allocSize(int64(cap(item)))
+			}
+		}
+	}
+	total += allocSize(int64(cap(tx.Outputs)) * int64(unsafe.Sizeof(types.TxOutput{})))
+	for _, output := range tx.Outputs {
+		total += allocSize(int64(cap(output.PubKeyScript)))
+	}
+	return total
+}
+
+// allocSize rounds an allocation up to 16 bytes, roughly the size class
+// Go's allocator serves small objects from
+func allocSize(n int64) int64 {
+	return (n + 15) &^ 15
+}
@@ -2,12 +2,14 @@ package mining
 
 import (
 	"fmt"
-	"time"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
 )
 
 // BlockTemplate contains all data needed to mine a block
@@ -24,15 +26,30 @@ type BlockTemplate struct {
 // BlockBuilder constructs blocks from mempool
 type BlockBuilder struct {
 	mempool *mempool.Mempool
+	clock   clock.Clock
+	order   mempool.SelectionOrder
 }
 
 // NewBlockBuilder creates a new block builder
 func NewBlockBuilder(mp *mempool.Mempool) *BlockBuilder {
 	return &BlockBuilder{
 		mempool: mp,
+		clock:   clock.Real,
 	}
 }
 
+// SetClock replaces the time source used for template timestamps
+func (bb *BlockBuilder) SetClock(c clock.Clock) {
+	bb.clock = c
+}
+
+// SetOrder changes how transactions are picked. OrderPriority fills blocks
+// the way miners did before fees mattered, highest coin-age priority
+// first.
+func (bb *BlockBuilder) SetOrder(order mempool.SelectionOrder) {
+	bb.order = order
+}
+
 // CreateBlockTemplate creates a template ready for mining
 func (bb *BlockBuilder) CreateBlockTemplate(
 	prevBlockHash types.Hash,
@@ -42,7 +59,7 @@ func (bb *BlockBuilder) CreateBlockTemplate(
 ) (*BlockTemplate, error) {
 
 	// 1. Select transactions from mempool
-	selectedTxs, totalFees := bb.selectTransactions()
+	selectedTxs, totalFees := bb.selectTransactions(height)
 
 	// 2. Create coinbase transaction
 	coinbaseTx, err := CreateCoinbase(height, totalFees, minerAddress, 0)
@@ -60,7 +77,7 @@ func (bb *BlockBuilder) CreateBlockTemplate(
 		Version:       1,
 		PrevBlockHash: prevBlockHash,
 		Transactions:  allTxs,
-		Timestamp:     uint32(time.Now().Unix()),
+		Timestamp:     uint32(bb.clock.Now().Unix()),
 		Bits:          difficulty,
 		Height:        height,
 		TotalFees:     totalFees,
@@ -69,14 +86,15 @@ func (bb *BlockBuilder) CreateBlockTemplate(
 	return template, nil
 }
 
-// selectTransactions selects transactions from mempool
-// Returns transactions and total fees
-func (bb *BlockBuilder) selectTransactions() ([]types.Transaction, int64) {
+// selectTransactions selects transactions from mempool for a block at
+// height. Returns transactions and total fees
+func (bb *BlockBuilder) selectTransactions(height uint64) ([]types.Transaction, int64) {
 	// Create priority queue
 	pq := mempool.NewPriorityQueue(bb.mempool)
+	pq.SetOrder(bb.order, height)
 
-	// Select transactions (1MB max block size)
-	maxBlockSize := int64(1000000)
+	// Select transactions up to the block weight limit, in virtual bytes
+	maxBlockSize := int64(consensus.NewMainnetRules().MaxBlockWeight / validation.WitnessScaleFactor)
 	selectedTxPtrs, err := pq.SelectTransactionsWithDependencies(maxBlockSize)
 	if err != nil {
 		// If selection fails, return empty
@@ -106,8 +124,14 @@ func (bb *BlockBuilder) selectTransactions() ([]types.Transaction, int64) {
 
 // BuildBlock creates a complete block from template
 func BuildBlock(template *BlockTemplate, nonce uint32) (*types.Block, error) {
+	// Commit to any witness data before the coinbase is hashed
+	txs, err := addWitnessCommitment(template.Transactions)
+	if err != nil {
+		return nil, err
+	}
+
 	// Calculate merkle root
-	merkleRoot, err := calculateMerkleRoot(template.Transactions)
+	merkleRoot, err := calculateMerkleRoot(txs)
 	if err != nil {
 		return nil, err
 	}
@@ -125,12 +149,54 @@ func BuildBlock(template *BlockTemplate, nonce uint32) (*types.Block, error) {
 	// Create block
 	block := &types.Block{
 		Header:       header,
-		Transactions: template.Transactions,
+		Transactions: txs,
 	}
 
 	return block, nil
 }
 
+// addWitnessCommitment returns txs with a BIP141 witness commitment added
+// to a copy of the coinbase if any transaction carries witness data and
+// the coinbase doesn't commit already
+func addWitnessCommitment(txs []types.Transaction) ([]types.Transaction, error) {
+	if len(txs) == 0 || validation.WitnessCommitmentIndex(&txs[0]) >= 0 {
+		return txs, nil
+	}
+	hasWitness := false
+	for i := 1; i < len(txs); i++ {
+		if txs[i].HasWitness() {
+			hasWitness = true
+			break
+		}
+	}
+	if !hasWitness {
+		return txs, nil
+	}
+	return appendWitnessCommitment(txs)
+}
+
+// appendWitnessCommitment returns txs with a witness commitment output
+// added to a copy of the coinbase
+func appendWitnessCommitment(txs []types.Transaction) ([]types.Transaction, error) {
+	root, err := validation.WitnessMerkleRoot(txs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reserved value is all zeros
+	reserved := make([]byte, 32)
+	coinbase := txs[0]
+	coinbase.Inputs = append([]types.TxInput(nil), coinbase.Inputs...)
+	coinbase.Inputs[0].Witness = [][]byte{reserved}
+	coinbase.Outputs = append(append([]types.TxOutput(nil), coinbase.Outputs...), types.TxOutput{
+		Value:        0,
+		PubKeyScript: validation.WitnessCommitmentScript(validation.WitnessCommitment(root, reserved)),
+	})
+
+	committed := append([]types.Transaction{coinbase}, txs[1:]...)
+	return committed, nil
+}
+
 // calculateMerkleRoot computes merkle root from transactions
 func calculateMerkleRoot(txs []types.Transaction) (types.Hash, error) {
 	if len(txs) == 0 {
@@ -156,7 +222,7 @@ func calculateMerkleRoot(txs []types.Transaction) (types.Hash, error) {
 
 1. **Select Transactions:**
    - Get transactions from mempool
-   - Sort by fee rate (sat/byte)
+   - Sort by fee rate (sat/vB)
    - Select highest paying transactions
    - Respect block size limit (1MB)
 
@@ -3,18 +3,49 @@ package mining
 import (
 	"encoding/binary"
 	"fmt"
+	"math/big"
 
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
 )
 
+// Payout is one recipient of a split block reward. Shares are relative
+// weights: recipients with shares 3 and 1 get three quarters and a quarter.
+type Payout struct {
+	Address string
+	Share   uint64
+}
+
 // CreateCoinbase creates a coinbase transaction for a block
 func CreateCoinbase(blockHeight uint64, totalFees int64, minerAddress string, extraNonce uint64) (*types.Transaction, error) {
+	return CreateSplitCoinbase(blockHeight, totalFees, []Payout{{Address: minerAddress, Share: 1}}, extraNonce)
+}
+
+// CreateSplitCoinbase creates a coinbase transaction paying the block
+// reward and fees to several recipients in proportion to their shares, one
+// output each in the order given. Rounding leftovers go to the first
+// recipient, so the outputs always add up to exactly subsidy plus fees.
+func CreateSplitCoinbase(blockHeight uint64, totalFees int64, payouts []Payout, extraNonce uint64) (*types.Transaction, error) {
+	if len(payouts) == 0 {
+		return nil, fmt.Errorf("no payout recipients")
+	}
+	if totalFees < 0 {
+		return nil, fmt.Errorf("negative fees: %d", totalFees)
+	}
+
 	// Calculate block reward
 	blockReward := validation.GetBlockReward(blockHeight)
 	totalReward := blockReward + totalFees
 
+	totalShares := new(big.Int)
+	for i, payout := range payouts {
+		if payout.Share == 0 {
+			return nil, fmt.Errorf("payout %d to %s has no share", i, payout.Address)
+		}
+		totalShares.Add(totalShares, new(big.Int).SetUint64(payout.Share))
+	}
+
 	// Create coinbase input
 	// Coinbase input has:
 	// - PrevTxHash: all zeros
@@ -27,34 +58,55 @@ func CreateCoinbase(blockHeight uint64, totalFees int64, minerAddress string, ex
 		Sequence:        0xFFFFFFFF,
 	}
 
-	// Create output to miner
-	// For simplicity, we'll use a P2PKH script
-	// In a real implementation, we'd decode the address to get the pubkey hash
-	// For now, use a dummy 20-byte hash derived from the address
-	pubKeyHash := make([]byte, 20)
-	copy(pubKeyHash, []byte(minerAddress))
-
-	minerScript, err := script.P2PKH(pubKeyHash)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create miner script: %w", err)
+	outputs := make([]types.TxOutput, len(payouts))
+	paid := int64(0)
+	for i, payout := range payouts {
+		minerScript, err := payoutScript(payout.Address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create miner script: %w", err)
+		}
+
+		// reward * share / total can overflow 64 bits before the division
+		value := new(big.Int).SetInt64(totalReward)
+		value.Mul(value, new(big.Int).SetUint64(payout.Share))
+		value.Quo(value, totalShares)
+
+		outputs[i] = types.TxOutput{
+			Value:        value.Int64(),
+			PubKeyScript: minerScript,
+		}
+		paid += outputs[i].Value
 	}
+	outputs[0].Value += totalReward - paid
 
-	coinbaseOutput := types.TxOutput{
-		Value:        totalReward,
-		PubKeyScript: minerScript,
+	for i, output := range outputs {
+		if output.Value == 0 && totalReward > 0 {
+			return nil, fmt.Errorf("payout %d to %s rounds down to nothing", i, payouts[i].Address)
+		}
 	}
 
 	// Create transaction
 	coinbaseTx := &types.Transaction{
 		Version:  1,
 		Inputs:   []types.TxInput{coinbaseInput},
-		Outputs:  []types.TxOutput{coinbaseOutput},
+		Outputs:  outputs,
 		LockTime: 0,
 	}
 
 	return coinbaseTx, nil
 }
 
+// payoutScript returns the script paying a miner address
+func payoutScript(address string) ([]byte, error) {
+	// For simplicity, we'll use a P2PKH script
+	// In a real implementation, we'd decode the address to get the pubkey hash
+	// For now, use a dummy 20-byte hash derived from the address
+	pubKeyHash := make([]byte, 20)
+	copy(pubKeyHash, []byte(address))
+
+	return script.P2PKH(pubKeyHash)
+}
+
 // createCoinbaseScript creates the coinbase scriptSig
 // BIP34 requires block height to be first item
 func createCoinbaseScript(blockHeight uint64, extraNonce uint64) []byte {
@@ -2,8 +2,10 @@ package mining
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
@@ -22,6 +24,7 @@ type MiningStats struct {
 // Miner performs proof-of-work mining
 type Miner struct {
 	stats MiningStats
+	mu    sync.RWMutex // Guards stats, which RPC reads while mining
 }
 
 // NewMiner creates a new miner
@@ -38,12 +41,14 @@ func (m *Miner) MineBlock(template *BlockTemplate, targetZeros int) (*types.Bloc
 	fmt.Printf("   Difficulty: %d\n", template.Bits)
 
 	// Initialize stats
+	m.mu.Lock()
 	m.stats = MiningStats{
 		StartTime:   time.Now(),
 		Attempts:    0,
 		Difficulty:  template.Bits,
 		TargetZeros: targetZeros,
 	}
+	m.mu.Unlock()
 
 	// Try different nonces
 	nonce := uint32(0)
@@ -62,13 +67,17 @@ func (m *Miner) MineBlock(template *BlockTemplate, targetZeros int) (*types.Bloc
 		}
 
 		// Update stats
+		m.mu.Lock()
 		m.stats.Attempts++
 		m.stats.CurrentNonce = nonce
+		m.mu.Unlock()
 
 		// Check if hash meets difficulty
 		if m.checkProofOfWork(blockHash[:], targetZeros) {
 			// Found valid block!
+			m.mu.Lock()
 			m.calculateHashRate()
+			m.mu.Unlock()
 			m.printSuccess(blockHash, block)
 			return block, nil
 		}
@@ -88,6 +97,36 @@ func (m *Miner) MineBlock(template *BlockTemplate, targetZeros int) (*types.Bloc
 	}
 }
 
+// SolveBlock builds a block from template and grinds its nonce until the
+// header meets the template's target bits. Unlike MineBlock it prints
+// nothing, and on regtest's limit it takes a couple of attempts, so tests
+// can mine many blocks quickly.
+func SolveBlock(template *BlockTemplate) (*types.Block, error) {
+	block, err := BuildBlock(template, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	target := consensus.CompactToTarget(template.Bits)
+	if target.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid target bits %08x", template.Bits)
+	}
+	for {
+		blockHash, err := serialization.HashBlockHeader(&block.Header)
+		if err != nil {
+			return nil, err
+		}
+		if consensus.HashToBig(blockHash).Cmp(target) <= 0 {
+			return block, nil
+		}
+
+		block.Header.Nonce++
+		if block.Header.Nonce == 0 {
+			return nil, fmt.Errorf("nonce overflow - difficulty too high")
+		}
+	}
+}
+
 // checkProofOfWork checks if hash meets difficulty target
 func (m *Miner) checkProofOfWork(blockHash []byte, targetZeros int) bool {
 	// Count leading zero bytes
@@ -103,7 +142,7 @@ func (m *Miner) checkProofOfWork(blockHash []byte, targetZeros int) bool {
 	return leadingZeros >= targetZeros
 }
 
-// calculateHashRate calculates current hash rate
+// calculateHashRate calculates current hash rate. The caller holds m.mu.
 func (m *Miner) calculateHashRate() {
 	elapsed := time.Since(m.stats.StartTime).Seconds()
 	if elapsed > 0 {
@@ -113,7 +152,9 @@ func (m *Miner) calculateHashRate() {
 
 // printProgress prints mining progress
 func (m *Miner) printProgress() {
+	m.mu.Lock()
 	m.calculateHashRate()
+	m.mu.Unlock()
 	fmt.Printf("   ⛏️  Attempts: %d | Hash rate: %.0f H/s | Nonce: %d\n",
 		m.stats.Attempts,
 		m.stats.HashRate,
@@ -136,6 +177,8 @@ func (m *Miner) printSuccess(blockHash types.Hash, block *types.Block) {
 
 // GetStats returns current mining stats
 func (m *Miner) GetStats() MiningStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return m.stats
 }
 
@@ -0,0 +1,77 @@
+package mining
+
+import (
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// SignSignetTemplate adds a solution to challenge to the template's
+// coinbase, so a local signet issuer can sign its blocks. The challenge
+// must be <pubkey> OP_CHECKSIG or a bare multisig, and signers must hold
+// enough of its keys, in the challenge's key order. The signature covers
+// the template's version, previous block, time and transactions, so
+// those must be final; the nonce can still be ground afterwards.
+func SignSignetTemplate(template *BlockTemplate, challenge []byte, signers ...*keys.PrivateKey) error {
+	if len(template.Transactions) == 0 {
+		return fmt.Errorf("template has no coinbase")
+	}
+	class := script.ClassifyScript(challenge)
+	if class != script.PubKeyTy && class != script.MultiSigTy {
+		return fmt.Errorf("cannot sign a %s signet challenge", class)
+	}
+	if class == script.PubKeyTy && len(signers) != 1 {
+		return fmt.Errorf("pubkey challenge needs 1 signer, got %d", len(signers))
+	}
+
+	// The solution lives in the witness commitment output, which the
+	// block needs even without witness transactions
+	txs := template.Transactions
+	if validation.WitnessCommitmentIndex(&txs[0]) < 0 {
+		var err error
+		if txs, err = appendWitnessCommitment(txs); err != nil {
+			return err
+		}
+	}
+	index := validation.WitnessCommitmentIndex(&txs[0])
+	withCommitment := func(push []byte) []types.Transaction {
+		coinbase := txs[0]
+		coinbase.Outputs = append([]types.TxOutput(nil), coinbase.Outputs...)
+		pkScript := append([]byte(nil), coinbase.Outputs[index].PubKeyScript...)
+		coinbase.Outputs[index].PubKeyScript = append(pkScript, script.NewBuilder().AddData(push).Script()...)
+		return append([]types.Transaction{coinbase}, txs[1:]...)
+	}
+
+	// Sign the block with a bare header in place of the solution, which
+	// is how verifiers see it
+	unsigned := *template
+	unsigned.Transactions = withCommitment(validation.SignetHeader)
+	block, err := BuildBlock(&unsigned, 0)
+	if err != nil {
+		return err
+	}
+	toSign, _, err := validation.SignetSigningTx(block, challenge)
+	if err != nil {
+		return err
+	}
+
+	sigScript := script.NewBuilder()
+	if class == script.MultiSigTy {
+		sigScript.AddOp(script.OP_0) // OP_CHECKMULTISIG's extra element
+	}
+	for _, signer := range signers {
+		sig, err := transaction.Sign(toSign, 0, signer, challenge, transaction.SigHashAll)
+		if err != nil {
+			return err
+		}
+		sigScript.AddData(sig)
+	}
+	solution := &validation.SignetSolution{ScriptSig: sigScript.Script()}
+
+	template.Transactions = withCommitment(append(append([]byte(nil), validation.SignetHeader...), solution.Serialize()...))
+	return nil
+}
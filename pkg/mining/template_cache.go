@@ -0,0 +1,177 @@
+package mining
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// ChainTip reports the block new templates build on: its hash, height and
+// the target bits the next block must meet
+type ChainTip func() (hash types.Hash, height uint64, bits uint32, err error)
+
+// TemplateCacheConfig tunes when templates are rebuilt and long polls end
+type TemplateCacheConfig struct {
+	MinerAddress string
+
+	// RebuildInterval is the least time between rebuilds caused by mempool
+	// changes alone. Within it the cached template only gets a new time.
+	RebuildInterval time.Duration
+
+	// PollInterval is how often waiting long polls check the tip and
+	// mempool
+	PollInterval time.Duration
+
+	// MinFeeIncrease is how many more satoshis of fees the mempool must
+	// offer than the current template before long polls are woken
+	MinFeeIncrease int64
+}
+
+// DefaultTemplateCacheConfig returns the intervals bitcoind uses for
+// getblocktemplate
+func DefaultTemplateCacheConfig(minerAddress string) TemplateCacheConfig {
+	return TemplateCacheConfig{
+		MinerAddress:    minerAddress,
+		RebuildInterval: 5 * time.Second,
+		PollInterval:    time.Second,
+		MinFeeIncrease:  1000,
+	}
+}
+
+// TemplateCache hands out block templates, rebuilding them only when the
+// tip changes or the mempool changed and RebuildInterval has passed. It
+// also implements BIP22 long polling: a caller passing the id of the
+// template it has waits until there is a materially better one.
+type TemplateCache struct {
+	builder *BlockBuilder
+	tip     ChainTip
+	config  TemplateCacheConfig
+
+	current  *BlockTemplate
+	id       string
+	built    time.Time
+	updated  uint64 // Mempool TransactionsUpdated when current was built
+	stale    bool   // A long poll saw enough new fees to force a rebuild
+	serial   uint64 // Distinguishes templates built on the same tip
+	rebuilds uint64
+	mu       sync.Mutex
+}
+
+// NewTemplateCache creates a cache building templates with builder on the
+// tip reported by tip
+func NewTemplateCache(builder *BlockBuilder, tip ChainTip, config TemplateCacheConfig) *TemplateCache {
+	return &TemplateCache{
+		builder: builder,
+		tip:     tip,
+		config:  config,
+	}
+}
+
+// Rebuilds returns how many times a template was built from scratch
+func (tc *TemplateCache) Rebuilds() uint64 {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.rebuilds
+}
+
+// Get returns the current template and its long poll id. If longPollID is
+// the id of the current template, Get first waits until the tip changes,
+// the mempool offers MinFeeIncrease more in fees, or ctx is done.
+func (tc *TemplateCache) Get(ctx context.Context, longPollID string) (*BlockTemplate, string, error) {
+	if longPollID != "" {
+		if err := tc.waitForChange(ctx, longPollID); err != nil {
+			return nil, "", err
+		}
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.template()
+}
+
+// template returns the cached template, refreshed or rebuilt as needed.
+// The caller holds tc.mu.
+func (tc *TemplateCache) template() (*BlockTemplate, string, error) {
+	tipHash, height, bits, err := tc.tip()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get chain tip: %w", err)
+	}
+
+	now := tc.builder.clock.Now()
+	updated := tc.builder.mempool.TransactionsUpdated()
+
+	reuse := tc.current != nil && tc.current.PrevBlockHash == tipHash && !tc.stale &&
+		(updated == tc.updated || now.Sub(tc.built) < tc.config.RebuildInterval)
+	if reuse {
+		// Only the time moves forward; the transactions stay the same
+		template := *tc.current
+		template.Timestamp = uint32(now.Unix())
+		return &template, tc.id, nil
+	}
+
+	template, err := tc.builder.CreateBlockTemplate(tipHash, height+1, tc.config.MinerAddress, bits)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tc.serial++
+	tc.rebuilds++
+	tc.current = template
+	tc.id = fmt.Sprintf("%s%d", tipHash, tc.serial)
+	tc.built = now
+	tc.updated = updated
+	tc.stale = false
+
+	copied := *template
+	return &copied, tc.id, nil
+}
+
+// waitForChange blocks while longPollID is the current template and
+// nothing material has changed. An unknown or outdated id returns at once.
+func (tc *TemplateCache) waitForChange(ctx context.Context, longPollID string) error {
+	tc.mu.Lock()
+	if tc.current == nil || longPollID != tc.id {
+		tc.mu.Unlock()
+		return nil
+	}
+	tipHash, height, fees, updated := tc.current.PrevBlockHash, tc.current.Height, tc.current.TotalFees, tc.updated
+	tc.mu.Unlock()
+
+	ticker := time.NewTicker(tc.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		hash, _, _, err := tc.tip()
+		if err != nil {
+			return fmt.Errorf("failed to get chain tip: %w", err)
+		}
+		if hash != tipHash {
+			return nil
+		}
+
+		// Only look at the fees again when the mempool has changed
+		now := tc.builder.mempool.TransactionsUpdated()
+		if now == updated {
+			continue
+		}
+		updated = now
+
+		if _, newFees := tc.builder.selectTransactions(height); newFees-fees >= tc.config.MinFeeIncrease {
+			tc.mu.Lock()
+			if tc.id == longPollID {
+				tc.stale = true
+			}
+			tc.mu.Unlock()
+			return nil
+		}
+	}
+}
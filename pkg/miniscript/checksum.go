@@ -0,0 +1,114 @@
+package miniscript
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrChecksum is returned for a descriptor whose checksum is missing or
+// doesn't match
+var ErrChecksum = errors.New("descriptor checksum mismatch")
+
+const (
+	// checksumInputCharset orders the characters a descriptor may use so
+	// that the common ones fall in the first group of 32
+	checksumInputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+		"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+		"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+
+	// checksumCharset is the bech32 character set
+	checksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+	checksumLength = 8
+)
+
+// DescriptorChecksum returns the BIP380 checksum of a descriptor without
+// one: eight characters, written after a '#', that catch typos in a
+// descriptor copied between wallets
+func DescriptorChecksum(desc string) (string, error) {
+	c := uint64(1)
+	cls, clsCount := 0, 0
+	for i := 0; i < len(desc); i++ {
+		pos := strings.IndexByte(checksumInputCharset, desc[i])
+		if pos < 0 {
+			return "", fmt.Errorf("%w: invalid character %q in descriptor", ErrSyntax, desc[i])
+		}
+		// Symbols within a group go in directly, the group numbers in
+		// threes
+		c = checksumPolymod(c, pos&31)
+		cls = cls*3 + pos>>5
+		if clsCount++; clsCount == 3 {
+			c = checksumPolymod(c, cls)
+			cls, clsCount = 0, 0
+		}
+	}
+	if clsCount > 0 {
+		c = checksumPolymod(c, cls)
+	}
+	for i := 0; i < checksumLength; i++ {
+		c = checksumPolymod(c, 0)
+	}
+	c ^= 1
+
+	sum := make([]byte, checksumLength)
+	for i := range sum {
+		sum[i] = checksumCharset[(c>>(5*(checksumLength-1-i)))&31]
+	}
+	return string(sum), nil
+}
+
+// AddChecksum returns desc followed by '#' and its checksum
+func AddChecksum(desc string) (string, error) {
+	sum, err := DescriptorChecksum(desc)
+	if err != nil {
+		return "", err
+	}
+	return desc + "#" + sum, nil
+}
+
+// VerifyChecksum splits a descriptor from its checksum and checks it. A
+// descriptor without one is returned as is unless required is set.
+func VerifyChecksum(desc string, required bool) (string, error) {
+	body, sum, found := strings.Cut(desc, "#")
+	if !found {
+		if required {
+			return "", fmt.Errorf("%w: missing checksum", ErrChecksum)
+		}
+		return desc, nil
+	}
+	if len(sum) != checksumLength {
+		return "", fmt.Errorf("%w: checksum %q is not %d characters", ErrChecksum, sum, checksumLength)
+	}
+	want, err := DescriptorChecksum(body)
+	if err != nil {
+		return "", err
+	}
+	if sum != want {
+		return "", fmt.Errorf("%w: got %s, want %s", ErrChecksum, sum, want)
+	}
+	return body, nil
+}
+
+// checksumPolymod feeds one 5-bit value into the checksum's BCH code,
+// whose generator is degree 8 over GF(32)
+func checksumPolymod(c uint64, val int) uint64 {
+	c0 := c >> 35
+	c = (c&0x7ffffffff)<<5 ^ uint64(val)
+	if c0&1 != 0 {
+		c ^= 0xf5dee51989
+	}
+	if c0&2 != 0 {
+		c ^= 0xa9fdca3312
+	}
+	if c0&4 != 0 {
+		c ^= 0x1bab10e32d
+	}
+	if c0&8 != 0 {
+		c ^= 0x3706b1677a
+	}
+	if c0&16 != 0 {
+		c ^= 0x644d626ffd
+	}
+	return c
+}
@@ -0,0 +1,71 @@
+package miniscript
+
+import (
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+)
+
+// Compile returns the script enforcing the policy. Run with a satisfying
+// witness it leaves a single true value, as a P2WSH witness script must.
+//
+//	pk(K)        <K> OP_CHECKSIG
+//	multi(k,K..) <k> <K>... <n> OP_CHECKMULTISIG
+//	after(n)     <n> OP_CHECKLOCKTIMEVERIFY
+//	sha256(H)    OP_SHA256 <H> OP_EQUAL
+//	and(X,Y)     [X]v [Y]
+//	or(X,Y)      OP_IF [X] OP_ELSE [Y] OP_ENDIF
+//
+// [X]v is the verify form of X, which aborts rather than leave false:
+// OP_CHECKSIGVERIFY, OP_CHECKMULTISIGVERIFY and OP_EQUALVERIFY for the
+// checks, an OP_DROP after OP_CHECKLOCKTIMEVERIFY.
+func (p *Policy) Compile() []byte {
+	b := script.NewBuilder()
+	p.compile(b, false)
+	return b.Script()
+}
+
+// compile adds the policy's fragment to b, in verify form if verify
+func (p *Policy) compile(b *script.Builder, verify bool) {
+	switch p.Kind {
+	case KindPk:
+		b.AddData(p.Keys[0])
+		b.AddOp(pick(verify, script.OP_CHECKSIGVERIFY, script.OP_CHECKSIG))
+
+	case KindMulti:
+		b.AddInt(int64(p.K))
+		for _, key := range p.Keys {
+			b.AddData(key)
+		}
+		b.AddInt(int64(len(p.Keys)))
+		b.AddOp(pick(verify, script.OP_CHECKMULTISIGVERIFY, script.OP_CHECKMULTISIG))
+
+	case KindAfter:
+		// The lock time stays on the stack: non-zero, so true
+		b.AddInt(int64(p.LockTime)).AddOp(script.OP_CHECKLOCKTIMEVERIFY)
+		if verify {
+			b.AddOp(script.OP_DROP)
+		}
+
+	case KindSHA256:
+		b.AddOp(script.OP_SHA256).AddData(p.Hash)
+		b.AddOp(pick(verify, script.OP_EQUALVERIFY, script.OP_EQUAL))
+
+	case KindAnd:
+		p.Subs[0].compile(b, true)
+		p.Subs[1].compile(b, verify)
+
+	case KindOr:
+		b.AddOp(script.OP_IF)
+		p.Subs[0].compile(b, verify)
+		b.AddOp(script.OP_ELSE)
+		p.Subs[1].compile(b, verify)
+		b.AddOp(script.OP_ENDIF)
+	}
+}
+
+// pick returns verifyOp in verify form, else op
+func pick(verify bool, verifyOp, op byte) byte {
+	if verify {
+		return verifyOp
+	}
+	return op
+}
@@ -0,0 +1,58 @@
+package miniscript
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+)
+
+// Descriptor is an output descriptor around a policy. Only wsh() is
+// supported: the output pays to the P2WSH of the compiled policy.
+type Descriptor struct {
+	Policy *Policy
+}
+
+// ParseDescriptor parses a descriptor of the form wsh(POLICY), checking
+// its checksum if it has one
+func ParseDescriptor(s string) (*Descriptor, error) {
+	s, err := VerifyChecksum(strings.TrimSpace(s), false)
+	if err != nil {
+		return nil, err
+	}
+	s = strings.Join(strings.Fields(s), "")
+	inner, ok := strings.CutPrefix(s, "wsh(")
+	if !ok || !strings.HasSuffix(inner, ")") {
+		return nil, fmt.Errorf("%w: descriptor must be wsh(POLICY)", ErrSyntax)
+	}
+	policy, err := Parse(inner[:len(inner)-1])
+	if err != nil {
+		return nil, err
+	}
+	return &Descriptor{Policy: policy}, nil
+}
+
+// String returns the descriptor in the form ParseDescriptor reads
+func (d *Descriptor) String() string {
+	return "wsh(" + d.Policy.String() + ")"
+}
+
+// WitnessScript returns the compiled policy a spend reveals
+func (d *Descriptor) WitnessScript() []byte {
+	return d.Policy.Compile()
+}
+
+// Script returns the locking script to pay to
+func (d *Descriptor) Script() ([]byte, error) {
+	return script.P2WSH(d.WitnessScript())
+}
+
+// Witness returns the smallest witness spending the output with what s
+// supplies: the policy's satisfaction followed by the witness script
+func (d *Descriptor) Witness(s Satisfier) ([][]byte, error) {
+	stack, err := d.Policy.Satisfy(s)
+	if err != nil {
+		return nil, err
+	}
+	return append(stack, d.WitnessScript()), nil
+}
@@ -0,0 +1,206 @@
+// Package miniscript compiles a small spending policy language to
+// Bitcoin script and works out the witnesses that satisfy it. It follows
+// Miniscript (BIP379) in spirit but is much simplified: a policy such as
+//
+//	and(pk(A),or(pk(B),after(100)))
+//
+// is compiled with one fixed fragment per construct rather than the
+// cheapest of many, and there is no analysis of malleability or
+// resource limits. It shows how small script pieces compose: each
+// fragment either leaves a true value or aborts, so they can be chained
+// with OP_VERIFY forms and branched with OP_IF.
+package miniscript
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+)
+
+// MaxMultiKeys is the most keys multi() takes, OP_CHECKMULTISIG's limit
+const MaxMultiKeys = 20
+
+var (
+	ErrSyntax        = errors.New("invalid policy")
+	ErrUnsatisfiable = errors.New("policy can't be satisfied")
+)
+
+// Kind is a policy construct
+type Kind int
+
+const (
+	KindPk     Kind = iota // pk(KEY): a signature by KEY
+	KindMulti              // multi(k,KEY,...): signatures by k of the keys
+	KindAfter              // after(n): the transaction's lock time is at least n
+	KindSHA256             // sha256(HASH): the SHA-256 preimage of HASH
+	KindAnd                // and(X,Y): both X and Y
+	KindOr                 // or(X,Y): either X or Y
+)
+
+// names are the policy language's function names, indexed by Kind
+var names = [...]string{"pk", "multi", "after", "sha256", "and", "or"}
+
+// Policy is a parsed spending policy
+type Policy struct {
+	Kind     Kind
+	Keys     [][]byte  // pk, multi: compressed public keys
+	K        int       // multi: signatures needed
+	LockTime uint32    // after: height or timestamp, as nLockTime
+	Hash     []byte    // sha256: 32 bytes
+	Subs     []*Policy // and, or: the two sub-policies
+}
+
+// Parse parses a policy. Keys are hex compressed public keys and hashes
+// hex SHA-256 digests; spaces are ignored.
+func Parse(s string) (*Policy, error) {
+	s = strings.Join(strings.Fields(s), "")
+	p, rest, err := parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrSyntax, rest)
+	}
+	return p, nil
+}
+
+// parse parses the policy at the start of s and returns what follows it
+func parse(s string) (*Policy, string, error) {
+	open := strings.IndexByte(s, '(')
+	if open < 0 {
+		return nil, "", fmt.Errorf("%w: expected a function at %q", ErrSyntax, s)
+	}
+	name := s[:open]
+	kind := Kind(-1)
+	for k, n := range names {
+		if n == name {
+			kind = Kind(k)
+		}
+	}
+	if kind < 0 {
+		return nil, "", fmt.Errorf("%w: unknown function %q", ErrSyntax, name)
+	}
+	s = s[open+1:]
+	p := &Policy{Kind: kind}
+
+	// and/or take policies, the rest take plain arguments
+	if kind == KindAnd || kind == KindOr {
+		for i := 0; i < 2; i++ {
+			sub, rest, err := parse(s)
+			if err != nil {
+				return nil, "", err
+			}
+			p.Subs = append(p.Subs, sub)
+			sep := byte(',')
+			if i == 1 {
+				sep = ')'
+			}
+			if rest == "" || rest[0] != sep {
+				return nil, "", fmt.Errorf("%w: %s() takes two policies", ErrSyntax, name)
+			}
+			s = rest[1:]
+		}
+		return p, s, nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("%w: unclosed %s(", ErrSyntax, name)
+	}
+	args, rest := strings.Split(s[:end], ","), s[end+1:]
+	if err := p.setArgs(args); err != nil {
+		return nil, "", fmt.Errorf("%w: %s(): %v", ErrSyntax, name, err)
+	}
+	return p, rest, nil
+}
+
+// setArgs fills in a leaf policy from its arguments
+func (p *Policy) setArgs(args []string) error {
+	switch p.Kind {
+	case KindPk:
+		if len(args) != 1 {
+			return fmt.Errorf("takes one key")
+		}
+		key, err := parseKey(args[0])
+		if err != nil {
+			return err
+		}
+		p.Keys = [][]byte{key}
+
+	case KindMulti:
+		if len(args) < 2 || len(args)-1 > MaxMultiKeys {
+			return fmt.Errorf("takes a threshold and 1 to %d keys", MaxMultiKeys)
+		}
+		k, err := strconv.Atoi(args[0])
+		if err != nil || k < 1 || k > len(args)-1 {
+			return fmt.Errorf("invalid threshold %q of %d", args[0], len(args)-1)
+		}
+		p.K = k
+		for _, arg := range args[1:] {
+			key, err := parseKey(arg)
+			if err != nil {
+				return err
+			}
+			p.Keys = append(p.Keys, key)
+		}
+
+	case KindAfter:
+		if len(args) != 1 {
+			return fmt.Errorf("takes one lock time")
+		}
+		// Lock times are script numbers of up to 4 bytes: below 2^31
+		n, err := strconv.ParseUint(args[0], 10, 31)
+		if err != nil || n == 0 {
+			return fmt.Errorf("invalid lock time %q", args[0])
+		}
+		p.LockTime = uint32(n)
+
+	case KindSHA256:
+		if len(args) != 1 {
+			return fmt.Errorf("takes one hash")
+		}
+		hash, err := hex.DecodeString(args[0])
+		if err != nil || len(hash) != 32 {
+			return fmt.Errorf("invalid hash %q", args[0])
+		}
+		p.Hash = hash
+	}
+	return nil
+}
+
+// parseKey decodes a hex compressed public key
+func parseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil || len(key) != 33 {
+		return nil, fmt.Errorf("invalid key %q", s)
+	}
+	if _, err := keys.ParsePublicKey(key); err != nil {
+		return nil, fmt.Errorf("invalid key %q: %v", s, err)
+	}
+	return key, nil
+}
+
+// String returns the policy in the form Parse reads
+func (p *Policy) String() string {
+	var args []string
+	switch p.Kind {
+	case KindPk:
+		args = []string{hex.EncodeToString(p.Keys[0])}
+	case KindMulti:
+		args = []string{strconv.Itoa(p.K)}
+		for _, key := range p.Keys {
+			args = append(args, hex.EncodeToString(key))
+		}
+	case KindAfter:
+		args = []string{strconv.FormatUint(uint64(p.LockTime), 10)}
+	case KindSHA256:
+		args = []string{hex.EncodeToString(p.Hash)}
+	case KindAnd, KindOr:
+		args = []string{p.Subs[0].String(), p.Subs[1].String()}
+	}
+	return names[p.Kind] + "(" + strings.Join(args, ",") + ")"
+}
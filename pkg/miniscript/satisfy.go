@@ -0,0 +1,206 @@
+package miniscript
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+)
+
+// ItemKind is what goes in one place of a witness template
+type ItemKind int
+
+const (
+	ItemSig      ItemKind = iota // A signature by Keys[0]
+	ItemSigs                     // K signatures by Keys, in key order
+	ItemPreimage                 // The preimage of Hash
+	ItemPush                     // Data itself: a branch choice or multisig dummy
+)
+
+// WitnessItem is a place in a witness template
+type WitnessItem struct {
+	Kind ItemKind
+	Keys [][]byte
+	K    int
+	Hash []byte
+	Data []byte
+}
+
+// String returns the item as <sig(KEY)>, <sigs(k,KEY,...)>,
+// <preimage(HASH)> or the data in hex, <> if empty
+func (item WitnessItem) String() string {
+	switch item.Kind {
+	case ItemSig:
+		return fmt.Sprintf("<sig(%x)>", item.Keys[0])
+	case ItemSigs:
+		args := []string{fmt.Sprint(item.K)}
+		for _, key := range item.Keys {
+			args = append(args, hex.EncodeToString(key))
+		}
+		return "<sigs(" + strings.Join(args, ",") + ")>"
+	case ItemPreimage:
+		return fmt.Sprintf("<preimage(%x)>", item.Hash)
+	}
+	return "<" + hex.EncodeToString(item.Data) + ">"
+}
+
+// SpendPath is one way of satisfying a policy: the witness items it
+// takes, first deepest as in a witness, and the lock time it needs
+type SpendPath struct {
+	Items    []WitnessItem
+	LockTime uint32 // 0 if none
+}
+
+// String returns the path's items in witness order, followed by the lock
+// time it needs
+func (s SpendPath) String() string {
+	items := make([]string, len(s.Items))
+	for i, item := range s.Items {
+		items[i] = item.String()
+	}
+	out := strings.Join(items, " ")
+	if s.LockTime != 0 {
+		out += fmt.Sprintf(" (lock time %d)", s.LockTime)
+	}
+	return out
+}
+
+// Paths returns the witness templates that satisfy the policy: one per
+// choice at each or(). Paths that would need a height and a timestamp
+// lock time at once can never be satisfied and are left out.
+func (p *Policy) Paths() []SpendPath {
+	switch p.Kind {
+	case KindPk:
+		return []SpendPath{{Items: []WitnessItem{{Kind: ItemSig, Keys: p.Keys}}}}
+	case KindMulti:
+		// OP_CHECKMULTISIG pops one item too many
+		return []SpendPath{{Items: []WitnessItem{{Kind: ItemPush, Data: []byte{}}, {Kind: ItemSigs, Keys: p.Keys, K: p.K}}}}
+	case KindAfter:
+		return []SpendPath{{LockTime: p.LockTime}}
+	case KindSHA256:
+		return []SpendPath{{Items: []WitnessItem{{Kind: ItemPreimage, Hash: p.Hash}}}}
+
+	case KindAnd:
+		// X runs first, so its items go on top
+		var paths []SpendPath
+		for _, x := range p.Subs[0].Paths() {
+			for _, y := range p.Subs[1].Paths() {
+				lockTime, ok := combineLockTimes(x.LockTime, y.LockTime)
+				if !ok {
+					continue
+				}
+				items := append(append([]WitnessItem{}, y.Items...), x.Items...)
+				paths = append(paths, SpendPath{Items: items, LockTime: lockTime})
+			}
+		}
+		return paths
+
+	case KindOr:
+		// The OP_IF choice goes on top: 1 for X, empty for Y
+		var paths []SpendPath
+		for i, choice := range [][]byte{{1}, {}} {
+			for _, path := range p.Subs[i].Paths() {
+				path.Items = append(append([]WitnessItem{}, path.Items...), WitnessItem{Kind: ItemPush, Data: choice})
+				paths = append(paths, path)
+			}
+		}
+		return paths
+	}
+	return nil
+}
+
+// combineLockTimes returns the lock time satisfying both a and b, the
+// later one, or false if one is a height and the other a timestamp
+func combineLockTimes(a, b uint32) (uint32, bool) {
+	if a == 0 || b == 0 {
+		return a + b, true
+	}
+	if (a < script.LockTimeThreshold) != (b < script.LockTimeThreshold) {
+		return 0, false
+	}
+	return max(a, b), true
+}
+
+// Satisfier supplies what a spend path asks for
+type Satisfier interface {
+	// Sign returns a signature by pubKey as it appears in the witness,
+	// or nil
+	Sign(pubKey []byte) []byte
+
+	// Preimage returns the preimage of a SHA-256 hash, or nil
+	Preimage(hash []byte) []byte
+
+	// CheckAfter reports whether the spending transaction's lock time
+	// satisfies after(lockTime)
+	CheckAfter(lockTime uint32) bool
+}
+
+// Satisfy returns the smallest witness stack satisfying the policy with
+// what s supplies. The witness script itself isn't included.
+func (p *Policy) Satisfy(s Satisfier) ([][]byte, error) {
+	var best [][]byte
+	bestSize := -1
+	for _, path := range p.Paths() {
+		stack, ok := path.fill(s)
+		if !ok {
+			continue
+		}
+		size := 0
+		for _, item := range stack {
+			size += len(item)
+		}
+		if bestSize < 0 || size < bestSize {
+			best, bestSize = stack, size
+		}
+	}
+	if best == nil {
+		return nil, ErrUnsatisfiable
+	}
+	return best, nil
+}
+
+// fill builds the path's witness stack, or returns false if s lacks an
+// item or the lock time isn't met
+func (path SpendPath) fill(s Satisfier) ([][]byte, bool) {
+	if path.LockTime != 0 && !s.CheckAfter(path.LockTime) {
+		return nil, false
+	}
+	stack := [][]byte{}
+	for _, item := range path.Items {
+		switch item.Kind {
+		case ItemSig:
+			sig := s.Sign(item.Keys[0])
+			if sig == nil {
+				return nil, false
+			}
+			stack = append(stack, sig)
+
+		case ItemSigs:
+			var sigs [][]byte
+			for _, key := range item.Keys {
+				if len(sigs) == item.K {
+					break
+				}
+				if sig := s.Sign(key); sig != nil {
+					sigs = append(sigs, sig)
+				}
+			}
+			if len(sigs) < item.K {
+				return nil, false
+			}
+			stack = append(stack, sigs...)
+
+		case ItemPreimage:
+			preimage := s.Preimage(item.Hash)
+			if preimage == nil {
+				return nil, false
+			}
+			stack = append(stack, preimage)
+
+		case ItemPush:
+			stack = append(stack, item.Data)
+		}
+	}
+	return stack, true
+}
@@ -0,0 +1,170 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxProfileDuration caps CPU profiles and traces requested over HTTP
+const maxProfileDuration = 5 * time.Minute
+
+// MemoryInfo summarizes the Go runtime's memory statistics
+type MemoryInfo struct {
+	HeapAlloc    uint64 `json:"heap_alloc"`    // Bytes of live heap objects
+	HeapInuse    uint64 `json:"heap_inuse"`    // Bytes in in-use heap spans
+	HeapSys      uint64 `json:"heap_sys"`      // Heap bytes obtained from the OS
+	HeapObjects  uint64 `json:"heap_objects"`  // Live heap objects
+	Sys          uint64 `json:"sys"`           // Total bytes obtained from the OS
+	NumGC        uint32 `json:"num_gc"`        // Completed GC cycles
+	LastGCPause  int64  `json:"last_gc_pause"` // Nanoseconds
+	NextGC       uint64 `json:"next_gc"`       // Heap size target of the next GC
+	NumGoroutine int    `json:"goroutines"`
+}
+
+// ReadMemoryInfo samples the runtime memory statistics. It stops the
+// world briefly, so don't call it in a hot loop.
+func ReadMemoryInfo() MemoryInfo {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	return MemoryInfo{
+		HeapAlloc:    stats.HeapAlloc,
+		HeapInuse:    stats.HeapInuse,
+		HeapSys:      stats.HeapSys,
+		HeapObjects:  stats.HeapObjects,
+		Sys:          stats.Sys,
+		NumGC:        stats.NumGC,
+		LastGCPause:  int64(stats.PauseNs[(stats.NumGC+255)%256]),
+		NextGC:       stats.NextGC,
+		NumGoroutine: runtime.NumGoroutine(),
+	}
+}
+
+// DebugHandler serves profiling data in the same layout as net/http/pprof
+// (so `go tool pprof http://host/debug/pprof/heap` works), plus a full
+// goroutine dump and the memory summary. It is built on runtime/pprof
+// because importing net/http/pprof would also expose the profiles on
+// http.DefaultServeMux, which the RPC server listens on.
+func DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", handlePprof)
+	mux.HandleFunc("/debug/pprof/profile", handleCPUProfile)
+	mux.HandleFunc("/debug/pprof/trace", handleTrace)
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		pprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	mux.HandleFunc("/debug/memory", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ReadMemoryInfo())
+	})
+	return mux
+}
+
+// handlePprof serves a named profile, or the list of profiles
+func handlePprof(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	if name == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		profiles := pprof.Profiles()
+		sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+		for _, p := range profiles {
+			fmt.Fprintf(w, "%d\t%s\n", p.Count(), p.Name())
+		}
+		fmt.Fprintln(w, "-\tprofile")
+		fmt.Fprintln(w, "-\ttrace")
+		return
+	}
+
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		http.Error(w, "unknown profile: "+name, http.StatusNotFound)
+		return
+	}
+
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	if name == "heap" && r.URL.Query().Get("gc") != "" {
+		runtime.GC()
+	}
+	profile.WriteTo(w, debug)
+}
+
+// handleCPUProfile records a CPU profile for ?seconds= (default 30)
+func handleCPUProfile(w http.ResponseWriter, r *http.Request) {
+	duration, err := profileDuration(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, fmt.Sprintf("could not start CPU profile: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sleep(r, duration)
+	pprof.StopCPUProfile()
+}
+
+// handleTrace records an execution trace for ?seconds= (default 1)
+func handleTrace(w http.ResponseWriter, r *http.Request) {
+	duration := time.Second
+	if r.URL.Query().Get("seconds") != "" {
+		var err error
+		if duration, err = profileDuration(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, fmt.Sprintf("could not start trace: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sleep(r, duration)
+	trace.Stop()
+}
+
+func profileDuration(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("seconds")
+	if value == "" {
+		return 30 * time.Second, nil
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid seconds: %q", value)
+	}
+	duration := time.Duration(seconds * float64(time.Second))
+	if duration > maxProfileDuration {
+		return 0, fmt.Errorf("profile duration %v exceeds maximum %v", duration, maxProfileDuration)
+	}
+	return duration, nil
+}
+
+// sleep waits for d or until the client goes away
+func sleep(r *http.Request, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+	}
+}
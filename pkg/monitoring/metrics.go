@@ -29,6 +29,8 @@ type Metrics struct {
 	bytesSent        uint64
 	messagesReceived uint64
 	messagesSent     uint64
+	sentByCommand    map[string]*CommandTraffic // guarded by mu
+	recvByCommand    map[string]*CommandTraffic // guarded by mu
 
 	// Mempool metrics
 	mempoolSize  int32
@@ -40,8 +42,14 @@ type Metrics struct {
 	utxoCacheMisses uint64
 
 	// Reorg metrics
-	reorgCount     uint64
-	lastReorgDepth uint64
+	reorgCount      uint64
+	lastReorgDepth  uint64
+	rejectedReorgs  uint64 // Refused for exceeding the maximum depth
+	deepestRejected uint64
+
+	// Propagation metrics
+	txPropagation    *latencyTracker
+	blockPropagation *latencyTracker
 
 	// Performance metrics
 	avgBlockTime time.Duration
@@ -52,9 +60,20 @@ type Metrics struct {
 func NewMetrics() *Metrics {
 	return &Metrics{
 		lastBlockTime: time.Now(),
+		sentByCommand: make(map[string]*CommandTraffic),
+		recvByCommand: make(map[string]*CommandTraffic),
+
+		txPropagation:    newLatencyTracker(),
+		blockPropagation: newLatencyTracker(),
 	}
 }
 
+// CommandTraffic counts the messages and bytes of one P2P command
+type CommandTraffic struct {
+	Messages uint64
+	Bytes    uint64
+}
+
 // Block Processing Metrics
 
 // RecordBlockProcessed records a processed block
@@ -176,6 +195,63 @@ func (m *Metrics) RecordMessageSent() {
 	atomic.AddUint64(&m.messagesSent, 1)
 }
 
+// RecordSent records a sent P2P message of the given command and wire size
+func (m *Metrics) RecordSent(command string, bytes uint64) {
+	atomic.AddUint64(&m.bytesSent, bytes)
+	atomic.AddUint64(&m.messagesSent, 1)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	addTraffic(m.sentByCommand, command, bytes)
+}
+
+// RecordReceived records a received P2P message of the given command and wire size
+func (m *Metrics) RecordReceived(command string, bytes uint64) {
+	atomic.AddUint64(&m.bytesReceived, bytes)
+	atomic.AddUint64(&m.messagesReceived, 1)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	addTraffic(m.recvByCommand, command, bytes)
+}
+
+// addTraffic adds one message to a per-command table (lock held)
+func addTraffic(table map[string]*CommandTraffic, command string, bytes uint64) {
+	traffic, exists := table[command]
+	if !exists {
+		traffic = &CommandTraffic{}
+		table[command] = traffic
+	}
+	traffic.Messages++
+	traffic.Bytes += bytes
+}
+
+// GetMessagesSent returns total messages sent
+func (m *Metrics) GetMessagesSent() uint64 {
+	return atomic.LoadUint64(&m.messagesSent)
+}
+
+// GetMessagesReceived returns total messages received
+func (m *Metrics) GetMessagesReceived() uint64 {
+	return atomic.LoadUint64(&m.messagesReceived)
+}
+
+// GetTrafficByCommand returns copies of the per-command sent and received tables
+func (m *Metrics) GetTrafficByCommand() (sent, received map[string]CommandTraffic) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sent = make(map[string]CommandTraffic, len(m.sentByCommand))
+	for command, traffic := range m.sentByCommand {
+		sent[command] = *traffic
+	}
+	received = make(map[string]CommandTraffic, len(m.recvByCommand))
+	for command, traffic := range m.recvByCommand {
+		received[command] = *traffic
+	}
+	return sent, received
+}
+
 // GetBytesReceived returns total bytes received
 func (m *Metrics) GetBytesReceived() uint64 {
 	return atomic.LoadUint64(&m.bytesReceived)
@@ -257,24 +333,48 @@ func (m *Metrics) GetLastReorgDepth() uint64 {
 	return atomic.LoadUint64(&m.lastReorgDepth)
 }
 
+// RecordRejectedReorg records a reorganization refused for being too deep
+func (m *Metrics) RecordRejectedReorg(depth uint64) {
+	atomic.AddUint64(&m.rejectedReorgs, 1)
+	for {
+		deepest := atomic.LoadUint64(&m.deepestRejected)
+		if depth <= deepest || atomic.CompareAndSwapUint64(&m.deepestRejected, deepest, depth) {
+			return
+		}
+	}
+}
+
+// GetRejectedReorgCount returns how many reorgs were refused as too deep
+func (m *Metrics) GetRejectedReorgCount() uint64 {
+	return atomic.LoadUint64(&m.rejectedReorgs)
+}
+
+// GetDeepestRejectedReorg returns the depth of the deepest refused reorg
+func (m *Metrics) GetDeepestRejectedReorg() uint64 {
+	return atomic.LoadUint64(&m.deepestRejected)
+}
+
 // Summary returns a metrics summary
 func (m *Metrics) Summary() map[string]interface{} {
 	return map[string]interface{}{
-		"blocks_processed":    m.GetBlocksProcessed(),
-		"avg_block_time_ms":   m.GetAvgBlockProcessingTime().Milliseconds(),
-		"tx_processed":        m.GetTxProcessed(),
-		"avg_tx_time_us":      m.GetAvgTxValidationTime().Microseconds(),
-		"peer_count":          m.GetPeerCount(),
-		"inbound_peers":       m.GetInboundPeers(),
-		"outbound_peers":      m.GetOutboundPeers(),
-		"bytes_received":      m.GetBytesReceived(),
-		"bytes_sent":          m.GetBytesSent(),
-		"mempool_size":        m.GetMempoolSize(),
-		"mempool_bytes":       m.GetMempoolBytes(),
-		"utxo_set_size":       m.GetUTXOSetSize(),
-		"utxo_cache_hit_rate": m.GetUTXOCacheHitRate(),
-		"reorg_count":         m.GetReorgCount(),
-		"last_reorg_depth":    m.GetLastReorgDepth(),
+		"blocks_processed":         m.GetBlocksProcessed(),
+		"avg_block_time_ms":        m.GetAvgBlockProcessingTime().Milliseconds(),
+		"tx_processed":             m.GetTxProcessed(),
+		"avg_tx_time_us":           m.GetAvgTxValidationTime().Microseconds(),
+		"peer_count":               m.GetPeerCount(),
+		"inbound_peers":            m.GetInboundPeers(),
+		"outbound_peers":           m.GetOutboundPeers(),
+		"bytes_received":           m.GetBytesReceived(),
+		"bytes_sent":               m.GetBytesSent(),
+		"mempool_size":             m.GetMempoolSize(),
+		"mempool_bytes":            m.GetMempoolBytes(),
+		"utxo_set_size":            m.GetUTXOSetSize(),
+		"utxo_cache_hit_rate":      m.GetUTXOCacheHitRate(),
+		"reorg_count":              m.GetReorgCount(),
+		"last_reorg_depth":         m.GetLastReorgDepth(),
+		"rejected_reorgs":          m.GetRejectedReorgCount(),
+		"tx_propagation_p50_ms":    m.GetPropagationStats(PropagationTx).P50.Milliseconds(),
+		"block_propagation_p50_ms": m.GetPropagationStats(PropagationBlock).P50.Milliseconds(),
 	}
 }
 
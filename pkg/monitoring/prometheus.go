@@ -0,0 +1,88 @@
+package monitoring
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// MetricsPrefix is prepended to every exported metric name
+const MetricsPrefix = "learnbitcoin_"
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	writeMetric(bw, "blocks_processed_total", "counter", "Blocks processed", int64(m.GetBlocksProcessed()))
+	writeMetric(bw, "transactions_processed_total", "counter", "Transactions processed", int64(m.GetTxProcessed()))
+	writeMetric(bw, "peers", "gauge", "Connected peers", int64(m.GetPeerCount()))
+	writeMetric(bw, "peers_inbound", "gauge", "Inbound peers", int64(m.GetInboundPeers()))
+	writeMetric(bw, "peers_outbound", "gauge", "Outbound peers", int64(m.GetOutboundPeers()))
+	writeMetric(bw, "p2p_bytes_sent_total", "counter", "Bytes sent to peers", int64(m.GetBytesSent()))
+	writeMetric(bw, "p2p_bytes_received_total", "counter", "Bytes received from peers", int64(m.GetBytesReceived()))
+	writeMetric(bw, "p2p_messages_sent_total", "counter", "Messages sent to peers", int64(m.GetMessagesSent()))
+	writeMetric(bw, "p2p_messages_received_total", "counter", "Messages received from peers", int64(m.GetMessagesReceived()))
+	writeMetric(bw, "mempool_transactions", "gauge", "Transactions in the mempool", int64(m.GetMempoolSize()))
+	writeMetric(bw, "mempool_bytes", "gauge", "Size of the mempool in bytes", int64(m.GetMempoolBytes()))
+	writeMetric(bw, "utxo_set_size", "gauge", "Unspent transaction outputs", int64(m.GetUTXOSetSize()))
+	writeMetric(bw, "reorgs_total", "counter", "Chain reorganizations", int64(m.GetReorgCount()))
+	writeMetric(bw, "reorgs_rejected_total", "counter", "Reorganizations refused for exceeding the maximum depth", int64(m.GetRejectedReorgCount()))
+	writeMetric(bw, "reorg_rejected_max_depth", "gauge", "Depth of the deepest refused reorganization", int64(m.GetDeepestRejectedReorg()))
+
+	sent, received := m.GetTrafficByCommand()
+	writeCommandMetric(bw, "p2p_command_bytes_sent_total", "Bytes sent per P2P command", sent, func(t CommandTraffic) uint64 { return t.Bytes })
+	writeCommandMetric(bw, "p2p_command_bytes_received_total", "Bytes received per P2P command", received, func(t CommandTraffic) uint64 { return t.Bytes })
+	writeCommandMetric(bw, "p2p_command_messages_sent_total", "Messages sent per P2P command", sent, func(t CommandTraffic) uint64 { return t.Messages })
+	writeCommandMetric(bw, "p2p_command_messages_received_total", "Messages received per P2P command", received, func(t CommandTraffic) uint64 { return t.Messages })
+
+	writeSummary(bw, "tx_propagation_seconds", "Time from first transaction inv to relaying the validated transaction", m.GetPropagationStats(PropagationTx))
+	writeSummary(bw, "block_propagation_seconds", "Time from first block inv to relaying the validated block", m.GetPropagationStats(PropagationBlock))
+
+	return bw.Flush()
+}
+
+// PrometheusHandler serves the metrics for a Prometheus scraper
+func PrometheusHandler(m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.WritePrometheus(w)
+	})
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", MetricsPrefix, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s %s\n", MetricsPrefix, name, kind)
+	fmt.Fprintf(w, "%s%s %d\n", MetricsPrefix, name, value)
+}
+
+func writeCommandMetric(w io.Writer, name, help string, table map[string]CommandTraffic, value func(CommandTraffic) uint64) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", MetricsPrefix, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s counter\n", MetricsPrefix, name)
+
+	// Stable output order makes scrapes diffable
+	commands := make([]string, 0, len(table))
+	for command := range table {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	for _, command := range commands {
+		fmt.Fprintf(w, "%s%s{command=%q} %d\n", MetricsPrefix, name, command, value(table[command]))
+	}
+}
+
+func writeSummary(w io.Writer, name, help string, stats PropagationStats) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", MetricsPrefix, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s summary\n", MetricsPrefix, name)
+	for _, q := range []struct {
+		quantile string
+		value    time.Duration
+	}{{"0.5", stats.P50}, {"0.9", stats.P90}, {"0.99", stats.P99}} {
+		fmt.Fprintf(w, "%s%s{quantile=%q} %g\n", MetricsPrefix, name, q.quantile, q.value.Seconds())
+	}
+	fmt.Fprintf(w, "%s%s_sum %g\n", MetricsPrefix, name, stats.Sum.Seconds())
+	fmt.Fprintf(w, "%s%s_count %d\n", MetricsPrefix, name, stats.Count)
+}
@@ -0,0 +1,170 @@
+package monitoring
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Propagation latency is the time between the first inv announcing an
+// object and the moment this node has validated it and relayed it on.
+// Comparing it across a few local nodes shows how fast transactions and
+// blocks cross the network.
+
+// Object kinds whose propagation is tracked
+const (
+	PropagationTx    = "tx"
+	PropagationBlock = "block"
+)
+
+const (
+	// propagationSamples is how many recent latencies percentiles cover
+	propagationSamples = 1000
+
+	// maxPendingPropagations caps objects announced but not yet relayed
+	maxPendingPropagations = 50000
+
+	// propagationTimeout drops announcements never followed by a relay
+	propagationTimeout = 10 * time.Minute
+)
+
+// PropagationStats summarizes the propagation latency of one object kind
+type PropagationStats struct {
+	Count uint64        // Objects relayed after being announced
+	Sum   time.Duration // Total latency of all of them
+	P50   time.Duration // Percentiles over the most recent samples
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// latencyTracker pairs first-seen announcements with relays
+type latencyTracker struct {
+	seen    map[string]time.Time
+	samples []time.Duration // Ring buffer of recent latencies
+	next    int
+	count   uint64
+	sum     time.Duration
+	mu      sync.Mutex
+}
+
+// newLatencyTracker creates an empty tracker
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{seen: make(map[string]time.Time)}
+}
+
+// announced records when hash was first announced
+func (lt *latencyTracker) announced(hash string, at time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if _, exists := lt.seen[hash]; exists {
+		return
+	}
+	if len(lt.seen) >= maxPendingPropagations {
+		lt.prune(at)
+		if len(lt.seen) >= maxPendingPropagations {
+			return
+		}
+	}
+	lt.seen[hash] = at
+}
+
+// prune drops announcements older than propagationTimeout (lock held)
+func (lt *latencyTracker) prune(now time.Time) {
+	for hash, seen := range lt.seen {
+		if now.Sub(seen) > propagationTimeout {
+			delete(lt.seen, hash)
+		}
+	}
+}
+
+// relayed records a latency sample if hash was announced earlier
+func (lt *latencyTracker) relayed(hash string, at time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	seen, exists := lt.seen[hash]
+	if !exists {
+		return
+	}
+	delete(lt.seen, hash)
+
+	latency := at.Sub(seen)
+	if latency < 0 {
+		latency = 0
+	}
+	if len(lt.samples) < propagationSamples {
+		lt.samples = append(lt.samples, latency)
+	} else {
+		lt.samples[lt.next] = latency
+	}
+	lt.next = (lt.next + 1) % propagationSamples
+	lt.count++
+	lt.sum += latency
+}
+
+// stats computes percentiles over the recent samples
+func (lt *latencyTracker) stats() PropagationStats {
+	lt.mu.Lock()
+	sorted := append([]time.Duration(nil), lt.samples...)
+	stats := PropagationStats{Count: lt.count, Sum: lt.sum}
+	lt.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return stats
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	stats.P50 = percentile(sorted, 0.50)
+	stats.P90 = percentile(sorted, 0.90)
+	stats.P99 = percentile(sorted, 0.99)
+	stats.Max = sorted[len(sorted)-1]
+	return stats
+}
+
+// percentile picks the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// RecordInvSeen records when an object was first announced to this node
+func (m *Metrics) RecordInvSeen(kind string, hash string, at time.Time) {
+	if tracker := m.propagationTracker(kind); tracker != nil {
+		tracker.announced(hash, at)
+	}
+}
+
+// RecordRelayed records that an announced object was validated and relayed
+func (m *Metrics) RecordRelayed(kind string, hash string, at time.Time) {
+	if tracker := m.propagationTracker(kind); tracker != nil {
+		tracker.relayed(hash, at)
+	}
+}
+
+// GetPropagationStats returns the propagation latency of an object kind
+func (m *Metrics) GetPropagationStats(kind string) PropagationStats {
+	if tracker := m.propagationTracker(kind); tracker != nil {
+		return tracker.stats()
+	}
+	return PropagationStats{}
+}
+
+// propagationTracker returns the tracker for kind, nil if unknown
+func (m *Metrics) propagationTracker(kind string) *latencyTracker {
+	switch kind {
+	case PropagationTx:
+		return m.txPropagation
+	case PropagationBlock:
+		return m.blockPropagation
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,324 @@
+package addrmgr
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	mrand "math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// NewBucketCount is the number of buckets for addresses we have only heard about
+	NewBucketCount = 1024
+
+	// TriedBucketCount is the number of buckets for addresses we have connected to
+	TriedBucketCount = 256
+
+	// BucketSize is the maximum number of addresses in one bucket
+	BucketSize = 64
+)
+
+// KnownAddress is an address tracked by the manager
+type KnownAddress struct {
+	Addr        string // host:port
+	Source      string // Who told us about it (peer address or seed hostname)
+	Added       time.Time
+	Attempts    int
+	LastAttempt time.Time
+	LastSuccess time.Time
+
+	tried  bool
+	bucket int
+}
+
+// Tried reports whether we have successfully connected to the address
+func (ka *KnownAddress) Tried() bool {
+	return ka.tried
+}
+
+// AddrManager keeps candidate peer addresses in "new" and "tried" buckets,
+// loosely following Bitcoin Core's addrman. Bucket placement is keyed by a
+// secret random value and the network group of the address and its source,
+// so a single source can only fill a small part of the table.
+type AddrManager struct {
+	key   [32]byte
+	index map[string]*KnownAddress
+
+	newBuckets   [NewBucketCount]map[string]*KnownAddress
+	triedBuckets [TriedBucketCount]map[string]*KnownAddress
+	nNew         int
+	nTried       int
+
+	rand *mrand.Rand
+	mu   sync.RWMutex
+}
+
+// New creates an empty address manager
+func New() *AddrManager {
+	am := &AddrManager{
+		index: make(map[string]*KnownAddress),
+	}
+	if _, err := rand.Read(am.key[:]); err != nil {
+		panic(fmt.Sprintf("addrmgr: failed to read random key: %v", err))
+	}
+	am.rand = mrand.New(mrand.NewSource(int64(binary.LittleEndian.Uint64(am.key[:8]))))
+
+	for i := range am.newBuckets {
+		am.newBuckets[i] = make(map[string]*KnownAddress)
+	}
+	for i := range am.triedBuckets {
+		am.triedBuckets[i] = make(map[string]*KnownAddress)
+	}
+
+	return am
+}
+
+// AddAddress records addr as learned from source. Addresses already known
+// are left where they are. Returns an error if addr is not a valid host:port
+// with a numeric IP.
+func (am *AddrManager) AddAddress(addr, source string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid address %q: host is not an IP", addr)
+	}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	if _, exists := am.index[addr]; exists {
+		return nil
+	}
+
+	ka := &KnownAddress{
+		Addr:   addr,
+		Source: source,
+		Added:  time.Now(),
+		bucket: am.newBucket(addr, source),
+	}
+
+	bucket := am.newBuckets[ka.bucket]
+	if len(bucket) >= BucketSize {
+		am.removeLocked(am.oldest(bucket))
+	}
+
+	bucket[addr] = ka
+	am.index[addr] = ka
+	am.nNew++
+
+	return nil
+}
+
+// AddAddresses adds several addresses from the same source and returns how
+// many were accepted
+func (am *AddrManager) AddAddresses(addrs []string, source string) int {
+	added := 0
+	for _, addr := range addrs {
+		if err := am.AddAddress(addr, source); err == nil {
+			added++
+		}
+	}
+	return added
+}
+
+// Attempt records a connection attempt to addr
+func (am *AddrManager) Attempt(addr string) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	if ka, ok := am.index[addr]; ok {
+		ka.Attempts++
+		ka.LastAttempt = time.Now()
+	}
+}
+
+// Good moves addr to the tried table after a successful handshake. When the
+// target tried bucket is full its oldest entry is pushed back to "new".
+func (am *AddrManager) Good(addr string) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	ka, ok := am.index[addr]
+	if !ok {
+		return
+	}
+
+	ka.LastSuccess = time.Now()
+	ka.Attempts = 0
+	if ka.tried {
+		return
+	}
+
+	delete(am.newBuckets[ka.bucket], addr)
+	am.nNew--
+
+	triedIndex := am.triedBucket(addr)
+	bucket := am.triedBuckets[triedIndex]
+	if len(bucket) >= BucketSize {
+		evicted := am.oldest(bucket)
+		delete(bucket, evicted.Addr)
+		am.nTried--
+
+		evicted.tried = false
+		evicted.bucket = am.newBucket(evicted.Addr, evicted.Source)
+		if newBucket := am.newBuckets[evicted.bucket]; len(newBucket) < BucketSize {
+			newBucket[evicted.Addr] = evicted
+			am.nNew++
+		} else {
+			delete(am.index, evicted.Addr)
+		}
+	}
+
+	ka.tried = true
+	ka.bucket = triedIndex
+	bucket[addr] = ka
+	am.nTried++
+}
+
+// GetAddress picks a random address to connect to, choosing between the
+// tried and new tables with equal odds. Returns nil if nothing is known.
+func (am *AddrManager) GetAddress() *KnownAddress {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	if am.nNew+am.nTried == 0 {
+		return nil
+	}
+
+	useTried := am.nTried > 0 && (am.nNew == 0 || am.rand.Intn(2) == 0)
+	if useTried {
+		return am.pick(am.triedBuckets[:])
+	}
+	return am.pick(am.newBuckets[:])
+}
+
+// Lookup returns the tracked entry for addr
+func (am *AddrManager) Lookup(addr string) (*KnownAddress, bool) {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	ka, ok := am.index[addr]
+	if !ok {
+		return nil, false
+	}
+	copied := *ka
+	return &copied, true
+}
+
+// NumAddresses returns the total number of known addresses
+func (am *AddrManager) NumAddresses() int {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	return am.nNew + am.nTried
+}
+
+// NewCount returns the number of addresses in the new table
+func (am *AddrManager) NewCount() int {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	return am.nNew
+}
+
+// TriedCount returns the number of addresses in the tried table
+func (am *AddrManager) TriedCount() int {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	return am.nTried
+}
+
+// pick returns a copy of a random entry from a random non-empty bucket
+// (internal, lock held)
+func (am *AddrManager) pick(buckets []map[string]*KnownAddress) *KnownAddress {
+	start := am.rand.Intn(len(buckets))
+	for i := 0; i < len(buckets); i++ {
+		bucket := buckets[(start+i)%len(buckets)]
+		if len(bucket) == 0 {
+			continue
+		}
+
+		n := am.rand.Intn(len(bucket))
+		for _, ka := range bucket {
+			if n == 0 {
+				copied := *ka
+				return &copied
+			}
+			n--
+		}
+	}
+	return nil
+}
+
+// removeLocked drops a new-table entry (internal, lock held)
+func (am *AddrManager) removeLocked(ka *KnownAddress) {
+	delete(am.newBuckets[ka.bucket], ka.Addr)
+	delete(am.index, ka.Addr)
+	am.nNew--
+}
+
+// oldest returns the least recently useful entry in a bucket
+func (am *AddrManager) oldest(bucket map[string]*KnownAddress) *KnownAddress {
+	var oldest *KnownAddress
+	for _, ka := range bucket {
+		if oldest == nil || lastSeen(ka).Before(lastSeen(oldest)) {
+			oldest = ka
+		}
+	}
+	return oldest
+}
+
+// lastSeen is the most recent time we know the address was good (or added)
+func lastSeen(ka *KnownAddress) time.Time {
+	if ka.LastSuccess.After(ka.Added) {
+		return ka.LastSuccess
+	}
+	return ka.Added
+}
+
+// newBucket maps an address and its source to a new-table bucket
+func (am *AddrManager) newBucket(addr, source string) int {
+	return am.bucketIndex(NewBucketCount, "new", groupKey(addr), groupKey(source))
+}
+
+// triedBucket maps an address to a tried-table bucket
+func (am *AddrManager) triedBucket(addr string) int {
+	return am.bucketIndex(TriedBucketCount, "tried", groupKey(addr), addr)
+}
+
+// bucketIndex hashes the secret key with parts into [0, count)
+func (am *AddrManager) bucketIndex(count int, parts ...string) int {
+	h := sha256.New()
+	h.Write(am.key[:])
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	sum := h.Sum(nil)
+	return int(binary.LittleEndian.Uint64(sum[:8]) % uint64(count))
+}
+
+// groupKey returns the network group of an address: the /16 for IPv4, the
+// /32 for IPv6, and the name itself for anything else (e.g. a DNS seed)
+func groupKey(addr string) string {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("ipv4:%d.%d", ip4[0], ip4[1])
+	}
+	return fmt.Sprintf("ipv6:%x", []byte(ip[:4]))
+}
@@ -0,0 +1,338 @@
+package network
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/peer"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Dandelion relay: our own transactions first travel a "stem", being
+// passed to one outbound peer at a time. Every hop ends the stem with
+// a small probability and "fluffs" the transaction, announcing it to all
+// its peers as usual. Observers then see the broadcast start several hops
+// away from the node that created it.
+
+const (
+	// DefaultStemFluffProbability is the chance that a hop ends the stem
+	DefaultStemFluffProbability = 0.1
+
+	// DefaultStemEmbargo is how long a stem transaction may take to be
+	// fluffed by someone else before this node fluffs it itself
+	DefaultStemEmbargo = 30 * time.Second
+
+	// StemEpoch is how long the same peer is used as the stem relay
+	StemEpoch = 10 * time.Minute
+
+	// stemCheckInterval is how often embargoes are checked
+	stemCheckInterval = time.Second
+)
+
+// DandelionStats counts transactions by how this node relayed them
+type DandelionStats struct {
+	Stemmed   uint64 // Passed on to the stem relay
+	Fluffed   uint64 // Stem ended here and the transaction was announced
+	Embargoed uint64 // Fluffed because nobody else did before the embargo
+	Pending   int    // On the stem, waiting to be seen fluffed
+}
+
+// stemTx is a transaction this node passed along the stem
+type stemTx struct {
+	tx      *types.Transaction
+	fee     int64
+	embargo time.Time // Fluffed by us if not seen relayed by then
+}
+
+// stemPool holds stem transactions. They stay out of the mempool so that
+// getdata requests can't reveal that this node has seen them.
+type stemPool struct {
+	txs      map[types.Hash]*stemTx
+	relay    string    // Address of the current stem relay
+	epochEnd time.Time // When a new relay is picked
+	stats    DandelionStats
+	mu       sync.Mutex
+}
+
+// newStemPool creates an empty stem pool
+func newStemPool() *stemPool {
+	return &stemPool{txs: make(map[types.Hash]*stemTx)}
+}
+
+// remove drops a transaction that has left the stem
+func (sp *stemPool) remove(txHash types.Hash) {
+	sp.mu.Lock()
+	delete(sp.txs, txHash)
+	sp.mu.Unlock()
+}
+
+// has reports whether txHash is on the stem
+func (sp *stemPool) has(txHash types.Hash) bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	_, ok := sp.txs[txHash]
+	return ok
+}
+
+// removeConfirmed drops stem transactions included in a block
+func (sp *stemPool) removeConfirmed(txs []types.Transaction) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	for i := range txs {
+		if txHash, err := serialization.HashTransaction(&txs[i]); err == nil {
+			delete(sp.txs, txHash)
+		}
+	}
+}
+
+// DandelionStats returns the node's stem relay counters
+func (n *Node) DandelionStats() DandelionStats {
+	n.stem.mu.Lock()
+	defer n.stem.mu.Unlock()
+
+	stats := n.stem.stats
+	stats.Pending = len(n.stem.txs)
+	return stats
+}
+
+// BroadcastTransaction relays a transaction created by this node. With
+// Dandelion enabled it goes out along the stem; otherwise it enters the
+// mempool and is announced to every peer.
+func (n *Node) BroadcastTransaction(tx *types.Transaction) error {
+	txHash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		return err
+	}
+
+	fee, err := n.checkStemTransaction(tx)
+	if err != nil {
+		return err
+	}
+
+	if !n.Config.EnableDandelion {
+		height, _ := n.Blockchain.GetBestBlockHeight()
+		if err := n.Mempool.Add(tx, fee, height); err != nil {
+			return err
+		}
+		n.RelayTransaction(tx, "")
+		return nil
+	}
+
+	n.stemTransaction(tx, txHash, fee, "")
+	return nil
+}
+
+// handleStemTx extends or ends the stem of a transaction a peer passed us
+func (n *Node) handleStemTx(p *peer.Peer, tx *types.Transaction) error {
+	if !n.Config.EnableDandelion {
+		// We don't advertise stem relay; treat it as a normal transaction
+		return n.handleTx(p, tx)
+	}
+
+	txHash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		return err
+	}
+	n.SyncManager.TxReceived(txHash)
+	if n.Mempool.Exists(txHash) || n.stem.has(txHash) {
+		return nil
+	}
+	wtxid, err := serialization.HashTransactionWitness(tx)
+	if err != nil {
+		return err
+	}
+	if n.rejects.Contains(wtxid) {
+		return nil
+	}
+
+	fee, err := n.checkStemTransaction(tx)
+	if err != nil {
+		n.rejectTx(p, protocol.CmdDandelionTx, txHash, wtxid, err)
+		return nil
+	}
+
+	if rand.Float64() < n.stemFluffProbability() {
+		n.fluffStem(tx, txHash, fee)
+		return nil
+	}
+	n.stemTransaction(tx, txHash, fee, p.Address())
+	return nil
+}
+
+// checkStemTransaction checks what the mempool would before a transaction
+// is relayed without entering it, and returns its fee
+func (n *Node) checkStemTransaction(tx *types.Transaction) (int64, error) {
+	fee, err := n.checkTransactionInputs(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, input := range tx.Inputs {
+		outpoint := types.OutPoint{Hash: input.PrevTxHash, Index: input.OutputIndex}
+		if spender, ok := n.Mempool.SpentBy(outpoint); ok {
+			return 0, fmt.Errorf("%w: output already spent by %s", mempool.ErrConflict, spender)
+		}
+	}
+	return fee, nil
+}
+
+// stemTransaction passes a transaction to the stem relay, or fluffs it
+// when there is no peer to extend the stem
+func (n *Node) stemTransaction(tx *types.Transaction, txHash types.Hash, fee int64, source string) {
+	relay := n.stemRelay(source)
+	if relay == nil {
+		n.fluffStem(tx, txHash, fee)
+		return
+	}
+
+	serialized, err := serialization.SerializeTransactionWitness(tx)
+	if err != nil {
+		return
+	}
+
+	// Randomized so the nodes on a stem don't all fluff at once
+	embargo := n.stemEmbargo()
+	embargo += time.Duration(rand.Int63n(int64(embargo)/2 + 1))
+
+	n.stem.mu.Lock()
+	n.stem.txs[txHash] = &stemTx{tx: tx, fee: fee, embargo: n.getClock().Now().Add(embargo)}
+	n.stem.stats.Stemmed++
+	n.stem.mu.Unlock()
+
+	relay.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdDandelionTx, serialized))
+}
+
+// fluffStem ends the stem: the transaction enters the mempool and is
+// announced to every peer, the ones on the stem included since they kept
+// it out of their mempools
+func (n *Node) fluffStem(tx *types.Transaction, txHash types.Hash, fee int64) {
+	n.stem.remove(txHash)
+
+	height, _ := n.Blockchain.GetBestBlockHeight()
+	if err := n.Mempool.Add(tx, fee, height); err != nil {
+		fmt.Printf("Dropping stem transaction %s: %v\n", txHash, err)
+		return
+	}
+
+	n.stem.mu.Lock()
+	n.stem.stats.Fluffed++
+	n.stem.mu.Unlock()
+
+	n.RelayTransaction(tx, "")
+}
+
+// stemRelay returns the outbound peer stem transactions are passed to,
+// picking a new one each epoch or when the current one is gone. Peers on
+// the encrypted transport are preferred. A transaction never goes back to
+// the peer it came from, so source gets a different relay or none.
+func (n *Node) stemRelay(source string) *peer.Peer {
+	n.stem.mu.Lock()
+	defer n.stem.mu.Unlock()
+
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+
+	var candidates, encrypted []*peer.Peer
+	for _, p := range n.peers {
+		if p.Inbound || !p.HandshakeComplete() || p.Services()&protocol.SFNodeDandelion == 0 {
+			continue
+		}
+		candidates = append(candidates, p)
+		if p.Transport == "v2" {
+			encrypted = append(encrypted, p)
+		}
+	}
+	if len(encrypted) > 0 {
+		candidates = encrypted
+	}
+
+	now := n.getClock().Now()
+	current, ok := n.peers[n.stem.relay]
+	if !ok || !containsPeer(candidates, current) || !now.Before(n.stem.epochEnd) {
+		current = nil
+		n.stem.relay = ""
+		if len(candidates) > 0 {
+			current = candidates[rand.Intn(len(candidates))]
+			n.stem.relay = current.Address()
+			n.stem.epochEnd = now.Add(StemEpoch)
+		}
+	}
+
+	if current == nil || current.Address() != source {
+		return current
+	}
+	for _, p := range candidates {
+		if p.Address() != source {
+			return p
+		}
+	}
+	return nil
+}
+
+// containsPeer reports whether p is in peers
+func containsPeer(peers []*peer.Peer, p *peer.Peer) bool {
+	for _, other := range peers {
+		if other == p {
+			return true
+		}
+	}
+	return false
+}
+
+// stemLoop fluffs stem transactions whose embargo ran out: the stem
+// probably ended at a peer that dropped them
+func (n *Node) stemLoop() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.getClock().After(stemCheckInterval):
+			n.fluffEmbargoed()
+		}
+	}
+}
+
+// fluffEmbargoed fluffs every stem transaction past its embargo
+func (n *Node) fluffEmbargoed() {
+	now := n.getClock().Now()
+
+	n.stem.mu.Lock()
+	expired := make(map[types.Hash]*stemTx)
+	for txHash, entry := range n.stem.txs {
+		if !now.Before(entry.embargo) {
+			expired[txHash] = entry
+			delete(n.stem.txs, txHash)
+			n.stem.stats.Embargoed++
+		}
+	}
+	n.stem.mu.Unlock()
+
+	for txHash, entry := range expired {
+		n.fluffStem(entry.tx, txHash, entry.fee)
+	}
+}
+
+// stemFluffProbability returns the configured chance of ending the stem
+func (n *Node) stemFluffProbability() float64 {
+	if n.Config.StemFluffProbability > 0 {
+		return n.Config.StemFluffProbability
+	}
+	return DefaultStemFluffProbability
+}
+
+// stemEmbargo returns the configured embargo
+func (n *Node) stemEmbargo() time.Duration {
+	if n.Config.StemEmbargo > 0 {
+		return n.Config.StemEmbargo
+	}
+	return DefaultStemEmbargo
+}
@@ -0,0 +1,61 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/addrmgr"
+)
+
+// DNSSeeder resolves seed hostnames into candidate peer addresses
+type DNSSeeder struct {
+	Seeds []string
+	Port  uint16 // Seeds return bare IPs; this is the port we assume they listen on
+
+	// LookupHost resolves a hostname (net.LookupHost by default)
+	LookupHost func(host string) ([]string, error)
+}
+
+// NewDNSSeeder creates a seeder for the given hostnames
+func NewDNSSeeder(seeds []string, port uint16) *DNSSeeder {
+	return &DNSSeeder{
+		Seeds:      seeds,
+		Port:       port,
+		LookupHost: net.LookupHost,
+	}
+}
+
+// Seed queries every seed and adds the results to the new table of am.
+// Nothing is connected to directly. Returns the number of addresses added
+// and an error only if every seed failed.
+func (s *DNSSeeder) Seed(am *addrmgr.AddrManager) (int, error) {
+	added := 0
+	failed := 0
+	var lastErr error
+
+	for _, seed := range s.Seeds {
+		ips, err := s.LookupHost(seed)
+		if err != nil {
+			failed++
+			lastErr = err
+			fmt.Printf("DNS seed %s failed: %v\n", seed, err)
+			continue
+		}
+
+		addrs := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, strconv.Itoa(int(s.Port))))
+		}
+
+		n := am.AddAddresses(addrs, seed)
+		fmt.Printf("DNS seed %s returned %d addresses (%d new)\n", seed, len(ips), n)
+		added += n
+	}
+
+	if len(s.Seeds) > 0 && failed == len(s.Seeds) {
+		return 0, fmt.Errorf("all %d DNS seeds failed: %w", failed, lastErr)
+	}
+
+	return added, nil
+}
@@ -0,0 +1,90 @@
+package nat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoGateway is returned when no port-mapping capable router was found
+var ErrNoGateway = errors.New("no NAT-PMP or UPnP gateway found")
+
+// PortMapper asks a home router to forward an external port to us
+type PortMapper interface {
+	// Name identifies the mapping protocol ("natpmp" or "upnp")
+	Name() string
+
+	// ExternalIP returns the router's public address
+	ExternalIP() (net.IP, error)
+
+	// AddPortMapping forwards externalPort to internalPort on this host for
+	// the given lifetime and returns the external port actually granted
+	AddPortMapping(protocol string, internalPort, externalPort int, description string, lifetime time.Duration) (int, error)
+
+	// DeletePortMapping removes a mapping created by AddPortMapping
+	DeletePortMapping(protocol string, internalPort, externalPort int) error
+}
+
+// Discover looks for a router that supports NAT-PMP (tried first, it's a
+// single UDP round trip) or UPnP IGD
+func Discover(timeout time.Duration) (PortMapper, error) {
+	if gateway, err := DefaultGateway(); err == nil {
+		pmp := NewNATPMP(gateway)
+		pmp.Timeout = timeout
+		if _, err := pmp.ExternalIP(); err == nil {
+			return pmp, nil
+		}
+	}
+
+	igd, err := DiscoverUPnP(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoGateway, err)
+	}
+	return igd, nil
+}
+
+// DefaultGateway returns the IPv4 default route from /proc/net/route
+func DefaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing table: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Header line
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+
+		var gw uint32
+		if _, err := fmt.Sscanf(fields[2], "%x", &gw); err != nil || gw == 0 {
+			continue
+		}
+
+		// The kernel prints the address in host (little-endian) order
+		ip := make(net.IP, 4)
+		binary.LittleEndian.PutUint32(ip, gw)
+		return ip, nil
+	}
+
+	return nil, errors.New("no default route")
+}
+
+// localIPFor returns our address on the interface used to reach remote
+func localIPFor(remote string) (net.IP, error) {
+	conn, err := net.Dial("udp4", remote)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,148 @@
+package nat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// NATPMPPort is the UDP port routers listen on for NAT-PMP (RFC 6886)
+	NATPMPPort = 5351
+
+	natpmpVersion       = 0
+	natpmpOpExternalIP  = 0
+	natpmpOpMapUDP      = 1
+	natpmpOpMapTCP      = 2
+	natpmpResponseFlag  = 128
+	natpmpInitialResend = 250 * time.Millisecond
+)
+
+// natpmpResultCodes are the RFC 6886 error results
+var natpmpResultCodes = map[uint16]string{
+	1: "unsupported version",
+	2: "not authorized",
+	3: "network failure",
+	4: "out of resources",
+	5: "unsupported opcode",
+}
+
+// NATPMP is a NAT-PMP client talking to a single gateway
+type NATPMP struct {
+	Gateway string        // host:port of the router
+	Timeout time.Duration // Total time to wait for a response
+}
+
+// NewNATPMP creates a client for the gateway on the standard port
+func NewNATPMP(gateway net.IP) *NATPMP {
+	return &NATPMP{
+		Gateway: net.JoinHostPort(gateway.String(), strconv.Itoa(NATPMPPort)),
+		Timeout: 2 * time.Second,
+	}
+}
+
+// Name returns "natpmp"
+func (n *NATPMP) Name() string {
+	return "natpmp"
+}
+
+// ExternalIP asks the gateway for its public address
+func (n *NATPMP) ExternalIP() (net.IP, error) {
+	resp, err := n.call([]byte{natpmpVersion, natpmpOpExternalIP}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+// AddPortMapping requests a mapping; the router may grant a different
+// external port or a shorter lifetime than asked for
+func (n *NATPMP) AddPortMapping(protocol string, internalPort, externalPort int, description string, lifetime time.Duration) (int, error) {
+	resp, err := n.mapPort(protocol, internalPort, externalPort, uint32(lifetime/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(resp[10:12])), nil
+}
+
+// DeletePortMapping removes a mapping by requesting a zero lifetime
+func (n *NATPMP) DeletePortMapping(protocol string, internalPort, externalPort int) error {
+	_, err := n.mapPort(protocol, internalPort, 0, 0)
+	return err
+}
+
+// mapPort sends a mapping request
+func (n *NATPMP) mapPort(protocol string, internalPort, externalPort int, lifetime uint32) ([]byte, error) {
+	var op byte
+	switch strings.ToLower(protocol) {
+	case "tcp":
+		op = natpmpOpMapTCP
+	case "udp":
+		op = natpmpOpMapUDP
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", protocol)
+	}
+
+	req := make([]byte, 12)
+	req[0] = natpmpVersion
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:8], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:12], lifetime)
+
+	return n.call(req, 16)
+}
+
+// call sends req and waits for a matching response, resending with
+// exponential backoff as RFC 6886 recommends
+func (n *NATPMP) call(req []byte, respLen int) ([]byte, error) {
+	conn, err := net.Dial("udp", n.Gateway)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach gateway: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(n.Timeout)
+	wait := natpmpInitialResend
+	buf := make([]byte, 16)
+
+	for time.Now().Before(deadline) {
+		if _, err := conn.Write(req); err != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+
+		readUntil := time.Now().Add(wait)
+		if readUntil.After(deadline) {
+			readUntil = deadline
+		}
+		conn.SetReadDeadline(readUntil)
+
+		for {
+			size, err := conn.Read(buf)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break // Resend
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read response: %w", err)
+			}
+			if size < respLen || buf[0] != natpmpVersion || buf[1] != req[1]|natpmpResponseFlag {
+				continue
+			}
+
+			if code := binary.BigEndian.Uint16(buf[2:4]); code != 0 {
+				if reason, ok := natpmpResultCodes[code]; ok {
+					return nil, fmt.Errorf("gateway refused request: %s", reason)
+				}
+				return nil, fmt.Errorf("gateway refused request: result code %d", code)
+			}
+			return buf[:size], nil
+		}
+
+		wait *= 2
+	}
+
+	return nil, fmt.Errorf("no NAT-PMP response from %s", n.Gateway)
+}
@@ -0,0 +1,248 @@
+package nat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ssdpAddr = "239.255.255.250:1900"
+
+// wanServiceTypes are the IGD services that can create port mappings
+var wanServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// UPnP controls port mappings on a UPnP Internet Gateway Device
+type UPnP struct {
+	ControlURL  string
+	ServiceType string
+	LocalIP     net.IP // Our address as seen by the router
+	Client      *http.Client
+}
+
+// DiscoverUPnP finds a gateway with SSDP and reads its device description
+func DiscoverUPnP(timeout time.Duration) (*UPnP, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SSDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), dst); err != nil {
+		return nil, fmt.Errorf("failed to send SSDP search: %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	for {
+		size, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, fmt.Errorf("no UPnP gateway answered: %w", err)
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:size])), nil)
+		if err != nil {
+			continue
+		}
+		location := resp.Header.Get("Location")
+		if location == "" {
+			continue
+		}
+
+		if igd, err := NewUPnP(location, timeout); err == nil {
+			return igd, nil
+		}
+	}
+}
+
+// NewUPnP reads the device description at location and picks the WAN
+// connection service to control
+func NewUPnP(location string, timeout time.Duration) (*UPnP, error) {
+	client := &http.Client{Timeout: timeout}
+
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch device description: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var desc struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return nil, fmt.Errorf("invalid device description: %w", err)
+	}
+
+	service := desc.Device.findService()
+	if service == nil {
+		return nil, errors.New("gateway has no WAN connection service")
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if desc.URLBase != "" {
+		if b, err := url.Parse(desc.URLBase); err == nil {
+			base = b
+		}
+	}
+	control, err := base.Parse(service.ControlURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control URL: %w", err)
+	}
+
+	localIP, err := localIPFor(base.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine local address: %w", err)
+	}
+
+	return &UPnP{
+		ControlURL:  control.String(),
+		ServiceType: service.ServiceType,
+		LocalIP:     localIP,
+		Client:      client,
+	}, nil
+}
+
+// upnpDevice is the part of an IGD description we care about
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// findService searches the device tree for a WAN connection service
+func (d *upnpDevice) findService() *upnpService {
+	for i := range d.Services {
+		for _, st := range wanServiceTypes {
+			if d.Services[i].ServiceType == st {
+				return &d.Services[i]
+			}
+		}
+	}
+	for i := range d.Devices {
+		if s := d.Devices[i].findService(); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// Name returns "upnp"
+func (u *UPnP) Name() string {
+	return "upnp"
+}
+
+// ExternalIP asks the gateway for its public address
+func (u *UPnP) ExternalIP() (net.IP, error) {
+	body, err := u.soap("GetExternalIPAddress", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("invalid GetExternalIPAddress response: %w", err)
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(result.IP))
+	if ip == nil {
+		return nil, fmt.Errorf("gateway returned invalid external IP %q", result.IP)
+	}
+	return ip, nil
+}
+
+// AddPortMapping forwards externalPort to internalPort on LocalIP. UPnP
+// grants exactly the requested port or fails.
+func (u *UPnP) AddPortMapping(protocol string, internalPort, externalPort int, description string, lifetime time.Duration) (int, error) {
+	args := "<NewRemoteHost></NewRemoteHost>" +
+		"<NewExternalPort>" + strconv.Itoa(externalPort) + "</NewExternalPort>" +
+		"<NewProtocol>" + strings.ToUpper(protocol) + "</NewProtocol>" +
+		"<NewInternalPort>" + strconv.Itoa(internalPort) + "</NewInternalPort>" +
+		"<NewInternalClient>" + u.LocalIP.String() + "</NewInternalClient>" +
+		"<NewEnabled>1</NewEnabled>" +
+		"<NewPortMappingDescription>" + xmlEscape(description) + "</NewPortMappingDescription>" +
+		"<NewLeaseDuration>" + strconv.Itoa(int(lifetime/time.Second)) + "</NewLeaseDuration>"
+
+	if _, err := u.soap("AddPortMapping", args); err != nil {
+		return 0, err
+	}
+	return externalPort, nil
+}
+
+// DeletePortMapping removes the mapping for externalPort
+func (u *UPnP) DeletePortMapping(protocol string, internalPort, externalPort int) error {
+	args := "<NewRemoteHost></NewRemoteHost>" +
+		"<NewExternalPort>" + strconv.Itoa(externalPort) + "</NewExternalPort>" +
+		"<NewProtocol>" + strings.ToUpper(protocol) + "</NewProtocol>"
+
+	_, err := u.soap("DeletePortMapping", args)
+	return err
+}
+
+// soap performs a SOAP action against the control URL
+func (u *UPnP) soap(action, args string) ([]byte, error) {
+	envelope := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + u.ServiceType + `">` + args + `</u:` + action + `></s:Body></s:Envelope>`
+
+	req, err := http.NewRequest(http.MethodPost, u.ControlURL, strings.NewReader(envelope))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+u.ServiceType+"#"+action+`"`)
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s failed: gateway returned %s", action, resp.Status)
+	}
+
+	return body, nil
+}
+
+// xmlEscape escapes s for use as element text
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -2,18 +2,66 @@ package network
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/addrmgr"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/nat"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/peer"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
 	syncmanager "github.com/pouria-shahmiri/learn-bitcoin/pkg/network/sync"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/v2transport"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/security"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+const (
+	// BanThreshold is the ban score at which a misbehaving peer is banned
+	BanThreshold = 100
+
+	// PingInterval is how often connected peers are pinged (and added
+	// nodes reconnected)
+	PingInterval = 2 * time.Minute
+
+	// txRequestCheckInterval is how often transaction requests are
+	// checked for timeouts
+	txRequestCheckInterval = time.Second
+
+	// DefaultHandshakeTimeout is how long a peer has to complete the
+	// version/verack exchange before it is disconnected
+	DefaultHandshakeTimeout = 60 * time.Second
+
+	// DefaultDNSSeedPort is assumed for addresses returned by DNS seeds
+	DefaultDNSSeedPort = 8333
+
+	// DefaultNATLease is how long router port mappings are requested for;
+	// they are renewed at half this interval
+	DefaultNATLease = 20 * time.Minute
+
+	// v2HandshakeTimeout bounds the encrypted transport key exchange; a
+	// plaintext-only peer normally hangs up on us right away
+	v2HandshakeTimeout = 10 * time.Second
+
+	// natDiscoverTimeout bounds the search for a NAT-PMP/UPnP gateway
+	natDiscoverTimeout = 3 * time.Second
+
+	// dialTimeout bounds outbound connection attempts
+	dialTimeout = 5 * time.Second
 )
 
 // Node represents a P2P node
@@ -22,101 +70,520 @@ type Node struct {
 	Blockchain  *storage.BlockchainStorage
 	Mempool     *mempool.Mempool
 	SyncManager *syncmanager.SyncManager
-
-	peers    map[string]*peer.Peer
-	peerLock sync.RWMutex
-
-	quit chan struct{}
-	wg   sync.WaitGroup
+	AddrManager *addrmgr.AddrManager
+	DNSSeeder   *DNSSeeder     // Nil when no DNS seeds are configured
+	PortMapper  nat.PortMapper // Discovered on Start if nil and NAT is enabled
+
+	peers      map[string]*peer.Peer
+	addedNodes map[string]bool // Manually added via addnode, guarded by peerLock
+	v1Only     map[string]bool // Addresses that failed the v2 handshake, guarded by peerLock
+	peerLock   sync.RWMutex
+	nextPeerID uint64 // atomic
+
+	bans    *security.DoSProtection
+	metrics *monitoring.Metrics
+	stem    *stemPool // Transactions on the Dandelion stem
+	rejects *mempool.RecentRejects
+	started time.Time
+
+	listeners    []net.Listener // Empty with NoListen
+	clock        clock.Clock
+	timeData     *clock.NetworkTime // clock adjusted by the offsets peers report
+	externalAddr string             // Public address from the port mapping
+	banListPath  string             // Where bans are persisted, "" keeps them in memory
+	subscribers  []validation.ChainListener
+	mu           sync.RWMutex // Guards clock, externalAddr, banListPath, subscribers and stopping
+
+	// ctx is cancelled by Stop; every goroutine the node starts watches it
+	// and is counted in wg, so Stop can wait for all of them
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopping bool
+	stopOnce sync.Once
+	wg       sync.WaitGroup
 }
 
 // NodeConfig holds configuration
 type NodeConfig struct {
-	ListenAddr string
-	SeedNodes  []string
-	UserAgent  string
+	ListenAddr       string
+	ListenAddrs      []string // Further addresses to accept peers on, e.g. an IPv6 or localhost-only one
+	NoListen         bool     // Only make outbound connections
+	ExternalAddr     string   // ip:port advertised in version messages, "" = work it out
+	SeedNodes        []string
+	UserAgent        string
+	HandshakeTimeout time.Duration // Zero means DefaultHandshakeTimeout
+
+	// DNSSeeds are queried on startup when the address manager is empty
+	DNSSeeds    []string
+	DNSSeedPort uint16 // Zero means DefaultDNSSeedPort
+
+	// EnableNAT maps the listen port on the router (NAT-PMP or UPnP) so
+	// peers can reach us from outside
+	EnableNAT        bool
+	NATLeaseDuration time.Duration // Zero means DefaultNATLease
+
+	// EnableV2Transport offers and accepts encrypted connections. Outbound
+	// connections try v2 first and fall back to plaintext for old peers.
+	EnableV2Transport bool
+
+	// InvTrickleInterval overrides the average delay between transaction
+	// announcements (zero keeps the per-direction peer defaults)
+	InvTrickleInterval time.Duration
+
+	// EnableDandelion sends our own transactions along a stem of single
+	// peers before they are announced to everyone, and extends the stems
+	// of peers doing the same
+	EnableDandelion      bool
+	StemFluffProbability float64       // Zero means DefaultStemFluffProbability
+	StemEmbargo          time.Duration // Zero means DefaultStemEmbargo
 }
 
 // NewNode creates a new node
 func NewNode(config NodeConfig, chain *storage.BlockchainStorage) *Node {
-	// Mempool config: 300MB max size, 1 sat/byte min fee, 14 days max age
-	mp := mempool.NewMempool(300*1024*1024, 1, 14*24*60*60)
-	return &Node{
+	// Mempool config: 300MB max size, 1 sat/vB min fee, 14 days max age
+	mp := mempool.NewMempool(300*1024*1024, 1000, 14*24*60*60)
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Node{
 		Config:      config,
 		Blockchain:  chain,
 		Mempool:     mp,
 		SyncManager: syncmanager.NewSyncManager(chain),
 		peers:       make(map[string]*peer.Peer),
-		quit:        make(chan struct{}),
+		addedNodes:  make(map[string]bool),
+		v1Only:      make(map[string]bool),
+		bans:        security.NewDoSProtection(),
+		metrics:     monitoring.NewMetrics(),
+		stem:        newStemPool(),
+		rejects:     mempool.NewRecentRejects(mempool.DefaultRecentRejectsSize),
+		clock:       clock.Real,
+		timeData:    clock.NewNetworkTime(clock.Real),
+		ctx:         ctx,
+		cancel:      cancel,
+		AddrManager: addrmgr.New(),
+	}
+
+	// Reorganizations give the transactions of disconnected blocks back
+	// to the mempool
+	n.SyncManager.Reorganizer().SetMempool(mp)
+	n.SyncManager.Reorganizer().SetMetrics(n.metrics)
+
+	if len(config.DNSSeeds) > 0 {
+		port := config.DNSSeedPort
+		if port == 0 {
+			port = DefaultDNSSeedPort
+		}
+		n.DNSSeeder = NewDNSSeeder(config.DNSSeeds, port)
 	}
+
+	return n
+}
+
+// SetClock replaces the time source for the node, its peers, mempool and
+// transaction requests
+func (n *Node) SetClock(c clock.Clock) {
+	n.mu.Lock()
+	n.clock = c
+	n.mu.Unlock()
+
+	n.timeData.SetBase(c)
+	n.Mempool.SetClock(c)
+	n.SyncManager.SetClock(c)
+}
+
+// AdjustedTime returns the node's clock corrected by the median offset of
+// its outbound peers' clocks. Block timestamps are checked against it,
+// and miners should timestamp templates with it.
+func (n *Node) AdjustedTime() *clock.NetworkTime {
+	return n.timeData
+}
+
+// getClock returns the node's current time source
+func (n *Node) getClock() clock.Clock {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.clock
 }
 
 // Start starts the node
 func (n *Node) Start() error {
+	return n.StartContext(context.Background())
+}
+
+// StartContext starts the node and stops it when ctx is cancelled
+func (n *Node) StartContext(ctx context.Context) error {
 	// Start listening
-	listener, err := net.Listen("tcp", n.Config.ListenAddr)
-	if err != nil {
-		return fmt.Errorf("failed to listen: %w", err)
+	if !n.Config.NoListen {
+		for _, addr := range n.listenAddresses() {
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				for _, l := range n.listeners {
+					l.Close()
+				}
+				n.listeners = nil
+				return fmt.Errorf("failed to listen on %s: %w", addr, err)
+			}
+			n.listeners = append(n.listeners, listener)
+		}
 	}
 
+	n.started = n.getClock().Now()
+	context.AfterFunc(ctx, n.Stop)
+
+	n.wg.Add(1 + len(n.listeners))
+	for _, listener := range n.listeners {
+		go n.acceptLoop(listener)
+	}
+	go n.maintenanceLoop()
 	n.wg.Add(1)
-	go n.acceptLoop(listener)
+	go n.txRequestLoop()
+
+	if n.Config.EnableDandelion {
+		n.wg.Add(1)
+		go n.stemLoop()
+	}
+
+	// Routers can only forward one port to us
+	if n.Config.EnableNAT && len(n.listeners) > 0 {
+		n.wg.Add(1)
+		go n.natLoop(n.listeners[0].Addr().(*net.TCPAddr).Port)
+	}
 
 	// Connect to seeds
 	for _, seed := range n.Config.SeedNodes {
-		go n.Connect(seed)
+		n.goConnect(seed)
+	}
+
+	// Fill an empty address table from DNS; lookups can be slow so don't
+	// hold up startup (or Stop) for them
+	if n.DNSSeeder != nil && n.AddrManager.NumAddresses() == 0 {
+		go n.seedAddresses()
 	}
 
-	fmt.Printf("Node started on %s\n", n.Config.ListenAddr)
+	if n.Config.NoListen {
+		fmt.Println("Node started without listening (outbound only)")
+	} else {
+		fmt.Printf("Node started on %s\n", strings.Join(n.ListenAddrs(), ", "))
+	}
 	return nil
 }
 
-// Stop stops the node
+// Stop stops the node and waits for its goroutines to exit. It is safe to
+// call more than once.
 func (n *Node) Stop() {
-	close(n.quit)
+	n.stopOnce.Do(n.stop)
+	n.wg.Wait()
+}
 
-	n.peerLock.Lock()
+// stop cancels the node's context and closes every connection
+func (n *Node) stop() {
+	n.mu.Lock()
+	n.stopping = true
+	n.mu.Unlock()
+	n.cancel()
+
+	// Unblock the accept loops
+	for _, listener := range n.listeners {
+		listener.Close()
+	}
+
+	n.peerLock.RLock()
 	for _, p := range n.peers {
 		p.Stop()
 	}
-	n.peerLock.Unlock()
+	n.peerLock.RUnlock()
 
 	n.wg.Wait()
+
+	// Drops bans that expired while we were running
+	n.saveBans()
+}
+
+// Context returns a context that is cancelled when the node stops
+func (n *Node) Context() context.Context {
+	return n.ctx
+}
+
+// spawn runs f in a goroutine that Stop waits for. It does nothing once
+// the node is stopping.
+func (n *Node) spawn(f func()) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopping {
+		return false
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+	return true
+}
+
+// goConnect connects to address in the background
+func (n *Node) goConnect(address string) {
+	n.spawn(func() { n.Connect(address) })
+}
+
+// Addr returns the address the node is listening on
+// (useful when ListenAddr uses port 0)
+func (n *Node) Addr() string {
+	if len(n.listeners) == 0 {
+		return n.Config.ListenAddr
+	}
+	return n.listeners[0].Addr().String()
+}
+
+// ListenAddrs returns every address the node is listening on
+func (n *Node) ListenAddrs() []string {
+	addrs := make([]string, len(n.listeners))
+	for i, listener := range n.listeners {
+		addrs[i] = listener.Addr().String()
+	}
+	return addrs
+}
+
+// listenAddresses returns the configured listen addresses without
+// duplicates, ListenAddr first
+func (n *Node) listenAddresses() []string {
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, addr := range append([]string{n.Config.ListenAddr}, n.Config.ListenAddrs...) {
+		if addr == "" || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// localAddress is the address we advertise to the peer on conn: the
+// configured external address, else the one mapped on the router, else
+// the interface the peer reached us on with our listen port. A node that
+// doesn't listen has nothing to advertise and sends a zero address.
+func (n *Node) localAddress(conn net.Conn) protocol.NetAddress {
+	services := n.LocalServices()
+	if n.Config.NoListen || len(n.listeners) == 0 {
+		return protocol.NetAddress{Services: services}
+	}
+
+	for _, external := range []string{n.Config.ExternalAddr, n.ExternalAddr()} {
+		if external == "" {
+			continue
+		}
+		if addr, err := protocol.ParseNetAddress(external, services); err == nil {
+			return addr
+		}
+	}
+
+	// Prefer the listener that shares the connection's interface
+	local, _ := conn.LocalAddr().(*net.TCPAddr)
+	listen := n.listeners[0].Addr().(*net.TCPAddr)
+	for _, l := range n.listeners {
+		bound := l.Addr().(*net.TCPAddr)
+		if local != nil && bound.IP.Equal(local.IP) {
+			listen = bound
+			break
+		}
+	}
+	ip := listen.IP
+	if ip.IsUnspecified() && local != nil {
+		ip = local.IP
+	}
+	return protocol.NewNetAddress(ip, uint16(listen.Port), services)
+}
+
+// remoteAddress is the peer's address as seen from our end of conn
+func remoteAddress(conn net.Conn) protocol.NetAddress {
+	remote, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return protocol.NetAddress{}
+	}
+	return protocol.NewNetAddress(remote.IP, uint16(remote.Port), protocol.SFNodeNetwork)
+}
+
+// Metrics returns the node's metrics collector
+func (n *Node) Metrics() *monitoring.Metrics {
+	return n.metrics
+}
+
+// NetTotals holds the node-wide traffic counters for getnettotals
+type NetTotals struct {
+	BytesRecv uint64
+	BytesSent uint64
+	Uptime    time.Duration
+}
+
+// GetNetTotals returns total bytes sent and received since the node started
+func (n *Node) GetNetTotals() NetTotals {
+	return NetTotals{
+		BytesRecv: n.metrics.GetBytesReceived(),
+		BytesSent: n.metrics.GetBytesSent(),
+		Uptime:    n.getClock().Now().Sub(n.started),
+	}
+}
+
+// PeerCount returns the number of connected peers
+func (n *Node) PeerCount() int {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+
+	return len(n.peers)
 }
 
 // Connect connects to a peer
 func (n *Node) Connect(address string) {
-	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if n.isBannedAddr(address) {
+		fmt.Printf("Not connecting to banned peer %s\n", address)
+		return
+	}
+
+	n.AddrManager.Attempt(address)
+
+	conn, err := n.dial(address)
 	if err != nil {
 		fmt.Printf("Failed to connect to %s: %v\n", address, err)
 		return
 	}
 
+	if n.Config.EnableV2Transport && !n.isV1Only(address) {
+		encrypted, err := n.initiateV2(conn)
+		if err == nil {
+			n.handlePeer(encrypted, false)
+			return
+		}
+
+		// Old peers drop the connection when they see our key instead of
+		// a message header; remember that and retry in plaintext
+		fmt.Printf("v2 handshake with %s failed, retrying in plaintext: %v\n", address, err)
+		conn.Close()
+		n.setV1Only(address, true)
+
+		conn, err = n.dial(address)
+		if err != nil {
+			fmt.Printf("Failed to connect to %s: %v\n", address, err)
+			return
+		}
+	}
+
 	n.handlePeer(conn, false)
 }
 
+// dial opens a TCP connection, giving up when the node stops
+func (n *Node) dial(address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	return dialer.DialContext(n.ctx, "tcp", address)
+}
+
+// handshakeDeadline bounds a handshake on conn by v2HandshakeTimeout and
+// cuts it short when the node stops. The returned func clears both.
+func (n *Node) handshakeDeadline(conn net.Conn) func() {
+	conn.SetDeadline(time.Now().Add(v2HandshakeTimeout))
+	stop := context.AfterFunc(n.ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	return func() {
+		stop()
+		conn.SetDeadline(time.Time{})
+	}
+}
+
+// initiateV2 runs the encrypted transport handshake on an outbound connection
+func (n *Node) initiateV2(conn net.Conn) (net.Conn, error) {
+	defer n.handshakeDeadline(conn)()
+
+	return v2transport.Initiate(conn, protocol.MagicMainnet)
+}
+
+// acceptV2 detects whether an inbound connection speaks v2 and, if so,
+// completes the handshake
+func (n *Node) acceptV2(conn net.Conn) (net.Conn, error) {
+	defer n.handshakeDeadline(conn)()
+
+	accepted, _, err := v2transport.Accept(conn, protocol.MagicMainnet)
+	return accepted, err
+}
+
+// isV1Only reports whether address is known not to support v2
+func (n *Node) isV1Only(address string) bool {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+
+	return n.v1Only[address]
+}
+
+// setV1Only records whether address only speaks plaintext
+func (n *Node) setV1Only(address string, v1Only bool) {
+	n.peerLock.Lock()
+	defer n.peerLock.Unlock()
+
+	if v1Only {
+		n.v1Only[address] = true
+	} else {
+		delete(n.v1Only, address)
+	}
+}
+
+// LocalServices returns the service bits we advertise
+func (n *Node) LocalServices() uint64 {
+	services := uint64(protocol.SFNodeNetwork)
+	if n.Config.EnableV2Transport {
+		services |= protocol.SFNodeP2PV2
+	}
+	if n.Config.EnableDandelion {
+		services |= protocol.SFNodeDandelion
+	}
+	return services
+}
+
 // acceptLoop accepts incoming connections
 func (n *Node) acceptLoop(listener net.Listener) {
 	defer n.wg.Done()
 	defer listener.Close()
 
 	for {
-		select {
-		case <-n.quit:
-			return
-		default:
-			conn, err := listener.Accept()
-			if err != nil {
-				continue
+		conn, err := listener.Accept()
+		if err != nil {
+			// Stop closes the listener
+			if n.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
 			}
-			go n.handlePeer(conn, true)
+			continue
+		}
+		if n.isBannedAddr(conn.RemoteAddr().String()) {
+			conn.Close()
+			continue
+		}
+		if !n.spawn(func() { n.handlePeer(conn, true) }) {
+			conn.Close()
 		}
 	}
 }
 
 // handlePeer handles a new peer connection
 func (n *Node) handlePeer(conn net.Conn, inbound bool) {
-	p := peer.NewPeer(conn, inbound)
+	if inbound && n.Config.EnableV2Transport {
+		accepted, err := n.acceptV2(conn)
+		if err != nil {
+			if n.ctx.Err() == nil {
+				fmt.Printf("Dropping %s: %v\n", conn.RemoteAddr(), err)
+			}
+			conn.Close()
+			return
+		}
+		conn = accepted
+	}
+
+	p := peer.NewPeerWithClock(conn, inbound, n.getClock())
+	p.ID = atomic.AddUint64(&n.nextPeerID, 1)
+	if n.Config.InvTrickleInterval > 0 {
+		p.SetTrickleInterval(n.Config.InvTrickleInterval)
+	}
+	p.SetMetrics(n.metrics)
+	n.metrics.IncrementPeerCount(inbound)
+	defer n.metrics.DecrementPeerCount(inbound)
 
 	n.peerLock.Lock()
 	n.peers[p.Address()] = p
@@ -125,19 +592,12 @@ func (n *Node) handlePeer(conn net.Conn, inbound bool) {
 	fmt.Printf("New peer connected: %s (inbound=%v)\n", p.Address(), inbound)
 
 	p.Start()
+	n.spawn(func() { n.enforceHandshakeTimeout(p) })
 
 	// Initiate handshake if outbound
 	if !inbound {
-		localAddr := protocol.NetAddress{
-			Services: protocol.SFNodeNetwork,
-			IP:       [16]byte{}, // TODO: Get local IP
-			Port:     0,          // TODO: Get local port
-		}
-		remoteAddr := protocol.NetAddress{
-			Services: protocol.SFNodeNetwork,
-			IP:       [16]byte{},
-			Port:     0,
-		}
+		localAddr := n.localAddress(p.Conn)
+		remoteAddr := remoteAddress(p.Conn)
 
 		// Get best height
 		height, _ := n.Blockchain.GetBestBlockHeight()
@@ -149,6 +609,7 @@ func (n *Node) handlePeer(conn net.Conn, inbound bool) {
 			n.Config.UserAgent,
 			int32(height),
 		)
+		version.Services = n.LocalServices()
 
 		p.Handshake(version)
 	}
@@ -161,6 +622,7 @@ func (n *Node) handlePeer(conn net.Conn, inbound bool) {
 	delete(n.peers, p.Address())
 	n.peerLock.Unlock()
 	p.Stop()
+	n.SyncManager.PeerDisconnected(p)
 	fmt.Printf("Peer disconnected: %s\n", p.Address())
 }
 
@@ -174,7 +636,7 @@ func (n *Node) handleMessages(p *peer.Peer) {
 			}
 		case <-p.Quit:
 			return
-		case <-n.quit:
+		case <-n.ctx.Done():
 			return
 		}
 	}
@@ -184,54 +646,137 @@ func (n *Node) handleMessages(p *peer.Peer) {
 func (n *Node) processMessage(p *peer.Peer, msg *protocol.Message) error {
 	// fmt.Printf("Received %s from %s\n", msg.Command, p.Address())
 
+	// Nothing but the handshake itself is accepted before it completes
+	if msg.Command != protocol.CmdVersion && msg.Command != protocol.CmdVerAck && !p.HandshakeComplete() {
+		return fmt.Errorf("ignoring %s received before handshake", msg.Command)
+	}
+
 	switch msg.Command {
 	case protocol.CmdVersion:
 		return n.handleVersion(p, msg.Payload)
 
 	case protocol.CmdVerAck:
-		p.VerAckReceived = true
-		// Start sync after handshake
-		return n.SyncManager.StartSync(p)
+		p.MarkVerAck()
+		if p.HandshakeComplete() {
+			return n.onHandshakeComplete(p)
+		}
+		return nil
+
+	case protocol.CmdPing:
+		// Echo the nonce back
+		p.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdPong, msg.Payload))
+		return nil
+
+	case protocol.CmdPong:
+		nonce, err := protocol.DeserializePing(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, 10, "malformed pong")
+			return err
+		}
+		p.HandlePong(nonce)
+		return nil
 
 	case protocol.CmdInv:
 		inv, err := protocol.DeserializeInv(msg.Payload)
 		if err != nil {
+			n.misbehaving(p, 20, "malformed inv")
 			return err
 		}
-		return n.SyncManager.HandleInv(inv, p)
+		n.recordInvSeen(inv)
+		return n.SyncManager.HandleInv(n.unknownInv(inv), p)
 
 	case protocol.CmdGetData:
 		gd, err := protocol.DeserializeGetData(msg.Payload)
 		if err != nil {
+			n.misbehaving(p, 20, "malformed getdata")
 			return err
 		}
 		return n.handleGetData(p, gd)
 
+	case protocol.CmdNotFound:
+		nf, err := protocol.DeserializeNotFound(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, 20, "malformed notfound")
+			return err
+		}
+		return n.handleNotFound(p, nf)
+
+	case protocol.CmdReject:
+		reject, err := protocol.DeserializeReject(msg.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize reject: %w", err)
+		}
+		// Rejects are purely informational, but useful when debugging
+		fmt.Printf("Peer %s rejected our %s\n", p.Address(), reject)
+		return nil
+
 	case protocol.CmdBlock:
 		// Deserialize block
 		block, err := serialization.DeserializeBlock(msg.Payload)
 		if err != nil {
+			n.misbehaving(p, 20, "malformed block")
 			return fmt.Errorf("failed to deserialize block: %w", err)
 		}
 
-		return n.SyncManager.HandleBlock(block, p)
+		hash, err := n.Blockchain.GetBlockHash(block)
+		if err != nil {
+			return err
+		}
+
+		connected, err := n.connectBlock(block, p)
+		// A missing parent may still arrive, so an orphan isn't refused
+		if err != nil && len(connected) == 0 && !errors.Is(err, validation.ErrOrphanBlock) {
+			n.sendReject(p, protocol.CmdBlock, blockRejectCode(err), err.Error(), hash)
+		}
+		return err
 
 	case protocol.CmdGetBlocks:
 		gb, err := protocol.DeserializeGetBlocks(msg.Payload)
 		if err != nil {
+			n.misbehaving(p, 20, "malformed getblocks")
 			return err
-
 		}
 		return n.handleGetBlocks(p, gb)
 
+	case protocol.CmdGetHeaders:
+		gh, err := protocol.DeserializeGetHeaders(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, 20, "malformed getheaders")
+			return err
+		}
+		return n.handleGetHeaders(p, gh)
+
+	case protocol.CmdHeaders:
+		headers, err := protocol.DeserializeHeaders(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, 20, "malformed headers")
+			return err
+		}
+
+		err = n.SyncManager.HandleHeaders(headers, p)
+		var misbehavior *syncmanager.MisbehaviorError
+		if errors.As(err, &misbehavior) {
+			n.misbehaving(p, misbehavior.Score, misbehavior.Reason)
+		}
+		return err
+
 	case protocol.CmdTx:
 		// Deserialize transaction
 		tx, err := serialization.DeserializeTransaction(bytes.NewReader(msg.Payload))
 		if err != nil {
+			n.misbehaving(p, 20, "malformed tx")
 			return fmt.Errorf("failed to deserialize transaction: %w", err)
 		}
 		return n.handleTx(p, tx)
 
+	case protocol.CmdDandelionTx:
+		tx, err := serialization.DeserializeTransaction(bytes.NewReader(msg.Payload))
+		if err != nil {
+			n.misbehaving(p, 20, "malformed dandeliontx")
+			return fmt.Errorf("failed to deserialize stem transaction: %w", err)
+		}
+		return n.handleStemTx(p, tx)
+
 	default:
 		// fmt.Printf("Unknown command: %s\n", msg.Command)
 	}
@@ -240,20 +785,50 @@ func (n *Node) processMessage(p *peer.Peer, msg *protocol.Message) error {
 }
 
 func (n *Node) handleVersion(p *peer.Peer, payload []byte) error {
+	if p.Version != nil {
+		n.misbehaving(p, 1, "duplicate version")
+		return nil
+	}
+
 	v, err := protocol.DeserializeVersion(payload)
 	if err != nil {
+		n.misbehaving(p, 20, "malformed version")
 		return err
 	}
 
-	p.Version = v
+	if v.Version < protocol.MinProtocolVersion {
+		n.sendReject(p, protocol.CmdVersion, protocol.RejectObsolete,
+			fmt.Sprintf("version %d is below the minimum %d", v.Version, protocol.MinProtocolVersion), types.Hash{})
+		p.Stop()
+		return fmt.Errorf("peer %s uses obsolete protocol version %d (minimum %d)", p.Address(), v.Version, protocol.MinProtocolVersion)
+	}
+
+	p.SetVersion(v)
+
+	// Only outbound peers, which we picked, get a say in our clock
+	if !p.Inbound {
+		host, _, err := net.SplitHostPort(p.Address())
+		if err != nil {
+			host = p.Address()
+		}
+		if warning := n.timeData.AddSample(host, p.TimeOffset()); warning != "" {
+			fmt.Printf("WARNING: %s\n", warning)
+		}
+	}
+
+	// A plaintext peer that now advertises v2 gets another chance next time
+	if !p.Inbound && p.Transport == "v1" && v.Services&protocol.SFNodeP2PV2 != 0 {
+		n.setV1Only(p.Address(), false)
+	}
 
 	// Send VerAck
 	p.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdVerAck, nil))
 
 	// If inbound, send our Version
 	if p.Inbound {
-		localAddr := protocol.NetAddress{}
-		remoteAddr := v.AddrFrom
+		localAddr := n.localAddress(p.Conn)
+		remoteAddr := remoteAddress(p.Conn)
+		remoteAddr.Services = v.Services
 		height, _ := n.Blockchain.GetBestBlockHeight()
 
 		myVersion := protocol.NewVersionMessage(
@@ -263,63 +838,223 @@ func (n *Node) handleVersion(p *peer.Peer, payload []byte) error {
 			n.Config.UserAgent,
 			int32(height),
 		)
+		myVersion.Services = n.LocalServices()
 		p.Handshake(myVersion)
 	}
 
+	if p.HandshakeComplete() {
+		return n.onHandshakeComplete(p)
+	}
 	return nil
 }
 
-func (n *Node) handleGetData(p *peer.Peer, gd *protocol.GetDataMessage) error {
-	for _, vect := range gd.Inventory {
-		if vect.Type == protocol.InvTypeBlock {
-			// Send block
-			block, err := n.Blockchain.GetBlock(vect.Hash)
-			if err != nil {
-				// Not found
-				continue
-			}
+// onHandshakeComplete runs once both version and verack have been exchanged
+func (n *Node) onHandshakeComplete(p *peer.Peer) error {
+	if !p.Inbound {
+		n.AddrManager.Good(p.Address())
+	}
+	return n.SyncManager.StartSync(p)
+}
 
-			// Serialize block
-			serialized, err := serialization.SerializeBlock(block)
-			if err != nil {
-				continue
-			}
+// ExternalAddr returns the public address obtained from the router, or ""
+// if no port mapping is active
+func (n *Node) ExternalAddr() string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
 
-			p.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdBlock, serialized))
-		}
-	}
-	return nil
+	return n.externalAddr
 }
 
-func (n *Node) handleGetBlocks(p *peer.Peer, gb *protocol.GetBlocksMessage) error {
-	// Find the latest block we have that is in their locator
-	var startHash types.Hash
-	for _, hash := range gb.BlockLocator {
-		exists, _ := n.Blockchain.HasBlock(hash)
-		if exists {
-			startHash = hash
-			break
+// natLoop maps the listen port on the router, renews the lease until the
+// node stops and then removes the mapping
+func (n *Node) natLoop(port int) {
+	defer n.wg.Done()
+
+	mapper := n.PortMapper
+	if mapper == nil {
+		discovered, err := nat.Discover(natDiscoverTimeout)
+		if err != nil {
+			fmt.Printf("Port mapping disabled: %v\n", err)
+			return
 		}
+		mapper = discovered
 	}
 
-	if startHash.IsZero() {
-		// No common block found, start from genesis?
-		// Or maybe we don't have anything they have.
-		return nil
+	lease := n.Config.NATLeaseDuration
+	if lease == 0 {
+		lease = DefaultNATLease
 	}
 
-	// Send inv for blocks starting from startHash
-	// Limit to 500 blocks
-	inv := protocol.NewInvMessage()
+	external := 0
+	for {
+		granted, err := mapper.AddPortMapping("tcp", port, port, n.Config.UserAgent, lease)
+		if err != nil {
+			fmt.Printf("Failed to map port %d via %s: %v\n", port, mapper.Name(), err)
+		} else {
+			external = granted
+			n.setExternalAddr(mapper, external)
+		}
 
-	// Iterate forward from startHash
-	// We need a way to get next block hash.
-	// Our storage doesn't support "GetNextBlock".
-	// We only have "GetBlockByHeight".
+		select {
+		case <-n.ctx.Done():
+			if external != 0 {
+				if err := mapper.DeletePortMapping("tcp", port, external); err != nil {
+					fmt.Printf("Failed to remove port mapping: %v\n", err)
+				}
+				n.mu.Lock()
+				n.externalAddr = ""
+				n.mu.Unlock()
+			}
+			return
+		case <-n.getClock().After(lease / 2):
+		}
+	}
+}
 
-	height, err := n.Blockchain.GetBlockHeight(startHash)
+// setExternalAddr records the public address for a granted mapping
+func (n *Node) setExternalAddr(mapper nat.PortMapper, port int) {
+	ip, err := mapper.ExternalIP()
 	if err != nil {
-		return err
+		fmt.Printf("Failed to get external IP via %s: %v\n", mapper.Name(), err)
+		return
+	}
+
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+
+	n.mu.Lock()
+	changed := n.externalAddr != addr
+	n.externalAddr = addr
+	n.mu.Unlock()
+
+	if changed {
+		fmt.Printf("Mapped external address %s via %s\n", addr, mapper.Name())
+	}
+}
+
+// seedAddresses queries the DNS seeds into the address manager
+func (n *Node) seedAddresses() {
+	added, err := n.DNSSeeder.Seed(n.AddrManager)
+	if err != nil {
+		fmt.Printf("DNS seeding failed: %v\n", err)
+		return
+	}
+	fmt.Printf("DNS seeding added %d addresses\n", added)
+}
+
+// enforceHandshakeTimeout disconnects p if it doesn't finish the handshake in time
+func (n *Node) enforceHandshakeTimeout(p *peer.Peer) {
+	timeout := n.Config.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	select {
+	case <-n.getClock().After(timeout):
+		if !p.HandshakeComplete() {
+			fmt.Printf("Peer %s did not complete handshake within %v\n", p.Address(), timeout)
+			p.Stop()
+		}
+	case <-p.Quit:
+	case <-n.ctx.Done():
+	}
+}
+
+func (n *Node) handleGetData(p *peer.Peer, gd *protocol.GetDataMessage) error {
+	notFound := protocol.NewNotFoundMessage()
+
+	for _, vect := range gd.Inventory {
+		if vect.Type == protocol.InvTypeTx {
+			// Serve transactions from the mempool
+			entry, err := n.Mempool.Get(vect.Hash)
+			if err != nil {
+				notFound.AddInvVect(vect)
+				continue
+			}
+
+			serialized, err := serialization.SerializeTransactionWitness(entry.Tx)
+			if err != nil {
+				notFound.AddInvVect(vect)
+				continue
+			}
+
+			p.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdTx, serialized))
+		} else if vect.Type == protocol.InvTypeBlock {
+			// Send block
+			block, err := n.Blockchain.GetBlock(vect.Hash)
+			if err != nil {
+				notFound.AddInvVect(vect)
+				continue
+			}
+
+			// Serialize block
+			serialized, err := serialization.SerializeBlock(block)
+			if err != nil {
+				notFound.AddInvVect(vect)
+				continue
+			}
+
+			p.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdBlock, serialized))
+		} else {
+			notFound.AddInvVect(vect)
+		}
+	}
+
+	if len(notFound.Inventory) > 0 {
+		p.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdNotFound, mustSerialize(notFound)))
+	}
+	return nil
+}
+
+// handleNotFound re-requests missing blocks from other peers
+func (n *Node) handleNotFound(p *peer.Peer, nf *protocol.NotFoundMessage) error {
+	n.peerLock.RLock()
+	others := make([]syncmanager.MessageSender, 0, len(n.peers))
+	for _, other := range n.peers {
+		if other != p && other.HandshakeComplete() {
+			others = append(others, other)
+		}
+	}
+	n.peerLock.RUnlock()
+
+	return n.SyncManager.HandleNotFound(nf, p, others)
+}
+
+// sendReject tells a peer why we refused one of its messages
+func (n *Node) sendReject(p *peer.Peer, command string, code byte, reason string, hash types.Hash) {
+	reject := protocol.NewRejectMessage(command, code, reason, hash)
+	fmt.Printf("Rejecting %s from %s: %s\n", command, p.Address(), reason)
+	p.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdReject, mustSerialize(reject)))
+}
+
+func (n *Node) handleGetBlocks(p *peer.Peer, gb *protocol.GetBlocksMessage) error {
+	// Find the latest block we have that is in their locator
+	var startHash types.Hash
+	for _, hash := range gb.BlockLocator {
+		exists, _ := n.Blockchain.HasBlock(hash)
+		if exists {
+			startHash = hash
+			break
+		}
+	}
+
+	if startHash.IsZero() {
+		// No common block found, start from genesis?
+		// Or maybe we don't have anything they have.
+		return nil
+	}
+
+	// Send inv for blocks starting from startHash
+	// Limit to 500 blocks
+	inv := protocol.NewInvMessage()
+
+	// Iterate forward from startHash
+	// We need a way to get next block hash.
+	// Our storage doesn't support "GetNextBlock".
+	// We only have "GetBlockByHeight".
+
+	height, err := n.Blockchain.GetBlockHeight(startHash)
+	if err != nil {
+		return err
 	}
 
 	// Send up to 500 blocks
@@ -344,77 +1079,400 @@ func (n *Node) handleGetBlocks(p *peer.Peer, gb *protocol.GetBlocksMessage) erro
 	return nil
 }
 
+// handleGetHeaders answers with up to MaxHeadersResults headers following
+// the first locator hash we know
+func (n *Node) handleGetHeaders(p *peer.Peer, gh *protocol.GetHeadersMessage) error {
+	var startHash types.Hash
+	for _, hash := range gh.BlockLocator {
+		if exists, _ := n.Blockchain.HasBlock(hash); exists {
+			startHash = hash
+			break
+		}
+	}
+	if startHash.IsZero() {
+		return nil
+	}
+
+	height, err := n.Blockchain.GetBlockHeight(startHash)
+	if err != nil {
+		return err
+	}
+
+	headers := protocol.NewHeadersMessage()
+	for i := uint64(1); i <= protocol.MaxHeadersResults; i++ {
+		block, err := n.Blockchain.GetBlockByHeight(height + i)
+		if err != nil {
+			break // End of chain
+		}
+		headers.AddHeader(block.Header)
+
+		if hash, _ := n.Blockchain.GetBlockHash(block); hash == gh.HashStop {
+			break
+		}
+	}
+
+	p.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdHeaders, mustSerialize(headers)))
+	return nil
+}
+
 func (n *Node) handleTx(p *peer.Peer, tx *types.Transaction) error {
-	// Calculate fee
-	fee, err := n.calculateTxFee(tx)
+	txHash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		return err
+	}
+	n.SyncManager.TxReceived(txHash)
+
+	// Announcements can race, a duplicate is not an error
+	if n.Mempool.Exists(txHash) {
+		return nil
+	}
+	wtxid, err := serialization.HashTransactionWitness(tx)
 	if err != nil {
-		// Could not calculate fee (e.g. inputs not found)
+		return err
+	}
+	if n.rejects.Contains(wtxid) {
+		return nil
+	}
+
+	fee, err := n.checkTransactionInputs(tx)
+	if errors.Is(err, mempool.ErrMissingInputs) {
+		// Parent still unknown to us.
 		// In a real node we might request missing inputs
 		return nil
 	}
+	if err != nil {
+		n.rejectTx(p, protocol.CmdTx, txHash, wtxid, err)
+		return nil
+	}
 
 	// Get current height
 	height, _ := n.Blockchain.GetBestBlockHeight()
 
 	// Add to mempool
 	if err := n.Mempool.Add(tx, fee, height); err != nil {
-		// Already exists or invalid
+		n.rejectTx(p, protocol.CmdTx, txHash, wtxid, err)
 		return nil
 	}
 
 	fmt.Printf("Received new transaction from %s\n", p.Address())
 
+	// Someone fluffed it, so it is off our stem
+	n.stem.remove(txHash)
+
 	// Relay to other peers
 	n.RelayTransaction(tx, p.Address())
+	n.metrics.RecordRelayed(monitoring.PropagationTx, txHash.String(), n.getClock().Now())
 
 	return nil
 }
 
-// calculateTxFee calculates transaction fee by looking up inputs
-func (n *Node) calculateTxFee(tx *types.Transaction) (int64, error) {
-	inputValues := make([]int64, len(tx.Inputs))
+// recordInvSeen notes when announced blocks and transactions were first
+// seen, to measure how long they take to be validated and relayed
+func (n *Node) recordInvSeen(inv *protocol.InvMessage) {
+	now := n.getClock().Now()
+	for _, vect := range inv.Inventory {
+		switch vect.Type {
+		case protocol.InvTypeTx:
+			if !n.Mempool.Exists(vect.Hash) {
+				n.metrics.RecordInvSeen(monitoring.PropagationTx, vect.Hash.String(), now)
+			}
+		case protocol.InvTypeBlock:
+			if exists, err := n.Blockchain.HasBlock(vect.Hash); err == nil && !exists {
+				n.metrics.RecordInvSeen(monitoring.PropagationBlock, vect.Hash.String(), now)
+			}
+		}
+	}
+}
 
-	for i, input := range tx.Inputs {
-		// Find previous transaction
-		prevTxLoc, _, err := n.Blockchain.GetTransactionLocation(input.PrevTxHash)
-		if err != nil {
-			return 0, fmt.Errorf("prev tx not found: %w", err)
+// rejectTx tells the peer why its transaction was refused and remembers
+// the refusal, so the transaction isn't requested or checked again
+func (n *Node) rejectTx(p *peer.Peer, command string, txHash, wtxid types.Hash, err error) {
+	n.rejects.Add(wtxid, err)
+	n.sendReject(p, command, txRejectCode(err), err.Error(), txHash)
+}
+
+// unknownInv drops transactions already in the mempool or recently
+// rejected from an announcement. Announcements carry txids, which only
+// match the recent-rejects keys for transactions without witness data.
+func (n *Node) unknownInv(inv *protocol.InvMessage) *protocol.InvMessage {
+	filtered := protocol.NewInvMessage()
+	for _, vect := range inv.Inventory {
+		if vect.Type == protocol.InvTypeTx && (n.Mempool.Exists(vect.Hash) || n.rejects.Contains(vect.Hash)) {
+			continue
 		}
+		filtered.AddInvVect(vect)
+	}
+	return filtered
+}
 
-		prevBlock, err := n.Blockchain.GetBlock(prevTxLoc)
+// RecentRejects returns the filter of transactions refused from peers
+func (n *Node) RecentRejects() *mempool.RecentRejects {
+	return n.rejects
+}
+
+// txRejectCode picks the BIP61 reject code for a mempool rejection
+func txRejectCode(err error) byte {
+	switch {
+	case errors.Is(err, mempool.ErrLowFee), errors.Is(err, mempool.ErrMempoolFull):
+		return protocol.RejectInsufficientFee
+	case errors.Is(err, mempool.ErrNonStandard), errors.Is(err, mempool.ErrTooLarge):
+		return protocol.RejectNonstandard
+	case errors.Is(err, mempool.ErrAlreadyInMempool), errors.Is(err, mempool.ErrConflict):
+		return protocol.RejectDuplicate
+	default:
+		return protocol.RejectInvalid
+	}
+}
+
+// SetChainstate keeps the UTXO set blocks and transactions are checked
+// against in cache. Its storage gets a filter of the stored coins, so
+// checkTransactionInputs skips the disk for inputs that were never
+// created, which is what junk transactions mostly spend.
+func (n *Node) SetChainstate(cache *utxo.UTXOCache) error {
+	if store := cache.Storage(); store != nil {
+		count, err := store.Count()
 		if err != nil {
-			return 0, fmt.Errorf("prev block not found: %w", err)
+			return err
 		}
-
-		// Find transaction in block
-		var prevTx *types.Transaction
-		for _, t := range prevBlock.Transactions {
-			hash, _ := serialization.HashTransaction(&t)
-			if hash == input.PrevTxHash {
-				prevTx = &t
-				break
-			}
+		if err := store.EnableFilter(count); err != nil {
+			return fmt.Errorf("failed to build UTXO filter: %w", err)
 		}
+	}
+	n.SyncManager.SetChainstate(cache)
+	return nil
+}
+
+// blockRejectCode picks the BIP61 reject code for a block validation failure
+func blockRejectCode(err error) byte {
+	switch validation.RejectReason(err) {
+	case validation.RejectDuplicate, validation.RejectDuplicateInvalid:
+		return protocol.RejectDuplicate
+	default:
+		return protocol.RejectInvalid
+	}
+}
 
-		if prevTx == nil {
-			return 0, fmt.Errorf("prev tx not found in block")
+// checkTransactionInputs validates tx's inputs and scripts against the
+// UTXO set as of the best block, in a view that adds the outputs of its
+// mempool parents, so the set itself is never touched. It applies the
+// relay policy to the outputs it spends and returns its fee. Scripts run
+// last, once the fee is known to be enough.
+func (n *Node) checkTransactionInputs(tx *types.Transaction) (int64, error) {
+	set, err := n.SyncManager.Chainstate()
+	if err != nil {
+		return 0, err
+	}
+	height, _ := n.Blockchain.GetBestBlockHeight()
+	view := n.mempoolView(set, tx, height+1)
+
+	prevOuts := make([]types.TxOutput, len(tx.Inputs))
+	inputValues := make([]int64, len(tx.Inputs))
+	for i, input := range tx.Inputs {
+		coin, err := view.Get(utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex))
+		if err != nil {
+			return 0, fmt.Errorf("%w: input %d: %v", mempool.ErrMissingInputs, i, err)
 		}
+		prevOuts[i] = coin.Output
+		inputValues[i] = coin.Value()
+	}
+
+	fee, err := mempool.CalculateTransactionFee(tx, inputValues)
+	if err != nil {
+		return 0, err
+	}
+	if err := n.Mempool.CheckFeeRate(tx, fee); err != nil {
+		return 0, err
+	}
+	if err := mempool.CheckInputStandardness(tx, prevOuts); err != nil {
+		return 0, err
+	}
 
-		if int(input.OutputIndex) >= len(prevTx.Outputs) {
-			return 0, fmt.Errorf("output index out of range")
+	validator := validation.NewBlockValidator(view)
+	validator.SetRules(n.SyncManager.Rules())
+	validator.SetBlockchain(n.Blockchain)
+	if _, err := validator.AcceptTransaction(tx, height+1); err != nil {
+		if validation.RejectReason(err) == validation.RejectScriptFailed {
+			return 0, fmt.Errorf("%w: %v", mempool.ErrScriptFailed, err)
 		}
+		return 0, err
+	}
+	if err := mempool.CheckWitnessScripts(tx, prevOuts); err != nil {
+		return 0, err
+	}
+	return fee, nil
+}
 
-		inputValues[i] = prevTx.Outputs[input.OutputIndex].Value
+// mempoolView returns a view of set with the outputs tx spends from
+// transactions in the mempool added, as if those were mined at height.
+// Whether another mempool transaction already spends them is the
+// mempool's to check.
+func (n *Node) mempoolView(set utxo.View, tx *types.Transaction, height uint64) *utxo.UTXOView {
+	view := utxo.NewUTXOView(set)
+	for _, input := range tx.Inputs {
+		parent, err := n.Mempool.Get(input.PrevTxHash)
+		if err != nil || int(input.OutputIndex) >= len(parent.Tx.Outputs) {
+			continue
+		}
+		coin := utxo.NewUTXO(parent.TxHash, input.OutputIndex, parent.Tx.Outputs[input.OutputIndex], height, false)
+		if !view.Exists(coin.OutPoint()) {
+			view.Add(coin)
+		}
 	}
+	return view
+}
+
+// EnableCoinAgePriority makes the mempool track the coin-age priority of
+// transactions added from now on, finding their inputs in the chain
+func (n *Node) EnableCoinAgePriority() {
+	n.Mempool.SetCoinLookup(n.lookupCoin)
+}
 
-	return mempool.CalculateTransactionFee(tx, inputValues)
+// lookupCoin finds a confirmed output's value and the height of its block
+func (n *Node) lookupCoin(outpoint types.OutPoint) (int64, uint64, bool) {
+	blockHash, txIndex, err := n.Blockchain.GetTransactionLocation(outpoint.Hash)
+	if err != nil {
+		return 0, 0, false
+	}
+	block, err := n.Blockchain.GetBlock(blockHash)
+	if err != nil || int(txIndex) >= len(block.Transactions) {
+		return 0, 0, false
+	}
+	outputs := block.Transactions[txIndex].Outputs
+	if int(outpoint.Index) >= len(outputs) {
+		return 0, 0, false
+	}
+	height, err := n.Blockchain.GetBlockHeight(blockHash)
+	if err != nil {
+		return 0, 0, false
+	}
+	return outputs[outpoint.Index].Value, height, true
 }
 
-// RelayTransaction broadcasts a transaction to all peers except the source
+// RelayTransaction queues a transaction announcement for every peer except
+// the source. Announcements go out with each peer's next inventory trickle.
 func (n *Node) RelayTransaction(tx *types.Transaction, sourceAddr string) {
 	txHash, _ := serialization.HashTransaction(tx)
+	vect := protocol.NewInvVect(protocol.InvTypeTx, txHash)
+
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+
+	for _, p := range n.peers {
+		if p.Address() != sourceAddr && p.HandshakeComplete() {
+			p.QueueInventory(vect)
+		}
+	}
+}
+
+// Subscribe registers l for every block that joins or leaves the best
+// chain, such as a wallet following the chain
+func (n *Node) Subscribe(l validation.ChainListener) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.subscribers = append(n.subscribers, l)
+}
+
+// chainListeners returns a snapshot of the subscribers
+func (n *Node) chainListeners() []validation.ChainListener {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return append([]validation.ChainListener(nil), n.subscribers...)
+}
+
+// connectBlock hands a block to the sync manager, then passes on every
+// block a reorganization took off the best chain, tip first, and every
+// block that joined it. Joining blocks have their transactions dropped
+// from the mempool and their confirmations recorded for fee estimation;
+// the sync manager's reorganizer has already given the mempool back the
+// transactions of the disconnected ones.
+func (n *Node) connectBlock(block *types.Block, source syncmanager.MessageSender) ([]*types.Block, error) {
+	if err := validation.CheckHeaderTime(&block.Header, n.timeData.Now()); err != nil {
+		return nil, err
+	}
+
+	connected, disconnected, err := n.SyncManager.HandleBlock(block, source)
+
+	// A new tip can make room in the mempool or confirm what a rejected
+	// transaction conflicted with
+	if len(connected) > 0 {
+		n.rejects.ResetPolicy()
+	}
+
+	listeners := n.chainListeners()
+	for _, b := range disconnected {
+		height, heightErr := n.blockHeight(b)
+		if heightErr != nil {
+			continue
+		}
+		for _, l := range listeners {
+			l.BlockDisconnected(b, height)
+		}
+	}
+	for _, b := range connected {
+		n.Mempool.RemoveConfirmed(b.Transactions)
+		n.stem.removeConfirmed(b.Transactions)
+		h, hashErr := n.Blockchain.GetBlockHash(b)
+		if hashErr != nil {
+			continue
+		}
+		height, heightErr := n.Blockchain.GetBlockHeight(h)
+		if heightErr != nil {
+			continue
+		}
+		for _, l := range listeners {
+			l.BlockConnected(b, height)
+		}
+		n.recordConfirmations(b, height)
+		n.announceBlock(h, source.Address())
+		n.metrics.RecordRelayed(monitoring.PropagationBlock, h.String(), n.getClock().Now())
+	}
+	return connected, err
+}
+
+// blockHeight returns the height a stored block has, or had, on the best
+// chain
+func (n *Node) blockHeight(block *types.Block) (uint64, error) {
+	hash, err := n.Blockchain.GetBlockHash(block)
+	if err != nil {
+		return 0, err
+	}
+	return n.Blockchain.GetBlockHeight(hash)
+}
+
+// recordConfirmations tells the mempool's fee history which of the
+// transactions it tracks a block connected at height confirmed
+func (n *Node) recordConfirmations(block *types.Block, height uint64) {
+	fees := n.Mempool.FeeHistory()
+	if fees == nil {
+		return
+	}
+
+	txHashes := make([]types.Hash, 0, len(block.Transactions))
+	for i := range block.Transactions {
+		if txHash, err := serialization.HashTransaction(&block.Transactions[i]); err == nil {
+			txHashes = append(txHashes, txHash)
+		}
+	}
+	fees.ProcessBlock(height, txHashes)
+}
+
+// BroadcastBlock announces a block we created to all peers
+func (n *Node) BroadcastBlock(block *types.Block) error {
+	hash, err := n.Blockchain.GetBlockHash(block)
+	if err != nil {
+		return err
+	}
+
+	n.announceBlock(hash, "")
+	return nil
+}
+
+// announceBlock sends a block inv to all peers except the source
+func (n *Node) announceBlock(hash types.Hash, sourceAddr string) {
 	inv := protocol.NewInvMessage()
-	inv.AddInvVect(protocol.NewInvVect(protocol.InvTypeTx, txHash))
+	inv.AddInvVect(protocol.NewInvVect(protocol.InvTypeBlock, hash))
 
 	msg := protocol.NewMessage(protocol.MagicMainnet, protocol.CmdInv, mustSerialize(inv))
 
@@ -422,12 +1480,253 @@ func (n *Node) RelayTransaction(tx *types.Transaction, sourceAddr string) {
 	defer n.peerLock.RUnlock()
 
 	for _, p := range n.peers {
-		if p.Address() != sourceAddr {
+		if p.Address() != sourceAddr && p.HandshakeComplete() {
 			p.SendMessage(msg)
 		}
 	}
 }
 
+// maintenanceLoop periodically pings peers and reconnects added nodes
+func (n *Node) maintenanceLoop() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.getClock().After(PingInterval):
+			n.pingPeers()
+			n.connectAddedNodes()
+		}
+	}
+}
+
+// txRequestLoop moves transaction requests that timed out on to the next
+// peer that announced them
+func (n *Node) txRequestLoop() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.getClock().After(txRequestCheckInterval):
+			n.SyncManager.ExpireTxRequests()
+		}
+	}
+}
+
+// pingPeers sends a ping to every connected peer to measure latency
+func (n *Node) pingPeers() {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+
+	for _, p := range n.peers {
+		if p.HandshakeComplete() {
+			p.SendPing(rand.Uint64())
+		}
+	}
+}
+
+// connectAddedNodes dials added nodes we are not currently connected to
+func (n *Node) connectAddedNodes() {
+	n.peerLock.RLock()
+	var missing []string
+	for addr := range n.addedNodes {
+		if _, connected := n.peers[addr]; !connected {
+			missing = append(missing, addr)
+		}
+	}
+	n.peerLock.RUnlock()
+
+	for _, addr := range missing {
+		n.goConnect(addr)
+	}
+}
+
+// misbehaving raises a peer's ban score and bans its IP once the score
+// reaches BanThreshold
+func (n *Node) misbehaving(p *peer.Peer, points int, reason string) {
+	score := p.AddBanScore(points)
+	fmt.Printf("Peer %s misbehaving (%s), ban score %d\n", p.Address(), reason, score)
+
+	if score >= BanThreshold {
+		n.bans.BanIP(hostOf(p.Address()))
+		n.saveBans()
+		p.Stop()
+	}
+}
+
+// PeerInfo returns a snapshot of every connected peer, ordered by ID
+func (n *Node) PeerInfo() []peer.Stats {
+	n.peerLock.RLock()
+	stats := make([]peer.Stats, 0, len(n.peers))
+	for _, p := range n.peers {
+		stats = append(stats, p.Stats())
+	}
+	n.peerLock.RUnlock()
+
+	// Sort by ID (bubble sort is fine for a handful of peers)
+	for i := 0; i < len(stats); i++ {
+		for j := i + 1; j < len(stats); j++ {
+			if stats[j].ID < stats[i].ID {
+				stats[i], stats[j] = stats[j], stats[i]
+			}
+		}
+	}
+
+	return stats
+}
+
+// AddNode manages the manually added peer list. command is "add" (remember
+// the node and keep it connected), "remove" or "onetry" (connect once).
+func (n *Node) AddNode(address string, command string) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("invalid node address %q: %w", address, err)
+	}
+
+	switch command {
+	case "add":
+		n.peerLock.Lock()
+		if n.addedNodes[address] {
+			n.peerLock.Unlock()
+			return fmt.Errorf("node already added: %s", address)
+		}
+		n.addedNodes[address] = true
+		n.peerLock.Unlock()
+
+		n.goConnect(address)
+
+	case "remove":
+		n.peerLock.Lock()
+		defer n.peerLock.Unlock()
+
+		if !n.addedNodes[address] {
+			return fmt.Errorf("node has not been added: %s", address)
+		}
+		delete(n.addedNodes, address)
+
+	case "onetry":
+		n.goConnect(address)
+
+	default:
+		return fmt.Errorf("unknown addnode command %q (want add, remove or onetry)", command)
+	}
+
+	return nil
+}
+
+// DisconnectNode drops the connection to the peer with the given address
+func (n *Node) DisconnectNode(address string) error {
+	n.peerLock.RLock()
+	p, exists := n.peers[address]
+	n.peerLock.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("node not found in connected nodes: %s", address)
+	}
+
+	p.Stop()
+	return nil
+}
+
+// SetBan adds or removes an IP ban. Adding a ban disconnects every peer
+// from that IP.
+func (n *Node) SetBan(ip string, command string, duration time.Duration) error {
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	switch command {
+	case "add":
+		if n.bans.IsBanned(ip) {
+			return fmt.Errorf("IP already banned: %s", ip)
+		}
+		if duration <= 0 {
+			n.bans.BanIP(ip)
+		} else {
+			n.bans.BanIPFor(ip, duration)
+		}
+
+		n.peerLock.RLock()
+		var victims []*peer.Peer
+		for addr, p := range n.peers {
+			if hostOf(addr) == ip {
+				victims = append(victims, p)
+			}
+		}
+		n.peerLock.RUnlock()
+
+		for _, p := range victims {
+			p.Stop()
+		}
+
+	case "remove":
+		if !n.bans.IsBanned(ip) {
+			return fmt.Errorf("IP is not banned: %s", ip)
+		}
+		n.bans.UnbanIP(ip)
+
+	default:
+		return fmt.Errorf("unknown setban command %q (want add or remove)", command)
+	}
+
+	n.saveBans()
+	return nil
+}
+
+// ListBanned returns the active bans
+func (n *Node) ListBanned() []security.BanEntry {
+	return n.bans.ListBans()
+}
+
+// ClearBanned lifts every ban
+func (n *Node) ClearBanned() {
+	n.bans.ClearBans()
+	n.saveBans()
+}
+
+// LoadBanList restores bans from path and keeps it up to date from now on
+func (n *Node) LoadBanList(path string) error {
+	if err := n.bans.LoadBans(path); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.banListPath = path
+	n.mu.Unlock()
+
+	return nil
+}
+
+// saveBans persists the ban list if LoadBanList was called
+func (n *Node) saveBans() {
+	n.mu.RLock()
+	path := n.banListPath
+	n.mu.RUnlock()
+
+	if path == "" {
+		return
+	}
+	if err := n.bans.SaveBans(path); err != nil {
+		fmt.Printf("Failed to save ban list: %v\n", err)
+	}
+}
+
+// isBannedAddr reports whether the host part of address is banned
+func (n *Node) isBannedAddr(address string) bool {
+	return n.bans.IsBanned(hostOf(address))
+}
+
+// hostOf strips the port from a host:port address
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
 func mustSerialize(msg interface{ Serialize() ([]byte, error) }) []byte {
 	b, err := msg.Serialize()
 	if err != nil {
@@ -2,17 +2,38 @@ package peer
 
 import (
 	"bufio"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/v2transport"
+)
+
+const (
+	// MaxSendQueue is the number of outgoing messages buffered per peer
+	MaxSendQueue = 100
+
+	// DefaultSendTimeout is how long SendMessage waits for room in a full
+	// queue before the peer is considered too slow and disconnected
+	DefaultSendTimeout = 10 * time.Second
+
+	// Average delay between inventory trickles (as in Bitcoin Core, we
+	// announce to inbound peers less often to slow down spy nodes)
+	InboundTrickleInterval  = 5 * time.Second
+	OutboundTrickleInterval = 2 * time.Second
 )
 
 // Peer represents a connected node
 type Peer struct {
+	ID             uint64 // Assigned by the node, unique per process
 	Conn           net.Conn
 	addr           string
 	Inbound        bool // True if peer connected to us, false if we connected to them
@@ -26,42 +47,281 @@ type Peer struct {
 	Receive chan *protocol.Message
 	Quit    chan struct{}
 
-	wg sync.WaitGroup
+	// SendTimeout overrides DefaultSendTimeout; set before Start
+	SendTimeout time.Duration
+
+	// Transport is "v2" for encrypted connections, "v1" otherwise
+	Transport string
+	SessionID string // Hex session ID of a v2 connection
+
+	// Traffic statistics
+	bytesSent uint64 // atomic
+	bytesRecv uint64 // atomic
+
+	mu              sync.RWMutex
+	protocolVersion int32 // Negotiated: min(ours, theirs)
+	lastSend        time.Time
+	lastRecv        time.Time
+	pingNonce       uint64
+	pingSent        time.Time
+	pingTime        time.Duration
+	banScore        int
+	timeOffset      time.Duration // Peer's clock minus ours, from its version
+
+	// Per-command traffic and the node-wide collector it also feeds
+	sentPerMsg map[string]uint64
+	recvPerMsg map[string]uint64
+	metrics    *monitoring.Metrics
+
+	// Transaction announcements waiting for the next trickle
+	invQueue        []*protocol.InvVect
+	trickleInterval time.Duration
+
+	clock    clock.Clock
+	quitOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Stats is a point-in-time snapshot of a peer for getpeerinfo
+type Stats struct {
+	ID          uint64
+	Address     string
+	Inbound     bool
+	Version     int32
+	Negotiated  int32
+	Services    uint64
+	UserAgent   string
+	StartHeight int32
+	ConnectedAt time.Time
+	LastSend    time.Time
+	LastRecv    time.Time
+	BytesSent   uint64
+	BytesRecv   uint64
+	PingTime    time.Duration // Zero until the first pong arrives
+	BanScore    int
+	TimeOffset  time.Duration // Peer's clock minus ours
+	Transport   string
+	SessionID   string
+
+	// Bytes per message command
+	BytesSentPerMsg map[string]uint64
+	BytesRecvPerMsg map[string]uint64
 }
 
 // NewPeer creates a new peer instance
 func NewPeer(conn net.Conn, inbound bool) *Peer {
-	return &Peer{
-		Conn:        conn,
-		addr:        conn.RemoteAddr().String(),
-		Inbound:     inbound,
-		ConnectedAt: time.Now(),
-		LastActive:  time.Now(),
-		Send:        make(chan *protocol.Message, 100),
-		Receive:     make(chan *protocol.Message, 100),
-		Quit:        make(chan struct{}),
+	return NewPeerWithClock(conn, inbound, clock.Real)
+}
+
+// NewPeerWithClock creates a peer that reads time from the given clock
+func NewPeerWithClock(conn net.Conn, inbound bool, clk clock.Clock) *Peer {
+	now := clk.Now()
+	trickle := OutboundTrickleInterval
+	if inbound {
+		trickle = InboundTrickleInterval
+	}
+
+	p := &Peer{
+		Conn:            conn,
+		addr:            conn.RemoteAddr().String(),
+		Inbound:         inbound,
+		ConnectedAt:     now,
+		LastActive:      now,
+		Send:            make(chan *protocol.Message, MaxSendQueue),
+		Receive:         make(chan *protocol.Message, 100),
+		Quit:            make(chan struct{}),
+		SendTimeout:     DefaultSendTimeout,
+		sentPerMsg:      make(map[string]uint64),
+		recvPerMsg:      make(map[string]uint64),
+		trickleInterval: trickle,
+		clock:           clk,
+	}
+
+	p.Transport = "v1"
+	if encrypted, ok := conn.(*v2transport.Conn); ok {
+		id := encrypted.SessionID()
+		p.Transport = "v2"
+		p.SessionID = hex.EncodeToString(id[:])
+	}
+
+	return p
+}
+
+// SetMetrics makes the peer report its traffic to m as well; set before Start
+func (p *Peer) SetMetrics(m *monitoring.Metrics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.metrics = m
+}
+
+// recordReceived accounts for an incoming message
+func (p *Peer) recordReceived(command string, size int) {
+	now := p.clock.Now()
+	atomic.AddUint64(&p.bytesRecv, uint64(size))
+
+	p.mu.Lock()
+	p.LastActive = now
+	p.lastRecv = now
+	p.recvPerMsg[command] += uint64(size)
+	metrics := p.metrics
+	p.mu.Unlock()
+
+	if metrics != nil {
+		metrics.RecordReceived(command, uint64(size))
 	}
 }
 
-// Start begins the read/write loops
+// recordSent accounts for an outgoing message
+func (p *Peer) recordSent(command string, size int) {
+	atomic.AddUint64(&p.bytesSent, uint64(size))
+
+	p.mu.Lock()
+	p.lastSend = p.clock.Now()
+	p.sentPerMsg[command] += uint64(size)
+	metrics := p.metrics
+	p.mu.Unlock()
+
+	if metrics != nil {
+		metrics.RecordSent(command, uint64(size))
+	}
+}
+
+// SetTrickleInterval changes the average delay between inventory trickles
+func (p *Peer) SetTrickleInterval(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.trickleInterval = d
+}
+
+// Start begins the read/write/trickle loops
 func (p *Peer) Start() {
-	p.wg.Add(2)
+	p.wg.Add(3)
 	go p.readLoop()
 	go p.writeLoop()
+	go p.trickleLoop()
 }
 
-// Stop terminates the connection
+// Stop terminates the connection. It is safe to call more than once.
 func (p *Peer) Stop() {
-	close(p.Quit)
-	p.Conn.Close()
+	p.disconnect()
 	p.wg.Wait()
 }
 
-// SendMessage queues a message to be sent
+// stopped reports whether the peer has been told to quit
+func (p *Peer) stopped() bool {
+	select {
+	case <-p.Quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// disconnect closes the quit channel and the connection without waiting
+// for the read/write loops, so it can be called from inside them
+func (p *Peer) disconnect() {
+	p.quitOnce.Do(func() {
+		close(p.Quit)
+		p.Conn.Close()
+	})
+}
+
+// SendMessage queues a message to be sent. If the queue stays full for
+// longer than SendTimeout the peer isn't keeping up and is disconnected.
 func (p *Peer) SendMessage(msg *protocol.Message) {
 	select {
 	case p.Send <- msg:
+		return
 	case <-p.Quit:
+		return
+	default:
+	}
+
+	// Queue is full, give the writer a chance to catch up
+	timer := time.NewTimer(p.SendTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.Send <- msg:
+	case <-p.Quit:
+	case <-timer.C:
+		fmt.Printf("Send queue to %s full for %v, disconnecting slow peer\n", p.addr, p.SendTimeout)
+		p.disconnect()
+	}
+}
+
+// QueueInventory adds an announcement to be sent with the next trickle
+func (p *Peer) QueueInventory(vect *protocol.InvVect) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, queued := range p.invQueue {
+		if queued.Hash == vect.Hash && queued.Type == vect.Type {
+			return
+		}
+	}
+	p.invQueue = append(p.invQueue, vect)
+}
+
+// PendingInventory returns the number of announcements waiting to trickle
+func (p *Peer) PendingInventory() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.invQueue)
+}
+
+// trickleLoop flushes queued inventory after randomized delays so that
+// announcements are batched and their timing reveals less about their origin
+func (p *Peer) trickleLoop() {
+	defer p.wg.Done()
+
+	for {
+		p.mu.RLock()
+		mean := p.trickleInterval
+		p.mu.RUnlock()
+
+		// Exponentially distributed delay, like a Poisson process
+		delay := time.Duration(rand.ExpFloat64() * float64(mean))
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+			p.flushInventory()
+		case <-p.Quit:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// flushInventory sends all queued announcements in as few inv messages as possible
+func (p *Peer) flushInventory() {
+	p.mu.Lock()
+	queue := p.invQueue
+	p.invQueue = nil
+	p.mu.Unlock()
+
+	for len(queue) > 0 {
+		n := len(queue)
+		if n > protocol.MaxInvSize {
+			n = protocol.MaxInvSize
+		}
+
+		inv := protocol.NewInvMessage()
+		for _, vect := range queue[:n] {
+			inv.AddInvVect(vect)
+		}
+		queue = queue[n:]
+
+		payload, err := inv.Serialize()
+		if err != nil {
+			fmt.Printf("Error serializing inventory: %v\n", err)
+			return
+		}
+		p.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdInv, payload))
 	}
 }
 
@@ -82,15 +342,16 @@ func (p *Peer) readLoop() {
 			// Read message
 			msg, err := protocol.Deserialize(reader)
 			if err != nil {
-				if err != io.EOF {
+				if err != io.EOF && !p.stopped() {
 					fmt.Printf("Error reading from peer %s: %v\n", p.addr, err)
 				}
-				// Close connection on error
-				// In a real app we'd signal the manager to remove this peer
+				// Close connection on error, which also signals the node
+				// (via Quit) to remove this peer
+				p.disconnect()
 				return
 			}
 
-			p.LastActive = time.Now()
+			p.recordReceived(msg.Command, protocol.HeaderSize+len(msg.Payload))
 
 			// Send to receive channel
 			select {
@@ -120,9 +381,12 @@ func (p *Peer) writeLoop() {
 
 			if _, err := p.Conn.Write(serialized); err != nil {
 				fmt.Printf("Error writing to peer %s: %v\n", p.addr, err)
+				p.disconnect()
 				return
 			}
 
+			p.recordSent(msg.Command, len(serialized))
+
 		case <-p.Quit:
 			return
 		}
@@ -150,6 +414,148 @@ func mustSerialize(v *protocol.VersionMessage) []byte {
 	return b
 }
 
+// IdleFor returns how long ago the peer last sent us a message
+func (p *Peer) IdleFor() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.clock.Now().Sub(p.LastActive)
+}
+
+// SetVersion records the version message the peer sent us and
+// negotiates the protocol version used on this connection
+func (p *Peer) SetVersion(v *protocol.VersionMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Version = v
+	p.timeOffset = time.Unix(v.Timestamp, 0).Sub(p.clock.Now()).Round(time.Second)
+	p.protocolVersion = v.Version
+	if p.protocolVersion > protocol.ProtocolVersion {
+		p.protocolVersion = protocol.ProtocolVersion
+	}
+}
+
+// TimeOffset returns how far the peer's clock is ahead of ours, as
+// reported in its version message
+func (p *Peer) TimeOffset() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.timeOffset
+}
+
+// ProtocolVersion returns the negotiated protocol version (0 before the
+// peer's version message arrives)
+func (p *Peer) ProtocolVersion() int32 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.protocolVersion
+}
+
+// MarkVerAck records that the peer acknowledged our version
+func (p *Peer) MarkVerAck() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.VerAckReceived = true
+}
+
+// Services returns the service bits the peer advertised (0 before its
+// version message arrives)
+func (p *Peer) Services() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.Version == nil {
+		return 0
+	}
+	return p.Version.Services
+}
+
+// HandshakeComplete reports whether both version and verack have been received
+func (p *Peer) HandshakeComplete() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.Version != nil && p.VerAckReceived
+}
+
+// SendPing sends a ping with the given nonce and remembers when it left
+func (p *Peer) SendPing(nonce uint64) {
+	p.mu.Lock()
+	p.pingNonce = nonce
+	p.pingSent = p.clock.Now()
+	p.mu.Unlock()
+
+	p.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdPing, protocol.SerializePing(nonce)))
+}
+
+// HandlePong records the round-trip time if nonce answers our last ping
+func (p *Peer) HandlePong(nonce uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pingSent.IsZero() || nonce != p.pingNonce {
+		return false
+	}
+
+	p.pingTime = p.clock.Now().Sub(p.pingSent)
+	p.pingSent = time.Time{}
+	return true
+}
+
+// AddBanScore increases the peer's misbehaviour score and returns the new total
+func (p *Peer) AddBanScore(points int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.banScore += points
+	return p.banScore
+}
+
+// Stats returns a snapshot of the peer's connection details
+func (p *Peer) Stats() Stats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	stats := Stats{
+		ID:          p.ID,
+		Address:     p.addr,
+		Inbound:     p.Inbound,
+		ConnectedAt: p.ConnectedAt,
+		LastSend:    p.lastSend,
+		LastRecv:    p.lastRecv,
+		BytesSent:   atomic.LoadUint64(&p.bytesSent),
+		BytesRecv:   atomic.LoadUint64(&p.bytesRecv),
+		PingTime:    p.pingTime,
+		BanScore:    p.banScore,
+		TimeOffset:  p.timeOffset,
+		Transport:   p.Transport,
+		SessionID:   p.SessionID,
+
+		BytesSentPerMsg: make(map[string]uint64, len(p.sentPerMsg)),
+		BytesRecvPerMsg: make(map[string]uint64, len(p.recvPerMsg)),
+	}
+	for command, bytes := range p.sentPerMsg {
+		stats.BytesSentPerMsg[command] = bytes
+	}
+	for command, bytes := range p.recvPerMsg {
+		stats.BytesRecvPerMsg[command] = bytes
+	}
+
+	if v := p.Version; v != nil {
+		stats.Version = v.Version
+		stats.Negotiated = p.protocolVersion
+		stats.Services = v.Services
+		stats.UserAgent = v.UserAgent
+		stats.StartHeight = v.StartHeight
+	}
+
+	return stats
+}
+
 // Address returns the peer's address
 func (p *Peer) Address() string {
 	return p.addr
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// MaxHeadersResults is the maximum number of headers in one headers message
+const MaxHeadersResults = 2000
+
+// HeadersMessage carries block headers in answer to getheaders
+type HeadersMessage struct {
+	Headers []types.BlockHeader
+}
+
+// NewHeadersMessage creates a new headers message
+func NewHeadersMessage() *HeadersMessage {
+	return &HeadersMessage{
+		Headers: make([]types.BlockHeader, 0),
+	}
+}
+
+// AddHeader adds a header to the message
+func (hm *HeadersMessage) AddHeader(header types.BlockHeader) {
+	hm.Headers = append(hm.Headers, header)
+}
+
+// Serialize converts headers message to bytes
+func (hm *HeadersMessage) Serialize() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	if err := writeVarInt(buf, uint64(len(hm.Headers))); err != nil {
+		return nil, err
+	}
+
+	for i := range hm.Headers {
+		header, err := serialization.SerializeBlockHeader(&hm.Headers[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(header)
+
+		// Each header is followed by a transaction count, always zero
+		if err := buf.WriteByte(0); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DeserializeHeaders reads a headers message from bytes
+func DeserializeHeaders(data []byte) (*HeadersMessage, error) {
+	buf := bytes.NewReader(data)
+
+	count, err := readVarInt(buf)
+	if err != nil {
+		return nil, err
+	}
+	if count > MaxHeadersResults {
+		return nil, fmt.Errorf("too many headers: %d (max %d)", count, MaxHeadersResults)
+	}
+
+	hm := &HeadersMessage{Headers: make([]types.BlockHeader, 0, count)}
+	for i := uint64(0); i < count; i++ {
+		header, err := serialization.DeserializeBlockHeader(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		txCount, err := readVarInt(buf)
+		if err != nil {
+			return nil, err
+		}
+		if txCount != 0 {
+			return nil, fmt.Errorf("header %d has non-zero transaction count %d", i, txCount)
+		}
+
+		hm.Headers = append(hm.Headers, *header)
+	}
+
+	return hm, nil
+}
+
+func (hm *HeadersMessage) String() string {
+	return fmt.Sprintf("Headers{Count: %d}", len(hm.Headers))
+}
@@ -17,6 +17,14 @@ const (
 	InvTypeCompactBlock  = 4 // Compact block
 )
 
+const (
+	// MaxInvSize is the maximum number of entries in an inv or getdata message
+	MaxInvSize = 50000
+
+	// MaxLocatorSize is the maximum number of hashes in a block locator
+	MaxLocatorSize = 101
+)
+
 // InvVect represents an inventory vector
 type InvVect struct {
 	Type uint32     // Inventory type
@@ -81,6 +89,9 @@ func DeserializeInv(data []byte) (*InvMessage, error) {
 	if err != nil {
 		return nil, err
 	}
+	if count > MaxInvSize {
+		return nil, fmt.Errorf("too many inventory vectors: %d (max %d)", count, MaxInvSize)
+	}
 
 	// Read inventory vectors
 	for i := uint64(0); i < count; i++ {
@@ -133,6 +144,39 @@ func DeserializeGetData(data []byte) (*GetDataMessage, error) {
 	return &GetDataMessage{Inventory: inv.Inventory}, nil
 }
 
+// NotFoundMessage answers a getdata for objects we don't have
+type NotFoundMessage struct {
+	Inventory []*InvVect
+}
+
+// NewNotFoundMessage creates a new notfound message
+func NewNotFoundMessage() *NotFoundMessage {
+	return &NotFoundMessage{
+		Inventory: make([]*InvVect, 0),
+	}
+}
+
+// AddInvVect adds an inventory vector
+func (nf *NotFoundMessage) AddInvVect(vect *InvVect) {
+	nf.Inventory = append(nf.Inventory, vect)
+}
+
+// Serialize converts notfound message to bytes
+func (nf *NotFoundMessage) Serialize() ([]byte, error) {
+	// Same format as inv
+	inv := &InvMessage{Inventory: nf.Inventory}
+	return inv.Serialize()
+}
+
+// DeserializeNotFound reads a notfound message from bytes
+func DeserializeNotFound(data []byte) (*NotFoundMessage, error) {
+	inv, err := DeserializeInv(data)
+	if err != nil {
+		return nil, err
+	}
+	return &NotFoundMessage{Inventory: inv.Inventory}, nil
+}
+
 // GetBlocksMessage requests block hashes
 type GetBlocksMessage struct {
 	Version      uint32
@@ -194,6 +238,9 @@ func DeserializeGetBlocks(data []byte) (*GetBlocksMessage, error) {
 	if err != nil {
 		return nil, err
 	}
+	if count > MaxLocatorSize {
+		return nil, fmt.Errorf("block locator too long: %d (max %d)", count, MaxLocatorSize)
+	}
 
 	// Read locator hashes
 	gb.BlockLocator = make([]types.Hash, count)
@@ -261,6 +308,10 @@ func (gd *GetDataMessage) String() string {
 	return fmt.Sprintf("GetData{Count: %d}", len(gd.Inventory))
 }
 
+func (nf *NotFoundMessage) String() string {
+	return fmt.Sprintf("NotFound{Count: %d}", len(nf.Inventory))
+}
+
 func (gb *GetBlocksMessage) String() string {
 	return fmt.Sprintf("GetBlocks{Locator: %d hashes}", len(gb.BlockLocator))
 }
@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// MerkleBlockMessage is a block header with a partial merkle tree proving
+// some of its transactions are in the block (BIP37). gettxoutproof returns
+// one serialized.
+type MerkleBlockMessage struct {
+	Header types.BlockHeader
+	Tree   *crypto.PartialMerkleTree
+}
+
+// NewMerkleBlockMessage builds a proof that the transactions in txids are
+// in block. txHashes are the hashes of every transaction in the block.
+func NewMerkleBlockMessage(header types.BlockHeader, txHashes []types.Hash, txids map[types.Hash]bool) *MerkleBlockMessage {
+	matches := make([]bool, len(txHashes))
+	for i, hash := range txHashes {
+		matches[i] = txids[hash]
+	}
+	return &MerkleBlockMessage{
+		Header: header,
+		Tree:   crypto.NewPartialMerkleTree(txHashes, matches),
+	}
+}
+
+// Serialize converts the merkle block to bytes
+func (mb *MerkleBlockMessage) Serialize() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	header, err := serialization.SerializeBlockHeader(&mb.Header)
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(header)
+
+	if err := binary.Write(buf, binary.LittleEndian, mb.Tree.Transactions); err != nil {
+		return nil, err
+	}
+
+	if err := writeVarInt(buf, uint64(len(mb.Tree.Hashes))); err != nil {
+		return nil, err
+	}
+	for _, hash := range mb.Tree.Hashes {
+		buf.Write(hash[:])
+	}
+
+	// Flags are packed least significant bit first
+	flags := make([]byte, (len(mb.Tree.Flags)+7)/8)
+	for i, set := range mb.Tree.Flags {
+		if set {
+			flags[i/8] |= 1 << (i % 8)
+		}
+	}
+	if err := writeVarInt(buf, uint64(len(flags))); err != nil {
+		return nil, err
+	}
+	buf.Write(flags)
+
+	return buf.Bytes(), nil
+}
+
+// DeserializeMerkleBlock reads a merkle block from bytes
+func DeserializeMerkleBlock(data []byte) (*MerkleBlockMessage, error) {
+	buf := bytes.NewReader(data)
+
+	header, err := serialization.DeserializeBlockHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	mb := &MerkleBlockMessage{Header: *header, Tree: &crypto.PartialMerkleTree{}}
+
+	if err := binary.Read(buf, binary.LittleEndian, &mb.Tree.Transactions); err != nil {
+		return nil, fmt.Errorf("failed to read transaction count: %w", err)
+	}
+
+	count, err := readVarInt(buf)
+	if err != nil {
+		return nil, err
+	}
+	// Each hash takes 32 bytes, so a count beyond what's left is a lie
+	if count > uint64(buf.Len())/32 {
+		return nil, fmt.Errorf("merkle block claims %d hashes in %d bytes", count, buf.Len())
+	}
+	mb.Tree.Hashes = make([]types.Hash, count)
+	for i := range mb.Tree.Hashes {
+		if _, err := io.ReadFull(buf, mb.Tree.Hashes[i][:]); err != nil {
+			return nil, fmt.Errorf("failed to read hash %d: %w", i, err)
+		}
+	}
+
+	flagBytes, err := readVarInt(buf)
+	if err != nil {
+		return nil, err
+	}
+	if flagBytes > uint64(buf.Len()) {
+		return nil, fmt.Errorf("merkle block claims %d flag bytes in %d bytes", flagBytes, buf.Len())
+	}
+	flags := make([]byte, flagBytes)
+	if _, err := io.ReadFull(buf, flags); err != nil {
+		return nil, fmt.Errorf("failed to read flags: %w", err)
+	}
+	mb.Tree.Flags = make([]bool, len(flags)*8)
+	for i := range mb.Tree.Flags {
+		mb.Tree.Flags[i] = flags[i/8]&(1<<(i%8)) != 0
+	}
+
+	if buf.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after merkle block", buf.Len())
+	}
+	return mb, nil
+}
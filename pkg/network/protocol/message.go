@@ -18,32 +18,40 @@ const (
 	MagicTestnet uint32 = 0x0709110B
 	// Magic bytes for regtest (our testing network)
 	MagicRegtest uint32 = 0xDAB5BFFA
+	// Magic bytes for the default signet, the start of the double
+	// SHA256 of its challenge
+	MagicSignet uint32 = 0x40CF030A
 
 	// Maximum payload size (32MB)
 	MaxPayloadSize = 32 * 1024 * 1024
 
 	// Command length
 	CommandLength = 12
+
+	// HeaderSize is the size of the message header on the wire
+	// (magic + command + payload length + checksum)
+	HeaderSize = 4 + CommandLength + 4 + 4
 )
 
 // Message types
 const (
-	CmdVersion    = "version"
-	CmdVerAck     = "verack"
-	CmdPing       = "ping"
-	CmdPong       = "pong"
-	CmdGetAddr    = "getaddr"
-	CmdAddr       = "addr"
-	CmdInv        = "inv"
-	CmdGetData    = "getdata"
-	CmdNotFound   = "notfound"
-	CmdGetBlocks  = "getblocks"
-	CmdGetHeaders = "getheaders"
-	CmdTx         = "tx"
-	CmdBlock      = "block"
-	CmdHeaders    = "headers"
-	CmdMempool    = "mempool"
-	CmdReject     = "reject"
+	CmdVersion     = "version"
+	CmdVerAck      = "verack"
+	CmdPing        = "ping"
+	CmdPong        = "pong"
+	CmdGetAddr     = "getaddr"
+	CmdAddr        = "addr"
+	CmdInv         = "inv"
+	CmdGetData     = "getdata"
+	CmdNotFound    = "notfound"
+	CmdGetBlocks   = "getblocks"
+	CmdGetHeaders  = "getheaders"
+	CmdTx          = "tx"
+	CmdBlock       = "block"
+	CmdHeaders     = "headers"
+	CmdMempool     = "mempool"
+	CmdReject      = "reject"
+	CmdDandelionTx = "dandeliontx" // A transaction on the Dandelion stem, sent unannounced
 )
 
 // Message represents a Bitcoin protocol message
@@ -120,6 +128,13 @@ func Deserialize(r io.Reader) (*Message, error) {
 		return nil, fmt.Errorf("failed to read magic: %w", err)
 	}
 
+	// Bail out before trusting the length of a message from the wrong network
+	switch msg.Magic {
+	case MagicMainnet, MagicTestnet, MagicRegtest, MagicSignet:
+	default:
+		return nil, fmt.Errorf("unknown network magic %#x", msg.Magic)
+	}
+
 	// Read command
 	command := make([]byte, CommandLength)
 	if _, err := io.ReadFull(r, command); err != nil {
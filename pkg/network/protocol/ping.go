@@ -0,0 +1,21 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SerializePing encodes the nonce carried by ping and pong messages
+func SerializePing(nonce uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, nonce)
+	return buf
+}
+
+// DeserializePing decodes a ping or pong payload
+func DeserializePing(data []byte) (uint64, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("invalid ping payload length: %d", len(data))
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
@@ -0,0 +1,104 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Reject codes (BIP61)
+const (
+	RejectMalformed       byte = 0x01
+	RejectInvalid         byte = 0x10
+	RejectObsolete        byte = 0x11
+	RejectDuplicate       byte = 0x12
+	RejectNonstandard     byte = 0x40
+	RejectDust            byte = 0x41
+	RejectInsufficientFee byte = 0x42
+	RejectCheckpoint      byte = 0x43
+)
+
+// RejectMessage tells a peer why one of its messages was refused
+type RejectMessage struct {
+	Message string     // Command of the rejected message
+	Code    byte       // One of the Reject* codes
+	Reason  string     // Human readable explanation
+	Hash    types.Hash // Rejected tx or block (only for tx/block rejects)
+}
+
+// NewRejectMessage creates a new reject message
+func NewRejectMessage(command string, code byte, reason string, hash types.Hash) *RejectMessage {
+	return &RejectMessage{
+		Message: command,
+		Code:    code,
+		Reason:  reason,
+		Hash:    hash,
+	}
+}
+
+// hasHash reports whether the rejected command carries a hash
+func (rm *RejectMessage) hasHash() bool {
+	return rm.Message == CmdTx || rm.Message == CmdBlock
+}
+
+// Serialize converts reject message to bytes
+func (rm *RejectMessage) Serialize() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	if err := writeVarString(buf, rm.Message); err != nil {
+		return nil, err
+	}
+	if err := buf.WriteByte(rm.Code); err != nil {
+		return nil, err
+	}
+
+	// Keep reasons short enough for the receiver's string limit
+	reason := rm.Reason
+	if len(reason) > MaxUserAgentLength {
+		reason = reason[:MaxUserAgentLength]
+	}
+	if err := writeVarString(buf, reason); err != nil {
+		return nil, err
+	}
+
+	if rm.hasHash() {
+		if _, err := buf.Write(rm.Hash[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DeserializeReject reads a reject message from bytes
+func DeserializeReject(data []byte) (*RejectMessage, error) {
+	buf := bytes.NewReader(data)
+	rm := &RejectMessage{}
+
+	var err error
+	if rm.Message, err = readVarString(buf); err != nil {
+		return nil, err
+	}
+	if rm.Code, err = buf.ReadByte(); err != nil {
+		return nil, err
+	}
+	if rm.Reason, err = readVarString(buf); err != nil {
+		return nil, err
+	}
+
+	if rm.hasHash() {
+		if n, _ := buf.Read(rm.Hash[:]); n != len(rm.Hash) {
+			return nil, fmt.Errorf("reject for %s is missing the hash", rm.Message)
+		}
+	}
+
+	return rm, nil
+}
+
+func (rm *RejectMessage) String() string {
+	if rm.hasHash() {
+		return fmt.Sprintf("Reject{%s %s code=0x%02x: %s}", rm.Message, rm.Hash, rm.Code, rm.Reason)
+	}
+	return fmt.Sprintf("Reject{%s code=0x%02x: %s}", rm.Message, rm.Code, rm.Reason)
+}
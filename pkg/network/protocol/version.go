@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"net"
+	"strconv"
 	"time"
 )
 
@@ -11,6 +13,9 @@ import (
 const (
 	ProtocolVersion    = 70015 // Bitcoin Core 0.13.2+
 	MinProtocolVersion = 70001
+
+	// MaxUserAgentLength is the longest user agent accepted (BIP14)
+	MaxUserAgentLength = 256
 )
 
 // Service flags
@@ -20,6 +25,8 @@ const (
 	SFNodeBloom          = 1 << 2  // Supports bloom filtering
 	SFNodeWitness        = 1 << 3  // Supports segregated witness
 	SFNodeNetworkLimited = 1 << 10 // Pruned node with limited history
+	SFNodeP2PV2          = 1 << 11 // Accepts the encrypted v2 transport
+	SFNodeDandelion      = 1 << 24 // Relays dandeliontx stem transactions (experimental bit)
 )
 
 // NetAddress represents a network address
@@ -29,6 +36,44 @@ type NetAddress struct {
 	Port     uint16   // Port number
 }
 
+// NewNetAddress builds a NetAddress from an IP and port. IPv4 addresses
+// are stored IPv4-mapped.
+func NewNetAddress(ip net.IP, port uint16, services uint64) NetAddress {
+	addr := NetAddress{Services: services, Port: port}
+	if ip16 := ip.To16(); ip16 != nil {
+		copy(addr.IP[:], ip16)
+	}
+	return addr
+}
+
+// ParseNetAddress parses "ip:port" into a NetAddress. Host names are not
+// resolved.
+func ParseNetAddress(hostport string, services uint64) (NetAddress, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return NetAddress{}, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return NetAddress{}, fmt.Errorf("not an IP address: %s", host)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return NetAddress{}, fmt.Errorf("invalid port: %s", portStr)
+	}
+	return NewNetAddress(ip, uint16(port), services), nil
+}
+
+// IsZero reports whether the address is unset
+func (a NetAddress) IsZero() bool {
+	return a.IP == [16]byte{} && a.Port == 0
+}
+
+// String formats the address as ip:port
+func (a NetAddress) String() string {
+	return net.JoinHostPort(net.IP(a.IP[:]).String(), strconv.Itoa(int(a.Port)))
+}
+
 // VersionMessage is sent during handshake
 type VersionMessage struct {
 	Version     int32      // Protocol version
@@ -226,6 +271,9 @@ func readVarString(buf *bytes.Reader) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if length > MaxUserAgentLength {
+		return "", fmt.Errorf("string too long: %d bytes (max %d)", length, MaxUserAgentLength)
+	}
 
 	// Read string
 	str := make([]byte, length)
@@ -1,8 +1,10 @@
 package network
 
 import (
+	"context"
 	"sync"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
@@ -33,11 +35,26 @@ func (s *Server) Start() error {
 	return s.node.Start()
 }
 
+// StartContext starts the P2P server and stops it when ctx is cancelled
+func (s *Server) StartContext(ctx context.Context) error {
+	return s.node.StartContext(ctx)
+}
+
 // Stop stops the P2P server
 func (s *Server) Stop() {
 	s.node.Stop()
 }
 
+// Node returns the underlying P2P node
+func (s *Server) Node() *Node {
+	return s.node
+}
+
+// Mempool returns the node's transaction pool
+func (s *Server) Mempool() *mempool.Mempool {
+	return s.node.Mempool
+}
+
 // ConnectToPeer connects to a peer
 func (s *Server) ConnectToPeer(address string) error {
 	s.node.Connect(address)
@@ -46,21 +63,7 @@ func (s *Server) ConnectToPeer(address string) error {
 
 // BroadcastBlock broadcasts a block to all connected peers
 func (s *Server) BroadcastBlock(block *types.Block) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	// Get all connected peers
-	peers := s.node.peers
-	if len(peers) == 0 {
-		// No peers to broadcast to, not an error
-		return nil
-	}
-
-	// For now, we'll skip broadcasting since it requires proper protocol implementation
-	// In a full implementation, we would serialize the block and send it via protocol messages
-	// This is acceptable for Phase 11 as the focus is on Docker orchestration
-
-	return nil
+	return s.node.BroadcastBlock(block)
 }
 
 // GetPeerCount returns the number of connected peers
@@ -0,0 +1,52 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// localSource stands in for a peer when a block is submitted over RPC
+type localSource struct{}
+
+func (localSource) SendMessage(*protocol.Message) {}
+func (localSource) Address() string               { return "local" }
+
+// ProcessNewBlock checks a block submitted by a local miner or test and
+// connects it like one received from a peer. Rejections are
+// *validation.RejectError values carrying the BIP22 reason.
+func (n *Node) ProcessNewBlock(block *types.Block) error {
+	if err := validation.CheckHeaderContext(n.Blockchain, &block.Header); err != nil {
+		return err
+	}
+	if err := validation.CheckBlockSanity(block); err != nil {
+		return err
+	}
+
+	if _, err := n.connectBlock(block, localSource{}); err != nil {
+		var reject *validation.RejectError
+		if errors.As(err, &reject) {
+			return err
+		}
+		return &validation.RejectError{Reason: validation.RejectInvalid, Msg: err.Error()}
+	}
+	return nil
+}
+
+// ProcessNewHeader checks a submitted header and remembers it until its
+// block arrives
+func (n *Node) ProcessNewHeader(header *types.BlockHeader) error {
+	if err := validation.CheckHeaderContext(n.Blockchain, header); err != nil {
+		return err
+	}
+	if err := validation.CheckHeaderTime(header, n.timeData.Now()); err != nil {
+		return err
+	}
+	if err := n.SyncManager.AddHeader(header); err != nil {
+		return fmt.Errorf("failed to add header: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package sync
+
+import (
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// MaxHeadersOnly is the most headers remembered while their blocks are
+// still missing
+const MaxHeadersOnly = 2000
+
+// headerEntry is a header whose block we don't have
+type headerEntry struct {
+	prev   types.Hash
+	height uint64
+}
+
+// addHeadersOnly remembers the headers of blocks we don't have yet, the
+// first building on the stored block at parentHeight (internal, lock held)
+func (sm *SyncManager) addHeadersOnly(headers []types.BlockHeader, hashes []types.Hash, parentHeight uint64) {
+	for i, hash := range hashes {
+		if len(sm.headersOnly) >= MaxHeadersOnly {
+			return
+		}
+		if exists, err := sm.chain.HasBlock(hash); err != nil || exists {
+			continue
+		}
+		sm.headersOnly[hash] = headerEntry{prev: headers[i].PrevBlockHash, height: parentHeight + uint64(i) + 1}
+	}
+}
+
+// ChainTips returns the tips of every known chain: the stored ones from
+// the block index plus header chains whose blocks haven't been downloaded.
+// A stored side-chain tip that headers extend is no longer a tip.
+func (sm *SyncManager) ChainTips() ([]storage.ChainTip, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	stored, err := sm.chain.GetChainTips()
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[types.Hash]bool, len(sm.headersOnly))
+	for _, entry := range sm.headersOnly {
+		parents[entry.prev] = true
+	}
+
+	var tips []storage.ChainTip
+	for _, tip := range stored {
+		if tip.Status == storage.ChainTipActive || !parents[tip.Hash] {
+			tips = append(tips, tip)
+		}
+	}
+
+	for hash, entry := range sm.headersOnly {
+		if parents[hash] {
+			continue
+		}
+
+		// Walk back to the stored block the headers build on
+		missing := 0
+		ancestor := hash
+		for {
+			e, ok := sm.headersOnly[ancestor]
+			if !ok {
+				break
+			}
+			missing++
+			ancestor = e.prev
+		}
+		branch, _, err := sm.chain.GetBranch(ancestor)
+		if err != nil {
+			continue
+		}
+
+		tips = append(tips, storage.ChainTip{
+			Hash:      hash,
+			Height:    entry.height,
+			BranchLen: missing + len(branch),
+			Status:    storage.ChainTipHeadersOnly,
+		})
+	}
+	return tips, nil
+}
+
+// AddHeader remembers a header whose parent is stored, as if a peer had
+// announced it
+func (sm *SyncManager) AddHeader(header *types.BlockHeader) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	hash, err := serialization.HashBlockHeader(header)
+	if err != nil {
+		return err
+	}
+	parentHeight, err := sm.chain.GetBlockHeight(header.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	sm.addHeadersOnly([]types.BlockHeader{*header}, []types.Hash{hash}, parentHeight)
+	return nil
+}
@@ -0,0 +1,160 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+const (
+	// MaxUnconnectingHeaders is how many headers messages that don't
+	// connect to our chain a peer may send in a row before being penalized
+	MaxUnconnectingHeaders = 10
+
+	// Ban score penalties for bad headers
+	NonContinuousHeadersPenalty = 20
+	UnconnectingHeadersPenalty  = 20
+	DuplicateHeadersPenalty     = 5
+)
+
+// ErrLowWorkChain is returned for header chains below the minimum chain
+// work. They are ignored but the peer is not punished.
+var ErrLowWorkChain = errors.New("header chain has too little work")
+
+// MisbehaviorError reports a protocol violation that should raise the
+// sending peer's ban score
+type MisbehaviorError struct {
+	Score  int
+	Reason string
+}
+
+func (e *MisbehaviorError) Error() string {
+	return fmt.Sprintf("peer misbehaving: %s", e.Reason)
+}
+
+// HandleHeaders checks a headers message and requests the blocks of any
+// new, sufficiently worked chain it describes
+func (sm *SyncManager) HandleHeaders(msg *protocol.HeadersMessage, peer MessageSender) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if len(msg.Headers) == 0 {
+		return nil
+	}
+
+	// Headers must form a single chain
+	hashes := make([]types.Hash, len(msg.Headers))
+	for i := range msg.Headers {
+		hash, err := serialization.HashBlockHeader(&msg.Headers[i])
+		if err != nil {
+			return err
+		}
+		hashes[i] = hash
+
+		if i > 0 && msg.Headers[i].PrevBlockHash != hashes[i-1] {
+			return &MisbehaviorError{Score: NonContinuousHeadersPenalty, Reason: "non-continuous headers sequence"}
+		}
+	}
+
+	// The first header must build on a block we know
+	parent := msg.Headers[0].PrevBlockHash
+	hasParent, err := sm.chain.HasBlock(parent)
+	if err != nil {
+		return err
+	}
+	if !hasParent {
+		sm.unconnecting[peer.Address()]++
+		if sm.unconnecting[peer.Address()] > MaxUnconnectingHeaders {
+			delete(sm.unconnecting, peer.Address())
+			return &MisbehaviorError{Score: UnconnectingHeadersPenalty, Reason: "too many unconnecting headers"}
+		}
+
+		// Maybe we're just behind, ask for the headers in between
+		getHeaders := protocol.NewGetHeadersMessage(sm.getBlockLocator(), types.Hash{})
+		peer.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdGetHeaders, mustSerialize(getHeaders)))
+		return nil
+	}
+	delete(sm.unconnecting, peer.Address())
+
+	// Skip what we already have
+	var unknown []types.Hash
+	for _, hash := range hashes {
+		exists, err := sm.chain.HasBlock(hash)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			unknown = append(unknown, hash)
+		}
+	}
+	if len(unknown) == 0 {
+		return &MisbehaviorError{Score: DuplicateHeadersPenalty, Reason: "all headers already known"}
+	}
+
+	// Compare the work of the announced chain with our own
+	parentHeight, err := sm.chain.GetBlockHeight(parent)
+	if err != nil {
+		return err
+	}
+	work, err := sm.chainWork(parentHeight)
+	if err != nil {
+		return err
+	}
+	for i := range msg.Headers {
+		work.Add(work, consensus.CalcWork(msg.Headers[i].Bits))
+	}
+
+	if work.Cmp(sm.minChainWork) < 0 {
+		return fmt.Errorf("%w: %s < minimum %s", ErrLowWorkChain, work.Text(16), sm.minChainWork.Text(16))
+	}
+	sm.addHeadersOnly(msg.Headers, hashes, parentHeight)
+
+	tipHeight, err := sm.chain.GetBestBlockHeight()
+	if err != nil {
+		return err
+	}
+	tipWork, err := sm.chainWork(tipHeight)
+	if err != nil {
+		return err
+	}
+	if work.Cmp(tipWork) <= 0 {
+		// Valid but not better than what we have
+		return nil
+	}
+	if height := parentHeight + uint64(len(msg.Headers)); height > sm.bestHeaderHeight {
+		sm.bestHeaderHeight = height
+	}
+
+	// Download the new blocks
+	getData := protocol.NewGetDataMessage()
+	for _, hash := range unknown {
+		if _, requested := sm.requestedBlocks[hash]; requested {
+			continue
+		}
+		getData.AddInvVect(protocol.NewInvVect(protocol.InvTypeBlock, hash))
+		sm.requestedBlocks[hash] = peer.Address()
+	}
+	if len(getData.Inventory) > 0 {
+		peer.SendMessage(protocol.NewMessage(protocol.MagicMainnet, protocol.CmdGetData, mustSerialize(getData)))
+	}
+
+	return nil
+}
+
+// chainWork sums the work of our chain from genesis to height (internal, lock held)
+func (sm *SyncManager) chainWork(height uint64) (*big.Int, error) {
+	total := big.NewInt(0)
+	for h := uint64(0); h <= height; h++ {
+		block, err := sm.chain.GetBlockByHeight(h)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load block %d: %w", h, err)
+		}
+		total.Add(total, consensus.CalcWork(block.Header.Bits))
+	}
+	return total, nil
+}
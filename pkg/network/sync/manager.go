@@ -2,11 +2,18 @@ package sync
 
 import (
 	"fmt"
+	"math/big"
 	"sync"
+	"time"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
 )
 
 // MessageSender defines interface for sending messages
@@ -15,6 +22,16 @@ type MessageSender interface {
 	Address() string
 }
 
+// MaxOrphanBlocks is the most blocks kept while waiting for their parents
+const MaxOrphanBlocks = 100
+
+// orphanBlock is a block whose parent we don't have yet
+type orphanBlock struct {
+	block *types.Block
+	hash  types.Hash
+	from  string // Peer that sent it
+}
+
 // SyncManager handles block synchronization
 type SyncManager struct {
 	chain *storage.BlockchainStorage
@@ -22,6 +39,32 @@ type SyncManager struct {
 
 	// Keep track of requested blocks to avoid duplicate requests
 	requestedBlocks map[types.Hash]string // hash -> peer address
+
+	// Orphan pool
+	orphans       map[types.Hash]*orphanBlock
+	orphansByPrev map[types.Hash][]types.Hash
+	orphanOrder   []types.Hash // Insertion order, oldest first
+
+	// Headers anti-DoS state
+	minChainWork *big.Int
+	unconnecting map[string]int // peer address -> unconnecting headers messages
+
+	// Height of the best header chain peers have shown us
+	bestHeaderHeight uint64
+
+	// Headers whose blocks haven't arrived yet
+	headersOnly map[types.Hash]headerEntry
+
+	txRequests *TxRequestTracker
+	clock      clock.Clock
+
+	// Peer blocks are validated against rules and the UTXO set in
+	// chainstate, which is brought up to date when the best chain moves
+	rules      *consensus.ConsensusRules
+	chainstate *utxo.UTXOCache
+
+	// Switches the best chain to branches with more work
+	reorg *validation.Reorganizer
 }
 
 // NewSyncManager creates a new sync manager
@@ -29,7 +72,98 @@ func NewSyncManager(chain *storage.BlockchainStorage) *SyncManager {
 	return &SyncManager{
 		chain:           chain,
 		requestedBlocks: make(map[types.Hash]string),
+		orphans:         make(map[types.Hash]*orphanBlock),
+		orphansByPrev:   make(map[types.Hash][]types.Hash),
+		minChainWork:    big.NewInt(0),
+		unconnecting:    make(map[string]int),
+		headersOnly:     make(map[types.Hash]headerEntry),
+		txRequests:      NewTxRequestTracker(),
+		clock:           clock.Real,
+		rules:           consensus.NewMainnetRules(),
+		chainstate:      utxo.NewUTXOCacheWithConfig(nil, utxo.CacheConfig{}),
+		reorg:           validation.NewReorganizer(chain),
+	}
+}
+
+// SetClock replaces the time source for transaction request timeouts
+func (sm *SyncManager) SetClock(c clock.Clock) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.clock = c
+}
+
+// now returns the current time of the manager's clock
+func (sm *SyncManager) now() time.Time {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	return sm.clock.Now()
+}
+
+// SetRules sets the consensus rules peer blocks are validated against.
+// Nil means mainnet's.
+func (sm *SyncManager) SetRules(rules *consensus.ConsensusRules) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if rules == nil {
+		rules = consensus.NewMainnetRules()
+	}
+	sm.rules = rules
+	sm.reorg.SetRules(rules)
+	sm.chainstate.SetBestBlock(types.Hash{})
+}
+
+// Rules returns the consensus rules peer blocks are validated against
+func (sm *SyncManager) Rules() *consensus.ConsensusRules {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	return sm.rules
+}
+
+// Reorganizer returns the reorganizer that switches the best chain to
+// branches with more work, for setting its depth limit, mempool and
+// listeners
+func (sm *SyncManager) Reorganizer() *validation.Reorganizer {
+	return sm.reorg
+}
+
+// SetChainstate replaces the UTXO set peer blocks are validated against,
+// by default one only kept in memory. A cache over storage carries on
+// from the block it was last flushed at; flushing and closing it is up
+// to the caller.
+func (sm *SyncManager) SetChainstate(cache *utxo.UTXOCache) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.chainstate = cache
+}
+
+// SetMinimumChainWork sets the least work a header chain needs before its
+// blocks are downloaded (usually ConsensusRules.MinimumChainWork)
+func (sm *SyncManager) SetMinimumChainWork(work *big.Int) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if work == nil {
+		work = big.NewInt(0)
+	}
+	sm.minChainWork = new(big.Int).Set(work)
+}
+
+// BestHeaderHeight returns the height of the best known header, which is
+// never below our own tip
+func (sm *SyncManager) BestHeaderHeight() uint64 {
+	sm.mutex.Lock()
+	headers := sm.bestHeaderHeight
+	sm.mutex.Unlock()
+
+	if tip, err := sm.chain.GetBestBlockHeight(); err == nil && tip > headers {
+		return tip
 	}
+	return headers
 }
 
 // HandleInv handles inventory announcements
@@ -47,6 +181,12 @@ func (sm *SyncManager) HandleInv(msg *protocol.InvMessage, peer MessageSender) e
 				return err
 			}
 
+			if _, orphan := sm.orphans[vect.Hash]; orphan {
+				// We have it but not its ancestors, ask for those instead
+				sm.requestAncestors(vect.Hash, peer)
+				continue
+			}
+
 			if !exists {
 				// Check if already requested
 				if _, requested := sm.requestedBlocks[vect.Hash]; !requested {
@@ -56,9 +196,10 @@ func (sm *SyncManager) HandleInv(msg *protocol.InvMessage, peer MessageSender) e
 				}
 			}
 		} else if vect.Type == protocol.InvTypeTx {
-			// For now, request all transactions we don't know about
-			// In a real node, we'd check mempool
-			getData.AddInvVect(vect)
+			// One peer at a time; the caller drops transactions we have
+			if sm.txRequests.Announced(vect.Hash, peer, sm.clock.Now()) {
+				getData.AddInvVect(vect)
+			}
 		}
 	}
 
@@ -74,69 +215,493 @@ func (sm *SyncManager) HandleInv(msg *protocol.InvMessage, peer MessageSender) e
 	return nil
 }
 
-// HandleBlock handles a received block
-func (sm *SyncManager) HandleBlock(block *types.Block, peer MessageSender) error {
+// HandleBlock handles a received block. Blocks whose parent is unknown
+// are kept in the orphan pool while their ancestors are requested from the
+// peer, and blocks on a competing branch are stored as side-chain blocks.
+// It returns every block that joined the best chain, oldest first: the
+// block itself, orphans that were waiting on it, or a whole branch that
+// overtook the best chain. Blocks a reorganization took off the best
+// chain are returned too, tip first.
+func (sm *SyncManager) HandleBlock(block *types.Block, peer MessageSender) (connected, disconnected []*types.Block, err error) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
 	// Calculate hash
 	hash, err := sm.chain.GetBlockHash(block)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Remove from requested list
 	delete(sm.requestedBlocks, hash)
+	delete(sm.headersOnly, hash)
 
 	// Check if we already have it
 	exists, err := sm.chain.HasBlock(hash)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	if exists {
-		return nil
+		return nil, nil, nil
+	}
+	if _, orphan := sm.orphans[hash]; orphan {
+		return nil, nil, nil
 	}
-
-	// Save block
-	// Note: In a real node, we would validate the block first!
-	// We'll assume the chain.SaveBlock handles basic validation or we trust for now
-	// But we need the height. For this phase, we might need to assume it's next block
-	// or look up parent.
 
 	prevHash := block.Header.PrevBlockHash
 
 	// Check if parent exists
 	exists, err = sm.chain.HasBlock(prevHash)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	var height uint64
 	if !exists {
-		// Parent not found. This is an orphan block.
-		if prevHash.IsZero() {
-			height = 0
-		} else {
-			return fmt.Errorf("parent block %s not found", prevHash)
+		if !prevHash.IsZero() {
+			// Parent not found. Keep the block and ask for the gap.
+			sm.addOrphan(block, hash, peer.Address())
+			sm.requestAncestors(hash, peer)
+			return nil, nil, nil
 		}
+		height = 0
 	} else {
 		// Get parent height
 		prevHeight, err := sm.chain.GetBlockHeight(prevHash)
 		if err != nil {
-			return fmt.Errorf("failed to get parent height: %w", err)
+			return nil, nil, fmt.Errorf("failed to get parent height: %w", err)
 		}
 		height = prevHeight + 1
 	}
 
-	if err := sm.chain.SaveBlock(block, height); err != nil {
-		return fmt.Errorf("failed to save block: %w", err)
+	oldTip, err := sm.chain.GetBestBlockHash()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	connected, err = sm.storeBlock(block, hash, height)
+	if err != nil {
+		return sm.chainChanges(oldTip, connected, fmt.Errorf("failed to save block: %w", err))
 	}
 
 	fmt.Printf("Synced block %s at height %d from %s\n", hash, height, peer.Address())
 
+	orphans, err := sm.connectOrphans(hash, height)
+	return sm.chainChanges(oldTip, append(connected, orphans...), err)
+}
+
+// chainChanges works out how the best chain moved since oldTip. Of the
+// blocks that joined it, those a later reorganization took off again are
+// dropped, and the blocks of the old chain no longer on it are collected
+// tip first (internal, lock held)
+func (sm *SyncManager) chainChanges(oldTip types.Hash, joined []*types.Block, err error) ([]*types.Block, []*types.Block, error) {
+	var connected, disconnected []*types.Block
+	for _, block := range joined {
+		hash, hashErr := serialization.HashBlockHeader(&block.Header)
+		if hashErr != nil {
+			continue
+		}
+		if onMain, mainErr := sm.chain.IsMainChain(hash); mainErr == nil && onMain {
+			connected = append(connected, block)
+		}
+	}
+
+	for hash := oldTip; !hash.IsZero(); {
+		if onMain, mainErr := sm.chain.IsMainChain(hash); mainErr != nil || onMain {
+			break
+		}
+		block, getErr := sm.chain.GetBlock(hash)
+		if getErr != nil {
+			break
+		}
+		disconnected = append(disconnected, block)
+		hash = block.Header.PrevBlockHash
+	}
+
+	return connected, disconnected, err
+}
+
+// storeBlock validates and saves a block whose parent is stored. A block
+// extending the tip becomes the new tip; any other block is kept as a
+// side-chain block, and its branch replaces the best chain once it has
+// more work and every block on it is valid. It returns the blocks that
+// joined the best chain (internal, lock held)
+func (sm *SyncManager) storeBlock(block *types.Block, hash types.Hash, height uint64) ([]*types.Block, error) {
+	if err := validation.CheckBlockSanity(block); err != nil {
+		return nil, err
+	}
+
+	bestHash, err := sm.chain.GetBestBlockHash()
+	if err != nil {
+		return nil, err
+	}
+	if bestHash.IsZero() {
+		if err := sm.chain.SaveBlock(block, height); err != nil {
+			return nil, err
+		}
+		return []*types.Block{block}, nil
+	}
+
+	if err := validation.CheckBlockContext(sm.chain, sm.rules, &block.Header, height); err != nil {
+		return nil, err
+	}
+	set, err := sm.chainState()
+	if err != nil {
+		return nil, err
+	}
+	view := utxo.NewUTXOView(set)
+
+	if block.Header.PrevBlockHash == bestHash {
+		validator := validation.NewBlockValidator(view)
+		validator.SetRules(sm.rules)
+		validator.SetBlockchain(sm.chain)
+		if err := validator.ValidateBlock(block, height, bestHash); err != nil {
+			return nil, err
+		}
+		if err := validator.ApplyBlock(block, height); err != nil {
+			return nil, err
+		}
+		if err := sm.chain.SaveBlock(block, height); err != nil {
+			return nil, err
+		}
+		// As of no block until the commit completes; a failed one leaves
+		// the set to be rebuilt on next use
+		sm.chainstate.SetBestBlock(types.Hash{})
+		if err := view.Commit(); err != nil {
+			return nil, err
+		}
+		sm.chainstate.SetBestBlock(hash)
+		return []*types.Block{block}, nil
+	}
+
+	if err := sm.chain.SaveSideBlock(block, height); err != nil {
+		return nil, err
+	}
+	branch, forkHeight, err := sm.chain.GetBranch(hash)
+	if err != nil {
+		return nil, err
+	}
+	better, err := sm.chain.BranchHasMoreWork(branch, forkHeight)
+	if err != nil {
+		return nil, err
+	}
+	if !better {
+		fmt.Printf("Stored side-chain block %s at height %d\n", hash, height)
+		return nil, nil
+	}
+
+	sm.chainstate.SetBestBlock(types.Hash{})
+	if _, err := sm.reorg.Reorganize(set, branch, forkHeight); err != nil {
+		// Failing after the switch leaves the set behind the chain; it is
+		// rebuilt on next use
+		if tip, tipErr := sm.chain.GetBestBlockHash(); tipErr == nil && tip == bestHash {
+			sm.chainstate.SetBestBlock(bestHash)
+		}
+		return nil, err
+	}
+	sm.chainstate.SetBestBlock(hash)
+	fmt.Printf("Switched to a branch with more work: fork at height %d, new tip %s at height %d\n",
+		forkHeight, hash, height)
+	return branch, nil
+}
+
+// Chainstate returns the UTXO set peer blocks are validated against,
+// brought up to date with the best chain. The set is the same one until
+// SetChainstate and follows every block connected through it.
+func (sm *SyncManager) Chainstate() (*utxo.UTXOCache, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if empty, err := sm.chain.IsEmpty(); err != nil || empty {
+		return sm.chainstate, err
+	}
+	return sm.chainState()
+}
+
+// chainState returns the UTXO set as of the best block. Blocks connected
+// elsewhere are replayed onto it; a reorganization it didn't see, or a
+// set as of no block, rebuilds it from genesis (internal, lock held)
+func (sm *SyncManager) chainState() (*utxo.UTXOCache, error) {
+	tipHash, tipHeight, err := sm.chain.GetTip()
+	if err != nil {
+		return nil, err
+	}
+	best := sm.chainstate.BestBlock()
+	if best == tipHash {
+		return sm.chainstate, nil
+	}
+
+	from := uint64(0)
+	if onMain, err := sm.chain.IsMainChain(best); err == nil && onMain && !best.IsZero() {
+		height, _ := sm.chain.GetBlockHeight(best)
+		from = height + 1
+	} else if err := sm.chainstate.Clear(); err != nil {
+		return nil, fmt.Errorf("failed to clear UTXO set: %w", err)
+	}
+
+	// Forgotten until the replay completes, so a failure never leaves a
+	// half-updated set trusted
+	sm.chainstate.SetBestBlock(types.Hash{})
+	replay := validation.NewBlockValidator(sm.chainstate)
+	replay.SetRules(sm.rules)
+	for h := from; h <= tipHeight; h++ {
+		block, err := sm.chain.GetBlockByHeight(h)
+		if err == nil {
+			err = replay.ApplyBlock(block, h)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay block at height %d: %w", h, err)
+		}
+	}
+	sm.chainstate.SetBestBlock(tipHash)
+	return sm.chainstate, nil
+}
+
+// addOrphan stores a block until its parent arrives, evicting the oldest
+// orphan if the pool is full (internal, lock held)
+func (sm *SyncManager) addOrphan(block *types.Block, hash types.Hash, from string) {
+	for len(sm.orphanOrder) >= MaxOrphanBlocks {
+		sm.removeOrphan(sm.orphanOrder[0])
+	}
+
+	prev := block.Header.PrevBlockHash
+	sm.orphans[hash] = &orphanBlock{block: block, hash: hash, from: from}
+	sm.orphansByPrev[prev] = append(sm.orphansByPrev[prev], hash)
+	sm.orphanOrder = append(sm.orphanOrder, hash)
+
+	fmt.Printf("Stored orphan block %s (parent %s) from %s\n", hash, prev, from)
+}
+
+// removeOrphan drops a block from the orphan pool (internal, lock held)
+func (sm *SyncManager) removeOrphan(hash types.Hash) {
+	orphan, exists := sm.orphans[hash]
+	if !exists {
+		return
+	}
+	delete(sm.orphans, hash)
+
+	prev := orphan.block.Header.PrevBlockHash
+	siblings := sm.orphansByPrev[prev]
+	for i, h := range siblings {
+		if h == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(sm.orphansByPrev, prev)
+	} else {
+		sm.orphansByPrev[prev] = siblings
+	}
+
+	for i, h := range sm.orphanOrder {
+		if h == hash {
+			sm.orphanOrder = append(sm.orphanOrder[:i], sm.orphanOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// connectOrphans saves every orphan descending from parent, breadth first
+// (internal, lock held)
+func (sm *SyncManager) connectOrphans(parent types.Hash, parentHeight uint64) ([]*types.Block, error) {
+	type pending struct {
+		hash   types.Hash
+		height uint64
+	}
+
+	var connected []*types.Block
+	queue := []pending{{parent, parentHeight}}
+
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+
+		children := append([]types.Hash(nil), sm.orphansByPrev[next.hash]...)
+		for _, childHash := range children {
+			orphan := sm.orphans[childHash]
+			sm.removeOrphan(childHash)
+
+			height := next.height + 1
+			joined, err := sm.storeBlock(orphan.block, childHash, height)
+			if err != nil {
+				return connected, fmt.Errorf("failed to save orphan %s: %w", childHash, err)
+			}
+			fmt.Printf("Connected orphan block %s at height %d\n", childHash, height)
+
+			connected = append(connected, joined...)
+			queue = append(queue, pending{childHash, height})
+		}
+	}
+
+	return connected, nil
+}
+
+// orphanRoot follows the orphan pool back to the oldest missing ancestor's child
+// (internal, lock held)
+func (sm *SyncManager) orphanRoot(hash types.Hash) types.Hash {
+	for {
+		orphan, exists := sm.orphans[hash]
+		if !exists {
+			return hash
+		}
+		prev := orphan.block.Header.PrevBlockHash
+		if _, parentIsOrphan := sm.orphans[prev]; !parentIsOrphan {
+			return hash
+		}
+		hash = prev
+	}
+}
+
+// requestAncestors asks peer for the blocks between our tip and the root of
+// the orphan chain ending in hash (internal, lock held)
+func (sm *SyncManager) requestAncestors(hash types.Hash, peer MessageSender) {
+	msg := protocol.NewGetBlocksMessage(sm.getBlockLocator(), sm.orphanRoot(hash))
+	peer.SendMessage(protocol.NewMessage(
+		protocol.MagicMainnet,
+		protocol.CmdGetBlocks,
+		mustSerialize(msg),
+	))
+}
+
+// OrphanCount returns the number of blocks waiting for a parent
+func (sm *SyncManager) OrphanCount() int {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	return len(sm.orphans)
+}
+
+// MemoryUsage returns how many blocks the manager tracks in memory (orphans
+// and in-flight requests) and roughly how many bytes they hold
+func (sm *SyncManager) MemoryUsage() (entries int, bytes int64) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	for _, orphan := range sm.orphans {
+		if data, err := serialization.SerializeBlock(orphan.block); err == nil {
+			bytes += int64(len(data))
+		}
+		bytes += int64(len(orphan.from)) + 2*32 // Pool and by-prev index keys
+	}
+	for _, from := range sm.requestedBlocks {
+		bytes += 32 + int64(len(from))
+	}
+	return len(sm.orphans) + len(sm.requestedBlocks), bytes
+}
+
+// IsOrphan reports whether hash is in the orphan pool
+func (sm *SyncManager) IsOrphan(hash types.Hash) bool {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	_, exists := sm.orphans[hash]
+	return exists
+}
+
+// HandleNotFound handles a peer telling us it doesn't have blocks we asked
+// it for. Each such block is requested from the first of the other peers
+// instead, or forgotten so a later inv can trigger a new request.
+// Transactions go to the next peer that announced them.
+func (sm *SyncManager) HandleNotFound(msg *protocol.NotFoundMessage, peer MessageSender, others []MessageSender) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	retry := make(map[MessageSender]*protocol.GetDataMessage)
+
+	for _, vect := range msg.Inventory {
+		if vect.Type == protocol.InvTypeTx {
+			for other, txids := range sm.txRequests.NotFound(vect.Hash, peer, sm.clock.Now()) {
+				if retry[other] == nil {
+					retry[other] = protocol.NewGetDataMessage()
+				}
+				for _, txid := range txids {
+					retry[other].AddInvVect(protocol.NewInvVect(protocol.InvTypeTx, txid))
+				}
+			}
+			continue
+		}
+		if vect.Type != protocol.InvTypeBlock {
+			continue
+		}
+
+		// Ignore notfound for things we never asked this peer for
+		if from, requested := sm.requestedBlocks[vect.Hash]; !requested || from != peer.Address() {
+			continue
+		}
+		delete(sm.requestedBlocks, vect.Hash)
+
+		if len(others) == 0 {
+			continue
+		}
+
+		other := others[0]
+		if retry[other] == nil {
+			retry[other] = protocol.NewGetDataMessage()
+		}
+		retry[other].AddInvVect(vect)
+		sm.requestedBlocks[vect.Hash] = other.Address()
+	}
+
+	for other, getData := range retry {
+		fmt.Printf("Re-requesting %d items from %s after notfound from %s\n", len(getData.Inventory), other.Address(), peer.Address())
+		other.SendMessage(protocol.NewMessage(
+			protocol.MagicMainnet,
+			protocol.CmdGetData,
+			mustSerialize(getData),
+		))
+	}
+
 	return nil
 }
 
+// TxReceived forgets the outstanding request for a transaction that
+// arrived
+func (sm *SyncManager) TxReceived(txid types.Hash) {
+	sm.txRequests.Received(txid)
+}
+
+// ExpireTxRequests re-requests transactions whose peer didn't deliver in
+// time from the next peer that announced them
+func (sm *SyncManager) ExpireTxRequests() {
+	sendTxRequests(sm.txRequests.Expire(sm.now()))
+}
+
+// PeerDisconnected re-requests the transactions outstanding from a peer
+// that went away from other peers that announced them
+func (sm *SyncManager) PeerDisconnected(peer MessageSender) {
+	sendTxRequests(sm.txRequests.PeerDisconnected(peer, sm.now()))
+}
+
+// TxRequests returns the tracker of announced transactions
+func (sm *SyncManager) TxRequests() *TxRequestTracker {
+	return sm.txRequests
+}
+
+// sendTxRequests sends each peer a getdata for its transactions
+func sendTxRequests(requests map[MessageSender][]types.Hash) {
+	for peer, txids := range requests {
+		getData := protocol.NewGetDataMessage()
+		for _, txid := range txids {
+			getData.AddInvVect(protocol.NewInvVect(protocol.InvTypeTx, txid))
+		}
+		peer.SendMessage(protocol.NewMessage(
+			protocol.MagicMainnet,
+			protocol.CmdGetData,
+			mustSerialize(getData),
+		))
+	}
+}
+
+// IsRequested reports whether a block is waiting on a getdata response
+func (sm *SyncManager) IsRequested(hash types.Hash) bool {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	_, requested := sm.requestedBlocks[hash]
+	return requested
+}
+
 // StartSync initiates sync with a peer
 func (sm *SyncManager) StartSync(peer MessageSender) error {
 	// Send getblocks to find common history
@@ -157,8 +722,9 @@ func (sm *SyncManager) StartSync(peer MessageSender) error {
 // getBlockLocator returns block hashes from newest to oldest
 // Used to find the divergence point between chains
 func (sm *SyncManager) getBlockLocator() []types.Hash {
-	// Simplified: just return the tip
-	hash, err := sm.chain.GetBestBlockHash()
+	// Simplified: just return the tip. Without one the locator is empty,
+	// so peers start from their genesis.
+	hash, _, err := sm.chain.GetTip()
 	if err != nil {
 		return []types.Hash{}
 	}
@@ -0,0 +1,210 @@
+package sync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+const (
+	// MaxPeerTxInFlight is the most transactions requested from one peer
+	// and not yet delivered. Announcements beyond it wait for another peer
+	// or for the peer to catch up.
+	MaxPeerTxInFlight = 100
+
+	// TxRequestTimeout is how long a peer has to deliver a transaction
+	// before it is requested from the next peer that announced it
+	TxRequestTimeout = time.Minute
+)
+
+// txRequest is a transaction peers announced and we haven't received
+type txRequest struct {
+	announcers []MessageSender // Not asked yet, in announcement order
+	requested  MessageSender   // Nil while no announcer has room
+	expiry     time.Time       // When requested is given up on
+}
+
+// TxRequestTracker asks for each announced transaction from one peer at
+// a time. Other announcers are remembered and asked in turn when the
+// request times out, the peer answers notfound or disconnects, so a
+// transaction is downloaded once however many peers announce it.
+type TxRequestTracker struct {
+	txs      map[types.Hash]*txRequest
+	inFlight map[string]int // Requests outstanding by peer address
+	mu       sync.Mutex
+}
+
+// NewTxRequestTracker creates an empty tracker
+func NewTxRequestTracker() *TxRequestTracker {
+	return &TxRequestTracker{
+		txs:      make(map[types.Hash]*txRequest),
+		inFlight: make(map[string]int),
+	}
+}
+
+// Announced records that peer has txid and reports whether it should be
+// requested from peer now
+func (t *TxRequestTracker) Announced(txid types.Hash, peer MessageSender, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	req, ok := t.txs[txid]
+	if !ok {
+		req = &txRequest{}
+		t.txs[txid] = req
+	}
+	if req.requested != nil && req.requested.Address() == peer.Address() {
+		return false
+	}
+	for _, a := range req.announcers {
+		if a.Address() == peer.Address() {
+			return false
+		}
+	}
+
+	if req.requested == nil && t.inFlight[peer.Address()] < MaxPeerTxInFlight {
+		t.request(req, peer, now)
+		return true
+	}
+	req.announcers = append(req.announcers, peer)
+	return false
+}
+
+// Received forgets a transaction that arrived, whoever sent it
+func (t *TxRequestTracker) Received(txid types.Hash) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if req, ok := t.txs[txid]; ok {
+		t.release(req)
+		delete(t.txs, txid)
+	}
+}
+
+// NotFound handles peer not having a transaction we requested from it.
+// It returns the requests to send instead.
+func (t *TxRequestTracker) NotFound(txid types.Hash, peer MessageSender, now time.Time) map[MessageSender][]types.Hash {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	retry := make(map[MessageSender][]types.Hash)
+	req, ok := t.txs[txid]
+	if !ok || req.requested == nil || req.requested.Address() != peer.Address() {
+		return retry
+	}
+	t.release(req)
+	t.next(txid, req, now, retry)
+	return retry
+}
+
+// Expire moves requests that timed out to their next announcer, and
+// hands waiting transactions to announcers that now have room. It
+// returns the requests to send.
+func (t *TxRequestTracker) Expire(now time.Time) map[MessageSender][]types.Hash {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	retry := make(map[MessageSender][]types.Hash)
+	for txid, req := range t.txs {
+		if req.requested != nil && now.Before(req.expiry) {
+			continue
+		}
+		t.release(req)
+		t.next(txid, req, now, retry)
+	}
+	return retry
+}
+
+// PeerDisconnected forgets peer's announcements and moves its
+// outstanding requests to other announcers. It returns the requests to
+// send.
+func (t *TxRequestTracker) PeerDisconnected(peer MessageSender, now time.Time) map[MessageSender][]types.Hash {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	retry := make(map[MessageSender][]types.Hash)
+	for txid, req := range t.txs {
+		kept := req.announcers[:0]
+		for _, a := range req.announcers {
+			if a.Address() != peer.Address() {
+				kept = append(kept, a)
+			}
+		}
+		req.announcers = kept
+
+		if req.requested != nil && req.requested.Address() == peer.Address() {
+			t.release(req)
+			t.next(txid, req, now, retry)
+		} else if req.requested == nil && len(req.announcers) == 0 {
+			delete(t.txs, txid)
+		}
+	}
+	delete(t.inFlight, peer.Address())
+	return retry
+}
+
+// InFlight returns how many transactions are requested from a peer
+func (t *TxRequestTracker) InFlight(addr string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.inFlight[addr]
+}
+
+// RequestedFrom returns the peer txid is requested from, or "" when it
+// isn't requested from anyone
+func (t *TxRequestTracker) RequestedFrom(txid types.Hash) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if req, ok := t.txs[txid]; ok && req.requested != nil {
+		return req.requested.Address()
+	}
+	return ""
+}
+
+// Pending returns how many announced transactions haven't arrived
+func (t *TxRequestTracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.txs)
+}
+
+// request marks req as requested from peer
+func (t *TxRequestTracker) request(req *txRequest, peer MessageSender, now time.Time) {
+	req.requested = peer
+	req.expiry = now.Add(TxRequestTimeout)
+	t.inFlight[peer.Address()]++
+}
+
+// release clears req's outstanding request
+func (t *TxRequestTracker) release(req *txRequest) {
+	if req.requested == nil {
+		return
+	}
+	addr := req.requested.Address()
+	if t.inFlight[addr]--; t.inFlight[addr] <= 0 {
+		delete(t.inFlight, addr)
+	}
+	req.requested = nil
+}
+
+// next requests txid from the first remaining announcer with room,
+// adding it to retry. A transaction nobody else announced is forgotten,
+// so a later announcement starts over.
+func (t *TxRequestTracker) next(txid types.Hash, req *txRequest, now time.Time, retry map[MessageSender][]types.Hash) {
+	for i, peer := range req.announcers {
+		if t.inFlight[peer.Address()] >= MaxPeerTxInFlight {
+			continue
+		}
+		req.announcers = append(req.announcers[:i], req.announcers[i+1:]...)
+		t.request(req, peer, now)
+		retry[peer] = append(retry[peer], txid)
+		return
+	}
+	if len(req.announcers) == 0 {
+		delete(t.txs, txid)
+	}
+}
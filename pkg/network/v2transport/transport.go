@@ -0,0 +1,345 @@
+// Package v2transport implements an opt-in encrypted connection layer in
+// the spirit of BIP324.
+//
+// The initiator opens with a 32-byte ephemeral X25519 public key. Because a
+// plaintext (v1) connection always starts with the 4-byte network magic,
+// the responder can tell the two apart from the first bytes it reads and
+// fall back to plaintext. The responder answers with its own public key,
+// and both sides derive directional keys and a session ID from the shared
+// secret with HKDF-SHA256.
+//
+// After the key exchange data travels in packets:
+//
+//	[length (3, ChaCha20 encrypted)] [ChaCha20-Poly1305(contents) + tag (16)]
+//
+// The length is encrypted with its own keystream so packet sizes aren't
+// visible on the wire, and is bound to the packet as associated data.
+// The first packet in each direction is empty and serves as key
+// confirmation: a peer that derived different keys fails to decrypt it.
+package v2transport
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// PubKeySize is the size of the ephemeral key each side sends
+	PubKeySize = 32
+
+	// MaxPacketSize is the largest packet contents the 3-byte length can
+	// describe; larger writes are split
+	MaxPacketSize = 1<<24 - 1
+
+	lengthSize = 3
+	tagSize    = chacha20poly1305.Overhead
+)
+
+// ErrKeyConfirmation is returned when the peer's first packet doesn't
+// decrypt, i.e. the two sides did not derive the same keys
+var ErrKeyConfirmation = errors.New("v2 key confirmation failed")
+
+// Conn is an encrypted connection. Reads and writes are a byte stream; each
+// Write is sent as one packet. Deadlines and addresses come from the
+// underlying connection.
+type Conn struct {
+	net.Conn
+
+	sessionID [32]byte
+
+	sendMu     sync.Mutex
+	sendLength *chacha20.Cipher
+	sendAEAD   cipherAEAD
+	sendNonce  uint64
+
+	recvMu     sync.Mutex
+	recvLength *chacha20.Cipher
+	recvAEAD   cipherAEAD
+	recvNonce  uint64
+	recvBuf    []byte
+}
+
+// cipherAEAD is the part of cipher.AEAD we use
+type cipherAEAD interface {
+	Seal(dst, nonce, plaintext, additionalData []byte) []byte
+	Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// Initiate performs the initiator side of the handshake on conn
+func Initiate(conn net.Conn, magic uint32) (*Conn, error) {
+	key, err := newEphemeralKey(magic)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(key.PublicKey().Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to send public key: %w", err)
+	}
+
+	theirs := make([]byte, PubKeySize)
+	if _, err := io.ReadFull(conn, theirs); err != nil {
+		return nil, fmt.Errorf("failed to read responder key: %w", err)
+	}
+
+	return establish(conn, key, theirs, true, magic)
+}
+
+// Accept performs the responder side of the handshake. If the peer speaks
+// plaintext, the returned connection replays the bytes already consumed
+// and v2 is false.
+func Accept(conn net.Conn, magic uint32) (c net.Conn, v2 bool, err error) {
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(conn, prefix); err != nil {
+		return nil, false, fmt.Errorf("failed to read connection prefix: %w", err)
+	}
+
+	if binary.LittleEndian.Uint32(prefix) == magic {
+		return &replayConn{Conn: conn, pending: prefix}, false, nil
+	}
+
+	theirs := make([]byte, PubKeySize)
+	copy(theirs, prefix)
+	if _, err := io.ReadFull(conn, theirs[len(prefix):]); err != nil {
+		return nil, false, fmt.Errorf("failed to read initiator key: %w", err)
+	}
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := conn.Write(key.PublicKey().Bytes()); err != nil {
+		return nil, false, fmt.Errorf("failed to send public key: %w", err)
+	}
+
+	encrypted, err := establish(conn, key, theirs, false, magic)
+	if err != nil {
+		return nil, false, err
+	}
+	return encrypted, true, nil
+}
+
+// SessionID identifies the session; both ends see the same value, which
+// can be compared out of band to detect a man in the middle
+func (c *Conn) SessionID() [32]byte {
+	return c.sessionID
+}
+
+// Read returns decrypted bytes, reading another packet when needed
+func (c *Conn) Read(b []byte) (int, error) {
+	c.recvMu.Lock()
+	defer c.recvMu.Unlock()
+
+	for len(c.recvBuf) == 0 {
+		contents, err := c.readPacket()
+		if err != nil {
+			return 0, err
+		}
+		c.recvBuf = contents
+	}
+
+	n := copy(b, c.recvBuf)
+	c.recvBuf = c.recvBuf[n:]
+	return n, nil
+}
+
+// Write encrypts b as one packet (several if it exceeds MaxPacketSize)
+func (c *Conn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		chunk := b[written:]
+		if len(chunk) > MaxPacketSize {
+			chunk = chunk[:MaxPacketSize]
+		}
+		if err := c.writePacket(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// writePacket encrypts and sends one packet
+func (c *Conn) writePacket(contents []byte) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	packet := make([]byte, lengthSize, lengthSize+len(contents)+tagSize)
+	putUint24(packet, uint32(len(contents)))
+	c.sendLength.XORKeyStream(packet[:lengthSize], packet[:lengthSize])
+
+	packet = c.sendAEAD.Seal(packet, packetNonce(c.sendNonce), contents, packet[:lengthSize])
+	c.sendNonce++
+
+	_, err := c.Conn.Write(packet)
+	return err
+}
+
+// readPacket reads and decrypts one packet (recvMu held)
+func (c *Conn) readPacket() ([]byte, error) {
+	header := make([]byte, lengthSize)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return nil, err
+	}
+
+	length := make([]byte, lengthSize)
+	c.recvLength.XORKeyStream(length, header)
+	size := getUint24(length)
+
+	body := make([]byte, int(size)+tagSize)
+	if _, err := io.ReadFull(c.Conn, body); err != nil {
+		return nil, err
+	}
+
+	contents, err := c.recvAEAD.Open(body[:0], packetNonce(c.recvNonce), body, header)
+	if err != nil {
+		return nil, fmt.Errorf("packet authentication failed: %w", err)
+	}
+	c.recvNonce++
+
+	return contents, nil
+}
+
+// establish derives the session keys and exchanges the confirmation packets
+func establish(conn net.Conn, ours *ecdh.PrivateKey, theirsBytes []byte, initiator bool, magic uint32) (*Conn, error) {
+	theirs, err := ecdh.X25519().NewPublicKey(theirsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer key: %w", err)
+	}
+	if subtle.ConstantTimeCompare(theirs.Bytes(), ours.PublicKey().Bytes()) == 1 {
+		return nil, errors.New("peer echoed our public key")
+	}
+
+	shared, err := ours.ECDH(theirs)
+	if err != nil {
+		return nil, fmt.Errorf("key exchange failed: %w", err)
+	}
+
+	// Bind the keys to both public keys in initiator/responder order
+	initiatorKey, responderKey := ours.PublicKey().Bytes(), theirs.Bytes()
+	if !initiator {
+		initiatorKey, responderKey = responderKey, initiatorKey
+	}
+	ikm := make([]byte, 0, len(shared)+2*PubKeySize)
+	ikm = append(ikm, shared...)
+	ikm = append(ikm, initiatorKey...)
+	ikm = append(ikm, responderKey...)
+
+	salt := make([]byte, 0, 32)
+	salt = append(salt, "learn-bitcoin_v2_shared_secret"...)
+	salt = binary.LittleEndian.AppendUint32(salt, magic)
+
+	keys := hkdf.New(sha256.New, ikm, salt, nil)
+	var initL, initP, respL, respP [32]byte
+	c := &Conn{Conn: conn}
+	for _, out := range [][]byte{initL[:], initP[:], respL[:], respP[:], c.sessionID[:]} {
+		if _, err := io.ReadFull(keys, out); err != nil {
+			return nil, err
+		}
+	}
+
+	sendL, sendP, recvL, recvP := initL, initP, respL, respP
+	if !initiator {
+		sendL, sendP, recvL, recvP = respL, respP, initL, initP
+	}
+
+	zeroNonce := make([]byte, chacha20.NonceSize)
+	if c.sendLength, err = chacha20.NewUnauthenticatedCipher(sendL[:], zeroNonce); err != nil {
+		return nil, err
+	}
+	if c.recvLength, err = chacha20.NewUnauthenticatedCipher(recvL[:], zeroNonce); err != nil {
+		return nil, err
+	}
+	if c.sendAEAD, err = chacha20poly1305.New(sendP[:]); err != nil {
+		return nil, err
+	}
+	if c.recvAEAD, err = chacha20poly1305.New(recvP[:]); err != nil {
+		return nil, err
+	}
+
+	// Key confirmation: the initiator speaks first so unbuffered
+	// connections don't deadlock
+	if initiator {
+		if err := c.writePacket(nil); err != nil {
+			return nil, fmt.Errorf("failed to send key confirmation: %w", err)
+		}
+	}
+	c.recvMu.Lock()
+	contents, err := c.readPacket()
+	c.recvMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyConfirmation, err)
+	}
+	if len(contents) != 0 {
+		return nil, ErrKeyConfirmation
+	}
+	if !initiator {
+		if err := c.writePacket(nil); err != nil {
+			return nil, fmt.Errorf("failed to send key confirmation: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// newEphemeralKey generates an initiator key whose encoding can't be
+// mistaken for the start of a plaintext message
+func newEphemeralKey(magic uint32) (*ecdh.PrivateKey, error) {
+	var prefix [4]byte
+	binary.LittleEndian.PutUint32(prefix[:], magic)
+
+	for {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(key.PublicKey().Bytes(), prefix[:]) {
+			return key, nil
+		}
+	}
+}
+
+// packetNonce encodes a packet counter as a ChaCha20-Poly1305 nonce
+func packetNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+func getUint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+// replayConn returns already-consumed bytes before reading from the
+// underlying connection
+type replayConn struct {
+	net.Conn
+	pending []byte
+}
+
+func (r *replayConn) Read(b []byte) (int, error) {
+	if len(r.pending) > 0 {
+		n := copy(b, r.pending)
+		r.pending = r.pending[n:]
+		return n, nil
+	}
+	return r.Conn.Read(b)
+}
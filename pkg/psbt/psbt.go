@@ -0,0 +1,440 @@
+// Package psbt implements partially signed Bitcoin transactions (BIP174):
+// an unsigned transaction plus what each signer needs to sign it, passed
+// between wallets that each add their signatures until the inputs can be
+// finalized and the network transaction extracted.
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Magic starts every serialized PSBT: "psbt" and a 0xff separator
+var Magic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// Key types of the global map
+const (
+	globalUnsignedTx = 0x00
+)
+
+// Key types of an input map
+const (
+	inputNonWitnessUTXO     = 0x00
+	inputWitnessUTXO        = 0x01
+	inputPartialSig         = 0x02
+	inputSighashType        = 0x03
+	inputRedeemScript       = 0x04
+	inputWitnessScript      = 0x05
+	inputFinalScriptSig     = 0x07
+	inputFinalScriptWitness = 0x08
+)
+
+// Key types of an output map
+const (
+	outputRedeemScript  = 0x00
+	outputWitnessScript = 0x01
+)
+
+var (
+	ErrInvalidMagic   = errors.New("not a PSBT")
+	ErrDuplicateKey   = errors.New("duplicate key in PSBT map")
+	ErrSignedTx       = errors.New("unsigned transaction has scriptSigs or witnesses")
+	ErrMismatchedPSBT = errors.New("PSBTs are for different transactions")
+)
+
+// Packet is a PSBT: the transaction being signed, and a map of signing
+// data for each of its inputs and outputs
+type Packet struct {
+	UnsignedTx *types.Transaction
+	Inputs     []Input
+	Outputs    []Output
+	Unknown    []KeyValue // Global entries this package doesn't interpret
+}
+
+// Input holds what signers and the finalizer know about one input
+type Input struct {
+	NonWitnessUTXO     *types.Transaction // The whole transaction being spent
+	WitnessUTXO        *types.TxOutput    // Just the output, for segwit inputs
+	PartialSigs        map[string][]byte  // Signatures keyed by the hex public key
+	SighashType        uint32             // 0 when unset, meaning SIGHASH_ALL
+	RedeemScript       []byte
+	WitnessScript      []byte
+	FinalScriptSig     []byte
+	FinalScriptWitness [][]byte
+	Unknown            []KeyValue
+}
+
+// Output holds what signers know about one output, so they can recognise
+// their change
+type Output struct {
+	RedeemScript  []byte
+	WitnessScript []byte
+	Unknown       []KeyValue
+}
+
+// KeyValue is a map entry kept as it was read, so that PSBTs carrying
+// fields from newer BIPs survive a round trip through this package
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// New creates a PSBT for tx, which must not be signed yet
+func New(tx *types.Transaction) (*Packet, error) {
+	for _, input := range tx.Inputs {
+		if len(input.SignatureScript) > 0 || len(input.Witness) > 0 {
+			return nil, ErrSignedTx
+		}
+	}
+	return &Packet{
+		UnsignedTx: tx,
+		Inputs:     make([]Input, len(tx.Inputs)),
+		Outputs:    make([]Output, len(tx.Outputs)),
+	}, nil
+}
+
+// Serialize encodes the PSBT in the BIP174 binary format
+func (p *Packet) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(Magic)
+
+	tx, err := serialization.SerializeTransaction(p.UnsignedTx)
+	if err != nil {
+		return nil, err
+	}
+	writeEntry(&buf, []byte{globalUnsignedTx}, tx)
+	writeUnknown(&buf, p.Unknown)
+	buf.WriteByte(0x00)
+
+	for i := range p.Inputs {
+		if err := p.Inputs[i].serialize(&buf); err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	for i := range p.Outputs {
+		p.Outputs[i].serialize(&buf)
+	}
+	return buf.Bytes(), nil
+}
+
+// B64Encode serializes the PSBT as base64, the form wallets exchange
+func (p *Packet) B64Encode() (string, error) {
+	data, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Parse decodes a binary PSBT
+func Parse(data []byte) (*Packet, error) {
+	if !bytes.HasPrefix(data, Magic) {
+		return nil, ErrInvalidMagic
+	}
+	r := bytes.NewReader(data[len(Magic):])
+
+	p := &Packet{}
+	entries, err := readMap(r)
+	if err != nil {
+		return nil, fmt.Errorf("global map: %w", err)
+	}
+	for _, kv := range entries {
+		if len(kv.Key) == 1 && kv.Key[0] == globalUnsignedTx {
+			if p.UnsignedTx, err = parseTx(kv.Value); err != nil {
+				return nil, fmt.Errorf("unsigned transaction: %w", err)
+			}
+			continue
+		}
+		p.Unknown = append(p.Unknown, kv)
+	}
+	if p.UnsignedTx == nil {
+		return nil, fmt.Errorf("PSBT has no unsigned transaction")
+	}
+	for _, input := range p.UnsignedTx.Inputs {
+		if len(input.SignatureScript) > 0 || len(input.Witness) > 0 {
+			return nil, ErrSignedTx
+		}
+	}
+
+	p.Inputs = make([]Input, len(p.UnsignedTx.Inputs))
+	for i := range p.Inputs {
+		entries, err := readMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		if err := p.Inputs[i].parse(entries); err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	p.Outputs = make([]Output, len(p.UnsignedTx.Outputs))
+	for i := range p.Outputs {
+		entries, err := readMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("output %d: %w", i, err)
+		}
+		p.Outputs[i].parse(entries)
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after PSBT", r.Len())
+	}
+	return p, nil
+}
+
+// B64Decode parses a base64 PSBT
+func B64Decode(encoded string) (*Packet, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	return Parse(data)
+}
+
+// serialize writes the input's map
+func (in *Input) serialize(buf *bytes.Buffer) error {
+	if in.NonWitnessUTXO != nil {
+		tx, err := serialization.SerializeTransactionWitness(in.NonWitnessUTXO)
+		if err != nil {
+			return err
+		}
+		writeEntry(buf, []byte{inputNonWitnessUTXO}, tx)
+	}
+	if in.WitnessUTXO != nil {
+		writeEntry(buf, []byte{inputWitnessUTXO}, serializeOutput(in.WitnessUTXO))
+	}
+	for _, pubKey := range sortedKeys(in.PartialSigs) {
+		key, err := hex.DecodeString(pubKey)
+		if err != nil {
+			return fmt.Errorf("partial signature key %q: %w", pubKey, err)
+		}
+		writeEntry(buf, append([]byte{inputPartialSig}, key...), in.PartialSigs[pubKey])
+	}
+	if in.SighashType != 0 {
+		var value bytes.Buffer
+		serialization.WriteUint32(&value, in.SighashType)
+		writeEntry(buf, []byte{inputSighashType}, value.Bytes())
+	}
+	if in.RedeemScript != nil {
+		writeEntry(buf, []byte{inputRedeemScript}, in.RedeemScript)
+	}
+	if in.WitnessScript != nil {
+		writeEntry(buf, []byte{inputWitnessScript}, in.WitnessScript)
+	}
+	if in.FinalScriptSig != nil {
+		writeEntry(buf, []byte{inputFinalScriptSig}, in.FinalScriptSig)
+	}
+	if in.FinalScriptWitness != nil {
+		var value bytes.Buffer
+		serialization.WriteVarInt(&value, uint64(len(in.FinalScriptWitness)))
+		for _, item := range in.FinalScriptWitness {
+			serialization.WriteBytes(&value, item)
+		}
+		writeEntry(buf, []byte{inputFinalScriptWitness}, value.Bytes())
+	}
+	writeUnknown(buf, in.Unknown)
+	buf.WriteByte(0x00)
+	return nil
+}
+
+// parse fills the input from its map entries
+func (in *Input) parse(entries []KeyValue) error {
+	var err error
+	for _, kv := range entries {
+		keyData := kv.Key[1:]
+		switch kv.Key[0] {
+		case inputNonWitnessUTXO:
+			if len(keyData) != 0 {
+				break
+			}
+			if in.NonWitnessUTXO, err = parseTx(kv.Value); err != nil {
+				return fmt.Errorf("non-witness UTXO: %w", err)
+			}
+			continue
+		case inputWitnessUTXO:
+			if len(keyData) != 0 {
+				break
+			}
+			if in.WitnessUTXO, err = parseOutput(kv.Value); err != nil {
+				return fmt.Errorf("witness UTXO: %w", err)
+			}
+			continue
+		case inputPartialSig:
+			if len(keyData) != 33 && len(keyData) != 65 {
+				return fmt.Errorf("partial signature key of %d bytes", len(keyData))
+			}
+			if in.PartialSigs == nil {
+				in.PartialSigs = make(map[string][]byte)
+			}
+			in.PartialSigs[hex.EncodeToString(keyData)] = kv.Value
+			continue
+		case inputSighashType:
+			if len(keyData) != 0 {
+				break
+			}
+			if len(kv.Value) != 4 {
+				return fmt.Errorf("sighash type of %d bytes", len(kv.Value))
+			}
+			in.SighashType, _ = serialization.ReadUint32(bytes.NewReader(kv.Value))
+			continue
+		case inputRedeemScript:
+			if len(keyData) == 0 {
+				in.RedeemScript = kv.Value
+				continue
+			}
+		case inputWitnessScript:
+			if len(keyData) == 0 {
+				in.WitnessScript = kv.Value
+				continue
+			}
+		case inputFinalScriptSig:
+			if len(keyData) == 0 {
+				in.FinalScriptSig = kv.Value
+				continue
+			}
+		case inputFinalScriptWitness:
+			if len(keyData) != 0 {
+				break
+			}
+			if in.FinalScriptWitness, err = parseWitness(kv.Value); err != nil {
+				return fmt.Errorf("final witness: %w", err)
+			}
+			continue
+		}
+		in.Unknown = append(in.Unknown, kv)
+	}
+	return nil
+}
+
+// serialize writes the output's map
+func (out *Output) serialize(buf *bytes.Buffer) {
+	if out.RedeemScript != nil {
+		writeEntry(buf, []byte{outputRedeemScript}, out.RedeemScript)
+	}
+	if out.WitnessScript != nil {
+		writeEntry(buf, []byte{outputWitnessScript}, out.WitnessScript)
+	}
+	writeUnknown(buf, out.Unknown)
+	buf.WriteByte(0x00)
+}
+
+// parse fills the output from its map entries
+func (out *Output) parse(entries []KeyValue) {
+	for _, kv := range entries {
+		switch {
+		case len(kv.Key) == 1 && kv.Key[0] == outputRedeemScript:
+			out.RedeemScript = kv.Value
+		case len(kv.Key) == 1 && kv.Key[0] == outputWitnessScript:
+			out.WitnessScript = kv.Value
+		default:
+			out.Unknown = append(out.Unknown, kv)
+		}
+	}
+}
+
+// writeEntry writes one key-value pair. Writes to a bytes.Buffer can't
+// fail.
+func writeEntry(buf *bytes.Buffer, key, value []byte) {
+	serialization.WriteBytes(buf, key)
+	serialization.WriteBytes(buf, value)
+}
+
+// writeUnknown writes entries kept from parsing
+func writeUnknown(buf *bytes.Buffer, entries []KeyValue) {
+	for _, kv := range entries {
+		writeEntry(buf, kv.Key, kv.Value)
+	}
+}
+
+// readMap reads key-value pairs up to the 0x00 separator
+func readMap(r *bytes.Reader) ([]KeyValue, error) {
+	var entries []KeyValue
+	seen := make(map[string]bool)
+	for {
+		key, err := serialization.ReadBytes(r)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if len(key) == 0 {
+			return entries, nil
+		}
+		if seen[string(key)] {
+			return nil, fmt.Errorf("%w: %x", ErrDuplicateKey, key)
+		}
+		seen[string(key)] = true
+
+		value, err := serialization.ReadBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, KeyValue{Key: key, Value: value})
+	}
+}
+
+// parseTx decodes a transaction that must fill the whole value
+func parseTx(data []byte) (*types.Transaction, error) {
+	r := bytes.NewReader(data)
+	tx, err := serialization.DeserializeTransaction(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after transaction", r.Len())
+	}
+	return tx, nil
+}
+
+// serializeOutput encodes an output as it appears in a transaction
+func serializeOutput(out *types.TxOutput) []byte {
+	var buf bytes.Buffer
+	serialization.WriteUint64(&buf, uint64(out.Value))
+	serialization.WriteBytes(&buf, out.PubKeyScript)
+	return buf.Bytes()
+}
+
+// parseOutput decodes an output that must fill the whole value
+func parseOutput(data []byte) (*types.TxOutput, error) {
+	r := bytes.NewReader(data)
+	value, err := serialization.ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	pkScript, err := serialization.ReadBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after output", r.Len())
+	}
+	return &types.TxOutput{Value: int64(value), PubKeyScript: pkScript}, nil
+}
+
+// parseWitness decodes a witness stack that must fill the whole value
+func parseWitness(data []byte) ([][]byte, error) {
+	r := bytes.NewReader(data)
+	count, err := serialization.ReadCount(r)
+	if err != nil {
+		return nil, err
+	}
+	stack := make([][]byte, 0, serialization.PreallocCount(count))
+	for i := uint64(0); i < count; i++ {
+		item, err := serialization.ReadBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, item)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after witness", r.Len())
+	}
+	return stack, nil
+}
@@ -0,0 +1,369 @@
+package psbt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"golang.org/x/crypto/ripemd160"
+)
+
+var (
+	ErrMissingUTXO        = errors.New("input has no UTXO to sign against")
+	ErrKeyNotInScript     = errors.New("key is not one of the script's keys")
+	ErrUnsupportedScript  = errors.New("script type not supported")
+	ErrNotEnoughSigs      = errors.New("not enough signatures to finalize")
+	ErrIncomplete         = errors.New("PSBT has unfinalized inputs")
+	ErrInputAlreadyFinal  = errors.New("input is already finalized")
+	ErrInputIndexTooLarge = errors.New("input index out of range")
+)
+
+// AddUTXO records prevTx, the transaction spent by input inputIdx, with
+// redeemScript if the spent output is P2SH
+func (p *Packet) AddUTXO(inputIdx int, prevTx *types.Transaction, redeemScript []byte) error {
+	if inputIdx < 0 || inputIdx >= len(p.Inputs) {
+		return ErrInputIndexTooLarge
+	}
+	hash, err := serialization.HashTransaction(prevTx)
+	if err != nil {
+		return err
+	}
+	outpoint := p.UnsignedTx.Inputs[inputIdx]
+	if hash != outpoint.PrevTxHash {
+		return fmt.Errorf("transaction %s is not the one input %d spends", hash, inputIdx)
+	}
+	if int(outpoint.OutputIndex) >= len(prevTx.Outputs) {
+		return fmt.Errorf("transaction %s has no output %d", hash, outpoint.OutputIndex)
+	}
+
+	p.Inputs[inputIdx].NonWitnessUTXO = prevTx
+	p.Inputs[inputIdx].RedeemScript = redeemScript
+	return nil
+}
+
+// AddWitnessUTXO records prevOut, the segwit output spent by input
+// inputIdx, with witnessScript if it is P2WSH
+func (p *Packet) AddWitnessUTXO(inputIdx int, prevOut types.TxOutput, witnessScript []byte) error {
+	if inputIdx < 0 || inputIdx >= len(p.Inputs) {
+		return ErrInputIndexTooLarge
+	}
+	if _, _, ok := script.ExtractWitnessProgram(prevOut.PubKeyScript); !ok {
+		return fmt.Errorf("output is not a witness program")
+	}
+
+	p.Inputs[inputIdx].WitnessUTXO = &prevOut
+	p.Inputs[inputIdx].WitnessScript = witnessScript
+	return nil
+}
+
+// Sign adds key's signature for input inputIdx. The key must be one the
+// script being spent checks, and the input can't be finalized yet.
+func (p *Packet) Sign(inputIdx int, key *keys.PrivateKey) error {
+	if inputIdx < 0 || inputIdx >= len(p.Inputs) {
+		return ErrInputIndexTooLarge
+	}
+	in := &p.Inputs[inputIdx]
+	if in.isFinal() {
+		return ErrInputAlreadyFinal
+	}
+	spent, err := p.spentScript(inputIdx)
+	if err != nil {
+		return err
+	}
+
+	pubKey := key.PublicKey().Bytes(true)
+	if !containsKey(spent.script, pubKey) {
+		return ErrKeyNotInScript
+	}
+
+	hashType := transaction.SigHashAll
+	if in.SighashType != 0 {
+		hashType = transaction.SigHashType(in.SighashType)
+	}
+	var sig []byte
+	if spent.witness {
+		sig, err = transaction.SignWitness(p.UnsignedTx, inputIdx, key, spent.script, spent.amount, hashType)
+	} else {
+		sig, err = transaction.Sign(p.UnsignedTx, inputIdx, key, spent.script, hashType)
+	}
+	if err != nil {
+		return err
+	}
+
+	if in.PartialSigs == nil {
+		in.PartialSigs = make(map[string][]byte)
+	}
+	in.PartialSigs[hex.EncodeToString(pubKey)] = sig
+	return nil
+}
+
+// Combine merges what other copies of the same PSBT know into p, as when
+// each signer has signed its own copy
+func (p *Packet) Combine(others ...*Packet) error {
+	txHash, err := serialization.HashTransaction(p.UnsignedTx)
+	if err != nil {
+		return err
+	}
+
+	for _, other := range others {
+		otherHash, err := serialization.HashTransaction(other.UnsignedTx)
+		if err != nil {
+			return err
+		}
+		if otherHash != txHash {
+			return fmt.Errorf("%w: %s and %s", ErrMismatchedPSBT, txHash, otherHash)
+		}
+
+		for i := range p.Inputs {
+			p.Inputs[i].merge(&other.Inputs[i])
+		}
+		for i := range p.Outputs {
+			out, o := &p.Outputs[i], &other.Outputs[i]
+			if out.RedeemScript == nil {
+				out.RedeemScript = o.RedeemScript
+			}
+			if out.WitnessScript == nil {
+				out.WitnessScript = o.WitnessScript
+			}
+		}
+	}
+	return nil
+}
+
+// Finalize builds the scriptSig of every input that isn't final yet from
+// its partial signatures and drops the signing data, as BIP174's
+// finalizer does. Only multisig scripts, bare, in P2SH or in P2WSH, are
+// supported.
+func (p *Packet) Finalize() error {
+	for i := range p.Inputs {
+		if p.Inputs[i].isFinal() {
+			continue
+		}
+		if err := p.finalizeInput(i); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// IsComplete reports whether every input is finalized
+func (p *Packet) IsComplete() bool {
+	for i := range p.Inputs {
+		if !p.Inputs[i].isFinal() {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract returns the network transaction of a finalized PSBT
+func (p *Packet) Extract() (*types.Transaction, error) {
+	if !p.IsComplete() {
+		return nil, ErrIncomplete
+	}
+
+	tx := *p.UnsignedTx
+	tx.Inputs = append([]types.TxInput(nil), p.UnsignedTx.Inputs...)
+	tx.Outputs = append([]types.TxOutput(nil), p.UnsignedTx.Outputs...)
+	for i := range tx.Inputs {
+		tx.Inputs[i].SignatureScript = p.Inputs[i].FinalScriptSig
+		tx.Inputs[i].Witness = p.Inputs[i].FinalScriptWitness
+	}
+	return &tx, nil
+}
+
+// finalizeInput orders an input's multisig signatures by key position and
+// sets its final scriptSig, or its witness for P2WSH
+func (p *Packet) finalizeInput(inputIdx int) error {
+	in := &p.Inputs[inputIdx]
+	spent, err := p.spentScript(inputIdx)
+	if err != nil {
+		return err
+	}
+	if script.ClassifyScript(spent.script) != script.MultiSigTy {
+		return fmt.Errorf("%w: %s", ErrUnsupportedScript, script.ClassifyScript(spent.script))
+	}
+
+	m := int(spent.script[0] - script.OP_1 + 1)
+	var sigs [][]byte
+	for _, pubKey := range multiSigKeys(spent.script) {
+		if sig, ok := in.PartialSigs[hex.EncodeToString(pubKey)]; ok && len(sigs) < m {
+			sigs = append(sigs, sig)
+		}
+	}
+	if len(sigs) < m {
+		return fmt.Errorf("%w: have %d of %d", ErrNotEnoughSigs, len(sigs), m)
+	}
+
+	if spent.witness {
+		in.FinalScriptWitness = contracts.MultiSigWitness(spent.script, sigs...)
+	} else {
+		sigScript := contracts.MultiSigSpend(sigs...)
+		if in.RedeemScript != nil {
+			sigScript = append(sigScript, script.NewBuilder().AddData(in.RedeemScript).Script()...)
+		}
+		in.FinalScriptSig = sigScript
+	}
+	in.PartialSigs = nil
+	in.SighashType = 0
+	in.RedeemScript = nil
+	in.WitnessScript = nil
+	return nil
+}
+
+// spentOutput is what an input's signatures commit to
+type spentOutput struct {
+	script  []byte // Redeem or witness script, or the output's own script
+	witness bool   // Signed with the BIP143 hash
+	amount  int64
+}
+
+// spentScript finds the script an input's signatures commit to: the
+// redeem script of a P2SH output, the witness script of a P2WSH output,
+// the P2PKH template of a P2WPKH output, otherwise the output's own
+// script
+func (p *Packet) spentScript(inputIdx int) (*spentOutput, error) {
+	in := &p.Inputs[inputIdx]
+	var prevOut types.TxOutput
+	switch {
+	case in.NonWitnessUTXO != nil:
+		index := p.UnsignedTx.Inputs[inputIdx].OutputIndex
+		if int(index) >= len(in.NonWitnessUTXO.Outputs) {
+			return nil, fmt.Errorf("UTXO has no output %d", index)
+		}
+		prevOut = in.NonWitnessUTXO.Outputs[index]
+	case in.WitnessUTXO != nil:
+		prevOut = *in.WitnessUTXO
+	default:
+		return nil, ErrMissingUTXO
+	}
+
+	pkScript := prevOut.PubKeyScript
+	version, program, ok := script.ExtractWitnessProgram(pkScript)
+	switch {
+	case ok && version == 0 && len(program) == script.WitnessV0ScriptHashSize:
+		if in.WitnessScript == nil {
+			return nil, fmt.Errorf("P2WSH input has no witness script")
+		}
+		hash := sha256.Sum256(in.WitnessScript)
+		if !bytes.Equal(hash[:], program) {
+			return nil, fmt.Errorf("witness script does not match the P2WSH output")
+		}
+		return &spentOutput{script: in.WitnessScript, witness: true, amount: prevOut.Value}, nil
+
+	case ok && version == 0 && len(program) == script.WitnessV0KeyHashSize:
+		keyHash, err := script.P2PKH(program)
+		if err != nil {
+			return nil, err
+		}
+		return &spentOutput{script: keyHash, witness: true, amount: prevOut.Value}, nil
+
+	case ok:
+		return nil, fmt.Errorf("%w: witness version %d", ErrUnsupportedScript, version)
+
+	case script.IsP2SH(pkScript):
+		if in.RedeemScript == nil {
+			return nil, fmt.Errorf("P2SH input has no redeem script")
+		}
+		if !bytes.Equal(script.ExtractScriptHash(pkScript), hash160(in.RedeemScript)) {
+			return nil, fmt.Errorf("redeem script does not match the P2SH output")
+		}
+		return &spentOutput{script: in.RedeemScript, amount: prevOut.Value}, nil
+	}
+	return &spentOutput{script: pkScript, amount: prevOut.Value}, nil
+}
+
+// isFinal reports whether the input's scriptSig or witness has been built
+func (in *Input) isFinal() bool {
+	return in.FinalScriptSig != nil || in.FinalScriptWitness != nil
+}
+
+// merge copies what other knows about the input and in doesn't
+func (in *Input) merge(other *Input) {
+	if in.isFinal() {
+		return
+	}
+	if other.isFinal() {
+		*in = *other
+		return
+	}
+
+	if in.NonWitnessUTXO == nil {
+		in.NonWitnessUTXO = other.NonWitnessUTXO
+	}
+	if in.WitnessUTXO == nil {
+		in.WitnessUTXO = other.WitnessUTXO
+	}
+	if in.SighashType == 0 {
+		in.SighashType = other.SighashType
+	}
+	if in.RedeemScript == nil {
+		in.RedeemScript = other.RedeemScript
+	}
+	if in.WitnessScript == nil {
+		in.WitnessScript = other.WitnessScript
+	}
+	for pubKey, sig := range other.PartialSigs {
+		if in.PartialSigs == nil {
+			in.PartialSigs = make(map[string][]byte)
+		}
+		if _, ok := in.PartialSigs[pubKey]; !ok {
+			in.PartialSigs[pubKey] = sig
+		}
+	}
+}
+
+// multiSigKeys returns the public keys of a multisig script in order
+func multiSigKeys(multiSig []byte) [][]byte {
+	pushes, err := script.Pushes(multiSig[:len(multiSig)-1])
+	if err != nil || len(pushes) < 3 {
+		return nil
+	}
+	return pushes[1 : len(pushes)-1]
+}
+
+// containsKey reports whether pubKey is checked by signScript, either as
+// a multisig key or as the key or key hash of a single-key script
+func containsKey(signScript, pubKey []byte) bool {
+	switch script.ClassifyScript(signScript) {
+	case script.MultiSigTy:
+		for _, key := range multiSigKeys(signScript) {
+			if bytes.Equal(key, pubKey) {
+				return true
+			}
+		}
+	case script.PubKeyHashTy:
+		return bytes.Equal(script.ExtractScriptHash(signScript), hash160(pubKey))
+	case script.PubKeyTy:
+		return bytes.Equal(signScript[1:len(signScript)-1], pubKey)
+	}
+	return false
+}
+
+// hash160 returns RIPEMD160(SHA256(data)), what P2SH and P2PKH commit to
+func hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
+	ripe := ripemd160.New()
+	ripe.Write(sha[:])
+	return ripe.Sum(nil)
+}
+
+// sortedKeys returns a map's keys in order, so serialization is
+// deterministic
+func sortedKeys(m map[string][]byte) []string {
+	sorted := make([]string, 0, len(m))
+	for key := range m {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
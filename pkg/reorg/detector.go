@@ -103,6 +103,9 @@ func (rd *ReorgDetector) findForkPoint(newBlocks []*types.Block) (uint64, error)
 		}
 		return 0, fmt.Errorf("fork point not found: %w", err)
 	}
+	if onMain, err := rd.blockchain.IsMainChain(prevHash); err != nil || !onMain {
+		return 0, fmt.Errorf("fork point %s is not on the best chain", prevHash)
+	}
 
 	return height, nil
 }
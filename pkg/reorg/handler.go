@@ -3,21 +3,37 @@ package reorg
 import (
 	"fmt"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
-	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
 )
 
-// ReorgHandler handles blockchain reorganizations
+// DefaultMaxReorgDepth is the deepest reorganization accepted by default
+const DefaultMaxReorgDepth = validation.DefaultMaxReorgDepth
+
+// ErrReorgTooDeep is returned when a better chain forks off further back
+// than the handler's maximum reorg depth
+var ErrReorgTooDeep = validation.ErrReorgTooDeep
+
+// DeepReorgAlert describes a reorganization refused for being too deep
+type DeepReorgAlert = validation.DeepReorgAlert
+
+// ChainListener is told about every block the handler connects to or
+// disconnects from the best chain. Disconnections arrive tip first.
+type ChainListener = validation.ChainListener
+
+// ReorgHandler detects when a set of blocks forms a better chain and
+// switches to it through the same validation.Reorganizer a node uses
 type ReorgHandler struct {
 	blockchain *storage.BlockchainStorage
 	utxoSet    *utxo.UTXOSet
-	mempool    *mempool.Mempool
-	validator  *validation.BlockValidator
 	detector   *ReorgDetector
+	reorg      *validation.Reorganizer
+	rules      *consensus.ConsensusRules // Every block validated is checked against these
 }
 
 // NewReorgHandler creates a new reorganization handler
@@ -26,13 +42,53 @@ func NewReorgHandler(
 	utxoSet *utxo.UTXOSet,
 	mp *mempool.Mempool,
 ) *ReorgHandler {
+	reorg := validation.NewReorganizer(blockchain)
+	reorg.SetMempool(mp)
+
 	return &ReorgHandler{
 		blockchain: blockchain,
 		utxoSet:    utxoSet,
-		mempool:    mp,
-		validator:  validation.NewBlockValidator(utxoSet),
 		detector:   NewReorgDetector(blockchain),
+		reorg:      reorg,
+		rules:      consensus.NewMainnetRules(),
+	}
+}
+
+// SetRules sets the consensus rules branches are validated against. Nil
+// means mainnet's.
+func (rh *ReorgHandler) SetRules(rules *consensus.ConsensusRules) {
+	if rules == nil {
+		rules = consensus.NewMainnetRules()
 	}
+	rh.rules = rules
+	rh.reorg.SetRules(rules)
+}
+
+// Rules returns the consensus rules branches are validated against
+func (rh *ReorgHandler) Rules() *consensus.ConsensusRules {
+	return rh.rules
+}
+
+// SetMaxReorgDepth sets the deepest reorganization the handler performs.
+// Zero removes the limit.
+func (rh *ReorgHandler) SetMaxReorgDepth(depth uint64) {
+	rh.reorg.SetMaxReorgDepth(depth)
+}
+
+// SetMetrics records completed and refused reorganizations in m
+func (rh *ReorgHandler) SetMetrics(m *monitoring.Metrics) {
+	rh.reorg.SetMetrics(m)
+}
+
+// OnDeepReorg registers fn to be called whenever a reorganization is
+// refused for exceeding the maximum depth
+func (rh *ReorgHandler) OnDeepReorg(fn func(DeepReorgAlert)) {
+	rh.reorg.OnDeepReorg(fn)
+}
+
+// Subscribe registers l for block connected and disconnected events
+func (rh *ReorgHandler) Subscribe(l ChainListener) {
+	rh.reorg.Subscribe(l)
 }
 
 // HandleReorg performs a blockchain reorganization
@@ -50,175 +106,21 @@ func (rh *ReorgHandler) HandleReorg(newBlocks []*types.Block) error {
 	fmt.Printf("Starting reorganization: fork at height %d, new chain height %d\n",
 		chainInfo.ForkHeight, chainInfo.Height)
 
-	// Step 1: Disconnect blocks from old chain
-	orphanedTxs, err := rh.disconnectBlocks(chainInfo.ForkHeight)
+	disconnected, err := rh.reorg.Reorganize(rh.utxoSet, newBlocks, chainInfo.ForkHeight)
 	if err != nil {
-		return fmt.Errorf("failed to disconnect blocks: %w", err)
-	}
-
-	fmt.Printf("Disconnected %d blocks, recovered %d transactions\n",
-		len(orphanedTxs), countTransactions(orphanedTxs))
-
-	// Step 2: Connect blocks from new chain
-	if err := rh.connectBlocks(newBlocks, chainInfo.ForkHeight); err != nil {
-		// Attempt to rollback
-		fmt.Printf("Failed to connect new blocks, attempting rollback...\n")
-		// In production, we'd try to restore the old chain here
-		return fmt.Errorf("failed to connect new blocks: %w", err)
-	}
-
-	fmt.Printf("Connected %d new blocks\n", len(newBlocks))
-
-	// Step 3: Return orphaned transactions to mempool
-	if err := rh.returnOrphanedTransactions(orphanedTxs, newBlocks); err != nil {
-		// This is not critical, just log it
-		fmt.Printf("Warning: failed to return some orphaned transactions: %v\n", err)
+		return err
 	}
 
-	fmt.Printf("Reorganization completed successfully\n")
+	fmt.Printf("Disconnected %d blocks (%d transactions), connected %d new blocks\n",
+		len(disconnected), countTransactions(disconnected), len(newBlocks))
 	return nil
 }
 
-// disconnectBlocks removes blocks from the current chain back to fork point
-func (rh *ReorgHandler) disconnectBlocks(forkHeight uint64) ([]*types.Block, error) {
-	currentHeight, err := rh.blockchain.GetBestBlockHeight()
-	if err != nil {
-		return nil, err
-	}
-
-	var disconnectedBlocks []*types.Block
-
-	// Disconnect blocks in reverse order (from tip to fork)
-	for h := currentHeight; h > forkHeight; h-- {
-		block, err := rh.blockchain.GetBlockByHeight(h)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get block at height %d: %w", h, err)
-		}
-
-		// Revert UTXO changes
-		if err := rh.revertBlock(block, h); err != nil {
-			return nil, fmt.Errorf("failed to revert block at height %d: %w", h, err)
-		}
-
-		disconnectedBlocks = append(disconnectedBlocks, block)
-	}
-
-	// Update chain state to fork point
-	if err := rh.updateChainState(forkHeight); err != nil {
-		return nil, fmt.Errorf("failed to update chain state: %w", err)
-	}
-
-	return disconnectedBlocks, nil
-}
-
-// connectBlocks adds new blocks to the chain
+// connectBlocks validates blocks on top of the block at startHeight and
+// extends the chain with them, all or nothing
 func (rh *ReorgHandler) connectBlocks(blocks []*types.Block, startHeight uint64) error {
-	for i, block := range blocks {
-		height := startHeight + uint64(i) + 1
-
-		// Validate block
-		var prevHash types.Hash
-		if height > 0 {
-			prevBlock, err := rh.blockchain.GetBlockByHeight(height - 1)
-			if err != nil {
-				return fmt.Errorf("failed to get previous block: %w", err)
-			}
-			prevHash, _ = serialization.HashBlockHeader(&prevBlock.Header)
-		}
-
-		if err := rh.validator.ValidateBlock(block, height, prevHash); err != nil {
-			return fmt.Errorf("block validation failed at height %d: %w", height, err)
-		}
-
-		// Apply to UTXO set
-		if err := rh.validator.ApplyBlock(block, height); err != nil {
-			return fmt.Errorf("failed to apply block at height %d: %w", height, err)
-		}
-
-		// Save block
-		if err := rh.blockchain.SaveBlock(block, height); err != nil {
-			return fmt.Errorf("failed to save block at height %d: %w", height, err)
-		}
-	}
-
-	return nil
-}
-
-// revertBlock reverts UTXO changes from a block
-func (rh *ReorgHandler) revertBlock(block *types.Block, height uint64) error {
-	// Remove outputs created by this block
-	for _, tx := range block.Transactions {
-		txHash, err := serialization.HashTransaction(&tx)
-		if err != nil {
-			return err
-		}
-
-		// Remove all outputs
-		for i := range tx.Outputs {
-			outpoint := utxo.NewOutPoint(txHash, uint32(i))
-			rh.utxoSet.Remove(outpoint)
-		}
-	}
-
-	// Restore inputs (except coinbase)
-	for txIdx, tx := range block.Transactions {
-		if txIdx == 0 {
-			continue // Skip coinbase
-		}
-
-		for _, input := range tx.Inputs {
-			// We need to restore the spent UTXO
-			// In a real implementation, we'd need to store the previous UTXO data
-			// For now, we'll skip this (simplified version)
-			_ = input
-		}
-	}
-
-	return nil
-}
-
-// returnOrphanedTransactions adds orphaned transactions back to mempool
-func (rh *ReorgHandler) returnOrphanedTransactions(orphanedBlocks []*types.Block, newBlocks []*types.Block) error {
-	// Build set of transactions in new chain
-	newChainTxs := make(map[types.Hash]bool)
-	for _, block := range newBlocks {
-		for _, tx := range block.Transactions {
-			txHash, _ := serialization.HashTransaction(&tx)
-			newChainTxs[txHash] = true
-		}
-	}
-
-	// Add orphaned transactions that aren't in new chain
-	for _, block := range orphanedBlocks {
-		for txIdx, tx := range block.Transactions {
-			if txIdx == 0 {
-				continue // Skip coinbase
-			}
-
-			txHash, _ := serialization.HashTransaction(&tx)
-			if !newChainTxs[txHash] {
-				// Try to add back to mempool
-				if err := rh.mempool.Add(&tx, 0, 0); err != nil {
-					// Log but don't fail - transaction might be invalid now
-					fmt.Printf("Could not return tx %s to mempool: %v\n", txHash, err)
-				}
-			}
-		}
-	}
-
-	return nil
-}
-
-// updateChainState updates the chain state to a specific height
-func (rh *ReorgHandler) updateChainState(height uint64) error {
-	block, err := rh.blockchain.GetBlockByHeight(height)
-	if err != nil {
-		return err
-	}
-
-	// Update best block (this would need to be implemented in storage)
-	// For now, we'll just save the block again to update the chain state
-	return rh.blockchain.SaveBlock(block, height)
+	_, err := rh.reorg.Reorganize(rh.utxoSet, blocks, startHeight)
+	return err
 }
 
 // countTransactions counts total transactions in blocks
@@ -0,0 +1,591 @@
+package reorg
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+const (
+	// SimBits is the difficulty of simulated blocks. Every block has the
+	// same work, so the longer branch always wins.
+	SimBits = 0x207fffff
+
+	// simFeeRate is the fee rate of simulated spends in sat/kvB
+	simFeeRate = 2000
+
+	// simMinSpend skips outputs too small to split again
+	simMinSpend = 10000
+
+	// simGenesisTime is the timestamp of the simulator's genesis block
+	simGenesisTime = 1296688602
+)
+
+// Simulator builds competing branches against a chain, reorganizes onto
+// them with a ReorgHandler and checks that the chain, UTXO set, mempool
+// and wallet agree afterwards. All coins belong to a single wallet key.
+type Simulator struct {
+	Chain   *storage.BlockchainStorage
+	UTXOs   *utxo.UTXOSet
+	Mempool *mempool.Mempool
+	Handler *ReorgHandler
+	Wallet  *wallet.Wallet
+
+	key     *keys.PrivateKey
+	address string
+	rng     *rand.Rand
+	blocks  uint64 // Blocks built so far, keeps coinbases of rival blocks unique
+}
+
+// NewSimulator prepares a simulator on chain under mainnet rules. An
+// empty chain gets a fresh genesis block; otherwise the UTXO set is
+// rebuilt from the stored blocks.
+func NewSimulator(chain *storage.BlockchainStorage, seed int64) (*Simulator, error) {
+	return NewSimulatorWithRules(chain, seed, nil)
+}
+
+// NewSimulatorWithRules prepares a simulator whose blocks follow rules,
+// which the handler validates them against. Nil means mainnet's.
+func NewSimulatorWithRules(chain *storage.BlockchainStorage, seed int64, rules *consensus.ConsensusRules) (*Simulator, error) {
+	w := wallet.NewWallet()
+	address, err := w.GenerateAddress()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	key, _ := w.GetKey(address)
+
+	utxoSet := utxo.NewUTXOSet()
+	mp := mempool.NewMempool(300*1024*1024, 1000, 14*24*60*60)
+
+	s := &Simulator{
+		Chain:   chain,
+		UTXOs:   utxoSet,
+		Mempool: mp,
+		Handler: NewReorgHandler(chain, utxoSet, mp),
+		Wallet:  w,
+		key:     key,
+		address: address,
+		rng:     rand.New(rand.NewSource(seed)),
+	}
+	s.Handler.SetRules(rules)
+	s.Handler.Subscribe(w)
+
+	empty, err := chain.IsEmpty()
+	if err != nil {
+		return nil, err
+	}
+	if empty {
+		genesis, err := s.buildBlock(utxoSet, types.Hash{}, simGenesisTime, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := chain.SaveBlock(genesis, 0); err != nil {
+			return nil, fmt.Errorf("failed to save genesis: %w", err)
+		}
+		w.BlockConnected(genesis, 0)
+	}
+
+	tip, err := chain.GetBestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+	if err := replayChain(chain, s.Handler.Rules(), utxoSet, tip); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Mine extends the best chain by count blocks. Each block confirms the
+// mempool transactions whose inputs are already confirmed.
+func (s *Simulator) Mine(count int) ([]*types.Block, error) {
+	blocks := make([]*types.Block, 0, count)
+
+	for i := 0; i < count; i++ {
+		tip, height, err := s.Chain.GetBestBlock()
+		if err != nil {
+			return blocks, err
+		}
+		tipHash, err := serialization.HashBlockHeader(&tip.Header)
+		if err != nil {
+			return blocks, err
+		}
+
+		var txs []*types.Transaction
+		for _, entry := range s.Mempool.GetAllTransactions() {
+			if spendable(s.UTXOs, entry.Tx) {
+				txs = append(txs, entry.Tx)
+			}
+		}
+
+		block, err := s.buildBlock(s.UTXOs, tipHash, tip.Header.Timestamp+1, height+1, txs)
+		if err != nil {
+			return blocks, err
+		}
+		if err := s.Handler.connectBlocks([]*types.Block{block}, height); err != nil {
+			return blocks, err
+		}
+		s.Mempool.RemoveConfirmed(block.Transactions)
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// Spend adds up to count new transactions to the mempool, each splitting
+// a random confirmed output that the mempool doesn't spend yet
+func (s *Simulator) Spend(count int) ([]*types.Transaction, error) {
+	spentInMempool := make(map[utxo.OutPoint]bool)
+	for _, entry := range s.Mempool.GetAllTransactions() {
+		for _, input := range entry.Tx.Inputs {
+			spentInMempool[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)] = true
+		}
+	}
+
+	var candidates []*utxo.UTXO
+	for _, coin := range sortedUTXOs(s.UTXOs) {
+		if !spentInMempool[coin.OutPoint()] && coin.Value() >= simMinSpend {
+			candidates = append(candidates, coin)
+		}
+	}
+	s.rng.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	height, err := s.Chain.GetBestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	// Each spend is checked in a view of the UTXO set, which the
+	// spends before it have already been applied to
+	pool := validation.NewBlockValidator(utxo.NewUTXOView(s.UTXOs))
+	pool.SetRules(s.Handler.Rules())
+	var txs []*types.Transaction
+	for _, coin := range candidates {
+		if len(txs) == count {
+			break
+		}
+
+		tx, err := s.split(coin)
+		if err != nil {
+			return txs, err
+		}
+		fee, err := pool.AcceptTransaction(tx, height+1)
+		if err != nil {
+			return txs, fmt.Errorf("simulated spend is invalid: %w", err)
+		}
+		if err := s.Mempool.Add(tx, fee, height); err != nil {
+			return txs, fmt.Errorf("mempool rejected simulated spend: %w", err)
+		}
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
+
+// BuildFork builds depth+extra blocks branching off depth blocks below the
+// tip, without connecting them. The branch spends coins at random, so it
+// reconfirms some transactions of the old branch and double-spends others.
+func (s *Simulator) BuildFork(depth, extra, txsPerBlock int) ([]*types.Block, error) {
+	tipHeight, err := s.Chain.GetBestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(depth) > tipHeight {
+		return nil, fmt.Errorf("fork depth %d exceeds chain height %d", depth, tipHeight)
+	}
+	forkHeight := tipHeight - uint64(depth)
+
+	// The UTXO set as of the fork point, in a view over the real one.
+	// The blocks being replaced are undone in it, and their transactions
+	// kept as candidates to reconfirm.
+	view := utxo.NewUTXOView(s.UTXOs)
+	validator := validation.NewBlockValidator(view)
+	validator.SetRules(s.Handler.Rules())
+	var oldTxs []*types.Transaction
+	for h := tipHeight; h > forkHeight; h-- {
+		block, err := s.Chain.GetBlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+		if err := validator.UndoBlock(block, s.Chain); err != nil {
+			return nil, fmt.Errorf("failed to undo block %d: %w", h, err)
+		}
+		var txs []*types.Transaction
+		for i := 1; i < len(block.Transactions); i++ {
+			txs = append(txs, &block.Transactions[i])
+		}
+		oldTxs = append(txs, oldTxs...)
+	}
+
+	prev, err := s.Chain.GetBlockByHeight(forkHeight)
+	if err != nil {
+		return nil, err
+	}
+	prevHash, err := serialization.HashBlockHeader(&prev.Header)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := prev.Header.Timestamp
+
+	blocks := make([]*types.Block, 0, depth+extra)
+	for i := 0; i < depth+extra; i++ {
+		height := forkHeight + uint64(i) + 1
+
+		var txs []*types.Transaction
+		spent := make(map[utxo.OutPoint]bool)
+		claim := func(tx *types.Transaction) bool {
+			for _, input := range tx.Inputs {
+				if spent[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)] {
+					return false
+				}
+			}
+			for _, input := range tx.Inputs {
+				spent[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)] = true
+			}
+			return true
+		}
+
+		for _, tx := range oldTxs {
+			if len(txs) < txsPerBlock && s.rng.Intn(2) == 0 && spendable(view, tx) && claim(tx) {
+				txs = append(txs, tx)
+			}
+		}
+
+		coins := sortedUTXOs(view)
+		for attempts := 0; len(txs) < txsPerBlock && attempts < 4*txsPerBlock && len(coins) > 0; attempts++ {
+			coin := coins[s.rng.Intn(len(coins))]
+			if coin.Value() < simMinSpend {
+				continue
+			}
+			tx, err := s.split(coin)
+			if err != nil {
+				return nil, err
+			}
+			if claim(tx) {
+				txs = append(txs, tx)
+			}
+		}
+
+		timestamp += 2 // Differs from the old branch's timestamps
+		block, err := s.buildBlock(view, prevHash, timestamp, height, txs)
+		if err != nil {
+			return nil, err
+		}
+		if err := validator.ApplyBlock(block, height); err != nil {
+			return nil, fmt.Errorf("simulated block %d is invalid: %w", height, err)
+		}
+
+		prevHash, _ = serialization.HashBlockHeader(&block.Header)
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// Reorg switches the chain to branch and checks the result with Verify.
+// It also checks that no transaction of the old branch was lost while
+// it could still be mined.
+func (s *Simulator) Reorg(branch []*types.Block) error {
+	if len(branch) == 0 {
+		return fmt.Errorf("empty branch")
+	}
+
+	forkHeight, err := s.Chain.GetBlockHeight(branch[0].Header.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("branch does not connect: %w", err)
+	}
+	oldBlocks, err := s.Handler.detector.GetForkBlocks(forkHeight)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Handler.HandleReorg(branch); err != nil {
+		return err
+	}
+
+	wantTip, _ := serialization.HashBlockHeader(&branch[len(branch)-1].Header)
+	tip, err := s.Chain.GetBestBlockHash()
+	if err != nil {
+		return err
+	}
+	if tip != wantTip {
+		return fmt.Errorf("tip is %s after reorg, want %s", tip, wantTip)
+	}
+
+	if err := s.Verify(); err != nil {
+		return err
+	}
+
+	for _, block := range oldBlocks {
+		for i := 1; i < len(block.Transactions); i++ {
+			tx := &block.Transactions[i]
+			txHash, _ := serialization.HashTransaction(tx)
+			if s.Mempool.Exists(txHash) || s.confirmed(txHash) {
+				continue
+			}
+			if s.canAccept(tx) {
+				return fmt.Errorf("transaction %s from the old branch was dropped but is still valid", txHash)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Verify checks that the height index forms a chain, the UTXO set matches
+// a replay of that chain, every mempool transaction is unconfirmed and
+// spends available outputs, and the wallet agrees with both
+func (s *Simulator) Verify() error {
+	tipHeight, err := s.Chain.GetBestBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	var prevHash types.Hash
+	for h := uint64(0); h <= tipHeight; h++ {
+		block, err := s.Chain.GetBlockByHeight(h)
+		if err != nil {
+			return fmt.Errorf("missing block at height %d: %w", h, err)
+		}
+		hash, _ := serialization.HashBlockHeader(&block.Header)
+		if h > 0 && block.Header.PrevBlockHash != prevHash {
+			return fmt.Errorf("block %d does not build on block %d", h, h-1)
+		}
+		if stored, err := s.Chain.GetBlockHeight(hash); err != nil || stored != h {
+			return fmt.Errorf("block %s indexed at height %d, want %d", hash, stored, h)
+		}
+		prevHash = hash
+	}
+
+	replayed := utxo.NewUTXOSet()
+	if err := replayChain(s.Chain, s.Handler.Rules(), replayed, tipHeight); err != nil {
+		return err
+	}
+	if err := compareUTXOSets(s.UTXOs, replayed); err != nil {
+		return err
+	}
+
+	for _, entry := range s.Mempool.GetAllTransactions() {
+		if s.confirmed(entry.TxHash) {
+			return fmt.Errorf("mempool transaction %s is already confirmed", entry.TxHash)
+		}
+		for _, input := range entry.Tx.Inputs {
+			if s.UTXOs.Exists(utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)) {
+				continue
+			}
+			if s.Mempool.Exists(input.PrevTxHash) {
+				continue
+			}
+			return fmt.Errorf("mempool transaction %s spends missing output %s:%d",
+				entry.TxHash, input.PrevTxHash, input.OutputIndex)
+		}
+	}
+
+	return s.verifyWallet()
+}
+
+// verifyWallet checks that the wallet's confirmed outputs are exactly the
+// UTXO set, its unconfirmed outputs belong to mempool transactions and its
+// conflicted outputs to transactions that are nowhere
+func (s *Simulator) verifyWallet() error {
+	confirmed := utxo.NewUTXOSet()
+	for _, coin := range s.Wallet.ListUTXOs() {
+		status, _ := s.Wallet.OutputStatus(coin.OutPoint())
+		switch status {
+		case wallet.StatusConfirmed:
+			if err := confirmed.Add(coin); err != nil {
+				return err
+			}
+		case wallet.StatusUnconfirmed:
+			if !s.Mempool.Exists(coin.TxHash) {
+				return fmt.Errorf("wallet output %s is unconfirmed but not in the mempool", coin.OutPoint())
+			}
+		case wallet.StatusConflicted:
+			if s.Mempool.Exists(coin.TxHash) || s.confirmed(coin.TxHash) {
+				return fmt.Errorf("wallet output %s is conflicted but its transaction is still live", coin.OutPoint())
+			}
+		}
+	}
+
+	if err := compareUTXOSets(confirmed, s.UTXOs); err != nil {
+		return fmt.Errorf("wallet: %w", err)
+	}
+	return nil
+}
+
+// split builds a signed transaction paying coin back to the simulator in
+// two parts, minus the fee. The parts are random so that splitting the same
+// coin twice gives conflicting transactions rather than the same one.
+func (s *Simulator) split(coin *utxo.UTXO) (*types.Transaction, error) {
+	fee := transaction.FeeAtRate(simFeeRate, int64(transaction.CalculateSize(1, 2)))
+	part := (coin.Value() - fee) * int64(25+s.rng.Intn(51)) / 100
+
+	builder := transaction.NewTxBuilder().AddInput(coin.TxHash, coin.OutputIndex)
+	for _, value := range []int64{part, coin.Value() - fee - part} {
+		if _, err := builder.AddP2PKHOutput(value, s.address); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := transaction.SignInput(tx, 0, s.key, coin.Output.PubKeyScript, transaction.SigHashAll); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// buildBlock assembles a block paying the subsidy and fees to the
+// simulator. view holds the outputs the transactions spend.
+func (s *Simulator) buildBlock(view utxo.View, prevHash types.Hash, timestamp uint32, height uint64, txs []*types.Transaction) (*types.Block, error) {
+	var fees int64
+	for _, tx := range txs {
+		for _, input := range tx.Inputs {
+			spent, err := view.Get(utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex))
+			if err != nil {
+				return nil, fmt.Errorf("simulated transaction spends a missing output: %w", err)
+			}
+			fees += spent.Value()
+		}
+		for _, output := range tx.Outputs {
+			fees -= output.Value
+		}
+	}
+
+	s.blocks++
+	reward := int64(s.Handler.Rules().GetBlockSubsidy(height)) + fees
+	coinbase, err := transaction.CreateCoinbase(height, reward, s.address, []byte(fmt.Sprintf("reorgsim-%d", s.blocks)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create coinbase: %w", err)
+	}
+
+	all := []types.Transaction{*coinbase}
+	for _, tx := range txs {
+		all = append(all, *tx)
+	}
+
+	return mining.BuildBlock(&mining.BlockTemplate{
+		Version:       1,
+		PrevBlockHash: prevHash,
+		Transactions:  all,
+		Timestamp:     timestamp,
+		Bits:          SimBits,
+		Height:        height,
+		TotalFees:     fees,
+	}, 0)
+}
+
+// confirmed reports whether txHash is in a block on the best chain
+func (s *Simulator) confirmed(txHash types.Hash) bool {
+	blockHash, _, err := s.Chain.GetTransactionLocation(txHash)
+	if err != nil {
+		return false
+	}
+	height, err := s.Chain.GetBlockHeight(blockHash)
+	if err != nil {
+		return false
+	}
+	tipHeight, err := s.Chain.GetBestBlockHeight()
+	if err != nil || height > tipHeight {
+		return false
+	}
+
+	block, err := s.Chain.GetBlockByHeight(height)
+	if err != nil {
+		return false
+	}
+	hash, _ := serialization.HashBlockHeader(&block.Header)
+	return hash == blockHash
+}
+
+// canAccept reports whether tx could enter the mempool right now: all
+// inputs available and none spent by a mempool transaction
+func (s *Simulator) canAccept(tx *types.Transaction) bool {
+	spentInMempool := make(map[utxo.OutPoint]bool)
+	for _, entry := range s.Mempool.GetAllTransactions() {
+		for _, input := range entry.Tx.Inputs {
+			spentInMempool[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)] = true
+		}
+	}
+
+	for _, input := range tx.Inputs {
+		outpoint := utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)
+		if spentInMempool[outpoint] {
+			return false
+		}
+		if !s.UTXOs.Exists(outpoint) && !s.Mempool.Exists(input.PrevTxHash) {
+			return false
+		}
+	}
+	return true
+}
+
+// spendable reports whether every input of tx is in view
+func spendable(view utxo.View, tx *types.Transaction) bool {
+	for _, input := range tx.Inputs {
+		if !view.Exists(utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)) {
+			return false
+		}
+	}
+	return true
+}
+
+// replayChain applies the best chain's blocks up to height to set
+func replayChain(chain *storage.BlockchainStorage, rules *consensus.ConsensusRules, set *utxo.UTXOSet, height uint64) error {
+	validator := validation.NewBlockValidator(set)
+	validator.SetRules(rules)
+	for h := uint64(0); h <= height; h++ {
+		block, err := chain.GetBlockByHeight(h)
+		if err != nil {
+			return fmt.Errorf("failed to load block %d: %w", h, err)
+		}
+		if err := validator.ApplyBlock(block, h); err != nil {
+			return fmt.Errorf("failed to replay block %d: %w", h, err)
+		}
+	}
+	return nil
+}
+
+// sortedUTXOs returns the entries of a set or view in a stable order so
+// seeded runs are reproducible
+func sortedUTXOs(set interface{ GetAll() []*utxo.UTXO }) []*utxo.UTXO {
+	coins := set.GetAll()
+	sort.Slice(coins, func(i, j int) bool {
+		return coins[i].OutPoint().String() < coins[j].OutPoint().String()
+	})
+	return coins
+}
+
+// compareUTXOSets returns an error describing the first difference
+func compareUTXOSets(got, want *utxo.UTXOSet) error {
+	if got.Size() != want.Size() {
+		return fmt.Errorf("UTXO set has %d entries, replay has %d", got.Size(), want.Size())
+	}
+
+	for _, coin := range want.GetAll() {
+		have, err := got.Get(coin.OutPoint())
+		if err != nil {
+			return fmt.Errorf("UTXO %s missing after reorg", coin.OutPoint())
+		}
+		if have.Value() != coin.Value() || have.Height != coin.Height || have.IsCoinbase != coin.IsCoinbase {
+			return fmt.Errorf("UTXO %s differs from replay", coin.OutPoint())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+// Package rest serves raw chain data over plain HTTP GET, like Bitcoin
+// Core's REST interface. It is read-only and unauthenticated, so it is
+// meant for its own port next to the RPC server, not behind it.
+//
+// Routes, where <fmt> is bin, hex or json:
+//
+//	/rest/block/<hash>.<fmt>
+//	/rest/headers/<count>/<hash>.<fmt>
+//	/rest/tx/<hash>.<fmt>
+//
+// The format may be left out, in which case json is served.
+package rest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/explorer"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// PathPrefix is the URL prefix all REST routes live under
+const PathPrefix = "/rest/"
+
+// MaxHeaders caps the count of a headers request
+const MaxHeaders = 2000
+
+// Response formats
+const (
+	FormatBinary = "bin"
+	FormatHex    = "hex"
+	FormatJSON   = "json"
+)
+
+// Server answers REST requests from the chain and mempool
+type Server struct {
+	chain   *storage.BlockchainStorage
+	mempool *mempool.Mempool
+}
+
+// NewServer creates a REST server. mp may be nil, in which case only
+// confirmed transactions are served.
+func NewServer(chain *storage.BlockchainStorage, mp *mempool.Mempool) *Server {
+	return &Server{chain: chain, mempool: mp}
+}
+
+// Register mounts the REST routes on a mux
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc(PathPrefix+"block/", s.handleBlock)
+	mux.HandleFunc(PathPrefix+"headers/", s.handleHeaders)
+	mux.HandleFunc(PathPrefix+"tx/", s.handleTx)
+}
+
+// Handler returns an http.Handler serving only the REST routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	s.Register(mux)
+	return mux
+}
+
+// Header is the JSON form of a block header
+type Header struct {
+	Hash       string `json:"hash"`
+	Height     uint64 `json:"height"`
+	Version    int32  `json:"version"`
+	PrevHash   string `json:"prev_hash"`
+	MerkleRoot string `json:"merkle_root"`
+	Timestamp  uint32 `json:"timestamp"`
+	Bits       uint32 `json:"bits"`
+	Nonce      uint32 `json:"nonce"`
+}
+
+// splitFormat separates "<name>.<fmt>" and checks the format
+func splitFormat(name string) (string, string, error) {
+	format := FormatJSON
+	if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+		name, format = name[:dot], name[dot+1:]
+	}
+	switch format {
+	case FormatBinary, FormatHex, FormatJSON:
+		return name, format, nil
+	default:
+		return "", "", fmt.Errorf("unknown format %q, use bin, hex or json", format)
+	}
+}
+
+// parseRequest checks the method and splits the hash and format off the
+// path after prefix
+func parseRequest(w http.ResponseWriter, r *http.Request, prefix string) (types.Hash, string, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return types.Hash{}, "", false
+	}
+
+	name, format, err := splitFormat(strings.TrimPrefix(r.URL.Path, prefix))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return types.Hash{}, "", false
+	}
+	hash, err := types.NewHashFromString(name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid hash: %s", name), http.StatusBadRequest)
+		return types.Hash{}, "", false
+	}
+	return hash, format, true
+}
+
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	hash, format, ok := parseRequest(w, r, PathPrefix+"block/")
+	if !ok {
+		return
+	}
+
+	raw, err := s.chain.GetRawBlock(hash)
+	if err != nil {
+		sendLookupError(w, err)
+		return
+	}
+	if format != FormatJSON {
+		sendRaw(w, format, raw)
+		return
+	}
+
+	block, err := serialization.DeserializeBlock(raw)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode block: %v", err), http.StatusInternalServerError)
+		return
+	}
+	height, err := s.chain.GetBlockHeight(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendJSON(w, explorer.DecodeBlock(block, hash, height))
+}
+
+func (s *Server) handleHeaders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// <count>/<hash>.<fmt>
+	rest := strings.TrimPrefix(r.URL.Path, PathPrefix+"headers/")
+	slash := strings.IndexByte(rest, '/')
+	if slash < 0 {
+		http.Error(w, "usage: /rest/headers/<count>/<hash>.<bin|hex|json>", http.StatusBadRequest)
+		return
+	}
+	count, err := strconv.Atoi(rest[:slash])
+	if err != nil || count < 1 || count > MaxHeaders {
+		http.Error(w, fmt.Sprintf("header count must be 1 to %d: %s", MaxHeaders, rest[:slash]), http.StatusBadRequest)
+		return
+	}
+	hash, format, ok := parseRequest(w, r, PathPrefix+"headers/"+rest[:slash]+"/")
+	if !ok {
+		return
+	}
+
+	headers, err := s.headersFrom(hash, count)
+	if err != nil {
+		sendLookupError(w, err)
+		return
+	}
+
+	if format == FormatJSON {
+		result := make([]Header, len(headers))
+		for i, h := range headers {
+			result[i] = decodeHeader(h.header, h.hash, h.height)
+		}
+		sendJSON(w, result)
+		return
+	}
+	var buf bytes.Buffer
+	for _, h := range headers {
+		raw, err := serialization.SerializeBlockHeader(h.header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		buf.Write(raw)
+	}
+	sendRaw(w, format, buf.Bytes())
+}
+
+// chainHeader is a header with its place in the chain
+type chainHeader struct {
+	header *types.BlockHeader
+	hash   types.Hash
+	height uint64
+}
+
+// headersFrom returns up to count headers starting at hash and following
+// the best chain. A block off the best chain yields just its own header.
+func (s *Server) headersFrom(hash types.Hash, count int) ([]chainHeader, error) {
+	block, err := s.chain.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	height, err := s.chain.GetBlockHeight(hash)
+	if err != nil {
+		return nil, err
+	}
+	headers := []chainHeader{{&block.Header, hash, height}}
+
+	mainChain, err := s.chain.IsMainChain(hash)
+	if err != nil || !mainChain {
+		return headers, nil
+	}
+	best, err := s.chain.GetBestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	for next := height + 1; len(headers) < count && next <= best; next++ {
+		block, err := s.chain.GetBlockByHeight(next)
+		if err != nil {
+			return nil, err
+		}
+		blockHash, err := s.chain.GetBlockHash(block)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, chainHeader{&block.Header, blockHash, next})
+	}
+	return headers, nil
+}
+
+func (s *Server) handleTx(w http.ResponseWriter, r *http.Request) {
+	txHash, format, ok := parseRequest(w, r, PathPrefix+"tx/")
+	if !ok {
+		return
+	}
+
+	tx, blockHash, err := s.findTransaction(txHash)
+	if err != nil {
+		sendLookupError(w, err)
+		return
+	}
+
+	if format != FormatJSON {
+		raw, err := serialization.SerializeTransactionWitness(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sendRaw(w, format, raw)
+		return
+	}
+
+	result := explorer.DecodeTransaction(tx, txHash)
+	if !blockHash.IsZero() {
+		result.BlockHash = blockHash.String()
+		result.Height, _ = s.chain.GetBlockHeight(blockHash)
+	}
+	sendJSON(w, result)
+}
+
+// findTransaction looks a transaction up in the mempool, then the chain.
+// The block hash is zero for mempool transactions.
+func (s *Server) findTransaction(txHash types.Hash) (*types.Transaction, types.Hash, error) {
+	if s.mempool != nil {
+		if entry, err := s.mempool.Get(txHash); err == nil {
+			return entry.Tx, types.Hash{}, nil
+		}
+	}
+
+	blockHash, txIndex, err := s.chain.GetTransactionLocation(txHash)
+	if err != nil {
+		return nil, types.Hash{}, err
+	}
+	block, err := s.chain.GetBlock(blockHash)
+	if err != nil {
+		return nil, types.Hash{}, err
+	}
+	if int(txIndex) >= len(block.Transactions) {
+		return nil, types.Hash{}, fmt.Errorf("invalid transaction index %d", txIndex)
+	}
+	return &block.Transactions[txIndex], blockHash, nil
+}
+
+// decodeHeader converts a header into its JSON form
+func decodeHeader(header *types.BlockHeader, hash types.Hash, height uint64) Header {
+	return Header{
+		Hash:       hash.String(),
+		Height:     height,
+		Version:    header.Version,
+		PrevHash:   header.PrevBlockHash.String(),
+		MerkleRoot: header.MerkleRoot.String(),
+		Timestamp:  header.Timestamp,
+		Bits:       header.Bits,
+		Nonce:      header.Nonce,
+	}
+}
+
+// sendLookupError reports a failed lookup: not found if the block or
+// transaction doesn't exist, an internal error otherwise
+func sendLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrBlockNotFound) || errors.Is(err, storage.ErrTxNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// sendRaw writes data as binary or as a line of hex
+func sendRaw(w http.ResponseWriter, format string, data []byte) {
+	if format == FormatBinary {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, hex.EncodeToString(data))
+}
+
+// sendJSON writes v as JSON
+func sendJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
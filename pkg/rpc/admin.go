@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UptimeResponse is returned by /uptime
+type UptimeResponse struct {
+	Uptime int64 `json:"uptime"` // Seconds since the server was created
+}
+
+// StopResponse is returned by /stop
+type StopResponse struct {
+	Message string `json:"message"`
+}
+
+// RPCInfoResponse is returned by /getrpcinfo
+type RPCInfoResponse struct {
+	ActiveCommands []ActiveCommand `json:"active_commands"`
+}
+
+// ActiveCommand is an RPC call that hasn't returned yet
+type ActiveCommand struct {
+	Method   string `json:"method"`
+	Duration int64  `json:"duration"` // Microseconds so far
+}
+
+// activeCall is the bookkeeping behind ActiveCommand
+type activeCall struct {
+	method  string
+	started time.Time
+}
+
+// SetShutdown sets the function the stop command calls to shut the node
+// down. It must not block on the RPC server, which is still answering
+// the stop request when it runs.
+func (s *Server) SetShutdown(shutdown func()) {
+	s.mu.Lock()
+	s.shutdown = shutdown
+	s.mu.Unlock()
+}
+
+// track records handler as active for as long as it runs
+func (s *Server) track(path string, handler http.HandlerFunc) http.HandlerFunc {
+	method := strings.TrimPrefix(path, "/")
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.nextCallID++
+		id := s.nextCallID
+		s.activeCalls[id] = activeCall{method: method, started: time.Now()}
+		s.mu.Unlock()
+
+		defer func() {
+			s.mu.Lock()
+			delete(s.activeCalls, id)
+			s.mu.Unlock()
+		}()
+
+		handler(w, r)
+	}
+}
+
+// handleUptime returns how long the server has been up
+func (s *Server) handleUptime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	s.sendSuccess(w, UptimeResponse{Uptime: int64(time.Since(s.started).Seconds())})
+}
+
+// handleStop starts the node's shutdown. The response is sent first; the
+// server then finishes in-flight requests and stops with the node.
+func (s *Server) handleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	s.mu.RLock()
+	shutdown := s.shutdown
+	s.mu.RUnlock()
+	if shutdown == nil {
+		s.sendError(w, "shutdown not configured")
+		return
+	}
+
+	s.sendSuccess(w, StopResponse{Message: "node stopping"})
+	shutdown()
+}
+
+// handleGetRPCInfo lists the calls currently being served, this one
+// included, longest running first
+func (s *Server) handleGetRPCInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	now := time.Now()
+	s.mu.RLock()
+	commands := make([]ActiveCommand, 0, len(s.activeCalls))
+	for _, call := range s.activeCalls {
+		commands = append(commands, ActiveCommand{
+			Method:   call.method,
+			Duration: now.Sub(call.started).Microseconds(),
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Duration > commands[j].Duration })
+	s.sendSuccess(w, RPCInfoResponse{ActiveCommands: commands})
+}
@@ -0,0 +1,120 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/backup"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/fileutil"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// BackupWalletResponse is returned by /backupwallet
+type BackupWalletResponse struct {
+	Destination string `json:"destination"`
+}
+
+// handleBackupWallet copies the wallet to a file on the node's machine
+func (s *Server) handleBackupWallet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Destination string `json:"destination"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.Destination == "" {
+		s.sendError(w, "missing destination")
+		return
+	}
+
+	if err := wal.Backup(req.Destination); err != nil {
+		s.sendError(w, fmt.Sprintf("failed to back up wallet: %v", err))
+		return
+	}
+
+	s.sendSuccess(w, BackupWalletResponse{Destination: req.Destination})
+}
+
+// BackupNodeResponse is returned by /backupnode
+type BackupNodeResponse struct {
+	Destination string   `json:"destination"`
+	TipHash     string   `json:"tip_hash"`
+	TipHeight   uint64   `json:"tip_height"`
+	Entries     int64    `json:"entries"`
+	Wallets     []string `json:"wallets"`
+	Bytes       int64    `json:"bytes"`
+}
+
+// handleBackupNode writes the chain database and every loaded wallet to a
+// tarball on the node's machine while the node keeps running. Wallets are
+// named by where they sit in the data directory: the default wallet file,
+// and the wallet directory's files beside it.
+func (s *Server) handleBackupNode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Destination string `json:"destination"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.Destination == "" {
+		s.sendError(w, "missing destination")
+		return
+	}
+
+	s.mu.RLock()
+	wallets := map[string]*wallet.Wallet{wallet.WalletFileName: s.wallet}
+	for name, wal := range s.wallets {
+		wallets[path.Join(filepath.Base(s.walletDir), name+walletFileSuffix)] = wal
+	}
+	s.mu.RUnlock()
+
+	// A failed backup never replaces a good one
+	var manifest *backup.Manifest
+	err := fileutil.Write(req.Destination, 0600, func(out io.Writer) error {
+		var err error
+		manifest, err = backup.Create(out, s.blockchain, wallets)
+		return err
+	})
+	var size int64
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(req.Destination); err == nil {
+			size = info.Size()
+		}
+	}
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to back up node: %v", err))
+		return
+	}
+
+	s.sendSuccess(w, BackupNodeResponse{
+		Destination: req.Destination,
+		TipHash:     manifest.TipHash,
+		TipHeight:   manifest.TipHeight,
+		Entries:     manifest.Entries,
+		Wallets:     manifest.Wallets(),
+		Bytes:       size,
+	})
+}
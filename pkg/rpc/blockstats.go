@@ -0,0 +1,175 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// BlockStatsResponse is returned by /getblockstats. Apart from the block's
+// own size and weight, the totals leave out the coinbase. Fee rates are in
+// satoshis per 1000 virtual bytes (sat/kvB).
+type BlockStatsResponse struct {
+	Hash          string `json:"blockhash"`
+	Height        uint64 `json:"height"`
+	Time          uint32 `json:"time"`
+	Txs           int    `json:"txs"` // Including the coinbase
+	Ins           int    `json:"ins"`
+	Outs          int    `json:"outs"`
+	TotalOut      int64  `json:"total_out"`
+	TotalFee      int64  `json:"totalfee"`
+	Subsidy       int64  `json:"subsidy"`
+	MinFee        int64  `json:"minfee"`
+	MaxFee        int64  `json:"maxfee"`
+	AvgFee        int64  `json:"avgfee"`
+	MinFeeRate    int64  `json:"minfeerate"`
+	MedianFeeRate int64  `json:"medianfeerate"`
+	MaxFeeRate    int64  `json:"maxfeerate"`
+	AvgFeeRate    int64  `json:"avgfeerate"` // Total fee over total vsize
+	TotalSize     int    `json:"total_size"`
+	TotalWeight   int    `json:"total_weight"`
+	Size          int    `json:"size"`
+	Weight        int    `json:"weight"`
+}
+
+// handleGetBlockStats computes fee and size statistics for the block at
+// height, or with blockhash
+func (s *Server) handleGetBlockStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	var (
+		block  *types.Block
+		height uint64
+		err    error
+	)
+	switch {
+	case query.Get("blockhash") != "":
+		hash, err := types.NewHashFromString(query.Get("blockhash"))
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("invalid blockhash: %v", err))
+			return
+		}
+		if block, err = s.blockchain.GetBlock(hash); err != nil {
+			s.sendError(w, fmt.Sprintf("block not found: %v", err))
+			return
+		}
+		if height, err = s.blockchain.GetBlockHeight(hash); err != nil {
+			s.sendError(w, fmt.Sprintf("block height not found: %v", err))
+			return
+		}
+	case query.Get("height") != "":
+		if height, err = strconv.ParseUint(query.Get("height"), 10, 64); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid height: %v", err))
+			return
+		}
+		if block, err = s.blockchain.GetBlockByHeight(height); err != nil {
+			s.sendError(w, fmt.Sprintf("block not found: %v", err))
+			return
+		}
+	default:
+		s.sendError(w, "missing height or blockhash parameter")
+		return
+	}
+
+	stats, err := s.blockStats(block, height)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to compute block stats: %v", err))
+		return
+	}
+	s.sendSuccess(w, stats)
+}
+
+// blockStats aggregates a stored block. There is no undo data, so the
+// outputs its inputs spent are read from the blocks that created them.
+func (s *Server) blockStats(block *types.Block, height uint64) (*BlockStatsResponse, error) {
+	hash, err := serialization.HashBlockHeader(&block.Header)
+	if err != nil {
+		return nil, err
+	}
+	weight, err := validation.BlockWeight(block)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &BlockStatsResponse{
+		Hash:    hash.String(),
+		Height:  height,
+		Time:    block.Header.Timestamp,
+		Txs:     len(block.Transactions),
+		Subsidy: validation.GetBlockReward(height),
+		Size:    serialization.BlockHeaderSize + serialization.VarIntSize(uint64(len(block.Transactions))),
+		Weight:  weight,
+	}
+	s.mu.RLock()
+	if s.rules != nil {
+		stats.Subsidy = int64(s.rules.GetBlockSubsidy(height))
+	}
+	s.mu.RUnlock()
+
+	var feeRates []int64
+	totalVSize := 0
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		size := transaction.SerializedSize(tx)
+		stats.Size += size
+		if i == 0 {
+			continue
+		}
+
+		stats.Ins += len(tx.Inputs)
+		stats.Outs += len(tx.Outputs)
+		stats.TotalSize += size
+		stats.TotalWeight += transaction.Weight(tx)
+
+		var in, out int64
+		for _, input := range tx.Inputs {
+			spent, err := validation.LookupOutput(s.blockchain, input.PrevTxHash, input.OutputIndex)
+			if err != nil {
+				return nil, fmt.Errorf("transaction %d: failed to find spent output %s:%d: %w", i, input.PrevTxHash, input.OutputIndex, err)
+			}
+			in += spent.Output.Value
+		}
+		for _, output := range tx.Outputs {
+			out += output.Value
+		}
+		stats.TotalOut += out
+
+		fee := in - out
+		vsize := transaction.VirtualSize(tx)
+		feeRate := transaction.FeeRate(fee, int64(vsize))
+		if len(feeRates) == 0 || fee < stats.MinFee {
+			stats.MinFee = fee
+		}
+		if fee > stats.MaxFee {
+			stats.MaxFee = fee
+		}
+		stats.TotalFee += fee
+		totalVSize += vsize
+		feeRates = append(feeRates, feeRate)
+	}
+
+	if len(feeRates) == 0 {
+		return stats, nil
+	}
+
+	sort.Slice(feeRates, func(i, j int) bool { return feeRates[i] < feeRates[j] })
+	stats.MinFeeRate = feeRates[0]
+	stats.MaxFeeRate = feeRates[len(feeRates)-1]
+	stats.MedianFeeRate = feeRates[len(feeRates)/2]
+	if len(feeRates)%2 == 0 {
+		stats.MedianFeeRate = (feeRates[len(feeRates)/2-1] + feeRates[len(feeRates)/2]) / 2
+	}
+	stats.AvgFee = stats.TotalFee / int64(len(feeRates))
+	stats.AvgFeeRate = transaction.FeeRate(stats.TotalFee, int64(totalVSize))
+	return stats, nil
+}
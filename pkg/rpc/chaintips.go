@@ -0,0 +1,64 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+)
+
+// ChainTipInfo describes one chain tip. BranchLen is zero for the active
+// tip and otherwise counts the blocks back to the best chain.
+type ChainTipInfo struct {
+	Height    uint64 `json:"height"`
+	Hash      string `json:"hash"`
+	BranchLen int    `json:"branchlen"`
+	Status    string `json:"status"` // active, valid-fork, invalid or headers-only
+}
+
+// ChainTipsResponse is returned by /getchaintips
+type ChainTipsResponse struct {
+	Tips []ChainTipInfo `json:"tips"`
+}
+
+// handleGetChainTips lists the tip of the best chain and of every known
+// fork, highest first. Headers-only tips need the P2P node.
+func (s *Server) handleGetChainTips(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	var (
+		tips []storage.ChainTip
+		err  error
+	)
+	if s.node != nil {
+		tips, err = s.node.SyncManager.ChainTips()
+	} else {
+		tips, err = s.blockchain.GetChainTips()
+	}
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to list chain tips: %v", err))
+		return
+	}
+
+	sort.Slice(tips, func(i, j int) bool {
+		if tips[i].Height != tips[j].Height {
+			return tips[i].Height > tips[j].Height
+		}
+		return tips[i].Status == storage.ChainTipActive
+	})
+
+	infos := make([]ChainTipInfo, len(tips))
+	for i, tip := range tips {
+		infos[i] = ChainTipInfo{
+			Height:    tip.Height,
+			Hash:      tip.Hash.String(),
+			BranchLen: tip.BranchLen,
+			Status:    tip.Status,
+		}
+	}
+	s.sendSuccess(w, ChainTipsResponse{Tips: infos})
+}
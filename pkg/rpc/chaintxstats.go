@@ -0,0 +1,121 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// DefaultChainTxStatsWindow is the getchaintxstats window when nblocks is
+// not given: about a month of ten-minute blocks
+const DefaultChainTxStatsWindow = 30 * 24 * 6
+
+// ChainTxStatsResponse is returned by /getchaintxstats. The window fields
+// are left out when the window is empty, and the rate when no time
+// passed across it.
+type ChainTxStatsResponse struct {
+	Time             uint32  `json:"time"`    // Timestamp of the final block
+	TxCount          uint64  `json:"txcount"` // Transactions from genesis through the final block
+	FinalBlockHash   string  `json:"window_final_block_hash"`
+	FinalBlockHeight uint64  `json:"window_final_block_height"`
+	BlockCount       uint64  `json:"window_block_count"`
+	TxCountWindow    uint64  `json:"window_tx_count,omitempty"`
+	Interval         int64   `json:"window_interval,omitempty"` // Seconds
+	TxRate           float64 `json:"txrate,omitempty"`          // Transactions per second
+}
+
+// handleGetChainTxStats reports the transaction rate over the nblocks
+// blocks ending at blockhash (default: the tip). Counts come from the
+// cumulative counter stored with each block, so any window is cheap.
+func (s *Server) handleGetChainTxStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	var (
+		finalHash types.Hash
+		err       error
+	)
+	if hashStr := query.Get("blockhash"); hashStr != "" {
+		if finalHash, err = types.NewHashFromString(hashStr); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid blockhash: %v", err))
+			return
+		}
+		if main, err := s.blockchain.IsMainChain(finalHash); err != nil || !main {
+			s.sendError(w, "block is not in the main chain")
+			return
+		}
+	} else if finalHash, _, err = s.blockchain.GetTip(); err != nil {
+		s.sendError(w, fmt.Sprintf("failed to get best block: %v", err))
+		return
+	}
+
+	final, err := s.blockchain.GetBlock(finalHash)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block not found: %v", err))
+		return
+	}
+	height, err := s.blockchain.GetBlockHeight(finalHash)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block height not found: %v", err))
+		return
+	}
+
+	// The window can't reach past genesis
+	var blockCount uint64
+	if nblocks := query.Get("nblocks"); nblocks != "" {
+		if blockCount, err = strconv.ParseUint(nblocks, 10, 64); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid nblocks: %v", err))
+			return
+		}
+		if height == 0 || blockCount >= height {
+			s.sendError(w, fmt.Sprintf("invalid block count: should be between 0 and %d", max(height, 1)-1))
+			return
+		}
+	} else if height > 0 {
+		blockCount = min(DefaultChainTxStatsWindow, height-1)
+	}
+
+	txCount, err := s.blockchain.GetChainTxCount(finalHash)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to count transactions: %v", err))
+		return
+	}
+
+	resp := ChainTxStatsResponse{
+		Time:             final.Header.Timestamp,
+		TxCount:          txCount,
+		FinalBlockHash:   finalHash.String(),
+		FinalBlockHeight: height,
+		BlockCount:       blockCount,
+	}
+	if blockCount > 0 {
+		start, err := s.blockchain.GetBlockByHeight(height - blockCount)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("window start not found: %v", err))
+			return
+		}
+		startHash, err := s.blockchain.GetBlockHash(start)
+		if err != nil {
+			s.sendError(w, err.Error())
+			return
+		}
+		startCount, err := s.blockchain.GetChainTxCount(startHash)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("failed to count transactions: %v", err))
+			return
+		}
+
+		resp.TxCountWindow = txCount - startCount
+		resp.Interval = int64(final.Header.Timestamp) - int64(start.Header.Timestamp)
+		if resp.Interval > 0 {
+			resp.TxRate = float64(resp.TxCountWindow) / float64(resp.Interval)
+		}
+	}
+
+	s.sendSuccess(w, resp)
+}
@@ -2,16 +2,25 @@ package rpc
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
+// ErrRateLimited is returned when the server answers 429 Too Many Requests
+var ErrRateLimited = errors.New("rate limited")
+
 // Client represents an RPC client
 type Client struct {
 	baseURL string
 	client  *http.Client
+	wallet  string // Named wallet for wallet calls, "" = the default wallet
 }
 
 // NewClient creates a new RPC client
@@ -22,9 +31,27 @@ func NewClient(baseURL string) *Client {
 	}
 }
 
+// SetWallet directs the wallet calls to a wallet made by CreateWallet.
+// An empty name selects the node's default wallet.
+func (c *Client) SetWallet(name string) {
+	c.wallet = name
+}
+
+// walletPath adds the selected wallet to a wallet call's path
+func (c *Client) walletPath(path string) string {
+	if c.wallet == "" {
+		return path
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + WalletParam + "=" + url.QueryEscape(c.wallet)
+}
+
 // GetNewAddress generates a new address
 func (c *Client) GetNewAddress() (string, error) {
-	resp, err := c.get("/getnewaddress")
+	resp, err := c.get(c.walletPath("/getnewaddress"))
 	if err != nil {
 		return "", err
 	}
@@ -39,7 +66,7 @@ func (c *Client) GetNewAddress() (string, error) {
 
 // GetBalance retrieves wallet balance
 func (c *Client) GetBalance() (int64, error) {
-	resp, err := c.get("/getbalance")
+	resp, err := c.get(c.walletPath("/getbalance"))
 	if err != nil {
 		return 0, err
 	}
@@ -59,7 +86,27 @@ func (c *Client) SendToAddress(address string, amount int64) (string, error) {
 		"amount":  amount,
 	}
 
-	resp, err := c.post("/sendtoaddress", reqBody)
+	resp, err := c.post(c.walletPath("/sendtoaddress"), reqBody)
+	if err != nil {
+		return "", err
+	}
+
+	var result SendResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return "", err
+	}
+
+	return result.TxHash, nil
+}
+
+// SendToAddressWithData is like SendToAddress but also attaches an
+// OP_RETURN output carrying data
+func (c *Client) SendToAddressWithData(address string, amount int64, data []byte) (string, error) {
+	resp, err := c.post(c.walletPath("/sendtoaddress"), map[string]interface{}{
+		"address": address,
+		"amount":  amount,
+		"data":    hex.EncodeToString(data),
+	})
 	if err != nil {
 		return "", err
 	}
@@ -103,6 +150,164 @@ func (c *Client) GetBlock(height uint64) (*BlockResponse, error) {
 	return &result, nil
 }
 
+// GetBlockByHash returns the header and txids of a stored block, which
+// need not be on the best chain
+func (c *Client) GetBlockByHash(hash string) (*BlockResponse, error) {
+	resp, err := c.get("/getblock?verbosity=1&blockhash=" + hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var result BlockResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetRawBlock returns a stored block serialized as hex
+func (c *Client) GetRawBlock(hash string) (string, error) {
+	resp, err := c.get("/getblock?verbosity=0&blockhash=" + hash)
+	if err != nil {
+		return "", err
+	}
+
+	var result string
+	if err := c.parseResponse(resp, &result); err != nil {
+		return "", err
+	}
+
+	return result, nil
+}
+
+// GetBlockVerbose returns a stored block with its transactions decoded
+func (c *Client) GetBlockVerbose(hash string) (*DecodedBlock, error) {
+	resp, err := c.get("/getblock?verbosity=2&blockhash=" + hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var result DecodedBlock
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetBlockHash returns the hash of the best chain's block at height
+func (c *Client) GetBlockHash(height uint64) (string, error) {
+	resp, err := c.get(fmt.Sprintf("/getblockhash?height=%d", height))
+	if err != nil {
+		return "", err
+	}
+
+	var result BlockHashResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return "", err
+	}
+
+	return result.Hash, nil
+}
+
+// GetBlockHeader returns the decoded header of a stored block
+func (c *Client) GetBlockHeader(hash string) (*BlockHeaderResponse, error) {
+	resp, err := c.get("/getblockheader?blockhash=" + hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var result BlockHeaderResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetBlockHeaderHex returns the 80-byte header of a stored block as hex
+func (c *Client) GetBlockHeaderHex(hash string) (string, error) {
+	resp, err := c.get("/getblockheader?verbose=false&blockhash=" + hash)
+	if err != nil {
+		return "", err
+	}
+
+	var result string
+	if err := c.parseResponse(resp, &result); err != nil {
+		return "", err
+	}
+
+	return result, nil
+}
+
+// GetMempoolInfo summarizes the node's mempool
+func (c *Client) GetMempoolInfo() (*MempoolInfoResponse, error) {
+	resp, err := c.get("/getmempoolinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var result MempoolInfoResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetMempoolSnapshot captures the mempool's contents on the server,
+// returning the id to diff against later
+func (c *Client) GetMempoolSnapshot() (*MempoolSnapshotResponse, error) {
+	resp, err := c.get("/getmempoolsnapshot")
+	if err != nil {
+		return nil, err
+	}
+
+	var result MempoolSnapshotResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetMempoolDiff reports how the mempool changed between snapshots from
+// and to. A to of 0 compares with the mempool now, kept as a new snapshot.
+func (c *Client) GetMempoolDiff(from, to uint64) (*MempoolDiffResponse, error) {
+	url := fmt.Sprintf("/getmempooldiff?from=%d", from)
+	if to != 0 {
+		url += fmt.Sprintf("&to=%d", to)
+	}
+	resp, err := c.get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var result MempoolDiffResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetRawMempool lists the mempool's transactions, highest fee rate first.
+// With verbose, Entries describes each of them.
+func (c *Client) GetRawMempool(verbose bool) (*RawMempoolResponse, error) {
+	resp, err := c.get(fmt.Sprintf("/getrawmempool?verbose=%t", verbose))
+	if err != nil {
+		return nil, err
+	}
+
+	var result RawMempoolResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
 // GetTransaction retrieves transaction by hash
 func (c *Client) GetTransaction(txHash string) (*TransactionResponse, error) {
 	url := fmt.Sprintf("/gettransaction?txhash=%s", txHash)
@@ -121,7 +326,7 @@ func (c *Client) GetTransaction(txHash string) (*TransactionResponse, error) {
 
 // ListAddresses lists all wallet addresses
 func (c *Client) ListAddresses() ([]string, error) {
-	resp, err := c.get("/listaddresses")
+	resp, err := c.get(c.walletPath("/listaddresses"))
 	if err != nil {
 		return nil, err
 	}
@@ -134,45 +339,938 @@ func (c *Client) ListAddresses() ([]string, error) {
 	return result.Addresses, nil
 }
 
-// Helper methods
-func (c *Client) get(path string) (*http.Response, error) {
-	url := c.baseURL + path
-	return c.client.Get(url)
+// BackupWallet asks the node to copy its wallet to destination, a path on
+// the node's machine
+func (c *Client) BackupWallet(destination string) error {
+	resp, err := c.post(c.walletPath("/backupwallet"), map[string]interface{}{
+		"destination": destination,
+	})
+	if err != nil {
+		return err
+	}
+
+	var result BackupWalletResponse
+	return c.parseResponse(resp, &result)
 }
 
-func (c *Client) post(path string, body interface{}) (*http.Response, error) {
-	url := c.baseURL + path
+// CreateWallet adds a named wallet on the node, encrypted with passphrase
+// unless it is empty
+func (c *Client) CreateWallet(name, passphrase string) (*CreateWalletResponse, error) {
+	resp, err := c.post("/createwallet", map[string]interface{}{
+		"name":       name,
+		"passphrase": passphrase,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	jsonBody, err := json.Marshal(body)
+	var result CreateWalletResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// EncryptWallet encrypts the wallet's keys with passphrase and locks it
+func (c *Client) EncryptWallet(passphrase string) (*WalletStatusResponse, error) {
+	return c.walletStatus("/encryptwallet", map[string]interface{}{
+		"passphrase": passphrase,
+	})
+}
+
+// WalletPassphrase unlocks an encrypted wallet for timeout seconds
+func (c *Client) WalletPassphrase(passphrase string, timeout int64) (*WalletStatusResponse, error) {
+	return c.walletStatus("/walletpassphrase", map[string]interface{}{
+		"passphrase": passphrase,
+		"timeout":    timeout,
+	})
+}
+
+// WalletLock locks an encrypted wallet
+func (c *Client) WalletLock() (*WalletStatusResponse, error) {
+	return c.walletStatus("/walletlock", map[string]interface{}{})
+}
+
+func (c *Client) walletStatus(path string, body map[string]interface{}) (*WalletStatusResponse, error) {
+	resp, err := c.post(c.walletPath(path), body)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.client.Post(url, "application/json", bytes.NewBuffer(jsonBody))
+	var result WalletStatusResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
 }
 
-func (c *Client) parseResponse(resp *http.Response, result interface{}) error {
-	defer resp.Body.Close()
+// SetHDSeed makes the wallet derive its keys from seed and restores the
+// addresses the seed used before, looking gapLimit unused addresses past
+// the last used one (0 for the default)
+func (c *Client) SetHDSeed(seed []byte, gapLimit int) (*SetHDSeedResponse, error) {
+	resp, err := c.post(c.walletPath("/sethdseed"), map[string]interface{}{
+		"seed":      hex.EncodeToString(seed),
+		"gap_limit": gapLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	body, err := io.ReadAll(resp.Body)
+	var result SetHDSeedResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// SetWalletFlag sets or clears a wallet flag, such as avoid_reuse
+func (c *Client) SetWalletFlag(flag string, value bool) (*SetWalletFlagResponse, error) {
+	resp, err := c.post(c.walletPath("/setwalletflag"), map[string]interface{}{
+		"flag":  flag,
+		"value": value,
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var rpcResp Response
-	if err := json.Unmarshal(body, &rpcResp); err != nil {
-		return err
+	var result SetWalletFlagResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
 	}
 
-	if rpcResp.Error != "" {
-		return fmt.Errorf("RPC error: %s", rpcResp.Error)
+	return &result, nil
+}
+
+// ListDescriptors exports the wallet's descriptors, with private keys if
+// private is set
+func (c *Client) ListDescriptors(private bool) ([]WalletDescriptorInfo, error) {
+	resp, err := c.get(c.walletPath(fmt.Sprintf("/listdescriptors?private=%t", private)))
+	if err != nil {
+		return nil, err
+	}
+
+	var result ListDescriptorsResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
 	}
 
-	// Convert result to JSON and back to parse into the target type
-	resultJSON, err := json.Marshal(rpcResp.Result)
+	return result.Descriptors, nil
+}
+
+// GetDescriptorInfo returns a descriptor with its checksum. A checksum
+// already on it must be right.
+func (c *Client) GetDescriptorInfo(descriptor string) (*DescriptorInfo, error) {
+	resp, err := c.post("/getdescriptorinfo", map[string]interface{}{
+		"descriptor": descriptor,
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	var result DescriptorInfo
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// ImportPrivKey adds a WIF private key to the wallet and returns its
+// address. With rescan the node searches the chain for its outputs.
+func (c *Client) ImportPrivKey(wif string, rescan bool) (string, error) {
+	resp, err := c.post(c.walletPath("/importprivkey"), map[string]interface{}{
+		"privkey": wif,
+		"rescan":  rescan,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var result ImportPrivKeyResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return "", err
+	}
+
+	return result.Address, nil
+}
+
+// DumpWallet asks the node to write the wallet's private keys to a new
+// file, a path on the node's machine
+func (c *Client) DumpWallet(filename string) (*DumpWalletResponse, error) {
+	resp, err := c.post(c.walletPath("/dumpwallet"), map[string]interface{}{
+		"filename": filename,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result DumpWalletResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// ListUnspent lists the wallet's outputs with at least minConf
+// confirmations
+func (c *Client) ListUnspent(minConf uint64) ([]UnspentInfo, error) {
+	resp, err := c.get(c.walletPath(fmt.Sprintf("/listunspent?minconf=%d", minConf)))
+	if err != nil {
+		return nil, err
+	}
+
+	var result ListUnspentResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Unspent, nil
+}
+
+// ListTransactions lists up to count wallet transactions, oldest first,
+// leaving out the skip most recent
+func (c *Client) ListTransactions(count, skip int) ([]WalletTxInfo, error) {
+	resp, err := c.get(c.walletPath(fmt.Sprintf("/listtransactions?count=%d&skip=%d", count, skip)))
+	if err != nil {
+		return nil, err
+	}
+
+	var result ListTransactionsResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Transactions, nil
+}
+
+// BumpFee replaces an unconfirmed wallet transaction with one paying fee
+// in total. A fee of 0 lets the wallet pick the increase.
+func (c *Client) BumpFee(txid string, fee int64) (*BumpFeeResponse, error) {
+	resp, err := c.post(c.walletPath("/bumpfee"), map[string]interface{}{
+		"txid": txid,
+		"fee":  fee,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result BumpFeeResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// AbandonTransaction releases the inputs of a stuck wallet transaction
+func (c *Client) AbandonTransaction(txid string) error {
+	resp, err := c.post(c.walletPath("/abandontransaction"), map[string]interface{}{
+		"txid": txid,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.parseResponse(resp, nil)
+}
+
+// GetPeerInfo lists connected peers
+func (c *Client) GetPeerInfo() ([]PeerInfo, error) {
+	resp, err := c.get("/getpeerinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var result PeerInfoResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Peers, nil
+}
+
+// GetNetTotals returns the node's total P2P traffic
+func (c *Client) GetNetTotals() (*NetTotalsResponse, error) {
+	resp, err := c.get("/getnettotals")
+	if err != nil {
+		return nil, err
+	}
+
+	var result NetTotalsResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetNetworkInfo returns the node's P2P state and clock offset
+func (c *Client) GetNetworkInfo() (*NetworkInfoResponse, error) {
+	resp, err := c.get("/getnetworkinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var result NetworkInfoResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetMemoryInfo returns the node's heap usage
+func (c *Client) GetMemoryInfo() (*MemoryInfoResponse, error) {
+	resp, err := c.get("/getmemoryinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var result MemoryInfoResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetDeploymentInfo returns the state of the soft fork deployments
+func (c *Client) GetDeploymentInfo() (*DeploymentInfoResponse, error) {
+	resp, err := c.get("/getdeploymentinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var result DeploymentInfoResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetChainTips lists the tips of the best chain and every known fork
+func (c *Client) GetChainTips() ([]ChainTipInfo, error) {
+	resp, err := c.get("/getchaintips")
+	if err != nil {
+		return nil, err
+	}
+
+	var result ChainTipsResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Tips, nil
+}
+
+// GetBlockStats returns fee and size statistics for the block at height
+func (c *Client) GetBlockStats(height uint64) (*BlockStatsResponse, error) {
+	url := fmt.Sprintf("/getblockstats?height=%d", height)
+	resp, err := c.get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var result BlockStatsResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// VerifyChain re-validates the last nblocks blocks (0 = all) at
+// checkLevel. A negative checkLevel or nblocks uses the server's default.
+func (c *Client) VerifyChain(checkLevel, nblocks int) (*VerifyChainResponse, error) {
+	params := url.Values{}
+	if checkLevel >= 0 {
+		params.Set("checklevel", fmt.Sprint(checkLevel))
+	}
+	if nblocks >= 0 {
+		params.Set("nblocks", fmt.Sprint(nblocks))
+	}
+	resp, err := c.get("/verifychain?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var result VerifyChainResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetChainTxStats returns transaction statistics over the nblocks blocks
+// ending at blockHash. A negative nblocks and an empty blockHash use the
+// server's defaults (about a month, ending at the tip).
+func (c *Client) GetChainTxStats(nblocks int, blockHash string) (*ChainTxStatsResponse, error) {
+	params := url.Values{}
+	if nblocks >= 0 {
+		params.Set("nblocks", fmt.Sprint(nblocks))
+	}
+	if blockHash != "" {
+		params.Set("blockhash", blockHash)
+	}
+	resp, err := c.get("/getchaintxstats?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var result ChainTxStatsResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetDifficulty returns the difficulty of the chain tip
+func (c *Client) GetDifficulty() (float64, error) {
+	resp, err := c.get("/getdifficulty")
+	if err != nil {
+		return 0, err
+	}
+
+	var result DifficultyResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return 0, err
+	}
+
+	return result.Difficulty, nil
+}
+
+// GetMiningInfo returns difficulty, hash rate and block template totals,
+// estimating the network hash rate over the last nblocks blocks
+func (c *Client) GetMiningInfo(nblocks uint64) (*MiningInfoResponse, error) {
+	url := fmt.Sprintf("/getmininginfo?nblocks=%d", nblocks)
+	resp, err := c.get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var result MiningInfoResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetNetworkHashPS estimates the network hash rate over the nblocks blocks
+// ending at height, or at the tip if height is negative
+func (c *Client) GetNetworkHashPS(nblocks uint64, height int64) (*NetworkHashPSResponse, error) {
+	url := fmt.Sprintf("/getnetworkhashps?nblocks=%d", nblocks)
+	if height >= 0 {
+		url += fmt.Sprintf("&height=%d", height)
+	}
+	resp, err := c.get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var result NetworkHashPSResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetBlockTemplate returns a block template. A non-empty longPollID
+// waits until the template with that id is outdated.
+func (c *Client) GetBlockTemplate(longPollID string) (*BlockTemplateResponse, error) {
+	url := "/getblocktemplate"
+	if longPollID != "" {
+		url += "?longpollid=" + longPollID
+	}
+	resp, err := c.get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var result BlockTemplateResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// ProposeBlock checks a hex-serialized block against the tip without its
+// proof of work. It returns "" for a valid block and the rejection reason
+// otherwise.
+func (c *Client) ProposeBlock(hexData string) (string, error) {
+	resp, err := c.post("/getblocktemplate", map[string]interface{}{
+		"mode": "proposal",
+		"data": hexData,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var reason string
+	if err := c.parseResponse(resp, &reason); err != nil {
+		return "", err
+	}
+
+	return reason, nil
+}
+
+// ProposeBlockVerbose is ProposeBlock reporting every rule the block was
+// checked against
+func (c *Client) ProposeBlockVerbose(hexData string) (*BlockReportResponse, error) {
+	resp, err := c.post("/getblocktemplate", map[string]interface{}{
+		"mode":    "proposal",
+		"data":    hexData,
+		"verbose": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result BlockReportResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// ValidateBlock checks a hex-serialized block extending the tip against
+// every rule without submitting it
+func (c *Client) ValidateBlock(hexData string) (*BlockReportResponse, error) {
+	resp, err := c.post("/validateblock", map[string]interface{}{
+		"hexdata": hexData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result BlockReportResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// SubmitBlock submits a hex-serialized block
+func (c *Client) SubmitBlock(hexData string) (*SubmitBlockResponse, error) {
+	return c.submit("/submitblock", hexData)
+}
+
+// SubmitHeader submits a hex-serialized block header
+func (c *Client) SubmitHeader(hexData string) (*SubmitBlockResponse, error) {
+	return c.submit("/submitheader", hexData)
+}
+
+func (c *Client) submit(path string, hexData string) (*SubmitBlockResponse, error) {
+	resp, err := c.post(path, map[string]interface{}{
+		"hexdata": hexData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result SubmitBlockResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// DecodeRawTransaction decodes a hex-serialized transaction
+func (c *Client) DecodeRawTransaction(hexData string) (*DecodedTransaction, error) {
+	resp, err := c.post("/decoderawtransaction", map[string]interface{}{
+		"hexdata": hexData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result DecodedTransaction
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// DecodeBlock decodes a hex-serialized block
+func (c *Client) DecodeBlock(hexData string) (*DecodedBlock, error) {
+	resp, err := c.post("/decodeblock", map[string]interface{}{
+		"hexdata": hexData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result DecodedBlock
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetTxOut returns an unspent output, or nil if it is spent or unknown
+func (c *Client) GetTxOut(txid string, n uint32, includeMempool bool) (*TxOutResponse, error) {
+	url := fmt.Sprintf("/gettxout?txid=%s&n=%d&include_mempool=%t", txid, n, includeMempool)
+	resp, err := c.get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *TxOutResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetSpentInfo returns the input spending an output, or nil if it is
+// unspent or the spend isn't indexed
+func (c *Client) GetSpentInfo(txid string, n uint32, includeMempool bool) (*SpentInfoResponse, error) {
+	url := fmt.Sprintf("/getspentinfo?txid=%s&n=%d&include_mempool=%t", txid, n, includeMempool)
+	resp, err := c.get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *SpentInfoResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetTxGraph returns the transactions within depth generations of txid,
+// spent from or spending it, in the mempool and the chain
+func (c *Client) GetTxGraph(txid string, depth int) (*TxGraphResponse, error) {
+	resp, err := c.get(fmt.Sprintf("/gettxgraph?txid=%s&depth=%d", txid, depth))
+	if err != nil {
+		return nil, err
+	}
+
+	var result TxGraphResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetTxOutProof returns a hex proof that txids are in a block. blockHash
+// may be empty to use the block the first transaction was confirmed in.
+func (c *Client) GetTxOutProof(txids []string, blockHash string) (string, error) {
+	url := "/gettxoutproof?txids=" + strings.Join(txids, ",")
+	if blockHash != "" {
+		url += "&blockhash=" + blockHash
+	}
+	resp, err := c.get(url)
+	if err != nil {
+		return "", err
+	}
+
+	var result TxOutProofResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return "", err
+	}
+
+	return result.Proof, nil
+}
+
+// VerifyTxOutProof returns the txids a proof commits to
+func (c *Client) VerifyTxOutProof(proof string) ([]string, error) {
+	resp, err := c.post("/verifytxoutproof", map[string]interface{}{
+		"proof": proof,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result VerifyTxOutProofResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.TxIDs, nil
+}
+
+// AddNode adds, removes or tries a manual peer ("add", "remove", "onetry")
+func (c *Client) AddNode(node string, command string) error {
+	resp, err := c.post("/addnode", map[string]interface{}{
+		"node":    node,
+		"command": command,
+	})
+	if err != nil {
+		return err
+	}
+
+	var result interface{}
+	return c.parseResponse(resp, &result)
+}
+
+// DisconnectNode drops a connected peer
+func (c *Client) DisconnectNode(address string) error {
+	resp, err := c.post("/disconnectnode", map[string]interface{}{
+		"address": address,
+	})
+	if err != nil {
+		return err
+	}
+
+	var result interface{}
+	return c.parseResponse(resp, &result)
+}
+
+// SetBan bans ("add") or unbans ("remove") an IP; banTime is in seconds
+func (c *Client) SetBan(ip string, command string, banTime int64) error {
+	resp, err := c.post("/setban", map[string]interface{}{
+		"ip":      ip,
+		"command": command,
+		"bantime": banTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	var result interface{}
+	return c.parseResponse(resp, &result)
+}
+
+// ListBanned returns the node's active bans
+func (c *Client) ListBanned() ([]BannedInfo, error) {
+	resp, err := c.get("/listbanned")
+	if err != nil {
+		return nil, err
+	}
+
+	var result ListBannedResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Bans, nil
+}
+
+// ClearBanned lifts every ban
+func (c *Client) ClearBanned() error {
+	resp, err := c.post("/clearbanned", map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+
+	var result interface{}
+	return c.parseResponse(resp, &result)
+}
+
+// Uptime returns the server's uptime in seconds
+func (c *Client) Uptime() (int64, error) {
+	resp, err := c.get("/uptime")
+	if err != nil {
+		return 0, err
+	}
+
+	var result UptimeResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return 0, err
+	}
+
+	return result.Uptime, nil
+}
+
+// Stop asks the node to shut down
+func (c *Client) Stop() error {
+	resp, err := c.post("/stop", map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+
+	var result StopResponse
+	return c.parseResponse(resp, &result)
+}
+
+// GetRPCInfo lists the RPC calls the server is currently running
+func (c *Client) GetRPCInfo() ([]ActiveCommand, error) {
+	resp, err := c.get("/getrpcinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var result RPCInfoResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.ActiveCommands, nil
+}
+
+// GetDiskUsage returns the chain database's size, overall and per kind
+// of entry
+func (c *Client) GetDiskUsage() (*DiskUsageResponse, error) {
+	resp, err := c.get("/getdiskusage")
+	if err != nil {
+		return nil, err
+	}
+
+	var result DiskUsageResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// CompactDB compacts the chain database
+func (c *Client) CompactDB() (*CompactResponse, error) {
+	resp, err := c.post("/compactdb", map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	var result CompactResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// BackupNode writes the chain and the node's wallets to a tarball at
+// destination, on the node's machine
+func (c *Client) BackupNode(destination string) (*BackupNodeResponse, error) {
+	resp, err := c.post("/backupnode", map[string]interface{}{
+		"destination": destination,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result BackupNodeResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Helper methods
+// WatchAddress starts reporting outputs paying address and spends of them
+func (c *Client) WatchAddress(address string) (*WatchResponse, error) {
+	return c.watch("/watchaddress", map[string]interface{}{"address": address})
+}
+
+// WatchScript starts reporting outputs paying a hex script and spends of
+// them
+func (c *Client) WatchScript(script string) (*WatchResponse, error) {
+	return c.watch("/watchaddress", map[string]interface{}{"script": script})
+}
+
+// UnwatchAddress stops reporting new outputs paying address
+func (c *Client) UnwatchAddress(address string) (*WatchResponse, error) {
+	return c.watch("/unwatchaddress", map[string]interface{}{"address": address})
+}
+
+func (c *Client) watch(path string, body map[string]interface{}) (*WatchResponse, error) {
+	resp, err := c.post(path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result WatchResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// WaitForWatchEvents returns watch events after since, waiting for one if
+// there are none yet. A zero timeout waits indefinitely.
+func (c *Client) WaitForWatchEvents(since uint64, timeout time.Duration) (*WatchEventsResponse, error) {
+	url := fmt.Sprintf("/waitforwatchevents?since=%d&timeout=%d", since, timeout.Milliseconds())
+	resp, err := c.get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var result WatchEventsResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *Client) get(path string) (*http.Response, error) {
+	url := c.baseURL + path
+	return c.client.Get(url)
+}
+
+func (c *Client) post(path string, body interface{}) (*http.Response, error) {
+	url := c.baseURL + path
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.client.Post(url, "application/json", bytes.NewBuffer(jsonBody))
+}
+
+func (c *Client) parseResponse(resp *http.Response, result interface{}) error {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Keep the result raw so large integers don't lose precision as floats
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error"`
+	}
+	if err := json.Unmarshal(body, &rpcResp); err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %s", ErrRateLimited, rpcResp.Error)
+	}
+	if rpcResp.Error != "" {
+		return fmt.Errorf("RPC error: %s", rpcResp.Error)
+	}
+
+	if len(rpcResp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+// ListNullData returns the indexed OP_RETURN payloads starting with prefix
+// in blocks from fromHeight up
+func (c *Client) ListNullData(prefix []byte, fromHeight uint64) ([]NullDataInfo, error) {
+	resp, err := c.get(fmt.Sprintf("/listnulldata?prefix=%x&from=%d", prefix, fromHeight))
+	if err != nil {
+		return nil, err
+	}
+
+	var result NullDataResponse
+	if err := c.parseResponse(resp, &result); err != nil {
+		return nil, err
 	}
 
-	return json.Unmarshal(resultJSON, result)
+	return result.Entries, nil
 }
@@ -0,0 +1,275 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// ScriptInfo is a decoded script. Type and Address are only set for
+// locking scripts.
+type ScriptInfo struct {
+	Asm     string `json:"asm"`
+	Hex     string `json:"hex"`
+	Type    string `json:"type,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// DecodedPrevOut is the output a decoded input spends
+type DecodedPrevOut struct {
+	Value        int64      `json:"value"` // Satoshis
+	ScriptPubKey ScriptInfo `json:"scriptPubKey"`
+}
+
+// DecodedInput is an input of a decoded transaction. PrevOut is only set
+// when the spent output is on the chain, in the mempool or, for
+// decodeblock, earlier in the same block.
+type DecodedInput struct {
+	TxID      string          `json:"txid,omitempty"`
+	Vout      uint32          `json:"vout"`
+	Coinbase  string          `json:"coinbase,omitempty"`
+	ScriptSig *ScriptInfo     `json:"scriptSig,omitempty"`
+	Witness   []string        `json:"txinwitness,omitempty"`
+	Sequence  uint32          `json:"sequence"`
+	PrevOut   *DecodedPrevOut `json:"prevout,omitempty"`
+}
+
+// DecodedOutput is an output of a decoded transaction
+type DecodedOutput struct {
+	Value        int64      `json:"value"` // Satoshis
+	N            int        `json:"n"`
+	ScriptPubKey ScriptInfo `json:"scriptPubKey"`
+}
+
+// DecodedTransaction is returned by /decoderawtransaction. Fee is only
+// set when every spent output is known.
+type DecodedTransaction struct {
+	TxID     string          `json:"txid"`
+	Hash     string          `json:"hash"` // Witness hash, equal to TxID without witness data
+	Version  int32           `json:"version"`
+	Size     int             `json:"size"`
+	VSize    int             `json:"vsize"`
+	Weight   int             `json:"weight"`
+	LockTime uint32          `json:"locktime"`
+	Vin      []DecodedInput  `json:"vin"`
+	Vout     []DecodedOutput `json:"vout"`
+	Fee      *int64          `json:"fee,omitempty"` // Satoshis
+}
+
+// DecodedBlock is returned by /decodeblock and by /getblock at verbosity
+// 2. Height is only set when the block is stored, and Confirmations only
+// by /getblock.
+type DecodedBlock struct {
+	Hash          string               `json:"hash"`
+	Height        *uint64              `json:"height,omitempty"`
+	Confirmations int64                `json:"confirmations,omitempty"` // -1 off the best chain
+	Version       int32                `json:"version"`
+	PrevHash      string               `json:"previousblockhash"`
+	MerkleRoot    string               `json:"merkleroot"`
+	Timestamp     uint32               `json:"time"`
+	Bits          uint32               `json:"bits"`
+	Nonce         uint32               `json:"nonce"`
+	Size          int                  `json:"size"`
+	Weight        int                  `json:"weight"`
+	NTx           int                  `json:"nTx"`
+	Tx            []DecodedTransaction `json:"tx"`
+}
+
+// handleDecodeRawTransaction decodes a hex-serialized transaction without
+// checking or relaying it
+func (s *Server) handleDecodeRawTransaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	data, ok := s.readHexData(w, r)
+	if !ok {
+		return
+	}
+
+	reader := bytes.NewReader(data)
+	tx, err := serialization.DeserializeTransaction(reader)
+	if err != nil || reader.Len() != 0 {
+		s.sendError(w, "TX decode failed")
+		return
+	}
+	s.sendSuccess(w, s.decodeTransaction(tx, nil))
+}
+
+// handleDecodeBlock decodes a hex-serialized block without checking or
+// connecting it
+func (s *Server) handleDecodeBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	data, ok := s.readHexData(w, r)
+	if !ok {
+		return
+	}
+
+	block, err := serialization.DeserializeBlock(data)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block decode failed: %v", err))
+		return
+	}
+	hash, err := serialization.HashBlockHeader(&block.Header)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block decode failed: %v", err))
+		return
+	}
+
+	resp := s.decodeBlock(block, hash, len(data))
+	if height, err := s.blockchain.GetBlockHeight(hash); err == nil {
+		resp.Height = &height
+	}
+	s.sendSuccess(w, resp)
+}
+
+// decodeBlock describes a block of size serialized bytes and its
+// transactions
+func (s *Server) decodeBlock(block *types.Block, hash types.Hash, size int) DecodedBlock {
+	weight, _ := validation.BlockWeight(block)
+
+	resp := DecodedBlock{
+		Hash:       hash.String(),
+		Version:    block.Header.Version,
+		PrevHash:   block.Header.PrevBlockHash.String(),
+		MerkleRoot: block.Header.MerkleRoot.String(),
+		Timestamp:  block.Header.Timestamp,
+		Bits:       block.Header.Bits,
+		Nonce:      block.Header.Nonce,
+		Size:       size,
+		Weight:     weight,
+		NTx:        len(block.Transactions),
+		Tx:         make([]DecodedTransaction, len(block.Transactions)),
+	}
+
+	// Transactions may spend outputs created earlier in the block
+	inBlock := make(map[types.Hash]*types.Transaction, len(block.Transactions))
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		resp.Tx[i] = s.decodeTransaction(tx, inBlock)
+		if txHash, err := serialization.HashTransaction(tx); err == nil {
+			inBlock[txHash] = tx
+		}
+	}
+	return resp
+}
+
+// decodeTransaction describes tx, looking up the outputs it spends in
+// local, then the chain, then the mempool
+func (s *Server) decodeTransaction(tx *types.Transaction, local map[types.Hash]*types.Transaction) DecodedTransaction {
+	params := s.netParams()
+	txid, _ := serialization.HashTransaction(tx)
+	wtxid, _ := serialization.HashTransactionWitness(tx)
+
+	decoded := DecodedTransaction{
+		TxID:     txid.String(),
+		Hash:     wtxid.String(),
+		Version:  tx.Version,
+		Size:     transaction.SerializedSize(tx),
+		VSize:    transaction.VirtualSize(tx),
+		Weight:   transaction.Weight(tx),
+		LockTime: tx.LockTime,
+		Vin:      make([]DecodedInput, len(tx.Inputs)),
+		Vout:     make([]DecodedOutput, len(tx.Outputs)),
+	}
+
+	coinbase := transaction.IsCoinbase(tx)
+	var inputValue int64
+	allKnown := !coinbase
+	for i, input := range tx.Inputs {
+		in := DecodedInput{Vout: input.OutputIndex, Sequence: input.Sequence}
+		for _, item := range input.Witness {
+			in.Witness = append(in.Witness, hex.EncodeToString(item))
+		}
+
+		if coinbase {
+			in.Coinbase = hex.EncodeToString(input.SignatureScript)
+			decoded.Vin[i] = in
+			continue
+		}
+		in.TxID = input.PrevTxHash.String()
+		in.ScriptSig = &ScriptInfo{
+			Asm: script.Asm(input.SignatureScript),
+			Hex: hex.EncodeToString(input.SignatureScript),
+		}
+
+		if prevOut, ok := s.lookupPrevOut(input.PrevTxHash, input.OutputIndex, local); ok {
+			in.PrevOut = &DecodedPrevOut{
+				Value:        prevOut.Value,
+				ScriptPubKey: describePkScript(prevOut.PubKeyScript, params),
+			}
+			inputValue += prevOut.Value
+		} else {
+			allKnown = false
+		}
+		decoded.Vin[i] = in
+	}
+
+	var outputValue int64
+	for i, output := range tx.Outputs {
+		decoded.Vout[i] = DecodedOutput{
+			Value:        output.Value,
+			N:            i,
+			ScriptPubKey: describePkScript(output.PubKeyScript, params),
+		}
+		outputValue += output.Value
+	}
+
+	if allKnown {
+		fee := inputValue - outputValue
+		decoded.Fee = &fee
+	}
+	return decoded
+}
+
+// lookupPrevOut finds the output at index of txHash
+func (s *Server) lookupPrevOut(txHash types.Hash, index uint32, local map[types.Hash]*types.Transaction) (types.TxOutput, bool) {
+	if tx, ok := local[txHash]; ok {
+		if int(index) < len(tx.Outputs) {
+			return tx.Outputs[index], true
+		}
+		return types.TxOutput{}, false
+	}
+	if prev, err := validation.LookupOutput(s.blockchain, txHash, index); err == nil {
+		return prev.Output, true
+	}
+	if s.node != nil {
+		if entry, err := s.node.Mempool.Get(txHash); err == nil && int(index) < len(entry.Tx.Outputs) {
+			return entry.Tx.Outputs[index], true
+		}
+	}
+	return types.TxOutput{}, false
+}
+
+// describePkScript decodes a locking script with its type and address
+func describePkScript(pkScript []byte, params *keys.NetParams) ScriptInfo {
+	info := ScriptInfo{
+		Asm:  script.Asm(pkScript),
+		Hex:  hex.EncodeToString(pkScript),
+		Type: script.ClassifyScript(pkScript).String(),
+	}
+	if addr, err := keys.AddressFromScript(pkScript, params); err == nil {
+		info.Address = addr.String()
+	}
+	return info
+}
+
+// netParams returns the network addresses are shown for, taken from the
+// default wallet
+func (s *Server) netParams() *keys.NetParams {
+	if s.wallet == nil {
+		return keys.MainNetParams
+	}
+	return s.wallet.NetParams()
+}
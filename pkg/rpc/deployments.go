@@ -0,0 +1,137 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+)
+
+// DeploymentInfoResponse is returned by /getdeploymentinfo
+type DeploymentInfoResponse struct {
+	Hash        string                    `json:"hash"`
+	Height      uint64                    `json:"height"`
+	Deployments map[string]DeploymentInfo `json:"deployments"`
+}
+
+// DeploymentInfo describes one soft fork. Active and Height refer to the
+// block after the tip, which is the next one the rules apply to.
+type DeploymentInfo struct {
+	Type   string    `json:"type"` // "buried" or "bip9"
+	Active bool      `json:"active"`
+	Height uint64    `json:"height,omitempty"` // Activation height, if known
+	BIP9   *BIP9Info `json:"bip9,omitempty"`
+}
+
+// BIP9Info is the versionbits state of a deployment
+type BIP9Info struct {
+	Bit                 uint8                      `json:"bit"`
+	StartTime           int64                      `json:"start_time"`
+	Timeout             int64                      `json:"timeout"`
+	MinActivationHeight uint64                     `json:"min_activation_height"`
+	Status              string                     `json:"status"`      // At the tip
+	Since               uint64                     `json:"since"`       // Height the status began at
+	StatusNext          string                     `json:"status_next"` // For the next block
+	Statistics          *consensus.DeploymentStats `json:"statistics,omitempty"`
+}
+
+// SetConsensusRules attaches the rules whose deployments getdeploymentinfo
+// reports
+func (s *Server) SetConsensusRules(rules *consensus.ConsensusRules) {
+	s.mu.Lock()
+	s.rules = rules
+	s.versionBits = consensus.NewVersionBits(rules)
+	s.mu.Unlock()
+}
+
+// handleGetDeploymentInfo reports buried and versionbits deployments at
+// the chain tip
+func (s *Server) handleGetDeploymentInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	s.mu.RLock()
+	rules, versionBits := s.rules, s.versionBits
+	s.mu.RUnlock()
+	if rules == nil {
+		s.sendError(w, "consensus rules not configured")
+		return
+	}
+
+	hash, height, err := s.blockchain.GetTip()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to get tip: %v", err))
+		return
+	}
+
+	result := DeploymentInfoResponse{
+		Hash:        hash.String(),
+		Height:      height,
+		Deployments: make(map[string]DeploymentInfo),
+	}
+
+	buried := map[string]uint64{
+		"bip16":  rules.BIP16Height,
+		"bip34":  rules.BIP34Height,
+		"bip65":  rules.BIP65Height,
+		"bip66":  rules.BIP66Height,
+		"segwit": rules.SegWitHeight,
+	}
+	for name, activation := range buried {
+		result.Deployments[name] = DeploymentInfo{
+			Type:   "buried",
+			Active: height+1 >= activation,
+			Height: activation,
+		}
+	}
+
+	for _, d := range rules.Deployments {
+		info, err := s.bip9Info(versionBits, &d, height)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("failed to compute %s state: %v", d.Name, err))
+			return
+		}
+		result.Deployments[d.Name] = *info
+	}
+
+	s.sendSuccess(w, result)
+}
+
+// bip9Info computes the versionbits state of a deployment at the tip
+func (s *Server) bip9Info(versionBits *consensus.VersionBits, d *consensus.Deployment, height uint64) (*DeploymentInfo, error) {
+	status, err := versionBits.State(s.blockchain, d.Name, height)
+	if err != nil {
+		return nil, err
+	}
+	since, err := versionBits.StateSince(s.blockchain, d.Name, height)
+	if err != nil {
+		return nil, err
+	}
+	next, err := versionBits.State(s.blockchain, d.Name, height+1)
+	if err != nil {
+		return nil, err
+	}
+
+	bip9 := &BIP9Info{
+		Bit:                 d.Bit,
+		StartTime:           d.StartTime,
+		Timeout:             d.Timeout,
+		MinActivationHeight: d.MinActivationHeight,
+		Status:              status.String(),
+		Since:               since,
+		StatusNext:          next.String(),
+	}
+	if status == consensus.ThresholdStarted {
+		if bip9.Statistics, err = versionBits.Statistics(s.blockchain, d.Name, height); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &DeploymentInfo{Type: "bip9", Active: next == consensus.ThresholdActive, BIP9: bip9}
+	if status == consensus.ThresholdActive {
+		info.Height = since
+	}
+	return info, nil
+}
@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// KeySpaceUsageInfo is the space one kind of database entry takes
+type KeySpaceUsageInfo struct {
+	Name      string `json:"name"`
+	Entries   int64  `json:"entries"`
+	Bytes     int64  `json:"bytes"`      // Keys and values, uncompressed
+	DiskBytes int64  `json:"disk_bytes"` // Estimated, compressed, in table files
+}
+
+// DiskUsageResponse is returned by /getdiskusage
+type DiskUsageResponse struct {
+	TotalBytes int64               `json:"total_bytes"`
+	KeySpaces  []KeySpaceUsageInfo `json:"keyspaces"`
+}
+
+// CompactResponse is returned by /compactdb
+type CompactResponse struct {
+	BytesBefore int64 `json:"bytes_before"`
+	BytesAfter  int64 `json:"bytes_after"`
+}
+
+// handleGetDiskUsage reports the chain database's size, overall and per
+// kind of entry
+func (s *Server) handleGetDiskUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	usage, err := s.blockchain.GetDiskUsage()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to measure disk usage: %v", err))
+		return
+	}
+	resp := DiskUsageResponse{
+		TotalBytes: usage.Total,
+		KeySpaces:  make([]KeySpaceUsageInfo, 0, len(usage.KeySpaces)),
+	}
+	for _, space := range usage.KeySpaces {
+		resp.KeySpaces = append(resp.KeySpaces, KeySpaceUsageInfo{
+			Name:      space.Name,
+			Entries:   space.Entries,
+			Bytes:     space.Bytes,
+			DiskBytes: space.DiskBytes,
+		})
+	}
+	s.sendSuccess(w, resp)
+}
+
+// handleCompactDB compacts the chain database and reports the space
+// reclaimed
+func (s *Server) handleCompactDB(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	before, after, err := s.blockchain.Compact()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to compact database: %v", err))
+		return
+	}
+	s.sendSuccess(w, CompactResponse{BytesBefore: before, BytesAfter: after})
+}
@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+)
+
+// ReadinessConfig sets when /readyz reports the node as ready to serve
+type ReadinessConfig struct {
+	MinPeers   int           // Connected peers required (ignored without a P2P node)
+	MaxSyncLag uint64        // Blocks the chain may trail the best header by
+	MaxTipAge  time.Duration // Oldest acceptable tip timestamp, 0 = no limit
+}
+
+// DefaultReadinessConfig returns thresholds similar to Bitcoin Core's
+// initial block download check (tip younger than a day)
+func DefaultReadinessConfig() ReadinessConfig {
+	return ReadinessConfig{
+		MinPeers:   1,
+		MaxSyncLag: 1,
+		MaxTipAge:  24 * time.Hour,
+	}
+}
+
+// HealthStatus is returned by /healthz and /readyz
+type HealthStatus struct {
+	Status        string   `json:"status"` // "ok" or "unavailable"
+	DBOpen        bool     `json:"db_open"`
+	Blocks        uint64   `json:"blocks"`
+	Headers       uint64   `json:"headers"`
+	Peers         int      `json:"peers"`
+	LastBlockTime int64    `json:"last_block_time"`
+	LastBlockAge  int64    `json:"last_block_age"` // Seconds
+	Problems      []string `json:"problems,omitempty"`
+}
+
+// SetReadiness replaces the thresholds used by /readyz
+func (s *Server) SetReadiness(config ReadinessConfig) {
+	s.mu.Lock()
+	s.readiness = config
+	s.mu.Unlock()
+}
+
+// registerHealthHandlers mounts the probes. They skip rate limiting so an
+// orchestrator polling them can't starve or be starved by RPC clients.
+func (s *Server) registerHealthHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+}
+
+// handleHealthz reports liveness: the process is up and the database usable
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	status := s.healthStatus()
+	if status.DBOpen {
+		// Liveness ignores sync state so a syncing node isn't restarted
+		status.Problems = nil
+	}
+	s.sendHealth(w, status)
+}
+
+// handleReadyz reports readiness: synced, connected and with a recent tip
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	s.sendHealth(w, s.healthStatus())
+}
+
+// healthStatus gathers the node state and lists every readiness problem
+func (s *Server) healthStatus() HealthStatus {
+	s.mu.RLock()
+	config := s.readiness
+	s.mu.RUnlock()
+
+	var status HealthStatus
+	if err := s.blockchain.Ping(); err != nil {
+		status.Problems = append(status.Problems, err.Error())
+		return status
+	}
+	status.DBOpen = true
+
+	block, height, err := s.blockchain.GetBestBlock()
+	if errors.Is(err, storage.ErrEmptyChain) {
+		status.Problems = append(status.Problems, "no blocks")
+	} else if err != nil {
+		status.Problems = append(status.Problems, fmt.Sprintf("failed to read tip: %v", err))
+	} else {
+		status.Blocks = height
+		status.LastBlockTime = int64(block.Header.Timestamp)
+		status.LastBlockAge = int64(time.Since(time.Unix(status.LastBlockTime, 0)).Seconds())
+
+		if config.MaxTipAge > 0 && time.Duration(status.LastBlockAge)*time.Second > config.MaxTipAge {
+			status.Problems = append(status.Problems, "tip is too old")
+		}
+	}
+	status.Headers = status.Blocks
+
+	if s.node != nil {
+		status.Peers = s.node.PeerCount()
+		if headers := s.node.SyncManager.BestHeaderHeight(); headers > status.Headers {
+			status.Headers = headers
+		}
+
+		if status.Peers < config.MinPeers {
+			status.Problems = append(status.Problems, "not enough peers")
+		}
+		if status.Headers-status.Blocks > config.MaxSyncLag {
+			status.Problems = append(status.Problems, "still syncing")
+		}
+	}
+
+	return status
+}
+
+// sendHealth writes status with 200 if there are no problems, 503 otherwise
+func (s *Server) sendHealth(w http.ResponseWriter, status HealthStatus) {
+	code := http.StatusOK
+	status.Status = "ok"
+	if len(status.Problems) > 0 {
+		code = http.StatusServiceUnavailable
+		status.Status = "unavailable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
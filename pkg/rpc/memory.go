@@ -0,0 +1,89 @@
+package rpc
+
+import (
+	"net/http"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// ComponentMemory is the estimated footprint of one in-memory structure
+type ComponentMemory struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// FilterInfo describes the filter in front of the UTXO storage and how
+// many disk lookups it saved
+type FilterInfo struct {
+	ComponentMemory
+	Capacity          int     `json:"capacity"`
+	Checks            uint64  `json:"checks"`
+	Skipped           uint64  `json:"skipped"` // Answered "absent" without a disk lookup
+	FalsePositives    uint64  `json:"false_positives"`
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+	Rebuilds          uint64  `json:"rebuilds"`
+}
+
+// MemoryInfoResponse is returned by /getmemoryinfo. Components the server
+// has no access to are omitted.
+type MemoryInfoResponse struct {
+	Runtime    monitoring.MemoryInfo `json:"runtime"`
+	Mempool    *ComponentMemory      `json:"mempool,omitempty"`
+	UTXOCache  *ComponentMemory      `json:"utxo_cache,omitempty"`
+	UTXOFilter *FilterInfo           `json:"utxo_filter,omitempty"` // Only with a filter enabled
+	BlockIndex *ComponentMemory      `json:"block_index,omitempty"` // Orphans and in-flight blocks
+}
+
+// SetUTXOCache attaches the UTXO cache reported by getmemoryinfo, along
+// with the filter of its storage
+func (s *Server) SetUTXOCache(cache *utxo.UTXOCache) {
+	s.mu.Lock()
+	s.utxoCache = cache
+	s.mu.Unlock()
+}
+
+// handleGetMemoryInfo returns heap usage overall and per component
+func (s *Server) handleGetMemoryInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	result := MemoryInfoResponse{Runtime: monitoring.ReadMemoryInfo()}
+
+	if s.node != nil {
+		result.Mempool = &ComponentMemory{
+			Entries: s.node.Mempool.Size(),
+			Bytes:   s.node.Mempool.GetMemoryUsage(),
+		}
+
+		entries, bytes := s.node.SyncManager.MemoryUsage()
+		result.BlockIndex = &ComponentMemory{Entries: entries, Bytes: bytes}
+	}
+
+	s.mu.RLock()
+	cache := s.utxoCache
+	s.mu.RUnlock()
+	if cache != nil {
+		result.UTXOCache = &ComponentMemory{
+			Entries: cache.Size(),
+			Bytes:   cache.MemoryUsage(),
+		}
+		if store := cache.Storage(); store != nil {
+			if stats := store.FilterStats(); stats != nil {
+				result.UTXOFilter = &FilterInfo{
+					ComponentMemory:   ComponentMemory{Entries: stats.Entries, Bytes: stats.Bytes},
+					Capacity:          stats.Capacity,
+					Checks:            stats.Checks,
+					Skipped:           stats.Skipped,
+					FalsePositives:    stats.FalsePositives,
+					FalsePositiveRate: stats.FalsePositiveRate(),
+					Rebuilds:          stats.Rebuilds,
+				}
+			}
+		}
+	}
+
+	s.sendSuccess(w, result)
+}
@@ -0,0 +1,320 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+)
+
+// MaxMempoolSnapshots is how many snapshots the server keeps for
+// getmempooldiff. Taking another forgets the oldest.
+const MaxMempoolSnapshots = 100
+
+// MempoolInfoResponse is returned by /getmempoolinfo
+type MempoolInfoResponse struct {
+	Size           int               `json:"size"`                // Transactions
+	Bytes          int64             `json:"bytes"`               // Sum of transaction sizes
+	Usage          int64             `json:"usage"`               // Estimated memory usage, bytes
+	UsageSplit     MempoolUsageSplit `json:"usage_split"`         // Usage by what holds it
+	TotalFee       int64             `json:"total_fee"`           // Satoshis
+	MaxMempool     int64             `json:"maxmempool"`          // Bound on Usage, bytes
+	MinFeeRate     int64             `json:"mempoolminfee"`       // sat/kvB
+	MinRelayFee    int64             `json:"minrelaytxfee"`       // sat/kvB
+	IncrementalFee int64             `json:"incrementalrelayfee"` // sat/kvB
+	Replaceable    int               `json:"replaceable"`         // Transactions signalling BIP125
+	OldestEntered  int64             `json:"oldest_entered"`      // Unix time, 0 when empty
+}
+
+// MempoolUsageSplit is the mempool's estimated memory usage, in bytes, by
+// what holds it
+type MempoolUsageSplit struct {
+	Transactions int64 `json:"transactions"` // The decoded transactions
+	Entries      int64 `json:"entries"`      // Entry metadata and the txid map
+	Spends       int64 `json:"spends"`       // The spent outpoint index
+	Links        int64 `json:"links"`        // Parent and child lists
+}
+
+// MempoolTxInfo is a transaction listed by /getrawmempool?verbose=true
+type MempoolTxInfo struct {
+	TxID         string   `json:"txid"`
+	Size         int64    `json:"size"`
+	Fee          int64    `json:"fee"`
+	FeeRate      int64    `json:"feerate"` // sat/kvB
+	Time         int64    `json:"time"`
+	Height       uint64   `json:"height"`
+	Depends      []string `json:"depends"`
+	AncestorFee  int64    `json:"ancestorfee"`
+	AncestorSize int64    `json:"ancestorsize"`
+	Replaceable  bool     `json:"bip125-replaceable"`
+
+	// Coin-age priority, when the node tracks it
+	StartingPriority float64 `json:"startingpriority,omitempty"` // When it entered
+	CurrentPriority  float64 `json:"currentpriority,omitempty"`  // In the next block
+}
+
+// RawMempoolResponse is returned by /getrawmempool. Entries is only
+// filled in verbose mode.
+type RawMempoolResponse struct {
+	TxIDs   []string        `json:"txids"`
+	Entries []MempoolTxInfo `json:"entries,omitempty"`
+}
+
+// SnapshotTxInfo is a transaction in a mempool snapshot or diff
+type SnapshotTxInfo struct {
+	TxID    string `json:"txid"`
+	Size    int64  `json:"size"`
+	Fee     int64  `json:"fee"`
+	FeeRate int64  `json:"feerate"` // sat/kvB
+}
+
+// MempoolSnapshotResponse is returned by /getmempoolsnapshot. Pass ID to
+// getmempooldiff to see what changed since.
+type MempoolSnapshotResponse struct {
+	ID           uint64           `json:"id"`
+	Time         int64            `json:"time"`
+	Height       uint64           `json:"height"`
+	Transactions []SnapshotTxInfo `json:"transactions"` // Highest fee rate first
+}
+
+// MempoolDiffResponse is returned by /getmempooldiff. Transactions that
+// left are listed by why; removed ones left for no recorded reason.
+type MempoolDiffResponse struct {
+	From       uint64           `json:"from"`
+	To         uint64           `json:"to"`
+	Added      []SnapshotTxInfo `json:"added"`
+	Mined      []SnapshotTxInfo `json:"mined"`
+	Evicted    []SnapshotTxInfo `json:"evicted"`
+	Expired    []SnapshotTxInfo `json:"expired"`
+	Replaced   []SnapshotTxInfo `json:"replaced"`
+	Conflicted []SnapshotTxInfo `json:"conflicted"`
+	Removed    []SnapshotTxInfo `json:"removed"`
+}
+
+// handleGetMempoolInfo summarizes the mempool
+func (s *Server) handleGetMempoolInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	pool := s.node.Mempool
+	usage := pool.MemoryUsage()
+	info := MempoolInfoResponse{
+		Usage: usage.Total(),
+		UsageSplit: MempoolUsageSplit{
+			Transactions: usage.Transactions,
+			Entries:      usage.Entries,
+			Spends:       usage.Spends,
+			Links:        usage.Links,
+		},
+		MaxMempool:     pool.MaxSize(),
+		MinFeeRate:     pool.MinFeeRate(),
+		MinRelayFee:    pool.MinFeeRate(),
+		IncrementalFee: pool.IncrementalRelayFee(),
+	}
+	for _, entry := range pool.GetAllTransactions() {
+		info.Size++
+		info.Bytes += entry.Size
+		info.TotalFee += entry.Fee
+		if entry.SignalsRBF {
+			info.Replaceable++
+		}
+		if info.OldestEntered == 0 || entry.Time < info.OldestEntered {
+			info.OldestEntered = entry.Time
+		}
+	}
+
+	s.sendSuccess(w, info)
+}
+
+// handleGetRawMempool lists the mempool's transactions, highest fee rate
+// first. With verbose=true each comes with its fee and dependencies.
+func (s *Server) handleGetRawMempool(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	verbose := false
+	if v := r.URL.Query().Get("verbose"); v != "" {
+		var err error
+		if verbose, err = strconv.ParseBool(v); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid verbose: %s", v))
+			return
+		}
+	}
+
+	entries := s.node.Mempool.GetAllTransactions()
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].FeeRate != entries[j].FeeRate {
+			return entries[i].FeeRate > entries[j].FeeRate
+		}
+		return entries[i].TxHash.String() < entries[j].TxHash.String()
+	})
+
+	// Priority grows with each block, so it is given for the next one
+	nextHeight := uint64(0)
+	if _, height, err := s.blockchain.GetTip(); err == nil {
+		nextHeight = height + 1
+	}
+
+	resp := RawMempoolResponse{TxIDs: make([]string, len(entries))}
+	for i, entry := range entries {
+		resp.TxIDs[i] = entry.TxHash.String()
+		if !verbose {
+			continue
+		}
+		depends := make([]string, len(entry.Parents))
+		for j, parent := range entry.Parents {
+			depends[j] = parent.String()
+		}
+		resp.Entries = append(resp.Entries, MempoolTxInfo{
+			TxID:         entry.TxHash.String(),
+			Size:         entry.Size,
+			Fee:          entry.Fee,
+			FeeRate:      entry.FeeRate,
+			Time:         entry.Time,
+			Height:       entry.Height,
+			Depends:      depends,
+			AncestorFee:  entry.AncestorFee,
+			AncestorSize: entry.AncestorSize,
+			Replaceable:  entry.SignalsRBF,
+
+			StartingPriority: entry.StartingPriority,
+			CurrentPriority:  entry.Priority(nextHeight),
+		})
+	}
+
+	s.sendSuccess(w, resp)
+}
+
+// handleGetMempoolSnapshot captures the mempool's contents and keeps them
+// for later diffs
+func (s *Server) handleGetMempoolSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	snapshot := s.node.Mempool.Snapshot()
+	s.sendSuccess(w, MempoolSnapshotResponse{
+		ID:           s.saveSnapshot(snapshot),
+		Time:         snapshot.Time,
+		Height:       snapshot.Height,
+		Transactions: snapshotTxInfos(snapshot.List()),
+	})
+}
+
+// handleGetMempoolDiff compares the snapshot from with the snapshot to, or
+// with the mempool now if to is omitted. The current contents are then
+// kept as a new snapshot, so a poller can pass the returned To next time.
+func (s *Server) handleGetMempoolDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	query := r.URL.Query()
+	fromID, err := strconv.ParseUint(query.Get("from"), 10, 64)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid from: %s", query.Get("from")))
+		return
+	}
+	from, err := s.snapshot(fromID)
+	if err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+
+	var to *mempool.Snapshot
+	var toID uint64
+	if value := query.Get("to"); value != "" {
+		if toID, err = strconv.ParseUint(value, 10, 64); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid to: %s", value))
+			return
+		}
+		if to, err = s.snapshot(toID); err != nil {
+			s.sendError(w, err.Error())
+			return
+		}
+	} else {
+		to = s.node.Mempool.Snapshot()
+		toID = s.saveSnapshot(to)
+	}
+
+	diff := s.node.Mempool.DiffSnapshots(from, to)
+	s.sendSuccess(w, MempoolDiffResponse{
+		From:       fromID,
+		To:         toID,
+		Added:      snapshotTxInfos(diff.Added),
+		Mined:      snapshotTxInfos(diff.Mined),
+		Evicted:    snapshotTxInfos(diff.Evicted),
+		Expired:    snapshotTxInfos(diff.Expired),
+		Replaced:   snapshotTxInfos(diff.Replaced),
+		Conflicted: snapshotTxInfos(diff.Conflicted),
+		Removed:    snapshotTxInfos(diff.Removed),
+	})
+}
+
+// saveSnapshot keeps a snapshot under a new id, forgetting the oldest
+// beyond MaxMempoolSnapshots
+func (s *Server) saveSnapshot(snapshot *mempool.Snapshot) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.snapshots == nil {
+		s.snapshots = make(map[uint64]*mempool.Snapshot)
+	}
+	s.lastSnapshotID++
+	s.snapshots[s.lastSnapshotID] = snapshot
+	if s.lastSnapshotID > MaxMempoolSnapshots {
+		delete(s.snapshots, s.lastSnapshotID-MaxMempoolSnapshots)
+	}
+	return s.lastSnapshotID
+}
+
+// snapshot returns a kept snapshot
+func (s *Server) snapshot(id uint64) (*mempool.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot, ok := s.snapshots[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown mempool snapshot %d", id)
+	}
+	return snapshot, nil
+}
+
+// snapshotTxInfos converts snapshot entries for a response, never nil so
+// empty lists encode as []
+func snapshotTxInfos(entries []mempool.SnapshotEntry) []SnapshotTxInfo {
+	infos := make([]SnapshotTxInfo, len(entries))
+	for i, entry := range entries {
+		infos[i] = SnapshotTxInfo{
+			TxID:    entry.TxHash.String(),
+			Size:    entry.Size,
+			Fee:     entry.Fee,
+			FeeRate: entry.FeeRate,
+		}
+	}
+	return infos
+}
@@ -0,0 +1,460 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// DefaultHashRateBlocks is how many recent blocks the network hash rate
+// is estimated over when the caller doesn't say
+const DefaultHashRateBlocks = 120
+
+// defaultMaxBlockSize bounds the block template when no consensus rules
+// are configured
+const defaultMaxBlockSize = 1000000
+
+// DifficultyResponse is returned by /getdifficulty
+type DifficultyResponse struct {
+	Difficulty float64 `json:"difficulty"`
+}
+
+// MiningInfoResponse is returned by /getmininginfo. The template and local
+// miner fields are omitted when the server has no node or miner.
+type MiningInfoResponse struct {
+	Blocks        uint64  `json:"blocks"`
+	Bits          string  `json:"bits"`
+	Difficulty    float64 `json:"difficulty"`
+	NetworkHashPS float64 `json:"networkhashps"`
+
+	PooledTx         *int   `json:"pooledtx,omitempty"`
+	CurrentBlockTx   *int   `json:"currentblocktx,omitempty"`
+	CurrentBlockSize *int64 `json:"currentblocksize,omitempty"`
+	CurrentBlockFees *int64 `json:"currentblockfees,omitempty"`
+
+	Miner *LocalMinerInfo `json:"miner,omitempty"`
+}
+
+// NetworkHashPSResponse is returned by /getnetworkhashps. LocalHashPS is
+// omitted when the server has no miner.
+type NetworkHashPSResponse struct {
+	NetworkHashPS float64  `json:"networkhashps"`
+	Height        uint64   `json:"height"` // Last block of the window
+	Blocks        uint64   `json:"blocks"` // Window size
+	LocalHashPS   *float64 `json:"localhashps,omitempty"`
+}
+
+// LocalMinerInfo reports this node's own miner
+type LocalMinerInfo struct {
+	HashesPerSec float64 `json:"hashespersec"`
+	Attempts     uint64  `json:"attempts"`
+	StartTime    int64   `json:"starttime,omitempty"` // Of the current or last run
+}
+
+// BlockTemplateResponse is returned by /getblocktemplate (BIP22). Pass
+// LongPollID back to wait for the next template.
+type BlockTemplateResponse struct {
+	Version           int32                 `json:"version"`
+	PreviousBlockHash string                `json:"previousblockhash"`
+	Coinbase          string                `json:"coinbasetxn"` // Hex, paying the node's miner address
+	Transactions      []TemplateTransaction `json:"transactions"`
+	CoinbaseValue     int64                 `json:"coinbasevalue"`
+	Fees              int64                 `json:"fees"`
+	Target            string                `json:"target"`
+	CurTime           uint32                `json:"curtime"`
+	Bits              string                `json:"bits"`
+	Height            uint64                `json:"height"`
+	LongPollID        string                `json:"longpollid"`
+}
+
+// TemplateTransaction is a non-coinbase transaction in a block template
+type TemplateTransaction struct {
+	Data   string `json:"data"` // Hex, with witness data
+	TxID   string `json:"txid"`
+	Weight int    `json:"weight"`
+}
+
+// SubmitBlockResponse is returned by /submitblock and /submitheader.
+// Rejections carry a BIP22 reason such as "duplicate" or "high-hash". A
+// rejected block also lists every rule it was checked against, when the
+// server can check blocks.
+type SubmitBlockResponse struct {
+	Accepted bool                 `json:"accepted"`
+	Reason   string               `json:"reason,omitempty"`
+	Message  string               `json:"message,omitempty"`
+	Report   *BlockReportResponse `json:"report,omitempty"`
+}
+
+// SetBlockTemplateCache attaches the cache getblocktemplate serves from
+func (s *Server) SetBlockTemplateCache(cache *mining.TemplateCache) {
+	s.mu.Lock()
+	s.templates = cache
+	s.mu.Unlock()
+}
+
+// SetMiner attaches the local miner reported by getmininginfo
+func (s *Server) SetMiner(miner *mining.Miner) {
+	s.mu.Lock()
+	s.miner = miner
+	s.mu.Unlock()
+}
+
+// handleGetDifficulty returns the difficulty of the tip's target
+func (s *Server) handleGetDifficulty(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	tip, _, err := s.blockchain.GetBestBlock()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to get best block: %v", err))
+		return
+	}
+
+	s.sendSuccess(w, DifficultyResponse{Difficulty: consensus.GetDifficulty(tip.Header.Bits)})
+}
+
+// handleGetMiningInfo reports the tip's difficulty, the network hash rate
+// over the last nblocks blocks, the block template the mempool would give
+// and the local miner's rate
+func (s *Server) handleGetMiningInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	nblocks := uint64(DefaultHashRateBlocks)
+	if value := r.URL.Query().Get("nblocks"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || n == 0 {
+			s.sendError(w, fmt.Sprintf("invalid nblocks: %s", value))
+			return
+		}
+		nblocks = n
+	}
+
+	tip, height, err := s.blockchain.GetBestBlock()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to get best block: %v", err))
+		return
+	}
+
+	hashRate, err := consensus.NetworkHashRate(s.blockchain, height, nblocks)
+	if err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+
+	result := MiningInfoResponse{
+		Blocks:        height,
+		Bits:          fmt.Sprintf("%08x", tip.Header.Bits),
+		Difficulty:    consensus.GetDifficulty(tip.Header.Bits),
+		NetworkHashPS: hashRate,
+	}
+
+	s.mu.RLock()
+	rules, miner := s.rules, s.miner
+	s.mu.RUnlock()
+
+	if s.node != nil {
+		maxSize := int64(defaultMaxBlockSize)
+		if rules != nil {
+			maxSize = int64(rules.MaxBlockSize)
+		}
+		pooled := s.node.Mempool.Size()
+		queue := mempool.NewPriorityQueue(s.node.Mempool)
+		queue.Build()
+		template, err := queue.CreateBlockTemplate(maxSize, 0)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("failed to create block template: %v", err))
+			return
+		}
+		result.PooledTx = &pooled
+		result.CurrentBlockTx = &template.TxCount
+		result.CurrentBlockSize = &template.TotalSize
+		result.CurrentBlockFees = &template.TotalFees
+	}
+
+	if miner != nil {
+		stats := miner.GetStats()
+		result.Miner = &LocalMinerInfo{
+			HashesPerSec: stats.HashRate,
+			Attempts:     stats.Attempts,
+		}
+		if !stats.StartTime.IsZero() {
+			result.Miner.StartTime = stats.StartTime.Unix()
+		}
+	}
+
+	s.sendSuccess(w, result)
+}
+
+// handleGetNetworkHashPS estimates the network hash rate over the nblocks
+// blocks ending at height, the tip by default, next to the local miner's
+func (s *Server) handleGetNetworkHashPS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	nblocks := uint64(DefaultHashRateBlocks)
+	if value := query.Get("nblocks"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || n == 0 {
+			s.sendError(w, fmt.Sprintf("invalid nblocks: %s", value))
+			return
+		}
+		nblocks = n
+	}
+
+	_, height, err := s.blockchain.GetTip()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to get best block: %v", err))
+		return
+	}
+	if value := query.Get("height"); value != "" { // ruleHeight refuses heights above the tip
+		h, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("invalid height: %s", value))
+			return
+		}
+		height = h
+	}
+
+	hashRate, err := consensus.NetworkHashRate(s.blockchain, height, nblocks)
+	if err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+	result := NetworkHashPSResponse{
+		NetworkHashPS: hashRate,
+		Height:        height,
+		Blocks:        min(nblocks, height),
+	}
+
+	s.mu.RLock()
+	miner := s.miner
+	s.mu.RUnlock()
+	if miner != nil {
+		local := miner.GetStats().HashRate
+		result.LocalHashPS = &local
+	}
+
+	s.sendSuccess(w, result)
+}
+
+// handleGetBlockTemplate returns a template to mine on. With longpollid it
+// waits until the tip changes or the mempool has materially more fees.
+// POSTing a template request in "proposal" mode checks a block instead.
+func (s *Server) handleGetBlockTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s.handleBlockProposal(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	s.mu.RLock()
+	cache := s.templates
+	s.mu.RUnlock()
+	if cache == nil {
+		s.sendError(w, "block templates not configured")
+		return
+	}
+
+	template, longPollID, err := cache.Get(r.Context(), r.URL.Query().Get("longpollid"))
+	if errors.Is(err, context.Canceled) {
+		return // Client went away or the server is stopping
+	}
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to get block template: %v", err))
+		return
+	}
+
+	// BuildBlock adds the witness commitment the coinbase needs
+	block, err := mining.BuildBlock(template, 0)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to assemble template: %v", err))
+		return
+	}
+
+	coinbase, err := serialization.SerializeTransactionWitness(&block.Transactions[0])
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to serialize coinbase: %v", err))
+		return
+	}
+	result := BlockTemplateResponse{
+		Version:           template.Version,
+		PreviousBlockHash: template.PrevBlockHash.String(),
+		Coinbase:          hex.EncodeToString(coinbase),
+		Transactions:      make([]TemplateTransaction, 0, len(block.Transactions)-1),
+		Fees:              template.TotalFees,
+		Target:            fmt.Sprintf("%064x", consensus.CompactToTarget(template.Bits)),
+		CurTime:           template.Timestamp,
+		Bits:              fmt.Sprintf("%08x", template.Bits),
+		Height:            template.Height,
+		LongPollID:        longPollID,
+	}
+	for _, output := range block.Transactions[0].Outputs {
+		result.CoinbaseValue += output.Value
+	}
+	for i := 1; i < len(block.Transactions); i++ {
+		tx := &block.Transactions[i]
+		data, err := serialization.SerializeTransactionWitness(tx)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("failed to serialize transaction: %v", err))
+			return
+		}
+		txid, err := serialization.HashTransaction(tx)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("failed to hash transaction: %v", err))
+			return
+		}
+		result.Transactions = append(result.Transactions, TemplateTransaction{
+			Data:   hex.EncodeToString(data),
+			TxID:   txid.String(),
+			Weight: transaction.Weight(tx),
+		})
+	}
+
+	s.sendSuccess(w, result)
+}
+
+// handleBlockProposal validates a hex-serialized block against the tip
+// without its proof of work and without storing it. The result is null for
+// a valid block and the BIP22 rejection reason otherwise, or with verbose
+// set a report of every rule.
+func (s *Server) handleBlockProposal(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Mode    string `json:"mode"`
+		Data    string `json:"data"`
+		Verbose bool   `json:"verbose"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.Mode != "proposal" {
+		s.sendError(w, fmt.Sprintf("invalid mode: %q", req.Mode))
+		return
+	}
+	data, err := hex.DecodeString(req.Data)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid data: %v", err))
+		return
+	}
+	block, err := serialization.DeserializeBlock(data)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block decode failed: %v", err))
+		return
+	}
+
+	report, err := s.checkBlockAtTip(block)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to check block: %v", err))
+		return
+	}
+	if req.Verbose {
+		s.sendSuccess(w, newBlockReportResponse(report))
+		return
+	}
+	if err := report.Err(); err != nil {
+		s.sendSuccess(w, validation.RejectReason(err))
+		return
+	}
+	s.sendSuccess(w, nil)
+}
+
+// handleSubmitBlock connects a hex-serialized block as if a peer had sent
+// it. A block that fails validation is a normal result, not an error.
+func (s *Server) handleSubmitBlock(w http.ResponseWriter, r *http.Request) {
+	data, ok := s.readSubmission(w, r)
+	if !ok {
+		return
+	}
+
+	block, err := serialization.DeserializeBlock(data)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block decode failed: %v", err))
+		return
+	}
+	result := submitResult(s.node.ProcessNewBlock(block))
+	if !result.Accepted && result.Reason != validation.RejectDuplicate {
+		// Diagnostics only: a block that can't be checked is still rejected
+		if report, err := s.checkBlockAtTip(block); err == nil {
+			result.Report = newBlockReportResponse(report)
+		}
+	}
+	s.sendSuccess(w, result)
+}
+
+// handleSubmitHeader accepts a hex-serialized header building on a stored
+// block, listing it as a headers-only chain tip until its block arrives
+func (s *Server) handleSubmitHeader(w http.ResponseWriter, r *http.Request) {
+	data, ok := s.readSubmission(w, r)
+	if !ok {
+		return
+	}
+
+	reader := bytes.NewReader(data)
+	header, err := serialization.DeserializeBlockHeader(reader)
+	if err != nil || reader.Len() != 0 {
+		s.sendError(w, "header decode failed")
+		return
+	}
+	s.sendSuccess(w, submitResult(s.node.ProcessNewHeader(header)))
+}
+
+// readSubmission decodes the hexdata parameter of a submit call
+func (s *Server) readSubmission(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return nil, false
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return nil, false
+	}
+	return s.readHexData(w, r)
+}
+
+// readHexData decodes the hexdata field of a JSON request body
+func (s *Server) readHexData(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var req struct {
+		HexData string `json:"hexdata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return nil, false
+	}
+	data, err := hex.DecodeString(req.HexData)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid hexdata: %v", err))
+		return nil, false
+	}
+	return data, true
+}
+
+// submitResult turns the outcome of a submission into its response
+func submitResult(err error) SubmitBlockResponse {
+	if err == nil {
+		return SubmitBlockResponse{Accepted: true}
+	}
+	return SubmitBlockResponse{Reason: validation.RejectReason(err), Message: err.Error()}
+}
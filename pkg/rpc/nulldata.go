@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// NullDataInfo is an indexed OP_RETURN payload
+type NullDataInfo struct {
+	TxID   string `json:"txid"`
+	Vout   uint32 `json:"vout"`
+	Height uint64 `json:"height"`
+	Data   string `json:"data"` // Hex payload
+}
+
+// NullDataResponse is returned by /listnulldata
+type NullDataResponse struct {
+	Entries []NullDataInfo `json:"entries"`
+}
+
+// handleListNullData lists indexed OP_RETURN payloads, oldest first. The
+// optional prefix (hex) keeps only payloads starting with it, e.g. a
+// protocol tag, and from skips blocks below that height.
+func (s *Server) handleListNullData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if !s.blockchain.NullDataIndexEnabled() {
+		s.sendError(w, "OP_RETURN index not enabled")
+		return
+	}
+
+	query := r.URL.Query()
+	prefix, err := hex.DecodeString(query.Get("prefix"))
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid prefix: %v", err))
+		return
+	}
+	var from uint64
+	if value := query.Get("from"); value != "" {
+		if from, err = strconv.ParseUint(value, 10, 64); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid from: %v", err))
+			return
+		}
+	}
+
+	entries, err := s.blockchain.FindNullData(prefix, from)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to read index: %v", err))
+		return
+	}
+
+	result := NullDataResponse{Entries: make([]NullDataInfo, len(entries))}
+	for i, entry := range entries {
+		result.Entries[i] = NullDataInfo{
+			TxID:   entry.TxHash.String(),
+			Vout:   entry.Index,
+			Height: entry.Height,
+			Data:   hex.EncodeToString(entry.Data),
+		}
+	}
+	s.sendSuccess(w, result)
+}
@@ -0,0 +1,88 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/security"
+)
+
+// MethodClass groups RPC endpoints that share a rate limit
+type MethodClass int
+
+const (
+	// ClassReadOnly covers queries that don't change any state
+	ClassReadOnly MethodClass = iota
+
+	// ClassWallet covers wallet commands and anything that changes node
+	// state (sending coins, banning peers, ...)
+	ClassWallet
+)
+
+// RateLimit is a per-client token bucket: Rate requests per second with
+// bursts of up to Burst. A zero Rate disables limiting for the class.
+type RateLimit struct {
+	Rate  int
+	Burst int
+}
+
+// RateLimitConfig holds the limits for each method class
+type RateLimitConfig struct {
+	ReadOnly RateLimit
+	Wallet   RateLimit
+}
+
+// DefaultRateLimitConfig returns limits generous enough for scripts polling
+// the node, but that stop a client from hammering sendtoaddress
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		ReadOnly: RateLimit{Rate: 50, Burst: 100},
+		Wallet:   RateLimit{Rate: 5, Burst: 10},
+	}
+}
+
+// SetRateLimits replaces the per-client limits. Buckets start full.
+func (s *Server) SetRateLimits(config RateLimitConfig) {
+	limiters := make(map[MethodClass]*security.ConnectionRateLimiter)
+	for class, limit := range map[MethodClass]RateLimit{
+		ClassReadOnly: config.ReadOnly,
+		ClassWallet:   config.Wallet,
+	} {
+		if limit.Rate > 0 {
+			limiters[class] = security.NewConnectionRateLimiter(0, limit.Rate, limit.Burst)
+		}
+	}
+
+	s.mu.Lock()
+	s.limiters = limiters
+	s.mu.Unlock()
+}
+
+// limit wraps a handler with the rate limit for its method class
+func (s *Server) limit(class MethodClass, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		limiter := s.limiters[class]
+		s.mu.RUnlock()
+
+		if limiter != nil && !limiter.AllowConnection(clientIP(r)) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(Response{Error: "rate limit exceeded"})
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// clientIP returns the remote IP of a request, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,46 +1,241 @@
 package rpc
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/security"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/watch"
 )
 
 // Server represents the RPC server
 type Server struct {
-	wallet     *wallet.Wallet
+	wallet     *wallet.Wallet            // Default wallet
+	wallets    map[string]*wallet.Wallet // Named wallets in walletDir
+	walletDir  string                    // Optional, enables createwallet
 	blockchain *storage.BlockchainStorage
+	node       *network.Node // Optional, enables the network commands
 	addr       string
+	validator  *security.InputValidator
+
+	limiters  map[MethodClass]*security.ConnectionRateLimiter
+	readiness ReadinessConfig
+	utxoCache *utxo.UTXOCache       // Optional, reported by getmemoryinfo
+	utxos     utxo.View             // Optional, enables gettxout
+	miner     *mining.Miner         // Optional, reported by getmininginfo
+	templates *mining.TemplateCache // Optional, enables getblocktemplate
+
+	rules       *consensus.ConsensusRules // Optional, enables getdeploymentinfo
+	versionBits *consensus.VersionBits
+
+	snapshots      map[uint64]*mempool.Snapshot // Kept for getmempooldiff
+	lastSnapshotID uint64
+
+	watcher *watch.Watcher // Optional, enables address watching
+
+	started     time.Time
+	shutdown    func() // Optional, enables stop
+	activeCalls map[uint64]activeCall
+	nextCallID  uint64
+
+	mu sync.RWMutex // Guards wallets, walletDir, limiters, readiness, utxoCache, utxos, miner, templates, rules, snapshots, watcher, shutdown and activeCalls
 }
 
 // NewServer creates a new RPC server
 func NewServer(w *wallet.Wallet, bc *storage.BlockchainStorage, addr string) *Server {
-	return &Server{
+	s := &Server{
 		wallet:     w,
 		blockchain: bc,
 		addr:       addr,
+		validator:  security.NewInputValidator(MaxRequestBody),
+		readiness:  DefaultReadinessConfig(),
+
+		started:     time.Now(),
+		activeCalls: make(map[uint64]activeCall),
 	}
+	s.SetRateLimits(DefaultRateLimitConfig())
+	return s
+}
+
+// SetNode attaches the P2P node used by the peer management commands
+func (s *Server) SetNode(node *network.Node) {
+	s.node = node
 }
 
+const (
+	// ReadTimeout bounds reading a whole request, so a stalled client
+	// can't hold a connection open
+	ReadTimeout = 30 * time.Second
+
+	// IdleTimeout closes keep-alive connections left unused this long
+	IdleTimeout = 2 * time.Minute
+
+	// ShutdownTimeout is how long in-flight requests get to finish once
+	// the server is told to stop
+	ShutdownTimeout = 5 * time.Second
+)
+
 // Start starts the HTTP server
 func (s *Server) Start() error {
-	http.HandleFunc("/getnewaddress", s.handleGetNewAddress)
-	http.HandleFunc("/getbalance", s.handleGetBalance)
-	http.HandleFunc("/sendtoaddress", s.handleSendToAddress)
-	http.HandleFunc("/getblockcount", s.handleGetBlockCount)
-	http.HandleFunc("/getblock", s.handleGetBlock)
-	http.HandleFunc("/gettransaction", s.handleGetTransaction)
-	http.HandleFunc("/listaddresses", s.handleListAddresses)
+	return s.StartContext(context.Background())
+}
+
+// StartContext serves RPC on the configured address until ctx is cancelled
+func (s *Server) StartContext(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	log.Printf("RPC server listening on %s", listener.Addr())
+	return s.Serve(ctx, listener, http.DefaultServeMux)
+}
+
+// Serve mounts the endpoints on mux and serves them on listener until ctx
+// is cancelled. In-flight requests get ShutdownTimeout to finish before
+// their connections are closed.
+func (s *Server) Serve(ctx context.Context, listener net.Listener, mux *http.ServeMux) error {
+	s.registerHandlers(mux)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: ReadTimeout,
+		ReadTimeout:       ReadTimeout,
+		IdleTimeout:       IdleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	// Shutdown makes Serve return at once, so wait for the in-flight
+	// requests separately
+	stopped := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(stopped)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			server.Close()
+		}
+	})
+
+	err := server.Serve(listener)
+	if !stop() {
+		<-stopped
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
 
-	log.Printf("RPC server listening on %s", s.addr)
-	return http.ListenAndServe(s.addr, nil)
+// Handler returns the RPC endpoints on a fresh mux (useful for tests)
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	s.registerHandlers(mux)
+	return mux
+}
+
+// registerHandlers mounts every RPC endpoint on mux
+func (s *Server) registerHandlers(mux *http.ServeMux) {
+	s.handle(mux, "/getnewaddress", ClassWallet, s.handleGetNewAddress)
+	s.handle(mux, "/getbalance", ClassWallet, s.handleGetBalance)
+	s.handle(mux, "/sendtoaddress", ClassWallet, s.handleSendToAddress, ruleAddress, ruleAmount)
+	s.handle(mux, "/getblockcount", ClassReadOnly, s.handleGetBlockCount)
+	s.handle(mux, "/getblock", ClassReadOnly, s.handleGetBlock, ruleHeight, ruleBlockHash)
+	s.handle(mux, "/getblockheader", ClassReadOnly, s.handleGetBlockHeader, ruleBlockHash)
+	s.handle(mux, "/getblockhash", ClassReadOnly, s.handleGetBlockHash, ruleHeight)
+	s.handle(mux, "/gettransaction", ClassReadOnly, s.handleGetTransaction, ruleTxHash)
+	s.handle(mux, "/listaddresses", ClassWallet, s.handleListAddresses)
+	s.handle(mux, "/backupwallet", ClassWallet, s.handleBackupWallet)
+	s.handle(mux, "/createwallet", ClassWallet, s.handleCreateWallet)
+	s.handle(mux, "/encryptwallet", ClassWallet, s.handleEncryptWallet)
+	s.handle(mux, "/walletpassphrase", ClassWallet, s.handleWalletPassphrase)
+	s.handle(mux, "/walletlock", ClassWallet, s.handleWalletLock)
+	s.handle(mux, "/sethdseed", ClassWallet, s.handleSetHDSeed)
+	s.handle(mux, "/setwalletflag", ClassWallet, s.handleSetWalletFlag)
+	s.handle(mux, "/listdescriptors", ClassWallet, s.handleListDescriptors)
+	s.handle(mux, "/getdescriptorinfo", ClassReadOnly, s.handleGetDescriptorInfo)
+	s.handle(mux, "/importprivkey", ClassWallet, s.handleImportPrivKey)
+	s.handle(mux, "/dumpwallet", ClassWallet, s.handleDumpWallet)
+	s.handle(mux, "/listunspent", ClassWallet, s.handleListUnspent)
+	s.handle(mux, "/listtransactions", ClassWallet, s.handleListTransactions)
+	s.handle(mux, "/bumpfee", ClassWallet, s.handleBumpFee, ruleTxID)
+	s.handle(mux, "/abandontransaction", ClassWallet, s.handleAbandonTransaction, ruleTxID)
+	s.handle(mux, "/getdeploymentinfo", ClassReadOnly, s.handleGetDeploymentInfo)
+	s.handle(mux, "/getchaintips", ClassReadOnly, s.handleGetChainTips)
+	s.handle(mux, "/getblockstats", ClassReadOnly, s.handleGetBlockStats, ruleHeight, ruleBlockHash)
+	s.handle(mux, "/getchaintxstats", ClassReadOnly, s.handleGetChainTxStats, ruleBlockHash)
+	s.handle(mux, "/getdifficulty", ClassReadOnly, s.handleGetDifficulty)
+	s.handle(mux, "/getmininginfo", ClassReadOnly, s.handleGetMiningInfo)
+	s.handle(mux, "/getnetworkhashps", ClassReadOnly, s.handleGetNetworkHashPS, ruleHeight)
+	s.handle(mux, "/getblocktemplate", ClassReadOnly, s.handleGetBlockTemplate)
+	s.handle(mux, "/submitblock", ClassWallet, s.handleSubmitBlock)
+	s.handle(mux, "/validateblock", ClassReadOnly, s.handleValidateBlock)
+	s.handle(mux, "/verifychain", ClassReadOnly, s.handleVerifyChain)
+	s.handle(mux, "/submitheader", ClassWallet, s.handleSubmitHeader)
+	s.handle(mux, "/gettxout", ClassReadOnly, s.handleGetTxOut, ruleTxID)
+	s.handle(mux, "/getspentinfo", ClassReadOnly, s.handleGetSpentInfo, ruleTxID)
+	s.handle(mux, "/gettxgraph", ClassReadOnly, s.handleGetTxGraph, ruleTxID)
+	s.handle(mux, "/gettxoutproof", ClassReadOnly, s.handleGetTxOutProof, ruleTxIDs, ruleBlockHash)
+	s.handle(mux, "/verifytxoutproof", ClassReadOnly, s.handleVerifyTxOutProof)
+	s.handle(mux, "/listnulldata", ClassReadOnly, s.handleListNullData)
+	s.handle(mux, "/decoderawtransaction", ClassReadOnly, s.handleDecodeRawTransaction)
+	s.handle(mux, "/decodeblock", ClassReadOnly, s.handleDecodeBlock)
+	s.handle(mux, "/getmempoolinfo", ClassReadOnly, s.handleGetMempoolInfo)
+	s.handle(mux, "/getrawmempool", ClassReadOnly, s.handleGetRawMempool)
+	s.handle(mux, "/getmempoolsnapshot", ClassReadOnly, s.handleGetMempoolSnapshot)
+	s.handle(mux, "/getmempooldiff", ClassReadOnly, s.handleGetMempoolDiff)
+	s.handle(mux, "/watchaddress", ClassWallet, s.handleWatchAddress, ruleAddress)
+	s.handle(mux, "/unwatchaddress", ClassWallet, s.handleUnwatchAddress, ruleAddress)
+	s.handle(mux, "/waitforwatchevents", ClassReadOnly, s.handleWaitForWatchEvents)
+
+	// Network
+	s.handle(mux, "/getpeerinfo", ClassReadOnly, s.handleGetPeerInfo)
+	s.handle(mux, "/addnode", ClassWallet, s.handleAddNode)
+	s.handle(mux, "/disconnectnode", ClassWallet, s.handleDisconnectNode)
+	s.handle(mux, "/setban", ClassWallet, s.handleSetBan, ruleIP)
+	s.handle(mux, "/listbanned", ClassReadOnly, s.handleListBanned)
+	s.handle(mux, "/clearbanned", ClassWallet, s.handleClearBanned)
+	s.handle(mux, "/getnettotals", ClassReadOnly, s.handleGetNetTotals)
+	s.handle(mux, "/getnetworkinfo", ClassReadOnly, s.handleGetNetworkInfo)
+
+	// Monitoring
+	s.handle(mux, "/metrics", ClassReadOnly, s.handleMetrics)
+	s.handle(mux, "/getmemoryinfo", ClassReadOnly, s.handleGetMemoryInfo)
+	s.handle(mux, "/getdiskusage", ClassReadOnly, s.handleGetDiskUsage)
+	s.registerHealthHandlers(mux)
+
+	// Administration
+	s.handle(mux, "/uptime", ClassReadOnly, s.handleUptime)
+	s.handle(mux, "/stop", ClassWallet, s.handleStop)
+	s.handle(mux, "/getrpcinfo", ClassReadOnly, s.handleGetRPCInfo)
+	s.handle(mux, "/compactdb", ClassWallet, s.handleCompactDB)
+	s.handle(mux, "/backupnode", ClassWallet, s.handleBackupNode)
+}
+
+// handle mounts handler behind the rate limiter and parameter validation,
+// listing it in getrpcinfo while it runs
+func (s *Server) handle(mux *http.ServeMux, path string, class MethodClass, handler http.HandlerFunc, rules ...paramRule) {
+	mux.HandleFunc(path, s.limit(class, s.track(path, s.validate(handler, rules...))))
 }
 
 // Response structures
@@ -63,18 +258,41 @@ type SendResponse struct {
 
 type BlockCountResponse struct {
 	Height uint64 `json:"height"`
+	Blocks uint64 `json:"blocks"` // 0 for an empty chain
 }
 
 type BlockResponse struct {
-	Hash         string   `json:"hash"`
-	Height       uint64   `json:"height"`
-	Version      int32    `json:"version"`
-	PrevHash     string   `json:"prev_hash"`
-	MerkleRoot   string   `json:"merkle_root"`
-	Timestamp    uint32   `json:"timestamp"`
-	Bits         uint32   `json:"bits"`
-	Nonce        uint32   `json:"nonce"`
-	Transactions []string `json:"transactions"`
+	Hash          string   `json:"hash"`
+	Height        uint64   `json:"height"`
+	Confirmations int64    `json:"confirmations"` // -1 off the best chain
+	Version       int32    `json:"version"`
+	PrevHash      string   `json:"prev_hash"`
+	MerkleRoot    string   `json:"merkle_root"`
+	Timestamp     uint32   `json:"timestamp"`
+	Bits          uint32   `json:"bits"`
+	Nonce         uint32   `json:"nonce"`
+	Transactions  []string `json:"transactions"`
+}
+
+type BlockHashResponse struct {
+	Hash string `json:"hash"`
+}
+
+// BlockHeaderResponse is returned by /getblockheader
+type BlockHeaderResponse struct {
+	Hash          string  `json:"hash"`
+	Height        uint64  `json:"height"`
+	Confirmations int64   `json:"confirmations"` // -1 off the best chain
+	Version       int32   `json:"version"`
+	MerkleRoot    string  `json:"merkle_root"`
+	Timestamp     uint32  `json:"timestamp"`
+	Bits          uint32  `json:"bits"`
+	Nonce         uint32  `json:"nonce"`
+	Difficulty    float64 `json:"difficulty"`
+	ChainWork     string  `json:"chainwork"` // Hex total work through this block
+	NTx           int     `json:"n_tx"`
+	PrevHash      string  `json:"prev_hash,omitempty"`
+	NextHash      string  `json:"next_hash,omitempty"` // Best-chain successor
 }
 
 type TransactionResponse struct {
@@ -101,6 +319,63 @@ type ListAddressesResponse struct {
 	Addresses []string `json:"addresses"`
 }
 
+type PeerInfo struct {
+	ID             uint64  `json:"id"`
+	Addr           string  `json:"addr"`
+	Inbound        bool    `json:"inbound"`
+	Version        int32   `json:"version"`
+	Services       uint64  `json:"services"`
+	SubVer         string  `json:"subver"`
+	StartingHeight int32   `json:"startingheight"`
+	ConnTime       int64   `json:"conntime"`
+	LastSend       int64   `json:"lastsend"`
+	LastRecv       int64   `json:"lastrecv"`
+	BytesSent      uint64  `json:"bytessent"`
+	BytesRecv      uint64  `json:"bytesrecv"`
+	PingTime       float64 `json:"pingtime,omitempty"` // Seconds
+	BanScore       int     `json:"banscore"`
+	TimeOffset     int64   `json:"timeoffset"` // Seconds the peer's clock is ahead of ours
+	Transport      string  `json:"transport_protocol_type"`
+	SessionID      string  `json:"session_id,omitempty"`
+
+	BytesSentPerMsg map[string]uint64 `json:"bytessent_per_msg"`
+	BytesRecvPerMsg map[string]uint64 `json:"bytesrecv_per_msg"`
+}
+
+type PeerInfoResponse struct {
+	Peers []PeerInfo `json:"peers"`
+}
+
+type BannedInfo struct {
+	Address       string `json:"address"`
+	BanCreated    int64  `json:"ban_created"`
+	BannedUntil   int64  `json:"banned_until"`
+	BanDuration   int64  `json:"ban_duration"`   // Seconds
+	TimeRemaining int64  `json:"time_remaining"` // Seconds
+}
+
+type ListBannedResponse struct {
+	Bans []BannedInfo `json:"bans"`
+}
+
+type NetworkInfoResponse struct {
+	ProtocolVersion int32    `json:"protocolversion"`
+	SubVersion      string   `json:"subversion"`
+	LocalServices   uint64   `json:"localservices"`
+	Connections     int      `json:"connections"`
+	TimeOffset      int64    `json:"timeoffset"`  // Seconds added to the local clock
+	TimeSamples     int      `json:"timesamples"` // Outbound peers that reported their clock
+	LocalAddresses  []string `json:"localaddresses"`
+	Warnings        string   `json:"warnings"`
+}
+
+type NetTotalsResponse struct {
+	TotalBytesRecv uint64 `json:"totalbytesrecv"`
+	TotalBytesSent uint64 `json:"totalbytessent"`
+	TimeMillis     int64  `json:"timemillis"`
+	Uptime         int64  `json:"uptime"` // Seconds since the node started
+}
+
 // Handler functions
 func (s *Server) handleGetNewAddress(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodGet {
@@ -108,7 +383,12 @@ func (s *Server) handleGetNewAddress(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	address, err := s.wallet.GenerateAddress()
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	address, err := wal.GenerateAddress()
 	if err != nil {
 		s.sendError(w, fmt.Sprintf("failed to generate address: %v", err))
 		return
@@ -123,7 +403,12 @@ func (s *Server) handleGetBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	balance := s.wallet.GetBalance()
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	balance := wal.GetBalance()
 	s.sendSuccess(w, BalanceResponse{Balance: balance})
 }
 
@@ -133,10 +418,16 @@ func (s *Server) handleSendToAddress(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
 	// Parse request
 	var req struct {
 		Address string `json:"address"`
 		Amount  int64  `json:"amount"`
+		Data    string `json:"data,omitempty"` // Hex payload for an OP_RETURN output
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -145,7 +436,18 @@ func (s *Server) handleSendToAddress(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create and sign transaction
-	tx, err := s.wallet.Send(req.Address, req.Amount)
+	var tx *types.Transaction
+	var err error
+	if req.Data != "" {
+		data, decodeErr := hex.DecodeString(req.Data)
+		if decodeErr != nil {
+			s.sendError(w, fmt.Sprintf("invalid data: %v", decodeErr))
+			return
+		}
+		tx, err = wal.SendWithData(req.Address, req.Amount, 0, data)
+	} else {
+		tx, err = wal.Send(req.Address, req.Amount)
+	}
 	if err != nil {
 		s.sendError(w, fmt.Sprintf("failed to create transaction: %v", err))
 		return
@@ -167,37 +469,100 @@ func (s *Server) handleGetBlockCount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	height, err := s.blockchain.GetBestBlockHeight()
+	// An empty chain is reported rather than refused: Blocks tells it
+	// apart from a chain holding only genesis
+	_, height, err := s.blockchain.GetTip()
+	if errors.Is(err, storage.ErrEmptyChain) {
+		s.sendSuccess(w, BlockCountResponse{})
+		return
+	}
 	if err != nil {
 		s.sendError(w, fmt.Sprintf("failed to get height: %v", err))
 		return
 	}
-	s.sendSuccess(w, BlockCountResponse{Height: height})
+	s.sendSuccess(w, BlockCountResponse{Height: height, Blocks: height + 1})
 }
 
+// handleGetBlock returns a block as hex (verbosity 0), its header and
+// txids (1, the default) or fully decoded (2)
 func (s *Server) handleGetBlock(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		s.sendError(w, "method not allowed")
 		return
 	}
 
-	// Get height from query parameter
-	heightStr := r.URL.Query().Get("height")
-	if heightStr == "" {
-		s.sendError(w, "missing height parameter")
+	// The block is named by best-chain height or by hash; a hash may also
+	// name a side-chain block
+	query := r.URL.Query()
+	heightStr, hashStr := query.Get("height"), query.Get("blockhash")
+	if (heightStr == "") == (hashStr == "") {
+		s.sendError(w, "need either height or blockhash parameter")
 		return
 	}
 
-	height, err := strconv.ParseUint(heightStr, 10, 64)
+	verbosity := uint64(1)
+	if value := query.Get("verbosity"); value != "" {
+		v, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || v > 2 {
+			s.sendError(w, fmt.Sprintf("invalid verbosity: %s", value))
+			return
+		}
+		verbosity = v
+	}
+
+	var blockHash types.Hash
+	if heightStr != "" {
+		height, err := strconv.ParseUint(heightStr, 10, 64)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("invalid height: %v", err))
+			return
+		}
+		header, err := s.blockchain.HeaderAt(height)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("block not found: %v", err))
+			return
+		}
+		blockHash, _ = serialization.HashBlockHeader(header)
+	} else {
+		hash, err := types.NewHashFromString(hashStr)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("invalid blockhash: %v", err))
+			return
+		}
+		blockHash = hash
+	}
+
+	data, err := s.blockchain.GetRawBlock(blockHash)
 	if err != nil {
-		s.sendError(w, fmt.Sprintf("invalid height: %v", err))
+		s.sendError(w, fmt.Sprintf("block not found: %v", err))
+		return
+	}
+	if verbosity == 0 {
+		s.sendSuccess(w, hex.EncodeToString(data))
 		return
 	}
 
-	// Get block
-	block, err := s.blockchain.GetBlockByHeight(height)
+	block, err := serialization.DeserializeBlock(data)
 	if err != nil {
-		s.sendError(w, fmt.Sprintf("block not found: %v", err))
+		s.sendError(w, fmt.Sprintf("failed to decode block: %v", err))
+		return
+	}
+	height, err := s.blockchain.GetBlockHeight(blockHash)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block height unknown: %v", err))
+		return
+	}
+	confirmations, err := s.confirmations(blockHash, height)
+	if err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+
+	if verbosity == 2 {
+		decoded := s.decodeBlock(block, blockHash, len(data))
+		decoded.Height = &height
+		decoded.Confirmations = confirmations
+		s.sendSuccess(w, decoded)
 		return
 	}
 
@@ -208,22 +573,153 @@ func (s *Server) handleGetBlock(w http.ResponseWriter, r *http.Request) {
 		txHashes[i] = txHash.String()
 	}
 
-	blockHash, _ := s.blockchain.GetBlockHash(block)
 	blockResp := BlockResponse{
-		Hash:         blockHash.String(),
-		Height:       height,
-		Version:      block.Header.Version,
-		PrevHash:     block.Header.PrevBlockHash.String(),
-		MerkleRoot:   block.Header.MerkleRoot.String(),
-		Timestamp:    block.Header.Timestamp,
-		Bits:         block.Header.Bits,
-		Nonce:        block.Header.Nonce,
-		Transactions: txHashes,
+		Hash:          blockHash.String(),
+		Height:        height,
+		Confirmations: confirmations,
+		Version:       block.Header.Version,
+		PrevHash:      block.Header.PrevBlockHash.String(),
+		MerkleRoot:    block.Header.MerkleRoot.String(),
+		Timestamp:     block.Header.Timestamp,
+		Bits:          block.Header.Bits,
+		Nonce:         block.Header.Nonce,
+		Transactions:  txHashes,
 	}
 
 	s.sendSuccess(w, blockResp)
 }
 
+// confirmations returns how many best-chain blocks are at or above a
+// stored block at height, or -1 if it is not on the best chain
+func (s *Server) confirmations(hash types.Hash, height uint64) (int64, error) {
+	onMain, err := s.blockchain.IsMainChain(hash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check best chain: %v", err)
+	}
+	if !onMain {
+		return -1, nil
+	}
+	bestHeight, err := s.blockchain.GetBestBlockHeight()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get height: %v", err)
+	}
+	return int64(bestHeight-height) + 1, nil
+}
+
+// handleGetBlockHash returns the hash of the best chain's block at height
+func (s *Server) handleGetBlockHash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	heightStr := r.URL.Query().Get("height")
+	if heightStr == "" {
+		s.sendError(w, "missing height parameter")
+		return
+	}
+	height, err := strconv.ParseUint(heightStr, 10, 64)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid height: %v", err))
+		return
+	}
+
+	header, err := s.blockchain.HeaderAt(height)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block not found: %v", err))
+		return
+	}
+	blockHash, err := serialization.HashBlockHeader(header)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to hash block: %v", err))
+		return
+	}
+
+	s.sendSuccess(w, BlockHashResponse{Hash: blockHash.String()})
+}
+
+// handleGetBlockHeader returns a stored block's header, decoded or, with
+// verbose=false, as hex
+func (s *Server) handleGetBlockHeader(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	hash, err := types.NewHashFromString(query.Get("blockhash"))
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid blockhash: %v", err))
+		return
+	}
+	verbose := true
+	if value := query.Get("verbose"); value != "" {
+		if verbose, err = strconv.ParseBool(value); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid verbose: %v", err))
+			return
+		}
+	}
+
+	block, err := s.blockchain.GetBlock(hash)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block not found: %v", err))
+		return
+	}
+	if !verbose {
+		data, err := serialization.SerializeBlockHeader(&block.Header)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("failed to serialize header: %v", err))
+			return
+		}
+		s.sendSuccess(w, hex.EncodeToString(data))
+		return
+	}
+
+	height, err := s.blockchain.GetBlockHeight(hash)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block height unknown: %v", err))
+		return
+	}
+	confirmations, err := s.confirmations(hash, height)
+	if err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+	work, err := s.blockchain.GetChainWork(hash)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to compute chain work: %v", err))
+		return
+	}
+
+	resp := BlockHeaderResponse{
+		Hash:          hash.String(),
+		Height:        height,
+		Confirmations: confirmations,
+		Version:       block.Header.Version,
+		MerkleRoot:    block.Header.MerkleRoot.String(),
+		Timestamp:     block.Header.Timestamp,
+		Bits:          block.Header.Bits,
+		Nonce:         block.Header.Nonce,
+		Difficulty:    consensus.GetDifficulty(block.Header.Bits),
+		ChainWork:     fmt.Sprintf("%064x", work),
+		NTx:           len(block.Transactions),
+	}
+	if !block.Header.PrevBlockHash.IsZero() {
+		resp.PrevHash = block.Header.PrevBlockHash.String()
+	}
+	if confirmations > 1 {
+		next, err := s.blockchain.HeaderAt(height + 1)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("failed to get next block: %v", err))
+			return
+		}
+		nextHash, _ := serialization.HashBlockHeader(next)
+		resp.NextHash = nextHash.String()
+	}
+
+	s.sendSuccess(w, resp)
+}
+
 func (s *Server) handleGetTransaction(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		s.sendError(w, "method not allowed")
@@ -302,11 +798,250 @@ func (s *Server) handleListAddresses(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	addresses := s.wallet.ListAddresses()
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	addresses := wal.ListAddresses()
 	s.sendSuccess(w, ListAddressesResponse{Addresses: addresses})
 }
 
+func (s *Server) handleGetPeerInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	stats := s.node.PeerInfo()
+	peers := make([]PeerInfo, len(stats))
+	for i, st := range stats {
+		peers[i] = PeerInfo{
+			ID:             st.ID,
+			Addr:           st.Address,
+			Inbound:        st.Inbound,
+			Version:        st.Version,
+			Services:       st.Services,
+			SubVer:         st.UserAgent,
+			StartingHeight: st.StartHeight,
+			ConnTime:       unixOrZero(st.ConnectedAt),
+			LastSend:       unixOrZero(st.LastSend),
+			LastRecv:       unixOrZero(st.LastRecv),
+			BytesSent:      st.BytesSent,
+			BytesRecv:      st.BytesRecv,
+			PingTime:       st.PingTime.Seconds(),
+			BanScore:       st.BanScore,
+			TimeOffset:     int64(st.TimeOffset.Seconds()),
+			Transport:      st.Transport,
+			SessionID:      st.SessionID,
+
+			BytesSentPerMsg: st.BytesSentPerMsg,
+			BytesRecvPerMsg: st.BytesRecvPerMsg,
+		}
+	}
+
+	s.sendSuccess(w, PeerInfoResponse{Peers: peers})
+}
+
+func (s *Server) handleAddNode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	var req struct {
+		Node    string `json:"node"`
+		Command string `json:"command"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	if err := s.node.AddNode(req.Node, req.Command); err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+
+	s.sendSuccess(w, nil)
+}
+
+func (s *Server) handleDisconnectNode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	var req struct {
+		Address string `json:"address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	if err := s.node.DisconnectNode(req.Address); err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+
+	s.sendSuccess(w, nil)
+}
+
+func (s *Server) handleSetBan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	var req struct {
+		IP      string `json:"ip"`
+		Command string `json:"command"`
+		BanTime int64  `json:"bantime"` // Seconds, 0 = default (24h)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.BanTime < 0 {
+		s.sendError(w, "bantime must not be negative")
+		return
+	}
+
+	if err := s.node.SetBan(req.IP, req.Command, time.Duration(req.BanTime)*time.Second); err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+
+	s.sendSuccess(w, nil)
+}
+
+func (s *Server) handleListBanned(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	now := time.Now()
+	entries := s.node.ListBanned()
+	bans := make([]BannedInfo, len(entries))
+	for i, ban := range entries {
+		bans[i] = BannedInfo{
+			Address:       ban.IP,
+			BanCreated:    ban.BanCreated.Unix(),
+			BannedUntil:   ban.BannedUntil.Unix(),
+			BanDuration:   int64(ban.BannedUntil.Sub(ban.BanCreated).Seconds()),
+			TimeRemaining: int64(ban.BannedUntil.Sub(now).Seconds()),
+		}
+	}
+
+	s.sendSuccess(w, ListBannedResponse{Bans: bans})
+}
+
+func (s *Server) handleClearBanned(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	s.node.ClearBanned()
+	s.sendSuccess(w, nil)
+}
+
+func (s *Server) handleGetNetTotals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	totals := s.node.GetNetTotals()
+	s.sendSuccess(w, NetTotalsResponse{
+		TotalBytesRecv: totals.BytesRecv,
+		TotalBytesSent: totals.BytesSent,
+		TimeMillis:     time.Now().UnixMilli(),
+		Uptime:         int64(totals.Uptime.Seconds()),
+	})
+}
+
+// handleGetNetworkInfo reports the node's P2P state, including the
+// network-adjusted time offset
+func (s *Server) handleGetNetworkInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	adjusted := s.node.AdjustedTime()
+	local := s.node.ListenAddrs()
+	if external := s.node.Config.ExternalAddr; external != "" {
+		local = append([]string{external}, local...)
+	}
+	s.sendSuccess(w, NetworkInfoResponse{
+		ProtocolVersion: protocol.ProtocolVersion,
+		SubVersion:      s.node.Config.UserAgent,
+		LocalServices:   s.node.LocalServices(),
+		Connections:     s.node.PeerCount(),
+		TimeOffset:      int64(adjusted.Offset().Seconds()),
+		TimeSamples:     adjusted.NumSamples(),
+		LocalAddresses:  local,
+		Warnings:        adjusted.Warning(),
+	})
+}
+
+// handleMetrics serves the node's counters in Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if s.node == nil {
+		s.sendError(w, "p2p networking is disabled")
+		return
+	}
+
+	monitoring.PrometheusHandler(s.node.Metrics()).ServeHTTP(w, r)
+}
+
 // Helper functions
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
 func (s *Server) sendSuccess(w http.ResponseWriter, result interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(Response{Result: result})
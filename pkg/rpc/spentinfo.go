@@ -0,0 +1,95 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// SpentInfoResponse is returned by /getspentinfo: the transaction input
+// spending an output
+type SpentInfoResponse struct {
+	TxID      string `json:"txid"`
+	Index     uint32 `json:"index"` // Input index
+	Height    uint64 `json:"height,omitempty"`
+	BlockHash string `json:"blockhash,omitempty"`
+	Confirmed bool   `json:"confirmed"` // False for a mempool spend
+}
+
+// handleGetSpentInfo returns where an output was spent, or null if it is
+// unspent, unknown or was spent before the index was enabled. Mempool
+// spends are included unless include_mempool is false.
+func (s *Server) handleGetSpentInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	if !s.blockchain.SpentIndexEnabled() {
+		s.sendError(w, "spent index not enabled")
+		return
+	}
+
+	query := r.URL.Query()
+	txid, err := types.NewHashFromString(query.Get("txid"))
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid txid: %v", err))
+		return
+	}
+	n, err := strconv.ParseUint(query.Get("n"), 10, 32)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid n: %v", err))
+		return
+	}
+	includeMempool := true
+	if value := query.Get("include_mempool"); value != "" {
+		if includeMempool, err = strconv.ParseBool(value); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid include_mempool: %v", err))
+			return
+		}
+	}
+	outpoint := types.NewOutPoint(txid, uint32(n))
+
+	info, err := s.blockchain.GetSpentInfo(outpoint)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to read index: %v", err))
+		return
+	}
+	if info != nil {
+		header, err := s.blockchain.HeaderAt(info.Height)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("failed to get block %d: %v", info.Height, err))
+			return
+		}
+		hash, err := serialization.HashBlockHeader(header)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("failed to get block %d: %v", info.Height, err))
+			return
+		}
+		s.sendSuccess(w, SpentInfoResponse{
+			TxID:      info.TxHash.String(),
+			Index:     info.Index,
+			Height:    info.Height,
+			BlockHash: hash.String(),
+			Confirmed: true,
+		})
+		return
+	}
+
+	if includeMempool && s.node != nil {
+		if spender, spent := s.node.Mempool.SpentBy(outpoint); spent {
+			if entry, err := s.node.Mempool.Get(spender); err == nil {
+				for i, input := range entry.Tx.Inputs {
+					if input.PrevTxHash == outpoint.Hash && input.OutputIndex == outpoint.Index {
+						s.sendSuccess(w, SpentInfoResponse{TxID: spender.String(), Index: uint32(i)})
+						return
+					}
+				}
+			}
+		}
+	}
+
+	s.sendSuccess(w, nil)
+}
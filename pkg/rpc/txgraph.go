@@ -0,0 +1,105 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/txgraph"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// MaxTxGraphDepth caps /gettxgraph's depth
+const MaxTxGraphDepth = 100
+
+// TxGraphNode is a transaction in a /gettxgraph result
+type TxGraphNode struct {
+	TxID      string   `json:"txid"`
+	Depth     int      `json:"depth"` // Negative for ancestors
+	Confirmed bool     `json:"confirmed"`
+	Height    uint64   `json:"height,omitempty"`
+	Fee       int64    `json:"fee,omitempty"`
+	Size      int64    `json:"size,omitempty"`
+	Parents   []string `json:"parents,omitempty"`
+	Children  []string `json:"children,omitempty"`
+}
+
+// TxGraphResponse is returned by /gettxgraph
+type TxGraphResponse struct {
+	Root        TxGraphNode   `json:"root"`
+	Ancestors   []TxGraphNode `json:"ancestors"`
+	Descendants []TxGraphNode `json:"descendants"`
+	Truncated   bool          `json:"truncated,omitempty"`
+}
+
+// handleGetTxGraph returns the ancestors and descendants of a transaction
+// up to depth generations away, in the mempool and the chain
+func (s *Server) handleGetTxGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	txid, err := types.NewHashFromString(query.Get("txid"))
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid txid: %v", err))
+		return
+	}
+	depth := txgraph.DefaultDepth
+	if v := query.Get("depth"); v != "" {
+		if depth, err = strconv.Atoi(v); err != nil || depth < 0 || depth > MaxTxGraphDepth {
+			s.sendError(w, fmt.Sprintf("depth must be 0 to %d", MaxTxGraphDepth))
+			return
+		}
+	}
+
+	var mp *mempool.Mempool
+	if s.node != nil {
+		mp = s.node.Mempool
+	}
+	result, err := txgraph.New(s.blockchain, mp).Query(txid, depth)
+	if errors.Is(err, txgraph.ErrNotFound) {
+		s.sendError(w, err.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to walk the graph: %v", err))
+		return
+	}
+
+	resp := TxGraphResponse{
+		Root:        txGraphNode(result.Root),
+		Ancestors:   make([]TxGraphNode, 0, len(result.Ancestors)),
+		Descendants: make([]TxGraphNode, 0, len(result.Descendants)),
+		Truncated:   result.Truncated,
+	}
+	for _, node := range result.Ancestors {
+		resp.Ancestors = append(resp.Ancestors, txGraphNode(node))
+	}
+	for _, node := range result.Descendants {
+		resp.Descendants = append(resp.Descendants, txGraphNode(node))
+	}
+	s.sendSuccess(w, resp)
+}
+
+// txGraphNode converts a graph node for the response
+func txGraphNode(node *txgraph.Node) TxGraphNode {
+	info := TxGraphNode{
+		TxID:      node.TxHash.String(),
+		Depth:     node.Depth,
+		Confirmed: node.Confirmed,
+		Height:    node.Height,
+		Fee:       node.Fee,
+		Size:      node.Size,
+	}
+	for _, hash := range node.Parents {
+		info.Parents = append(info.Parents, hash.String())
+	}
+	for _, hash := range node.Children {
+		info.Children = append(info.Children, hash.String())
+	}
+	return info
+}
@@ -0,0 +1,260 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// TxOutResponse is returned by /gettxout for an unspent output
+type TxOutResponse struct {
+	BestBlock     string `json:"bestblock"`
+	Confirmations uint64 `json:"confirmations"` // 0 for outputs of mempool transactions
+	Value         int64  `json:"value"`
+	ScriptPubKey  string `json:"script_pubkey"`
+	Coinbase      bool   `json:"coinbase"`
+}
+
+// TxOutProofResponse is returned by /gettxoutproof
+type TxOutProofResponse struct {
+	Proof string `json:"proof"` // Hex serialized merkle block
+}
+
+// VerifyTxOutProofResponse is returned by /verifytxoutproof
+type VerifyTxOutProofResponse struct {
+	TxIDs []string `json:"txids"` // Empty if the block isn't on the main chain
+}
+
+// SetUTXOSet attaches the unspent outputs gettxout looks outputs up in
+func (s *Server) SetUTXOSet(view utxo.View) {
+	s.mu.Lock()
+	s.utxos = view
+	s.mu.Unlock()
+}
+
+// handleGetTxOut returns an unspent output, or a null result if it is spent
+// or doesn't exist. Unless include_mempool=false, outputs spent by mempool
+// transactions count as spent and outputs they create as unspent.
+func (s *Server) handleGetTxOut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	txid, err := types.NewHashFromString(query.Get("txid"))
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid txid: %v", err))
+		return
+	}
+	n, err := strconv.ParseUint(query.Get("n"), 10, 32)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid n: %v", err))
+		return
+	}
+	includeMempool := true
+	if value := query.Get("include_mempool"); value != "" {
+		if includeMempool, err = strconv.ParseBool(value); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid include_mempool: %v", err))
+			return
+		}
+	}
+
+	s.mu.RLock()
+	view := s.utxos
+	s.mu.RUnlock()
+	if view == nil {
+		s.sendError(w, "UTXO set not configured")
+		return
+	}
+
+	bestHeight, err := s.blockchain.GetBestBlockHeight()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to get height: %v", err))
+		return
+	}
+	bestHash, err := s.blockchain.GetBestBlockHash()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to get best block: %v", err))
+		return
+	}
+
+	if includeMempool && s.node != nil {
+		if _, spent := s.node.Mempool.SpentBy(types.NewOutPoint(txid, uint32(n))); spent {
+			s.sendSuccess(w, nil)
+			return
+		}
+		if entry, err := s.node.Mempool.Get(txid); err == nil {
+			if n >= uint64(len(entry.Tx.Outputs)) {
+				s.sendSuccess(w, nil)
+				return
+			}
+			output := entry.Tx.Outputs[n]
+			s.sendSuccess(w, TxOutResponse{
+				BestBlock:    bestHash.String(),
+				Value:        output.Value,
+				ScriptPubKey: hex.EncodeToString(output.PubKeyScript),
+			})
+			return
+		}
+	}
+
+	unspent, err := view.Get(utxo.NewOutPoint(txid, uint32(n)))
+	if err != nil {
+		s.sendSuccess(w, nil)
+		return
+	}
+
+	s.sendSuccess(w, TxOutResponse{
+		BestBlock:     bestHash.String(),
+		Confirmations: bestHeight - unspent.Height + 1,
+		Value:         unspent.Output.Value,
+		ScriptPubKey:  hex.EncodeToString(unspent.Output.PubKeyScript),
+		Coinbase:      unspent.IsCoinbase,
+	})
+}
+
+// handleGetTxOutProof proves that the comma-separated txids are in a block.
+// Without blockhash the block is found through the transaction index.
+func (s *Server) handleGetTxOutProof(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("txids") == "" {
+		s.sendError(w, "missing txids parameter")
+		return
+	}
+
+	txids := make(map[types.Hash]bool)
+	var first types.Hash
+	for i, str := range strings.Split(query.Get("txids"), ",") {
+		txid, err := types.NewHashFromString(str)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("invalid txid: %v", err))
+			return
+		}
+		if txids[txid] {
+			s.sendError(w, fmt.Sprintf("duplicate txid: %s", str))
+			return
+		}
+		if i == 0 {
+			first = txid
+		}
+		txids[txid] = true
+	}
+
+	var blockHash types.Hash
+	if str := query.Get("blockhash"); str != "" {
+		hash, err := types.NewHashFromString(str)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("invalid blockhash: %v", err))
+			return
+		}
+		blockHash = hash
+	} else {
+		hash, _, err := s.blockchain.GetTransactionLocation(first)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("transaction not found: %v", err))
+			return
+		}
+		blockHash = hash
+	}
+
+	block, err := s.blockchain.GetBlock(blockHash)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block not found: %v", err))
+		return
+	}
+
+	txHashes := make([]types.Hash, len(block.Transactions))
+	found := 0
+	for i := range block.Transactions {
+		txHash, err := serialization.HashTransaction(&block.Transactions[i])
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("failed to hash transaction: %v", err))
+			return
+		}
+		txHashes[i] = txHash
+		if txids[txHash] {
+			found++
+		}
+	}
+	if found != len(txids) {
+		s.sendError(w, "not all transactions found in the block")
+		return
+	}
+
+	proof, err := protocol.NewMerkleBlockMessage(block.Header, txHashes, txids).Serialize()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to serialize proof: %v", err))
+		return
+	}
+
+	s.sendSuccess(w, TxOutProofResponse{Proof: hex.EncodeToString(proof)})
+}
+
+// handleVerifyTxOutProof checks a proof from gettxoutproof and returns the
+// transactions it commits to
+func (s *Server) handleVerifyTxOutProof(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Proof string `json:"proof"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	data, err := hex.DecodeString(req.Proof)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid proof: %v", err))
+		return
+	}
+	merkleBlock, err := protocol.DeserializeMerkleBlock(data)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid proof: %v", err))
+		return
+	}
+
+	root, matches, err := merkleBlock.Tree.ExtractMatches()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid proof: %v", err))
+		return
+	}
+	if root != merkleBlock.Header.MerkleRoot {
+		s.sendError(w, "proof does not match the block's merkle root")
+		return
+	}
+
+	result := VerifyTxOutProofResponse{TxIDs: []string{}}
+
+	blockHash, err := serialization.HashBlockHeader(&merkleBlock.Header)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to hash header: %v", err))
+		return
+	}
+	if onMain, err := s.blockchain.IsMainChain(blockHash); err != nil || !onMain {
+		s.sendSuccess(w, result)
+		return
+	}
+
+	for _, txid := range matches {
+		result.TxIDs = append(result.TxIDs, txid.String())
+	}
+	s.sendSuccess(w, result)
+}
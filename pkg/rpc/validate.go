@@ -0,0 +1,153 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+)
+
+// MaxRequestBody is the largest request body the server reads
+const MaxRequestBody = 1024 * 1024
+
+// maxAddressLength is the longest possible bech32 address
+const maxAddressLength = 90
+
+// paramRule validates one named request parameter
+type paramRule struct {
+	name  string
+	check func(s *Server, value string) error
+}
+
+// Parameter rules shared by the endpoints
+var (
+	ruleAddress   = paramRule{"address", (*Server).checkAddress}
+	ruleAmount    = paramRule{"amount", (*Server).checkAmount}
+	ruleHeight    = paramRule{"height", (*Server).checkHeight}
+	ruleTxHash    = paramRule{"txhash", (*Server).checkHash}
+	ruleTxID      = paramRule{"txid", (*Server).checkHash}
+	ruleTxIDs     = paramRule{"txids", (*Server).checkHashList}
+	ruleBlockHash = paramRule{"blockhash", (*Server).checkHash}
+	ruleIP        = paramRule{"ip", (*Server).checkIP}
+)
+
+// validate runs the rules against the query string and the top-level
+// fields of a JSON body before handing the request to handler. Missing
+// parameters are left for the handler to report.
+func (s *Server) validate(handler http.HandlerFunc, rules ...paramRule) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := s.requestParams(r)
+		if err != nil {
+			s.sendError(w, err.Error())
+			return
+		}
+
+		for _, rule := range rules {
+			value, ok := params[rule.name]
+			if !ok {
+				continue
+			}
+			if err := rule.check(s, value); err != nil {
+				s.sendError(w, fmt.Sprintf("invalid %s: %v", rule.name, err))
+				return
+			}
+		}
+
+		handler(w, r)
+	}
+}
+
+// requestParams collects query and JSON body parameters as strings. The
+// body is put back so the handler can decode it as usual.
+func (s *Server) requestParams(r *http.Request) (map[string]string, error) {
+	params := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		if len(values) > 0 {
+			params[name] = values[0]
+		}
+	}
+
+	if r.Body == nil {
+		return params, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxRequestBody+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request: %w", err)
+	}
+	if err := s.validator.ValidateMessageSize(body); err != nil {
+		return nil, fmt.Errorf("request too large: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	// Bodies that aren't JSON objects are the handler's problem
+	var fields map[string]json.RawMessage
+	if len(bytes.TrimSpace(body)) == 0 || json.Unmarshal(body, &fields) != nil {
+		return params, nil
+	}
+
+	for name, raw := range fields {
+		var str string
+		if err := json.Unmarshal(raw, &str); err == nil {
+			params[name] = str
+			continue
+		}
+		params[name] = string(raw) // Numbers (and anything else) verbatim
+	}
+
+	return params, nil
+}
+
+// checkAddress accepts any address type valid on the wallet's network
+func (s *Server) checkAddress(value string) error {
+	if len(value) > maxAddressLength {
+		return fmt.Errorf("address too long: %d characters", len(value))
+	}
+	_, err := keys.DecodeAddressForNetwork(value, s.wallet.NetParams())
+	return err
+}
+
+func (s *Server) checkAmount(value string) error {
+	amount, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("not an integer amount of satoshis: %s", value)
+	}
+	return s.validator.ValidateAmount(amount)
+}
+
+func (s *Server) checkHeight(value string) error {
+	height, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("not a block height: %s", value)
+	}
+
+	_, best, err := s.blockchain.GetTip()
+	if err != nil {
+		return err
+	}
+	return s.validator.ValidateHeight(height, best)
+}
+
+func (s *Server) checkHash(value string) error {
+	return s.validator.ValidateHash(value)
+}
+
+// checkHashList accepts comma-separated hashes
+func (s *Server) checkHashList(value string) error {
+	for _, hash := range strings.Split(value, ",") {
+		if err := s.validator.ValidateHash(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) checkIP(value string) error {
+	return s.validator.ValidateIPAddress(value)
+}
@@ -0,0 +1,109 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// BlockReportResponse is returned by /validateblock, and by proposals and
+// rejected submissions that ask for it. It lists every rule checked, not
+// just the first to fail.
+type BlockReportResponse struct {
+	Hash          string       `json:"hash"`
+	Height        uint64       `json:"height,omitempty"` // Unknown if the block doesn't build on the tip
+	Valid         bool         `json:"valid"`
+	Reason        string       `json:"reason,omitempty"` // BIP22 reason of the first failure
+	Size          int          `json:"size"`
+	Weight        int          `json:"weight"`
+	SigOps        int          `json:"sigops"`
+	Fees          int64        `json:"fees"`
+	CoinbaseValue int64        `json:"coinbasevalue"`
+	Rules         []RuleResult `json:"rules"`
+}
+
+// RuleResult is one rule's outcome in a BlockReportResponse
+type RuleResult struct {
+	Rule   string `json:"rule"`
+	Result string `json:"result"` // "pass", "fail" or "skipped"
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// errNoBlockCheck is returned by checkBlockAtTip when the server lacks
+// what checking a block needs
+var errNoBlockCheck = errors.New("consensus rules and UTXO set not configured")
+
+// checkBlockAtTip checks every rule for a block extending the tip without
+// storing it
+func (s *Server) checkBlockAtTip(block *types.Block) (*validation.BlockReport, error) {
+	s.mu.RLock()
+	rules, view := s.rules, s.utxos
+	s.mu.RUnlock()
+	if rules == nil || view == nil {
+		return nil, errNoBlockCheck
+	}
+
+	now := time.Now()
+	if s.node != nil {
+		now = s.node.AdjustedTime().Now()
+	}
+	return validation.CheckBlockAtTip(s.blockchain, view, rules, block, now)
+}
+
+// newBlockReportResponse converts a block report to its RPC form
+func newBlockReportResponse(report *validation.BlockReport) *BlockReportResponse {
+	resp := &BlockReportResponse{
+		Hash:          report.Hash.String(),
+		Height:        report.Height,
+		Valid:         report.Valid(),
+		Size:          report.Size,
+		Weight:        report.Weight,
+		SigOps:        report.SigOps,
+		Fees:          report.Fees,
+		CoinbaseValue: report.CoinbaseValue,
+		Rules:         make([]RuleResult, len(report.Checks)),
+	}
+	if err := report.Err(); err != nil {
+		resp.Reason = validation.RejectReason(err)
+	}
+	for i, check := range report.Checks {
+		resp.Rules[i] = RuleResult{Rule: check.Rule, Result: string(check.Status)}
+		if check.Err != nil {
+			resp.Rules[i].Reason = validation.RejectReason(check.Err)
+			resp.Rules[i].Error = check.Err.Error()
+		}
+	}
+	return resp
+}
+
+// handleValidateBlock checks a hex-serialized block extending the tip
+// against every rule, proof of work included, without storing it. A
+// block failing rules is a normal result, not an error.
+func (s *Server) handleValidateBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	data, ok := s.readHexData(w, r)
+	if !ok {
+		return
+	}
+	block, err := serialization.DeserializeBlock(data)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("block decode failed: %v", err))
+		return
+	}
+
+	report, err := s.checkBlockAtTip(block)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to check block: %v", err))
+		return
+	}
+	s.sendSuccess(w, newBlockReportResponse(report))
+}
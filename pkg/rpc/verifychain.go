@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// VerifyChainResponse is returned by /verifychain. Corruption is a normal
+// result, not an error.
+type VerifyChainResponse struct {
+	Valid        bool    `json:"valid"`
+	CheckLevel   int     `json:"checklevel"`
+	From         uint64  `json:"from_height"`
+	To           uint64  `json:"to_height"`
+	FailedHeight *uint64 `json:"failed_height,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// handleVerifyChain re-validates the last nblocks blocks (0 = all) at
+// checklevel, both defaulting to what a node checks at startup
+func (s *Server) handleVerifyChain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	level := validation.DefaultCheckLevel
+	if v := query.Get("checklevel"); v != "" {
+		var err error
+		if level, err = strconv.Atoi(v); err != nil || level < 0 || level > validation.MaxCheckLevel {
+			s.sendError(w, fmt.Sprintf("checklevel must be 0 to %d", validation.MaxCheckLevel))
+			return
+		}
+	}
+	nblocks := uint64(validation.DefaultCheckBlocks)
+	if v := query.Get("nblocks"); v != "" {
+		var err error
+		if nblocks, err = strconv.ParseUint(v, 10, 64); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid nblocks: %v", err))
+			return
+		}
+	}
+
+	s.mu.RLock()
+	rules := s.rules
+	s.mu.RUnlock()
+
+	// The request's context stops the check if the client goes away
+	result, err := validation.VerifyChain(r.Context(), s.blockchain, rules, level, nblocks)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to verify chain: %v", err))
+		return
+	}
+
+	resp := VerifyChainResponse{
+		Valid:      result.Valid(),
+		CheckLevel: result.Level,
+		From:       result.From,
+		To:         result.To,
+	}
+	if !result.Valid() {
+		resp.FailedHeight = &result.Height
+		resp.Error = result.Err.Error()
+	}
+	s.sendSuccess(w, resp)
+}
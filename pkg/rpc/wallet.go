@@ -0,0 +1,864 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/miniscript"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// WalletParam is the query parameter naming the wallet a request is for.
+// Requests without it go to the default wallet.
+const WalletParam = "wallet"
+
+// walletFileSuffix ends the file name of every wallet in the wallet
+// directory
+const walletFileSuffix = ".json"
+
+// MaxUnlockTime caps walletpassphrase's timeout, in seconds
+const MaxUnlockTime = 100000000
+
+// validWalletName keeps wallet names usable as file names
+var validWalletName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// CreateWalletResponse is returned by /createwallet
+type CreateWalletResponse struct {
+	Name      string `json:"name"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// WalletStatusResponse is returned by /encryptwallet, /walletpassphrase
+// and /walletlock
+type WalletStatusResponse struct {
+	Encrypted     bool  `json:"encrypted"`
+	Locked        bool  `json:"locked"`
+	UnlockedUntil int64 `json:"unlocked_until,omitempty"` // Unix time
+}
+
+// ImportPrivKeyResponse is returned by /importprivkey
+type ImportPrivKeyResponse struct {
+	Address string `json:"address"`
+}
+
+// SetHDSeedResponse is returned by /sethdseed: the highest used index of
+// each chain, -1 if none, and where new addresses continue
+type SetHDSeedResponse struct {
+	wallet.Discovery
+	NextExternal uint32 `json:"next_external"`
+	NextInternal uint32 `json:"next_internal"`
+}
+
+// SetWalletFlagResponse is returned by /setwalletflag
+type SetWalletFlagResponse struct {
+	FlagName  string `json:"flag_name"`
+	FlagState bool   `json:"flag_state"`
+}
+
+// WalletDescriptorInfo is a descriptor listed by /listdescriptors
+type WalletDescriptorInfo struct {
+	Desc     string `json:"desc"`
+	Active   bool   `json:"active"`
+	Internal bool   `json:"internal,omitempty"`
+	Next     uint32 `json:"next,omitempty"`
+}
+
+// ListDescriptorsResponse is returned by /listdescriptors
+type ListDescriptorsResponse struct {
+	Descriptors []WalletDescriptorInfo `json:"descriptors"`
+}
+
+// DescriptorInfo is returned by /getdescriptorinfo
+type DescriptorInfo struct {
+	Descriptor string `json:"descriptor"` // With its checksum
+	Checksum   string `json:"checksum"`
+}
+
+// DumpWalletResponse is returned by /dumpwallet
+type DumpWalletResponse struct {
+	Filename string `json:"filename"`
+	Keys     int    `json:"keys"`
+}
+
+// UnspentInfo is a wallet output listed by /listunspent
+type UnspentInfo struct {
+	TxID          string `json:"txid"`
+	Vout          uint32 `json:"vout"`
+	Address       string `json:"address,omitempty"`
+	ScriptPubKey  string `json:"script_pubkey"`
+	Amount        int64  `json:"amount"`
+	Confirmations uint64 `json:"confirmations"`
+	Coinbase      bool   `json:"coinbase"`
+}
+
+type ListUnspentResponse struct {
+	Unspent []UnspentInfo `json:"unspent"`
+}
+
+// WalletTxInfo is a wallet transaction listed by /listtransactions
+type WalletTxInfo struct {
+	TxID          string `json:"txid"`
+	Category      string `json:"category"`
+	Amount        int64  `json:"amount"`
+	Fee           int64  `json:"fee,omitempty"`
+	Confirmations uint64 `json:"confirmations"`
+	Height        uint64 `json:"height,omitempty"`
+	Time          int64  `json:"time"`
+	ReplacedBy    string `json:"replaced_by_txid,omitempty"`
+	Conflicted    bool   `json:"conflicted,omitempty"`
+	ConflictedBy  string `json:"conflicted_by_txid,omitempty"`
+	Abandoned     bool   `json:"abandoned,omitempty"`
+}
+
+type ListTransactionsResponse struct {
+	Transactions []WalletTxInfo `json:"transactions"`
+}
+
+// BumpFeeResponse is returned by /bumpfee
+type BumpFeeResponse struct {
+	TxID      string `json:"txid"`
+	OrigFee   int64  `json:"origfee"`
+	Fee       int64  `json:"fee"`
+	Broadcast bool   `json:"broadcast"` // False when p2p networking is disabled
+}
+
+// SetWalletDir makes dir the directory /createwallet puts wallets in and
+// loads the wallets already there
+func (s *Server) SetWalletDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create wallet directory: %w", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+walletFileSuffix))
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]*wallet.Wallet, len(paths))
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), walletFileSuffix)
+		if !validWalletName.MatchString(name) {
+			continue
+		}
+		w, err := s.newWallet(path)
+		if err != nil {
+			return fmt.Errorf("wallet %s: %w", name, err)
+		}
+		loaded[name] = w
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.walletDir = dir
+	s.wallets = loaded
+	return nil
+}
+
+// newWallet loads or creates the wallet file at path, on the default
+// wallet's network and with its RBF setting
+func (s *Server) newWallet(path string) (*wallet.Wallet, error) {
+	w := wallet.NewWallet()
+	w.SetNetParams(s.wallet.NetParams())
+	w.SetOptInRBF(s.wallet.OptInRBF())
+	if err := w.LoadFromFile(path); err != nil {
+		return nil, err
+	}
+	w.SetFile(path)
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// walletFor returns the wallet a request names, or the default wallet
+func (s *Server) walletFor(r *http.Request) (*wallet.Wallet, error) {
+	name := r.URL.Query().Get(WalletParam)
+	if name == "" {
+		return s.wallet, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w, ok := s.wallets[name]
+	if !ok {
+		return nil, fmt.Errorf("wallet %q not found", name)
+	}
+	return w, nil
+}
+
+// requestWallet is walletFor that answers the request itself on failure
+func (s *Server) requestWallet(w http.ResponseWriter, r *http.Request) (*wallet.Wallet, bool) {
+	wal, err := s.walletFor(r)
+	if err != nil {
+		s.sendError(w, err.Error())
+		return nil, false
+	}
+	return wal, true
+}
+
+// handleCreateWallet adds a named wallet to the wallet directory
+func (s *Server) handleCreateWallet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Name       string `json:"name"`
+		Passphrase string `json:"passphrase,omitempty"` // Encrypts the new wallet at once
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if !validWalletName.MatchString(req.Name) {
+		s.sendError(w, "wallet name must be 1 to 64 letters, digits, '_' or '-'")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.walletDir == "" {
+		s.sendError(w, "no wallet directory configured")
+		return
+	}
+	if _, ok := s.wallets[req.Name]; ok {
+		s.sendError(w, fmt.Sprintf("wallet %q already exists", req.Name))
+		return
+	}
+	path := filepath.Join(s.walletDir, req.Name+walletFileSuffix)
+	if _, err := os.Stat(path); err == nil {
+		s.sendError(w, fmt.Sprintf("wallet file %s already exists", path))
+		return
+	}
+
+	created, err := s.newWallet(path)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to create wallet: %v", err))
+		return
+	}
+	if req.Passphrase != "" {
+		if err := created.EncryptWallet(req.Passphrase); err != nil {
+			os.Remove(path)
+			s.sendError(w, fmt.Sprintf("failed to encrypt wallet: %v", err))
+			return
+		}
+	}
+	if s.wallets == nil {
+		s.wallets = make(map[string]*wallet.Wallet)
+	}
+	s.wallets[req.Name] = created
+
+	s.sendSuccess(w, CreateWalletResponse{Name: req.Name, Encrypted: req.Passphrase != ""})
+}
+
+// handleEncryptWallet encrypts the wallet's keys and locks it
+func (s *Server) handleEncryptWallet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if err := wal.EncryptWallet(req.Passphrase); err != nil {
+		s.sendError(w, fmt.Sprintf("failed to encrypt wallet: %v", err))
+		return
+	}
+
+	s.sendSuccess(w, WalletStatusResponse{Encrypted: true, Locked: true})
+}
+
+// handleWalletPassphrase unlocks an encrypted wallet for a while
+func (s *Server) handleWalletPassphrase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase"`
+		Timeout    int64  `json:"timeout"` // Seconds
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.Timeout <= 0 || req.Timeout > MaxUnlockTime {
+		s.sendError(w, fmt.Sprintf("timeout must be 1 to %d seconds", MaxUnlockTime))
+		return
+	}
+
+	timeout := time.Duration(req.Timeout) * time.Second
+	if err := wal.Unlock(req.Passphrase, timeout); err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+
+	s.sendSuccess(w, WalletStatusResponse{Encrypted: true, UnlockedUntil: time.Now().Add(timeout).Unix()})
+}
+
+// handleWalletLock locks an encrypted wallet before its unlock times out
+func (s *Server) handleWalletLock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	if err := wal.Lock(); err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+	s.sendSuccess(w, WalletStatusResponse{Encrypted: true, Locked: true})
+}
+
+// handleSetWalletFlag changes a wallet flag. The only flag is avoid_reuse.
+func (s *Server) handleSetWalletFlag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Flag  string `json:"flag"`
+		Value *bool  `json:"value,omitempty"` // Default true
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.Flag != "avoid_reuse" {
+		s.sendError(w, fmt.Sprintf("unknown wallet flag %q", req.Flag))
+		return
+	}
+	value := req.Value == nil || *req.Value
+	if err := wal.SetAvoidReuse(value); err != nil {
+		s.sendError(w, fmt.Sprintf("failed to set %s: %v", req.Flag, err))
+		return
+	}
+
+	s.sendSuccess(w, SetWalletFlagResponse{FlagName: req.Flag, FlagState: value})
+}
+
+// handleListDescriptors exports the wallet's descriptors, with private
+// keys if private=true
+func (s *Server) handleListDescriptors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	private := false
+	if v := r.URL.Query().Get("private"); v != "" {
+		var err error
+		if private, err = strconv.ParseBool(v); err != nil {
+			s.sendError(w, fmt.Sprintf("invalid private flag: %s", v))
+			return
+		}
+	}
+	descs, err := wal.ListDescriptors(private)
+	if err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+	infos := make([]WalletDescriptorInfo, 0, len(descs))
+	for _, desc := range descs {
+		infos = append(infos, WalletDescriptorInfo{Desc: desc.Desc, Active: desc.Active, Internal: desc.Internal, Next: desc.Next})
+	}
+	s.sendSuccess(w, ListDescriptorsResponse{Descriptors: infos})
+}
+
+// handleGetDescriptorInfo computes a descriptor's checksum, checking the
+// one it carries if any
+func (s *Server) handleGetDescriptorInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Descriptor string `json:"descriptor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	body, err := miniscript.VerifyChecksum(req.Descriptor, false)
+	if err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+	checksum, err := miniscript.DescriptorChecksum(body)
+	if err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+	s.sendSuccess(w, DescriptorInfo{Descriptor: body + "#" + checksum, Checksum: checksum})
+}
+
+// handleImportPrivKey adds a WIF key to the wallet, by default rescanning
+// the chain for its outputs
+func (s *Server) handleImportPrivKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		PrivKey string `json:"privkey"`
+		Rescan  *bool  `json:"rescan,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	address, err := wal.ImportPrivateKey(req.PrivKey)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to import key: %v", err))
+		return
+	}
+	if req.Rescan == nil || *req.Rescan {
+		if err := s.rescan(wal); err != nil {
+			s.sendError(w, fmt.Sprintf("key imported, rescan failed: %v", err))
+			return
+		}
+	}
+
+	s.sendSuccess(w, ImportPrivKeyResponse{Address: address})
+}
+
+// handleSetHDSeed makes the wallet derive its keys from a seed. The chain
+// is searched for addresses the seed used before, up to gap_limit unused
+// ones in a row, and rescanned for their outputs.
+func (s *Server) handleSetHDSeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Seed     string `json:"seed"` // Hex
+		GapLimit int    `json:"gap_limit,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	seed, err := hex.DecodeString(req.Seed)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid seed: %v", err))
+		return
+	}
+	gapLimit := req.GapLimit
+	if gapLimit == 0 {
+		gapLimit = wallet.DefaultGapLimit
+	}
+	if gapLimit < 0 {
+		s.sendError(w, fmt.Sprintf("invalid gap limit %d", gapLimit))
+		return
+	}
+
+	used, err := s.usedAddresses(wal.NetParams())
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to scan the chain: %v", err))
+		return
+	}
+	if err := wal.SetHDSeed(seed); err != nil {
+		s.sendError(w, fmt.Sprintf("failed to set HD seed: %v", err))
+		return
+	}
+	found, err := wal.DiscoverAddresses(func(address string) bool { return used[address] }, gapLimit)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("HD seed set, address discovery failed: %v", err))
+		return
+	}
+	if err := s.rescan(wal); err != nil {
+		s.sendError(w, fmt.Sprintf("HD seed set, rescan failed: %v", err))
+		return
+	}
+
+	resp := SetHDSeedResponse{Discovery: found}
+	resp.NextExternal, _ = wal.NextIndex(wallet.ExternalChain)
+	resp.NextInternal, _ = wal.NextIndex(wallet.InternalChain)
+	s.sendSuccess(w, resp)
+}
+
+// usedAddresses returns every address paid by an output on the best
+// chain. The address index is keyed by script, not address, and may be
+// off, so it reads every block.
+func (s *Server) usedAddresses(params *keys.NetParams) (map[string]bool, error) {
+	best, err := s.blockchain.GetBestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for height := uint64(0); height <= best; height++ {
+		block, err := s.blockchain.GetBlockByHeight(height)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load block %d: %w", height, err)
+		}
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Outputs {
+				if addr, err := keys.AddressFromScript(out.PubKeyScript, params); err == nil {
+					used[addr.String()] = true
+				}
+			}
+		}
+	}
+	return used, nil
+}
+
+// rescan feeds the whole best chain to a wallet so it finds the outputs
+// of newly imported keys
+func (s *Server) rescan(wal *wallet.Wallet) error {
+	best, err := s.blockchain.GetBestBlockHeight()
+	if err != nil {
+		return err
+	}
+	for height := uint64(0); height <= best; height++ {
+		block, err := s.blockchain.GetBlockByHeight(height)
+		if err != nil {
+			return fmt.Errorf("failed to load block %d: %w", height, err)
+		}
+		wal.BlockConnected(block, height)
+	}
+	return wal.Flush()
+}
+
+// handleDumpWallet writes every key of the wallet in WIF to a new file on
+// the node's machine
+func (s *Server) handleDumpWallet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Filename string `json:"filename"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.Filename == "" {
+		s.sendError(w, "missing filename")
+		return
+	}
+
+	exports, err := wal.DumpKeys()
+	if err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Wallet dump created by learn-bitcoin\n")
+	fmt.Fprintf(&b, "# * Created on %s\n", time.Now().UTC().Format(time.RFC3339))
+	if tip, err := s.blockchain.GetBestBlockHash(); err == nil {
+		height, _ := s.blockchain.GetBestBlockHeight()
+		fmt.Fprintf(&b, "# * Best block at time of backup was %d (%s)\n", height, tip)
+	}
+	fmt.Fprintf(&b, "\n")
+	for _, export := range exports {
+		fmt.Fprintf(&b, "%s addr=%s\n", export.WIF, export.Address)
+	}
+	fmt.Fprintf(&b, "\n# End of dump\n")
+
+	// Never overwrite: the file holds every private key in the clear
+	file, err := os.OpenFile(req.Filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to create dump file: %v", err))
+		return
+	}
+	if _, err := file.WriteString(b.String()); err != nil {
+		file.Close()
+		os.Remove(req.Filename)
+		s.sendError(w, fmt.Sprintf("failed to write dump file: %v", err))
+		return
+	}
+	if err := file.Close(); err != nil {
+		s.sendError(w, fmt.Sprintf("failed to write dump file: %v", err))
+		return
+	}
+
+	s.sendSuccess(w, DumpWalletResponse{Filename: req.Filename, Keys: len(exports)})
+}
+
+// handleListUnspent lists the wallet's spendable outputs with at least
+// minconf confirmations (default 1)
+func (s *Server) handleListUnspent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	minConf := uint64(1)
+	if v := r.URL.Query().Get("minconf"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("invalid minconf: %s", v))
+			return
+		}
+		minConf = n
+	}
+	best, err := s.blockchain.GetBestBlockHeight()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to get height: %v", err))
+		return
+	}
+
+	params := wal.NetParams()
+	unspent := make([]UnspentInfo, 0)
+	for _, coin := range wal.ListUTXOs() {
+		status, _ := wal.OutputStatus(coin.OutPoint())
+		if status == wallet.StatusConflicted {
+			continue
+		}
+		confirmations := uint64(0)
+		if status == wallet.StatusConfirmed && coin.Height <= best {
+			confirmations = best - coin.Height + 1
+		}
+		if confirmations < minConf {
+			continue
+		}
+		unspent = append(unspent, UnspentInfo{
+			TxID:          coin.TxHash.String(),
+			Vout:          coin.OutputIndex,
+			Address:       scriptAddress(coin.Output.PubKeyScript, params),
+			ScriptPubKey:  fmt.Sprintf("%x", coin.Output.PubKeyScript),
+			Amount:        coin.Value(),
+			Confirmations: confirmations,
+			Coinbase:      coin.IsCoinbase,
+		})
+	}
+	sort.Slice(unspent, func(i, j int) bool {
+		if unspent[i].Confirmations != unspent[j].Confirmations {
+			return unspent[i].Confirmations > unspent[j].Confirmations
+		}
+		if unspent[i].TxID != unspent[j].TxID {
+			return unspent[i].TxID < unspent[j].TxID
+		}
+		return unspent[i].Vout < unspent[j].Vout
+	})
+
+	s.sendSuccess(w, ListUnspentResponse{Unspent: unspent})
+}
+
+// scriptAddress returns the P2PKH address a script pays on params, or ""
+func scriptAddress(pkScript []byte, params *keys.NetParams) string {
+	hash, err := script.ExtractP2PKHAddress(pkScript)
+	if err != nil {
+		return ""
+	}
+	addr, err := keys.NewAddress(params.PubKeyHashAddrID, hash)
+	if err != nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// handleListTransactions lists the most recent wallet transactions
+func (s *Server) handleListTransactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	count, skip := 10, 0
+	for name, dst := range map[string]*int{"count": &count, "skip": &skip} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.sendError(w, fmt.Sprintf("invalid %s: %s", name, v))
+			return
+		}
+		*dst = n
+	}
+	best, err := s.blockchain.GetBestBlockHeight()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to get height: %v", err))
+		return
+	}
+
+	records := wal.ListTransactions(count, skip)
+	txs := make([]WalletTxInfo, len(records))
+	for i, rec := range records {
+		txs[i] = WalletTxInfo{
+			TxID:     rec.TxHash.String(),
+			Category: rec.Category,
+			Amount:   rec.Amount,
+			Fee:      rec.Fee,
+			Height:   rec.Height,
+			Time:     rec.Time.Unix(),
+		}
+		if rec.Confirmed() && rec.Height <= best {
+			txs[i].Confirmations = best - rec.Height + 1
+		}
+		if !rec.ReplacedBy.IsZero() {
+			txs[i].ReplacedBy = rec.ReplacedBy.String()
+		}
+		txs[i].Conflicted, txs[i].Abandoned = rec.Conflicted, rec.Abandoned
+		if !rec.ConflictedBy.IsZero() {
+			txs[i].ConflictedBy = rec.ConflictedBy.String()
+		}
+	}
+
+	s.sendSuccess(w, ListTransactionsResponse{Transactions: txs})
+}
+
+// handleBumpFee replaces an unconfirmed wallet transaction with one paying
+// a higher fee and relays it
+func (s *Server) handleBumpFee(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TxID string `json:"txid"`
+		Fee  int64  `json:"fee,omitempty"` // Total new fee, 0 = bump by the default rate
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	txHash, err := types.NewHashFromString(req.TxID)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid txid: %v", err))
+		return
+	}
+	if req.Fee < 0 {
+		s.sendError(w, "fee must not be negative")
+		return
+	}
+
+	orig, _ := wal.GetTransactionRecord(txHash)
+	tx, err := wal.BumpFee(txHash, req.Fee)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to bump fee: %v", err))
+		return
+	}
+	newHash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("failed to hash transaction: %v", err))
+		return
+	}
+	bumped, _ := wal.GetTransactionRecord(newHash)
+	wal.Flush()
+
+	resp := BumpFeeResponse{TxID: newHash.String(), OrigFee: orig.Fee, Fee: bumped.Fee}
+	if s.node != nil {
+		if err := s.node.BroadcastTransaction(tx); err != nil {
+			s.sendError(w, fmt.Sprintf("replacement %s created but not relayed: %v", newHash, err))
+			return
+		}
+		resp.Broadcast = true
+	}
+
+	s.sendSuccess(w, resp)
+}
+
+// handleAbandonTransaction marks an unconfirmed wallet transaction that
+// is not in the mempool abandoned, so its inputs can be spent again
+func (s *Server) handleAbandonTransaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	wal, ok := s.requestWallet(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TxID string `json:"txid"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	txHash, err := types.NewHashFromString(req.TxID)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid txid: %v", err))
+		return
+	}
+
+	// A transaction in the mempool may still be mined
+	if s.node != nil && s.node.Mempool.Exists(txHash) {
+		s.sendError(w, fmt.Sprintf("transaction %s is in the mempool and cannot be abandoned", txHash))
+		return
+	}
+	if err := wal.AbandonTransaction(txHash); err != nil {
+		s.sendError(w, err.Error())
+		return
+	}
+	wal.Flush()
+
+	s.sendSuccess(w, nil)
+}
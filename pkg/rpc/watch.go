@@ -0,0 +1,212 @@
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/watch"
+)
+
+// WatchResponse is returned by /watchaddress and /unwatchaddress
+type WatchResponse struct {
+	Script  string `json:"script"`            // Hex
+	Address string `json:"address,omitempty"` // If the script has one
+}
+
+// WatchEventInfo is an event returned by /waitforwatchevents
+type WatchEventInfo struct {
+	Sequence  uint64 `json:"sequence"`
+	Type      string `json:"type"` // "received" or "spent"
+	TxID      string `json:"txid"` // Paying or spending transaction
+	Index     uint32 `json:"index"`
+	PrevTxID  string `json:"prev_txid"` // The output created or spent
+	PrevIndex uint32 `json:"prev_index"`
+	Script    string `json:"script,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Value     int64  `json:"value"`
+	Confirmed bool   `json:"confirmed"`
+	Height    uint64 `json:"height,omitempty"`
+}
+
+// WatchEventsResponse is returned by /waitforwatchevents. Pass Last as
+// since to get only later events.
+type WatchEventsResponse struct {
+	Events []WatchEventInfo `json:"events"`
+	Last   uint64           `json:"last"`
+}
+
+// SetWatcher attaches the watcher behind the address watch calls
+func (s *Server) SetWatcher(watcher *watch.Watcher) {
+	s.mu.Lock()
+	s.watcher = watcher
+	s.mu.Unlock()
+}
+
+// requestWatcher returns the watcher, or sends an error if there is none
+func (s *Server) requestWatcher(w http.ResponseWriter) *watch.Watcher {
+	s.mu.RLock()
+	watcher := s.watcher
+	s.mu.RUnlock()
+	if watcher == nil {
+		s.sendError(w, "address watching not configured")
+	}
+	return watcher
+}
+
+// readWatchScript decodes the address or hex script a watch call names
+func (s *Server) readWatchScript(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		s.sendError(w, "method not allowed")
+		return nil, false
+	}
+
+	var req struct {
+		Address string `json:"address"`
+		Script  string `json:"script"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, fmt.Sprintf("invalid request: %v", err))
+		return nil, false
+	}
+	if (req.Address == "") == (req.Script == "") {
+		s.sendError(w, "need either address or script")
+		return nil, false
+	}
+
+	if req.Script != "" {
+		script, err := hex.DecodeString(req.Script)
+		if err != nil || len(script) == 0 {
+			s.sendError(w, fmt.Sprintf("invalid script: %s", req.Script))
+			return nil, false
+		}
+		return script, true
+	}
+
+	addr, err := keys.DecodeAddressForNetwork(req.Address, s.netParams())
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid address: %v", err))
+		return nil, false
+	}
+	script, err := addr.Script()
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("invalid address: %v", err))
+		return nil, false
+	}
+	return script, true
+}
+
+// watchResponse describes a watched script
+func (s *Server) watchResponse(script []byte) WatchResponse {
+	resp := WatchResponse{Script: hex.EncodeToString(script)}
+	if addr, err := keys.AddressFromScript(script, s.netParams()); err == nil {
+		resp.Address = addr.String()
+	}
+	return resp
+}
+
+// handleWatchAddress starts reporting outputs paying an address or script
+// and spends of them
+func (s *Server) handleWatchAddress(w http.ResponseWriter, r *http.Request) {
+	watcher := s.requestWatcher(w)
+	if watcher == nil {
+		return
+	}
+	script, ok := s.readWatchScript(w, r)
+	if !ok {
+		return
+	}
+
+	watcher.WatchScript(script)
+	s.sendSuccess(w, s.watchResponse(script))
+}
+
+// handleUnwatchAddress stops reporting new outputs paying an address or
+// script
+func (s *Server) handleUnwatchAddress(w http.ResponseWriter, r *http.Request) {
+	watcher := s.requestWatcher(w)
+	if watcher == nil {
+		return
+	}
+	script, ok := s.readWatchScript(w, r)
+	if !ok {
+		return
+	}
+
+	if !watcher.UnwatchScript(script) {
+		s.sendError(w, "script is not watched")
+		return
+	}
+	s.sendSuccess(w, s.watchResponse(script))
+}
+
+// handleWaitForWatchEvents returns the events after since. If there are
+// none it waits for one, for at most timeout milliseconds if given.
+func (s *Server) handleWaitForWatchEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.sendError(w, "method not allowed")
+		return
+	}
+	watcher := s.requestWatcher(w)
+	if watcher == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	since := uint64(0)
+	if value := query.Get("since"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("invalid since: %s", value))
+			return
+		}
+		since = n
+	}
+	ctx := r.Context()
+	if value := query.Get("timeout"); value != "" {
+		ms, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("invalid timeout: %s", value))
+			return
+		}
+		if ms > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+			defer cancel()
+		}
+	}
+
+	events, last := watcher.Wait(ctx, since)
+	if r.Context().Err() != nil {
+		return // Client went away or the server is stopping
+	}
+
+	resp := WatchEventsResponse{Events: make([]WatchEventInfo, len(events)), Last: last}
+	params := s.netParams()
+	for i, event := range events {
+		info := WatchEventInfo{
+			Sequence:  event.Sequence,
+			Type:      string(event.Type),
+			TxID:      event.TxHash.String(),
+			Index:     event.Index,
+			PrevTxID:  event.Outpoint.Hash.String(),
+			PrevIndex: event.Outpoint.Index,
+			Value:     event.Value,
+			Confirmed: event.Confirmed,
+			Height:    event.Height,
+		}
+		if len(event.Script) > 0 {
+			info.Script = hex.EncodeToString(event.Script)
+			if addr, err := keys.AddressFromScript(event.Script, params); err == nil {
+				info.Address = addr.String()
+			}
+		}
+		resp.Events[i] = info
+	}
+	s.sendSuccess(w, resp)
+}
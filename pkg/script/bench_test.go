@@ -0,0 +1,56 @@
+package script_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// Run with e.g. `go test ./pkg/script -run=^$ -bench=. -benchmem`
+
+func BenchmarkScriptVerification(b *testing.B) {
+	block, set, err := testharness.SyntheticBlock(testharness.BenchBlockConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// The outputs each spend consumes, indexed like block.Transactions
+	prevs := make([][]types.TxOutput, len(block.Transactions))
+	for i := 1; i < len(block.Transactions); i++ {
+		for _, input := range block.Transactions[i].Inputs {
+			coin, err := set.Get(utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex))
+			if err != nil {
+				b.Fatal(err)
+			}
+			prevs[i] = append(prevs[i], coin.Output)
+		}
+	}
+
+	// Both report time per transaction. Transactions are checked
+	// independently, the way Bitcoin Core's checkqueue hands script checks
+	// to worker threads.
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			j := 1 + i%(len(block.Transactions)-1)
+			if err := transaction.ValidateTransactionScripts(&block.Transactions[j], prevs[j]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		var next atomic.Int64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				j := 1 + int(next.Add(1))%(len(block.Transactions)-1)
+				if err := transaction.ValidateTransactionScripts(&block.Transactions[j], prevs[j]); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
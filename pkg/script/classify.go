@@ -0,0 +1,188 @@
+package script
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// ScriptClass is the standard template a locking script follows
+type ScriptClass int
+
+const (
+	NonStandardTy ScriptClass = iota
+	PubKeyTy
+	PubKeyHashTy
+	ScriptHashTy
+	MultiSigTy
+	NullDataTy
+	WitnessV0KeyHashTy
+	WitnessV0ScriptHashTy
+	WitnessV1TaprootTy
+	WitnessUnknownTy
+)
+
+// String returns the class name used by Bitcoin Core's RPCs
+func (c ScriptClass) String() string {
+	switch c {
+	case PubKeyTy:
+		return "pubkey"
+	case PubKeyHashTy:
+		return "pubkeyhash"
+	case ScriptHashTy:
+		return "scripthash"
+	case MultiSigTy:
+		return "multisig"
+	case NullDataTy:
+		return "nulldata"
+	case WitnessV0KeyHashTy:
+		return "witness_v0_keyhash"
+	case WitnessV0ScriptHashTy:
+		return "witness_v0_scripthash"
+	case WitnessV1TaprootTy:
+		return "witness_v1_taproot"
+	case WitnessUnknownTy:
+		return "witness_unknown"
+	default:
+		return "nonstandard"
+	}
+}
+
+// ClassifyScript returns the template a locking script follows
+func ClassifyScript(pkScript []byte) ScriptClass {
+	if version, program, ok := ExtractWitnessProgram(pkScript); ok {
+		switch {
+		case version == 0 && len(program) == 20:
+			return WitnessV0KeyHashTy
+		case version == 0 && len(program) == 32:
+			return WitnessV0ScriptHashTy
+		case version == 0:
+			return NonStandardTy
+		case version == 1 && len(program) == 32:
+			return WitnessV1TaprootTy
+		default:
+			return WitnessUnknownTy
+		}
+	}
+
+	switch {
+	case IsP2PKH(pkScript):
+		return PubKeyHashTy
+	case IsP2SH(pkScript):
+		return ScriptHashTy
+	case IsNullData(pkScript) && len(pkScript) <= MaxNullDataScriptSize && IsPushOnly(pkScript[1:]):
+		return NullDataTy
+	case isPubKey(pkScript):
+		return PubKeyTy
+	case isMultiSig(pkScript):
+		return MultiSigTy
+	}
+	return NonStandardTy
+}
+
+// IsP2SH checks if script is a P2SH locking script
+func IsP2SH(script []byte) bool {
+	return len(script) == 23 &&
+		script[0] == OP_HASH160 &&
+		script[1] == 20 &&
+		script[22] == OP_EQUAL
+}
+
+// ExtractWitnessProgram returns the version and program of a segwit
+// locking script: a version opcode followed by one 2 to 40 byte push
+func ExtractWitnessProgram(pkScript []byte) (byte, []byte, bool) {
+	if len(pkScript) < 4 || len(pkScript) > 42 {
+		return 0, nil, false
+	}
+	if pkScript[0] != OP_0 && (pkScript[0] < OP_1 || pkScript[0] > OP_16) {
+		return 0, nil, false
+	}
+	if int(pkScript[1])+2 != len(pkScript) {
+		return 0, nil, false
+	}
+
+	version := byte(0)
+	if pkScript[0] != OP_0 {
+		version = pkScript[0] - OP_1 + 1
+	}
+	return version, pkScript[2:], true
+}
+
+// ExtractScriptHash returns the hash a P2SH or P2PKH script commits to,
+// and the key hash or program of a segwit script
+func ExtractScriptHash(pkScript []byte) []byte {
+	switch ClassifyScript(pkScript) {
+	case PubKeyHashTy:
+		return pkScript[3:23]
+	case ScriptHashTy:
+		return pkScript[2:22]
+	case WitnessV0KeyHashTy, WitnessV0ScriptHashTy, WitnessV1TaprootTy, WitnessUnknownTy:
+		return pkScript[2:]
+	}
+	return nil
+}
+
+// isPubKey matches <33 or 65 byte pubkey> OP_CHECKSIG
+func isPubKey(script []byte) bool {
+	n := len(script)
+	return (n == 35 && script[0] == 33 || n == 67 && script[0] == 65) && script[n-1] == OP_CHECKSIG
+}
+
+// isMultiSig matches OP_m <pubkey>... OP_n OP_CHECKMULTISIG
+func isMultiSig(script []byte) bool {
+	if len(script) < 3 || script[len(script)-1] != OP_CHECKMULTISIG {
+		return false
+	}
+	pushes, err := Pushes(script[:len(script)-1])
+	if err != nil || len(pushes) < 3 {
+		return false
+	}
+
+	m, n := smallInt(script[0]), smallInt(script[len(script)-2])
+	keys := pushes[1 : len(pushes)-1]
+	if m < 1 || n < m || n != len(keys) {
+		return false
+	}
+	for _, key := range keys {
+		if len(key) != 33 && len(key) != 65 {
+			return false
+		}
+	}
+	return true
+}
+
+// smallInt returns the value of OP_1 to OP_16, or -1
+func smallInt(opcode byte) int {
+	if opcode < OP_1 || opcode > OP_16 {
+		return -1
+	}
+	return int(opcode - OP_1 + 1)
+}
+
+// Asm renders a script the way Bitcoin Core's RPCs do: pushed data as
+// hex, small integers as numbers, and other opcodes by name. A push
+// running past the end of the script ends the output with [error].
+func Asm(script []byte) string {
+	var parts []string
+	for pc := 0; pc < len(script); {
+		opcode, data, next, err := parseOp(script, pc)
+		if err != nil {
+			parts = append(parts, "[error]")
+			break
+		}
+		switch {
+		case opcode == OP_0:
+			parts = append(parts, "0")
+		case opcode <= OP_PUSHDATA4:
+			parts = append(parts, hex.EncodeToString(data))
+		case opcode == OP_1NEGATE:
+			parts = append(parts, "-1")
+		case smallInt(opcode) > 0:
+			parts = append(parts, strconv.Itoa(smallInt(opcode)))
+		default:
+			parts = append(parts, OpcodeName(opcode))
+		}
+		pc = next
+	}
+	return strings.Join(parts, " ")
+}
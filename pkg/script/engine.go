@@ -3,20 +3,59 @@ package script
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 	"golang.org/x/crypto/ripemd160"
 )
 
+// SigChecker reports whether sig is a valid signature by pubKey for the
+// input being validated. Without one the engine accepts any non-empty
+// legacy signature; tapscript signature checks fail.
+type SigChecker func(sig, pubKey []byte) bool
+
+// LockTimeThreshold separates lock times that are block heights (below)
+// from ones that are Unix timestamps
+const LockTimeThreshold = 500000000
+
+// maxMultiSigKeys caps the public keys of OP_CHECKMULTISIG
+const maxMultiSigKeys = 20
+
+// Interpreter limits. The size and operation limits apply to each script
+// on its own: an input's scriptSig and the output script it spends are
+// counted separately (see NewInputEngine).
+const (
+	MaxScriptSize   = 10000 // Bytes
+	MaxElementSize  = 520   // Bytes pushed by one opcode
+	MaxOpsPerScript = 201   // Opcodes above OP_16, plus OP_CHECKMULTISIG keys
+	MaxStackSize    = 1000  // Items on the main and alt stacks together
+)
+
+var (
+	ErrScriptSize  = errors.New("script size exceeds limit")
+	ErrElementSize = errors.New("push size exceeds limit")
+	ErrOpCount     = errors.New("operation count exceeds limit")
+	ErrStackSize   = errors.New("stack size exceeds limit")
+)
+
 // Engine executes Bitcoin scripts
 type Engine struct {
-	stack    *Stack
-	altStack *Stack
-	script   []byte
-	pc       int         // Program counter
-	tx       interface{} // Transaction being validated
-	inputIdx int         // Input index being validated
+	stack      *Stack
+	altStack   *Stack
+	script     []byte
+	next       [][]byte    // Scripts run after this one, on the stack it leaves
+	pc         int         // Program counter
+	tx         interface{} // Transaction being validated
+	inputIdx   int         // Input index being validated
+	condStack  []bool      // One entry per open OP_IF, false in a branch not taken
+	opCount    int         // Towards MaxOpsPerScript
+	witness    bool        // Running a witness program, which must leave a clean stack
+	sigChecker SigChecker
+
+	tapscript    bool // Running a tapscript leaf (BIP342)
+	sigOpsBudget int  // Tapscript signature checks left, times SigOpsBudgetPerCheck
+	opSuccess    bool // The tapscript contains an OP_SUCCESSx and succeeds
 }
 
 // NewEngine creates a new script execution engine
@@ -29,12 +68,30 @@ func NewEngine(script []byte) *Engine {
 	}
 }
 
+// NewInputEngine creates an engine running an input's scriptSig and then
+// the output script it spends, on the stack the scriptSig leaves. Each
+// gets its own size and operation limits, alt stack and conditionals.
+func NewInputEngine(sigScript, pubKeyScript []byte) *Engine {
+	engine := NewEngine(sigScript)
+	engine.next = [][]byte{pubKeyScript}
+	return engine
+}
+
 // Execute runs the script
 func (e *Engine) Execute() error {
-	for e.pc < len(e.script) {
-		if err := e.step(); err != nil {
-			return fmt.Errorf("execution failed at pc=%d: %w", e.pc, err)
+	if e.opSuccess {
+		return nil
+	}
+	for {
+		if err := e.run(); err != nil {
+			return err
 		}
+		if len(e.next) == 0 {
+			break
+		}
+		e.script, e.next = e.next[0], e.next[1:]
+		e.pc, e.opCount = 0, 0
+		e.altStack = NewStack()
 	}
 
 	// Script succeeds if stack top is true
@@ -50,7 +107,30 @@ func (e *Engine) Execute() error {
 	if !castToBool(top) {
 		return fmt.Errorf("script failed: false on stack")
 	}
+	if e.witness && e.stack.Size() != 1 {
+		return fmt.Errorf("%w: %d elements left", ErrCleanStack, e.stack.Size())
+	}
+
+	return nil
+}
+
+// run executes the current script to its end
+func (e *Engine) run() error {
+	if len(e.script) > MaxScriptSize && !e.tapscript {
+		return fmt.Errorf("%w: %d bytes", ErrScriptSize, len(e.script))
+	}
 
+	for e.pc < len(e.script) {
+		if err := e.step(); err != nil {
+			return fmt.Errorf("execution failed at pc=%d: %w", e.pc, err)
+		}
+		if size := e.stack.Size() + e.altStack.Size(); size > MaxStackSize {
+			return fmt.Errorf("execution failed at pc=%d: %w: %d items", e.pc, ErrStackSize, size)
+		}
+	}
+	if len(e.condStack) != 0 {
+		return fmt.Errorf("script failed: unbalanced conditional")
+	}
 	return nil
 }
 
@@ -61,18 +141,37 @@ func (e *Engine) step() error {
 	}
 
 	opcode := e.script[e.pc]
+
+	// Limits apply in branches not taken too. Tapscript has no opcode
+	// limit: its signature budget bounds the expensive ones.
+	if opcode > OP_16 && !e.tapscript {
+		if err := e.countOps(1); err != nil {
+			return err
+		}
+	}
+	if opcode <= OP_PUSHDATA4 {
+		_, data, next, err := parseOp(e.script, e.pc)
+		if err != nil {
+			return err
+		}
+		if len(data) > MaxElementSize {
+			return fmt.Errorf("%w: %d bytes", ErrElementSize, len(data))
+		}
+		e.pc = next
+		if e.executing() {
+			e.stack.Push(append(make([]byte, 0, len(data)), data...))
+		}
+		return nil
+	}
 	e.pc++
 
-	// Handle data push opcodes (0x01-0x4b push that many bytes)
-	if opcode > 0 && opcode <= 0x4b {
-		return e.executePush(int(opcode))
+	// Inside a branch not taken only the flow control opcodes run
+	if !e.executing() && (opcode < OP_IF || opcode > OP_ENDIF) {
+		return nil
 	}
 
 	// Handle specific opcodes
 	switch opcode {
-	case OP_0:
-		e.stack.Push([]byte{})
-
 	case OP_1NEGATE:
 		e.stack.PushInt(-1)
 
@@ -83,6 +182,21 @@ func (e *Engine) step() error {
 	case OP_NOP:
 		// Do nothing
 
+	case OP_IF, OP_NOTIF:
+		return e.opIf(opcode == OP_NOTIF)
+
+	case OP_ELSE:
+		if len(e.condStack) == 0 {
+			return fmt.Errorf("OP_ELSE without OP_IF")
+		}
+		e.condStack[len(e.condStack)-1] = !e.condStack[len(e.condStack)-1]
+
+	case OP_ENDIF:
+		if len(e.condStack) == 0 {
+			return fmt.Errorf("OP_ENDIF without OP_IF")
+		}
+		e.condStack = e.condStack[:len(e.condStack)-1]
+
 	case OP_VERIFY:
 		return e.opVerify()
 
@@ -107,6 +221,15 @@ func (e *Engine) step() error {
 	case OP_SHA256:
 		return e.opSHA256()
 
+	case OP_NUMEQUAL:
+		return e.opNumEqual()
+
+	case OP_NUMEQUALVERIFY:
+		if err := e.opNumEqual(); err != nil {
+			return err
+		}
+		return e.opVerify()
+
 	case OP_CHECKSIG:
 		return e.opCheckSig()
 
@@ -116,6 +239,24 @@ func (e *Engine) step() error {
 		}
 		return e.opVerify()
 
+	case OP_CHECKMULTISIG:
+		return e.opCheckMultiSig()
+
+	case OP_CHECKMULTISIGVERIFY:
+		if err := e.opCheckMultiSig(); err != nil {
+			return err
+		}
+		return e.opVerify()
+
+	case OP_CHECKSIGADD:
+		if !e.tapscript {
+			return fmt.Errorf("OP_CHECKSIGADD outside tapscript")
+		}
+		return e.opCheckSigAdd()
+
+	case OP_CHECKLOCKTIMEVERIFY:
+		return e.opCheckLockTimeVerify()
+
 	case OP_DROP:
 		_, err := e.stack.Pop()
 		return err
@@ -130,17 +271,12 @@ func (e *Engine) step() error {
 	return nil
 }
 
-// executePush pushes N bytes onto stack
-func (e *Engine) executePush(n int) error {
-	if e.pc+n > len(e.script) {
-		return fmt.Errorf("push %d bytes exceeds script length", n)
+// countOps adds n to the operation count, failing past MaxOpsPerScript
+func (e *Engine) countOps(n int) error {
+	e.opCount += n
+	if e.opCount > MaxOpsPerScript {
+		return fmt.Errorf("%w: %d", ErrOpCount, e.opCount)
 	}
-
-	data := make([]byte, n)
-	copy(data, e.script[e.pc:e.pc+n])
-	e.pc += n
-
-	e.stack.Push(data)
 	return nil
 }
 
@@ -179,6 +315,20 @@ func (e *Engine) opEqual() error {
 	return nil
 }
 
+// opNumEqual pops two numbers and pushes whether they are equal
+func (e *Engine) opNumEqual() error {
+	a, err := e.popInt()
+	if err != nil {
+		return err
+	}
+	b, err := e.popInt()
+	if err != nil {
+		return err
+	}
+	e.stack.Push(boolItem(a == b))
+	return nil
+}
+
 // opHash160 performs RIPEMD160(SHA256(x))
 func (e *Engine) opHash160() error {
 	item, err := e.stack.Pop()
@@ -225,19 +375,183 @@ func (e *Engine) opCheckSig() error {
 		return err
 	}
 
-	// Basic validation - ensure we have data
-	if len(pubKeyBytes) == 0 || len(sigBytes) == 0 {
-		e.stack.Push([]byte{})
+	if e.tapscript {
+		ok, err := e.checkTapscriptSig(sigBytes, pubKeyBytes)
+		if err != nil {
+			return err
+		}
+		e.stack.Push(boolItem(ok))
 		return nil
 	}
 
-	// For now, push true (we'll implement full validation later)
-	// Full implementation requires transaction context
+	if e.checkSig(sigBytes, pubKeyBytes) {
+		e.stack.Push([]byte{1})
+	} else {
+		e.stack.Push([]byte{})
+	}
+
+	return nil
+}
+
+// checkSig verifies one signature with the engine's SigChecker. Without
+// one any non-empty signature and key pass (full validation requires
+// transaction context).
+func (e *Engine) checkSig(sig, pubKey []byte) bool {
+	if len(pubKey) == 0 || len(sig) == 0 {
+		return false
+	}
+	if e.sigChecker == nil {
+		return true
+	}
+	return e.sigChecker(sig, pubKey)
+}
+
+// opCheckMultiSig pops n keys and m signatures and pushes whether every
+// signature matches one of the keys, in the same order
+func (e *Engine) opCheckMultiSig() error {
+	if e.tapscript {
+		return ErrTapscriptCheckMulti
+	}
+	n, err := e.popInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > maxMultiSigKeys {
+		return fmt.Errorf("invalid public key count %d", n)
+	}
+	if err := e.countOps(n); err != nil {
+		return err
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = e.stack.Pop(); err != nil {
+			return err
+		}
+	}
+
+	m, err := e.popInt()
+	if err != nil {
+		return err
+	}
+	if m < 0 || m > n {
+		return fmt.Errorf("invalid signature count %d of %d", m, n)
+	}
+	sigs := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if sigs[i], err = e.stack.Pop(); err != nil {
+			return err
+		}
+	}
+
+	// One element more than needed is consumed, an off-by-one kept for
+	// consensus compatibility
+	if _, err := e.stack.Pop(); err != nil {
+		return fmt.Errorf("missing OP_CHECKMULTISIG dummy element")
+	}
+
+	key := 0
+	for _, sig := range sigs {
+		for key < len(pubKeys) && !e.checkSig(sig, pubKeys[key]) {
+			key++
+		}
+		if key == len(pubKeys) {
+			e.stack.Push([]byte{})
+			return nil
+		}
+		key++
+	}
+
 	e.stack.Push([]byte{1})
+	return nil
+}
+
+// opCheckLockTimeVerify fails unless the transaction's lock time has
+// reached the one on top of the stack (BIP65). The item stays on the
+// stack.
+func (e *Engine) opCheckLockTimeVerify() error {
+	tx, ok := e.tx.(*types.Transaction)
+	if !ok || tx == nil {
+		return fmt.Errorf("OP_CHECKLOCKTIMEVERIFY without a transaction")
+	}
+
+	item, err := e.stack.Peek()
+	if err != nil {
+		return err
+	}
+	if len(item) > 5 {
+		return fmt.Errorf("lock time operand too long")
+	}
+	lockTime := scriptNumToInt64(item)
+	if lockTime < 0 {
+		return fmt.Errorf("negative lock time")
+	}
+
+	// Heights and timestamps can't be compared with each other
+	if (lockTime < LockTimeThreshold) != (tx.LockTime < LockTimeThreshold) {
+		return fmt.Errorf("lock time type mismatch")
+	}
+	if lockTime > int64(tx.LockTime) {
+		return fmt.Errorf("lock time %d not reached by transaction lock time %d", lockTime, tx.LockTime)
+	}
+
+	// A final input would disable the transaction's lock time
+	if tx.Inputs[e.inputIdx].Sequence == 0xFFFFFFFF {
+		return fmt.Errorf("input sequence is final")
+	}
+
+	return nil
+}
 
+// opIf starts a branch, taken if the popped item is true (false for
+// OP_NOTIF). In a branch not taken nothing is popped.
+func (e *Engine) opIf(notIf bool) error {
+	cond := false
+	if e.executing() {
+		item, err := e.stack.Pop()
+		if err != nil {
+			return fmt.Errorf("OP_IF with empty stack")
+		}
+		// Tapscript only takes an exact true or false, so the witness
+		// can't be padded without changing the spend (MINIMALIF)
+		if e.tapscript && (len(item) > 1 || len(item) == 1 && item[0] != 1) {
+			return ErrTapscriptMinimalIf
+		}
+		cond = castToBool(item) != notIf
+	}
+	e.condStack = append(e.condStack, cond)
 	return nil
 }
 
+// executing reports whether every enclosing branch is taken
+func (e *Engine) executing() bool {
+	for _, cond := range e.condStack {
+		if !cond {
+			return false
+		}
+	}
+	return true
+}
+
+// popInt pops the top item as a script number
+func (e *Engine) popInt() (int, error) {
+	item, err := e.stack.Pop()
+	if err != nil {
+		return 0, err
+	}
+	if len(item) > 4 {
+		return 0, fmt.Errorf("script number too long")
+	}
+	return int(scriptNumToInt64(item)), nil
+}
+
+// boolItem is how script pushes a boolean: 1, or the empty item
+func boolItem(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
 // castToBool converts script item to boolean
 func castToBool(b []byte) bool {
 	for i := 0; i < len(b); i++ {
@@ -262,3 +576,8 @@ func (e *Engine) SetTransaction(tx *types.Transaction, inputIdx int) {
 	e.tx = tx
 	e.inputIdx = inputIdx
 }
+
+// SetSigChecker makes signature opcodes verify signatures with checker
+func (e *Engine) SetSigChecker(checker SigChecker) {
+	e.sigChecker = checker
+}
@@ -99,6 +99,10 @@ const (
 	OP_CHECKSIGVERIFY      = 0xad
 	OP_CHECKMULTISIG       = 0xae
 	OP_CHECKMULTISIGVERIFY = 0xaf
+	OP_CHECKSIGADD         = 0xba // Tapscript only (BIP342)
+
+	// Locktime
+	OP_CHECKLOCKTIMEVERIFY = 0xb1 // Formerly OP_NOP2
 
 	// Pseudo-words
 	OP_PUBKEYHASH = 0xfd
@@ -138,6 +142,19 @@ func OpcodeName(op byte) string {
 		OP_HASH160:        "OP_HASH160",
 		OP_CHECKSIG:       "OP_CHECKSIG",
 		OP_CHECKSIGVERIFY: "OP_CHECKSIGVERIFY",
+		OP_IF:             "OP_IF",
+		OP_NOTIF:          "OP_NOTIF",
+		OP_ELSE:           "OP_ELSE",
+		OP_ENDIF:          "OP_ENDIF",
+		OP_DROP:           "OP_DROP",
+		OP_SHA256:         "OP_SHA256",
+
+		OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+		OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+		OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+		OP_NUMEQUAL:            "OP_NUMEQUAL",
+		OP_NUMEQUALVERIFY:      "OP_NUMEQUALVERIFY",
+		OP_CHECKSIGADD:         "OP_CHECKSIGADD",
 	}
 
 	if name, ok := names[op]; ok {
@@ -0,0 +1,208 @@
+package script
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Standardness rules for spending scripts. Consensus accepts more than
+// this; relay policy refuses the rest so a third party can't change a
+// transaction's id by re-encoding its scriptSig (malleability) and pass
+// the altered copy around.
+var (
+	ErrSigPushOnly = errors.New("scriptSig is not push-only")
+	ErrMinimalData = errors.New("non-minimal data push")
+	ErrCleanStack  = errors.New("stack not clean after evaluation")
+)
+
+// parseOp decodes the opcode at pc and the data it pushes, if any, and
+// returns the position of the next opcode
+func parseOp(script []byte, pc int) (byte, []byte, int, error) {
+	opcode := script[pc]
+	pc++
+
+	var size int
+	switch {
+	case opcode > OP_0 && opcode < OP_PUSHDATA1:
+		size = int(opcode)
+	case opcode == OP_PUSHDATA1:
+		if pc+1 > len(script) {
+			return 0, nil, 0, fmt.Errorf("truncated OP_PUSHDATA1")
+		}
+		size = int(script[pc])
+		pc++
+	case opcode == OP_PUSHDATA2:
+		if pc+2 > len(script) {
+			return 0, nil, 0, fmt.Errorf("truncated OP_PUSHDATA2")
+		}
+		size = int(binary.LittleEndian.Uint16(script[pc:]))
+		pc += 2
+	case opcode == OP_PUSHDATA4:
+		if pc+4 > len(script) {
+			return 0, nil, 0, fmt.Errorf("truncated OP_PUSHDATA4")
+		}
+		size = int(binary.LittleEndian.Uint32(script[pc:]))
+		pc += 4
+	default:
+		return opcode, nil, pc, nil
+	}
+
+	if size < 0 || pc+size > len(script) {
+		return 0, nil, 0, fmt.Errorf("push %d bytes exceeds script length", size)
+	}
+	return opcode, script[pc : pc+size], pc + size, nil
+}
+
+// ParsedOp is an opcode and the data it pushes, if any
+type ParsedOp struct {
+	Opcode byte
+	Data   []byte
+}
+
+// ParseScript splits script into its opcodes. Data slices share the
+// script's memory.
+func ParseScript(script []byte) ([]ParsedOp, error) {
+	var ops []ParsedOp
+	for pc := 0; pc < len(script); {
+		opcode, data, next, err := parseOp(script, pc)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, ParsedOp{Opcode: opcode, Data: data})
+		pc = next
+	}
+	return ops, nil
+}
+
+// MultisigSigOps is what a bare CHECKMULTISIG counts for in CountSigOps,
+// since the key count isn't known without running the script
+const MultisigSigOps = 20
+
+// CountSigOps counts the signature checks in script the legacy way: one
+// per CHECKSIG and MultisigSigOps per CHECKMULTISIG. Counting stops at a
+// malformed push.
+func CountSigOps(script []byte) int {
+	count := 0
+	for pc := 0; pc < len(script); {
+		opcode, _, next, err := parseOp(script, pc)
+		if err != nil {
+			break
+		}
+		switch opcode {
+		case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+			count++
+		case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+			count += MultisigSigOps
+		}
+		pc = next
+	}
+	return count
+}
+
+// IsPushOnly reports whether script only pushes data: every opcode is a
+// data push or a small integer
+func IsPushOnly(script []byte) bool {
+	for pc := 0; pc < len(script); {
+		opcode, _, next, err := parseOp(script, pc)
+		if err != nil || opcode > OP_16 {
+			return false
+		}
+		pc = next
+	}
+	return true
+}
+
+// Pushes returns the data each opcode of a push-only script pushes, with
+// small integers as their one-byte values
+func Pushes(script []byte) ([][]byte, error) {
+	var pushes [][]byte
+	for pc := 0; pc < len(script); {
+		opcode, data, next, err := parseOp(script, pc)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case opcode <= OP_PUSHDATA4:
+			pushes = append(pushes, data)
+		case opcode == OP_1NEGATE:
+			pushes = append(pushes, []byte{0x81})
+		case opcode >= OP_1 && opcode <= OP_16:
+			pushes = append(pushes, []byte{opcode - OP_1 + 1})
+		default:
+			return nil, ErrSigPushOnly
+		}
+		pc = next
+	}
+	return pushes, nil
+}
+
+// IsMinimalPush reports whether data is pushed with the shortest opcode
+// that can push it
+func IsMinimalPush(opcode byte, data []byte) bool {
+	switch {
+	case len(data) == 0:
+		return opcode == OP_0
+	case len(data) == 1 && data[0] >= 1 && data[0] <= 16:
+		return opcode == OP_1+data[0]-1
+	case len(data) == 1 && data[0] == 0x81:
+		return opcode == OP_1NEGATE
+	case len(data) <= 75:
+		return int(opcode) == len(data)
+	case len(data) <= 0xff:
+		return opcode == OP_PUSHDATA1
+	case len(data) <= 0xffff:
+		return opcode == OP_PUSHDATA2
+	}
+	return true
+}
+
+// CheckMinimalPushes fails on the first push in script that isn't minimal
+func CheckMinimalPushes(script []byte) error {
+	for pc := 0; pc < len(script); {
+		opcode, data, next, err := parseOp(script, pc)
+		if err != nil {
+			return err
+		}
+		if opcode <= OP_PUSHDATA4 && !IsMinimalPush(opcode, data) {
+			return fmt.Errorf("%w: %d bytes with %s at %d", ErrMinimalData, len(data), OpcodeName(opcode), pc)
+		}
+		pc = next
+	}
+	return nil
+}
+
+// VerifyStandardInput runs an input's scriptSig against the output it
+// spends under relay policy: the scriptSig must be push-only, every push
+// minimal, and evaluation must leave exactly one true element behind.
+// A witness program is spent by its witness instead, which must be
+// within the standard witness limits; consensus checks run it.
+func VerifyStandardInput(sigScript, pubKeyScript []byte, tx *types.Transaction, inputIdx int) error {
+	if _, _, ok := ExtractWitnessProgram(pubKeyScript); ok {
+		if len(sigScript) != 0 {
+			return ErrWitnessMalleated
+		}
+		return CheckStandardWitness(pubKeyScript, tx.Inputs[inputIdx].Witness)
+	}
+	if !IsPushOnly(sigScript) {
+		return ErrSigPushOnly
+	}
+	if err := CheckMinimalPushes(sigScript); err != nil {
+		return err
+	}
+	if err := CheckMinimalPushes(pubKeyScript); err != nil {
+		return err
+	}
+
+	engine := NewInputEngine(sigScript, pubKeyScript)
+	engine.SetTransaction(tx, inputIdx)
+	if err := engine.Execute(); err != nil {
+		return err
+	}
+	if size := engine.Stack().Size(); size != 1 {
+		return fmt.Errorf("%w: %d elements left", ErrCleanStack, size)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package script
 
 import (
+	"crypto/sha256"
 	"fmt"
 )
 
@@ -22,6 +23,48 @@ func P2PKH(pubKeyHash []byte) ([]byte, error) {
 	return script, nil
 }
 
+// P2SH creates a Pay-to-Script-Hash locking script
+// Format: OP_HASH160 <scriptHash> OP_EQUAL
+func P2SH(scriptHash []byte) ([]byte, error) {
+	if len(scriptHash) != 20 {
+		return nil, fmt.Errorf("scriptHash must be 20 bytes, got %d", len(scriptHash))
+	}
+
+	script := []byte{OP_HASH160, byte(len(scriptHash))}
+	script = append(script, scriptHash...)
+	script = append(script, OP_EQUAL)
+
+	return script, nil
+}
+
+// WitnessProgram creates a segwit locking script: P2WPKH or P2WSH for
+// version 0, P2TR for version 1 with a 32-byte program
+// Format: <version> <program>
+func WitnessProgram(version byte, program []byte) ([]byte, error) {
+	if version > 16 {
+		return nil, fmt.Errorf("invalid witness version %d", version)
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return nil, fmt.Errorf("witness program must be 2 to 40 bytes, got %d", len(program))
+	}
+
+	versionOp := byte(OP_0)
+	if version > 0 {
+		versionOp = OP_1 + version - 1
+	}
+
+	script := []byte{versionOp, byte(len(program))}
+	return append(script, program...), nil
+}
+
+// P2WSH creates a Pay-to-Witness-Script-Hash locking script, committing
+// to the SHA-256 of witnessScript
+// Format: OP_0 <32-byte hash>
+func P2WSH(witnessScript []byte) ([]byte, error) {
+	hash := sha256.Sum256(witnessScript)
+	return WitnessProgram(0, hash[:])
+}
+
 // P2PKHUnlockingScript creates an unlocking script for P2PKH
 // Format: <signature> <pubKey>
 func P2PKHUnlockingScript(signature, pubKey []byte) []byte {
@@ -63,10 +106,7 @@ func ExtractP2PKHAddress(script []byte) ([]byte, error) {
 
 // ExecuteP2PKH executes a complete P2PKH transaction
 func ExecuteP2PKH(unlocking, locking []byte) error {
-	// Combine scripts: unlocking + locking
-	combined := append(unlocking, locking...)
-
-	engine := NewEngine(combined)
+	engine := NewInputEngine(unlocking, locking)
 	return engine.Execute()
 }
 
@@ -95,3 +135,47 @@ func DisassembleScript(script []byte) string {
 
 	return result
 }
+
+// MaxNullDataSize is the largest payload relay policy allows in an
+// OP_RETURN output
+const MaxNullDataSize = 80
+
+// MaxNullDataScriptSize is the largest standard OP_RETURN script: the
+// opcode and an OP_PUSHDATA1 push of MaxNullDataSize bytes
+const MaxNullDataScriptSize = MaxNullDataSize + 3
+
+// NullData creates an unspendable OP_RETURN script carrying data
+// Format: OP_RETURN <data>
+func NullData(data []byte) ([]byte, error) {
+	if len(data) > MaxNullDataSize {
+		return nil, fmt.Errorf("null data is %d bytes, more than %d", len(data), MaxNullDataSize)
+	}
+	return NewBuilder().AddOp(OP_RETURN).AddData(data).Script(), nil
+}
+
+// IsNullData checks if script is an OP_RETURN output script
+func IsNullData(script []byte) bool {
+	return len(script) > 0 && script[0] == OP_RETURN
+}
+
+// ExtractNullData returns the data pushed after OP_RETURN, the pushes
+// joined together. It fails if anything other than pushes follows.
+func ExtractNullData(script []byte) ([]byte, error) {
+	if !IsNullData(script) {
+		return nil, fmt.Errorf("not an OP_RETURN script")
+	}
+
+	var data []byte
+	for pc := 1; pc < len(script); {
+		opcode, push, next, err := parseOp(script, pc)
+		if err != nil {
+			return nil, err
+		}
+		if opcode > OP_PUSHDATA4 {
+			return nil, fmt.Errorf("%s in OP_RETURN data", OpcodeName(opcode))
+		}
+		data = append(data, push...)
+		pc = next
+	}
+	return data, nil
+}
@@ -0,0 +1,298 @@
+package script
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+)
+
+// Taproot script trees (BIP341)
+const (
+	TapscriptLeafVersion = 0xc0 // Leaves run as tapscript (BIP342)
+	TaprootLeafMask      = 0xfe // The low bit of a control block's first byte is the output key's parity
+	TaprootAnnexTag      = 0x50 // First byte of an annex, the optional last witness item
+
+	ControlBlockBaseSize = 33  // Leaf version and internal key
+	ControlBlockNodeSize = 32  // Each hash of the merkle path
+	ControlBlockMaxNodes = 128 // Deepest leaf a tree can have
+)
+
+// Tapscript signature budget (BIP342): every signature checked spends
+// SigOpsBudgetPerCheck of a budget that starts at SigOpsBudgetBase plus
+// the size of the witness
+const (
+	SigOpsBudgetBase     = 50
+	SigOpsBudgetPerCheck = 50
+)
+
+var (
+	ErrControlBlock          = errors.New("invalid control block")
+	ErrTapscriptMinimalIf    = errors.New("OP_IF argument must be empty or 1 in tapscript")
+	ErrTapscriptCheckMulti   = errors.New("OP_CHECKMULTISIG is disabled in tapscript")
+	ErrTapscriptSigOpsBudget = errors.New("tapscript signature budget exceeded")
+	ErrSchnorrSig            = errors.New("invalid schnorr signature")
+	ErrNoSigChecker          = errors.New("tapscript signature check without a signature checker")
+)
+
+// TapLeafHash is the hash a leaf script contributes to its tree
+func TapLeafHash(leafVersion byte, script []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(leafVersion)
+	serialization.WriteBytes(&buf, script)
+	hash := crypto.TaggedHash("TapLeaf", buf.Bytes())
+	return hash[:]
+}
+
+// TapBranchHash combines two subtree hashes. They are sorted first, so a
+// merkle path needn't say which side each hash is on.
+func TapBranchHash(a, b []byte) []byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	hash := crypto.TaggedHash("TapBranch", a, b)
+	return hash[:]
+}
+
+// TapLeaf is a script in a taproot tree
+type TapLeaf struct {
+	Version byte
+	Script  []byte
+}
+
+// NewTapLeaf returns a tapscript leaf
+func NewTapLeaf(script []byte) TapLeaf {
+	return TapLeaf{Version: TapscriptLeafVersion, Script: script}
+}
+
+// Hash returns the leaf's TapLeafHash
+func (l TapLeaf) Hash() []byte {
+	return TapLeafHash(l.Version, l.Script)
+}
+
+// TapTree is a node of a taproot script tree: a leaf, or a branch over two
+// subtrees. Leaves nearer the root have shorter merkle paths, so the likely
+// spending conditions go there.
+type TapTree struct {
+	Leaf        *TapLeaf
+	Left, Right *TapTree
+}
+
+// TapLeafNode returns a tree of one leaf
+func TapLeafNode(leaf TapLeaf) *TapTree {
+	return &TapTree{Leaf: &leaf}
+}
+
+// TapBranchNode returns a tree with left and right as its subtrees
+func TapBranchNode(left, right *TapTree) *TapTree {
+	return &TapTree{Left: left, Right: right}
+}
+
+// BalancedTapTree returns a tree with leaves at depths differing by at
+// most one, in order
+func BalancedTapTree(leaves ...TapLeaf) *TapTree {
+	switch len(leaves) {
+	case 0:
+		return nil
+	case 1:
+		return TapLeafNode(leaves[0])
+	}
+	mid := (len(leaves) + 1) / 2
+	return TapBranchNode(BalancedTapTree(leaves[:mid]...), BalancedTapTree(leaves[mid:]...))
+}
+
+// RootHash returns the merkle root an output key commits to
+func (t *TapTree) RootHash() []byte {
+	if t.Leaf != nil {
+		return t.Leaf.Hash()
+	}
+	return TapBranchHash(t.Left.RootHash(), t.Right.RootHash())
+}
+
+// Leaves returns the tree's leaves, left to right
+func (t *TapTree) Leaves() []TapLeaf {
+	if t.Leaf != nil {
+		return []TapLeaf{*t.Leaf}
+	}
+	return append(t.Left.Leaves(), t.Right.Leaves()...)
+}
+
+// MerklePath returns the hashes proving leaf is in the tree, from the
+// leaf's sibling up to the root's child
+func (t *TapTree) MerklePath(leaf TapLeaf) ([][]byte, bool) {
+	if t.Leaf != nil {
+		return nil, t.Leaf.Version == leaf.Version && bytes.Equal(t.Leaf.Script, leaf.Script)
+	}
+	if path, ok := t.Left.MerklePath(leaf); ok {
+		return append(path, t.Right.RootHash()), true
+	}
+	if path, ok := t.Right.MerklePath(leaf); ok {
+		return append(path, t.Left.RootHash()), true
+	}
+	return nil, false
+}
+
+// ControlBlock is the last witness item of a script path spend: the
+// leaf version, the output key's parity, the internal key and the merkle
+// path from the leaf being spent
+type ControlBlock struct {
+	LeafVersion     byte
+	OutputKeyYIsOdd bool
+	InternalKey     []byte // x-only
+	Path            [][]byte
+}
+
+// ParseControlBlock decodes a control block
+func ParseControlBlock(data []byte) (*ControlBlock, error) {
+	if len(data) < ControlBlockBaseSize || (len(data)-ControlBlockBaseSize)%ControlBlockNodeSize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrControlBlock, len(data))
+	}
+	nodes := (len(data) - ControlBlockBaseSize) / ControlBlockNodeSize
+	if nodes > ControlBlockMaxNodes {
+		return nil, fmt.Errorf("%w: %d path hashes", ErrControlBlock, nodes)
+	}
+
+	c := &ControlBlock{
+		LeafVersion:     data[0] & TaprootLeafMask,
+		OutputKeyYIsOdd: data[0]&1 == 1,
+		InternalKey:     data[1:ControlBlockBaseSize],
+	}
+	for i := 0; i < nodes; i++ {
+		start := ControlBlockBaseSize + i*ControlBlockNodeSize
+		c.Path = append(c.Path, data[start:start+ControlBlockNodeSize])
+	}
+	return c, nil
+}
+
+// Bytes encodes the control block
+func (c *ControlBlock) Bytes() []byte {
+	first := c.LeafVersion & TaprootLeafMask
+	if c.OutputKeyYIsOdd {
+		first |= 1
+	}
+	data := append([]byte{first}, c.InternalKey...)
+	for _, node := range c.Path {
+		data = append(data, node...)
+	}
+	return data
+}
+
+// RootHash returns the merkle root the control block proves leafScript
+// is under. Checking the output key commits to it needs the curve, which
+// the transaction package does.
+func (c *ControlBlock) RootHash(leafScript []byte) []byte {
+	hash := TapLeafHash(c.LeafVersion, leafScript)
+	for _, node := range c.Path {
+		hash = TapBranchHash(hash, node)
+	}
+	return hash
+}
+
+// IsOpSuccess reports whether opcode is one of BIP342's OP_SUCCESSx,
+// which make any tapscript containing them succeed. They are reserved
+// for soft forks to give new meanings.
+func IsOpSuccess(opcode byte) bool {
+	switch {
+	case opcode == 80, opcode == 98:
+		return true
+	case opcode >= 126 && opcode <= 129, opcode >= 131 && opcode <= 134,
+		opcode >= 137 && opcode <= 138, opcode >= 141 && opcode <= 142,
+		opcode >= 149 && opcode <= 153, opcode >= 187 && opcode <= 254:
+		return true
+	}
+	return false
+}
+
+// NewTapscriptEngine sets up an engine for a script path spend of a
+// tapscript leaf. stack is the witness below the script and control
+// block; witnessSize, the serialized size of the whole witness, sets the
+// signature budget. Tapscript has no script size or opcode limit, and
+// signature opcodes take 32-byte keys and BIP340 signatures.
+func NewTapscriptEngine(leafScript []byte, stack [][]byte, witnessSize int) (*Engine, error) {
+	if len(stack) > MaxStackSize {
+		return nil, fmt.Errorf("%w: %d witness items", ErrStackSize, len(stack))
+	}
+	engine := NewEngine(leafScript)
+	for _, item := range stack {
+		if len(item) > MaxElementSize {
+			return nil, fmt.Errorf("%w: %d byte witness item", ErrElementSize, len(item))
+		}
+		engine.stack.Push(append([]byte(nil), item...))
+	}
+	engine.witness = true
+	engine.tapscript = true
+	engine.sigOpsBudget = SigOpsBudgetBase + witnessSize
+
+	// An OP_SUCCESSx anywhere, even where it won't run, makes the script
+	// succeed; only a malformed push before it can make it fail
+	for pc := 0; pc < len(leafScript); {
+		opcode, _, next, err := parseOp(leafScript, pc)
+		if err != nil {
+			return nil, err
+		}
+		if IsOpSuccess(opcode) {
+			engine.opSuccess = true
+			break
+		}
+		pc = next
+	}
+	return engine, nil
+}
+
+// opCheckSigAdd pops a key, a number and a signature and pushes the
+// number plus one if the signature is valid, or unchanged if it is empty
+// (BIP342). Counting signatures this way replaces OP_CHECKMULTISIG.
+func (e *Engine) opCheckSigAdd() error {
+	pubKey, err := e.stack.Pop()
+	if err != nil {
+		return err
+	}
+	n, err := e.popInt()
+	if err != nil {
+		return err
+	}
+	sig, err := e.stack.Pop()
+	if err != nil {
+		return err
+	}
+
+	ok, err := e.checkTapscriptSig(sig, pubKey)
+	if err != nil {
+		return err
+	}
+	if ok {
+		n++
+	}
+	e.stack.PushInt(int64(n))
+	return nil
+}
+
+// checkTapscriptSig runs a tapscript signature check. An empty signature
+// is a plain false; any other must be valid or the script fails, and
+// spends from the signature budget. Keys other than 32 bytes are
+// reserved for upgrades and accept any signature. A 32-byte key needs
+// the engine's SigChecker: without one the check fails rather than
+// passing unverified.
+func (e *Engine) checkTapscriptSig(sig, pubKey []byte) (bool, error) {
+	if len(pubKey) == 0 {
+		return false, fmt.Errorf("empty public key in tapscript")
+	}
+	if len(sig) == 0 {
+		return false, nil
+	}
+	e.sigOpsBudget -= SigOpsBudgetPerCheck
+	if e.sigOpsBudget < 0 {
+		return false, ErrTapscriptSigOpsBudget
+	}
+	if len(pubKey) == 32 {
+		if e.sigChecker == nil {
+			return false, ErrNoSigChecker
+		}
+		if !e.sigChecker(sig, pubKey) {
+			return false, ErrSchnorrSig
+		}
+	}
+	return true, nil
+}
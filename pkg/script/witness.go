@@ -0,0 +1,109 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Version 0 witness program sizes (BIP141)
+const (
+	WitnessV0KeyHashSize    = 20
+	WitnessV0ScriptHashSize = 32
+)
+
+// Standardness limits on P2WSH spends, on top of the consensus limits
+const (
+	MaxStandardWitnessScriptSize = 3600
+	MaxStandardWitnessStackItems = 100 // Not counting the witness script
+	MaxStandardWitnessItemSize   = 80
+)
+
+var (
+	ErrWitnessProgramLength   = errors.New("wrong length for a version 0 witness program")
+	ErrWitnessProgramMismatch = errors.New("witness does not match the witness program")
+	ErrWitnessProgramEmpty    = errors.New("witness program spent with an empty witness")
+	ErrWitnessMalleated       = errors.New("native witness program spent with a scriptSig")
+	ErrNonStandardWitness     = errors.New("non-standard witness")
+)
+
+// NewWitnessEngine sets up an engine for spending a version 0 witness
+// program. For P2WSH the last witness item is the script to run and must
+// hash to the program; for P2WPKH the program is a key hash spent like
+// P2PKH. The remaining items are the starting stack, and the spend must
+// leave exactly one true item on it.
+func NewWitnessEngine(program []byte, witness [][]byte) (*Engine, error) {
+	var witnessScript []byte
+	var stack [][]byte
+	switch len(program) {
+	case WitnessV0ScriptHashSize:
+		if len(witness) == 0 {
+			return nil, ErrWitnessProgramEmpty
+		}
+		witnessScript = witness[len(witness)-1]
+		hash := sha256.Sum256(witnessScript)
+		if !bytes.Equal(hash[:], program) {
+			return nil, ErrWitnessProgramMismatch
+		}
+		stack = witness[:len(witness)-1]
+
+	case WitnessV0KeyHashSize:
+		if len(witness) != 2 {
+			return nil, fmt.Errorf("%w: P2WPKH needs 2 items, got %d", ErrWitnessProgramMismatch, len(witness))
+		}
+		var err error
+		if witnessScript, err = P2PKH(program); err != nil {
+			return nil, err
+		}
+		stack = witness
+
+	default:
+		return nil, fmt.Errorf("%w: %d bytes", ErrWitnessProgramLength, len(program))
+	}
+
+	if len(stack) > MaxStackSize {
+		return nil, fmt.Errorf("%w: %d witness items", ErrStackSize, len(stack))
+	}
+	engine := NewEngine(witnessScript)
+	for _, item := range stack {
+		if len(item) > MaxElementSize {
+			return nil, fmt.Errorf("%w: %d byte witness item", ErrElementSize, len(item))
+		}
+		engine.stack.Push(append([]byte(nil), item...))
+	}
+	engine.witness = true
+	return engine, nil
+}
+
+// Script returns the script the engine runs. For a witness spend that is
+// the witness script, or the P2PKH script of a P2WPKH key hash: what its
+// signatures commit to.
+func (e *Engine) Script() []byte {
+	return e.script
+}
+
+// CheckStandardWitness applies relay policy to the witness spending
+// pkScript: a P2WSH witness script and its stack items are held well
+// under the consensus limits. Other outputs have no witness policy.
+func CheckStandardWitness(pkScript []byte, witness [][]byte) error {
+	version, program, ok := ExtractWitnessProgram(pkScript)
+	if !ok || version != 0 || len(program) != WitnessV0ScriptHashSize || len(witness) == 0 {
+		return nil
+	}
+
+	witnessScript := witness[len(witness)-1]
+	if len(witnessScript) > MaxStandardWitnessScriptSize {
+		return fmt.Errorf("%w: %d byte witness script", ErrNonStandardWitness, len(witnessScript))
+	}
+	items := witness[:len(witness)-1]
+	if len(items) > MaxStandardWitnessStackItems {
+		return fmt.Errorf("%w: %d witness items", ErrNonStandardWitness, len(items))
+	}
+	for i, item := range items {
+		if len(item) > MaxStandardWitnessItemSize {
+			return fmt.Errorf("%w: witness item %d is %d bytes", ErrNonStandardWitness, i, len(item))
+		}
+	}
+	return nil
+}
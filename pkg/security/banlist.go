@@ -0,0 +1,116 @@
+package security
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/fileutil"
+)
+
+// BanListFileName is the file name used to persist bans in the data directory
+const BanListFileName = "banlist.json"
+
+// banListVersion is bumped when the file layout changes
+const banListVersion = 1
+
+// BanEntry is a banned IP and the ban's lifetime
+type BanEntry struct {
+	IP          string    `json:"address"`
+	BanCreated  time.Time `json:"ban_created"`
+	BannedUntil time.Time `json:"banned_until"`
+}
+
+// banListFile is the on-disk layout
+type banListFile struct {
+	Version int        `json:"version"`
+	Bans    []BanEntry `json:"bans"`
+}
+
+// ListBans returns the active bans sorted by IP, dropping expired ones
+func (dp *DoSProtection) ListBans() []BanEntry {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	return dp.activeBansLocked(time.Now())
+}
+
+// ClearBans lifts every ban
+func (dp *DoSProtection) ClearBans() {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	dp.bannedIPs = make(map[string]BanEntry)
+}
+
+// SaveBans writes the active bans to path
+func (dp *DoSProtection) SaveBans(path string) error {
+	dp.saveMu.Lock()
+	defer dp.saveMu.Unlock()
+
+	data, err := json.MarshalIndent(banListFile{
+		Version: banListVersion,
+		Bans:    dp.ListBans(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode ban list: %w", err)
+	}
+
+	if err := fileutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write ban list: %w", err)
+	}
+
+	return nil
+}
+
+// LoadBans adds the bans stored at path, skipping expired ones. A missing
+// file is not an error.
+func (dp *DoSProtection) LoadBans(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read ban list: %w", err)
+	}
+
+	var file banListFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to decode ban list: %w", err)
+	}
+	if file.Version != banListVersion {
+		return fmt.Errorf("unsupported ban list version %d", file.Version)
+	}
+
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	now := time.Now()
+	for _, ban := range file.Bans {
+		if ban.IP == "" || !now.Before(ban.BannedUntil) {
+			continue
+		}
+		dp.bannedIPs[ban.IP] = ban
+	}
+
+	return nil
+}
+
+// activeBansLocked prunes expired bans and returns the rest (lock held)
+func (dp *DoSProtection) activeBansLocked(now time.Time) []BanEntry {
+	bans := make([]BanEntry, 0, len(dp.bannedIPs))
+	for ip, ban := range dp.bannedIPs {
+		if !now.Before(ban.BannedUntil) {
+			delete(dp.bannedIPs, ip)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].IP < bans[j].IP
+	})
+	return bans
+}
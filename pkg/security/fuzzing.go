@@ -3,9 +3,17 @@ package security
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"net"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
 )
 
+// MaxAmount is the largest amount in satoshis a request may carry
+// (21 million BTC, same as the consensus money limit)
+const MaxAmount = 21000000 * 100000000
+
 // FuzzTester provides fuzzing capabilities for testing
 type FuzzTester struct {
 	minSize int
@@ -160,10 +168,12 @@ func (iv *InputValidator) SanitizeString(s string) string {
 
 // ValidateIPAddress validates IP address format
 func (iv *InputValidator) ValidateIPAddress(ip string) error {
-	// Basic validation - in production use net.ParseIP
-	if len(ip) < 7 || len(ip) > 45 {
+	if len(ip) < 2 || len(ip) > 45 {
 		return fmt.Errorf("invalid IP address length")
 	}
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid IP address: %q", ip)
+	}
 	return nil
 }
 
@@ -174,3 +184,59 @@ func (iv *InputValidator) ValidatePort(port int) error {
 	}
 	return nil
 }
+
+// ValidateAmount checks that a satoshi amount is positive and no larger
+// than MaxAmount
+func (iv *InputValidator) ValidateAmount(amount int64) error {
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive, got %d", amount)
+	}
+	if amount > MaxAmount {
+		return fmt.Errorf("amount %d exceeds maximum %d", amount, int64(MaxAmount))
+	}
+	return nil
+}
+
+// ValidateAddress checks a Base58Check address: checksum, a known version
+// byte and a 20-byte hash
+func (iv *InputValidator) ValidateAddress(address string) error {
+	if len(address) < 26 || len(address) > 35 {
+		return fmt.Errorf("invalid address length %d", len(address))
+	}
+
+	addr, err := keys.DecodeAddress(address)
+	if err != nil {
+		return err
+	}
+	if !addr.IsP2PKH() && !addr.IsP2SH() {
+		return fmt.Errorf("unknown address version 0x%02x", addr.Version())
+	}
+	return nil
+}
+
+// ValidateHex checks that s is hex encoded and decodes to at most maxBytes
+func (iv *InputValidator) ValidateHex(s string, maxBytes int) error {
+	if len(s) > 2*maxBytes {
+		return fmt.Errorf("hex string too long: %d bytes, maximum %d", len(s)/2, maxBytes)
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return fmt.Errorf("invalid hex: %w", err)
+	}
+	return nil
+}
+
+// ValidateHash checks a 32-byte hash in hex
+func (iv *InputValidator) ValidateHash(s string) error {
+	if len(s) != 64 {
+		return fmt.Errorf("hash must be 64 hex characters, got %d", len(s))
+	}
+	return iv.ValidateHex(s, 32)
+}
+
+// ValidateHeight checks that a block height is not beyond maxHeight
+func (iv *InputValidator) ValidateHeight(height, maxHeight uint64) error {
+	if height > maxHeight {
+		return fmt.Errorf("height %d is beyond the chain tip %d", height, maxHeight)
+	}
+	return nil
+}
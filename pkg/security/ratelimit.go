@@ -176,10 +176,11 @@ type DoSProtection struct {
 	mu                sync.RWMutex
 	connectionLimiter *ConnectionRateLimiter
 	bandwidthLimiter  *BandwidthLimiter
-	bannedIPs         map[string]time.Time
+	bannedIPs         map[string]BanEntry
 	banDuration       time.Duration
 	maxBanScore       int
 	banScores         map[string]int
+	saveMu            sync.Mutex // Serializes SaveBans
 }
 
 // NewDoSProtection creates DoS protection
@@ -187,7 +188,7 @@ func NewDoSProtection() *DoSProtection {
 	return &DoSProtection{
 		connectionLimiter: NewConnectionRateLimiter(100, 10, 20),
 		bandwidthLimiter:  NewBandwidthLimiter(1024*1024, 10*1024*1024), // 1MB/s, 10MB burst
-		bannedIPs:         make(map[string]time.Time),
+		bannedIPs:         make(map[string]BanEntry),
 		banDuration:       24 * time.Hour,
 		maxBanScore:       100,
 		banScores:         make(map[string]int),
@@ -199,7 +200,7 @@ func (dp *DoSProtection) AllowConnection(addr net.Addr) error {
 	ip := extractIP(addr)
 
 	// Check if IP is banned
-	if dp.isBanned(ip) {
+	if dp.IsBanned(ip) {
 		return fmt.Errorf("IP is banned: %s", ip)
 	}
 
@@ -221,18 +222,18 @@ func (dp *DoSProtection) AllowBytes(peer string, bytes int) error {
 	return nil
 }
 
-// isBanned checks if an IP is banned
-func (dp *DoSProtection) isBanned(ip string) bool {
-	dp.mu.RLock()
-	defer dp.mu.RUnlock()
+// IsBanned checks if an IP is banned
+func (dp *DoSProtection) IsBanned(ip string) bool {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
 
-	banTime, exists := dp.bannedIPs[ip]
+	ban, exists := dp.bannedIPs[ip]
 	if !exists {
 		return false
 	}
 
 	// Check if ban has expired
-	if time.Since(banTime) > dp.banDuration {
+	if time.Now().After(ban.BannedUntil) {
 		delete(dp.bannedIPs, ip)
 		return false
 	}
@@ -249,16 +250,24 @@ func (dp *DoSProtection) increaseBanScore(ip string, points int) {
 
 	// Ban if score exceeds threshold
 	if dp.banScores[ip] >= dp.maxBanScore {
-		dp.bannedIPs[ip] = time.Now()
+		now := time.Now()
+		dp.bannedIPs[ip] = BanEntry{IP: ip, BanCreated: now, BannedUntil: now.Add(dp.banDuration)}
 		delete(dp.banScores, ip)
 	}
 }
 
-// BanIP manually bans an IP
+// BanIP manually bans an IP for the default ban duration
 func (dp *DoSProtection) BanIP(ip string) {
+	dp.BanIPFor(ip, dp.banDuration)
+}
+
+// BanIPFor bans an IP for the given duration
+func (dp *DoSProtection) BanIPFor(ip string, duration time.Duration) {
 	dp.mu.Lock()
 	defer dp.mu.Unlock()
-	dp.bannedIPs[ip] = time.Now()
+
+	now := time.Now()
+	dp.bannedIPs[ip] = BanEntry{IP: ip, BanCreated: now, BannedUntil: now.Add(duration)}
 }
 
 // UnbanIP unbans an IP
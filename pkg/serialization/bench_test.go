@@ -0,0 +1,66 @@
+package serialization_test
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Run with e.g. `go test ./pkg/serialization -run=^$ -bench=. -benchmem`
+
+func benchBlock(b *testing.B) *types.Block {
+	b.Helper()
+	block, _, err := testharness.SyntheticBlock(testharness.BenchBlockConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return block
+}
+
+func BenchmarkSerializeBlock(b *testing.B) {
+	block := benchBlock(b)
+	data, err := serialization.SerializeBlock(block)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := serialization.SerializeBlock(block); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDeserializeBlock(b *testing.B) {
+	block := benchBlock(b)
+	data, err := serialization.SerializeBlock(block)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := serialization.DeserializeBlock(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHashTransaction(b *testing.B) {
+	tx := &benchBlock(b).Transactions[1]
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := serialization.HashTransaction(tx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
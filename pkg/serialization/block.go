@@ -8,6 +8,9 @@ import (
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
 
+// BlockHeaderSize is the size of a serialized block header
+const BlockHeaderSize = 80
+
 // SerializeBlockHeader converts header to 80 bytes
 func SerializeBlockHeader(bh *types.BlockHeader) ([]byte, error) {
 	var buf bytes.Buffer
@@ -85,8 +88,19 @@ func HashBlockHeader(bh *types.BlockHeader) (types.Hash, error) {
 	return crypto.HashBlockHeader(serialized), nil
 }
 
-// SerializeBlock serializes a complete block
+// SerializeBlock serializes a complete block, including witness data
 func SerializeBlock(block *types.Block) ([]byte, error) {
+	return serializeBlock(block, SerializeTransactionWitness)
+}
+
+// SerializeBlockNoWitness serializes a block with its transactions
+// stripped of witness data, as pre-SegWit nodes see it
+func SerializeBlockNoWitness(block *types.Block) ([]byte, error) {
+	return serializeBlock(block, SerializeTransaction)
+}
+
+// serializeBlock writes the header and each transaction with serializeTx
+func serializeBlock(block *types.Block, serializeTx func(*types.Transaction) ([]byte, error)) ([]byte, error) {
 	var buf bytes.Buffer
 
 	// Serialize header
@@ -103,7 +117,7 @@ func SerializeBlock(block *types.Block) ([]byte, error) {
 
 	// Serialize each transaction
 	for _, tx := range block.Transactions {
-		txBytes, err := SerializeTransaction(&tx)
+		txBytes, err := serializeTx(&tx)
 		if err != nil {
 			return nil, err
 		}
@@ -122,18 +136,18 @@ func DeserializeBlock(data []byte) (*types.Block, error) {
 		return nil, err
 	}
 
-	txCount, err := ReadVarInt(r)
+	txCount, err := ReadCount(r)
 	if err != nil {
 		return nil, err
 	}
 
-	txs := make([]types.Transaction, txCount)
+	txs := make([]types.Transaction, 0, PreallocCount(txCount))
 	for i := uint64(0); i < txCount; i++ {
 		tx, err := DeserializeTransaction(r)
 		if err != nil {
 			return nil, err
 		}
-		txs[i] = *tx
+		txs = append(txs, *tx)
 	}
 
 	return &types.Block{
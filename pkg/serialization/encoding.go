@@ -2,10 +2,26 @@
 package serialization
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 )
 
+const (
+	// MaxSize is the largest count or length prefix accepted when decoding
+	// (Bitcoin's MAX_SIZE). Prefixes come from the network, so without a
+	// limit a 9-byte varint could ask for an 18 exabyte allocation.
+	MaxSize = 0x02000000
+
+	// maxPreallocCount caps how many slice elements are allocated up front
+	// from an untrusted count; the slice grows as elements are really read
+	maxPreallocCount = 1024
+
+	// maxPreallocBytes is the byte equivalent for length-prefixed data
+	maxPreallocBytes = 64 * 1024
+)
+
 // WriteUint32 writes uint32 in little-endian
 func WriteUint32(w io.Writer, v uint32) error {
 	return binary.Write(w, binary.LittleEndian, v)
@@ -49,6 +65,20 @@ func WriteVarInt(w io.Writer, v uint64) error {
 	}
 }
 
+// VarIntSize returns how many bytes WriteVarInt uses for v
+func VarIntSize(v uint64) int {
+	switch {
+	case v < 0xFD:
+		return 1
+	case v <= 0xFFFF:
+		return 3
+	case v <= 0xFFFFFFFF:
+		return 5
+	default:
+		return 9
+	}
+}
+
 // WriteBytes writes byte slice with length prefix
 func WriteBytes(w io.Writer, data []byte) error {
 	if err := WriteVarInt(w, uint64(len(data))); err != nil {
@@ -110,13 +140,45 @@ func ReadVarInt(r io.Reader) (uint64, error) {
 	}
 }
 
+// ReadCount reads a VarInt element count and rejects values above MaxSize
+func ReadCount(r io.Reader) (uint64, error) {
+	count, err := ReadVarInt(r)
+	if err != nil {
+		return 0, err
+	}
+	if count > MaxSize {
+		return 0, fmt.Errorf("count %d exceeds maximum %d", count, MaxSize)
+	}
+	return count, nil
+}
+
+// PreallocCount returns a safe initial capacity for a slice of count elements
+func PreallocCount(count uint64) int {
+	if count > maxPreallocCount {
+		return maxPreallocCount
+	}
+	return int(count)
+}
+
 // ReadBytes reads byte slice with length prefix
 func ReadBytes(r io.Reader) ([]byte, error) {
-	length, err := ReadVarInt(r)
+	length, err := ReadCount(r)
 	if err != nil {
 		return nil, err
 	}
 
+	// Large claims are only backed by memory as the bytes actually arrive
+	if length > maxPreallocBytes {
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
 	data := make([]byte, length)
 	if _, err := io.ReadFull(r, data); err != nil {
 		return nil, err
@@ -2,14 +2,36 @@ package serialization
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
-	"io"
 )
 
-// SerializeTransaction converts transaction to bytes
+// Segregated witness serialization (BIP144) marks a transaction with a
+// zero byte where the input count would be, followed by a flag
+const (
+	witnessMarker = 0x00
+	witnessFlag   = 0x01
+)
+
+// SerializeTransaction converts transaction to bytes without witness data.
+// This is the form the txid is computed from.
 // Order matters! Must match Bitcoin exactly
 func SerializeTransaction(tx *types.Transaction) ([]byte, error) {
+	return serializeTransaction(tx, false)
+}
+
+// SerializeTransactionWitness converts transaction to bytes including
+// witness data (BIP144). Transactions without witnesses serialize exactly
+// as in SerializeTransaction.
+func SerializeTransactionWitness(tx *types.Transaction) ([]byte, error) {
+	return serializeTransaction(tx, tx.HasWitness())
+}
+
+// serializeTransaction writes the legacy or the witness serialization
+func serializeTransaction(tx *types.Transaction, withWitness bool) ([]byte, error) {
 	var buf bytes.Buffer
 
 	// 1. Version (4 bytes, little-endian)
@@ -17,6 +39,11 @@ func SerializeTransaction(tx *types.Transaction) ([]byte, error) {
 		return nil, err
 	}
 
+	// Witness marker and flag
+	if withWitness {
+		buf.Write([]byte{witnessMarker, witnessFlag})
+	}
+
 	// 2. Input count (VarInt)
 	if err := WriteVarInt(&buf, uint64(len(tx.Inputs))); err != nil {
 		return nil, err
@@ -61,6 +88,20 @@ func SerializeTransaction(tx *types.Transaction) ([]byte, error) {
 		}
 	}
 
+	// Witness stack of each input
+	if withWitness {
+		for _, input := range tx.Inputs {
+			if err := WriteVarInt(&buf, uint64(len(input.Witness))); err != nil {
+				return nil, err
+			}
+			for _, item := range input.Witness {
+				if err := WriteBytes(&buf, item); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
 	// 6. Locktime (4 bytes)
 	if err := WriteUint32(&buf, tx.LockTime); err != nil {
 		return nil, err
@@ -69,7 +110,8 @@ func SerializeTransaction(tx *types.Transaction) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// DeserializeTransaction reads transaction from bytes
+// DeserializeTransaction reads transaction from bytes, in either the legacy
+// or the witness serialization
 func DeserializeTransaction(r io.Reader) (*types.Transaction, error) {
 	var tx types.Transaction
 	var err error
@@ -78,45 +120,87 @@ func DeserializeTransaction(r io.Reader) (*types.Transaction, error) {
 		return nil, err
 	}
 
-	// Inputs
-	inputCount, err := ReadVarInt(r)
+	// Inputs. A zero count is the witness marker.
+	inputCount, err := ReadCount(r)
 	if err != nil {
 		return nil, err
 	}
 
-	tx.Inputs = make([]types.TxInput, inputCount)
+	withWitness := false
+	if inputCount == witnessMarker {
+		var flag [1]byte
+		if _, err = io.ReadFull(r, flag[:]); err != nil {
+			return nil, err
+		}
+		if flag[0] != witnessFlag {
+			return nil, fmt.Errorf("unknown witness flag %#x", flag[0])
+		}
+		withWitness = true
+
+		if inputCount, err = ReadCount(r); err != nil {
+			return nil, err
+		}
+	}
+
+	tx.Inputs = make([]types.TxInput, 0, PreallocCount(inputCount))
 	for i := uint64(0); i < inputCount; i++ {
-		if _, err = io.ReadFull(r, tx.Inputs[i].PrevTxHash[:]); err != nil {
+		var input types.TxInput
+		if _, err = io.ReadFull(r, input.PrevTxHash[:]); err != nil {
 			return nil, err
 		}
-		if tx.Inputs[i].OutputIndex, err = ReadUint32(r); err != nil {
+		if input.OutputIndex, err = ReadUint32(r); err != nil {
 			return nil, err
 		}
-		if tx.Inputs[i].SignatureScript, err = ReadBytes(r); err != nil {
+		if input.SignatureScript, err = ReadBytes(r); err != nil {
 			return nil, err
 		}
-		if tx.Inputs[i].Sequence, err = ReadUint32(r); err != nil {
+		if input.Sequence, err = ReadUint32(r); err != nil {
 			return nil, err
 		}
+		tx.Inputs = append(tx.Inputs, input)
 	}
 
 	// Outputs
-	outputCount, err := ReadVarInt(r)
+	outputCount, err := ReadCount(r)
 	if err != nil {
 		return nil, err
 	}
 
-	tx.Outputs = make([]types.TxOutput, outputCount)
+	tx.Outputs = make([]types.TxOutput, 0, PreallocCount(outputCount))
 	for i := uint64(0); i < outputCount; i++ {
 		val, err := ReadUint64(r)
 		if err != nil {
 			return nil, err
 		}
-		tx.Outputs[i].Value = int64(val)
+		output := types.TxOutput{Value: int64(val)}
 
-		if tx.Outputs[i].PubKeyScript, err = ReadBytes(r); err != nil {
+		if output.PubKeyScript, err = ReadBytes(r); err != nil {
 			return nil, err
 		}
+		tx.Outputs = append(tx.Outputs, output)
+	}
+
+	if withWitness {
+		for i := range tx.Inputs {
+			itemCount, err := ReadCount(r)
+			if err != nil {
+				return nil, err
+			}
+			witness := make([][]byte, 0, PreallocCount(itemCount))
+			for j := uint64(0); j < itemCount; j++ {
+				item, err := ReadBytes(r)
+				if err != nil {
+					return nil, err
+				}
+				witness = append(witness, item)
+			}
+			tx.Inputs[i].Witness = witness
+		}
+
+		// BIP144 forbids the witness serialization without witness data
+		if !tx.HasWitness() {
+			return nil, fmt.Errorf("superfluous witness record")
+		}
 	}
 
 	if tx.LockTime, err = ReadUint32(r); err != nil {
@@ -135,11 +219,22 @@ func HashTransaction(tx *types.Transaction) (types.Hash, error) {
 	return crypto.HashTransaction(serialized), nil
 }
 
+// HashTransactionWitness computes the witness transaction ID (wtxid). It
+// equals the txid for transactions without witness data.
+func HashTransactionWitness(tx *types.Transaction) (types.Hash, error) {
+	serialized, err := SerializeTransactionWitness(tx)
+	if err != nil {
+		return types.Hash{}, err
+	}
+	return crypto.HashTransaction(serialized), nil
+}
+
 /*
 ```
 **Transaction format (bytes):**
 ```
 [Version: 4 bytes]
+[Marker 0x00, Flag 0x01: witness serialization only]
 [Input count: VarInt]
   [Input 0]
     [Prev TX hash: 32 bytes]
@@ -154,5 +249,8 @@ func HashTransaction(tx *types.Transaction) (types.Hash, error) {
     [Script length: VarInt]
     [Script: variable]
   [Output 1...]
+[Witness stack per input: witness serialization only]
+  [Item count: VarInt]
+  [Item length: VarInt][Item: variable] ...
 [Locktime: 4 bytes]
 */
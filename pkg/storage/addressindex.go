@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// AddressTx is a best-chain transaction paying or spending from a locking
+// script, with what it moved for that script
+type AddressTx struct {
+	TxHash   types.Hash
+	Height   uint64
+	Received int64 // Paid to the script
+	Sent     int64 // Spent from earlier outputs paying the script
+}
+
+// EnableAddressIndex makes blocks connected from now on have their
+// transactions indexed by the locking scripts they pay and spend from.
+// Blocks already stored are not indexed.
+func (bs *BlockchainStorage) EnableAddressIndex() {
+	bs.addressIndex = true
+}
+
+// AddressIndexEnabled reports whether transactions are indexed by script
+func (bs *BlockchainStorage) AddressIndexEnabled() bool {
+	return bs.addressIndex
+}
+
+// addressEntries returns the address index entries of a block at height,
+// keyed by database key. Spent outputs are looked up in pending, the
+// transactions connected earlier in the same batch, and then in the
+// transaction index; spends of outputs found in neither aren't indexed.
+func (bs *BlockchainStorage) addressEntries(block *types.Block, height uint64, pending map[types.Hash]*types.Transaction) (map[string][]byte, error) {
+	entries := make(map[string][]byte)
+
+	for txIndex := range block.Transactions {
+		tx := &block.Transactions[txIndex]
+		txHash, err := serialization.HashTransaction(tx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash transaction: %w", err)
+		}
+
+		received := make(map[[32]byte]int64)
+		sent := make(map[[32]byte]int64)
+		if txIndex > 0 {
+			for _, input := range tx.Inputs {
+				output, err := bs.prevOutput(input.PrevTxHash, input.OutputIndex, pending)
+				if err != nil {
+					return nil, err
+				}
+				if output != nil {
+					sent[sha256.Sum256(output.PubKeyScript)] += output.Value
+				}
+			}
+		}
+		for _, output := range tx.Outputs {
+			received[sha256.Sum256(output.PubKeyScript)] += output.Value
+		}
+		pending[txHash] = tx
+
+		for scriptHash := range received {
+			entries[string(AddressKey(scriptHash, height, uint32(txIndex)))] = addressValue(txHash, received[scriptHash], sent[scriptHash])
+		}
+		for scriptHash := range sent {
+			if _, ok := received[scriptHash]; !ok {
+				entries[string(AddressKey(scriptHash, height, uint32(txIndex)))] = addressValue(txHash, 0, sent[scriptHash])
+			}
+		}
+	}
+	return entries, nil
+}
+
+// prevOutput finds the output an input spends, or nil if it isn't known
+func (bs *BlockchainStorage) prevOutput(txHash types.Hash, index uint32, pending map[types.Hash]*types.Transaction) (*types.TxOutput, error) {
+	tx, ok := pending[txHash]
+	if !ok {
+		value, err := bs.db.Get(TxKey(txHash))
+		if err != nil || value == nil {
+			return nil, err
+		}
+		blockHash, txIndex, err := deserializeTxLocation(value)
+		if err != nil {
+			return nil, nil
+		}
+		block, err := bs.GetBlock(blockHash)
+		if err != nil || int(txIndex) >= len(block.Transactions) {
+			return nil, nil
+		}
+		tx = &block.Transactions[txIndex]
+	}
+	if int(index) >= len(tx.Outputs) {
+		return nil, nil
+	}
+	return &tx.Outputs[index], nil
+}
+
+// addressValue encodes an address index entry
+func addressValue(txHash types.Hash, received, sent int64) []byte {
+	value := make([]byte, 32+8+8)
+	copy(value, txHash[:])
+	binary.BigEndian.PutUint64(value[32:], uint64(received))
+	binary.BigEndian.PutUint64(value[40:], uint64(sent))
+	return value
+}
+
+// putAddresses adds the address index entries of a block at height to
+// batch
+func (bs *BlockchainStorage) putAddresses(batch *Batch, block *types.Block, height uint64, pending map[types.Hash]*types.Transaction) error {
+	entries, err := bs.addressEntries(block, height, pending)
+	if err != nil {
+		return fmt.Errorf("failed to index addresses of block %d: %w", height, err)
+	}
+	for key, value := range entries {
+		batch.Put([]byte(key), value)
+	}
+	return nil
+}
+
+// deleteAddresses adds removing the address index entries of the
+// best-chain blocks at heights from..to to batch, for blocks leaving the
+// best chain
+func (bs *BlockchainStorage) deleteAddresses(batch *Batch, from, to uint64) error {
+	pending := make(map[types.Hash]*types.Transaction)
+	for h := from; h <= to; h++ {
+		block, err := bs.GetBlockByHeight(h)
+		if err != nil {
+			return fmt.Errorf("failed to unindex block %d: %w", h, err)
+		}
+		entries, err := bs.addressEntries(block, h, pending)
+		if err != nil {
+			return fmt.Errorf("failed to unindex block %d: %w", h, err)
+		}
+		for key := range entries {
+			batch.Delete([]byte(key))
+		}
+	}
+	return nil
+}
+
+// GetAddressHistory returns the indexed transactions paying or spending
+// from pkScript, in chain order
+func (bs *BlockchainStorage) GetAddressHistory(pkScript []byte) ([]AddressTx, error) {
+	var history []AddressTx
+
+	it := bs.db.NewIterator(AddressScriptPrefix(sha256.Sum256(pkScript)))
+	defer it.Release()
+	for it.Next() {
+		key, value := it.Key(), it.Value()
+		if len(key) != 1+32+8+4 || len(value) != 32+8+8 {
+			return nil, fmt.Errorf("%w: bad address index entry", ErrCorrupt)
+		}
+		entry := AddressTx{
+			Height:   binary.BigEndian.Uint64(key[33:41]),
+			Received: int64(binary.BigEndian.Uint64(value[32:])),
+			Sent:     int64(binary.BigEndian.Uint64(value[40:])),
+		}
+		copy(entry.TxHash[:], value[:32])
+		history = append(history, entry)
+	}
+
+	return history, it.Error()
+}
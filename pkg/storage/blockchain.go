@@ -3,62 +3,392 @@ package storage
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math/big"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
 
 // BlockchainStorage handles block storage and retrieval
 type BlockchainStorage struct {
-	db         *Database
-	chainState *ChainState
+	db            *Database
+	chainState    *ChainState
+	nullDataIndex bool // Index OP_RETURN payloads of connected blocks
+	spentIndex    bool // Index outputs spent by connected blocks
+	addressIndex  bool // Index transactions of connected blocks by script
+	lock          *DirLock
 }
 
-// NewBlockchainStorage creates blockchain storage manager
+// NewBlockchainStorage creates blockchain storage manager. It locks the
+// directory so that a second node can't open the same database and
+// corrupt it.
 func NewBlockchainStorage(dbPath string) (*BlockchainStorage, error) {
+	lock, err := LockDataDir(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := OpenDatabase(dbPath)
 	if err != nil {
+		lock.Release()
 		return nil, err
 	}
 
-	return &BlockchainStorage{
+	bs := &BlockchainStorage{
 		db:         db,
 		chainState: NewChainState(db),
-	}, nil
+		lock:       lock,
+	}
+
+	// Recovery may have dropped index entries along with whatever else
+	// was lost, so every index is rebuilt. A reindex scheduled by the
+	// last run is carried out too.
+	if db.Recovered() {
+		bs.scheduleReindex(0)
+	}
+	if err := bs.RepairIndexes(); err != nil {
+		bs.Close()
+		return nil, err
+	}
+	return bs, nil
 }
 
-// Close closes the database
+// Close closes the database and releases the directory lock
 func (bs *BlockchainStorage) Close() error {
-	return bs.db.Close()
+	err := bs.db.Close()
+	if releaseErr := bs.lock.Release(); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+// Ping checks that the underlying database is still usable
+func (bs *BlockchainStorage) Ping() error {
+	return bs.db.Ping()
 }
 
 // SaveBlock stores a block with all indexes
 func (bs *BlockchainStorage) SaveBlock(block *types.Block, height uint64) error {
-	// Compute block hash
+	parentCount, err := bs.parentChainTx(block)
+	if err != nil {
+		return err
+	}
+
+	// Create atomic batch
+	batch := bs.db.NewBatch()
+
+	if err := putBlock(batch, block, height); err != nil {
+		return err
+	}
+	hash, err := serialization.HashBlockHeader(&block.Header)
+	if err != nil {
+		return err
+	}
+	putChainTx(batch, hash, block, parentCount)
+	if bs.nullDataIndex {
+		putNullData(batch, block, height)
+	}
+	if bs.spentIndex {
+		putSpent(batch, block, height)
+	}
+	if bs.addressIndex {
+		if err := bs.putAddresses(batch, block, height, make(map[types.Hash]*types.Transaction)); err != nil {
+			return err
+		}
+	}
+
+	// Commit everything atomically
+	return batch.Write()
+}
+
+// SwitchChain replaces the best chain above forkHeight with blocks in a
+// single atomic write. Heights above the new tip are dropped from the
+// height index; the old blocks themselves stay stored.
+func (bs *BlockchainStorage) SwitchChain(forkHeight uint64, blocks []*types.Block) error {
+	if len(blocks) == 0 {
+		return fmt.Errorf("no blocks to connect")
+	}
+
+	oldHeight, err := bs.chainState.GetBestBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	count, err := bs.parentChainTx(blocks[0])
+	if err != nil {
+		return err
+	}
+
+	batch := bs.db.NewBatch()
+	if bs.nullDataIndex {
+		bs.deleteNullData(batch, forkHeight+1, oldHeight)
+	}
+	if bs.spentIndex {
+		if err := bs.deleteSpent(batch, forkHeight+1, oldHeight); err != nil {
+			return err
+		}
+	}
+	if bs.addressIndex {
+		if err := bs.deleteAddresses(batch, forkHeight+1, oldHeight); err != nil {
+			return err
+		}
+	}
+	pending := make(map[types.Hash]*types.Transaction)
+	for i, block := range blocks {
+		height := forkHeight + uint64(i) + 1
+		if err := putBlock(batch, block, height); err != nil {
+			return err
+		}
+		hash, err := serialization.HashBlockHeader(&block.Header)
+		if err != nil {
+			return err
+		}
+		count = putChainTx(batch, hash, block, count)
+		if bs.nullDataIndex {
+			putNullData(batch, block, height)
+		}
+		if bs.spentIndex {
+			putSpent(batch, block, height)
+		}
+		if bs.addressIndex {
+			if err := bs.putAddresses(batch, block, height, pending); err != nil {
+				return err
+			}
+		}
+	}
+
+	newHeight := forkHeight + uint64(len(blocks))
+	for h := newHeight + 1; h <= oldHeight; h++ {
+		batch.Delete(HeightKey(h))
+	}
+
+	return batch.Write()
+}
+
+// SaveSideBlock stores a block that is not on the best chain. Only the
+// block data, its hash -> height entry and its transaction count are
+// written; the height and transaction indexes and the tip are left alone.
+func (bs *BlockchainStorage) SaveSideBlock(block *types.Block, height uint64) error {
 	blockHash, err := serialization.HashBlockHeader(&block.Header)
 	if err != nil {
 		return fmt.Errorf("failed to hash block: %w", err)
 	}
 
-	// Serialize block
 	serializedBlock, err := serializeBlock(block)
 	if err != nil {
 		return fmt.Errorf("failed to serialize block: %w", err)
 	}
 
-	// Create atomic batch
+	parentCount, err := bs.parentChainTx(block)
+	if err != nil {
+		return err
+	}
+
+	heightBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(heightBytes, height)
+
 	batch := bs.db.NewBatch()
+	batch.Put(BlockKey(blockHash), serializedBlock)
+	batch.Put(BlockHeightKey(blockHash), heightBytes)
+	putChainTx(batch, blockHash, block, parentCount)
+	return batch.Write()
+}
+
+// IsMainChain reports whether a stored block is on the best chain
+func (bs *BlockchainStorage) IsMainChain(hash types.Hash) (bool, error) {
+	height, err := bs.GetBlockHeight(hash)
+	if err != nil {
+		return false, err
+	}
+
+	mainHash, err := bs.db.Get(HeightKey(height))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(mainHash, hash[:]), nil
+}
+
+// GetAncestor returns the hash of the block at height on the chain ending
+// in the stored block hash
+func (bs *BlockchainStorage) GetAncestor(hash types.Hash, height uint64) (types.Hash, error) {
+	tipHeight, err := bs.GetBlockHeight(hash)
+	if err != nil {
+		return types.Hash{}, err
+	}
+	if height > tipHeight {
+		return types.Hash{}, fmt.Errorf("no ancestor at height %d above block %s at %d", height, hash, tipHeight)
+	}
+
+	for tipHeight > height {
+		// Once the walk reaches the best chain the height index has the rest
+		onMain, err := bs.IsMainChain(hash)
+		if err != nil {
+			return types.Hash{}, err
+		}
+		if onMain {
+			break
+		}
+
+		block, err := bs.GetBlock(hash)
+		if err != nil {
+			return types.Hash{}, err
+		}
+		hash = block.Header.PrevBlockHash
+		tipHeight--
+	}
+	if tipHeight == height {
+		return hash, nil
+	}
+
+	mainHash, err := bs.db.Get(HeightKey(height))
+	if err != nil {
+		return types.Hash{}, err
+	}
+	var ancestor types.Hash
+	copy(ancestor[:], mainHash)
+	return ancestor, nil
+}
+
+// GetBranch walks back from a stored block to the best chain and returns
+// the blocks off the best chain, oldest first, with the height of the
+// best-chain block they fork from. A block on the best chain gives an
+// empty branch.
+func (bs *BlockchainStorage) GetBranch(hash types.Hash) ([]*types.Block, uint64, error) {
+	var branch []*types.Block
+	for {
+		onMain, err := bs.IsMainChain(hash)
+		if err != nil {
+			return nil, 0, err
+		}
+		if onMain {
+			height, err := bs.GetBlockHeight(hash)
+			if err != nil {
+				return nil, 0, err
+			}
+			// Reverse into oldest-first order
+			for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+				branch[i], branch[j] = branch[j], branch[i]
+			}
+			return branch, height, nil
+		}
+
+		block, err := bs.GetBlock(hash)
+		if err != nil {
+			return nil, 0, err
+		}
+		branch = append(branch, block)
+		hash = block.Header.PrevBlockHash
+	}
+}
+
+// BranchHasMoreWork reports whether a branch forking off the best chain
+// at forkHeight carries more work than the best chain above that height
+func (bs *BlockchainStorage) BranchHasMoreWork(branch []*types.Block, forkHeight uint64) (bool, error) {
+	tipHeight, err := bs.GetBestBlockHeight()
+	if err != nil {
+		return false, err
+	}
+
+	branchWork := big.NewInt(0)
+	for _, block := range branch {
+		branchWork.Add(branchWork, consensus.CalcWork(block.Header.Bits))
+	}
+
+	mainWork := big.NewInt(0)
+	for h := forkHeight + 1; h <= tipHeight; h++ {
+		block, err := bs.GetBlockByHeight(h)
+		if err != nil {
+			return false, err
+		}
+		mainWork.Add(mainWork, consensus.CalcWork(block.Header.Bits))
+	}
+
+	return branchWork.Cmp(mainWork) > 0, nil
+}
+
+// GetChainWork returns the total work of the chain from genesis through
+// the stored block with hash, which need not be on the best chain
+func (bs *BlockchainStorage) GetChainWork(hash types.Hash) (*big.Int, error) {
+	height, err := bs.GetBlockHeight(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	work := big.NewInt(0)
+	for {
+		onMain, err := bs.IsMainChain(hash)
+		if err != nil {
+			return nil, err
+		}
+		if onMain {
+			break
+		}
+		// Walk back to the best chain
+		block, err := bs.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		work.Add(work, consensus.CalcWork(block.Header.Bits))
+		if height == 0 {
+			return work, nil
+		}
+		hash = block.Header.PrevBlockHash
+		height--
+	}
+
+	for h := uint64(0); h <= height; h++ {
+		header, err := bs.HeaderAt(h)
+		if err != nil {
+			return nil, err
+		}
+		work.Add(work, consensus.CalcWork(header.Bits))
+	}
+	return work, nil
+}
+
+// putBlock adds a block, its indexes and the new tip to batch
+func putBlock(batch *Batch, block *types.Block, height uint64) error {
+	// Compute block hash
+	blockHash, err := serialization.HashBlockHeader(&block.Header)
+	if err != nil {
+		return fmt.Errorf("failed to hash block: %w", err)
+	}
+
+	// Serialize block
+	serializedBlock, err := serializeBlock(block)
+	if err != nil {
+		return fmt.Errorf("failed to serialize block: %w", err)
+	}
 
 	// 1. Store block data
 	blockKey := BlockKey(blockHash)
 	batch.Put(blockKey, serializedBlock)
 
-	// 2. Store height index
+	// 2-4. Store the height, transaction and block height indexes
+	if err := putIndexes(batch, block, blockHash, height); err != nil {
+		return err
+	}
+
+	// 5. Update chain state (if this is new tip)
+	heightBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(heightBytes, height)
+	batch.Put(ChainStateKey(KeyBestBlockHash), blockHash[:])
+	batch.Put(ChainStateKey(KeyBestBlockHeight), heightBytes)
+
+	return nil
+}
+
+// putIndexes adds the index entries of a best-chain block to batch: all
+// of them can be derived from the block and its height again
+func putIndexes(batch *Batch, block *types.Block, blockHash types.Hash, height uint64) error {
+	// Height index
 	heightKey := HeightKey(height)
 	batch.Put(heightKey, blockHash[:])
 
-	// 3. Store transaction indexes
+	// Transaction indexes
 	for txIndex, tx := range block.Transactions {
 		txHash, err := serialization.HashTransaction(&tx)
 		if err != nil {
@@ -70,19 +400,13 @@ func (bs *BlockchainStorage) SaveBlock(block *types.Block, height uint64) error
 		batch.Put(txKey, txLocation)
 	}
 
-	// 4. Store block height index (Hash -> Height)
+	// Block height index (Hash -> Height)
 	blockHeightKey := BlockHeightKey(blockHash)
 	heightBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(heightBytes, height)
 	batch.Put(blockHeightKey, heightBytes)
 
-	// 5. Update chain state (if this is new tip)
-	batch.Put(ChainStateKey(KeyBestBlockHash), blockHash[:])
-	// heightBytes is already created above
-	batch.Put(ChainStateKey(KeyBestBlockHeight), heightBytes)
-
-	// Commit everything atomically
-	return batch.Write()
+	return nil
 }
 
 // GetBlock retrieves block by hash
@@ -94,10 +418,36 @@ func (bs *BlockchainStorage) GetBlock(hash types.Hash) (*types.Block, error) {
 	}
 
 	if value == nil {
-		return nil, fmt.Errorf("block not found: %s", hash)
+		return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, hash)
+	}
+
+	// A damaged record can still decode, but not to the block it is
+	// stored under
+	block, err := deserializeBlock(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: block %s doesn't decode: %v", ErrCorrupt, hash, err)
 	}
+	actual, err := serialization.HashBlockHeader(&block.Header)
+	if err != nil {
+		return nil, err
+	}
+	if actual != hash {
+		return nil, fmt.Errorf("%w: block stored as %s hashes to %s", ErrCorrupt, hash, actual)
+	}
+	return block, nil
+}
 
-	return deserializeBlock(value)
+// GetRawBlock returns a block's stored serialization, the same bytes it
+// has on the wire
+func (bs *BlockchainStorage) GetRawBlock(hash types.Hash) ([]byte, error) {
+	value, err := bs.db.Get(BlockKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, hash)
+	}
+	return value, nil
 }
 
 // GetBlockByHeight retrieves block by height
@@ -109,15 +459,37 @@ func (bs *BlockchainStorage) GetBlockByHeight(height uint64) (*types.Block, erro
 		return nil, err
 	}
 
+	// The tip is read after the index: a reorg drops heights and lowers
+	// the tip in one write, so a height missing below it is damage
 	if hashBytes == nil {
-		return nil, fmt.Errorf("no block at height %d", height)
+		if _, tip, err := bs.GetTip(); err == nil && height <= tip {
+			return nil, bs.inconsistent(height, "no block indexed at height %d below the tip at %d", height, tip)
+		}
+		return nil, fmt.Errorf("%w at height %d", ErrBlockNotFound, height)
+	}
+	if len(hashBytes) != 32 {
+		return nil, bs.inconsistent(height, "height %d indexes a %d byte hash", height, len(hashBytes))
 	}
 
 	var hash types.Hash
 	copy(hash[:], hashBytes)
 
-	// Then get block by hash
-	return bs.GetBlock(hash)
+	// Then get block by hash. Blocks are never deleted, so one the index
+	// names must be there.
+	block, err := bs.GetBlock(hash)
+	if errors.Is(err, ErrBlockNotFound) {
+		return nil, bs.inconsistent(height, "height %d indexes missing block %s", height, hash)
+	}
+	return block, err
+}
+
+// HeaderAt retrieves the header of the main chain block at height
+func (bs *BlockchainStorage) HeaderAt(height uint64) (*types.BlockHeader, error) {
+	block, err := bs.GetBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return &block.Header, nil
 }
 
 // GetBlockHeight retrieves height by block hash
@@ -129,7 +501,7 @@ func (bs *BlockchainStorage) GetBlockHeight(hash types.Hash) (uint64, error) {
 	}
 
 	if value == nil {
-		return 0, fmt.Errorf("block height not found for hash: %s", hash)
+		return 0, fmt.Errorf("%w: no height for %s", ErrBlockNotFound, hash)
 	}
 
 	if len(value) != 8 {
@@ -153,7 +525,7 @@ func (bs *BlockchainStorage) GetBestBlock() (*types.Block, uint64, error) {
 	}
 
 	if hash.IsZero() {
-		return nil, 0, fmt.Errorf("blockchain is empty")
+		return nil, 0, ErrEmptyChain
 	}
 
 	height, err := bs.chainState.GetBestBlockHeight()
@@ -178,27 +550,69 @@ func (bs *BlockchainStorage) GetTransactionLocation(txHash types.Hash) (blockHas
 	}
 
 	if value == nil {
-		return types.Hash{}, 0, fmt.Errorf("transaction not found: %s", txHash)
+		return types.Hash{}, 0, fmt.Errorf("%w: %s", ErrTxNotFound, txHash)
 	}
 
-	return deserializeTxLocation(value)
+	// A location that doesn't decode, or names a block that isn't
+	// stored, has no height to start a reindex at: the whole transaction
+	// index is rebuilt, and the bad entry dropped in case the
+	// transaction isn't on the best chain to be indexed again
+	blockHash, txIndex, err = deserializeTxLocation(value)
+	if err == nil {
+		var stored bool
+		if stored, err = bs.HasBlock(blockHash); err != nil {
+			return types.Hash{}, 0, err
+		}
+		if !stored {
+			err = fmt.Errorf("block %s not stored", blockHash)
+		}
+	}
+	if err != nil {
+		bs.db.Delete(key)
+		return types.Hash{}, 0, bs.inconsistent(0, "transaction %s: %v", txHash, err)
+	}
+	return blockHash, txIndex, nil
 }
 
-// GetBlockCount returns total number of blocks
+// GetBlockCount returns the number of best-chain blocks, 0 for an empty
+// chain
 func (bs *BlockchainStorage) GetBlockCount() (uint64, error) {
-	height, err := bs.chainState.GetBestBlockHeight()
+	_, height, err := bs.GetTip()
+	if errors.Is(err, ErrEmptyChain) {
+		return 0, nil
+	}
 	if err != nil {
 		return 0, err
 	}
 	return height + 1, nil // Height is 0-indexed
 }
 
-// GetBestBlockHash returns the hash of the tip block
+// GetTip returns the hash and height of the best block, or ErrEmptyChain.
+// Unlike GetBestBlockHeight, which reports 0 for an empty chain, it
+// tells an empty chain from one holding only genesis.
+func (bs *BlockchainStorage) GetTip() (types.Hash, uint64, error) {
+	hash, err := bs.chainState.GetBestBlockHash()
+	if err != nil {
+		return types.Hash{}, 0, err
+	}
+	if hash.IsZero() {
+		return types.Hash{}, 0, ErrEmptyChain
+	}
+	height, err := bs.chainState.GetBestBlockHeight()
+	if err != nil {
+		return types.Hash{}, 0, err
+	}
+	return hash, height, nil
+}
+
+// GetBestBlockHash returns the hash of the tip block, zero for an empty
+// chain
 func (bs *BlockchainStorage) GetBestBlockHash() (types.Hash, error) {
 	return bs.chainState.GetBestBlockHash()
 }
 
-// GetBestBlockHeight returns the height of the tip block
+// GetBestBlockHeight returns the height of the tip block, 0 for an empty
+// chain
 func (bs *BlockchainStorage) GetBestBlockHeight() (uint64, error) {
 	return bs.chainState.GetBestBlockHeight()
 }
@@ -230,7 +644,7 @@ func serializeBlock(block *types.Block) ([]byte, error) {
 
 	// Serialize each transaction
 	for _, tx := range block.Transactions {
-		txBytes, err := serialization.SerializeTransaction(&tx)
+		txBytes, err := serialization.SerializeTransactionWitness(&tx)
 		if err != nil {
 			return nil, err
 		}
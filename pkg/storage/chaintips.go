@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"encoding/binary"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Chain tip statuses, as reported by getchaintips
+const (
+	ChainTipActive      = "active"       // Tip of the best chain
+	ChainTipValidFork   = "valid-fork"   // Stored branch that doesn't have the most work
+	ChainTipInvalid     = "invalid"      // Branch containing a block that failed validation
+	ChainTipHeadersOnly = "headers-only" // Headers known, blocks not downloaded yet
+)
+
+// ChainTip is a block with no known child
+type ChainTip struct {
+	Hash      types.Hash
+	Height    uint64
+	BranchLen int // Blocks between the tip and the best chain
+	Status    string
+}
+
+// MarkInvalid records that a stored block failed validation, so its
+// branch is reported as invalid
+func (bs *BlockchainStorage) MarkInvalid(hash types.Hash) error {
+	return bs.db.Put(InvalidBlockKey(hash), nil)
+}
+
+// IsInvalid reports whether a block was marked invalid
+func (bs *BlockchainStorage) IsInvalid(hash types.Hash) (bool, error) {
+	return bs.db.Has(InvalidBlockKey(hash))
+}
+
+// IsBranchInvalid reports whether a stored block or any of its ancestors
+// off the best chain was marked invalid
+func (bs *BlockchainStorage) IsBranchInvalid(hash types.Hash) (bool, error) {
+	for {
+		if invalid, err := bs.IsInvalid(hash); err != nil || invalid {
+			return invalid, err
+		}
+		onMain, err := bs.IsMainChain(hash)
+		if err != nil || onMain {
+			return false, err
+		}
+		block, err := bs.GetBlock(hash)
+		if err != nil {
+			return false, err
+		}
+		hash = block.Header.PrevBlockHash
+	}
+}
+
+// GetChainTips returns every stored block that no other stored block
+// builds on: the best tip and the tips of all side branches
+func (bs *BlockchainStorage) GetChainTips() ([]ChainTip, error) {
+	heights := make(map[types.Hash]uint64)
+	it := bs.db.NewIterator([]byte{PrefixBlockHeight})
+	for it.Next() {
+		var hash types.Hash
+		copy(hash[:], it.Key()[1:])
+		heights[hash] = binary.BigEndian.Uint64(it.Value())
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+
+	// A block is a tip unless it is some other block's parent
+	parents := make(map[types.Hash]bool, len(heights))
+	for hash := range heights {
+		block, err := bs.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		parents[block.Header.PrevBlockHash] = true
+	}
+
+	bestHash, err := bs.GetBestBlockHash()
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []ChainTip
+	for hash, height := range heights {
+		if parents[hash] {
+			continue
+		}
+		tip := ChainTip{Hash: hash, Height: height, Status: ChainTipActive}
+		if hash != bestHash {
+			branch, _, err := bs.GetBranch(hash)
+			if err != nil {
+				return nil, err
+			}
+			tip.BranchLen = len(branch)
+			tip.Status = ChainTipValidFork
+			if invalid, err := bs.IsBranchInvalid(hash); err != nil {
+				return nil, err
+			} else if invalid {
+				tip.Status = ChainTipInvalid
+			}
+		}
+		tips = append(tips, tip)
+	}
+	return tips, nil
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// GetChainTxCount returns the number of transactions from genesis through
+// the block with hash. Blocks stored before the counter existed have no
+// entry; their count is rebuilt by walking back to the nearest block that
+// has one.
+func (bs *BlockchainStorage) GetChainTxCount(hash types.Hash) (uint64, error) {
+	var pending uint64
+	for {
+		value, err := bs.db.Get(ChainTxKey(hash))
+		if err != nil {
+			return 0, err
+		}
+		if len(value) == 8 {
+			return pending + binary.BigEndian.Uint64(value), nil
+		}
+
+		block, err := bs.GetBlock(hash)
+		if err != nil {
+			return 0, err
+		}
+		pending += uint64(len(block.Transactions))
+		if block.Header.PrevBlockHash.IsZero() {
+			return pending, nil
+		}
+		hash = block.Header.PrevBlockHash
+	}
+}
+
+// putChainTx records the cumulative transaction count of a block whose
+// parent's count is parentCount, and returns it
+func putChainTx(batch *Batch, hash types.Hash, block *types.Block, parentCount uint64) uint64 {
+	count := parentCount + uint64(len(block.Transactions))
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, count)
+	batch.Put(ChainTxKey(hash), value)
+	return count
+}
+
+// parentChainTx returns the cumulative transaction count of a block's
+// parent. Genesis, and a block whose parent isn't stored, count from zero.
+func (bs *BlockchainStorage) parentChainTx(block *types.Block) (uint64, error) {
+	prev := block.Header.PrevBlockHash
+	if prev.IsZero() {
+		return 0, nil
+	}
+	if known, err := bs.HasBlock(prev); err != nil {
+		return 0, err
+	} else if !known {
+		return 0, nil
+	}
+	count, err := bs.GetChainTxCount(prev)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count chain transactions: %w", err)
+	}
+	return count, nil
+}
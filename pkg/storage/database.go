@@ -2,7 +2,11 @@ package storage
 
 import (
 	"fmt"
+	"io/fs"
+	"path/filepath"
+
 	"github.com/syndtr/goleveldb/leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/iterator" // ADD THIS LINE
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -10,10 +14,14 @@ import (
 
 // Database wraps LevelDB with Bitcoin-specific operations
 type Database struct {
-	db *leveldb.DB
+	db        *leveldb.DB
+	path      string
+	recovered bool // Opened by recovering a corrupt manifest
 }
 
-// OpenDatabase opens or creates a LevelDB database
+// OpenDatabase opens or creates a LevelDB database. A database whose
+// manifest is corrupt is recovered from its table files, which may lose
+// recent writes; Recovered reports that it happened.
 func OpenDatabase(path string) (*Database, error) {
 	// Open with compression enabled
 	opts := &opt.Options{
@@ -21,11 +29,63 @@ func OpenDatabase(path string) (*Database, error) {
 	}
 
 	db, err := leveldb.OpenFile(path, opts)
+	recovered := false
+	if lerrors.IsCorrupted(err) {
+		db, err = leveldb.RecoverFile(path, opts)
+		recovered = true
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", corruptErr(err))
+	}
+
+	return &Database{db: db, path: path, recovered: recovered}, nil
+}
+
+// SizeOf estimates the bytes on disk the keys starting with prefix take,
+// compressed, in table files. The estimate goes by table data blocks of
+// a few KB, and recent writes still in the journal aren't counted.
+func (db *Database) SizeOf(prefix []byte) (int64, error) {
+	sizes, err := db.db.SizeOf([]util.Range{*util.BytesPrefix(prefix)})
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return 0, corruptErr(err)
 	}
+	return sizes.Sum(), nil
+}
 
-	return &Database{db: db}, nil
+// DiskSize returns the total size of the database's files
+func (db *Database) DiskSize() (int64, error) {
+	var total int64
+	err := filepath.WalkDir(db.path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// Compact rewrites the whole key range, dropping deleted and overwritten
+// entries and flushing the journal into table files
+func (db *Database) Compact() error {
+	return corruptErr(db.db.CompactRange(util.Range{}))
+}
+
+// Recovered reports whether the database had to be recovered on open
+func (db *Database) Recovered() bool {
+	return db.recovered
+}
+
+// corruptErr marks LevelDB's checksum and corruption errors as ErrCorrupt
+func corruptErr(err error) error {
+	if lerrors.IsCorrupted(err) {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return err
 }
 
 // Close closes the database
@@ -33,13 +93,21 @@ func (db *Database) Close() error {
 	return db.db.Close()
 }
 
+// Ping checks that the database is open and readable
+func (db *Database) Ping() error {
+	if _, err := db.db.Has([]byte("ping"), nil); err != nil {
+		return fmt.Errorf("database unavailable: %w", err)
+	}
+	return nil
+}
+
 // Get retrieves value for key
 func (db *Database) Get(key []byte) ([]byte, error) {
 	value, err := db.db.Get(key, nil)
 	if err == leveldb.ErrNotFound {
 		return nil, nil // Return nil for not found (not an error)
 	}
-	return value, err
+	return value, corruptErr(err)
 }
 
 // Put stores key-value pair
@@ -54,7 +122,8 @@ func (db *Database) Delete(key []byte) error {
 
 // Has checks if key exists
 func (db *Database) Has(key []byte) (bool, error) {
-	return db.db.Has(key, nil)
+	has, err := db.db.Has(key, nil)
+	return has, corruptErr(err)
 }
 
 // Batch represents an atomic batch of operations
@@ -91,6 +160,41 @@ func (b *Batch) Reset() {
 	b.batch.Reset()
 }
 
+// Snapshot is a read-only view of the database as it was when taken.
+// Writes made since don't show through it.
+type Snapshot struct {
+	snap *leveldb.Snapshot
+}
+
+// GetSnapshot takes a snapshot. It must be released.
+func (db *Database) GetSnapshot() (*Snapshot, error) {
+	snap, err := db.db.GetSnapshot()
+	if err != nil {
+		return nil, corruptErr(err)
+	}
+	return &Snapshot{snap: snap}, nil
+}
+
+// Get retrieves the value for key as of the snapshot, nil if not found
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	value, err := s.snap.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return value, corruptErr(err)
+}
+
+// NewIterator iterates the snapshot's keys starting with prefix, every
+// key for an empty one
+func (s *Snapshot) NewIterator(prefix []byte) *Iterator {
+	return &Iterator{iter: s.snap.NewIterator(util.BytesPrefix(prefix), nil)}
+}
+
+// Release frees the snapshot
+func (s *Snapshot) Release() {
+	s.snap.Release()
+}
+
 // Iterator for range queries
 type Iterator struct {
 	iter iterator.Iterator
@@ -124,5 +228,5 @@ func (it *Iterator) Release() {
 
 // Error returns any error encountered
 func (it *Iterator) Error() error {
-	return it.iter.Error()
+	return corruptErr(it.iter.Error())
 }
@@ -0,0 +1,83 @@
+package storage
+
+// KeySpace is the group of database entries under one key prefix
+type KeySpace struct {
+	Name   string
+	Prefix byte
+}
+
+// KeySpaces lists every prefix the storage writes, in the order disk
+// usage is reported. There is no undo data: disconnecting a block
+// re-reads the outputs it spent from the blocks that created them.
+var KeySpaces = []KeySpace{
+	{"blocks", PrefixBlock},
+	{"height_index", PrefixHeight},
+	{"tx_index", PrefixTx},
+	{"block_heights", PrefixBlockHeight},
+	{"chain_tx", PrefixChainTx},
+	{"invalid", PrefixInvalid},
+	{"nulldata_index", PrefixNullData},
+	{"spent_index", PrefixSpent},
+	{"address_index", PrefixAddress},
+	{"chainstate", PrefixChainState},
+}
+
+// KeySpaceUsage is the space one key prefix takes
+type KeySpaceUsage struct {
+	Name      string
+	Entries   int64
+	Bytes     int64 // Keys and values, uncompressed
+	DiskBytes int64 // Estimated, compressed, in table files
+}
+
+// DiskUsage is the space the database takes
+type DiskUsage struct {
+	Total     int64 // Every file in the directory, journal and lock included
+	KeySpaces []KeySpaceUsage
+}
+
+// GetDiskUsage measures the database overall and per key prefix.
+// Entries and uncompressed sizes come from reading every key, so this is
+// slow on a large chain.
+func (bs *BlockchainStorage) GetDiskUsage() (*DiskUsage, error) {
+	total, err := bs.db.DiskSize()
+	if err != nil {
+		return nil, err
+	}
+	usage := &DiskUsage{Total: total}
+
+	for _, space := range KeySpaces {
+		prefix := []byte{space.Prefix}
+		entry := KeySpaceUsage{Name: space.Name}
+		it := bs.db.NewIterator(prefix)
+		for it.Next() {
+			entry.Entries++
+			entry.Bytes += int64(len(it.Key()) + len(it.Value()))
+		}
+		it.Release()
+		if err := it.Error(); err != nil {
+			return nil, err
+		}
+		if entry.DiskBytes, err = bs.db.SizeOf(prefix); err != nil {
+			return nil, err
+		}
+		usage.KeySpaces = append(usage.KeySpaces, entry)
+	}
+	return usage, nil
+}
+
+// Compact compacts the database, reclaiming the space of entries deleted
+// or overwritten, e.g. by reorgs, and returns its size before and after.
+// It can take a while and slows other reads and writes meanwhile.
+func (bs *BlockchainStorage) Compact() (before, after int64, err error) {
+	if before, err = bs.db.DiskSize(); err != nil {
+		return 0, 0, err
+	}
+	if err := bs.db.Compact(); err != nil {
+		return 0, 0, err
+	}
+	if after, err = bs.db.DiskSize(); err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
@@ -0,0 +1,27 @@
+package storage
+
+import "errors"
+
+// Lookup failures. The storage calls wrap these, so callers can tell a
+// missing block or transaction from a database failure with errors.Is.
+var (
+	// ErrEmptyChain is returned when a call needs a tip but no block, not
+	// even genesis, is stored yet
+	ErrEmptyChain = errors.New("chain is empty")
+
+	ErrBlockNotFound = errors.New("block not found")
+	ErrTxNotFound    = errors.New("transaction not found")
+)
+
+// Damage found on read. Unlike the lookup failures these mean data that
+// should be there is missing or wrong.
+var (
+	// ErrCorrupt is returned when LevelDB reports a checksum failure, or a
+	// stored record doesn't decode or doesn't hash to its key
+	ErrCorrupt = errors.New("database corrupt")
+
+	// ErrIndexInconsistent is returned when the height or transaction
+	// index disagrees with the stored blocks. A reindex of the affected
+	// heights is scheduled; RepairIndexes runs it.
+	ErrIndexInconsistent = errors.New("index inconsistent with stored blocks, reindex scheduled")
+)
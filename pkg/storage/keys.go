@@ -23,12 +23,28 @@ const (
 
 	// Block height index: 'i' + block_hash -> height
 	PrefixBlockHeight = 'i'
+
+	// Invalid blocks: 'x' + block_hash -> empty
+	PrefixInvalid = 'x'
+
+	// OP_RETURN index: 'd' + height + tx_hash + output_index -> payload
+	PrefixNullData = 'd'
+
+	// Cumulative transactions: 'n' + block_hash -> transactions from genesis through the block
+	PrefixChainTx = 'n'
+
+	// Spent index: 's' + tx_hash + output_index -> spending tx_hash + input_index + height
+	PrefixSpent = 's'
+
+	// Address index: 'a' + sha256(script) + height + tx_index -> tx_hash + received + sent
+	PrefixAddress = 'a'
 )
 
 // Chain state keys
 const (
-	KeyBestBlockHash   = "bestblock"  // Current chain tip hash
-	KeyBestBlockHeight = "bestheight" // Current chain height
+	KeyBestBlockHash   = "bestblock"   // Current chain tip hash
+	KeyBestBlockHeight = "bestheight"  // Current chain height
+	KeyReindexFrom     = "reindexfrom" // Lowest height whose indexes need rebuilding
 )
 
 // BlockKey creates key for storing block data
@@ -67,6 +83,73 @@ func BlockHeightKey(hash types.Hash) []byte {
 	return key
 }
 
+// InvalidBlockKey creates key marking a block as invalid
+// Format: 'x' + block_hash
+func InvalidBlockKey(hash types.Hash) []byte {
+	key := make([]byte, 1+32)
+	key[0] = PrefixInvalid
+	copy(key[1:], hash[:])
+	return key
+}
+
+// NullDataKey creates key for an indexed OP_RETURN payload
+// Format: 'd' + height (8 bytes, big-endian) + tx_hash + output_index (4 bytes, big-endian)
+func NullDataKey(height uint64, txHash types.Hash, index uint32) []byte {
+	key := make([]byte, 1+8+32+4)
+	copy(key, NullDataHeightPrefix(height))
+	copy(key[9:], txHash[:])
+	binary.BigEndian.PutUint32(key[41:], index)
+	return key
+}
+
+// NullDataHeightPrefix is the key prefix of the payloads indexed at height
+// Format: 'd' + height (8 bytes, big-endian)
+func NullDataHeightPrefix(height uint64) []byte {
+	key := make([]byte, 1+8)
+	key[0] = PrefixNullData
+	binary.BigEndian.PutUint64(key[1:], height)
+	return key
+}
+
+// ChainTxKey creates key for a block's cumulative transaction count
+// Format: 'n' + block_hash
+func ChainTxKey(hash types.Hash) []byte {
+	key := make([]byte, 1+32)
+	key[0] = PrefixChainTx
+	copy(key[1:], hash[:])
+	return key
+}
+
+// SpentKey creates key for the spend of an output
+// Format: 's' + tx_hash + output_index (4 bytes, big-endian)
+func SpentKey(outpoint types.OutPoint) []byte {
+	key := make([]byte, 1+32+4)
+	key[0] = PrefixSpent
+	copy(key[1:], outpoint.Hash[:])
+	binary.BigEndian.PutUint32(key[33:], outpoint.Index)
+	return key
+}
+
+// AddressKey creates key for a transaction touching a locking script
+// Format: 'a' + sha256(script) + height (8 bytes, big-endian) + tx_index (4 bytes, big-endian)
+func AddressKey(scriptHash [32]byte, height uint64, txIndex uint32) []byte {
+	key := make([]byte, 1+32+8+4)
+	copy(key, AddressScriptPrefix(scriptHash))
+	binary.BigEndian.PutUint64(key[33:], height)
+	binary.BigEndian.PutUint32(key[41:], txIndex)
+	return key
+}
+
+// AddressScriptPrefix is the key prefix of the transactions indexed for a
+// locking script
+// Format: 'a' + sha256(script)
+func AddressScriptPrefix(scriptHash [32]byte) []byte {
+	key := make([]byte, 1+32)
+	key[0] = PrefixAddress
+	copy(key[1:], scriptHash[:])
+	return key
+}
+
 // ChainStateKey creates key for chain state
 // Format: 'c' + string_key
 func ChainStateKey(key string) []byte {
@@ -102,6 +185,9 @@ Database Layout:
   'b' + <32-byte hash> → <serialized block>     (Block data)
   'h' + <8-byte height> → <32-byte hash>        (Height index)
   't' + <32-byte txid> → <32-byte block hash>   (Transaction lookup)
+  'x' + <32-byte hash> → <empty>                (Invalid block marker)
+  'n' + <32-byte hash> → <8-byte count>         (Transactions up to the block)
+  's' + <32-byte txid> + <4-byte index> → <spending txid + input + height> (Spent index)
   'c' + "bestblock" → <32-byte hash>            (Chain tip)
   'c' + "bestheight" → <8-byte height>          (Chain height)
 */
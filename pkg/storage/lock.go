@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// LockFileName is the file in a data directory naming the process using it
+const LockFileName = ".lock"
+
+// ErrDataDirLocked is returned when another process, or another storage
+// in this process, already uses the data directory
+var ErrDataDirLocked = errors.New("data directory is locked")
+
+var (
+	heldLocksMu sync.Mutex
+	heldLocks   = make(map[string]bool) // Lock files held by this process
+)
+
+// DirLock is an exclusive claim on a data directory. It holds an flock on
+// the open lock file, which the kernel drops when the process exits, so a
+// crash never leaves the directory locked.
+type DirLock struct {
+	path     string
+	file     *os.File
+	released bool
+}
+
+// LockDataDir claims dir for this process by taking an exclusive flock on
+// its lock file, then writing our PID into it for the error other
+// processes report. Whoever holds the flock owns the directory, whatever
+// the file says.
+func LockDataDir(dir string) (*DirLock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	path, err := filepath.Abs(filepath.Join(dir, LockFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	if heldLocks[path] {
+		return nil, fmt.Errorf("%w: %s is already in use by this process (PID %d)", ErrDataDirLocked, dir, os.Getpid())
+	}
+
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			file.Close()
+			if !errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, fmt.Errorf("failed to lock data directory: %w", err)
+			}
+			// The owner may not have written its PID yet
+			data, _ := os.ReadFile(path)
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+				return nil, fmt.Errorf("%w: %s is already in use by PID %d", ErrDataDirLocked, dir, pid)
+			}
+			return nil, fmt.Errorf("%w: %s is already in use by another process", ErrDataDirLocked, dir)
+		}
+
+		// A previous owner may have removed the file between our open and
+		// our flock, leaving us holding a lock on nothing; try again
+		if !samePath(file, path) {
+			file.Close()
+			continue
+		}
+
+		if err := writePID(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write lock file: %w", err)
+		}
+		heldLocks[path] = true
+		return &DirLock{path: path, file: file}, nil
+	}
+}
+
+// samePath reports whether file is still the file at path
+func samePath(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, current)
+}
+
+// writePID replaces the lock file's contents with our PID
+func writePID(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// Release gives up the claim on the data directory. The lock file is
+// removed while the flock is still held, so no other process can take a
+// lock on it in between.
+func (l *DirLock) Release() error {
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	if l.released {
+		return nil
+	}
+	l.released = true
+	delete(heldLocks, l.path)
+
+	err := os.Remove(l.path)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
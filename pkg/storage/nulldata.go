@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// NullDataEntry is an OP_RETURN payload found in a best-chain block
+type NullDataEntry struct {
+	TxHash types.Hash
+	Index  uint32 // Output index
+	Height uint64
+	Data   []byte
+}
+
+// EnableNullDataIndex makes blocks connected from now on have their
+// OP_RETURN payloads indexed. Blocks already stored are not indexed.
+func (bs *BlockchainStorage) EnableNullDataIndex() {
+	bs.nullDataIndex = true
+}
+
+// NullDataIndexEnabled reports whether OP_RETURN payloads are indexed
+func (bs *BlockchainStorage) NullDataIndexEnabled() bool {
+	return bs.nullDataIndex
+}
+
+// putNullData adds the OP_RETURN payloads of a block at height to batch
+func putNullData(batch *Batch, block *types.Block, height uint64) {
+	for _, tx := range block.Transactions {
+		txHash, err := serialization.HashTransaction(&tx)
+		if err != nil {
+			continue
+		}
+		for i, output := range tx.Outputs {
+			if !script.IsNullData(output.PubKeyScript) {
+				continue
+			}
+			data, err := script.ExtractNullData(output.PubKeyScript)
+			if err != nil || len(data) == 0 {
+				continue // Nothing a lookup could match
+			}
+			batch.Put(NullDataKey(height, txHash, uint32(i)), data)
+		}
+	}
+}
+
+// deleteNullData adds removing the payloads indexed at heights from..to to
+// batch, for blocks leaving the best chain
+func (bs *BlockchainStorage) deleteNullData(batch *Batch, from, to uint64) {
+	for h := from; h <= to; h++ {
+		it := bs.db.NewIterator(NullDataHeightPrefix(h))
+		for it.Next() {
+			batch.Delete(append([]byte(nil), it.Key()...))
+		}
+		it.Release()
+	}
+}
+
+// FindNullData returns the indexed payloads starting with prefix, oldest
+// first, from fromHeight up. A nil prefix matches every payload.
+func (bs *BlockchainStorage) FindNullData(prefix []byte, fromHeight uint64) ([]NullDataEntry, error) {
+	var entries []NullDataEntry
+
+	it := bs.db.NewIterator([]byte{PrefixNullData})
+	defer it.Release()
+	for it.Next() {
+		key := it.Key()
+		if len(key) != 1+8+32+4 {
+			continue
+		}
+		height := binary.BigEndian.Uint64(key[1:9])
+		if height < fromHeight || !bytes.HasPrefix(it.Value(), prefix) {
+			continue
+		}
+
+		entry := NullDataEntry{
+			Index:  binary.BigEndian.Uint32(key[41:]),
+			Height: height,
+			Data:   append([]byte(nil), it.Value()...),
+		}
+		copy(entry.TxHash[:], key[9:41])
+		entries = append(entries, entry)
+	}
+
+	return entries, it.Error()
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// inconsistent schedules a reindex from height and returns the
+// ErrIndexInconsistent a read that found the damage reports
+func (bs *BlockchainStorage) inconsistent(height uint64, format string, args ...interface{}) error {
+	bs.scheduleReindex(height)
+	return fmt.Errorf("%w: %s", ErrIndexInconsistent, fmt.Sprintf(format, args...))
+}
+
+// scheduleReindex records that the indexes from height up need
+// rebuilding, keeping the lowest height asked for. It is best effort: a
+// database too damaged to take the write will fail the reads again.
+func (bs *BlockchainStorage) scheduleReindex(height uint64) {
+	if from, pending, err := bs.ReindexPending(); err == nil && pending && from <= height {
+		return
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, height)
+	bs.db.Put(ChainStateKey(KeyReindexFrom), value)
+}
+
+// ReindexPending returns the height a scheduled reindex starts at, and
+// whether one is scheduled
+func (bs *BlockchainStorage) ReindexPending() (uint64, bool, error) {
+	value, err := bs.db.Get(ChainStateKey(KeyReindexFrom))
+	if err != nil || value == nil {
+		return 0, false, err
+	}
+	if len(value) != 8 {
+		// Damaged itself: rebuild everything
+		return 0, true, nil
+	}
+	return binary.BigEndian.Uint64(value), true, nil
+}
+
+// RepairIndexes carries out a scheduled reindex: the height, transaction
+// and block height entries of every best-chain block from the scheduled
+// height to the tip are written again from the stored blocks, found by
+// walking back from the tip. It does nothing when no reindex is
+// scheduled, and runs when the storage is opened; a node calling it
+// while running must not connect blocks meanwhile. Blocks whose data is
+// lost or damaged can't be repaired this way and fail it, leaving the
+// reindex scheduled.
+func (bs *BlockchainStorage) RepairIndexes() error {
+	from, pending, err := bs.ReindexPending()
+	if err != nil || !pending {
+		return err
+	}
+
+	batch := bs.db.NewBatch()
+	tipHash, tipHeight, err := bs.GetTip()
+	if err != nil && !errors.Is(err, ErrEmptyChain) {
+		return err
+	}
+	if err == nil && from <= tipHeight {
+		hash := tipHash
+		for height := tipHeight; ; height-- {
+			block, err := bs.GetBlock(hash)
+			if err != nil {
+				return fmt.Errorf("cannot reindex height %d: %w", height, err)
+			}
+			if err := putIndexes(batch, block, hash, height); err != nil {
+				return err
+			}
+			if height == from {
+				break
+			}
+			if block.Header.PrevBlockHash == (types.Hash{}) {
+				return fmt.Errorf("%w: chain ends at height %d below the tip at %d", ErrCorrupt, height, tipHeight)
+			}
+			hash = block.Header.PrevBlockHash
+		}
+	}
+
+	batch.Delete(ChainStateKey(KeyReindexFrom))
+	return batch.Write()
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// snapshotBatchSize is how many entries ImportSnapshot writes at a time
+const snapshotBatchSize = 1000
+
+// SnapshotInfo describes an exported database
+type SnapshotInfo struct {
+	Entries   int64
+	TipHash   types.Hash // Zero for an empty chain
+	TipHeight uint64
+	Digest    [32]byte // SHA-256 of the exported stream
+}
+
+// ExportSnapshot writes every entry of the database, as of one moment, to
+// out while the node keeps running: a length-prefixed key then value per
+// entry, in key order. The tip reported is the one in the snapshot.
+func (bs *BlockchainStorage) ExportSnapshot(out io.Writer) (*SnapshotInfo, error) {
+	snap, err := bs.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	info := &SnapshotInfo{}
+	if hash, err := snap.Get(ChainStateKey(KeyBestBlockHash)); err != nil {
+		return nil, err
+	} else if len(hash) == 32 {
+		copy(info.TipHash[:], hash)
+	}
+	if height, err := snap.Get(ChainStateKey(KeyBestBlockHeight)); err != nil {
+		return nil, err
+	} else if len(height) == 8 {
+		info.TipHeight = binary.BigEndian.Uint64(height)
+	}
+
+	hasher := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(out, hasher))
+	it := snap.NewIterator(nil)
+	defer it.Release()
+	for it.Next() {
+		if err := writeSnapshotField(w, it.Key()); err != nil {
+			return nil, err
+		}
+		if err := writeSnapshotField(w, it.Value()); err != nil {
+			return nil, err
+		}
+		info.Entries++
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	copy(info.Digest[:], hasher.Sum(nil))
+	return info, nil
+}
+
+// ImportSnapshot creates a database at dir, which must not hold one, from
+// a stream ExportSnapshot wrote. The info returned is computed from the
+// stream read, for the caller to compare with what was exported.
+func ImportSnapshot(dir string, in io.Reader) (*SnapshotInfo, error) {
+	if _, err := os.Stat(dir + "/CURRENT"); err == nil {
+		return nil, fmt.Errorf("%s already holds a database", dir)
+	}
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	hasher := sha256.New()
+	r := bufio.NewReader(io.TeeReader(in, hasher))
+	info := &SnapshotInfo{}
+	batch := db.NewBatch()
+	for {
+		key, err := readSnapshotField(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		value, err := readSnapshotField(r)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", info.Entries, unexpectedEOF(err))
+		}
+		batch.Put(key, value)
+		info.Entries++
+		if info.Entries%snapshotBatchSize == 0 {
+			if err := batch.Write(); err != nil {
+				return nil, err
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	copy(info.Digest[:], hasher.Sum(nil))
+
+	chainState := NewChainState(db)
+	if info.TipHash, err = chainState.GetBestBlockHash(); err != nil {
+		return nil, err
+	}
+	if info.TipHeight, err = chainState.GetBestBlockHeight(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// writeSnapshotField writes a length-prefixed byte string
+func writeSnapshotField(w io.Writer, data []byte) error {
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(data)))
+	if _, err := w.Write(length[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readSnapshotField reads a length-prefixed byte string. io.EOF means the
+// stream ended cleanly before it.
+func readSnapshotField(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return data, nil
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, for a stream that
+// ends partway through an entry
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// SpentInfo says where a best-chain block spent an output
+type SpentInfo struct {
+	TxHash types.Hash // Spending transaction
+	Index  uint32     // Input index
+	Height uint64
+}
+
+// EnableSpentIndex makes blocks connected from now on have the outputs
+// they spend indexed. Blocks already stored are not indexed.
+func (bs *BlockchainStorage) EnableSpentIndex() {
+	bs.spentIndex = true
+}
+
+// SpentIndexEnabled reports whether spent outputs are indexed
+func (bs *BlockchainStorage) SpentIndexEnabled() bool {
+	return bs.spentIndex
+}
+
+// putSpent adds the outputs spent by a block at height to batch
+func putSpent(batch *Batch, block *types.Block, height uint64) {
+	for i, tx := range block.Transactions {
+		if i == 0 {
+			continue // Coinbase
+		}
+		txHash, err := serialization.HashTransaction(&tx)
+		if err != nil {
+			continue
+		}
+		for j, input := range tx.Inputs {
+			value := make([]byte, 32+4+8)
+			copy(value, txHash[:])
+			binary.BigEndian.PutUint32(value[32:], uint32(j))
+			binary.BigEndian.PutUint64(value[36:], height)
+			batch.Put(SpentKey(types.OutPoint{Hash: input.PrevTxHash, Index: input.OutputIndex}), value)
+		}
+	}
+}
+
+// deleteSpent adds removing the spends indexed for the best-chain blocks
+// at heights from..to to batch, for blocks leaving the best chain
+func (bs *BlockchainStorage) deleteSpent(batch *Batch, from, to uint64) error {
+	for h := from; h <= to; h++ {
+		block, err := bs.GetBlockByHeight(h)
+		if err != nil {
+			return fmt.Errorf("failed to unindex block %d: %w", h, err)
+		}
+		for i, tx := range block.Transactions {
+			if i == 0 {
+				continue
+			}
+			for _, input := range tx.Inputs {
+				batch.Delete(SpentKey(types.OutPoint{Hash: input.PrevTxHash, Index: input.OutputIndex}))
+			}
+		}
+	}
+	return nil
+}
+
+// GetSpentInfo returns where the best chain spent outpoint, or nil if it
+// hasn't or the spend isn't indexed
+func (bs *BlockchainStorage) GetSpentInfo(outpoint types.OutPoint) (*SpentInfo, error) {
+	value, err := bs.db.Get(SpentKey(outpoint))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	if len(value) != 32+4+8 {
+		return nil, fmt.Errorf("corrupt spent index entry for %s:%d", outpoint.Hash, outpoint.Index)
+	}
+
+	info := &SpentInfo{
+		Index:  binary.BigEndian.Uint32(value[32:]),
+		Height: binary.BigEndian.Uint64(value[36:]),
+	}
+	copy(info.TxHash[:], value[:32])
+	return info, nil
+}
@@ -0,0 +1,134 @@
+package testharness
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+const (
+	// syntheticCoinValue is the value of every output a synthetic block spends
+	syntheticCoinValue = 1000000
+
+	// syntheticFee is what each synthetic spend pays
+	syntheticFee = 1000
+)
+
+// SyntheticBlockConfig sizes a block built by SyntheticBlock
+type SyntheticBlockConfig struct {
+	Transactions int  // Spends besides the coinbase
+	Inputs       int  // Inputs per spend
+	Outputs      int  // Outputs per spend
+	Taproot      bool // Key path taproot spends instead of P2PKH
+}
+
+// BenchBlockConfig is the block of about 250 kB the package benchmarks
+// run on. For bigger blocks use cmd/bench.
+var BenchBlockConfig = SyntheticBlockConfig{Transactions: 500, Inputs: 2, Outputs: 2}
+
+// SyntheticBlock builds a block at height 1 on a zero parent hash, filled
+// with signed P2PKH or taproot spends, and the UTXO set it spends from. No
+// spend depends on another, so any of them can be validated on its own.
+func SyntheticBlock(cfg SyntheticBlockConfig) (*types.Block, *utxo.UTXOSet, error) {
+	if cfg.Transactions < 0 || cfg.Inputs < 1 || cfg.Outputs < 1 {
+		return nil, nil, fmt.Errorf("synthetic block needs at least one input and output per transaction")
+	}
+
+	// A fixed key keeps the block the same from run to run
+	secret := make([]byte, 32)
+	secret[31] = 1
+	key, err := keys.NewPrivateKeyFromBytes(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	address := key.PublicKey().P2PKHAddress()
+	addr, err := keys.DecodeAddress(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	coinScript, err := script.P2PKH(addr.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	spendScript := coinScript
+	var tweaked *keys.PrivateKey
+	if cfg.Taproot {
+		if tweaked, err = key.TaprootPrivateKey(nil); err != nil {
+			return nil, nil, err
+		}
+		if spendScript, err = script.WitnessProgram(1, tweaked.PublicKey().XOnly()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	set := utxo.NewUTXOSet()
+	txs := make([]types.Transaction, 0, cfg.Transactions+1)
+	var fees int64
+	for i := 0; i < cfg.Transactions; i++ {
+		builder := transaction.NewTxBuilder()
+		prevOuts := make([]types.TxOutput, 0, cfg.Inputs)
+		for j := 0; j < cfg.Inputs; j++ {
+			var funding types.Hash
+			binary.LittleEndian.PutUint64(funding[:], uint64(i*cfg.Inputs+j))
+			funding[31] = 0xbe
+			coin := utxo.NewUTXO(funding, 0, types.TxOutput{Value: syntheticCoinValue, PubKeyScript: spendScript}, 0, false)
+			if err := set.Add(coin); err != nil {
+				return nil, nil, err
+			}
+			builder.AddInput(funding, 0)
+			prevOuts = append(prevOuts, coin.Output)
+		}
+
+		total := int64(cfg.Inputs)*syntheticCoinValue - syntheticFee
+		for j := 0; j < cfg.Outputs; j++ {
+			value := total / int64(cfg.Outputs)
+			if j == 0 {
+				value += total % int64(cfg.Outputs)
+			}
+			builder.AddOutput(value, coinScript)
+		}
+
+		tx, err := builder.Build()
+		if err != nil {
+			return nil, nil, err
+		}
+		for j := range tx.Inputs {
+			if !cfg.Taproot {
+				if err := transaction.SignInput(tx, j, key, coinScript, transaction.SigHashAll); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			ctx := &transaction.TaprootSpendContext{PrevOuts: prevOuts}
+			sig, err := transaction.SignTaproot(tx, j, ctx, tweaked, transaction.SigHashDefault)
+			if err != nil {
+				return nil, nil, err
+			}
+			tx.Inputs[j].Witness = [][]byte{sig}
+		}
+		txs = append(txs, *tx)
+		fees += syntheticFee
+	}
+
+	coinbase, err := mining.CreateCoinbase(1, fees, address, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := mining.SolveBlock(&mining.BlockTemplate{
+		Version:      1,
+		Transactions: append([]types.Transaction{*coinbase}, txs...),
+		Timestamp:    GenesisTimestamp + 600,
+		Bits:         RegtestBits,
+		Height:       1,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, set, nil
+}
@@ -0,0 +1,188 @@
+package testharness
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+const (
+	// GenesisTimestamp is the fixed timestamp of the harness genesis block
+	GenesisTimestamp = 1296688602
+
+	// RegtestBits is the difficulty field used for harness blocks:
+	// regtest's proof-of-work limit, which never retargets
+	RegtestBits = consensus.RegtestPowLimitBits
+
+	// InvTrickleInterval keeps transaction relay fast between harness nodes
+	InvTrickleInterval = 50 * time.Millisecond
+
+	// pollInterval is how often Wait* helpers re-check their condition
+	pollInterval = 20 * time.Millisecond
+)
+
+// Harness runs several in-process regtest nodes for functional tests
+type Harness struct {
+	Nodes   []*TestNode
+	Rules   *consensus.ConsensusRules
+	Genesis *types.Block
+	Clock   clock.Clock
+
+	baseDir string
+}
+
+// New creates a harness with numNodes started (but unconnected) nodes.
+// Every node gets its own temporary data directory and shares the same
+// deterministic genesis block, so they can sync with each other.
+func New(numNodes int) (*Harness, error) {
+	return NewWithConfig(numNodes, nil)
+}
+
+// NewWithConfig is like New but lets configure adjust each node's P2P
+// config before it starts
+func NewWithConfig(numNodes int, configure func(id int, config *network.NodeConfig)) (*Harness, error) {
+	if numNodes < 1 {
+		return nil, fmt.Errorf("need at least one node, got %d", numNodes)
+	}
+
+	baseDir, err := os.MkdirTemp("", "testharness-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+
+	genesis, err := createGenesisBlock()
+	if err != nil {
+		os.RemoveAll(baseDir)
+		return nil, err
+	}
+
+	h := &Harness{
+		Rules:   consensus.NewRegtestRules(),
+		Genesis: genesis,
+		Clock:   clock.Real,
+		baseDir: baseDir,
+	}
+
+	for i := 0; i < numNodes; i++ {
+		node, err := newTestNode(h, i, filepath.Join(baseDir, fmt.Sprintf("node%d", i)), configure)
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("failed to create node %d: %w", i, err)
+		}
+		h.Nodes = append(h.Nodes, node)
+	}
+
+	return h, nil
+}
+
+// SetClock switches every node to the given time source, e.g. a
+// clock.Fake so mempool expiry can be tested without waiting
+func (h *Harness) SetClock(c clock.Clock) {
+	h.Clock = c
+	for _, node := range h.Nodes {
+		node.P2P.SetClock(c)
+	}
+}
+
+// Node returns the i-th node
+func (h *Harness) Node(i int) *TestNode {
+	return h.Nodes[i]
+}
+
+// Connect opens an outbound connection from node i to node j and waits for
+// both sides to register the peer
+func (h *Harness) Connect(i, j int, timeout time.Duration) error {
+	from, to := h.Nodes[i], h.Nodes[j]
+	fromPeers, toPeers := from.P2P.PeerCount(), to.P2P.PeerCount()
+
+	go from.P2P.Connect(to.P2P.Addr())
+
+	return waitFor(timeout, func() bool {
+		return from.P2P.PeerCount() > fromPeers && to.P2P.PeerCount() > toPeers
+	}, fmt.Sprintf("node%d to connect to node%d", i, j))
+}
+
+// ConnectAll connects the nodes in a line: 0-1, 1-2, ...
+func (h *Harness) ConnectAll(timeout time.Duration) error {
+	for i := 0; i+1 < len(h.Nodes); i++ {
+		if err := h.Connect(i, i+1, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitForSync waits until all nodes have the same best block
+func (h *Harness) WaitForSync(timeout time.Duration) error {
+	return waitFor(timeout, func() bool {
+		first, err := h.Nodes[0].BestHash()
+		if err != nil {
+			return false
+		}
+		for _, node := range h.Nodes[1:] {
+			hash, err := node.BestHash()
+			if err != nil || hash != first {
+				return false
+			}
+		}
+		return true
+	}, "nodes to sync")
+}
+
+// WaitForMempool waits until every node has txHash in its mempool
+func (h *Harness) WaitForMempool(txHash types.Hash, timeout time.Duration) error {
+	return waitFor(timeout, func() bool {
+		for _, node := range h.Nodes {
+			if !node.P2P.Mempool.Exists(txHash) {
+				return false
+			}
+		}
+		return true
+	}, fmt.Sprintf("transaction %s to reach all mempools", txHash))
+}
+
+// Close stops all nodes and removes their data directories
+func (h *Harness) Close() error {
+	for _, node := range h.Nodes {
+		node.stop()
+	}
+	return os.RemoveAll(h.baseDir)
+}
+
+// waitFor polls cond until it returns true or the timeout expires
+func waitFor(timeout time.Duration, cond func() bool, what string) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for %s", timeout, what)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// createGenesisBlock builds the deterministic genesis block shared by all nodes
+func createGenesisBlock() (*types.Block, error) {
+	coinbase, err := mining.CreateCoinbase(0, 0, "regtest-genesis", 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create genesis coinbase: %w", err)
+	}
+
+	template := &mining.BlockTemplate{
+		Version:      1,
+		Transactions: []types.Transaction{*coinbase},
+		Timestamp:    GenesisTimestamp,
+		Bits:         RegtestBits,
+	}
+
+	return mining.BuildBlock(template, 0)
+}
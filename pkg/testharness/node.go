@@ -0,0 +1,288 @@
+package testharness
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// TestNode is a single in-process node managed by the harness
+type TestNode struct {
+	ID      int
+	DataDir string
+	Chain   *storage.BlockchainStorage
+	P2P     *network.Node
+	Wallet  *wallet.Wallet
+	Address string // Default mining/receiving address
+
+	harness *Harness
+
+	// Wallet scanning state
+	mu          sync.Mutex
+	scannedTip  types.Hash
+	scannedNext uint64
+}
+
+// newTestNode creates storage, wallet and P2P node and starts listening.
+// configure, if set, adjusts the P2P config before the node starts.
+func newTestNode(h *Harness, id int, dataDir string, configure func(id int, config *network.NodeConfig)) (*TestNode, error) {
+	chain, err := storage.NewBlockchainStorage(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := chain.SaveBlock(h.Genesis, 0); err != nil {
+		chain.Close()
+		return nil, fmt.Errorf("failed to save genesis: %w", err)
+	}
+
+	w := wallet.NewWallet()
+	address, err := w.GenerateAddress()
+	if err != nil {
+		chain.Close()
+		return nil, fmt.Errorf("failed to generate address: %w", err)
+	}
+
+	config := network.NodeConfig{
+		ListenAddr:         "127.0.0.1:0",
+		UserAgent:          fmt.Sprintf("testharness-node%d", id),
+		InvTrickleInterval: InvTrickleInterval,
+	}
+	if configure != nil {
+		configure(id, &config)
+	}
+	p2p := network.NewNode(config, chain)
+	p2p.SyncManager.SetRules(h.Rules)
+	p2p.Mempool.SetRemovalHandler(func(txHash types.Hash, _ mempool.RemovalReason) {
+		w.TransactionDropped(txHash)
+	})
+
+	if err := p2p.Start(); err != nil {
+		chain.Close()
+		return nil, err
+	}
+
+	return &TestNode{
+		ID:      id,
+		DataDir: dataDir,
+		Chain:   chain,
+		P2P:     p2p,
+		Wallet:  w,
+		Address: address,
+		harness: h,
+	}, nil
+}
+
+// BestHash returns the hash of the node's chain tip
+func (n *TestNode) BestHash() (types.Hash, error) {
+	return n.Chain.GetBestBlockHash()
+}
+
+// Height returns the node's chain height
+func (n *TestNode) Height() (uint64, error) {
+	return n.Chain.GetBestBlockHeight()
+}
+
+// MineBlocks mines count blocks on top of the node's tip, paying the
+// coinbase to the node's address and including mempool transactions.
+// Blocks are announced to connected peers.
+func (n *TestNode) MineBlocks(count int) ([]*types.Block, error) {
+	blocks := make([]*types.Block, 0, count)
+
+	for i := 0; i < count; i++ {
+		block, err := n.mineBlock()
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// mineBlock assembles and stores a single block
+func (n *TestNode) mineBlock() (*types.Block, error) {
+	prevBlock, prevHeight, err := n.Chain.GetBestBlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tip: %w", err)
+	}
+	prevHash, err := n.Chain.GetBlockHash(prevBlock)
+	if err != nil {
+		return nil, err
+	}
+	height := prevHeight + 1
+	bits, err := n.harness.Rules.NextWorkRequired(n.Chain, height)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get target: %w", err)
+	}
+
+	// Collect mempool transactions (parents before children)
+	selected, err := mempool.NewPriorityQueue(n.P2P.Mempool).SelectTransactionsWithDependencies(validation.MaxBlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select transactions: %w", err)
+	}
+
+	fees := int64(0)
+	txs := make([]types.Transaction, 0, len(selected)+1)
+	for _, tx := range selected {
+		txHash, _ := serialization.HashTransaction(tx)
+		if entry, err := n.P2P.Mempool.Get(txHash); err == nil {
+			fees += entry.Fee
+		}
+		txs = append(txs, *tx)
+	}
+
+	// Height in the coinbase keeps coinbase txids unique
+	reward := int64(n.harness.Rules.GetBlockSubsidy(height)) + fees
+	coinbase, err := transaction.CreateCoinbase(height, reward, n.Address, []byte(fmt.Sprintf("node%d", n.ID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create coinbase: %w", err)
+	}
+	txs = append([]types.Transaction{*coinbase}, txs...)
+
+	timestamp := uint32(n.harness.Clock.Now().Unix())
+	if timestamp <= prevBlock.Header.Timestamp {
+		timestamp = prevBlock.Header.Timestamp + 1
+	}
+
+	template := &mining.BlockTemplate{
+		Version:       1,
+		PrevBlockHash: prevHash,
+		Transactions:  txs,
+		Timestamp:     timestamp,
+		Bits:          bits,
+		Height:        height,
+		TotalFees:     fees,
+	}
+
+	// Regtest's target is met within a couple of nonces
+	block, err := mining.SolveBlock(template)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.Chain.SaveBlock(block, height); err != nil {
+		return nil, fmt.Errorf("failed to save block: %w", err)
+	}
+
+	n.P2P.Mempool.RemoveConfirmed(block.Transactions)
+	n.P2P.BroadcastBlock(block)
+
+	return block, nil
+}
+
+// SendTo creates, signs and broadcasts a payment from the node's wallet
+func (n *TestNode) SendTo(address string, amount int64, fee int64) (*types.Transaction, error) {
+	if err := n.ScanWallet(); err != nil {
+		return nil, err
+	}
+
+	tx, err := n.Wallet.SendWithFee(address, amount, fee)
+	if err != nil {
+		return nil, err
+	}
+	return tx, n.broadcastPayment(tx)
+}
+
+// SendToScript is like SendTo but pays a locking script, e.g. a contract
+func (n *TestNode) SendToScript(pkScript []byte, amount int64, fee int64) (*types.Transaction, error) {
+	if err := n.ScanWallet(); err != nil {
+		return nil, err
+	}
+
+	tx, err := n.Wallet.SendToScript(pkScript, amount, fee)
+	if err != nil {
+		return nil, err
+	}
+	return tx, n.broadcastPayment(tx)
+}
+
+// broadcastPayment relays a wallet payment and takes its coins out of the
+// wallet
+func (n *TestNode) broadcastPayment(tx *types.Transaction) error {
+	if err := n.P2P.BroadcastTransaction(tx); err != nil {
+		return fmt.Errorf("failed to broadcast transaction: %w", err)
+	}
+
+	// Don't pick the same coins for the next payment
+	for _, input := range tx.Inputs {
+		n.Wallet.RemoveUTXO(utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex))
+	}
+
+	return nil
+}
+
+// Balance returns the confirmed wallet balance after scanning new blocks
+func (n *TestNode) Balance() (int64, error) {
+	if err := n.ScanWallet(); err != nil {
+		return 0, err
+	}
+	return n.Wallet.GetBalance(), nil
+}
+
+// ScanWallet feeds blocks the wallet hasn't seen yet into it.
+// Only forward progress is handled; reorgs are not undone in the wallet.
+func (n *TestNode) ScanWallet() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	tip, err := n.Chain.GetBestBlockHash()
+	if err != nil {
+		return err
+	}
+	if tip == n.scannedTip {
+		return nil
+	}
+
+	bestHeight, err := n.Chain.GetBestBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	for height := n.scannedNext; height <= bestHeight; height++ {
+		block, err := n.Chain.GetBlockByHeight(height)
+		if err != nil {
+			return fmt.Errorf("failed to load block %d: %w", height, err)
+		}
+
+		for i := range block.Transactions {
+			tx := &block.Transactions[i]
+			txHash, err := serialization.HashTransaction(tx)
+			if err != nil {
+				return err
+			}
+
+			isCoinbase := transaction.IsCoinbase(tx)
+			if !isCoinbase {
+				for _, input := range tx.Inputs {
+					n.Wallet.RemoveUTXO(utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex))
+				}
+			}
+
+			for index, output := range tx.Outputs {
+				n.Wallet.AddUTXO(utxo.NewUTXO(txHash, uint32(index), output, height, isCoinbase))
+			}
+		}
+	}
+
+	n.scannedTip = tip
+	n.scannedNext = bestHeight + 1
+
+	return nil
+}
+
+// stop shuts the node down and closes its storage
+func (n *TestNode) stop() {
+	n.P2P.Stop()
+	n.Chain.Close()
+}
@@ -8,12 +8,23 @@ import (
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
 
+const (
+	// SequenceFinal is the default input sequence, with no relative lock
+	// time and no replacement signal
+	SequenceFinal uint32 = 0xFFFFFFFF
+
+	// MaxRBFSequence is the highest input sequence that signals opt-in
+	// replace-by-fee (BIP125)
+	MaxRBFSequence uint32 = 0xFFFFFFFD
+)
+
 // TxBuilder helps construct transactions
 type TxBuilder struct {
 	version  int32
 	inputs   []types.TxInput
 	outputs  []types.TxOutput
 	lockTime uint32
+	rbf      bool
 }
 
 // NewTxBuilder creates a new transaction builder
@@ -32,7 +43,7 @@ func (b *TxBuilder) AddInput(prevTxHash types.Hash, outputIndex uint32) *TxBuild
 		PrevTxHash:      prevTxHash,
 		OutputIndex:     outputIndex,
 		SignatureScript: nil, // Will be filled when signing
-		Sequence:        0xFFFFFFFF,
+		Sequence:        SequenceFinal,
 	}
 
 	b.inputs = append(b.inputs, input)
@@ -58,6 +69,10 @@ func (b *TxBuilder) AddP2PKHOutput(value int64, address string) (*TxBuilder, err
 		return b, fmt.Errorf("invalid address: %w", err)
 	}
 
+	if !addr.IsP2PKH() {
+		return b, fmt.Errorf("not a P2PKH address: %s", address)
+	}
+
 	// Create P2PKH script
 	scriptPubKey, err := script.P2PKH(addr.Hash())
 	if err != nil {
@@ -73,6 +88,13 @@ func (b *TxBuilder) SetLockTime(lockTime uint32) *TxBuilder {
 	return b
 }
 
+// SetRBF makes the transaction signal that it may be replaced by one
+// paying a higher fee (BIP125)
+func (b *TxBuilder) SetRBF(enabled bool) *TxBuilder {
+	b.rbf = enabled
+	return b
+}
+
 // Build creates the unsigned transaction
 func (b *TxBuilder) Build() (*types.Transaction, error) {
 	if len(b.inputs) == 0 {
@@ -90,42 +112,93 @@ func (b *TxBuilder) Build() (*types.Transaction, error) {
 		LockTime: b.lockTime,
 	}
 
+	if b.rbf {
+		for i := range tx.Inputs {
+			if tx.Inputs[i].Sequence > MaxRBFSequence {
+				tx.Inputs[i].Sequence = MaxRBFSequence
+			}
+		}
+	}
+
 	return tx, nil
 }
 
+// SignalsRBF reports whether any input of tx opts in to replace-by-fee
+func SignalsRBF(tx *types.Transaction) bool {
+	for _, input := range tx.Inputs {
+		if input.Sequence <= MaxRBFSequence {
+			return true
+		}
+	}
+	return false
+}
+
 // SignInput signs a specific input
 func SignInput(tx *types.Transaction, inputIdx int, privKey *keys.PrivateKey, prevScript []byte, hashType SigHashType) error {
+	sigBytes, err := Sign(tx, inputIdx, privKey, prevScript, hashType)
+	if err != nil {
+		return err
+	}
+
+	// Get public key
+	pubKey := privKey.PublicKey()
+	pubKeyBytes := pubKey.Bytes(true)
+
+	// Create unlocking script
+	unlockingScript := script.P2PKHUnlockingScript(sigBytes, pubKeyBytes)
+
+	// Set the signature script
+	tx.Inputs[inputIdx].SignatureScript = unlockingScript
+
+	return nil
+}
+
+// Sign returns a signature for an input spending an output locked by
+// prevScript, as it appears in a scriptSig: DER followed by the hash type
+func Sign(tx *types.Transaction, inputIdx int, privKey *keys.PrivateKey, prevScript []byte, hashType SigHashType) ([]byte, error) {
 	if inputIdx < 0 || inputIdx >= len(tx.Inputs) {
-		return fmt.Errorf("invalid input index: %d", inputIdx)
+		return nil, fmt.Errorf("invalid input index: %d", inputIdx)
 	}
 
 	// Calculate signature hash
 	sigHash, err := CalcSignatureHash(tx, inputIdx, prevScript, hashType)
 	if err != nil {
-		return fmt.Errorf("failed to calculate signature hash: %w", err)
+		return nil, fmt.Errorf("failed to calculate signature hash: %w", err)
 	}
 
 	// Sign the hash
 	signature, err := privKey.Sign(sigHash)
 	if err != nil {
-		return fmt.Errorf("failed to sign: %w", err)
+		return nil, fmt.Errorf("failed to sign: %w", err)
 	}
 
 	// Append hash type to signature (DER + hash type byte)
-	sigBytes := signature.Serialize()
-	sigBytes = append(sigBytes, byte(hashType))
-
-	// Get public key
-	pubKey := privKey.PublicKey()
-	pubKeyBytes := pubKey.Bytes(true)
-
-	// Create unlocking script
-	unlockingScript := script.P2PKHUnlockingScript(sigBytes, pubKeyBytes)
-
-	// Set the signature script
-	tx.Inputs[inputIdx].SignatureScript = unlockingScript
+	return append(signature.Serialize(), byte(hashType)), nil
+}
 
-	return nil
+// SignatureChecker returns a checker for the script engine that verifies
+// signatures made by Sign for an input spending an output locked by
+// prevScript
+func SignatureChecker(tx *types.Transaction, inputIdx int, prevScript []byte) script.SigChecker {
+	return func(sig, pubKey []byte) bool {
+		if len(sig) < 2 {
+			return false
+		}
+		hashType := SigHashType(sig[len(sig)-1])
+		sigHash, err := CalcSignatureHash(tx, inputIdx, prevScript, hashType)
+		if err != nil {
+			return false
+		}
+		signature, err := keys.ParseSignature(sig[:len(sig)-1])
+		if err != nil {
+			return false
+		}
+		key, err := keys.ParsePublicKey(pubKey)
+		if err != nil {
+			return false
+		}
+		return key.Verify(sigHash, signature)
+	}
 }
 
 // CreateCoinbase creates a coinbase transaction
@@ -197,8 +270,9 @@ func CalculateSize(numInputs, numOutputs int) int {
 	return size
 }
 
-// EstimateFee estimates transaction fee based on size and fee rate
-func EstimateFee(numInputs, numOutputs int, feePerByte int64) int64 {
+// EstimateFee estimates transaction fee based on size and a fee rate in
+// sat/kvB
+func EstimateFee(numInputs, numOutputs int, feeRate int64) int64 {
 	size := CalculateSize(numInputs, numOutputs)
-	return int64(size) * feePerByte
+	return FeeAtRate(feeRate, int64(size))
 }
@@ -0,0 +1,32 @@
+package transaction
+
+import "fmt"
+
+// FeeRateScale is how many sat/kvB make one sat/vB. Fee rates are kept in
+// satoshis per 1000 virtual bytes, so 1.9 sat/vB is 1900 rather than
+// rounding down to 1 and comparing equal to a transaction paying less.
+const FeeRateScale = 1000
+
+// FeeRate returns the fee rate, in sat/kvB, of fee paid for vsize virtual
+// bytes, rounded down
+func FeeRate(fee, vsize int64) int64 {
+	if vsize <= 0 {
+		return 0
+	}
+	return fee * FeeRateScale / vsize
+}
+
+// FeeAtRate returns the fee paying feeRate sat/kvB for vsize virtual
+// bytes, rounded up so the transaction's rate is at least feeRate
+func FeeAtRate(feeRate, vsize int64) int64 {
+	return (feeRate*vsize + FeeRateScale - 1) / FeeRateScale
+}
+
+// FormatFeeRate formats a sat/kvB fee rate in sat/vB, e.g. "1.900 sat/vB"
+func FormatFeeRate(feeRate int64) string {
+	sign := ""
+	if feeRate < 0 {
+		sign, feeRate = "-", -feeRate
+	}
+	return fmt.Sprintf("%s%d.%03d sat/vB", sign, feeRate/FeeRateScale, feeRate%FeeRateScale)
+}
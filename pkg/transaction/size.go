@@ -0,0 +1,67 @@
+package transaction
+
+import (
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// WitnessScaleFactor is how much more a non-witness byte weighs than a
+// witness byte (BIP141)
+const WitnessScaleFactor = 4
+
+// StrippedSize returns the size in bytes of the transaction serialized
+// without witness data, the serialization its txid commits to
+func StrippedSize(tx *types.Transaction) int {
+	// Version and locktime
+	size := 4 + 4
+
+	size += serialization.VarIntSize(uint64(len(tx.Inputs)))
+	for _, input := range tx.Inputs {
+		// Previous outpoint, script and sequence
+		size += 32 + 4 + scriptSize(input.SignatureScript) + 4
+	}
+
+	size += serialization.VarIntSize(uint64(len(tx.Outputs)))
+	for _, output := range tx.Outputs {
+		// Value and script
+		size += 8 + scriptSize(output.PubKeyScript)
+	}
+
+	return size
+}
+
+// SerializedSize returns the size in bytes of the transaction serialized
+// with its witness data, as it is relayed and stored
+func SerializedSize(tx *types.Transaction) int {
+	size := StrippedSize(tx)
+	if !tx.HasWitness() {
+		return size
+	}
+
+	// Marker and flag, then one stack per input
+	size += 2
+	for _, input := range tx.Inputs {
+		size += serialization.VarIntSize(uint64(len(input.Witness)))
+		for _, item := range input.Witness {
+			size += scriptSize(item)
+		}
+	}
+	return size
+}
+
+// Weight returns the BIP141 weight of the transaction: three times its
+// stripped size plus its full size
+func Weight(tx *types.Transaction) int {
+	return StrippedSize(tx)*(WitnessScaleFactor-1) + SerializedSize(tx)
+}
+
+// VirtualSize returns the transaction's weight in virtual bytes, rounded
+// up. Fee rates and policy size limits are measured in these.
+func VirtualSize(tx *types.Transaction) int {
+	return (Weight(tx) + WitnessScaleFactor - 1) / WitnessScaleFactor
+}
+
+// scriptSize is the size of a length-prefixed byte string
+func scriptSize(data []byte) int {
+	return serialization.VarIntSize(uint64(len(data))) + len(data)
+}
@@ -0,0 +1,275 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// SigHashDefault is the taproot hash type of a 64-byte signature. It
+// signs what SigHashAll does.
+const SigHashDefault SigHashType = 0x00
+
+var (
+	ErrTaprootSigHashType = errors.New("invalid taproot signature hash type")
+	ErrTaprootCommitment  = errors.New("control block does not commit to the output key")
+)
+
+// TaprootSpendContext is what a taproot signature commits to beyond the
+// transaction: every output the transaction spends, and for a script path
+// spend the leaf being run
+type TaprootSpendContext struct {
+	PrevOuts []types.TxOutput // Spent by each input, in order
+	LeafHash []byte           // nil for a key path spend
+	Annex    []byte           // nil without one
+}
+
+// CalcTaprootSignatureHash computes the BIP341 signature hash for a
+// taproot input. Unlike the earlier hashes it commits to the amount and
+// script of every input, so a signer can tell the fee from the hash
+// alone.
+func CalcTaprootSignatureHash(tx *types.Transaction, inputIdx int, ctx *TaprootSpendContext, hashType SigHashType) ([]byte, error) {
+	if inputIdx < 0 || inputIdx >= len(tx.Inputs) {
+		return nil, fmt.Errorf("invalid input index: %d", inputIdx)
+	}
+	if len(ctx.PrevOuts) != len(tx.Inputs) {
+		return nil, fmt.Errorf("%d spent outputs for %d inputs", len(ctx.PrevOuts), len(tx.Inputs))
+	}
+	baseType := hashType & 0x03
+	anyoneCanPay := hashType&SigHashAnyOneCanPay != 0
+	if hashType != SigHashDefault && (hashType&^(SigHashAnyOneCanPay|0x03) != 0 || baseType == 0) {
+		return nil, fmt.Errorf("%w: 0x%02x", ErrTaprootSigHashType, uint32(hashType))
+	}
+	if baseType == SigHashSingle && inputIdx >= len(tx.Outputs) {
+		return nil, fmt.Errorf("%w: SIGHASH_SINGLE input %d has no output", ErrTaprootSigHashType, inputIdx)
+	}
+
+	var msg bytes.Buffer
+	msg.WriteByte(0x00) // Epoch
+	msg.WriteByte(byte(hashType))
+	serialization.WriteInt32(&msg, tx.Version)
+	serialization.WriteUint32(&msg, tx.LockTime)
+
+	if !anyoneCanPay {
+		var prevouts, amounts, scripts, sequences bytes.Buffer
+		for i, input := range tx.Inputs {
+			prevouts.Write(input.PrevTxHash[:])
+			serialization.WriteUint32(&prevouts, input.OutputIndex)
+			serialization.WriteUint64(&amounts, uint64(ctx.PrevOuts[i].Value))
+			serialization.WriteBytes(&scripts, ctx.PrevOuts[i].PubKeyScript)
+			serialization.WriteUint32(&sequences, input.Sequence)
+		}
+		for _, data := range []*bytes.Buffer{&prevouts, &amounts, &scripts, &sequences} {
+			hash := sha256.Sum256(data.Bytes())
+			msg.Write(hash[:])
+		}
+	}
+	if baseType != SigHashNone && baseType != SigHashSingle {
+		var outputs bytes.Buffer
+		for _, output := range tx.Outputs {
+			writeOutput(&outputs, output)
+		}
+		hash := sha256.Sum256(outputs.Bytes())
+		msg.Write(hash[:])
+	}
+
+	spendType := byte(0)
+	if ctx.LeafHash != nil {
+		spendType |= 2
+	}
+	if ctx.Annex != nil {
+		spendType |= 1
+	}
+	msg.WriteByte(spendType)
+
+	input := tx.Inputs[inputIdx]
+	if anyoneCanPay {
+		msg.Write(input.PrevTxHash[:])
+		serialization.WriteUint32(&msg, input.OutputIndex)
+		writeOutput(&msg, ctx.PrevOuts[inputIdx])
+		serialization.WriteUint32(&msg, input.Sequence)
+	} else {
+		serialization.WriteUint32(&msg, uint32(inputIdx))
+	}
+	if ctx.Annex != nil {
+		var annex bytes.Buffer
+		serialization.WriteBytes(&annex, ctx.Annex)
+		hash := sha256.Sum256(annex.Bytes())
+		msg.Write(hash[:])
+	}
+	if baseType == SigHashSingle {
+		var output bytes.Buffer
+		writeOutput(&output, tx.Outputs[inputIdx])
+		hash := sha256.Sum256(output.Bytes())
+		msg.Write(hash[:])
+	}
+
+	// Script path extension (BIP342). There is no OP_CODESEPARATOR, so
+	// the last one's position is always "none".
+	if ctx.LeafHash != nil {
+		msg.Write(ctx.LeafHash)
+		msg.WriteByte(0x00) // Key version
+		serialization.WriteUint32(&msg, 0xffffffff)
+	}
+
+	hash := crypto.TaggedHash("TapSighash", msg.Bytes())
+	return hash[:], nil
+}
+
+// SignTaproot returns a BIP340 signature for a taproot input as it
+// appears in the witness: 64 bytes for SigHashDefault, else followed by
+// the hash type. For a key path spend key must already be tweaked.
+func SignTaproot(tx *types.Transaction, inputIdx int, ctx *TaprootSpendContext, key *keys.PrivateKey, hashType SigHashType) ([]byte, error) {
+	sigHash, err := CalcTaprootSignatureHash(tx, inputIdx, ctx, hashType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate signature hash: %w", err)
+	}
+	sig, err := key.SignSchnorr(sigHash, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	if hashType != SigHashDefault {
+		sig = append(sig, byte(hashType))
+	}
+	return sig, nil
+}
+
+// TaprootSignatureChecker returns a checker for tapscript signature
+// opcodes, verifying signatures made by SignTaproot
+func TaprootSignatureChecker(tx *types.Transaction, inputIdx int, ctx *TaprootSpendContext) script.SigChecker {
+	return taprootSignatureChecker(tx, inputIdx, ctx, nil)
+}
+
+// taprootSignatureChecker is TaprootSignatureChecker queueing signatures
+// in batch, if given, instead of verifying them
+func taprootSignatureChecker(tx *types.Transaction, inputIdx int, ctx *TaprootSpendContext, batch *keys.SchnorrBatch) script.SigChecker {
+	return func(sig, pubKey []byte) bool {
+		return verifyTaprootSig(tx, inputIdx, ctx, sig, pubKey, batch) == nil
+	}
+}
+
+// verifyTaprootSig checks one witness signature: 64 bytes with the
+// default hash type, or 65 with an explicit one. With a batch only what
+// can be checked alone is, and the signature is queued.
+func verifyTaprootSig(tx *types.Transaction, inputIdx int, ctx *TaprootSpendContext, sig, pubKey []byte, batch *keys.SchnorrBatch) error {
+	hashType := SigHashDefault
+	switch len(sig) {
+	case keys.SchnorrSigSize:
+	case keys.SchnorrSigSize + 1:
+		hashType = SigHashType(sig[keys.SchnorrSigSize])
+		if hashType == SigHashDefault {
+			return fmt.Errorf("%w: explicit default", ErrTaprootSigHashType)
+		}
+		sig = sig[:keys.SchnorrSigSize]
+	default:
+		return fmt.Errorf("%w: %d bytes", script.ErrSchnorrSig, len(sig))
+	}
+
+	sigHash, err := CalcTaprootSignatureHash(tx, inputIdx, ctx, hashType)
+	if err != nil {
+		return err
+	}
+	if batch != nil {
+		if !batch.Add(pubKey, sigHash, sig) {
+			return script.ErrSchnorrSig
+		}
+		return nil
+	}
+	if !keys.VerifySchnorr(pubKey, sigHash, sig) {
+		return script.ErrSchnorrSig
+	}
+	return nil
+}
+
+// VerifyTaprootInput checks input inputIdx of tx spending a taproot
+// output (BIP341). prevOuts are the outputs every input spends. A single
+// witness item is a signature by the output key. Otherwise the last item
+// is a control block proving the one before is a leaf of the output's
+// script tree, and the leaf is run with the rest as its stack. Leaf
+// versions other than tapscript are left for future soft forks and
+// succeed.
+func VerifyTaprootInput(tx *types.Transaction, inputIdx int, prevOuts []types.TxOutput) error {
+	return VerifyTaprootInputBatch(tx, inputIdx, prevOuts, nil)
+}
+
+// VerifyTaprootInputBatch is VerifyTaprootInput with the signatures
+// queued in batch rather than verified; the input is only valid once
+// batch.Verify succeeds too. That is sound because a taproot signature
+// check never just returns false: an empty signature is skipped and any
+// other must be valid, so whether the script succeeds doesn't depend on
+// the result.
+func VerifyTaprootInputBatch(tx *types.Transaction, inputIdx int, prevOuts []types.TxOutput, batch *keys.SchnorrBatch) error {
+	if inputIdx < 0 || inputIdx >= len(tx.Inputs) || len(prevOuts) != len(tx.Inputs) {
+		return fmt.Errorf("invalid input index: %d", inputIdx)
+	}
+	version, program, ok := script.ExtractWitnessProgram(prevOuts[inputIdx].PubKeyScript)
+	if !ok || version != 1 || len(program) != keys.XOnlyPubKeySize {
+		return fmt.Errorf("output is not a taproot output")
+	}
+	input := tx.Inputs[inputIdx]
+	if len(input.SignatureScript) != 0 {
+		return script.ErrWitnessMalleated
+	}
+
+	witness := input.Witness
+	if len(witness) == 0 {
+		return script.ErrWitnessProgramEmpty
+	}
+	ctx := &TaprootSpendContext{PrevOuts: prevOuts}
+	if last := witness[len(witness)-1]; len(witness) >= 2 && len(last) > 0 && last[0] == script.TaprootAnnexTag {
+		ctx.Annex = last
+		witness = witness[:len(witness)-1]
+	}
+
+	// Key path
+	if len(witness) == 1 {
+		return verifyTaprootSig(tx, inputIdx, ctx, witness[0], program, batch)
+	}
+
+	// Script path
+	leafScript := witness[len(witness)-2]
+	control, err := script.ParseControlBlock(witness[len(witness)-1])
+	if err != nil {
+		return err
+	}
+	internalKey, err := keys.ParseXOnlyPublicKey(control.InternalKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", script.ErrControlBlock, err)
+	}
+	outputKey, err := keys.TaprootOutputKey(internalKey, control.RootHash(leafScript))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(outputKey.XOnly(), program) || outputKey.HasOddY() != control.OutputKeyYIsOdd {
+		return ErrTaprootCommitment
+	}
+	if control.LeafVersion != script.TapscriptLeafVersion {
+		return nil
+	}
+
+	ctx.LeafHash = script.TapLeafHash(control.LeafVersion, leafScript)
+	engine, err := script.NewTapscriptEngine(leafScript, witness[:len(witness)-2], witnessSize(input.Witness))
+	if err != nil {
+		return err
+	}
+	engine.SetTransaction(tx, inputIdx)
+	engine.SetSigChecker(taprootSignatureChecker(tx, inputIdx, ctx, batch))
+	return engine.Execute()
+}
+
+// witnessSize is the serialized size of a witness, item count included
+func witnessSize(witness [][]byte) int {
+	var buf bytes.Buffer
+	serialization.WriteVarInt(&buf, uint64(len(witness)))
+	for _, item := range witness {
+		serialization.WriteBytes(&buf, item)
+	}
+	return buf.Len()
+}
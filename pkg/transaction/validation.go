@@ -3,8 +3,8 @@ package transaction
 import (
 	"fmt"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
-	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
 
@@ -52,14 +52,9 @@ func ValidateTransaction(tx *types.Transaction) error {
 	}
 
 	// Rule 6: Transaction size check (simplified)
-	serialized, err := serialization.SerializeTransaction(tx)
-	if err != nil {
-		return fmt.Errorf("failed to serialize transaction: %w", err)
-	}
-
 	const maxTxSize = 100000 // 100KB
-	if len(serialized) > maxTxSize {
-		return fmt.Errorf("transaction too large: %d bytes", len(serialized))
+	if size := StrippedSize(tx); size > maxTxSize {
+		return fmt.Errorf("transaction too large: %d bytes", size)
 	}
 
 	return nil
@@ -73,6 +68,22 @@ func ValidateTransactionScripts(tx *types.Transaction, prevOutputs []types.TxOut
 	}
 
 	for i, input := range tx.Inputs {
+		// Version 0 witness programs and taproot outputs are spent by the
+		// witness
+		version, program, ok := script.ExtractWitnessProgram(prevOutputs[i].PubKeyScript)
+		switch {
+		case ok && version == 0:
+			if err := VerifyWitnessInput(tx, i, prevOutputs[i]); err != nil {
+				return fmt.Errorf("input %d witness validation failed: %w", i, err)
+			}
+			continue
+		case ok && version == 1 && len(program) == keys.XOnlyPubKeySize:
+			if err := VerifyTaprootInput(tx, i, prevOutputs); err != nil {
+				return fmt.Errorf("input %d taproot validation failed: %w", i, err)
+			}
+			continue
+		}
+
 		// Get the locking script from previous output
 		lockingScript := prevOutputs[i].PubKeyScript
 
@@ -90,11 +101,8 @@ func ValidateTransactionScripts(tx *types.Transaction, prevOutputs []types.TxOut
 
 // validateScript executes unlocking + locking script
 func validateScript(unlocking, locking []byte, tx *types.Transaction, inputIdx int) error {
-	// Combine scripts: unlocking + locking
-	combined := append(unlocking, locking...)
-
 	// Create engine with transaction context
-	engine := script.NewEngine(combined)
+	engine := script.NewInputEngine(unlocking, locking)
 	engine.SetTransaction(tx, inputIdx)
 
 	// Execute
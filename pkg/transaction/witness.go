@@ -0,0 +1,151 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// CalcWitnessSignatureHash computes the BIP143 signature hash for a
+// version 0 witness input spending amount. scriptCode is the witness
+// script of a P2WSH input, or the P2PKH script of a P2WPKH key hash.
+// Unlike the legacy hash it commits to the amount, and hashing the
+// prevouts, sequences and outputs once keeps it linear in transaction
+// size.
+func CalcWitnessSignatureHash(tx *types.Transaction, inputIdx int, scriptCode []byte, amount int64, hashType SigHashType) ([]byte, error) {
+	if inputIdx < 0 || inputIdx >= len(tx.Inputs) {
+		return nil, fmt.Errorf("invalid input index: %d", inputIdx)
+	}
+	baseType := hashType & 0x1f
+	if baseType < SigHashAll || baseType > SigHashSingle {
+		return nil, fmt.Errorf("unsupported signature hash type: %d", hashType)
+	}
+	anyoneCanPay := hashType&SigHashAnyOneCanPay != 0
+
+	var hashPrevouts, hashSequence, hashOutputs [32]byte
+	if !anyoneCanPay {
+		var prevouts bytes.Buffer
+		for _, input := range tx.Inputs {
+			prevouts.Write(input.PrevTxHash[:])
+			serialization.WriteUint32(&prevouts, input.OutputIndex)
+		}
+		hashPrevouts = doubleSHA256(prevouts.Bytes())
+	}
+	if !anyoneCanPay && baseType == SigHashAll {
+		var sequences bytes.Buffer
+		for _, input := range tx.Inputs {
+			serialization.WriteUint32(&sequences, input.Sequence)
+		}
+		hashSequence = doubleSHA256(sequences.Bytes())
+	}
+	switch {
+	case baseType == SigHashAll:
+		var outputs bytes.Buffer
+		for _, output := range tx.Outputs {
+			writeOutput(&outputs, output)
+		}
+		hashOutputs = doubleSHA256(outputs.Bytes())
+	case baseType == SigHashSingle && inputIdx < len(tx.Outputs):
+		var output bytes.Buffer
+		writeOutput(&output, tx.Outputs[inputIdx])
+		hashOutputs = doubleSHA256(output.Bytes())
+	}
+
+	input := tx.Inputs[inputIdx]
+	var preimage bytes.Buffer
+	serialization.WriteInt32(&preimage, tx.Version)
+	preimage.Write(hashPrevouts[:])
+	preimage.Write(hashSequence[:])
+	preimage.Write(input.PrevTxHash[:])
+	serialization.WriteUint32(&preimage, input.OutputIndex)
+	serialization.WriteBytes(&preimage, scriptCode)
+	serialization.WriteUint64(&preimage, uint64(amount))
+	serialization.WriteUint32(&preimage, input.Sequence)
+	preimage.Write(hashOutputs[:])
+	serialization.WriteUint32(&preimage, tx.LockTime)
+	serialization.WriteUint32(&preimage, uint32(hashType))
+
+	hash := doubleSHA256(preimage.Bytes())
+	return hash[:], nil
+}
+
+// SignWitness returns a signature for a version 0 witness input, as it
+// appears in the witness: DER followed by the hash type
+func SignWitness(tx *types.Transaction, inputIdx int, privKey *keys.PrivateKey, scriptCode []byte, amount int64, hashType SigHashType) ([]byte, error) {
+	sigHash, err := CalcWitnessSignatureHash(tx, inputIdx, scriptCode, amount, hashType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate signature hash: %w", err)
+	}
+
+	signature, err := privKey.Sign(sigHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	return append(signature.Serialize(), byte(hashType)), nil
+}
+
+// WitnessSignatureChecker returns a checker for the script engine that
+// verifies signatures made by SignWitness
+func WitnessSignatureChecker(tx *types.Transaction, inputIdx int, scriptCode []byte, amount int64) script.SigChecker {
+	return func(sig, pubKey []byte) bool {
+		if len(sig) < 2 {
+			return false
+		}
+		hashType := SigHashType(sig[len(sig)-1])
+		sigHash, err := CalcWitnessSignatureHash(tx, inputIdx, scriptCode, amount, hashType)
+		if err != nil {
+			return false
+		}
+		signature, err := keys.ParseSignature(sig[:len(sig)-1])
+		if err != nil {
+			return false
+		}
+		key, err := keys.ParsePublicKey(pubKey)
+		if err != nil {
+			return false
+		}
+		return key.Verify(sigHash, signature)
+	}
+}
+
+// VerifyWitnessInput runs a version 0 witness program spend with
+// signature checking: input inputIdx of tx spends prevOut, whose script
+// must be P2WPKH or P2WSH
+func VerifyWitnessInput(tx *types.Transaction, inputIdx int, prevOut types.TxOutput) error {
+	if inputIdx < 0 || inputIdx >= len(tx.Inputs) {
+		return fmt.Errorf("invalid input index: %d", inputIdx)
+	}
+	version, program, ok := script.ExtractWitnessProgram(prevOut.PubKeyScript)
+	if !ok || version != 0 {
+		return fmt.Errorf("output is not a version 0 witness program")
+	}
+	input := tx.Inputs[inputIdx]
+	if len(input.SignatureScript) != 0 {
+		return script.ErrWitnessMalleated
+	}
+
+	engine, err := script.NewWitnessEngine(program, input.Witness)
+	if err != nil {
+		return err
+	}
+	engine.SetTransaction(tx, inputIdx)
+	engine.SetSigChecker(WitnessSignatureChecker(tx, inputIdx, engine.Script(), prevOut.Value))
+	return engine.Execute()
+}
+
+// writeOutput serializes an output as it appears in a transaction
+func writeOutput(buf *bytes.Buffer, output types.TxOutput) {
+	serialization.WriteUint64(buf, uint64(output.Value))
+	serialization.WriteBytes(buf, output.PubKeyScript)
+}
+
+// doubleSHA256 hashes data twice with SHA-256
+func doubleSHA256(data []byte) [32]byte {
+	first := sha256.Sum256(data)
+	return sha256.Sum256(first[:])
+}
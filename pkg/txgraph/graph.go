@@ -0,0 +1,242 @@
+// Package txgraph walks the spending relationships around a transaction:
+// the transactions whose outputs it spends, the ones spending its
+// outputs, and so on outwards, across the mempool and the chain
+package txgraph
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+const (
+	// DefaultDepth is how many generations Query follows each way
+	// when not told otherwise
+	DefaultDepth = 10
+
+	// MaxNodes caps the transactions one query returns. Fan-out grows a
+	// graph fast; past this many the result is marked truncated.
+	MaxNodes = 1000
+)
+
+// ErrNotFound is returned for a transaction neither the mempool nor the
+// transaction index knows
+var ErrNotFound = errors.New("transaction not found in the mempool or the chain")
+
+// Node is one transaction of the graph
+type Node struct {
+	TxHash types.Hash
+	Depth  int // Generations from the root: negative for ancestors, positive for descendants
+
+	Confirmed bool
+	Height    uint64 // Block height, when confirmed
+	Fee       int64  // Mempool transactions only
+	Size      int64  // Mempool transactions only
+
+	Parents  []types.Hash // Transactions it spends from that are in the graph
+	Children []types.Hash // Transactions spending it that are in the graph
+}
+
+// Result is the graph around a root transaction
+type Result struct {
+	Root        *Node
+	Ancestors   []*Node // Nearest first
+	Descendants []*Node // Nearest first
+	Truncated   bool    // MaxNodes was reached, or spends of confirmed outputs aren't indexed
+}
+
+// Graph answers queries over a chain and, optionally, a mempool
+type Graph struct {
+	chain   *storage.BlockchainStorage
+	mempool *mempool.Mempool
+}
+
+// New creates a graph over chain and mp, which may be nil. Confirmed
+// transactions are found through the chain's transaction index; who
+// spent a confirmed output is only known with the spent index enabled.
+func New(chain *storage.BlockchainStorage, mp *mempool.Mempool) *Graph {
+	return &Graph{chain: chain, mempool: mp}
+}
+
+// Query returns the transactions within depth generations of txHash,
+// each way. Mempool transactions are looked up first, so a CPFP chain
+// shows up with its fees and sizes.
+func (g *Graph) Query(txHash types.Hash, depth int) (*Result, error) {
+	if depth < 0 {
+		return nil, fmt.Errorf("negative depth %d", depth)
+	}
+	q := &query{graph: g, nodes: make(map[types.Hash]*Node), txs: make(map[types.Hash]*types.Transaction)}
+	root, err := q.visit(txHash, 0)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Root: root}
+
+	// Walk outwards a generation at a time
+	frontier := []*Node{root}
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		var next []*Node
+		for _, node := range frontier {
+			for _, input := range q.txs[node.TxHash].Inputs {
+				if input.OutputIndex == 0xFFFFFFFF {
+					continue // Coinbase
+				}
+				parent, added, err := q.link(input.PrevTxHash, -d)
+				if err != nil {
+					return nil, err
+				}
+				if parent == nil {
+					result.Truncated = result.Truncated || q.full()
+					continue
+				}
+				connect(parent, node)
+				if added {
+					result.Ancestors = append(result.Ancestors, parent)
+					next = append(next, parent)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	frontier = []*Node{root}
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		var next []*Node
+		for _, node := range frontier {
+			spenders, complete, err := q.spenders(node)
+			if err != nil {
+				return nil, err
+			}
+			result.Truncated = result.Truncated || !complete
+			for _, spender := range spenders {
+				child, added, err := q.link(spender, d)
+				if err != nil {
+					return nil, err
+				}
+				if child == nil {
+					result.Truncated = result.Truncated || q.full()
+					continue
+				}
+				connect(node, child)
+				if added {
+					result.Descendants = append(result.Descendants, child)
+					next = append(next, child)
+				}
+			}
+		}
+		frontier = next
+	}
+	return result, nil
+}
+
+// query is the state of one Query
+type query struct {
+	graph *Graph
+	nodes map[types.Hash]*Node
+	txs   map[types.Hash]*types.Transaction
+}
+
+// full reports whether the graph has MaxNodes transactions
+func (q *query) full() bool {
+	return len(q.nodes) >= MaxNodes
+}
+
+// link returns the node for txHash, visiting it at depth if it is new.
+// It returns nil if the graph is full or the transaction can't be found,
+// e.g. a parent confirmed before the transaction index saw it.
+func (q *query) link(txHash types.Hash, depth int) (*Node, bool, error) {
+	if node, ok := q.nodes[txHash]; ok {
+		return node, false, nil
+	}
+	if q.full() {
+		return nil, false, nil
+	}
+	node, err := q.visit(txHash, depth)
+	if errors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return node, true, nil
+}
+
+// visit loads txHash and adds its node
+func (q *query) visit(txHash types.Hash, depth int) (*Node, error) {
+	node := &Node{TxHash: txHash, Depth: depth}
+	if q.graph.mempool != nil {
+		if entry, err := q.graph.mempool.Get(txHash); err == nil {
+			node.Fee, node.Size = entry.Fee, entry.Size
+			q.nodes[txHash], q.txs[txHash] = node, entry.Tx
+			return node, nil
+		}
+	}
+
+	blockHash, txIndex, err := q.graph.chain.GetTransactionLocation(txHash)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, txHash)
+	}
+	block, err := q.graph.chain.GetBlock(blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load block %s: %w", blockHash, err)
+	}
+	if int(txIndex) >= len(block.Transactions) {
+		return nil, fmt.Errorf("transaction index %d out of range in block %s", txIndex, blockHash)
+	}
+	height, err := q.graph.chain.GetBlockHeight(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	node.Confirmed, node.Height = true, height
+	q.nodes[txHash], q.txs[txHash] = node, &block.Transactions[txIndex]
+	return node, nil
+}
+
+// spenders returns the transactions spending node's outputs, and whether
+// every output could be checked: a confirmed spend is only found through
+// the spent index
+func (q *query) spenders(node *Node) ([]types.Hash, bool, error) {
+	var spenders []types.Hash
+	seen := make(map[types.Hash]bool)
+	complete := true
+	for index := range q.txs[node.TxHash].Outputs {
+		outpoint := types.NewOutPoint(node.TxHash, uint32(index))
+		var spender types.Hash
+		found := false
+		if q.graph.mempool != nil {
+			spender, found = q.graph.mempool.SpentBy(outpoint)
+		}
+		if !found && node.Confirmed {
+			if !q.graph.chain.SpentIndexEnabled() {
+				complete = false
+				continue
+			}
+			info, err := q.graph.chain.GetSpentInfo(outpoint)
+			if err != nil {
+				return nil, false, err
+			}
+			if info != nil {
+				spender, found = info.TxHash, true
+			}
+		}
+		if found && !seen[spender] {
+			seen[spender] = true
+			spenders = append(spenders, spender)
+		}
+	}
+	return spenders, complete, nil
+}
+
+// connect records that child spends parent
+func connect(parent, child *Node) {
+	for _, hash := range parent.Children {
+		if hash == child.TxHash {
+			return
+		}
+	}
+	parent.Children = append(parent.Children, child.TxHash)
+	child.Parents = append(child.Parents, parent.TxHash)
+}
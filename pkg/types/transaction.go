@@ -6,10 +6,11 @@ import (
 
 // TxInput represents where coins come from
 type TxInput struct {
-	PrevTxHash      Hash   // Which transaction created these coins?
-	OutputIndex     uint32 // Which output in that transaction?
-	SignatureScript []byte // Proof you can spend (signature + pubkey)
-	Sequence        uint32 // For timelock features (usually 0xFFFFFFFF)
+	PrevTxHash      Hash     // Which transaction created these coins?
+	OutputIndex     uint32   // Which output in that transaction?
+	SignatureScript []byte   // Proof you can spend (signature + pubkey)
+	Sequence        uint32   // For timelock features (usually 0xFFFFFFFF)
+	Witness         [][]byte // Segregated witness stack (BIP141), empty for legacy inputs
 }
 
 // TxOutput represents where coins go
@@ -50,6 +51,16 @@ type Transaction struct {
 	LockTime uint32     // When tx becomes valid (0 = immediately)
 }
 
+// HasWitness reports whether any input carries witness data
+func (tx *Transaction) HasWitness() bool {
+	for _, input := range tx.Inputs {
+		if len(input.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 /*
 **Key concepts explained:**
 
@@ -0,0 +1,36 @@
+package utxo_test
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Run with e.g. `go test ./pkg/utxo -run=^$ -bench=. -benchmem`
+
+func BenchmarkUTXOApplyTransaction(b *testing.B) {
+	block, set, err := testharness.SyntheticBlock(testharness.BenchBlockConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+	hashes := make([]types.Hash, len(block.Transactions))
+	for i := range block.Transactions {
+		hashes[i], _ = serialization.HashTransaction(&block.Transactions[i])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		view := set.Clone()
+		b.StartTimer()
+
+		for j := range block.Transactions {
+			if err := view.ApplyTransaction(&block.Transactions[j], hashes[j], 1, j == 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -1,28 +1,116 @@
 package utxo
 
-// UTXOCache provides a caching layer over UTXO storage
-// Keeps frequently accessed UTXOs in memory
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// CacheConfig tunes how a UTXOCache writes changes back to storage
+type CacheConfig struct {
+	// FlushInterval is how often Start flushes changed entries in the
+	// background, 0 to flush only on Flush and Close
+	FlushInterval time.Duration
+
+	// BatchSize is the most changes written in one database batch. Each
+	// batch is atomic on its own; a flush failing partway keeps the
+	// changes not yet written for the next one.
+	BatchSize int
+}
+
+// DefaultCacheConfig returns a config flushing every minute in batches of
+// a thousand coins
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		FlushInterval: time.Minute,
+		BatchSize:     1000,
+	}
+}
+
+// UTXOCache provides a caching layer over UTXO storage. Coins read are
+// kept in memory; coins added or spent are only written back by Flush,
+// which writes just the entries changed since the last one, along with
+// the block the coins are as of.
 type UTXOCache struct {
 	storage *UTXOStorage
+	config  CacheConfig
 	cache   *UTXOSet
-	dirty   map[string]bool // Track modified entries
+
+	best   types.Hash // Block the cache is as of, zero while unknown
+	stored types.Hash // Block storage is as of, guarded by flushMu
+
+	// Outpoints changed since the last flush, and those the running flush
+	// is writing. Either way the cache holds the coin, or it is spent and
+	// storage may not know yet.
+	dirty    map[OutPoint]struct{}
+	flushing map[OutPoint]struct{}
+	mu       sync.Mutex
+
+	flushMu sync.Mutex // Serializes flushes, so writes land in order
+	quit    chan struct{}
+	wg      sync.WaitGroup
 }
 
-// NewUTXOCache creates a new UTXO cache
+// NewUTXOCache creates a new UTXO cache with the default config
 func NewUTXOCache(storage *UTXOStorage) *UTXOCache {
-	return &UTXOCache{
-		storage: storage,
-		cache:   NewUTXOSet(),
-		dirty:   make(map[string]bool),
+	return NewUTXOCacheWithConfig(storage, DefaultCacheConfig())
+}
+
+// NewUTXOCacheWithConfig creates a UTXO cache over storage, which may be
+// nil for a cache that only lives in memory. The cache starts out as of
+// the best block storage recorded; one it can't read counts as unknown.
+func NewUTXOCacheWithConfig(storage *UTXOStorage, config CacheConfig) *UTXOCache {
+	uc := &UTXOCache{
+		storage:  storage,
+		config:   config,
+		cache:    NewUTXOSet(),
+		dirty:    make(map[OutPoint]struct{}),
+		flushing: make(map[OutPoint]struct{}),
+		quit:     make(chan struct{}),
+	}
+	if storage != nil {
+		if best, err := storage.BestBlock(); err == nil {
+			uc.best, uc.stored = best, best
+		}
 	}
+	return uc
+}
+
+// BestBlock returns the block the cache is as of, zero if unknown
+func (uc *UTXOCache) BestBlock() types.Hash {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	return uc.best
+}
+
+// SetBestBlock records the block the cache is as of, flushed with the
+// coins. Set it to zero before changing the coins and to the new block
+// once they are up to date, so a flush in between never claims a block
+// the stored coins don't match.
+func (uc *UTXOCache) SetBestBlock(hash types.Hash) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	uc.best = hash
 }
 
 // Get retrieves a UTXO (from cache or storage)
 func (uc *UTXOCache) Get(outpoint OutPoint) (*UTXO, error) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
 	// Try cache first
 	if uc.cache.Exists(outpoint) {
 		return uc.cache.Get(outpoint)
 	}
+	if uc.pending(outpoint) || uc.storage == nil {
+		return nil, fmt.Errorf("UTXO not found: %s", outpoint)
+	}
 
 	// Load from storage
 	utxo, err := uc.storage.Load(outpoint)
@@ -38,36 +126,67 @@ func (uc *UTXOCache) Get(outpoint OutPoint) (*UTXO, error) {
 
 // Add adds a UTXO to the cache
 func (uc *UTXOCache) Add(utxo *UTXO) error {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
 	if err := uc.cache.Add(utxo); err != nil {
 		return err
 	}
-
-	// Mark as dirty
-	key := utxo.OutPoint().String()
-	uc.dirty[key] = true
-
+	uc.dirty[utxo.OutPoint()] = struct{}{}
 	return nil
 }
 
-// Remove removes a UTXO from the cache
+// Remove removes a UTXO from the cache, and from storage at the next
+// flush
 func (uc *UTXOCache) Remove(outpoint OutPoint) error {
-	if err := uc.cache.Remove(outpoint); err != nil {
-		return err
-	}
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
 
-	// Mark as dirty
-	key := outpoint.String()
-	uc.dirty[key] = true
+	return uc.remove(outpoint)
+}
 
-	return nil
+// remove spends a coin held in memory or storage. The caller must hold
+// uc.mu.
+func (uc *UTXOCache) remove(outpoint OutPoint) error {
+	if uc.cache.Exists(outpoint) {
+		if err := uc.cache.Remove(outpoint); err != nil {
+			return err
+		}
+		uc.dirty[outpoint] = struct{}{}
+		return nil
+	}
+
+	// A coin only in storage needs no loading to be spent
+	if !uc.pending(outpoint) && uc.storage != nil {
+		exists, err := uc.storage.Exists(outpoint)
+		if err != nil {
+			return err
+		}
+		if exists {
+			uc.dirty[outpoint] = struct{}{}
+			return nil
+		}
+	}
+	return fmt.Errorf("UTXO not found: %s", outpoint)
 }
 
 // Exists checks if a UTXO exists (checks cache and storage)
 func (uc *UTXOCache) Exists(outpoint OutPoint) bool {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	return uc.exists(outpoint)
+}
+
+// exists checks memory, then storage. The caller must hold uc.mu.
+func (uc *UTXOCache) exists(outpoint OutPoint) bool {
 	// Check cache first
 	if uc.cache.Exists(outpoint) {
 		return true
 	}
+	if uc.pending(outpoint) || uc.storage == nil {
+		return false
+	}
 
 	// Check storage
 	exists, err := uc.storage.Exists(outpoint)
@@ -78,47 +197,247 @@ func (uc *UTXOCache) Exists(outpoint OutPoint) bool {
 	return exists
 }
 
-// Flush writes all dirty entries to storage
-func (uc *UTXOCache) Flush() error {
-	if len(uc.dirty) == 0 {
-		return nil // Nothing to flush
+// ApplyTransaction spends a transaction's inputs and adds its outputs,
+// like UTXOSet.ApplyTransaction
+func (uc *UTXOCache) ApplyTransaction(tx *types.Transaction, txHash types.Hash, height uint64, isCoinbase bool) error {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	if !isCoinbase {
+		for _, input := range tx.Inputs {
+			outpoint := NewOutPoint(input.PrevTxHash, input.OutputIndex)
+			if err := uc.remove(outpoint); err != nil {
+				return fmt.Errorf("trying to spend non-existent UTXO: %s", outpoint)
+			}
+		}
 	}
 
-	var changes []UTXOChange
+	for i, output := range tx.Outputs {
+		utxo := NewUTXO(txHash, uint32(i), output, height, isCoinbase)
+		// An output recreated under a txid that is still unspent
+		// replaces the old entry
+		uc.cache.Remove(utxo.OutPoint())
+		uc.cache.Add(utxo)
+		uc.dirty[utxo.OutPoint()] = struct{}{}
+	}
 
-	for _ = range uc.dirty {
-		// Parse outpoint from key
-		// (In production, you'd store outpoints directly)
+	return nil
+}
 
-		// Check if UTXO exists in cache
-		utxo, err := uc.cache.Get(OutPoint{}) // Simplified
-		if err == nil {
-			// Add to storage
-			changes = append(changes, UTXOChange{
-				Outpoint: utxo.OutPoint(),
-				Add:      utxo,
-			})
-		} else {
-			// Remove from storage
-			// changes = append(changes, UTXOChange{
-			// 	Outpoint: outpoint,
-			// 	Remove:   true,
-			// })
+// RevertTransaction removes a transaction's outputs. As with UTXOSet,
+// restoring the outputs it spent is up to the caller.
+func (uc *UTXOCache) RevertTransaction(tx *types.Transaction, txHash types.Hash) error {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	for i := range tx.Outputs {
+		outpoint := NewOutPoint(txHash, uint32(i))
+		if uc.exists(outpoint) {
+			uc.remove(outpoint)
 		}
 	}
 
-	// Apply changes
-	if err := uc.storage.ApplyChanges(changes); err != nil {
-		return err
+	return nil
+}
+
+// GetAll returns every coin, those only in storage included
+func (uc *UTXOCache) GetAll() []*UTXO {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	result := uc.cache.GetAll()
+	if uc.storage == nil {
+		return result
+	}
+	stored, err := uc.storage.LoadAll()
+	if err != nil {
+		log.Printf("utxo: failed to list stored coins: %v", err)
+		return result
 	}
+	for _, utxo := range stored.GetAll() {
+		if !uc.cache.Exists(utxo.OutPoint()) && !uc.pending(utxo.OutPoint()) {
+			result = append(result, utxo)
+		}
+	}
+	return result
+}
+
+// FindByScript returns every coin locked by script
+func (uc *UTXOCache) FindByScript(script []byte) []*UTXO {
+	var result []*UTXO
+	for _, utxo := range uc.GetAll() {
+		if bytes.Equal(utxo.Output.PubKeyScript, script) {
+			result = append(result, utxo)
+		}
+	}
+	return result
+}
+
+// Clear empties the cache and its storage, leaving it as of no block
+func (uc *UTXOCache) Clear() error {
+	uc.flushMu.Lock()
+	defer uc.flushMu.Unlock()
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	uc.cache.Clear()
+	uc.dirty = make(map[OutPoint]struct{})
+	uc.best = types.Hash{}
+	if uc.storage == nil {
+		return nil
+	}
+	uc.stored = types.Hash{}
+	return uc.storage.Clear()
+}
+
+// pending reports whether outpoint changed since storage was last written.
+// The caller must hold uc.mu.
+func (uc *UTXOCache) pending(outpoint OutPoint) bool {
+	_, dirty := uc.dirty[outpoint]
+	_, flushing := uc.flushing[outpoint]
+	return dirty || flushing
+}
+
+// Flush writes the entries changed since the last flush to storage, in
+// batches of the configured size and in key order, and then the best
+// block. The cache stays usable meanwhile; changes made during the flush
+// are left for the next.
+func (uc *UTXOCache) Flush() error {
+	uc.flushMu.Lock()
+	defer uc.flushMu.Unlock()
+
+	uc.mu.Lock()
+	if uc.storage == nil || (len(uc.dirty) == 0 && uc.best == uc.stored) {
+		uc.mu.Unlock()
+		return nil
+	}
+	best := uc.best
+	changes := make([]UTXOChange, 0, len(uc.dirty))
+	for outpoint := range uc.dirty {
+		if utxo, err := uc.cache.Get(outpoint); err == nil {
+			changes = append(changes, UTXOChange{Outpoint: outpoint, Add: utxo})
+		} else {
+			changes = append(changes, UTXOChange{Outpoint: outpoint, Remove: true})
+		}
+	}
+	uc.flushing, uc.dirty = uc.dirty, make(map[OutPoint]struct{})
+	uc.mu.Unlock()
+
+	// Sorted keys are cheaper for LevelDB to merge
+	sort.Slice(changes, func(i, j int) bool {
+		return bytes.Compare(changes[i].Outpoint.Bytes(), changes[j].Outpoint.Bytes()) < 0
+	})
 
-	// Clear dirty map
-	uc.dirty = make(map[string]bool)
+	// Storage is as of no block until every change is in, so a flush cut
+	// short leaves it marked as needing a rebuild
+	var written int
+	var err error
+	if len(changes) > 0 && uc.stored != (types.Hash{}) {
+		if err = uc.storage.SaveBestBlock(types.Hash{}); err == nil {
+			uc.stored = types.Hash{}
+		}
+	}
+	if err == nil {
+		written, err = uc.storage.ApplyChangesBatched(changes, uc.config.BatchSize)
+	}
+	if err == nil && best != uc.stored {
+		if err = uc.storage.SaveBestBlock(best); err == nil {
+			uc.stored = best
+		}
+	}
 
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	if err != nil {
+		// What wasn't written is still dirty
+		for _, change := range changes[written:] {
+			uc.dirty[change.Outpoint] = struct{}{}
+		}
+	}
+	uc.flushing = make(map[OutPoint]struct{})
+	if err != nil {
+		return fmt.Errorf("failed to flush UTXO cache after %d of %d changes: %w", written, len(changes), err)
+	}
 	return nil
 }
 
+// Start flushes in the background every FlushInterval until Close. It
+// does nothing with a zero interval.
+func (uc *UTXOCache) Start() {
+	if uc.config.FlushInterval <= 0 {
+		return
+	}
+	uc.wg.Add(1)
+	go uc.flushLoop(uc.config.FlushInterval)
+}
+
+// flushLoop flushes every interval until quit is closed
+func (uc *UTXOCache) flushLoop(interval time.Duration) {
+	defer uc.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-uc.quit:
+			return
+		case <-ticker.C:
+		}
+
+		if err := uc.Flush(); err != nil {
+			log.Printf("utxo: %v", err)
+		}
+	}
+}
+
+// Close stops background flushing and flushes what is left, so a clean
+// shutdown loses no changes. The storage stays open.
+func (uc *UTXOCache) Close() error {
+	uc.mu.Lock()
+	select {
+	case <-uc.quit:
+	default:
+		close(uc.quit)
+	}
+	uc.mu.Unlock()
+
+	uc.wg.Wait()
+	return uc.Flush()
+}
+
+// Storage returns the storage behind the cache, nil for one that only
+// lives in memory
+func (uc *UTXOCache) Storage() *UTXOStorage {
+	return uc.storage
+}
+
 // GetSet returns the in-memory UTXO set
 func (uc *UTXOCache) GetSet() *UTXOSet {
 	return uc.cache
 }
+
+// Size returns the number of UTXOs held in memory
+func (uc *UTXOCache) Size() int {
+	return uc.cache.Size()
+}
+
+// Dirty returns the number of entries changed since the last flush
+func (uc *UTXOCache) Dirty() int {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	return len(uc.dirty) + len(uc.flushing)
+}
+
+// dirtyEntryOverhead estimates the heap bytes of one tracked outpoint:
+// the key and its map bucket slot
+const dirtyEntryOverhead = int64(unsafe.Sizeof(OutPoint{})) + 16
+
+// MemoryUsage estimates the heap bytes held by the cache, including the
+// dirty entry tracking
+func (uc *UTXOCache) MemoryUsage() int64 {
+	uc.mu.Lock()
+	tracked := int64(len(uc.dirty) + len(uc.flushing))
+	uc.mu.Unlock()
+	return uc.cache.MemoryUsage() + tracked*dirtyEntryOverhead
+}
@@ -0,0 +1,225 @@
+package utxo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Compact coin format, as in Bitcoin Core's chainstate. The outpoint is
+// already in the database key, so a stored coin is just:
+//
+//	varint(height*2 + coinbase) varint(CompressAmount(value)) script
+//
+// where script is one of the special forms below or varint(len+6) followed
+// by the raw script. Varints are Core's base-128 encoding, most significant
+// group first, not the CompactSize used on the wire.
+
+// Special script forms
+const (
+	scriptP2PKH       = 0x00 // Followed by the 20-byte key hash
+	scriptP2SH        = 0x01 // Followed by the 20-byte script hash
+	scriptP2PKEven    = 0x02 // Compressed key with even y, 32-byte x follows
+	scriptP2PKOdd     = 0x03 // Compressed key with odd y, 32-byte x follows
+	numSpecialScripts = 6    // Raw scripts store their length plus this
+)
+
+// maxCompactScriptSize bounds raw scripts read back from the database
+const maxCompactScriptSize = 10000
+
+// Script opcodes the special forms stand for
+const (
+	opDup         = 0x76
+	opHash160     = 0xa9
+	opEqual       = 0x87
+	opEqualVerify = 0x88
+	opCheckSig    = 0xac
+)
+
+var errCompactCoin = errors.New("malformed compact coin")
+
+// CompressAmount maps a satoshi amount to a smaller number for amounts
+// with trailing decimal zeros, which most are
+func CompressAmount(n uint64) uint64 {
+	if n == 0 {
+		return 0
+	}
+	e := uint64(0)
+	for n%10 == 0 && e < 9 {
+		n /= 10
+		e++
+	}
+	if e < 9 {
+		d := n % 10
+		n /= 10
+		return 1 + (n*9+d-1)*10 + e
+	}
+	return 1 + (n-1)*10 + 9
+}
+
+// DecompressAmount reverses CompressAmount
+func DecompressAmount(x uint64) uint64 {
+	if x == 0 {
+		return 0
+	}
+	x--
+	e := x % 10
+	x /= 10
+	n := uint64(0)
+	if e < 9 {
+		d := x%9 + 1
+		x /= 9
+		n = x*10 + d
+	} else {
+		n = x + 1
+	}
+	for ; e > 0; e-- {
+		n *= 10
+	}
+	return n
+}
+
+// compressScript returns the special form of a standard script, or nil
+func compressScript(script []byte) []byte {
+	switch {
+	case len(script) == 25 && script[0] == opDup && script[1] == opHash160 && script[2] == 20 &&
+		script[23] == opEqualVerify && script[24] == opCheckSig:
+		return append([]byte{scriptP2PKH}, script[3:23]...)
+	case len(script) == 23 && script[0] == opHash160 && script[1] == 20 && script[22] == opEqual:
+		return append([]byte{scriptP2SH}, script[2:22]...)
+	case len(script) == 35 && script[0] == 33 && script[34] == opCheckSig &&
+		(script[1] == 0x02 || script[1] == 0x03):
+		return append([]byte{script[1]}, script[2:34]...)
+	}
+	// Uncompressed P2PK would need the key decompressed again on load, so
+	// it is stored raw
+	return nil
+}
+
+// decompressScript rebuilds a standard script from its special form
+func decompressScript(kind uint64, data []byte) []byte {
+	switch kind {
+	case scriptP2PKH:
+		script := []byte{opDup, opHash160, 20}
+		script = append(script, data...)
+		return append(script, opEqualVerify, opCheckSig)
+	case scriptP2SH:
+		script := []byte{opHash160, 20}
+		script = append(script, data...)
+		return append(script, opEqual)
+	default: // scriptP2PKEven, scriptP2PKOdd
+		script := []byte{33, byte(kind)}
+		script = append(script, data...)
+		return append(script, opCheckSig)
+	}
+}
+
+// specialScriptSize is the payload length of a special script form
+func specialScriptSize(kind uint64) int {
+	if kind == scriptP2PKH || kind == scriptP2SH {
+		return 20
+	}
+	return 32
+}
+
+// SerializeCompact encodes the coin in the compact chainstate format,
+// leaving out the outpoint
+func (u *UTXO) SerializeCompact() []byte {
+	var buf bytes.Buffer
+
+	code := u.Height * 2
+	if u.IsCoinbase {
+		code |= 1
+	}
+	writeCompactVarInt(&buf, code)
+	writeCompactVarInt(&buf, CompressAmount(uint64(u.Output.Value)))
+
+	if special := compressScript(u.Output.PubKeyScript); special != nil {
+		buf.Write(special)
+	} else {
+		writeCompactVarInt(&buf, uint64(len(u.Output.PubKeyScript))+numSpecialScripts)
+		buf.Write(u.Output.PubKeyScript)
+	}
+
+	return buf.Bytes()
+}
+
+// DeserializeCompactUTXO decodes a coin stored by SerializeCompact
+func DeserializeCompactUTXO(outpoint OutPoint, data []byte) (*UTXO, error) {
+	r := bytes.NewReader(data)
+
+	code, err := readCompactVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: height: %v", errCompactCoin, err)
+	}
+	amount, err := readCompactVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: amount: %v", errCompactCoin, err)
+	}
+	kind, err := readCompactVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: script: %v", errCompactCoin, err)
+	}
+
+	var script []byte
+	if kind < numSpecialScripts {
+		if kind > scriptP2PKOdd {
+			return nil, fmt.Errorf("%w: unsupported script form %d", errCompactCoin, kind)
+		}
+		payload := make([]byte, specialScriptSize(kind))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, fmt.Errorf("%w: script: %v", errCompactCoin, err)
+		}
+		script = decompressScript(kind, payload)
+	} else {
+		size := kind - numSpecialScripts
+		if size > maxCompactScriptSize || size != uint64(r.Len()) {
+			return nil, fmt.Errorf("%w: script length %d", errCompactCoin, size)
+		}
+		script = make([]byte, size)
+		io.ReadFull(r, script)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", errCompactCoin, r.Len())
+	}
+
+	output := types.TxOutput{Value: int64(DecompressAmount(amount)), PubKeyScript: script}
+	return NewUTXO(outpoint.Hash, outpoint.Index, output, code>>1, code&1 == 1), nil
+}
+
+// writeCompactVarInt writes Core's chainstate varint: base-128 groups,
+// most significant first, each but the last with the high bit set and
+// offset by one so every number has a single encoding
+func writeCompactVarInt(buf *bytes.Buffer, n uint64) {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(n & 0x7f)
+	for n > 0x7f {
+		n = (n >> 7) - 1
+		i--
+		tmp[i] = byte(n&0x7f) | 0x80
+	}
+	buf.Write(tmp[i:])
+}
+
+// readCompactVarInt reads a number written by writeCompactVarInt
+func readCompactVarInt(r io.ByteReader) (uint64, error) {
+	n := uint64(0)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if n > (^uint64(0) >> 7) {
+			return 0, errors.New("varint overflows 64 bits")
+		}
+		n = n<<7 | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			return n, nil
+		}
+		n++
+	}
+}
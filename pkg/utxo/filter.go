@@ -0,0 +1,161 @@
+package utxo
+
+import (
+	"hash/maphash"
+	"math/rand"
+)
+
+const (
+	// filterBucketSize is how many fingerprints a bucket holds. Four
+	// lets the filter fill to about 95% before inserts start failing.
+	filterBucketSize = 4
+
+	// filterMaxKicks is how many fingerprints an insert relocates before
+	// giving up on a full filter
+	filterMaxKicks = 500
+
+	// filterLoadFactor is the share of slots a filter sized for a given
+	// capacity expects to use
+	filterLoadFactor = 0.9
+)
+
+// FilterStats reports how a UTXO storage's existence filter performs
+type FilterStats struct {
+	Entries  int   // Coins in the filter
+	Capacity int   // Fingerprint slots
+	Bytes    int64 // Memory the slots take
+
+	Checks         uint64 // Existence checks that consulted the filter
+	Skipped        uint64 // Checks the filter answered "absent" without a disk lookup
+	FalsePositives uint64 // Checks the filter passed on that the disk found absent
+	Rebuilds       uint64 // Times the filter filled up and was rebuilt larger
+}
+
+// FalsePositiveRate returns the share of lookups for absent coins that
+// the filter let through to disk
+func (s FilterStats) FalsePositiveRate() float64 {
+	absent := s.Skipped + s.FalsePositives
+	if absent == 0 {
+		return 0
+	}
+	return float64(s.FalsePositives) / float64(absent)
+}
+
+// cuckooFilter answers "might this outpoint be stored?" in memory. Each
+// outpoint is a 16-bit fingerprint in one of two buckets, either of which
+// can be found from the other and the fingerprint alone, so entries can be
+// moved and deleted. With four slots a bucket, about one lookup in 8000
+// for an absent outpoint is a false positive. It is not safe for
+// concurrent use.
+type cuckooFilter struct {
+	buckets [][filterBucketSize]uint16 // 0 marks an empty slot
+	mask    uint64
+	count   int
+	seed    maphash.Seed // Random, so crafted outpoints can't target buckets
+}
+
+// newCuckooFilter creates a filter with room for about capacity outpoints
+func newCuckooFilter(capacity int) *cuckooFilter {
+	needed := uint64(float64(capacity)/filterLoadFactor/filterBucketSize) + 1
+	size := uint64(1)
+	for size < needed {
+		size <<= 1
+	}
+	return &cuckooFilter{
+		buckets: make([][filterBucketSize]uint16, size),
+		mask:    size - 1,
+		seed:    maphash.MakeSeed(),
+	}
+}
+
+// locate returns the outpoint's fingerprint and its two buckets
+func (f *cuckooFilter) locate(outpoint OutPoint) (uint16, uint64, uint64) {
+	h := maphash.Bytes(f.seed, outpoint.Bytes())
+	fp := uint16(h >> 48)
+	if fp == 0 {
+		fp = 1
+	}
+	i1 := h & f.mask
+	return fp, i1, f.altIndex(i1, fp)
+}
+
+// altIndex returns the other bucket a fingerprint in bucket i may be in.
+// Applied twice it gives i back.
+func (f *cuckooFilter) altIndex(i uint64, fp uint16) uint64 {
+	return (i ^ uint64(fp)*0x5bd1e995) & f.mask
+}
+
+// insert adds an outpoint, returning false if the filter is too full.
+// The filter then holds a fingerprint that was kicked out and couldn't be
+// placed, so it must be rebuilt before answering again.
+func (f *cuckooFilter) insert(outpoint OutPoint) bool {
+	fp, i1, i2 := f.locate(outpoint)
+	if f.place(i1, fp) || f.place(i2, fp) {
+		f.count++
+		return true
+	}
+
+	i := i1
+	if rand.Intn(2) == 1 {
+		i = i2
+	}
+	for kick := 0; kick < filterMaxKicks; kick++ {
+		slot := rand.Intn(filterBucketSize)
+		fp, f.buckets[i][slot] = f.buckets[i][slot], fp
+		i = f.altIndex(i, fp)
+		if f.place(i, fp) {
+			f.count++
+			return true
+		}
+	}
+	return false
+}
+
+// place puts fp in an empty slot of bucket i
+func (f *cuckooFilter) place(i uint64, fp uint16) bool {
+	for slot, held := range f.buckets[i] {
+		if held == 0 {
+			f.buckets[i][slot] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether the outpoint may have been inserted. False
+// is certain; true is wrong for a small share of outpoints.
+func (f *cuckooFilter) contains(outpoint OutPoint) bool {
+	fp, i1, i2 := f.locate(outpoint)
+	return f.holds(i1, fp) || f.holds(i2, fp)
+}
+
+// holds reports whether bucket i has fp
+func (f *cuckooFilter) holds(i uint64, fp uint16) bool {
+	for _, held := range f.buckets[i] {
+		if held == fp {
+			return true
+		}
+	}
+	return false
+}
+
+// remove deletes one copy of the outpoint's fingerprint. Removing an
+// outpoint never inserted may delete another's, so callers must know it
+// was.
+func (f *cuckooFilter) remove(outpoint OutPoint) {
+	fp, i1, i2 := f.locate(outpoint)
+	for _, i := range []uint64{i1, i2} {
+		for slot, held := range f.buckets[i] {
+			if held == fp {
+				f.buckets[i][slot] = 0
+				f.count--
+				return
+			}
+		}
+	}
+}
+
+// slots returns the number of fingerprint slots
+func (f *cuckooFilter) slots() int {
+	return len(f.buckets) * filterBucketSize
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"sync"
+	"unsafe"
 
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
@@ -164,6 +165,22 @@ func (us *UTXOSet) RevertTransaction(tx *types.Transaction, txHash types.Hash) e
 	return nil
 }
 
+// utxoEntryOverhead approximates the fixed cost of one entry: the UTXO
+// struct, its map bucket slot and the pointer to it
+const utxoEntryOverhead = int64(unsafe.Sizeof(UTXO{})) + 48
+
+// MemoryUsage estimates the heap bytes held by the set
+func (us *UTXOSet) MemoryUsage() int64 {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	var total int64
+	for key, utxo := range us.utxos {
+		total += utxoEntryOverhead + int64(len(key)) + int64(cap(utxo.Output.PubKeyScript))
+	}
+	return total
+}
+
 // Clone creates a deep copy of the UTXO set
 func (us *UTXOSet) Clone() *UTXOSet {
 	us.mu.RLock()
@@ -1,14 +1,33 @@
 package utxo
 
 import (
+	"bytes"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 )
 
-// UTXOStorage provides persistent storage for the UTXO set
+// ChainstateDirName is the database a node keeps its UTXO set in, inside
+// the data directory
+const ChainstateDirName = "chainstate"
+
+// UTXOStorage provides persistent storage for the UTXO set. Coins are
+// kept in the compact format of compress.go.
 type UTXOStorage struct {
 	db *storage.Database
+
+	// filter, when enabled, answers existence checks for coins that were
+	// never stored without touching disk. Writes hold filterMu, so the
+	// filter and the database agree.
+	filter         *cuckooFilter
+	filterMu       sync.RWMutex
+	filterRebuilds uint64
+	checks         atomic.Uint64
+	skipped        atomic.Uint64
+	falsePositives atomic.Uint64
 }
 
 // NewUTXOStorage creates a new UTXO storage
@@ -35,16 +54,29 @@ func utxoKey(outpoint OutPoint) []byte {
 	return key
 }
 
+// bestBlockKey holds the hash of the block the stored coins are as of
+var bestBlockKey = []byte{'B'}
+
 // Save saves a UTXO to storage
 func (us *UTXOStorage) Save(utxo *UTXO) error {
 	key := utxoKey(utxo.OutPoint())
-	value := utxo.Serialize()
+	value := utxo.SerializeCompact()
 
-	return us.db.Put(key, value)
+	us.filterMu.Lock()
+	defer us.filterMu.Unlock()
+	if err := us.db.Put(key, value); err != nil {
+		return err
+	}
+	return us.filterAddLocked(utxo.OutPoint())
 }
 
 // Load loads a UTXO from storage
 func (us *UTXOStorage) Load(outpoint OutPoint) (*UTXO, error) {
+	maybe, filtered := us.filterCheck(outpoint)
+	if !maybe {
+		return nil, fmt.Errorf("UTXO not found: %s", outpoint)
+	}
+
 	key := utxoKey(outpoint)
 	value, err := us.db.Get(key)
 
@@ -53,35 +85,77 @@ func (us *UTXOStorage) Load(outpoint OutPoint) (*UTXO, error) {
 	}
 
 	if value == nil {
+		if filtered {
+			us.falsePositives.Add(1)
+		}
 		return nil, fmt.Errorf("UTXO not found: %s", outpoint)
 	}
 
-	return DeserializeUTXO(value)
+	return decodeCoin(outpoint, value)
+}
+
+// decodeCoin decodes a stored coin. Databases written before the compact
+// format hold the verbose UTXO encoding, which starts with the outpoint.
+func decodeCoin(outpoint OutPoint, value []byte) (*UTXO, error) {
+	if len(value) >= 53 && bytes.Equal(value[:36], outpoint.Bytes()) {
+		return DeserializeUTXO(value)
+	}
+	return DeserializeCompactUTXO(outpoint, value)
 }
 
 // Delete removes a UTXO from storage
 func (us *UTXOStorage) Delete(outpoint OutPoint) error {
 	key := utxoKey(outpoint)
-	return us.db.Delete(key)
+
+	us.filterMu.Lock()
+	defer us.filterMu.Unlock()
+	stored, err := us.filterStoredLocked(outpoint)
+	if err != nil {
+		return err
+	}
+	if err := us.db.Delete(key); err != nil {
+		return err
+	}
+	if stored {
+		us.filter.remove(outpoint)
+	}
+	return nil
 }
 
 // Exists checks if a UTXO exists in storage
 func (us *UTXOStorage) Exists(outpoint OutPoint) (bool, error) {
+	maybe, filtered := us.filterCheck(outpoint)
+	if !maybe {
+		return false, nil
+	}
+
 	key := utxoKey(outpoint)
-	return us.db.Has(key)
+	exists, err := us.db.Has(key)
+	if err == nil && !exists && filtered {
+		us.falsePositives.Add(1)
+	}
+	return exists, err
 }
 
-// SaveSet saves an entire UTXO set to storage
+// SaveSet saves an entire UTXO set to storage. A UTXOCache flushes only
+// the coins that changed instead.
 func (us *UTXOStorage) SaveSet(set *UTXOSet) error {
 	batch := us.db.NewBatch()
 
+	var outpoints []OutPoint
 	for _, utxo := range set.GetAll() {
 		key := utxoKey(utxo.OutPoint())
-		value := utxo.Serialize()
+		value := utxo.SerializeCompact()
 		batch.Put(key, value)
+		outpoints = append(outpoints, utxo.OutPoint())
 	}
 
-	return batch.Write()
+	us.filterMu.Lock()
+	defer us.filterMu.Unlock()
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	return us.filterAddLocked(outpoints...)
 }
 
 // LoadAll loads all UTXOs from storage into a UTXO set
@@ -93,8 +167,11 @@ func (us *UTXOStorage) LoadAll() (*UTXOSet, error) {
 	defer iter.Release()
 
 	for iter.Next() {
-		value := iter.Value()
-		utxo, err := DeserializeUTXO(value)
+		outpoint, err := OutPointFromBytes(iter.Key()[1:])
+		if err != nil {
+			return nil, err
+		}
+		utxo, err := decodeCoin(outpoint, iter.Value())
 		if err != nil {
 			return nil, fmt.Errorf("failed to deserialize UTXO: %w", err)
 		}
@@ -120,18 +197,166 @@ type UTXOChange struct {
 func (us *UTXOStorage) ApplyChanges(changes []UTXOChange) error {
 	batch := us.db.NewBatch()
 
+	us.filterMu.Lock()
+	defer us.filterMu.Unlock()
+
+	var added, removed []OutPoint
 	for _, change := range changes {
 		key := utxoKey(change.Outpoint)
 
 		if change.Remove {
 			batch.Delete(key)
+			stored, err := us.filterStoredLocked(change.Outpoint)
+			if err != nil {
+				return err
+			}
+			if stored {
+				removed = append(removed, change.Outpoint)
+			}
 		} else if change.Add != nil {
-			value := change.Add.Serialize()
+			value := change.Add.SerializeCompact()
 			batch.Put(key, value)
+			added = append(added, change.Outpoint)
+		}
+	}
+
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	for _, outpoint := range removed {
+		us.filter.remove(outpoint)
+	}
+	return us.filterAddLocked(added...)
+}
+
+// ApplyChangesBatched applies changes in batches of at most batchSize,
+// each atomic on its own, so a large flush doesn't build one huge write.
+// It returns how many changes were written: all of them unless err is set,
+// else those in the batches before the one that failed.
+func (us *UTXOStorage) ApplyChangesBatched(changes []UTXOChange, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = len(changes)
+	}
+	written := 0
+	for written < len(changes) {
+		end := written + batchSize
+		if end > len(changes) {
+			end = len(changes)
+		}
+		if err := us.ApplyChanges(changes[written:end]); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// EnableFilter builds an in-memory filter of the stored coins, with room
+// for at least capacity of them, so checks for coins that were never
+// stored, like the inputs of garbage transactions, skip the disk. The
+// filter grows when it fills up.
+func (us *UTXOStorage) EnableFilter(capacity int) error {
+	us.filterMu.Lock()
+	defer us.filterMu.Unlock()
+	return us.buildFilterLocked(capacity)
+}
+
+// FilterStats reports how the filter performs, nil if it isn't enabled
+func (us *UTXOStorage) FilterStats() *FilterStats {
+	us.filterMu.RLock()
+	defer us.filterMu.RUnlock()
+	if us.filter == nil {
+		return nil
+	}
+	return &FilterStats{
+		Entries:        us.filter.count,
+		Capacity:       us.filter.slots(),
+		Bytes:          int64(us.filter.slots()) * 2,
+		Checks:         us.checks.Load(),
+		Skipped:        us.skipped.Load(),
+		FalsePositives: us.falsePositives.Load(),
+		Rebuilds:       us.filterRebuilds,
+	}
+}
+
+// buildFilterLocked fills a new filter from the database, doubling its
+// size until every coin fits. The caller must hold filterMu.
+func (us *UTXOStorage) buildFilterLocked(capacity int) error {
+	if capacity < filterBucketSize {
+		capacity = filterBucketSize
+	}
+	for {
+		filter := newCuckooFilter(capacity)
+		full := false
+
+		iter := us.db.NewIterator([]byte{'u'})
+		for iter.Next() {
+			outpoint, err := OutPointFromBytes(iter.Key()[1:])
+			if err != nil {
+				iter.Release()
+				return err
+			}
+			if !filter.insert(outpoint) {
+				full = true
+				break
+			}
 		}
+		err := iter.Error()
+		iter.Release()
+		if err != nil {
+			return err
+		}
+
+		if !full {
+			us.filter = filter
+			return nil
+		}
+		capacity = filter.slots() * 2
 	}
+}
+
+// filterCheck asks the filter whether outpoint may be stored. maybe is
+// false only when it certainly isn't; filtered is whether a filter was
+// asked at all.
+func (us *UTXOStorage) filterCheck(outpoint OutPoint) (maybe, filtered bool) {
+	us.filterMu.RLock()
+	defer us.filterMu.RUnlock()
+	if us.filter == nil {
+		return true, false
+	}
+	us.checks.Add(1)
+	if !us.filter.contains(outpoint) {
+		us.skipped.Add(1)
+		return false, true
+	}
+	return true, true
+}
+
+// filterAddLocked adds stored coins to the filter, rebuilding it larger if
+// it fills up. The caller must hold filterMu.
+func (us *UTXOStorage) filterAddLocked(outpoints ...OutPoint) error {
+	if us.filter == nil {
+		return nil
+	}
+	for _, outpoint := range outpoints {
+		if !us.filter.insert(outpoint) {
+			// The database already has every coin, this one included
+			us.filterRebuilds++
+			return us.buildFilterLocked(us.filter.slots() * 2)
+		}
+	}
+	return nil
+}
 
-	return batch.Write()
+// filterStoredLocked reports whether a coin about to be deleted is stored,
+// and so may be taken out of the filter. Deleting an outpoint that never
+// went in could remove another coin's fingerprint. The caller must hold
+// filterMu.
+func (us *UTXOStorage) filterStoredLocked(outpoint OutPoint) (bool, error) {
+	if us.filter == nil || !us.filter.contains(outpoint) {
+		return false, nil
+	}
+	return us.db.Has(utxoKey(outpoint))
 }
 
 // Count returns the number of UTXOs in storage
@@ -151,3 +376,51 @@ func (us *UTXOStorage) Count() (int, error) {
 
 	return count, nil
 }
+
+// SaveBestBlock records the block the stored coins are as of. The zero
+// hash marks them as belonging to no block, as while a flush is writing
+// them.
+func (us *UTXOStorage) SaveBestBlock(hash types.Hash) error {
+	return us.db.Put(bestBlockKey, hash[:])
+}
+
+// BestBlock returns the block the stored coins are as of, zero if that
+// isn't known
+func (us *UTXOStorage) BestBlock() (types.Hash, error) {
+	var hash types.Hash
+	value, err := us.db.Get(bestBlockKey)
+	if err != nil || value == nil {
+		return hash, err
+	}
+	if len(value) != len(hash) {
+		return hash, fmt.Errorf("invalid best block record of %d bytes", len(value))
+	}
+	copy(hash[:], value)
+	return hash, nil
+}
+
+// Clear deletes every stored coin and the best block in one batch
+func (us *UTXOStorage) Clear() error {
+	batch := us.db.NewBatch()
+
+	iter := us.db.NewIterator([]byte{'u'})
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	err := iter.Error()
+	iter.Release()
+	if err != nil {
+		return err
+	}
+	batch.Delete(bestBlockKey)
+
+	us.filterMu.Lock()
+	defer us.filterMu.Unlock()
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if us.filter != nil {
+		us.filter = newCuckooFilter(us.filter.slots())
+	}
+	return nil
+}
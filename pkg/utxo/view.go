@@ -0,0 +1,239 @@
+package utxo
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// View is the access to unspent outputs that block validation needs.
+// UTXOSet, UTXOView and UTXOCache implement it.
+type View interface {
+	Get(outpoint OutPoint) (*UTXO, error)
+	Exists(outpoint OutPoint) bool
+	Add(utxo *UTXO) error
+	Remove(outpoint OutPoint) error
+	ApplyTransaction(tx *types.Transaction, txHash types.Hash, height uint64, isCoinbase bool) error
+	RevertTransaction(tx *types.Transaction, txHash types.Hash) error
+}
+
+// UTXOView is a copy-on-write overlay on another View. Reads fall through
+// to the base; changes stay in the view until Commit, so a batch of blocks
+// or transactions can be tried out and thrown away without touching the
+// base. Views stack: a view over a view commits into the one below it.
+type UTXOView struct {
+	base    View
+	added   map[string]*UTXO
+	removed map[string]OutPoint // Base entries spent in the view
+	mu      sync.RWMutex
+}
+
+// NewUTXOView creates an empty overlay on base
+func NewUTXOView(base View) *UTXOView {
+	return &UTXOView{
+		base:    base,
+		added:   make(map[string]*UTXO),
+		removed: make(map[string]OutPoint),
+	}
+}
+
+// Get retrieves a UTXO from the view
+func (v *UTXOView) Get(outpoint OutPoint) (*UTXO, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	key := outpoint.String()
+	if utxo, ok := v.added[key]; ok {
+		return utxo.Clone(), nil
+	}
+	if _, spent := v.removed[key]; spent {
+		return nil, fmt.Errorf("UTXO not found: %s", key)
+	}
+	return v.base.Get(outpoint)
+}
+
+// Exists checks if a UTXO exists in the view
+func (v *UTXOView) Exists(outpoint OutPoint) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.exists(outpoint.String(), outpoint)
+}
+
+// Add adds a UTXO to the view
+func (v *UTXOView) Add(utxo *UTXO) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	outpoint := utxo.OutPoint()
+	key := outpoint.String()
+	if v.exists(key, outpoint) {
+		return fmt.Errorf("UTXO already exists: %s", key)
+	}
+
+	v.added[key] = utxo.Clone()
+	return nil
+}
+
+// Remove removes a UTXO from the view
+func (v *UTXOView) Remove(outpoint OutPoint) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key := outpoint.String()
+	if !v.exists(key, outpoint) {
+		return fmt.Errorf("UTXO not found: %s", key)
+	}
+
+	v.spend(key, outpoint)
+	return nil
+}
+
+// ApplyTransaction spends a transaction's inputs and adds its outputs in
+// the view, like UTXOSet.ApplyTransaction
+func (v *UTXOView) ApplyTransaction(tx *types.Transaction, txHash types.Hash, height uint64, isCoinbase bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !isCoinbase {
+		for _, input := range tx.Inputs {
+			outpoint := NewOutPoint(input.PrevTxHash, input.OutputIndex)
+			key := outpoint.String()
+			if !v.exists(key, outpoint) {
+				return fmt.Errorf("trying to spend non-existent UTXO: %s", key)
+			}
+			v.spend(key, outpoint)
+		}
+	}
+
+	for i, output := range tx.Outputs {
+		utxo := NewUTXO(txHash, uint32(i), output, height, isCoinbase)
+		v.added[utxo.OutPoint().String()] = utxo
+	}
+
+	return nil
+}
+
+// RevertTransaction removes a transaction's outputs from the view. As with
+// UTXOSet, restoring the outputs it spent is up to the caller.
+func (v *UTXOView) RevertTransaction(tx *types.Transaction, txHash types.Hash) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for i := range tx.Outputs {
+		outpoint := NewOutPoint(txHash, uint32(i))
+		key := outpoint.String()
+		if v.exists(key, outpoint) {
+			v.spend(key, outpoint)
+		}
+	}
+
+	return nil
+}
+
+// GetAll returns every entry visible in the view. Only entries of a base
+// that can list them, as UTXOSet and UTXOView can, are included.
+func (v *UTXOView) GetAll() []*UTXO {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var result []*UTXO
+	if lister, ok := v.base.(interface{ GetAll() []*UTXO }); ok {
+		for _, utxo := range lister.GetAll() {
+			key := utxo.OutPoint().String()
+			if _, spent := v.removed[key]; spent {
+				continue
+			}
+			if _, replaced := v.added[key]; replaced {
+				continue
+			}
+			result = append(result, utxo)
+		}
+	}
+	for _, utxo := range v.added {
+		result = append(result, utxo.Clone())
+	}
+
+	return result
+}
+
+// Changes returns the number of entries the view adds and removes
+func (v *UTXOView) Changes() (added, removed int) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return len(v.added), len(v.removed)
+}
+
+// Commit writes the view's changes to the base and empties the view. A
+// UTXOSet base is updated in one step; any other base change by change,
+// stopping at the first one the base refuses, after which the view is
+// left as it was and the base partly updated.
+func (v *UTXOView) Commit() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if set, ok := v.base.(*UTXOSet); ok {
+		set.mu.Lock()
+		for key := range v.removed {
+			delete(set.utxos, key)
+		}
+		for key, utxo := range v.added {
+			set.utxos[key] = utxo
+		}
+		set.mu.Unlock()
+	} else {
+		for _, outpoint := range v.removed {
+			if err := v.base.Remove(outpoint); err != nil {
+				return fmt.Errorf("failed to commit view: %w", err)
+			}
+		}
+		for _, utxo := range v.added {
+			// An output recreated under a txid that is still unspent
+			// replaces the old entry, as in UTXOSet.ApplyTransaction
+			if v.base.Exists(utxo.OutPoint()) {
+				if err := v.base.Remove(utxo.OutPoint()); err != nil {
+					return fmt.Errorf("failed to commit view: %w", err)
+				}
+			}
+			if err := v.base.Add(utxo); err != nil {
+				return fmt.Errorf("failed to commit view: %w", err)
+			}
+		}
+	}
+
+	v.reset()
+	return nil
+}
+
+// Discard drops the view's changes, leaving it an empty overlay again
+func (v *UTXOView) Discard() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.reset()
+}
+
+// reset empties the view. The caller must hold v.mu.
+func (v *UTXOView) reset() {
+	v.added = make(map[string]*UTXO)
+	v.removed = make(map[string]OutPoint)
+}
+
+// exists reports whether an entry is visible. The caller must hold v.mu.
+func (v *UTXOView) exists(key string, outpoint OutPoint) bool {
+	if _, ok := v.added[key]; ok {
+		return true
+	}
+	_, spent := v.removed[key]
+	return !spent && v.base.Exists(outpoint)
+}
+
+// spend hides an entry from the view. The caller must hold v.mu.
+func (v *UTXOView) spend(key string, outpoint OutPoint) {
+	delete(v.added, key)
+	if v.base.Exists(outpoint) {
+		v.removed[key] = outpoint
+	}
+}
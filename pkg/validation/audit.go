@@ -0,0 +1,162 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// BlockAudit holds the supply figures for a single block
+type BlockAudit struct {
+	Height        uint64
+	Hash          types.Hash
+	TxCount       int
+	Subsidy       int64
+	Fees          int64
+	CoinbaseValue int64
+	Problem       string
+}
+
+// AuditReport summarizes a full walk of the chain
+type AuditReport struct {
+	Blocks         []BlockAudit
+	ExpectedSupply int64 // Sum of subsidies allowed by the schedule
+	IssuedSupply   int64 // Coinbase value minus fees actually claimed
+	TotalFees      int64
+	Violations     int
+}
+
+// AuditChain walks the best chain from genesis and checks every coinbase
+// against the rules' subsidy schedule and the fees of its block. Nil rules
+// audit against mainnet's.
+func AuditChain(chain *storage.BlockchainStorage, rules *consensus.ConsensusRules) (*AuditReport, error) {
+	if rules == nil {
+		rules = consensus.NewMainnetRules()
+	}
+	report := &AuditReport{}
+
+	isEmpty, err := chain.IsEmpty()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chain state: %w", err)
+	}
+	if isEmpty {
+		return report, nil
+	}
+
+	bestHeight, err := chain.GetBestBlockHeight()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get best height: %w", err)
+	}
+
+	// Output values seen so far, used to compute fees without extra lookups
+	outputs := make(map[types.OutPoint]int64)
+
+	for height := uint64(0); height <= bestHeight; height++ {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load block %d: %w", height, err)
+		}
+
+		hash, err := chain.GetBlockHash(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash block %d: %w", height, err)
+		}
+
+		audit := BlockAudit{
+			Height:  height,
+			Hash:    hash,
+			TxCount: len(block.Transactions),
+			Subsidy: int64(rules.GetBlockSubsidy(height)),
+		}
+
+		for i := range block.Transactions {
+			tx := &block.Transactions[i]
+			txHash, err := serialization.HashTransaction(tx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash transaction in block %d: %w", height, err)
+			}
+
+			if transaction.IsCoinbase(tx) {
+				if i != 0 {
+					audit.Problem = fmt.Sprintf("coinbase at position %d", i)
+				}
+				for _, output := range tx.Outputs {
+					audit.CoinbaseValue += output.Value
+				}
+			} else {
+				totalIn := int64(0)
+				for _, input := range tx.Inputs {
+					outpoint := types.OutPoint{Hash: input.PrevTxHash, Index: input.OutputIndex}
+					value, exists := outputs[outpoint]
+					if !exists {
+						audit.Problem = fmt.Sprintf("tx %s spends unknown output %s", txHash, outpoint)
+						continue
+					}
+					totalIn += value
+					delete(outputs, outpoint)
+				}
+
+				totalOut := int64(0)
+				for _, output := range tx.Outputs {
+					totalOut += output.Value
+				}
+				audit.Fees += totalIn - totalOut
+			}
+
+			for index, output := range tx.Outputs {
+				outputs[types.OutPoint{Hash: txHash, Index: uint32(index)}] = output.Value
+			}
+		}
+
+		if err := checkBlockReward(audit.CoinbaseValue, audit.Fees, audit.Subsidy); err != nil && audit.Problem == "" {
+			audit.Problem = err.Error()
+		}
+
+		if audit.Problem != "" {
+			report.Violations++
+		}
+
+		report.ExpectedSupply += audit.Subsidy
+		report.IssuedSupply += audit.CoinbaseValue - audit.Fees
+		report.TotalFees += audit.Fees
+		report.Blocks = append(report.Blocks, audit)
+	}
+
+	return report, nil
+}
+
+// HalvingEra is a halving interval of blocks paying the same subsidy
+type HalvingEra struct {
+	Era         uint64
+	StartHeight uint64
+	Subsidy     int64
+	SupplyAtEnd int64 // Every subsidy up to the era's last block
+}
+
+// HalvingSchedule lists the rules' eras with a non-zero subsidy, oldest
+// first. Nil rules list mainnet's.
+func HalvingSchedule(rules *consensus.ConsensusRules) []HalvingEra {
+	if rules == nil {
+		rules = consensus.NewMainnetRules()
+	}
+	interval := uint64(rules.SubsidyHalvingInterval)
+
+	var eras []HalvingEra
+
+	supply := int64(0)
+	for era := uint64(0); era < 64; era++ {
+		start := era * interval
+		subsidy := int64(rules.GetBlockSubsidy(start))
+		if subsidy == 0 {
+			break
+		}
+		supply += subsidy * int64(interval)
+		eras = append(eras, HalvingEra{Era: era, StartHeight: start, Subsidy: subsidy, SupplyAtEnd: supply})
+	}
+
+	return eras
+}
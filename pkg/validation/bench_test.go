@@ -0,0 +1,35 @@
+package validation_test
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// Run with e.g. `go test ./pkg/validation -run=^$ -bench=. -benchmem`
+
+func BenchmarkConnectBlock(b *testing.B) {
+	block, set, err := testharness.SyntheticBlock(testharness.BenchBlockConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		view := set.Clone()
+		b.StartTimer()
+
+		validator := validation.NewBlockValidator(view)
+		if err := validator.ValidateBlock(block, 1, types.Hash{}); err != nil {
+			b.Fatal(err)
+		}
+		if err := validator.ApplyBlock(block, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(block.Transactions)*b.N)/b.Elapsed().Seconds(), "tx/s")
+}
@@ -3,8 +3,12 @@ package validation
 import (
 	"fmt"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
@@ -12,79 +16,162 @@ import (
 
 // BlockValidator validates blocks
 type BlockValidator struct {
-	utxoSet *utxo.UTXOSet
+	utxoSet    utxo.View
+	rules      *consensus.ConsensusRules
+	blockchain *storage.BlockchainStorage // Looks up the assumevalid block, nil = never assume
+
+	versionBits *consensus.VersionBits // Deployment states under rules
 }
 
-// NewBlockValidator creates a new block validator
-func NewBlockValidator(utxoSet *utxo.UTXOSet) *BlockValidator {
+// NewBlockValidator creates a block validator over a UTXO set or view,
+// checking rewards against the mainnet subsidy schedule
+func NewBlockValidator(utxoSet utxo.View) *BlockValidator {
+	rules := consensus.NewMainnetRules()
 	return &BlockValidator{
-		utxoSet: utxoSet,
+		utxoSet:     utxoSet,
+		rules:       rules,
+		versionBits: consensus.NewVersionBits(rules),
 	}
 }
 
+// SetRules sets the consensus rules the validator enforces
+func (bv *BlockValidator) SetRules(rules *consensus.ConsensusRules) {
+	bv.rules = rules
+	bv.versionBits = consensus.NewVersionBits(rules)
+}
+
+// SetBlockchain sets the chain the rules' AssumeValid block is looked up
+// in. Without one every script is checked.
+func (bv *BlockValidator) SetBlockchain(blockchain *storage.BlockchainStorage) {
+	bv.blockchain = blockchain
+}
+
 // ValidateBlock performs full block validation
 func (bv *BlockValidator) ValidateBlock(block *types.Block, height uint64, prevBlockHash types.Hash) error {
+	return bv.checkBlock(block, height, prevBlockHash, true).Err()
+}
+
+// CheckBlock runs every rule ValidateBlock does without stopping at the
+// first failure, and reports each outcome along with the block's fees,
+// sigops, size and weight. Rules an earlier failure leaves nothing to
+// check are skipped. Nothing is applied.
+func (bv *BlockValidator) CheckBlock(block *types.Block, height uint64, prevBlockHash types.Hash) *BlockReport {
+	return bv.checkBlock(block, height, prevBlockHash, false)
+}
+
+// checkBlock runs the block rules in order. With stop set it returns at
+// the first failure.
+func (bv *BlockValidator) checkBlock(block *types.Block, height uint64, prevBlockHash types.Hash, stop bool) *BlockReport {
+	report := newBlockReport(block, height, !stop)
+	if report.Err() != nil {
+		return report
+	}
+	c := &reportChecker{report: report, stop: stop}
+	checkScripts := !bv.assumedValid(report.Hash, height)
+
 	// 1. Validate block header
-	if err := bv.validateBlockHeader(&block.Header, prevBlockHash); err != nil {
-		return fmt.Errorf("invalid block header: %w", err)
+	if !c.check(RuleHeader, wrapErr("invalid block header", bv.validateBlockHeader(&block.Header, prevBlockHash))) {
+		return report
 	}
 
-	// 2. Check block size
-	blockSize, err := serialization.SerializeBlock(block)
-	if err != nil {
-		return err
+	// 2. Check block weight. Without witness data this is four times the
+	// size, so it also enforces the old 1 MB limit.
+	var err error
+	if report.Weight > int(bv.rules.MaxBlockWeight) {
+		err = rejectf(RejectBadBlockWeight, "block weight too high: %d > %d", report.Weight, bv.rules.MaxBlockWeight)
 	}
-	if len(blockSize) > MaxBlockSize {
-		return fmt.Errorf("block too large: %d > %d", len(blockSize), MaxBlockSize)
+	if !c.check(RuleWeight, err) {
+		return report
 	}
 
 	// 3. Validate transactions
+	err = nil
 	if len(block.Transactions) == 0 {
-		return fmt.Errorf("block has no transactions")
+		err = rejectf(RejectNoTransactions, "block has no transactions")
+	}
+	if !c.check(RuleHasTransactions, err) {
+		return report
+	}
+	if err != nil {
+		c.skip(RuleCoinbaseFirst, RuleSingleCoinbase, RuleCoinbase, RuleTransactions, RuleCoinbaseReward,
+			RuleMerkleRoot, RuleUniqueTxs, RuleBIP30, RuleWitnessCommitment)
+		return report
 	}
 
 	// 4. First transaction must be coinbase
+	err = nil
 	if !transaction.IsCoinbase(&block.Transactions[0]) {
-		return fmt.Errorf("first transaction is not coinbase")
+		err = rejectf(RejectNoCoinbase, "first transaction is not coinbase")
 	}
+	if !c.check(RuleCoinbaseFirst, err) {
+		return report
+	}
+	hasCoinbase := err == nil
 
 	// 5. Only first transaction can be coinbase
+	err = nil
 	for i := 1; i < len(block.Transactions); i++ {
 		if transaction.IsCoinbase(&block.Transactions[i]) {
-			return fmt.Errorf("coinbase transaction at index %d (must be first)", i)
+			err = rejectf(RejectMultipleCoinbase, "coinbase transaction at index %d (must be first)", i)
+			break
 		}
 	}
+	if !c.check(RuleSingleCoinbase, err) {
+		return report
+	}
 
 	// 6. Validate coinbase
-	if err := transaction.ValidateCoinbase(&block.Transactions[0], height); err != nil {
-		return fmt.Errorf("invalid coinbase: %w", err)
+	if hasCoinbase {
+		if !c.check(RuleCoinbase, wrapErr("invalid coinbase", transaction.ValidateCoinbase(&block.Transactions[0], height))) {
+			return report
+		}
+	} else {
+		c.skip(RuleCoinbase)
 	}
 
-	// 7. Validate all transactions
-	totalFees := int64(0)
+	// 7. Validate all transactions. Taproot signatures are queued and
+	// verified together at the end.
+	var txErr error
+	sigs := &blockSigs{batch: keys.NewSchnorrBatch()}
 	for i, tx := range block.Transactions {
 		if i == 0 {
 			continue // Skip coinbase
 		}
+		sigs.tx = i
 
 		// Basic validation
 		if err := transaction.ValidateTransaction(&tx); err != nil {
-			return fmt.Errorf("transaction %d invalid: %w", i, err)
+			txErr = fmt.Errorf("transaction %d invalid: %w", i, err)
+			break
 		}
 
 		// Check inputs against UTXO set
-		fee, err := bv.validateTransactionInputs(&tx)
+		fee, err := bv.validateTransactionInputs(&tx, height, checkScripts, sigs)
 		if err != nil {
-			return fmt.Errorf("transaction %d inputs invalid: %w", i, err)
+			txErr = fmt.Errorf("transaction %d inputs invalid: %w", i, err)
+			break
 		}
 
-		totalFees += fee
+		if report.Fees, err = addMoney(report.Fees, fee); err != nil {
+			txErr = fmt.Errorf("transaction %d fee invalid: %w", i, err)
+			break
+		}
+	}
+	if txErr == nil {
+		txErr = sigs.verify()
+	}
+	if !c.check(RuleTransactions, txErr) {
+		return report
 	}
 
-	// 8. Validate coinbase reward
-	coinbaseValue := block.Transactions[0].Outputs[0].Value
-	if err := ValidateBlockReward(coinbaseValue, totalFees, height); err != nil {
-		return fmt.Errorf("invalid block reward: %w", err)
+	// 8. Validate coinbase reward across all its outputs. The fees are
+	// only known if every transaction checked out.
+	if hasCoinbase && txErr == nil {
+		if !c.check(RuleCoinbaseReward, bv.checkCoinbaseReward(block, height, report)) {
+			return report
+		}
+	} else {
+		c.skip(RuleCoinbaseReward)
 	}
 
 	// 9. Verify merkle root
@@ -92,27 +179,96 @@ func (bv *BlockValidator) ValidateBlock(block *types.Block, height uint64, prevB
 	for _, tx := range block.Transactions {
 		txHash, err := serialization.HashTransaction(&tx)
 		if err != nil {
-			return err
+			c.check(RuleEncoding, err)
+			return report
 		}
 		txHashes = append(txHashes, txHash)
 	}
 
+	err = nil
 	calculatedMerkleRoot := crypto.ComputeMerkleRoot(txHashes)
 	if calculatedMerkleRoot != block.Header.MerkleRoot {
-		return fmt.Errorf("merkle root mismatch: expected %s, got %s",
+		err = rejectf(RejectBadMerkleRoot, "merkle root mismatch: expected %s, got %s",
 			block.Header.MerkleRoot, calculatedMerkleRoot)
 	}
+	if !c.check(RuleMerkleRoot, err) {
+		return report
+	}
 
 	// 10. Check for duplicate transactions
+	err = nil
 	seen := make(map[types.Hash]bool)
-	for _, tx := range block.Transactions {
-		txHash, _ := serialization.HashTransaction(&tx)
+	for _, txHash := range txHashes {
 		if seen[txHash] {
-			return fmt.Errorf("duplicate transaction: %s", txHash)
+			err = rejectf(RejectDuplicateTx, "duplicate transaction: %s", txHash)
+			break
 		}
 		seen[txHash] = true
 	}
+	if !c.check(RuleUniqueTxs, err) {
+		return report
+	}
+
+	// 11. BIP30: a transaction may not reuse the txid of one with unspent
+	// outputs, or it would overwrite them. Before BIP34 put the height in
+	// the coinbase, identical coinbases in different blocks did exactly that.
+	err = nil
+	if !bv.rules.IsBIP30Exception(height, report.Hash) {
+		err = bv.checkDuplicateTxids(block)
+	}
+	if !c.check(RuleBIP30, err) {
+		return report
+	}
+
+	// 12. Witness data must match the coinbase commitment
+	if hasCoinbase {
+		err = checkWitnessCommitment(block, bv.rules.IsSegWitActive(height))
+		if !c.check(RuleWitnessCommitment, wrapErr("invalid witness", err)) {
+			return report
+		}
+	} else {
+		c.skip(RuleWitnessCommitment)
+	}
+
+	// 13. On signet the coinbase must carry the issuer's signature
+	if len(bv.rules.SignetChallenge) > 0 {
+		c.check(RuleSignet, wrapErr("invalid signet block", CheckSignetSolution(block, bv.rules.SignetChallenge)))
+	}
+
+	return report
+}
+
+// checkCoinbaseReward checks the coinbase pays no more than the subsidy
+// plus the report's fees, noting its value in the report
+func (bv *BlockValidator) checkCoinbaseReward(block *types.Block, height uint64, report *BlockReport) error {
+	coinbaseValue := int64(0)
+	for i, output := range block.Transactions[0].Outputs {
+		var err error
+		if coinbaseValue, err = addMoney(coinbaseValue, output.Value); err != nil {
+			return fmt.Errorf("coinbase output %d invalid: %w", i, err)
+		}
+	}
+	report.CoinbaseValue = coinbaseValue
 
+	subsidy := int64(bv.rules.GetBlockSubsidy(height))
+	return wrapErr("invalid block reward", checkBlockReward(coinbaseValue, report.Fees, subsidy))
+}
+
+// checkDuplicateTxids fails if any transaction in the block has the txid
+// of a transaction whose outputs are still unspent
+func (bv *BlockValidator) checkDuplicateTxids(block *types.Block) error {
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		txHash, err := serialization.HashTransaction(tx)
+		if err != nil {
+			return err
+		}
+		for index := range tx.Outputs {
+			if bv.utxoSet.Exists(utxo.NewOutPoint(txHash, uint32(index))) {
+				return rejectf(RejectBIP30, "BIP30: transaction %s would overwrite unspent output %d", txHash, index)
+			}
+		}
+	}
 	return nil
 }
 
@@ -120,7 +276,7 @@ func (bv *BlockValidator) ValidateBlock(block *types.Block, height uint64, prevB
 func (bv *BlockValidator) validateBlockHeader(header *types.BlockHeader, prevBlockHash types.Hash) error {
 	// 1. Check previous block hash
 	if header.PrevBlockHash != prevBlockHash {
-		return fmt.Errorf("previous block hash mismatch")
+		return rejectf(RejectBadPrevBlock, "previous block hash mismatch")
 	}
 
 	// 2. Check proof of work
@@ -130,7 +286,7 @@ func (bv *BlockValidator) validateBlockHeader(header *types.BlockHeader, prevBlo
 	}
 
 	if !IsValidProofOfWork(blockHash[:], header.Bits) {
-		return fmt.Errorf("insufficient proof of work")
+		return rejectf(RejectHighHash, "insufficient proof of work")
 	}
 
 	// 3. Check timestamp (simplified - should not be too far in future)
@@ -139,9 +295,28 @@ func (bv *BlockValidator) validateBlockHeader(header *types.BlockHeader, prevBlo
 	return nil
 }
 
-// validateTransactionInputs validates transaction inputs against UTXO set
-func (bv *BlockValidator) validateTransactionInputs(tx *types.Transaction) (int64, error) {
+// assumedValid reports whether a block is the rules' AssumeValid block or
+// one of its ancestors, so its scripts needn't be checked
+func (bv *BlockValidator) assumedValid(hash types.Hash, height uint64) bool {
+	if bv.rules.AssumeValid.IsZero() || bv.blockchain == nil {
+		return false
+	}
+	if hash == bv.rules.AssumeValid {
+		return true
+	}
+
+	// An unknown assumevalid block, or one below this height, gives an
+	// error or a different hash and the scripts are checked
+	ancestor, err := bv.blockchain.GetAncestor(bv.rules.AssumeValid, height)
+	return err == nil && ancestor == hash
+}
+
+// validateTransactionInputs validates transaction inputs against UTXO set,
+// running their scripts if checkScripts is set, for a block at height.
+// With sigs, taproot signatures are left in it to verify later.
+func (bv *BlockValidator) validateTransactionInputs(tx *types.Transaction, height uint64, checkScripts bool, sigs *blockSigs) (int64, error) {
 	totalIn := int64(0)
+	prevOuts := make([]types.TxOutput, len(tx.Inputs))
 
 	for i, input := range tx.Inputs {
 		// Get the UTXO being spent
@@ -149,52 +324,160 @@ func (bv *BlockValidator) validateTransactionInputs(tx *types.Transaction) (int6
 
 		spentUTXO, err := bv.utxoSet.Get(outpoint)
 		if err != nil {
-			return 0, fmt.Errorf("input %d: UTXO not found: %s", i, outpoint)
+			return 0, rejectf(RejectMissingInputs, "input %d: UTXO not found: %s", i, outpoint)
 		}
+		prevOuts[i] = spentUTXO.Output
 
 		// Check if UTXO is mature (for coinbase)
 		// Note: We'd need current height for this - simplified for now
 
-		// Validate script
-		if err := bv.validateInputScript(&input, &spentUTXO.Output, tx, i); err != nil {
-			return 0, fmt.Errorf("input %d: script validation failed: %w", i, err)
+		if totalIn, err = addMoney(totalIn, spentUTXO.Value()); err != nil {
+			return 0, fmt.Errorf("input %d: %w", i, err)
 		}
+	}
 
-		totalIn += spentUTXO.Value()
+	// Validate scripts. Taproot signatures commit to every spent output,
+	// so they run once all are known.
+	if checkScripts {
+		flags := scriptFlags{segwit: bv.rules.IsSegWitActive(height), taproot: bv.taprootActive(height)}
+		for i := range tx.Inputs {
+			if err := bv.validateInputScript(tx, i, prevOuts, flags, sigs.queue()); err != nil {
+				return 0, rejectf(RejectScriptFailed, "input %d: script validation failed: %w", i, err)
+			}
+			sigs.record(i)
+		}
 	}
 
 	// Calculate total outputs
 	totalOut := int64(0)
-	for _, output := range tx.Outputs {
-		totalOut += output.Value
-
-		// Check money range
-		if err := CheckMoneyRange(output.Value); err != nil {
-			return 0, err
+	for i, output := range tx.Outputs {
+		sum, err := addMoney(totalOut, output.Value)
+		if err != nil {
+			return 0, fmt.Errorf("output %d: %w", i, err)
 		}
+		totalOut = sum
 	}
 
 	// Calculate fee
 	fee := totalIn - totalOut
 	if fee < 0 {
-		return 0, fmt.Errorf("outputs exceed inputs")
+		return 0, rejectf(RejectInBelowOut, "outputs exceed inputs")
 	}
 
 	return fee, nil
 }
 
-// validateInputScript validates input script against output script
-func (bv *BlockValidator) validateInputScript(input *types.TxInput, prevOutput *types.TxOutput, tx *types.Transaction, inputIdx int) error {
-	// For now, we'll do a simplified validation
-	// Full implementation would execute the script
+// scriptFlags are the soft forks whose script rules apply to a block
+type scriptFlags struct {
+	segwit  bool
+	taproot bool
+}
 
-	// Combine unlocking and locking scripts
-	_ = append(input.SignatureScript, prevOutput.PubKeyScript...)
+// blockSigs holds the Schnorr signatures of a block's taproot inputs, so
+// they can be verified as one batch once every script has run
+type blockSigs struct {
+	batch  *keys.SchnorrBatch
+	tx     int        // Index of the transaction being validated
+	inputs []inputRef // Where each queued signature came from
+}
 
-	// TODO: Execute script with transaction context
-	// This would use the script engine from Milestone 4
+// inputRef is an input of a block's transaction
+type inputRef struct {
+	tx, input int
+}
 
-	return nil
+// queue returns the batch to queue signatures in, nil to verify them
+// straight away
+func (s *blockSigs) queue() *keys.SchnorrBatch {
+	if s == nil {
+		return nil
+	}
+	return s.batch
+}
+
+// record notes that the signatures queued since the last call came from
+// input
+func (s *blockSigs) record(input int) {
+	if s == nil {
+		return
+	}
+	for len(s.inputs) < s.batch.Len() {
+		s.inputs = append(s.inputs, inputRef{tx: s.tx, input: input})
+	}
+}
+
+// verify checks the queued signatures. The batch only says whether all
+// are valid, so on failure they are verified one by one to name the
+// input at fault.
+func (s *blockSigs) verify() error {
+	if s.batch.Verify() {
+		return nil
+	}
+	bad := s.batch.FirstInvalid()
+	if bad < 0 {
+		return nil
+	}
+	ref := s.inputs[bad]
+	return fmt.Errorf("transaction %d inputs invalid: %w", ref.tx,
+		rejectf(RejectScriptFailed, "input %d: script validation failed: %w", ref.input, script.ErrSchnorrSig))
+}
+
+// taprootActive reports whether taproot outputs are spent under BIP341
+// rules at height. Its versionbits state needs the chain's headers, so
+// without a chain only an always-active deployment counts.
+func (bv *BlockValidator) taprootActive(height uint64) bool {
+	d, err := bv.rules.Deployment("taproot")
+	if err != nil {
+		return false
+	}
+	if d.StartTime == consensus.AlwaysActive {
+		return true
+	}
+	if bv.blockchain == nil {
+		return false
+	}
+	active, err := bv.versionBits.IsActive(bv.blockchain, "taproot", height)
+	return err == nil && active
+}
+
+// validateInputScript validates input inputIdx of tx against the output
+// it spends, prevOuts[inputIdx]. Once SegWit is active a version 0
+// witness program is spent by its witness, with signatures checked, and
+// likewise a taproot output once taproot is; before that, and for later
+// versions, they are anyone-can-spend as old nodes see them. Taproot
+// signatures go in batch if given.
+func (bv *BlockValidator) validateInputScript(tx *types.Transaction, inputIdx int, prevOuts []types.TxOutput, flags scriptFlags, batch *keys.SchnorrBatch) error {
+	input, prevOutput := &tx.Inputs[inputIdx], &prevOuts[inputIdx]
+	version, program, ok := script.ExtractWitnessProgram(prevOutput.PubKeyScript)
+	switch {
+	case flags.segwit && ok && version == 0:
+		return transaction.VerifyWitnessInput(tx, inputIdx, *prevOutput)
+	case flags.taproot && ok && version == 1 && len(program) == keys.XOnlyPubKeySize:
+		return transaction.VerifyTaprootInputBatch(tx, inputIdx, prevOuts, batch)
+	}
+
+	engine := script.NewInputEngine(input.SignatureScript, prevOutput.PubKeyScript)
+	engine.SetTransaction(tx, inputIdx)
+	return engine.Execute()
+}
+
+// AcceptTransaction validates a loose transaction's inputs and scripts and
+// applies it, returning its fee. Run over a UTXOView, a pool of unconfirmed
+// transactions can be checked in order, each spending the outputs of the
+// ones before it, without touching the canonical set.
+func (bv *BlockValidator) AcceptTransaction(tx *types.Transaction, height uint64) (int64, error) {
+	fee, err := bv.validateTransactionInputs(tx, height, true, nil)
+	if err != nil {
+		return 0, err
+	}
+	txHash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := bv.utxoSet.ApplyTransaction(tx, txHash, height, false); err != nil {
+		return 0, err
+	}
+	return fee, nil
 }
 
 // ApplyBlock applies a validated block to the UTXO set
@@ -233,3 +516,108 @@ func (bv *BlockValidator) RevertBlock(block *types.Block) error {
 
 	return nil
 }
+
+// UndoBlock reverts a block from the UTXO set, restoring the outputs its
+// transactions spent. There is no separate undo data, so the spent outputs
+// are looked up in the stored blocks that created them.
+func (bv *BlockValidator) UndoBlock(block *types.Block, chain *storage.BlockchainStorage) error {
+	// Walk backwards so a transaction spending an earlier one in the same
+	// block is undone first
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := &block.Transactions[i]
+		txHash, err := serialization.HashTransaction(tx)
+		if err != nil {
+			return err
+		}
+
+		if err := bv.utxoSet.RevertTransaction(tx, txHash); err != nil {
+			return fmt.Errorf("failed to revert transaction %d: %w", i, err)
+		}
+
+		if i == 0 {
+			continue // Coinbase spends nothing
+		}
+
+		for _, input := range tx.Inputs {
+			spent, err := LookupOutput(chain, input.PrevTxHash, input.OutputIndex)
+			if err != nil {
+				return fmt.Errorf("failed to restore input %s:%d: %w", input.PrevTxHash, input.OutputIndex, err)
+			}
+			if err := bv.utxoSet.Add(spent); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// LookupOutput rebuilds the UTXO for an output from the stored block that
+// created it
+func LookupOutput(chain *storage.BlockchainStorage, txHash types.Hash, index uint32) (*utxo.UTXO, error) {
+	blockHash, txIndex, err := chain.GetTransactionLocation(txHash)
+	if err != nil {
+		return nil, err
+	}
+	block, err := chain.GetBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	height, err := chain.GetBlockHeight(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(txIndex) >= len(block.Transactions) {
+		return nil, fmt.Errorf("transaction index %d out of range", txIndex)
+	}
+	tx := &block.Transactions[txIndex]
+	if int(index) >= len(tx.Outputs) {
+		return nil, fmt.Errorf("output index %d out of range", index)
+	}
+
+	return utxo.NewUTXO(txHash, index, tx.Outputs[index], height, txIndex == 0), nil
+}
+
+// CheckBlockSanity runs the checks that need no chain context: proof of
+// work, coinbase placement, merkle root and duplicate transactions. Side
+// chain blocks get these before they are stored.
+func CheckBlockSanity(block *types.Block) error {
+	blockHash, err := serialization.HashBlockHeader(&block.Header)
+	if err != nil {
+		return err
+	}
+	if !IsValidProofOfWork(blockHash[:], block.Header.Bits) {
+		return rejectf(RejectHighHash, "insufficient proof of work")
+	}
+
+	if len(block.Transactions) == 0 {
+		return rejectf(RejectNoTransactions, "block has no transactions")
+	}
+	if !transaction.IsCoinbase(&block.Transactions[0]) {
+		return rejectf(RejectNoCoinbase, "first transaction is not coinbase")
+	}
+
+	seen := make(map[types.Hash]bool)
+	txHashes := make([]types.Hash, 0, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		if i > 0 && transaction.IsCoinbase(&tx) {
+			return rejectf(RejectMultipleCoinbase, "coinbase transaction at index %d (must be first)", i)
+		}
+		txHash, err := serialization.HashTransaction(&tx)
+		if err != nil {
+			return err
+		}
+		if seen[txHash] {
+			return rejectf(RejectDuplicateTx, "duplicate transaction: %s", txHash)
+		}
+		seen[txHash] = true
+		txHashes = append(txHashes, txHash)
+	}
+
+	if root := crypto.ComputeMerkleRoot(txHashes); root != block.Header.MerkleRoot {
+		return rejectf(RejectBadMerkleRoot, "merkle root mismatch: expected %s, got %s", block.Header.MerkleRoot, root)
+	}
+
+	return nil
+}
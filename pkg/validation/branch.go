@@ -0,0 +1,100 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// branchHeaders reads headers along the chain ending in a stored block,
+// which need not be on the best chain
+type branchHeaders struct {
+	chain *storage.BlockchainStorage
+	tip   types.Hash
+}
+
+func (b branchHeaders) HeaderAt(height uint64) (*types.BlockHeader, error) {
+	block, err := ancestorBlock(b.chain, b.tip, height)
+	if err != nil {
+		return nil, err
+	}
+	return &block.Header, nil
+}
+
+// CheckBlockContext checks that a header at height builds on a stored
+// block of a valid branch and carries the bits that branch requires. A
+// side-chain block is judged by its own ancestors, so a branch can't
+// claim more work than it did. Nil rules check against mainnet's.
+func CheckBlockContext(chain *storage.BlockchainStorage, rules *consensus.ConsensusRules, header *types.BlockHeader, height uint64) error {
+	if rules == nil {
+		rules = consensus.NewMainnetRules()
+	}
+	if invalid, err := chain.IsBranchInvalid(header.PrevBlockHash); err != nil {
+		return err
+	} else if invalid {
+		return rejectf(RejectBadPrevBlock, "previous block %s is invalid", header.PrevBlockHash)
+	}
+
+	bits, err := rules.NextWorkRequired(branchHeaders{chain, header.PrevBlockHash}, height)
+	if err != nil {
+		return err
+	}
+	if header.Bits != bits {
+		return rejectf(RejectBadDiffBits, "bits %08x, want %08x", header.Bits, bits)
+	}
+	return nil
+}
+
+// ConnectBranch disconnects the best chain down to forkHeight and connects
+// branch, oldest first, in view, fully validating every block. view must
+// hold the UTXO set as of the best block. The first invalid block is
+// marked invalid, so getchaintips reports its branch as such, and its
+// error returned; view should then be discarded. Nil rules check against
+// mainnet's.
+func ConnectBranch(chain *storage.BlockchainStorage, rules *consensus.ConsensusRules, view utxo.View, branch []*types.Block, forkHeight uint64) error {
+	if len(branch) == 0 {
+		return fmt.Errorf("no blocks to connect")
+	}
+	if rules == nil {
+		rules = consensus.NewMainnetRules()
+	}
+	validator := NewBlockValidator(view)
+	validator.SetRules(rules)
+	validator.SetBlockchain(chain)
+
+	tipHeight, err := chain.GetBestBlockHeight()
+	if err != nil {
+		return err
+	}
+	for h := tipHeight; h > forkHeight; h-- {
+		block, err := chain.GetBlockByHeight(h)
+		if err != nil {
+			return fmt.Errorf("failed to get block at height %d: %w", h, err)
+		}
+		if err := validator.UndoBlock(block, chain); err != nil {
+			return fmt.Errorf("failed to undo block at height %d: %w", h, err)
+		}
+	}
+
+	prevHash := branch[0].Header.PrevBlockHash
+	for i, block := range branch {
+		height := forkHeight + uint64(i) + 1
+		hash, err := serialization.HashBlockHeader(&block.Header)
+		if err != nil {
+			return err
+		}
+		if err := validator.ValidateBlock(block, height, prevHash); err != nil {
+			chain.MarkInvalid(hash)
+			return fmt.Errorf("block at height %d invalid, keeping the current chain: %w", height, err)
+		}
+		if err := validator.ApplyBlock(block, height); err != nil {
+			return fmt.Errorf("failed to apply block at height %d: %w", height, err)
+		}
+		prevHash = hash
+	}
+	return nil
+}
@@ -1,8 +1,10 @@
 package validation
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
@@ -14,17 +16,45 @@ type ChainValidator struct {
 	blockchain *storage.BlockchainStorage
 	utxoSet    *utxo.UTXOSet
 	validator  *BlockValidator
+	rules      *consensus.ConsensusRules
+	reorg      *Reorganizer
 }
 
-// NewChainValidator creates a new chain validator
+// NewChainValidator creates a new chain validator using mainnet rules
 func NewChainValidator(blockchain *storage.BlockchainStorage, utxoSet *utxo.UTXOSet) *ChainValidator {
+	validator := NewBlockValidator(utxoSet)
+	validator.SetBlockchain(blockchain)
+
 	return &ChainValidator{
 		blockchain: blockchain,
 		utxoSet:    utxoSet,
-		validator:  NewBlockValidator(utxoSet),
+		validator:  validator,
+		rules:      consensus.NewMainnetRules(),
+		reorg:      NewReorganizer(blockchain),
 	}
 }
 
+// SetRules sets the consensus rules blocks are validated against
+func (cv *ChainValidator) SetRules(rules *consensus.ConsensusRules) {
+	cv.rules = rules
+	cv.validator.SetRules(rules)
+	cv.reorg.SetRules(rules)
+}
+
+// Reorganizer returns the reorganizer that switches the chain to better
+// branches, for setting its depth limit, mempool and listeners
+func (cv *ChainValidator) Reorganizer() *Reorganizer {
+	return cv.reorg
+}
+
+// newValidator creates a block validator over view with the chain's rules
+func (cv *ChainValidator) newValidator(view utxo.View) *BlockValidator {
+	validator := NewBlockValidator(view)
+	validator.SetRules(cv.rules)
+	validator.SetBlockchain(cv.blockchain)
+	return validator
+}
+
 // ValidateNewBlock validates a new block against the current chain
 func (cv *ChainValidator) ValidateNewBlock(block *types.Block) error {
 	// Get current chain tip
@@ -50,7 +80,10 @@ func (cv *ChainValidator) ValidateNewBlock(block *types.Block) error {
 	return nil
 }
 
-// AcceptBlock validates and adds a block to the chain
+// AcceptBlock validates and stores a block. A block extending the tip is
+// connected; a block on a competing branch is stored as a side-chain block,
+// and if its branch now has more work than the best chain the chain
+// reorganizes onto it.
 func (cv *ChainValidator) AcceptBlock(block *types.Block) error {
 	// Check if blockchain is empty (genesis block case)
 	isEmpty, err := cv.blockchain.IsEmpty()
@@ -59,13 +92,32 @@ func (cv *ChainValidator) AcceptBlock(block *types.Block) error {
 	}
 
 	var newHeight uint64
-	var prevHash types.Hash
 
 	if isEmpty {
 		// This is the genesis block
 		newHeight = 0
-		prevHash = types.Hash{} // No previous block
+		if err := cv.validator.ValidateBlock(block, newHeight, types.Hash{}); err != nil {
+			return fmt.Errorf("block validation failed: %w", err)
+		}
 	} else {
+		hash, err := serialization.HashBlockHeader(&block.Header)
+		if err != nil {
+			return err
+		}
+		if known, err := cv.blockchain.HasBlock(hash); err != nil {
+			return err
+		} else if known {
+			return nil
+		}
+
+		bestHash, err := cv.blockchain.GetBestBlockHash()
+		if err != nil {
+			return err
+		}
+		if block.Header.PrevBlockHash != bestHash {
+			return cv.acceptSideBlock(block, hash)
+		}
+
 		// Validate the block against current chain
 		if err := cv.ValidateNewBlock(block); err != nil {
 			return err
@@ -79,38 +131,74 @@ func (cv *ChainValidator) AcceptBlock(block *types.Block) error {
 		newHeight = bestHeight + 1
 	}
 
-	// Validate the block structure
-	if err := cv.validator.ValidateBlock(block, newHeight, prevHash); err != nil {
-		return fmt.Errorf("block validation failed: %w", err)
-	}
-
-	// Apply to UTXO set
-	if err := cv.validator.ApplyBlock(block, newHeight); err != nil {
+	// Stage the UTXO changes so a failed save leaves the set untouched
+	view := utxo.NewUTXOView(cv.utxoSet)
+	if err := cv.newValidator(view).ApplyBlock(block, newHeight); err != nil {
 		return fmt.Errorf("failed to apply block: %w", err)
 	}
 
 	// Save to blockchain
 	if err := cv.blockchain.SaveBlock(block, newHeight); err != nil {
-		// Revert UTXO changes on failure
-		cv.validator.RevertBlock(block)
 		return fmt.Errorf("failed to save block: %w", err)
 	}
+	if err := view.Commit(); err != nil {
+		return fmt.Errorf("failed to update UTXO set: %w", err)
+	}
 
 	return nil
 }
 
-// GetBlockLocator returns block locator for sync
+// acceptSideBlock stores a block whose parent is not the tip and switches
+// to its branch if that branch now has the most work
+func (cv *ChainValidator) acceptSideBlock(block *types.Block, hash types.Hash) error {
+	parentHeight, err := cv.blockchain.GetBlockHeight(block.Header.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("%w: parent %s not found", ErrOrphanBlock, block.Header.PrevBlockHash)
+	}
+	if err := CheckBlockSanity(block); err != nil {
+		return fmt.Errorf("block validation failed: %w", err)
+	}
+	if err := CheckBlockContext(cv.blockchain, cv.rules, &block.Header, parentHeight+1); err != nil {
+		return fmt.Errorf("block validation failed: %w", err)
+	}
+
+	if err := cv.blockchain.SaveSideBlock(block, parentHeight+1); err != nil {
+		return fmt.Errorf("failed to save side-chain block: %w", err)
+	}
+
+	branch, forkHeight, err := cv.blockchain.GetBranch(hash)
+	if err != nil {
+		return err
+	}
+	better, err := cv.blockchain.BranchHasMoreWork(branch, forkHeight)
+	if err != nil {
+		return err
+	}
+	if !better {
+		fmt.Printf("Stored side-chain block %s at height %d\n", hash, parentHeight+1)
+		return nil
+	}
+
+	return cv.Reorganize(branch)
+}
+
+// GetBlockLocator returns block locator for sync. An empty chain has an
+// empty locator.
 func (cv *ChainValidator) GetBlockLocator() ([]types.Hash, error) {
 	var locator []types.Hash
 
-	_, height, err := cv.blockchain.GetBestBlock()
+	_, height, err := cv.blockchain.GetTip()
+	if errors.Is(err, storage.ErrEmptyChain) {
+		return locator, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Add blocks with exponentially increasing gaps
+	// Add blocks with exponentially increasing gaps, stopping above
+	// genesis rather than stepping past it
 	step := uint64(1)
-	for h := height; h > 0; h -= step {
+	for h := height; h > 0; {
 		block, err := cv.blockchain.GetBlockByHeight(h)
 		if err != nil {
 			break
@@ -127,6 +215,10 @@ func (cv *ChainValidator) GetBlockLocator() ([]types.Hash, error) {
 		if len(locator) > 10 {
 			step *= 2
 		}
+		if h <= step {
+			break
+		}
+		h -= step
 	}
 
 	// Always include genesis
@@ -158,17 +250,30 @@ func (cv *ChainValidator) FindFork(otherHashes []types.Hash) (uint64, error) {
 	return 0, fmt.Errorf("no common ancestor found")
 }
 
-// Reorganize handles blockchain reorganization
+// Reorganize switches the best chain to newBlocks, which must build on a
+// block of the current best chain. The old branch is undone and the new one
+// validated in a copy-on-write view first, so an invalid block leaves the
+// chain and UTXO set as they were.
 func (cv *ChainValidator) Reorganize(newBlocks []*types.Block) error {
-	// This is a simplified version
-	// Real Bitcoin has more complex reorg logic
+	if len(newBlocks) == 0 {
+		return fmt.Errorf("no blocks to reorganize onto")
+	}
 
-	// Find fork point
-	// Disconnect blocks from old chain
-	// Connect blocks from new chain
+	forkHash := newBlocks[0].Header.PrevBlockHash
+	onMain, err := cv.blockchain.IsMainChain(forkHash)
+	if err != nil {
+		return fmt.Errorf("fork point %s not found: %w", forkHash, err)
+	}
+	if !onMain {
+		return fmt.Errorf("fork point %s is not on the best chain", forkHash)
+	}
+	forkHeight, err := cv.blockchain.GetBlockHeight(forkHash)
+	if err != nil {
+		return err
+	}
 
-	// For now, we'll implement a basic version
-	return fmt.Errorf("reorganization not fully implemented")
+	_, err = cv.reorg.Reorganize(cv.utxoSet, newBlocks, forkHeight)
+	return err
 }
 
 // GetChainWork calculates total chain work
@@ -184,7 +289,10 @@ func (cv *ChainValidator) GetChainWork() (uint64, error) {
 	return height, nil
 }
 
-// IsValidChain checks if the entire chain is valid
+// IsValidChain checks if the entire chain is valid. Besides validating
+// every block it asserts after each one that the coins in existence never
+// exceed the subsidies issued so far, so an inflation bug can't slip
+// through a gap in the per-block checks.
 func (cv *ChainValidator) IsValidChain() error {
 	_, height, err := cv.blockchain.GetBestBlock()
 	if err != nil {
@@ -193,7 +301,8 @@ func (cv *ChainValidator) IsValidChain() error {
 
 	// Create temporary UTXO set for validation
 	tempUTXO := utxo.NewUTXOSet()
-	tempValidator := NewBlockValidator(tempUTXO)
+	tempValidator := cv.newValidator(tempUTXO)
+	issued := int64(0)
 
 	// Validate each block sequentially
 	for h := uint64(0); h <= height; h++ {
@@ -221,6 +330,14 @@ func (cv *ChainValidator) IsValidChain() error {
 		if err := tempValidator.ApplyBlock(block, h); err != nil {
 			return fmt.Errorf("failed to apply block at height %d: %w", h, err)
 		}
+
+		// Fees only move existing coins, so the supply is bounded by the
+		// subsidies
+		issued += int64(cv.rules.GetBlockSubsidy(h))
+		supply := tempUTXO.TotalValue()
+		if supply > issued || CheckMoneyRange(supply) != nil {
+			return fmt.Errorf("supply %d at height %d exceeds the %d issued by subsidies", supply, h, issued)
+		}
 	}
 
 	return nil
@@ -252,5 +369,5 @@ func (cv *ChainValidator) GetBlockHeight(hash types.Hash) (uint64, error) {
 		}
 	}
 
-	return 0, fmt.Errorf("block not found")
+	return 0, fmt.Errorf("%w: %s", storage.ErrBlockNotFound, hash)
 }
@@ -51,13 +51,30 @@ func CheckMoneyRange(value int64) error {
 	return nil
 }
 
+// addMoney adds value to total, failing if either leaves the money range
+func addMoney(total, value int64) (int64, error) {
+	if err := CheckMoneyRange(value); err != nil {
+		return 0, err
+	}
+	total += value
+	if err := CheckMoneyRange(total); err != nil {
+		return 0, fmt.Errorf("total out of range: %w", err)
+	}
+	return total, nil
+}
+
 // ValidateBlockReward checks if block reward is correct
 func ValidateBlockReward(coinbaseValue int64, totalFees int64, height uint64) error {
-	expectedReward := GetBlockReward(height)
-	maxAllowed := expectedReward + totalFees
+	return checkBlockReward(coinbaseValue, totalFees, GetBlockReward(height))
+}
+
+// checkBlockReward checks that a coinbase claims no more than the subsidy
+// plus the block's fees
+func checkBlockReward(coinbaseValue, totalFees, subsidy int64) error {
+	maxAllowed := subsidy + totalFees
 
 	if coinbaseValue > maxAllowed {
-		return fmt.Errorf("coinbase value (%d) exceeds allowed (%d)",
+		return rejectf(RejectBadCoinbaseValue, "coinbase value (%d) exceeds allowed (%d)",
 			coinbaseValue, maxAllowed)
 	}
 
@@ -0,0 +1,78 @@
+package validation
+
+import (
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// CheckBlockProposal runs every check a block extending the tip must pass
+// except proof of work, so a miner can test a block before grinding its
+// nonce (BIP23 proposals). view must be the UTXO set at the tip; nothing
+// is stored or applied. Every script is checked, even below the
+// assumevalid block.
+func CheckBlockProposal(chain *storage.BlockchainStorage, view utxo.View, rules *consensus.ConsensusRules, block *types.Block, now time.Time) error {
+	report, err := CheckBlockAtTip(chain, view, rules, block, now)
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// CheckBlockAtTip is CheckBlockProposal reporting every rule, as
+// BlockValidator.CheckBlock does. The error is a failure to check, such as
+// a database read; rule violations are in the report. A block that is
+// already known or doesn't build on the tip has no height to check the
+// remaining rules at, so its report stops there.
+func CheckBlockAtTip(chain *storage.BlockchainStorage, view utxo.View, rules *consensus.ConsensusRules, block *types.Block, now time.Time) (*BlockReport, error) {
+	hash, err := serialization.HashBlockHeader(&block.Header)
+	if err != nil {
+		return nil, err
+	}
+	report := &BlockReport{Hash: hash}
+	c := &reportChecker{report: report}
+
+	if known, err := chain.HasBlock(hash); err != nil {
+		return nil, err
+	} else if known {
+		if invalid, err := chain.IsBranchInvalid(hash); err == nil && invalid {
+			c.check(RuleNewBlock, rejectf(RejectDuplicateInvalid, "block %s is known to be invalid", hash))
+		} else {
+			c.check(RuleNewBlock, rejectf(RejectDuplicate, "block %s already known", hash))
+		}
+		return report, nil
+	}
+	c.check(RuleNewBlock, nil)
+
+	tipHash, tipHeight, err := chain.GetTip()
+	if err != nil {
+		return nil, err
+	}
+	if block.Header.PrevBlockHash != tipHash {
+		c.check(RuleBuildsOnTip, rejectf(RejectNotBestPrevBlock, "previous block %s is not the tip %s", block.Header.PrevBlockHash, tipHash))
+		return report, nil
+	}
+	c.check(RuleBuildsOnTip, nil)
+	height := tipHeight + 1
+
+	c.check(RuleTime, CheckHeaderTime(&block.Header, now))
+	bits, err := rules.NextWorkRequired(chain, height)
+	if err != nil {
+		return nil, err
+	}
+	err = nil
+	if block.Header.Bits != bits {
+		err = rejectf(RejectBadDiffBits, "bits %08x, want %08x", block.Header.Bits, bits)
+	}
+	c.check(RuleDifficulty, err)
+
+	validator := NewBlockValidator(view)
+	validator.SetRules(rules)
+	full := validator.CheckBlock(block, height, tipHash)
+	full.Checks = append(report.Checks, full.Checks...)
+	return full, nil
+}
@@ -0,0 +1,140 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// BIP22 reasons for rejecting a submitted block or header
+const (
+	RejectDuplicate         = "duplicate"                           // Already stored
+	RejectDuplicateInvalid  = "duplicate-invalid"                   // Already stored and known to be invalid
+	RejectPrevNotFound      = "prev-blk-not-found"                  // Parent unknown
+	RejectBadPrevBlock      = "bad-prevblk"                         // Parent is invalid or not the expected block
+	RejectHighHash          = "high-hash"                           // Hash above the target
+	RejectBadMerkleRoot     = "bad-txnmrklroot"                     // Merkle root doesn't match the transactions
+	RejectNoTransactions    = "bad-blk-length"                      // No transactions at all
+	RejectNoCoinbase        = "bad-cb-missing"                      // First transaction isn't a coinbase
+	RejectMultipleCoinbase  = "bad-cb-multiple"                     // Coinbase after the first transaction
+	RejectDuplicateTx       = "bad-txns-duplicate"                  // Same transaction twice
+	RejectTimeTooNew        = "time-too-new"                        // Timestamp too far past network-adjusted time
+	RejectBadDiffBits       = "bad-diffbits"                        // Bits aren't the target the chain requires
+	RejectNotBestPrevBlock  = "inconclusive-not-best-prevblk"       // Proposal doesn't build on the tip
+	RejectBadBlockWeight    = "bad-blk-weight"                      // Block weight above the limit
+	RejectBIP30             = "bad-txns-BIP30"                      // Transaction overwrites an unspent output
+	RejectMissingInputs     = "bad-txns-inputs-missingorspent"      // Input spends an unknown or spent output
+	RejectScriptFailed      = "mandatory-script-verify-flag-failed" // Input script doesn't verify
+	RejectInBelowOut        = "bad-txns-in-belowout"                // Outputs worth more than inputs
+	RejectBadCoinbaseValue  = "bad-cb-amount"                       // Coinbase claims more than subsidy plus fees
+	RejectUnexpectedWitness = "unexpected-witness"                  // Witness data without SegWit or a commitment
+	RejectBadWitnessNonce   = "bad-witness-nonce-size"              // Coinbase witness isn't one 32 byte value
+	RejectBadWitnessCommit  = "bad-witness-merkle-match"            // Witness commitment doesn't match
+	RejectInvalid           = "rejected"                            // Any other failure
+)
+
+// ErrOrphanBlock matches failures caused by an unknown parent, which may
+// arrive later, so the block isn't invalid. RejectPrevNotFound rejections
+// match it too.
+var ErrOrphanBlock = errors.New("orphan block")
+
+// MaxFutureBlockTime is how far a block's timestamp may be ahead of the
+// network-adjusted time
+const MaxFutureBlockTime = 2 * time.Hour
+
+// RejectError is a consensus rule violation carrying its BIP22 reason.
+// Block checks return one, possibly wrapped, for every rule a block can
+// break; other errors, such as a failed database read, say nothing about
+// the block.
+type RejectError struct {
+	Reason string
+	Msg    string
+	Err    error // Underlying failure, if any
+}
+
+func (e *RejectError) Error() string {
+	return e.Msg
+}
+
+// Unwrap returns the underlying failure
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrOrphanBlock) true for a missing parent
+func (e *RejectError) Is(target error) bool {
+	return target == ErrOrphanBlock && e.Reason == RejectPrevNotFound
+}
+
+// rejectf creates a RejectError with a formatted message. A %w verb sets
+// the underlying failure.
+func rejectf(reason string, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	return &RejectError{Reason: reason, Msg: err.Error(), Err: errors.Unwrap(err)}
+}
+
+// IsRuleError reports whether err's chain holds a consensus rule
+// violation, as opposed to a failure to check
+func IsRuleError(err error) bool {
+	var reject *RejectError
+	return errors.As(err, &reject)
+}
+
+// RejectReason returns the BIP22 reason in err's chain, or RejectInvalid
+func RejectReason(err error) string {
+	var reject *RejectError
+	if errors.As(err, &reject) {
+		return reject.Reason
+	}
+	return RejectInvalid
+}
+
+// CheckHeaderContext checks that a header is new, builds on a stored
+// valid block and has enough proof of work
+func CheckHeaderContext(chain *storage.BlockchainStorage, header *types.BlockHeader) error {
+	hash, err := serialization.HashBlockHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if known, err := chain.HasBlock(hash); err != nil {
+		return err
+	} else if known {
+		if invalid, err := chain.IsBranchInvalid(hash); err == nil && invalid {
+			return rejectf(RejectDuplicateInvalid, "block %s is known to be invalid", hash)
+		}
+		return rejectf(RejectDuplicate, "block %s already known", hash)
+	}
+
+	if known, err := chain.HasBlock(header.PrevBlockHash); err != nil {
+		return err
+	} else if !known {
+		return rejectf(RejectPrevNotFound, "previous block %s not found", header.PrevBlockHash)
+	}
+	if invalid, err := chain.IsBranchInvalid(header.PrevBlockHash); err != nil {
+		return err
+	} else if invalid {
+		return rejectf(RejectBadPrevBlock, "previous block %s is invalid", header.PrevBlockHash)
+	}
+
+	if !IsValidProofOfWork(hash[:], header.Bits) {
+		return rejectf(RejectHighHash, "insufficient proof of work")
+	}
+	return nil
+}
+
+// CheckHeaderTime rejects a header timestamped more than
+// MaxFutureBlockTime after now, which should be the network-adjusted
+// time. Such a block may become valid later, so it isn't marked invalid.
+func CheckHeaderTime(header *types.BlockHeader, now time.Time) error {
+	maxTime := now.Add(MaxFutureBlockTime)
+	if blockTime := time.Unix(int64(header.Timestamp), 0); blockTime.After(maxTime) {
+		return rejectf(RejectTimeTooNew, "block timestamp %s is more than %v ahead of network time",
+			blockTime.UTC().Format(time.RFC3339), MaxFutureBlockTime)
+	}
+	return nil
+}
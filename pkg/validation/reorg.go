@@ -0,0 +1,293 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// DefaultMaxReorgDepth is the deepest reorganization accepted by default.
+// Coinbases mature after 100 blocks, so anything deeper could invalidate
+// coins that have already been spent.
+const DefaultMaxReorgDepth = 100
+
+// ErrReorgTooDeep is returned when a better chain forks off further back
+// than the maximum reorg depth
+var ErrReorgTooDeep = errors.New("reorganization exceeds maximum depth")
+
+// DeepReorgAlert describes a reorganization refused for being too deep
+type DeepReorgAlert struct {
+	Depth      uint64 // Blocks that would have been disconnected
+	MaxDepth   uint64
+	ForkHeight uint64
+	CurrentTip types.Hash
+	NewTip     types.Hash
+	NewHeight  uint64
+}
+
+// ChainListener is told about every block a reorganization connects to or
+// disconnects from the best chain. Disconnections arrive tip first.
+type ChainListener interface {
+	BlockConnected(block *types.Block, height uint64)
+	BlockDisconnected(block *types.Block, height uint64)
+}
+
+// Reorganizer switches the best chain over to a branch with more work.
+// Every reorganization goes through it, so the depth limit, the listeners
+// and the mempool see all of them however the branch arrived.
+type Reorganizer struct {
+	chain *storage.BlockchainStorage
+
+	rules       *consensus.ConsensusRules
+	mempool     *mempool.Mempool // Nil leaves transactions of disconnected blocks out
+	maxDepth    uint64           // 0 = unlimited
+	metrics     *monitoring.Metrics
+	onDeepReorg func(DeepReorgAlert)
+	listeners   []ChainListener
+	mu          sync.RWMutex // Guards everything but chain
+}
+
+// NewReorganizer creates a reorganizer for chain using mainnet rules and
+// the default maximum depth
+func NewReorganizer(chain *storage.BlockchainStorage) *Reorganizer {
+	return &Reorganizer{
+		chain:    chain,
+		rules:    consensus.NewMainnetRules(),
+		maxDepth: DefaultMaxReorgDepth,
+	}
+}
+
+// SetRules sets the consensus rules branches are validated against. Nil
+// means mainnet's.
+func (r *Reorganizer) SetRules(rules *consensus.ConsensusRules) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rules == nil {
+		rules = consensus.NewMainnetRules()
+	}
+	r.rules = rules
+}
+
+// SetMempool sets the mempool that gets back the transactions of
+// disconnected blocks
+func (r *Reorganizer) SetMempool(mp *mempool.Mempool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.mempool = mp
+}
+
+// SetMaxReorgDepth sets the deepest reorganization performed. Zero
+// removes the limit.
+func (r *Reorganizer) SetMaxReorgDepth(depth uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.maxDepth = depth
+}
+
+// SetMetrics records completed and refused reorganizations in m
+func (r *Reorganizer) SetMetrics(m *monitoring.Metrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = m
+}
+
+// OnDeepReorg registers fn to be called whenever a reorganization is
+// refused for exceeding the maximum depth
+func (r *Reorganizer) OnDeepReorg(fn func(DeepReorgAlert)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onDeepReorg = fn
+}
+
+// Subscribe registers l for block connected and disconnected events
+func (r *Reorganizer) Subscribe(l ChainListener) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.listeners = append(r.listeners, l)
+}
+
+// Reorganize disconnects the best chain down to forkHeight and connects
+// branch, oldest first. set must hold the UTXO set as of the best block;
+// the branch is validated in a view over it, so an invalid block or a
+// fork deeper than the maximum depth leaves the chain and set as they
+// were. With forkHeight at the tip this just extends the chain. It
+// returns the disconnected blocks, tip first.
+func (r *Reorganizer) Reorganize(set utxo.View, branch []*types.Block, forkHeight uint64) ([]*types.Block, error) {
+	if len(branch) == 0 {
+		return nil, fmt.Errorf("no blocks to connect")
+	}
+
+	r.mu.RLock()
+	rules, mp, maxDepth, metrics, notify := r.rules, r.mempool, r.maxDepth, r.metrics, r.onDeepReorg
+	listeners := append([]ChainListener(nil), r.listeners...)
+	r.mu.RUnlock()
+
+	tipHeight, err := r.chain.GetBestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+	if forkHeight > tipHeight {
+		return nil, fmt.Errorf("fork height %d above the best height %d", forkHeight, tipHeight)
+	}
+	depth := tipHeight - forkHeight
+
+	if maxDepth > 0 && depth > maxDepth {
+		alert := DeepReorgAlert{
+			Depth:      depth,
+			MaxDepth:   maxDepth,
+			ForkHeight: forkHeight,
+			NewHeight:  forkHeight + uint64(len(branch)),
+		}
+		alert.CurrentTip, _ = r.chain.GetBestBlockHash()
+		alert.NewTip, _ = serialization.HashBlockHeader(&branch[len(branch)-1].Header)
+
+		monitoring.Errorf("ALERT: refusing %d-block reorganization (max %d): fork at height %d, current tip %s, competing tip %s at height %d",
+			depth, maxDepth, alert.ForkHeight, alert.CurrentTip, alert.NewTip, alert.NewHeight)
+		if metrics != nil {
+			metrics.RecordRejectedReorg(depth)
+		}
+		if notify != nil {
+			notify(alert)
+		}
+
+		return nil, fmt.Errorf("%w: %d blocks > %d", ErrReorgTooDeep, depth, maxDepth)
+	}
+
+	disconnected := make([]*types.Block, 0, depth)
+	for h := tipHeight; h > forkHeight; h-- {
+		block, err := r.chain.GetBlockByHeight(h)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get block at height %d: %w", h, err)
+		}
+		disconnected = append(disconnected, block)
+	}
+
+	view := utxo.NewUTXOView(set)
+	if err := ConnectBranch(r.chain, rules, view, branch, forkHeight); err != nil {
+		return nil, err
+	}
+	if err := r.chain.SwitchChain(forkHeight, branch); err != nil {
+		return nil, fmt.Errorf("failed to switch chain: %w", err)
+	}
+	if err := view.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to update UTXO set: %w", err)
+	}
+
+	for i, block := range disconnected {
+		for _, l := range listeners {
+			l.BlockDisconnected(block, tipHeight-uint64(i))
+		}
+	}
+	for i, block := range branch {
+		for _, l := range listeners {
+			l.BlockConnected(block, forkHeight+uint64(i)+1)
+		}
+	}
+
+	if depth == 0 {
+		return nil, nil
+	}
+
+	if mp != nil {
+		if failed := returnToMempool(mp, set, disconnected, branch, forkHeight+uint64(len(branch))); failed > 0 {
+			fmt.Printf("Warning: %d transactions of disconnected blocks could not be returned to the mempool\n", failed)
+		}
+	}
+	if metrics != nil {
+		metrics.RecordReorg(depth)
+	}
+
+	fmt.Printf("Reorganized: disconnected %d blocks, connected %d (fork at height %d)\n",
+		depth, len(branch), forkHeight)
+	return disconnected, nil
+}
+
+// returnToMempool drops mempool transactions confirmed by or conflicting
+// with the new blocks, then adds back the transactions of the disconnected
+// blocks (given tip first) that are still valid against set. It returns
+// how many could not be added back.
+func returnToMempool(mp *mempool.Mempool, set utxo.View, disconnected, connected []*types.Block, height uint64) int {
+	newChainTxs := make(map[types.Hash]bool)
+	for _, block := range connected {
+		mp.RemoveConfirmed(block.Transactions)
+		mp.RemoveConflicts(block.Transactions)
+		for i := range block.Transactions {
+			txHash, _ := serialization.HashTransaction(&block.Transactions[i])
+			newChainTxs[txHash] = true
+		}
+	}
+
+	// Oldest block first so parents go back in before their children
+	var failed int
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for txIdx := range disconnected[i].Transactions {
+			if txIdx == 0 {
+				continue // Skip coinbase
+			}
+			tx := &disconnected[i].Transactions[txIdx]
+
+			txHash, _ := serialization.HashTransaction(tx)
+			if newChainTxs[txHash] {
+				continue
+			}
+
+			fee, err := mempoolFee(mp, set, tx)
+			if err == nil {
+				err = mp.Add(tx, fee, height)
+			}
+			if err != nil {
+				// The transaction might be invalid now
+				fmt.Printf("Could not return tx %s to mempool: %v\n", txHash, err)
+				failed++
+			}
+		}
+	}
+
+	// Drop whatever spent outputs of the disconnected blocks that are gone
+	// now (removing a transaction takes its descendants along)
+	for _, entry := range mp.GetAllTransactions() {
+		if _, err := mempoolFee(mp, set, entry.Tx); err != nil {
+			mp.Remove(entry.TxHash)
+		}
+	}
+
+	return failed
+}
+
+// mempoolFee computes a transaction's fee from outputs in set or the
+// mempool
+func mempoolFee(mp *mempool.Mempool, set utxo.View, tx *types.Transaction) (int64, error) {
+	var totalIn int64
+	for _, input := range tx.Inputs {
+		if spent, err := set.Get(utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)); err == nil {
+			totalIn += spent.Value()
+			continue
+		}
+
+		parent, err := mp.Get(input.PrevTxHash)
+		if err != nil || int(input.OutputIndex) >= len(parent.Tx.Outputs) {
+			return 0, fmt.Errorf("input %s:%d is missing or spent", input.PrevTxHash, input.OutputIndex)
+		}
+		totalIn += parent.Tx.Outputs[input.OutputIndex].Value
+	}
+
+	var totalOut int64
+	for _, output := range tx.Outputs {
+		totalOut += output.Value
+	}
+	return totalIn - totalOut, nil
+}
@@ -0,0 +1,146 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Rules a BlockReport lists, in the order they're checked
+const (
+	RuleNewBlock          = "new-block"     // Not already stored (proposals only)
+	RuleBuildsOnTip       = "builds-on-tip" // Parent is the tip (proposals only)
+	RuleTime              = "time"          // Timestamp not too far ahead (proposals only)
+	RuleDifficulty        = "difficulty"    // Bits the chain requires (proposals only)
+	RuleEncoding          = "encoding"
+	RuleHeader            = "header" // Parent hash and proof of work
+	RuleWeight            = "weight"
+	RuleHasTransactions   = "has-transactions"
+	RuleCoinbaseFirst     = "coinbase-first"
+	RuleSingleCoinbase    = "single-coinbase"
+	RuleCoinbase          = "coinbase"
+	RuleTransactions      = "transactions" // Inputs exist, scripts verify, fees are positive
+	RuleCoinbaseReward    = "coinbase-reward"
+	RuleMerkleRoot        = "merkle-root"
+	RuleUniqueTxs         = "unique-transactions"
+	RuleBIP30             = "bip30"
+	RuleWitnessCommitment = "witness-commitment"
+	RuleSignet            = "signet" // Only on signet
+)
+
+// RuleStatus is the outcome of one rule in a BlockReport
+type RuleStatus string
+
+const (
+	RulePassed  RuleStatus = "pass"
+	RuleFailed  RuleStatus = "fail"
+	RuleSkipped RuleStatus = "skipped" // An earlier failure left nothing to check
+)
+
+// RuleCheck is one rule's outcome
+type RuleCheck struct {
+	Rule   string
+	Status RuleStatus
+	Err    error // Set when the rule failed
+}
+
+// BlockReport is the outcome of every block rule, not just the first to
+// fail, with the figures the rules computed
+type BlockReport struct {
+	Hash          types.Hash
+	Height        uint64
+	Size          int // Serialized with witness data
+	Weight        int
+	SigOps        int   // Legacy count over every scriptSig and output script
+	Fees          int64 // Of the transactions whose inputs checked out
+	CoinbaseValue int64
+	Checks        []RuleCheck
+}
+
+// Err returns the first failure, or nil if the block is valid
+func (r *BlockReport) Err() error {
+	for _, check := range r.Checks {
+		if check.Status == RuleFailed {
+			return check.Err
+		}
+	}
+	return nil
+}
+
+// Valid reports whether every rule passed
+func (r *BlockReport) Valid() bool {
+	return r.Err() == nil
+}
+
+// newBlockReport starts a report with the block's hash, and with measure
+// set its size and sigop count
+func newBlockReport(block *types.Block, height uint64, measure bool) *BlockReport {
+	report := &BlockReport{Height: height}
+	checks := &reportChecker{report: report}
+
+	hash, err := serialization.HashBlockHeader(&block.Header)
+	if err != nil {
+		checks.check(RuleEncoding, err)
+		return report
+	}
+	weight, err := BlockWeight(block)
+	if err != nil {
+		checks.check(RuleEncoding, err)
+		return report
+	}
+	report.Hash, report.Weight = hash, weight
+	if !measure {
+		return report
+	}
+
+	data, err := serialization.SerializeBlock(block)
+	if err != nil {
+		checks.check(RuleEncoding, err)
+		return report
+	}
+	report.Size = len(data)
+
+	for _, tx := range block.Transactions {
+		for _, input := range tx.Inputs {
+			report.SigOps += script.CountSigOps(input.SignatureScript)
+		}
+		for _, output := range tx.Outputs {
+			report.SigOps += script.CountSigOps(output.PubKeyScript)
+		}
+	}
+	return report
+}
+
+// reportChecker records rule outcomes in a report. With stop set the
+// first failure ends checking, as ValidateBlock wants.
+type reportChecker struct {
+	report *BlockReport
+	stop   bool
+}
+
+// check records a rule's outcome and reports whether to go on checking
+func (c *reportChecker) check(rule string, err error) bool {
+	if err != nil {
+		c.report.Checks = append(c.report.Checks, RuleCheck{Rule: rule, Status: RuleFailed, Err: err})
+		return !c.stop
+	}
+	c.report.Checks = append(c.report.Checks, RuleCheck{Rule: rule, Status: RulePassed})
+	return true
+}
+
+// skip records rules that can't be checked
+func (c *reportChecker) skip(rules ...string) {
+	for _, rule := range rules {
+		c.report.Checks = append(c.report.Checks, RuleCheck{Rule: rule, Status: RuleSkipped})
+	}
+}
+
+// wrapErr prefixes a non-nil err with context
+func wrapErr(context string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", context, err)
+}
@@ -0,0 +1,175 @@
+package validation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// SignetHeader starts the push in the witness commitment output that
+// carries a block's signet solution (BIP325)
+var SignetHeader = []byte{0xec, 0xc7, 0xda, 0xa2}
+
+// ErrNoSignetSolution is returned for a signet block without a solution
+var ErrNoSignetSolution = errors.New("block has no signet solution")
+
+// SignetSolution satisfies the signet challenge for one block. The
+// script engine has no witness support, so only challenges solved by
+// ScriptSig alone, such as bare multisig, can be checked.
+type SignetSolution struct {
+	ScriptSig []byte
+	Witness   [][]byte
+}
+
+// Serialize encodes the solution as it appears after SignetHeader: the
+// scriptSig followed by the witness stack
+func (s *SignetSolution) Serialize() []byte {
+	var buf bytes.Buffer
+	serialization.WriteBytes(&buf, s.ScriptSig)
+	serialization.WriteVarInt(&buf, uint64(len(s.Witness)))
+	for _, item := range s.Witness {
+		serialization.WriteBytes(&buf, item)
+	}
+	return buf.Bytes()
+}
+
+// ParseSignetSolution decodes a serialized solution, which must have
+// nothing after the witness stack
+func ParseSignetSolution(data []byte) (*SignetSolution, error) {
+	r := bytes.NewReader(data)
+	sigScript, err := serialization.ReadBytes(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signet solution: %w", err)
+	}
+	count, err := serialization.ReadCount(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signet solution: %w", err)
+	}
+	solution := &SignetSolution{ScriptSig: sigScript}
+	for i := uint64(0); i < count; i++ {
+		item, err := serialization.ReadBytes(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signet solution: %w", err)
+		}
+		solution.Witness = append(solution.Witness, item)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("invalid signet solution: %d trailing bytes", r.Len())
+	}
+	return solution, nil
+}
+
+// SignetSigningTx returns the transaction a signet solution signs: the
+// BIP325 "to_sign" transaction, with an empty scriptSig, spending the
+// "to_spend" output that commits to the block and is locked by challenge.
+// The commitment covers the header's version, previous block and time and
+// the merkle root with the solution itself left out of the coinbase. The
+// solution found in the block, if any, is returned too.
+func SignetSigningTx(block *types.Block, challenge []byte) (*types.Transaction, []byte, error) {
+	if len(block.Transactions) == 0 {
+		return nil, nil, fmt.Errorf("block has no transactions")
+	}
+	coinbase := block.Transactions[0]
+	index := WitnessCommitmentIndex(&coinbase)
+	if index < 0 {
+		return nil, nil, fmt.Errorf("%w: no witness commitment", ErrNoSignetSolution)
+	}
+
+	// Truncate the solution push to the bare header
+	ops, err := script.ParseScript(coinbase.Outputs[index].PubKeyScript)
+	if err != nil {
+		return nil, nil, err
+	}
+	var solution []byte
+	found := false
+	stripped := script.NewBuilder()
+	for _, op := range ops {
+		if len(op.Data) == 0 {
+			stripped.AddOp(op.Opcode)
+			continue
+		}
+		data := op.Data
+		if !found && len(data) > len(SignetHeader) && bytes.HasPrefix(data, SignetHeader) {
+			solution = data[len(SignetHeader):]
+			data = SignetHeader
+			found = true
+		}
+		stripped.AddData(data)
+	}
+
+	coinbase.Outputs = append([]types.TxOutput(nil), coinbase.Outputs...)
+	coinbase.Outputs[index].PubKeyScript = stripped.Script()
+	txHashes := make([]types.Hash, len(block.Transactions))
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if i == 0 {
+			tx = &coinbase
+		}
+		if txHashes[i], err = serialization.HashTransaction(tx); err != nil {
+			return nil, nil, err
+		}
+	}
+	merkleRoot := crypto.ComputeMerkleRoot(txHashes)
+
+	var commitment bytes.Buffer
+	serialization.WriteInt32(&commitment, block.Header.Version)
+	commitment.Write(block.Header.PrevBlockHash[:])
+	commitment.Write(merkleRoot[:])
+	serialization.WriteUint32(&commitment, block.Header.Timestamp)
+
+	toSpend := &types.Transaction{
+		Version: 0,
+		Inputs: []types.TxInput{{
+			OutputIndex:     0xFFFFFFFF,
+			SignatureScript: script.NewBuilder().AddOp(script.OP_0).AddData(commitment.Bytes()).Script(),
+		}},
+		Outputs: []types.TxOutput{{Value: 0, PubKeyScript: challenge}},
+	}
+	toSpendHash, err := serialization.HashTransaction(toSpend)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toSign := &types.Transaction{
+		Version: 0,
+		Inputs:  []types.TxInput{{PrevTxHash: toSpendHash, OutputIndex: 0}},
+		Outputs: []types.TxOutput{{Value: 0, PubKeyScript: []byte{script.OP_RETURN}}},
+	}
+	return toSign, solution, nil
+}
+
+// CheckSignetSolution checks that block carries a solution to challenge
+func CheckSignetSolution(block *types.Block, challenge []byte) error {
+	toSign, data, err := SignetSigningTx(block, challenge)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return ErrNoSignetSolution
+	}
+	solution, err := ParseSignetSolution(data)
+	if err != nil {
+		return err
+	}
+	if len(solution.Witness) > 0 {
+		return fmt.Errorf("signet solutions with witness data are not supported")
+	}
+	if !script.IsPushOnly(solution.ScriptSig) {
+		return fmt.Errorf("signet solution scriptSig is not push only")
+	}
+
+	toSign.Inputs[0].SignatureScript = solution.ScriptSig
+	engine := script.NewInputEngine(solution.ScriptSig, challenge)
+	engine.SetTransaction(toSign, 0)
+	engine.SetSigChecker(transaction.SignatureChecker(toSign, 0, challenge))
+	if err := engine.Execute(); err != nil {
+		return fmt.Errorf("invalid signet solution: %w", err)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package validation
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
@@ -70,17 +71,19 @@ func (cs *ChainState) AddBlock(block *types.Block) error {
 		return fmt.Errorf("block validation failed: %w", err)
 	}
 
-	// Apply block to UTXO set
-	if err := cs.validator.ApplyBlock(block, newHeight); err != nil {
+	// Apply block to a view of the UTXO set, committed once it is saved
+	view := utxo.NewUTXOView(cs.utxoSet)
+	if err := NewBlockValidator(view).ApplyBlock(block, newHeight); err != nil {
 		return fmt.Errorf("failed to apply block: %w", err)
 	}
 
 	// Save block to storage
 	if err := cs.blockchain.SaveBlock(block, newHeight); err != nil {
-		// Revert UTXO changes on failure
-		cs.validator.RevertBlock(block)
 		return fmt.Errorf("failed to save block: %w", err)
 	}
+	if err := view.Commit(); err != nil {
+		return fmt.Errorf("failed to update UTXO set: %w", err)
+	}
 
 	return nil
 }
@@ -132,8 +135,11 @@ func (cs *ChainState) RebuildUTXOSet() error {
 	// Clear current UTXO set
 	cs.utxoSet.Clear()
 
-	// Get blockchain height
-	_, height, err := cs.blockchain.GetBestBlock()
+	// Get blockchain height; an empty chain leaves an empty set
+	_, height, err := cs.blockchain.GetTip()
+	if errors.Is(err, storage.ErrEmptyChain) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -155,7 +161,10 @@ func (cs *ChainState) RebuildUTXOSet() error {
 
 // ValidateChain validates the entire blockchain
 func (cs *ChainState) ValidateChain() error {
-	_, height, err := cs.blockchain.GetBestBlock()
+	_, height, err := cs.blockchain.GetTip()
+	if errors.Is(err, storage.ErrEmptyChain) {
+		return nil // Nothing to check
+	}
 	if err != nil {
 		return err
 	}
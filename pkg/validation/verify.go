@@ -0,0 +1,224 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// Check levels for VerifyChain. Each level runs the checks of the ones
+// below it too.
+const (
+	// CheckLevelHeaders reads each block back and checks it hashes to the
+	// block asked for, links to its parent and meets its proof of work
+	CheckLevelHeaders = 0
+
+	// CheckLevelSanity adds the checks needing no chain context: coinbase
+	// placement, merkle root and duplicate transactions
+	CheckLevelSanity = 1
+
+	// CheckLevelUndo checks every output the blocks spend can be found
+	// again. There is no separate undo data; disconnecting a block
+	// restores these outputs from the blocks that created them.
+	CheckLevelUndo = 2
+
+	// CheckLevelDisconnect disconnects the blocks, tip first, from the UTXO
+	// set they left behind, rebuilt by replaying the chain
+	CheckLevelDisconnect = 3
+
+	// CheckLevelConnect connects them again with full validation, every
+	// script included
+	CheckLevelConnect = 4
+
+	// MaxCheckLevel is the most thorough level
+	MaxCheckLevel = CheckLevelConnect
+)
+
+const (
+	// DefaultCheckBlocks is how many blocks below the tip are verified
+	// at startup
+	DefaultCheckBlocks = 6
+
+	// DefaultCheckLevel is how thoroughly they are verified
+	DefaultCheckLevel = CheckLevelDisconnect
+)
+
+// ErrCorruptChain is the error a failed VerifyChain reports, wrapping the
+// check that failed
+var ErrCorruptChain = errors.New("stored chain is corrupt")
+
+// VerifyResult is the outcome of VerifyChain
+type VerifyResult struct {
+	Level   int
+	From    uint64 // Lowest height verified
+	To      uint64 // The tip when verification started
+	TipHash types.Hash
+
+	Err    error  // The first failure, nil if every block passed
+	Height uint64 // Where Err was found
+}
+
+// Valid reports whether every block passed
+func (r *VerifyResult) Valid() bool {
+	return r.Err == nil
+}
+
+// fail records the first failure, at height
+func (r *VerifyResult) fail(height uint64, err error) *VerifyResult {
+	r.Err = fmt.Errorf("%w: height %d: %v", ErrCorruptChain, height, err)
+	r.Height = height
+	return r
+}
+
+// VerifyChain re-validates the last numBlocks blocks of the best chain
+// (0 = all of them) at level, catching blocks damaged on disk. Heights
+// are resolved from the tip seen at the start, so blocks connected or
+// reorganized away meanwhile don't show up as failures. Corruption is
+// reported in the result; the error is for a chain that can't be read at
+// all, or ctx being cancelled. Nil rules check against mainnet's.
+func VerifyChain(ctx context.Context, chain *storage.BlockchainStorage, rules *consensus.ConsensusRules, level int, numBlocks uint64) (*VerifyResult, error) {
+	if level < CheckLevelHeaders || level > MaxCheckLevel {
+		return nil, fmt.Errorf("check level %d out of range 0-%d", level, MaxCheckLevel)
+	}
+	if rules == nil {
+		rules = consensus.NewMainnetRules()
+	}
+	tipHash, tipHeight, err := chain.GetTip()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tip: %w", err)
+	}
+	result := &VerifyResult{Level: level, To: tipHeight, TipHash: tipHash}
+	if numBlocks > 0 && numBlocks <= tipHeight {
+		result.From = tipHeight - numBlocks + 1
+	}
+
+	// Levels 0-2 look at each block on its own, tip first
+	expected := tipHash
+	for h := tipHeight; ; h-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block, err := chain.GetBlock(expected)
+		if err != nil {
+			return result.fail(h, err), nil
+		}
+		if err := verifyBlock(chain, block, expected, level); err != nil {
+			return result.fail(h, err), nil
+		}
+		if h == result.From {
+			break
+		}
+		expected = block.Header.PrevBlockHash
+	}
+	if level < CheckLevelDisconnect {
+		return result, nil
+	}
+
+	// Level 3 needs the UTXO set as of the tip, which only a replay of the
+	// whole chain gives
+	set := utxo.NewUTXOSet()
+	replay := NewBlockValidator(set)
+	replay.SetRules(rules)
+	for h := uint64(0); h <= tipHeight; h++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block, err := ancestorBlock(chain, tipHash, h)
+		if err == nil {
+			err = replay.ApplyBlock(block, h)
+		}
+		if err != nil {
+			return result.fail(h, err), nil
+		}
+	}
+
+	// Disconnect into a view, so level 4 can connect again on top
+	view := utxo.NewUTXOView(set)
+	validator := NewBlockValidator(view)
+	validator.SetRules(rules)
+	for h := tipHeight; ; h-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block, err := ancestorBlock(chain, tipHash, h)
+		if err == nil {
+			err = validator.UndoBlock(block, chain)
+		}
+		if err != nil {
+			return result.fail(h, fmt.Errorf("disconnecting: %w", err)), nil
+		}
+		if h == result.From {
+			break
+		}
+	}
+	if level < CheckLevelConnect {
+		return result, nil
+	}
+
+	// No blockchain is set on the validator, so assumevalid doesn't skip
+	// any scripts
+	for h := result.From; h <= tipHeight; h++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block, err := ancestorBlock(chain, tipHash, h)
+		if err == nil {
+			err = validator.ValidateBlock(block, h, block.Header.PrevBlockHash)
+		}
+		if err == nil {
+			err = validator.ApplyBlock(block, h)
+		}
+		if err != nil {
+			return result.fail(h, fmt.Errorf("reconnecting: %w", err)), nil
+		}
+	}
+	return result, nil
+}
+
+// verifyBlock runs the level 0-2 checks on a block stored as hash
+func verifyBlock(chain *storage.BlockchainStorage, block *types.Block, hash types.Hash, level int) error {
+	actual, err := serialization.HashBlockHeader(&block.Header)
+	if err != nil {
+		return err
+	}
+	if actual != hash {
+		return fmt.Errorf("block stored as %s hashes to %s", hash, actual)
+	}
+	if !IsValidProofOfWork(actual[:], block.Header.Bits) {
+		return rejectf(RejectHighHash, "insufficient proof of work")
+	}
+	if level < CheckLevelSanity {
+		return nil
+	}
+
+	if err := CheckBlockSanity(block); err != nil {
+		return err
+	}
+	if level < CheckLevelUndo {
+		return nil
+	}
+
+	for i := 1; i < len(block.Transactions); i++ {
+		for _, input := range block.Transactions[i].Inputs {
+			if _, err := LookupOutput(chain, input.PrevTxHash, input.OutputIndex); err != nil {
+				return fmt.Errorf("spent output %s:%d can't be restored: %w", input.PrevTxHash, input.OutputIndex, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ancestorBlock loads the block at height on the chain ending in tip
+func ancestorBlock(chain *storage.BlockchainStorage, tip types.Hash, height uint64) (*types.Block, error) {
+	hash, err := chain.GetAncestor(tip, height)
+	if err != nil {
+		return nil, err
+	}
+	return chain.GetBlock(hash)
+}
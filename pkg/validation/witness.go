@@ -0,0 +1,118 @@
+package validation
+
+import (
+	"bytes"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// WitnessScaleFactor is how much more a non-witness byte weighs than a
+// witness byte (BIP141)
+const WitnessScaleFactor = transaction.WitnessScaleFactor
+
+// witnessCommitmentHeader starts the coinbase output committing to the
+// witness merkle root: OP_RETURN, a 36 byte push and the 0xaa21a9ed tag
+var witnessCommitmentHeader = []byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+
+// witnessCommitmentSize is the header followed by the 32 byte commitment
+const witnessCommitmentSize = 38
+
+// BlockWeight returns the BIP141 weight of a block: three times its size
+// without witness data plus its full size
+func BlockWeight(block *types.Block) (int, error) {
+	// The header and transaction count have no witness part
+	weight := (serialization.BlockHeaderSize + serialization.VarIntSize(uint64(len(block.Transactions)))) * WitnessScaleFactor
+	for i := range block.Transactions {
+		weight += transaction.Weight(&block.Transactions[i])
+	}
+	return weight, nil
+}
+
+// WitnessMerkleRoot computes the merkle root of the transactions' wtxids.
+// The coinbase's wtxid is taken as zero since it can't commit to itself.
+func WitnessMerkleRoot(txs []types.Transaction) (types.Hash, error) {
+	hashes := make([]types.Hash, len(txs))
+	for i := 1; i < len(txs); i++ {
+		wtxid, err := serialization.HashTransactionWitness(&txs[i])
+		if err != nil {
+			return types.Hash{}, err
+		}
+		hashes[i] = wtxid
+	}
+	return crypto.ComputeMerkleRoot(hashes), nil
+}
+
+// WitnessCommitment hashes a witness merkle root with the reserved value
+// from the coinbase witness
+func WitnessCommitment(witnessRoot types.Hash, reserved []byte) types.Hash {
+	return crypto.DoubleSHA256(append(witnessRoot[:], reserved...))
+}
+
+// WitnessCommitmentScript builds the coinbase output script for a commitment
+func WitnessCommitmentScript(commitment types.Hash) []byte {
+	script := make([]byte, 0, witnessCommitmentSize)
+	script = append(script, witnessCommitmentHeader...)
+	return append(script, commitment[:]...)
+}
+
+// WitnessCommitmentIndex returns the index of the coinbase output holding
+// the witness commitment, or -1. If several outputs match the last wins.
+func WitnessCommitmentIndex(coinbase *types.Transaction) int {
+	index := -1
+	for i, output := range coinbase.Outputs {
+		script := output.PubKeyScript
+		if len(script) >= witnessCommitmentSize && bytes.HasPrefix(script, witnessCommitmentHeader) {
+			index = i
+		}
+	}
+	return index
+}
+
+// checkWitnessCommitment checks a block's witness data against the
+// commitment in its coinbase. Witness data is only allowed once SegWit is
+// active and when the coinbase commits to it.
+func checkWitnessCommitment(block *types.Block, segwitActive bool) error {
+	hasWitness := false
+	for i := range block.Transactions {
+		if block.Transactions[i].HasWitness() {
+			hasWitness = true
+			break
+		}
+	}
+
+	if !segwitActive {
+		if hasWitness {
+			return rejectf(RejectUnexpectedWitness, "unexpected witness data before SegWit activation")
+		}
+		return nil
+	}
+
+	coinbase := &block.Transactions[0]
+	index := WitnessCommitmentIndex(coinbase)
+	if index < 0 {
+		if hasWitness {
+			return rejectf(RejectUnexpectedWitness, "unexpected witness data without a witness commitment")
+		}
+		return nil
+	}
+
+	witness := coinbase.Inputs[0].Witness
+	if len(witness) != 1 || len(witness[0]) != 32 {
+		return rejectf(RejectBadWitnessNonce, "coinbase witness must be a single 32 byte reserved value")
+	}
+
+	root, err := WitnessMerkleRoot(block.Transactions)
+	if err != nil {
+		return err
+	}
+	commitment := WitnessCommitment(root, witness[0])
+	committed := coinbase.Outputs[index].PubKeyScript[len(witnessCommitmentHeader):witnessCommitmentSize]
+	if !bytes.Equal(committed, commitment[:]) {
+		return rejectf(RejectBadWitnessCommit, "witness commitment mismatch: coinbase has %x, block has %s", committed, commitment)
+	}
+
+	return nil
+}
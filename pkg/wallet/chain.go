@@ -0,0 +1,276 @@
+package wallet
+
+import (
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// spentHistoryDepth is how many blocks the wallet remembers the coins a
+// block spent, so that disconnecting it can give them back
+const spentHistoryDepth = 100
+
+// spentCoin is a wallet output spent by the block at height
+type spentCoin struct {
+	coin   *utxo.UTXO
+	height uint64
+}
+
+// OutputStatus describes whether a wallet output is on the best chain
+type OutputStatus int
+
+const (
+	// StatusConfirmed outputs are in a block on the best chain
+	StatusConfirmed OutputStatus = iota
+	// StatusUnconfirmed outputs lost their block to a reorg and wait to
+	// be mined again
+	StatusUnconfirmed
+	// StatusConflicted outputs can never confirm: their transaction was
+	// double-spent by the new chain or descends from an orphaned coinbase
+	StatusConflicted
+)
+
+// String returns the status name
+func (s OutputStatus) String() string {
+	switch s {
+	case StatusConfirmed:
+		return "confirmed"
+	case StatusUnconfirmed:
+		return "unconfirmed"
+	case StatusConflicted:
+		return "conflicted"
+	default:
+		return "unknown"
+	}
+}
+
+// OutputStatus returns the status of one of the wallet's outputs
+func (w *Wallet) OutputStatus(outpoint utxo.OutPoint) (OutputStatus, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if _, ok := w.utxos[outpoint]; !ok {
+		return StatusConfirmed, false
+	}
+	return w.statusOf(outpoint), true
+}
+
+// ListUTXOs returns copies of the wallet's outputs, including unconfirmed
+// and conflicted ones
+func (w *Wallet) ListUTXOs() []*utxo.UTXO {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	coins := make([]*utxo.UTXO, 0, len(w.utxos))
+	for _, u := range w.utxos {
+		coins = append(coins, u.Clone())
+	}
+	return coins
+}
+
+// BlockConnected credits outputs paying us, drops the outputs the block
+// spends and conflicts disconnected transactions it double-spends
+func (w *Wallet) BlockConnected(block *types.Block, height uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirty = true
+
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		txHash, err := serialization.HashTransaction(tx)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := w.unconfirmed[txHash]; ok {
+			delete(w.unconfirmed, txHash) // Mined again on the new chain
+		} else if i > 0 {
+			w.conflictDoubleSpends(tx)
+		}
+		if i > 0 {
+			w.conflictCreated(tx, txHash)
+		}
+
+		var received, spent int64
+		if i > 0 {
+			for _, input := range tx.Inputs {
+				outpoint := utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)
+				if coin, ok := w.utxos[outpoint]; ok {
+					w.spent[outpoint] = spentCoin{coin: coin, height: height}
+					spent += coin.Value()
+				}
+				delete(w.utxos, outpoint)
+				delete(w.status, outpoint)
+			}
+		}
+
+		for index, output := range tx.Outputs {
+			if !w.owns(output.PubKeyScript) {
+				continue
+			}
+			outpoint := utxo.NewOutPoint(txHash, uint32(index))
+			w.utxos[outpoint] = utxo.NewUTXO(txHash, uint32(index), output, height, i == 0)
+			delete(w.status, outpoint)
+			w.markUsedLocked(output.PubKeyScript)
+			received += output.Value
+		}
+
+		w.recordConfirmed(txHash, i == 0, received, spent, height, time.Unix(int64(block.Header.Timestamp), 0))
+	}
+
+	for outpoint, spent := range w.spent {
+		if spent.height+spentHistoryDepth < height {
+			delete(w.spent, outpoint)
+		}
+	}
+}
+
+// BlockDisconnected undoes a block removed from the best chain. Coinbase
+// credits are reversed entirely since the coinbase can never be mined
+// again, and anything built on them becomes conflicted. Other outputs
+// paying us turn unconfirmed until their transaction is mined again, and
+// the coins the block spent are given back.
+func (w *Wallet) BlockDisconnected(block *types.Block, height uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirty = true
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := &block.Transactions[i]
+		txHash, err := serialization.HashTransaction(tx)
+		if err != nil {
+			continue
+		}
+
+		w.recordDisconnected(txHash, i == 0)
+
+		if i == 0 {
+			for index := range tx.Outputs {
+				outpoint := utxo.NewOutPoint(txHash, uint32(index))
+				delete(w.utxos, outpoint)
+				delete(w.status, outpoint)
+			}
+			w.conflictDescendants(txHash)
+			continue
+		}
+
+		paysUs := false
+		for index := range tx.Outputs {
+			outpoint := utxo.NewOutPoint(txHash, uint32(index))
+			if _, ok := w.utxos[outpoint]; ok {
+				w.status[outpoint] = StatusUnconfirmed
+				paysUs = true
+			}
+		}
+		if paysUs {
+			w.unconfirmed[txHash] = tx
+		}
+
+		for _, input := range tx.Inputs {
+			outpoint := utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)
+			if spent, ok := w.spent[outpoint]; ok && spent.height == height {
+				w.utxos[outpoint] = spent.coin
+				delete(w.status, outpoint)
+				delete(w.spent, outpoint)
+			}
+		}
+	}
+}
+
+// statusOf returns the status of an output. The caller must hold w.mu.
+func (w *Wallet) statusOf(outpoint utxo.OutPoint) OutputStatus {
+	if status, ok := w.status[outpoint]; ok {
+		return status
+	}
+	return StatusConfirmed
+}
+
+// conflictDoubleSpends conflicts every unconfirmed transaction that spends
+// an input of tx, which was just confirmed. The caller must hold w.mu.
+func (w *Wallet) conflictDoubleSpends(tx *types.Transaction) {
+	spent := make(map[utxo.OutPoint]bool, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		spent[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)] = true
+	}
+
+	for txHash, pending := range w.unconfirmed {
+		for _, input := range pending.Inputs {
+			if spent[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)] {
+				w.conflict(txHash)
+				w.conflictDescendants(txHash)
+				break
+			}
+		}
+	}
+}
+
+// conflictCreated conflicts every transaction the wallet created that
+// spends an input of tx, which was just confirmed, giving its other
+// inputs back. The caller must hold w.mu.
+func (w *Wallet) conflictCreated(tx *types.Transaction, txHash types.Hash) {
+	spent := make(map[utxo.OutPoint]bool, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		spent[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)] = true
+	}
+
+	for createdHash, created := range w.created {
+		if createdHash == txHash {
+			continue
+		}
+		for _, input := range created.tx.Inputs {
+			if spent[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)] {
+				w.release(createdHash, txHash)
+				break
+			}
+		}
+	}
+}
+
+// release marks a created transaction conflicted by conflictedBy, zero if
+// unknown, and frees its inputs for new transactions. The caller must
+// hold w.mu.
+func (w *Wallet) release(txHash, conflictedBy types.Hash) {
+	delete(w.created, txHash)
+	if rec, ok := w.history[txHash]; ok {
+		rec.Conflicted, rec.ConflictedBy = true, conflictedBy
+	}
+	w.dirty = true
+}
+
+// conflictDescendants conflicts every unconfirmed transaction that spends
+// an output of root, directly or through other unconfirmed transactions.
+// The caller must hold w.mu.
+func (w *Wallet) conflictDescendants(root types.Hash) {
+	dead := map[types.Hash]bool{root: true}
+	for changed := true; changed; {
+		changed = false
+		for txHash, pending := range w.unconfirmed {
+			for _, input := range pending.Inputs {
+				if dead[input.PrevTxHash] {
+					dead[txHash] = true
+					w.conflict(txHash)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+}
+
+// conflict marks the outputs of an unconfirmed transaction conflicted.
+// The caller must hold w.mu.
+func (w *Wallet) conflict(txHash types.Hash) {
+	tx, ok := w.unconfirmed[txHash]
+	if !ok {
+		return
+	}
+	for index := range tx.Outputs {
+		outpoint := utxo.NewOutPoint(txHash, uint32(index))
+		if _, ok := w.utxos[outpoint]; ok {
+			w.status[outpoint] = StatusConflicted
+		}
+	}
+	delete(w.unconfirmed, txHash)
+}
@@ -0,0 +1,95 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/miniscript"
+)
+
+// WalletDescriptor is an output descriptor covering some of the wallet's
+// keys. Importing it elsewhere recreates those addresses.
+type WalletDescriptor struct {
+	Desc     string // With its checksum
+	Active   bool   // New addresses are derived from it
+	Internal bool   // It derives change addresses
+	Next     uint32 // Next index a ranged descriptor hands out
+}
+
+// ListDescriptors returns the wallet's descriptors: for an HD wallet one
+// ranged pkh() descriptor per chain of its account, followed by a pkh()
+// descriptor for every key not derived from the seed. With private the
+// descriptors hold private keys (xprv, WIF), otherwise only public ones,
+// enough for a watch-only copy. An encrypted wallet must be unlocked.
+func (w *Wallet) ListDescriptors(private bool) ([]WalletDescriptor, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.lockedLocked() {
+		return nil, ErrWalletLocked
+	}
+
+	var descs []WalletDescriptor
+	derived := make(map[string]bool)
+	if w.hd != nil {
+		master, err := keys.NewMasterKey(w.hd.seed)
+		if err != nil {
+			return nil, err
+		}
+		path := hdAccountPath(w.params)
+		account, err := master.Derive(path...)
+		if err != nil {
+			return nil, err
+		}
+		fp := master.Fingerprint()
+		origin := hex.EncodeToString(fp[:])
+		for _, index := range path {
+			origin += fmt.Sprintf("/%dh", index-keys.HardenedKeyStart)
+		}
+		key := account.PublicString(w.params)
+		if private {
+			key = account.String(w.params)
+		}
+
+		for chain := range w.hd.next {
+			desc, err := miniscript.AddChecksum(fmt.Sprintf("pkh([%s]%s/%d/*)", origin, key, chain))
+			if err != nil {
+				return nil, err
+			}
+			descs = append(descs, WalletDescriptor{Desc: desc, Active: true, Internal: chain == InternalChain, Next: w.hd.next[chain]})
+
+			chainKey, err := account.Child(uint32(chain))
+			if err != nil {
+				return nil, err
+			}
+			for index := uint32(0); index < w.hd.next[chain]; index++ {
+				if child, err := chainKey.Child(index); err == nil {
+					derived[child.PrivateKey().PublicKey().P2PKHAddressForNetwork(w.params)] = true
+				}
+			}
+		}
+	}
+
+	addresses := make([]string, 0, len(w.keys))
+	for address := range w.keys {
+		if !derived[address] {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		privKey := w.keys[address]
+		key := hex.EncodeToString(privKey.PublicKey().Bytes(true))
+		if private {
+			key = privKey.ToWIF(true)
+		}
+		desc, err := miniscript.AddChecksum("pkh(" + key + ")")
+		if err != nil {
+			return nil, err
+		}
+		descs = append(descs, WalletDescriptor{Desc: desc})
+	}
+	return descs, nil
+}
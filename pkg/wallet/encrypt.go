@@ -0,0 +1,284 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+)
+
+var (
+	ErrWalletLocked     = errors.New("wallet is locked, unlock it with walletpassphrase first")
+	ErrWrongPassphrase  = errors.New("wrong wallet passphrase")
+	ErrNotEncrypted     = errors.New("wallet is not encrypted")
+	ErrAlreadyEncrypted = errors.New("wallet is already encrypted")
+)
+
+// Key derivation parameters. scrypt makes every passphrase guess cost
+// about 100ms and 32MB.
+const (
+	scryptN   = 1 << 15
+	scryptR   = 8
+	scryptP   = 1
+	saltSize  = 16
+	aesKeyLen = 32
+)
+
+// checkLabel is sealed with the derived key so a wrong passphrase is
+// caught even when the wallet has no keys yet
+const checkLabel = "passphrase check"
+
+// EncryptWallet encrypts every private key, and the HD seed if any, with
+// a key derived from passphrase and locks the wallet. From then on the
+// keys are only in memory between Unlock and Lock.
+func (w *Wallet) EncryptWallet(passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("passphrase must not be empty")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.salt != nil {
+		return ErrAlreadyEncrypted
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+
+	encrypted := make(map[string][]byte, len(w.keys))
+	plain := make(map[string]*keys.PrivateKey, len(w.keys))
+	for address, privKey := range w.keys {
+		sealed, err := sealKey(key, address, privKey)
+		if err != nil {
+			return err
+		}
+		encrypted[address] = sealed
+		plain[address] = privKey
+	}
+
+	var seed []byte
+	if w.hd != nil {
+		sealed, err := seal(key, hdSeedLabel, w.hd.seed)
+		if err != nil {
+			return err
+		}
+		seed, w.hd.sealed = w.hd.seed, sealed
+	}
+
+	check, err := seal(key, checkLabel, []byte(checkLabel))
+	if err != nil {
+		return err
+	}
+
+	w.salt, w.check, w.encrypted = salt, check, encrypted
+	w.lockLocked()
+
+	// Only the encrypted keys may reach the disk from now on
+	w.dirty = true
+	if err := w.flushLocked(); err != nil {
+		w.salt, w.check, w.encrypted, w.keys = nil, nil, nil, plain
+		if w.hd != nil {
+			w.hd.seed, w.hd.sealed = seed, nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Unlock decrypts the private keys for timeout, after which the wallet
+// locks itself again. Unlocking an unlocked wallet restarts the timeout.
+func (w *Wallet) Unlock(passphrase string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("unlock timeout must be positive, got %s", timeout)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.salt == nil {
+		return ErrNotEncrypted
+	}
+	key, err := deriveKey(passphrase, w.salt)
+	if err != nil {
+		return err
+	}
+	if _, err := open(key, checkLabel, w.check); err != nil {
+		return ErrWrongPassphrase
+	}
+
+	decrypted := make(map[string]*keys.PrivateKey, len(w.encrypted))
+	for address, sealed := range w.encrypted {
+		privKey, err := openKey(key, address, sealed)
+		if err != nil {
+			return ErrWrongPassphrase
+		}
+		decrypted[address] = privKey
+	}
+	var seed []byte
+	if w.hd != nil {
+		if seed, err = open(key, hdSeedLabel, w.hd.sealed); err != nil {
+			return ErrWrongPassphrase
+		}
+		w.hd.seed = seed
+	}
+	for address, privKey := range decrypted {
+		w.keys[address] = privKey
+	}
+	w.unlockKey = key
+
+	if w.relock != nil {
+		w.relock.Stop()
+	}
+	w.relock = time.AfterFunc(timeout, func() { w.Lock() })
+	return nil
+}
+
+// Lock forgets the decrypted private keys
+func (w *Wallet) Lock() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.salt == nil {
+		return ErrNotEncrypted
+	}
+	w.lockLocked()
+	return nil
+}
+
+// IsEncrypted reports whether the wallet's keys are encrypted
+func (w *Wallet) IsEncrypted() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.salt != nil
+}
+
+// IsLocked reports whether the wallet is encrypted and its keys are not
+// available for signing
+func (w *Wallet) IsLocked() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.lockedLocked()
+}
+
+// lockedLocked reports whether signing needs an unlock. The caller must
+// hold w.mu.
+func (w *Wallet) lockedLocked() bool {
+	return w.salt != nil && w.unlockKey == nil
+}
+
+// lockLocked drops the decrypted keys but keeps their addresses, so the
+// wallet still recognizes its outputs. The caller must hold w.mu.
+func (w *Wallet) lockLocked() {
+	for address := range w.keys {
+		w.keys[address] = nil
+	}
+	if w.hd != nil {
+		w.hd.seed = nil
+	}
+	w.unlockKey = nil
+	if w.relock != nil {
+		w.relock.Stop()
+		w.relock = nil
+	}
+}
+
+// addKeyLocked stores a new private key, encrypting it if the wallet is
+// encrypted. The caller must hold w.mu.
+func (w *Wallet) addKeyLocked(address string, privKey *keys.PrivateKey) error {
+	if w.salt != nil {
+		if w.unlockKey == nil {
+			return ErrWalletLocked
+		}
+		sealed, err := sealKey(w.unlockKey, address, privKey)
+		if err != nil {
+			return err
+		}
+		w.encrypted[address] = sealed
+	}
+	w.keys[address] = privKey
+	return nil
+}
+
+// removeKeyLocked undoes addKeyLocked. The caller must hold w.mu.
+func (w *Wallet) removeKeyLocked(address string) {
+	delete(w.keys, address)
+	if w.encrypted != nil {
+		delete(w.encrypted, address)
+	}
+}
+
+// deriveKey stretches a passphrase into an AES-256 key
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, aesKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return key, nil
+}
+
+// sealKey encrypts a private key with AES-256-GCM. The address is
+// authenticated with it, so a key can't be swapped onto another address
+// in the file. The result is the nonce followed by the ciphertext.
+func sealKey(key []byte, address string, privKey *keys.PrivateKey) ([]byte, error) {
+	return seal(key, address, privKey.Bytes())
+}
+
+// openKey decrypts a key sealed by sealKey
+func openKey(key []byte, address string, sealed []byte) (*keys.PrivateKey, error) {
+	raw, err := open(key, address, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return keys.NewPrivateKeyFromBytes(raw)
+}
+
+// seal encrypts plaintext with AES-256-GCM, authenticating label with it,
+// and returns the nonce followed by the ciphertext
+func seal(key []byte, label string, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, []byte(label)), nil
+}
+
+// open decrypts data sealed by seal with the same label
+func open(key []byte, label string, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed data for %s is truncated", label)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, []byte(label))
+}
+
+// newGCM creates an AES-GCM cipher for key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
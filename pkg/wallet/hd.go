@@ -0,0 +1,236 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+)
+
+// DefaultGapLimit is how many unused addresses in a row DiscoverAddresses
+// looks past before deciding a chain has no more used addresses
+const DefaultGapLimit = 20
+
+// The two BIP44 chains below an account: addresses handed out to payers
+// and change
+const (
+	ExternalChain = 0
+	InternalChain = 1
+)
+
+var (
+	ErrNoHDSeed  = errors.New("wallet has no HD seed")
+	ErrHasHDSeed = errors.New("wallet already has an HD seed")
+)
+
+// hdSeedLabel authenticates the sealed seed of an encrypted wallet
+const hdSeedLabel = "hd seed"
+
+// hdState is the seed of an HD wallet and how far each chain has been
+// handed out
+type hdState struct {
+	seed   []byte    // nil while an encrypted wallet is locked
+	sealed []byte    // The seed sealed by seal, when the wallet is encrypted
+	next   [2]uint32 // Next unused index of the external and internal chain
+}
+
+// Discovery is what DiscoverAddresses found: the highest used index of
+// each chain, -1 if none was used
+type Discovery struct {
+	External int64 `json:"external"`
+	Internal int64 `json:"internal"`
+}
+
+// SetHDSeed makes the wallet derive its keys from seed, along
+// m/44'/coin'/0'/chain/index with coin 0 on mainnet and 1 elsewhere.
+// New addresses continue from index 0; call DiscoverAddresses to skip
+// the ones used before.
+func (w *Wallet) SetHDSeed(seed []byte) error {
+	if _, err := keys.NewMasterKey(seed); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.hd != nil {
+		return ErrHasHDSeed
+	}
+	if w.lockedLocked() {
+		return ErrWalletLocked
+	}
+	hd := &hdState{seed: bytes.Clone(seed)}
+	if w.salt != nil {
+		sealed, err := seal(w.unlockKey, hdSeedLabel, seed)
+		if err != nil {
+			return err
+		}
+		hd.sealed = sealed
+	}
+	w.hd = hd
+
+	w.dirty = true
+	if err := w.flushLocked(); err != nil {
+		w.hd = nil
+		return err
+	}
+	return nil
+}
+
+// IsHD reports whether the wallet derives its keys from a seed
+func (w *Wallet) IsHD() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.hd != nil
+}
+
+// NextIndex returns the index the next address of chain will be derived at
+func (w *Wallet) NextIndex(chain int) (uint32, error) {
+	if chain != ExternalChain && chain != InternalChain {
+		return 0, fmt.Errorf("invalid HD chain %d", chain)
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.hd == nil {
+		return 0, ErrNoHDSeed
+	}
+	return w.hd.next[chain], nil
+}
+
+// DiscoverAddresses finds the addresses a restored seed used before.
+// Each chain is derived from index 0 and every address is passed to used
+// until gapLimit addresses in a row come back unused. The keys up to the
+// highest used index are added to the wallet and new addresses continue
+// after it. The wallet's outputs are not touched: rescan the chain
+// afterwards to find them.
+func (w *Wallet) DiscoverAddresses(used func(address string) bool, gapLimit int) (Discovery, error) {
+	if gapLimit < 1 {
+		return Discovery{}, fmt.Errorf("gap limit must be positive, got %d", gapLimit)
+	}
+
+	w.mu.RLock()
+	if w.hd == nil {
+		w.mu.RUnlock()
+		return Discovery{}, ErrNoHDSeed
+	}
+	if w.lockedLocked() {
+		w.mu.RUnlock()
+		return Discovery{}, ErrWalletLocked
+	}
+	seed, params := w.hd.seed, w.params
+	w.mu.RUnlock()
+
+	// used may be slow, so derive and ask without holding the lock
+	var found [2][]*keys.PrivateKey
+	for chain := range found {
+		chainKey, err := hdChainKey(seed, params, uint32(chain))
+		if err != nil {
+			return Discovery{}, err
+		}
+		var derived []*keys.PrivateKey
+		highest := -1
+		for index := 0; index-highest <= gapLimit; index++ {
+			child, err := chainKey.Child(uint32(index))
+			if err != nil {
+				return Discovery{}, fmt.Errorf("HD chain %d index %d: %w", chain, index, err)
+			}
+			derived = append(derived, child.PrivateKey())
+			if used(child.PrivateKey().PublicKey().P2PKHAddressForNetwork(params)) {
+				highest = index
+			}
+		}
+		found[chain] = derived[:highest+1]
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.hd == nil || !bytes.Equal(w.hd.seed, seed) {
+		return Discovery{}, fmt.Errorf("HD seed changed during discovery")
+	}
+	var added []string
+	next := w.hd.next
+	undo := func() {
+		for _, address := range added {
+			w.removeKeyLocked(address)
+		}
+		w.hd.next = next
+	}
+	for chain, derived := range found {
+		for _, privKey := range derived {
+			address := privKey.PublicKey().P2PKHAddressForNetwork(params)
+			if w.keys[address] != nil {
+				continue
+			}
+			if err := w.addKeyLocked(address, privKey); err != nil {
+				undo()
+				return Discovery{}, err
+			}
+			added = append(added, address)
+		}
+		w.hd.next[chain] = max(w.hd.next[chain], uint32(len(derived)))
+	}
+
+	w.dirty = true
+	if err := w.flushLocked(); err != nil {
+		undo()
+		return Discovery{}, err
+	}
+	return Discovery{External: int64(len(found[ExternalChain])) - 1, Internal: int64(len(found[InternalChain])) - 1}, nil
+}
+
+// nextHDKeyLocked derives the key at the next index of chain and moves
+// past it. The caller must hold w.mu and check the wallet is unlocked.
+func (w *Wallet) nextHDKeyLocked(chain int) (*keys.PrivateKey, error) {
+	chainKey, err := hdChainKey(w.hd.seed, w.params, uint32(chain))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		index := w.hd.next[chain]
+		if index >= keys.HardenedKeyStart {
+			return nil, fmt.Errorf("HD chain %d is exhausted", chain)
+		}
+		w.hd.next[chain]++
+		child, err := chainKey.Child(index)
+		if errors.Is(err, keys.ErrUnusableKey) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return child.PrivateKey(), nil
+	}
+}
+
+// hdChainKey derives m/44'/coin'/0'/chain from seed
+func hdChainKey(seed []byte, params *keys.NetParams, chain uint32) (*keys.ExtendedKey, error) {
+	account, err := hdAccountKey(seed, params)
+	if err != nil {
+		return nil, err
+	}
+	return account.Child(chain)
+}
+
+// hdAccountKey derives the account key m/44'/coin'/0' from seed, with
+// coin 0 on mainnet and 1 elsewhere
+func hdAccountKey(seed []byte, params *keys.NetParams) (*keys.ExtendedKey, error) {
+	master, err := keys.NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return master.Derive(hdAccountPath(params)...)
+}
+
+// hdAccountPath returns the path from the master key to the account key
+func hdAccountPath(params *keys.NetParams) []uint32 {
+	coin := uint32(1)
+	if params.Name == keys.MainNetParams.Name {
+		coin = 0
+	}
+	return []uint32{keys.HardenedKeyStart + 44, keys.HardenedKeyStart + coin, keys.HardenedKeyStart}
+}
@@ -0,0 +1,154 @@
+package wallet
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// Transaction categories, as listtransactions reports them
+const (
+	CategorySend     = "send"     // Spends wallet coins
+	CategoryReceive  = "receive"  // Only pays the wallet
+	CategoryGenerate = "generate" // Coinbase paying the wallet
+	CategoryOrphan   = "orphan"   // Coinbase whose block left the best chain
+)
+
+// TxRecord is a transaction in the wallet's history
+type TxRecord struct {
+	TxHash   types.Hash
+	Category string
+	Amount   int64  // Paid to the payee for sends we created, else the net change to the balance
+	Fee      int64  // Known only for transactions the wallet created
+	Height   uint64 // 0 while unconfirmed
+	Time     time.Time
+
+	// ReplacedBy is set once BumpFee replaced the transaction
+	ReplacedBy types.Hash
+
+	// Conflicted is set once the transaction can no longer confirm as
+	// things stand: a block double-spent it (ConflictedBy), or it left the
+	// mempool without being mined (ConflictedBy is zero)
+	Conflicted   bool
+	ConflictedBy types.Hash
+
+	// Abandoned is set by AbandonTransaction
+	Abandoned bool
+}
+
+// Confirmed reports whether the transaction is in a block on the best chain
+func (r *TxRecord) Confirmed() bool {
+	return r.Height > 0
+}
+
+// createdTx is a transaction the wallet built, kept so it can be re-signed
+// with a higher fee
+type createdTx struct {
+	tx       *types.Transaction
+	prevOuts []types.TxOutput // Output spent by each input
+	fee      int64
+}
+
+// ListTransactions returns up to count records in chronological order,
+// leaving out the skip most recent ones. Unconfirmed transactions count
+// as the most recent.
+func (w *Wallet) ListTransactions(count, skip int) []TxRecord {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	records := make([]TxRecord, 0, len(w.history))
+	for _, rec := range w.history {
+		records = append(records, *rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Confirmed() != b.Confirmed() {
+			return a.Confirmed()
+		}
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		return a.TxHash.String() < b.TxHash.String()
+	})
+
+	end := len(records) - skip
+	if end < 0 {
+		end = 0
+	}
+	start := end - count
+	if start < 0 || count < 0 {
+		start = 0
+	}
+	return records[start:end]
+}
+
+// recordCreated adds a transaction the wallet just built to its history.
+// The caller must hold w.mu.
+func (w *Wallet) recordCreated(txHash types.Hash, created *createdTx, amount int64) {
+	w.created[txHash] = created
+	w.history[txHash] = &TxRecord{
+		TxHash:   txHash,
+		Category: CategorySend,
+		Amount:   -amount,
+		Fee:      created.fee,
+		Time:     time.Now(),
+	}
+	w.dirty = true
+}
+
+// recordConfirmed notes that a transaction moving received and spent
+// satoshis of the wallet was mined at height. The caller must hold w.mu.
+func (w *Wallet) recordConfirmed(txHash types.Hash, coinbase bool, received, spent int64, height uint64, blockTime time.Time) {
+	if received == 0 && spent == 0 {
+		return
+	}
+
+	rec, ok := w.history[txHash]
+	if !ok {
+		rec = &TxRecord{TxHash: txHash, Amount: received - spent, Time: blockTime}
+		switch {
+		case coinbase:
+			rec.Category = CategoryGenerate
+		case spent > 0:
+			rec.Category = CategorySend
+		default:
+			rec.Category = CategoryReceive
+		}
+		w.history[txHash] = rec
+	}
+	if rec.Category == CategoryOrphan {
+		rec.Category = CategoryGenerate
+	}
+	rec.Height = height
+	rec.Conflicted, rec.ConflictedBy, rec.Abandoned = false, types.Hash{}, false
+	delete(w.created, txHash)
+}
+
+// recordDisconnected marks the wallet transactions of a disconnected block
+// unconfirmed. The caller must hold w.mu.
+func (w *Wallet) recordDisconnected(txHash types.Hash, coinbase bool) {
+	rec, ok := w.history[txHash]
+	if !ok {
+		return
+	}
+	rec.Height = 0
+	if coinbase {
+		rec.Category = CategoryOrphan
+	}
+}
+
+// GetTransactionRecord returns the wallet's record of a transaction
+func (w *Wallet) GetTransactionRecord(txHash types.Hash) (TxRecord, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	rec, ok := w.history[txHash]
+	if !ok {
+		return TxRecord{}, false
+	}
+	return *rec, true
+}
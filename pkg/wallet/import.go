@@ -0,0 +1,66 @@
+package wallet
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+)
+
+// KeyExport is a wallet address and its private key in WIF
+type KeyExport struct {
+	Address string
+	WIF     string
+}
+
+// ImportPrivateKey adds a WIF private key to the wallet and returns its
+// address. Importing a key the wallet already has is a no-op. Outputs
+// already on the chain are only found by feeding the blocks to
+// BlockConnected again.
+func (w *Wallet) ImportPrivateKey(wif string) (string, error) {
+	privKey, _, err := keys.FromWIF(wif)
+	if err != nil {
+		return "", err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.lockedLocked() {
+		return "", ErrWalletLocked
+	}
+	address := privKey.PublicKey().P2PKHAddressForNetwork(w.params)
+	if _, ok := w.keys[address]; ok {
+		return address, nil
+	}
+	if err := w.addKeyLocked(address, privKey); err != nil {
+		return "", err
+	}
+
+	w.dirty = true
+	if err := w.flushLocked(); err != nil {
+		w.removeKeyLocked(address)
+		return "", err
+	}
+	return address, nil
+}
+
+// DumpKeys returns every address with its private key, sorted by address.
+// An encrypted wallet must be unlocked.
+func (w *Wallet) DumpKeys() ([]KeyExport, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.lockedLocked() {
+		return nil, ErrWalletLocked
+	}
+	exports := make([]KeyExport, 0, len(w.keys))
+	for address, privKey := range w.keys {
+		if privKey == nil {
+			return nil, fmt.Errorf("no private key for %s", address)
+		}
+		exports = append(exports, KeyExport{Address: address, WIF: privKey.ToWIF(true)})
+	}
+	sort.Slice(exports, func(i, j int) bool { return exports[i].Address < exports[j].Address })
+	return exports, nil
+}
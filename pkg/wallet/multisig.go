@@ -0,0 +1,66 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/psbt"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+)
+
+// MultiSigAddress returns the P2WSH address of an m-of-n multisig over
+// pubKeys on the wallet's network, and the witness script spending it
+// needs. Nothing is stored: the co-signers each keep the script, and
+// spends go through a PSBT that every wallet signs with SignPSBT.
+func (w *Wallet) MultiSigAddress(m int, pubKeys ...[]byte) (string, []byte, error) {
+	witnessScript, err := contracts.MultiSigScript(m, pubKeys...)
+	if err != nil {
+		return "", nil, err
+	}
+	pkScript, err := script.P2WSH(witnessScript)
+	if err != nil {
+		return "", nil, err
+	}
+	addr, err := keys.AddressFromScript(pkScript, w.NetParams())
+	if err != nil {
+		return "", nil, err
+	}
+	return addr.String(), witnessScript, nil
+}
+
+// SignPSBT adds the wallet's signature to every input of p spending a
+// script one of its keys signs for, and returns how many it added.
+// Finalized inputs, inputs the PSBT lacks the UTXO of and scripts the
+// PSBT package can't sign are skipped.
+func (w *Wallet) SignPSBT(p *psbt.Packet) (int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.lockedLocked() {
+		return 0, ErrWalletLocked
+	}
+
+	signed := 0
+	for i := range p.Inputs {
+	keyLoop:
+		for _, key := range w.keys {
+			if key == nil {
+				continue
+			}
+			err := p.Sign(i, key)
+			switch {
+			case err == nil:
+				signed++
+			case errors.Is(err, psbt.ErrKeyNotInScript):
+			case errors.Is(err, psbt.ErrInputAlreadyFinal), errors.Is(err, psbt.ErrMissingUTXO),
+				errors.Is(err, psbt.ErrUnsupportedScript):
+				break keyLoop
+			default:
+				return signed, fmt.Errorf("input %d: %w", i, err)
+			}
+		}
+	}
+	return signed, nil
+}
@@ -0,0 +1,450 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/fileutil"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// WalletFileName is the wallet file inside the data directory
+const WalletFileName = "wallet.json"
+
+// BackupDirName is the directory inside the data directory automatic
+// backups are rotated in
+const BackupDirName = "backups"
+
+// walletFileVersion is bumped whenever the file layout changes
+const walletFileVersion = 1
+
+// backupPrefix and backupSuffix frame the timestamp in rotated backup names
+const (
+	backupPrefix = "wallet-"
+	backupSuffix = ".json"
+)
+
+// walletFile is the on-disk form of a wallet. Reorg bookkeeping (coins
+// spent by recent blocks, disconnected transactions) is not saved; it is
+// rebuilt as blocks arrive.
+type walletFile struct {
+	Version      int          `json:"version"`
+	Network      string       `json:"network"`
+	Salt         string       `json:"salt,omitempty"`   // Hex, set when the keys are encrypted
+	Check        string       `json:"check,omitempty"`  // Hex, verifies the passphrase
+	HDSeed       string       `json:"hdseed,omitempty"` // Hex, sealed with hdSeedLabel if the file has a salt
+	HDNext       []uint32     `json:"hdnext,omitempty"` // Next index of the external and internal chain
+	AvoidReuse   bool         `json:"avoid_reuse,omitempty"`
+	Used         []string     `json:"used,omitempty"` // Addresses that have received
+	Keys         []walletKey  `json:"keys"`
+	UTXOs        []walletUTXO `json:"utxos"`
+	Transactions []walletTx   `json:"transactions,omitempty"`
+}
+
+// walletKey is a private key and the address it was generated for
+type walletKey struct {
+	Address    string `json:"address"`
+	PrivateKey string `json:"privkey"` // Hex, sealed by sealKey if the file has a salt
+}
+
+// walletTx is a TxRecord
+type walletTx struct {
+	TxHash     string `json:"txid"`
+	Category   string `json:"category"`
+	Amount     int64  `json:"amount"`
+	Fee        int64  `json:"fee,omitempty"`
+	Height     uint64 `json:"height,omitempty"`
+	Time       int64  `json:"time"`
+	ReplacedBy string `json:"replaced_by,omitempty"`
+
+	Conflicted   bool   `json:"conflicted,omitempty"`
+	ConflictedBy string `json:"conflicted_by,omitempty"`
+	Abandoned    bool   `json:"abandoned,omitempty"`
+}
+
+// walletUTXO is a serialized wallet output and its status
+type walletUTXO struct {
+	Data   string       `json:"data"` // Hex of utxo.UTXO.Serialize
+	Status OutputStatus `json:"status,omitempty"`
+}
+
+// SetFile makes path the wallet's file. New keys are written to it at
+// once; other changes are written by Flush.
+func (w *Wallet) SetFile(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.path = path
+	w.dirty = true
+}
+
+// Flush writes pending changes to the wallet file, if one is set
+func (w *Wallet) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.flushLocked()
+}
+
+// flushLocked writes the wallet file if it has pending changes. The caller
+// must hold w.mu.
+func (w *Wallet) flushLocked() error {
+	if w.path == "" || !w.dirty {
+		return nil
+	}
+	data, err := w.encodeLocked()
+	if err != nil {
+		return err
+	}
+	if err := writeWalletFile(w.path, data); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// SaveToFile writes the whole wallet to path
+func (w *Wallet) SaveToFile(path string) error {
+	w.mu.RLock()
+	data, err := w.encodeLocked()
+	w.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return writeWalletFile(path, data)
+}
+
+// LoadFromFile adds the keys and outputs saved at path to the wallet. The
+// file must be for the wallet's network. A missing file is not an error:
+// the wallet simply starts empty.
+func (w *Wallet) LoadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read wallet file: %w", err)
+	}
+
+	var file walletFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to decode wallet file: %w", err)
+	}
+	if file.Version != walletFileVersion {
+		return fmt.Errorf("unsupported wallet file version %d", file.Version)
+	}
+
+	var salt, check []byte
+	if file.Salt != "" {
+		if salt, err = hex.DecodeString(file.Salt); err != nil || len(salt) != saltSize {
+			return fmt.Errorf("wallet file: invalid salt")
+		}
+		if check, err = hex.DecodeString(file.Check); err != nil || len(check) == 0 {
+			return fmt.Errorf("wallet file: invalid passphrase check")
+		}
+	}
+
+	var hd *hdState
+	if file.HDSeed != "" {
+		raw, err := hex.DecodeString(file.HDSeed)
+		if err != nil {
+			return fmt.Errorf("wallet file: invalid HD seed: %w", err)
+		}
+		hd = &hdState{}
+		if salt != nil {
+			hd.sealed = raw
+		} else if _, err := keys.NewMasterKey(raw); err != nil {
+			return fmt.Errorf("wallet file: %w", err)
+		} else {
+			hd.seed = raw
+		}
+		if len(file.HDNext) > len(hd.next) {
+			return fmt.Errorf("wallet file: invalid HD chain indexes")
+		}
+		copy(hd.next[:], file.HDNext)
+	}
+
+	privKeys := make(map[string]*keys.PrivateKey, len(file.Keys))
+	sealed := make(map[string][]byte, len(file.Keys))
+	for _, key := range file.Keys {
+		raw, err := hex.DecodeString(key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("wallet file: invalid key for %s: %w", key.Address, err)
+		}
+		if salt != nil {
+			sealed[key.Address] = raw
+			continue
+		}
+		privKey, err := keys.NewPrivateKeyFromBytes(raw)
+		if err != nil {
+			return fmt.Errorf("wallet file: invalid key for %s: %w", key.Address, err)
+		}
+		privKeys[key.Address] = privKey
+	}
+
+	coins := make([]*utxo.UTXO, 0, len(file.UTXOs))
+	for _, entry := range file.UTXOs {
+		raw, err := hex.DecodeString(entry.Data)
+		if err != nil {
+			return fmt.Errorf("wallet file: invalid output: %w", err)
+		}
+		coin, err := utxo.DeserializeUTXO(raw)
+		if err != nil {
+			return fmt.Errorf("wallet file: invalid output: %w", err)
+		}
+		coins = append(coins, coin)
+	}
+
+	records := make([]*TxRecord, 0, len(file.Transactions))
+	for _, entry := range file.Transactions {
+		txHash, err := types.NewHashFromString(entry.TxHash)
+		if err != nil {
+			return fmt.Errorf("wallet file: invalid transaction: %w", err)
+		}
+		rec := &TxRecord{
+			TxHash:   txHash,
+			Category: entry.Category,
+			Amount:   entry.Amount,
+			Fee:      entry.Fee,
+			Height:   entry.Height,
+			Time:     time.Unix(entry.Time, 0),
+
+			Conflicted: entry.Conflicted,
+			Abandoned:  entry.Abandoned,
+		}
+		if entry.ReplacedBy != "" {
+			if rec.ReplacedBy, err = types.NewHashFromString(entry.ReplacedBy); err != nil {
+				return fmt.Errorf("wallet file: invalid transaction: %w", err)
+			}
+		}
+		if entry.ConflictedBy != "" {
+			if rec.ConflictedBy, err = types.NewHashFromString(entry.ConflictedBy); err != nil {
+				return fmt.Errorf("wallet file: invalid transaction: %w", err)
+			}
+		}
+		records = append(records, rec)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if file.Network != w.params.Name {
+		return fmt.Errorf("wallet file is for %s, not %s", file.Network, w.params.Name)
+	}
+	if salt != nil {
+		// Keys can't be merged across passphrases
+		if len(w.keys) > 0 && (w.salt == nil || !bytes.Equal(w.salt, salt)) {
+			return fmt.Errorf("wallet file is encrypted differently from the wallet")
+		}
+		if w.salt == nil {
+			w.salt, w.check, w.encrypted = salt, check, make(map[string][]byte)
+		}
+		for address, key := range sealed {
+			w.encrypted[address] = key
+			if _, ok := w.keys[address]; !ok {
+				w.keys[address] = nil
+			}
+		}
+	} else if w.salt != nil && (len(privKeys) > 0 || hd != nil) {
+		return fmt.Errorf("wallet file is not encrypted but the wallet is")
+	}
+	if hd != nil {
+		if w.hd != nil {
+			return ErrHasHDSeed
+		}
+		w.hd = hd
+	}
+	for address, privKey := range privKeys {
+		w.keys[address] = privKey
+	}
+	w.avoidReuse = w.avoidReuse || file.AvoidReuse
+	for _, address := range file.Used {
+		w.used[address] = true
+	}
+	for _, rec := range records {
+		w.history[rec.TxHash] = rec
+	}
+	for i, coin := range coins {
+		outpoint := coin.OutPoint()
+		w.utxos[outpoint] = coin
+		if status := file.UTXOs[i].Status; status != StatusConfirmed {
+			w.status[outpoint] = status
+		}
+	}
+	return nil
+}
+
+// Backup writes a consistent copy of the wallet to destination. Pending
+// changes are flushed to the wallet file first, under the same lock, so
+// the backup and the wallet file hold the same state.
+func (w *Wallet) Backup(destination string) error {
+	if destination == "" {
+		return fmt.Errorf("backup destination is empty")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.path != "" && samePath(w.path, destination) {
+		return fmt.Errorf("backup destination is the wallet file")
+	}
+	if err := w.flushLocked(); err != nil {
+		return err
+	}
+
+	data, err := w.encodeLocked()
+	if err != nil {
+		return err
+	}
+	if err := writeWalletFile(destination, data); err != nil {
+		return fmt.Errorf("failed to back up wallet: %w", err)
+	}
+	return nil
+}
+
+// Contents returns what the wallet file holds, flushing pending changes
+// first, for a backup written somewhere other than a plain file
+func (w *Wallet) Contents() ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flushLocked(); err != nil {
+		return nil, err
+	}
+	return w.encodeLocked()
+}
+
+// RotateBackups writes a timestamped backup into dir and deletes the
+// oldest backups there beyond keep. It returns the new backup's path.
+func (w *Wallet) RotateBackups(dir string, keep int) (string, error) {
+	if keep < 1 {
+		return "", fmt.Errorf("must keep at least one backup, got %d", keep)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	// The timestamp sorts in creation order
+	name := backupPrefix + time.Now().UTC().Format("20060102-150405.000000000") + backupSuffix
+	path := filepath.Join(dir, name)
+	if err := w.Backup(path); err != nil {
+		return "", err
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*"+backupSuffix))
+	if err != nil {
+		return "", fmt.Errorf("failed to list backups: %w", err)
+	}
+	sort.Strings(backups)
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return path, fmt.Errorf("failed to remove old backup: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return path, nil
+}
+
+// encodeLocked serializes the wallet. The caller must hold w.mu.
+func (w *Wallet) encodeLocked() ([]byte, error) {
+	file := walletFile{
+		Version: walletFileVersion,
+		Network: w.params.Name,
+		Keys:    make([]walletKey, 0, len(w.keys)),
+		UTXOs:   make([]walletUTXO, 0, len(w.utxos)),
+	}
+	if w.salt != nil {
+		file.Salt = hex.EncodeToString(w.salt)
+		file.Check = hex.EncodeToString(w.check)
+	}
+	file.AvoidReuse = w.avoidReuse
+	for address := range w.used {
+		file.Used = append(file.Used, address)
+	}
+	if w.hd != nil {
+		seed := w.hd.seed
+		if w.salt != nil {
+			seed = w.hd.sealed
+		}
+		file.HDSeed = hex.EncodeToString(seed)
+		file.HDNext = w.hd.next[:]
+	}
+	for address, privKey := range w.keys {
+		var raw []byte
+		if w.salt != nil {
+			raw = w.encrypted[address]
+		} else {
+			raw = privKey.Bytes()
+		}
+		file.Keys = append(file.Keys, walletKey{
+			Address:    address,
+			PrivateKey: hex.EncodeToString(raw),
+		})
+	}
+	for outpoint, coin := range w.utxos {
+		file.UTXOs = append(file.UTXOs, walletUTXO{
+			Data:   hex.EncodeToString(coin.Serialize()),
+			Status: w.statusOf(outpoint),
+		})
+	}
+
+	for _, rec := range w.history {
+		entry := walletTx{
+			TxHash:   rec.TxHash.String(),
+			Category: rec.Category,
+			Amount:   rec.Amount,
+			Fee:      rec.Fee,
+			Height:   rec.Height,
+			Time:     rec.Time.Unix(),
+
+			Conflicted: rec.Conflicted,
+			Abandoned:  rec.Abandoned,
+		}
+		if !rec.ReplacedBy.IsZero() {
+			entry.ReplacedBy = rec.ReplacedBy.String()
+		}
+		if !rec.ConflictedBy.IsZero() {
+			entry.ConflictedBy = rec.ConflictedBy.String()
+		}
+		file.Transactions = append(file.Transactions, entry)
+	}
+
+	// Keep the file stable between saves of the same wallet
+	sort.Strings(file.Used)
+	sort.Slice(file.Keys, func(i, j int) bool { return file.Keys[i].Address < file.Keys[j].Address })
+	sort.Slice(file.UTXOs, func(i, j int) bool { return file.UTXOs[i].Data < file.UTXOs[j].Data })
+	sort.Slice(file.Transactions, func(i, j int) bool { return file.Transactions[i].TxHash < file.Transactions[j].TxHash })
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode wallet: %w", err)
+	}
+	return data, nil
+}
+
+// writeWalletFile replaces path with data. The file holds private keys,
+// so only the owner may read it.
+func writeWalletFile(path string, data []byte) error {
+	if err := fileutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write wallet file: %w", err)
+	}
+	return nil
+}
+
+// samePath reports whether a and b name the same file
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/miniscript"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// PolicyAddress returns the address of a policy descriptor's output on
+// the wallet's network. As with MultiSigAddress nothing is stored.
+func (w *Wallet) PolicyAddress(desc *miniscript.Descriptor) (string, error) {
+	pkScript, err := desc.Script()
+	if err != nil {
+		return "", err
+	}
+	addr, err := keys.AddressFromScript(pkScript, w.NetParams())
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// SignPolicy sets the witness of input inputIdx of tx, which spends
+// amount from desc's output, to the smallest one the wallet can make
+// with its keys and the given hash preimages. Lock times are checked
+// against tx as it is, so a spend taking an after() path must set its
+// lock time first. It fails with miniscript.ErrUnsatisfiable if no path
+// can be completed.
+func (w *Wallet) SignPolicy(tx *types.Transaction, inputIdx int, amount int64, desc *miniscript.Descriptor, preimages ...[]byte) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.lockedLocked() {
+		return ErrWalletLocked
+	}
+	if inputIdx < 0 || inputIdx >= len(tx.Inputs) {
+		return fmt.Errorf("invalid input index: %d", inputIdx)
+	}
+	s := &policySatisfier{
+		w:             w,
+		tx:            tx,
+		inputIdx:      inputIdx,
+		amount:        amount,
+		witnessScript: desc.WitnessScript(),
+		preimages:     preimages,
+		sigs:          make(map[string][]byte),
+	}
+	witness, err := desc.Witness(s)
+	if s.err != nil {
+		return s.err
+	}
+	if err != nil {
+		return err
+	}
+	tx.Inputs[inputIdx].Witness = witness
+	return nil
+}
+
+// policySatisfier satisfies a policy from a wallet. The caller must hold
+// w.mu.
+type policySatisfier struct {
+	w             *Wallet
+	tx            *types.Transaction
+	inputIdx      int
+	amount        int64
+	witnessScript []byte
+	preimages     [][]byte
+	sigs          map[string][]byte // Made so far, by public key
+	err           error             // First signing failure
+}
+
+func (s *policySatisfier) Sign(pubKey []byte) []byte {
+	if sig, ok := s.sigs[string(pubKey)]; ok {
+		return sig
+	}
+	var sig []byte
+	for _, key := range s.w.keys {
+		if key == nil || !bytes.Equal(key.PublicKey().Bytes(true), pubKey) {
+			continue
+		}
+		var err error
+		sig, err = transaction.SignWitness(s.tx, s.inputIdx, key, s.witnessScript, s.amount, transaction.SigHashAll)
+		if err != nil && s.err == nil {
+			s.err = err
+		}
+		break
+	}
+	s.sigs[string(pubKey)] = sig
+	return sig
+}
+
+func (s *policySatisfier) Preimage(hash []byte) []byte {
+	for _, preimage := range s.preimages {
+		if digest := sha256.Sum256(preimage); bytes.Equal(digest[:], hash) {
+			return preimage
+		}
+	}
+	return nil
+}
+
+// CheckAfter applies OP_CHECKLOCKTIMEVERIFY's rules to the transaction
+func (s *policySatisfier) CheckAfter(lockTime uint32) bool {
+	if (lockTime < script.LockTimeThreshold) != (s.tx.LockTime < script.LockTimeThreshold) {
+		return false
+	}
+	return lockTime <= s.tx.LockTime && s.tx.Inputs[s.inputIdx].Sequence != transaction.SequenceFinal
+}
@@ -0,0 +1,126 @@
+package wallet
+
+import (
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+// SetAvoidReuse sets the avoid_reuse flag. While it is set, change goes
+// to a fresh address instead of one that may already have received, and
+// coin selection spends every output of an address together, so no
+// address is left holding coins after it was linked to a payment.
+func (w *Wallet) SetAvoidReuse(enabled bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.avoidReuse == enabled {
+		return nil
+	}
+	w.avoidReuse = enabled
+	w.dirty = true
+	if err := w.flushLocked(); err != nil {
+		w.avoidReuse = !enabled
+		return err
+	}
+	return nil
+}
+
+// AvoidReuse reports whether the avoid_reuse flag is set
+func (w *Wallet) AvoidReuse() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.avoidReuse
+}
+
+// IsUsed reports whether address has received coins. Handing it out
+// again would link the payments.
+func (w *Wallet) IsUsed(address string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.used[address]
+}
+
+// markUsedLocked records that pubKeyScript, which pays us, received
+// coins. The caller must hold w.mu.
+func (w *Wallet) markUsedLocked(pubKeyScript []byte) {
+	if addr, err := keys.AddressFromScript(pubKeyScript, w.params); err == nil {
+		w.used[addr.String()] = true
+	}
+}
+
+// changeAddressLocked returns the address change goes to. With
+// avoid_reuse it is a new key, from the internal chain of an HD wallet;
+// otherwise any key of the wallet. The caller must hold w.mu and check
+// the wallet is unlocked.
+func (w *Wallet) changeAddressLocked() (string, error) {
+	if !w.avoidReuse {
+		for address := range w.keys {
+			return address, nil
+		}
+		return "", fmt.Errorf("wallet has no key for change")
+	}
+
+	var privKey *keys.PrivateKey
+	var err error
+	var next [2]uint32
+	if w.hd != nil {
+		next = w.hd.next
+		privKey, err = w.nextHDKeyLocked(InternalChain)
+	} else {
+		privKey, err = keys.GeneratePrivateKey()
+	}
+	if err != nil {
+		return "", err
+	}
+	address := privKey.PublicKey().P2PKHAddressForNetwork(w.params)
+	if err := w.addKeyLocked(address, privKey); err != nil {
+		return "", err
+	}
+
+	// The change key must be on disk before anything is paid to it
+	w.dirty = true
+	if err := w.flushLocked(); err != nil {
+		w.removeKeyLocked(address)
+		if w.hd != nil {
+			w.hd.next = next
+		}
+		return "", err
+	}
+	return address, nil
+}
+
+// selectGroups is selectUTXOs for avoid_reuse: outputs are taken a whole
+// address at a time, so spending from an address that received several
+// times empties it. The caller must hold w.mu.
+func (w *Wallet) selectGroups(amount int64) ([]*utxo.UTXO, int64, error) {
+	groups := make(map[string][]*utxo.UTXO)
+	var order []string
+	locked := w.lockedInputs()
+	for outpoint, u := range w.utxos {
+		if w.statusOf(outpoint) == StatusConflicted || locked[outpoint] {
+			continue
+		}
+		script := string(u.Output.PubKeyScript)
+		if _, ok := groups[script]; !ok {
+			order = append(order, script)
+		}
+		groups[script] = append(groups[script], u)
+	}
+
+	var selected []*utxo.UTXO
+	var total int64
+	for _, script := range order {
+		for _, u := range groups[script] {
+			selected = append(selected, u)
+			total += u.Value()
+		}
+		if total >= amount {
+			return selected, total, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("insufficient funds: have %d, need %d", total, amount)
+}
@@ -0,0 +1,142 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+var ErrCannotSpendTaproot = errors.New("wallet holds neither the internal key nor enough keys for any leaf")
+
+// TaprootAddress returns the address of a taproot output on the wallet's
+// network. As with MultiSigAddress nothing is stored: whoever spends it
+// needs output again.
+func (w *Wallet) TaprootAddress(output *contracts.TaprootOutput) (string, error) {
+	pkScript, err := output.Script()
+	if err != nil {
+		return "", err
+	}
+	addr, err := keys.AddressFromScript(pkScript, w.NetParams())
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// SignTaproot sets the witness of input inputIdx of tx, which spends
+// output; prevOuts are the outputs every input spends. With the internal
+// key the wallet signs for the key path: the smallest witness, and it
+// reveals no scripts. Otherwise it takes the cheapest leaf of the tree
+// it holds enough keys for. Leaves must be tapscript multisigs made by
+// contracts.CheckSigAddScript. It reports whether the key path was used.
+func (w *Wallet) SignTaproot(tx *types.Transaction, inputIdx int, prevOuts []types.TxOutput, output *contracts.TaprootOutput) (bool, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.lockedLocked() {
+		return false, ErrWalletLocked
+	}
+	ctx := &transaction.TaprootSpendContext{PrevOuts: prevOuts}
+
+	// Key path
+	if internal := w.xOnlyKeyLocked(output.InternalKey.XOnly()); internal != nil {
+		tweaked, err := internal.TaprootPrivateKey(output.MerkleRoot())
+		if err != nil {
+			return false, err
+		}
+		sig, err := transaction.SignTaproot(tx, inputIdx, ctx, tweaked, transaction.SigHashDefault)
+		if err != nil {
+			return false, err
+		}
+		tx.Inputs[inputIdx].Witness = [][]byte{sig}
+		return true, nil
+	}
+
+	// Script path, choosing the leaf by witness size: a signature or an
+	// empty item per key, the script and the control block
+	var best [][]byte
+	bestSize := -1
+	if output.Tree != nil {
+		for _, leaf := range output.Tree.Leaves() {
+			witness, err := w.satisfyLeafLocked(tx, inputIdx, ctx, output, leaf)
+			if err != nil {
+				return false, err
+			}
+			if witness == nil {
+				continue
+			}
+			size := 0
+			for _, item := range witness {
+				size += len(item)
+			}
+			if bestSize < 0 || size < bestSize {
+				best, bestSize = witness, size
+			}
+		}
+	}
+	if best == nil {
+		return false, ErrCannotSpendTaproot
+	}
+	tx.Inputs[inputIdx].Witness = best
+	return false, nil
+}
+
+// satisfyLeafLocked returns the witness spending output with leaf, or nil
+// if the leaf isn't a multisig the wallet holds enough keys for. The
+// caller must hold w.mu.
+func (w *Wallet) satisfyLeafLocked(tx *types.Transaction, inputIdx int, ctx *transaction.TaprootSpendContext, output *contracts.TaprootOutput, leaf script.TapLeaf) ([][]byte, error) {
+	if leaf.Version != script.TapscriptLeafVersion {
+		return nil, nil
+	}
+	pubKeys, m, err := contracts.ParseCheckSigAddScript(leaf.Script)
+	if err != nil {
+		return nil, nil
+	}
+
+	var signers []*keys.PrivateKey
+	held := 0
+	for _, pubKey := range pubKeys {
+		key := w.xOnlyKeyLocked(pubKey)
+		if key != nil && held < m {
+			held++
+		} else {
+			key = nil
+		}
+		signers = append(signers, key)
+	}
+	if held < m {
+		return nil, nil
+	}
+
+	// The script checks the last key first, so its signature is on top
+	leafCtx := *ctx
+	leafCtx.LeafHash = leaf.Hash()
+	stack := make([][]byte, len(signers))
+	for i, key := range signers {
+		item := []byte{}
+		if key != nil {
+			if item, err = transaction.SignTaproot(tx, inputIdx, &leafCtx, key, transaction.SigHashDefault); err != nil {
+				return nil, fmt.Errorf("signing leaf: %w", err)
+			}
+		}
+		stack[len(signers)-1-i] = item
+	}
+	return output.ScriptPathWitness(leaf, stack...)
+}
+
+// xOnlyKeyLocked returns the wallet's private key for an x-only public
+// key, or nil. The caller must hold w.mu.
+func (w *Wallet) xOnlyKeyLocked(xOnly []byte) *keys.PrivateKey {
+	for _, key := range w.keys {
+		if key != nil && bytes.Equal(key.PublicKey().XOnly(), xOnly) {
+			return key
+		}
+	}
+	return nil
+}
@@ -2,27 +2,96 @@ package wallet
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
 )
 
+// Fee bump defaults
+const (
+	// DefaultBumpFeeRate is the fee rate (sat/kvB) BumpFee adds when no
+	// fee is given, enough for the mempool's replacement rules at the
+	// default incremental relay fee
+	DefaultBumpFeeRate = 1000
+
+	// BumpDustThreshold is the smallest change BumpFee leaves
+	BumpDustThreshold = 546
+)
+
 // Send creates a signed transaction sending amount to toAddress
 func (w *Wallet) Send(toAddress string, amount int64) (*types.Transaction, error) {
+	return w.SendWithFee(toAddress, amount, 0)
+}
+
+// SendWithFee is like Send but leaves fee satoshis out of the change output
+func (w *Wallet) SendWithFee(toAddress string, amount int64, fee int64) (*types.Transaction, error) {
+	return w.send(toAddress, amount, fee, nil)
+}
+
+// SendWithData is like SendWithFee but also adds a zero-value OP_RETURN
+// output carrying data, e.g. a document hash to timestamp
+func (w *Wallet) SendWithData(toAddress string, amount int64, fee int64, data []byte) (*types.Transaction, error) {
+	nullData, err := script.NullData(data)
+	if err != nil {
+		return nil, err
+	}
+	return w.send(toAddress, amount, fee, nullData)
+}
+
+// SendToScript is like SendWithFee but pays an arbitrary locking script,
+// such as a contract, instead of an address
+func (w *Wallet) SendToScript(payeeScript []byte, amount int64, fee int64) (*types.Transaction, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if len(payeeScript) == 0 {
+		return nil, fmt.Errorf("empty payee script")
+	}
+	return w.build(payeeScript, amount, fee, nil)
+}
+
+// send builds and signs a payment, with an OP_RETURN output after the
+// payee's if nullData is set
+func (w *Wallet) send(toAddress string, amount int64, fee int64, nullData []byte) (*types.Transaction, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Check the payee before touching any coins
+	payee, err := keys.DecodeAddressForNetwork(toAddress, w.params)
+	if err != nil {
+		return nil, err
+	}
+	payeeScript, err := payee.Script()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s script: %w", payee.Type, err)
+	}
+
+	return w.build(payeeScript, amount, fee, nullData)
+}
+
+// build selects coins for a payment to payeeScript and signs it. The
+// caller must hold w.mu.
+func (w *Wallet) build(payeeScript []byte, amount int64, fee int64, nullData []byte) (*types.Transaction, error) {
+	if fee < 0 {
+		return nil, fmt.Errorf("negative fee: %d", fee)
+	}
+	if w.lockedLocked() {
+		return nil, ErrWalletLocked
+	}
+
 	// 1. Select UTXOs
-	selectedUTXOs, totalValue, err := w.selectUTXOs(amount)
+	selectedUTXOs, totalValue, err := w.selectUTXOs(amount + fee)
 	if err != nil {
 		return nil, err
 	}
 
 	// 2. Create Transaction Builder
-	builder := transaction.NewTxBuilder()
+	builder := transaction.NewTxBuilder().SetRBF(w.optInRBF)
 
 	// Add Inputs
 	for _, u := range selectedUTXOs {
@@ -30,18 +99,17 @@ func (w *Wallet) Send(toAddress string, amount int64) (*types.Transaction, error
 	}
 
 	// Add Recipient Output
-	if _, err := builder.AddP2PKHOutput(amount, toAddress); err != nil {
-		return nil, err
+	builder.AddOutput(amount, payeeScript)
+	if nullData != nil {
+		builder.AddOutput(0, nullData)
 	}
 
 	// Add Change Output
-	change := totalValue - amount
+	change := totalValue - amount - fee
 	if change > 0 {
-		// Get a change address (use first available key for now)
-		var changeAddr string
-		for k := range w.keys {
-			changeAddr = k
-			break
+		changeAddr, err := w.changeAddressLocked()
+		if err != nil {
+			return nil, err
 		}
 		if _, err := builder.AddP2PKHOutput(change, changeAddr); err != nil {
 			return nil, err
@@ -55,40 +123,206 @@ func (w *Wallet) Send(toAddress string, amount int64) (*types.Transaction, error
 	}
 
 	// 3. Sign Inputs
+	prevOuts := make([]types.TxOutput, len(tx.Inputs))
 	for i, input := range tx.Inputs {
-		u := w.utxos[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)]
-
-		// Get private key
-		hash, _ := script.ExtractP2PKHAddress(u.Output.PubKeyScript)
-
-		// Try mainnet
-		addr, _ := keys.NewAddress(keys.AddressTypeP2PKH, hash)
-		privKey, ok := w.keys[addr.String()]
-		if !ok {
-			// Try testnet
-			addrTest, _ := keys.NewAddress(keys.AddressTypeTestnetP2PKH, hash)
-			privKey, ok = w.keys[addrTest.String()]
+		prevOuts[i] = w.utxos[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)].Output
+	}
+	if err := w.signLocked(tx, prevOuts); err != nil {
+		return nil, err
+	}
+
+	txHash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		return nil, err
+	}
+	w.recordCreated(txHash, &createdTx{tx: tx, prevOuts: prevOuts, fee: fee}, amount)
+
+	return tx, nil
+}
+
+// signLocked signs every input of tx, which spend prevOuts. The caller
+// must hold w.mu.
+func (w *Wallet) signLocked(tx *types.Transaction, prevOuts []types.TxOutput) error {
+	for i := range tx.Inputs {
+		privKey := w.keyForScript(prevOuts[i].PubKeyScript)
+		if privKey == nil {
+			return fmt.Errorf("key not found for input %d", i)
 		}
+		err := transaction.SignInput(tx, i, privKey, prevOuts[i].PubKeyScript, transaction.SigHashAll)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyForScript returns the private key a P2PKH script pays, or nil. The
+// caller must hold w.mu.
+func (w *Wallet) keyForScript(pubKeyScript []byte) *keys.PrivateKey {
+	hash, err := script.ExtractP2PKHAddress(pubKeyScript)
+	if err != nil {
+		return nil
+	}
+
+	// Try mainnet
+	addr, _ := keys.NewAddress(keys.AddressTypeP2PKH, hash)
+	if privKey := w.keys[addr.String()]; privKey != nil {
+		return privKey
+	}
+	// Try testnet
+	addrTest, _ := keys.NewAddress(keys.AddressTypeTestnetP2PKH, hash)
+	return w.keys[addrTest.String()]
+}
+
+// BumpFee replaces an unconfirmed transaction the wallet created with a
+// copy paying newFee, taking the difference out of its change output.
+// The original must signal BIP125 replaceability. A newFee of 0 adds
+// DefaultBumpFeeRate for the transaction's size to the old fee.
+func (w *Wallet) BumpFee(txHash types.Hash, newFee int64) (*types.Transaction, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-		if !ok {
-			return nil, fmt.Errorf("key not found for input %d", i)
+	if w.lockedLocked() {
+		return nil, ErrWalletLocked
+	}
+	created, ok := w.created[txHash]
+	if !ok {
+		if rec, known := w.history[txHash]; known && !rec.ReplacedBy.IsZero() {
+			return nil, fmt.Errorf("transaction %s was already replaced by %s", txHash, rec.ReplacedBy)
+		}
+		if rec, known := w.history[txHash]; known && (rec.Conflicted || rec.Abandoned) {
+			return nil, fmt.Errorf("transaction %s is conflicted or abandoned", txHash)
 		}
+		return nil, fmt.Errorf("transaction %s is not an unconfirmed wallet transaction", txHash)
+	}
+	if !transaction.SignalsRBF(created.tx) {
+		return nil, fmt.Errorf("transaction %s does not signal replaceability", txHash)
+	}
 
-		// Sign
-		err = transaction.SignInput(tx, i, privKey, u.Output.PubKeyScript, transaction.SigHashAll)
+	if newFee == 0 {
+		raw, err := serialization.SerializeTransaction(created.tx)
 		if err != nil {
 			return nil, err
 		}
+		newFee = created.fee + transaction.FeeAtRate(DefaultBumpFeeRate, int64(len(raw)))
+	}
+	if newFee <= created.fee {
+		return nil, fmt.Errorf("new fee %d must be above the current fee %d", newFee, created.fee)
 	}
 
+	// The change is the last output paying us
+	change := -1
+	for i, output := range created.tx.Outputs {
+		if w.owns(output.PubKeyScript) {
+			change = i
+		}
+	}
+	if change < 0 {
+		return nil, fmt.Errorf("transaction %s has no change output to take the fee from", txHash)
+	}
+	bump := newFee - created.fee
+	if created.tx.Outputs[change].Value-bump < BumpDustThreshold {
+		return nil, fmt.Errorf("change of %d cannot pay %d more fee", created.tx.Outputs[change].Value, bump)
+	}
+
+	tx := &types.Transaction{
+		Version:  created.tx.Version,
+		Inputs:   make([]types.TxInput, len(created.tx.Inputs)),
+		Outputs:  make([]types.TxOutput, len(created.tx.Outputs)),
+		LockTime: created.tx.LockTime,
+	}
+	for i, input := range created.tx.Inputs {
+		tx.Inputs[i] = types.TxInput{PrevTxHash: input.PrevTxHash, OutputIndex: input.OutputIndex, Sequence: input.Sequence}
+	}
+	copy(tx.Outputs, created.tx.Outputs)
+	tx.Outputs[change].Value -= bump
+
+	if err := w.signLocked(tx, created.prevOuts); err != nil {
+		return nil, err
+	}
+	newHash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	old := w.history[txHash]
+	w.created[newHash] = &createdTx{tx: tx, prevOuts: created.prevOuts, fee: newFee}
+	w.history[newHash] = &TxRecord{
+		TxHash:   newHash,
+		Category: CategorySend,
+		Amount:   old.Amount,
+		Fee:      newFee,
+		Time:     time.Now(),
+	}
+	old.ReplacedBy = newHash
+	delete(w.created, txHash)
+	w.dirty = true
+
 	return tx, nil
 }
 
+// TransactionDropped handles a transaction leaving the mempool without
+// being mined, e.g. evicted or expired. If the wallet created it, it is
+// marked conflicted and its inputs may be spent again.
+func (w *Wallet) TransactionDropped(txHash types.Hash) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.created[txHash]; ok {
+		w.release(txHash, types.Hash{})
+	}
+}
+
+// AbandonTransaction gives up on an unconfirmed wallet transaction that
+// is stuck outside the mempool, so its inputs may be spent again. The
+// caller must make sure the transaction isn't in the mempool, where it
+// could still be mined. Should it be mined anyway, it counts as
+// confirmed as usual.
+func (w *Wallet) AbandonTransaction(txHash types.Hash) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec, ok := w.history[txHash]
+	if !ok {
+		return fmt.Errorf("transaction %s is not a wallet transaction", txHash)
+	}
+	if rec.Confirmed() {
+		return fmt.Errorf("transaction %s is confirmed and cannot be abandoned", txHash)
+	}
+
+	delete(w.created, txHash)
+	rec.Abandoned = true
+	w.dirty = true
+	return nil
+}
+
+// lockedInputs returns the outputs spent by unconfirmed transactions the
+// wallet created. The caller must hold w.mu.
+func (w *Wallet) lockedInputs() map[utxo.OutPoint]bool {
+	locked := make(map[utxo.OutPoint]bool)
+	for _, created := range w.created {
+		for _, input := range created.tx.Inputs {
+			locked[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)] = true
+		}
+	}
+	return locked
+}
+
+// selectUTXOs picks spendable outputs worth at least amount. The caller
+// must hold w.mu.
 func (w *Wallet) selectUTXOs(amount int64) ([]*utxo.UTXO, int64, error) {
+	if w.avoidReuse {
+		return w.selectGroups(amount)
+	}
+
 	var selected []*utxo.UTXO
 	var total int64
 
-	for _, u := range w.utxos {
+	locked := w.lockedInputs()
+	for outpoint, u := range w.utxos {
+		if w.statusOf(outpoint) == StatusConflicted || locked[outpoint] {
+			continue
+		}
 		selected = append(selected, u)
 		total += u.Value()
 		if total >= amount {
@@ -2,9 +2,11 @@ package wallet
 
 import (
 	"sync"
+	"time"
 
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
 	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
 )
 
@@ -13,41 +15,149 @@ type Wallet struct {
 	mu    sync.RWMutex
 	keys  map[string]*keys.PrivateKey // address -> private key
 	utxos map[utxo.OutPoint]*utxo.UTXO
+
+	status      map[utxo.OutPoint]OutputStatus    // Outputs not confirmed on the best chain
+	unconfirmed map[types.Hash]*types.Transaction // Disconnected transactions paying us
+	spent       map[utxo.OutPoint]spentCoin       // Coins spent by recent blocks
+
+	salt      []byte            // Key derivation salt, nil = keys not encrypted
+	check     []byte            // Sealed checkLabel, to verify passphrases
+	encrypted map[string][]byte // address -> sealed private key
+	unlockKey []byte            // Derived key while unlocked
+	relock    *time.Timer       // Locks the wallet when the unlock times out
+
+	hd *hdState // Seed keys are derived from, nil = keys are random
+
+	history map[types.Hash]*TxRecord  // Transactions touching the wallet
+	created map[types.Hash]*createdTx // Unconfirmed transactions built this session; their inputs are not reused
+
+	optInRBF   bool            // Created transactions signal BIP125 replaceability
+	avoidReuse bool            // Change goes to fresh addresses and an address's outputs are spent together
+	used       map[string]bool // Addresses that have received
+	params     *keys.NetParams // Network new addresses are for and payees must be on
+
+	path  string // Wallet file, "" = not persisted
+	dirty bool   // Changes not yet written to path
 }
 
 // NewWallet creates a new empty wallet
 func NewWallet() *Wallet {
 	return &Wallet{
-		keys:  make(map[string]*keys.PrivateKey),
-		utxos: make(map[utxo.OutPoint]*utxo.UTXO),
+		keys:        make(map[string]*keys.PrivateKey),
+		utxos:       make(map[utxo.OutPoint]*utxo.UTXO),
+		status:      make(map[utxo.OutPoint]OutputStatus),
+		unconfirmed: make(map[types.Hash]*types.Transaction),
+		spent:       make(map[utxo.OutPoint]spentCoin),
+		history:     make(map[types.Hash]*TxRecord),
+		created:     make(map[types.Hash]*createdTx),
+		used:        make(map[string]bool),
+		params:      keys.MainNetParams,
 	}
 }
 
-// GenerateAddress creates a new private key and returns its address
+// SetNetParams sets the network the wallet creates addresses for and
+// accepts payment addresses from
+func (w *Wallet) SetNetParams(params *keys.NetParams) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.params = params
+}
+
+// NetParams returns the network the wallet's addresses are for
+func (w *Wallet) NetParams() *keys.NetParams {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.params
+}
+
+// SetOptInRBF sets whether transactions the wallet creates signal that
+// they may be replaced by a higher-fee version (BIP125)
+func (w *Wallet) SetOptInRBF(enabled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.optInRBF = enabled
+}
+
+// OptInRBF reports whether created transactions signal replaceability
+func (w *Wallet) OptInRBF() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.optInRBF
+}
+
+// GenerateAddress creates a new private key and returns its address. An
+// HD wallet derives it from its seed.
 func (w *Wallet) GenerateAddress() (string, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	privKey, err := keys.GeneratePrivateKey()
+	// An encrypted wallet can only store keys it can encrypt
+	if w.lockedLocked() {
+		return "", ErrWalletLocked
+	}
+
+	// An HD wallet derives the next external key so its seed covers it
+	var privKey *keys.PrivateKey
+	var err error
+	var next [2]uint32
+	if w.hd != nil {
+		next = w.hd.next
+		privKey, err = w.nextHDKeyLocked(ExternalChain)
+	} else {
+		privKey, err = keys.GeneratePrivateKey()
+	}
 	if err != nil {
 		return "", err
 	}
 
 	pubKey := privKey.PublicKey()
-	address := pubKey.P2PKHAddress()
+	address := pubKey.P2PKHAddressForNetwork(w.params)
 
-	w.keys[address] = privKey
+	if err := w.addKeyLocked(address, privKey); err != nil {
+		return "", err
+	}
+
+	// A key that isn't on disk could lose the coins sent to it
+	w.dirty = true
+	if err := w.flushLocked(); err != nil {
+		w.removeKeyLocked(address)
+		if w.hd != nil {
+			w.hd.next = next
+		}
+		return "", err
+	}
 	return address, nil
 }
 
-// GetBalance calculates the total balance of the wallet
+// GetBalance calculates the confirmed balance of the wallet
 func (w *Wallet) GetBalance() int64 {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
 
 	var balance int64
-	for _, u := range w.utxos {
-		balance += u.Value()
+	for outpoint, u := range w.utxos {
+		if w.statusOf(outpoint) == StatusConfirmed {
+			balance += u.Value()
+		}
+	}
+	return balance
+}
+
+// GetUnconfirmedBalance sums outputs whose blocks were disconnected and
+// that are waiting to be mined again
+func (w *Wallet) GetUnconfirmedBalance() int64 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var balance int64
+	for outpoint, u := range w.utxos {
+		if w.statusOf(outpoint) == StatusUnconfirmed {
+			balance += u.Value()
+		}
 	}
 	return balance
 }
@@ -57,38 +167,56 @@ func (w *Wallet) AddUTXO(u *utxo.UTXO) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.owns(u.Output.PubKeyScript) {
+		w.utxos[u.OutPoint()] = u.Clone()
+		w.markUsedLocked(u.Output.PubKeyScript)
+		w.dirty = true
+	}
+}
+
+// owns reports whether pubKeyScript pays one of our addresses. The
+// caller must hold w.mu.
+func (w *Wallet) owns(pubKeyScript []byte) bool {
 	// Check if script is P2PKH
-	if !script.IsP2PKH(u.Output.PubKeyScript) {
-		return
+	if !script.IsP2PKH(pubKeyScript) {
+		return false
 	}
 
 	// Extract hash
-	hash, err := script.ExtractP2PKHAddress(u.Output.PubKeyScript)
+	hash, err := script.ExtractP2PKHAddress(pubKeyScript)
 	if err != nil {
-		return
+		return false
 	}
 
 	// Check against Mainnet P2PKH
 	addr, _ := keys.NewAddress(keys.AddressTypeP2PKH, hash)
 	if _, ok := w.keys[addr.String()]; ok {
-		w.utxos[u.OutPoint()] = u.Clone()
-		return
+		return true
 	}
 
 	// Check against Testnet P2PKH
 	addrTest, _ := keys.NewAddress(keys.AddressTypeTestnetP2PKH, hash)
-	if _, ok := w.keys[addrTest.String()]; ok {
-		w.utxos[u.OutPoint()] = u.Clone()
-		return
-	}
+	_, ok := w.keys[addrTest.String()]
+	return ok
+}
+
+// RemoveUTXO removes a spent UTXO from the wallet
+func (w *Wallet) RemoveUTXO(outpoint utxo.OutPoint) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.utxos, outpoint)
+	delete(w.status, outpoint)
+	w.dirty = true
 }
 
-// GetAddress returns the private key for a given address
+// GetKey returns the private key for a given address. It is not found
+// while the wallet is locked.
 func (w *Wallet) GetKey(address string) (*keys.PrivateKey, bool) {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
 	key, ok := w.keys[address]
-	return key, ok
+	return key, ok && key != nil
 }
 
 // ListAddresses returns all addresses in the wallet
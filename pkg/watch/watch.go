@@ -0,0 +1,402 @@
+// Package watch follows the chain and mempool for activity on watched
+// scripts and outputs: new outputs paying a watched script, and spends of
+// outputs it knows about. Listeners are called for every event, and the
+// most recent events are kept for callers that poll or long-poll.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+const (
+	// DefaultPollInterval is how often the chain and mempool are checked
+	DefaultPollInterval = time.Second
+
+	// MaxEvents is how many recent events are kept for Events and Wait
+	MaxEvents = 1000
+)
+
+// EventType says what happened to a watched script or output
+type EventType string
+
+const (
+	EventReceived EventType = "received" // An output pays a watched script
+	EventSpent    EventType = "spent"    // A watched output is spent
+)
+
+// Event is one payment to or spend from something watched. A transaction
+// seen in the mempool gives an unconfirmed event and later, once mined, a
+// confirmed one.
+type Event struct {
+	Sequence  uint64 // Increases by one per event
+	Type      EventType
+	TxHash    types.Hash     // The paying or spending transaction
+	Index     uint32         // Output index paid, or input index spending
+	Outpoint  types.OutPoint // The output created or spent
+	Script    []byte         // Script of that output, nil if unknown
+	Value     int64          // Value of that output, 0 if unknown
+	Confirmed bool
+	Height    uint64 // Block height, when confirmed
+}
+
+// Listener is called with each event, in order and without the watcher
+// locked
+type Listener func(Event)
+
+// watchedOutput is an output the watcher reports spends of
+type watchedOutput struct {
+	script []byte
+	value  int64
+}
+
+// Watcher reports activity on watched scripts and outputs. Only activity
+// after a script is watched is seen: outputs it paid before are unknown
+// unless passed to WatchOutpoint. Blocks disconnected by a reorg are not
+// reported.
+type Watcher struct {
+	chain   *storage.BlockchainStorage
+	mempool *mempool.Mempool
+
+	scripts     map[string]bool
+	outputs     map[types.OutPoint]watchedOutput
+	seenMempool map[types.Hash]bool // Mempool transactions already scanned
+	nextHeight  uint64              // Next block to scan
+	started     bool                // nextHeight is set
+
+	events    []Event
+	sequence  uint64
+	changed   chan struct{} // Closed and replaced when events arrive
+	listeners []Listener
+
+	pollInterval time.Duration
+	quit         chan struct{}
+	wg           sync.WaitGroup
+	mu           sync.Mutex
+}
+
+// NewWatcher creates a watcher over chain and mp. mp may be nil, in which
+// case only confirmed activity is reported.
+func NewWatcher(chain *storage.BlockchainStorage, mp *mempool.Mempool) *Watcher {
+	return &Watcher{
+		chain:        chain,
+		mempool:      mp,
+		scripts:      make(map[string]bool),
+		outputs:      make(map[types.OutPoint]watchedOutput),
+		seenMempool:  make(map[types.Hash]bool),
+		changed:      make(chan struct{}),
+		pollInterval: DefaultPollInterval,
+		quit:         make(chan struct{}),
+	}
+}
+
+// SetPollInterval changes how often the chain and mempool are checked
+func (w *Watcher) SetPollInterval(interval time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pollInterval = interval
+}
+
+// Subscribe registers l to be called with every event from now on
+func (w *Watcher) Subscribe(l Listener) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.listeners = append(w.listeners, l)
+}
+
+// WatchScript reports outputs paying script from now on, and spends of them
+func (w *Watcher) WatchScript(script []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.scripts[string(script)] = true
+}
+
+// UnwatchScript stops reporting new outputs paying script. Outputs already
+// seen are still reported when spent.
+func (w *Watcher) UnwatchScript(script []byte) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.scripts[string(script)] {
+		return false
+	}
+	delete(w.scripts, string(script))
+	return true
+}
+
+// Scripts returns the watched scripts
+func (w *Watcher) Scripts() [][]byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	scripts := make([][]byte, 0, len(w.scripts))
+	for script := range w.scripts {
+		scripts = append(scripts, []byte(script))
+	}
+	return scripts
+}
+
+// WatchOutpoint reports when an existing output is spent, such as one paid
+// before its script was watched
+func (w *Watcher) WatchOutpoint(outpoint types.OutPoint) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.outputs[outpoint]; !ok {
+		w.outputs[outpoint] = watchedOutput{}
+	}
+}
+
+// Start scans new blocks and mempool transactions every poll interval
+// until Stop. Activity before Start is not reported.
+func (w *Watcher) Start() error {
+	if err := w.Poll(); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	interval := w.pollInterval
+	w.mu.Unlock()
+
+	w.wg.Add(1)
+	go w.pollLoop(interval)
+	return nil
+}
+
+// Stop ends the poll loop
+func (w *Watcher) Stop() {
+	w.mu.Lock()
+	select {
+	case <-w.quit:
+		w.mu.Unlock()
+		return
+	default:
+	}
+	close(w.quit)
+	w.mu.Unlock()
+
+	w.wg.Wait()
+}
+
+// pollLoop scans for activity. The node has no event bus to push blocks
+// and transactions, so the chain and mempool are polled.
+func (w *Watcher) pollLoop(interval time.Duration) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-ticker.C:
+		}
+
+		if err := w.Poll(); err != nil {
+			log.Printf("watch: %v", err)
+		}
+	}
+}
+
+// Poll scans blocks connected since the last poll, then mempool
+// transactions not scanned yet. The first poll only notes the tip.
+func (w *Watcher) Poll() error {
+	empty, err := w.chain.IsEmpty()
+	if err != nil {
+		return err
+	}
+	bestHeight := uint64(0)
+	if !empty {
+		if bestHeight, err = w.chain.GetBestBlockHeight(); err != nil {
+			return err
+		}
+	}
+
+	var events []Event
+	w.mu.Lock()
+	if !w.started {
+		w.started = true
+		w.nextHeight = bestHeight + 1
+		if empty {
+			w.nextHeight = 0
+		}
+	}
+	for ; !empty && w.nextHeight <= bestHeight; w.nextHeight++ {
+		block, err := w.chain.GetBlockByHeight(w.nextHeight)
+		if err != nil {
+			w.mu.Unlock()
+			return fmt.Errorf("failed to get block %d: %w", w.nextHeight, err)
+		}
+		for i := range block.Transactions {
+			events = append(events, w.scanTransaction(&block.Transactions[i], true, w.nextHeight)...)
+		}
+	}
+
+	if w.mempool != nil {
+		// The mempool is unordered, so learn every new output before
+		// looking for spends of them
+		current := make(map[types.Hash]bool)
+		var added []*mempool.MempoolEntry
+		for _, entry := range w.mempool.GetAllTransactions() {
+			current[entry.TxHash] = true
+			if !w.seenMempool[entry.TxHash] {
+				added = append(added, entry)
+			}
+		}
+		for _, entry := range added {
+			events = append(events, w.scanOutputs(entry.Tx, entry.TxHash, false, 0)...)
+		}
+		for _, entry := range added {
+			events = append(events, w.scanInputs(entry.Tx, entry.TxHash, false, 0)...)
+		}
+		w.seenMempool = current
+	}
+
+	listeners := w.publish(events)
+	w.mu.Unlock()
+
+	for _, event := range events {
+		for _, l := range listeners {
+			l(event)
+		}
+	}
+	return nil
+}
+
+// scanTransaction returns the events a block transaction causes. The
+// caller holds w.mu.
+func (w *Watcher) scanTransaction(tx *types.Transaction, confirmed bool, height uint64) []Event {
+	txHash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		return nil
+	}
+	events := w.scanInputs(tx, txHash, confirmed, height)
+	return append(events, w.scanOutputs(tx, txHash, confirmed, height)...)
+}
+
+// scanInputs returns spends of watched outputs by tx, forgetting those a
+// block spends. The caller holds w.mu.
+func (w *Watcher) scanInputs(tx *types.Transaction, txHash types.Hash, confirmed bool, height uint64) []Event {
+	if transaction.IsCoinbase(tx) {
+		return nil
+	}
+
+	var events []Event
+	for i, input := range tx.Inputs {
+		outpoint := types.OutPoint{Hash: input.PrevTxHash, Index: input.OutputIndex}
+		output, ok := w.outputs[outpoint]
+		if !ok {
+			continue
+		}
+		events = append(events, Event{
+			Type:      EventSpent,
+			TxHash:    txHash,
+			Index:     uint32(i),
+			Outpoint:  outpoint,
+			Script:    output.script,
+			Value:     output.value,
+			Confirmed: confirmed,
+			Height:    height,
+		})
+		if confirmed {
+			delete(w.outputs, outpoint)
+		}
+	}
+	return events
+}
+
+// scanOutputs returns outputs of tx paying watched scripts, learning them
+// so their spends are reported. The caller holds w.mu.
+func (w *Watcher) scanOutputs(tx *types.Transaction, txHash types.Hash, confirmed bool, height uint64) []Event {
+	var events []Event
+	for i, output := range tx.Outputs {
+		if !w.scripts[string(output.PubKeyScript)] {
+			continue
+		}
+		outpoint := types.OutPoint{Hash: txHash, Index: uint32(i)}
+		w.outputs[outpoint] = watchedOutput{script: output.PubKeyScript, value: output.Value}
+		events = append(events, Event{
+			Type:      EventReceived,
+			TxHash:    txHash,
+			Index:     uint32(i),
+			Outpoint:  outpoint,
+			Script:    output.PubKeyScript,
+			Value:     output.Value,
+			Confirmed: confirmed,
+			Height:    height,
+		})
+	}
+	return events
+}
+
+// publish numbers and keeps events, wakes waiters and returns the
+// listeners to call. The caller holds w.mu.
+func (w *Watcher) publish(events []Event) []Listener {
+	if len(events) == 0 {
+		return nil
+	}
+	for i := range events {
+		w.sequence++
+		events[i].Sequence = w.sequence
+	}
+	w.events = append(w.events, events...)
+	if len(w.events) > MaxEvents {
+		w.events = append([]Event(nil), w.events[len(w.events)-MaxEvents:]...)
+	}
+
+	close(w.changed)
+	w.changed = make(chan struct{})
+	return append([]Listener(nil), w.listeners...)
+}
+
+// Events returns the kept events with a sequence number above since, and
+// the latest sequence number to pass next time
+func (w *Watcher) Events(since uint64) ([]Event, uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.eventsSince(since), w.sequence
+}
+
+// eventsSince returns kept events after since. The caller holds w.mu.
+func (w *Watcher) eventsSince(since uint64) []Event {
+	var events []Event
+	for _, event := range w.events {
+		if event.Sequence > since {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Wait is Events, but while there are no events after since it blocks
+// until there are or ctx is done. A done ctx is not an error: the result
+// is then empty.
+func (w *Watcher) Wait(ctx context.Context, since uint64) ([]Event, uint64) {
+	for {
+		w.mu.Lock()
+		events, latest, changed := w.eventsSince(since), w.sequence, w.changed
+		w.mu.Unlock()
+		if len(events) > 0 {
+			return events, latest
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, latest
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/encoding"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestSegWitAddressVectors(t *testing.T) {
+	// From BIP173 and BIP350
+	tests := []struct {
+		address string
+		params  *keys.NetParams
+		typ     keys.AddressType
+		script  string
+	}{
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", keys.MainNetParams, keys.AddressP2WPKH,
+			"0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", keys.TestNetParams, keys.AddressP2WSH,
+			"00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", keys.MainNetParams, keys.AddressP2TR,
+			"512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	}
+	for _, tt := range tests {
+		addr, err := keys.DecodeAddressForNetwork(tt.address, tt.params)
+		if err != nil {
+			t.Errorf("%s: %v", tt.address, err)
+			continue
+		}
+		if addr.Type != tt.typ {
+			t.Errorf("%s: type %s, want %s", tt.address, addr.Type, tt.typ)
+		}
+		pkScript, err := addr.Script()
+		if err != nil || hex.EncodeToString(pkScript) != tt.script {
+			t.Errorf("%s: script %x (%v), want %s", tt.address, pkScript, err, tt.script)
+		}
+		if addr.String() != strings.ToLower(tt.address) {
+			t.Errorf("%s: re-encoded as %s", tt.address, addr.String())
+		}
+	}
+
+	invalid := []string{
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh",                     // Version 0 with a bech32m checksum
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", // Version 1 with a bech32 checksum
+		"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T5",                     // Bad checksum
+		"bc1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4",                     // Mixed case
+	}
+	for _, address := range invalid {
+		if _, _, _, err := encoding.DecodeSegWitAddress(address); err == nil {
+			t.Errorf("Invalid address %s accepted", address)
+		}
+	}
+}
+
+func TestAddressNetworkChecks(t *testing.T) {
+	key, err := keys.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := key.PublicKey()
+	testnet := pub.P2PKHAddressForNetwork(keys.TestNetParams)
+
+	if _, err := keys.DecodeAddressForNetwork(pub.P2PKHAddress(), keys.RegtestParams); !errors.Is(err, keys.ErrWrongNetwork) {
+		t.Errorf("Mainnet address on regtest: got %v, want ErrWrongNetwork", err)
+	}
+	if _, err := keys.DecodeAddressForNetwork(testnet, keys.MainNetParams); !errors.Is(err, keys.ErrWrongNetwork) {
+		t.Errorf("Testnet address on mainnet: got %v, want ErrWrongNetwork", err)
+	}
+	// Testnet and regtest share Base58 prefixes but not bech32 ones
+	if _, err := keys.DecodeAddressForNetwork(testnet, keys.RegtestParams); err != nil {
+		t.Errorf("Testnet Base58 address on regtest: %v", err)
+	}
+	segwit, _ := encoding.EncodeSegWitAddress("tb", 0, pub.Hash160())
+	if _, err := keys.DecodeAddressForNetwork(segwit, keys.RegtestParams); !errors.Is(err, keys.ErrWrongNetwork) {
+		t.Errorf("Testnet segwit address on regtest: got %v, want ErrWrongNetwork", err)
+	}
+}
+
+func TestWalletPaysEveryAddressType(t *testing.T) {
+	w := wallet.NewWallet()
+	w.SetNetParams(keys.RegtestParams)
+	own, err := w.GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(own, "m") && !strings.HasPrefix(own, "n") {
+		t.Fatalf("Regtest wallet generated %s", own)
+	}
+	decoded, _ := keys.DecodeAddress(own)
+	pkScript, _ := script.P2PKH(decoded.Hash())
+	// One coin per payment: the inputs of an unconfirmed send stay locked
+	for i := uint32(0); i < 3; i++ {
+		w.AddUTXO(utxo.NewUTXO(types.Hash{7}, i, types.TxOutput{Value: 1000000, PubKeyScript: pkScript}, 1, false))
+	}
+
+	hash := bytes.Repeat([]byte{0xab}, 20)
+	program := bytes.Repeat([]byte{0xcd}, 32)
+	p2sh := &keys.NetworkAddress{Type: keys.AddressP2SH, Program: hash, Params: keys.RegtestParams}
+	p2wpkh := &keys.NetworkAddress{Type: keys.AddressP2WPKH, Program: hash, Params: keys.RegtestParams}
+	p2tr := &keys.NetworkAddress{Type: keys.AddressP2TR, WitnessVersion: 1, Program: program, Params: keys.RegtestParams}
+
+	for _, payee := range []*keys.NetworkAddress{p2sh, p2wpkh, p2tr} {
+		tx, err := w.Send(payee.String(), 1000)
+		if err != nil {
+			t.Errorf("%s: %v", payee.Type, err)
+			continue
+		}
+		want, _ := payee.Script()
+		if !bytes.Equal(tx.Outputs[0].PubKeyScript, want) {
+			t.Errorf("%s: paid script %x, want %x", payee.Type, tx.Outputs[0].PubKeyScript, want)
+		}
+	}
+
+	mainnet := &keys.NetworkAddress{Type: keys.AddressP2WPKH, Program: hash, Params: keys.MainNetParams}
+	if _, err := w.Send(mainnet.String(), 1000); !errors.Is(err, keys.ErrWrongNetwork) {
+		t.Errorf("Mainnet payee on regtest wallet: got %v, want ErrWrongNetwork", err)
+	}
+}
+
+func TestRPCRejectsCrossNetworkAddress(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	w := wallet.NewWallet()
+	w.SetNetParams(keys.TestNetParams)
+	server := rpc.NewServer(w, chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	_, err = client.SendToAddress("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", 1000)
+	if err == nil || !strings.Contains(err.Error(), "invalid address") || !strings.Contains(err.Error(), "different network") {
+		t.Errorf("Mainnet address on testnet: got %v", err)
+	}
+
+	// Valid testnet segwit addresses get past validation to the wallet
+	segwit, _ := encoding.EncodeSegWitAddress("tb", 0, bytes.Repeat([]byte{1}, 20))
+	if _, err := client.SendToAddress(segwit, 1000); err == nil || strings.Contains(err.Error(), "invalid address") {
+		t.Errorf("Testnet segwit address: got %v, want an insufficient funds error", err)
+	}
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/addrmgr"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+)
+
+func TestAddrManagerNewAndTried(t *testing.T) {
+	am := addrmgr.New()
+
+	if am.GetAddress() != nil {
+		t.Fatal("Empty manager returned an address")
+	}
+
+	if err := am.AddAddress("seed.example.org:8333", "test"); err == nil {
+		t.Error("Hostname should be rejected, only IPs are stored")
+	}
+	if err := am.AddAddress("10.0.0.1", "test"); err == nil {
+		t.Error("Address without port should be rejected")
+	}
+
+	added := am.AddAddresses([]string{"10.0.0.1:8333", "10.0.0.2:8333", "10.0.0.1:8333"}, "seed.example.org")
+	if added != 3 {
+		t.Errorf("Added %d, want 3 (duplicates are accepted but not stored twice)", added)
+	}
+	if am.NewCount() != 2 || am.TriedCount() != 0 {
+		t.Fatalf("new=%d tried=%d, want 2/0", am.NewCount(), am.TriedCount())
+	}
+
+	am.Attempt("10.0.0.1:8333")
+	am.Good("10.0.0.1:8333")
+	if am.NewCount() != 1 || am.TriedCount() != 1 {
+		t.Fatalf("new=%d tried=%d after Good, want 1/1", am.NewCount(), am.TriedCount())
+	}
+
+	ka, ok := am.Lookup("10.0.0.1:8333")
+	if !ok || !ka.Tried() || ka.Attempts != 0 || ka.LastSuccess.IsZero() {
+		t.Errorf("Unexpected entry after Good: %+v", ka)
+	}
+	if ka.Source != "seed.example.org" {
+		t.Errorf("Source = %q", ka.Source)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		seen[am.GetAddress().Addr] = true
+	}
+	if !seen["10.0.0.1:8333"] || !seen["10.0.0.2:8333"] {
+		t.Errorf("GetAddress should draw from both tables, saw %v", seen)
+	}
+}
+
+func TestAddrManagerLimitsSingleSource(t *testing.T) {
+	am := addrmgr.New()
+
+	// One source flooding a single /16 lands in one new bucket
+	for i := 0; i < 1000; i++ {
+		am.AddAddress(fmt.Sprintf("10.1.%d.%d:8333", i/256, i%256), "10.9.9.9:8333")
+	}
+
+	if am.NewCount() > addrmgr.BucketSize {
+		t.Errorf("Single source/group filled %d entries, limit is %d", am.NewCount(), addrmgr.BucketSize)
+	}
+}
+
+func TestDNSSeederFillsNewTable(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	node := network.NewNode(network.NodeConfig{
+		ListenAddr: "127.0.0.1:0",
+		DNSSeeds:   []string{"seed.a.example", "seed.b.example"},
+	}, chain)
+
+	if node.DNSSeeder == nil {
+		t.Fatal("Seeder not created for configured seeds")
+	}
+	if node.DNSSeeder.Port != network.DefaultDNSSeedPort {
+		t.Errorf("Port = %d, want default", node.DNSSeeder.Port)
+	}
+
+	node.DNSSeeder.LookupHost = func(host string) ([]string, error) {
+		if host == "seed.b.example" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"192.0.2.1", "198.51.100.7", "2001:db8::1"}, nil
+	}
+
+	added, err := node.DNSSeeder.Seed(node.AddrManager)
+	if err != nil {
+		t.Fatalf("Seeding failed with one working seed: %v", err)
+	}
+	if added != 3 {
+		t.Errorf("Added %d addresses, want 3", added)
+	}
+
+	// Seeded addresses are candidates only: nothing tried, nothing connected
+	if node.AddrManager.NewCount() != 3 || node.AddrManager.TriedCount() != 0 {
+		t.Errorf("new=%d tried=%d, want 3/0", node.AddrManager.NewCount(), node.AddrManager.TriedCount())
+	}
+	if node.PeerCount() != 0 {
+		t.Errorf("Seeding connected to %d peers", node.PeerCount())
+	}
+	if _, ok := node.AddrManager.Lookup("[2001:db8::1]:8333"); !ok {
+		t.Error("IPv6 seed result missing")
+	}
+
+	node.DNSSeeder.LookupHost = func(string) ([]string, error) {
+		return nil, errors.New("timeout")
+	}
+	if _, err := node.DNSSeeder.Seed(node.AddrManager); err == nil {
+		t.Error("Expected an error when every seed fails")
+	}
+}
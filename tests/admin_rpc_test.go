@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestGetRPCInfoListsActiveCalls(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	if uptime, err := client.Uptime(); err != nil || uptime < 0 {
+		t.Errorf("Uptime = %d, %v", uptime, err)
+	}
+
+	// A request whose body hasn't arrived yet stays active
+	body, stall := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Post(ts.URL+"/verifytxoutproof", "application/json", body)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	stall.Write([]byte(`{"proof":`))
+
+	waitUntil(t, "stalled call to be listed", func() bool {
+		commands, err := client.GetRPCInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		methods := make(map[string]bool)
+		for _, command := range commands {
+			methods[command.Method] = true
+		}
+		return methods["verifytxoutproof"] && methods["getrpcinfo"]
+	})
+
+	stall.Write([]byte(`"00"}`))
+	stall.Close()
+	<-done
+
+	commands, err := client.GetRPCInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 1 || commands[0].Method != "getrpcinfo" {
+		t.Errorf("Active commands after the call returned: %+v", commands)
+	}
+}
+
+func TestStopShutsDownServer(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.NewClient("http://" + listener.Addr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, listener, http.NewServeMux()) }()
+
+	waitUntil(t, "server to answer", func() bool {
+		_, err := client.Uptime()
+		return err == nil
+	})
+	if err := client.Stop(); err == nil {
+		t.Error("Stop succeeded without a shutdown function")
+	}
+
+	server.SetShutdown(cancel)
+	if err := client.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	returnsWithin(t, "Serve", shutdownBound, func() {
+		if err := <-served; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+}
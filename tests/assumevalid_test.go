@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+func TestAssumeValidSkipsScriptsOfAncestors(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	set := utxo.NewUTXOSet()
+	funding := types.Hash{5}
+	if err := set.Add(utxo.NewUTXO(funding, 0, types.TxOutput{Value: 100000000, PubKeyScript: []byte{0x00}}, 0, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Block 1 spends an output whose script always fails
+	genesis := buildBranch(t, types.Hash{}, 0, 1, 0)[0]
+	bad := buildBranch(t, blockHash(t, genesis), 1, 1, 0)[0]
+	bad.Transactions = append(bad.Transactions, types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: funding, OutputIndex: 0, Sequence: 0xFFFFFFFF}},
+		Outputs: []types.TxOutput{{Value: 90000000, PubKeyScript: []byte{0x51}}},
+	})
+	bad = rebuildBlock(t, bad, 1)
+	tip := buildBranch(t, blockHash(t, bad), 2, 1, 0)[0]
+	side := buildBranch(t, blockHash(t, genesis), 1, 2, 1)
+
+	for i, block := range []*types.Block{genesis, bad, tip} {
+		if err := chain.SaveBlock(block, uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, block := range side {
+		if err := chain.SaveSideBlock(block, uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	validate := func(assumeValid types.Hash, view utxo.View) error {
+		rules := consensus.NewMainnetRules()
+		rules.AssumeValid = assumeValid
+		validator := validation.NewBlockValidator(view)
+		validator.SetRules(rules)
+		validator.SetBlockchain(chain)
+		return validator.ValidateBlock(bad, 1, blockHash(t, genesis))
+	}
+
+	if err := validate(types.Hash{}, set); err == nil || !strings.Contains(err.Error(), "script validation failed") {
+		t.Fatalf("Without assumevalid = %v, want a script failure", err)
+	}
+	if err := validate(blockHash(t, tip), set); err != nil {
+		t.Errorf("Ancestor of the assumevalid block rejected: %v", err)
+	}
+	if err := validate(blockHash(t, bad), set); err != nil {
+		t.Errorf("The assumevalid block itself rejected: %v", err)
+	}
+
+	// Blocks that aren't ancestors are checked in full
+	for name, hash := range map[string]types.Hash{
+		"below":   blockHash(t, genesis),
+		"sibling": blockHash(t, side[1]),
+		"unknown": {0xab},
+	} {
+		if err := validate(hash, set); err == nil {
+			t.Errorf("Assumevalid block %s skipped the script check", name)
+		}
+	}
+
+	// Assumed valid scripts still need their coins
+	err = validate(blockHash(t, tip), utxo.NewUTXOSet())
+	if err == nil || !strings.Contains(err.Error(), "UTXO not found") {
+		t.Errorf("Missing input under assumevalid = %v", err)
+	}
+}
+
+func TestGetAncestorFollowsSideBranches(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	main := buildBranch(t, types.Hash{}, 0, 3, 0)
+	for i, block := range main {
+		if err := chain.SaveBlock(block, uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	side := buildBranch(t, blockHash(t, main[0]), 1, 3, 1)
+	for i, block := range side {
+		if err := chain.SaveSideBlock(block, uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		from   types.Hash
+		height uint64
+		want   types.Hash
+	}{
+		{blockHash(t, side[2]), 3, blockHash(t, side[2])},
+		{blockHash(t, side[2]), 1, blockHash(t, side[0])},
+		{blockHash(t, side[2]), 0, blockHash(t, main[0])},
+		{blockHash(t, main[2]), 1, blockHash(t, main[1])},
+	}
+	for _, tt := range tests {
+		got, err := chain.GetAncestor(tt.from, tt.height)
+		if err != nil || got != tt.want {
+			t.Errorf("GetAncestor(%s, %d) = %s, %v, want %s", tt.from, tt.height, got, err, tt.want)
+		}
+	}
+
+	if _, err := chain.GetAncestor(blockHash(t, main[1]), 2); err == nil {
+		t.Error("GetAncestor above the block succeeded")
+	}
+}
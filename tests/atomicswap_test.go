@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/atomicswap"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// newSwapChain starts a single-node chain with some mined coins
+func newSwapChain(t *testing.T, name string) *atomicswap.Participant {
+	t.Helper()
+
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	if _, err := h.Node(0).MineBlocks(3); err != nil {
+		t.Fatalf("Failed to mine: %v", err)
+	}
+	p, err := atomicswap.NewParticipant(name, h.Node(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAtomicSwap(t *testing.T) {
+	alice := newSwapChain(t, "Alice")
+	bob := newSwapChain(t, "Bob")
+
+	cfg := atomicswap.Config{
+		InitiatorAmount:   5 * 100000000,
+		ParticipantAmount: 3 * 100000000,
+		Fee:               10000,
+		LockBlocks:        20,
+	}
+	result, err := atomicswap.Run(alice, bob, cfg)
+	if err != nil {
+		t.Fatalf("Swap failed: %v", err)
+	}
+
+	// Each claim is confirmed on the other side's chain and pays its key
+	claims := []struct {
+		claimer *atomicswap.Participant
+		chain   *atomicswap.Participant
+		tx      *types.Transaction
+		amount  int64
+	}{
+		{alice, bob, result.InitiatorClaim, cfg.ParticipantAmount - cfg.Fee},
+		{bob, alice, result.ParticipantClaim, cfg.InitiatorAmount - cfg.Fee},
+	}
+	for _, c := range claims {
+		if _, _, err := c.chain.Node.Chain.GetTransactionLocation(txid(t, c.tx)); err != nil {
+			t.Errorf("%s's claim not confirmed: %v", c.claimer.Name, err)
+		}
+		payTo, _ := c.claimer.PayTo(c.amount)
+		if got := c.tx.Outputs[0]; got.Value != payTo.Value || !bytes.Equal(got.PubKeyScript, payTo.PubKeyScript) {
+			t.Errorf("%s's claim pays %+v, want %+v", c.claimer.Name, got, payTo)
+		}
+	}
+
+	// Bob learned the secret from Alice's claim
+	secret, err := atomicswap.ExtractSecret(result.InitiatorClaim, result.ParticipantContract)
+	if err != nil || !bytes.Equal(secret, result.Secret) {
+		t.Errorf("Secret from Alice's claim = %x, %v; want %x", secret, err, result.Secret)
+	}
+}
+
+func TestAtomicSwapRefund(t *testing.T) {
+	alice := newSwapChain(t, "Alice")
+	bob, err := atomicswap.NewParticipant("Bob", alice.Node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, hash, err := atomicswap.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	height, _ := alice.Node.Height()
+	lockTime := uint32(height) + 5
+
+	contract, err := atomicswap.Lock(alice, bob.PubKey(), hash, lockTime, 100000000, 10000)
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if err := atomicswap.Audit(contract, bob.PubKey(), alice.PubKey(), 100000000, lockTime); err == nil {
+		t.Error("Audit accepted an unconfirmed contract")
+	}
+	if _, err := alice.Node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := atomicswap.Audit(contract, bob.PubKey(), alice.PubKey(), 100000000, lockTime+1); err == nil {
+		t.Error("Audit accepted a contract expiring too early")
+	}
+	if err := atomicswap.Audit(contract, alice.PubKey(), bob.PubKey(), 100000000, lockTime); err == nil {
+		t.Error("Audit accepted a contract paying the wrong key")
+	}
+
+	// Only the recipient with the right secret can claim
+	if _, err := atomicswap.Claim(contract, bob, []byte("wrong secret"), 10000); err == nil {
+		t.Error("Claim succeeded with the wrong secret")
+	}
+	if _, err := atomicswap.Claim(contract, alice, secret, 10000); err == nil {
+		t.Error("Sender claimed the contract with the secret")
+	}
+
+	// Bob never claims; Alice waits out the lock and takes her coins back
+	if _, err := atomicswap.Refund(contract, alice, 10000); !errors.Is(err, atomicswap.ErrNotExpired) {
+		t.Fatalf("Early refund: got %v, want ErrNotExpired", err)
+	}
+	if _, err := alice.Node.MineBlocks(int(lockTime) - int(height) - 1); err != nil {
+		t.Fatal(err)
+	}
+	refund, err := atomicswap.Refund(contract, alice, 10000)
+	if err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if _, err := alice.Node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := alice.Node.Chain.GetTransactionLocation(txid(t, refund)); err != nil {
+		t.Errorf("Refund not confirmed: %v", err)
+	}
+
+	// A refund reveals nothing
+	if _, err := atomicswap.FindSecret(contract, height); !errors.Is(err, atomicswap.ErrNotRevealed) {
+		t.Errorf("FindSecret after refund: got %v, want ErrNotRevealed", err)
+	}
+}
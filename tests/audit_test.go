@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+func TestHalvingSchedule(t *testing.T) {
+	eras := validation.HalvingSchedule(consensus.NewMainnetRules())
+
+	// 50 BTC halves 32 times before a block pays less than a satoshi
+	if len(eras) != 33 {
+		t.Fatalf("%d eras, want 33", len(eras))
+	}
+	want := []struct {
+		start   uint64
+		subsidy int64
+	}{
+		{0, 50 * 100000000},
+		{210000, 25 * 100000000},
+		{420000, 1250000000},
+		{630000, 625000000},
+	}
+	for i, w := range want {
+		if eras[i].StartHeight != w.start || eras[i].Subsidy != w.subsidy {
+			t.Errorf("Era %d starts at %d paying %d, want %d paying %d",
+				i, eras[i].StartHeight, eras[i].Subsidy, w.start, w.subsidy)
+		}
+	}
+	if last := eras[len(eras)-1]; last.StartHeight != 32*validation.SubsidyHalvingInterval || last.Subsidy != 1 {
+		t.Errorf("Last era starts at %d paying %d", last.StartHeight, last.Subsidy)
+	}
+
+	// The subsidy changes exactly on the halving height
+	if before, after := validation.GetBlockReward(209999), validation.GetBlockReward(210000); before != 50*100000000 || after != 25*100000000 {
+		t.Errorf("Subsidy around the first halving: %d then %d", before, after)
+	}
+
+	// Rounding down each era leaves the total just under 21 million
+	if supply := eras[len(eras)-1].SupplyAtEnd; supply != 2099999997690000 {
+		t.Errorf("Total supply = %d, want 2099999997690000", supply)
+	}
+	if eras[1].SupplyAtEnd != 210000*(50+25)*100000000 {
+		t.Errorf("Supply after two eras = %d", eras[1].SupplyAtEnd)
+	}
+}
+
+func TestAuditChainSumsFeesAndFlagsOverclaims(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	blocks := buildBranch(t, types.Hash{}, 0, 2, 0)
+
+	// Block 2 spends block 1's coinbase paying a 5000 satoshi fee, and
+	// its coinbase claims exactly the subsidy and that fee
+	funding := blocks[1].Transactions[0]
+	spend := types.Transaction{
+		Version:  1,
+		Inputs:   []types.TxInput{{PrevTxHash: txid(t, &funding), OutputIndex: 0, Sequence: 0xFFFFFFFF}},
+		Outputs:  []types.TxOutput{{Value: funding.Outputs[0].Value - 5000, PubKeyScript: []byte{0x51}}},
+		LockTime: 0,
+	}
+	coinbase, err := mining.CreateCoinbase(2, 5000, "side-chain", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withFee := buildBranch(t, blockHash(t, blocks[1]), 2, 1, 0)[0]
+	withFee.Transactions = []types.Transaction{*coinbase, spend}
+	withFee = rebuildBlock(t, withFee, 2)
+	blocks = append(blocks, withFee)
+
+	// Block 3 claims a satoshi more than its subsidy
+	overclaim := buildBranch(t, blockHash(t, withFee), 3, 1, 0)[0]
+	overclaim.Transactions[0].Outputs[0].Value++
+	overclaim = rebuildBlock(t, overclaim, 3)
+	blocks = append(blocks, overclaim)
+
+	for height, block := range blocks {
+		if err := chain.SaveBlock(block, uint64(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := validation.AuditChain(chain, consensus.NewMainnetRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Blocks) != 4 {
+		t.Fatalf("Audited %d blocks, want 4", len(report.Blocks))
+	}
+	if fees := report.Blocks[2].Fees; fees != 5000 {
+		t.Errorf("Block 2 fees = %d, want 5000", fees)
+	}
+	if report.Blocks[2].Problem != "" {
+		t.Errorf("Block 2 flagged: %s", report.Blocks[2].Problem)
+	}
+	if report.TotalFees != 5000 {
+		t.Errorf("Total fees = %d, want 5000", report.TotalFees)
+	}
+	if report.ExpectedSupply != 4*50*100000000 {
+		t.Errorf("Expected supply = %d", report.ExpectedSupply)
+	}
+	if report.IssuedSupply != report.ExpectedSupply+1 {
+		t.Errorf("Issued supply = %d, want %d", report.IssuedSupply, report.ExpectedSupply+1)
+	}
+	if report.Violations != 1 || report.Blocks[3].Problem == "" {
+		t.Errorf("Violations = %d, block 3 problem %q", report.Violations, report.Blocks[3].Problem)
+	}
+}
+
+func TestAuditFollowsRegtestHalvings(t *testing.T) {
+	rules := consensus.NewRegtestRules()
+
+	eras := validation.HalvingSchedule(rules)
+	if len(eras) != 33 || eras[1].StartHeight != 150 || eras[1].Subsidy != 25*100000000 {
+		t.Fatalf("Regtest schedule: %d eras, second starting at %d paying %d", len(eras), eras[1].StartHeight, eras[1].Subsidy)
+	}
+	if eras[0].SupplyAtEnd != 150*50*100000000 {
+		t.Errorf("Supply after the first era = %d", eras[0].SupplyAtEnd)
+	}
+
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	// Every coinbase claims mainnet's 50 BTC, which overpays from height
+	// 150 on, except block 151 which takes the halved subsidy
+	blocks := buildBranch(t, types.Hash{}, 0, 152, 0)
+	blocks[151].Transactions[0].Outputs[0].Value = 25 * 100000000
+	blocks[151] = rebuildBlock(t, blocks[151], 151)
+	for height, block := range blocks {
+		if err := chain.SaveBlock(block, uint64(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := validation.AuditChain(chain, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Violations != 1 || report.Blocks[150].Problem == "" || report.Blocks[151].Problem != "" {
+		t.Errorf("Violations = %d, block 150 problem %q, block 151 problem %q",
+			report.Violations, report.Blocks[150].Problem, report.Blocks[151].Problem)
+	}
+	if want := int64(150*50+2*25) * 100000000; report.ExpectedSupply != want {
+		t.Errorf("Expected supply = %d, want %d", report.ExpectedSupply, want)
+	}
+	if report.IssuedSupply != report.ExpectedSupply+25*100000000 {
+		t.Errorf("Issued supply = %d, want the overpayment on top of %d", report.IssuedSupply, report.ExpectedSupply)
+	}
+
+	// Under mainnet's schedule every one of those coinbases is fine
+	mainnet, err := validation.AuditChain(chain, consensus.NewMainnetRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mainnet.Violations != 0 {
+		t.Errorf("Mainnet audit flagged %d blocks", mainnet.Violations)
+	}
+}
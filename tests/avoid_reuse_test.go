@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// With avoid_reuse, an address that received twice is emptied in one
+// spend and change goes to an address that never received
+func TestWalletAvoidReuse(t *testing.T) {
+	node, _, _ := walletRPCNode(t)
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), wallet.WalletFileName)
+	w := wallet.NewWallet()
+	w.SetNetParams(node.Wallet.NetParams())
+	w.SetFile(path)
+	if err := w.SetAvoidReuse(true); err != nil {
+		t.Fatal(err)
+	}
+	reused, _ := w.GenerateAddress()
+	single, _ := w.GenerateAddress()
+	fresh, _ := w.GenerateAddress()
+
+	start, err := node.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payment := range []struct {
+		address string
+		amount  int64
+	}{{reused, 30000}, {reused, 30000}, {single, 50000}} {
+		if _, err := node.SendTo(payment.address, payment.amount, 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	height, _ := node.Height()
+	for h := start + 1; h <= height; h++ {
+		block, err := node.Chain.GetBlockByHeight(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.BlockConnected(block, h)
+	}
+	if w.GetBalance() != 110000 {
+		t.Fatalf("Balance %d, want 110000", w.GetBalance())
+	}
+	if !w.IsUsed(reused) || !w.IsUsed(single) || w.IsUsed(fresh) {
+		t.Errorf("Used: %t %t %t, want true true false", w.IsUsed(reused), w.IsUsed(single), w.IsUsed(fresh))
+	}
+
+	// 55000 can't come from one output, and whichever address is picked
+	// first, all of its outputs are spent
+	tx, err := w.SendWithFee(node.Address, 55000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spent := make(map[utxo.OutPoint]bool)
+	for _, input := range tx.Inputs {
+		spent[utxo.NewOutPoint(input.PrevTxHash, input.OutputIndex)] = true
+	}
+	spentScripts := make(map[string]bool)
+	var left []*utxo.UTXO
+	for _, coin := range w.ListUTXOs() {
+		if spent[coin.OutPoint()] {
+			spentScripts[string(coin.Output.PubKeyScript)] = true
+		} else {
+			left = append(left, coin)
+		}
+	}
+	for _, coin := range left {
+		if spentScripts[string(coin.Output.PubKeyScript)] {
+			t.Errorf("Left an output of %d behind on a spent address", coin.Value())
+		}
+	}
+	if len(tx.Inputs) < 2 {
+		t.Errorf("Spent %d inputs, want both outputs of the reused address", len(tx.Inputs))
+	}
+
+	if len(tx.Outputs) != 2 {
+		t.Fatalf("%d outputs, want payment and change", len(tx.Outputs))
+	}
+	change, err := keys.AddressFromScript(tx.Outputs[1].PubKeyScript, w.NetParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.GetKey(change.String()); !ok || change.String() == reused || change.String() == single || change.String() == fresh {
+		t.Errorf("Change went to %s, want a new wallet address", change)
+	}
+
+	// The flag and the used addresses are saved
+	loaded := wallet.NewWallet()
+	loaded.SetNetParams(w.NetParams())
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.AvoidReuse() || !loaded.IsUsed(reused) || loaded.IsUsed(fresh) {
+		t.Error("avoid_reuse state not saved")
+	}
+}
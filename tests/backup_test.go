@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/backup"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// A node is backed up over RPC while running, and the backup restores to
+// a data directory with the same chain and wallets
+func TestBackupNode(t *testing.T) {
+	node, server, client := walletRPCNode(t)
+	if err := server.SetWalletDir(filepath.Join(t.TempDir(), "wallets")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateWallet("savings", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+	tipHash, tipHeight, err := node.Chain.GetTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	destination := filepath.Join(t.TempDir(), "node.tar.gz")
+	result, err := client.BackupNode(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TipHash != tipHash.String() || result.TipHeight != tipHeight || result.Entries == 0 {
+		t.Errorf("Backed up tip %s at %d with %d entries, want %s at %d", result.TipHash, result.TipHeight, result.Entries, tipHash, tipHeight)
+	}
+	if len(result.Wallets) != 2 || result.Wallets[0] != wallet.WalletFileName || result.Wallets[1] != "wallets/savings.json" {
+		t.Errorf("Wallets %v, want the default and savings", result.Wallets)
+	}
+
+	// The node carries on meanwhile; the backup keeps its own tip
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := filepath.Join(t.TempDir(), "restored")
+	manifest, err := backup.Restore(bytes.NewReader(data), dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.TipHeight != tipHeight {
+		t.Errorf("Restored height %d, want %d", manifest.TipHeight, tipHeight)
+	}
+
+	chain, err := storage.NewBlockchainStorage(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash, height, err := chain.GetTip(); err != nil || hash != tipHash || height != tipHeight {
+		t.Errorf("Restored tip %s at %d (%v), want %s at %d", hash, height, err, tipHash, tipHeight)
+	}
+	chain.Close()
+
+	restored := wallet.NewWallet()
+	restored.SetNetParams(node.Wallet.NetParams())
+	if err := restored.LoadFromFile(filepath.Join(dataDir, wallet.WalletFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.ListAddresses(), node.Wallet.ListAddresses(); len(got) != len(want) {
+		t.Errorf("Restored %d addresses, want %d", len(got), len(want))
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "wallets", "savings.json")); err != nil {
+		t.Error(err)
+	}
+
+	// Restoring over existing data is refused
+	if _, err := backup.Restore(bytes.NewReader(data), dataDir); err == nil {
+		t.Error("Restored over an existing data directory")
+	}
+
+	// A flipped byte in the chain snapshot fails its hash, leaving nothing
+	// behind. The tarball is gzipped, so damage it uncompressed.
+	var damaged bytes.Buffer
+	if _, err := backup.Create(&damaged, node.Chain, nil); err != nil {
+		t.Fatal(err)
+	}
+	raw := gunzip(t, damaged.Bytes())
+	marker := []byte(storage.KeyBestBlockHash)
+	i := bytes.LastIndex(raw, marker)
+	if i < 0 {
+		t.Fatal("chain state not found in backup")
+	}
+	raw[i+len(marker)+1] ^= 0xff
+	dataDir = filepath.Join(t.TempDir(), "damaged")
+	if _, err := backup.Restore(bytes.NewReader(gzipBytes(t, raw)), dataDir); !errors.Is(err, backup.ErrMismatch) {
+		t.Errorf("Restoring a damaged backup: %v, want ErrMismatch", err)
+	}
+	if _, err := os.Stat(dataDir); !os.IsNotExist(err) {
+		t.Errorf("Damaged restore left %s behind", dataDir)
+	}
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func gzipBytes(t *testing.T, raw []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
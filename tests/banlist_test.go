@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/security"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestBanListSkipsExpiredOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), security.BanListFileName)
+
+	now := time.Now().UTC()
+	contents := fmt.Sprintf(`{"version": 1, "bans": [
+		{"address": "10.0.0.1", "ban_created": %q, "banned_until": %q},
+		{"address": "10.0.0.2", "ban_created": %q, "banned_until": %q}
+	]}`,
+		now.Add(-2*time.Hour).Format(time.RFC3339), now.Add(-time.Hour).Format(time.RFC3339),
+		now.Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339))
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dp := security.NewDoSProtection()
+	if err := dp.LoadBans(path); err != nil {
+		t.Fatalf("LoadBans failed: %v", err)
+	}
+	if dp.IsBanned("10.0.0.1") {
+		t.Error("Expired ban was restored")
+	}
+	if !dp.IsBanned("10.0.0.2") {
+		t.Error("Active ban was not restored")
+	}
+
+	if err := security.NewDoSProtection().LoadBans(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("Missing file should not be an error: %v", err)
+	}
+}
+
+func TestBanListRPCPersists(t *testing.T) {
+	dataDir := t.TempDir()
+	banPath := filepath.Join(dataDir, security.BanListFileName)
+
+	chain, err := storage.NewBlockchainStorage(filepath.Join(dataDir, "chain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	node := network.NewNode(network.NodeConfig{ListenAddr: "127.0.0.1:0"}, chain)
+	if err := node.LoadBanList(banPath); err != nil {
+		t.Fatal(err)
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetNode(node)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	if err := client.SetBan("192.0.2.7", "add", 3600); err != nil {
+		t.Fatalf("setban failed: %v", err)
+	}
+	if err := client.SetBan("192.0.2.8", "add", 0); err != nil {
+		t.Fatalf("setban failed: %v", err)
+	}
+
+	bans, err := client.ListBanned()
+	if err != nil {
+		t.Fatalf("listbanned failed: %v", err)
+	}
+	if len(bans) != 2 || bans[0].Address != "192.0.2.7" || bans[1].Address != "192.0.2.8" {
+		t.Fatalf("Unexpected bans: %+v", bans)
+	}
+	if bans[0].BanDuration != 3600 || bans[0].TimeRemaining <= 0 {
+		t.Errorf("Unexpected ban times: %+v", bans[0])
+	}
+	if bans[1].BanDuration != int64((24 * time.Hour).Seconds()) {
+		t.Errorf("Default ban duration = %d", bans[1].BanDuration)
+	}
+
+	// A restarted node picks the bans up from disk
+	restored := network.NewNode(network.NodeConfig{ListenAddr: "127.0.0.1:0"}, chain)
+	if err := restored.LoadBanList(banPath); err != nil {
+		t.Fatalf("Failed to reload bans: %v", err)
+	}
+	if got := restored.ListBanned(); len(got) != 2 {
+		t.Fatalf("Restored %d bans, want 2", len(got))
+	}
+
+	if err := client.SetBan("192.0.2.7", "remove", 0); err != nil {
+		t.Fatalf("setban remove failed: %v", err)
+	}
+	if err := client.ClearBanned(); err != nil {
+		t.Fatalf("clearbanned failed: %v", err)
+	}
+	if bans, _ := client.ListBanned(); len(bans) != 0 {
+		t.Errorf("Bans left after clearbanned: %+v", bans)
+	}
+
+	cleared := security.NewDoSProtection()
+	if err := cleared.LoadBans(banPath); err != nil {
+		t.Fatal(err)
+	}
+	if len(cleared.ListBans()) != 0 {
+		t.Error("clearbanned was not persisted")
+	}
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// preBIP34Block builds a block whose coinbase, like those mined before
+// BIP34, carries no height and so has the same txid at any height
+func preBIP34Block(t *testing.T, prev types.Hash, height uint64, txs ...types.Transaction) *types.Block {
+	t.Helper()
+
+	coinbase := types.Transaction{
+		Version: 1,
+		Inputs: []types.TxInput{{
+			PrevTxHash:      types.Hash{},
+			OutputIndex:     0xFFFFFFFF,
+			SignatureScript: []byte{0x04, 0xff, 0xff, 0x00, 0x1d},
+			Sequence:        0xFFFFFFFF,
+		}},
+		Outputs: []types.TxOutput{{Value: validation.GetBlockReward(height), PubKeyScript: []byte{0x51}}},
+	}
+
+	block, err := mining.BuildBlock(&mining.BlockTemplate{
+		Version:       1,
+		PrevBlockHash: prev,
+		Transactions:  append([]types.Transaction{coinbase}, txs...),
+		Timestamp:     uint32(1231006505 + 600*height),
+		Bits:          0x207fffff,
+		Height:        height,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestBIP30RejectsDuplicateCoinbase(t *testing.T) {
+	set := utxo.NewUTXOSet()
+	validator := validation.NewBlockValidator(set)
+
+	first := preBIP34Block(t, types.Hash{}, 0)
+	if err := validator.ValidateBlock(first, 0, types.Hash{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.ApplyBlock(first, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same coinbase in a later block would silently replace the unspent
+	// output, destroying the first block's reward
+	prev := blockHash(t, first)
+	second := preBIP34Block(t, prev, 1)
+	err := validator.ValidateBlock(second, 1, prev)
+	if err == nil || !strings.Contains(err.Error(), "BIP30") {
+		t.Fatalf("ValidateBlock = %v, want a BIP30 error", err)
+	}
+
+	// Once the earlier coinbase is spent the txid may be reused
+	coinbaseHash, _ := serialization.HashTransaction(&first.Transactions[0])
+	spend := types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: coinbaseHash, OutputIndex: 0, SignatureScript: []byte{0x51}, Sequence: 0xFFFFFFFF}},
+		Outputs: []types.TxOutput{{Value: first.Transactions[0].Outputs[0].Value, PubKeyScript: []byte{0x52}}},
+	}
+	spending := preBIP34Block(t, prev, 1, spend)
+	spending.Transactions[0].Inputs[0].SignatureScript = []byte{0x04, 0xff, 0xff, 0x00, 0x1d, 0x01}
+	spending = rebuildBlock(t, spending, 1)
+	if err := validator.ValidateBlock(spending, 1, prev); err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.ApplyBlock(spending, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	prev = blockHash(t, spending)
+	reused := preBIP34Block(t, prev, 2)
+	if err := validator.ValidateBlock(reused, 2, prev); err != nil {
+		t.Fatalf("Reusing the txid of a spent coinbase was rejected: %v", err)
+	}
+}
+
+func TestBIP30HistoricalExceptions(t *testing.T) {
+	rules := consensus.NewMainnetRules()
+	exception := rules.BIP30Exceptions[0]
+
+	if !rules.IsBIP30Exception(exception.Height, exception.Hash) {
+		t.Errorf("Block %d is not a BIP30 exception", exception.Height)
+	}
+	if rules.IsBIP30Exception(exception.Height+1, exception.Hash) {
+		t.Error("Exception hash matched at the wrong height")
+	}
+	if len(consensus.NewRegtestRules().BIP30Exceptions) != 0 {
+		t.Error("Regtest has BIP30 exceptions")
+	}
+}
@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+func TestBlockProposal(t *testing.T) {
+	client, server, blocks, chain := txoutServer(t)
+	rules := consensus.NewRegtestRules()
+
+	propose := func(block *types.Block) string {
+		t.Helper()
+		data, err := serialization.SerializeBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reason, err := client.ProposeBlock(hex.EncodeToString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reason
+	}
+
+	tip := blockHash(t, blocks[1])
+	candidate := buildBranch(t, tip, 2, 1, 0)[0]
+	data, _ := serialization.SerializeBlock(candidate)
+	if _, err := client.ProposeBlock(hex.EncodeToString(data)); err == nil {
+		t.Error("Proposal without consensus rules succeeded")
+	}
+	server.SetConsensusRules(rules)
+
+	// Proof of work isn't checked: find a nonce that misses the target
+	for {
+		hash := blockHash(t, candidate)
+		if rules.CheckProofOfWork(hash, candidate.Header.Bits) != nil {
+			break
+		}
+		candidate.Header.Nonce++
+	}
+	if reason := propose(candidate); reason != "" {
+		t.Fatalf("Valid proposal rejected: %s", reason)
+	}
+	if height, _ := chain.GetBestBlockHeight(); height != 1 {
+		t.Errorf("Proposal changed the tip height to %d", height)
+	}
+
+	if reason := propose(blocks[1]); reason != validation.RejectDuplicate {
+		t.Errorf("Stored block: %q, want %q", reason, validation.RejectDuplicate)
+	}
+
+	stale := buildBranch(t, blockHash(t, blocks[0]), 1, 1, 7)[0]
+	if reason := propose(stale); reason != validation.RejectNotBestPrevBlock {
+		t.Errorf("Block off the tip: %q, want %q", reason, validation.RejectNotBestPrevBlock)
+	}
+
+	easy := buildBranch(t, tip, 2, 1, 0)[0]
+	easy.Header.Bits = consensus.DifficultyOneBits
+	if reason := propose(easy); reason != validation.RejectBadDiffBits {
+		t.Errorf("Wrong bits: %q, want %q", reason, validation.RejectBadDiffBits)
+	}
+
+	greedy := buildBranch(t, tip, 2, 1, 0)[0]
+	greedy.Transactions[0].Outputs[0].Value++
+	greedy = rebuildBlock(t, greedy, 2)
+	if reason := propose(greedy); reason != validation.RejectBadCoinbaseValue {
+		t.Errorf("Excess coinbase value: %q, want %q", reason, validation.RejectBadCoinbaseValue)
+	}
+
+	unrooted := buildBranch(t, tip, 2, 1, 0)[0]
+	unrooted.Header.MerkleRoot = types.Hash{1}
+	if reason := propose(unrooted); reason != validation.RejectBadMerkleRoot {
+		t.Errorf("Bad merkle root: %q, want %q", reason, validation.RejectBadMerkleRoot)
+	}
+}
+
+func TestValidateBlockReport(t *testing.T) {
+	client, server, blocks, chain := txoutServer(t)
+	server.SetConsensusRules(consensus.NewRegtestRules())
+
+	validate := func(block *types.Block) *rpc.BlockReportResponse {
+		t.Helper()
+		data, err := serialization.SerializeBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := client.ValidateBlock(hex.EncodeToString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	results := func(report *rpc.BlockReportResponse) map[string]string {
+		byRule := make(map[string]string)
+		for _, rule := range report.Rules {
+			byRule[rule.Rule] = rule.Result
+		}
+		return byRule
+	}
+
+	spent := &blocks[1].Transactions[1]
+	candidate := buildBranch(t, blockHash(t, blocks[1]), 2, 1, 0)[0]
+	candidate.Transactions = append(candidate.Transactions, *rbfSpend(txid(t, spent), 0xffffffff, spent.Outputs[0].Value-500))
+	candidate = rebuildBlock(t, candidate, 2)
+
+	report := validate(candidate)
+	if !report.Valid || report.Reason != "" {
+		t.Fatalf("Valid block reported invalid: %+v", report)
+	}
+	data, _ := serialization.SerializeBlock(candidate)
+	weight, _ := validation.BlockWeight(candidate)
+	if report.Height != 2 || report.Size != len(data) || report.Weight != weight {
+		t.Errorf("Height %d, size %d, weight %d, want 2, %d, %d", report.Height, report.Size, report.Weight, len(data), weight)
+	}
+	if report.Fees != 500 {
+		t.Errorf("Fees = %d, want 500", report.Fees)
+	}
+	if report.CoinbaseValue != candidate.Transactions[0].Outputs[0].Value {
+		t.Errorf("Coinbase value = %d, want %d", report.CoinbaseValue, candidate.Transactions[0].Outputs[0].Value)
+	}
+	for rule, result := range results(report) {
+		if result != string(validation.RulePassed) {
+			t.Errorf("Rule %s: %s", rule, result)
+		}
+	}
+	if height, _ := chain.GetBestBlockHeight(); height != 1 {
+		t.Errorf("Validation changed the tip height to %d", height)
+	}
+
+	// Every failure is reported, not just the first
+	bad := buildBranch(t, blockHash(t, blocks[1]), 2, 1, 0)[0]
+	bad.Transactions[0].Outputs[0].Value++
+	bad = rebuildBlock(t, bad, 2)
+	bad.Header.MerkleRoot = types.Hash{1}
+	report = validate(bad)
+	if report.Valid || report.Reason != validation.RejectBadCoinbaseValue {
+		t.Errorf("Reason = %q, want %q", report.Reason, validation.RejectBadCoinbaseValue)
+	}
+	byRule := results(report)
+	if byRule[validation.RuleCoinbaseReward] != "fail" || byRule[validation.RuleMerkleRoot] != "fail" {
+		t.Errorf("Rules = %v, want reward and merkle root failures", byRule)
+	}
+	if byRule[validation.RuleUniqueTxs] != "pass" {
+		t.Errorf("Checking stopped at the first failure: %v", byRule)
+	}
+
+	// Proposals report the same way on request
+	data, _ = serialization.SerializeBlock(bad)
+	proposal, err := client.ProposeBlockVerbose(hex.EncodeToString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proposal.Reason != validation.RejectBadCoinbaseValue || results(proposal)[validation.RuleBuildsOnTip] != "pass" {
+		t.Errorf("Verbose proposal = %+v", proposal)
+	}
+}
+
+func TestCheckBlockSkipsDependentRules(t *testing.T) {
+	block := buildBranch(t, types.Hash{}, 0, 1, 0)[0]
+	block.Transactions = nil
+
+	report := validation.NewBlockValidator(utxo.NewUTXOSet()).CheckBlock(block, 0, types.Hash{})
+	if validation.RejectReason(report.Err()) != validation.RejectNoTransactions {
+		t.Fatalf("Err = %v", report.Err())
+	}
+	for _, check := range report.Checks {
+		switch check.Rule {
+		case validation.RuleHeader, validation.RuleWeight:
+			if check.Status != validation.RulePassed {
+				t.Errorf("%s: %s (%v)", check.Rule, check.Status, check.Err)
+			}
+		case validation.RuleHasTransactions:
+			if check.Status != validation.RuleFailed {
+				t.Errorf("%s: %s", check.Rule, check.Status)
+			}
+		default:
+			if check.Status != validation.RuleSkipped {
+				t.Errorf("%s: %s, want skipped", check.Rule, check.Status)
+			}
+		}
+	}
+}
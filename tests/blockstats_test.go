@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestGetBlockStats(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	// Block 1 spends the genesis coinbase, then spends that spend again
+	blocks := buildBranch(t, types.Hash{}, 0, 2, 0)
+	reward := blocks[0].Transactions[0].Outputs[0].Value
+	first := rbfSpend(txid(t, &blocks[0].Transactions[0]), transaction.SequenceFinal, reward-20000)
+	second := rbfSpend(txid(t, first), transaction.SequenceFinal, reward-25000)
+	blocks[1].Transactions = append(blocks[1].Transactions, *first, *second)
+	blocks[1] = rebuildBlock(t, blocks[1], 1)
+	for height, block := range blocks {
+		if err := chain.SaveBlock(block, uint64(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	stats, err := client.GetBlockStats(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rates := []int64{transaction.FeeRate(20000, int64(transaction.VirtualSize(first))), transaction.FeeRate(5000, int64(transaction.VirtualSize(second)))}
+	vsize := int64(transaction.VirtualSize(first) + transaction.VirtualSize(second))
+	raw, _ := serialization.SerializeBlock(blocks[1])
+	weight, _ := validation.BlockWeight(blocks[1])
+
+	checks := []struct {
+		name      string
+		got, want int64
+	}{
+		{"txs", int64(stats.Txs), 3},
+		{"ins", int64(stats.Ins), 2},
+		{"outs", int64(stats.Outs), 2},
+		{"total_out", stats.TotalOut, 2*reward - 45000},
+		{"totalfee", stats.TotalFee, 25000},
+		{"subsidy", stats.Subsidy, validation.GetBlockReward(1)},
+		{"minfee", stats.MinFee, 5000},
+		{"maxfee", stats.MaxFee, 20000},
+		{"avgfee", stats.AvgFee, 12500},
+		{"minfeerate", stats.MinFeeRate, rates[1]},
+		{"maxfeerate", stats.MaxFeeRate, rates[0]},
+		{"medianfeerate", stats.MedianFeeRate, (rates[0] + rates[1]) / 2},
+		{"avgfeerate", stats.AvgFeeRate, transaction.FeeRate(25000, vsize)},
+		{"size", int64(stats.Size), int64(len(raw))},
+		{"weight", int64(stats.Weight), int64(weight)},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %d, want %d", c.name, c.got, c.want)
+		}
+	}
+
+	genesis, err := client.GetBlockStats(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genesis.Txs != 1 || genesis.TotalFee != 0 || genesis.MedianFeeRate != 0 {
+		t.Errorf("Coinbase-only block stats = %+v", genesis)
+	}
+}
@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// fakeTip is a chain tip tests can move
+type fakeTip struct {
+	hash types.Hash
+	mu   sync.Mutex
+}
+
+func (f *fakeTip) get() (types.Hash, uint64, uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hash, 10, 0x207fffff, nil
+}
+
+func (f *fakeTip) set(hash types.Hash) {
+	f.mu.Lock()
+	f.hash = hash
+	f.mu.Unlock()
+}
+
+// templateCache returns a cache over an empty mempool that polls every
+// few milliseconds
+func templateCache(t *testing.T) (*mining.TemplateCache, *mempool.Mempool, *fakeTip, *clock.Fake) {
+	t.Helper()
+
+	pool := mempool.NewMempool(1000000, 1000, 3600)
+	builder := mining.NewBlockBuilder(pool)
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	builder.SetClock(fake)
+	tip := &fakeTip{hash: types.Hash{1}}
+
+	config := mining.DefaultTemplateCacheConfig("")
+	config.PollInterval = 5 * time.Millisecond
+	return mining.NewTemplateCache(builder, tip.get, config), pool, tip, fake
+}
+
+func TestTemplateCacheReusesTemplates(t *testing.T) {
+	cache, pool, tip, fake := templateCache(t)
+	ctx := context.Background()
+
+	first, id, err := cache.Get(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(time.Second)
+	again, againID, err := cache.Get(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Rebuilds() != 1 || againID != id {
+		t.Errorf("Unchanged template rebuilt: %d rebuilds, id %s then %s", cache.Rebuilds(), id, againID)
+	}
+	if again.Timestamp != first.Timestamp+1 {
+		t.Errorf("Cached template time %d, want %d", again.Timestamp, first.Timestamp+1)
+	}
+
+	// Mempool changes wait for the rebuild interval
+	if err := pool.Add(rbfSpend(types.Hash{2}, transaction.SequenceFinal, 1000), 500, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cache.Get(ctx, ""); err != nil || cache.Rebuilds() != 1 {
+		t.Errorf("Template rebuilt within the rebuild interval (%v)", err)
+	}
+	fake.Advance(5 * time.Second)
+	template, _, err := cache.Get(ctx, "")
+	if err != nil || cache.Rebuilds() != 2 || len(template.Transactions) != 2 {
+		t.Errorf("Mempool change not picked up: %d rebuilds, %v", cache.Rebuilds(), err)
+	}
+
+	// A new tip always rebuilds
+	tip.set(types.Hash{3})
+	if template, _, err := cache.Get(ctx, ""); err != nil || template.PrevBlockHash != (types.Hash{3}) {
+		t.Errorf("Template not rebuilt on the new tip (%v)", err)
+	}
+}
+
+func TestTemplateLongPoll(t *testing.T) {
+	cache, pool, tip, _ := templateCache(t)
+
+	_, id, err := cache.Get(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		template *mining.BlockTemplate
+		id       string
+		err      error
+	}
+	poll := func() chan result {
+		done := make(chan result, 1)
+		go func() {
+			template, newID, err := cache.Get(context.Background(), id)
+			done <- result{template, newID, err}
+		}()
+		return done
+	}
+
+	done := poll()
+
+	// Too little in new fees to be worth a new template
+	if err := pool.Add(rbfSpend(types.Hash{2}, transaction.SequenceFinal, 1000), 500, 10); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		t.Fatalf("Long poll returned after a small fee increase: %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := pool.Add(rbfSpend(types.Hash{3}, transaction.SequenceFinal, 1000), 5000, 10); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil || r.id == id || r.template.TotalFees != 5500 {
+			t.Errorf("Long poll after a fee increase: %+v", r)
+		}
+		id = r.id
+	case <-time.After(5 * time.Second):
+		t.Fatal("Long poll did not return after a fee increase")
+	}
+
+	done = poll()
+	tip.set(types.Hash{9})
+	select {
+	case r := <-done:
+		if r.err != nil || r.template.PrevBlockHash != (types.Hash{9}) {
+			t.Errorf("Long poll after a new tip: %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Long poll did not return after a new tip")
+	}
+
+	// An outdated id doesn't wait at all
+	returnsWithin(t, "Get with an outdated id", time.Second, func() {
+		cache.Get(context.Background(), id)
+	})
+
+	_, id, _ = cache.Get(context.Background(), "")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := cache.Get(ctx, id); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Long poll past its context: got %v", err)
+	}
+}
+
+func TestGetBlockTemplateRPC(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	cache, pool, tip, _ := templateCache(t)
+	if err := pool.Add(rbfSpend(types.Hash{2}, transaction.SequenceFinal, 1000), 5000, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	if _, err := client.GetBlockTemplate(""); err == nil {
+		t.Error("getblocktemplate succeeded without a template cache")
+	}
+	server.SetBlockTemplateCache(cache)
+
+	template, err := client.GetBlockTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.Height != 11 || template.PreviousBlockHash != (types.Hash{1}).String() || template.Bits != "207fffff" {
+		t.Errorf("Template = %+v", template)
+	}
+	if len(template.Transactions) != 1 || template.Fees != 5000 || template.Coinbase == "" {
+		t.Errorf("Template transactions = %+v, fees %d", template.Transactions, template.Fees)
+	}
+	if template.Target != "7fffff0000000000000000000000000000000000000000000000000000000000" {
+		t.Errorf("Target = %s", template.Target)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tip.set(types.Hash{4})
+	}()
+	next, err := client.GetBlockTemplate(template.LongPollID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.PreviousBlockHash != (types.Hash{4}).String() || next.LongPollID == template.LongPollID {
+		t.Errorf("Long poll returned %+v", next)
+	}
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	syncmanager "github.com/pouria-shahmiri/learn-bitcoin/pkg/network/sync"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestGetChainTips(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	cv := validation.NewChainValidator(chain, utxo.NewUTXOSet())
+
+	main := buildBranch(t, types.Hash{}, 0, 4, 0)
+	fork := buildBranch(t, blockHash(t, main[1]), 2, 2, 1)
+
+	// The second block of this fork pays itself too much, which is only
+	// noticed when the fork gets enough work to be reorganized onto
+	bad := buildBranch(t, blockHash(t, main[2]), 3, 2, 2)
+	coinbase, err := mining.CreateCoinbase(4, 1000*100000000, "side-chain", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad[1], err = mining.BuildBlock(&mining.BlockTemplate{
+		Version:       1,
+		PrevBlockHash: blockHash(t, bad[0]),
+		Transactions:  []types.Transaction{*coinbase},
+		Timestamp:     bad[1].Header.Timestamp,
+		Bits:          0x207fffff,
+		Height:        4,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range append(append(main, fork...), bad[0]) {
+		if err := cv.AcceptBlock(block); err != nil {
+			t.Fatalf("AcceptBlock: %v", err)
+		}
+	}
+	if err := cv.AcceptBlock(bad[1]); err == nil {
+		t.Fatal("Reorg onto an overpaying block succeeded")
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	tips, err := rpc.NewClient(ts.URL).GetChainTips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rpc.ChainTipInfo{
+		{Height: 4, Hash: blockHash(t, bad[1]).String(), BranchLen: 2, Status: storage.ChainTipInvalid},
+		{Height: 3, Hash: blockHash(t, main[3]).String(), BranchLen: 0, Status: storage.ChainTipActive},
+		{Height: 3, Hash: blockHash(t, fork[1]).String(), BranchLen: 2, Status: storage.ChainTipValidFork},
+	}
+	if len(tips) != len(want) {
+		t.Fatalf("Got %d tips, want %d: %+v", len(tips), len(want), tips)
+	}
+	for i := range want {
+		if tips[i] != want[i] {
+			t.Errorf("Tip %d = %+v, want %+v", i, tips[i], want[i])
+		}
+	}
+
+	// Headers extending the valid fork replace it as a tip until the
+	// blocks arrive
+	sm := syncmanager.NewSyncManager(chain)
+	headers := protocol.NewHeadersMessage()
+	ahead := buildBranch(t, blockHash(t, fork[1]), 4, 2, 1)
+	for _, block := range ahead {
+		headers.AddHeader(block.Header)
+	}
+	if err := sm.HandleHeaders(headers, &recordingSender{addr: "10.0.0.1:8333"}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := sm.ChainTips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[types.Hash]storage.ChainTip)
+	for _, tip := range all {
+		statuses[tip.Hash] = tip
+	}
+	if len(all) != 3 {
+		t.Errorf("Got %d tips with headers, want 3: %+v", len(all), all)
+	}
+	if tip := statuses[blockHash(t, ahead[1])]; tip.Status != storage.ChainTipHeadersOnly || tip.Height != 5 || tip.BranchLen != 4 {
+		t.Errorf("Headers-only tip = %+v", tip)
+	}
+	if _, listed := statuses[blockHash(t, fork[1])]; listed {
+		t.Error("Fork extended by headers is still listed as a tip")
+	}
+
+	// The first block gives the fork the most work, leaving one header
+	// ahead of the new active tip
+	if _, _, err := sm.HandleBlock(ahead[0], &recordingSender{addr: "10.0.0.1:8333"}); err != nil {
+		t.Fatal(err)
+	}
+	all, _ = sm.ChainTips()
+	statuses = make(map[types.Hash]storage.ChainTip)
+	for _, tip := range all {
+		statuses[tip.Hash] = tip
+	}
+	if tip := statuses[blockHash(t, ahead[0])]; tip.Status != storage.ChainTipActive {
+		t.Errorf("Downloaded block tip = %+v, want active", tip)
+	}
+	if tip := statuses[blockHash(t, ahead[1])]; tip.Status != storage.ChainTipHeadersOnly || tip.BranchLen != 1 {
+		t.Errorf("Headers-only tip = %+v, want branch length 1", tip)
+	}
+}
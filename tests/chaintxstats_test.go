@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"testing"
+)
+
+func TestGetChainTxStats(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	// Genesis and blocks 1-2 hold a coinbase each; block 3 adds a payment
+	if _, err := node.SendTo(node.Address, 1000, 500); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := client.GetChainTxStats(-1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tip, _ := node.BestHash()
+	if stats.TxCount != 5 || stats.FinalBlockHeight != 3 || stats.FinalBlockHash != tip.String() {
+		t.Errorf("Tip stats: %+v", stats)
+	}
+	// The default window stops short of genesis
+	if stats.BlockCount != 2 || stats.TxCountWindow != 3 {
+		t.Errorf("Window: %d blocks, %d transactions, want 2 and 3", stats.BlockCount, stats.TxCountWindow)
+	}
+	if stats.Interval > 0 && stats.TxRate != float64(stats.TxCountWindow)/float64(stats.Interval) {
+		t.Errorf("Rate %f over %ds", stats.TxRate, stats.Interval)
+	}
+
+	block2, err := client.GetBlockHash(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err = client.GetChainTxStats(1, block2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TxCount != 3 || stats.BlockCount != 1 || stats.TxCountWindow != 1 {
+		t.Errorf("Stats at block 2: %+v", stats)
+	}
+
+	stats, err = client.GetChainTxStats(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TxCountWindow != 0 || stats.TxRate != 0 {
+		t.Errorf("Empty window: %+v", stats)
+	}
+
+	if _, err := client.GetChainTxStats(3, ""); err == nil {
+		t.Error("Window reaching genesis accepted")
+	}
+}
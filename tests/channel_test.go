@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/channel"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+)
+
+// openChannel funds a channel from node's wallet and confirms it
+func openChannel(t *testing.T, node *testharness.TestNode, expiry uint32) (*channel.Payer, *channel.Payee, channel.Params) {
+	t.Helper()
+
+	payerKey, _ := keys.GeneratePrivateKey()
+	payeeKey, _ := keys.GeneratePrivateKey()
+	params := channel.Params{
+		Payer:    payerKey.PublicKey().Bytes(true),
+		Payee:    payeeKey.PublicKey().Bytes(true),
+		Capacity: 100000000,
+		Fee:      10000,
+		Expiry:   expiry,
+		Step:     2,
+	}
+	payer, err := channel.NewPayer(payerKey, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payee, err := channel.NewPayee(payeeKey, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node.ScanWallet(); err != nil {
+		t.Fatal(err)
+	}
+	funding, err := payer.Fund(node.Wallet, 10000)
+	if err != nil {
+		t.Fatalf("Fund failed: %v", err)
+	}
+	refundSig, err := payee.SignRefund(funding)
+	if err != nil {
+		t.Fatalf("SignRefund failed: %v", err)
+	}
+
+	// Nothing is paid before the refund is safe
+	if _, err := payer.Pay(1000); !errors.Is(err, channel.ErrNotOpen) {
+		t.Errorf("Pay before open: got %v, want ErrNotOpen", err)
+	}
+	if err := payer.Open(node.P2P, append([]byte{}, refundSig[:len(refundSig)-2]...)); err == nil {
+		t.Error("Open accepted a bad refund signature")
+	}
+	if err := payer.Open(node.P2P, refundSig); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := node.Chain.GetTransactionLocation(txid(t, funding)); err != nil {
+		t.Fatalf("Funding not confirmed: %v", err)
+	}
+	return payer, payee, params
+}
+
+// pay sends a payment through the channel
+func pay(t *testing.T, payer *channel.Payer, payee *channel.Payee, amount int64) *channel.Update {
+	t.Helper()
+
+	update, err := payer.Pay(amount)
+	if err != nil {
+		t.Fatalf("Pay failed: %v", err)
+	}
+	if err := payee.Accept(update); err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	return update
+}
+
+func TestChannelCooperativeClose(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	payer, payee, params := openChannel(t, node, 40)
+
+	first := pay(t, payer, payee, 1000000)
+	second := pay(t, payer, payee, 2000000)
+	if second.LockTime != first.LockTime-params.Step {
+		t.Errorf("Lock time went from %d to %d, want a decrease of %d", first.LockTime, second.LockTime, params.Step)
+	}
+
+	// Replays and forgeries are refused
+	if err := payee.Accept(first); !errors.Is(err, channel.ErrStaleUpdate) {
+		t.Errorf("Replayed update: got %v, want ErrStaleUpdate", err)
+	}
+	forged := *second
+	forged.Seq, forged.Paid, forged.LockTime = 3, 50000000, params.Expiry-3*params.Step
+	if err := payee.Accept(&forged); !errors.Is(err, channel.ErrBadSignature) {
+		t.Errorf("Forged update: got %v, want ErrBadSignature", err)
+	}
+	if _, err := payer.Pay(params.Capacity); !errors.Is(err, channel.ErrInsufficient) {
+		t.Errorf("Overpayment: got %v, want ErrInsufficient", err)
+	}
+	if payer.Paid() != 3000000 || payee.Received() != 3000000 {
+		t.Fatalf("Paid %d, received %d; want 3000000", payer.Paid(), payee.Received())
+	}
+
+	// The latest commitment is still locked, but both sides can close now
+	if _, err := payee.Broadcast(node.P2P); !errors.Is(err, channel.ErrTimeLocked) {
+		t.Errorf("Early commitment: got %v, want ErrTimeLocked", err)
+	}
+	closeSig, err := payee.SignClose()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeTx, err := payer.Close(node.P2P, closeSig)
+	if err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := node.Chain.GetTransactionLocation(txid(t, closeTx)); err != nil {
+		t.Fatalf("Close not confirmed: %v", err)
+	}
+	if len(closeTx.Outputs) != 2 || closeTx.Outputs[0].Value != 3000000 ||
+		closeTx.Outputs[1].Value != params.Capacity-params.Fee-3000000 {
+		t.Errorf("Close pays %+v", closeTx.Outputs)
+	}
+}
+
+func TestChannelUnilateralClose(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	payer, payee, _ := openChannel(t, node, 20)
+	pay(t, payer, payee, 1000000)
+	latest := pay(t, payer, payee, 1000000)
+
+	// The payee's newest commitment unlocks before the payer's refund
+	height, _ := node.Height()
+	if _, err := node.MineBlocks(int(latest.LockTime - uint32(height))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := payer.Refund(node.P2P); !errors.Is(err, channel.ErrTimeLocked) {
+		t.Errorf("Early refund: got %v, want ErrTimeLocked", err)
+	}
+	commitment, err := payee.Broadcast(node.P2P)
+	if err != nil {
+		t.Fatalf("Broadcast failed: %v", err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := node.Chain.GetTransactionLocation(txid(t, commitment)); err != nil {
+		t.Fatalf("Commitment not confirmed: %v", err)
+	}
+	if commitment.Outputs[0].Value != 2000000 {
+		t.Errorf("Commitment pays the payee %d, want 2000000", commitment.Outputs[0].Value)
+	}
+}
+
+func TestChannelRefund(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// The payee disappears; the payer waits for the expiry
+	payer, _, params := openChannel(t, node, 10)
+	height, _ := node.Height()
+	if _, err := node.MineBlocks(int(params.Expiry - uint32(height))); err != nil {
+		t.Fatal(err)
+	}
+	refund, err := payer.Refund(node.P2P)
+	if err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := node.Chain.GetTransactionLocation(txid(t, refund)); err != nil {
+		t.Fatalf("Refund not confirmed: %v", err)
+	}
+	if len(refund.Outputs) != 1 || refund.Outputs[0].Value != params.Capacity-params.Fee {
+		t.Errorf("Refund pays %+v", refund.Outputs)
+	}
+}
@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestFakeClockAfter(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1000, 0))
+	timer := fake.After(10 * time.Second)
+
+	fake.Advance(5 * time.Second)
+	select {
+	case <-timer:
+		t.Fatal("Timer fired too early")
+	default:
+	}
+
+	fake.Advance(5 * time.Second)
+	select {
+	case fired := <-timer:
+		if fired.Unix() != 1010 {
+			t.Errorf("Timer fired at %d, want 1010", fired.Unix())
+		}
+	default:
+		t.Fatal("Timer should have fired")
+	}
+
+	if fake.PendingTimers() != 0 {
+		t.Errorf("Pending timers = %d, want 0", fake.PendingTimers())
+	}
+}
+
+func TestMempoolExpiryWithFakeClock(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+
+	// 1 hour max age
+	mp := mempool.NewMempool(1000000, 1000, 3600)
+	mp.SetClock(fake)
+
+	tx := &types.Transaction{
+		Version: 1,
+		Inputs: []types.TxInput{
+			{PrevTxHash: types.Hash{7}, OutputIndex: 0, SignatureScript: []byte{0x01}, Sequence: 0xFFFFFFFF},
+		},
+		Outputs:  []types.TxOutput{{Value: 1000, PubKeyScript: []byte{0x51}}},
+		LockTime: 0,
+	}
+	if err := mp.Add(tx, 1000, 1); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	fake.Advance(30 * time.Minute)
+	if expired := mp.ExpireTransactions(); expired != 0 {
+		t.Errorf("Expired %d transactions after 30 minutes, want 0", expired)
+	}
+
+	fake.Advance(31 * time.Minute)
+	if expired := mp.ExpireTransactions(); expired != 1 {
+		t.Errorf("Expired %d transactions after 61 minutes, want 1", expired)
+	}
+}
+
+// Blocks mined under a fake clock carry its time, so a retarget over them
+// comes out the same on every run without waiting for real blocks
+func TestRetargetFollowsFakeClock(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	fake := clock.NewFake(time.Unix(2000000000, 0))
+	h.SetClock(fake)
+
+	node := h.Node(0)
+	for i := 0; i < 7; i++ {
+		fake.Advance(5 * time.Minute)
+		if _, err := node.MineBlocks(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Regtest with a retarget every 4 blocks: heights 4 to 7 took 15
+	// minutes against a 40 minute target
+	rules := consensus.NewRegtestRules()
+	rules.PowNoRetargeting = false
+	rules.PowTargetTimespan = 4 * rules.PowTargetSpacing
+	bits, err := rules.NextWorkRequired(node.Chain, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := consensus.CompactToTarget(rules.PowLimitBits)
+	target.Mul(target, big.NewInt(15))
+	target.Div(target, big.NewInt(40))
+	if want := consensus.TargetToCompact(target); bits != want {
+		t.Errorf("Bits after retarget = %08x, want %08x", bits, want)
+	}
+}
+
+func TestNetworkTimeMedian(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	nt := clock.NewNetworkTime(fake)
+
+	// Too few samples change nothing
+	for i, offset := range []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 40 * time.Second} {
+		nt.AddSample(string(rune('a'+i)), offset)
+	}
+	if nt.Offset() != 0 {
+		t.Fatalf("Adjusted with %d samples: %v", nt.NumSamples(), nt.Offset())
+	}
+
+	// A peer only counts once
+	nt.AddSample("a", time.Hour)
+	if nt.NumSamples() != 4 {
+		t.Fatalf("Duplicate source counted, %d samples", nt.NumSamples())
+	}
+
+	nt.AddSample("e", -time.Minute)
+	if nt.Offset() != 20*time.Second {
+		t.Errorf("Offset = %v, want the median 20s", nt.Offset())
+	}
+	if got := nt.Now().Sub(fake.Now()); got != 20*time.Second {
+		t.Errorf("Now is %v ahead of the base clock", got)
+	}
+
+	// Even counts keep the previous adjustment
+	nt.AddSample("f", time.Minute)
+	if nt.Offset() != 20*time.Second {
+		t.Errorf("Offset changed on an even sample count: %v", nt.Offset())
+	}
+	if nt.Warning() != "" {
+		t.Errorf("Warned about a small offset: %s", nt.Warning())
+	}
+}
+
+func TestNetworkTimeLimits(t *testing.T) {
+	nt := clock.NewNetworkTime(clock.NewFake(time.Unix(1700000000, 0)))
+
+	// Ten minutes is applied, with a warning the first time
+	var warnings []string
+	for i := 0; i < clock.MinTimeSamples; i++ {
+		if w := nt.AddSample(string(rune('a'+i)), 10*time.Minute); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	if nt.Offset() != 10*time.Minute {
+		t.Errorf("Offset = %v, want 10m", nt.Offset())
+	}
+	if len(warnings) != 1 || nt.Warning() != warnings[0] {
+		t.Errorf("Warnings = %q", warnings)
+	}
+
+	// Beyond the maximum adjustment the local clock is used as is
+	for i := 0; i < 6; i++ {
+		nt.AddSample(string(rune('k'+i)), 2*time.Hour)
+	}
+	if nt.Offset() != 0 {
+		t.Errorf("Applied an offset above the maximum: %v", nt.Offset())
+	}
+	if w := nt.AddSample("z", 2*time.Hour); w != "" {
+		t.Errorf("Warned twice: %s", w)
+	}
+}
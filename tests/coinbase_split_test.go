@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+func TestSplitCoinbase(t *testing.T) {
+	const fees = 1001
+	payouts := []mining.Payout{
+		{Address: "pool", Share: 1},
+		{Address: "alice", Share: 3},
+		{Address: "bob", Share: 3},
+	}
+	coinbase, err := mining.CreateSplitCoinbase(1, fees, payouts, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := validation.GetBlockReward(1) + fees
+	if len(coinbase.Outputs) != len(payouts) {
+		t.Fatalf("%d outputs, want %d", len(coinbase.Outputs), len(payouts))
+	}
+	sum := int64(0)
+	for _, output := range coinbase.Outputs {
+		sum += output.Value
+	}
+	if sum != total {
+		t.Errorf("Outputs add up to %d, want %d", sum, total)
+	}
+	share := total * 3 / 7
+	if coinbase.Outputs[1].Value != share || coinbase.Outputs[2].Value != share {
+		t.Errorf("Recipient outputs %d and %d, want %d", coinbase.Outputs[1].Value, coinbase.Outputs[2].Value, share)
+	}
+	if coinbase.Outputs[0].Value != total-2*share {
+		t.Errorf("First recipient got %d, want the remainder %d", coinbase.Outputs[0].Value, total-2*share)
+	}
+
+	// A single payout is the plain coinbase
+	single, _ := mining.CreateSplitCoinbase(1, fees, payouts[:1], 0)
+	plain, _ := mining.CreateCoinbase(1, fees, "pool", 0)
+	if txid(t, single) != txid(t, plain) {
+		t.Error("Single payout differs from CreateCoinbase")
+	}
+
+	// A block without transactions has no fees to pay out
+	block, err := mining.BuildBlock(&mining.BlockTemplate{
+		Version:      1,
+		Transactions: []types.Transaction{*mustSplitCoinbase(t, 1, 0, payouts)},
+		Timestamp:    1700000000,
+		Bits:         0x207fffff,
+		Height:       1,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := validation.NewBlockValidator(utxo.NewUTXOSet())
+	if err := validator.ValidateBlock(block, 1, types.Hash{}); err != nil {
+		t.Errorf("Block with a split coinbase rejected: %v", err)
+	}
+
+	block.Transactions[0] = *coinbase
+	block = rebuildBlock(t, block, 1)
+	if err := validator.ValidateBlock(block, 1, types.Hash{}); err == nil {
+		t.Error("Block paying out more than subsidy plus fees accepted")
+	}
+
+	for name, bad := range map[string][]mining.Payout{
+		"no recipients": nil,
+		"zero share":    {{Address: "pool", Share: 1}, {Address: "alice"}},
+		"dust share":    {{Address: "pool", Share: 1 << 62}, {Address: "alice", Share: 1}},
+	} {
+		if _, err := mining.CreateSplitCoinbase(1, 0, bad, 0); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func mustSplitCoinbase(t *testing.T, height uint64, fees int64, payouts []mining.Payout) *types.Transaction {
+	t.Helper()
+	coinbase, err := mining.CreateSplitCoinbase(height, fees, payouts, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coinbase
+}
@@ -0,0 +1,239 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// contractChain connects blocks through full block validation so
+// contracts can be funded and spent on chain
+type contractChain struct {
+	t         *testing.T
+	validator *validation.BlockValidator
+	prev      types.Hash
+	height    uint64
+	coinbase  types.Hash // Unspent OP_TRUE coinbase of the last block
+}
+
+func newContractChain(t *testing.T) *contractChain {
+	c := &contractChain{t: t, validator: validation.NewBlockValidator(utxo.NewUTXOSet())}
+	if err := c.connect(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// connect validates and applies a block holding txs after a coinbase
+// paying to OP_TRUE
+func (c *contractChain) connect(txs ...types.Transaction) error {
+	c.t.Helper()
+	height := c.height
+	if !c.prev.IsZero() {
+		height++
+	}
+
+	coinbase := types.Transaction{
+		Version: 1,
+		Inputs: []types.TxInput{{
+			OutputIndex:     0xFFFFFFFF,
+			SignatureScript: script.NewBuilder().AddInt(int64(height)).AddOp(script.OP_0).Script(),
+			Sequence:        0xFFFFFFFF,
+		}},
+		Outputs: []types.TxOutput{{Value: validation.GetBlockReward(height), PubKeyScript: []byte{script.OP_TRUE}}},
+	}
+	block, err := mining.BuildBlock(&mining.BlockTemplate{
+		Version:       1,
+		PrevBlockHash: c.prev,
+		Transactions:  append([]types.Transaction{coinbase}, txs...),
+		Timestamp:     uint32(1231006505 + 600*height),
+		Bits:          0x207fffff,
+		Height:        height,
+	}, 0)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	if err := c.validator.ValidateBlock(block, height, c.prev); err != nil {
+		return err
+	}
+	if err := c.validator.ApplyBlock(block, height); err != nil {
+		return err
+	}
+	c.prev = blockHash(c.t, block)
+	c.height = height
+	c.coinbase, _ = serialization.HashTransaction(&block.Transactions[0])
+	return nil
+}
+
+// fund locks the last coinbase's reward in contractScript, returning the
+// contract output
+func (c *contractChain) fund(contractScript []byte) (types.Hash, types.TxOutput) {
+	c.t.Helper()
+	locked := types.TxOutput{Value: validation.GetBlockReward(c.height), PubKeyScript: contractScript}
+	funding := contracts.SpendTx(c.coinbase, 0, locked, 0)
+	if err := c.connect(*funding); err != nil {
+		c.t.Fatalf("Funding rejected: %v", err)
+	}
+	hash, _ := serialization.HashTransaction(funding)
+	return hash, locked
+}
+
+func newContractKey(t *testing.T) (*keys.PrivateKey, []byte) {
+	t.Helper()
+	key, err := keys.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, key.PublicKey().Bytes(true)
+}
+
+func sign(t *testing.T, tx *types.Transaction, key *keys.PrivateKey, contractScript []byte) []byte {
+	t.Helper()
+	sig, err := contracts.Sign(tx, 0, key, contractScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestEscrowContract(t *testing.T) {
+	_, buyer := newContractKey(t)
+	sellerKey, seller := newContractKey(t)
+	arbiterKey, arbiter := newContractKey(t)
+	escrow, err := contracts.EscrowScript(buyer, seller, arbiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := newContractChain(t)
+	funding, locked := chain.fund(escrow)
+	spend := contracts.SpendTx(funding, 0, types.TxOutput{Value: locked.Value, PubKeyScript: []byte{script.OP_TRUE}}, 0)
+
+	// The seller alone can't release the funds, nor sign twice
+	sellerSig := sign(t, spend, sellerKey, escrow)
+	spend.Inputs[0].SignatureScript = contracts.EscrowSpend(sellerSig, sellerSig)
+	if err := contracts.Verify(spend, 0, locked); err == nil {
+		t.Error("One party released the escrow")
+	}
+
+	// Signatures out of key order fail
+	arbiterSig := sign(t, spend, arbiterKey, escrow)
+	spend.Inputs[0].SignatureScript = contracts.EscrowSpend(arbiterSig, sellerSig)
+	if err := contracts.Verify(spend, 0, locked); err == nil {
+		t.Error("Signatures out of order accepted")
+	}
+
+	// Seller and arbiter settle a dispute
+	spend.Inputs[0].SignatureScript = contracts.EscrowSpend(sellerSig, arbiterSig)
+	if err := contracts.Verify(spend, 0, locked); err != nil {
+		t.Fatalf("Seller and arbiter rejected: %v", err)
+	}
+	if err := chain.connect(*spend); err != nil {
+		t.Fatalf("Escrow release rejected by block validation: %v", err)
+	}
+}
+
+func TestRefundContract(t *testing.T) {
+	payerKey, payer := newContractKey(t)
+	payeeKey, payee := newContractKey(t)
+	const refundHeight = 10
+	refund, err := contracts.RefundScript(payer, payee, refundHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := newContractChain(t)
+	funding, locked := chain.fund(refund)
+	payTo := types.TxOutput{Value: locked.Value, PubKeyScript: []byte{script.OP_TRUE}}
+
+	// Together they can spend at once
+	coop := contracts.SpendTx(funding, 0, payTo, 0)
+	coop.Inputs[0].SignatureScript = contracts.RefundCooperativeSpend(sign(t, coop, payerKey, refund), sign(t, coop, payeeKey, refund))
+	if err := contracts.Verify(coop, 0, locked); err != nil {
+		t.Errorf("Cooperative spend rejected: %v", err)
+	}
+
+	// The payer alone has to wait for the lock time
+	early := contracts.SpendTx(funding, 0, payTo, refundHeight-1)
+	early.Inputs[0].SignatureScript = contracts.RefundTimeoutSpend(sign(t, early, payerKey, refund))
+	if err := chain.connect(*early); err == nil {
+		t.Error("Refund before the lock time accepted")
+	}
+
+	// The payee can't use the refund path
+	late := contracts.SpendTx(funding, 0, payTo, refundHeight)
+	late.Inputs[0].SignatureScript = contracts.RefundTimeoutSpend(sign(t, late, payeeKey, refund))
+	if err := contracts.Verify(late, 0, locked); err == nil {
+		t.Error("Payee took the refund")
+	}
+
+	late.Inputs[0].SignatureScript = contracts.RefundTimeoutSpend(sign(t, late, payerKey, refund))
+	if err := contracts.Verify(late, 0, locked); err != nil {
+		t.Fatalf("Refund after the lock time rejected: %v", err)
+	}
+	if err := chain.connect(*late); err != nil {
+		t.Fatalf("Refund rejected by block validation: %v", err)
+	}
+
+	// A final input sequence would switch the lock time off
+	final := contracts.SpendTx(funding, 0, payTo, refundHeight)
+	final.Inputs[0].Sequence = 0xFFFFFFFF
+	final.Inputs[0].SignatureScript = contracts.RefundTimeoutSpend(sign(t, final, payerKey, refund))
+	if err := contracts.Verify(final, 0, locked); err == nil {
+		t.Error("Refund with a final sequence accepted")
+	}
+}
+
+func TestHTLCContract(t *testing.T) {
+	recipientKey, recipient := newContractKey(t)
+	senderKey, sender := newContractKey(t)
+	preimage := []byte("swap secret")
+	const timeout = 20
+	htlc, err := contracts.HTLCScript(recipient, sender, contracts.HashLock(preimage), timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := newContractChain(t)
+	funding, locked := chain.fund(htlc)
+	payTo := types.TxOutput{Value: locked.Value, PubKeyScript: []byte{script.OP_TRUE}}
+
+	// The sender can't refund early
+	refund := contracts.SpendTx(funding, 0, payTo, timeout-1)
+	refund.Inputs[0].SignatureScript = contracts.HTLCRefundSpend(sign(t, refund, senderKey, htlc))
+	if err := contracts.Verify(refund, 0, locked); err == nil {
+		t.Error("Early refund accepted")
+	}
+	refund = contracts.SpendTx(funding, 0, payTo, timeout)
+	refund.Inputs[0].SignatureScript = contracts.HTLCRefundSpend(sign(t, refund, senderKey, htlc))
+	if err := contracts.Verify(refund, 0, locked); err != nil {
+		t.Errorf("Refund after the timeout rejected: %v", err)
+	}
+
+	// The recipient needs the right preimage and their own key
+	claim := contracts.SpendTx(funding, 0, payTo, 0)
+	claim.Inputs[0].SignatureScript = contracts.HTLCClaimSpend(sign(t, claim, recipientKey, htlc), []byte("wrong secret"))
+	if err := chain.connect(*claim); err == nil {
+		t.Error("Claim with the wrong preimage accepted")
+	}
+	claim.Inputs[0].SignatureScript = contracts.HTLCClaimSpend(sign(t, claim, senderKey, htlc), preimage)
+	if err := contracts.Verify(claim, 0, locked); err == nil {
+		t.Error("Sender claimed with the preimage")
+	}
+
+	claim.Inputs[0].SignatureScript = contracts.HTLCClaimSpend(sign(t, claim, recipientKey, htlc), preimage)
+	if err := contracts.Verify(claim, 0, locked); err != nil {
+		t.Fatalf("Claim rejected: %v", err)
+	}
+	if err := chain.connect(*claim); err != nil {
+		t.Fatalf("Claim rejected by block validation: %v", err)
+	}
+}
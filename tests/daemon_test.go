@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/daemon"
+)
+
+func TestPIDFileWrittenAndRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node", "bitcoind.pid")
+
+	if err := daemon.WritePIDFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := daemon.ReadPIDFile(path); err != nil || pid != os.Getpid() {
+		t.Fatalf("PID file holds %d, %v; want %d", pid, err, os.Getpid())
+	}
+
+	// Writing again from the same process is fine
+	if err := daemon.WritePIDFile(path); err != nil {
+		t.Errorf("Rewriting our own PID file: %v", err)
+	}
+
+	daemon.RemovePIDFile(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("PID file still there after removal: %v", err)
+	}
+	if _, err := os.Stat(path + ".new"); !os.IsNotExist(err) {
+		t.Error("Temporary PID file left behind")
+	}
+}
+
+func TestPIDFileOfAnotherProcessIsKept(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bitcoind.pid")
+	other := strconv.Itoa(os.Getppid()) + "\n"
+	if err := os.WriteFile(path, []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removal leaves a file naming someone else
+	daemon.RemovePIDFile(path)
+	if data, err := os.ReadFile(path); err != nil || string(data) != other {
+		t.Fatalf("PID file of another process removed: %q, %v", data, err)
+	}
+
+	// And a live process blocks starting
+	if err := daemon.WritePIDFile(path); !errors.Is(err, daemon.ErrAlreadyRunning) {
+		t.Errorf("Writing over a live process's PID file: error = %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != other {
+		t.Errorf("Live process's PID file overwritten with %q", data)
+	}
+}
+
+func TestStalePIDFileIsReplaced(t *testing.T) {
+	// A process that has exited, as after a crash
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "bitcoind.pid")
+	if err := os.WriteFile(path, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := daemon.WritePIDFile(path); err != nil {
+		t.Fatalf("Stale PID file blocked starting: %v", err)
+	}
+	if pid, _ := daemon.ReadPIDFile(path); pid != os.Getpid() {
+		t.Errorf("PID file holds %d after replacing a stale one", pid)
+	}
+}
@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+)
+
+// dandelionHarness starts numNodes nodes with Dandelion enabled on those
+// for which enabled returns true. Stems never end by chance.
+func dandelionHarness(t *testing.T, numNodes int, embargo time.Duration, enabled func(id int) bool) *testharness.Harness {
+	t.Helper()
+	h, err := testharness.NewWithConfig(numNodes, func(id int, config *network.NodeConfig) {
+		config.EnableDandelion = enabled(id)
+		config.StemFluffProbability = 1e-12
+		config.StemEmbargo = embargo
+	})
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	return h
+}
+
+func TestDandelionStemReachesEveryMempool(t *testing.T) {
+	h := dandelionHarness(t, 3, time.Minute, func(int) bool { return true })
+	defer h.Close()
+
+	if _, err := h.Node(0).MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ConnectAll(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.WaitForSync(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stem follows outbound links 0 -> 1 -> 2; node 2 has no outbound
+	// peer, so it fluffs and the transaction diffuses back
+	tx, err := h.Node(0).SendTo(h.Node(2).Address, 10*100000000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+	if err := h.WaitForMempool(txHash, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	waitUntil(t, "stems to be cleared", func() bool {
+		return h.Node(0).P2P.DandelionStats().Pending == 0 && h.Node(1).P2P.DandelionStats().Pending == 0
+	})
+	for i, want := range []network.DandelionStats{
+		{Stemmed: 1},
+		{Stemmed: 1},
+		{Fluffed: 1},
+	} {
+		if got := h.Node(i).P2P.DandelionStats(); got != want {
+			t.Errorf("Node %d stats = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestDandelionFluffsWithoutStemPeers(t *testing.T) {
+	h := dandelionHarness(t, 2, time.Minute, func(id int) bool { return id == 0 })
+	defer h.Close()
+
+	if _, err := h.Node(0).MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ConnectAll(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.WaitForSync(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Node 1 doesn't relay stem transactions, so node 0 announces at once
+	tx, err := h.Node(0).SendTo(h.Node(1).Address, 100000000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+	if !h.Node(0).P2P.Mempool.Exists(txHash) {
+		t.Error("Transaction with no stem peer was not fluffed")
+	}
+	if err := h.WaitForMempool(txHash, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if stats := h.Node(0).P2P.DandelionStats(); stats != (network.DandelionStats{Fluffed: 1}) {
+		t.Errorf("Stats = %+v", stats)
+	}
+}
+
+func TestDandelionEmbargo(t *testing.T) {
+	h := dandelionHarness(t, 1, 100*time.Millisecond, func(int) bool { return true })
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stem relay that swallows everything
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 100)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			msg, err := protocol.Deserialize(conn)
+			if err != nil {
+				return
+			}
+			received <- msg.Command
+			if msg.Command == protocol.CmdVersion {
+				v := protocol.NewVersionMessage(protocol.NetAddress{}, protocol.NetAddress{}, 9, "/blackhole:0.1/", 0)
+				v.Services |= protocol.SFNodeDandelion
+				payload, _ := v.Serialize()
+				for _, reply := range []*protocol.Message{
+					protocol.NewMessage(protocol.MagicMainnet, protocol.CmdVersion, payload),
+					protocol.NewMessage(protocol.MagicMainnet, protocol.CmdVerAck, nil),
+				} {
+					data, _ := reply.Serialize()
+					conn.Write(data)
+				}
+			}
+		}
+	}()
+
+	go node.P2P.Connect(listener.Addr().String())
+	waitUntil(t, "handshake with the stem relay", func() bool {
+		peers := node.P2P.PeerInfo()
+		return len(peers) == 1 && peers[0].Services&protocol.SFNodeDandelion != 0
+	})
+
+	tx, err := node.SendTo(node.Address, 100000000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+
+	for command := range received {
+		if command == protocol.CmdDandelionTx {
+			break
+		}
+	}
+	if node.P2P.Mempool.Exists(txHash) {
+		t.Error("Stem transaction entered the mempool before the embargo")
+	}
+
+	waitUntil(t, "embargo to fluff the transaction", func() bool { return node.P2P.Mempool.Exists(txHash) })
+	if stats := node.P2P.DandelionStats(); stats != (network.DandelionStats{Stemmed: 1, Fluffed: 1, Embargoed: 1}) {
+		t.Errorf("Stats = %+v", stats)
+	}
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+)
+
+func TestDataDirLock(t *testing.T) {
+	dir := t.TempDir()
+
+	chain, err := storage.NewBlockchainStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.NewBlockchainStorage(dir); !errors.Is(err, storage.ErrDataDirLocked) {
+		t.Fatalf("Second open = %v, want ErrDataDirLocked", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, storage.LockFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("Lock file holds %q, want our PID", data)
+	}
+
+	if err := chain.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, storage.LockFileName)); !os.IsNotExist(err) {
+		t.Error("Close left the lock file behind")
+	}
+
+	chain, err = storage.NewBlockchainStorage(dir)
+	if err != nil {
+		t.Fatalf("Reopen after close: %v", err)
+	}
+	chain.Close()
+}
+
+// holdDirLock takes the lock on path through a file of its own, as another
+// process would, after writing contents to it
+func holdDirLock(t *testing.T, path, contents string) *os.File {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	if _, err := file.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDataDirLockOtherProcess(t *testing.T) {
+	dir := t.TempDir()
+	lockPath := filepath.Join(dir, storage.LockFileName)
+
+	// The owner is named by the PID it wrote
+	parent := os.Getppid()
+	other := holdDirLock(t, lockPath, fmt.Sprintf("%d\n", parent))
+	_, err := storage.LockDataDir(dir)
+	if !errors.Is(err, storage.ErrDataDirLocked) || !strings.Contains(err.Error(), fmt.Sprintf("PID %d", parent)) {
+		t.Fatalf("LockDataDir with live owner = %v", err)
+	}
+
+	// An owner that hasn't written its PID yet still owns the directory,
+	// and its empty lock file is left alone
+	if err := other.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.LockDataDir(dir); !errors.Is(err, storage.ErrDataDirLocked) {
+		t.Fatalf("LockDataDir with an empty held lock file = %v", err)
+	}
+	held, _ := other.Stat()
+	if current, err := os.Stat(lockPath); err != nil || !os.SameFile(held, current) {
+		t.Fatalf("Held lock file removed or replaced: %v", err)
+	}
+	other.Close()
+
+	// Once the owner is gone its lock file is taken over, whatever PID it
+	// names
+	if err := os.WriteFile(lockPath, []byte(fmt.Sprintf("%d\n", parent)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := storage.LockDataDir(dir)
+	if err != nil {
+		t.Fatalf("LockDataDir with stale lock: %v", err)
+	}
+	if data, _ := os.ReadFile(lockPath); strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("Taken over lock file holds %q, want our PID", data)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Errorf("Second release: %v", err)
+	}
+}
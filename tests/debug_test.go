@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func fetch(t *testing.T, url string) (int, string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestDebugHandler(t *testing.T) {
+	ts := httptest.NewServer(monitoring.DebugHandler())
+	defer ts.Close()
+
+	if code, body := fetch(t, ts.URL+"/debug/pprof/"); code != http.StatusOK || !strings.Contains(body, "heap") {
+		t.Errorf("Profile index = %d %q", code, body)
+	}
+	if code, body := fetch(t, ts.URL+"/debug/pprof/heap"); code != http.StatusOK || len(body) == 0 {
+		t.Errorf("Heap profile = %d, %d bytes", code, len(body))
+	}
+	if code, body := fetch(t, ts.URL+"/debug/goroutines"); code != http.StatusOK || !strings.Contains(body, "goroutine ") {
+		t.Errorf("Goroutine dump = %d %q", code, body)
+	}
+	if code, body := fetch(t, ts.URL+"/debug/pprof/profile?seconds=0.05"); code != http.StatusOK || len(body) == 0 {
+		t.Errorf("CPU profile = %d, %d bytes", code, len(body))
+	}
+	if code, _ := fetch(t, ts.URL+"/debug/pprof/profile?seconds=-1"); code != http.StatusBadRequest {
+		t.Errorf("Negative duration = %d, want 400", code)
+	}
+	if code, _ := fetch(t, ts.URL+"/debug/pprof/nosuchprofile"); code != http.StatusNotFound {
+		t.Errorf("Unknown profile = %d, want 404", code)
+	}
+}
+
+func TestGetMemoryInfo(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	cache := utxo.NewUTXOCache(nil)
+	for i := uint32(0); i < 3; i++ {
+		output := types.TxOutput{Value: 1000, PubKeyScript: make([]byte, 25)}
+		cache.Add(utxo.NewUTXO(types.Hash{1}, i, output, 1, false))
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	client := rpc.NewClient(httptestServer(t, server))
+
+	info, err := client.GetMemoryInfo()
+	if err != nil {
+		t.Fatalf("getmemoryinfo failed: %v", err)
+	}
+	if info.Runtime.HeapAlloc == 0 || info.Runtime.NumGoroutine == 0 {
+		t.Errorf("Runtime stats missing: %+v", info.Runtime)
+	}
+	if info.Mempool != nil || info.UTXOCache != nil {
+		t.Errorf("Components reported without a node or cache: %+v", info)
+	}
+
+	server.SetNode(network.NewNode(network.NodeConfig{ListenAddr: "127.0.0.1:0"}, chain))
+	server.SetUTXOCache(cache)
+	info, err = client.GetMemoryInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mempool == nil || info.BlockIndex == nil {
+		t.Fatalf("Node components missing: %+v", info)
+	}
+	if info.UTXOCache == nil || info.UTXOCache.Entries != 3 || info.UTXOCache.Bytes <= 3*25 {
+		t.Errorf("UTXO cache = %+v", info.UTXOCache)
+	}
+}
+
+func httptestServer(t *testing.T, server *rpc.Server) string {
+	t.Helper()
+
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
@@ -0,0 +1,269 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestClassifyScript(t *testing.T) {
+	hash20 := bytes.Repeat([]byte{0x11}, 20)
+	hash32 := bytes.Repeat([]byte{0x22}, 32)
+	pubKey := append([]byte{0x02}, hash32...)
+
+	p2pkh, _ := script.P2PKH(hash20)
+	p2sh, _ := script.P2SH(hash20)
+	p2wpkh, _ := script.WitnessProgram(0, hash20)
+	p2wsh, _ := script.WitnessProgram(0, hash32)
+	p2tr, _ := script.WitnessProgram(1, hash32)
+	future, _ := script.WitnessProgram(2, hash20)
+
+	tests := []struct {
+		script []byte
+		want   script.ScriptClass
+	}{
+		{p2pkh, script.PubKeyHashTy},
+		{p2sh, script.ScriptHashTy},
+		{p2wpkh, script.WitnessV0KeyHashTy},
+		{p2wsh, script.WitnessV0ScriptHashTy},
+		{p2tr, script.WitnessV1TaprootTy},
+		{future, script.WitnessUnknownTy},
+		{script.NewBuilder().AddData(pubKey).AddOp(script.OP_CHECKSIG).Script(), script.PubKeyTy},
+		{script.NewBuilder().AddOp(script.OP_1).AddData(pubKey).AddData(pubKey).AddOp(script.OP_2).AddOp(script.OP_CHECKMULTISIG).Script(), script.MultiSigTy},
+		{script.NewBuilder().AddOp(script.OP_RETURN).AddData([]byte("hello")).Script(), script.NullDataTy},
+		{[]byte{script.OP_0, 0x03, 1, 2, 3}, script.NonStandardTy}, // v0 program of the wrong length
+		{[]byte{script.OP_DUP}, script.NonStandardTy},
+	}
+	for i, tt := range tests {
+		if got := script.ClassifyScript(tt.script); got != tt.want {
+			t.Errorf("Script %d: ClassifyScript = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestScriptAsm(t *testing.T) {
+	hash20 := bytes.Repeat([]byte{0xab}, 20)
+	p2pkh, _ := script.P2PKH(hash20)
+
+	tests := []struct {
+		script []byte
+		want   string
+	}{
+		{p2pkh, "OP_DUP OP_HASH160 " + hex.EncodeToString(hash20) + " OP_EQUALVERIFY OP_CHECKSIG"},
+		{[]byte{script.OP_0, script.OP_1NEGATE, script.OP_16}, "0 -1 16"},
+		{[]byte{0x02, 0xaa}, "[error]"},
+		{nil, ""},
+	}
+	for i, tt := range tests {
+		if got := script.Asm(tt.script); got != tt.want {
+			t.Errorf("Script %d: Asm = %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestAddressFromScript(t *testing.T) {
+	for _, address := range []string{
+		"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2",
+		"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy",
+		"bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq",
+		"bc1p5d7rjq7g6rdk2yhzks9smlaqtedr4dekq08ge8ztwac72sfr9rusxg3297",
+	} {
+		decoded, err := keys.DecodeAddressForNetwork(address, keys.MainNetParams)
+		if err != nil {
+			t.Fatalf("%s: %v", address, err)
+		}
+		pkScript, err := decoded.Script()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := keys.AddressFromScript(pkScript, keys.MainNetParams)
+		if err != nil || addr.String() != address {
+			t.Errorf("AddressFromScript(%s) = %v, %v", address, addr, err)
+		}
+	}
+
+	nullData := script.NewBuilder().AddOp(script.OP_RETURN).AddData([]byte("x")).Script()
+	if _, err := keys.AddressFromScript(nullData, keys.MainNetParams); err == nil {
+		t.Error("AddressFromScript accepted a null data script")
+	}
+}
+
+func TestDecodeRawTransactionRPC(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	tx, err := node.SendTo(node.Address, 1000, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := serialization.SerializeTransactionWitness(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+
+	decoded, err := client.DecodeRawTransaction(hex.EncodeToString(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.TxID != txHash.String() || decoded.Size != len(raw) || len(decoded.Vin) != len(tx.Inputs) {
+		t.Errorf("Decoded transaction: %+v", decoded)
+	}
+	if decoded.Fee == nil || *decoded.Fee != 500 {
+		t.Errorf("Fee = %v, want 500", decoded.Fee)
+	}
+	for _, in := range decoded.Vin {
+		if in.PrevOut == nil || in.PrevOut.ScriptPubKey.Address != node.Address {
+			t.Errorf("Input prevout: %+v", in.PrevOut)
+		}
+	}
+	out := decoded.Vout[0].ScriptPubKey
+	if out.Type != "pubkeyhash" || out.Address != node.Address || out.Asm == "" {
+		t.Errorf("Output script: %+v", out)
+	}
+
+	if _, err := client.DecodeRawTransaction(hex.EncodeToString(raw[:len(raw)-1])); err == nil {
+		t.Error("Truncated transaction decoded")
+	}
+}
+
+func TestDecodeBlockRPC(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	block, err := node.Chain.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := serialization.SerializeBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := client.DecodeBlock(hex.EncodeToString(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Height == nil || *decoded.Height != 1 || decoded.NTx != len(block.Transactions) || decoded.Size != len(raw) {
+		t.Errorf("Decoded block: %+v", decoded)
+	}
+
+	coinbase := decoded.Tx[0]
+	if coinbase.Vin[0].Coinbase == "" || coinbase.Vin[0].ScriptSig != nil || coinbase.Fee != nil {
+		t.Errorf("Coinbase input: %+v", coinbase.Vin[0])
+	}
+}
+
+func TestGetBlockVerbosity(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	block, err := node.Chain.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := serialization.HashBlockHeader(&block.Header)
+	raw, err := serialization.SerializeBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := client.GetRawBlock(hash.String()); err != nil || data != hex.EncodeToString(raw) {
+		t.Errorf("Raw block = %.32s..., %v", data, err)
+	}
+
+	byHash, err := client.GetBlockByHash(hash.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	byHeight, err := client.GetBlock(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byHash.Hash != hash.String() || byHash.Height != 1 || byHash.Confirmations != 2 || len(byHash.Transactions) != len(block.Transactions) {
+		t.Errorf("Block by hash: %+v", byHash)
+	}
+	if byHeight.Hash != byHash.Hash || byHeight.Confirmations != byHash.Confirmations {
+		t.Errorf("Block by height %+v differs from by hash %+v", byHeight, byHash)
+	}
+
+	decoded, err := client.GetBlockVerbose(hash.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Height == nil || *decoded.Height != 1 || decoded.Confirmations != 2 || decoded.Size != len(raw) || len(decoded.Tx) != len(block.Transactions) {
+		t.Errorf("Decoded block: %+v", decoded)
+	}
+
+	// A stored side-chain block is found by hash
+	side := blockOn(t, block.Header.PrevBlockHash, 1, 99)
+	if err := node.Chain.SaveSideBlock(side, 1); err != nil {
+		t.Fatal(err)
+	}
+	sideHash, _ := serialization.HashBlockHeader(&side.Header)
+	if info, err := client.GetBlockByHash(sideHash.String()); err != nil || info.Confirmations != -1 || info.Height != 1 {
+		t.Errorf("Side block = %+v, %v", info, err)
+	}
+
+	if _, err := client.GetBlockByHash(types.Hash{1}.String()); err == nil {
+		t.Error("Unknown block found")
+	}
+}
+
+func TestGetBlockHeaderRPC(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	genesis, _ := node.Chain.GetBlockByHeight(0)
+	block, _ := node.Chain.GetBlockByHeight(1)
+	next, _ := node.Chain.GetBlockByHeight(2)
+	genesisHash, _ := serialization.HashBlockHeader(&genesis.Header)
+	hash, _ := serialization.HashBlockHeader(&block.Header)
+	nextHash, _ := serialization.HashBlockHeader(&next.Header)
+
+	if got, err := client.GetBlockHash(1); err != nil || got != hash.String() {
+		t.Errorf("GetBlockHash(1) = %s, %v", got, err)
+	}
+
+	header, err := client.GetBlockHeader(hash.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Height != 1 || header.Confirmations != 2 || header.NTx != len(block.Transactions) {
+		t.Errorf("Header: %+v", header)
+	}
+	if header.PrevHash != genesisHash.String() || header.NextHash != nextHash.String() {
+		t.Errorf("Header links %s <- -> %s", header.PrevHash, header.NextHash)
+	}
+	work := new(big.Int).Mul(consensus.CalcWork(block.Header.Bits), big.NewInt(2))
+	if header.ChainWork != fmt.Sprintf("%064x", work) {
+		t.Errorf("Chain work = %s, want %064x", header.ChainWork, work)
+	}
+
+	tip, err := client.GetBlockHeader(nextHash.String())
+	if err != nil || tip.NextHash != "" || tip.Confirmations != 1 {
+		t.Errorf("Tip header = %+v, %v", tip, err)
+	}
+	raw, _ := serialization.SerializeBlockHeader(&block.Header)
+	if data, err := client.GetBlockHeaderHex(hash.String()); err != nil || data != hex.EncodeToString(raw) {
+		t.Errorf("Header hex = %s, %v", data, err)
+	}
+
+	// A side-chain block has no successor and counts its own branch's work
+	side := blockOn(t, genesisHash, 1, 99)
+	if err := node.Chain.SaveSideBlock(side, 1); err != nil {
+		t.Fatal(err)
+	}
+	sideHash, _ := serialization.HashBlockHeader(&side.Header)
+	sideHeader, err := client.GetBlockHeader(sideHash.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sideHeader.Confirmations != -1 || sideHeader.NextHash != "" || sideHeader.ChainWork != header.ChainWork {
+		t.Errorf("Side header: %+v", sideHeader)
+	}
+}
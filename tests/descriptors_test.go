@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/miniscript"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestDescriptorChecksum(t *testing.T) {
+	// BIP380's example
+	if sum, err := miniscript.DescriptorChecksum("raw(deadbeef)"); err != nil || sum != "89f8spxm" {
+		t.Errorf("checksum of raw(deadbeef) = %s, %v", sum, err)
+	}
+	if body, err := miniscript.VerifyChecksum("raw(deadbeef)#89f8spxm", true); err != nil || body != "raw(deadbeef)" {
+		t.Errorf("VerifyChecksum = %s, %v", body, err)
+	}
+	for _, bad := range []string{"raw(deadbeef)#89f8spxx", "raw(deedbeef)#89f8spxm", "raw(deadbeef)#89f8spx", "raw(deadbeef)"} {
+		if _, err := miniscript.VerifyChecksum(bad, true); !errors.Is(err, miniscript.ErrChecksum) {
+			t.Errorf("VerifyChecksum(%s) = %v", bad, err)
+		}
+	}
+	if _, err := miniscript.DescriptorChecksum("pkh(é)"); err == nil {
+		t.Error("Checksummed a character outside the descriptor charset")
+	}
+
+	desc := fmt.Sprintf("wsh(pk(%x))", fixedKey(t, 1).PublicKey().Bytes(true))
+	withSum, err := miniscript.AddChecksum(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := miniscript.ParseDescriptor(withSum); err != nil {
+		t.Errorf("ParseDescriptor with checksum: %v", err)
+	}
+	if _, err := miniscript.ParseDescriptor(strings.Replace(withSum, "wsh(", "wsh (", 1)); !errors.Is(err, miniscript.ErrChecksum) {
+		t.Errorf("ParseDescriptor with a wrong checksum = %v", err)
+	}
+}
+
+func TestListDescriptors(t *testing.T) {
+	seed := unhex(t, "000102030405060708090a0b0c0d0e0f")
+	w := wallet.NewWallet()
+	if err := w.SetHDSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := w.GenerateAddress(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	imported := fixedKey(t, 3)
+	if _, err := w.ImportPrivateKey(imported.ToWIF(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	master, _ := keys.NewMasterKey(seed)
+	account, _ := master.Derive(keys.HardenedKeyStart+44, keys.HardenedKeyStart, keys.HardenedKeyStart)
+	fp := master.Fingerprint()
+	origin := fmt.Sprintf("[%x/44h/0h/0h]", fp)
+	tests := []struct {
+		private bool
+		keys    []string // The key of each descriptor
+	}{
+		{false, []string{origin + account.PublicString(keys.MainNetParams), fmt.Sprintf("%x", imported.PublicKey().Bytes(true))}},
+		{true, []string{origin + account.String(keys.MainNetParams), imported.ToWIF(true)}},
+	}
+	for _, tt := range tests {
+		descs, err := w.ListDescriptors(tt.private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []wallet.WalletDescriptor{
+			{Desc: "pkh(" + tt.keys[0] + "/0/*)", Active: true, Next: 2},
+			{Desc: "pkh(" + tt.keys[0] + "/1/*)", Active: true, Internal: true},
+			{Desc: "pkh(" + tt.keys[1] + ")"},
+		}
+		if len(descs) != len(want) {
+			t.Fatalf("private=%t: %d descriptors, want %d: %+v", tt.private, len(descs), len(want), descs)
+		}
+		for i, desc := range descs {
+			body, err := miniscript.VerifyChecksum(desc.Desc, true)
+			if err != nil {
+				t.Errorf("%s: %v", desc.Desc, err)
+			}
+			desc.Desc = body
+			if desc != want[i] {
+				t.Errorf("private=%t: descriptor %d = %+v, want %+v", tt.private, i, desc, want[i])
+			}
+		}
+	}
+
+	// The well-known serialization of the master key
+	if xprv := master.String(keys.MainNetParams); xprv != "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi" {
+		t.Errorf("master xprv %s", xprv)
+	}
+	child, _ := master.Child(keys.HardenedKeyStart)
+	if xpub := child.PublicString(keys.MainNetParams); xpub != "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw" {
+		t.Errorf("m/0h xpub %s", xpub)
+	}
+
+	if err := w.EncryptWallet("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.ListDescriptors(false); err != wallet.ErrWalletLocked {
+		t.Errorf("ListDescriptors while locked = %v", err)
+	}
+	if err := w.Unlock("secret", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	descs, err := w.ListDescriptors(false)
+	if err != nil || len(descs) != 3 {
+		t.Fatalf("ListDescriptors after unlock = %d, %v", len(descs), err)
+	}
+	for _, desc := range descs {
+		if strings.Contains(desc.Desc, "xprv") || strings.Contains(desc.Desc, imported.ToWIF(true)) {
+			t.Errorf("Public descriptor %s holds a private key", desc.Desc)
+		}
+	}
+}
+
+func TestListDescriptorsRPC(t *testing.T) {
+	_, _, client := walletRPCNode(t)
+
+	descs, err := client.ListDescriptors(false)
+	if err != nil || len(descs) == 0 {
+		t.Fatalf("ListDescriptors = %v, %v", descs, err)
+	}
+	info, err := client.GetDescriptorInfo(descs[0].Desc)
+	if err != nil || info.Descriptor != descs[0].Desc {
+		t.Errorf("GetDescriptorInfo(%s) = %+v, %v", descs[0].Desc, info, err)
+	}
+	if _, err := client.GetDescriptorInfo(strings.Replace(info.Descriptor, "pkh(", "pkh( ", 1)); err == nil {
+		t.Error("GetDescriptorInfo accepted a wrong checksum")
+	}
+}
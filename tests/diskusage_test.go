@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+)
+
+// Disk usage counts each kind of entry; compaction moves them all into
+// table files, where their compressed size can be estimated. The
+// estimate goes by data block, so tiny key spaces can show none.
+func TestDiskUsage(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(4); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	for height := uint64(0); height <= 4; height++ {
+		block, err := node.Chain.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := chain.SaveBlock(block, height); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := chain.GetDiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Total <= 0 || len(usage.KeySpaces) != len(storage.KeySpaces) {
+		t.Fatalf("Usage = %+v", usage)
+	}
+	entries := make(map[string]int64)
+	for _, space := range usage.KeySpaces {
+		entries[space.Name] = space.Entries
+		if space.Entries > 0 && space.Bytes <= 0 {
+			t.Errorf("%s: %d entries in %d bytes", space.Name, space.Entries, space.Bytes)
+		}
+	}
+	for name, want := range map[string]int64{"blocks": 5, "height_index": 5, "tx_index": 5, "block_heights": 5, "chainstate": 2, "spent_index": 0} {
+		if entries[name] != want {
+			t.Errorf("%s: %d entries, want %d", name, entries[name], want)
+		}
+	}
+
+	before, after, err := chain.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before <= 0 || after <= 0 {
+		t.Errorf("Compacted from %d to %d bytes", before, after)
+	}
+	usage, err = chain.GetDiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var onDisk int64
+	for _, space := range usage.KeySpaces {
+		onDisk += space.DiskBytes
+	}
+	if onDisk <= 0 {
+		t.Errorf("Nothing in table files after compaction: %+v", usage)
+	}
+
+	server := rpc.NewServer(node.Wallet, node.Chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	resp, err := client.GetDiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TotalBytes <= 0 || resp.KeySpaces[0].Entries != 5 {
+		t.Errorf("getdiskusage = %+v", resp)
+	}
+	if _, err := client.CompactDB(); err != nil {
+		t.Error(err)
+	}
+}
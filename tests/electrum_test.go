@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/electrum"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+)
+
+// electrumConn is a line-based JSON-RPC connection to an Electrum server
+type electrumConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+// call sends a request and decodes its result into result
+func (ec *electrumConn) call(t *testing.T, method string, result interface{}, params ...interface{}) *electrum.Error {
+	t.Helper()
+
+	ec.nextID++
+	req, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": ec.nextID, "method": method, "params": params})
+	if _, err := ec.conn.Write(append(req, '\n')); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *electrum.Error `json:"error"`
+	}
+	ec.read(t, &resp)
+	if resp.ID != ec.nextID {
+		t.Fatalf("%s: response id %d, want %d", method, resp.ID, ec.nextID)
+	}
+	if resp.Error == nil && result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			t.Fatalf("%s: bad result %s: %v", method, resp.Result, err)
+		}
+	}
+	return resp.Error
+}
+
+// read decodes the next message from the server
+func (ec *electrumConn) read(t *testing.T, v interface{}) {
+	t.Helper()
+
+	ec.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := ec.reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("Failed to read from server: %v", err)
+	}
+	if err := json.Unmarshal(line, v); err != nil {
+		t.Fatalf("Bad message %s: %v", line, err)
+	}
+}
+
+// addressScriptHash returns the Electrum script hash of an address
+func addressScriptHash(t *testing.T, address string) string {
+	t.Helper()
+
+	addr, err := keys.DecodeAddressForNetwork(address, keys.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := addr.Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return electrum.ScriptHash(pkScript)
+}
+
+func TestElectrumServer(t *testing.T) {
+	h, err := testharness.New(2)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	miner, payee := h.Node(0), h.Node(1)
+	if _, err := miner.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	server := electrum.NewServer(miner.Chain, miner.P2P.Mempool)
+	server.SetPollInterval(20 * time.Millisecond)
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ec := &electrumConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	var version []string
+	if rpcErr := ec.call(t, "server.version", &version, "test", "1.4"); rpcErr != nil || len(version) != 2 {
+		t.Fatalf("server.version = %v, %v", version, rpcErr)
+	}
+
+	var header electrum.Header
+	if rpcErr := ec.call(t, "blockchain.headers.subscribe", &header); rpcErr != nil {
+		t.Fatalf("headers.subscribe failed: %v", rpcErr)
+	}
+	if header.Height != 3 || len(header.Hex) != 160 {
+		t.Errorf("Tip header = %+v, want height 3 with an 80-byte header", header)
+	}
+
+	// The miner's coinbases are confirmed
+	minerHash := addressScriptHash(t, miner.Address)
+	var balance electrum.Balance
+	if rpcErr := ec.call(t, "blockchain.scripthash.get_balance", &balance, minerHash); rpcErr != nil {
+		t.Fatalf("get_balance failed: %v", rpcErr)
+	}
+	if balance.Confirmed != 3*5000000000 || balance.Unconfirmed != 0 {
+		t.Errorf("Miner balance = %+v, want 3 subsidies confirmed", balance)
+	}
+
+	// A payment shows up unconfirmed, then confirmed once mined
+	tx, err := miner.SendTo(payee.Address, 100000000, 10000)
+	if err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	payeeHash := addressScriptHash(t, payee.Address)
+	if rpcErr := ec.call(t, "blockchain.scripthash.get_balance", &balance, payeeHash); rpcErr != nil {
+		t.Fatal(rpcErr)
+	}
+	if balance.Confirmed != 0 || balance.Unconfirmed != 100000000 {
+		t.Errorf("Payee balance before mining = %+v", balance)
+	}
+	var history []electrum.HistoryEntry
+	ec.call(t, "blockchain.scripthash.get_history", &history, payeeHash)
+	if len(history) != 1 || history[0].Height != 0 || history[0].TxHash != txid(t, tx).String() {
+		t.Errorf("Payee history before mining = %+v", history)
+	}
+
+	if _, err := miner.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribers hear about the new block
+	var note struct {
+		Method string            `json:"method"`
+		Params []electrum.Header `json:"params"`
+	}
+	ec.read(t, &note)
+	if note.Method != "blockchain.headers.subscribe" || len(note.Params) != 1 || note.Params[0].Height != 4 {
+		t.Errorf("Header notification = %+v", note)
+	}
+
+	ec.call(t, "blockchain.scripthash.get_balance", &balance, payeeHash)
+	if balance.Confirmed != 100000000 || balance.Unconfirmed != 0 {
+		t.Errorf("Payee balance after mining = %+v", balance)
+	}
+	ec.call(t, "blockchain.scripthash.get_history", &history, payeeHash)
+	if len(history) != 1 || history[0].Height != 4 {
+		t.Errorf("Payee history after mining = %+v", history)
+	}
+	var unspent []electrum.UnspentEntry
+	ec.call(t, "blockchain.scripthash.listunspent", &unspent, payeeHash)
+	if len(unspent) != 1 || unspent[0].Value != 100000000 || unspent[0].Height != 4 ||
+		unspent[0].TxHash != txid(t, tx).String() {
+		t.Errorf("Payee unspent = %+v", unspent)
+	}
+
+	// The spent coinbase left the miner's unspent list
+	ec.call(t, "blockchain.scripthash.listunspent", &unspent, minerHash)
+	for _, u := range unspent {
+		if u.TxHash == tx.Inputs[0].PrevTxHash.String() && u.TxPos == tx.Inputs[0].OutputIndex {
+			t.Errorf("Spent coin %s:%d still listed", u.TxHash, u.TxPos)
+		}
+	}
+
+	// Bad requests get JSON-RPC errors
+	if rpcErr := ec.call(t, "blockchain.scripthash.get_balance", nil, "not-a-hash"); rpcErr == nil {
+		t.Error("Invalid script hash accepted")
+	}
+	if rpcErr := ec.call(t, "blockchain.block.header", nil, 1); rpcErr == nil {
+		t.Error("Unknown method accepted")
+	} else if rpcErr.Code != -32601 {
+		t.Errorf("Unknown method: got code %d, want -32601", rpcErr.Code)
+	}
+}
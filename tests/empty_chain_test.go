@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestEmptyChain(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	if count, err := chain.GetBlockCount(); err != nil || count != 0 {
+		t.Errorf("GetBlockCount = %d, %v, want 0", count, err)
+	}
+	if _, _, err := chain.GetTip(); !errors.Is(err, storage.ErrEmptyChain) {
+		t.Errorf("GetTip error = %v", err)
+	}
+	if _, _, err := chain.GetBestBlock(); !errors.Is(err, storage.ErrEmptyChain) {
+		t.Errorf("GetBestBlock error = %v", err)
+	}
+
+	validator := validation.NewChainValidator(chain, utxo.NewUTXOSet())
+	if locator, err := validator.GetBlockLocator(); err != nil || len(locator) != 0 {
+		t.Errorf("Empty locator = %v, %v", locator, err)
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	if count := blockCount(t, ts.URL); count.Height != 0 || count.Blocks != 0 {
+		t.Errorf("getblockcount on an empty chain = %+v", count)
+	}
+	if _, err := client.GetBlockHash(0); err == nil || !strings.Contains(err.Error(), "chain is empty") {
+		t.Errorf("getblockhash 0 on an empty chain: %v", err)
+	}
+	if _, err := client.GetChainTxStats(0, ""); err == nil {
+		t.Error("getchaintxstats on an empty chain succeeded")
+	}
+
+	// Genesis alone is a chain of one block at height 0
+	genesis := blockOn(t, types.Hash{}, 0, 0)
+	if err := chain.SaveBlock(genesis, 0); err != nil {
+		t.Fatal(err)
+	}
+	genesisHash, _ := serialization.HashBlockHeader(&genesis.Header)
+	if count, err := chain.GetBlockCount(); err != nil || count != 1 {
+		t.Errorf("GetBlockCount = %d, %v, want 1", count, err)
+	}
+	if hash, height, err := chain.GetTip(); err != nil || hash != genesisHash || height != 0 {
+		t.Errorf("GetTip = %s, %d, %v", hash, height, err)
+	}
+	if locator, err := validator.GetBlockLocator(); err != nil || len(locator) != 1 || locator[0] != genesisHash {
+		t.Errorf("Genesis locator = %v, %v", locator, err)
+	}
+	if count := blockCount(t, ts.URL); count.Height != 0 || count.Blocks != 1 {
+		t.Errorf("getblockcount with genesis = %+v", count)
+	}
+}
+
+// blockCount calls /getblockcount directly, as the client only returns
+// the height
+func blockCount(t *testing.T, url string) rpc.BlockCountResponse {
+	t.Helper()
+	resp, err := http.Get(url + "/getblockcount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Result rpc.BlockCountResponse `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Result
+}
+
+func TestBlockLocatorSpacing(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	blocks := buildBranch(t, types.Hash{}, 0, 40, 0)
+	for i, block := range blocks {
+		if err := chain.SaveBlock(block, uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	locator, err := validation.NewChainValidator(chain, utxo.NewUTXOSet()).GetBlockLocator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Eleven single steps from the tip, then doubling gaps, then genesis
+	var want []types.Hash
+	for _, height := range []int{39, 38, 37, 36, 35, 34, 33, 32, 31, 30, 29, 27, 23, 15, 0} {
+		want = append(want, blockHash(t, blocks[height]))
+	}
+	if len(locator) != len(want) {
+		t.Fatalf("Locator has %d entries, want %d", len(locator), len(want))
+	}
+	for i := range want {
+		if locator[i] != want[i] {
+			t.Errorf("Locator entry %d = %s, want %s", i, locator[i], want[i])
+		}
+	}
+}
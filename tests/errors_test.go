@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rest"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+func TestStorageNotFoundErrors(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	cv := validation.NewChainValidator(chain, utxo.NewUTXOSet())
+	for _, block := range buildBranch(t, types.Hash{}, 0, 2, 0) {
+		if err := cv.AcceptBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	missing := types.Hash{0xee}
+	if _, err := chain.GetBlock(missing); !errors.Is(err, storage.ErrBlockNotFound) {
+		t.Errorf("GetBlock error = %v", err)
+	}
+	if _, err := chain.GetRawBlock(missing); !errors.Is(err, storage.ErrBlockNotFound) {
+		t.Errorf("GetRawBlock error = %v", err)
+	}
+	if _, err := chain.GetBlockByHeight(5); !errors.Is(err, storage.ErrBlockNotFound) {
+		t.Errorf("GetBlockByHeight error = %v", err)
+	}
+	if _, err := chain.GetBlockHeight(missing); !errors.Is(err, storage.ErrBlockNotFound) {
+		t.Errorf("GetBlockHeight error = %v", err)
+	}
+	if _, _, err := chain.GetTransactionLocation(missing); !errors.Is(err, storage.ErrTxNotFound) {
+		t.Errorf("GetTransactionLocation error = %v", err)
+	}
+
+	srv := httptest.NewServer(rest.NewServer(chain, nil).Handler())
+	defer srv.Close()
+	for _, path := range []string{
+		"/rest/block/" + missing.String() + ".hex",
+		"/rest/headers/1/" + missing.String() + ".json",
+		"/rest/tx/" + missing.String() + ".hex",
+	} {
+		if code, _ := restGet(t, srv, path); code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want %d", path, code, http.StatusNotFound)
+		}
+	}
+
+	// An unknown parent is an orphan, not an invalid block
+	orphan := blockOn(t, types.Hash{0xee}, 3, 3)
+	err = cv.AcceptBlock(orphan)
+	if !errors.Is(err, validation.ErrOrphanBlock) {
+		t.Errorf("AcceptBlock error = %v, want ErrOrphanBlock", err)
+	}
+	if validation.IsRuleError(err) {
+		t.Error("Orphan reported as a rule violation")
+	}
+}
+
+func TestRuleErrorReasons(t *testing.T) {
+	validator := validation.NewBlockValidator(utxo.NewUTXOSet())
+
+	first := preBIP34Block(t, types.Hash{}, 0)
+	if err := validator.ValidateBlock(first, 0, types.Hash{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.ApplyBlock(first, 0); err != nil {
+		t.Fatal(err)
+	}
+	prev := blockHash(t, first)
+
+	duplicate := preBIP34Block(t, prev, 1)
+	err := validator.ValidateBlock(duplicate, 1, prev)
+	if reason := validation.RejectReason(err); reason != validation.RejectBIP30 {
+		t.Errorf("Duplicate coinbase reason = %q (%v)", reason, err)
+	}
+
+	spend := types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: types.Hash{0xee}, SignatureScript: []byte{0x51}, Sequence: 0xFFFFFFFF}},
+		Outputs: []types.TxOutput{{Value: 1000, PubKeyScript: []byte{0x51}}},
+	}
+	missingInput := preBIP34Block(t, prev, 1, spend)
+	missingInput.Transactions[0].Inputs[0].SignatureScript = []byte{0x04, 0xff, 0xff, 0x00, 0x1d, 0x01}
+	missingInput = rebuildBlock(t, missingInput, 1)
+	err = validator.ValidateBlock(missingInput, 1, prev)
+	if !validation.IsRuleError(err) {
+		t.Fatalf("Missing input error = %v, want a rule violation", err)
+	}
+	if reason := validation.RejectReason(err); reason != validation.RejectMissingInputs {
+		t.Errorf("Missing input reason = %q (%v)", reason, err)
+	}
+	if errors.Is(err, validation.ErrOrphanBlock) {
+		t.Error("Missing input reported as an orphan")
+	}
+}
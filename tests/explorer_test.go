@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/explorer"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestExplorerBlockByHeightAndHash(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(filepath.Join(t.TempDir(), "chain"))
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer chain.Close()
+
+	coinbase, err := mining.CreateCoinbase(0, 0, "explorer", 0)
+	if err != nil {
+		t.Fatalf("Failed to create coinbase: %v", err)
+	}
+	block := &types.Block{
+		Header:       types.BlockHeader{Version: 1, Timestamp: 1231006505, Bits: 0x1d00ffff},
+		Transactions: []types.Transaction{*coinbase},
+	}
+	if err := chain.SaveBlock(block, 0); err != nil {
+		t.Fatalf("Failed to save block: %v", err)
+	}
+	blockHash, _ := chain.GetBlockHash(block)
+
+	server := httptest.NewServer(explorer.NewExplorer(chain, nil, nil).Handler())
+	defer server.Close()
+
+	for _, path := range []string{"height/0", "block/" + blockHash.String()} {
+		resp, err := http.Get(server.URL + explorer.PathPrefix + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+
+		var body struct {
+			Result explorer.Block `json:"result"`
+			Error  string         `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %v", path, err)
+		}
+
+		if body.Error != "" {
+			t.Fatalf("GET %s returned error: %s", path, body.Error)
+		}
+		if body.Result.Hash != blockHash.String() {
+			t.Errorf("GET %s hash = %s, want %s", path, body.Result.Hash, blockHash)
+		}
+		if len(body.Result.Transactions) != 1 || !body.Result.Transactions[0].Coinbase {
+			t.Errorf("GET %s should return the decoded coinbase", path)
+		}
+	}
+
+	// Unknown heights are reported as 404
+	resp, err := http.Get(server.URL + explorer.PathPrefix + "height/5")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Missing block status = %d, want 404", resp.StatusCode)
+	}
+}
+
+// getExplorer fetches an explorer path and decodes its result into result
+func getExplorer(t *testing.T, server *httptest.Server, path string, result interface{}) int {
+	t.Helper()
+	resp, err := http.Get(server.URL + explorer.PathPrefix + path)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	body := struct {
+		Result interface{} `json:"result"`
+		Error  string      `json:"error"`
+	}{Result: result}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode %s: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+func TestExplorerAddressPageUsesAddressIndex(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(filepath.Join(t.TempDir(), "chain"))
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer chain.Close()
+
+	address, err := wallet.NewWallet().GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := keys.DecodeAddress(address)
+	lockingScript, _ := script.P2PKH(addr.Hash())
+	other := []byte{script.OP_TRUE}
+
+	server := httptest.NewServer(explorer.NewExplorer(chain, nil, nil).Handler())
+	defer server.Close()
+	var page explorer.AddressPage
+	if status := getExplorer(t, server, "address/"+address, &page); status != http.StatusServiceUnavailable {
+		t.Errorf("Address page without the index: status %d, want 503", status)
+	}
+	chain.EnableAddressIndex()
+
+	// The genesis coinbase pays the address, block 1 spends it, paying
+	// 1000 elsewhere and the rest back as change, and block 2 spends the
+	// change
+	coinbase, err := mining.CreateCoinbase(0, 0, address, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase.Outputs[0].PubKeyScript = lockingScript
+	reward := coinbase.Outputs[0].Value
+	genesis := &types.Block{
+		Header:       types.BlockHeader{Version: 1, Timestamp: 1231006505, Bits: 0x1d00ffff},
+		Transactions: []types.Transaction{*coinbase},
+	}
+	if err := chain.SaveBlock(genesis, 0); err != nil {
+		t.Fatal(err)
+	}
+	coinbaseHash, _ := serialization.HashTransaction(coinbase)
+
+	pay := types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: coinbaseHash, OutputIndex: 0, Sequence: 0xFFFFFFFF}},
+		Outputs: []types.TxOutput{{Value: 1000, PubKeyScript: other}, {Value: reward - 1000, PubKeyScript: lockingScript}},
+	}
+	payHash, _ := serialization.HashTransaction(&pay)
+	block1 := nullDataBlock(t, blockHash(t, genesis), 1, []byte("pay"))
+	block1.Transactions = []types.Transaction{block1.Transactions[0], pay}
+	if err := chain.SaveBlock(block1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	spend := types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: payHash, OutputIndex: 1, Sequence: 0xFFFFFFFF}},
+		Outputs: []types.TxOutput{{Value: reward - 1000, PubKeyScript: other}},
+	}
+	spendHash, _ := serialization.HashTransaction(&spend)
+	block2 := nullDataBlock(t, blockHash(t, block1), 2, []byte("spend"))
+	block2.Transactions = []types.Transaction{block2.Transactions[0], spend}
+	if err := chain.SaveBlock(block2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	page = explorer.AddressPage{}
+	if status := getExplorer(t, server, "address/"+address, &page); status != http.StatusOK {
+		t.Fatalf("Address page status %d", status)
+	}
+	if page.Received != 2*reward-1000 || page.Balance != 0 || page.TxCount != 3 {
+		t.Errorf("Received %d, balance %d, %d transactions; want %d, 0, 3", page.Received, page.Balance, page.TxCount, 2*reward-1000)
+	}
+	want := []string{spendHash.String(), payHash.String(), coinbaseHash.String()}
+	if len(page.Transactions) != len(want) {
+		t.Fatalf("Transactions = %v, want %v", page.Transactions, want)
+	}
+	for i := range want {
+		if page.Transactions[i] != want[i] {
+			t.Errorf("Transaction %d = %s, want %s", i, page.Transactions[i], want[i])
+		}
+	}
+
+	// Reorganizing block 2 away leaves the change unspent
+	replacement := nullDataBlock(t, blockHash(t, block1), 2, []byte("replaced"))
+	replacement.Transactions = replacement.Transactions[:1]
+	if err := chain.SwitchChain(1, []*types.Block{replacement}); err != nil {
+		t.Fatal(err)
+	}
+	page = explorer.AddressPage{}
+	getExplorer(t, server, "address/"+address, &page)
+	if page.Balance != reward-1000 || page.TxCount != 2 {
+		t.Errorf("After reorg: balance %d, %d transactions; want %d, 2", page.Balance, page.TxCount, reward-1000)
+	}
+}
+
+func TestExplorerRichListSortsByValue(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(filepath.Join(t.TempDir(), "chain"))
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer chain.Close()
+
+	set := utxo.NewUTXOSet()
+	for i, value := range []int64{300, 5000, 20, 900} {
+		set.Add(utxo.NewUTXO(types.Hash{byte(i + 1)}, 0, types.TxOutput{Value: value}, 1, false))
+	}
+
+	server := httptest.NewServer(explorer.NewExplorer(chain, nil, set).Handler())
+	defer server.Close()
+
+	var rich []explorer.UTXOSummary
+	if status := getExplorer(t, server, "richlist?limit=3", &rich); status != http.StatusOK {
+		t.Fatalf("Rich list status %d", status)
+	}
+	want := []int64{5000, 900, 300}
+	if len(rich) != len(want) {
+		t.Fatalf("Rich list has %d entries, want %d", len(rich), len(want))
+	}
+	for i := range want {
+		if rich[i].Value != want[i] {
+			t.Errorf("Entry %d = %d, want %d", i, rich[i].Value, want[i])
+		}
+	}
+}
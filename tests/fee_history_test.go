@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestFeeHistorySaveLoad(t *testing.T) {
+	history := mempool.NewFeeHistory()
+
+	// Ten transactions at 20 sat/vB, all confirmed in the next block
+	txHashes := make([]types.Hash, 0)
+	for i := 0; i < 10; i++ {
+		hash := types.Hash{byte(i + 1)}
+		history.TrackTransaction(hash, 20000, 100)
+		txHashes = append(txHashes, hash)
+	}
+	history.ProcessBlock(101, txHashes)
+
+	feeRate, ok := history.EstimateFeeRate(1)
+	if !ok {
+		t.Fatal("Expected an estimate after confirmations")
+	}
+
+	path := filepath.Join(t.TempDir(), mempool.FeeEstimatesFileName)
+	if err := history.SaveToFile(path); err != nil {
+		t.Fatalf("Failed to save fee history: %v", err)
+	}
+
+	restored := mempool.NewFeeHistory()
+	if err := restored.LoadFromFile(path); err != nil {
+		t.Fatalf("Failed to load fee history: %v", err)
+	}
+
+	restoredRate, ok := restored.EstimateFeeRate(1)
+	if !ok || restoredRate != feeRate {
+		t.Errorf("Restored estimate = %d (ok=%v), want %d", restoredRate, ok, feeRate)
+	}
+
+	if restored.BestHeight() != 101 {
+		t.Errorf("Restored best height = %d, want 101", restored.BestHeight())
+	}
+}
+
+func TestFeeHistoryLoadMissingFile(t *testing.T) {
+	history := mempool.NewFeeHistory()
+	if err := history.LoadFromFile(filepath.Join(t.TempDir(), "missing.dat")); err != nil {
+		t.Errorf("Missing file should not be an error: %v", err)
+	}
+
+	if _, ok := history.EstimateFeeRate(1); ok {
+		t.Error("Empty history should not produce an estimate")
+	}
+}
+
+func TestMempoolStopsTrackingTransactionsThatLeave(t *testing.T) {
+	history := mempool.NewFeeHistory()
+	pool := mempool.NewMempool(1000000, 1000, 3600)
+	pool.SetFeeHistory(history)
+
+	replaceable := rbfSpend(types.Hash{1}, transaction.MaxRBFSequence, 90000)
+	removed := rbfSpend(types.Hash{2}, transaction.SequenceFinal, 90000)
+	confirmed := rbfSpend(types.Hash{3}, transaction.SequenceFinal, 90000)
+	for _, tx := range []*types.Transaction{replaceable, removed, confirmed} {
+		if err := pool.Add(tx, 10000, 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replacement := rbfSpend(types.Hash{1}, transaction.SequenceFinal, 80000)
+	if err := pool.Add(replacement, 20000, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Remove(txid(t, removed)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A block confirming everything only counts what was still waiting
+	history.ProcessBlock(101, []types.Hash{txid(t, replaceable), txid(t, removed), txid(t, confirmed), txid(t, replacement)})
+	counted := 0.0
+	for _, bucket := range history.Buckets() {
+		counted += bucket.TxCount
+	}
+	if counted != 2 {
+		t.Errorf("Fee history counted %v confirmations, want 2", counted)
+	}
+}
+
+func TestPeerBlocksFeedFeeHistory(t *testing.T) {
+	h, err := testharness.New(2)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	history := mempool.NewFeeHistory()
+	h.Node(1).P2P.Mempool.SetFeeHistory(history)
+	if err := h.Connect(0, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Node(0).MineBlocks(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.WaitForSync(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if history.BestHeight() != 2 {
+		t.Errorf("Fee history at height %d after syncing 2 blocks, want 2", history.BestHeight())
+	}
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// These targets parse attacker-controlled bytes. With plain `go test` only
+// the seed corpus runs; use e.g. `go test ./tests -fuzz=FuzzDeserializeBlock`
+// to fuzz for real. The only requirement is that nothing panics.
+
+func fuzzSeedTransaction() *types.Transaction {
+	return &types.Transaction{
+		Version: 1,
+		Inputs: []types.TxInput{
+			{
+				PrevTxHash:      types.Hash{1, 2, 3},
+				OutputIndex:     0,
+				SignatureScript: []byte{0x01, 0x02},
+				Sequence:        0xFFFFFFFF,
+			},
+		},
+		Outputs: []types.TxOutput{
+			{Value: 5000000000, PubKeyScript: []byte{0x76, 0xa9, 0x14}},
+		},
+		LockTime: 0,
+	}
+}
+
+func FuzzDeserializeVersion(f *testing.F) {
+	version := protocol.NewVersionMessage(protocol.NetAddress{}, protocol.NetAddress{}, 42, "/fuzz:0.1/", 100)
+	seed, err := version.Serialize()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Add([]byte{})
+	f.Add(seed[:len(seed)/2])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := protocol.DeserializeVersion(data)
+		if err != nil {
+			return
+		}
+
+		// Anything we accept must serialize again
+		if _, err := msg.Serialize(); err != nil {
+			t.Errorf("Re-serializing accepted version failed: %v", err)
+		}
+	})
+}
+
+func FuzzDeserializeInv(f *testing.F) {
+	inv := protocol.NewInvMessage()
+	inv.AddInvVect(protocol.NewInvVect(protocol.InvTypeBlock, types.Hash{1}))
+	inv.AddInvVect(protocol.NewInvVect(protocol.InvTypeTx, types.Hash{2}))
+	seed, err := inv.Serialize()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := protocol.DeserializeInv(data)
+		if err != nil {
+			return
+		}
+
+		reserialized, err := msg.Serialize()
+		if err != nil {
+			t.Fatalf("Re-serializing accepted inv failed: %v", err)
+		}
+		if _, err := protocol.DeserializeInv(reserialized); err != nil {
+			t.Errorf("Round trip failed: %v", err)
+		}
+	})
+}
+
+func FuzzDeserializeTransaction(f *testing.F) {
+	seed, err := serialization.SerializeTransaction(fuzzSeedTransaction())
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Add([]byte{0x01, 0x00, 0x00, 0x00, 0xfe, 0xff, 0xff, 0xff, 0x7f})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tx, err := serialization.DeserializeTransaction(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		if _, err := serialization.SerializeTransaction(tx); err != nil {
+			t.Errorf("Re-serializing accepted transaction failed: %v", err)
+		}
+	})
+}
+
+func FuzzDeserializeBlock(f *testing.F) {
+	block := &types.Block{
+		Header: types.BlockHeader{
+			Version:   1,
+			Timestamp: 1231006505,
+			Bits:      0x1d00ffff,
+		},
+		Transactions: []types.Transaction{*fuzzSeedTransaction()},
+	}
+	seed, err := serialization.SerializeBlock(block)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Add(seed[:80])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		block, err := serialization.DeserializeBlock(data)
+		if err != nil {
+			return
+		}
+
+		if _, err := serialization.HashBlockHeader(&block.Header); err != nil {
+			t.Errorf("Hashing accepted block header failed: %v", err)
+		}
+	})
+}
+
+func FuzzScriptExecution(f *testing.F) {
+	p2pkh, err := script.P2PKH(make([]byte, 20))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(p2pkh)
+	f.Add([]byte{script.OP_1, script.OP_1, script.OP_EQUAL})
+	f.Add([]byte{script.OP_DUP})
+	f.Add([]byte{0x4c, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		engine := script.NewEngine(data)
+		engine.SetTransaction(fuzzSeedTransaction(), 0)
+
+		// Errors are fine, panics are not
+		engine.Execute()
+		script.DisassembleScript(data)
+	})
+}
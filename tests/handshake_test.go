@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+)
+
+// sendRawVersion writes a version message with the given protocol version
+func sendRawVersion(t *testing.T, conn net.Conn, version int32) {
+	t.Helper()
+
+	v := protocol.NewVersionMessage(protocol.NetAddress{}, protocol.NetAddress{}, 7, "/raw:0.1/", 0)
+	v.Version = version
+	payload, err := v.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := protocol.NewMessage(protocol.MagicMainnet, protocol.CmdVersion, payload).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("Failed to write version: %v", err)
+	}
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	h.SetClock(fake)
+	node := h.Node(0)
+
+	// Connect but never send a version message
+	conn, err := net.Dial("tcp", node.P2P.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	waitUntil(t, "peer to register", func() bool { return node.P2P.PeerCount() == 1 })
+	// The maintenance loop holds the other timer
+	waitUntil(t, "handshake timer", func() bool { return fake.PendingTimers() > 1 })
+
+	fake.Advance(network.DefaultHandshakeTimeout - time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if node.P2P.PeerCount() != 1 {
+		t.Fatal("Peer dropped before the handshake deadline")
+	}
+
+	fake.Advance(2 * time.Second)
+	waitUntil(t, "silent peer to be dropped", func() bool { return node.P2P.PeerCount() == 0 })
+}
+
+func TestHandshakeVersionNegotiation(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+
+	// Peers below the minimum version are disconnected
+	old, err := net.Dial("tcp", node.P2P.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	sendRawVersion(t, old, protocol.MinProtocolVersion-1)
+
+	old.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for {
+		if _, err := old.Read(buf); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("Obsolete peer was not disconnected")
+			}
+			break
+		}
+	}
+
+	// A newer peer talks at our version
+	newer, err := net.Dial("tcp", node.P2P.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newer.Close()
+	sendRawVersion(t, newer, protocol.ProtocolVersion+100)
+
+	waitUntil(t, "version to be processed", func() bool {
+		peers := node.P2P.PeerInfo()
+		return len(peers) == 1 && peers[0].Version != 0
+	})
+
+	stats := node.P2P.PeerInfo()[0]
+	if stats.Version != protocol.ProtocolVersion+100 {
+		t.Errorf("Advertised version = %d, want %d", stats.Version, protocol.ProtocolVersion+100)
+	}
+	if stats.Negotiated != protocol.ProtocolVersion {
+		t.Errorf("Negotiated version = %d, want %d", stats.Negotiated, protocol.ProtocolVersion)
+	}
+}
@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+)
+
+func TestHarnessBlockSyncAndRelay(t *testing.T) {
+	h, err := testharness.New(3)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	// Blocks mined before connecting are fetched during the handshake sync
+	if _, err := h.Node(0).MineBlocks(3); err != nil {
+		t.Fatalf("Failed to mine: %v", err)
+	}
+
+	if err := h.ConnectAll(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.WaitForSync(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// A payment from node 0 reaches every mempool
+	tx, err := h.Node(0).SendTo(h.Node(2).Address, 10*100000000, 10000)
+	if err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+	if err := h.WaitForMempool(txHash, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// A block mined by the last node is relayed back through the line
+	if _, err := h.Node(2).MineBlocks(1); err != nil {
+		t.Fatalf("Failed to mine: %v", err)
+	}
+	if err := h.WaitForSync(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	height, _ := h.Node(0).Height()
+	if height != 4 {
+		t.Errorf("Node 0 height = %d, want 4", height)
+	}
+
+	if h.Node(1).P2P.Mempool.Exists(txHash) {
+		t.Error("Confirmed transaction should leave the mempool")
+	}
+
+	balance, err := h.Node(2).Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance <= 10*100000000 {
+		t.Errorf("Node 2 balance = %d, want payment plus its coinbase", balance)
+	}
+}
@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// BIP32 test vector 1
+func TestBIP32Derivation(t *testing.T) {
+	master, err := keys.NewMasterKey(unhex(t, "000102030405060708090a0b0c0d0e0f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const h = keys.HardenedKeyStart
+	tests := []struct {
+		path      []uint32
+		key       string
+		chainCode string
+	}{
+		{nil, "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35", "873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508"},
+		{[]uint32{h}, "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea", "47fdacbd0f1097043b78c63c20c34ef4ed9a111d980047ad16282c7ae6236141"},
+		{[]uint32{h, 1}, "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368", "2a7857631386ba23dacac34180dd1983734e444fdbf774041578e9b6adb37c19"},
+		{[]uint32{h, 1, h + 2}, "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca", "04466b9cc8e161e966409ca52986c584f07e9dc81f735db683c3ff6ec7b1503f"},
+	}
+	for _, tt := range tests {
+		key, err := master.Derive(tt.path...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(key.PrivateKey().Bytes()); got != tt.key {
+			t.Errorf("%v: key %s, want %s", tt.path, got, tt.key)
+		}
+		if got := hex.EncodeToString(key.ChainCode()); got != tt.chainCode {
+			t.Errorf("%v: chain code %s, want %s", tt.path, got, tt.chainCode)
+		}
+	}
+
+	if _, err := keys.NewMasterKey(make([]byte, 15)); err == nil {
+		t.Error("Accepted a 15 byte seed")
+	}
+}
+
+// The HD seed and chain position survive encryption and a reload
+func TestHDWalletPersists(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, 32)
+	path := filepath.Join(t.TempDir(), wallet.WalletFileName)
+	w := wallet.NewWallet()
+	w.SetFile(path)
+	if err := w.SetHDSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetHDSeed(seed); err != wallet.ErrHasHDSeed {
+		t.Errorf("SetHDSeed twice = %v", err)
+	}
+	first, err := w.GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.EncryptWallet("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := loadWallet(t, path)
+	if !loaded.IsHD() || !loaded.IsLocked() {
+		t.Fatal("Loaded wallet is not HD and locked")
+	}
+	if _, err := loaded.GenerateAddress(); err != wallet.ErrWalletLocked {
+		t.Errorf("GenerateAddress while locked = %v", err)
+	}
+	if err := loaded.Unlock("secret", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	second, err := loaded.GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh wallet on the same seed derives the same two addresses
+	fresh := wallet.NewWallet()
+	if err := fresh.SetHDSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{first, second} {
+		if got, _ := fresh.GenerateAddress(); got != want {
+			t.Errorf("Fresh wallet derived %s, want %s", got, want)
+		}
+	}
+}
+
+// A wallet restored from seed finds the addresses used before as far as
+// the gap limit reaches, and their coins
+func TestHDWalletRestore(t *testing.T) {
+	node, server, client := walletRPCNode(t)
+	if err := server.SetWalletDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	seed := bytes.Repeat([]byte{42}, 32)
+	original := wallet.NewWallet()
+	original.SetNetParams(node.Wallet.NetParams())
+	if err := original.SetHDSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	var addresses []string
+	for i := 0; i <= 31; i++ {
+		address, err := original.GenerateAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, address)
+	}
+
+	// Used: 0, then 15 within the first gap, then 30 within the next
+	var funded int64
+	for _, index := range []int{0, 15, 30} {
+		if _, err := node.SendTo(addresses[index], 100000, 1000); err != nil {
+			t.Fatal(err)
+		}
+		funded += 100000
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		gapLimit int
+		highest  int64
+		balance  int64
+	}{
+		{"narrow", 10, 0, 100000},
+		{"restored", 0, 30, funded},
+	}
+	for _, tt := range tests {
+		if _, err := client.CreateWallet(tt.name, ""); err != nil {
+			t.Fatal(err)
+		}
+		client.SetWallet(tt.name)
+		result, err := client.SetHDSeed(seed, tt.gapLimit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.External != tt.highest || result.Internal != -1 || result.NextExternal != uint32(tt.highest+1) {
+			t.Errorf("%s: SetHDSeed = %+v, want highest external index %d", tt.name, result, tt.highest)
+		}
+		if balance, err := client.GetBalance(); err != nil || balance != tt.balance {
+			t.Errorf("%s: balance %d, %v, want %d", tt.name, balance, err, tt.balance)
+		}
+		next, err := client.GetNewAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != addresses[tt.highest+1] {
+			t.Errorf("%s: next address %s, want index %d", tt.name, next, tt.highest+1)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	syncmanager "github.com/pouria-shahmiri/learn-bitcoin/pkg/network/sync"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// newHeadersFixture returns a sync manager whose chain holds only a genesis
+// block, plus headers for three blocks on top of it
+func newHeadersFixture(t *testing.T) (*syncmanager.SyncManager, *protocol.HeadersMessage) {
+	t.Helper()
+
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Close() })
+
+	genesis := buildTestChain(t, types.Hash{}, 1)[0]
+	if err := chain.SaveBlock(genesis, 0); err != nil {
+		t.Fatal(err)
+	}
+	genesisHash, _ := chain.GetBlockHash(genesis)
+
+	headers := protocol.NewHeadersMessage()
+	for _, block := range buildTestChain(t, genesisHash, 3) {
+		headers.AddHeader(block.Header)
+	}
+
+	return syncmanager.NewSyncManager(chain), headers
+}
+
+func misbehaviorScore(err error) int {
+	var misbehavior *syncmanager.MisbehaviorError
+	if errors.As(err, &misbehavior) {
+		return misbehavior.Score
+	}
+	return 0
+}
+
+func TestHeadersMessageRoundTrip(t *testing.T) {
+	_, headers := newHeadersFixture(t)
+
+	data, err := headers.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1+3*81 {
+		t.Errorf("Serialized length = %d, want %d", len(data), 1+3*81)
+	}
+
+	decoded, err := protocol.DeserializeHeaders(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range headers.Headers {
+		if decoded.Headers[i] != headers.Headers[i] {
+			t.Errorf("Header %d mismatch", i)
+		}
+	}
+}
+
+func TestHeadersRequestBlocks(t *testing.T) {
+	sm, headers := newHeadersFixture(t)
+	peer := &recordingSender{addr: "10.0.0.1:8333"}
+
+	if err := sm.HandleHeaders(headers, peer); err != nil {
+		t.Fatalf("Valid headers rejected: %v", err)
+	}
+	if len(peer.sent) != 1 || peer.sent[0].Command != protocol.CmdGetData {
+		t.Fatalf("Expected one getdata, got %d messages", len(peer.sent))
+	}
+	gd, _ := protocol.DeserializeGetData(peer.sent[0].Payload)
+	if len(gd.Inventory) != 3 {
+		t.Errorf("Requested %d blocks, want 3", len(gd.Inventory))
+	}
+}
+
+func TestHeadersNonContinuous(t *testing.T) {
+	sm, headers := newHeadersFixture(t)
+	peer := &recordingSender{addr: "10.0.0.1:8333"}
+
+	headers.Headers[1], headers.Headers[2] = headers.Headers[2], headers.Headers[1]
+	err := sm.HandleHeaders(headers, peer)
+	if misbehaviorScore(err) != syncmanager.NonContinuousHeadersPenalty {
+		t.Errorf("Expected non-continuous penalty, got %v", err)
+	}
+}
+
+func TestHeadersLowWork(t *testing.T) {
+	sm, headers := newHeadersFixture(t)
+	peer := &recordingSender{addr: "10.0.0.1:8333"}
+
+	sm.SetMinimumChainWork(consensus.NewMainnetRules().MinimumChainWork)
+	err := sm.HandleHeaders(headers, peer)
+	if !errors.Is(err, syncmanager.ErrLowWorkChain) {
+		t.Fatalf("Expected ErrLowWorkChain, got %v", err)
+	}
+	if misbehaviorScore(err) != 0 {
+		t.Error("Low work chains should not be penalized")
+	}
+	if len(peer.sent) != 0 {
+		t.Error("No blocks should be requested for a low work chain")
+	}
+
+	// The regtest minimum accepts them
+	sm.SetMinimumChainWork(big.NewInt(0))
+	if err := sm.HandleHeaders(headers, peer); err != nil {
+		t.Errorf("Headers rejected without a minimum: %v", err)
+	}
+}
+
+func TestHeadersUnconnectingLimit(t *testing.T) {
+	sm, headers := newHeadersFixture(t)
+	peer := &recordingSender{addr: "10.0.0.1:8333"}
+
+	// Drop the first header so the rest don't connect
+	headers.Headers = headers.Headers[1:]
+
+	for i := 0; i < syncmanager.MaxUnconnectingHeaders; i++ {
+		if err := sm.HandleHeaders(headers, peer); err != nil {
+			t.Fatalf("Unconnecting message %d should only trigger getheaders: %v", i, err)
+		}
+	}
+	if peer.sent[0].Command != protocol.CmdGetHeaders {
+		t.Errorf("Expected getheaders, got %s", peer.sent[0].Command)
+	}
+
+	err := sm.HandleHeaders(headers, peer)
+	if misbehaviorScore(err) != syncmanager.UnconnectingHeadersPenalty {
+		t.Errorf("Expected unconnecting penalty, got %v", err)
+	}
+}
+
+func TestNodeServesHeaders(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	blocks, err := h.Node(0).MineBlocks(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisHash, _ := h.Node(0).Chain.GetBlockHash(h.Genesis)
+
+	rp := dialRawPeer(t, h.Node(0).P2P.Addr())
+	defer rp.close()
+
+	payload, _ := protocol.NewGetHeadersMessage([]types.Hash{genesisHash}, types.Hash{}).Serialize()
+	rp.send(protocol.CmdGetHeaders, payload)
+
+	headers, err := protocol.DeserializeHeaders(rp.expect(protocol.CmdHeaders).Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers.Headers) != len(blocks) {
+		t.Fatalf("Got %d headers, want %d", len(headers.Headers), len(blocks))
+	}
+	for i, block := range blocks {
+		if headers.Headers[i] != block.Header {
+			t.Errorf("Header %d does not match mined block", i)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func getHealth(t *testing.T, url string) (int, rpc.HealthStatus) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var status rpc.HealthStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode %s: %v", url, err)
+	}
+	return resp.StatusCode, status
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test blocks are timestamped in 2023, far older than the default tip age
+	for i, block := range buildTestChain(t, types.Hash{}, 3) {
+		if err := chain.SaveBlock(block, uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetNode(network.NewNode(network.NodeConfig{ListenAddr: "127.0.0.1:0"}, chain))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	code, status := getHealth(t, ts.URL+"/healthz")
+	if code != http.StatusOK || !status.DBOpen || status.Blocks != 2 {
+		t.Fatalf("healthz = %d %+v", code, status)
+	}
+
+	code, status = getHealth(t, ts.URL+"/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz with no peers and an old tip = %d", code)
+	}
+	if len(status.Problems) != 2 || status.Peers != 0 || status.LastBlockAge <= 0 {
+		t.Errorf("Unexpected readiness report: %+v", status)
+	}
+
+	server.SetReadiness(rpc.ReadinessConfig{MinPeers: 0, MaxSyncLag: 1})
+	if code, status = getHealth(t, ts.URL+"/readyz"); code != http.StatusOK || status.Status != "ok" {
+		t.Errorf("readyz with relaxed thresholds = %d %+v", code, status)
+	}
+
+	// Probes bypass the RPC rate limits
+	server.SetRateLimits(rpc.RateLimitConfig{ReadOnly: rpc.RateLimit{Rate: 1, Burst: 1}})
+	for i := 0; i < 3; i++ {
+		if code, _ := getHealth(t, ts.URL+"/healthz"); code != http.StatusOK {
+			t.Fatalf("healthz call %d = %d", i, code)
+		}
+	}
+
+	chain.Close()
+	if code, status = getHealth(t, ts.URL+"/healthz"); code != http.StatusServiceUnavailable || status.DBOpen {
+		t.Errorf("healthz after closing the database = %d %+v", code, status)
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+)
+
+// startListenNode starts a node with the given listen configuration
+func startListenNode(t *testing.T, config network.NodeConfig) *network.Node {
+	t.Helper()
+
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Close() })
+
+	node := network.NewNode(config, chain)
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(node.Stop)
+	return node
+}
+
+// readVersionFrom connects to addr, sends a version and returns the one
+// the node answers with
+func readVersionFrom(t *testing.T, addr string) (*protocol.VersionMessage, net.Conn) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	sendRawVersion(t, conn, protocol.ProtocolVersion)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		msg, err := protocol.Deserialize(conn)
+		if err != nil {
+			t.Fatalf("No version from %s: %v", addr, err)
+		}
+		if msg.Command == protocol.CmdVersion {
+			v, err := protocol.DeserializeVersion(msg.Payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v, conn
+		}
+	}
+}
+
+func TestMultipleListeners(t *testing.T) {
+	node := startListenNode(t, network.NodeConfig{
+		ListenAddr:  "127.0.0.1:0",
+		ListenAddrs: []string{"127.0.0.2:0", "127.0.0.1:0"},
+	})
+
+	// The duplicate is bound once
+	addrs := node.ListenAddrs()
+	if len(addrs) != 2 {
+		t.Fatalf("Listening on %v, want 2 addresses", addrs)
+	}
+	if node.Addr() != addrs[0] {
+		t.Errorf("Addr() = %s, want the first listener %s", node.Addr(), addrs[0])
+	}
+
+	// Each listener advertises itself, and tells the peer how it is seen
+	for _, addr := range addrs {
+		v, conn := readVersionFrom(t, addr)
+		if v.AddrFrom.String() != addr {
+			t.Errorf("Version from %s advertises %s", addr, v.AddrFrom)
+		}
+		if v.AddrRecv.String() != conn.LocalAddr().String() {
+			t.Errorf("Version from %s addressed to %s, want %s", addr, v.AddrRecv, conn.LocalAddr())
+		}
+	}
+}
+
+func TestExternalAddrAdvertised(t *testing.T) {
+	node := startListenNode(t, network.NodeConfig{
+		ListenAddr:   "127.0.0.1:0",
+		ExternalAddr: "203.0.113.5:8333",
+	})
+
+	v, _ := readVersionFrom(t, node.Addr())
+	if v.AddrFrom.String() != "203.0.113.5:8333" {
+		t.Errorf("Advertised %s, want the external address", v.AddrFrom)
+	}
+}
+
+func TestNoListen(t *testing.T) {
+	server := startListenNode(t, network.NodeConfig{ListenAddr: "127.0.0.1:0"})
+	client := startListenNode(t, network.NodeConfig{ListenAddr: "127.0.0.1:0", NoListen: true})
+
+	if len(client.ListenAddrs()) != 0 {
+		t.Fatalf("Outbound-only node listens on %v", client.ListenAddrs())
+	}
+
+	// Outbound connections still work
+	go client.Connect(server.Addr())
+	waitUntil(t, "outbound connection", func() bool { return server.PeerCount() == 1 && client.PeerCount() == 1 })
+}
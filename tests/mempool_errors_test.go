@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestMempoolRejectionReasons(t *testing.T) {
+	pool := mempool.NewMempool(1000000, 10000, 3600)
+
+	tx := rbfSpend(types.Hash{1}, transaction.SequenceFinal, 90000)
+	if err := pool.Add(tx, 100, 1); !errors.Is(err, mempool.ErrLowFee) {
+		t.Errorf("Low fee: got %v, want ErrLowFee", err)
+	}
+	if err := pool.Add(tx, 10000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Add(tx, 10000, 1); !errors.Is(err, mempool.ErrAlreadyInMempool) {
+		t.Errorf("Duplicate: got %v, want ErrAlreadyInMempool", err)
+	}
+	if err := pool.Add(rbfSpend(types.Hash{1}, transaction.SequenceFinal, 80000), 20000, 1); !errors.Is(err, mempool.ErrConflict) {
+		t.Errorf("Double spend: got %v, want ErrConflict", err)
+	}
+
+	policy := mempool.DefaultPolicy()
+	validator := mempool.NewPolicyValidator(policy, pool)
+	dust := rbfSpend(types.Hash{2}, transaction.SequenceFinal, 1)
+	if err := validator.ValidateTransaction(dust, 10000); !errors.Is(err, mempool.ErrNonStandard) {
+		t.Errorf("Dust: got %v, want ErrNonStandard", err)
+	}
+	policy.MaxTxSize = 10
+	if err := validator.ValidateTransaction(tx, 10000); !errors.Is(err, mempool.ErrTooLarge) {
+		t.Errorf("Oversized: got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestMempoolFullReportsRequiredFee(t *testing.T) {
+	first := rbfSpend(types.Hash{1}, transaction.SequenceFinal, 90000)
+	size := mempool.CalculateTransactionSize(first)
+	pool := mempool.NewMempool(mempool.EstimateMemoryUsage(first, 0).Total(), 1000, 3600)
+	if err := pool.Add(first, 50*size, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Paying less than what's already there doesn't evict it
+	second := rbfSpend(types.Hash{2}, transaction.SequenceFinal, 90000)
+	err := pool.Add(second, 10*size, 1)
+	var full *mempool.MempoolFullError
+	if !errors.As(err, &full) || !errors.Is(err, mempool.ErrMempoolFull) {
+		t.Fatalf("Got %v, want a MempoolFullError", err)
+	}
+	// The floor is the evicted rate plus the incremental relay fee
+	floor := int64(50000 + mempool.DefaultIncrementalRelayFee)
+	if want := transaction.FeeAtRate(floor, size); full.MinFeeRate != floor || full.RequiredFee != want {
+		t.Errorf("Required fee rate %d and fee %d, want %d and %d", full.MinFeeRate, full.RequiredFee, floor, want)
+	}
+	if !pool.Exists(txid(t, first)) {
+		t.Error("Failed add evicted a transaction")
+	}
+
+	// Paying the required fee does
+	if err := pool.Add(second, full.RequiredFee, 1); err != nil {
+		t.Fatalf("Add with the required fee failed: %v", err)
+	}
+	if pool.Exists(txid(t, first)) || pool.Size() != 1 {
+		t.Error("Cheaper transaction not evicted")
+	}
+}
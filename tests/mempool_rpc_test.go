@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+)
+
+func TestMempoolRPC(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	info, err := client.GetMempoolInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 0 || info.OldestEntered != 0 || info.MaxMempool == 0 {
+		t.Errorf("Empty mempool info: %+v", info)
+	}
+
+	tx, err := node.SendTo(node.Address, 1000, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+	txid := txHash.String()
+
+	info, err = client.GetMempoolInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 1 || info.Bytes == 0 || info.TotalFee != 500 || info.OldestEntered == 0 {
+		t.Errorf("Mempool info after send: %+v", info)
+	}
+	split := info.UsageSplit
+	if info.Usage <= info.Bytes || split.Transactions+split.Entries+split.Spends+split.Links != info.Usage || split.Spends == 0 {
+		t.Errorf("Memory usage %d split %+v, for %d bytes of transactions", info.Usage, split, info.Bytes)
+	}
+
+	pool, err := client.GetRawMempool(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.TxIDs) != 1 || pool.TxIDs[0] != txid || len(pool.Entries) != 0 {
+		t.Errorf("GetRawMempool(false) = %+v", pool)
+	}
+
+	pool, err = client.GetRawMempool(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Entries) != 1 || pool.Entries[0].TxID != txid || pool.Entries[0].Size != info.Bytes {
+		t.Errorf("GetRawMempool(true) = %+v", pool)
+	}
+}
+
+func TestGetBlockHashRPC(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	best, err := node.BestHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := client.GetBlockHash(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != best.String() {
+		t.Errorf("GetBlockHash(2) = %s, want %s", hash, best)
+	}
+	if _, err := client.GetBlockHash(3); err == nil {
+		t.Error("Got a hash above the tip")
+	}
+}
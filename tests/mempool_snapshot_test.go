@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func snapshotTxIDs(entries []mempool.SnapshotEntry) map[types.Hash]bool {
+	ids := make(map[types.Hash]bool)
+	for _, entry := range entries {
+		ids[entry.TxHash] = true
+	}
+	return ids
+}
+
+func TestMempoolSnapshotDiff(t *testing.T) {
+	replaceable := rbfSpend(types.Hash{1}, transaction.MaxRBFSequence, 90000)
+	cheap := rbfSpend(types.Hash{2}, transaction.SequenceFinal, 90000)
+	mined := rbfSpend(types.Hash{3}, transaction.SequenceFinal, 90000)
+	dropped := rbfSpend(types.Hash{4}, transaction.SequenceFinal, 90000)
+	size := mempool.CalculateTransactionSize(cheap)
+
+	// Room for four transactions
+	pool := mempool.NewMempool(4*mempool.EstimateMemoryUsage(cheap, 0).Total(), 1000, 3600)
+	for i, tx := range []*types.Transaction{replaceable, cheap, mined, dropped} {
+		fee := (int64(i) + 2) * size
+		if tx == cheap {
+			fee = size
+		}
+		if err := pool.Add(tx, fee, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := pool.Snapshot()
+	if list := before.List(); len(list) != 4 || list[3].TxHash != txid(t, cheap) {
+		t.Fatalf("Snapshot list = %+v", list)
+	}
+
+	pool.RemoveConfirmed([]types.Transaction{*mined})
+	if err := pool.Remove(txid(t, dropped)); err != nil {
+		t.Fatal(err)
+	}
+	replacement := rbfSpend(types.Hash{1}, transaction.SequenceFinal, 80000)
+	if err := pool.Add(replacement, 20*size, 1); err != nil {
+		t.Fatal(err)
+	}
+	var added []*types.Transaction
+	for i := byte(5); i <= 7; i++ {
+		tx := rbfSpend(types.Hash{i}, transaction.SequenceFinal, 90000)
+		if err := pool.Add(tx, 30*size, 1); err != nil {
+			t.Fatal(err)
+		}
+		added = append(added, tx)
+	}
+	after := pool.Snapshot()
+
+	diff := pool.DiffSnapshots(before, after)
+	want := map[string]struct {
+		got []mempool.SnapshotEntry
+		txs []*types.Transaction
+	}{
+		"added":    {diff.Added, append([]*types.Transaction{replacement}, added...)},
+		"mined":    {diff.Mined, []*types.Transaction{mined}},
+		"replaced": {diff.Replaced, []*types.Transaction{replaceable}},
+		"evicted":  {diff.Evicted, []*types.Transaction{cheap}},
+		"removed":  {diff.Removed, []*types.Transaction{dropped}},
+	}
+	for name, tt := range want {
+		ids := snapshotTxIDs(tt.got)
+		if len(ids) != len(tt.txs) {
+			t.Errorf("%s: %d transactions, want %d", name, len(ids), len(tt.txs))
+		}
+		for _, tx := range tt.txs {
+			if !ids[txid(t, tx)] {
+				t.Errorf("%s: missing %s", name, txid(t, tx))
+			}
+		}
+	}
+	if len(diff.Expired) != 0 || len(diff.Conflicted) != 0 {
+		t.Errorf("Unexpected expiries or conflicts: %+v", diff)
+	}
+
+	// Nothing changed between a snapshot and itself
+	if same := pool.DiffSnapshots(after, after); len(same.Added)+len(same.Mined)+len(same.Evicted)+len(same.Removed) != 0 {
+		t.Errorf("Diff of a snapshot with itself = %+v", same)
+	}
+}
+
+func TestMempoolDiffRPC(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	snapshot, err := client.GetMempoolSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.ID == 0 || len(snapshot.Transactions) != 0 {
+		t.Fatalf("Empty mempool snapshot = %+v", snapshot)
+	}
+
+	tx, err := node.SendTo(node.Address, 1000, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+
+	diff, err := client.GetMempoolDiff(snapshot.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.From != snapshot.ID || diff.To <= snapshot.ID {
+		t.Errorf("Diff ids %d -> %d", diff.From, diff.To)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].TxID != txHash.String() || diff.Added[0].Fee != 500 {
+		t.Errorf("Added = %+v", diff.Added)
+	}
+
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	mined, err := client.GetMempoolDiff(diff.To, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mined.Mined) != 1 || mined.Mined[0].TxID != txHash.String() || len(mined.Added) != 0 {
+		t.Errorf("Diff after mining = %+v", mined)
+	}
+
+	// Stored snapshots compare the same way again
+	again, err := client.GetMempoolDiff(snapshot.ID, diff.To)
+	if err != nil || len(again.Added) != 1 || again.To != diff.To {
+		t.Errorf("Diff between stored snapshots = %+v, %v", again, err)
+	}
+	if _, err := client.GetMempoolDiff(mined.To+1, 0); err == nil {
+		t.Error("Diff from an unknown snapshot succeeded")
+	}
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// usageTx is shaped like a typical payment: one signed input, payment
+// and change
+func usageTx(i int) *types.Transaction {
+	return &types.Transaction{
+		Version: 1,
+		Inputs: []types.TxInput{{
+			PrevTxHash:      types.Hash{byte(i), byte(i >> 8), byte(i >> 16), 0xaa},
+			SignatureScript: make([]byte, 107),
+			Sequence:        transaction.SequenceFinal,
+		}},
+		Outputs: []types.TxOutput{
+			{Value: 50000, PubKeyScript: make([]byte, 25)},
+			{Value: 40000, PubKeyScript: make([]byte, 25)},
+		},
+	}
+}
+
+// The size limit bounds estimated memory, metadata included, and the
+// estimate follows what the heap actually grows by
+func TestMempoolMemoryAccounting(t *testing.T) {
+	parent := usageTx(1)
+	child := rbfSpend(txid(t, parent), transaction.SequenceFinal, 30000)
+	parentUsage := mempool.EstimateMemoryUsage(parent, 0)
+	childUsage := mempool.EstimateMemoryUsage(child, 1)
+	if parentUsage.Total() < 2*mempool.CalculateTransactionSize(parent) {
+		t.Errorf("Estimated %d bytes for a %d byte transaction", parentUsage.Total(), mempool.CalculateTransactionSize(parent))
+	}
+
+	pool := mempool.NewMempool(parentUsage.Total()+childUsage.Total(), 1000, 3600)
+	if err := pool.Add(parent, 10000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Add(child, 10000, 1); err != nil {
+		t.Fatal(err)
+	}
+	usage := pool.MemoryUsage()
+	if usage.Total() != pool.GetMemoryUsage() || usage.Total() != parentUsage.Total()+childUsage.Total() || usage.Links == 0 {
+		t.Errorf("Usage %+v, want %+v plus %+v", usage, parentUsage, childUsage)
+	}
+	if pool.Bytes() != mempool.CalculateTransactionSize(parent)+mempool.CalculateTransactionSize(child) {
+		t.Errorf("Bytes %d", pool.Bytes())
+	}
+
+	// Serialized bytes alone would leave room for this one; its memory
+	// doesn't fit, and it pays too little to evict anything
+	var full *mempool.MempoolFullError
+	if err := pool.Add(usageTx(2), 300, 1); !errors.As(err, &full) {
+		t.Errorf("Add over the memory limit: %v", err)
+	}
+
+	if err := pool.Remove(txid(t, parent)); err != nil {
+		t.Fatal(err)
+	}
+	if usage := pool.MemoryUsage(); usage != (mempool.MemoryUsage{}) || pool.Bytes() != 0 {
+		t.Errorf("Usage %+v and %d bytes left in an empty pool", usage, pool.Bytes())
+	}
+
+	// Compare with the heap, loosely: the allocator and map growth vary
+	const count = 5000
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	pool = mempool.NewMempool(1<<40, 1000, 3600)
+	for i := 0; i < count; i++ {
+		if err := pool.Add(usageTx(i), 10000, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	measured := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	estimated := pool.GetMemoryUsage()
+	if estimated < measured/2 || estimated > measured*2 {
+		t.Errorf("Estimated %d bytes, heap grew by %d", estimated, measured)
+	}
+	runtime.KeepAlive(pool)
+}
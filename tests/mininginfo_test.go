@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestGetDifficulty(t *testing.T) {
+	tests := []struct {
+		bits uint32
+		want float64
+	}{
+		{0x1d00ffff, 1},
+		{0x1b0404cb, 16307.420938523983},
+		{0x207fffff, 4.656542373906925e-10},
+		{0x04923456, 0}, // Negative target
+	}
+	for _, tt := range tests {
+		if got := consensus.GetDifficulty(tt.bits); math.Abs(got-tt.want) > tt.want*1e-12 {
+			t.Errorf("GetDifficulty(%#x) = %v, want %v", tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateHashRate(t *testing.T) {
+	// Each regtest block is two hashes of work
+	headers := make([]types.BlockHeader, 5)
+	for i := range headers {
+		headers[i] = types.BlockHeader{Bits: 0x207fffff, Timestamp: uint32(1000 + 10*i)}
+	}
+	if got := consensus.EstimateHashRate(headers); got != 0.2 {
+		t.Errorf("Hash rate = %v, want 0.2", got)
+	}
+
+	headers[4].Timestamp = 1000
+	headers[2].Timestamp = 1050 // Out of order, widening the span
+	if got := consensus.EstimateHashRate(headers); got != 8.0/50 {
+		t.Errorf("Hash rate with unordered timestamps = %v, want %v", got, 8.0/50)
+	}
+
+	if got := consensus.EstimateHashRate(headers[:1]); got != 0 {
+		t.Errorf("Hash rate of one header = %v, want 0", got)
+	}
+}
+
+func TestGetMiningInfo(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	// Blocks are 10 seconds apart
+	for height, block := range buildBranch(t, types.Hash{}, 0, 11, 0) {
+		if err := chain.SaveBlock(block, uint64(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	difficulty, err := client.GetDifficulty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if difficulty != consensus.GetDifficulty(0x207fffff) {
+		t.Errorf("Difficulty = %v", difficulty)
+	}
+
+	info, err := client.GetMiningInfo(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Blocks != 10 || info.Bits != "207fffff" || info.NetworkHashPS != 0.2 {
+		t.Errorf("Mining info = %+v", info)
+	}
+	if info.PooledTx != nil || info.Miner != nil {
+		t.Errorf("Mining info reported a mempool or miner the server doesn't have: %+v", info)
+	}
+
+	node := network.NewNode(network.NodeConfig{ListenAddr: "127.0.0.1:0"}, chain)
+	server.SetNode(node)
+	if err := node.Mempool.Add(rbfSpend(types.Hash{1}, transaction.SequenceFinal, 1000), 5000, 10); err != nil {
+		t.Fatal(err)
+	}
+	miner := mining.NewMiner()
+	if _, err := miner.MineBlock(&mining.BlockTemplate{
+		Version:      1,
+		Transactions: []types.Transaction{*rbfSpend(types.Hash{2}, transaction.SequenceFinal, 1000)},
+		Bits:         0x207fffff,
+	}, 0); err != nil {
+		t.Fatal(err)
+	}
+	server.SetMiner(miner)
+
+	info, err = client.GetMiningInfo(rpc.DefaultHashRateBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PooledTx == nil || *info.PooledTx != 1 || *info.CurrentBlockTx != 1 || *info.CurrentBlockFees != 5000 {
+		t.Errorf("Template totals = %+v", info)
+	}
+	if info.Miner == nil || info.Miner.Attempts != 1 {
+		t.Errorf("Local miner = %+v", info.Miner)
+	}
+	// The whole chain is shorter than the default window
+	if info.NetworkHashPS != 0.2 {
+		t.Errorf("Network hash rate = %v, want 0.2", info.NetworkHashPS)
+	}
+}
+
+func TestNetworkHashRate(t *testing.T) {
+	// Blocks come every 10 seconds up to height 4, then every 20
+	chain := make(headerChain, 9)
+	for h := range chain {
+		timestamp := 1000 + 10*h
+		if h > 4 {
+			timestamp = 1040 + 20*(h-4)
+		}
+		chain[h] = types.BlockHeader{Bits: 0x207fffff, Timestamp: uint32(timestamp)}
+	}
+
+	tests := []struct {
+		height, nblocks uint64
+		want            float64
+	}{
+		{4, 4, 0.2},
+		{8, 3, 0.1},
+		{8, 100, 16.0 / 120},
+		{0, 10, 0},
+	}
+	for _, tt := range tests {
+		got, err := consensus.NetworkHashRate(chain, tt.height, tt.nblocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%d blocks to height %d: %v, want %v", tt.nblocks, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestGetNetworkHashPS(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	for height, block := range buildBranch(t, types.Hash{}, 0, 11, 0) {
+		if err := chain.SaveBlock(block, uint64(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	info, err := client.GetNetworkHashPS(rpc.DefaultHashRateBlocks, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NetworkHashPS != 0.2 || info.Height != 10 || info.Blocks != 10 || info.LocalHashPS != nil {
+		t.Errorf("Network hash rate at the tip = %+v", info)
+	}
+
+	info, err = client.GetNetworkHashPS(3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NetworkHashPS != 0.2 || info.Height != 5 || info.Blocks != 3 {
+		t.Errorf("Network hash rate at height 5 = %+v", info)
+	}
+
+	if _, err := client.GetNetworkHashPS(3, 11); err == nil {
+		t.Error("Height above the tip accepted")
+	}
+	if _, err := client.GetNetworkHashPS(0, -1); err == nil {
+		t.Error("Empty window accepted")
+	}
+
+	server.SetMiner(mining.NewMiner())
+	if info, err = client.GetNetworkHashPS(5, -1); err != nil || info.LocalHashPS == nil {
+		t.Errorf("With a miner: %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/miniscript"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// fixedKey returns the key with secret n
+func fixedKey(t *testing.T, n byte) *keys.PrivateKey {
+	t.Helper()
+	secret := make([]byte, 32)
+	secret[31] = n
+	key, err := keys.NewPrivateKeyFromBytes(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestPolicyCompile(t *testing.T) {
+	a := hex.EncodeToString(fixedKey(t, 1).PublicKey().Bytes(true))
+	b := hex.EncodeToString(fixedKey(t, 2).PublicKey().Bytes(true))
+	hash := sha256.Sum256([]byte("secret"))
+	h := hex.EncodeToString(hash[:])
+
+	tests := []struct {
+		policy string
+		asm    string // Disassembly, keys and hashes as A, B and H
+		paths  []string
+	}{
+		{"pk(A)", "[A] OP_CHECKSIG", []string{"<sig(A)>"}},
+		{"multi(1,A,B)", "OP_1 [A] [B] OP_2 OP_CHECKMULTISIG", []string{"<> <sigs(1,A,B)>"}},
+		{"and(pk(A),or(pk(B),after(100)))",
+			"[A] OP_CHECKSIGVERIFY OP_IF [B] OP_CHECKSIG OP_ELSE [64] OP_CHECKLOCKTIMEVERIFY OP_ENDIF",
+			[]string{"<sig(B)> <01> <sig(A)>", "<> <sig(A)> (lock time 100)"}},
+		{"or(and(sha256(H),pk(A)),and(after(500),after(600)))",
+			"OP_IF OP_SHA256 [H] OP_EQUALVERIFY [A] OP_CHECKSIG OP_ELSE [f401] OP_CHECKLOCKTIMEVERIFY OP_DROP [5802] OP_CHECKLOCKTIMEVERIFY OP_ENDIF",
+			[]string{"<sig(A)> <preimage(H)> <01>", "<> (lock time 600)"}},
+		// A height and a timestamp can't both be met
+		{"and(after(100),after(500000000))", "[64] OP_CHECKLOCKTIMEVERIFY OP_DROP [0065cd1d] OP_CHECKLOCKTIMEVERIFY", nil},
+	}
+	expand := strings.NewReplacer("A", a, "B", b, "H", h)
+	shorten := strings.NewReplacer(a, "A", b, "B", h, "H")
+	for _, tt := range tests {
+		policy, err := miniscript.Parse(expand.Replace(tt.policy))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.policy, err)
+		}
+		if got := shorten.Replace(policy.String()); got != tt.policy {
+			t.Errorf("%s: String() = %s", tt.policy, got)
+		}
+		if got := strings.TrimSpace(shorten.Replace(script.DisassembleScript(policy.Compile()))); got != tt.asm {
+			t.Errorf("%s: compiles to %s", tt.policy, got)
+		}
+		var paths []string
+		for _, path := range policy.Paths() {
+			paths = append(paths, shorten.Replace(path.String()))
+		}
+		if fmt.Sprint(paths) != fmt.Sprint(tt.paths) {
+			t.Errorf("%s: paths %q, want %q", tt.policy, paths, tt.paths)
+		}
+	}
+
+	for _, bad := range []string{
+		"", "pk()", "pk(" + a + ")x", "pk(" + a[:64] + ")", "multi(3," + a + "," + b + ")",
+		"after(0)", "after(2147483648)", "sha256(00)", "and(pk(" + a + "))", "xor(pk(" + a + "),pk(" + b + "))",
+	} {
+		if _, err := miniscript.Parse(bad); !errors.Is(err, miniscript.ErrSyntax) {
+			t.Errorf("Parse(%q) = %v", bad, err)
+		}
+	}
+	if _, err := miniscript.ParseDescriptor("sh(pk(" + a + "))"); !errors.Is(err, miniscript.ErrSyntax) {
+		t.Errorf("ParseDescriptor(sh()) = %v", err)
+	}
+}
+
+// A wallet holding A spends and(pk(A),or(pk(B),after(n))) alone once the
+// lock time has passed, and with B before that
+func TestPolicySpends(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	address, err := node.Wallet.GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyA, _ := node.Wallet.GetKey(address)
+	other := wallet.NewWallet()
+	otherAddress, _ := other.GenerateAddress()
+	keyB, _ := other.GetKey(otherAddress)
+
+	height, err := node.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockTime := uint32(height)
+	desc, err := miniscript.ParseDescriptor(fmt.Sprintf("wsh(and(pk(%x),or(pk(%x),after(%d))))",
+		keyA.PublicKey().Bytes(true), keyB.PublicKey().Bytes(true), lockTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := miniscript.ParseDescriptor(desc.String()); err != nil || again.String() != desc.String() {
+		t.Errorf("descriptor doesn't round trip: %v", err)
+	}
+
+	policyAddress, err := node.Wallet.PolicyAddress(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funding, err := node.SendTo(policyAddress, 100000000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	pkScript, _ := desc.Script()
+	index := -1
+	for j, out := range funding.Outputs {
+		if bytes.Equal(out.PubKeyScript, pkScript) {
+			index = j
+		}
+	}
+	if index < 0 {
+		t.Fatal("funding transaction doesn't pay the policy")
+	}
+	prevOut := funding.Outputs[index]
+	payTo := types.TxOutput{Value: prevOut.Value - 10000, PubKeyScript: []byte{script.OP_TRUE}}
+
+	// Both keys: the B branch, no lock time needed
+	both := contracts.SpendTx(txid(t, funding), uint32(index), payTo, 0)
+	if err := node.Wallet.SignPolicy(both, 0, prevOut.Value, desc); !errors.Is(err, miniscript.ErrUnsatisfiable) {
+		t.Fatalf("SignPolicy with A alone before the lock time = %v", err)
+	}
+	if err := other.SignPolicy(both, 0, prevOut.Value, desc); !errors.Is(err, miniscript.ErrUnsatisfiable) {
+		t.Fatalf("SignPolicy with B alone = %v", err)
+	}
+	if _, err := other.ImportPrivateKey(keyA.ToWIF(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SignPolicy(both, 0, prevOut.Value, desc); err != nil {
+		t.Fatal(err)
+	}
+	if err := transaction.VerifyWitnessInput(both, 0, prevOut); err != nil {
+		t.Errorf("A and B spend: %v", err)
+	}
+
+	// A alone once the lock time is set
+	alone := contracts.SpendTx(txid(t, funding), uint32(index), payTo, lockTime)
+	if err := node.Wallet.SignPolicy(alone, 0, prevOut.Value, desc); err != nil {
+		t.Fatal(err)
+	}
+	if err := transaction.VerifyWitnessInput(alone, 0, prevOut); err != nil {
+		t.Errorf("A after the lock time: %v", err)
+	}
+	if err := node.P2P.BroadcastTransaction(alone); err != nil {
+		t.Fatalf("Broadcasting: %v", err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := node.Chain.GetTransactionLocation(txid(t, alone)); err != nil {
+		t.Errorf("Spend not confirmed: %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package tests
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/nat"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+)
+
+// fakeNATPMPGateway answers NAT-PMP requests on a local UDP socket
+// and returns a function reporting the requested mapping lifetimes
+func fakeNATPMPGateway(t *testing.T) (*net.UDPConn, func() []uint32) {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	lifetimes := []uint32{}
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			size, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if size < 2 {
+				continue
+			}
+
+			op := buf[1]
+			var resp []byte
+			switch op {
+			case 0:
+				resp = make([]byte, 12)
+				copy(resp[8:], []byte{203, 0, 113, 5})
+			case 1, 2:
+				resp = make([]byte, 16)
+				internal := binary.BigEndian.Uint16(buf[4:6])
+				lifetime := binary.BigEndian.Uint32(buf[8:12])
+				mu.Lock()
+				lifetimes = append(lifetimes, lifetime)
+				mu.Unlock()
+
+				binary.BigEndian.PutUint16(resp[8:10], internal)
+				binary.BigEndian.PutUint16(resp[10:12], internal+1) // Router picks a different port
+				binary.BigEndian.PutUint32(resp[12:16], lifetime)
+			default:
+				resp = make([]byte, 8)
+				binary.BigEndian.PutUint16(resp[2:4], 5)
+			}
+			resp[1] = op | 128
+			conn.WriteToUDP(resp, from)
+		}
+	}()
+
+	return conn, func() []uint32 {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]uint32(nil), lifetimes...)
+	}
+}
+
+func TestNATPMPClient(t *testing.T) {
+	gateway, lifetimes := fakeNATPMPGateway(t)
+	defer gateway.Close()
+
+	client := &nat.NATPMP{Gateway: gateway.LocalAddr().String(), Timeout: time.Second}
+
+	ip, err := client.ExternalIP()
+	if err != nil {
+		t.Fatalf("ExternalIP failed: %v", err)
+	}
+	if ip.String() != "203.0.113.5" {
+		t.Errorf("External IP = %s", ip)
+	}
+
+	external, err := client.AddPortMapping("tcp", 8333, 8333, "test", time.Hour)
+	if err != nil {
+		t.Fatalf("AddPortMapping failed: %v", err)
+	}
+	if external != 8334 {
+		t.Errorf("Granted port = %d, want the router's choice 8334", external)
+	}
+
+	if err := client.DeletePortMapping("tcp", 8333, external); err != nil {
+		t.Fatalf("DeletePortMapping failed: %v", err)
+	}
+	if got := lifetimes(); len(got) != 2 || got[0] != 3600 || got[1] != 0 {
+		t.Errorf("Requested lifetimes = %v, want [3600 0]", got)
+	}
+
+	if _, err := client.AddPortMapping("sctp", 1, 1, "", time.Hour); err == nil {
+		t.Error("Unsupported protocol should fail")
+	}
+}
+
+func TestUPnPClient(t *testing.T) {
+	var mu sync.Mutex
+	actions := []string{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/desc.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+ <device>
+  <deviceList><device><deviceList><device>
+   <serviceList><service>
+    <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+    <controlURL>/ctl/IPConn</controlURL>
+   </service></serviceList>
+  </device></deviceList></device></deviceList>
+ </device>
+</root>`)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		action := r.Header.Get("SOAPAction")
+
+		mu.Lock()
+		actions = append(actions, action)
+		mu.Unlock()
+
+		if strings.Contains(action, "AddPortMapping") && !strings.Contains(string(body), "<NewLeaseDuration>600</NewLeaseDuration>") {
+			http.Error(w, "bad lease", http.StatusInternalServerError)
+			return
+		}
+		if strings.HasSuffix(action, `#GetExternalIPAddress"`) {
+			fmt.Fprint(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewExternalIPAddress>198.51.100.20</NewExternalIPAddress>
+</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`)
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	igd, err := nat.NewUPnP(ts.URL+"/desc.xml", time.Second)
+	if err != nil {
+		t.Fatalf("Failed to read description: %v", err)
+	}
+	if igd.ControlURL != ts.URL+"/ctl/IPConn" {
+		t.Errorf("Control URL = %s", igd.ControlURL)
+	}
+
+	ip, err := igd.ExternalIP()
+	if err != nil || ip.String() != "198.51.100.20" {
+		t.Errorf("ExternalIP = %v, %v", ip, err)
+	}
+	if _, err := igd.AddPortMapping("tcp", 18444, 18444, "node <1>", 10*time.Minute); err != nil {
+		t.Errorf("AddPortMapping failed: %v", err)
+	}
+	if err := igd.DeletePortMapping("tcp", 18444, 18444); err != nil {
+		t.Errorf("DeletePortMapping failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(actions) != 3 || !strings.HasSuffix(actions[2], `#DeletePortMapping"`) {
+		t.Errorf("Unexpected SOAP actions: %v", actions)
+	}
+}
+
+// recordingMapper is a PortMapper that just counts calls
+type recordingMapper struct {
+	mu      sync.Mutex
+	adds    int
+	deletes int
+}
+
+func (m *recordingMapper) Name() string { return "fake" }
+
+func (m *recordingMapper) ExternalIP() (net.IP, error) {
+	return net.IPv4(192, 0, 2, 99), nil
+}
+
+func (m *recordingMapper) AddPortMapping(protocol string, internalPort, externalPort int, description string, lifetime time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.adds++
+	return 40000, nil
+}
+
+func (m *recordingMapper) DeletePortMapping(protocol string, internalPort, externalPort int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deletes++
+	return nil
+}
+
+func (m *recordingMapper) counts() (int, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.adds, m.deletes
+}
+
+func TestNodePortMappingLease(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	mapper := &recordingMapper{}
+
+	node := network.NewNode(network.NodeConfig{
+		ListenAddr:       "127.0.0.1:0",
+		EnableNAT:        true,
+		NATLeaseDuration: 10 * time.Minute,
+	}, chain)
+	node.SetClock(fake)
+	node.PortMapper = mapper
+
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitUntil(t, "port mapping", func() bool {
+		return node.ExternalAddr() == "192.0.2.99:40000"
+	})
+
+	// Renewed at half the lease
+	waitUntil(t, "renewal", func() bool {
+		fake.Advance(5 * time.Minute)
+		adds, _ := mapper.counts()
+		return adds >= 2
+	})
+
+	node.Stop()
+	if _, deletes := mapper.counts(); deletes != 1 {
+		t.Errorf("Mapping removed %d times on shutdown, want 1", deletes)
+	}
+	if node.ExternalAddr() != "" {
+		t.Errorf("External address still set after shutdown: %s", node.ExternalAddr())
+	}
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+)
+
+func TestNetTotalsAndPerCommandTraffic(t *testing.T) {
+	h, err := testharness.New(2)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	node := h.Node(0)
+	server := rpc.NewServer(node.Wallet, node.Chain, "")
+	server.SetNode(node.P2P)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	if err := h.Connect(0, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	var peers []rpc.PeerInfo
+	waitUntil(t, "verack to be exchanged", func() bool {
+		peers, err = client.GetPeerInfo()
+		return err == nil && len(peers) == 1 &&
+			peers[0].BytesSentPerMsg["verack"] > 0 && peers[0].BytesRecvPerMsg["verack"] > 0
+	})
+
+	p := peers[0]
+	if p.BytesSentPerMsg["version"] == 0 || p.BytesRecvPerMsg["version"] == 0 {
+		t.Errorf("Version traffic missing: sent=%v recv=%v", p.BytesSentPerMsg, p.BytesRecvPerMsg)
+	}
+
+	// An empty verack is just the 24-byte header
+	if got := p.BytesRecvPerMsg["verack"]; got != 24 {
+		t.Errorf("verack bytes = %d, want 24", got)
+	}
+
+	var perMsg uint64
+	for _, bytes := range p.BytesRecvPerMsg {
+		perMsg += bytes
+	}
+	if perMsg != p.BytesRecv {
+		t.Errorf("Per-command received bytes sum to %d, peer total is %d", perMsg, p.BytesRecv)
+	}
+
+	totals, err := client.GetNetTotals()
+	if err != nil {
+		t.Fatalf("getnettotals failed: %v", err)
+	}
+	if totals.TotalBytesSent < p.BytesSent || totals.TotalBytesRecv < p.BytesRecv {
+		t.Errorf("Totals %+v smaller than peer counters sent=%d recv=%d", totals, p.BytesSent, p.BytesRecv)
+	}
+	if totals.TimeMillis == 0 {
+		t.Error("timemillis not set")
+	}
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		monitoring.MetricsPrefix + "p2p_bytes_sent_total ",
+		monitoring.MetricsPrefix + `p2p_command_bytes_received_total{command="version"}`,
+		monitoring.MetricsPrefix + "peers 1",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Metrics output missing %q", want)
+		}
+	}
+}
+
+func TestMetricsPrometheusFormat(t *testing.T) {
+	m := monitoring.NewMetrics()
+	m.RecordSent("inv", 61)
+	m.RecordSent("inv", 61)
+	m.RecordReceived("ping", 32)
+
+	rec := httptest.NewRecorder()
+	monitoring.PrometheusHandler(m).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+
+	for _, want := range []string{
+		"# TYPE learnbitcoin_p2p_bytes_sent_total counter",
+		"learnbitcoin_p2p_bytes_sent_total 122",
+		"learnbitcoin_p2p_messages_received_total 1",
+		`learnbitcoin_p2p_command_messages_sent_total{command="inv"} 2`,
+		`learnbitcoin_p2p_command_bytes_received_total{command="ping"} 32`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output missing %q:\n%s", want, out)
+		}
+	}
+}
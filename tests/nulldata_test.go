@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestNullDataScript(t *testing.T) {
+	for _, size := range []int{0, 20, 75, 76, script.MaxNullDataSize} {
+		data := bytes.Repeat([]byte{0x42}, size)
+		nullData, err := script.NullData(data)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if len(nullData) > script.MaxNullDataScriptSize {
+			t.Errorf("%d bytes: script is %d bytes", size, len(nullData))
+		}
+		extracted, err := script.ExtractNullData(nullData)
+		if err != nil || !bytes.Equal(extracted, data) {
+			t.Errorf("%d bytes: extracted %x, %v", size, extracted, err)
+		}
+	}
+
+	if _, err := script.NullData(make([]byte, script.MaxNullDataSize+1)); err == nil {
+		t.Error("Oversized payload accepted")
+	}
+	if _, err := script.ExtractNullData([]byte{script.OP_RETURN, script.OP_DUP}); err == nil {
+		t.Error("Opcode after OP_RETURN accepted")
+	}
+}
+
+func TestWalletSendWithData(t *testing.T) {
+	w := wallet.NewWallet()
+	w.SetNetParams(keys.RegtestParams)
+	own, err := w.GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := keys.DecodeAddress(own)
+	pkScript, _ := script.P2PKH(decoded.Hash())
+	w.AddUTXO(utxo.NewUTXO(types.Hash{7}, 0, types.TxOutput{Value: 1000000, PubKeyScript: pkScript}, 1, false))
+
+	digest := bytes.Repeat([]byte{0xd0}, 32)
+	tx, err := w.SendWithData(own, 10000, 1000, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Outputs) != 3 || tx.Outputs[1].Value != 0 {
+		t.Fatalf("Expected payee, OP_RETURN and change outputs, got %+v", tx.Outputs)
+	}
+	if data, err := script.ExtractNullData(tx.Outputs[1].PubKeyScript); err != nil || !bytes.Equal(data, digest) {
+		t.Errorf("OP_RETURN output carries %x, %v", data, err)
+	}
+
+	// A zero-value data output is not dust
+	validator := mempool.NewPolicyValidator(mempool.DefaultPolicy(), mempool.NewMempool(1000000, 1000, 3600))
+	if err := validator.ValidateTransaction(tx, 1000); err != nil {
+		t.Errorf("Policy rejected the data carrier: %v", err)
+	}
+
+	if _, err := w.SendWithData(own, 10000, 1000, make([]byte, 81)); err == nil {
+		t.Error("Oversized payload accepted")
+	}
+}
+
+// nullDataBlock returns a block on prev whose second transaction carries
+// payload in an OP_RETURN output
+func nullDataBlock(t *testing.T, prev types.Hash, height uint64, payload []byte) *types.Block {
+	t.Helper()
+	block := blockOn(t, prev, height, height)
+	nullData, err := script.NullData(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Transactions = append(block.Transactions, types.Transaction{
+		Version:  1,
+		Inputs:   []types.TxInput{{PrevTxHash: types.Hash{byte(height)}, Sequence: 0xFFFFFFFF}},
+		Outputs:  []types.TxOutput{{Value: 0, PubKeyScript: nullData}},
+		LockTime: uint32(height),
+	})
+	return block
+}
+
+func TestNullDataIndex(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	chain.EnableNullDataIndex()
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	// Genesis, then two blocks timestamping documents and one other payload
+	genesis := blockOn(t, types.Hash{}, 0, 0)
+	if err := chain.SaveBlock(genesis, 0); err != nil {
+		t.Fatal(err)
+	}
+	prev, _ := serialization.HashBlockHeader(&genesis.Header)
+	payloads := [][]byte{[]byte("DOCPROOF first"), []byte("other"), []byte("DOCPROOF second")}
+	var blocks []*types.Block
+	for i, payload := range payloads {
+		block := nullDataBlock(t, prev, uint64(i+1), payload)
+		if err := chain.SaveBlock(block, uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+		prev, _ = serialization.HashBlockHeader(&block.Header)
+	}
+
+	entries, err := client.ListNullData([]byte("DOCPROOF"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Height != 1 || entries[1].Height != 3 {
+		t.Fatalf("Unexpected entries: %+v", entries)
+	}
+	txid, _ := serialization.HashTransaction(&blocks[0].Transactions[1])
+	if entries[0].TxID != txid.String() || entries[0].Vout != 0 {
+		t.Errorf("First entry points at %s:%d, want %s:0", entries[0].TxID, entries[0].Vout, txid)
+	}
+	if entries, _ := client.ListNullData(nil, 2); len(entries) != 2 {
+		t.Errorf("Expected 2 payloads from height 2, got %d", len(entries))
+	}
+
+	// A reorg replacing the last two blocks drops their payloads
+	forkHash, _ := serialization.HashBlockHeader(&blocks[0].Header)
+	replacement := nullDataBlock(t, forkHash, 2, []byte("DOCPROOF replaced"))
+	if err := chain.SwitchChain(1, []*types.Block{replacement}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = client.ListNullData([]byte("DOCPROOF"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Data != hex.EncodeToString([]byte("DOCPROOF replaced")) {
+		t.Errorf("Index not updated by the reorg: %+v", entries)
+	}
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	syncmanager "github.com/pouria-shahmiri/learn-bitcoin/pkg/network/sync"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// buildTestChain returns count blocks extending prev, starting at height
+// 0 on a zero prev and 1 otherwise. The height in each coinbase keeps
+// their txids apart.
+func buildTestChain(t *testing.T, prev types.Hash, count int) []*types.Block {
+	t.Helper()
+
+	first := uint64(1)
+	if prev.IsZero() {
+		first = 0
+	}
+	blocks := make([]*types.Block, 0, count)
+	for i := 0; i < count; i++ {
+		height := first + uint64(i)
+		coinbase, err := mining.CreateCoinbase(height, 0, "orphan-test", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := mining.BuildBlock(&mining.BlockTemplate{
+			Version:       1,
+			PrevBlockHash: prev,
+			Transactions:  []types.Transaction{*coinbase},
+			Timestamp:     uint32(1700000000 + i),
+			Bits:          0x207fffff,
+			Height:        height,
+		}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev, _ = serialization.HashBlockHeader(&block.Header)
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func TestOrphanBlocksConnectWhenParentArrives(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	genesis := buildTestChain(t, types.Hash{}, 1)[0]
+	if err := chain.SaveBlock(genesis, 0); err != nil {
+		t.Fatal(err)
+	}
+	genesisHash, _ := chain.GetBlockHash(genesis)
+	blocks := buildTestChain(t, genesisHash, 3)
+
+	sm := syncmanager.NewSyncManager(chain)
+	peer := &recordingSender{addr: "10.0.0.1:8333"}
+
+	// Newest first: both are orphans and trigger a getblocks
+	for _, b := range []*types.Block{blocks[2], blocks[1]} {
+		connected, _, err := sm.HandleBlock(b, peer)
+		if err != nil || len(connected) != 0 {
+			t.Fatalf("Orphan should be stored, got %d connected (%v)", len(connected), err)
+		}
+	}
+	if sm.OrphanCount() != 2 {
+		t.Fatalf("Orphan count = %d, want 2", sm.OrphanCount())
+	}
+
+	last := peer.sent[len(peer.sent)-1]
+	if last.Command != protocol.CmdGetBlocks {
+		t.Fatalf("Expected getblocks, got %s", last.Command)
+	}
+	gb, err := protocol.DeserializeGetBlocks(last.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := chain.GetBlockHash(blocks[1])
+	if gb.HashStop != root {
+		t.Errorf("getblocks should stop at the orphan root %s, got %s", root, gb.HashStop)
+	}
+
+	// The missing parent connects the whole chain
+	connected, _, err := sm.HandleBlock(blocks[0], peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connected) != 3 {
+		t.Fatalf("Connected %d blocks, want 3", len(connected))
+	}
+	if sm.OrphanCount() != 0 {
+		t.Errorf("Orphan pool not emptied: %d left", sm.OrphanCount())
+	}
+
+	height, _ := chain.GetBestBlockHeight()
+	if height != 3 {
+		t.Errorf("Height = %d, want 3", height)
+	}
+}
+
+func TestOrphanPoolIsBounded(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	sm := syncmanager.NewSyncManager(chain)
+	peer := &recordingSender{addr: "10.0.0.1:8333"}
+
+	var first types.Hash
+	for i := 0; i < syncmanager.MaxOrphanBlocks+5; i++ {
+		// Each block has its own unknown parent
+		block := buildTestChain(t, types.Hash{0xff, byte(i), byte(i >> 8)}, 1)[0]
+		if i == 0 {
+			first, _ = chain.GetBlockHash(block)
+		}
+		if _, _, err := sm.HandleBlock(block, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sm.OrphanCount() != syncmanager.MaxOrphanBlocks {
+		t.Errorf("Orphan count = %d, want %d", sm.OrphanCount(), syncmanager.MaxOrphanBlocks)
+	}
+	if sm.IsOrphan(first) {
+		t.Error("Oldest orphan should have been evicted")
+	}
+}
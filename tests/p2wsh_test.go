@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/psbt"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestP2WSHMultiSig(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Three wallets each contribute a key
+	wallets := []*wallet.Wallet{node.Wallet, wallet.NewWallet(), wallet.NewWallet()}
+	var pubKeys [][]byte
+	for _, w := range wallets {
+		w.SetNetParams(node.Wallet.NetParams())
+		address, err := w.GenerateAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, _ := w.GetKey(address)
+		pubKeys = append(pubKeys, key.PublicKey().Bytes(true))
+	}
+	address, witnessScript, err := node.Wallet.MultiSigAddress(2, pubKeys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := keys.DecodeAddressForNetwork(address, node.Wallet.NetParams())
+	if err != nil || decoded.Type != keys.AddressP2WSH {
+		t.Fatalf("Multisig address %s decodes to %v, %v", address, decoded, err)
+	}
+	pkScript, _ := script.P2WSH(witnessScript)
+
+	funding, err := node.SendTo(address, 5*100000000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	index := -1
+	for i, output := range funding.Outputs {
+		if bytes.Equal(output.PubKeyScript, pkScript) {
+			index = i
+		}
+	}
+	if index < 0 {
+		t.Fatal("Funding transaction doesn't pay the P2WSH script")
+	}
+	prevOut := funding.Outputs[index]
+
+	// The second and third wallets co-sign a PSBT
+	payTo := types.TxOutput{Value: prevOut.Value - 10000, PubKeyScript: []byte{script.OP_TRUE}}
+	packet, err := psbt.New(contracts.SpendTx(txid(t, funding), uint32(index), payTo, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := packet.AddWitnessUTXO(0, prevOut, witnessScript); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range wallets[1:] {
+		if n, err := w.SignPSBT(packet); err != nil || n != 1 {
+			t.Fatalf("SignPSBT = %d, %v", n, err)
+		}
+	}
+	if err := packet.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	spend, err := packet.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spend.Inputs[0].SignatureScript) != 0 || len(spend.Inputs[0].Witness) != 4 {
+		t.Fatalf("Spend has a %d byte scriptSig and %d witness items", len(spend.Inputs[0].SignatureScript), len(spend.Inputs[0].Witness))
+	}
+
+	// Signatures out of key order fail the script, and the mempool says so
+	swapped := *spend
+	swapped.Inputs = []types.TxInput{spend.Inputs[0]}
+	w := spend.Inputs[0].Witness
+	swapped.Inputs[0].Witness = [][]byte{w[0], w[2], w[1], w[3]}
+	if err := node.P2P.BroadcastTransaction(&swapped); !errors.Is(err, mempool.ErrScriptFailed) {
+		t.Errorf("Broadcasting signatures out of order = %v", err)
+	}
+
+	if err := node.P2P.BroadcastTransaction(spend); err != nil {
+		t.Fatalf("Broadcasting the co-signed spend: %v", err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := node.Chain.GetTransactionLocation(txid(t, spend)); err != nil {
+		t.Errorf("Spend not confirmed: %v", err)
+	}
+}
+
+func TestWitnessEngine(t *testing.T) {
+	witnessScript := []byte{script.OP_1}
+	pkScript, _ := script.P2WSH(witnessScript)
+	_, program, _ := script.ExtractWitnessProgram(pkScript)
+
+	engine, err := script.NewWitnessEngine(program, [][]byte{witnessScript})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Execute(); err != nil {
+		t.Errorf("OP_1 witness script failed: %v", err)
+	}
+
+	// Witness programs must leave a clean stack
+	engine, _ = script.NewWitnessEngine(program, [][]byte{{1}, witnessScript})
+	if err := engine.Execute(); !errors.Is(err, script.ErrCleanStack) {
+		t.Errorf("Extra stack item = %v", err)
+	}
+	if _, err := script.NewWitnessEngine(program, [][]byte{{script.OP_2}}); !errors.Is(err, script.ErrWitnessProgramMismatch) {
+		t.Errorf("Wrong witness script = %v", err)
+	}
+	if _, err := script.NewWitnessEngine(program, nil); !errors.Is(err, script.ErrWitnessProgramEmpty) {
+		t.Errorf("Empty witness = %v", err)
+	}
+	if _, err := script.NewWitnessEngine(program, [][]byte{make([]byte, 521), witnessScript}); !errors.Is(err, script.ErrElementSize) {
+		t.Errorf("Oversized witness item = %v", err)
+	}
+
+	// Relay policy is tighter than consensus
+	if err := script.CheckStandardWitness(pkScript, [][]byte{make([]byte, 80), witnessScript}); err != nil {
+		t.Errorf("80 byte item: %v", err)
+	}
+	if err := script.CheckStandardWitness(pkScript, [][]byte{make([]byte, 81), witnessScript}); !errors.Is(err, script.ErrNonStandardWitness) {
+		t.Errorf("81 byte item = %v", err)
+	}
+	big := make([]byte, script.MaxStandardWitnessScriptSize+1)
+	if err := script.CheckStandardWitness(pkScript, [][]byte{big}); !errors.Is(err, script.ErrNonStandardWitness) {
+		t.Errorf("Oversized witness script = %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/peer"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestSlowPeerDisconnected(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	p := peer.NewPeer(local, false)
+	p.SendTimeout = 100 * time.Millisecond
+	p.Start()
+	defer p.Stop()
+
+	// The remote never reads, so the writer stalls and the queue fills up
+	ping := protocol.NewMessage(protocol.MagicMainnet, protocol.CmdPing, protocol.SerializePing(1))
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < peer.MaxSendQueue+10; i++ {
+			p.SendMessage(ping)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-p.Quit:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Slow peer was not disconnected")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SendMessage blocked after disconnect")
+	}
+}
+
+func TestInventoryTrickleBatches(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	p := peer.NewPeer(local, true)
+	p.SetTrickleInterval(20 * time.Millisecond)
+
+	// Queue before starting so all three go out in the first trickle
+	for i := byte(1); i <= 3; i++ {
+		p.QueueInventory(protocol.NewInvVect(protocol.InvTypeTx, types.Hash{i}))
+	}
+	p.QueueInventory(protocol.NewInvVect(protocol.InvTypeTx, types.Hash{1})) // duplicate
+	if p.PendingInventory() != 3 {
+		t.Fatalf("Pending inventory = %d, want 3", p.PendingInventory())
+	}
+
+	p.Start()
+	defer p.Stop()
+
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := protocol.Deserialize(bufio.NewReader(remote))
+	if err != nil {
+		t.Fatalf("Failed to read trickled message: %v", err)
+	}
+	if msg.Command != protocol.CmdInv {
+		t.Fatalf("Command = %s, want inv", msg.Command)
+	}
+
+	inv, err := protocol.DeserializeInv(msg.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Inventory) != 3 {
+		t.Errorf("Inv has %d entries, want 3", len(inv.Inventory))
+	}
+	if p.PendingInventory() != 0 {
+		t.Errorf("Queue not drained: %d pending", p.PendingInventory())
+	}
+}
@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+)
+
+func TestPeerManagementRPC(t *testing.T) {
+	h, err := testharness.New(2)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	node := h.Node(0)
+	server := rpc.NewServer(node.Wallet, node.Chain, "")
+	server.SetNode(node.P2P)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	if err := h.Connect(0, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the version handshake so the user agent is known
+	var peers []rpc.PeerInfo
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		peers, err = client.GetPeerInfo()
+		if err != nil {
+			t.Fatalf("getpeerinfo failed: %v", err)
+		}
+		if len(peers) == 1 && peers[0].SubVer != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Handshake did not complete, peers: %+v", peers)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	p := peers[0]
+	if p.Inbound {
+		t.Error("Peer should be outbound")
+	}
+	if p.SubVer != "testharness-node1" {
+		t.Errorf("SubVer = %q, want testharness-node1", p.SubVer)
+	}
+	if p.BytesSent == 0 || p.BytesRecv == 0 {
+		t.Errorf("Expected traffic in both directions, got sent=%d recv=%d", p.BytesSent, p.BytesRecv)
+	}
+	if p.ConnTime == 0 {
+		t.Error("Connection time not set")
+	}
+
+	// Unknown commands and peers are rejected
+	if err := client.AddNode("127.0.0.1:1", "bogus"); err == nil {
+		t.Error("Expected error for unknown addnode command")
+	}
+	if err := client.DisconnectNode("127.0.0.1:1"); err == nil {
+		t.Error("Expected error disconnecting unknown peer")
+	}
+	if err := client.SetBan("not-an-ip", "add", 60); err == nil {
+		t.Error("Expected error for invalid IP")
+	}
+
+	// Banning drops the connection and refuses new ones
+	if err := client.SetBan("127.0.0.1", "add", 60); err != nil {
+		t.Fatalf("setban failed: %v", err)
+	}
+	waitForPeerCount(t, node, 0)
+
+	if err := h.Connect(1, 0, 500*time.Millisecond); err == nil {
+		t.Error("Banned IP should not be able to connect")
+	}
+
+	// Lifting the ban allows reconnection, which disconnectnode drops again
+	if err := client.SetBan("127.0.0.1", "remove", 0); err != nil {
+		t.Fatalf("setban remove failed: %v", err)
+	}
+	if err := h.Connect(1, 0, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err = client.GetPeerInfo()
+	if err != nil || len(peers) != 1 {
+		t.Fatalf("Expected one peer, got %d (%v)", len(peers), err)
+	}
+	if !peers[0].Inbound {
+		t.Error("Reconnected peer should be inbound")
+	}
+	if err := client.DisconnectNode(peers[0].Addr); err != nil {
+		t.Fatalf("disconnectnode failed: %v", err)
+	}
+	waitForPeerCount(t, node, 0)
+}
+
+func waitForPeerCount(t *testing.T, node *testharness.TestNode, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for node.P2P.PeerCount() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Peer count = %d, want %d", node.P2P.PeerCount(), want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestTargetToCompact(t *testing.T) {
+	for _, bits := range []uint32{consensus.DifficultyOneBits, consensus.RegtestPowLimitBits, 0x1b0404cb, 0x1c01fffe, 0x03123456} {
+		if got := consensus.TargetToCompact(consensus.CompactToTarget(bits)); got != bits {
+			t.Errorf("Round trip of %08x = %08x", bits, got)
+		}
+	}
+}
+
+// retargetChain is a mainnet adjustment interval at bits whose last
+// block came timespan seconds after its first
+func retargetChain(bits uint32, timespan uint32) headerChain {
+	chain := make(headerChain, 2016)
+	for h := range chain {
+		chain[h] = types.BlockHeader{Version: 1, Bits: bits}
+	}
+	chain[2015].Timestamp = timespan
+	return chain
+}
+
+func TestNextWorkRequired(t *testing.T) {
+	rules := consensus.NewMainnetRules()
+	if interval := rules.DifficultyAdjustmentInterval(); interval != 2016 {
+		t.Fatalf("Adjustment interval = %d, want 2016", interval)
+	}
+
+	const twoWeeks = 14 * 24 * 60 * 60
+	tests := []struct {
+		name     string
+		bits     uint32
+		timespan uint32
+		want     uint32
+	}{
+		{"on schedule", 0x1c00ffff, twoWeeks, 0x1c00ffff},
+		{"twice as slow", 0x1c00ffff, 2 * twoWeeks, 0x1c01fffe},
+		{"slower than 4x", 0x1c00ffff, 10 * twoWeeks, 0x1c03fffc},
+		{"faster than 4x", 0x1c00ffff, twoWeeks / 10, 0x1b3fffc0},
+		{"capped at the limit", consensus.DifficultyOneBits, 2 * twoWeeks, consensus.DifficultyOneBits},
+	}
+	for _, tt := range tests {
+		got, err := rules.NextWorkRequired(retargetChain(tt.bits, tt.timespan), 2016)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: %08x, want %08x", tt.name, got, tt.want)
+		}
+	}
+
+	// Between retargets the target stays put
+	if got, _ := rules.NextWorkRequired(retargetChain(0x1c00ffff, 1), 2015); got != 0x1c00ffff {
+		t.Errorf("Mid-interval target %08x, want 1c00ffff", got)
+	}
+
+	// Regtest never retargets
+	regtest := consensus.NewRegtestRules()
+	if got, _ := regtest.NextWorkRequired(retargetChain(0x1c00ffff, 1), 2016); got != consensus.RegtestPowLimitBits {
+		t.Errorf("Regtest target %08x, want %08x", got, consensus.RegtestPowLimitBits)
+	}
+}
+
+func TestCheckProofOfWork(t *testing.T) {
+	mainnet := consensus.NewMainnetRules()
+	if err := mainnet.CheckProofOfWork(types.Hash{}, consensus.RegtestPowLimitBits); err == nil {
+		t.Error("Mainnet accepted regtest's target")
+	}
+	if err := mainnet.CheckProofOfWork(types.Hash{0, 0, 0, 1}, consensus.DifficultyOneBits); !errors.Is(err, consensus.ErrHighHash) {
+		t.Errorf("Hash above target: got %v, want ErrHighHash", err)
+	}
+	if err := mainnet.CheckProofOfWork(types.Hash{0, 0, 0, 0, 1}, consensus.DifficultyOneBits); err != nil {
+		t.Errorf("Hash below target: %v", err)
+	}
+}
+
+func TestHarnessMinesRegtestBlocksInstantly(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	blocks, err := h.Node(0).MineBlocks(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, block := range blocks {
+		hash, _ := serialization.HashBlockHeader(&block.Header)
+		if block.Header.Bits != consensus.RegtestPowLimitBits {
+			t.Fatalf("Block %d bits %08x", i+1, block.Header.Bits)
+		}
+		if err := h.Rules.CheckProofOfWork(hash, block.Header.Bits); err != nil {
+			t.Fatalf("Block %d: %v", i+1, err)
+		}
+	}
+}
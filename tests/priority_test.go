@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"math"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestCoinAgePriority(t *testing.T) {
+	pool := mempool.NewMempool(1000000, 1000, 3600)
+
+	// An old coin and one from the tip, at height 100
+	coins := map[types.OutPoint]struct {
+		value  int64
+		height uint64
+	}{
+		{Hash: types.Hash{1}}: {100000000, 10},
+		{Hash: types.Hash{2}}: {100000000, 100},
+	}
+	pool.SetCoinLookup(func(outpoint types.OutPoint) (int64, uint64, bool) {
+		coin, ok := coins[outpoint]
+		return coin.value, coin.height, ok
+	})
+
+	old := rbfSpend(types.Hash{1}, 0xffffffff, 99999000)
+	fresh := rbfSpend(types.Hash{2}, 0xffffffff, 99900000)
+	if err := pool.Add(old, 1000, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Add(fresh, 100000, 100); err != nil {
+		t.Fatal(err)
+	}
+	child := rbfSpend(txid(t, old), 0xffffffff, 99990000)
+	if err := pool.Add(child, 9000, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, _ := pool.Get(txid(t, old))
+	size := float64(entry.Size)
+	if want := 100000000 * 91 / size; math.Abs(entry.StartingPriority-want) > 1e-6 {
+		t.Errorf("Starting priority = %f, want %f", entry.StartingPriority, want)
+	}
+	if want := 100000000 * 93 / size; math.Abs(entry.Priority(103)-want) > 1e-6 {
+		t.Errorf("Priority two blocks on = %f, want %f", entry.Priority(103), want)
+	}
+	if entry.StartingPriority < mempool.HighPriority {
+		t.Errorf("A 91 block old bitcoin is below the free threshold: %f", entry.StartingPriority)
+	}
+
+	// Unconfirmed inputs have no age
+	if entry, _ := pool.Get(txid(t, child)); entry.StartingPriority != 0 || entry.Priority(150) != 0 {
+		t.Errorf("Child priority = %f, %f, want 0", entry.StartingPriority, entry.Priority(150))
+	}
+
+	queue := mempool.NewPriorityQueue(pool)
+	if top := queue.GetTopTransactions(1); top[0].TxHash != txid(t, fresh) {
+		t.Error("Fee rate order didn't put the highest fee rate first")
+	}
+	order, err := mempool.ParseSelectionOrder("priority")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.SetOrder(order, 101)
+	if top := queue.GetTopTransactions(1); top[0].TxHash != txid(t, old) {
+		t.Error("Priority order didn't put the oldest coins first")
+	}
+
+	selected, err := queue.SelectTransactionsWithDependencies(1000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 3 || selected[0] != old || selected[1] != fresh || selected[2] != child {
+		t.Errorf("Selected %d transactions, want old coins, new coins, then the unconfirmed child", len(selected))
+	}
+
+	if _, err := mempool.ParseSelectionOrder("age"); err == nil {
+		t.Error("Unknown order accepted")
+	}
+}
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+)
+
+func TestPropagationPercentiles(t *testing.T) {
+	m := monitoring.NewMetrics()
+	start := time.Unix(1700000000, 0)
+
+	for i := 1; i <= 100; i++ {
+		hash := string(rune('a' + i))
+		m.RecordInvSeen(monitoring.PropagationTx, hash, start)
+		m.RecordRelayed(monitoring.PropagationTx, hash, start.Add(time.Duration(i)*time.Millisecond))
+	}
+	// Relays of objects never announced are ignored
+	m.RecordRelayed(monitoring.PropagationTx, "unannounced", start.Add(time.Hour))
+
+	stats := m.GetPropagationStats(monitoring.PropagationTx)
+	if stats.Count != 100 {
+		t.Errorf("Count = %d, want 100", stats.Count)
+	}
+	if stats.P50 != 50*time.Millisecond || stats.P90 != 90*time.Millisecond ||
+		stats.P99 != 99*time.Millisecond || stats.Max != 100*time.Millisecond {
+		t.Errorf("Percentiles = %+v", stats)
+	}
+	if blocks := m.GetPropagationStats(monitoring.PropagationBlock); blocks.Count != 0 {
+		t.Errorf("Block stats = %+v, want none", blocks)
+	}
+
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE learnbitcoin_tx_propagation_seconds summary",
+		`learnbitcoin_tx_propagation_seconds{quantile="0.5"} 0.05`,
+		"learnbitcoin_tx_propagation_seconds_count 100",
+		"learnbitcoin_block_propagation_seconds_count 0",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Metrics output missing %q", want)
+		}
+	}
+}
+
+func TestPropagationAcrossNodes(t *testing.T) {
+	h, err := testharness.New(2)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	if err := h.Connect(0, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Node(0).MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.WaitForSync(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := h.Node(0).SendTo(h.Node(1).Address, 100000000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+	if err := h.WaitForMempool(txHash, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := h.Node(1).P2P.Metrics()
+	waitUntil(t, "transaction relay to be measured", func() bool {
+		return metrics.GetPropagationStats(monitoring.PropagationTx).Count == 1
+	})
+	if blocks := metrics.GetPropagationStats(monitoring.PropagationBlock); blocks.Count == 0 {
+		t.Error("No block propagation measured")
+	}
+
+	// The originating node never saw an inv for its own objects
+	if stats := h.Node(0).P2P.Metrics().GetPropagationStats(monitoring.PropagationTx); stats.Count != 0 {
+		t.Errorf("Sender measured its own transaction: %+v", stats)
+	}
+}
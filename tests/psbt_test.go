@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/psbt"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestPSBTMultiSigCoSigning(t *testing.T) {
+	var signers []*keys.PrivateKey
+	var pubKeys [][]byte
+	for i := 0; i < 3; i++ {
+		key, err := keys.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, key)
+		pubKeys = append(pubKeys, key.PublicKey().Bytes(true))
+	}
+	multiSig, err := contracts.MultiSigScript(2, pubKeys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	funding := &types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: types.Hash{1}, SignatureScript: []byte{0x51}, Sequence: 0xffffffff}},
+		Outputs: []types.TxOutput{{Value: 100000, PubKeyScript: multiSig}},
+	}
+	spend := contracts.SpendTx(txid(t, funding), 0, types.TxOutput{Value: 90000, PubKeyScript: []byte{0x51}}, 0)
+
+	packet, err := psbt.New(spend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := packet.Sign(0, signers[0]); !errors.Is(err, psbt.ErrMissingUTXO) {
+		t.Errorf("Signing without the UTXO = %v", err)
+	}
+	if err := packet.AddUTXO(0, funding, nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The third and first signers sign their own copies
+	var copies []*psbt.Packet
+	for _, key := range []*keys.PrivateKey{signers[2], signers[0]} {
+		signed, err := psbt.B64Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := signed.Sign(0, key); err != nil {
+			t.Fatal(err)
+		}
+		copies = append(copies, signed)
+	}
+
+	outsider, _ := keys.GeneratePrivateKey()
+	if err := copies[0].Sign(0, outsider); !errors.Is(err, psbt.ErrKeyNotInScript) {
+		t.Errorf("Outsider signing = %v", err)
+	}
+	if err := copies[0].Finalize(); !errors.Is(err, psbt.ErrNotEnoughSigs) {
+		t.Errorf("Finalizing with one signature = %v", err)
+	}
+	if _, err := copies[0].Extract(); !errors.Is(err, psbt.ErrIncomplete) {
+		t.Errorf("Extracting unfinalized = %v", err)
+	}
+
+	if err := copies[0].Combine(copies[1]); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(copies[0].Inputs[0].PartialSigs); n != 2 {
+		t.Fatalf("Combined PSBT has %d signatures, want 2", n)
+	}
+
+	// A round trip keeps every field
+	data, err := copies[0].Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := psbt.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := parsed.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Error("PSBT changed in a round trip")
+	}
+
+	// Signatures go in the script's key order, not the order they came in
+	if err := parsed.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	final, err := parsed.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := contracts.Verify(final, 0, funding.Outputs[0]); err != nil {
+		t.Errorf("Finalized spend doesn't verify: %v", err)
+	}
+	if parsed.Inputs[0].PartialSigs != nil {
+		t.Error("Finalizing kept the partial signatures")
+	}
+
+	other, _ := psbt.New(contracts.SpendTx(types.Hash{2}, 0, types.TxOutput{Value: 1}, 0))
+	if err := parsed.Combine(other); !errors.Is(err, psbt.ErrMismatchedPSBT) {
+		t.Errorf("Combining different transactions = %v", err)
+	}
+	if _, err := psbt.Parse([]byte("psbx\xff")); !errors.Is(err, psbt.ErrInvalidMagic) {
+		t.Errorf("Bad magic = %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// rbfSpend spends prev:0 with the given sequence, paying value
+func rbfSpend(prev types.Hash, sequence uint32, value int64) *types.Transaction {
+	return &types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: prev, OutputIndex: 0, SignatureScript: []byte{0x51}, Sequence: sequence}},
+		Outputs: []types.TxOutput{{Value: value, PubKeyScript: []byte{0x51}}},
+	}
+}
+
+func TestMempoolReplacesOnlySignalingTransactions(t *testing.T) {
+	pool := mempool.NewMempool(1000000, 1000, 3600)
+
+	final := rbfSpend(types.Hash{1}, transaction.SequenceFinal, 90000)
+	if err := pool.Add(final, 1000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Add(rbfSpend(types.Hash{1}, transaction.SequenceFinal, 80000), 20000, 1); err == nil {
+		t.Error("Transaction that didn't signal RBF was replaced")
+	}
+
+	replaceable := rbfSpend(types.Hash{2}, transaction.MaxRBFSequence, 90000)
+	if err := pool.Add(replaceable, 1000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := pool.Get(txid(t, replaceable)); !entry.SignalsRBF {
+		t.Fatal("Signaling transaction not marked replaceable")
+	}
+	replacement := rbfSpend(types.Hash{2}, transaction.SequenceFinal, 80000)
+	if err := pool.Add(replacement, 20000, 1); err != nil {
+		t.Fatalf("Signaling transaction not replaced: %v", err)
+	}
+	if pool.Exists(txid(t, replaceable)) || !pool.Exists(txid(t, replacement)) {
+		t.Error("Replacement did not evict the original")
+	}
+}
+
+func TestMempoolRBFSignalIsInherited(t *testing.T) {
+	pool := mempool.NewMempool(1000000, 1000, 3600)
+
+	parent := rbfSpend(types.Hash{3}, transaction.MaxRBFSequence, 90000)
+	if err := pool.Add(parent, 1000, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The child doesn't signal itself but spends a replaceable parent
+	child := rbfSpend(txid(t, parent), transaction.SequenceFinal, 80000)
+	if err := pool.Add(child, 1000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := pool.Get(txid(t, child)); !entry.SignalsRBF {
+		t.Fatal("Child of a replaceable parent not marked replaceable")
+	}
+
+	conflict := rbfSpend(txid(t, parent), transaction.SequenceFinal, 70000)
+	if err := pool.Add(conflict, 20000, 1); err != nil {
+		t.Errorf("Child of a replaceable parent not replaced: %v", err)
+	}
+}
+
+// Fee rates keep fractions of a sat/vB, so 1.9 sat/vB replaces 1.5 sat/vB
+// rather than both rounding down to 1
+func TestMempoolRBFComparesFractionalFeeRates(t *testing.T) {
+	pool := mempool.NewMempool(1000000, 100, 3600)
+	pool.SetRelayFees(100, 100)
+
+	original := rbfSpend(types.Hash{4}, transaction.MaxRBFSequence, 90000)
+	size := mempool.CalculateTransactionSize(original)
+	if err := pool.Add(original, size*15/10, 1); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := pool.Get(txid(t, original))
+	if want := mempool.CalculateFeeRate(size*15/10, size); entry.FeeRate != want || want/1000 != 1 {
+		t.Fatalf("Fee rate %d, want %d", entry.FeeRate, want)
+	}
+
+	replacement := rbfSpend(types.Hash{4}, transaction.MaxRBFSequence, 80000)
+	if err := pool.Add(replacement, size*19/10, 1); err != nil {
+		t.Fatalf("1.9 sat/vB did not replace 1.5 sat/vB: %v", err)
+	}
+	if pool.Exists(txid(t, original)) {
+		t.Error("Replacement did not evict the original")
+	}
+}
+
+func TestWalletOptInRBF(t *testing.T) {
+	w := wallet.NewWallet()
+	addr, err := w.GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := keys.DecodeAddress(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := script.P2PKH(decoded.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.AddUTXO(utxo.NewUTXO(types.Hash{4}, 0, types.TxOutput{Value: 100000, PubKeyScript: pkScript}, 1, false))
+	w.AddUTXO(utxo.NewUTXO(types.Hash{4}, 1, types.TxOutput{Value: 100000, PubKeyScript: pkScript}, 1, false))
+
+	tx, err := w.SendWithFee(addr, 10000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.SignalsRBF(tx) {
+		t.Error("Wallet signaled RBF by default")
+	}
+
+	w.SetOptInRBF(true)
+	tx, err = w.SendWithFee(addr, 10000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, input := range tx.Inputs {
+		if input.Sequence != transaction.MaxRBFSequence {
+			t.Errorf("Input %d sequence = %#x, want %#x", i, input.Sequence, transaction.MaxRBFSequence)
+		}
+	}
+}
+
+func txid(t *testing.T, tx *types.Transaction) types.Hash {
+	t.Helper()
+	hash, err := serialization.HashTransaction(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestRecentRejects(t *testing.T) {
+	rr := mempool.NewRecentRejects(3)
+
+	invalid := fmt.Errorf("bad signature")
+	lowFee := fmt.Errorf("%w: 0 < 1", mempool.ErrLowFee)
+	rr.Add(types.Hash{1}, invalid)
+	rr.Add(types.Hash{2}, lowFee)
+	rr.Add(types.Hash{3}, mempool.ErrAlreadyInMempool)
+	rr.Add(types.Hash{3}, fmt.Errorf("%w: parent unknown", mempool.ErrMissingInputs))
+
+	if rr.Len() != 2 || rr.Contains(types.Hash{3}) {
+		t.Fatalf("Duplicates and missing inputs must not be recorded, have %d entries", rr.Len())
+	}
+	if entry, ok := rr.Lookup(types.Hash{2}); !ok || !entry.Policy || entry.Reason != lowFee.Error() {
+		t.Errorf("Lookup = %+v, %v", entry, ok)
+	}
+	if entry, _ := rr.Lookup(types.Hash{1}); entry.Policy {
+		t.Error("An invalid transaction was recorded as a policy rejection")
+	}
+
+	// Full: the oldest entry goes
+	rr.Add(types.Hash{4}, invalid)
+	rr.Add(types.Hash{5}, invalid)
+	if rr.Len() != 3 || rr.Contains(types.Hash{1}) || !rr.Contains(types.Hash{5}) {
+		t.Errorf("Eviction kept the wrong entries, %d left", rr.Len())
+	}
+
+	if dropped := rr.ResetPolicy(); dropped != 1 || rr.Contains(types.Hash{2}) || !rr.Contains(types.Hash{4}) {
+		t.Errorf("ResetPolicy dropped %d", dropped)
+	}
+}
+
+// expectQuiet sends a ping and fails if the node sends command before
+// answering it
+func (rp *rawPeer) expectQuiet(command string) {
+	rp.t.Helper()
+
+	rp.send(protocol.CmdPing, protocol.SerializePing(7))
+	for {
+		msg := rp.expectAny()
+		if msg.Command == command {
+			rp.t.Fatalf("Unexpected %s", command)
+		}
+		if msg.Command == protocol.CmdPong {
+			return
+		}
+	}
+}
+
+// expectAny reads the next message
+func (rp *rawPeer) expectAny() *protocol.Message {
+	rp.t.Helper()
+
+	rp.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := protocol.Deserialize(rp.reader)
+	if err != nil {
+		rp.t.Fatalf("Waiting for a message: %v", err)
+	}
+	return msg
+}
+
+func TestRecentRejectsFromPeers(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	node := h.Node(0)
+	blocks, err := node.MineBlocks(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbaseHash, _ := serialization.HashTransaction(&blocks[0].Transactions[0])
+	coinbaseValue := blocks[0].Transactions[0].Outputs[0].Value
+
+	rp := dialRawPeer(t, node.P2P.Addr())
+	defer rp.close()
+
+	// Spends more than its input: invalid whatever the tip
+	invalid := &types.Transaction{
+		Version: 1,
+		Inputs: []types.TxInput{
+			{PrevTxHash: coinbaseHash, OutputIndex: 0, SignatureScript: []byte{0x01}, Sequence: 0xFFFFFFFF},
+		},
+		Outputs: []types.TxOutput{{Value: coinbaseValue + 1, PubKeyScript: []byte{0x51}}},
+	}
+	invalidHash, _ := serialization.HashTransaction(invalid)
+	payload, _ := serialization.SerializeTransaction(invalid)
+	rp.send(protocol.CmdTx, payload)
+	rp.expect(protocol.CmdReject)
+
+	// Sent again it is dropped without being checked
+	rp.send(protocol.CmdTx, payload)
+	rp.expectQuiet(protocol.CmdReject)
+
+	// Announced again it is not requested
+	inv := protocol.NewInvMessage()
+	inv.AddInvVect(protocol.NewInvVect(protocol.InvTypeTx, invalidHash))
+	invPayload, _ := inv.Serialize()
+	rp.send(protocol.CmdInv, invPayload)
+	rp.expectQuiet(protocol.CmdGetData)
+
+	// Pays no fee: refused for policy
+	free := *invalid
+	free.Outputs = []types.TxOutput{{Value: coinbaseValue, PubKeyScript: []byte{0x51}}}
+	freeHash, _ := serialization.HashTransaction(&free)
+	payload, _ = serialization.SerializeTransaction(&free)
+	rp.send(protocol.CmdTx, payload)
+	rp.expect(protocol.CmdReject)
+
+	rejects := node.P2P.RecentRejects()
+	if !rejects.Contains(invalidHash) || !rejects.Contains(freeHash) {
+		t.Fatalf("Rejections not recorded, have %d", rejects.Len())
+	}
+
+	// A new tip forgets the policy rejection only
+	tip, _ := node.BestHash()
+	if err := node.P2P.ProcessNewBlock(blockOn(t, tip, 2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if !rejects.Contains(invalidHash) || rejects.Contains(freeHash) {
+		t.Errorf("After a new tip: invalid kept %v, free kept %v", rejects.Contains(invalidHash), rejects.Contains(freeHash))
+	}
+}
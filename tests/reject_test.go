@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	syncmanager "github.com/pouria-shahmiri/learn-bitcoin/pkg/network/sync"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// rawPeer speaks the wire protocol directly to a node
+type rawPeer struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialRawPeer connects to addr and completes the version handshake
+func dialRawPeer(t *testing.T, addr string) *rawPeer {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := &rawPeer{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	sendRawVersion(t, conn, protocol.ProtocolVersion)
+	rp.expect(protocol.CmdVerAck)
+	rp.expect(protocol.CmdVersion)
+	rp.send(protocol.CmdVerAck, nil)
+
+	return rp
+}
+
+func (rp *rawPeer) send(command string, payload []byte) {
+	rp.t.Helper()
+
+	data, err := protocol.NewMessage(protocol.MagicMainnet, command, payload).Serialize()
+	if err != nil {
+		rp.t.Fatal(err)
+	}
+	if _, err := rp.conn.Write(data); err != nil {
+		rp.t.Fatalf("Failed to send %s: %v", command, err)
+	}
+}
+
+// expect reads messages until one with the given command arrives
+func (rp *rawPeer) expect(command string) *protocol.Message {
+	rp.t.Helper()
+
+	rp.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		msg, err := protocol.Deserialize(rp.reader)
+		if err != nil {
+			rp.t.Fatalf("Waiting for %s: %v", command, err)
+		}
+		if msg.Command == command {
+			return msg
+		}
+	}
+}
+
+func (rp *rawPeer) close() {
+	rp.conn.Close()
+}
+
+func TestRejectMessageRoundTrip(t *testing.T) {
+	original := protocol.NewRejectMessage(protocol.CmdTx, protocol.RejectInsufficientFee, "fee too low", types.Hash{0xaa})
+	data, err := original.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := protocol.DeserializeReject(data)
+	if err != nil {
+		t.Fatalf("Failed to deserialize: %v", err)
+	}
+	if *decoded != *original {
+		t.Errorf("Round trip mismatch: %+v != %+v", decoded, original)
+	}
+
+	// Non tx/block rejects carry no hash
+	data, _ = protocol.NewRejectMessage(protocol.CmdVersion, protocol.RejectObsolete, "old", types.Hash{}).Serialize()
+	decoded, err = protocol.DeserializeReject(data)
+	if err != nil || decoded.Message != protocol.CmdVersion || decoded.Code != protocol.RejectObsolete {
+		t.Errorf("Unexpected version reject: %+v (%v)", decoded, err)
+	}
+}
+
+func TestNotFoundAndRejectFromNode(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	blocks, err := h.Node(0).MineBlocks(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbaseHash, _ := serialization.HashTransaction(&blocks[0].Transactions[0])
+	coinbaseValue := blocks[0].Transactions[0].Outputs[0].Value
+
+	rp := dialRawPeer(t, h.Node(0).P2P.Addr())
+	defer rp.close()
+
+	// getdata for something the node doesn't have
+	missing := types.Hash{0xde, 0xad}
+	gd := protocol.NewGetDataMessage()
+	gd.AddInvVect(protocol.NewInvVect(protocol.InvTypeBlock, missing))
+	payload, _ := gd.Serialize()
+	rp.send(protocol.CmdGetData, payload)
+
+	nf, err := protocol.DeserializeNotFound(rp.expect(protocol.CmdNotFound).Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nf.Inventory) != 1 || nf.Inventory[0].Hash != missing {
+		t.Errorf("Unexpected notfound: %+v", nf.Inventory)
+	}
+
+	// A transaction spending more than its input is rejected
+	tx := &types.Transaction{
+		Version: 1,
+		Inputs: []types.TxInput{
+			{PrevTxHash: coinbaseHash, OutputIndex: 0, SignatureScript: []byte{0x01}, Sequence: 0xFFFFFFFF},
+		},
+		Outputs: []types.TxOutput{{Value: coinbaseValue + 1, PubKeyScript: []byte{0x51}}},
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+	payload, _ = serialization.SerializeTransaction(tx)
+	rp.send(protocol.CmdTx, payload)
+
+	reject, err := protocol.DeserializeReject(rp.expect(protocol.CmdReject).Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reject.Message != protocol.CmdTx || reject.Code != protocol.RejectInvalid || reject.Hash != txHash {
+		t.Errorf("Unexpected reject: %s", reject)
+	}
+
+	// One paying no fee is refused for its fee rate
+	tx.Outputs[0].Value = coinbaseValue
+	txHash, _ = serialization.HashTransaction(tx)
+	payload, _ = serialization.SerializeTransaction(tx)
+	rp.send(protocol.CmdTx, payload)
+
+	reject, err = protocol.DeserializeReject(rp.expect(protocol.CmdReject).Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reject.Code != protocol.RejectInsufficientFee || reject.Hash != txHash {
+		t.Errorf("Unexpected reject for a free transaction: %s", reject)
+	}
+}
+
+// recordingSender collects messages instead of sending them
+type recordingSender struct {
+	addr string
+	sent []*protocol.Message
+}
+
+func (r *recordingSender) SendMessage(msg *protocol.Message) { r.sent = append(r.sent, msg) }
+func (r *recordingSender) Address() string                   { return r.addr }
+
+func TestSyncManagerRerequestsOnNotFound(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	sm := syncmanager.NewSyncManager(chain)
+	a := &recordingSender{addr: "10.0.0.1:8333"}
+	b := &recordingSender{addr: "10.0.0.2:8333"}
+
+	hash := types.Hash{0x42}
+	inv := protocol.NewInvMessage()
+	inv.AddInvVect(protocol.NewInvVect(protocol.InvTypeBlock, hash))
+	if err := sm.HandleInv(inv, a); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.sent) != 1 {
+		t.Fatalf("Expected getdata to peer A, got %d messages", len(a.sent))
+	}
+
+	nf := protocol.NewNotFoundMessage()
+	nf.AddInvVect(protocol.NewInvVect(protocol.InvTypeBlock, hash))
+
+	// notfound from a peer we didn't ask is ignored
+	if err := sm.HandleNotFound(nf, b, []syncmanager.MessageSender{a}); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.sent) != 1 {
+		t.Error("Unsolicited notfound should not trigger a request")
+	}
+
+	if err := sm.HandleNotFound(nf, a, []syncmanager.MessageSender{b}); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.sent) != 1 || b.sent[0].Command != protocol.CmdGetData {
+		t.Fatalf("Expected getdata to peer B, got %d messages", len(b.sent))
+	}
+	if !sm.IsRequested(hash) {
+		t.Error("Block should still be tracked as requested")
+	}
+
+	// With nobody else to ask the request is dropped
+	if err := sm.HandleNotFound(nf, b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if sm.IsRequested(hash) {
+		t.Error("Block should no longer be tracked")
+	}
+}
@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/config"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// The minimum relay fee gates acceptance and the incremental relay fee
+// what a replacement adds, each on its own
+func TestMempoolRelayFees(t *testing.T) {
+	pool := mempool.NewMempool(1000000, 1000, 3600)
+	pool.SetRelayFees(2000, 5000)
+
+	original := rbfSpend(types.Hash{1}, transaction.MaxRBFSequence, 90000)
+	size := mempool.CalculateTransactionSize(original)
+	if err := pool.Add(original, transaction.FeeAtRate(1500, size), 1); !errors.Is(err, mempool.ErrLowFee) {
+		t.Fatalf("Below the min relay fee: got %v, want ErrLowFee", err)
+	}
+	if err := pool.Add(original, transaction.FeeAtRate(2000, size), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 sat/vB more isn't the 5 the incremental relay fee asks for
+	replacement := rbfSpend(types.Hash{1}, transaction.MaxRBFSequence, 80000)
+	if err := pool.Add(replacement, transaction.FeeAtRate(6000, size), 1); err == nil {
+		t.Error("Replacement below the incremental relay fee accepted")
+	}
+	if err := pool.Add(replacement, transaction.FeeAtRate(7000, size), 1); err != nil {
+		t.Fatalf("Replacement paying the incremental relay fee refused: %v", err)
+	}
+
+	policy := mempool.DefaultPolicy()
+	policy.MinRelayTxFee, policy.IncrementalRelayFee = 1000, 3000
+	validator := mempool.NewPolicyValidator(policy, pool)
+	entry, _ := pool.Get(txid(t, replacement))
+	conflict := rbfSpend(types.Hash{1}, transaction.MaxRBFSequence, 70000)
+	if err := validator.ValidateReplacement(conflict, entry.Fee+transaction.FeeAtRate(2000, size), []*mempool.MempoolEntry{entry}); !errors.Is(err, mempool.ErrLowFee) {
+		t.Errorf("Policy replacement below the incremental relay fee: got %v, want ErrLowFee", err)
+	}
+	if err := validator.ValidateReplacement(conflict, entry.Fee+transaction.FeeAtRate(3000, size), []*mempool.MempoolEntry{entry}); err != nil {
+		t.Errorf("Policy replacement paying the incremental relay fee refused: %v", err)
+	}
+}
+
+func TestRelayFeeConfig(t *testing.T) {
+	t.Setenv("MIN_RELAY_TX_FEE", "1500")
+	t.Setenv("INCREMENTAL_RELAY_FEE", "2500")
+	cfg := config.LoadFromEnv()
+	if cfg.MinRelayTxFee != 1500 || cfg.IncrementalRelayFee != 2500 {
+		t.Errorf("Relay fees %d and %d, want 1500 and 2500", cfg.MinRelayTxFee, cfg.IncrementalRelayFee)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.IncrementalRelayFee = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Negative incremental relay fee accepted")
+	}
+}
+
+func TestMempoolInfoReportsRelayFees(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+	node.P2P.Mempool.SetRelayFees(1200, 3400)
+
+	info, err := client.GetMempoolInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MinRelayFee != 1200 || info.IncrementalFee != 3400 {
+		t.Errorf("Relay fees %d and %d, want 1200 and 3400", info.MinRelayFee, info.IncrementalFee)
+	}
+}
@@ -0,0 +1,443 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/monitoring"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/reorg"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func newReorgSimulator(t *testing.T, seed int64) *reorg.Simulator {
+	t.Helper()
+
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Close() })
+
+	sim, err := reorg.NewSimulator(chain, seed)
+	if err != nil {
+		t.Fatalf("Failed to create simulator: %v", err)
+	}
+	return sim
+}
+
+func TestReorgRestoresSpentOutputs(t *testing.T) {
+	sim := newReorgSimulator(t, 1)
+
+	if _, err := sim.Mine(5); err != nil {
+		t.Fatal(err)
+	}
+	spends, err := sim.Spend(3)
+	if err != nil || len(spends) != 3 {
+		t.Fatalf("Spend = %d txs, %v", len(spends), err)
+	}
+	if _, err := sim.Mine(2); err != nil {
+		t.Fatal(err)
+	}
+	if sim.Mempool.Size() != 0 {
+		t.Fatalf("Mined spends still in mempool: %d", sim.Mempool.Size())
+	}
+
+	// An empty branch replaces the blocks that confirmed the spends
+	branch, err := sim.BuildFork(2, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Reorg(branch); err != nil {
+		t.Fatalf("Reorg failed: %v", err)
+	}
+
+	height, _ := sim.Chain.GetBestBlockHeight()
+	if height != 8 {
+		t.Errorf("Height after reorg = %d, want 8", height)
+	}
+	for _, tx := range spends {
+		txHash, _ := serialization.HashTransaction(tx)
+		if !sim.Mempool.Exists(txHash) {
+			t.Errorf("Spend %s was not returned to the mempool", txHash)
+		}
+	}
+}
+
+func TestReorgStress(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		sim := newReorgSimulator(t, seed)
+		if _, err := sim.Mine(4); err != nil {
+			t.Fatal(err)
+		}
+
+		for round := 0; round < 6; round++ {
+			if _, err := sim.Spend(3); err != nil {
+				t.Fatalf("seed %d round %d: %v", seed, round, err)
+			}
+			if _, err := sim.Mine(1 + round%3); err != nil {
+				t.Fatalf("seed %d round %d: %v", seed, round, err)
+			}
+			if _, err := sim.Spend(2); err != nil {
+				t.Fatalf("seed %d round %d: %v", seed, round, err)
+			}
+
+			branch, err := sim.BuildFork(1+round%4, 1, 3)
+			if err != nil {
+				t.Fatalf("seed %d round %d: %v", seed, round, err)
+			}
+			if err := sim.Reorg(branch); err != nil {
+				t.Fatalf("seed %d round %d: reorg left the node inconsistent: %v", seed, round, err)
+			}
+		}
+	}
+}
+
+func TestReorgMaxDepth(t *testing.T) {
+	sim := newReorgSimulator(t, 1)
+	if _, err := sim.Mine(5); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := monitoring.NewMetrics()
+	var alerts []reorg.DeepReorgAlert
+	sim.Handler.SetMaxReorgDepth(2)
+	sim.Handler.SetMetrics(metrics)
+	sim.Handler.OnDeepReorg(func(alert reorg.DeepReorgAlert) {
+		alerts = append(alerts, alert)
+	})
+
+	tip, _ := sim.Chain.GetBestBlockHash()
+	branch, err := sim.BuildFork(3, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Handler.HandleReorg(branch); !errors.Is(err, reorg.ErrReorgTooDeep) {
+		t.Fatalf("HandleReorg = %v, want ErrReorgTooDeep", err)
+	}
+	if after, _ := sim.Chain.GetBestBlockHash(); after != tip {
+		t.Errorf("Tip moved to %s after a refused reorg", after)
+	}
+	if err := sim.Verify(); err != nil {
+		t.Errorf("Refused reorg left the node inconsistent: %v", err)
+	}
+
+	if len(alerts) != 1 || alerts[0].Depth != 3 || alerts[0].MaxDepth != 2 || alerts[0].CurrentTip != tip {
+		t.Errorf("Alerts = %+v", alerts)
+	}
+	if metrics.GetRejectedReorgCount() != 1 || metrics.GetDeepestRejectedReorg() != 3 {
+		t.Errorf("Rejected reorgs = %d, deepest %d", metrics.GetRejectedReorgCount(), metrics.GetDeepestRejectedReorg())
+	}
+
+	// A reorg within the limit still goes through
+	branch, err = sim.BuildFork(2, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Reorg(branch); err != nil {
+		t.Fatalf("Reorg within the limit failed: %v", err)
+	}
+	if metrics.GetReorgCount() != 1 || len(alerts) != 1 {
+		t.Errorf("Reorgs = %d, alerts = %d", metrics.GetReorgCount(), len(alerts))
+	}
+}
+
+func TestReorgWalletDisconnect(t *testing.T) {
+	sim := newReorgSimulator(t, 1)
+	if _, err := sim.Mine(5); err != nil {
+		t.Fatal(err)
+	}
+	spends, err := sim.Spend(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mined, err := sim.Mine(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.Wallet.GetUnconfirmedBalance() != 0 {
+		t.Fatalf("Unconfirmed balance before reorg = %d", sim.Wallet.GetUnconfirmedBalance())
+	}
+
+	branch, err := sim.BuildFork(2, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Reorg(branch); err != nil {
+		t.Fatalf("Reorg failed: %v", err)
+	}
+
+	// Coinbases of the disconnected blocks are gone for good
+	for _, block := range mined {
+		coinbaseHash, _ := serialization.HashTransaction(&block.Transactions[0])
+		if _, ok := sim.Wallet.OutputStatus(utxo.NewOutPoint(coinbaseHash, 0)); ok {
+			t.Errorf("Orphaned coinbase %s still credited", coinbaseHash)
+		}
+	}
+
+	// Spends are back in the mempool and their outputs unconfirmed
+	var unconfirmed int64
+	for _, tx := range spends {
+		txHash, _ := serialization.HashTransaction(tx)
+		for i, output := range tx.Outputs {
+			status, ok := sim.Wallet.OutputStatus(utxo.NewOutPoint(txHash, uint32(i)))
+			if !ok || status != wallet.StatusUnconfirmed {
+				t.Errorf("Output %s:%d = %v (tracked %v), want unconfirmed", txHash, i, status, ok)
+			}
+			unconfirmed += output.Value
+		}
+	}
+	if got := sim.Wallet.GetUnconfirmedBalance(); got != unconfirmed {
+		t.Errorf("Unconfirmed balance = %d, want %d", got, unconfirmed)
+	}
+
+	var confirmed int64
+	for _, coin := range sim.UTXOs.GetAll() {
+		confirmed += coin.Value()
+	}
+	if got := sim.Wallet.GetBalance(); got != confirmed {
+		t.Errorf("Balance = %d, want the UTXO set total %d", got, confirmed)
+	}
+
+	// Mining them again confirms the outputs
+	if _, err := sim.Mine(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.Wallet.GetUnconfirmedBalance(); got != 0 {
+		t.Errorf("Unconfirmed balance after remining = %d", got)
+	}
+	if err := sim.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReorgInvalidBranchLeavesChainUntouched(t *testing.T) {
+	sim := newReorgSimulator(t, 2)
+	if _, err := sim.Mine(5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.Spend(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.Mine(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.Spend(2); err != nil {
+		t.Fatal(err)
+	}
+
+	tip, _ := sim.Chain.GetBestBlockHash()
+	utxos, mempoolSize, balance := sim.UTXOs.Size(), sim.Mempool.Size(), sim.Wallet.GetBalance()
+
+	// The last block of the branch doesn't connect to the one before it,
+	// so it only fails after the earlier blocks were staged
+	branch, err := sim.BuildFork(2, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch[len(branch)-1].Header.PrevBlockHash = types.Hash{1}
+
+	if err := sim.Handler.HandleReorg(branch); err == nil {
+		t.Fatal("Reorg onto an invalid branch succeeded")
+	}
+
+	if after, _ := sim.Chain.GetBestBlockHash(); after != tip {
+		t.Errorf("Tip moved to %s", after)
+	}
+	if sim.UTXOs.Size() != utxos || sim.Mempool.Size() != mempoolSize || sim.Wallet.GetBalance() != balance {
+		t.Errorf("State changed: %d UTXOs (was %d), %d mempool txs (was %d), balance %d (was %d)",
+			sim.UTXOs.Size(), utxos, sim.Mempool.Size(), mempoolSize, sim.Wallet.GetBalance(), balance)
+	}
+	if err := sim.Verify(); err != nil {
+		t.Errorf("Node inconsistent after a rejected reorg: %v", err)
+	}
+}
+
+// A regtest handler checks branches against regtest's halving interval:
+// a branch paying mainnet's 50 BTC past height 150 is refused, one paying
+// the halved subsidy is switched to
+func TestReorgFollowsRegtestHalvings(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	rules := consensus.NewRegtestRules()
+	sim, err := reorg.NewSimulatorWithRules(chain, 5, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.Mine(148); err != nil {
+		t.Fatal(err)
+	}
+	tip, _ := sim.Chain.GetBestBlockHash()
+
+	// Five blocks off height 146, up to 151
+	fork, err := sim.Chain.GetBlockByHeight(146)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overpaying := buildBranch(t, blockHash(t, fork), 147, 5, 1)
+	if err := sim.Handler.HandleReorg(overpaying); err == nil {
+		t.Fatal("Switched to a branch paying 50 BTC past regtest's first halving")
+	}
+	if after, _ := sim.Chain.GetBestBlockHash(); after != tip {
+		t.Fatalf("Tip moved to %s", after)
+	}
+
+	branch, err := sim.BuildFork(2, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Reorg(branch); err != nil {
+		t.Fatalf("Reorg across the halving failed: %v", err)
+	}
+	for i, block := range branch {
+		height := uint64(147 + i)
+		if paid := block.Transactions[0].Outputs[0].Value; paid != int64(rules.GetBlockSubsidy(height)) {
+			t.Errorf("Block %d pays %d, want %d", height, paid, rules.GetBlockSubsidy(height))
+		}
+	}
+}
+
+// chainEvents records the blocks a node reports joining and leaving the
+// best chain
+type chainEvents struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (c *chainEvents) BlockConnected(block *types.Block, height uint64) {
+	c.record("connect", block, height)
+}
+
+func (c *chainEvents) BlockDisconnected(block *types.Block, height uint64) {
+	c.record("disconnect", block, height)
+}
+
+func (c *chainEvents) record(kind string, block *types.Block, height uint64) {
+	hash, _ := serialization.HashBlockHeader(&block.Header)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, fmt.Sprintf("%s %d %s", kind, height, hash))
+}
+
+func (c *chainEvents) take() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	events := c.events
+	c.events = nil
+	return events
+}
+
+func TestSyncedNodeReorganizes(t *testing.T) {
+	h, err := testharness.New(3)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	miner, node, rival := h.Node(0), h.Node(1), h.Node(2)
+
+	events := &chainEvents{}
+	node.P2P.Subscribe(events)
+	var alerts []validation.DeepReorgAlert
+	node.P2P.SyncManager.Reorganizer().SetMaxReorgDepth(1)
+	node.P2P.SyncManager.Reorganizer().OnDeepReorg(func(alert validation.DeepReorgAlert) {
+		alerts = append(alerts, alert)
+	})
+
+	// node and rival sync two blocks from the miner
+	base, err := miner.MineBlocks(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range base {
+		if err := node.P2P.ProcessNewBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		if err := rival.P2P.ProcessNewBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := events.take(); len(got) != 2 {
+		t.Fatalf("Events while syncing: %v", got)
+	}
+
+	// node confirms a payment only it has seen in a block of its own
+	if _, err := miner.Balance(); err != nil {
+		t.Fatal(err)
+	}
+	payment, err := miner.Wallet.SendWithFee(node.Address, 10*100000000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.P2P.BroadcastTransaction(payment); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := node.MineBlocks(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash := txid(t, payment)
+	if node.P2P.Mempool.Exists(paymentHash) {
+		t.Fatal("Payment still in the mempool after being mined")
+	}
+
+	// The miner's longer branch takes over
+	branch, err := miner.MineBlocks(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range branch {
+		if err := node.P2P.ProcessNewBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tip, _ := node.BestHash(); tip != blockHash(t, branch[1]) {
+		t.Fatalf("Tip is %s, want the miner's", tip)
+	}
+	want := []string{
+		fmt.Sprintf("disconnect 3 %s", blockHash(t, stale[0])),
+		fmt.Sprintf("connect 3 %s", blockHash(t, branch[0])),
+		fmt.Sprintf("connect 4 %s", blockHash(t, branch[1])),
+	}
+	if got := events.take(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Events = %v, want %v", got, want)
+	}
+	if !node.P2P.Mempool.Exists(paymentHash) {
+		t.Error("Payment from the disconnected block not returned to the mempool")
+	}
+	set, err := node.P2P.SyncManager.Chainstate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Exists(utxo.NewOutPoint(txid(t, &stale[0].Transactions[0]), 0)) {
+		t.Error("Coinbase of the disconnected block still unspent")
+	}
+
+	// A branch forking off two blocks back is too deep
+	deep, err := rival.MineBlocks(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range deep {
+		node.P2P.ProcessNewBlock(block)
+	}
+	if tip, _ := node.BestHash(); tip != blockHash(t, branch[1]) {
+		t.Errorf("Tip moved to %s past the depth limit", tip)
+	}
+	if len(alerts) != 1 || alerts[0].Depth != 2 || alerts[0].NewTip != blockHash(t, deep[2]) {
+		t.Errorf("Alerts = %+v", alerts)
+	}
+	if got := events.take(); len(got) != 0 {
+		t.Errorf("Events for a refused reorg: %v", got)
+	}
+}
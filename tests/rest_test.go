@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/explorer"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rest"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+)
+
+// restGet fetches a REST path and returns the status and body
+func restGet(t *testing.T, srv *httptest.Server, path string) (int, []byte) {
+	t.Helper()
+
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestRESTInterface(t *testing.T) {
+	h, err := testharness.New(2)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	blocks, err := node.MineBlocks(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := node.SendTo(h.Node(1).Address, 100000000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(rest.NewServer(node.Chain, node.P2P.Mempool).Handler())
+	defer srv.Close()
+
+	// Blocks come back byte for byte in every format
+	hash := blockHash(t, blocks[1])
+	want, err := serialization.SerializeBlock(blocks[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body := restGet(t, srv, "/rest/block/"+hash.String()+".bin")
+	if status != http.StatusOK || !bytes.Equal(body, want) {
+		t.Errorf("block.bin: status %d, %d bytes, want %d bytes", status, len(body), len(want))
+	}
+	status, body = restGet(t, srv, "/rest/block/"+hash.String()+".hex")
+	if status != http.StatusOK || strings.TrimSpace(string(body)) != hex.EncodeToString(want) {
+		t.Errorf("block.hex: status %d, body %.40s", status, body)
+	}
+	var block explorer.Block
+	status, body = restGet(t, srv, "/rest/block/"+hash.String()+".json")
+	if status != http.StatusOK || json.Unmarshal(body, &block) != nil || block.Height != 2 || block.Hash != hash.String() {
+		t.Errorf("block.json: status %d, %+v", status, block)
+	}
+
+	// Headers follow the best chain from the requested block
+	genesis := blockHash(t, h.Genesis)
+	status, body = restGet(t, srv, "/rest/headers/10/"+genesis.String()+".bin")
+	if status != http.StatusOK || len(body) != 4*80 {
+		t.Errorf("headers.bin: status %d, %d bytes, want 4 headers", status, len(body))
+	}
+	var headers []rest.Header
+	status, body = restGet(t, srv, "/rest/headers/2/"+genesis.String()+".json")
+	if status != http.StatusOK || json.Unmarshal(body, &headers) != nil || len(headers) != 2 || headers[1].Height != 1 {
+		t.Errorf("headers.json: status %d, %+v", status, headers)
+	}
+
+	// Transactions are found in the mempool and in blocks
+	txHash := txid(t, tx)
+	rawTx, _ := serialization.SerializeTransactionWitness(tx)
+	status, body = restGet(t, srv, "/rest/tx/"+txHash.String()+".hex")
+	if status != http.StatusOK || strings.TrimSpace(string(body)) != hex.EncodeToString(rawTx) {
+		t.Errorf("mempool tx.hex: status %d, body %.40s", status, body)
+	}
+	mined, err := node.MineBlocks(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded explorer.Transaction
+	status, body = restGet(t, srv, "/rest/tx/"+txHash.String())
+	if status != http.StatusOK || json.Unmarshal(body, &decoded) != nil ||
+		decoded.BlockHash != blockHash(t, mined[0]).String() || decoded.Height != 4 {
+		t.Errorf("tx.json: status %d, %+v", status, decoded)
+	}
+
+	// Bad requests
+	for path, wantStatus := range map[string]int{
+		"/rest/block/" + hash.String() + ".xml":        http.StatusNotFound,
+		"/rest/block/nothex.bin":                       http.StatusBadRequest,
+		"/rest/block/" + txHash.String() + ".bin":      http.StatusNotFound,
+		"/rest/headers/0/" + genesis.String() + ".bin": http.StatusBadRequest,
+		"/rest/headers/" + genesis.String():            http.StatusBadRequest,
+	} {
+		if status, _ := restGet(t, srv, path); status != wantStatus {
+			t.Errorf("%s: status %d, want %d", path, status, wantStatus)
+		}
+	}
+	resp, err := http.Post(srv.URL+"/rest/tx/"+txHash.String()+".bin", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", resp.StatusCode)
+	}
+}
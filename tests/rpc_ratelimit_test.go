@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestRPCRateLimitPerClass(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	// Rate 1/s is slow enough that no token is refilled during the test
+	server.SetRateLimits(rpc.RateLimitConfig{
+		ReadOnly: rpc.RateLimit{Rate: 1, Burst: 5},
+		Wallet:   rpc.RateLimit{Rate: 1, Burst: 2},
+	})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetNewAddress(); err != nil {
+			t.Fatalf("Request %d within burst failed: %v", i, err)
+		}
+	}
+
+	_, err = client.GetNewAddress()
+	if !errors.Is(err, rpc.ErrRateLimited) {
+		t.Fatalf("Third wallet call: got %v, want ErrRateLimited", err)
+	}
+
+	resp, err := http.Get(ts.URL + "/getbalance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Status = %d, want 429", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("Missing Retry-After header")
+	}
+
+	// Read-only commands have their own bucket
+	if _, err := client.ListAddresses(); !errors.Is(err, rpc.ErrRateLimited) {
+		t.Errorf("listaddresses is a wallet command, got %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := client.GetBlockCount(); errors.Is(err, rpc.ErrRateLimited) {
+			t.Fatalf("Read-only call %d limited by wallet usage", i)
+		}
+	}
+	if _, err := client.GetBlockCount(); !errors.Is(err, rpc.ErrRateLimited) {
+		t.Errorf("Sixth read-only call: got %v, want ErrRateLimited", err)
+	}
+
+	// Zero rate turns limiting off
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	for i := 0; i < 20; i++ {
+		if _, err := client.GetNewAddress(); err != nil {
+			t.Fatalf("Unlimited call %d failed: %v", i, err)
+		}
+	}
+}
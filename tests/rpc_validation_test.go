@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/security"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestInputValidatorRules(t *testing.T) {
+	iv := security.NewInputValidator(1024)
+
+	w := wallet.NewWallet()
+	addr, err := w.GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := iv.ValidateAddress(addr); err != nil {
+		t.Errorf("Valid address rejected: %v", err)
+	}
+	// Change the last character so the checksum no longer matches
+	last := addr[len(addr)-1]
+	swapped := byte('2')
+	if last == swapped {
+		swapped = '3'
+	}
+	if err := iv.ValidateAddress(addr[:len(addr)-1] + string(swapped)); err == nil {
+		t.Error("Address with bad checksum accepted")
+	}
+
+	for _, amount := range []int64{0, -1, security.MaxAmount + 1} {
+		if err := iv.ValidateAmount(amount); err == nil {
+			t.Errorf("Amount %d accepted", amount)
+		}
+	}
+	if err := iv.ValidateAmount(security.MaxAmount); err != nil {
+		t.Errorf("MaxAmount rejected: %v", err)
+	}
+
+	if err := iv.ValidateHex("abcd", 1); err == nil {
+		t.Error("Hex over the length cap accepted")
+	}
+	if err := iv.ValidateHex("zz", 4); err == nil {
+		t.Error("Non-hex accepted")
+	}
+	if err := iv.ValidateHash(strings.Repeat("0", 63)); err == nil {
+		t.Error("Short hash accepted")
+	}
+
+	if err := iv.ValidateHeight(11, 10); err == nil {
+		t.Error("Height beyond tip accepted")
+	}
+	if err := iv.ValidateIPAddress("300.1.1.1"); err == nil {
+		t.Error("Invalid IP accepted")
+	}
+	if err := iv.ValidateIPAddress("::1"); err != nil {
+		t.Errorf("IPv6 loopback rejected: %v", err)
+	}
+}
+
+func TestRPCRejectsInvalidParameters(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	for i, block := range buildTestChain(t, types.Hash{}, 3) {
+		if err := chain.SaveBlock(block, uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	if _, err := client.GetBlock(2); err != nil {
+		t.Fatalf("Valid height rejected: %v", err)
+	}
+	if _, err := client.GetBlock(3); err == nil || !strings.Contains(err.Error(), "invalid height") {
+		t.Errorf("Height beyond tip: got %v", err)
+	}
+
+	if _, err := client.GetTransaction("xyz"); err == nil || !strings.Contains(err.Error(), "invalid txhash") {
+		t.Errorf("Bad txhash: got %v", err)
+	}
+
+	if _, err := client.SendToAddress("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3", 1000); err == nil ||
+		!strings.Contains(err.Error(), "invalid address") {
+		t.Errorf("Bad checksum: got %v", err)
+	}
+
+	addr, err := wallet.NewWallet().GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, amount := range []int64{-5, security.MaxAmount + 1} {
+		if _, err := client.SendToAddress(addr, amount); err == nil || !strings.Contains(err.Error(), "invalid amount") {
+			t.Errorf("Amount %d: got %v", amount, err)
+		}
+	}
+
+	if err := client.SetBan("999.0.0.1", "add", 60); err == nil || !strings.Contains(err.Error(), "invalid ip") {
+		t.Errorf("Bad IP: got %v", err)
+	}
+
+	// Bodies over the cap are refused before any handler sees them
+	body, _ := json.Marshal(map[string]string{"address": strings.Repeat("1", rpc.MaxRequestBody)})
+	resp, err := http.Post(ts.URL+"/sendtoaddress", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Oversized body: status %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+)
+
+func TestScriptLimits(t *testing.T) {
+	// pushes returns count pushes of size bytes, each true
+	pushes := func(count, size int) []byte {
+		b := script.NewBuilder()
+		for i := 0; i < count; i++ {
+			b.AddData(bytes.Repeat([]byte{1}, size))
+		}
+		return b.Script()
+	}
+	repeat := func(op byte, n int) []byte {
+		return bytes.Repeat([]byte{op}, n)
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	// multiSig is a 0-of-20 OP_CHECKMULTISIG after nops OP_NOPs, counting
+	// 20 + nops + 1 operations
+	multiSig := func(nops int) []byte {
+		b := script.NewBuilder().AddOp(script.OP_0).AddOp(script.OP_0)
+		for i := 0; i < 20; i++ {
+			b.AddData([]byte{2})
+		}
+		return join(b.AddInt(20).Script(), repeat(script.OP_NOP, nops), []byte{script.OP_CHECKMULTISIG})
+	}
+
+	tests := []struct {
+		name   string
+		script []byte
+		want   error
+	}{
+		{"10000 bytes", join(pushes(19, 500), pushes(1, 440)), nil},
+		{"10001 bytes", join(pushes(19, 500), pushes(1, 441)), script.ErrScriptSize},
+		{"520 byte push", pushes(1, 520), nil},
+		{"521 byte push", pushes(1, 521), script.ErrElementSize},
+		{"521 byte push not executed", join([]byte{script.OP_0, script.OP_IF}, pushes(1, 521), []byte{script.OP_ENDIF, script.OP_1}), script.ErrElementSize},
+		{"201 ops", join([]byte{script.OP_1}, repeat(script.OP_NOP, 201)), nil},
+		{"202 ops", join([]byte{script.OP_1}, repeat(script.OP_NOP, 202)), script.ErrOpCount},
+		{"ops not executed", join([]byte{script.OP_0, script.OP_IF}, repeat(script.OP_NOP, 200), []byte{script.OP_ENDIF, script.OP_1}), script.ErrOpCount},
+		{"pushes aren't ops", repeat(script.OP_16, 1000), nil},
+		{"multisig keys count", multiSig(180), nil},
+		{"multisig keys over", multiSig(181), script.ErrOpCount},
+		{"1000 items", repeat(script.OP_1, 1000), nil},
+		{"1001 items", repeat(script.OP_1, 1001), script.ErrStackSize},
+	}
+	for _, tt := range tests {
+		err := script.NewEngine(tt.script).Execute()
+		if tt.want == nil && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// A scriptSig and the output script it spends are limited separately, not
+// as one program
+func TestScriptLimitsPerScript(t *testing.T) {
+	pushes := func(count, size int) []byte {
+		b := script.NewBuilder()
+		for i := 0; i < count; i++ {
+			b.AddData(bytes.Repeat([]byte{1}, size))
+		}
+		return b.Script()
+	}
+	maxSize := append(pushes(19, 500), pushes(1, 440)...)
+	overSize := append(pushes(19, 500), pushes(1, 441)...)
+	nops := func(n int) []byte {
+		return append([]byte{script.OP_1}, bytes.Repeat([]byte{script.OP_NOP}, n)...)
+	}
+
+	tests := []struct {
+		name                    string
+		sigScript, pubKeyScript []byte
+		want                    error
+	}{
+		{"10000 bytes each", maxSize, maxSize, nil},
+		{"10001 byte scriptSig", overSize, maxSize, script.ErrScriptSize},
+		{"10001 byte output script", maxSize, overSize, script.ErrScriptSize},
+		{"201 ops each", nops(201), nops(201), nil},
+		{"202 ops in scriptSig", nops(202), nops(1), script.ErrOpCount},
+		{"202 ops in output script", nops(1), nops(202), script.ErrOpCount},
+	}
+	for _, tt := range tests {
+		err := script.NewInputEngine(tt.sigScript, tt.pubKeyScript).Execute()
+		if tt.want == nil && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
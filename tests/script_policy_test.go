@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestScriptPushOnly(t *testing.T) {
+	tests := []struct {
+		script []byte
+		want   bool
+	}{
+		{nil, true},
+		{[]byte{script.OP_0, script.OP_16, script.OP_1NEGATE}, true},
+		{script.NewBuilder().AddData(bytes.Repeat([]byte{7}, 300)).Script(), true},
+		{[]byte{0x02, 0xaa}, false}, // Truncated push
+		{[]byte{0x01, 0xaa, script.OP_DUP}, false},
+	}
+	for i, tt := range tests {
+		if got := script.IsPushOnly(tt.script); got != tt.want {
+			t.Errorf("Script %d: IsPushOnly = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestScriptMinimalPushes(t *testing.T) {
+	minimal := [][]byte{
+		{script.OP_0},
+		{script.OP_5},
+		{script.OP_1NEGATE},
+		{0x01, 0x20},
+		script.NewBuilder().AddData(bytes.Repeat([]byte{1}, 76)).Script(),
+		script.NewBuilder().AddData(bytes.Repeat([]byte{1}, 256)).Script(),
+	}
+	for i, s := range minimal {
+		if err := script.CheckMinimalPushes(s); err != nil {
+			t.Errorf("Minimal script %d rejected: %v", i, err)
+		}
+	}
+
+	padded := [][]byte{
+		{0x01, 0x05},                      // OP_5 pushes this
+		{0x01, 0x81},                      // OP_1NEGATE pushes this
+		{script.OP_PUSHDATA1, 0x01, 0x20}, // Direct push is shorter
+		append([]byte{script.OP_PUSHDATA2, 76, 0}, bytes.Repeat([]byte{1}, 76)...),
+	}
+	for i, s := range padded {
+		if err := script.CheckMinimalPushes(s); !errors.Is(err, script.ErrMinimalData) {
+			t.Errorf("Padded script %d: got %v, want ErrMinimalData", i, err)
+		}
+	}
+}
+
+func TestStandardInputScripts(t *testing.T) {
+	tx := &types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: types.Hash{1}, Sequence: 0xFFFFFFFF}},
+		Outputs: []types.TxOutput{{Value: 1000, PubKeyScript: []byte{script.OP_1}}},
+	}
+	// Output needing a 76-byte preimage, pushed with OP_PUSHDATA1
+	preimage := bytes.Repeat([]byte{9}, 76)
+	hash := sha256.Sum256(preimage)
+	locking := script.NewBuilder().AddOp(script.OP_SHA256).AddData(hash[:]).AddOp(script.OP_EQUAL).Script()
+	unlocking := script.NewBuilder().AddData(preimage).Script()
+
+	if err := script.VerifyStandardInput(unlocking, locking, tx, 0); err != nil {
+		t.Errorf("Standard spend rejected: %v", err)
+	}
+
+	// An extra element left under the result
+	extra := append([]byte{script.OP_1}, unlocking...)
+	if err := script.VerifyStandardInput(extra, locking, tx, 0); !errors.Is(err, script.ErrCleanStack) {
+		t.Errorf("Dirty stack: got %v, want ErrCleanStack", err)
+	}
+
+	// Logic in the scriptSig
+	if err := script.VerifyStandardInput([]byte{script.OP_1, script.OP_DUP, script.OP_DROP}, []byte{script.OP_1, script.OP_DROP}, tx, 0); !errors.Is(err, script.ErrSigPushOnly) {
+		t.Errorf("Opcode in scriptSig: got %v, want ErrSigPushOnly", err)
+	}
+
+	prevOuts := []types.TxOutput{{Value: 2000, PubKeyScript: locking}}
+	tx.Inputs[0].SignatureScript = extra
+	if err := mempool.CheckInputStandardness(tx, prevOuts); !errors.Is(err, mempool.ErrNonStandard) {
+		t.Errorf("Mempool policy: got %v, want ErrNonStandard", err)
+	}
+
+	policy := mempool.DefaultPolicy()
+	policy.RequireStandard = false
+	if err := mempool.NewPolicyValidator(policy, nil).CheckInputScripts(tx, prevOuts); err != nil {
+		t.Errorf("Policy without standardness rejected the input: %v", err)
+	}
+}
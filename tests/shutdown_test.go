@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// shutdownBound is how long stopping may take with idle peers connected
+const shutdownBound = 2 * time.Second
+
+// returnsWithin fails t unless f returns within d
+func returnsWithin(t *testing.T, what string, d time.Duration, f func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("%s did not return within %v", what, d)
+	}
+}
+
+func TestNodeStopsWhenContextCancelled(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	node := network.NewNode(network.NodeConfig{
+		ListenAddr:        "127.0.0.1:0",
+		UserAgent:         "shutdowntest",
+		EnableV2Transport: true,
+	}, chain)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := node.StartContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A silent connection leaves the node waiting in the v2 handshake, a
+	// connected peer leaves it waiting on reads
+	silent, err := net.Dial("tcp", node.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	other := startV2Node(t, true)
+	go other.Connect(node.Addr())
+	handshakenPeer(t, other)
+
+	start := time.Now()
+	cancel()
+	returnsWithin(t, "Stop", shutdownBound, node.Stop)
+	t.Logf("Node stopped in %v", time.Since(start))
+
+	if node.Context().Err() == nil {
+		t.Error("Node context not cancelled")
+	}
+	silent.SetReadDeadline(time.Now().Add(shutdownBound))
+	if _, err := silent.Read(make([]byte, 1)); err == nil {
+		t.Error("Silent connection still open after stop")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("Silent connection not closed by stop")
+	}
+	waitUntil(t, "peer to notice the disconnect", func() bool { return other.PeerCount() == 0 })
+
+	// Stopping again is harmless
+	returnsWithin(t, "second Stop", shutdownBound, node.Stop)
+}
+
+func TestRPCServerStopsWhenContextCancelled(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, listener, http.NewServeMux()) }()
+
+	// Leaves an idle keep-alive connection behind
+	client := rpc.NewClient("http://" + listener.Addr().String())
+	if _, err := client.GetBlockCount(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(shutdownBound):
+		t.Fatalf("Serve did not return within %v", shutdownBound)
+	}
+
+	if _, err := client.GetBlockCount(); err == nil {
+		t.Error("Request succeeded after shutdown")
+	}
+}
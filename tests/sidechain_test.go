@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	syncmanager "github.com/pouria-shahmiri/learn-bitcoin/pkg/network/sync"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// buildBranch returns count blocks extending prev, starting at height
+// start. Branches built with different tags never share a coinbase.
+func buildBranch(t *testing.T, prev types.Hash, start uint64, count int, tag uint64) []*types.Block {
+	t.Helper()
+
+	blocks := make([]*types.Block, 0, count)
+	for i := 0; i < count; i++ {
+		height := start + uint64(i)
+		coinbase, err := mining.CreateCoinbase(height, 0, "side-chain", tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := mining.BuildBlock(&mining.BlockTemplate{
+			Version:       1,
+			PrevBlockHash: prev,
+			Transactions:  []types.Transaction{*coinbase},
+			Timestamp:     uint32(1700000000 + 10*height + tag),
+			Bits:          0x207fffff,
+			Height:        height,
+		}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev, _ = serialization.HashBlockHeader(&block.Header)
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func blockHash(t *testing.T, block *types.Block) types.Hash {
+	t.Helper()
+	hash, err := serialization.HashBlockHeader(&block.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestChainValidatorStoresSideChainAndReorganizes(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	utxoSet := utxo.NewUTXOSet()
+	cv := validation.NewChainValidator(chain, utxoSet)
+
+	main := buildBranch(t, types.Hash{}, 0, 4, 0)
+	for _, block := range main {
+		if err := cv.AcceptBlock(block); err != nil {
+			t.Fatalf("AcceptBlock: %v", err)
+		}
+	}
+	mainTip := blockHash(t, main[3])
+
+	// A fork off block 1 is stored but doesn't win until it has more work
+	side := buildBranch(t, blockHash(t, main[1]), 2, 3, 1)
+	for i, block := range side[:2] {
+		if err := cv.AcceptBlock(block); err != nil {
+			t.Fatalf("Side block %d rejected: %v", i, err)
+		}
+		hash := blockHash(t, block)
+		if known, _ := chain.HasBlock(hash); !known {
+			t.Errorf("Side block %d was not stored", i)
+		}
+		if onMain, _ := chain.IsMainChain(hash); onMain {
+			t.Errorf("Side block %d is on the best chain", i)
+		}
+		if tip, _ := chain.GetBestBlockHash(); tip != mainTip {
+			t.Fatalf("Tip moved to %s after side block %d", tip, i)
+		}
+	}
+	if utxoSet.Size() != 4 {
+		t.Errorf("UTXO set has %d entries, want the 4 main-chain coinbases", utxoSet.Size())
+	}
+
+	if err := cv.AcceptBlock(side[2]); err != nil {
+		t.Fatalf("Reorg onto the side chain failed: %v", err)
+	}
+	tip, height, _ := chain.GetBestBlock()
+	if blockHash(t, tip) != blockHash(t, side[2]) || height != 4 {
+		t.Fatalf("Tip is %s at %d, want the side chain tip", blockHash(t, tip), height)
+	}
+	for i, block := range side {
+		if onMain, _ := chain.IsMainChain(blockHash(t, block)); !onMain {
+			t.Errorf("Side block %d not on the best chain after reorg", i)
+		}
+	}
+	if onMain, _ := chain.IsMainChain(mainTip); onMain {
+		t.Error("Old tip still on the best chain")
+	}
+
+	coinbaseHash, _ := serialization.HashTransaction(&main[3].Transactions[0])
+	if utxoSet.Exists(utxo.NewOutPoint(coinbaseHash, 0)) {
+		t.Error("Coinbase of a disconnected block is still unspent")
+	}
+	if utxoSet.Size() != 5 {
+		t.Errorf("UTXO set has %d entries, want 5", utxoSet.Size())
+	}
+	if err := cv.IsValidChain(); err != nil {
+		t.Errorf("Chain invalid after reorg: %v", err)
+	}
+}
+
+func TestSyncManagerSwitchesToHeavierBranch(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	sm := syncmanager.NewSyncManager(chain)
+	peer := &recordingSender{addr: "10.0.0.1:8333"}
+
+	main := buildBranch(t, types.Hash{}, 0, 3, 0)
+	for _, block := range main {
+		if _, _, err := sm.HandleBlock(block, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	side := buildBranch(t, blockHash(t, main[0]), 1, 3, 1)
+	for i, block := range side[:2] {
+		connected, _, err := sm.HandleBlock(block, peer)
+		if err != nil || len(connected) != 0 {
+			t.Fatalf("Side block %d: %d connected, %v", i, len(connected), err)
+		}
+	}
+	if tip, _ := chain.GetBestBlockHash(); tip != blockHash(t, main[2]) {
+		t.Fatalf("Tip moved to a branch with equal work")
+	}
+	if block, _ := chain.GetBlockByHeight(2); blockHash(t, block) != blockHash(t, main[2]) {
+		t.Error("Height index overwritten by a side block")
+	}
+
+	connected, disconnected, err := sm.HandleBlock(side[2], peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connected) != 3 {
+		t.Errorf("Connected %d blocks, want the whole 3-block branch", len(connected))
+	}
+	if len(disconnected) != 2 || blockHash(t, disconnected[0]) != blockHash(t, main[2]) {
+		t.Errorf("Disconnected %d blocks, want the old tip first of 2", len(disconnected))
+	}
+	if tip, height, _ := chain.GetBestBlock(); blockHash(t, tip) != blockHash(t, side[2]) || height != 3 {
+		t.Errorf("Tip = %s at %d, want the side chain tip at 3", blockHash(t, tip), height)
+	}
+}
+
+func TestSyncManagerRejectsSideBlockClaimingMoreWork(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	sm := syncmanager.NewSyncManager(chain)
+	peer := &recordingSender{addr: "10.0.0.1:8333"}
+
+	main := buildBranch(t, types.Hash{}, 0, 3, 0)
+	for _, block := range main {
+		if _, _, err := sm.HandleBlock(block, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One block at a far harder target would outweigh the whole chain
+	side := buildBranch(t, blockHash(t, main[0]), 1, 1, 1)[0]
+	side.Header.Bits = 0x1d00ffff
+
+	connected, _, err := sm.HandleBlock(side, peer)
+	if err == nil || len(connected) != 0 {
+		t.Fatalf("Block with the wrong bits accepted: %d connected", len(connected))
+	}
+	if reason := validation.RejectReason(err); reason != validation.RejectBadDiffBits {
+		t.Errorf("Reject reason = %q, want %q", reason, validation.RejectBadDiffBits)
+	}
+	if known, _ := chain.HasBlock(blockHash(t, side)); known {
+		t.Error("Block with the wrong bits was stored")
+	}
+	if tip, _ := chain.GetBestBlockHash(); tip != blockHash(t, main[2]) {
+		t.Error("Tip moved to a branch with the wrong bits")
+	}
+}
+
+func TestSyncManagerKeepsChainWhenHeavierBranchIsInvalid(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	sm := syncmanager.NewSyncManager(chain)
+	peer := &recordingSender{addr: "10.0.0.1:8333"}
+
+	main := buildBranch(t, types.Hash{}, 0, 3, 0)
+	for _, block := range main {
+		if _, _, err := sm.HandleBlock(block, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The branch's last block pays itself twice the subsidy
+	side := buildBranch(t, blockHash(t, main[0]), 1, 2, 1)
+	coinbase, err := mining.CreateCoinbase(3, 2*50*100000000, "side-chain", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	greedy, err := mining.BuildBlock(&mining.BlockTemplate{
+		Version:       1,
+		PrevBlockHash: blockHash(t, side[1]),
+		Transactions:  []types.Transaction{*coinbase},
+		Timestamp:     1700000031,
+		Bits:          0x207fffff,
+		Height:        3,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range side {
+		if _, _, err := sm.HandleBlock(block, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	connected, _, err := sm.HandleBlock(greedy, peer)
+	if err == nil || len(connected) != 0 {
+		t.Fatalf("Invalid branch connected %d blocks", len(connected))
+	}
+	if reason := validation.RejectReason(err); reason != validation.RejectBadCoinbaseValue {
+		t.Errorf("Reject reason = %q, want %q", reason, validation.RejectBadCoinbaseValue)
+	}
+	if tip, _ := chain.GetBestBlockHash(); tip != blockHash(t, main[2]) {
+		t.Error("Tip moved to an invalid branch")
+	}
+	if invalid, _ := chain.IsInvalid(blockHash(t, greedy)); !invalid {
+		t.Error("Invalid block not marked invalid")
+	}
+
+	// The main chain still extends on the UTXO set it had
+	next := buildBranch(t, blockHash(t, main[2]), 3, 1, 0)[0]
+	if connected, _, err := sm.HandleBlock(next, peer); err != nil || len(connected) != 1 {
+		t.Fatalf("Main chain didn't extend: %d connected, %v", len(connected), err)
+	}
+}
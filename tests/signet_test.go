@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+func TestSignetMagic(t *testing.T) {
+	var buf bytes.Buffer
+	serialization.WriteBytes(&buf, consensus.DefaultSignetChallenge)
+	hash := crypto.DoubleSHA256(buf.Bytes())
+	if magic := binary.LittleEndian.Uint32(hash[:4]); magic != protocol.MagicSignet {
+		t.Errorf("Default signet magic %#x, want %#x", magic, protocol.MagicSignet)
+	}
+
+	params, err := keys.ParamsForNetwork("signet")
+	if err != nil || params.Bech32HRP != "tb" {
+		t.Errorf("Signet params = %+v, %v", params, err)
+	}
+}
+
+// signetTemplate is a template for block 1 of a signet with a coinbase
+// paying OP_TRUE
+func signetTemplate(prev types.Hash) *mining.BlockTemplate {
+	coinbase := types.Transaction{
+		Version: 1,
+		Inputs: []types.TxInput{{
+			OutputIndex:     0xFFFFFFFF,
+			SignatureScript: []byte{0x01, 0x01},
+			Sequence:        0xFFFFFFFF,
+		}},
+		Outputs: []types.TxOutput{{Value: validation.GetBlockReward(1), PubKeyScript: []byte{0x51}}},
+	}
+	return &mining.BlockTemplate{
+		Version:       1,
+		PrevBlockHash: prev,
+		Transactions:  []types.Transaction{coinbase},
+		Timestamp:     1700000000,
+		Bits:          consensus.RegtestPowLimitBits,
+		Height:        1,
+	}
+}
+
+func TestSignetBlockSignature(t *testing.T) {
+	issuer, _ := keys.GeneratePrivateKey()
+	other, _ := keys.GeneratePrivateKey()
+	challenge := script.NewBuilder().AddOp(script.OP_1).
+		AddData(other.PublicKey().Bytes(true)).AddData(issuer.PublicKey().Bytes(true)).
+		AddOp(script.OP_2).AddOp(script.OP_CHECKMULTISIG).Script()
+
+	prev := types.Hash{1}
+	unsigned, err := mining.SolveBlock(signetTemplate(prev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validation.CheckSignetSolution(unsigned, challenge); !errors.Is(err, validation.ErrNoSignetSolution) {
+		t.Errorf("Unsigned block: got %v, want ErrNoSignetSolution", err)
+	}
+
+	template := signetTemplate(prev)
+	if err := mining.SignSignetTemplate(template, challenge, issuer); err != nil {
+		t.Fatal(err)
+	}
+	block, err := mining.SolveBlock(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validation.CheckSignetSolution(block, challenge); err != nil {
+		t.Fatalf("Signed block rejected: %v", err)
+	}
+
+	// The nonce isn't signed, the time is
+	regrind := *block
+	regrind.Header.Nonce += 1000
+	if err := validation.CheckSignetSolution(&regrind, challenge); err != nil {
+		t.Errorf("Block with another nonce rejected: %v", err)
+	}
+	retimed := *block
+	retimed.Header.Timestamp++
+	if err := validation.CheckSignetSolution(&retimed, challenge); err == nil {
+		t.Error("Block with another time accepted")
+	}
+
+	outsider, _ := keys.GeneratePrivateKey()
+	forged := signetTemplate(prev)
+	if err := mining.SignSignetTemplate(forged, challenge, outsider); err != nil {
+		t.Fatal(err)
+	}
+	forgedBlock, _ := mining.SolveBlock(forged)
+	if err := validation.CheckSignetSolution(forgedBlock, challenge); err == nil {
+		t.Error("Block signed by an outsider accepted")
+	}
+
+	// Block validation enforces the challenge on signet only
+	validate := func(rules *consensus.ConsensusRules, block *types.Block) error {
+		validator := validation.NewBlockValidator(utxo.NewUTXOSet())
+		validator.SetRules(rules)
+		return validator.ValidateBlock(block, 1, prev)
+	}
+	signet := consensus.NewSignetRules(challenge)
+	if err := validate(signet, block); err != nil {
+		t.Errorf("Signet validation rejected the signed block: %v", err)
+	}
+	if err := validate(signet, unsigned); err == nil || !strings.Contains(err.Error(), "signet") {
+		t.Errorf("Signet validation of an unsigned block = %v", err)
+	}
+	if err := validate(consensus.NewTestnetRules(), unsigned); err != nil {
+		t.Errorf("Testnet validation rejected an unsigned block: %v", err)
+	}
+}
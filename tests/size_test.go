@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+func TestTransactionSizesMatchSerialization(t *testing.T) {
+	// Enough outputs and a long enough script to need multi-byte varints
+	wide := types.Transaction{
+		Version: 1,
+		Inputs: []types.TxInput{{
+			PrevTxHash:      types.Hash{3},
+			SignatureScript: bytes.Repeat([]byte{0x51}, 300),
+			Sequence:        0xFFFFFFFF,
+		}},
+	}
+	for i := 0; i < 300; i++ {
+		wide.Outputs = append(wide.Outputs, types.TxOutput{Value: 1000, PubKeyScript: []byte{0x51}})
+	}
+
+	tests := map[string]types.Transaction{
+		"legacy":  buildBranch(t, types.Hash{}, 1, 1, 0)[0].Transactions[0],
+		"wide":    wide,
+		"witness": witnessSpend(types.Hash{9}),
+	}
+	for name, tx := range tests {
+		stripped, _ := serialization.SerializeTransaction(&tx)
+		full, _ := serialization.SerializeTransactionWitness(&tx)
+
+		if got := transaction.StrippedSize(&tx); got != len(stripped) {
+			t.Errorf("%s: StrippedSize = %d, want %d", name, got, len(stripped))
+		}
+		if got := transaction.SerializedSize(&tx); got != len(full) {
+			t.Errorf("%s: SerializedSize = %d, want %d", name, got, len(full))
+		}
+
+		weight := 3*len(stripped) + len(full)
+		if got := transaction.Weight(&tx); got != weight {
+			t.Errorf("%s: Weight = %d, want %d", name, got, weight)
+		}
+		vsize := (weight + 3) / 4
+		if got := transaction.VirtualSize(&tx); got != vsize {
+			t.Errorf("%s: VirtualSize = %d, want %d", name, got, vsize)
+		}
+		if got := mempool.CalculateTransactionSize(&tx); got != int64(vsize) {
+			t.Errorf("%s: mempool size = %d, want the virtual size %d", name, got, vsize)
+		}
+	}
+
+	// Witness bytes are discounted, legacy bytes aren't
+	legacy := tests["legacy"]
+	if transaction.VirtualSize(&legacy) != transaction.SerializedSize(&legacy) {
+		t.Error("Legacy virtual size differs from its size")
+	}
+	spend := tests["witness"]
+	if transaction.VirtualSize(&spend) >= transaction.SerializedSize(&spend) {
+		t.Error("Witness data was not discounted")
+	}
+}
@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestSpentIndex(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	if _, err := client.GetSpentInfo(types.Hash{1}.String(), 0, false); err == nil {
+		t.Error("Lookup without the index succeeded")
+	}
+	chain.EnableSpentIndex()
+
+	// nullDataBlock's second transaction spends output 0 of Hash{height}
+	genesis := blockOn(t, types.Hash{}, 0, 0)
+	if err := chain.SaveBlock(genesis, 0); err != nil {
+		t.Fatal(err)
+	}
+	prev, _ := serialization.HashBlockHeader(&genesis.Header)
+	var blocks []*types.Block
+	for height := uint64(1); height <= 2; height++ {
+		block := nullDataBlock(t, prev, height, []byte("spend"))
+		if err := chain.SaveBlock(block, height); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+		prev, _ = serialization.HashBlockHeader(&block.Header)
+	}
+
+	spent := types.Hash{2}.String()
+	info, err := client.GetSpentInfo(spent, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spender, _ := serialization.HashTransaction(&blocks[1].Transactions[1])
+	if info == nil || info.TxID != spender.String() || info.Index != 0 || info.Height != 2 || !info.Confirmed {
+		t.Fatalf("Spent info = %+v", info)
+	}
+	if info.BlockHash != prev.String() {
+		t.Errorf("Block hash = %s, want %s", info.BlockHash, prev)
+	}
+	if info, err := client.GetSpentInfo(spent, 1, false); err != nil || info != nil {
+		t.Errorf("Unspent output = %+v, %v", info, err)
+	}
+
+	// A reorg replacing block 2 with one spending something else drops
+	// the spend, and the replacement's spend is indexed
+	forkHash, _ := serialization.HashBlockHeader(&blocks[0].Header)
+	replacement := nullDataBlock(t, forkHash, 2, []byte("replaced"))
+	replacement.Transactions[1].Inputs[0].PrevTxHash = types.Hash{9}
+	if err := chain.SwitchChain(1, []*types.Block{replacement}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := client.GetSpentInfo(spent, 0, false); err != nil || info != nil {
+		t.Errorf("Spend from the old branch = %+v, %v", info, err)
+	}
+	if info, err := client.GetSpentInfo(types.Hash{9}.String(), 0, false); err != nil || info == nil || info.Height != 2 {
+		t.Errorf("Spend from the new branch = %+v, %v", info, err)
+	}
+	if info, err := client.GetSpentInfo(types.Hash{1}.String(), 0, false); err != nil || info == nil || info.Height != 1 {
+		t.Errorf("Spend below the fork = %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// damageStorage edits the closed database at dir directly, as a bad disk
+// or an interrupted write might
+func damageStorage(t *testing.T, dir string, damage func(db *storage.Database)) {
+	t.Helper()
+	db, err := storage.OpenDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damage(db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Damaged index entries are reported as such rather than as missing
+// blocks, schedule a reindex of the heights involved, and are rebuilt
+// from the stored blocks, at once or on the next open
+func TestStorageRepair(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(5); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	chain, err := storage.NewBlockchainStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []*types.Block
+	for height := uint64(0); height <= 5; height++ {
+		block, err := node.Chain.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+		if err := chain.SaveBlock(block, height); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chain.Close()
+	coinbase, _ := serialization.HashTransaction(&blocks[4].Transactions[0])
+
+	// A height entry lost below the tip
+	damageStorage(t, dir, func(db *storage.Database) {
+		db.Delete(storage.HeightKey(3))
+	})
+	chain, err = storage.NewBlockchainStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.GetBlockByHeight(3); !errors.Is(err, storage.ErrIndexInconsistent) {
+		t.Errorf("Reading the lost height = %v, want ErrIndexInconsistent", err)
+	}
+	if from, pending, _ := chain.ReindexPending(); !pending || from != 3 {
+		t.Errorf("Reindex scheduled from %d (%t), want 3", from, pending)
+	}
+	if _, err := chain.GetBlockByHeight(6); !errors.Is(err, storage.ErrBlockNotFound) {
+		t.Errorf("Reading above the tip = %v, want ErrBlockNotFound", err)
+	}
+	if err := chain.RepairIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	if block, err := chain.GetBlockByHeight(3); err != nil || block.Header != blocks[3].Header {
+		t.Errorf("Height 3 after repair: %v", err)
+	}
+	if _, pending, _ := chain.ReindexPending(); pending {
+		t.Error("Reindex still scheduled after repair")
+	}
+
+	// Found but not repaired before shutdown: the next open repairs it
+	if _, err := chain.GetBlockByHeight(2); err != nil {
+		t.Fatal(err)
+	}
+	chain.Close()
+	damageStorage(t, dir, func(db *storage.Database) {
+		db.Put(storage.HeightKey(2), []byte{1, 2, 3})
+		db.Delete(storage.TxKey(coinbase))
+	})
+	chain, err = storage.NewBlockchainStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.GetBlockByHeight(2); !errors.Is(err, storage.ErrIndexInconsistent) {
+		t.Errorf("Reading a garbled height entry = %v", err)
+	}
+	chain.Close()
+	chain, err = storage.NewBlockchainStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.GetBlockByHeight(2); err != nil {
+		t.Errorf("Height 2 after reopening: %v", err)
+	}
+	if _, index, err := chain.GetTransactionLocation(coinbase); err != nil || index != 0 {
+		t.Errorf("Coinbase of block 4 after reopening: %d, %v", index, err)
+	}
+
+	// A transaction entry naming a block that isn't stored is dropped and
+	// the whole index rebuilt
+	stray := types.Hash{7}
+	chain.Close()
+	damageStorage(t, dir, func(db *storage.Database) {
+		db.Put(storage.TxKey(stray), append(make([]byte, 32), 0, 0, 0, 1))
+	})
+	chain, err = storage.NewBlockchainStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := chain.GetTransactionLocation(stray); !errors.Is(err, storage.ErrIndexInconsistent) {
+		t.Errorf("Reading a stray transaction entry = %v", err)
+	}
+	if from, pending, _ := chain.ReindexPending(); !pending || from != 0 {
+		t.Errorf("Reindex scheduled from %d (%t), want 0", from, pending)
+	}
+	if err := chain.RepairIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := chain.GetTransactionLocation(stray); !errors.Is(err, storage.ErrTxNotFound) {
+		t.Errorf("Stray transaction after repair = %v, want ErrTxNotFound", err)
+	}
+
+	// A block record that no longer matches its key is corruption, and a
+	// reindex can't get past it
+	hash3, _ := serialization.HashBlockHeader(&blocks[3].Header)
+	hash4, _ := serialization.HashBlockHeader(&blocks[4].Header)
+	raw3, err := chain.GetRawBlock(hash3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.Close()
+	damageStorage(t, dir, func(db *storage.Database) {
+		db.Put(storage.BlockKey(hash4), raw3)
+	})
+	chain, err = storage.NewBlockchainStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	if _, err := chain.GetBlock(hash4); !errors.Is(err, storage.ErrCorrupt) {
+		t.Errorf("Reading a swapped block = %v, want ErrCorrupt", err)
+	}
+	if _, err := chain.GetBlockByHeight(4); !errors.Is(err, storage.ErrCorrupt) {
+		t.Errorf("Reading a swapped block by height = %v, want ErrCorrupt", err)
+	}
+}
+
+// A database whose manifest is damaged is recovered from its tables
+func TestStorageRecoversManifest(t *testing.T) {
+	dir := t.TempDir()
+	db, err := storage.OpenDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	manifests, _ := filepath.Glob(filepath.Join(dir, "MANIFEST-*"))
+	if len(manifests) == 0 {
+		t.Fatal("No manifest written")
+	}
+	for _, manifest := range manifests {
+		if err := os.WriteFile(manifest, []byte("garbage"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err = storage.OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("Opening with a damaged manifest: %v", err)
+	}
+	defer db.Close()
+	if !db.Recovered() {
+		t.Error("Recovered() = false")
+	}
+	if value, err := db.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("After recovery key = %q, %v", value, err)
+	}
+}
@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// blockOn builds a block paying tag's coinbase on top of prev
+func blockOn(t *testing.T, prev types.Hash, height uint64, tag uint64) *types.Block {
+	t.Helper()
+	coinbase, err := mining.CreateCoinbase(height, 0, "submitted", tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := mining.BuildBlock(&mining.BlockTemplate{
+		Version:       1,
+		PrevBlockHash: prev,
+		Transactions:  []types.Transaction{*coinbase},
+		Timestamp:     uint32(testharness.GenesisTimestamp + 600*height),
+		Bits:          testharness.RegtestBits,
+		Height:        height,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func blockHex(t *testing.T, block *types.Block) string {
+	t.Helper()
+	data, err := serialization.SerializeBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(data)
+}
+
+func TestSubmitBlock(t *testing.T) {
+	h, err := testharness.New(2)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	node := h.Node(0)
+	if err := h.Connect(0, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.MineBlocks(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.WaitForSync(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	server := rpc.NewServer(node.Wallet, node.Chain, "")
+	server.SetNode(node.P2P)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	tip, _ := node.BestHash()
+	block := blockOn(t, tip, 3, 1)
+
+	result, err := client.SubmitBlock(blockHex(t, block))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Accepted {
+		t.Fatalf("Block rejected: %+v", result)
+	}
+	if best, _ := node.BestHash(); best != blockHash(t, block) {
+		t.Errorf("Tip = %s, want the submitted block", best)
+	}
+	// Submitted blocks are relayed like mined ones
+	if err := h.WaitForSync(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := blockOn(t, blockHash(t, block), 4, 1)
+	tampered.Header.MerkleRoot = types.Hash{1}
+
+	// Regtest targets accept any hash, so the timestamp can be changed
+	future := blockOn(t, blockHash(t, block), 4, 2)
+	future.Header.Timestamp = uint32(h.Clock.Now().Add(validation.MaxFutureBlockTime + time.Minute).Unix())
+
+	for name, tc := range map[string]struct {
+		block  *types.Block
+		reason string
+	}{
+		"duplicate":         {block, validation.RejectDuplicate},
+		"unknown parent":    {blockOn(t, types.Hash{2}, 4, 1), validation.RejectPrevNotFound},
+		"wrong merkle root": {tampered, validation.RejectBadMerkleRoot},
+		"too far ahead":     {future, validation.RejectTimeTooNew},
+	} {
+		result, err := client.SubmitBlock(blockHex(t, tc.block))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if result.Accepted || result.Reason != tc.reason {
+			t.Errorf("%s: result %+v, want reason %q", name, result, tc.reason)
+		}
+	}
+
+	if _, err := client.SubmitBlock("not hex"); err == nil {
+		t.Error("Undecodable block accepted")
+	}
+}
+
+func TestSubmitHeader(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	node := h.Node(0)
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+
+	server := rpc.NewServer(node.Wallet, node.Chain, "")
+	server.SetNode(node.P2P)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	tip, _ := node.BestHash()
+	block := blockOn(t, tip, 2, 1)
+	header, err := serialization.SerializeBlockHeader(&block.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.SubmitHeader(hex.EncodeToString(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Accepted {
+		t.Fatalf("Header rejected: %+v", result)
+	}
+
+	tips, err := client.GetChainTips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tips) != 2 || tips[0].Hash != blockHash(t, block).String() || tips[0].Status != storage.ChainTipHeadersOnly {
+		t.Errorf("Chain tips = %+v, want the submitted header first", tips)
+	}
+
+	// Its block is then accepted, leaving a single active tip
+	if result, err := client.SubmitBlock(blockHex(t, block)); err != nil || !result.Accepted {
+		t.Fatalf("Block rejected: %+v, %v", result, err)
+	}
+	tips, _ = client.GetChainTips()
+	if len(tips) != 1 || tips[0].Status != storage.ChainTipActive {
+		t.Errorf("Chain tips = %+v, want the block as the only tip", tips)
+	}
+
+	orphan := blockOn(t, types.Hash{3}, 2, 2)
+	header, _ = serialization.SerializeBlockHeader(&orphan.Header)
+	if result, _ := client.SubmitHeader(hex.EncodeToString(header)); result == nil || result.Reason != validation.RejectPrevNotFound {
+		t.Errorf("Unconnected header result = %+v", result)
+	}
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// rebuildBlock recomputes the merkle root after a test edits transactions
+func rebuildBlock(t *testing.T, block *types.Block, height uint64) *types.Block {
+	t.Helper()
+
+	rebuilt, err := mining.BuildBlock(&mining.BlockTemplate{
+		Version:       block.Header.Version,
+		PrevBlockHash: block.Header.PrevBlockHash,
+		Transactions:  block.Transactions,
+		Timestamp:     block.Header.Timestamp,
+		Bits:          block.Header.Bits,
+		Height:        height,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rebuilt
+}
+
+func TestCoinbaseRewardCountsEveryOutput(t *testing.T) {
+	block := buildBranch(t, types.Hash{}, 1, 1, 0)[0]
+
+	// Two outputs each claiming the full subsidy
+	coinbase := &block.Transactions[0]
+	coinbase.Outputs = append(coinbase.Outputs, coinbase.Outputs[0])
+	block = rebuildBlock(t, block, 1)
+
+	err := validation.NewBlockValidator(utxo.NewUTXOSet()).ValidateBlock(block, 1, types.Hash{})
+	if err == nil || !strings.Contains(err.Error(), "invalid block reward") {
+		t.Fatalf("ValidateBlock = %v, want a block reward error", err)
+	}
+}
+
+func TestBlockRewardFollowsNetworkSubsidy(t *testing.T) {
+	// Regtest halves every 150 blocks, so a full 50 BTC coinbase at 150
+	// is only valid on mainnet
+	block := buildBranch(t, types.Hash{}, 150, 1, 0)[0]
+
+	mainnet := validation.NewBlockValidator(utxo.NewUTXOSet())
+	if err := mainnet.ValidateBlock(block, 150, types.Hash{}); err != nil {
+		t.Fatalf("Mainnet rejected a 50 BTC coinbase at 150: %v", err)
+	}
+
+	regtest := validation.NewBlockValidator(utxo.NewUTXOSet())
+	regtest.SetRules(consensus.NewRegtestRules())
+	if err := regtest.ValidateBlock(block, 150, types.Hash{}); err == nil {
+		t.Fatal("Regtest accepted a 50 BTC coinbase after its first halving")
+	}
+}
+
+func TestTransactionTotalsMustStayInMoneyRange(t *testing.T) {
+	set := utxo.NewUTXOSet()
+	funding := types.Hash{7}
+	if err := set.Add(utxo.NewUTXO(funding, 0, types.TxOutput{Value: 100000000, PubKeyScript: []byte{0x51}}, 0, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each output is in range but together they exceed 21 million BTC
+	spend := types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: funding, OutputIndex: 0, Sequence: 0xFFFFFFFF}},
+		Outputs: []types.TxOutput{
+			{Value: 15000000 * 100000000, PubKeyScript: []byte{0x51}},
+			{Value: 15000000 * 100000000, PubKeyScript: []byte{0x51}},
+		},
+	}
+
+	block := buildBranch(t, types.Hash{}, 1, 1, 0)[0]
+	block.Transactions = append(block.Transactions, spend)
+	block = rebuildBlock(t, block, 1)
+
+	err := validation.NewBlockValidator(set).ValidateBlock(block, 1, types.Hash{})
+	if err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Fatalf("ValidateBlock = %v, want a money range error", err)
+	}
+}
+
+func TestIsValidChainCatchesInflation(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	// Stored directly, skipping the checks AcceptBlock would run
+	blocks := buildBranch(t, types.Hash{}, 0, 3, 0)
+	inflated := &blocks[2].Transactions[0]
+	inflated.Outputs[0].Value *= 2
+	blocks[2] = rebuildBlock(t, blocks[2], 2)
+
+	for i, block := range blocks {
+		if err := chain.SaveBlock(block, uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cv := validation.NewChainValidator(chain, utxo.NewUTXOSet())
+	err = cv.IsValidChain()
+	if err == nil || !strings.Contains(err.Error(), "height 2") {
+		t.Fatalf("IsValidChain = %v, want the inflated block at height 2 flagged", err)
+	}
+}
@@ -0,0 +1,33 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// The benchmarks live in the packages they measure and run on these
+// blocks, e.g. `go test ./pkg/validation -run=^$ -bench=. -benchmem`
+
+func TestSyntheticBlockIsValid(t *testing.T) {
+	block, set, err := testharness.SyntheticBlock(testharness.SyntheticBlockConfig{Transactions: 20, Inputs: 3, Outputs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Transactions) != 21 || set.Size() != 60 {
+		t.Fatalf("Got %d transactions spending %d outputs, want 21 and 60", len(block.Transactions), set.Size())
+	}
+
+	validator := validation.NewBlockValidator(set)
+	if err := validator.ValidateBlock(block, 1, types.Hash{}); err != nil {
+		t.Fatalf("Synthetic block rejected: %v", err)
+	}
+	if err := validator.ApplyBlock(block, 1); err != nil {
+		t.Fatal(err)
+	}
+	if set.Size() != 1+20*2 {
+		t.Errorf("UTXO set has %d outputs after connecting, want 41", set.Size())
+	}
+}
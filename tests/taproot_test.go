@@ -0,0 +1,413 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// BIP340 test vectors
+func TestSchnorrVectors(t *testing.T) {
+	vectors := []struct {
+		secKey, pubKey, auxRand, msg, sig string
+		valid                             bool
+	}{
+		{"0000000000000000000000000000000000000000000000000000000000000003",
+			"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0", true},
+		{"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+			"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+			"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A", true},
+		{"", "D69C3509BB99E412E68B0FE8544E72837DFA30746D8BE2AA65975F29D22DC7B9", "",
+			"4DF3C3F68FCC83B27E9D42C90431A72499F17875C81A599B566C9889B9696703",
+			"00000000000000000000003B78CE563F89A0ED9414F5AA28AD0D96D6795F9C6376AFB1548AF603B3EB45C9F8207DEE1060CB71C04E80F593060B07D28308D7F4", true},
+		// Public key not on the curve
+		{"", "EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34", "",
+			"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+			"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B", false},
+	}
+	for i, v := range vectors {
+		pubKey, msg, sig := unhex(t, v.pubKey), unhex(t, v.msg), unhex(t, v.sig)
+		if v.secKey != "" {
+			key, err := keys.NewPrivateKeyFromBytes(unhex(t, v.secKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(key.PublicKey().XOnly(), pubKey) {
+				t.Errorf("vector %d: public key %x", i, key.PublicKey().XOnly())
+			}
+			got, err := key.SignSchnorr(msg, unhex(t, v.auxRand))
+			if err != nil || !bytes.Equal(got, sig) {
+				t.Errorf("vector %d: signature %x, %v", i, got, err)
+			}
+		}
+		if got := keys.VerifySchnorr(pubKey, msg, sig); got != v.valid {
+			t.Errorf("vector %d: VerifySchnorr = %v", i, got)
+		}
+	}
+
+	// A changed signature no longer verifies
+	v := vectors[1]
+	sig := unhex(t, v.sig)
+	sig[63] ^= 1
+	if keys.VerifySchnorr(unhex(t, v.pubKey), unhex(t, v.msg), sig) {
+		t.Error("Tampered signature verifies")
+	}
+}
+
+func TestSchnorrBatch(t *testing.T) {
+	// Sizes below and above the point where the batch is worth it
+	for _, n := range []int{1, 3, 40} {
+		batch := keys.NewSchnorrBatch()
+		pubKeys, hashes, sigs := make([][]byte, n), make([][]byte, n), make([][]byte, n)
+		for i := 0; i < n; i++ {
+			key, err := keys.GeneratePrivateKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash := sha256.Sum256([]byte{byte(i)})
+			sig, err := key.SignSchnorr(hash[:], nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pubKeys[i], hashes[i], sigs[i] = key.PublicKey().XOnly(), hash[:], sig
+			if !batch.Add(pubKeys[i], hashes[i], sigs[i]) {
+				t.Fatalf("%d signatures: Add(%d) failed", n, i)
+			}
+		}
+		if !batch.Verify() {
+			t.Errorf("%d valid signatures: batch fails", n)
+		}
+
+		// Signing the wrong hash is only found by the batch
+		bad := n / 2
+		tampered := keys.NewSchnorrBatch()
+		for i := 0; i < n; i++ {
+			hash := hashes[i]
+			if i == bad {
+				hash = hashes[(i+1)%n]
+				if n == 1 {
+					hash = make([]byte, 32)
+				}
+			}
+			tampered.Add(pubKeys[i], hash, sigs[i])
+		}
+		if tampered.Verify() {
+			t.Errorf("%d signatures, one invalid: batch verifies", n)
+		}
+		if got := tampered.FirstInvalid(); got != bad {
+			t.Errorf("%d signatures: FirstInvalid = %d, want %d", n, got, bad)
+		}
+	}
+
+	// What can't be valid is refused up front
+	batch := keys.NewSchnorrBatch()
+	key, _ := keys.GeneratePrivateKey()
+	hash := sha256.Sum256([]byte("refused"))
+	sig, _ := key.SignSchnorr(hash[:], nil)
+	highS := append(append([]byte(nil), sig[:32]...), bytes.Repeat([]byte{0xff}, 32)...)
+	for name, sig := range map[string][]byte{"short": sig[:63], "s overflows": highS} {
+		if batch.Add(key.PublicKey().XOnly(), hash[:], sig) {
+			t.Errorf("%s signature accepted", name)
+		}
+	}
+	if batch.Len() != 0 || !batch.Verify() {
+		t.Errorf("refused signatures were queued")
+	}
+}
+
+// BIP341 wallet test vectors: output keys, addresses and control blocks
+func TestTaprootTreeVectors(t *testing.T) {
+	leaf := func(s string) script.TapLeaf { return script.NewTapLeaf(unhex(t, s)) }
+	vectors := []struct {
+		internalKey string
+		tree        *script.TapTree
+		leafHashes  []string
+		address     string
+		controls    []string
+	}{
+		{"d6889cb081036e0faefa3a35157ad71086b123b2b144b649798b494c300a961d", nil, nil,
+			"bc1p2wsldez5mud2yam29q22wgfh9439spgduvct83k3pm50fcxa5dps59h4z5", nil},
+		{"187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27",
+			script.TapLeafNode(leaf("20d85a959b0290bf19bb89ed43c916be835475d013da4b362117393e25a48229b8ac")),
+			[]string{"5b75adecf53548f3ec6ad7d78383bf84cc57b55a3127c72b9a2481752dd88b21"},
+			"bc1pz37fc4cn9ah8anwm4xqqhvxygjf9rjf2resrw8h8w4tmvcs0863sa2e586",
+			[]string{"c1187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27"}},
+		{"93478e9488f956df2396be2ce6c5cced75f900dfa18e7dabd2428aae78451820",
+			script.TapLeafNode(leaf("20b617298552a72ade070667e86ca63b8f5789a9fe8731ef91202a91c9f3459007ac")),
+			[]string{"c525714a7f49c28aedbbba78c005931a81c234b2f6c99a73e4d06082adc8bf2b"},
+			"bc1punvppl2stp38f7kwv2u2spltjuvuaayuqsthe34hd2dyy5w4g58qqfuag5",
+			[]string{"c093478e9488f956df2396be2ce6c5cced75f900dfa18e7dabd2428aae78451820"}},
+		{"ee4fe085983462a184015d1f782d6a5f8b9c2b60130aff050ce221ecf3786592",
+			script.TapBranchNode(
+				script.TapLeafNode(leaf("20387671353e273264c495656e27e39ba899ea8fee3bb69fb2a680e22093447d48ac")),
+				script.TapLeafNode(script.TapLeaf{Version: 250, Script: unhex(t, "06424950333431")})),
+			[]string{"8ad69ec7cf41c2a4001fd1f738bf1e505ce2277acdcaa63fe4765192497f47a7", "f224a923cd0021ab202ab139cc56802ddb92dcfc172b9212261a539df79a112a"},
+			"bc1pwyjywgrd0ffr3tx8laflh6228dj98xkjj8rum0zfpd6h0e930h6saqxrrm",
+			[]string{"c0ee4fe085983462a184015d1f782d6a5f8b9c2b60130aff050ce221ecf3786592f224a923cd0021ab202ab139cc56802ddb92dcfc172b9212261a539df79a112a",
+				"faee4fe085983462a184015d1f782d6a5f8b9c2b60130aff050ce221ecf37865928ad69ec7cf41c2a4001fd1f738bf1e505ce2277acdcaa63fe4765192497f47a7"}},
+	}
+	for i, v := range vectors {
+		internalKey, err := keys.ParseXOnlyPublicKey(unhex(t, v.internalKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := &contracts.TaprootOutput{InternalKey: internalKey, Tree: v.tree}
+		pkScript, err := output.Script()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := keys.AddressFromScript(pkScript, keys.MainNetParams)
+		if err != nil || addr.String() != v.address {
+			t.Errorf("vector %d: address %v, %v", i, addr, err)
+		}
+		if v.tree == nil {
+			continue
+		}
+
+		for j, leaf := range v.tree.Leaves() {
+			if got := hex.EncodeToString(leaf.Hash()); got != v.leafHashes[j] {
+				t.Errorf("vector %d leaf %d: hash %s", i, j, got)
+			}
+			control, err := output.ControlBlock(leaf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(control.Bytes()); got != v.controls[j] {
+				t.Errorf("vector %d leaf %d: control block %s", i, j, got)
+			}
+			parsed, err := script.ParseControlBlock(control.Bytes())
+			if err != nil || !bytes.Equal(parsed.RootHash(leaf.Script), v.tree.RootHash()) {
+				t.Errorf("vector %d leaf %d: control block proves the wrong root: %v", i, j, err)
+			}
+		}
+	}
+}
+
+func TestTapscriptRules(t *testing.T) {
+	key := bytes.Repeat([]byte{2}, 32)
+	sig := bytes.Repeat([]byte{1}, 64)
+	run := func(leafScript []byte, stack ...[]byte) error {
+		engine, err := script.NewTapscriptEngine(leafScript, stack, 100)
+		if err != nil {
+			return err
+		}
+		engine.SetSigChecker(func(s, k []byte) bool {
+			return bytes.Equal(s, sig) && bytes.Equal(k, key)
+		})
+		return engine.Execute()
+	}
+
+	// Empty signatures count as no, others must verify
+	checkSigAdd := script.NewBuilder().AddData(key).AddOp(script.OP_CHECKSIG).
+		AddData(key).AddOp(script.OP_CHECKSIGADD).AddInt(2).AddOp(script.OP_NUMEQUAL).Script()
+	if err := run(checkSigAdd, sig, sig); err != nil {
+		t.Errorf("2 of 2 signed: %v", err)
+	}
+	if err := run(checkSigAdd, sig, []byte{}); err == nil {
+		t.Error("2 of 2 with one signature succeeded")
+	}
+	if err := run(checkSigAdd, sig, bytes.Repeat([]byte{3}, 64)); !errors.Is(err, script.ErrSchnorrSig) {
+		t.Errorf("2 of 2 with a bad signature: error = %v", err)
+	}
+
+	// Without a checker a signature can't be verified, so it fails
+	engine, err := script.NewTapscriptEngine(checkSigAdd, [][]byte{sig, sig}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Execute(); !errors.Is(err, script.ErrNoSigChecker) {
+		t.Errorf("Signature check without a checker: error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		script []byte
+		stack  [][]byte
+		ok     bool
+		want   error // If not ok, nil for any error
+	}{
+		{"OP_SUCCESS in a branch not taken", []byte{script.OP_0, script.OP_IF, 0x50, script.OP_ENDIF, script.OP_0}, nil, true, nil},
+		{"OP_SUCCESS after a bad push", []byte{script.OP_PUSHDATA1, 0x50}, nil, false, nil},
+		{"OP_CHECKMULTISIG", []byte{script.OP_0, script.OP_0, script.OP_0, script.OP_CHECKMULTISIG}, nil, false, script.ErrTapscriptCheckMulti},
+		{"OP_IF takes 1", []byte{script.OP_IF, script.OP_1, script.OP_ENDIF}, [][]byte{{1}}, true, nil},
+		{"OP_IF refuses 2", []byte{script.OP_IF, script.OP_1, script.OP_ENDIF}, [][]byte{{2}}, false, script.ErrTapscriptMinimalIf},
+		{"no opcode limit", append(bytes.Repeat([]byte{script.OP_NOP}, 300), script.OP_1), nil, true, nil},
+		{"signature budget", bytes.Repeat(append(append([]byte{32}, key...), script.OP_CHECKSIGVERIFY), 4), [][]byte{sig, sig, sig, sig}, false, script.ErrTapscriptSigOpsBudget},
+	}
+	for _, tt := range tests {
+		err := run(tt.script, tt.stack...)
+		switch {
+		case tt.ok && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case !tt.ok && err == nil:
+			t.Errorf("%s: succeeded", tt.name)
+		case !tt.ok && tt.want != nil && !errors.Is(err, tt.want):
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestTaprootSpends(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// The node's wallet holds two keys; the internal key of the second
+	// output belongs to someone else
+	pubKey := func(w *wallet.Wallet) *keys.PublicKey {
+		address, err := w.GenerateAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, _ := w.GetKey(address)
+		return key.PublicKey()
+	}
+	alice, bob := pubKey(node.Wallet), pubKey(node.Wallet)
+	carol := pubKey(wallet.NewWallet())
+
+	twoOfTwo, err := contracts.CheckSigAddScript(2, alice.XOnly(), bob.XOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	carolOnly, _ := contracts.CheckSigAddScript(1, carol.XOnly())
+	tree := script.BalancedTapTree(script.NewTapLeaf(carolOnly), script.NewTapLeaf(twoOfTwo))
+	outputs := []*contracts.TaprootOutput{
+		{InternalKey: alice, Tree: tree},
+		{InternalKey: carol, Tree: tree},
+	}
+
+	for i, output := range outputs {
+		address, err := node.Wallet.TaprootAddress(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		funding, err := node.SendTo(address, 100000000, 10000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := node.MineBlocks(1); err != nil {
+			t.Fatal(err)
+		}
+		pkScript, _ := output.Script()
+		index := -1
+		for j, out := range funding.Outputs {
+			if bytes.Equal(out.PubKeyScript, pkScript) {
+				index = j
+			}
+		}
+		if index < 0 {
+			t.Fatalf("output %d: funding transaction doesn't pay the taproot script", i)
+		}
+
+		payTo := types.TxOutput{Value: funding.Outputs[index].Value - 10000, PubKeyScript: []byte{script.OP_TRUE}}
+		spend := contracts.SpendTx(txid(t, funding), uint32(index), payTo, 0)
+		prevOuts := []types.TxOutput{funding.Outputs[index]}
+		keyPath, err := node.Wallet.SignTaproot(spend, 0, prevOuts, output)
+		if err != nil {
+			t.Fatalf("output %d: %v", i, err)
+		}
+		if wantKeyPath := i == 0; keyPath != wantKeyPath {
+			t.Errorf("output %d: key path = %v", i, keyPath)
+		}
+		if err := transaction.VerifyTaprootInput(spend, 0, prevOuts); err != nil {
+			t.Errorf("output %d: %v", i, err)
+		}
+
+		// A signature committing to a different amount fails
+		wrongAmount := []types.TxOutput{{Value: prevOuts[0].Value + 1, PubKeyScript: prevOuts[0].PubKeyScript}}
+		if err := transaction.VerifyTaprootInput(spend, 0, wrongAmount); err == nil {
+			t.Errorf("output %d: spend verifies against the wrong amount", i)
+		}
+
+		// So does a witness whose leaf isn't in the tree
+		if !keyPath {
+			tampered := *spend
+			tampered.Inputs = []types.TxInput{spend.Inputs[0]}
+			w := spend.Inputs[0].Witness
+			tampered.Inputs[0].Witness = append(append([][]byte{}, w[:len(w)-2]...), carolOnly, w[len(w)-1])
+			if err := node.P2P.BroadcastTransaction(&tampered); !errors.Is(err, mempool.ErrScriptFailed) {
+				t.Errorf("Broadcasting a leaf not in the tree = %v", err)
+			}
+		}
+
+		if err := node.P2P.BroadcastTransaction(spend); err != nil {
+			t.Fatalf("output %d: broadcasting: %v", i, err)
+		}
+		if _, err := node.MineBlocks(1); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := node.Chain.GetTransactionLocation(txid(t, spend)); err != nil {
+			t.Errorf("output %d: spend not confirmed: %v", i, err)
+		}
+	}
+
+	// Without the internal key or enough leaf keys there is no spend
+	output := &contracts.TaprootOutput{InternalKey: carol, Tree: script.TapLeafNode(script.NewTapLeaf(carolOnly))}
+	spend := contracts.SpendTx(types.Hash{}, 0, types.TxOutput{Value: 1}, 0)
+	if _, err := node.Wallet.SignTaproot(spend, 0, []types.TxOutput{{Value: 2}}, output); !errors.Is(err, wallet.ErrCannotSpendTaproot) {
+		t.Errorf("SignTaproot without keys = %v", err)
+	}
+}
+
+// A block's taproot signatures are verified as one batch; if it fails the
+// input at fault is still named
+func TestBlockSchnorrBatch(t *testing.T) {
+	block, set, err := testharness.SyntheticBlock(testharness.SyntheticBlockConfig{Transactions: 10, Inputs: 2, Outputs: 1, Taproot: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := func(block *types.Block) error {
+		validator := validation.NewBlockValidator(set.Clone())
+		validator.SetRules(consensus.NewRegtestRules())
+		return validator.ValidateBlock(block, 1, types.Hash{})
+	}
+	if err := validate(block); err != nil {
+		t.Fatalf("valid block rejected: %v", err)
+	}
+
+	// Swapping two inputs' signatures keeps them well formed but wrong.
+	// The merkle root is checked after the transactions.
+	tx := &block.Transactions[7]
+	tx.Inputs[0].Witness, tx.Inputs[1].Witness = tx.Inputs[1].Witness, tx.Inputs[0].Witness
+	err = validate(block)
+	if !errors.Is(err, script.ErrSchnorrSig) || validation.RejectReason(err) != validation.RejectScriptFailed {
+		t.Fatalf("bad signature: got %v", err)
+	}
+	if want := "transaction 7 inputs invalid: input 0"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q doesn't name %q", err, want)
+	}
+}
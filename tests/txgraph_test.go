@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/txgraph"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// A confirmed funding transaction, a mempool child and grandchild: the
+// graph is found from any of them, across the mempool and the chain
+func TestTxGraph(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	node.Chain.EnableSpentIndex()
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	anyone := []byte{script.OP_TRUE}
+	funding, err := node.SendToScript(anyone, 100000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	index := -1
+	for i, out := range funding.Outputs {
+		if len(out.PubKeyScript) == 1 && out.PubKeyScript[0] == script.OP_TRUE {
+			index = i
+		}
+	}
+	child := contracts.SpendTx(txid(t, funding), uint32(index), types.TxOutput{Value: 90000, PubKeyScript: anyone}, 0)
+	grandchild := contracts.SpendTx(txid(t, child), 0, types.TxOutput{Value: 80000, PubKeyScript: anyone}, 0)
+	if err := node.P2P.BroadcastTransaction(child); err != nil {
+		t.Fatalf("Broadcasting the child: %v", err)
+	}
+	if err := node.P2P.BroadcastTransaction(grandchild); err != nil {
+		t.Fatalf("Broadcasting the grandchild: %v", err)
+	}
+
+	graph := txgraph.New(node.Chain, node.P2P.Mempool)
+	result, err := graph.Query(txid(t, child), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Root.Confirmed || result.Root.Fee != 10000 {
+		t.Errorf("Root = %+v, want a mempool transaction paying 10000", result.Root)
+	}
+	if len(result.Ancestors) != 1 || result.Ancestors[0].TxHash != txid(t, funding) || !result.Ancestors[0].Confirmed || result.Ancestors[0].Depth != -1 {
+		t.Errorf("Ancestors = %+v, want the confirmed funding transaction", result.Ancestors)
+	}
+	if len(result.Descendants) != 1 || result.Descendants[0].TxHash != txid(t, grandchild) || result.Descendants[0].Depth != 1 {
+		t.Errorf("Descendants = %+v, want the grandchild", result.Descendants)
+	}
+	if len(result.Root.Parents) != 1 || len(result.Root.Children) != 1 {
+		t.Errorf("Root links = %v, %v", result.Root.Parents, result.Root.Children)
+	}
+
+	// Once mined, the funding transaction's spends come from the spent
+	// index, the grandchild's parent from the transaction index
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	result, err = graph.Query(txid(t, funding), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Descendants) != 2 || result.Descendants[1].TxHash != txid(t, grandchild) || !result.Descendants[1].Confirmed || result.Truncated {
+		t.Errorf("Descendants of the funding transaction = %+v, truncated %t", result.Descendants, result.Truncated)
+	}
+
+	server := rpc.NewServer(node.Wallet, node.Chain, "")
+	server.SetNode(node.P2P)
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	resp, err := client.GetTxGraph(txid(t, grandchild).String(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Ancestors) < 2 || resp.Ancestors[0].TxID != txid(t, child).String() || resp.Ancestors[1].TxID != txid(t, funding).String() || resp.Ancestors[1].Depth != -2 {
+		t.Errorf("Ancestors of the grandchild = %+v", resp.Ancestors)
+	}
+	if _, err := client.GetTxGraph(types.Hash{1}.String(), 2); err == nil {
+		t.Error("Graph of an unknown transaction")
+	}
+}
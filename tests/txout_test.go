@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+func TestPartialMerkleTreeRoundTrip(t *testing.T) {
+	for count := 1; count <= 17; count++ {
+		txHashes := make([]types.Hash, count)
+		for i := range txHashes {
+			txHashes[i] = crypto.DoubleSHA256([]byte{byte(count), byte(i)})
+		}
+		root := crypto.ComputeMerkleRoot(txHashes)
+
+		subsets := [][]int{{}, {0}, {count - 1}}
+		var every, odd []int
+		for i := 0; i < count; i++ {
+			every = append(every, i)
+			if i%3 == 1 {
+				odd = append(odd, i)
+			}
+		}
+		subsets = append(subsets, every, odd)
+
+		for _, subset := range subsets {
+			wanted := make(map[types.Hash]bool)
+			for _, i := range subset {
+				wanted[txHashes[i]] = true
+			}
+
+			header := types.BlockHeader{Version: 1, MerkleRoot: root}
+			data, err := protocol.NewMerkleBlockMessage(header, txHashes, wanted).Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := protocol.DeserializeMerkleBlock(data)
+			if err != nil {
+				t.Fatalf("%d txs, matching %v: %v", count, subset, err)
+			}
+
+			gotRoot, matches, err := decoded.Tree.ExtractMatches()
+			if err != nil {
+				t.Fatalf("%d txs, matching %v: %v", count, subset, err)
+			}
+			if gotRoot != root {
+				t.Errorf("%d txs, matching %v: root %s, want %s", count, subset, gotRoot, root)
+			}
+			if len(matches) != len(subset) {
+				t.Fatalf("%d txs, matching %v: got %d matches", count, subset, len(matches))
+			}
+			for i, match := range matches {
+				if match != txHashes[subset[i]] {
+					t.Errorf("%d txs: match %d is %s, want %s", count, i, match, txHashes[subset[i]])
+				}
+			}
+		}
+	}
+}
+
+func TestPartialMerkleTreeRejectsTampering(t *testing.T) {
+	txHashes := make([]types.Hash, 7)
+	for i := range txHashes {
+		txHashes[i] = crypto.DoubleSHA256([]byte{byte(i)})
+	}
+	root := crypto.ComputeMerkleRoot(txHashes)
+	matches := []bool{false, false, true, false, false, false, false}
+
+	tree := crypto.NewPartialMerkleTree(txHashes, matches)
+	tree.Hashes[0][0] ^= 1
+	if got, _, err := tree.ExtractMatches(); err == nil && got == root {
+		t.Error("Proof with a modified hash still produced the merkle root")
+	}
+
+	tree = crypto.NewPartialMerkleTree(txHashes, matches)
+	tree.Hashes = append(tree.Hashes, types.Hash{1})
+	if _, _, err := tree.ExtractMatches(); err == nil {
+		t.Error("Proof with an extra hash accepted")
+	}
+
+	tree = crypto.NewPartialMerkleTree(txHashes, matches)
+	tree.Flags = append(tree.Flags, make([]bool, 8)...)
+	if _, _, err := tree.ExtractMatches(); err == nil {
+		t.Error("Proof with an extra flag byte accepted")
+	}
+
+	// A block whose last two transactions are identical has the same root
+	// as the block without the duplicate (CVE-2012-2459)
+	duplicated := append(append([]types.Hash{}, txHashes[:6]...), txHashes[5], txHashes[5])
+	tree = crypto.NewPartialMerkleTree(duplicated, []bool{false, false, false, false, false, false, false, true})
+	if _, _, err := tree.ExtractMatches(); err == nil {
+		t.Error("Proof with identical siblings accepted")
+	}
+}
+
+// txoutServer serves RPC over a chain of two blocks whose second block has
+// a spend of the first coinbase, with a UTXO set matching it
+func txoutServer(t *testing.T) (*rpc.Client, *rpc.Server, []*types.Block, *storage.BlockchainStorage) {
+	t.Helper()
+
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Close() })
+
+	blocks := buildBranch(t, types.Hash{}, 0, 2, 0)
+	spend := rbfSpend(txid(t, &blocks[0].Transactions[0]), 0xffffffff, 1000)
+	blocks[1].Transactions = append(blocks[1].Transactions, *spend)
+	blocks[1] = rebuildBlock(t, blocks[1], 1)
+
+	set := utxo.NewUTXOSet()
+	for height, block := range blocks {
+		if err := chain.SaveBlock(block, uint64(height)); err != nil {
+			t.Fatal(err)
+		}
+		for i := range block.Transactions {
+			tx := &block.Transactions[i]
+			if err := set.ApplyTransaction(tx, txid(t, tx), uint64(height), i == 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	server.SetUTXOSet(set)
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return rpc.NewClient(ts.URL), server, blocks, chain
+}
+
+func TestGetTxOut(t *testing.T) {
+	client, server, blocks, chain := txoutServer(t)
+
+	coinbase := txid(t, &blocks[1].Transactions[0])
+	out, err := client.GetTxOut(coinbase.String(), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out == nil {
+		t.Fatal("Unspent coinbase output not found")
+	}
+	want := blocks[1].Transactions[0].Outputs[0]
+	if out.Confirmations != 1 || !out.Coinbase || out.Value != want.Value || out.ScriptPubKey != hex.EncodeToString(want.PubKeyScript) {
+		t.Errorf("Coinbase output = %+v", out)
+	}
+
+	spentCoinbase := txid(t, &blocks[0].Transactions[0])
+	if out, err := client.GetTxOut(spentCoinbase.String(), 0, true); err != nil || out != nil {
+		t.Errorf("Spent output: got %+v, %v, want nil", out, err)
+	}
+	if out, err := client.GetTxOut(coinbase.String(), 5, true); err != nil || out != nil {
+		t.Errorf("Missing output index: got %+v, %v, want nil", out, err)
+	}
+
+	// A mempool spend only hides the output when the mempool is included
+	node := network.NewNode(network.NodeConfig{ListenAddr: "127.0.0.1:0"}, chain)
+	server.SetNode(node)
+	pending := rbfSpend(coinbase, 0xffffffff, 5000)
+	if err := node.Mempool.Add(pending, 10000, 2); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := client.GetTxOut(coinbase.String(), 0, true); err != nil || out != nil {
+		t.Errorf("Output spent in mempool: got %+v, %v, want nil", out, err)
+	}
+	if out, err := client.GetTxOut(coinbase.String(), 0, false); err != nil || out == nil {
+		t.Errorf("Output spent in mempool without include_mempool: got %+v, %v", out, err)
+	}
+
+	out, err = client.GetTxOut(txid(t, pending).String(), 0, true)
+	if err != nil || out == nil {
+		t.Fatalf("Mempool output: got %+v, %v", out, err)
+	}
+	if out.Confirmations != 0 || out.Value != 5000 {
+		t.Errorf("Mempool output = %+v", out)
+	}
+}
+
+func TestTxOutProof(t *testing.T) {
+	client, _, blocks, _ := txoutServer(t)
+
+	coinbase := txid(t, &blocks[1].Transactions[0]).String()
+	spend := txid(t, &blocks[1].Transactions[1]).String()
+
+	proof, err := client.GetTxOutProof([]string{spend}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	txids, err := client.VerifyTxOutProof(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txids) != 1 || txids[0] != spend {
+		t.Errorf("Verified txids %v, want [%s]", txids, spend)
+	}
+
+	blockHash, _ := serialization.HashBlockHeader(&blocks[1].Header)
+	proof, err = client.GetTxOutProof([]string{spend, coinbase}, blockHash.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txids, err := client.VerifyTxOutProof(proof); err != nil || len(txids) != 2 || txids[0] != coinbase {
+		t.Errorf("Verified txids %v (%v), want both in block order", txids, err)
+	}
+
+	other := txid(t, &blocks[0].Transactions[0]).String()
+	if _, err := client.GetTxOutProof([]string{spend, other}, ""); err == nil {
+		t.Error("Proof built for transactions in different blocks")
+	}
+
+	// A proof for a header that doesn't commit to the hashes is rejected
+	data, _ := hex.DecodeString(proof)
+	data[36] ^= 1 // First byte of the merkle root
+	if _, err := client.VerifyTxOutProof(hex.EncodeToString(data)); err == nil {
+		t.Error("Proof with a modified merkle root accepted")
+	}
+}
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	syncmanager "github.com/pouria-shahmiri/learn-bitcoin/pkg/network/sync"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+)
+
+// requestedTxs returns the transactions in the getdata messages a peer
+// was sent, and clears them
+func requestedTxs(t *testing.T, r *recordingSender) []types.Hash {
+	t.Helper()
+
+	var txids []types.Hash
+	for _, msg := range r.sent {
+		if msg.Command != protocol.CmdGetData {
+			continue
+		}
+		gd, err := protocol.DeserializeGetData(msg.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, vect := range gd.Inventory {
+			if vect.Type == protocol.InvTypeTx {
+				txids = append(txids, vect.Hash)
+			}
+		}
+	}
+	r.sent = nil
+	return txids
+}
+
+func announceTxs(t *testing.T, sm *syncmanager.SyncManager, peer *recordingSender, txids ...types.Hash) {
+	t.Helper()
+
+	inv := protocol.NewInvMessage()
+	for _, txid := range txids {
+		inv.AddInvVect(protocol.NewInvVect(protocol.InvTypeTx, txid))
+	}
+	if err := sm.HandleInv(inv, peer); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTxRequestManager(t *testing.T) (*syncmanager.SyncManager, *clock.Fake) {
+	t.Helper()
+
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Close() })
+
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	sm := syncmanager.NewSyncManager(chain)
+	sm.SetClock(fake)
+	return sm, fake
+}
+
+func TestTxRequestOnePeerAtATime(t *testing.T) {
+	sm, fake := newTxRequestManager(t)
+	a := &recordingSender{addr: "10.0.0.1:8333"}
+	b := &recordingSender{addr: "10.0.0.2:8333"}
+	c := &recordingSender{addr: "10.0.0.3:8333"}
+	txid := types.Hash{0x01}
+
+	announceTxs(t, sm, a, txid)
+	announceTxs(t, sm, b, txid)
+	announceTxs(t, sm, c, txid)
+	announceTxs(t, sm, a, txid) // Repeated announcement
+	if got := requestedTxs(t, a); len(got) != 1 || got[0] != txid {
+		t.Fatalf("Peer A was asked for %v", got)
+	}
+	if len(requestedTxs(t, b)) != 0 || len(requestedTxs(t, c)) != 0 {
+		t.Fatal("A transaction in flight was requested again")
+	}
+
+	// Nothing moves before the timeout
+	fake.Advance(syncmanager.TxRequestTimeout - time.Second)
+	sm.ExpireTxRequests()
+	if len(requestedTxs(t, b)) != 0 {
+		t.Fatal("Request moved before it timed out")
+	}
+
+	// Then the next announcer is asked
+	fake.Advance(time.Second)
+	sm.ExpireTxRequests()
+	if got := requestedTxs(t, b); len(got) != 1 || sm.TxRequests().RequestedFrom(txid) != b.addr {
+		t.Fatalf("Peer B was asked for %v after the timeout", got)
+	}
+	if sm.TxRequests().InFlight(a.addr) != 0 {
+		t.Error("Timed out request still counts against peer A")
+	}
+
+	// notfound moves it on to the last announcer
+	nf := protocol.NewNotFoundMessage()
+	nf.AddInvVect(protocol.NewInvVect(protocol.InvTypeTx, txid))
+	if err := sm.HandleNotFound(nf, b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := requestedTxs(t, c); len(got) != 1 {
+		t.Fatalf("Peer C was asked for %v after notfound", got)
+	}
+
+	// Disconnecting with nobody left to ask forgets the transaction
+	sm.PeerDisconnected(c)
+	if sm.TxRequests().Pending() != 0 {
+		t.Errorf("%d transactions still pending", sm.TxRequests().Pending())
+	}
+
+	// Delivery clears the request
+	announceTxs(t, sm, a, txid)
+	sm.TxReceived(txid)
+	if sm.TxRequests().Pending() != 0 || sm.TxRequests().InFlight(a.addr) != 0 {
+		t.Error("Received transaction is still tracked")
+	}
+}
+
+func TestTxRequestPeerLimit(t *testing.T) {
+	sm, _ := newTxRequestManager(t)
+	a := &recordingSender{addr: "10.0.0.1:8333"}
+	b := &recordingSender{addr: "10.0.0.2:8333"}
+
+	txids := make([]types.Hash, syncmanager.MaxPeerTxInFlight+1)
+	for i := range txids {
+		txids[i] = types.Hash{0x02, byte(i)}
+	}
+	announceTxs(t, sm, a, txids...)
+	if got := requestedTxs(t, a); len(got) != syncmanager.MaxPeerTxInFlight {
+		t.Fatalf("Peer A was asked for %d transactions", len(got))
+	}
+	last := txids[len(txids)-1]
+
+	// Another announcer with room takes the one left over
+	announceTxs(t, sm, b, last)
+	if got := requestedTxs(t, b); len(got) != 1 || got[0] != last {
+		t.Fatalf("Peer B was asked for %v", got)
+	}
+
+	// A disconnecting peer's requests go to other announcers
+	announceTxs(t, sm, b, txids[0])
+	sm.PeerDisconnected(a)
+	if got := requestedTxs(t, b); len(got) != 1 || got[0] != txids[0] {
+		t.Errorf("Peer B was asked for %v after A left", got)
+	}
+	if sm.TxRequests().Pending() != 2 {
+		t.Errorf("%d transactions pending, want 2", sm.TxRequests().Pending())
+	}
+}
@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	syncmanager "github.com/pouria-shahmiri/learn-bitcoin/pkg/network/sync"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+func testCoin(index uint32) *utxo.UTXO {
+	output := types.TxOutput{Value: 1000 + int64(index), PubKeyScript: make([]byte, 25)}
+	return utxo.NewUTXO(types.Hash{7}, index, output, 1, false)
+}
+
+func storedCoins(t *testing.T, store *utxo.UTXOStorage) int {
+	t.Helper()
+	count, err := store.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// Only changed coins are written, in batches, and spending a coin the
+// cache never loaded deletes it from storage
+func TestUTXOCacheFlush(t *testing.T) {
+	store, err := utxo.NewUTXOStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	cache := utxo.NewUTXOCacheWithConfig(store, utxo.CacheConfig{BatchSize: 2})
+	for i := uint32(0); i < 5; i++ {
+		if err := cache.Add(testCoin(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if storedCoins(t, store) != 0 || cache.Dirty() != 5 {
+		t.Fatalf("Written before flushing: %d stored, %d dirty", storedCoins(t, store), cache.Dirty())
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if storedCoins(t, store) != 5 || cache.Dirty() != 0 {
+		t.Fatalf("After flush: %d stored, %d dirty, want 5 and 0", storedCoins(t, store), cache.Dirty())
+	}
+
+	// A fresh cache spends one coin it loaded and one it didn't
+	cache = utxo.NewUTXOCacheWithConfig(store, utxo.CacheConfig{BatchSize: 2})
+	if _, err := cache.Get(testCoin(0).OutPoint()); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []uint32{0, 1} {
+		if err := cache.Remove(testCoin(i).OutPoint()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cache.Exists(testCoin(1).OutPoint()) {
+		t.Error("Spent coin still found in storage before the flush")
+	}
+	if _, err := cache.Get(testCoin(1).OutPoint()); err == nil {
+		t.Error("Spent coin loaded back from storage")
+	}
+	if err := cache.Remove(testCoin(1).OutPoint()); err == nil {
+		t.Error("Spent a coin twice")
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if storedCoins(t, store) != 3 {
+		t.Errorf("%d coins stored, want 3", storedCoins(t, store))
+	}
+	if coin, err := store.Load(testCoin(4).OutPoint()); err != nil || coin.Value() != 1004 {
+		t.Errorf("Load = %v, %v", coin, err)
+	}
+}
+
+// The background flusher writes changes on its interval, and Close
+// flushes what is left
+func TestUTXOCacheBackgroundFlush(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "utxo")
+	store, err := utxo.NewUTXOStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := utxo.NewUTXOCacheWithConfig(store, utxo.CacheConfig{FlushInterval: 10 * time.Millisecond, BatchSize: 100})
+	cache.Start()
+	cache.Add(testCoin(0))
+	deadline := time.Now().Add(5 * time.Second)
+	for cache.Dirty() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if storedCoins(t, store) != 1 {
+		t.Fatal("Background flush didn't write the coin")
+	}
+
+	// Nothing flushes on its own without an interval
+	cache.Close()
+	cache = utxo.NewUTXOCacheWithConfig(store, utxo.CacheConfig{})
+	cache.Start()
+	cache.Add(testCoin(1))
+	time.Sleep(20 * time.Millisecond)
+	if storedCoins(t, store) != 1 {
+		t.Error("Flushed without an interval")
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = utxo.NewUTXOStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if storedCoins(t, store) != 2 {
+		t.Errorf("%d coins after shutdown, want 2", storedCoins(t, store))
+	}
+}
+
+// The sync manager's chainstate is written back on a clean shutdown with
+// the block it is as of, so the next start carries on without a replay
+func TestChainstateFlushedOnShutdown(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	dir := filepath.Join(t.TempDir(), utxo.ChainstateDirName)
+	store, err := utxo.NewUTXOStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := utxo.NewUTXOCacheWithConfig(store, utxo.CacheConfig{FlushInterval: time.Hour})
+	cache.Start()
+	sm := syncmanager.NewSyncManager(chain)
+	sm.SetChainstate(cache)
+	peer := &recordingSender{addr: "10.0.0.1:8333"}
+
+	// Three blocks, then a heavier branch forking off the first
+	main := buildBranch(t, types.Hash{}, 0, 3, 0)
+	side := buildBranch(t, blockHash(t, main[0]), 1, 3, 1)
+	for _, block := range append(main, side...) {
+		if _, _, err := sm.HandleBlock(block, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tip := blockHash(t, side[2])
+	if cache.Dirty() == 0 || cache.BestBlock() != tip {
+		t.Fatalf("Chainstate has %d dirty entries as of %s, want some as of %s", cache.Dirty(), cache.BestBlock(), tip)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Dirty() != 0 {
+		t.Errorf("%d dirty entries left after a clean shutdown", cache.Dirty())
+	}
+	store.Close()
+
+	store, err = utxo.NewUTXOStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if best, err := store.BestBlock(); err != nil || best != tip {
+		t.Errorf("Stored best block = %s, %v; want %s", best, err, tip)
+	}
+	for _, block := range []*types.Block{main[0], side[0], side[1], side[2]} {
+		if exists, _ := store.Exists(utxo.NewOutPoint(txid(t, &block.Transactions[0]), 0)); !exists {
+			t.Errorf("Coinbase of block %s not stored", blockHash(t, block))
+		}
+	}
+	if exists, _ := store.Exists(utxo.NewOutPoint(txid(t, &main[2].Transactions[0]), 0)); exists {
+		t.Error("Coinbase of a disconnected block stored")
+	}
+
+	// The next start picks up at the tip instead of replaying the chain
+	cache = utxo.NewUTXOCacheWithConfig(store, utxo.CacheConfig{})
+	sm = syncmanager.NewSyncManager(chain)
+	sm.SetChainstate(cache)
+	if _, err := sm.Chainstate(); err != nil {
+		t.Fatal(err)
+	}
+	if cache.BestBlock() != tip || cache.Size() != 0 || cache.Dirty() != 0 {
+		t.Errorf("Restarted chainstate replayed blocks: %d coins loaded, %d dirty", cache.Size(), cache.Dirty())
+	}
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+)
+
+func TestCompressAmount(t *testing.T) {
+	const coin = 100000000
+
+	// Values from Bitcoin Core's compress_tests
+	for amount, want := range map[uint64]uint64{
+		0:               0x0,
+		1:               0x1,
+		coin / 100:      0x7,
+		coin:            0x9,
+		50 * coin:       0x32,
+		21000000 * coin: 0x1406f40,
+	} {
+		if got := utxo.CompressAmount(amount); got != want {
+			t.Errorf("CompressAmount(%d) = %#x, want %#x", amount, got, want)
+		}
+	}
+
+	for _, amount := range []uint64{0, 1, 9, 10, 123456789, 1000000000, 2100000000000000} {
+		if got := utxo.DecompressAmount(utxo.CompressAmount(amount)); got != amount {
+			t.Errorf("Amount %d round-tripped to %d", amount, got)
+		}
+	}
+}
+
+func compactTestScripts() map[string][]byte {
+	hash20 := bytes.Repeat([]byte{0xab}, 20)
+	x := bytes.Repeat([]byte{0xcd}, 32)
+	return map[string][]byte{
+		"p2pkh":        append(append([]byte{0x76, 0xa9, 20}, hash20...), 0x88, 0xac),
+		"p2sh":         append(append([]byte{0xa9, 20}, hash20...), 0x87),
+		"p2pk":         append(append([]byte{33, 0x03}, x...), 0xac),
+		"uncompressed": append(append([]byte{65, 0x04}, bytes.Repeat([]byte{0xef}, 64)...), 0xac),
+		"op_return":    {0x6a, 3, 'a', 'b', 'c'},
+		"empty":        {},
+	}
+}
+
+func TestCompactUTXORoundTrip(t *testing.T) {
+	for name, script := range compactTestScripts() {
+		for _, coinbase := range []bool{false, true} {
+			coin := utxo.NewUTXO(types.Hash{7}, 3, types.TxOutput{Value: 4999990000, PubKeyScript: script}, 840000, coinbase)
+
+			decoded, err := utxo.DeserializeCompactUTXO(coin.OutPoint(), coin.SerializeCompact())
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if decoded.TxHash != coin.TxHash || decoded.OutputIndex != coin.OutputIndex ||
+				decoded.Output.Value != coin.Output.Value || !bytes.Equal(decoded.Output.PubKeyScript, script) ||
+				decoded.Height != coin.Height || decoded.IsCoinbase != coinbase {
+				t.Errorf("%s: decoded %+v, want %+v", name, decoded, coin)
+			}
+		}
+	}
+
+	// A standard coin takes less than half the verbose encoding
+	p2pkh := utxo.NewUTXO(types.Hash{7}, 0, types.TxOutput{Value: 5000000000, PubKeyScript: compactTestScripts()["p2pkh"]}, 100, true)
+	if compact, verbose := len(p2pkh.SerializeCompact()), len(p2pkh.Serialize()); compact*2 > verbose {
+		t.Errorf("Compact size %d is not half of verbose %d", compact, verbose)
+	}
+
+	for _, data := range [][]byte{
+		nil,
+		{0x80},                   // Truncated varint
+		{0x02, 0x09, 0x00, 0x01}, // Truncated key hash
+		{0x02, 0x09, 0x04},       // Reserved script form
+		{0x02, 0x09, 0x07},       // Raw script shorter than its length
+	} {
+		if _, err := utxo.DeserializeCompactUTXO(utxo.OutPoint{}, data); err == nil {
+			t.Errorf("Malformed coin %x decoded", data)
+		}
+	}
+}
+
+func TestUTXOStorageCompactFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chainstate")
+	store, err := utxo.NewUTXOStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := utxo.NewUTXOSet()
+	i := uint32(0)
+	for _, script := range compactTestScripts() {
+		set.Add(utxo.NewUTXO(types.Hash{1}, i, types.TxOutput{Value: int64(i+1) * 1000, PubKeyScript: script}, uint64(i), i == 0))
+		i++
+	}
+	if err := store.SaveSet(set); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// A coin in the verbose format of older databases is still readable
+	legacy := utxo.NewUTXO(types.Hash{2}, 0, types.TxOutput{Value: 777, PubKeyScript: compactTestScripts()["p2pkh"]}, 9, false)
+	set.Add(legacy)
+	db, err := storage.OpenDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(append([]byte{'u'}, legacy.OutPoint().Bytes()...), legacy.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	store, err = utxo.NewUTXOStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	loaded, err := store.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Size() != set.Size() || loaded.TotalValue() != set.TotalValue() {
+		t.Errorf("Loaded %d coins worth %d, saved %d worth %d", loaded.Size(), loaded.TotalValue(), set.Size(), set.TotalValue())
+	}
+	for _, coin := range set.GetAll() {
+		got, err := store.Load(coin.OutPoint())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Output.PubKeyScript, coin.Output.PubKeyScript) || got.Height != coin.Height || got.IsCoinbase != coin.IsCoinbase {
+			t.Errorf("Loaded %+v, want %+v", got, coin)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// The existence filter never hides a stored coin, grows as coins are
+// added, forgets spent ones and answers for unknown outpoints from memory
+func TestUTXOStorageFilter(t *testing.T) {
+	store, err := utxo.NewUTXOStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Save(testCoin(1000)); err != nil {
+		t.Fatal(err)
+	}
+	if store.FilterStats() != nil {
+		t.Error("Stats reported without a filter")
+	}
+	if err := store.EnableFilter(8); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []utxo.UTXOChange
+	for i := uint32(0); i < 500; i++ {
+		changes = append(changes, utxo.UTXOChange{Outpoint: testCoin(i).OutPoint(), Add: testCoin(i)})
+	}
+	if _, err := store.ApplyChangesBatched(changes, 100); err != nil {
+		t.Fatal(err)
+	}
+	stats := store.FilterStats()
+	if stats.Entries != 501 || stats.Rebuilds == 0 || stats.Capacity < 501 {
+		t.Errorf("Stats after growing: %+v", stats)
+	}
+
+	// Spend every other coin, plus one never stored
+	changes = changes[:0]
+	for i := uint32(0); i < 500; i += 2 {
+		changes = append(changes, utxo.UTXOChange{Outpoint: testCoin(i).OutPoint(), Remove: true})
+	}
+	changes = append(changes, utxo.UTXOChange{Outpoint: testCoin(9999).OutPoint(), Remove: true})
+	if err := store.ApplyChanges(changes); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(testCoin(1000).OutPoint()); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(0); i < 500; i++ {
+		exists, err := store.Exists(testCoin(i).OutPoint())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != (i%2 == 1) {
+			t.Fatalf("Coin %d exists = %t", i, exists)
+		}
+	}
+	if stats := store.FilterStats(); stats.Entries != 250 {
+		t.Errorf("%d entries after spending, want 250", stats.Entries)
+	}
+
+	before := *store.FilterStats()
+	for i := 0; i < 5000; i++ {
+		outpoint := utxo.NewOutPoint(types.Hash{byte(i), byte(i >> 8), 0xee}, uint32(i))
+		if _, err := store.Load(outpoint); err == nil {
+			t.Fatal("Loaded an unknown outpoint")
+		}
+	}
+	stats = store.FilterStats()
+	skipped := stats.Skipped - before.Skipped
+	falsePositives := stats.FalsePositives - before.FalsePositives
+	if skipped+falsePositives != 5000 || skipped < 4900 {
+		t.Errorf("Unknown lookups: %d skipped, %d false positives", skipped, falsePositives)
+	}
+	if rate := stats.FalsePositiveRate(); rate > 0.02 {
+		t.Errorf("False positive rate %f", rate)
+	}
+}
+
+// A node's chainstate storage gets a filter, so checking a transaction
+// spending a coin that never existed skips the disk, and getmemoryinfo
+// reports it
+func TestNodeInputChecksUseUTXOFilter(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+
+	store, err := utxo.NewUTXOStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	cache := utxo.NewUTXOCacheWithConfig(store, utxo.CacheConfig{})
+	if err := node.P2P.SetChainstate(cache); err != nil {
+		t.Fatal(err)
+	}
+	if store.FilterStats() == nil {
+		t.Fatal("Chainstate storage has no filter")
+	}
+	if _, err := node.MineBlocks(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.P2P.SyncManager.Chainstate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	anyone := []byte{script.OP_TRUE}
+	junk := contracts.SpendTx(types.Hash{0xde, 0xad}, 0, types.TxOutput{Value: 1000, PubKeyScript: anyone}, 0)
+	if err := node.P2P.BroadcastTransaction(junk); !errors.Is(err, mempool.ErrMissingInputs) {
+		t.Fatalf("Broadcasting a spend of a coin that never existed = %v", err)
+	}
+	stats := store.FilterStats()
+	if stats.Entries == 0 || stats.Skipped == 0 {
+		t.Errorf("Filter stats = %+v, want stored coins and a skipped lookup", stats)
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), node.Chain, "")
+	server.SetUTXOCache(cache)
+	info, err := rpc.NewClient(httptestServer(t, server)).GetMemoryInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.UTXOFilter == nil || info.UTXOFilter.Skipped != stats.Skipped || info.UTXOFilter.Entries != stats.Entries {
+		t.Errorf("getmemoryinfo filter = %+v, want %+v", info.UTXOFilter, stats)
+	}
+}
@@ -0,0 +1,217 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/contracts"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+func TestUTXOViewCopyOnWrite(t *testing.T) {
+	base := utxo.NewUTXOSet()
+	output := types.TxOutput{Value: 5000, PubKeyScript: []byte{0x51}}
+	kept := utxo.NewUTXO(types.Hash{1}, 0, output, 1, false)
+	spent := utxo.NewUTXO(types.Hash{2}, 0, output, 1, false)
+	base.Add(kept)
+	base.Add(spent)
+
+	view := utxo.NewUTXOView(base)
+	tx := &types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: types.Hash{2}, OutputIndex: 0}},
+		Outputs: []types.TxOutput{output},
+	}
+	if err := view.ApplyTransaction(tx, types.Hash{3}, 2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if view.Exists(spent.OutPoint()) || !view.Exists(utxo.NewOutPoint(types.Hash{3}, 0)) || !view.Exists(kept.OutPoint()) {
+		t.Error("View doesn't reflect the transaction")
+	}
+	if !base.Exists(spent.OutPoint()) || base.Exists(utxo.NewOutPoint(types.Hash{3}, 0)) {
+		t.Error("Base changed before commit")
+	}
+	if err := view.ApplyTransaction(tx, types.Hash{3}, 2, false); err == nil {
+		t.Error("Double spend inside the view accepted")
+	}
+	if all := view.GetAll(); len(all) != 2 {
+		t.Errorf("View lists %d entries, want 2", len(all))
+	}
+
+	// Spending and restoring a base entry leaves it in place
+	if err := view.Remove(kept.OutPoint()); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.Add(kept); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := view.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if base.Exists(spent.OutPoint()) || !base.Exists(kept.OutPoint()) || !base.Exists(utxo.NewOutPoint(types.Hash{3}, 0)) {
+		t.Errorf("Commit produced the wrong set: %d entries", base.Size())
+	}
+	if added, removed := view.Changes(); added != 0 || removed != 0 {
+		t.Errorf("View not empty after commit: +%d -%d", added, removed)
+	}
+}
+
+func TestUTXOViewLayering(t *testing.T) {
+	base := utxo.NewUTXOSet()
+	output := types.TxOutput{Value: 5000, PubKeyScript: []byte{0x51}}
+	funding := utxo.NewUTXO(types.Hash{1}, 0, output, 1, false)
+	base.Add(funding)
+
+	// A pool view holds the parent, a candidate view on top the child
+	pool := utxo.NewUTXOView(base)
+	parent := &types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: types.Hash{1}, OutputIndex: 0}},
+		Outputs: []types.TxOutput{{Value: 4000, PubKeyScript: []byte{0x51}}},
+	}
+	parentFee, err := validation.NewBlockValidator(pool).AcceptTransaction(parent, 2)
+	if err != nil {
+		t.Fatalf("Parent rejected: %v", err)
+	}
+	if parentFee != 1000 {
+		t.Errorf("Expected parent fee 1000, got %d", parentFee)
+	}
+	parentHash, _ := serialization.HashTransaction(parent)
+
+	candidate := utxo.NewUTXOView(pool)
+	child := &types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: parentHash, OutputIndex: 0}},
+		Outputs: []types.TxOutput{{Value: 3500, PubKeyScript: []byte{0x51}}},
+	}
+	if _, err := validation.NewBlockValidator(candidate).AcceptTransaction(child, 2); err != nil {
+		t.Fatalf("Child of an unconfirmed parent rejected: %v", err)
+	}
+	if _, err := validation.NewBlockValidator(candidate).AcceptTransaction(parent, 2); err == nil {
+		t.Error("Double spend of the funding output accepted")
+	}
+
+	// Throwing the candidate away leaves the pool as it was
+	candidate.Discard()
+	if !pool.Exists(utxo.NewOutPoint(parentHash, 0)) {
+		t.Error("Discarding the candidate touched the pool view")
+	}
+
+	if _, err := validation.NewBlockValidator(candidate).AcceptTransaction(child, 2); err != nil {
+		t.Fatal(err)
+	}
+	childHash, _ := serialization.HashTransaction(child)
+	if err := candidate.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Exists(utxo.NewOutPoint(parentHash, 0)) || !pool.Exists(utxo.NewOutPoint(childHash, 0)) {
+		t.Error("Candidate not committed into the pool view")
+	}
+	if !base.Exists(funding.OutPoint()) || base.Size() != 1 {
+		t.Error("Base changed before the pool view was committed")
+	}
+
+	if err := pool.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if base.Exists(funding.OutPoint()) || !base.Exists(utxo.NewOutPoint(childHash, 0)) || base.Size() != 1 {
+		t.Errorf("Pool commit produced the wrong set: %d entries", base.Size())
+	}
+}
+
+// refusingView is a View that won't take new entries
+type refusingView struct {
+	*utxo.UTXOSet
+}
+
+func (refusingView) Add(*utxo.UTXO) error {
+	return errors.New("read-only")
+}
+
+func TestUTXOViewCommitReturnsBaseErrors(t *testing.T) {
+	base := refusingView{utxo.NewUTXOSet()}
+	view := utxo.NewUTXOView(base)
+	output := types.TxOutput{Value: 5000, PubKeyScript: []byte{0x51}}
+	if err := view.Add(utxo.NewUTXO(types.Hash{1}, 0, output, 1, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.Commit(); err == nil {
+		t.Fatal("Commit into a base refusing the change succeeded")
+	}
+	if added, _ := view.Changes(); added != 1 {
+		t.Error("Failed commit dropped the view's changes")
+	}
+
+	// Removing an entry the base no longer holds fails too
+	set := utxo.NewUTXOSet()
+	set.Add(utxo.NewUTXO(types.Hash{2}, 0, output, 1, false))
+	lower := utxo.NewUTXOView(set)
+	upper := utxo.NewUTXOView(lower)
+	if err := upper.Remove(utxo.NewOutPoint(types.Hash{2}, 0)); err != nil {
+		t.Fatal(err)
+	}
+	set.Remove(utxo.NewOutPoint(types.Hash{2}, 0))
+	if err := upper.Commit(); err == nil {
+		t.Error("Commit removing a missing entry succeeded")
+	}
+}
+
+// The node checks loose transactions against its UTXO set through a
+// view: a spend of an unconfirmed parent is accepted, a spend of an
+// output a block already spent is not
+func TestNodeChecksTransactionsAgainstUTXOSet(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	anyone := []byte{script.OP_TRUE}
+	funding, err := node.SendToScript(anyone, 100000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	index := -1
+	for i, out := range funding.Outputs {
+		if len(out.PubKeyScript) == 1 && out.PubKeyScript[0] == script.OP_TRUE {
+			index = i
+		}
+	}
+
+	child := contracts.SpendTx(txid(t, funding), uint32(index), types.TxOutput{Value: 90000, PubKeyScript: anyone}, 0)
+	grandchild := contracts.SpendTx(txid(t, child), 0, types.TxOutput{Value: 80000, PubKeyScript: anyone}, 0)
+	if err := node.P2P.BroadcastTransaction(child); err != nil {
+		t.Fatalf("Broadcasting the child: %v", err)
+	}
+	if err := node.P2P.BroadcastTransaction(grandchild); err != nil {
+		t.Fatalf("Broadcasting a spend of an unconfirmed parent: %v", err)
+	}
+	if _, err := node.MineBlocks(1); err != nil {
+		t.Fatal(err)
+	}
+	if node.P2P.Mempool.Size() != 0 {
+		t.Fatalf("%d transactions left unmined", node.P2P.Mempool.Size())
+	}
+
+	// The funding output is gone from the UTXO set, though the chain
+	// still has the transaction that created it
+	doubleSpend := contracts.SpendTx(txid(t, funding), uint32(index), types.TxOutput{Value: 85000, PubKeyScript: anyone}, 0)
+	if err := node.P2P.BroadcastTransaction(doubleSpend); !errors.Is(err, mempool.ErrMissingInputs) {
+		t.Errorf("Broadcasting a spend of a spent output = %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/peer"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/protocol"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/network/v2transport"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+)
+
+func TestV2TransportRoundTrip(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	type result struct {
+		conn net.Conn
+		v2   bool
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, v2, err := v2transport.Accept(server, protocol.MagicMainnet)
+		accepted <- result{conn, v2, err}
+	}()
+
+	initiator, err := v2transport.Initiate(client, protocol.MagicMainnet)
+	if err != nil {
+		t.Fatalf("Initiate failed: %v", err)
+	}
+	res := <-accepted
+	if res.err != nil || !res.v2 {
+		t.Fatalf("Accept = v2 %v, err %v", res.v2, res.err)
+	}
+	responder := res.conn.(*v2transport.Conn)
+
+	if initiator.SessionID() != responder.SessionID() {
+		t.Error("Session IDs differ")
+	}
+
+	// Larger than one packet in the 3-byte length field
+	big := bytes.Repeat([]byte{0xab}, v2transport.MaxPacketSize+10)
+	go func() {
+		initiator.Write([]byte("hello"))
+		initiator.Write(big)
+	}()
+
+	got := make([]byte, 5+len(big))
+	if _, err := io.ReadFull(responder, got); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(got[:5]) != "hello" || !bytes.Equal(got[5:], big) {
+		t.Error("Decrypted data doesn't match")
+	}
+}
+
+func TestV2TransportDetectsPlaintext(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header, protocol.MagicMainnet)
+	copy(header[4:], "vers")
+	go client.Write(header)
+
+	conn, v2, err := v2transport.Accept(server, protocol.MagicMainnet)
+	if err != nil || v2 {
+		t.Fatalf("Accept = v2 %v, err %v", v2, err)
+	}
+
+	// The consumed magic is handed back to the v1 reader
+	got := make([]byte, len(header))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, header) {
+		t.Errorf("Replayed %x, want %x", got, header)
+	}
+}
+
+func TestV2TransportRejectsTampering(t *testing.T) {
+	client, relayIn := net.Pipe()
+	relayOut, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// Relay that flips a bit in everything the initiator sends after its
+	// key and the empty confirmation packet
+	handshakeBytes := v2transport.PubKeySize + 3 + 16
+	go func() {
+		defer relayOut.Close()
+		buf := make([]byte, 4096)
+		forwarded := 0
+		for {
+			n, err := relayIn.Read(buf)
+			if err != nil {
+				return
+			}
+			if forwarded+n > handshakeBytes {
+				buf[n-1] ^= 1
+			}
+			forwarded += n
+			if _, err := relayOut.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	go io.Copy(relayIn, relayOut)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _, err := v2transport.Accept(server, protocol.MagicMainnet)
+		if err != nil {
+			t.Errorf("Accept failed: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	initiator, err := v2transport.Initiate(client, protocol.MagicMainnet)
+	if err != nil {
+		t.Fatalf("Initiate failed: %v", err)
+	}
+	responder := <-accepted
+	if responder == nil {
+		return
+	}
+
+	go initiator.Write([]byte("tampered"))
+	if _, err := responder.Read(make([]byte, 16)); err == nil {
+		t.Error("Tampered packet was accepted")
+	}
+}
+
+// startV2Node starts a node on a fresh store
+func startV2Node(t *testing.T, v2 bool) *network.Node {
+	t.Helper()
+
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := network.NewNode(network.NodeConfig{
+		ListenAddr:        "127.0.0.1:0",
+		UserAgent:         "v2test",
+		EnableV2Transport: v2,
+	}, chain)
+	if err := node.Start(); err != nil {
+		chain.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		node.Stop()
+		chain.Close()
+	})
+	return node
+}
+
+// handshakenPeer waits for node to have one peer that finished the version
+// handshake and returns its stats
+func handshakenPeer(t *testing.T, node *network.Node) peer.Stats {
+	t.Helper()
+
+	var stats peer.Stats
+	waitUntil(t, "handshake", func() bool {
+		peers := node.PeerInfo()
+		if len(peers) != 1 || peers[0].Version == 0 {
+			return false
+		}
+		stats = peers[0]
+		return true
+	})
+	return stats
+}
+
+func TestNodesNegotiateV2Transport(t *testing.T) {
+	a, b := startV2Node(t, true), startV2Node(t, true)
+
+	go a.Connect(b.Addr())
+
+	outbound, inbound := handshakenPeer(t, a), handshakenPeer(t, b)
+	if outbound.Transport != "v2" || inbound.Transport != "v2" {
+		t.Fatalf("Transports = %s/%s, want v2/v2", outbound.Transport, inbound.Transport)
+	}
+	if outbound.SessionID == "" || outbound.SessionID != inbound.SessionID {
+		t.Errorf("Session IDs %q and %q should match", outbound.SessionID, inbound.SessionID)
+	}
+	if inbound.Services&protocol.SFNodeP2PV2 == 0 {
+		t.Error("Initiator should advertise the v2 service bit")
+	}
+}
+
+func TestV2TransportFallsBackToPlaintext(t *testing.T) {
+	// v2 node dialing an old node: the handshake fails and it redials in v1
+	modern, old := startV2Node(t, true), startV2Node(t, false)
+	go modern.Connect(old.Addr())
+
+	outbound := handshakenPeer(t, modern)
+	if outbound.Transport != "v1" {
+		t.Errorf("Outbound transport = %s, want v1 fallback", outbound.Transport)
+	}
+	if outbound.Services&protocol.SFNodeP2PV2 != 0 {
+		t.Error("Old node should not advertise v2")
+	}
+
+	// Old node dialing a v2 node is detected as plaintext
+	modern2 := startV2Node(t, true)
+	old2 := startV2Node(t, false)
+	go old2.Connect(modern2.Addr())
+
+	if inbound := handshakenPeer(t, modern2); inbound.Transport != "v1" {
+		t.Errorf("Inbound transport = %s, want v1", inbound.Transport)
+	}
+}
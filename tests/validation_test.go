@@ -313,9 +313,9 @@ func TestCalculateTransactionSize(t *testing.T) {
 }
 
 func TestEstimateFee(t *testing.T) {
-	feePerByte := int64(10) // 10 satoshis per byte
+	feeRate := int64(10000) // 10 sat/vB
 
-	fee := transaction.EstimateFee(1, 2, feePerByte)
+	fee := transaction.EstimateFee(1, 2, feeRate)
 
 	// For 1 input, 2 outputs, size ~226 bytes
 	// Fee should be around 2260 satoshis
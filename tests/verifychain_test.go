@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+// A sound chain passes every check level; a block whose stored body no
+// longer matches its header fails from level 1, at its height, and only
+// when the window reaches it
+func TestVerifyChain(t *testing.T) {
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	defer h.Close()
+	node := h.Node(0)
+	if _, err := node.MineBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.SendTo(node.Address, 100000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.MineBlocks(2); err != nil {
+		t.Fatal(err)
+	}
+	tip, _ := node.Height()
+
+	ctx := context.Background()
+	for level := validation.CheckLevelHeaders; level <= validation.MaxCheckLevel; level++ {
+		result, err := validation.VerifyChain(ctx, node.Chain, h.Rules, level, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Valid() || result.From != 0 || result.To != tip {
+			t.Errorf("Level %d: %+v, want blocks 0 to %d valid", level, result, tip)
+		}
+	}
+	if _, err := validation.VerifyChain(ctx, node.Chain, h.Rules, 5, 0); err == nil {
+		t.Error("Accepted check level 5")
+	}
+
+	// Copy the chain, then overwrite the block holding the payment with a
+	// body that doesn't match its header, as a bad disk might
+	copied, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	for height := uint64(0); height <= tip; height++ {
+		block, err := node.Chain.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if height == tip-1 {
+			block.Transactions[1].Outputs[0].Value++
+		}
+		if err := copied.SaveBlock(block, height); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		level     int
+		numBlocks uint64
+		valid     bool
+	}{
+		{validation.CheckLevelHeaders, 0, true},
+		{validation.CheckLevelSanity, 1, true},
+		{validation.CheckLevelSanity, 2, false},
+		{validation.CheckLevelConnect, 0, false},
+	}
+	for _, tt := range tests {
+		result, err := validation.VerifyChain(ctx, copied, h.Rules, tt.level, tt.numBlocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Valid() != tt.valid {
+			t.Errorf("Level %d over %d blocks: valid %t, want %t (%v)", tt.level, tt.numBlocks, result.Valid(), tt.valid, result.Err)
+		}
+		if !tt.valid && (result.Height != tip-1 || !errors.Is(result.Err, validation.ErrCorruptChain)) {
+			t.Errorf("Level %d: failed at %d with %v, want height %d", tt.level, result.Height, result.Err, tip-1)
+		}
+	}
+
+	server := rpc.NewServer(node.Wallet, node.Chain, "")
+	server.SetConsensusRules(h.Rules)
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	resp, err := client.VerifyChain(validation.MaxCheckLevel, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Valid || resp.From != tip-2 || resp.To != tip || resp.FailedHeight != nil {
+		t.Errorf("verifychain = %+v", resp)
+	}
+	if _, err := client.VerifyChain(9, -1); err == nil {
+		t.Error("verifychain accepted check level 9")
+	}
+}
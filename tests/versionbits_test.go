@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// headerChain is an in-memory chain of headers, block h timestamped 100*h
+type headerChain []types.BlockHeader
+
+func (c headerChain) HeaderAt(height uint64) (*types.BlockHeader, error) {
+	return &c[height], nil
+}
+
+func newHeaderChain(count int) headerChain {
+	chain := make(headerChain, count)
+	for h := range chain {
+		chain[h] = types.BlockHeader{Version: 1, Timestamp: uint32(100 * h)}
+	}
+	return chain
+}
+
+// signal sets the version of blocks from..to to signal bit
+func (c headerChain) signal(from, to int, bit uint8) {
+	for h := from; h <= to; h++ {
+		c[h].Version = consensus.VersionBitsTopBits | 1<<bit
+	}
+}
+
+// testVersionBitsRules uses 10 block windows with a threshold of 8. With
+// 100 second blocks the median time past of block h is 100*(h-5), so the
+// deployment starts in the window beginning at 20.
+func testVersionBitsRules(timeout int64, minHeight uint64) *consensus.ConsensusRules {
+	rules := consensus.NewRegtestRules()
+	rules.MinerConfirmationWindow = 10
+	rules.RuleChangeActivationThreshold = 8
+	rules.Deployments = []consensus.Deployment{
+		{Name: "dummy", Bit: 1, StartTime: 1000, Timeout: timeout, MinActivationHeight: minHeight},
+	}
+	return rules
+}
+
+func expectState(t *testing.T, vb *consensus.VersionBits, chain consensus.HeaderSource, height uint64, want consensus.ThresholdState) {
+	t.Helper()
+
+	state, err := vb.State(chain, "dummy", height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != want {
+		t.Errorf("State at %d = %v, want %v", height, state, want)
+	}
+}
+
+func TestVersionBitsActivation(t *testing.T) {
+	chain := newHeaderChain(60)
+	chain.signal(20, 27, 1)
+	vb := consensus.NewVersionBits(testVersionBitsRules(consensus.NoTimeout, 0))
+
+	expectState(t, vb, chain, 5, consensus.ThresholdDefined)
+	expectState(t, vb, chain, 19, consensus.ThresholdDefined)
+	expectState(t, vb, chain, 20, consensus.ThresholdStarted)
+	expectState(t, vb, chain, 30, consensus.ThresholdLockedIn)
+	expectState(t, vb, chain, 39, consensus.ThresholdLockedIn)
+	expectState(t, vb, chain, 40, consensus.ThresholdActive)
+	expectState(t, vb, chain, 59, consensus.ThresholdActive)
+
+	if since, _ := vb.StateSince(chain, "dummy", 55); since != 40 {
+		t.Errorf("Active since %d, want 40", since)
+	}
+	if since, _ := vb.StateSince(chain, "dummy", 25); since != 20 {
+		t.Errorf("Started since %d, want 20", since)
+	}
+
+	stats, err := vb.Statistics(chain, "dummy", 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Elapsed != 5 || stats.Count != 5 || !stats.Possible {
+		t.Errorf("Statistics at 24 = %+v", stats)
+	}
+
+	// Miners signal while the deployment is started or locked in
+	for height, want := range map[uint64]int32{25: consensus.VersionBitsTopBits | 2, 35: consensus.VersionBitsTopBits | 2, 45: consensus.VersionBitsTopBits} {
+		if version, _ := vb.ComputeBlockVersion(chain, height); version != want {
+			t.Errorf("Block version at %d = %#x, want %#x", height, version, want)
+		}
+	}
+}
+
+func TestVersionBitsThresholdNotReached(t *testing.T) {
+	chain := newHeaderChain(50)
+	chain.signal(20, 26, 1) // One short of the threshold
+	vb := consensus.NewVersionBits(testVersionBitsRules(3000, 0))
+
+	// Signals must carry the versionbits top bits to count
+	chain[27].Version = 1 << 1
+
+	stats, _ := vb.Statistics(chain, "dummy", 26)
+	if stats.Count != 7 || !stats.Possible {
+		t.Errorf("Statistics at 26 = %+v, threshold should still be reachable", stats)
+	}
+	stats, _ = vb.Statistics(chain, "dummy", 29)
+	if stats.Count != 7 || stats.Possible {
+		t.Errorf("Statistics at 29 = %+v, threshold should be out of reach", stats)
+	}
+	expectState(t, vb, chain, 30, consensus.ThresholdStarted)
+
+	// The median time past passes the timeout at the end of the next window
+	expectState(t, vb, chain, 40, consensus.ThresholdFailed)
+	expectState(t, vb, chain, 49, consensus.ThresholdFailed)
+}
+
+func TestVersionBitsMinActivationHeight(t *testing.T) {
+	chain := newHeaderChain(70)
+	chain.signal(20, 29, 1)
+	vb := consensus.NewVersionBits(testVersionBitsRules(consensus.NoTimeout, 60))
+
+	expectState(t, vb, chain, 30, consensus.ThresholdLockedIn)
+	expectState(t, vb, chain, 59, consensus.ThresholdLockedIn)
+	expectState(t, vb, chain, 60, consensus.ThresholdActive)
+}
+
+func TestVersionBitsReorgRecomputes(t *testing.T) {
+	chain := newHeaderChain(50)
+	chain.signal(20, 29, 1)
+	vb := consensus.NewVersionBits(testVersionBitsRules(consensus.NoTimeout, 0))
+	expectState(t, vb, chain, 45, consensus.ThresholdActive)
+
+	// A competing chain without the signals replaces the window
+	reorged := newHeaderChain(50)
+	for h := 20; h < 50; h++ {
+		reorged[h].Nonce = 1
+	}
+	expectState(t, vb, reorged, 45, consensus.ThresholdStarted)
+	expectState(t, vb, chain, 45, consensus.ThresholdActive)
+}
+
+func TestVersionBitsFixedStates(t *testing.T) {
+	rules := consensus.NewRegtestRules()
+	vb := consensus.NewVersionBits(rules)
+	chain := newHeaderChain(1)
+
+	if active, err := vb.IsActive(chain, "taproot", 0); err != nil || !active {
+		t.Errorf("Regtest taproot active = %v, %v", active, err)
+	}
+
+	rules.Deployments = append(rules.Deployments, consensus.Deployment{Name: "never", Bit: 3, StartTime: consensus.NeverActive})
+	if state, _ := vb.State(chain, "never", 500); state != consensus.ThresholdFailed {
+		t.Errorf("Never active deployment = %v", state)
+	}
+	if _, err := vb.State(chain, "missing", 0); err == nil {
+		t.Error("Unknown deployment did not fail")
+	}
+}
+
+func TestGetDeploymentInfo(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	rules := consensus.NewRegtestRules()
+	rules.MinerConfirmationWindow = 10
+	rules.RuleChangeActivationThreshold = 8
+
+	// testdummy starts at genesis and every block of the second window
+	// signals for it, locking it in at 20 and activating it at 30
+	saveBlock := func(height int) {
+		var prev types.Hash
+		if height > 0 {
+			prev, _ = chain.GetBestBlockHash()
+		}
+		version := int32(1)
+		if height >= 10 && height < 20 {
+			version = consensus.VersionBitsTopBits | 1<<28
+		}
+		coinbase, err := mining.CreateCoinbase(uint64(height), 0, "versionbits-test", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := mining.BuildBlock(&mining.BlockTemplate{
+			Version:       version,
+			PrevBlockHash: prev,
+			Transactions:  []types.Transaction{*coinbase},
+			Timestamp:     uint32(1700000000 + 600*height),
+			Bits:          0x207fffff,
+			Height:        uint64(height),
+		}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := chain.SaveBlock(block, uint64(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := rpc.NewServer(wallet.NewWallet(), chain, "")
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	saveBlock(0)
+	if _, err := client.GetDeploymentInfo(); err == nil {
+		t.Error("getdeploymentinfo without consensus rules succeeded")
+	}
+	server.SetConsensusRules(rules)
+
+	for height := 1; height <= 14; height++ {
+		saveBlock(height)
+	}
+	info, err := client.GetDeploymentInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dummy := info.Deployments["testdummy"]
+	if info.Height != 14 || dummy.Type != "bip9" || dummy.Active || dummy.BIP9 == nil {
+		t.Fatalf("testdummy at 14 = %+v", dummy)
+	}
+	if dummy.BIP9.Status != "started" || dummy.BIP9.Since != 10 || dummy.BIP9.Bit != 28 {
+		t.Errorf("testdummy bip9 at 14 = %+v", dummy.BIP9)
+	}
+	if stats := dummy.BIP9.Statistics; stats == nil || stats.Elapsed != 5 || stats.Count != 5 || stats.Threshold != 8 {
+		t.Errorf("testdummy statistics = %+v", stats)
+	}
+
+	for height := 15; height <= 29; height++ {
+		saveBlock(height)
+	}
+	info, err = client.GetDeploymentInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dummy = info.Deployments["testdummy"]
+	if !dummy.Active || dummy.BIP9.Status != "locked_in" || dummy.BIP9.StatusNext != "active" || dummy.BIP9.Since != 20 {
+		t.Errorf("testdummy at 29 = %+v %+v", dummy, dummy.BIP9)
+	}
+	if taproot := info.Deployments["taproot"]; !taproot.Active || taproot.BIP9.Status != "active" {
+		t.Errorf("taproot = %+v", taproot)
+	}
+	if segwit := info.Deployments["segwit"]; segwit.Type != "buried" || !segwit.Active {
+		t.Errorf("segwit = %+v", segwit)
+	}
+
+	tipHash, _ := chain.GetBestBlockHash()
+	if info.Hash != tipHash.String() {
+		t.Errorf("Hash = %s, want %s", info.Hash, tipHash)
+	}
+}
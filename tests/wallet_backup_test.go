@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// fundedWallet returns a wallet persisted at path holding one coin
+func fundedWallet(t *testing.T, path string, value int64) (*wallet.Wallet, string) {
+	t.Helper()
+	w := wallet.NewWallet()
+	w.SetFile(path)
+	address, err := w.GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payee, err := keys.DecodeAddressForNetwork(address, w.NetParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := payee.Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.AddUTXO(utxo.NewUTXO(types.Hash{1}, 0, types.TxOutput{Value: value, PubKeyScript: pkScript}, 5, false))
+	return w, address
+}
+
+// loadWallet reads a wallet file into a fresh wallet
+func loadWallet(t *testing.T, path string) *wallet.Wallet {
+	t.Helper()
+	w := wallet.NewWallet()
+	if err := w.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWalletFileFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), wallet.WalletFileName)
+	w, address := fundedWallet(t, path, 5000)
+
+	// New keys are written at once, other changes on flush
+	loaded := loadWallet(t, path)
+	if _, ok := loaded.GetKey(address); !ok {
+		t.Fatal("Generated key was not written to the wallet file")
+	}
+	if loaded.GetBalance() != 0 {
+		t.Errorf("Unflushed coin in wallet file: balance %d", loaded.GetBalance())
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	loaded = loadWallet(t, path)
+	if loaded.GetBalance() != 5000 {
+		t.Errorf("Balance after reload = %d, want 5000", loaded.GetBalance())
+	}
+	key, _ := w.GetKey(address)
+	loadedKey, _ := loaded.GetKey(address)
+	if string(key.Bytes()) != string(loadedKey.Bytes()) {
+		t.Error("Reloaded key differs")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Wallet file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// A file from another network is refused
+	testnet := wallet.NewWallet()
+	testnet.SetNetParams(keys.TestNetParams)
+	if err := testnet.LoadFromFile(path); err == nil {
+		t.Error("Loaded a mainnet wallet file into a testnet wallet")
+	}
+
+	// A missing file leaves the wallet empty
+	if err := wallet.NewWallet().LoadFromFile(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("Missing wallet file: %v", err)
+	}
+}
+
+func TestWalletBackupFlushesPendingChanges(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, wallet.WalletFileName)
+	w, _ := fundedWallet(t, path, 7000)
+
+	backup := filepath.Join(dir, "backup.json")
+	if err := w.Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+
+	walletData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupData, err := os.ReadFile(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(walletData) != string(backupData) {
+		t.Error("Backup differs from the flushed wallet file")
+	}
+	if balance := loadWallet(t, backup).GetBalance(); balance != 7000 {
+		t.Errorf("Backup balance = %d, want 7000", balance)
+	}
+
+	if err := w.Backup(path); err == nil {
+		t.Error("Backed up over the wallet file")
+	}
+}
+
+func TestWalletRotateBackups(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := fundedWallet(t, filepath.Join(dir, wallet.WalletFileName), 1000)
+	backups := filepath.Join(dir, wallet.BackupDirName)
+
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path, err := w.RotateBackups(backups, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	entries, err := os.ReadDir(backups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d backups kept, want 2", len(entries))
+	}
+	for _, path := range paths[:2] {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Old backup %s was not removed", filepath.Base(path))
+		}
+	}
+	for _, path := range paths[2:] {
+		if balance := loadWallet(t, path).GetBalance(); balance != 1000 {
+			t.Errorf("Backup %s balance = %d, want 1000", filepath.Base(path), balance)
+		}
+	}
+
+	if _, err := w.RotateBackups(backups, 0); err == nil {
+		t.Error("Rotated keeping no backups")
+	}
+}
+
+func TestBackupWalletRPC(t *testing.T) {
+	dir := t.TempDir()
+	chain, err := storage.NewBlockchainStorage(filepath.Join(dir, "chain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	w, _ := fundedWallet(t, filepath.Join(dir, wallet.WalletFileName), 3000)
+	server := rpc.NewServer(w, chain, "")
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	client := rpc.NewClient(ts.URL)
+
+	destination := filepath.Join(dir, "rpc-backup.json")
+	if err := client.BackupWallet(destination); err != nil {
+		t.Fatal(err)
+	}
+	if balance := loadWallet(t, destination).GetBalance(); balance != 3000 {
+		t.Errorf("Backup balance = %d, want 3000", balance)
+	}
+
+	if err := client.BackupWallet(""); err == nil {
+		t.Error("Backup without a destination succeeded")
+	}
+}
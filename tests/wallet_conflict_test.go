@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/clock"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/script"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// spendingTx spends prev:0 to an anyone-can-spend output
+func spendingTx(prev types.Hash, value int64) *types.Transaction {
+	return &types.Transaction{
+		Version:  1,
+		Inputs:   []types.TxInput{{PrevTxHash: prev, OutputIndex: 0, SignatureScript: []byte{0x01}, Sequence: 0xFFFFFFFF}},
+		Outputs:  []types.TxOutput{{Value: value, PubKeyScript: []byte{0x51}}},
+		LockTime: 0,
+	}
+}
+
+func TestMempoolRemovalHandler(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	first, second := spendingTx(types.Hash{1}, 1000), spendingTx(types.Hash{2}, 1000)
+	size := mempool.CalculateTransactionSize(first)
+
+	// Room for one transaction
+	mp := mempool.NewMempool(mempool.EstimateMemoryUsage(first, 0).Total(), 1000, 3600)
+	mp.SetClock(fake)
+	removed := make(map[types.Hash]mempool.RemovalReason)
+	mp.SetRemovalHandler(func(txHash types.Hash, reason mempool.RemovalReason) {
+		removed[txHash] = reason
+	})
+
+	if err := mp.Add(first, size, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(second, 10*size, 1); err != nil {
+		t.Fatal(err)
+	}
+	firstHash, _ := serialization.HashTransaction(first)
+	secondHash, _ := serialization.HashTransaction(second)
+	if reason, ok := removed[firstHash]; !ok || reason != mempool.RemovalEvicted {
+		t.Errorf("Evicted transaction reported as %v (%v)", reason, ok)
+	}
+
+	fake.Advance(2 * time.Hour)
+	mp.ExpireTransactions()
+	if reason := removed[secondHash]; reason != mempool.RemovalExpired {
+		t.Errorf("Expired transaction reported as %v", reason)
+	}
+
+	third := spendingTx(types.Hash{3}, 1000)
+	if err := mp.Add(third, size, 1); err != nil {
+		t.Fatal(err)
+	}
+	thirdHash, _ := serialization.HashTransaction(third)
+	if n := mp.RemoveConflicts([]types.Transaction{*spendingTx(types.Hash{3}, 900)}); n != 1 {
+		t.Fatalf("Removed %d conflicts, want 1", n)
+	}
+	if reason := removed[thirdHash]; reason != mempool.RemovalConflict {
+		t.Errorf("Conflicted transaction reported as %v", reason)
+	}
+
+	// Mined and explicitly removed transactions aren't reported
+	if err := mp.Add(first, size, 1); err != nil {
+		t.Fatal(err)
+	}
+	delete(removed, firstHash)
+	mp.RemoveConfirmed([]types.Transaction{*first})
+	if _, ok := removed[firstHash]; ok {
+		t.Error("Confirmed transaction reported as dropped")
+	}
+}
+
+// singleCoinWallet returns a wallet holding one 100000 satoshi coin and
+// the address it pays to
+func singleCoinWallet(t *testing.T) (*wallet.Wallet, string) {
+	t.Helper()
+	w := wallet.NewWallet()
+	w.SetNetParams(keys.RegtestParams)
+	addr, err := w.GenerateAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := keys.DecodeAddress(addr)
+	pkScript, _ := script.P2PKH(decoded.Hash())
+	w.AddUTXO(utxo.NewUTXO(types.Hash{9}, 0, types.TxOutput{Value: 100000, PubKeyScript: pkScript}, 1, false))
+	return w, addr
+}
+
+func TestWalletDroppedTransactionReleasesInputs(t *testing.T) {
+	w, addr := singleCoinWallet(t)
+
+	tx, err := w.SendWithFee(addr, 10000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.SendWithFee(addr, 10000, 1000); err == nil {
+		t.Fatal("Spent the input of an unconfirmed transaction again")
+	}
+
+	txHash, _ := serialization.HashTransaction(tx)
+	w.TransactionDropped(txHash)
+	if rec, _ := w.GetTransactionRecord(txHash); !rec.Conflicted || !rec.ConflictedBy.IsZero() {
+		t.Errorf("Dropped transaction: %+v", rec)
+	}
+	if _, err := w.SendWithFee(addr, 10000, 1000); err != nil {
+		t.Errorf("Input not released: %v", err)
+	}
+}
+
+func TestWalletConflictedByBlock(t *testing.T) {
+	w, addr := singleCoinWallet(t)
+
+	tx, err := w.SendWithFee(addr, 10000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+
+	// A different spend of the same coin confirms
+	double := spendingTx(types.Hash{9}, 50000)
+	doubleHash, _ := serialization.HashTransaction(double)
+	coinbase := spendingTx(types.Hash{}, 5000000000)
+	coinbase.Inputs[0].OutputIndex = 0xFFFFFFFF
+	w.BlockConnected(&types.Block{Transactions: []types.Transaction{*coinbase, *double}}, 2)
+
+	rec, _ := w.GetTransactionRecord(txHash)
+	if !rec.Conflicted || rec.ConflictedBy != doubleHash {
+		t.Errorf("Conflicted transaction: %+v, want conflicted by %s", rec, doubleHash)
+	}
+	if _, err := w.BumpFee(txHash, 5000); err == nil {
+		t.Error("Bumped a conflicted transaction")
+	}
+}
+
+func TestWalletAbandonTransaction(t *testing.T) {
+	w, addr := singleCoinWallet(t)
+
+	if err := w.AbandonTransaction(types.Hash{42}); err == nil {
+		t.Error("Abandoned an unknown transaction")
+	}
+
+	tx, err := w.SendWithFee(addr, 10000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+	if err := w.AbandonTransaction(txHash); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := w.GetTransactionRecord(txHash); !rec.Abandoned {
+		t.Errorf("Abandoned transaction: %+v", rec)
+	}
+	if _, err := w.SendWithFee(addr, 10000, 1000); err != nil {
+		t.Errorf("Input not released: %v", err)
+	}
+}
+
+func TestWalletRPCAbandonTransaction(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	tx, err := node.SendTo(node.Address, 1000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+	if err := client.AbandonTransaction(txHash.String()); err == nil || !strings.Contains(err.Error(), "mempool") {
+		t.Fatalf("Abandoned a transaction in the mempool: %v", err)
+	}
+
+	if err := node.P2P.Mempool.Remove(txHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.AbandonTransaction(txHash.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	txs, err := client.ListTransactions(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range txs {
+		if info.TxID == txHash.String() && !info.Abandoned {
+			t.Errorf("Listed abandoned transaction as %+v", info)
+		}
+		if info.Confirmations > 0 {
+			if err := client.AbandonTransaction(info.TxID); err == nil {
+				t.Errorf("Abandoned confirmed transaction %s", info.TxID)
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/rpc"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/testharness"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/wallet"
+)
+
+// walletRPCNode starts a node with mined coins and an RPC server for its
+// wallet
+func walletRPCNode(t *testing.T) (*testharness.TestNode, *rpc.Server, *rpc.Client) {
+	t.Helper()
+
+	h, err := testharness.New(1)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	node := h.Node(0)
+	if _, err := node.MineBlocks(2); err != nil {
+		t.Fatalf("Failed to mine: %v", err)
+	}
+	if err := node.ScanWallet(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := rpc.NewServer(node.Wallet, node.Chain, "")
+	server.SetNode(node.P2P)
+	server.SetRateLimits(rpc.RateLimitConfig{})
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return node, server, rpc.NewClient(ts.URL)
+}
+
+func TestWalletRPCEncryption(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	status, err := client.EncryptWallet("correct horse")
+	if err != nil || !status.Locked {
+		t.Fatalf("EncryptWallet = %+v, %v", status, err)
+	}
+	if _, err := client.EncryptWallet("again"); err == nil {
+		t.Error("Encrypted the wallet twice")
+	}
+
+	// Nothing needing a private key works while locked
+	if _, err := client.SendToAddress(node.Address, 1000); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("Send from locked wallet: %v", err)
+	}
+	if _, err := client.GetNewAddress(); err == nil {
+		t.Error("Generated an address in a locked wallet")
+	}
+	if _, err := client.WalletPassphrase("wrong", 60); err == nil {
+		t.Error("Wrong passphrase unlocked the wallet")
+	}
+
+	status, err = client.WalletPassphrase("correct horse", 60)
+	if err != nil || status.Locked || status.UnlockedUntil == 0 {
+		t.Fatalf("WalletPassphrase = %+v, %v", status, err)
+	}
+	if _, err := client.SendToAddress(node.Address, 1000); err != nil {
+		t.Errorf("Send from unlocked wallet: %v", err)
+	}
+
+	if _, err := client.WalletLock(); err != nil {
+		t.Fatal(err)
+	}
+	if !node.Wallet.IsLocked() {
+		t.Error("Wallet not locked by walletlock")
+	}
+}
+
+func TestWalletEncryptionPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), wallet.WalletFileName)
+	w, address := fundedWallet(t, path, 5000)
+	key, _ := w.GetKey(address)
+	if err := w.EncryptWallet("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(key.ToWIF(true))) || strings.Contains(string(data), hex.EncodeToString(key.Bytes())) {
+		t.Fatal("Wallet file holds the private key in the clear")
+	}
+
+	loaded := loadWallet(t, path)
+	if !loaded.IsEncrypted() || !loaded.IsLocked() {
+		t.Fatal("Loaded wallet is not encrypted and locked")
+	}
+	if _, ok := loaded.GetKey(address); ok {
+		t.Error("Locked wallet handed out a key")
+	}
+	if err := loaded.Unlock("wrong", time.Minute); err != wallet.ErrWrongPassphrase {
+		t.Errorf("Unlock with wrong passphrase = %v", err)
+	}
+	if err := loaded.Unlock("secret", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if loadedKey, ok := loaded.GetKey(address); !ok || !bytes.Equal(loadedKey.Bytes(), key.Bytes()) {
+		t.Error("Unlocked wallet has a different key")
+	}
+}
+
+func TestWalletRPCImportDumpListUnspent(t *testing.T) {
+	node, server, client := walletRPCNode(t)
+	if err := server.SetWalletDir(filepath.Join(t.TempDir(), "wallets")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Coinbases of blocks 1 and 2 pay the node's address
+	unspent, err := client.ListUnspent(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unspent) != 2 {
+		t.Fatalf("Listed %d outputs, want 2", len(unspent))
+	}
+	if u := unspent[0]; u.Address != node.Address || u.Confirmations != 2 || !u.Coinbase {
+		t.Errorf("Oldest output: %+v", u)
+	}
+	if unspent, _ := client.ListUnspent(3); len(unspent) != 0 {
+		t.Errorf("minconf 3 listed %d outputs", len(unspent))
+	}
+
+	dump := filepath.Join(t.TempDir(), "dump.txt")
+	result, err := client.DumpWallet(dump)
+	if err != nil || result.Keys != 1 {
+		t.Fatalf("DumpWallet = %+v, %v", result, err)
+	}
+	if _, err := client.DumpWallet(dump); err == nil {
+		t.Error("dumpwallet overwrote an existing file")
+	}
+	data, err := os.ReadFile(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wif string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasSuffix(line, "addr="+node.Address) {
+			wif = strings.Fields(line)[0]
+		}
+	}
+	if wif == "" {
+		t.Fatalf("No key for %s in dump:\n%s", node.Address, data)
+	}
+
+	// A new named wallet finds the coins of the imported key by rescanning
+	if _, err := client.CreateWallet("cold", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateWallet("cold", ""); err == nil {
+		t.Error("Created the same wallet twice")
+	}
+	client.SetWallet("cold")
+	address, err := client.ImportPrivKey(wif, true)
+	if err != nil || address != node.Address {
+		t.Fatalf("ImportPrivKey = %s, %v", address, err)
+	}
+	if unspent, _ := client.ListUnspent(1); len(unspent) != 2 {
+		t.Errorf("Imported wallet lists %d outputs, want 2", len(unspent))
+	}
+
+	client.SetWallet("missing")
+	if _, err := client.GetBalance(); err == nil {
+		t.Error("Unknown wallet answered")
+	}
+}
+
+func TestWalletRPCBumpFee(t *testing.T) {
+	node, _, client := walletRPCNode(t)
+
+	final, err := client.SendToAddress(node.Address, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.BumpFee(final, 5000); err == nil || !strings.Contains(err.Error(), "replaceability") {
+		t.Errorf("Bumped a transaction without RBF: %v", err)
+	}
+
+	node.Wallet.SetOptInRBF(true)
+	original, err := client.SendToAddress(node.Address, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bumped, err := client.BumpFee(original, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bumped.OrigFee != 0 || bumped.Fee != 5000 || !bumped.Broadcast {
+		t.Errorf("BumpFee = %+v", bumped)
+	}
+	if _, err := client.BumpFee(original, 6000); err == nil {
+		t.Error("Bumped a replaced transaction")
+	}
+
+	// Confirm the replacement
+	blocks, err := node.MineBlocks(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	height, _ := node.Height()
+	node.Wallet.BlockConnected(blocks[0], height)
+
+	txs, err := client.ListTransactions(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[string]rpc.WalletTxInfo)
+	for _, tx := range txs {
+		byID[tx.TxID] = tx
+	}
+	if tx := byID[original]; tx.ReplacedBy != bumped.TxID || tx.Confirmations != 0 {
+		t.Errorf("Original: %+v", tx)
+	}
+	if tx := byID[bumped.TxID]; tx.Category != wallet.CategorySend || tx.Amount != -1000 || tx.Fee != 5000 || tx.Confirmations != 1 {
+		t.Errorf("Replacement: %+v", tx)
+	}
+	if last := txs[len(txs)-1]; last.TxID == bumped.TxID {
+		t.Error("Confirmed replacement listed after unconfirmed transactions")
+	}
+	if _, err := client.BumpFee(bumped.TxID, 9000); err == nil {
+		t.Error("Bumped a confirmed transaction")
+	}
+}
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/keys"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mempool"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/transaction"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/watch"
+)
+
+func TestWatcherEvents(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	genesis := buildBranch(t, types.Hash{}, 0, 1, 0)[0]
+	if err := chain.SaveBlock(genesis, 0); err != nil {
+		t.Fatal(err)
+	}
+	pool := mempool.NewMempool(1000000, 1000, 3600)
+
+	watcher := watch.NewWatcher(chain, pool)
+	var heard []watch.Event
+	watcher.Subscribe(func(event watch.Event) { heard = append(heard, event) })
+	if err := watcher.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// rbfSpend pays OP_TRUE
+	watched := []byte{0x51}
+	watcher.WatchScript(watched)
+	payment := rbfSpend(types.Hash{9}, transaction.SequenceFinal, 1000)
+	spend := &types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: txid(t, payment), SignatureScript: []byte{0x51}, Sequence: transaction.SequenceFinal}},
+		Outputs: []types.TxOutput{{Value: 900, PubKeyScript: []byte{0x52}}},
+	}
+	for _, tx := range []*types.Transaction{payment, spend} {
+		if err := pool.Add(tx, 100, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := watcher.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, last := watcher.Events(0)
+	paid := types.OutPoint{Hash: txid(t, payment), Index: 0}
+	if len(events) != 2 || last != 2 {
+		t.Fatalf("Mempool events = %+v", events)
+	}
+	// Outputs are learned before spends, whatever the mempool order
+	if events[0].Type != watch.EventReceived || events[1].Type != watch.EventSpent {
+		t.Errorf("Mempool event types = %s, %s", events[0].Type, events[1].Type)
+	}
+	for _, event := range events {
+		if event.Confirmed || event.Outpoint != paid || event.Value != 1000 {
+			t.Errorf("Mempool event = %+v", event)
+		}
+	}
+
+	// Polling again reports nothing new
+	if err := watcher.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := watcher.Events(last); len(events) != 0 {
+		t.Errorf("Repeated events = %+v", events)
+	}
+
+	// Mining confirms both
+	coinbase, _ := mining.CreateCoinbase(1, 200, "watch", 0)
+	block, err := mining.BuildBlock(&mining.BlockTemplate{
+		Version:       1,
+		PrevBlockHash: blockHash(t, genesis),
+		Transactions:  []types.Transaction{*coinbase, *payment, *spend},
+		Timestamp:     1700000010,
+		Bits:          0x207fffff,
+		Height:        1,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.SaveBlock(block, 1); err != nil {
+		t.Fatal(err)
+	}
+	pool.RemoveConfirmed(block.Transactions)
+	if err := watcher.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	confirmed, _ := watcher.Events(last)
+	if len(confirmed) != 2 {
+		t.Fatalf("Confirmed events = %+v", confirmed)
+	}
+	received, spent := confirmed[0], confirmed[1]
+	if received.Type != watch.EventReceived || !received.Confirmed || received.Height != 1 || received.TxHash != paid.Hash {
+		t.Errorf("Confirmed payment = %+v", received)
+	}
+	if spent.Type != watch.EventSpent || !spent.Confirmed || spent.TxHash != txid(t, spend) || spent.Outpoint != paid {
+		t.Errorf("Confirmed spend = %+v", spent)
+	}
+	if len(heard) != 4 || heard[3].Sequence != 4 {
+		t.Errorf("Listener heard %d events", len(heard))
+	}
+
+	// Unwatched scripts are no longer reported, explicit outpoints are
+	if !watcher.UnwatchScript(watched) || watcher.UnwatchScript(watched) {
+		t.Error("UnwatchScript didn't report whether the script was watched")
+	}
+	old := types.OutPoint{Hash: types.Hash{7}, Index: 3}
+	watcher.WatchOutpoint(old)
+	if err := pool.Add(rbfSpend(types.Hash{8}, transaction.SequenceFinal, 1000), 100, 1); err != nil {
+		t.Fatal(err)
+	}
+	oldSpend := &types.Transaction{
+		Version: 1,
+		Inputs:  []types.TxInput{{PrevTxHash: old.Hash, OutputIndex: old.Index, SignatureScript: []byte{0x51}, Sequence: transaction.SequenceFinal}},
+		Outputs: []types.TxOutput{{Value: 500, PubKeyScript: []byte{0x52}}},
+	}
+	if err := pool.Add(oldSpend, 100, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A long poll wakes up for the next event
+	done := make(chan []watch.Event)
+	go func() {
+		events, _ := watcher.Wait(context.Background(), 4)
+		done <- events
+	}()
+	if err := watcher.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case events := <-done:
+		if len(events) != 1 || events[0].Type != watch.EventSpent || events[0].Outpoint != old {
+			t.Errorf("Events after unwatching = %+v", events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait didn't return after an event")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if events, latest := watcher.Wait(ctx, 5); len(events) != 0 || latest != 5 {
+		t.Errorf("Wait with nothing new = %+v, %d", events, latest)
+	}
+}
+
+func TestWatchAddressRPC(t *testing.T) {
+	node, server, client := walletRPCNode(t)
+
+	key, _ := keys.GeneratePrivateKey()
+	address := key.PublicKey().P2PKHAddressForNetwork(node.Wallet.NetParams())
+	if _, err := client.WatchAddress(address); err == nil {
+		t.Error("Watching without a watcher succeeded")
+	}
+
+	watcher := watch.NewWatcher(node.Chain, node.P2P.Mempool)
+	if err := watcher.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	server.SetWatcher(watcher)
+
+	watching, err := client.WatchAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watching.Address != address || watching.Script == "" {
+		t.Errorf("WatchAddress = %+v", watching)
+	}
+	if _, err := client.WatchScript("zz"); err == nil {
+		t.Error("Invalid script accepted")
+	}
+
+	tx, err := node.SendTo(address, 1000, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := serialization.HashTransaction(tx)
+	if err := watcher.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.WaitForWatchEvents(0, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Events) != 1 || result.Last != 1 {
+		t.Fatalf("Events = %+v", result)
+	}
+	event := result.Events[0]
+	if event.Type != "received" || event.TxID != txHash.String() || event.Address != address || event.Value != 1000 || event.Confirmed {
+		t.Errorf("Event = %+v", event)
+	}
+
+	if result, err = client.WaitForWatchEvents(result.Last, 20*time.Millisecond); err != nil || len(result.Events) != 0 {
+		t.Errorf("Wait with nothing new = %+v, %v", result, err)
+	}
+
+	if _, err := client.UnwatchAddress(address); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.UnwatchAddress(address); err == nil {
+		t.Error("Unwatching twice succeeded")
+	}
+}
@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/consensus"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/crypto"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/mining"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/serialization"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/storage"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/types"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/utxo"
+	"github.com/pouria-shahmiri/learn-bitcoin/pkg/validation"
+)
+
+func witnessSpend(prev types.Hash) types.Transaction {
+	return types.Transaction{
+		Version: 2,
+		Inputs: []types.TxInput{{
+			PrevTxHash:  prev,
+			OutputIndex: 0,
+			Sequence:    0xFFFFFFFF,
+			Witness:     [][]byte{bytes.Repeat([]byte{0x30}, 71), bytes.Repeat([]byte{0x02}, 33)},
+		}},
+		Outputs: []types.TxOutput{{Value: 90000000, PubKeyScript: append([]byte{0x00, 0x14}, make([]byte, 20)...)}},
+	}
+}
+
+func TestWitnessSerializationRoundTrip(t *testing.T) {
+	tx := witnessSpend(types.Hash{9})
+
+	full, err := serialization.SerializeTransactionWitness(&tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped, _ := serialization.SerializeTransaction(&tx)
+	if full[4] != 0x00 || full[5] != 0x01 || len(full) <= len(stripped) {
+		t.Fatalf("Witness serialization lacks marker and flag: %x", full[:6])
+	}
+
+	decoded, err := serialization.DeserializeTransaction(bytes.NewReader(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Inputs[0].Witness, tx.Inputs[0].Witness) {
+		t.Errorf("Round trip witness = %x, want %x", decoded.Inputs[0].Witness, tx.Inputs[0].Witness)
+	}
+	if again, _ := serialization.SerializeTransactionWitness(decoded); !bytes.Equal(again, full) {
+		t.Error("Round trip changed the serialization")
+	}
+
+	// The txid ignores the witness, the wtxid doesn't
+	txid, _ := serialization.HashTransaction(&tx)
+	wtxid, _ := serialization.HashTransactionWitness(&tx)
+	tx.Inputs[0].Witness[1][0] = 0x03
+	if after, _ := serialization.HashTransaction(&tx); after != txid {
+		t.Error("Changing the witness changed the txid")
+	}
+	if after, _ := serialization.HashTransactionWitness(&tx); after == wtxid {
+		t.Error("Changing the witness did not change the wtxid")
+	}
+
+	// Legacy transactions serialize the same either way
+	legacy := buildBranch(t, types.Hash{}, 1, 1, 0)[0].Transactions[0]
+	a, _ := serialization.SerializeTransaction(&legacy)
+	b, _ := serialization.SerializeTransactionWitness(&legacy)
+	if !bytes.Equal(a, b) {
+		t.Error("Legacy transaction serializes differently with witnesses enabled")
+	}
+}
+
+func TestWitnessSerializationRejectsEmptyWitness(t *testing.T) {
+	tx := witnessSpend(types.Hash{9})
+	full, _ := serialization.SerializeTransactionWitness(&tx)
+	stripped, _ := serialization.SerializeTransaction(&tx)
+
+	// Marker and flag but both stacks empty
+	data := append([]byte{}, stripped[:4]...)
+	data = append(data, 0x00, 0x01)
+	data = append(data, stripped[4:len(stripped)-4]...)
+	data = append(data, 0x00)
+	data = append(data, full[len(full)-4:]...)
+
+	if _, err := serialization.DeserializeTransaction(bytes.NewReader(data)); err == nil {
+		t.Error("Witness serialization without witness data was accepted")
+	}
+}
+
+// witnessBlock builds a block at height 1 spending a funded output with a
+// witness, returning it with the UTXO set it validates against
+func witnessBlock(t *testing.T) (*types.Block, *utxo.UTXOSet) {
+	t.Helper()
+
+	set := utxo.NewUTXOSet()
+	funding := types.Hash{9}
+	if err := set.Add(utxo.NewUTXO(funding, 0, types.TxOutput{Value: 100000000, PubKeyScript: []byte{0x51}}, 0, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase, err := mining.CreateCoinbase(1, 10000000, "witness-test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := mining.BuildBlock(&mining.BlockTemplate{
+		Version:      1,
+		Transactions: []types.Transaction{*coinbase, witnessSpend(funding)},
+		Timestamp:    1700000000,
+		Bits:         0x207fffff,
+		Height:       1,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block, set
+}
+
+func regtestValidator(set utxo.View) *validation.BlockValidator {
+	validator := validation.NewBlockValidator(set)
+	validator.SetRules(consensus.NewRegtestRules())
+	return validator
+}
+
+func TestBlockWeight(t *testing.T) {
+	legacy := buildBranch(t, types.Hash{}, 1, 1, 0)[0]
+	size, _ := serialization.SerializeBlock(legacy)
+	if weight, _ := validation.BlockWeight(legacy); weight != 4*len(size) {
+		t.Errorf("Legacy block weight = %d, want 4 * %d", weight, len(size))
+	}
+
+	block, _ := witnessBlock(t)
+	full, _ := serialization.SerializeBlock(block)
+	stripped, _ := serialization.SerializeBlockNoWitness(block)
+	weight, _ := validation.BlockWeight(block)
+	if weight != 3*len(stripped)+len(full) || weight >= 4*len(full) {
+		t.Errorf("Witness block weight = %d (stripped %d, full %d)", weight, len(stripped), len(full))
+	}
+
+	rules := consensus.NewRegtestRules()
+	rules.MaxBlockWeight = uint32(weight - 1)
+	validator := validation.NewBlockValidator(utxo.NewUTXOSet())
+	validator.SetRules(rules)
+	err := validator.ValidateBlock(block, 1, types.Hash{})
+	if err == nil || !strings.Contains(err.Error(), "weight") {
+		t.Errorf("ValidateBlock over the weight limit = %v", err)
+	}
+}
+
+func TestWitnessCommitment(t *testing.T) {
+	block, set := witnessBlock(t)
+	if validation.WitnessCommitmentIndex(&block.Transactions[0]) < 0 {
+		t.Fatal("BuildBlock did not add a witness commitment")
+	}
+	if err := regtestValidator(set).ValidateBlock(block, 1, types.Hash{}); err != nil {
+		t.Fatalf("Valid witness block rejected: %v", err)
+	}
+
+	// Before activation witness data isn't allowed at all
+	err := validation.NewBlockValidator(set).ValidateBlock(block, 1, types.Hash{})
+	if err == nil || !strings.Contains(err.Error(), "before SegWit activation") {
+		t.Errorf("Mainnet at height 1 = %v", err)
+	}
+
+	// Tampering with a witness leaves the merkle root intact but breaks
+	// the commitment
+	block.Transactions[1].Inputs[0].Witness[0][0] ^= 0xff
+	err = regtestValidator(set).ValidateBlock(block, 1, types.Hash{})
+	if err == nil || !strings.Contains(err.Error(), "commitment mismatch") {
+		t.Errorf("Tampered witness = %v", err)
+	}
+	block.Transactions[1].Inputs[0].Witness[0][0] ^= 0xff
+
+	// Witness data with no commitment at all
+	plain, _ := mining.CreateCoinbase(1, 10000000, "witness-test", 0)
+	uncommitted := &types.Block{
+		Header:       block.Header,
+		Transactions: []types.Transaction{*plain, block.Transactions[1]},
+	}
+	var txids []types.Hash
+	for i := range uncommitted.Transactions {
+		txid, _ := serialization.HashTransaction(&uncommitted.Transactions[i])
+		txids = append(txids, txid)
+	}
+	uncommitted.Header.MerkleRoot = crypto.ComputeMerkleRoot(txids)
+	err = regtestValidator(set).ValidateBlock(uncommitted, 1, types.Hash{})
+	if err == nil || !strings.Contains(err.Error(), "without a witness commitment") {
+		t.Errorf("Uncommitted witness block = %v", err)
+	}
+}
+
+func TestWitnessBlockStorageRoundTrip(t *testing.T) {
+	chain, err := storage.NewBlockchainStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	block, _ := witnessBlock(t)
+	if err := chain.SaveBlock(block, 0); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := serialization.SerializeBlock(block)
+	if got, _ := serialization.SerializeBlock(stored); !bytes.Equal(got, want) || !stored.Transactions[1].HasWitness() {
+		t.Error("Witness data lost in storage")
+	}
+}